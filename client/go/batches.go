@@ -0,0 +1,216 @@
+package zb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// File purposes
+const (
+	PurposeBatch       = "batch"
+	PurposeBatchOutput = "batch_output"
+)
+
+// Batch statuses
+const (
+	BatchValidating = "validating"
+	BatchInProgress = "in_progress"
+	BatchFinalizing = "finalizing"
+	BatchCompleted  = "completed"
+	BatchFailed     = "failed"
+	BatchExpired    = "expired"
+	BatchCancelling = "cancelling"
+	BatchCancelled  = "cancelled"
+)
+
+// File is an uploaded or generated file
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// CreateBatchRequest runs the requests of a JSONL input file
+type CreateBatchRequest struct {
+	InputFileID string `json:"input_file_id"`
+	// Endpoint of every request, e.g. /v1/chat/completions
+	Endpoint string `json:"endpoint"`
+	// CompletionWindow is 24h
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// Batch is a batch of requests run asynchronously
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *BatchErrors      `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	ErrorFileID      string            `json:"error_file_id,omitempty"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     int64             `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64             `json:"expires_at"`
+	FinalizingAt     int64             `json:"finalizing_at,omitempty"`
+	CompletedAt      int64             `json:"completed_at,omitempty"`
+	FailedAt         int64             `json:"failed_at,omitempty"`
+	CancellingAt     int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    BatchCounts       `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// Done reports whether the batch reached a final status
+func (b *Batch) Done() bool {
+	switch b.Status {
+	case BatchCompleted, BatchFailed, BatchExpired, BatchCancelled:
+		return true
+	}
+	return false
+}
+
+// BatchErrors are the validation errors of the input file
+type BatchErrors struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+// BatchError is an error of a line of the input file
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// BatchCounts counts the requests of a batch
+type BatchCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchList is a page of batches, newest first
+type BatchList struct {
+	Object  string   `json:"object"`
+	Data    []*Batch `json:"data"`
+	HasMore bool     `json:"has_more"`
+	FirstID string   `json:"first_id,omitempty"`
+	LastID  string   `json:"last_id,omitempty"`
+}
+
+// UploadFile uploads a file, e.g. the JSONL input of a batch with
+// PurposeBatch
+func (c *Client) UploadFile(ctx context.Context, filename string, content io.Reader, purpose string) (*File, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err := form.WriteField("purpose", purpose); err != nil {
+		return nil, fmt.Errorf("zb: encode upload: %w", err)
+	}
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("zb: encode upload: %w", err)
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return nil, fmt.Errorf("zb: read upload: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("zb: encode upload: %w", err)
+	}
+
+	r := request{
+		method:      http.MethodPost,
+		path:        "/v1/files",
+		body:        body.Bytes(),
+		contentType: form.FormDataContentType(),
+		accept:      "application/json",
+	}
+	var file File
+	if err := c.getJSON(ctx, r, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// GetFile returns the metadata of a file
+func (c *Client) GetFile(ctx context.Context, id string) (*File, error) {
+	var file File
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/v1/files/" + url.PathEscape(id)}, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// FileContent returns the content of a file, e.g. the JSONL results of a
+// batch from its OutputFileID
+func (c *Client) FileContent(ctx context.Context, id string) ([]byte, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, path: "/v1/files/" + url.PathEscape(id) + "/content"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("zb: read file: %w", err)
+	}
+	return content, nil
+}
+
+// CreateBatch starts a batch
+func (c *Client) CreateBatch(ctx context.Context, req CreateBatchRequest) (*Batch, error) {
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+	r, err := jsonRequest(http.MethodPost, "/v1/batches", req)
+	if err != nil {
+		return nil, err
+	}
+	var batch Batch
+	if err := c.getJSON(ctx, r, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetBatch returns a batch
+func (c *Client) GetBatch(ctx context.Context, id string) (*Batch, error) {
+	var batch Batch
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/v1/batches/" + url.PathEscape(id)}, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// ListBatches returns the latest batches; limit is 1 to 100, 0 for the
+// default of 20
+func (c *Client) ListBatches(ctx context.Context, limit int) (*BatchList, error) {
+	path := "/v1/batches"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var list BatchList
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: path}, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// CancelBatch cancels a batch; requests already run keep their results
+func (c *Client) CancelBatch(ctx context.Context, id string) (*Batch, error) {
+	var batch Batch
+	if err := c.getJSON(ctx, request{method: http.MethodPost, path: "/v1/batches/" + url.PathEscape(id) + "/cancel"}, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
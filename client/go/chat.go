@@ -0,0 +1,216 @@
+package zb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Message is a chat message
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ResponseFormat constrains the output, e.g. {"type": "json_object"}
+type ResponseFormat struct {
+	Type string `json:"type"`
+}
+
+// ChatRequest is a chat completion request; nil parameters use the defaults
+// of the provider
+type ChatRequest struct {
+	Model            string          `json:"model"`
+	Messages         []Message       `json:"messages"`
+	MaxTokens        *int            `json:"max_tokens,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"top_p,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	Logprobs         *bool           `json:"logprobs,omitempty"`
+	TopLogprobs      *int            `json:"top_logprobs,omitempty"`
+	N                int             `json:"n,omitempty"`
+	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
+	// Gateway extensions: stored conversations, prompt templates and A/B
+	// experiments
+	ConversationID  string            `json:"conversation_id,omitempty"`
+	TemplateID      string            `json:"template_id,omitempty"`
+	TemplateVersion int               `json:"template_version,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+	ExperimentID    string            `json:"experiment_id,omitempty"`
+}
+
+// ChatResponse is a chat completion
+type ChatResponse struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   Usage        `json:"usage"`
+}
+
+// ChatChoice is a choice of a chat completion
+type ChatChoice struct {
+	Index        int             `json:"index"`
+	Message      Message         `json:"message"`
+	FinishReason string          `json:"finish_reason"`
+	Logprobs     json.RawMessage `json:"logprobs,omitempty"`
+}
+
+// Usage is the token usage of a request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Content is the message of the first choice
+func (r *ChatResponse) Content() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	return r.Choices[0].Message.Content
+}
+
+// ChatChunk is an event of a streamed chat completion
+type ChatChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	// Usage is set on the last chunk by providers that report it
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// ChunkChoice is the delta of a choice
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+}
+
+// streamingRequest is a ChatRequest with stream set by the client
+type streamingRequest struct {
+	ChatRequest
+	Stream bool `json:"stream"`
+}
+
+// Chat creates a chat completion
+func (c *Client) Chat(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	r, err := jsonRequest(http.MethodPost, "/v1/chat/completions", streamingRequest{ChatRequest: req})
+	if err != nil {
+		return nil, err
+	}
+	var resp ChatResponse
+	if err := c.getJSON(ctx, r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ChatStream creates a streamed chat completion. Retries only cover the
+// request; an error after the first chunk is returned by Err.
+//
+//	stream, err := c.ChatStream(ctx, req)
+//	if err != nil {
+//		return err
+//	}
+//	defer stream.Close()
+//	for stream.Next() {
+//		fmt.Print(stream.Chunk().Choices[0].Delta.Content)
+//	}
+//	return stream.Err()
+func (c *Client) ChatStream(ctx context.Context, req ChatRequest) (*ChatStream, error) {
+	r, err := jsonRequest(http.MethodPost, "/v1/chat/completions", streamingRequest{ChatRequest: req, Stream: true})
+	if err != nil {
+		return nil, err
+	}
+	r.accept = "text/event-stream"
+	resp, err := c.send(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatStream{body: resp.Body, reader: bufio.NewReader(resp.Body)}, nil
+}
+
+// ChatStream iterates over the chunks of a streamed chat completion
+type ChatStream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	chunk  ChatChunk
+	err    error
+	done   bool
+}
+
+// Next reads the next chunk; it returns false at the end of the stream or on
+// an error
+func (s *ChatStream) Next() bool {
+	if s.done {
+		return false
+	}
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err != io.EOF {
+				s.err = fmt.Errorf("zb: read stream: %w", err)
+			}
+			s.done = true
+			return false
+		}
+		line = strings.TrimRight(line, "\r\n")
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			// Blank lines, comments and event names
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			s.done = true
+			return false
+		}
+
+		var event struct {
+			ChatChunk
+			Error *errorFields `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			s.err = fmt.Errorf("zb: decode chunk: %w", err)
+			s.done = true
+			return false
+		}
+		if event.Error != nil {
+			// Provider failures after the stream started
+			apiErr := &APIError{StatusCode: http.StatusOK, Type: "api_error"}
+			event.Error.apply(apiErr)
+			s.err = apiErr
+			s.done = true
+			return false
+		}
+		s.chunk = event.ChatChunk
+		return true
+	}
+}
+
+// Chunk is the chunk read by the last call to Next
+func (s *ChatStream) Chunk() ChatChunk {
+	return s.chunk
+}
+
+// Err is the error that ended the stream, nil at its normal end
+func (s *ChatStream) Err() error {
+	return s.err
+}
+
+// Close releases the connection; it is safe to call before the stream ends
+func (s *ChatStream) Close() error {
+	s.done = true
+	return s.body.Close()
+}
@@ -0,0 +1,296 @@
+// Package zb is the Go client of the ZB gateway: chat completions, streaming,
+// embeddings, the Batch API and usage, with API key auth and retries.
+//
+//	c := zb.New("https://gateway.example.com", os.Getenv("ZB_API_KEY"))
+//	resp, err := c.Chat(ctx, zb.ChatRequest{
+//		Model:    "gpt-4",
+//		Messages: []zb.Message{{Role: "user", Content: "Hello"}},
+//	})
+//
+// Requests that fail with 408, 429 or a 5xx status, or that do not reach the
+// gateway, are retried with exponential backoff, waiting for Retry-After when
+// the gateway sets it. POST requests carry an Idempotency-Key that stays the
+// same across retries, so a retried completion is not run or billed twice.
+package zb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the options
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryWait    = 500 * time.Millisecond
+	DefaultMaxRetryWait = 30 * time.Second
+)
+
+// Client calls the gateway; it is safe for concurrent use
+type Client struct {
+	baseURL      string
+	apiKey       string
+	httpClient   *http.Client
+	maxRetries   int
+	retryWait    time.Duration
+	maxRetryWait time.Duration
+	userAgent    string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client, e.g. for custom TLS or proxies
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithMaxRetries sets how many times a failed request is retried; 0 disables
+// retries
+func WithMaxRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = n }
+}
+
+// WithRetryWait sets the first backoff, doubled on each retry, and the
+// longest wait, which also caps Retry-After
+func WithRetryWait(wait, max time.Duration) Option {
+	return func(c *Client) { c.retryWait, c.maxRetryWait = wait, max }
+}
+
+// WithUserAgent sets the User-Agent of the requests
+func WithUserAgent(ua string) Option {
+	return func(c *Client) { c.userAgent = ua }
+}
+
+// New returns a client of the gateway at baseURL that authenticates with
+// apiKey
+func New(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		apiKey:       apiKey,
+		httpClient:   http.DefaultClient,
+		maxRetries:   DefaultMaxRetries,
+		retryWait:    DefaultRetryWait,
+		maxRetryWait: DefaultMaxRetryWait,
+		userAgent:    "zb-go",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is an error response of the gateway, in the OpenAI format
+type APIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+	Param      string
+	Code       string
+	// RequestID identifies the request in the gateway logs
+	RequestID string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("zb: %d %s: %s", e.StatusCode, e.Type, e.Message)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	return msg
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *APIError) Temporary() bool {
+	return retryable(e.StatusCode)
+}
+
+func retryable(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// request is a call to the gateway; body is sent as is on every attempt
+type request struct {
+	method      string
+	path        string
+	body        []byte
+	contentType string
+	accept      string
+}
+
+// jsonRequest encodes body as the JSON body of the request
+func jsonRequest(method, path string, body interface{}) (request, error) {
+	req := request{method: method, path: path, accept: "application/json"}
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return req, fmt.Errorf("zb: encode request: %w", err)
+		}
+		req.body, req.contentType = raw, "application/json"
+	}
+	return req, nil
+}
+
+// getJSON decodes the response of a request into out
+func (c *Client) getJSON(ctx context.Context, req request, out interface{}) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("zb: decode response: %w", err)
+	}
+	return nil
+}
+
+// send runs the request with retries and returns the first successful
+// response; the caller closes its body
+func (c *Client) send(ctx context.Context, req request) (*http.Response, error) {
+	var idempotencyKey string
+	if req.method == http.MethodPost && c.maxRetries > 0 {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, c.baseURL+req.path, bytes.NewReader(req.body))
+		if err != nil {
+			return nil, fmt.Errorf("zb: %w", err)
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+		httpReq.Header.Set("User-Agent", c.userAgent)
+		if req.contentType != "" {
+			httpReq.Header.Set("Content-Type", req.contentType)
+		}
+		if req.accept != "" {
+			httpReq.Header.Set("Accept", req.accept)
+		}
+		if idempotencyKey != "" {
+			httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		var retryAfter time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			err = fmt.Errorf("zb: %w", err)
+		case resp.StatusCode < 300:
+			return resp, nil
+		default:
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			err = readError(resp)
+			resp.Body.Close()
+			if !retryable(resp.StatusCode) {
+				return nil, err
+			}
+		}
+
+		if attempt >= c.maxRetries {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.backoff(attempt, retryAfter)):
+		}
+	}
+}
+
+// backoff is how long to wait before retry attempt+1: Retry-After when the
+// gateway set it, exponential with jitter otherwise
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	wait := retryAfter
+	if wait <= 0 {
+		wait = time.Duration(float64(c.retryWait) * math.Pow(2, float64(attempt)))
+		wait += time.Duration(mrand.Int63n(int64(wait)/2 + 1))
+	}
+	if wait > c.maxRetryWait {
+		wait = c.maxRetryWait
+	}
+	return wait
+}
+
+// parseRetryAfter reads Retry-After in seconds or as an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// readError decodes the error body of a response
+func readError(resp *http.Response) error {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Type:       "api_error",
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		Error *errorFields `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &body); err == nil && body.Error != nil {
+		body.Error.apply(apiErr)
+	} else if msg := strings.TrimSpace(string(raw)); msg != "" {
+		// Plain-text errors of proxies and older endpoints
+		apiErr.Message = msg
+	}
+	return apiErr
+}
+
+type errorFields struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func (f *errorFields) apply(e *APIError) {
+	if f.Message != "" {
+		e.Message = f.Message
+	}
+	if f.Type != "" {
+		e.Type = f.Type
+	}
+	if f.Param != nil {
+		e.Param = *f.Param
+	}
+	if f.Code != nil {
+		e.Code = *f.Code
+	}
+}
+
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// IsRateLimited reports whether err is a 429 response that outlasted the
+// retries
+func IsRateLimited(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,241 @@
+package zb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return New(srv.URL, "test-key", WithRetryWait(time.Millisecond, 10*time.Millisecond))
+}
+
+func TestChatRetriesWithSameIdempotencyKey(t *testing.T) {
+	var calls int32
+	var keys []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] != false || req["model"] != "gpt-4" {
+			t.Errorf("request = %v", req)
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"id":"c1","choices":[{"index":0,"message":{"role":"assistant","content":"hi"}}],"usage":{"total_tokens":3}}`)
+	})
+
+	resp, err := c.Chat(context.Background(), ChatRequest{Model: "gpt-4", Messages: []Message{{Role: "user", Content: "hello"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content() != "hi" || resp.Usage.TotalTokens != 3 {
+		t.Errorf("response = %+v", resp)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+	if keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("idempotency keys = %v, want one key for all attempts", keys)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("X-Request-ID", "req-1")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"n must be between 1 and 16","type":"invalid_request_error","param":"n","code":null}}`)
+	})
+
+	_, err := c.Chat(context.Background(), ChatRequest{Model: "gpt-4", N: 20})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != 400 || apiErr.Param != "n" || apiErr.Type != "invalid_request_error" || apiErr.RequestID != "req-1" {
+		t.Errorf("error = %+v", apiErr)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, a 400 is not retried", calls)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	var calls int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	c.maxRetries = 2
+
+	_, err := c.GetBatch(context.Background(), "batch_1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 503 || apiErr.Message != "unavailable" {
+		t.Errorf("err = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRetryAfterHonorsContext(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	c.maxRetryWait = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.ListBatches(ctx, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want deadline exceeded", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("retry did not stop at the context deadline")
+	}
+}
+
+func TestChatStream(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["stream"] != true {
+			t.Errorf("stream = %v", req["stream"])
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"Hel", "lo"} {
+			fmt.Fprintf(w, "data: {\"id\":\"c1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		fmt.Fprint(w, ": keep-alive\n\ndata: [DONE]\n\n")
+	})
+
+	stream, err := c.ChatStream(context.Background(), ChatRequest{Model: "gpt-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	var content strings.Builder
+	for stream.Next() {
+		content.WriteString(stream.Chunk().Choices[0].Delta.Content)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if content.String() != "Hello" {
+		t.Errorf("content = %q", content.String())
+	}
+}
+
+func TestChatStreamError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"error\":{\"message\":\"provider failed\",\"type\":\"api_error\"}}\n\n")
+	})
+
+	stream, err := c.ChatStream(context.Background(), ChatRequest{Model: "gpt-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	n := 0
+	for stream.Next() {
+		n++
+	}
+	if n != 1 || stream.Err() == nil || !strings.Contains(stream.Err().Error(), "provider failed") {
+		t.Errorf("chunks = %d, err = %v", n, stream.Err())
+	}
+}
+
+func TestUploadFileAndCreateBatch(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/files":
+			if r.FormValue("purpose") != PurposeBatch {
+				t.Errorf("purpose = %q", r.FormValue("purpose"))
+			}
+			f, header, err := r.FormFile("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := io.ReadAll(f)
+			json.NewEncoder(w).Encode(File{ID: "file-1", Filename: header.Filename, Bytes: len(content)})
+		case "/v1/batches":
+			var req CreateBatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(Batch{ID: "batch_1", InputFileID: req.InputFileID, CompletionWindow: req.CompletionWindow, Status: BatchValidating})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	file, err := c.UploadFile(context.Background(), "input.jsonl", strings.NewReader("{}\n"), PurposeBatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.ID != "file-1" || file.Filename != "input.jsonl" || file.Bytes != 3 {
+		t.Errorf("file = %+v", file)
+	}
+
+	batch, err := c.CreateBatch(context.Background(), CreateBatchRequest{InputFileID: file.ID, Endpoint: "/v1/chat/completions"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.InputFileID != "file-1" || batch.CompletionWindow != "24h" || batch.Done() {
+		t.Errorf("batch = %+v", batch)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("start"); got != "2024-01-01T00:00:00Z" {
+			t.Errorf("start = %q", got)
+		}
+		if r.URL.Query().Has("end") {
+			t.Error("zero end is sent")
+		}
+		fmt.Fprint(w, `{"object":"list","data":[{"id":"1","model":"gpt-4","tokens":10,"cost":0.5}],"total_tokens":10,"total_cost":0.5}`)
+	})
+
+	report, err := c.Usage(context.Background(), start, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Data) != 1 || report.TotalTokens != 10 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("2"); got != 2*time.Second {
+		t.Errorf("seconds = %v", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute {
+		t.Errorf("date = %v", got)
+	}
+	if got := parseRetryAfter("soon"); got != 0 {
+		t.Errorf("invalid = %v", got)
+	}
+}
@@ -0,0 +1,40 @@
+package zb
+
+import (
+	"context"
+	"net/http"
+)
+
+// EmbeddingsRequest embeds each input
+type EmbeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingsResponse holds an embedding per input, in the order of the inputs
+type EmbeddingsResponse struct {
+	Object string      `json:"object"`
+	Data   []Embedding `json:"data"`
+	Model  string      `json:"model"`
+	Usage  Usage       `json:"usage"`
+}
+
+// Embedding is the embedding of an input
+type Embedding struct {
+	Index     int       `json:"index"`
+	Object    string    `json:"object"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// Embeddings creates embeddings
+func (c *Client) Embeddings(ctx context.Context, req EmbeddingsRequest) (*EmbeddingsResponse, error) {
+	r, err := jsonRequest(http.MethodPost, "/v1/embeddings", req)
+	if err != nil {
+		return nil, err
+	}
+	var resp EmbeddingsResponse
+	if err := c.getJSON(ctx, r, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
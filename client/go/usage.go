@@ -0,0 +1,48 @@
+package zb
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// UsageReport is the billed usage of the API key over a period
+type UsageReport struct {
+	Object      string       `json:"object"`
+	Start       time.Time    `json:"start"`
+	End         time.Time    `json:"end"`
+	Data        []UsageEntry `json:"data"`
+	TotalTokens int          `json:"total_tokens"`
+	TotalCost   float64      `json:"total_cost"`
+}
+
+// UsageEntry is a billed request
+type UsageEntry struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	Tokens    int       `json:"tokens"`
+	Cost      float64   `json:"cost"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Usage returns the billed requests between start and end, newest first; zero
+// times default to the last 30 days
+func (c *Client) Usage(ctx context.Context, start, end time.Time) (*UsageReport, error) {
+	q := url.Values{}
+	if !start.IsZero() {
+		q.Set("start", start.UTC().Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		q.Set("end", end.UTC().Format(time.RFC3339))
+	}
+	path := "/v1/usage"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	var report UsageReport
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: path}, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
@@ -7,6 +7,7 @@
 package routing
 
 import (
+	_ "google.golang.org/genproto/googleapis/api/annotations"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -887,7 +888,7 @@ var File_proto_routing_proto protoreflect.FileDescriptor
 
 const file_proto_routing_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/routing.proto\x12\arouting\"\xf2\x02\n" +
+	"\x13proto/routing.proto\x12\arouting\x1a\x1cgoogle/api/annotations.proto\"\xf2\x02\n" +
 	"\vHeadService\x12\x17\n" +
 	"\ahead_id\x18\x01 \x01(\tR\x06headId\x12\x1a\n" +
 	"\bendpoint\x18\x02 \x01(\tR\bendpoint\x12\x16\n" +
@@ -962,14 +963,14 @@ const file_proto_routing_proto_rawDesc = "" +
 	"\amessage\x18\x02 \x01(\tR\amessage\"\x19\n" +
 	"\x17GetRoutingPolicyRequest\"J\n" +
 	"\x18GetRoutingPolicyResponse\x12.\n" +
-	"\x06policy\x18\x01 \x01(\v2\x16.routing.RoutingPolicyR\x06policy2\x9a\x04\n" +
-	"\x0eRoutingService\x12K\n" +
-	"\fRegisterHead\x12\x1c.routing.RegisterHeadRequest\x1a\x1d.routing.RegisterHeadResponse\x12W\n" +
-	"\x10UpdateHeadStatus\x12 .routing.UpdateHeadStatusRequest\x1a!.routing.UpdateHeadStatusResponse\x12]\n" +
-	"\x12GetRoutingDecision\x12\".routing.GetRoutingDecisionRequest\x1a#.routing.GetRoutingDecisionResponse\x12H\n" +
-	"\vGetAllHeads\x12\x1b.routing.GetAllHeadsRequest\x1a\x1c.routing.GetAllHeadsResponse\x12`\n" +
-	"\x13UpdateRoutingPolicy\x12#.routing.UpdateRoutingPolicyRequest\x1a$.routing.UpdateRoutingPolicyResponse\x12W\n" +
-	"\x10GetRoutingPolicy\x12 .routing.GetRoutingPolicyRequest\x1a!.routing.GetRoutingPolicyResponseBD\n" +
+	"\x06policy\x18\x01 \x01(\v2\x16.routing.RoutingPolicyR\x06policy2\xeb\x05\n" +
+	"\x0eRoutingService\x12j\n" +
+	"\fRegisterHead\x12\x1c.routing.RegisterHeadRequest\x1a\x1d.routing.RegisterHeadResponse\"\x1d\x82\xd3\xe4\x93\x02\x17:\x01*\"\x12/api/routing/heads\x12\x87\x01\n" +
+	"\x10UpdateHeadStatus\x12 .routing.UpdateHeadStatusRequest\x1a!.routing.UpdateHeadStatusResponse\".\x82\xd3\xe4\x93\x02(:\x01*\x1a#/api/routing/heads/{head_id}/status\x12\x7f\n" +
+	"\x12GetRoutingDecision\x12\".routing.GetRoutingDecisionRequest\x1a#.routing.GetRoutingDecisionResponse\" \x82\xd3\xe4\x93\x02\x1a:\x01*\"\x15/api/routing/decision\x12d\n" +
+	"\vGetAllHeads\x12\x1b.routing.GetAllHeadsRequest\x1a\x1c.routing.GetAllHeadsResponse\"\x1a\x82\xd3\xe4\x93\x02\x14\x12\x12/api/routing/heads\x12\x85\x01\n" +
+	"\x13UpdateRoutingPolicy\x12#.routing.UpdateRoutingPolicyRequest\x1a$.routing.UpdateRoutingPolicyResponse\"#\x82\xd3\xe4\x93\x02\x1d:\x06policy\x1a\x13/api/routing/policy\x12t\n" +
+	"\x10GetRoutingPolicy\x12 .routing.GetRoutingPolicyRequest\x1a!.routing.GetRoutingPolicyResponse\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/api/routing/policyBD\n" +
 	"\x0ecom.zb.routingB\fRoutingProtoP\x01Z\"github.com/MaksimVF/ZB/gen/routingb\x06proto3"
 
 var (
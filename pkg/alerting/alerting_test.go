@@ -0,0 +1,154 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ruleFile is the rule file Prometheus loads in the observability stack
+const ruleFile = "../../observability/zb-alerts.yml"
+
+func TestRuleFileUpToDate(t *testing.T) {
+	want := YAML(Rules(Default()))
+	if os.Getenv("UPDATE_ALERT_RULES") != "" {
+		if err := os.WriteFile(ruleFile, want, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := os.ReadFile(ruleFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s is out of date, regenerate it with UPDATE_ALERT_RULES=1 go test ./pkg/alerting", ruleFile)
+	}
+}
+
+func TestRules(t *testing.T) {
+	cfg := Default()
+	names := func(rules []Rule) string {
+		var n []string
+		for _, r := range rules {
+			n = append(n, r.Alert)
+		}
+		return strings.Join(n, ",")
+	}
+	if got := names(Rules(cfg)); got != "ProviderErrorRateHigh,HeadHeartbeatMissing,CircuitBreakerStuckOpen" {
+		t.Errorf("rules without a budget = %s", got)
+	}
+
+	cfg.DailyBudgetUSD = 250.5
+	cfg.HeartbeatInterval = 15 * time.Second
+	cfg.MissedHeartbeats = 4
+	rules := Rules(cfg)
+	if got := names(rules); got != "ProviderErrorRateHigh,HeadHeartbeatMissing,BudgetOverrun,CircuitBreakerStuckOpen" {
+		t.Fatalf("rules with a budget = %s", got)
+	}
+	if rules[1].Expr != "time() - head_last_heartbeat_timestamp_seconds > 60" || rules[1].For != 15*time.Second {
+		t.Errorf("heartbeat rule = %+v", rules[1])
+	}
+	if !strings.HasSuffix(rules[2].Expr, "> 250.5") {
+		t.Errorf("budget rule = %s", rules[2].Expr)
+	}
+
+	yaml := string(YAML(rules))
+	for _, want := range []string{
+		"  - name: zb_generated\n",
+		"      - alert: BudgetOverrun\n",
+		`        expr: "sum by (provider) (rate(gateway_provider_requests_total{result=\"error\"}[5m]))`,
+		"        for: 15s\n",
+		`          summary: "Head {{ $labels.head_id }} stopped sending heartbeats"`,
+	} {
+		if !strings.Contains(yaml, want) {
+			t.Errorf("rule file lacks %q:\n%s", want, yaml)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("ALERT_HEARTBEAT_INTERVAL", "5s")
+	t.Setenv("ALERT_DAILY_BUDGET_USD", "1000")
+	cfg, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HeartbeatInterval != 5*time.Second || cfg.DailyBudgetUSD != 1000 || cfg.ProviderErrorRate != 0.05 {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	t.Setenv("ALERT_PROVIDER_ERROR_RATE", "5")
+	if _, err := FromEnv(); err == nil {
+		t.Error("error rate above 1 accepted")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                              "0s",
+		90 * time.Minute:               "1h30m",
+		25 * time.Hour:                 "1d1h",
+		1500 * time.Millisecond:        "1s500ms",
+		time.Microsecond:               "0s",
+		10*time.Minute + 5*time.Second: "10m5s",
+	} {
+		if got := duration(d); got != want {
+			t.Errorf("duration(%v) = %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/alerts" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"alerts":[
+			{"labels":{"alertname":"HeadHeartbeatMissing","head_id":"h1"},"state":"pending","activeAt":"2026-10-16T10:00:00Z","value":"42"},
+			{"labels":{"alertname":"HeadHeartbeatMissing","head_id":"h2"},"state":"firing","activeAt":"2026-10-16T09:00:00Z","value":"90"},
+			{"labels":{"alertname":"ServiceDown","job":"ui"},"state":"firing","activeAt":"2026-10-16T09:00:00Z","value":"0"}
+		]}}`))
+	}))
+	defer prometheus.Close()
+
+	handler := AdminHandler(Rules(Default()), &Prometheus{URL: prometheus.URL})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, AdminPrefix, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Firing int         `json:"firing"`
+		Rules  []RuleState `json:"rules"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Firing != 1 || len(body.Rules) != 3 {
+		t.Fatalf("body = %+v", body)
+	}
+	heartbeat := body.Rules[1]
+	if heartbeat.State != StateFiring || len(heartbeat.Active) != 2 || heartbeat.Active[0].Labels["head_id"] != "h1" {
+		t.Errorf("heartbeat state = %+v", heartbeat)
+	}
+	if body.Rules[0].State != StateInactive || body.Rules[0].For != "5m" {
+		t.Errorf("provider state = %+v", body.Rules[0])
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, AdminPrefix+"/rules", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "alert: CircuitBreakerStuckOpen") {
+		t.Errorf("rules = %d %s", rec.Code, rec.Body)
+	}
+
+	down := &Prometheus{URL: prometheus.URL + "/missing"}
+	if _, err := States(context.Background(), Rules(Default()), down); err == nil {
+		t.Error("failed query returned no error")
+	}
+}
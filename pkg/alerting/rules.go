@@ -0,0 +1,267 @@
+// Package alerting generates the Prometheus alerting rules of the platform
+// from service configuration and reports their current state. Prometheus
+// loads the generated rule file and sends firing alerts to Alertmanager:
+//
+//	rule_files:
+//	  - "zb-alerts.yml"
+//
+// Thresholds come from Config, e.g. the heartbeat interval heads are
+// configured with, so that the rules follow the services instead of being
+// edited by hand.
+package alerting
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GroupName is the rule group every generated rule is in
+const GroupName = "zb_generated"
+
+// Names of the generated alerts
+const (
+	AlertProviderErrorRate   = "ProviderErrorRateHigh"
+	AlertHeadHeartbeat       = "HeadHeartbeatMissing"
+	AlertBudgetOverrun       = "BudgetOverrun"
+	AlertCircuitBreakerStuck = "CircuitBreakerStuckOpen"
+)
+
+// Config holds the thresholds the rules are generated from
+type Config struct {
+	// ProviderErrorRate is the share of failed provider requests over
+	// ProviderErrorWindow that fires ProviderErrorRateHigh
+	ProviderErrorRate   float64
+	ProviderErrorWindow time.Duration
+	// HeartbeatInterval is how often heads send heartbeats; a head that
+	// misses MissedHeartbeats of them fires HeadHeartbeatMissing
+	HeartbeatInterval time.Duration
+	MissedHeartbeats  int
+	// DailyBudgetUSD is the provider spend over 24h that fires BudgetOverrun;
+	// zero leaves the rule out
+	DailyBudgetUSD float64
+	// BreakerOpenFor is how long a circuit breaker may stay open without
+	// closing before CircuitBreakerStuckOpen fires
+	BreakerOpenFor time.Duration
+}
+
+// Default returns the thresholds for the default service configuration
+func Default() Config {
+	return Config{
+		ProviderErrorRate:   0.05,
+		ProviderErrorWindow: 5 * time.Minute,
+		HeartbeatInterval:   10 * time.Second,
+		MissedHeartbeats:    3,
+		BreakerOpenFor:      10 * time.Minute,
+	}
+}
+
+// FromEnv returns Default with the thresholds set in
+// ALERT_PROVIDER_ERROR_RATE, ALERT_PROVIDER_ERROR_WINDOW,
+// ALERT_HEARTBEAT_INTERVAL, ALERT_MISSED_HEARTBEATS,
+// ALERT_DAILY_BUDGET_USD and ALERT_BREAKER_OPEN_FOR
+func FromEnv() (Config, error) {
+	cfg := Default()
+	var err error
+	if cfg.ProviderErrorRate, err = envFloat("ALERT_PROVIDER_ERROR_RATE", cfg.ProviderErrorRate); err != nil {
+		return cfg, err
+	}
+	if cfg.ProviderErrorRate <= 0 || cfg.ProviderErrorRate > 1 {
+		return cfg, fmt.Errorf("ALERT_PROVIDER_ERROR_RATE: want a share in (0, 1], got %v", cfg.ProviderErrorRate)
+	}
+	if cfg.ProviderErrorWindow, err = envDuration("ALERT_PROVIDER_ERROR_WINDOW", cfg.ProviderErrorWindow); err != nil {
+		return cfg, err
+	}
+	if cfg.HeartbeatInterval, err = envDuration("ALERT_HEARTBEAT_INTERVAL", cfg.HeartbeatInterval); err != nil {
+		return cfg, err
+	}
+	if raw := os.Getenv("ALERT_MISSED_HEARTBEATS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("ALERT_MISSED_HEARTBEATS: want a positive count, got %q", raw)
+		}
+		cfg.MissedHeartbeats = n
+	}
+	if cfg.DailyBudgetUSD, err = envFloat("ALERT_DAILY_BUDGET_USD", cfg.DailyBudgetUSD); err != nil {
+		return cfg, err
+	}
+	if cfg.BreakerOpenFor, err = envDuration("ALERT_BREAKER_OPEN_FOR", cfg.BreakerOpenFor); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v < 0 {
+		return def, fmt.Errorf("%s: want a non-negative number, got %q", name, raw)
+	}
+	return v, nil
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return def, fmt.Errorf("%s: want a positive duration such as 5m, got %q", name, raw)
+	}
+	return d, nil
+}
+
+// Rule is a Prometheus alerting rule
+type Rule struct {
+	Alert       string
+	Expr        string
+	For         time.Duration
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// Severity returns the severity label of the rule
+func (r Rule) Severity() string {
+	return r.Labels["severity"]
+}
+
+// Rules returns the alerting rules for cfg
+func Rules(cfg Config) []Rule {
+	heartbeatTimeout := cfg.HeartbeatInterval * time.Duration(cfg.MissedHeartbeats)
+	rules := []Rule{
+		{
+			Alert: AlertProviderErrorRate,
+			Expr: fmt.Sprintf(`sum by (provider) (rate(gateway_provider_requests_total{result="error"}[%[1]s]))`+
+				` / sum by (provider) (rate(gateway_provider_requests_total{result=~"success|error"}[%[1]s])) > %[2]s`,
+				duration(cfg.ProviderErrorWindow), formatFloat(cfg.ProviderErrorRate)),
+			For:    cfg.ProviderErrorWindow,
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "Provider {{ $labels.provider }} is failing",
+				"description": fmt.Sprintf("More than %s%% of requests to the provider failed over %s", formatFloat(cfg.ProviderErrorRate*100), duration(cfg.ProviderErrorWindow)),
+			},
+		},
+		{
+			Alert:  AlertHeadHeartbeat,
+			Expr:   fmt.Sprintf("time() - head_last_heartbeat_timestamp_seconds > %s", formatFloat(heartbeatTimeout.Seconds())),
+			For:    cfg.HeartbeatInterval,
+			Labels: map[string]string{"severity": "critical"},
+			Annotations: map[string]string{
+				"summary":     "Head {{ $labels.head_id }} stopped sending heartbeats",
+				"description": fmt.Sprintf("No heartbeat from the head for %s (%d missed)", duration(heartbeatTimeout), cfg.MissedHeartbeats),
+			},
+		},
+	}
+	if cfg.DailyBudgetUSD > 0 {
+		rules = append(rules, Rule{
+			Alert:  AlertBudgetOverrun,
+			Expr:   fmt.Sprintf("sum(increase(gateway_usage_cost_usd_total[1d])) > %s", formatFloat(cfg.DailyBudgetUSD)),
+			Labels: map[string]string{"severity": "warning"},
+			Annotations: map[string]string{
+				"summary":     "Provider spend is over budget",
+				"description": fmt.Sprintf("Usage cost over the last 24h is above the daily budget of $%s", formatFloat(cfg.DailyBudgetUSD)),
+			},
+		})
+	}
+	rules = append(rules, Rule{
+		Alert: AlertCircuitBreakerStuck,
+		// Open is 2 and half-open 1: a breaker that trips again on every
+		// half-open probe never gets back to 0
+		Expr:   fmt.Sprintf("min_over_time(gateway_circuit_breaker_state[%s]) > 0", duration(cfg.BreakerOpenFor)),
+		For:    time.Minute,
+		Labels: map[string]string{"severity": "warning"},
+		Annotations: map[string]string{
+			"summary":     "Circuit breaker {{ $labels.name }} is stuck open",
+			"description": fmt.Sprintf("The circuit breaker has not closed for %s", duration(cfg.BreakerOpenFor)),
+		},
+	})
+	return rules
+}
+
+// YAML renders rules as a Prometheus rule file
+func YAML(rules []Rule) []byte {
+	var b strings.Builder
+	b.WriteString("# Generated by pkg/alerting; do not edit\n")
+	b.WriteString("groups:\n")
+	fmt.Fprintf(&b, "  - name: %s\n", GroupName)
+	b.WriteString("    rules:\n")
+	for i, r := range rules {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "      - alert: %s\n", r.Alert)
+		fmt.Fprintf(&b, "        expr: %s\n", quote(r.Expr))
+		if r.For > 0 {
+			fmt.Fprintf(&b, "        for: %s\n", duration(r.For))
+		}
+		writeMap(&b, "labels", r.Labels)
+		writeMap(&b, "annotations", r.Annotations)
+	}
+	return []byte(b.String())
+}
+
+func writeMap(b *strings.Builder, name string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "        %s:\n", name)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "          %s: %s\n", k, quote(m[k]))
+	}
+}
+
+// quote returns a YAML double-quoted scalar; its escapes are a superset of
+// JSON's
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r < 0x20:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// duration formats d as a Prometheus duration, e.g. 1h30m or 45s
+func duration(d time.Duration) string {
+	var b strings.Builder
+	for _, unit := range []struct {
+		suffix string
+		size   time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}, {"s", time.Second}, {"ms", time.Millisecond}} {
+		if n := d / unit.size; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, unit.suffix)
+			d -= n * unit.size
+		}
+	}
+	if b.Len() == 0 {
+		return "0s"
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
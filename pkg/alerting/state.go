@@ -0,0 +1,166 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// AdminPrefix is the path the admin API is mounted at
+const AdminPrefix = "/admin/api/alerts"
+
+// Alert states as Prometheus reports them; a rule without active alerts is
+// inactive
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+)
+
+// Alert is an active instance of a rule
+type Alert struct {
+	Name        string            `json:"name"`
+	State       string            `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	ActiveAt    time.Time         `json:"active_at"`
+	Value       string            `json:"value,omitempty"`
+}
+
+// Source returns the active alerts
+type Source interface {
+	Alerts(ctx context.Context) ([]Alert, error)
+}
+
+// Prometheus reads the active alerts from the Prometheus HTTP API
+type Prometheus struct {
+	// URL is the Prometheus base URL, e.g. http://prometheus:9090
+	URL    string
+	Client *http.Client
+}
+
+// Alerts returns the pending and firing alerts of every rule Prometheus
+// evaluates
+func (p *Prometheus) Alerts(ctx context.Context) ([]Alert, error) {
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.URL, "/")+"/api/v1/alerts", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus alerts: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query Prometheus alerts: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Alerts []struct {
+				Labels      map[string]string `json:"labels"`
+				Annotations map[string]string `json:"annotations"`
+				State       string            `json:"state"`
+				ActiveAt    time.Time         `json:"activeAt"`
+				Value       string            `json:"value"`
+			} `json:"alerts"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode Prometheus alerts: %w", err)
+	}
+	alerts := make([]Alert, 0, len(body.Data.Alerts))
+	for _, a := range body.Data.Alerts {
+		alerts = append(alerts, Alert{
+			Name:        a.Labels["alertname"],
+			State:       a.State,
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+			ActiveAt:    a.ActiveAt,
+			Value:       a.Value,
+		})
+	}
+	return alerts, nil
+}
+
+// RuleState is a generated rule with its active alerts
+type RuleState struct {
+	Alert    string `json:"alert"`
+	Severity string `json:"severity"`
+	Expr     string `json:"expr"`
+	For      string `json:"for"`
+	// State is the most severe state of the active alerts
+	State  string  `json:"state"`
+	Active []Alert `json:"active"`
+}
+
+// States returns every rule with its active alerts from src; alerts of
+// rules not generated here are left out
+func States(ctx context.Context, rules []Rule, src Source) ([]RuleState, error) {
+	alerts, err := src.Alerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]RuleState, len(rules))
+	index := make(map[string]int, len(rules))
+	for i, r := range rules {
+		states[i] = RuleState{Alert: r.Alert, Severity: r.Severity(), Expr: r.Expr, For: duration(r.For), State: StateInactive, Active: []Alert{}}
+		index[r.Alert] = i
+	}
+	for _, a := range alerts {
+		i, ok := index[a.Name]
+		if !ok {
+			continue
+		}
+		states[i].Active = append(states[i].Active, a)
+		if a.State == StateFiring || states[i].State == StateInactive {
+			states[i].State = a.State
+		}
+	}
+	return states, nil
+}
+
+// AdminHandler serves the rules and their state for the admin dashboard.
+// Mount it at AdminPrefix and its subpaths behind the admin auth of the
+// service:
+//
+//	GET /admin/api/alerts        rules with their active alerts
+//	GET /admin/api/alerts/rules  the Prometheus rule file
+func AdminHandler(rules []Rule, src Source) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		switch strings.Trim(strings.TrimPrefix(r.URL.Path, AdminPrefix), "/") {
+		case "":
+			states, err := States(r.Context(), rules, src)
+			if err != nil {
+				apierror.Write(w, http.StatusServiceUnavailable, err.Error())
+				return
+			}
+			firing := 0
+			for _, s := range states {
+				if s.State == StateFiring {
+					firing++
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"firing": firing, "rules": states})
+		case "rules":
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write(YAML(rules))
+		default:
+			apierror.Write(w, http.StatusNotFound, "not found")
+		}
+	})
+}
@@ -0,0 +1,108 @@
+// Package annotations attributes cost and latency to individual responses.
+//
+// Responses carry X-ZB-Provider, X-ZB-Cost-USD, X-ZB-Latency-Ms and, where
+// a cache is involved, X-ZB-Cache (hit, miss or partial); streamed responses
+// send cost and latency as trailers. Clients that send
+// "X-ZB-Annotations: body" also get them as a "zb" field of JSON responses.
+// ZB_ANNOTATIONS=off disables both.
+package annotations
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response headers
+const (
+	HeaderProvider = "X-ZB-Provider"
+	HeaderCost     = "X-ZB-Cost-USD"
+	HeaderLatency  = "X-ZB-Latency-Ms"
+	HeaderCache    = "X-ZB-Cache"
+)
+
+// RequestHeader asks for the annotations in the response body
+const RequestHeader = "X-ZB-Annotations"
+
+// Cache results
+const (
+	CacheHit     = "hit"
+	CacheMiss    = "miss"
+	CachePartial = "partial"
+)
+
+var enabled = !strings.EqualFold(os.Getenv("ZB_ANNOTATIONS"), "off")
+
+// Annotation describes how a request was served
+type Annotation struct {
+	Provider string        `json:"provider,omitempty"`
+	CostUSD  float64       `json:"cost_usd"`
+	Latency  time.Duration `json:"-"`
+	// CacheHit, CacheMiss or CachePartial; empty when no cache is involved
+	Cache string `json:"cache,omitempty"`
+}
+
+// MarshalJSON reports the latency in milliseconds
+func (a Annotation) MarshalJSON() ([]byte, error) {
+	type annotation Annotation
+	return json.Marshal(struct {
+		annotation
+		LatencyMs int64 `json:"latency_ms"`
+	}{annotation(a), a.Latency.Milliseconds()})
+}
+
+// SetHeaders sets the response headers; call it before writing the header
+func (a Annotation) SetHeaders(h http.Header) {
+	if !enabled {
+		return
+	}
+	if a.Provider != "" {
+		h.Set(HeaderProvider, a.Provider)
+	}
+	h.Set(HeaderCost, strconv.FormatFloat(a.CostUSD, 'f', 6, 64))
+	h.Set(HeaderLatency, strconv.FormatInt(a.Latency.Milliseconds(), 10))
+	if a.Cache != "" {
+		h.Set(HeaderCache, a.Cache)
+	}
+}
+
+// DeclareTrailers announces cost and latency as trailers, for streamed
+// responses whose cost is only known at the end. Call SetHeaders after the
+// body to send them.
+func DeclareTrailers(h http.Header) {
+	if enabled {
+		h.Add("Trailer", HeaderCost)
+		h.Add("Trailer", HeaderLatency)
+	}
+}
+
+// WantsBody reports whether the client asked for annotations in the body
+func WantsBody(r *http.Request) bool {
+	return enabled && strings.EqualFold(r.Header.Get(RequestHeader), "body")
+}
+
+// AddToBody adds the annotation as a "zb" field of a JSON object, keeping
+// the rest of the body as is. Anything else is returned unchanged.
+func AddToBody(body []byte, a Annotation) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) < 2 || trimmed[0] != '{' || !json.Valid(trimmed) {
+		return body
+	}
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return body
+	}
+
+	annotated := make([]byte, 0, len(trimmed)+len(raw)+8)
+	annotated = append(annotated, trimmed[:len(trimmed)-1]...)
+	if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+		annotated = append(annotated, ',')
+	}
+	annotated = append(annotated, `"zb":`...)
+	annotated = append(annotated, raw...)
+	return append(annotated, '}')
+}
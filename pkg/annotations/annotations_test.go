@@ -0,0 +1,52 @@
+package annotations
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	Annotation{Provider: "openai", CostUSD: 0.00123, Latency: 1500 * time.Millisecond, Cache: CacheMiss}.SetHeaders(h)
+
+	want := map[string]string{
+		HeaderProvider: "openai",
+		HeaderCost:     "0.001230",
+		HeaderLatency:  "1500",
+		HeaderCache:    "miss",
+	}
+	for name, value := range want {
+		if got := h.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestAddToBody(t *testing.T) {
+	a := Annotation{Provider: "openai", CostUSD: 0.5, Latency: 20 * time.Millisecond}
+
+	body := AddToBody([]byte(`{"id":"x","choices":[]}`+"\n"), a)
+	var fields struct {
+		ID string `json:"id"`
+		ZB struct {
+			Provider  string  `json:"provider"`
+			CostUSD   float64 `json:"cost_usd"`
+			LatencyMs int64   `json:"latency_ms"`
+		} `json:"zb"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatalf("invalid JSON %s: %v", body, err)
+	}
+	if fields.ID != "x" || fields.ZB.Provider != "openai" || fields.ZB.CostUSD != 0.5 || fields.ZB.LatencyMs != 20 {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	if got := string(AddToBody([]byte(`{}`), a)); got != `{"zb":{"provider":"openai","cost_usd":0.5,"latency_ms":20}}` {
+		t.Errorf("empty object: %s", got)
+	}
+	if got := string(AddToBody([]byte(`[1]`), a)); got != `[1]` {
+		t.Errorf("array changed: %s", got)
+	}
+}
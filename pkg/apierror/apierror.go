@@ -0,0 +1,119 @@
+// Package apierror writes errors in the OpenAI format, so clients and SDKs
+// handle errors from every service the same way:
+//
+//	{"error": {"message": "...", "type": "invalid_request_error", "param": null, "code": null}}
+//
+// The type follows the status unless it is set explicitly; param and code are
+// null unless set.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error types
+const (
+	TypeInvalidRequest = "invalid_request_error"
+	TypeAuthentication = "authentication_error"
+	TypePermission     = "permission_error"
+	TypeRateLimit      = "rate_limit_error"
+	// TypeAPI is a failure of a provider or another upstream service
+	TypeAPI    = "api_error"
+	TypeServer = "server_error"
+)
+
+// Error is an API error with its HTTP status
+type Error struct {
+	Status  int
+	Message string
+	Type    string
+	Param   string
+	Code    string
+}
+
+// New returns an error of the type that matches status
+func New(status int, message string) *Error {
+	return &Error{Status: status, Message: message, Type: TypeForStatus(status)}
+}
+
+// WithCode sets the machine-readable code, e.g. "model_not_found"
+func (e *Error) WithCode(code string) *Error {
+	e.Code = code
+	return e
+}
+
+// WithParam sets the request parameter the error is about
+func (e *Error) WithParam(param string) *Error {
+	e.Param = param
+	return e
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Write sends the error with its status
+func (e *Error) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(e.body())
+}
+
+// MarshalJSON encodes the error as the response body
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.body())
+}
+
+type errorBody struct {
+	Error errorFields `json:"error"`
+}
+
+type errorFields struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+func (e *Error) body() errorBody {
+	typ := e.Type
+	if typ == "" {
+		typ = TypeForStatus(e.Status)
+	}
+	return errorBody{Error: errorFields{
+		Message: e.Message,
+		Type:    typ,
+		Param:   nullable(e.Param),
+		Code:    nullable(e.Code),
+	}}
+}
+
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// Write sends an error of the type that matches status
+func Write(w http.ResponseWriter, status int, message string) {
+	New(status, message).Write(w)
+}
+
+// TypeForStatus returns the error type OpenAI uses for an HTTP status
+func TypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return TypeAuthentication
+	case status == http.StatusForbidden:
+		return TypePermission
+	case status == http.StatusTooManyRequests:
+		return TypeRateLimit
+	case status == http.StatusBadGateway, status == http.StatusServiceUnavailable, status == http.StatusGatewayTimeout:
+		return TypeAPI
+	case status >= 500:
+		return TypeServer
+	default:
+		return TypeInvalidRequest
+	}
+}
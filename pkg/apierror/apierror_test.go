@@ -0,0 +1,47 @@
+package apierror
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, http.StatusBadRequest, `invalid "json"`)
+
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("status %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	want := `{"error":{"message":"invalid \"json\"","type":"invalid_request_error","param":null,"code":null}}` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("body %s, want %s", rec.Body, want)
+	}
+}
+
+func TestCodeAndParam(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(http.StatusNotFound, "model not found").WithCode("model_not_found").WithParam("model").Write(rec)
+
+	want := `{"error":{"message":"model not found","type":"invalid_request_error","param":"model","code":"model_not_found"}}` + "\n"
+	if rec.Code != http.StatusNotFound || rec.Body.String() != want {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTypeForStatus(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusBadRequest:          TypeInvalidRequest,
+		http.StatusNotFound:            TypeInvalidRequest,
+		http.StatusUnauthorized:        TypeAuthentication,
+		http.StatusForbidden:           TypePermission,
+		http.StatusTooManyRequests:     TypeRateLimit,
+		http.StatusInternalServerError: TypeServer,
+		http.StatusBadGateway:          TypeAPI,
+		http.StatusServiceUnavailable:  TypeAPI,
+	} {
+		if got := TypeForStatus(status); got != want {
+			t.Errorf("%d: %s, want %s", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package apiversion negotiates the version of public API requests. The
+// version is the first path segment, e.g. /v2/chat/completions; a client may
+// also name it in X-API-Version ("v2" or "2"), which must then agree with
+// the path. Every versioned response says which version served it:
+//
+//	X-API-Version: v1
+//	Deprecation: @1798761600
+//	Sunset: Sat, 01 May 2027 00:00:00 GMT
+//	Link: <https://docs.example.com/migrate-v2>; rel="deprecation"
+//
+// Deprecation (RFC 9745) and Sunset (RFC 8594) are sent once a version has a
+// deprecation or sunset date. After its sunset a version answers 410.
+//
+// Rate limits, timeouts and load-shedding priorities are keyed by /v1 paths;
+// Canonical maps the paths of every version onto them.
+package apiversion
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// Header names the version a client expects
+const Header = "X-API-Version"
+
+// Base is the version path-keyed policies are written for
+const Base = "v1"
+
+var segment = regexp.MustCompile(`^/(v[0-9]+)(/|$)`)
+
+var requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zb_api_version_requests_total",
+	Help: "Public API requests by negotiated version; rejected ones have an empty version",
+}, []string{"service", "version", "deprecated", "outcome"})
+
+// Version is a supported API version
+type Version struct {
+	// Name is the path segment, e.g. "v2"
+	Name string
+	// Deprecated is when the version was or will be deprecated, zero while
+	// it is not
+	Deprecated time.Time
+	// Sunset is when the version stops being served, zero for never
+	Sunset time.Time
+	// Link documents the migration off the version
+	Link string
+}
+
+// Of returns the version segment of a path, empty for unversioned paths such
+// as /health
+func Of(path string) string {
+	if m := segment.FindStringSubmatch(path); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// Canonical returns the path under Base, e.g. /v1/embeddings for
+// /v2/embeddings; unversioned paths are returned as they are
+func Canonical(path string) string {
+	v := Of(path)
+	if v == "" || v == Base {
+		return path
+	}
+	return "/" + Base + path[len(v)+1:]
+}
+
+type contextKey struct{}
+
+// FromContext returns the negotiated version of a request, empty for
+// unversioned paths
+func FromContext(ctx context.Context) string {
+	v, _ := ctx.Value(contextKey{}).(string)
+	return v
+}
+
+// Negotiator checks requests against the supported versions
+type Negotiator struct {
+	service  string
+	versions map[string]Version
+	now      func() time.Time
+}
+
+// New returns a negotiator of the versions a service supports
+func New(service string, versions ...Version) *Negotiator {
+	n := &Negotiator{service: service, versions: make(map[string]Version, len(versions)), now: time.Now}
+	for _, v := range versions {
+		n.versions[v.Name] = v
+	}
+	return n
+}
+
+// FromEnv returns a negotiator of the named versions with their dates from
+// API_<NAME>_DEPRECATED and API_<NAME>_SUNSET (YYYY-MM-DD or RFC 3339) and
+// their migration guide from API_<NAME>_LINK, e.g. API_V1_SUNSET=2027-05-01
+func FromEnv(service string, names ...string) (*Negotiator, error) {
+	versions := make([]Version, 0, len(names))
+	for _, name := range names {
+		prefix := "API_" + strings.ToUpper(name) + "_"
+		v := Version{Name: name, Link: os.Getenv(prefix + "LINK")}
+		var err error
+		if v.Deprecated, err = parseDate(prefix+"DEPRECATED", os.Getenv(prefix+"DEPRECATED")); err != nil {
+			return nil, err
+		}
+		if v.Sunset, err = parseDate(prefix+"SUNSET", os.Getenv(prefix+"SUNSET")); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return New(service, versions...), nil
+}
+
+func parseDate(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: want YYYY-MM-DD or RFC 3339, got %q", name, value)
+	}
+	return t, nil
+}
+
+// Versions returns the supported version names in order
+func (n *Negotiator) Versions() []string {
+	names := make([]string, 0, len(n.versions))
+	for name := range n.versions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(names[i][1:])
+		b, _ := strconv.Atoi(names[j][1:])
+		return a < b
+	})
+	return names
+}
+
+// Middleware rejects unsupported, mismatched and sunset versions, sets the
+// version headers and puts the version in the context. Unversioned paths
+// pass through untouched.
+func (n *Negotiator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := Of(r.URL.Path)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		v, ok := n.versions[name]
+		if !ok {
+			n.count("", false, "unsupported")
+			apierror.New(http.StatusNotFound, fmt.Sprintf("API version %s is not supported, use one of %s", name, strings.Join(n.Versions(), ", "))).
+				WithCode("unsupported_api_version").Write(w)
+			return
+		}
+		if asked := r.Header.Get(Header); asked != "" && normalize(asked) != name {
+			n.count("", false, "mismatch")
+			apierror.New(http.StatusBadRequest, fmt.Sprintf("%s %q does not match the %s path", Header, asked, name)).
+				WithCode("api_version_mismatch").Write(w)
+			return
+		}
+
+		now := n.now()
+		deprecated := !v.Deprecated.IsZero() && !now.Before(v.Deprecated)
+		h := w.Header()
+		h.Set(Header, name)
+		if !v.Deprecated.IsZero() {
+			h.Set("Deprecation", "@"+strconv.FormatInt(v.Deprecated.Unix(), 10))
+		}
+		if !v.Sunset.IsZero() {
+			h.Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if v.Link != "" && (!v.Deprecated.IsZero() || !v.Sunset.IsZero()) {
+			h.Set("Link", fmt.Sprintf("<%s>; rel=%q", v.Link, "deprecation"))
+		}
+
+		if !v.Sunset.IsZero() && !now.Before(v.Sunset) {
+			n.count(name, deprecated, "sunset")
+			apierror.New(http.StatusGone, fmt.Sprintf("API version %s was retired on %s", name, v.Sunset.UTC().Format("2006-01-02"))).
+				WithCode("api_version_sunset").Write(w)
+			return
+		}
+		n.count(name, deprecated, "served")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, name)))
+	})
+}
+
+func (n *Negotiator) count(version string, deprecated bool, outcome string) {
+	requestsTotal.WithLabelValues(n.service, version, strconv.FormatBool(deprecated), outcome).Inc()
+}
+
+// normalize turns "2" and "V2" into "v2"
+func normalize(v string) string {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return v
+}
@@ -0,0 +1,98 @@
+package apiversion
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCanonical(t *testing.T) {
+	for path, want := range map[string]string{
+		"/v2/embeddings":        "/v1/embeddings",
+		"/v1/chat/completions":  "/v1/chat/completions",
+		"/v10":                  "/v1",
+		"/health":               "/health",
+		"/vendors/v2/whatever":  "/vendors/v2/whatever",
+		"/v2x/chat/completions": "/v2x/chat/completions",
+	} {
+		if got := Canonical(path); got != want {
+			t.Errorf("Canonical(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	n := New("test",
+		Version{Name: "v1", Deprecated: now.AddDate(0, -1, 0), Sunset: now.AddDate(0, 6, 0), Link: "https://docs/migrate"},
+		Version{Name: "v2"},
+	)
+	n.now = func() time.Time { return now }
+
+	var served string
+	handler := n.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = FromContext(r.Context())
+	}))
+	do := func(path, header string) *httptest.ResponseRecorder {
+		served = ""
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		if header != "" {
+			r.Header.Set(Header, header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := do("/v1/chat/completions", "")
+	if served != "v1" || rec.Header().Get(Header) != "v1" {
+		t.Fatalf("v1 served as %q, headers %v", served, rec.Header())
+	}
+	if rec.Header().Get("Deprecation") != "@1788220800" || rec.Header().Get("Sunset") != "Thu, 01 Apr 2027 00:00:00 GMT" ||
+		rec.Header().Get("Link") != `<https://docs/migrate>; rel="deprecation"` {
+		t.Errorf("deprecation headers = %v", rec.Header())
+	}
+
+	rec = do("/v2/chat/completions", "2")
+	if served != "v2" || rec.Header().Get("Deprecation") != "" || rec.Header().Get("Sunset") != "" {
+		t.Errorf("v2 served as %q, headers %v", served, rec.Header())
+	}
+
+	if rec = do("/health", ""); rec.Header().Get(Header) != "" {
+		t.Errorf("unversioned path got headers %v", rec.Header())
+	}
+	if rec = do("/v3/chat/completions", ""); rec.Code != http.StatusNotFound || served != "" {
+		t.Errorf("unsupported version = %d", rec.Code)
+	}
+	if rec = do("/v1/chat/completions", "v2"); rec.Code != http.StatusBadRequest || served != "" {
+		t.Errorf("mismatched version = %d", rec.Code)
+	}
+
+	now = now.AddDate(1, 0, 0)
+	if rec = do("/v1/chat/completions", ""); rec.Code != http.StatusGone || served != "" {
+		t.Errorf("sunset version = %d", rec.Code)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("API_V1_DEPRECATED", "2026-11-01")
+	t.Setenv("API_V1_SUNSET", "2027-05-01T12:00:00Z")
+	n, err := FromEnv("test", "v2", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Versions(); !reflect.DeepEqual(got, []string{"v1", "v2"}) {
+		t.Errorf("versions = %v", got)
+	}
+	v1 := n.versions["v1"]
+	if !v1.Deprecated.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) || v1.Sunset.Hour() != 12 || !n.versions["v2"].Sunset.IsZero() {
+		t.Errorf("versions = %+v", n.versions)
+	}
+
+	t.Setenv("API_V1_SUNSET", "soon")
+	if _, err := FromEnv("test", "v1"); err == nil {
+		t.Error("invalid sunset accepted")
+	}
+}
@@ -0,0 +1,229 @@
+// Package certs keeps service certificates fresh without restarts.
+//
+// A Manager loads a Bundle (certificate, key and CA pool) from a Source,
+// checks the source every RefreshInterval and swaps in the new bundle when the
+// files changed on disk, a Vault-issued certificate is due for renewal or the
+// SPIFFE Workload API rotated the SVID. TLS configs from ServerConfig and
+// ClientConfig read the current bundle on every handshake, so servers and
+// gRPC connections pick up rotated certificates and CAs as they are replaced.
+//
+// Peers are authorized by SPIFFE ID with an Authorizer on top of the chain
+// verification.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	expiryDays = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tls_certificate_expiry_days",
+		Help: "Days until the current certificate expires, by certificate name",
+	}, []string{"name"})
+	reloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tls_certificate_reloads_total",
+		Help: "Certificate loads, by certificate name and result",
+	}, []string{"name", "result"})
+)
+
+// DefaultRefreshInterval is how often a Manager checks its source
+const DefaultRefreshInterval = time.Minute
+
+// Bundle is a certificate with its key and the CAs that verify peers
+type Bundle struct {
+	Certificate tls.Certificate
+	Leaf        *x509.Certificate
+	CAs         *x509.CertPool
+}
+
+// Source loads bundles and tells the Manager when to load again
+type Source interface {
+	Load(ctx context.Context) (*Bundle, error)
+	// Due reports whether current should be replaced by a new Load
+	Due(current *Bundle, now time.Time) bool
+}
+
+// Manager holds the current bundle of one certificate
+type Manager struct {
+	name            string
+	source          Source
+	RefreshInterval time.Duration
+
+	mu     sync.RWMutex
+	bundle *Bundle
+}
+
+// NewManager loads the first bundle from source; name labels logs and metrics
+func NewManager(name string, source Source) (*Manager, error) {
+	m := &Manager{name: name, source: source, RefreshInterval: DefaultRefreshInterval}
+	if err := m.Reload(context.Background()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Watch is NewManager with the source chosen by SourceFromEnv, refreshed in
+// the background for the life of the process
+func Watch(name, certFile, keyFile, caFile string) (*Manager, error) {
+	m, err := NewManager(name, SourceFromEnv(name, certFile, keyFile, caFile))
+	if err != nil {
+		return nil, err
+	}
+	go m.Run(context.Background())
+	return m, nil
+}
+
+// Bundle returns the current bundle
+func (m *Manager) Bundle() *Bundle {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.bundle
+}
+
+// Reload loads a new bundle now. On error the current one is kept.
+func (m *Manager) Reload(ctx context.Context) error {
+	b, err := m.source.Load(ctx)
+	if err == nil && b.Leaf == nil {
+		b.Leaf, err = x509.ParseCertificate(b.Certificate.Certificate[0])
+	}
+	if err != nil {
+		reloadsTotal.WithLabelValues(m.name, "error").Inc()
+		return fmt.Errorf("failed to load %s certificate: %w", m.name, err)
+	}
+
+	m.mu.Lock()
+	m.bundle = b
+	m.mu.Unlock()
+	reloadsTotal.WithLabelValues(m.name, "success").Inc()
+	expiryDays.WithLabelValues(m.name).Set(time.Until(b.Leaf.NotAfter).Hours() / 24)
+	return nil
+}
+
+// Run checks the source every RefreshInterval until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			current := m.Bundle()
+			expiryDays.WithLabelValues(m.name).Set(current.Leaf.NotAfter.Sub(now).Hours() / 24)
+			if !m.source.Due(current, now) {
+				continue
+			}
+			if err := m.Reload(ctx); err != nil {
+				log.Printf("Certificates: %v; keeping the current one", err)
+				continue
+			}
+			log.Printf("Certificates: reloaded %s, valid until %s", m.name, m.Bundle().Leaf.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// GetCertificate is a tls.Config callback serving the current certificate
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &m.Bundle().Certificate, nil
+}
+
+// GetClientCertificate is a tls.Config callback presenting the current
+// certificate to servers
+func (m *Manager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return &m.Bundle().Certificate, nil
+}
+
+// ServerConfig serves the current certificate and verifies client
+// certificates against the current CAs as clientAuth asks. Verified clients
+// must also pass authorize; nil trusts any certificate the CAs signed.
+//
+// crypto/tls only verifies against a fixed ClientCAs pool, so clients are
+// verified in VerifyPeerCertificate instead.
+func (m *Manager) ServerConfig(clientAuth tls.ClientAuthType, authorize Authorizer) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: m.GetCertificate,
+		ClientAuth:     clientAuth,
+		MinVersion:     tls.VersionTLS12,
+	}
+	switch clientAuth {
+	case tls.RequireAndVerifyClientCert:
+		config.ClientAuth = tls.RequireAnyClientCert
+	case tls.VerifyClientCertIfGiven:
+		config.ClientAuth = tls.RequestClientCert
+	default:
+		return config
+	}
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return nil // only reached with VerifyClientCertIfGiven
+		}
+		return m.verify(rawCerts, "", x509.ExtKeyUsageClientAuth, authorize)
+	}
+	return config
+}
+
+// ClientConfig presents the current certificate and verifies the server
+// against the current CAs. serverName overrides the name checked in the
+// server certificate; when empty the dialled host name is used. SPIFFE
+// certificates name workloads rather than hosts, so for them the name is not
+// checked and authorize decides alone; nil trusts any certificate the CAs
+// signed.
+//
+// crypto/tls only verifies against a fixed RootCAs pool, so the built-in
+// verification is replaced by VerifyConnection.
+func (m *Manager) ClientConfig(serverName string, authorize Authorizer) *tls.Config {
+	return &tls.Config{
+		ServerName:           serverName,
+		GetClientCertificate: m.GetClientCertificate,
+		MinVersion:           tls.VersionTLS12,
+		InsecureSkipVerify:   true, // verified in VerifyConnection
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			raw := make([][]byte, len(cs.PeerCertificates))
+			for i, cert := range cs.PeerCertificates {
+				raw[i] = cert.Raw
+			}
+			return m.verify(raw, cs.ServerName, x509.ExtKeyUsageServerAuth, authorize)
+		},
+	}
+}
+
+func (m *Manager) verify(rawCerts [][]byte, dnsName string, usage x509.ExtKeyUsage, authorize Authorizer) error {
+	if len(rawCerts) == 0 {
+		return errors.New("certs: peer sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("certs: invalid peer certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if PeerID(certs[0]) != "" {
+		dnsName = ""
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       dnsName,
+		Roots:         m.Bundle().CAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil || authorize == nil {
+		return err
+	}
+	return authorize(certs[0])
+}
@@ -0,0 +1,278 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue signs a certificate for localhost usable by servers and clients
+func (ca *testCA) issue(t *testing.T, serial int64, lifetime time.Duration) (certPEM, keyPEM []byte) {
+	return ca.issueID(t, serial, lifetime, "")
+}
+
+// issueID is issue with a SPIFFE ID instead of a host name when id is set
+func (ca *testCA) issueID(t *testing.T, serial int64, lifetime time.Duration, id string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if id != "" {
+		uri, _ := url.Parse(id)
+		template.URIs, template.DNSNames = []*url.URL{uri}, nil
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFiles(t *testing.T, dir string, ca *testCA, serial int64, mtime time.Time) *FileSource {
+	t.Helper()
+	return writeID(t, dir, ca, serial, mtime, "")
+}
+
+func writeID(t *testing.T, dir string, ca *testCA, serial int64, mtime time.Time, id string) *FileSource {
+	t.Helper()
+	certPEM, keyPEM := ca.issueID(t, serial, time.Hour, id)
+	s := &FileSource{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	for name, data := range map[string][]byte{s.CertFile: certPEM, s.KeyFile: keyPEM, s.CAFile: ca.pem} {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(name, mtime, mtime)
+	}
+	return s
+}
+
+func TestFileSourceReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	source := writeFiles(t, dir, ca, 2, time.Now().Add(-time.Hour))
+	m, err := NewManager("test", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Due(m.Bundle(), time.Now()) {
+		t.Fatal("due before the files changed")
+	}
+
+	writeFiles(t, dir, ca, 3, time.Now())
+	if !source.Due(m.Bundle(), time.Now()) {
+		t.Fatal("not due after the files changed")
+	}
+	if err := m.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if serial := m.Bundle().Leaf.SerialNumber.Int64(); serial != 3 {
+		t.Errorf("serial %d after reload, want 3", serial)
+	}
+	if source.Due(m.Bundle(), time.Now()) {
+		t.Error("due right after reload")
+	}
+
+	// A broken file keeps the current bundle
+	os.WriteFile(source.KeyFile, []byte("garbage"), 0o600)
+	if err := m.Reload(context.Background()); err == nil {
+		t.Error("broken key accepted")
+	}
+	if m.Bundle().Leaf.SerialNumber.Int64() != 3 {
+		t.Error("current bundle replaced by a failed load")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCA(t)
+	server, err := NewManager("server", writeFiles(t, t.TempDir(), ca, 2, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewManager("client", writeFiles(t, t.TempDir(), ca, 3, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := startServer(t, server.ServerConfig(tls.RequireAndVerifyClientCert, nil))
+	get := func(config *tls.Config) error { return getTLS(srv.URL, config) }
+	if err := get(client.ClientConfig("localhost", nil)); err != nil {
+		t.Fatalf("mTLS handshake failed: %v", err)
+	}
+
+	// Certificates from another CA are refused both ways
+	other, err := NewManager("other", writeFiles(t, t.TempDir(), newCA(t), 4, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if get(other.ClientConfig("localhost", nil)) == nil {
+		t.Error("server accepted a client and trusted a server from another CA")
+	}
+	if get(client.ClientConfig("example.com", nil)) == nil {
+		t.Error("server certificate accepted for the wrong name")
+	}
+}
+
+func TestSPIFFEAuthorization(t *testing.T) {
+	ca := newCA(t)
+	manager := func(id string) *Manager {
+		m, err := NewManager(id, writeID(t, t.TempDir(), ca, 2, time.Now(), id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	secrets := manager("spiffe://zb.internal/secrets-service")
+	tail := manager("spiffe://zb.internal/tail")
+	head := manager("spiffe://zb.internal/head")
+
+	t.Setenv("SPIFFE_AUTHORIZED_IDS", "spiffe://zb.internal/tail, spiffe://zb.internal/agentic/*")
+	srv := startServer(t, secrets.ServerConfig(tls.RequireAndVerifyClientCert, secrets.AuthorizeFromEnv()))
+
+	// SVIDs have no host name; the ID is checked instead
+	if err := getTLS(srv.URL, tail.ClientConfig("", tail.AuthorizeService("secrets-service"))); err != nil {
+		t.Fatalf("authorized client refused: %v", err)
+	}
+	if getTLS(srv.URL, head.ClientConfig("", head.AuthorizeService("secrets-service"))) == nil {
+		t.Error("client missing from SPIFFE_AUTHORIZED_IDS accepted")
+	}
+	if getTLS(srv.URL, tail.ClientConfig("", tail.AuthorizeService("auth-service"))) == nil {
+		t.Error("server with another ID trusted")
+	}
+
+	allow := AuthorizeIDs("spiffe://zb.internal/agentic/*")
+	for id, want := range map[string]bool{
+		"spiffe://zb.internal/agentic/worker": true,
+		"spiffe://zb.internal/agentic":        false,
+		"spiffe://other.internal/agentic/x":   false,
+	} {
+		uri, _ := url.Parse(id)
+		if got := allow(&x509.Certificate{URIs: []*url.URL{uri}}) == nil; got != want {
+			t.Errorf("%s: allowed %v, want %v", id, got, want)
+		}
+	}
+
+	// Without an ID in the certificate nothing changes
+	plain, err := NewManager("plain", writeFiles(t, t.TempDir(), ca, 3, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.AuthorizeService("secrets-service") != nil || plain.ID("tail") != "" {
+		t.Error("authorizer for a certificate without SPIFFE ID")
+	}
+}
+
+// startServer serves TLS with config alone; StartTLS would add its own
+// certificate, which crypto/tls prefers over GetCertificate without SNI
+func startServer(t *testing.T, config *tls.Config) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Listener = tls.NewListener(srv.Listener, config)
+	srv.Start()
+	srv.URL = strings.Replace(srv.URL, "http://", "https://", 1)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getTLS(url string, config *tls.Config) error {
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	resp, err := c.Get(url)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestVaultSource(t *testing.T) {
+	ca := newCA(t)
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/pki/issue/tail" || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		var params map[string]string
+		json.NewDecoder(r.Body).Decode(&params)
+		if params["common_name"] != "tail.internal" || params["ttl"] != "1h0m0s" {
+			t.Errorf("params %v", params)
+		}
+		certPEM, keyPEM := ca.issue(t, 5, 3*time.Hour)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"certificate": string(certPEM),
+			"private_key": string(keyPEM),
+			"issuing_ca":  string(ca.pem),
+			"ca_chain":    []string{string(ca.pem)},
+		}})
+	}))
+	defer vault.Close()
+
+	source := &VaultSource{Address: vault.URL, Token: "token", Role: "tail", CommonName: "tail.internal", TTL: time.Hour}
+	m, err := NewManager("tail", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := m.Bundle().Leaf
+	if leaf.SerialNumber.Int64() != 5 || len(m.Bundle().Certificate.Certificate) != 2 {
+		t.Errorf("serial %d, chain of %d", leaf.SerialNumber.Int64(), len(m.Bundle().Certificate.Certificate))
+	}
+	if source.Due(m.Bundle(), leaf.NotBefore.Add(time.Hour)) || !source.Due(m.Bundle(), leaf.NotAfter.Add(-time.Hour+time.Second)) {
+		t.Error("renewal is not due after two thirds of the lifetime")
+	}
+
+	source.Token = "wrong"
+	if err := m.Reload(context.Background()); err == nil {
+		t.Error("vault error not returned")
+	}
+}
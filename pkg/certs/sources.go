@@ -0,0 +1,176 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/tlsutil"
+)
+
+// SourceFromEnv gets SVIDs from the SPIFFE Workload API when
+// SPIFFE_ENDPOINT_SOCKET is set, issues certificates from Vault PKI when
+// VAULT_PKI_ROLE is set and reads the given files otherwise. Vault is
+// configured with VAULT_ADDR, VAULT_TOKEN, VAULT_PKI_MOUNT (default pki),
+// VAULT_PKI_COMMON_NAME (default name) and VAULT_PKI_TTL (default the role's
+// TTL).
+func SourceFromEnv(name, certFile, keyFile, caFile string) Source {
+	if os.Getenv("SPIFFE_ENDPOINT_SOCKET") != "" {
+		return &WorkloadSource{}
+	}
+	role := os.Getenv("VAULT_PKI_ROLE")
+	if role == "" {
+		return &FileSource{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+	}
+	s := &VaultSource{
+		Address:    os.Getenv("VAULT_ADDR"),
+		Token:      os.Getenv("VAULT_TOKEN"),
+		Mount:      os.Getenv("VAULT_PKI_MOUNT"),
+		Role:       role,
+		CommonName: os.Getenv("VAULT_PKI_COMMON_NAME"),
+	}
+	if s.CommonName == "" {
+		s.CommonName = name
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("VAULT_PKI_TTL")); err == nil {
+		s.TTL = ttl
+	}
+	return s
+}
+
+// FileSource reads PEM files and is due when any of them changed on disk,
+// which covers both rewrites and the symlink swaps of mounted secrets
+type FileSource struct {
+	CertFile, KeyFile, CAFile string
+
+	mu     sync.Mutex
+	loaded string
+}
+
+func (s *FileSource) Load(context.Context) (*Bundle, error) {
+	stamp := s.stamp()
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	pool, err := tlsutil.LoadCertPool(s.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.loaded = stamp
+	s.mu.Unlock()
+	return &Bundle{Certificate: cert, CAs: pool}, nil
+}
+
+func (s *FileSource) Due(*Bundle, time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stamp() != s.loaded
+}
+
+// stamp identifies the current contents of the files by size and mtime
+func (s *FileSource) stamp() string {
+	var b strings.Builder
+	for _, name := range []string{s.CertFile, s.KeyFile, s.CAFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			b.WriteString("missing;")
+			continue
+		}
+		fmt.Fprintf(&b, "%d/%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return b.String()
+}
+
+// VaultSource issues certificates from a Vault PKI secrets engine and is due
+// once two thirds of a certificate's lifetime have passed
+type VaultSource struct {
+	Address    string
+	Token      string
+	Mount      string // default pki
+	Role       string
+	CommonName string
+	TTL        time.Duration // 0 for the role's default
+	Client     *http.Client  // default http.DefaultClient
+}
+
+type vaultIssueResponse struct {
+	Data struct {
+		Certificate string   `json:"certificate"`
+		PrivateKey  string   `json:"private_key"`
+		IssuingCA   string   `json:"issuing_ca"`
+		CAChain     []string `json:"ca_chain"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (s *VaultSource) Load(ctx context.Context) (*Bundle, error) {
+	mount := s.Mount
+	if mount == "" {
+		mount = "pki"
+	}
+	params := map[string]string{"common_name": s.CommonName}
+	if s.TTL > 0 {
+		params["ttl"] = s.TTL.String()
+	}
+	body, _ := json.Marshal(params)
+
+	url := strings.TrimRight(s.Address, "/") + "/v1/" + mount + "/issue/" + s.Role
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var issued vaultIssueResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&issued); err != nil && resp.StatusCode < 300 {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.Join(issued.Errors, "; "))
+	}
+	if issued.Data.Certificate == "" || issued.Data.PrivateKey == "" {
+		return nil, errors.New("vault response has no certificate")
+	}
+
+	// Send the issuing CA along so peers that only trust the root can build
+	// the chain
+	chain := issued.Data.Certificate + "\n" + issued.Data.IssuingCA
+	cert, err := tls.X509KeyPair([]byte(chain), []byte(issued.Data.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate from vault: %w", err)
+	}
+	cas := issued.Data.IssuingCA + "\n" + strings.Join(issued.Data.CAChain, "\n")
+	pool, err := tlsutil.CertPoolFromPEM([]byte(cas))
+	if err != nil {
+		return nil, err
+	}
+	return &Bundle{Certificate: cert, CAs: pool}, nil
+}
+
+func (s *VaultSource) Due(current *Bundle, now time.Time) bool {
+	lifetime := current.Leaf.NotAfter.Sub(current.Leaf.NotBefore)
+	return now.After(current.Leaf.NotBefore.Add(lifetime * 2 / 3))
+}
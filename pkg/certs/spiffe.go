@@ -0,0 +1,161 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// WorkloadSource gets X.509 SVIDs and their trust bundle from the SPIFFE
+// Workload API, e.g. a SPIRE agent. The agent rotates SVIDs on its own; the
+// source is due whenever the SVID it holds is not the current one.
+type WorkloadSource struct {
+	// Address of the Workload API, e.g. unix:///run/spire/sockets/agent.sock;
+	// empty uses SPIFFE_ENDPOINT_SOCKET
+	Address string
+
+	mu     sync.Mutex
+	source *workloadapi.X509Source
+}
+
+func (s *WorkloadSource) Load(ctx context.Context) (*Bundle, error) {
+	source, err := s.x509Source(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svid, err := source.GetX509SVID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SVID: %w", err)
+	}
+	trust, err := source.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trust bundle: %w", err)
+	}
+
+	cert := tls.Certificate{PrivateKey: svid.PrivateKey, Leaf: svid.Certificates[0]}
+	for _, c := range svid.Certificates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	pool := x509.NewCertPool()
+	for _, ca := range trust.X509Authorities() {
+		pool.AddCert(ca)
+	}
+	return &Bundle{Certificate: cert, Leaf: svid.Certificates[0], CAs: pool}, nil
+}
+
+func (s *WorkloadSource) Due(current *Bundle, _ time.Time) bool {
+	s.mu.Lock()
+	source := s.source
+	s.mu.Unlock()
+	if source == nil {
+		return true
+	}
+	svid, err := source.GetX509SVID()
+	return err == nil && !bytes.Equal(svid.Certificates[0].Raw, current.Leaf.Raw)
+}
+
+// x509Source connects to the Workload API once and keeps the stream open for
+// the life of the process
+func (s *WorkloadSource) x509Source(ctx context.Context) (*workloadapi.X509Source, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.source != nil {
+		return s.source, nil
+	}
+
+	var options []workloadapi.X509SourceOption
+	if s.Address != "" {
+		options = append(options, workloadapi.WithClientOptions(workloadapi.WithAddr(s.Address)))
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	source, err := workloadapi.NewX509Source(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SPIFFE Workload API: %w", err)
+	}
+	s.source = source
+	return source, nil
+}
+
+// Authorizer decides whether a peer whose certificate chain was verified may
+// connect
+type Authorizer func(peer *x509.Certificate) error
+
+// PeerID returns the SPIFFE ID of a certificate, or "" when it has none
+func PeerID(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			return uri.String()
+		}
+	}
+	return ""
+}
+
+// AuthorizeIDs allows peers with one of the SPIFFE IDs. An ID ending in /*
+// allows every ID under it, e.g. spiffe://zb.internal/* a whole trust domain.
+func AuthorizeIDs(ids ...string) Authorizer {
+	return func(peer *x509.Certificate) error {
+		id := PeerID(peer)
+		if id != "" {
+			for _, allowed := range ids {
+				if id == allowed || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(id, strings.TrimSuffix(allowed, "*")) {
+					return nil
+				}
+			}
+		}
+		if id == "" {
+			id = peer.Subject.CommonName
+		}
+		return fmt.Errorf("certs: peer %q is not authorized", id)
+	}
+}
+
+// ID returns the SPIFFE ID of the service name in the trust domain of the
+// current certificate, or "" when the certificate has no SPIFFE ID
+func (m *Manager) ID(name string) string {
+	own, err := url.Parse(PeerID(m.Bundle().Leaf))
+	if err != nil || own.Host == "" {
+		return ""
+	}
+	return "spiffe://" + own.Host + "/" + name
+}
+
+// AuthorizeService allows only the service name as the peer, for clients that
+// know which service they dial. It is nil, trusting any certificate the CAs
+// signed, when the current certificate has no SPIFFE ID.
+func (m *Manager) AuthorizeService(name string) Authorizer {
+	id := m.ID(name)
+	if id == "" {
+		return nil
+	}
+	return AuthorizeIDs(id)
+}
+
+// AuthorizeFromEnv allows the SPIFFE IDs in SPIFFE_AUTHORIZED_IDS, separated
+// by commas, for servers. Without them a server with a SPIFFE certificate
+// allows its own trust domain, and one without trusts any certificate the CAs
+// signed.
+func (m *Manager) AuthorizeFromEnv() Authorizer {
+	var ids []string
+	for _, id := range strings.Split(os.Getenv("SPIFFE_AUTHORIZED_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		return AuthorizeIDs(ids...)
+	}
+	if id := m.ID("*"); id != "" {
+		return AuthorizeIDs(id)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package clamav scans content for malware with a clamd daemon, streaming it
+// over TCP with the INSTREAM command.
+package clamav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of the INSTREAM chunks; clamd's StreamMaxLength
+// limits the whole stream
+const chunkSize = 64 << 10
+
+// ErrUnavailable wraps failures to reach or talk to clamd
+var ErrUnavailable = errors.New("virus scanner unavailable")
+
+// Scanner talks to one clamd
+type Scanner struct {
+	Addr    string
+	Timeout time.Duration
+}
+
+// FromEnv returns a scanner for CLAMAV_ADDR (host:port), nil when it is unset
+func FromEnv() *Scanner {
+	addr := os.Getenv("CLAMAV_ADDR")
+	if addr == "" {
+		return nil
+	}
+	return &Scanner{Addr: addr, Timeout: 2 * time.Minute}
+}
+
+// Scan returns the name of the malware found in content, "" if it is clean
+func (s *Scanner) Scan(ctx context.Context, content []byte) (string, error) {
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer conn.Close()
+	var deadline time.Time
+	if s.Timeout > 0 {
+		deadline = time.Now().Add(s.Timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("zINSTREAM\x00")
+	var size [4]byte
+	for start := 0; start < len(content); start += chunkSize {
+		end := start + chunkSize
+		if end > len(content) {
+			end = len(content)
+		}
+		binary.BigEndian.PutUint32(size[:], uint32(end-start))
+		w.Write(size[:])
+		w.Write(content[start:end])
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply reads "stream: OK", "stream: <name> FOUND" or "<message> ERROR"
+func parseReply(reply string) (string, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnavailable, reply)
+	}
+}
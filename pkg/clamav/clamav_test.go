@@ -0,0 +1,80 @@
+package clamav
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeClamd answers INSTREAM like clamd, flagging streams that contain EICAR
+func fakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				if cmd, _ := r.ReadString(0); cmd != "zINSTREAM\x00" {
+					io.WriteString(conn, "UNKNOWN COMMAND ERROR\x00")
+					return
+				}
+				var stream bytes.Buffer
+				for {
+					var size uint32
+					if binary.Read(r, binary.BigEndian, &size) != nil {
+						return
+					}
+					if size == 0 {
+						break
+					}
+					io.CopyN(&stream, r, int64(size))
+				}
+				if strings.Contains(stream.String(), "EICAR") {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+					return
+				}
+				io.WriteString(conn, "stream: OK\x00")
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestScan(t *testing.T) {
+	s := &Scanner{Addr: fakeClamd(t)}
+	ctx := context.Background()
+
+	if virus, err := s.Scan(ctx, bytes.Repeat([]byte("clean "), 30000)); err != nil || virus != "" {
+		t.Errorf("clean = %q, %v", virus, err)
+	}
+	infected := append(bytes.Repeat([]byte("x"), chunkSize), []byte("EICAR")...)
+	if virus, err := s.Scan(ctx, infected); err != nil || virus != "Eicar-Test-Signature" {
+		t.Errorf("infected = %q, %v", virus, err)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err := (&Scanner{Addr: addr}).Scan(context.Background(), []byte("x")); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := parseReply("INSTREAM size limit exceeded. ERROR"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("error reply: %v", err)
+	}
+}
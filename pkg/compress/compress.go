@@ -0,0 +1,312 @@
+// Package compress compresses large responses: an HTTP middleware that
+// encodes JSON and text responses with zstd or gzip as the client's
+// Accept-Encoding allows, and gzip for gRPC calls between services.
+//
+// HTTP compression is configured from the environment:
+//
+//	HTTP_COMPRESSION            encodings in order of preference, default
+//	                            "zstd,gzip"; "off" disables compression
+//	HTTP_COMPRESSION_MIN_BYTES  smaller responses are sent as they are,
+//	                            default 1024
+//
+// Responses are buffered up to the minimum size to decide, so streams
+// (text/event-stream) and other types are never held back. Compression
+// ratios are observed in zb_http_compression_ratio{service,encoding} and the
+// bytes before and after in zb_http_compression_bytes_total.
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Encodings
+const (
+	Zstd = "zstd"
+	Gzip = "gzip"
+)
+
+// DefaultMinSize is the smallest response compressed by default; below it
+// the headers outweigh the savings
+const DefaultMinSize = 1024
+
+var (
+	ratioHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zb_http_compression_ratio",
+		Help:    "Uncompressed divided by compressed size of compressed responses",
+		Buckets: []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16, 32},
+	}, []string{"service", "encoding"})
+	bytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zb_http_compression_bytes_total",
+		Help: "Bytes of compressed responses before (uncompressed) and after (compressed) compression",
+	}, []string{"service", "encoding", "size"})
+)
+
+// compressible are the content types worth compressing
+var compressible = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"application/jsonl":    true,
+	"application/xml":      true,
+	"text/plain":           true,
+	"text/csv":             true,
+	"text/html":            true,
+}
+
+// Config of the middleware
+type Config struct {
+	// Encodings offered, in order of preference; empty disables compression
+	Encodings []string
+	// MinSize is the smallest response compressed
+	MinSize int
+}
+
+// FromEnv reads HTTP_COMPRESSION and HTTP_COMPRESSION_MIN_BYTES
+func FromEnv() Config {
+	cfg := Config{Encodings: []string{Zstd, Gzip}, MinSize: DefaultMinSize}
+	if v := strings.TrimSpace(os.Getenv("HTTP_COMPRESSION")); v != "" {
+		cfg.Encodings = nil
+		if v != "off" {
+			for _, e := range strings.Split(v, ",") {
+				if e = strings.TrimSpace(e); e == Zstd || e == Gzip {
+					cfg.Encodings = append(cfg.Encodings, e)
+				}
+			}
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("HTTP_COMPRESSION_MIN_BYTES")); err == nil && n >= 0 {
+		cfg.MinSize = n
+	}
+	return cfg
+}
+
+// Middleware compresses the responses of next for service, configured with
+// FromEnv
+func Middleware(service string) func(http.Handler) http.Handler {
+	return middleware(service, FromEnv())
+}
+
+func middleware(service string, c Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(c.Encodings) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiate(r.Header.Get("Accept-Encoding"), c.Encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, service: service, encoding: encoding, minSize: c.MinSize, status: http.StatusOK}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate returns the first of offered the client accepts, "" for none
+func negotiate(acceptEncoding string, offered []string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q != "q=0" && q != "q=0.0"
+	}
+	for _, e := range offered {
+		if ok, listed := accepted[e]; ok || (!listed && accepted["*"]) {
+			return e
+		}
+	}
+	return ""
+}
+
+// compressWriter buffers the start of a response until it knows whether to
+// compress it: once minSize bytes were written, at a flush, or at the end
+type compressWriter struct {
+	http.ResponseWriter
+	service  string
+	encoding string
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	enc         io.WriteCloser
+	counter     *countingWriter
+	in          int
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	// Informational responses go out at once and say nothing of the body
+	if status < 200 {
+		w.wroteHeader = false
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if !w.worthBuffering() {
+			w.decide(false)
+		} else {
+			w.buf = append(w.buf, p...)
+			if len(w.buf) < w.minSize {
+				return len(p), nil
+			}
+			w.decide(true)
+			return len(p), nil
+		}
+	}
+	if w.enc != nil {
+		w.in += len(p)
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends what was written so far; a response flushed before it reached
+// the minimum size is a stream and is not compressed
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket upgrades through
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// worthBuffering reports whether the response may be compressed at all
+func (w *compressWriter) worthBuffering() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || w.status == http.StatusNoContent || w.status == http.StatusNotModified || w.status < 200 {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return compressible[mediaType] || (mediaType == "" && h.Get("Content-Type") == "")
+}
+
+// decide sends the headers, compressed if the buffered start is long enough
+// and its type compressible, and then the buffered start
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	h := w.Header()
+	if compress && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+		mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+		compress = compressible[mediaType]
+	}
+	if compress {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		w.counter = &countingWriter{w: w.ResponseWriter}
+		w.enc = newEncoder(w.encoding, w.counter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		buf := w.buf
+		w.buf = nil
+		if w.enc != nil {
+			w.in += len(buf)
+			w.enc.Write(buf)
+		} else {
+			w.ResponseWriter.Write(buf)
+		}
+	}
+}
+
+// close ends the response: a response that never reached the minimum size
+// is sent as it is
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader && len(w.buf) == 0 {
+			return
+		}
+		w.decide(false)
+	}
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	putEncoder(w.encoding, w.enc)
+	if w.counter.n > 0 {
+		ratioHistogram.WithLabelValues(w.service, w.encoding).Observe(float64(w.in) / float64(w.counter.n))
+	}
+	bytesTotal.WithLabelValues(w.service, w.encoding, "uncompressed").Add(float64(w.in))
+	bytesTotal.WithLabelValues(w.service, w.encoding, "compressed").Add(float64(w.counter.n))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+var (
+	gzipPool sync.Pool
+	zstdPool sync.Pool
+)
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == Zstd {
+		if enc, ok := zstdPool.Get().(*zstd.Encoder); ok {
+			enc.Reset(w)
+			return enc
+		}
+		// Concurrency 1: each response has its own encoder
+		enc, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return enc
+	}
+	if gz, ok := gzipPool.Get().(*gzip.Writer); ok {
+		gz.Reset(w)
+		return gz
+	}
+	gz, _ := gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	return gz
+}
+
+func putEncoder(encoding string, enc io.WriteCloser) {
+	if encoding == Zstd {
+		zstdPool.Put(enc)
+	} else {
+		gzipPool.Put(enc)
+	}
+}
@@ -0,0 +1,173 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+var largeJSON = `{"data":[` + strings.Repeat(`{"object":"usage","tokens":1234},`, 100) + `{}]}`
+
+func serve(t *testing.T, cfg Config, acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/v1/usage", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	middleware("test", cfg)(h).ServeHTTP(rec, req)
+	return rec
+}
+
+func jsonHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "123")
+		io.WriteString(w, body)
+	}
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case Gzip:
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case Zstd:
+		dec, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		r = dec
+	default:
+		return string(body)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompressesLargeJSON(t *testing.T) {
+	cfg := Config{Encodings: []string{Zstd, Gzip}, MinSize: DefaultMinSize}
+	for accept, want := range map[string]string{
+		"gzip, deflate, br": Gzip,
+		"zstd, gzip":        Zstd,
+		"gzip;q=1, zstd":    Zstd,
+		"zstd;q=0, gzip":    Gzip,
+		"*":                 Zstd,
+		"":                  "",
+		"br":                "",
+	} {
+		rec := serve(t, cfg, accept, jsonHandler(largeJSON))
+		if got := rec.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", accept, got, want)
+			continue
+		}
+		if want != "" && (rec.Header().Get("Content-Length") != "" || rec.Body.Len() >= len(largeJSON)) {
+			t.Errorf("Accept-Encoding %q: %d bytes, Content-Length %q", accept, rec.Body.Len(), rec.Header().Get("Content-Length"))
+		}
+		if got := decode(t, want, rec.Body.Bytes()); got != largeJSON {
+			t.Errorf("Accept-Encoding %q: body changed", accept)
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: Vary %q", accept, rec.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestLeavesResponsesAlone(t *testing.T) {
+	cfg := Config{Encodings: []string{Gzip}, MinSize: DefaultMinSize}
+	tests := map[string]http.HandlerFunc{
+		"small": jsonHandler(`{"ok":true}`),
+		"image": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, largeJSON)
+		},
+		"encoded": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, largeJSON)
+		},
+		"no content": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+		"stream": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: {}\n\n")
+			w.(http.Flusher).Flush()
+			io.WriteString(w, "data: "+largeJSON+"\n\n")
+		},
+	}
+	for name, h := range tests {
+		rec := serve(t, cfg, "gzip", h)
+		if enc := rec.Header().Get("Content-Encoding"); enc == Gzip {
+			t.Errorf("%s: compressed", name)
+		}
+	}
+	if rec := serve(t, cfg, "gzip", tests["stream"]); !rec.Flushed {
+		t.Error("stream not flushed")
+	}
+}
+
+func TestStatusKept(t *testing.T) {
+	rec := serve(t, Config{Encodings: []string{Gzip}, MinSize: 10}, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, largeJSON[:20])
+		io.WriteString(w, largeJSON[20:])
+	})
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Content-Encoding") != Gzip {
+		t.Fatalf("status %d, Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if decode(t, Gzip, rec.Body.Bytes()) != largeJSON {
+		t.Error("body changed")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := map[string][]string{
+		"":             {Zstd, Gzip},
+		"gzip":         {Gzip},
+		"gzip, zstd":   {Gzip, Zstd},
+		"off":          nil,
+		"brotli, gzip": {Gzip},
+	}
+	for v, want := range tests {
+		t.Setenv("HTTP_COMPRESSION", v)
+		if got := FromEnv().Encodings; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("HTTP_COMPRESSION=%q: %v, want %v", v, got, want)
+		}
+	}
+	t.Setenv("HTTP_COMPRESSION_MIN_BYTES", "256")
+	if got := FromEnv().MinSize; got != 256 {
+		t.Errorf("MinSize %d", got)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	if encoding.GetCompressor(Gzip) == nil {
+		t.Error("gzip compressor not registered")
+	}
+	t.Setenv("GRPC_COMPRESSION", "")
+	if GRPCEnabled() {
+		t.Error("enabled by default")
+	}
+	t.Setenv("GRPC_COMPRESSION", "gzip")
+	if !GRPCEnabled() {
+		t.Error("not enabled by GRPC_COMPRESSION=gzip")
+	}
+}
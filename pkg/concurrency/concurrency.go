@@ -0,0 +1,283 @@
+// Package concurrency limits how many requests a client may have in flight
+// at once, e.g. ten simultaneous streams per API key, across all replicas.
+//
+// It complements the per-minute limits of pkg/ratelimit: a client within its
+// RPM can still hold many long streams open. Each client has a semaphore in
+// Redis, a sorted set of leases scored by their expiry. A request acquires a
+// lease, renews it while it runs and releases it when done; leases of crashed
+// replicas expire after LeaseTTL. Lease times come from Redis (TIME), so all
+// replicas share one clock. When Redis is unavailable requests are let
+// through.
+//
+// Clients are identified the way the rate limiter identifies them: by the
+// client ID the rate-limiter service resolved the credential to (the user of
+// a JWT, the API key), or by the credential when it was limited locally. The
+// rate limit middleware must therefore run first.
+package concurrency
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/ratelimit"
+)
+
+// ErrorCode is the code of the 429 sent when a client is over its limit
+const ErrorCode = "concurrency_limit_exceeded"
+
+// DefaultLeaseTTL is how long a lease lives without renewal
+const DefaultLeaseTTL = 30 * time.Second
+
+// keyPrefix is the prefix of the semaphore keys
+const keyPrefix = "concurrency:"
+
+// DefaultLimits are the simultaneous requests per client by auth type
+var DefaultLimits = map[string]int{
+	ratelimit.AuthAPIKey:    10,
+	ratelimit.AuthJWT:       10,
+	ratelimit.AuthAnonymous: 2,
+}
+
+var decisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "concurrency_limit_decisions_total",
+	Help: "Concurrency limit decisions by service and result (allowed, limited, unavailable)",
+}, []string{"service", "result"})
+
+// acquireScript drops expired leases and adds one if the client is under its
+// limit. KEYS[1] is the semaphore; ARGV: lease TTL (ms), lease ID, limit, key
+// TTL (ms). Returns {acquired, leases held}.
+var acquireScript = redis.NewScript(`
+-- Needed on Redis < 5 to write after TIME
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+local held = redis.call('ZCARD', KEYS[1])
+if held >= tonumber(ARGV[3]) then
+	return {0, held}
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[1]), ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {1, held + 1}
+`)
+
+// renewScript extends a lease that still exists; ARGV: lease TTL (ms), lease
+// ID, key TTL (ms)
+var renewScript = redis.NewScript(`
+redis.replicate_commands()
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	local t = redis.call('TIME')
+	local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+	redis.call('ZADD', KEYS[1], now + tonumber(ARGV[1]), ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// Limiter hands out leases per client
+type Limiter struct {
+	service  string
+	rdb      redis.UniversalClient
+	limits   map[string]int
+	leaseTTL time.Duration
+}
+
+// New creates a limiter for a service with limits by auth type; a limit of 0
+// or a missing auth type is not limited
+func New(service string, rdb redis.UniversalClient, limits map[string]int, leaseTTL time.Duration) *Limiter {
+	if leaseTTL <= 0 {
+		leaseTTL = DefaultLeaseTTL
+	}
+	return &Limiter{service: service, rdb: rdb, limits: limits, leaseTTL: leaseTTL}
+}
+
+// LimitsFromEnv reads CONCURRENCY_LIMITS, e.g. "api_key=10,jwt=20,anonymous=2",
+// on top of DefaultLimits
+func LimitsFromEnv() (map[string]int, error) {
+	limits := make(map[string]int, len(DefaultLimits))
+	for authType, limit := range DefaultLimits {
+		limits[authType] = limit
+	}
+	raw := os.Getenv("CONCURRENCY_LIMITS")
+	if raw == "" {
+		return limits, nil
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		authType, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid CONCURRENCY_LIMITS entry %q, want auth_type=limit", entry)
+		}
+		limits[strings.TrimSpace(authType)] = limit
+	}
+	return limits, nil
+}
+
+// NewFromEnv creates a limiter with LimitsFromEnv and CONCURRENCY_LEASE_TTL
+func NewFromEnv(service string, rdb redis.UniversalClient) (*Limiter, error) {
+	limits, err := LimitsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	ttl := DefaultLeaseTTL
+	if raw := os.Getenv("CONCURRENCY_LEASE_TTL"); raw != "" {
+		if ttl, err = time.ParseDuration(raw); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid CONCURRENCY_LEASE_TTL %q", raw)
+		}
+	}
+	return New(service, rdb, limits, ttl), nil
+}
+
+// clientKey is the semaphore of a client: the client ID resolved by the
+// rate-limiter service, so all tokens of a user share one limit, or else the
+// credential, as the local rate limiter keys it. Anonymous clients share one
+// semaphore.
+func clientKey(clientID, authorization, authType string) string {
+	if authType == ratelimit.AuthAnonymous {
+		return keyPrefix + authType
+	}
+	id := authType + ":" + authorization
+	if clientID != "" {
+		id = clientID
+	}
+	sum := sha256.Sum256([]byte(id))
+	return keyPrefix + hex.EncodeToString(sum[:8])
+}
+
+// Lease is a slot held by a request; release it when the request is done.
+// A nil Lease is valid and does nothing.
+type Lease struct {
+	limiter *Limiter
+	key, id string
+	stop    chan struct{}
+}
+
+// Decision is the outcome of Acquire
+type Decision struct {
+	Allowed bool
+	// Limit is 0 when the client is not limited
+	Limit int
+	// Held is the number of the client's requests in flight, this one included
+	Held  int
+	Lease *Lease
+}
+
+// SetHeaders sets X-Concurrency-Limit and X-Concurrency-Remaining and, for
+// rejected requests, Retry-After
+func (d Decision) SetHeaders(h http.Header) {
+	if d.Limit == 0 {
+		return
+	}
+	remaining := d.Limit - d.Held
+	if remaining < 0 {
+		remaining = 0
+	}
+	h.Set("X-Concurrency-Limit", strconv.Itoa(d.Limit))
+	h.Set("X-Concurrency-Remaining", strconv.Itoa(remaining))
+	if !d.Allowed {
+		h.Set("Retry-After", "1")
+	}
+}
+
+// Acquire takes a lease for the client of an Authorization header; ctx
+// carries the rate limit ticket with the resolved client ID, if any
+func (l *Limiter) Acquire(ctx context.Context, authorization string) Decision {
+	authType := ratelimit.AuthType(authorization)
+	limit := l.limits[authType]
+	if limit <= 0 {
+		return Decision{Allowed: true}
+	}
+
+	key := clientKey(ratelimit.TicketFromContext(ctx).ClientID(), authorization, authType)
+	id := newLeaseID()
+	res, err := acquireScript.Run(ctx, l.rdb, []string{key},
+		l.leaseTTL.Milliseconds(), id, limit, (2 * l.leaseTTL).Milliseconds()).Int64Slice()
+	if err != nil {
+		decisionsTotal.WithLabelValues(l.service, "unavailable").Inc()
+		log.Printf("Concurrency limiter unavailable, letting the request through: %v", err)
+		return Decision{Allowed: true}
+	}
+
+	d := Decision{Allowed: res[0] == 1, Limit: limit, Held: int(res[1])}
+	if !d.Allowed {
+		decisionsTotal.WithLabelValues(l.service, "limited").Inc()
+		return d
+	}
+	decisionsTotal.WithLabelValues(l.service, "allowed").Inc()
+	d.Lease = &Lease{limiter: l, key: key, id: id, stop: make(chan struct{})}
+	go d.Lease.renew()
+	return d
+}
+
+// renew extends the lease until it is released, so long streams keep it
+func (le *Lease) renew() {
+	ticker := time.NewTicker(le.limiter.leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-le.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			l := le.limiter
+			err := renewScript.Run(ctx, l.rdb, []string{le.key},
+				l.leaseTTL.Milliseconds(), le.id, (2 * l.leaseTTL).Milliseconds()).Err()
+			cancel()
+			if err != nil {
+				log.Printf("Failed to renew concurrency lease: %v", err)
+			}
+		}
+	}
+}
+
+// Release gives the slot back
+func (le *Lease) Release() {
+	if le == nil {
+		return
+	}
+	close(le.stop)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := le.limiter.rdb.ZRem(ctx, le.key, le.id).Err(); err != nil {
+		// The lease expires on its own
+		log.Printf("Failed to release concurrency lease: %v", err)
+	}
+}
+
+func newLeaseID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware holds a lease while next serves the request and rejects clients
+// over their limit with 429 and the code concurrency_limit_exceeded; rejected
+// requests are given back to the rate limiter
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := l.Acquire(r.Context(), r.Header.Get("Authorization"))
+		d.SetHeaders(w.Header())
+		if !d.Allowed {
+			ratelimit.TicketFromContext(r.Context()).Refund()
+			apierror.New(http.StatusTooManyRequests,
+				fmt.Sprintf("too many concurrent requests: at most %d at a time", d.Limit)).WithCode(ErrorCode).Write(w)
+			return
+		}
+		defer d.Lease.Release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,148 @@
+package concurrency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/ratelimit"
+)
+
+func newLimiter(t *testing.T) (*Limiter, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return New("tail", rdb, map[string]int{ratelimit.AuthAPIKey: 2}, time.Minute), mr
+}
+
+func TestAcquireRelease(t *testing.T) {
+	l, _ := newLimiter(t)
+	ctx := context.Background()
+
+	first := l.Acquire(ctx, "tvo_key1")
+	second := l.Acquire(ctx, "tvo_key1")
+	if !first.Allowed || !second.Allowed || second.Held != 2 {
+		t.Fatalf("first = %+v, second = %+v", first, second)
+	}
+	if d := l.Acquire(ctx, "tvo_key1"); d.Allowed || d.Held != 2 {
+		t.Errorf("third = %+v, want limited", d)
+	}
+	// Other clients have their own semaphore
+	if d := l.Acquire(ctx, "tvo_key2"); !d.Allowed {
+		t.Errorf("other client limited: %+v", d)
+	}
+	// Unlimited auth types are not counted
+	if d := l.Acquire(ctx, "Bearer token"); !d.Allowed || d.Limit != 0 || d.Lease != nil {
+		t.Errorf("jwt = %+v", d)
+	}
+
+	first.Lease.Release()
+	if d := l.Acquire(ctx, "tvo_key1"); !d.Allowed {
+		t.Errorf("after release = %+v", d)
+	}
+}
+
+func TestExpiredLeases(t *testing.T) {
+	l, mr := newLimiter(t)
+	ctx := context.Background()
+	// Lease times come from Redis
+	now := time.Now()
+	mr.SetTime(now)
+
+	// Leases of a crashed replica are never released or renewed
+	for i := 0; i < 2; i++ {
+		d := l.Acquire(ctx, "tvo_key1")
+		close(d.Lease.stop)
+	}
+	if d := l.Acquire(ctx, "tvo_key1"); d.Allowed {
+		t.Fatal("limit not enforced")
+	}
+	mr.SetTime(now.Add(2 * time.Minute))
+	if d := l.Acquire(ctx, "tvo_key1"); !d.Allowed || d.Held != 1 {
+		t.Errorf("after expiry = %+v", d)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	// Tokens of a user resolved by the rate-limiter service share a semaphore
+	if clientKey("user:42", "Bearer first", ratelimit.AuthJWT) != clientKey("user:42", "Bearer second", ratelimit.AuthJWT) {
+		t.Error("tokens of one user have separate semaphores")
+	}
+	if clientKey("user:42", "Bearer first", ratelimit.AuthJWT) == clientKey("user:43", "Bearer first", ratelimit.AuthJWT) {
+		t.Error("users share a semaphore")
+	}
+	// Without a resolved client the credential identifies it
+	if clientKey("", "tvo_key1", ratelimit.AuthAPIKey) == clientKey("", "tvo_key2", ratelimit.AuthAPIKey) {
+		t.Error("API keys share a semaphore")
+	}
+	if clientKey("", "", ratelimit.AuthAnonymous) != clientKey("", "other", ratelimit.AuthAnonymous) {
+		t.Error("anonymous clients have separate semaphores")
+	}
+}
+
+func TestRedisDown(t *testing.T) {
+	l, mr := newLimiter(t)
+	mr.Close()
+	if d := l.Acquire(context.Background(), "tvo_key1"); !d.Allowed || d.Lease != nil {
+		t.Errorf("d = %+v, want allowed without a lease", d)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newLimiter(t)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "tvo_key1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() { serve(); done <- struct{}{} }()
+		<-started
+	}
+
+	rec := serve()
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), ErrorCode) {
+		t.Errorf("third stream: %d %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("X-Concurrency-Limit") != "2" || rec.Header().Get("X-Concurrency-Remaining") != "0" || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("headers = %v", rec.Header())
+	}
+
+	close(release)
+	<-done
+	<-done
+	go func() { <-started }()
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("after the streams ended: %d", rec.Code)
+	}
+}
+
+func TestLimitsFromEnv(t *testing.T) {
+	t.Setenv("CONCURRENCY_LIMITS", "api_key=25, anonymous=0")
+	limits, err := LimitsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits[ratelimit.AuthAPIKey] != 25 || limits[ratelimit.AuthAnonymous] != 0 || limits[ratelimit.AuthJWT] != DefaultLimits[ratelimit.AuthJWT] {
+		t.Errorf("limits = %v", limits)
+	}
+	t.Setenv("CONCURRENCY_LIMITS", "api_key")
+	if _, err := LimitsFromEnv(); err == nil {
+		t.Error("invalid entry accepted")
+	}
+}
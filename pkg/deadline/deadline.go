@@ -0,0 +1,217 @@
+// Package deadline gives each request one deadline, set where it enters the
+// system and carried along by its context: tail applies the timeout policy of
+// the route and model, gRPC sends the remaining time to head as grpc-timeout,
+// and head hands what is left to model-proxy the same way.
+//
+// The policy is part of the network config (timeouts), so every service uses
+// the same timeouts and picks up changes without a restart:
+//
+//	{"default_ms": 120000, "max_ms": 600000,
+//	 "routes": {"/v1/embeddings": 30000},
+//	 "models": {"o1": 600000}}
+//
+// A model timeout wins over a route timeout, which wins over the default, and
+// none may exceed max_ms. Clients can ask for less with X-Request-Timeout.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+)
+
+// Header lets a client shorten the deadline of its request, in seconds or as
+// a duration such as "30s"
+const Header = "X-Request-Timeout"
+
+// Policy is the timeouts of routes and models, in milliseconds
+type Policy struct {
+	DefaultMs int `json:"default_ms"`
+	MaxMs     int `json:"max_ms,omitempty"`
+	// Routes are path or gRPC method prefixes; the longest match applies.
+	// /v1 paths apply to every API version.
+	Routes map[string]int `json:"routes,omitempty"`
+	Models map[string]int `json:"models,omitempty"`
+}
+
+// DefaultPolicy is used while the network config has no timeouts
+func DefaultPolicy() Policy {
+	return Policy{
+		DefaultMs: 120000,
+		MaxMs:     600000,
+		Routes: map[string]int{
+			"/v1/embeddings":                    30000,
+			"/embedding.EmbeddingService/":      30000,
+			"/v1/batch":                         300000,
+			"/model.ModelService/BatchGenerate": 300000,
+		},
+	}
+}
+
+// OrDefault returns DefaultPolicy for a policy that was never configured
+func (p Policy) OrDefault() Policy {
+	if p.DefaultMs <= 0 {
+		return DefaultPolicy()
+	}
+	return p
+}
+
+// Validate checks that every timeout is positive and within max_ms
+func (p Policy) Validate() error {
+	if p.DefaultMs <= 0 {
+		return fmt.Errorf("default_ms must be positive")
+	}
+	if p.MaxMs != 0 && p.MaxMs < p.DefaultMs {
+		return fmt.Errorf("max_ms must not be below default_ms")
+	}
+	check := func(kind string, timeouts map[string]int) error {
+		for name, ms := range timeouts {
+			if ms <= 0 || (p.MaxMs != 0 && ms > p.MaxMs) {
+				return fmt.Errorf("%s %s: timeout must be between 1 and max_ms", kind, name)
+			}
+		}
+		return nil
+	}
+	if err := check("route", p.Routes); err != nil {
+		return err
+	}
+	return check("model", p.Models)
+}
+
+// For returns the timeout of a request to route, a path or gRPC full method,
+// for model, which may be empty
+func (p Policy) For(route, model string) time.Duration {
+	p = p.OrDefault()
+	ms := p.DefaultMs
+	if t, ok := p.Models[model]; ok && model != "" {
+		ms = t
+	} else {
+		longest := -1
+		for prefix, t := range p.Routes {
+			if len(prefix) > longest && strings.HasPrefix(route, prefix) {
+				ms, longest = t, len(prefix)
+			}
+		}
+	}
+	if p.MaxMs > 0 && ms > p.MaxMs {
+		ms = p.MaxMs
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Longest is the longest timeout the policy gives any request, e.g. to keep
+// other timeouts from ending calls that are still within their deadline
+func (p Policy) Longest() time.Duration {
+	p = p.OrDefault()
+	if p.MaxMs > 0 {
+		return time.Duration(p.MaxMs) * time.Millisecond
+	}
+	ms := p.DefaultMs
+	for _, timeouts := range []map[string]int{p.Routes, p.Models} {
+		for _, t := range timeouts {
+			ms = max(ms, t)
+		}
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Source returns the current policy, e.g. from the network config
+type Source func() Policy
+
+// errRouteDeadline is the cause of the route deadline, which ForModel may
+// replace with the model's
+var errRouteDeadline = errors.New("route deadline exceeded")
+
+// budget is what ForModel needs to recompute the deadline of a request
+type budget struct {
+	start  time.Time
+	route  string
+	policy Policy
+	// limit is the most the caller allows, 0 for no limit
+	limit time.Duration
+	// inherited deadlines were set by the caller and are kept as they are
+	inherited bool
+}
+
+type contextKey struct{}
+
+// Middleware sets the deadline of each request from the policy of its path
+// and X-Request-Timeout. Handlers that know the model call ForModel.
+func Middleware(source Source) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b := budget{start: time.Now(), route: apiversion.Canonical(r.URL.Path), policy: source(), limit: clientLimit(r.Header.Get(Header))}
+			ctx, cancel := context.WithDeadlineCause(r.Context(), b.deadline(""), errRouteDeadline)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, contextKey{}, b)))
+		})
+	}
+}
+
+// clientLimit parses X-Request-Timeout; invalid values are ignored
+func clientLimit(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (b budget) deadline(model string) time.Time {
+	timeout := b.policy.For(b.route, model)
+	if b.limit > 0 && b.limit < timeout {
+		timeout = b.limit
+	}
+	return b.start.Add(timeout)
+}
+
+// ForModel returns ctx with the deadline of its request recomputed for model,
+// which may be later than the route's. Client cancellation still applies.
+// Without a deadline set by Middleware or the interceptors ctx is returned as
+// is.
+func ForModel(ctx context.Context, model string) (context.Context, context.CancelFunc) {
+	b, ok := ctx.Value(contextKey{}).(budget)
+	if !ok || b.inherited {
+		return ctx, func() {}
+	}
+	deadline := b.deadline(model)
+	if current, ok := ctx.Deadline(); ok && !deadline.After(current) {
+		return context.WithDeadline(ctx, deadline)
+	}
+
+	// A longer deadline: detach from the route deadline but not from the
+	// client going away
+	extended, cancel := context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	stop := context.AfterFunc(ctx, func() {
+		if context.Cause(ctx) != errRouteDeadline {
+			cancel()
+		}
+	})
+	return extended, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Exceeded reports whether the request of ctx ran out of time, as opposed to
+// being cancelled by the client
+func Exceeded(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// Error is the 504 returned to clients whose request ran out of time
+func Error() *apierror.Error {
+	return apierror.New(http.StatusGatewayTimeout, "request timed out").WithCode("timeout")
+}
@@ -0,0 +1,165 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+var testPolicy = Policy{
+	DefaultMs: 1000,
+	MaxMs:     5000,
+	Routes:    map[string]int{"/v1/": 2000, "/v1/embeddings": 500},
+	Models:    map[string]int{"slow": 4000, "huge": 9000},
+}
+
+func TestFor(t *testing.T) {
+	tests := []struct {
+		route, model string
+		want         time.Duration
+	}{
+		{"/health", "", time.Second},
+		{"/v1/chat/completions", "", 2 * time.Second},
+		{"/v1/embeddings", "", 500 * time.Millisecond},
+		{"/v1/embeddings", "slow", 4 * time.Second},
+		{"/v1/chat/completions", "huge", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := testPolicy.For(tt.route, tt.model); got != tt.want {
+			t.Errorf("For(%s, %s) = %s, want %s", tt.route, tt.model, got, tt.want)
+		}
+	}
+	if got := (Policy{}).For("/v1/chat/completions", ""); got != 120*time.Second {
+		t.Errorf("unconfigured policy: %s", got)
+	}
+}
+
+func TestLongest(t *testing.T) {
+	if got := testPolicy.Longest(); got != 5*time.Second {
+		t.Errorf("with max_ms: %s", got)
+	}
+	unbounded := Policy{DefaultMs: 1000, Routes: map[string]int{"/v1/": 3000}, Models: map[string]int{"m": 2000}}
+	if got := unbounded.Longest(); got != 3*time.Second {
+		t.Errorf("without max_ms: %s", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultPolicy().Validate(); err != nil {
+		t.Errorf("default policy: %v", err)
+	}
+	for _, p := range []Policy{
+		{},
+		{DefaultMs: 1000, MaxMs: 500},
+		{DefaultMs: 1000, Routes: map[string]int{"/v1/": 0}},
+		{DefaultMs: 1000, MaxMs: 2000, Models: map[string]int{"m": 3000}},
+	} {
+		if p.Validate() == nil {
+			t.Errorf("%+v: no error", p)
+		}
+	}
+}
+
+func serve(r *http.Request, handler func(r *http.Request)) {
+	Middleware(func() Policy { return testPolicy })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func remaining(ctx context.Context) time.Duration {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return 0
+	}
+	return time.Until(d)
+}
+
+func near(got, want time.Duration) bool {
+	return got > want-100*time.Millisecond && got <= want
+}
+
+func TestMiddlewareAndForModel(t *testing.T) {
+	serve(httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil), func(r *http.Request) {
+		if got := remaining(r.Context()); !near(got, 2*time.Second) {
+			t.Errorf("route deadline in %s", got)
+		}
+		ctx, cancel := ForModel(r.Context(), "slow")
+		defer cancel()
+		if got := remaining(ctx); !near(got, 4*time.Second) {
+			t.Errorf("model deadline in %s", got)
+		}
+		if ctx.Value(contextKey{}) == nil {
+			t.Error("extended context lost the values of the request")
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set(Header, "0.5")
+	serve(req, func(r *http.Request) {
+		ctx, cancel := ForModel(r.Context(), "slow")
+		defer cancel()
+		if got := remaining(ctx); !near(got, 500*time.Millisecond) {
+			t.Errorf("deadline asked by the client in %s", got)
+		}
+	})
+}
+
+func TestForModelFollowsClientCancellation(t *testing.T) {
+	client, disconnect := context.WithCancel(context.Background())
+	serve(httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil).WithContext(client), func(r *http.Request) {
+		ctx, cancel := ForModel(r.Context(), "slow")
+		defer cancel()
+		disconnect()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("extended context outlived the client")
+		}
+		if Exceeded(ctx) {
+			t.Error("a disconnect is not a timeout")
+		}
+	})
+}
+
+func TestForModelOutlivesRouteDeadline(t *testing.T) {
+	policy := Policy{DefaultMs: 20, Models: map[string]int{"slow": 1000}}
+	Middleware(func() Policy { return policy })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := ForModel(r.Context(), "slow")
+		defer cancel()
+		<-r.Context().Done()
+		time.Sleep(10 * time.Millisecond)
+		if ctx.Err() != nil {
+			t.Errorf("model context ended with the route deadline: %v", ctx.Err())
+		}
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+type chatRequest struct{ model string }
+
+func (r chatRequest) GetModel() string { return r.model }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(func() Policy { return testPolicy })
+	info := &grpc.UnaryServerInfo{FullMethod: "/chat.ChatService/ChatCompletion"}
+
+	var got time.Duration
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got = remaining(ctx)
+		return nil, nil
+	}
+	interceptor(context.Background(), chatRequest{"slow"}, info, handler)
+	if !near(got, 4*time.Second) {
+		t.Errorf("deadline without one from the caller in %s", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	interceptor(ctx, chatRequest{"slow"}, info, handler)
+	if !near(got, 300*time.Millisecond) {
+		t.Errorf("deadline of the caller in %s", got)
+	}
+}
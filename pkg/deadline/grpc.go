@@ -0,0 +1,52 @@
+package deadline
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryServerInterceptor keeps the deadline sent by the caller and gives
+// calls without one the policy's for their method and the model of the
+// request
+func UnaryServerInterceptor(source Source) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var model string
+		if m, ok := req.(interface{ GetModel() string }); ok {
+			model = m.GetModel()
+		}
+		ctx, cancel := serverContext(ctx, source, info.FullMethod, model)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams; the request
+// is not read yet, so handlers apply the model with ForModel
+func StreamServerInterceptor(source Source) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel := serverContext(ss.Context(), source, info.FullMethod, "")
+		defer cancel()
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func serverContext(ctx context.Context, source Source, method, model string) (context.Context, context.CancelFunc) {
+	b := budget{start: time.Now(), route: method, policy: source()}
+	if _, ok := ctx.Deadline(); ok {
+		b.inherited = true
+		return context.WithValue(ctx, contextKey{}, b), func() {}
+	}
+	ctx, cancel := context.WithDeadlineCause(ctx, b.deadline(model), errRouteDeadline)
+	return context.WithValue(ctx, contextKey{}, b), cancel
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,176 @@
+// Package diagnostics serves pprof, expvar and runtime information for
+// production debugging:
+//
+//	/debug/pprof/   profiles, see net/http/pprof
+//	/debug/vars     expvar
+//	/debug/runtime  goroutines, memory and GC stats, build info as JSON
+//
+// The endpoints are off unless DEBUG_ENDPOINTS=true and always sit behind the
+// admin auth of the service. Importing net/http/pprof and expvar also
+// registers them on http.DefaultServeMux, so services must serve their own
+// mux.
+package diagnostics
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// Prefix is the path the handler is mounted at
+const Prefix = "/debug/"
+
+// AdminKeyHeader carries the admin key for services without user roles, as
+// secret-service's admin API does
+const AdminKeyHeader = "X-Admin-Key"
+
+var started = time.Now()
+
+// Enabled reports whether DEBUG_ENDPOINTS turns the endpoints on
+func Enabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("DEBUG_ENDPOINTS"))
+	return enabled
+}
+
+// Handler serves everything under Prefix behind auth. It answers 404 when
+// the endpoints are disabled.
+func Handler(auth func(http.Handler) http.Handler) http.Handler {
+	if !Enabled() {
+		return http.NotFoundHandler()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(Prefix+"pprof/", pprof.Index)
+	mux.HandleFunc(Prefix+"pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc(Prefix+"pprof/profile", pprof.Profile)
+	mux.HandleFunc(Prefix+"pprof/symbol", pprof.Symbol)
+	mux.HandleFunc(Prefix+"pprof/trace", pprof.Trace)
+	mux.Handle(Prefix+"vars", expvar.Handler())
+	mux.HandleFunc(Prefix+"runtime", serveRuntime)
+	return auth(mux)
+}
+
+// AdminKey allows requests whose X-Admin-Key equals key. An empty key
+// rejects every request.
+func AdminKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get(AdminKeyHeader)
+			if key == "" || subtle.ConstantTimeCompare([]byte(got), []byte(key)) != 1 {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Runtime is the body of /debug/runtime
+type Runtime struct {
+	GoVersion     string    `json:"go_version"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds float64   `json:"uptime_seconds"`
+	Goroutines    int       `json:"goroutines"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	NumCPU        int       `json:"num_cpu"`
+	CgoCalls      int64     `json:"cgo_calls"`
+	Memory        Memory    `json:"memory"`
+	GC            GC        `json:"gc"`
+	Build         *Build    `json:"build,omitempty"`
+}
+
+type Memory struct {
+	HeapAllocBytes   uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes   uint64 `json:"heap_inuse_bytes"`
+	HeapObjects      uint64 `json:"heap_objects"`
+	StackInuseBytes  uint64 `json:"stack_inuse_bytes"`
+	SysBytes         uint64 `json:"sys_bytes"`
+	TotalAllocBytes  uint64 `json:"total_alloc_bytes"`
+	NextGCBytes      uint64 `json:"next_gc_bytes"`
+	MemoryLimitBytes int64  `json:"memory_limit_bytes"`
+}
+
+type GC struct {
+	NumGC        uint32     `json:"num_gc"`
+	NumForcedGC  uint32     `json:"num_forced_gc"`
+	PauseTotalMs float64    `json:"pause_total_ms"`
+	LastPauseMs  float64    `json:"last_pause_ms"`
+	LastGC       *time.Time `json:"last_gc,omitempty"`
+	CPUFraction  float64    `json:"cpu_fraction"`
+	GOGC         string     `json:"gogc,omitempty"`
+}
+
+// Build describes the binary, with the VCS revision when it was built from a
+// checkout
+type Build struct {
+	Path     string `json:"path"`
+	Version  string `json:"version"`
+	Revision string `json:"vcs_revision,omitempty"`
+	Time     string `json:"vcs_time,omitempty"`
+	Modified bool   `json:"vcs_modified,omitempty"`
+}
+
+// ReadRuntime collects the current runtime state
+func ReadRuntime() Runtime {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	rt := Runtime{
+		GoVersion:     runtime.Version(),
+		StartedAt:     started,
+		UptimeSeconds: time.Since(started).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		CgoCalls:      runtime.NumCgoCall(),
+		Memory: Memory{
+			HeapAllocBytes:   ms.HeapAlloc,
+			HeapInuseBytes:   ms.HeapInuse,
+			HeapObjects:      ms.HeapObjects,
+			StackInuseBytes:  ms.StackInuse,
+			SysBytes:         ms.Sys,
+			TotalAllocBytes:  ms.TotalAlloc,
+			NextGCBytes:      ms.NextGC,
+			MemoryLimitBytes: debug.SetMemoryLimit(-1), // a negative limit only reads it
+		},
+		GC: GC{
+			NumGC:        ms.NumGC,
+			NumForcedGC:  ms.NumForcedGC,
+			PauseTotalMs: float64(ms.PauseTotalNs) / 1e6,
+			CPUFraction:  ms.GCCPUFraction,
+			GOGC:         os.Getenv("GOGC"),
+		},
+	}
+	if ms.NumGC > 0 {
+		rt.GC.LastPauseMs = float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e6
+		last := time.Unix(0, int64(ms.LastGC))
+		rt.GC.LastGC = &last
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		rt.Build = &Build{Path: info.Main.Path, Version: info.Main.Version}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				rt.Build.Revision = s.Value
+			case "vcs.time":
+				rt.Build.Time = s.Value
+			case "vcs.modified":
+				rt.Build.Modified = s.Value == "true"
+			}
+		}
+	}
+	return rt
+}
+
+func serveRuntime(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(ReadRuntime())
+}
@@ -0,0 +1,66 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerDisabled(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "")
+	h := Handler(AdminKey("secret"))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/runtime", nil)
+	req.Header.Set(AdminKeyHeader, "secret")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandlerAdminKey(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	h := Handler(AdminKey("secret"))
+
+	for _, key := range []string{"", "wrong"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/debug/runtime", nil)
+		req.Header.Set(AdminKeyHeader, key)
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("key %q: status = %d, want 403", key, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/debug/runtime", nil)
+	req.Header.Set(AdminKeyHeader, "secret")
+	h.ServeHTTP(rec, req)
+	var rt Runtime
+	if err := json.NewDecoder(rec.Body).Decode(&rt); err != nil {
+		t.Fatal(err)
+	}
+	if rt.Goroutines == 0 || rt.GoVersion == "" || rt.Memory.SysBytes == 0 {
+		t.Errorf("runtime = %+v", rt)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	req.Header.Set(AdminKeyHeader, "secret")
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"memstats"`) {
+		t.Error("expvar output has no memstats")
+	}
+}
+
+func TestAdminKeyEmpty(t *testing.T) {
+	h := AdminKey("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,49 @@
+package diagnostics
+
+import (
+	"os"
+	"strconv"
+
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+// gRPC servers can expose two debugging services for grpcurl, grpcui and
+// channelz tooling, each off unless its variable is true:
+//
+//	GRPC_REFLECTION  grpc.reflection, the services and messages of the server
+//	GRPC_CHANNELZ    grpc.channelz, connections, streams and call counts
+//
+// They are meant for staging: reflection publishes the API schema and
+// channelz the peers of the server. They are served on the server's own port
+// and behind its mTLS and interceptors, so callers still need a client
+// certificate and whatever credentials the server requires.
+
+// ReflectionEnabled reports whether GRPC_REFLECTION turns reflection on
+func ReflectionEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("GRPC_REFLECTION"))
+	return enabled
+}
+
+// ChannelzEnabled reports whether GRPC_CHANNELZ turns channelz on
+func ChannelzEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("GRPC_CHANNELZ"))
+	return enabled
+}
+
+// RegisterGRPC registers the enabled debugging services on s. It must be
+// called after the server's own services, so reflection lists them, and
+// before Serve. It returns the names of the registered services.
+func RegisterGRPC(s *grpc.Server) []string {
+	var registered []string
+	if ReflectionEnabled() {
+		reflection.Register(s)
+		registered = append(registered, "reflection")
+	}
+	if ChannelzEnabled() {
+		channelz.RegisterChannelzServiceToServer(s)
+		registered = append(registered, "channelz")
+	}
+	return registered
+}
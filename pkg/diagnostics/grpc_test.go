@@ -0,0 +1,38 @@
+package diagnostics
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestRegisterGRPC(t *testing.T) {
+	tests := []struct {
+		reflection, channelz string
+		want                 []string
+		services             []string
+	}{
+		{"", "", nil, nil},
+		{"true", "", []string{"reflection"}, []string{"grpc.reflection.v1alpha.ServerReflection"}},
+		{"", "1", []string{"channelz"}, []string{"grpc.channelz.v1.Channelz"}},
+	}
+	for _, tt := range tests {
+		t.Setenv("GRPC_REFLECTION", tt.reflection)
+		t.Setenv("GRPC_CHANNELZ", tt.channelz)
+		s := grpc.NewServer()
+
+		if got := RegisterGRPC(s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("reflection %q, channelz %q: registered %v, want %v", tt.reflection, tt.channelz, got, tt.want)
+		}
+		info := s.GetServiceInfo()
+		if tt.services == nil && len(info) > 0 {
+			t.Errorf("serves %d services with both disabled", len(info))
+		}
+		for _, name := range tt.services {
+			if _, ok := info[name]; !ok {
+				t.Errorf("reflection %q, channelz %q: %s not served", tt.reflection, tt.channelz, name)
+			}
+		}
+	}
+}
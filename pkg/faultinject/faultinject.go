@@ -0,0 +1,231 @@
+// Package faultinject injects latency, errors and dropped streams into
+// requests, so circuit breakers, retries and failover can be tested against
+// realistic failures.
+//
+// Injection needs two keys: the process must be started with
+// FAULT_INJECTION_ENABLED=true, which production deployments never set, and
+// the fault_injection feature flag must be on for the service. Rules come from
+// FAULT_INJECTION_RULES, a JSON array:
+//
+//	[{"route": "/v1/chat/completions", "latency_probability": 0.2, "latency_ms": 1500},
+//	 {"route": "/model.ModelService/", "error_probability": 0.1, "status": 503},
+//	 {"route": "*", "drop_probability": 0.05, "drop_after": 3}]
+//
+// A route is a path or gRPC method prefix, or "*" for all. The first matching
+// rule applies; latency, error and drop are drawn independently.
+package faultinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// FlagName is the feature flag that turns injection on at runtime
+const FlagName = "fault_injection"
+
+// Kinds of fault, the fault label of zb_fault_injections_total
+const (
+	FaultLatency = "latency"
+	FaultError   = "error"
+	FaultDrop    = "drop"
+)
+
+var injectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zb_fault_injections_total",
+	Help: "Faults injected by service, layer, route and fault",
+}, []string{"service", "layer", "route", "fault"})
+
+// Rule is the faults injected into the requests of a route
+type Rule struct {
+	// Route is a path or gRPC full method prefix, "*" for all
+	Route string `json:"route"`
+
+	LatencyProbability float64 `json:"latency_probability,omitempty"`
+	LatencyMs          int     `json:"latency_ms,omitempty"`
+
+	ErrorProbability float64 `json:"error_probability,omitempty"`
+	// Status is the HTTP status of the error, mapped to a gRPC code for gRPC;
+	// 503 by default
+	Status int `json:"status,omitempty"`
+
+	// DropProbability drops a response stream after DropAfter writes or
+	// messages, 1 by default
+	DropProbability float64 `json:"drop_probability,omitempty"`
+	DropAfter       int     `json:"drop_after,omitempty"`
+}
+
+func (r Rule) matches(route string) bool {
+	return r.Route == "*" || strings.HasPrefix(route, r.Route)
+}
+
+// Validate checks probabilities and durations
+func (r Rule) Validate() error {
+	if r.Route == "" {
+		return fmt.Errorf("route is required")
+	}
+	for name, p := range map[string]float64{
+		"latency_probability": r.LatencyProbability,
+		"error_probability":   r.ErrorProbability,
+		"drop_probability":    r.DropProbability,
+	} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("%s of %s must be between 0 and 1", name, r.Route)
+		}
+	}
+	if r.LatencyMs < 0 || r.DropAfter < 0 {
+		return fmt.Errorf("latency_ms and drop_after of %s must not be negative", r.Route)
+	}
+	if r.Status != 0 && (r.Status < 400 || r.Status > 599) {
+		return fmt.Errorf("status of %s must be an error status", r.Route)
+	}
+	return nil
+}
+
+// Flags evaluates feature flags, e.g. *featureflags.Flags
+type Flags interface {
+	Enabled(name, tenant string) bool
+}
+
+// Faults are the faults drawn for a request
+type Faults struct {
+	Latency time.Duration
+	// Status is the error to answer with instead of the handler, 0 for none
+	Status int
+	// DropAfter is the number of writes or messages after which the response
+	// is dropped, 0 for none
+	DropAfter int
+}
+
+// None reports whether no fault was drawn
+func (f Faults) None() bool {
+	return f.Latency == 0 && f.Status == 0 && f.DropAfter == 0
+}
+
+// Injector draws faults for the requests of a service
+type Injector struct {
+	service string
+	armed   bool
+	flags   Flags
+	rules   atomic.Pointer[[]Rule]
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// New returns the injector of a service; it injects nothing unless armed, and
+// while armed only while the fault_injection flag is on
+func New(service string, armed bool, flags Flags, rules []Rule) *Injector {
+	in := &Injector{
+		service: service,
+		armed:   armed,
+		flags:   flags,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	in.SetRules(rules)
+	return in
+}
+
+// FromEnv returns the injector configured by FAULT_INJECTION_ENABLED and
+// FAULT_INJECTION_RULES. Invalid rules are logged and disarm it.
+func FromEnv(service string, flags Flags) *Injector {
+	armed, _ := strconv.ParseBool(os.Getenv("FAULT_INJECTION_ENABLED"))
+	var rules []Rule
+	if raw := os.Getenv("FAULT_INJECTION_RULES"); raw != "" && armed {
+		var err error
+		if rules, err = ParseRules([]byte(raw)); err != nil {
+			log.Printf("Fault injection disabled, invalid FAULT_INJECTION_RULES: %v", err)
+			armed = false
+		}
+	}
+	if armed {
+		log.Printf("Fault injection armed for %s with %d rules, active while the %s flag is on", service, len(rules), FlagName)
+	}
+	return New(service, armed, flags, rules)
+}
+
+// ParseRules decodes and validates a JSON array of rules
+func ParseRules(raw []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// SetRules replaces the rules
+func (in *Injector) SetRules(rules []Rule) {
+	rules = append([]Rule(nil), rules...)
+	in.rules.Store(&rules)
+}
+
+// Active reports whether faults are injected now
+func (in *Injector) Active() bool {
+	return in != nil && in.armed && in.flags != nil && in.flags.Enabled(FlagName, "")
+}
+
+// Draw picks the faults of a request to route at a layer, e.g. "http" or
+// "grpc-client", and counts them
+func (in *Injector) Draw(layer, route string) Faults {
+	if !in.Active() {
+		return Faults{}
+	}
+	var rule *Rule
+	for _, r := range *in.rules.Load() {
+		if r.matches(route) {
+			r := r
+			rule = &r
+			break
+		}
+	}
+	if rule == nil {
+		return Faults{}
+	}
+
+	in.mu.Lock()
+	latency, failure, drop := in.rand.Float64(), in.rand.Float64(), in.rand.Float64()
+	in.mu.Unlock()
+
+	var f Faults
+	if latency < rule.LatencyProbability && rule.LatencyMs > 0 {
+		f.Latency = time.Duration(rule.LatencyMs) * time.Millisecond
+		injectionsTotal.WithLabelValues(in.service, layer, rule.Route, FaultLatency).Inc()
+	}
+	if failure < rule.ErrorProbability {
+		f.Status = rule.Status
+		if f.Status == 0 {
+			f.Status = 503
+		}
+		injectionsTotal.WithLabelValues(in.service, layer, rule.Route, FaultError).Inc()
+	} else if drop < rule.DropProbability {
+		f.DropAfter = rule.DropAfter
+		if f.DropAfter == 0 {
+			f.DropAfter = 1
+		}
+		injectionsTotal.WithLabelValues(in.service, layer, rule.Route, FaultDrop).Inc()
+	}
+	return f
+}
+
+// FlagsFunc adapts a function to Flags
+type FlagsFunc func(name, tenant string) bool
+
+// Enabled calls f
+func (f FlagsFunc) Enabled(name, tenant string) bool {
+	return f(name, tenant)
+}
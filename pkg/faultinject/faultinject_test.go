@@ -0,0 +1,187 @@
+package faultinject
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type flags map[string]bool
+
+func (f flags) Enabled(name, tenant string) bool { return f[name] }
+
+var on = flags{FlagName: true}
+
+func TestDraw(t *testing.T) {
+	rules := []Rule{
+		{Route: "/v1/chat", LatencyProbability: 1, LatencyMs: 5, ErrorProbability: 1, Status: 429},
+		{Route: "*", DropProbability: 1},
+	}
+
+	tests := []struct {
+		name  string
+		in    *Injector
+		route string
+		want  Faults
+	}{
+		{"flag off", New("gateway", true, flags{}, rules), "/v1/chat", Faults{}},
+		{"not armed", New("gateway", false, on, rules), "/v1/chat", Faults{}},
+		{"nil injector", nil, "/v1/chat", Faults{}},
+		{"first rule", New("gateway", true, on, rules), "/v1/chat/completions", Faults{Latency: 5 * time.Millisecond, Status: 429}},
+		{"catch-all", New("gateway", true, on, rules), "/v1/embeddings", Faults{DropAfter: 1}},
+		{"no rule", New("gateway", true, on, rules[:1]), "/health", Faults{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.in.Draw("http", tt.route); got != tt.want {
+				t.Errorf("faults = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	if _, err := ParseRules([]byte(`[{"route":"*","error_probability":0.5,"status":503}]`)); err != nil {
+		t.Errorf("valid rules: %v", err)
+	}
+	for _, raw := range []string{
+		`[{"error_probability":0.5}]`,
+		`[{"route":"*","drop_probability":1.5}]`,
+		`[{"route":"*","status":200}]`,
+		`[{"route":"*","latency_ms":-1}]`,
+		`{"route":"*"}`,
+	} {
+		if _, err := ParseRules([]byte(raw)); err == nil {
+			t.Errorf("%s: no error", raw)
+		}
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	in := New("gateway", true, on, []Rule{{Route: "/v1/", ErrorProbability: 1}})
+	called := false
+	h := in.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if called || rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("called = %v, status = %d", called, rr.Code)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if body.Error.Code != "fault_injected" {
+		t.Errorf("body = %s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if !called {
+		t.Error("request outside the rule did not reach the handler")
+	}
+}
+
+func TestMiddlewareLatency(t *testing.T) {
+	in := New("gateway", true, on, []Rule{{Route: "*", LatencyProbability: 1, LatencyMs: 1000}})
+	h := in.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called after the client went away")
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("latency outlived the request")
+	}
+}
+
+func TestMiddlewareDrop(t *testing.T) {
+	in := New("gateway", true, on, []Rule{{Route: "*", DropProbability: 1, DropAfter: 2}})
+	srv := httptest.NewServer(in.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			w.Write([]byte("data: chunk\n\n"))
+			w.(http.Flusher).Flush()
+		}
+	})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 1024)
+	n, err := 0, error(nil)
+	for err == nil {
+		var m int
+		m, err = resp.Body.Read(buf[n:])
+		n += m
+	}
+	if got := string(buf[:n]); got != "data: chunk\n\ndata: chunk\n\n" {
+		t.Errorf("body = %q", got)
+	}
+	if err.Error() == "EOF" {
+		t.Error("stream ended cleanly, want a dropped connection")
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	in := New("head", true, on, []Rule{{Route: "/model.ModelService/", ErrorProbability: 1, Status: 504}})
+	invoked := false
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked = true
+		return nil
+	}
+
+	err := in.UnaryClientInterceptor()(context.Background(), "/model.ModelService/Generate", nil, nil, nil, invoker)
+	if status.Code(err) != codes.DeadlineExceeded || invoked {
+		t.Errorf("err = %v, invoked = %v", err, invoked)
+	}
+	if err := in.UnaryClientInterceptor()(context.Background(), "/chat.ChatService/Chat", nil, nil, nil, invoker); err != nil || !invoked {
+		t.Errorf("err = %v, invoked = %v", err, invoked)
+	}
+}
+
+type recvStream struct {
+	grpc.ClientStream
+	n int
+}
+
+func (s *recvStream) RecvMsg(m interface{}) error { s.n++; return nil }
+
+func TestStreamClientInterceptorDrop(t *testing.T) {
+	in := New("head", true, on, []Rule{{Route: "*", DropProbability: 1, DropAfter: 2}})
+	inner := &recvStream{}
+	var streamCtx context.Context
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return inner, nil
+	}
+
+	cs, err := in.StreamClientInterceptor()(context.Background(), &grpc.StreamDesc{}, nil, "/model.ModelService/GenerateStream", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := cs.RecvMsg(nil); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	if err := cs.RecvMsg(nil); status.Code(err) != codes.Unavailable {
+		t.Errorf("err = %v, want Unavailable", err)
+	}
+	if inner.n != 2 || streamCtx.Err() == nil {
+		t.Errorf("received = %d, call cancelled = %v", inner.n, streamCtx.Err() != nil)
+	}
+}
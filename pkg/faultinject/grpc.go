@@ -0,0 +1,165 @@
+package faultinject
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor injects faults into the unary methods of a server
+func (in *Injector) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := in.before(ctx, "grpc-server", info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor injects faults into the streaming methods of a
+// server; a dropped stream fails after DropAfter sent messages
+func (in *Injector) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		f := in.Draw("grpc-server", info.FullMethod)
+		if err := apply(ss.Context(), f); err != nil {
+			return err
+		}
+		if f.DropAfter > 0 {
+			ss = &droppingServerStream{ServerStream: ss, left: f.DropAfter}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// UnaryClientInterceptor injects faults into the unary calls of a client, as
+// if the server failed
+func (in *Injector) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := in.before(ctx, "grpc-client", method); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor injects faults into the streaming calls of a
+// client; a dropped stream fails after DropAfter received messages
+func (in *Injector) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		f := in.Draw("grpc-client", method)
+		if err := apply(ctx, f); err != nil {
+			return nil, err
+		}
+		if f.DropAfter == 0 {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		// Dropping cancels the call, as a broken connection would
+		ctx, cancel := context.WithCancel(ctx)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &droppingClientStream{ClientStream: cs, left: f.DropAfter, cancel: cancel}, nil
+	}
+}
+
+// DialOptions are the client interceptors as dial options
+func (in *Injector) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(in.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(in.StreamClientInterceptor()),
+	}
+}
+
+func (in *Injector) before(ctx context.Context, layer, method string) error {
+	f := in.Draw(layer, method)
+	if err := apply(ctx, f); err != nil {
+		return err
+	}
+	if f.DropAfter > 0 {
+		// A unary call has nothing to drop midway; it loses its response
+		return status.Error(codes.Unavailable, "fault injected: connection dropped")
+	}
+	return nil
+}
+
+// apply waits for the latency and returns the injected error
+func apply(ctx context.Context, f Faults) error {
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if f.Status != 0 {
+		return status.Error(Code(f.Status), "fault injected")
+	}
+	return nil
+}
+
+// Code maps an HTTP error status to the gRPC code with the same meaning
+func Code(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case http.StatusInternalServerError:
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+var errDropped = status.Error(codes.Unavailable, "fault injected: stream dropped")
+
+type droppingServerStream struct {
+	grpc.ServerStream
+	left int
+}
+
+func (s *droppingServerStream) SendMsg(m interface{}) error {
+	if s.left <= 0 {
+		return errDropped
+	}
+	s.left--
+	return s.ServerStream.SendMsg(m)
+}
+
+type droppingClientStream struct {
+	grpc.ClientStream
+	left   int
+	cancel context.CancelFunc
+}
+
+func (s *droppingClientStream) RecvMsg(m interface{}) error {
+	if s.left <= 0 {
+		s.cancel()
+		return errDropped
+	}
+	s.left--
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
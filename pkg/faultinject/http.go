@@ -0,0 +1,72 @@
+package faultinject
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// Middleware injects faults into the requests of an HTTP server, by path.
+// Errors are OpenAI-format with code fault_injected; a dropped response is
+// aborted after DropAfter writes, which closes the connection mid-stream.
+func (in *Injector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := in.Draw("http", r.URL.Path)
+		if f.None() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sleep(r, f.Latency) {
+			return
+		}
+		if f.Status != 0 {
+			apierror.New(f.Status, "fault injected").WithCode("fault_injected").Write(w)
+			return
+		}
+		if f.DropAfter > 0 {
+			w = &droppingWriter{ResponseWriter: w, left: f.DropAfter}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sleep waits for d unless the request is cancelled first
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// droppingWriter aborts the response after a number of writes
+type droppingWriter struct {
+	http.ResponseWriter
+	left int
+}
+
+func (w *droppingWriter) Write(b []byte) (int, error) {
+	if w.left <= 0 {
+		// net/http closes the connection without logging a panic
+		panic(http.ErrAbortHandler)
+	}
+	w.left--
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *droppingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *droppingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,194 @@
+package featureflags
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// AdminPrefix is the path the admin API is mounted at
+const AdminPrefix = "/admin/api/flags"
+
+// AdminHandler serves the admin API for the flags in store. Mount it at
+// AdminPrefix and its subpaths behind the admin auth of the service:
+//
+//	GET    /admin/api/flags[?service=&tenant=]
+//	GET    /admin/api/flags/{name}[?service=&tenant=]
+//	PUT    /admin/api/flags/{name}
+//	DELETE /admin/api/flags/{name}
+//	POST   /admin/api/flags/{name}/enable
+//	POST   /admin/api/flags/{name}/disable
+//	PUT    /admin/api/flags/{name}/services/{service}  {"enabled": true}
+//	DELETE /admin/api/flags/{name}/services/{service}
+//	PUT    /admin/api/flags/{name}/tenants/{tenant}    {"enabled": true}
+//	DELETE /admin/api/flags/{name}/tenants/{tenant}
+//
+// With service or tenant in the query, flags carry the value they evaluate to
+// there, so the admin UI can show what a tenant sees.
+func AdminHandler(store *Store) http.Handler {
+	return &adminHandler{store: store}
+}
+
+type adminHandler struct {
+	store *Store
+}
+
+// flagView is a flag with its value for the service and tenant of the query
+type flagView struct {
+	Flag
+	Value  *bool  `json:"value,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+type overrideRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, AdminPrefix), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.list(w, r)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	name := parts[0]
+	switch {
+	case len(parts) == 1:
+		h.flag(w, r, name)
+	case len(parts) == 2 && (parts[1] == "enable" || parts[1] == "disable") && r.Method == http.MethodPost:
+		enabled := parts[1] == "enable"
+		h.update(w, r, name, func(flag *Flag) { flag.Enabled = enabled })
+	case len(parts) == 3 && (parts[1] == "services" || parts[1] == "tenants") && parts[2] != "":
+		h.override(w, r, name, parts[1], parts[2])
+	default:
+		apierror.Write(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	flags, err := h.store.List(r.Context())
+	if err != nil {
+		apierror.Write(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	views := make([]flagView, 0, len(flags))
+	for _, flag := range flags {
+		views = append(views, view(r, flag))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"flags": views})
+}
+
+func (h *adminHandler) flag(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		flag, err := h.store.Get(r.Context(), name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, view(r, flag))
+
+	case http.MethodPut:
+		var flag Flag
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&flag); err != nil {
+			apierror.Write(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		flag.Name = name
+		flag, err := h.store.Put(r.Context(), flag)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, flag)
+
+	case http.MethodDelete:
+		if err := h.store.Delete(r.Context(), name); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// override sets or removes the override of one service or tenant
+func (h *adminHandler) override(w http.ResponseWriter, r *http.Request, name, level, key string) {
+	var set *bool
+	switch r.Method {
+	case http.MethodPut:
+		var req overrideRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.Enabled == nil {
+			apierror.Write(w, http.StatusBadRequest, `body must be {"enabled": true|false}`)
+			return
+		}
+		set = req.Enabled
+	case http.MethodDelete:
+	default:
+		apierror.Write(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	h.update(w, r, name, func(flag *Flag) {
+		overrides := &flag.Services
+		if level == "tenants" {
+			overrides = &flag.Tenants
+		}
+		if set == nil {
+			delete(*overrides, key)
+			return
+		}
+		if *overrides == nil {
+			*overrides = make(map[string]bool)
+		}
+		(*overrides)[key] = *set
+	})
+}
+
+func (h *adminHandler) update(w http.ResponseWriter, r *http.Request, name string, fn func(*Flag)) {
+	flag, err := h.store.Update(r.Context(), name, fn)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, flag)
+}
+
+func view(r *http.Request, flag Flag) flagView {
+	v := flagView{Flag: flag}
+	service, tenant := r.URL.Query().Get("service"), r.URL.Query().Get("tenant")
+	if service != "" || tenant != "" {
+		value, source := flag.Evaluate(service, tenant)
+		v.Value, v.Source = &value, source
+	}
+	return v
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		apierror.Write(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalid):
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrConflict):
+		apierror.Write(w, http.StatusConflict, err.Error())
+	default:
+		apierror.Write(w, http.StatusServiceUnavailable, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
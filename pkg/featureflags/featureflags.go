@@ -0,0 +1,378 @@
+// Package featureflags keeps feature flags in Redis so they can be changed at
+// runtime and per service or tenant.
+//
+// A flag is on or off by default; a service override replaces the default in
+// that service and a tenant override replaces both for that tenant. Flags are
+// stored as JSON in one Redis hash and every change is announced on a pub/sub
+// channel, so all replicas of all services reload at once; a periodic reload
+// covers missed messages. Flags serves the current values from memory and
+// counts evaluations in zb_feature_flag_evaluations_total.
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Redis keys: flags are stored as JSON in a hash keyed by flag name, and
+// every change is announced on a pub/sub channel
+const (
+	RedisKey     = "feature_flags"
+	RedisChannel = "feature_flags:changed"
+)
+
+// Where the value of an evaluation came from, the source label of
+// zb_feature_flag_evaluations_total
+const (
+	SourceTenant  = "tenant"
+	SourceService = "service"
+	SourceDefault = "default"
+	SourceUnknown = "unknown"
+)
+
+var (
+	// ErrNotFound is returned for flags that are not stored
+	ErrNotFound = errors.New("feature flag not found")
+	// ErrInvalid wraps validation failures
+	ErrInvalid = errors.New("invalid feature flag")
+	// ErrConflict is returned when an update keeps losing to concurrent ones
+	ErrConflict = errors.New("feature flag changed concurrently, try again")
+)
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+var (
+	evaluationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zb_feature_flag_evaluations_total",
+		Help: "Feature flag evaluations by service, flag, result and the level that decided it",
+	}, []string{"service", "flag", "result", "source"})
+
+	reloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zb_feature_flag_reloads_total",
+		Help: "Feature flag reloads from Redis by service and status",
+	}, []string{"service", "status"})
+)
+
+// Flag is a feature flag with its overrides
+type Flag struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Enabled     bool            `json:"enabled"`
+	Services    map[string]bool `json:"services,omitempty"`
+	Tenants     map[string]bool `json:"tenants,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Evaluate returns the value of the flag for a tenant of a service and the
+// level that decided it. An empty tenant skips the tenant overrides.
+func (f Flag) Evaluate(service, tenant string) (bool, string) {
+	if tenant != "" {
+		if enabled, ok := f.Tenants[tenant]; ok {
+			return enabled, SourceTenant
+		}
+	}
+	if enabled, ok := f.Services[service]; ok {
+		return enabled, SourceService
+	}
+	return f.Enabled, SourceDefault
+}
+
+// Validate checks the flag name and the override keys
+func (f Flag) Validate() error {
+	if !validName.MatchString(f.Name) {
+		return fmt.Errorf("%w: name %q must be up to 64 lowercase letters, digits, '_', '.' or '-'", ErrInvalid, f.Name)
+	}
+	for service := range f.Services {
+		if service == "" {
+			return fmt.Errorf("%w: service override without a service name", ErrInvalid)
+		}
+	}
+	for tenant := range f.Tenants {
+		if tenant == "" {
+			return fmt.Errorf("%w: tenant override without a tenant ID", ErrInvalid)
+		}
+	}
+	return nil
+}
+
+// Store reads and changes the flags in Redis
+type Store struct {
+	rdb redis.UniversalClient
+}
+
+// NewStore creates a store on the given Redis client
+func NewStore(rdb redis.UniversalClient) *Store {
+	return &Store{rdb: rdb}
+}
+
+// List returns every stored flag sorted by name
+func (s *Store) List(ctx context.Context) ([]Flag, error) {
+	flags, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Flag, 0, len(flags))
+	for _, flag := range flags {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+func (s *Store) load(ctx context.Context) (map[string]Flag, error) {
+	entries, err := s.rdb.HGetAll(ctx, RedisKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	flags := make(map[string]Flag, len(entries))
+	for name, raw := range entries {
+		var flag Flag
+		if err := json.Unmarshal([]byte(raw), &flag); err != nil {
+			log.Printf("Skipping invalid feature flag %s: %v", name, err)
+			continue
+		}
+		flag.Name = name
+		flags[name] = flag
+	}
+	return flags, nil
+}
+
+// Get returns a stored flag
+func (s *Store) Get(ctx context.Context, name string) (Flag, error) {
+	raw, err := s.rdb.HGet(ctx, RedisKey, name).Result()
+	if err == redis.Nil {
+		return Flag{}, ErrNotFound
+	}
+	if err != nil {
+		return Flag{}, err
+	}
+	var flag Flag
+	if err := json.Unmarshal([]byte(raw), &flag); err != nil {
+		return Flag{}, fmt.Errorf("invalid feature flag %s: %w", name, err)
+	}
+	flag.Name = name
+	return flag, nil
+}
+
+// Put validates and stores a flag, replacing its overrides, then notifies
+// all services
+func (s *Store) Put(ctx context.Context, flag Flag) (Flag, error) {
+	if err := flag.Validate(); err != nil {
+		return Flag{}, err
+	}
+	flag.UpdatedAt = time.Now().UTC()
+	raw, err := json.Marshal(flag)
+	if err != nil {
+		return Flag{}, err
+	}
+	if err := s.rdb.HSet(ctx, RedisKey, flag.Name, raw).Err(); err != nil {
+		return Flag{}, err
+	}
+	return flag, s.publish(ctx)
+}
+
+// Update changes a stored flag with fn. Concurrent updates of the same flag
+// are retried, so toggling overrides from several admin sessions loses none.
+func (s *Store) Update(ctx context.Context, name string, fn func(*Flag)) (Flag, error) {
+	var updated Flag
+	txf := func(tx *redis.Tx) error {
+		raw, err := tx.HGet(ctx, RedisKey, name).Result()
+		if err == redis.Nil {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var flag Flag
+		if err := json.Unmarshal([]byte(raw), &flag); err != nil {
+			return fmt.Errorf("invalid feature flag %s: %w", name, err)
+		}
+		flag.Name = name
+		fn(&flag)
+		if err := flag.Validate(); err != nil {
+			return err
+		}
+		flag.UpdatedAt = time.Now().UTC()
+		data, err := json.Marshal(flag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, RedisKey, name, data)
+			return nil
+		})
+		updated = flag
+		return err
+	}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		err := s.rdb.Watch(ctx, txf, RedisKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return Flag{}, err
+		}
+		return updated, s.publish(ctx)
+	}
+	return Flag{}, fmt.Errorf("%s: %w", name, ErrConflict)
+}
+
+// Delete removes a stored flag, then notifies all services. Services fall
+// back to their built-in default for it.
+func (s *Store) Delete(ctx context.Context, name string) error {
+	n, err := s.rdb.HDel(ctx, RedisKey, name).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return s.publish(ctx)
+}
+
+// Seed stores the flags that are not stored yet, so the built-in defaults of
+// a service become the initial dynamic configuration. Replicas starting
+// together seed the same flags.
+func (s *Store) Seed(ctx context.Context, defaults []Flag) error {
+	seeded := false
+	for _, flag := range defaults {
+		if err := flag.Validate(); err != nil {
+			return err
+		}
+		flag.UpdatedAt = time.Now().UTC()
+		raw, err := json.Marshal(flag)
+		if err != nil {
+			return err
+		}
+		ok, err := s.rdb.HSetNX(ctx, RedisKey, flag.Name, raw).Result()
+		if err != nil {
+			return err
+		}
+		seeded = seeded || ok
+	}
+	if !seeded {
+		return nil
+	}
+	return s.publish(ctx)
+}
+
+func (s *Store) publish(ctx context.Context) error {
+	return s.rdb.Publish(ctx, RedisChannel, time.Now().Unix()).Err()
+}
+
+// Flags evaluates flags for one service from an in-memory copy of the store
+type Flags struct {
+	service  string
+	store    *Store
+	defaults map[string]Flag
+
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// New creates the flags of a service. Defaults are used until the first
+// Load and for flags that are not stored; a nil store keeps them for good.
+func New(service string, store *Store, defaults ...Flag) *Flags {
+	f := &Flags{
+		service:  service,
+		store:    store,
+		defaults: make(map[string]Flag, len(defaults)),
+	}
+	for _, flag := range defaults {
+		f.defaults[flag.Name] = flag
+	}
+	f.flags = f.defaults
+	return f
+}
+
+// Enabled reports whether a flag is on for a tenant; an empty tenant
+// evaluates the service level. Unknown flags are off.
+func (f *Flags) Enabled(name, tenant string) bool {
+	f.mu.RLock()
+	flag, ok := f.flags[name]
+	f.mu.RUnlock()
+
+	enabled, source := false, SourceUnknown
+	if ok {
+		enabled, source = flag.Evaluate(f.service, tenant)
+	}
+	evaluationsTotal.WithLabelValues(f.service, name, strconv.FormatBool(enabled), source).Inc()
+	return enabled
+}
+
+// All returns the current flags, including defaults that are not stored
+func (f *Flags) All() map[string]Flag {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	flags := make(map[string]Flag, len(f.flags))
+	for name, flag := range f.flags {
+		flags[name] = flag
+	}
+	return flags
+}
+
+// Load replaces the flags with the stored ones
+func (f *Flags) Load(ctx context.Context) error {
+	if f.store == nil {
+		return nil
+	}
+	stored, err := f.store.load(ctx)
+	if err != nil {
+		reloadsTotal.WithLabelValues(f.service, "error").Inc()
+		return err
+	}
+	for name, flag := range f.defaults {
+		if _, ok := stored[name]; !ok {
+			stored[name] = flag
+		}
+	}
+
+	f.mu.Lock()
+	f.flags = stored
+	f.mu.Unlock()
+	reloadsTotal.WithLabelValues(f.service, "ok").Inc()
+	return nil
+}
+
+// Watch reloads the flags on change notifications and, as a fallback for
+// missed messages, every interval until ctx is cancelled
+func (f *Flags) Watch(ctx context.Context, interval time.Duration) {
+	if f.store == nil {
+		return
+	}
+	sub := f.store.rdb.Subscribe(ctx, RedisChannel)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+			case <-ticker.C:
+			}
+
+			if err := f.Load(ctx); err != nil {
+				log.Printf("Failed to reload feature flags: %v", err)
+			}
+		}
+	}()
+}
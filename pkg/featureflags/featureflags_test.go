@@ -0,0 +1,65 @@
+package featureflags
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	flag := Flag{
+		Name:     "hedging",
+		Enabled:  true,
+		Services: map[string]bool{"head": false},
+		Tenants:  map[string]bool{"acme": true, "globex": false},
+	}
+	tests := []struct {
+		service, tenant string
+		want            bool
+		source          string
+	}{
+		{"gateway", "", true, SourceDefault},
+		{"head", "", false, SourceService},
+		{"head", "acme", true, SourceTenant},
+		{"gateway", "globex", false, SourceTenant},
+		{"head", "initech", false, SourceService},
+	}
+	for _, tt := range tests {
+		got, source := flag.Evaluate(tt.service, tt.tenant)
+		if got != tt.want || source != tt.source {
+			t.Errorf("Evaluate(%q, %q) = %v, %s; want %v, %s", tt.service, tt.tenant, got, source, tt.want, tt.source)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []Flag{{Name: "streaming"}, {Name: "model_registry.v2"}}
+	for _, flag := range valid {
+		if err := flag.Validate(); err != nil {
+			t.Errorf("%q: %v", flag.Name, err)
+		}
+	}
+	invalid := []Flag{
+		{Name: ""},
+		{Name: "Streaming"},
+		{Name: "a/b"},
+		{Name: "ok", Tenants: map[string]bool{"": true}},
+	}
+	for _, flag := range invalid {
+		if err := flag.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: err = %v, want ErrInvalid", flag, err)
+		}
+	}
+}
+
+func TestFlagsWithoutStore(t *testing.T) {
+	flags := New("head", nil, Flag{Name: "streaming", Enabled: true}, Flag{Name: "webhook"})
+	if !flags.Enabled("streaming", "acme") {
+		t.Error("streaming disabled, want the default")
+	}
+	if flags.Enabled("webhook", "") || flags.Enabled("unknown", "") {
+		t.Error("disabled and unknown flags evaluate to true")
+	}
+	if len(flags.All()) != 2 {
+		t.Errorf("All() = %v", flags.All())
+	}
+}
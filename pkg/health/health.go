@@ -0,0 +1,228 @@
+// Package health serves the same liveness and readiness endpoints in every
+// service.
+//
+// /livez only says that the process serves HTTP; orchestrators restart a
+// service when it fails. /readyz runs the checks of the service's
+// dependencies, Redis, Postgres, Vault, NATS and upstream gRPC services, in
+// parallel and answers 503 when one of them fails, so traffic is held back
+// without a restart. Once Drain is called on shutdown, /readyz answers 503
+// with status "draining" while in-flight requests finish. Both answer JSON:
+//
+//	{"status": "fail", "service": "tail", "checks": {
+//	  "redis": {"status": "ok", "latency_ms": 0.4},
+//	  "secret-service": {"status": "fail", "latency_ms": 2000, "error": "connection transient_failure"}}}
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// DefaultTimeout bounds each check of a /readyz request
+const DefaultTimeout = 2 * time.Second
+
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// Check returns an error when the dependency cannot be used
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check
+type Result struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the body of /livez and /readyz
+type Report struct {
+	Status  string            `json:"status"`
+	Service string            `json:"service"`
+	Checks  map[string]Result `json:"checks,omitempty"`
+}
+
+// Checker holds the readiness checks of a service
+type Checker struct {
+	Service string
+	Timeout time.Duration // per check, default DefaultTimeout
+
+	mu       sync.RWMutex
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+func New(service string) *Checker {
+	return &Checker{Service: service, Timeout: DefaultTimeout, checks: make(map[string]Check)}
+}
+
+// Add registers a readiness check under name, replacing one with that name
+func (c *Checker) Add(name string, check Check) *Checker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+	return c
+}
+
+// Drain makes /readyz fail from now on so load balancers stop sending new
+// requests while the service shuts down
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Resume makes /readyz run its checks again after Drain, when a service
+// leaves maintenance instead of shutting down
+func (c *Checker) Resume() {
+	c.draining.Store(false)
+}
+
+// Draining reports whether Drain was called since the last Resume
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Run runs every check in parallel
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.RUnlock()
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	report := Report{Status: StatusOK, Service: c.Service, Checks: make(map[string]Result, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			result := run(ctx, check, timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+func run(ctx context.Context, check Check, timeout time.Duration) (result Result) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		// A panicking check fails instead of taking the endpoint down
+		if p := recover(); p != nil {
+			result.Status, result.Error = StatusFail, fmt.Sprint("panic: ", p)
+		}
+		result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	if err := check(ctx); err != nil {
+		return Result{Status: StatusFail, Error: err.Error()}
+	}
+	return Result{Status: StatusOK}
+}
+
+// LiveHandler serves /livez
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, Report{Status: StatusOK, Service: c.Service})
+	})
+}
+
+// ReadyHandler serves /readyz
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Draining() {
+			writeReport(w, Report{Status: StatusDraining, Service: c.Service})
+			return
+		}
+		writeReport(w, c.Run(r.Context()))
+	})
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// SQL checks a database with a ping, e.g. Postgres through gorm's DB()
+func SQL(db *sql.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// GRPC checks that a client connection is ready or gets ready before the
+// deadline. It does not call the upstream, which may not implement the gRPC
+// health service.
+func GRPC(conn *grpc.ClientConn) Check {
+	return func(ctx context.Context) error {
+		for {
+			state := conn.GetState()
+			switch state {
+			case connectivity.Ready:
+				return nil
+			case connectivity.Idle:
+				conn.Connect()
+			case connectivity.Shutdown:
+				return fmt.Errorf("connection %s", stateName(state))
+			}
+			if !conn.WaitForStateChange(ctx, state) {
+				return fmt.Errorf("connection %s", stateName(state))
+			}
+		}
+	}
+}
+
+func stateName(state connectivity.State) string {
+	return strings.ToLower(state.String())
+}
+
+// HTTP checks that GET url answers with a status below 500, e.g. Vault's
+// /v1/sys/health?standbyok=true
+func HTTP(client *http.Client, url string) Check {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	c := New("test")
+	c.Timeout = 50 * time.Millisecond
+	c.Add("redis", func(ctx context.Context) error { return nil })
+	c.Add("vault", func(ctx context.Context) error { return errors.New("sealed") })
+	c.Add("nats", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	var report Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != StatusFail || report.Service != "test" {
+		t.Fatalf("report = %+v", report)
+	}
+	if r := report.Checks["redis"]; r.Status != StatusOK {
+		t.Errorf("redis = %+v", r)
+	}
+	if r := report.Checks["vault"]; r.Status != StatusFail || r.Error != "sealed" {
+		t.Errorf("vault = %+v", r)
+	}
+	if r := report.Checks["nats"]; r.Status != StatusFail || r.LatencyMs < 50 {
+		t.Errorf("nats = %+v", r)
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+	c := New("test").Add("vault", func(ctx context.Context) error { return errors.New("sealed") })
+
+	rec := httptest.NewRecorder()
+	c.LiveHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestReadyHandlerDraining(t *testing.T) {
+	c := New("test").Add("redis", func(ctx context.Context) error { return nil })
+	c.Drain()
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	var report Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != StatusDraining {
+		t.Errorf("status = %q, want %q", report.Status, StatusDraining)
+	}
+}
+
+func TestReadyHandlerResumed(t *testing.T) {
+	c := New("test").Add("redis", func(ctx context.Context) error { return nil })
+	c.Drain()
+	c.Resume()
+
+	rec := httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
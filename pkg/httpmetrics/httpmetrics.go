@@ -0,0 +1,42 @@
+// Package httpmetrics has helpers for recording HTTP responses in metrics and
+// audit logs, and the RED metrics shared by all services.
+package httpmetrics
+
+import "net/http"
+
+// StatusRecorder wraps a ResponseWriter and keeps the status the handler
+// wrote. Status is 200 until the handler writes another one, as net/http does.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status      int
+	wroteHeader bool
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *StatusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController the underlying writer
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,40 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	for name, tc := range map[string]struct {
+		handler http.HandlerFunc
+		want    int
+	}{
+		"implicit": {func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, http.StatusOK},
+		"explicit": {func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) }, http.StatusTeapot},
+		"first wins": {func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusBadGateway},
+		"after body": {func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK},
+	} {
+		rec := NewStatusRecorder(httptest.NewRecorder())
+		tc.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Status != tc.want {
+			t.Errorf("%s: status %d, want %d", name, rec.Status, tc.want)
+		}
+	}
+}
+
+func TestStatusRecorderFlush(t *testing.T) {
+	inner := httptest.NewRecorder()
+	var w http.ResponseWriter = NewStatusRecorder(inner)
+	w.(http.Flusher).Flush()
+	if !inner.Flushed {
+		t.Error("Flush not passed through")
+	}
+}
@@ -0,0 +1,28 @@
+// Package muxroute labels request metrics of gorilla/mux routers with route
+// templates. It is separate from httpmetrics so that services on
+// net/http.ServeMux do not depend on gorilla/mux.
+package muxroute
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+)
+
+// Template returns the path template of the route matching a request, e.g.
+// /v1/circuit-breakers/{name}
+func Template(router *mux.Router) httpmetrics.Route {
+	return func(r *http.Request) string {
+		var match mux.RouteMatch
+		if !router.Match(r, &match) || match.Route == nil {
+			return ""
+		}
+		template, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return ""
+		}
+		return template
+	}
+}
@@ -0,0 +1,97 @@
+package httpmetrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Unmatched is the route label of requests no route matched
+const Unmatched = "unmatched"
+
+// Request rate, errors and duration with the same names and labels in every
+// service. route is a template such as /v1/batches/{id}, never the raw path,
+// so IDs in paths do not create a series per request.
+var (
+	RequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zb_http_requests_total",
+		Help: "HTTP requests by service, method, route template and status",
+	}, []string{"service", "method", "route", "status"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zb_request_duration_seconds",
+		Help:    "HTTP request duration by service, method and route template, with trace ID exemplars",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "method", "route"})
+)
+
+// Route returns the route template of a request, or "" if none matched
+type Route func(r *http.Request) string
+
+// ServeMuxRoute labels requests with the pattern that mux routes them to,
+// without the method of Go 1.22 patterns such as "GET /v1/batches/{id}"
+func ServeMuxRoute(mux *http.ServeMux) Route {
+	return func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		if i := strings.IndexByte(pattern, ' '); i >= 0 {
+			pattern = strings.TrimLeft(pattern[i+1:], " \t")
+		}
+		return pattern
+	}
+}
+
+// Middleware records zb_http_requests_total and zb_request_duration_seconds.
+// It goes inside tracing.Middleware so that durations get the trace ID of the
+// request as exemplar.
+func Middleware(service string, route Route) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := NewStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			template := route(r)
+			if template == "" {
+				template = Unmatched
+			}
+			method := methodLabel(r.Method)
+			RequestsTotal.WithLabelValues(service, method, template, strconv.Itoa(rec.Status)).Inc()
+			Observe(r.Context(), RequestDuration.WithLabelValues(service, method, template), time.Since(start).Seconds())
+		})
+	}
+}
+
+// methodLabel keeps clients from adding series with made-up methods
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// Observe records value and, when ctx carries a sampled span, its trace ID
+// as exemplar so that dashboards link latency buckets to traces
+func Observe(ctx context.Context, o prometheus.Observer, value float64) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": sc.TraceID().String()})
+			return
+		}
+	}
+	o.Observe(value)
+}
+
+// Handler serves /metrics. Exemplars are only exposed in the OpenMetrics
+// format, which Prometheus negotiates when exemplar storage is enabled.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
@@ -0,0 +1,54 @@
+package httpmetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddlewareRouteTemplate(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/batches/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	h := Middleware("test-route", ServeMuxRoute(mux))(mux)
+
+	for _, path := range []string{"/v1/batches/a", "/v1/batches/b", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/v1/batches/a", nil))
+
+	if got := testutil.ToFloat64(RequestsTotal.WithLabelValues("test-route", "GET", "/v1/batches/{id}", "202")); got != 2 {
+		t.Errorf("templated requests = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(RequestsTotal.WithLabelValues("test-route", "GET", Unmatched, "404")); got != 1 {
+		t.Errorf("unmatched requests = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(RequestsTotal.WithLabelValues("test-route", "OTHER", Unmatched, "405")); got != 1 {
+		t.Errorf("other method requests = %v, want 1", got)
+	}
+}
+
+func TestMiddlewareExemplar(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/traced", func(w http.ResponseWriter, r *http.Request) {})
+	h := Middleware("test-exemplar", ServeMuxRoute(mux))(mux)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	req := httptest.NewRequest(http.MethodGet, "/traced", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req.WithContext(trace.ContextWithSpanContext(req.Context(), sc)))
+
+	rec := httptest.NewRecorder()
+	metrics := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	metrics.Header.Set("Accept", "application/openmetrics-text; version=0.0.1")
+	Handler().ServeHTTP(rec, metrics)
+	if !strings.Contains(rec.Body.String(), `trace_id="4bf92f3577b34da6a3ce929d0e0e4736"`) {
+		t.Error("no trace_id exemplar in OpenMetrics output")
+	}
+}
@@ -0,0 +1,329 @@
+// Package ipfilter restricts endpoints to client IPs by allow and deny lists
+// of addresses and CIDR ranges.
+//
+// A denied IP is always blocked; with a non-empty allow list only the IPs on
+// it get through. Each protected surface has a scope, e.g. routing-admin,
+// whose rules start out from the environment and can be replaced at runtime
+// by publishing them to Redis as JSON under "ipfilter:<scope>"; every change
+// is announced on a pub/sub channel, so all replicas apply it at once.
+package ipfilter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// RedisKeyPrefix and RedisChannel: the rules of a scope are stored as JSON
+// under RedisKeyPrefix+scope, and the scope is published on RedisChannel when
+// they change
+const (
+	RedisKeyPrefix = "ipfilter:"
+	RedisChannel   = "ipfilter:changed"
+)
+
+// Reasons a request is blocked
+const (
+	ReasonDenied     = "denied"
+	ReasonNotAllowed = "not_allowed"
+	ReasonInvalidIP  = "invalid_ip"
+)
+
+// ErrInvalid wraps invalid addresses and ranges
+var ErrInvalid = errors.New("invalid IP filter rules")
+
+var blockedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "zb_ip_filter_blocked_total",
+	Help: "Requests blocked by the IP filter by scope and reason",
+}, []string{"scope", "reason"})
+
+// Rules are the lists of a scope. Entries are addresses or CIDR ranges, IPv4
+// or IPv6. TrustedProxies are the proxies whose X-Forwarded-For is used as
+// the client IP; without them the connection's address is.
+type Rules struct {
+	Allow          []string  `json:"allow,omitempty"`
+	Deny           []string  `json:"deny,omitempty"`
+	TrustedProxies []string  `json:"trusted_proxies,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at,omitempty"`
+}
+
+// compiled are parsed rules
+type compiled struct {
+	allow, deny, proxies []*net.IPNet
+}
+
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q is not an IP address or CIDR range", ErrInvalid, entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not an IP address or CIDR range", ErrInvalid, entry)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// Validate checks that every entry is an address or a CIDR range
+func (r Rules) Validate() error {
+	_, err := r.compile()
+	return err
+}
+
+func (r Rules) compile() (compiled, error) {
+	var c compiled
+	var err error
+	if c.allow, err = parseNets(r.Allow); err != nil {
+		return c, err
+	}
+	if c.deny, err = parseNets(r.Deny); err != nil {
+		return c, err
+	}
+	if c.proxies, err = parseNets(r.TrustedProxies); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the reason ip is blocked, or "" if it is let through
+func (c compiled) check(ip net.IP) string {
+	switch {
+	case ip == nil:
+		return ReasonInvalidIP
+	case contains(c.deny, ip):
+		return ReasonDenied
+	case len(c.allow) > 0 && !contains(c.allow, ip):
+		return ReasonNotAllowed
+	}
+	return ""
+}
+
+// clientIP is the connection's address or, behind a trusted proxy, the
+// rightmost X-Forwarded-For address that is not a trusted proxy
+func (c compiled) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(c.proxies, ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+		if !contains(c.proxies, hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// FromEnv reads rules from <prefix>_ALLOW, <prefix>_DENY and
+// <prefix>_TRUSTED_PROXIES, each a comma-separated list
+func FromEnv(prefix string) Rules {
+	list := func(name string) []string {
+		var entries []string
+		for _, entry := range strings.Split(os.Getenv(prefix+"_"+name), ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+	return Rules{Allow: list("ALLOW"), Deny: list("DENY"), TrustedProxies: list("TRUSTED_PROXIES")}
+}
+
+// Publish replaces the rules of a scope in Redis and notifies all services
+func Publish(ctx context.Context, rdb redis.UniversalClient, scope string, rules Rules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	if rules.UpdatedAt.IsZero() {
+		rules.UpdatedAt = time.Now().UTC()
+	}
+	raw, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	if err := rdb.Set(ctx, RedisKeyPrefix+scope, raw, 0).Err(); err != nil {
+		return fmt.Errorf("failed to publish IP filter rules: %w", err)
+	}
+	return rdb.Publish(ctx, RedisChannel, scope).Err()
+}
+
+// Blocked describes a blocked request for audit logging
+type Blocked struct {
+	Scope  string
+	IP     string
+	Reason string
+}
+
+// Filter checks client IPs against the rules of one scope
+type Filter struct {
+	scope    string
+	rdb      redis.UniversalClient
+	defaults Rules
+
+	mu    sync.RWMutex
+	rules Rules
+	c     compiled
+}
+
+// New creates a filter with the given rules, e.g. FromEnv. A nil rdb keeps
+// them for good; otherwise Load and Watch replace them with the published
+// ones, and deleting the published rules brings them back.
+func New(scope string, rdb redis.UniversalClient, defaults Rules) (*Filter, error) {
+	c, err := defaults.compile()
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{scope: scope, rdb: rdb, defaults: defaults, rules: defaults, c: c}, nil
+}
+
+// Rules returns the rules in effect
+func (f *Filter) Rules() Rules {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rules
+}
+
+// Set replaces the rules
+func (f *Filter) Set(rules Rules) error {
+	c, err := rules.compile()
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.rules, f.c = rules, c
+	f.mu.Unlock()
+	return nil
+}
+
+// Check returns the client IP of a request and the reason it is blocked, ""
+// if it is let through
+func (f *Filter) Check(r *http.Request) (string, string) {
+	f.mu.RLock()
+	c := f.c
+	f.mu.RUnlock()
+	ip := c.clientIP(r)
+	reason := c.check(ip)
+	if ip == nil {
+		return r.RemoteAddr, reason
+	}
+	return ip.String(), reason
+}
+
+// Load replaces the rules with the published ones; without published rules
+// the defaults apply. Invalid published rules keep the current ones.
+func (f *Filter) Load(ctx context.Context) error {
+	if f.rdb == nil {
+		return nil
+	}
+	raw, err := f.rdb.Get(ctx, RedisKeyPrefix+f.scope).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return f.Set(f.defaults)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load IP filter rules: %w", err)
+	}
+	var rules Rules
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return f.Set(rules)
+}
+
+// Watch reloads the rules when they are published for the scope and, as a
+// fallback for missed messages, every interval until ctx is cancelled
+func (f *Filter) Watch(ctx context.Context, interval time.Duration) {
+	if f.rdb == nil {
+		return
+	}
+	sub := f.rdb.Subscribe(ctx, RedisChannel)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-messages:
+				if msg == nil || msg.Payload != f.scope {
+					continue
+				}
+			case <-ticker.C:
+			}
+
+			if err := f.Load(ctx); err != nil {
+				log.Printf("Failed to reload IP filter rules of %s: %v", f.scope, err)
+			}
+		}
+	}()
+}
+
+// Middleware blocks requests from IPs the rules do not let through with 403
+// and calls audit, if set, for each of them
+func (f *Filter) Middleware(audit func(r *http.Request, b Blocked)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, reason := f.Check(r)
+			if reason == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			blockedTotal.WithLabelValues(f.scope, reason).Inc()
+			if audit != nil {
+				audit(r, Blocked{Scope: f.scope, IP: ip, Reason: reason})
+			}
+			apierror.New(http.StatusForbidden, "forbidden: your IP address may not use this endpoint").WithCode("ip_" + reason).Write(w)
+		})
+	}
+}
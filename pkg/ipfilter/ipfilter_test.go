@@ -0,0 +1,139 @@
+package ipfilter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func request(remoteAddr, forwardedFor string) *http.Request {
+	r := httptest.NewRequest(http.MethodPut, "/api/routing/policy", nil)
+	r.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	return r
+}
+
+func TestCheck(t *testing.T) {
+	f, err := New("routing-admin", nil, Rules{
+		Allow:          []string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.7"},
+		Deny:           []string{"10.6.6.0/24"},
+		TrustedProxies: []string{"172.16.0.0/12"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		remoteAddr, forwardedFor string
+		ip, reason               string
+	}{
+		{"10.1.2.3:5000", "", "10.1.2.3", ""},
+		{"192.0.2.7:5000", "", "192.0.2.7", ""},
+		{"192.0.2.8:5000", "", "192.0.2.8", ReasonNotAllowed},
+		{"10.6.6.6:5000", "", "10.6.6.6", ReasonDenied},
+		{"[2001:db8::1]:5000", "", "2001:db8::1", ""},
+		{"[2001:db9::1]:5000", "", "2001:db9::1", ReasonNotAllowed},
+		// X-Forwarded-For only counts behind a trusted proxy
+		{"203.0.113.9:5000", "10.1.2.3", "203.0.113.9", ReasonNotAllowed},
+		{"172.16.0.5:5000", "203.0.113.9, 10.1.2.3", "10.1.2.3", ""},
+		{"172.16.0.5:5000", "10.1.2.3, 10.6.6.6, 172.16.0.9", "10.6.6.6", ReasonDenied},
+		{"172.16.0.5:5000", "not-an-ip", "172.16.0.5", ReasonNotAllowed},
+		{"garbage", "", "garbage", ReasonInvalidIP},
+	}
+	for _, tt := range tests {
+		ip, reason := f.Check(request(tt.remoteAddr, tt.forwardedFor))
+		if ip != tt.ip || reason != tt.reason {
+			t.Errorf("%s (%s) = %s, %q; want %s, %q", tt.remoteAddr, tt.forwardedFor, ip, reason, tt.ip, tt.reason)
+		}
+	}
+
+	open, _ := New("open", nil, Rules{})
+	if _, reason := open.Check(request("198.51.100.1:1", "")); reason != "" {
+		t.Errorf("empty rules blocked: %s", reason)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, rules := range []Rules{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"example.com"}},
+		{TrustedProxies: []string{"10.0.0"}},
+	} {
+		if err := rules.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: err = %v", rules, err)
+		}
+	}
+}
+
+func TestPublishLoad(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	f, err := New("secrets-admin", rdb, Rules{Allow: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, reason := f.Check(request("10.0.0.1:1", "")); reason != "" {
+		t.Fatalf("defaults lost before the first publish: %s", reason)
+	}
+
+	if err := Publish(ctx, rdb, "secrets-admin", Rules{Deny: []string{"10.0.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Publish(ctx, rdb, "other", Rules{Allow: []string{"127.0.0.1/32"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, reason := f.Check(request("10.0.0.1:1", "")); reason != ReasonDenied {
+		t.Errorf("published deny: %q", reason)
+	}
+	if _, reason := f.Check(request("198.51.100.1:1", "")); reason != "" {
+		t.Errorf("published rules without an allow list blocked: %s", reason)
+	}
+
+	if err := Publish(ctx, rdb, "secrets-admin", Rules{Allow: []string{"nope"}}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("invalid rules published: %v", err)
+	}
+
+	// Deleting the published rules brings back the defaults
+	mr.Del(RedisKeyPrefix + "secrets-admin")
+	if err := f.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, reason := f.Check(request("198.51.100.1:1", "")); reason != ReasonNotAllowed {
+		t.Errorf("defaults not restored: %q", reason)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	f, _ := New("network-config", nil, Rules{Allow: []string{"10.0.0.0/8"}})
+	var blocked []Blocked
+	handler := f.Middleware(func(r *http.Request, b Blocked) {
+		blocked = append(blocked, b)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for addr, want := range map[string]int{"10.0.0.1:1": http.StatusNoContent, "198.51.100.1:1": http.StatusForbidden} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, request(addr, ""))
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", addr, rec.Code, want)
+		}
+	}
+	if len(blocked) != 1 || blocked[0] != (Blocked{Scope: "network-config", IP: "198.51.100.1", Reason: ReasonNotAllowed}) {
+		t.Errorf("blocked = %+v", blocked)
+	}
+}
@@ -0,0 +1,77 @@
+package loadshed
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// metadataKey is Header in gRPC metadata, which is lower case
+const metadataKey = "x-priority"
+
+// UnaryServerInterceptor sheds unary calls by the priority of their method,
+// lowered by the caller's x-priority metadata
+func (s *Shedder) UnaryServerInterceptor(routes Routes) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := s.admitCall(ctx, routes, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor sheds streaming calls like UnaryServerInterceptor
+func (s *Shedder) StreamServerInterceptor(routes Routes) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if _, err := s.admitCall(ss.Context(), routes, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (s *Shedder) admitCall(ctx context.Context, routes Routes, method string) (context.Context, error) {
+	var requested string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(metadataKey); len(values) > 0 {
+			requested = values[0]
+		}
+	}
+	p := lower(routes.Priority(method), requested)
+	if !s.Admit(p) {
+		retryAfter := s.RetryAfter()
+		grpc.SetHeader(ctx, metadata.Pairs(
+			"retry-after", strconv.Itoa(s.retryAfterSeconds()),
+			"grpc-retry-pushback-ms", strconv.FormatInt(retryAfter.Milliseconds(), 10),
+		))
+		return ctx, status.Error(codes.Unavailable, "server is overloaded, retry later")
+	}
+	return NewContext(ctx, p), nil
+}
+
+// DialOption sends the priority of the call's context in metadata, so the
+// upstream service sheds the same requests first
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(clientHandler{})
+}
+
+type clientHandler struct{}
+
+func (clientHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	if p, ok := FromContext(ctx); ok {
+		return metadata.AppendToOutgoingContext(ctx, metadataKey, p.String())
+	}
+	return ctx
+}
+
+func (clientHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (clientHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+
+func (clientHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,38 @@
+package loadshed
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+)
+
+// Header lets a client lower the priority of its request, e.g. for
+// background jobs
+const Header = "X-Priority"
+
+// Middleware sheds requests by the priority of their path, lowered by
+// X-Priority, and puts the priority in the context for upstream calls
+func (s *Shedder) Middleware(routes Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p := lower(routes.Priority(apiversion.Canonical(r.URL.Path)), r.Header.Get(Header))
+			if !s.Admit(p) {
+				w.Header().Set("Retry-After", strconv.Itoa(s.retryAfterSeconds()))
+				apierror.New(http.StatusServiceUnavailable, "server is overloaded, retry later").WithCode("overloaded").Write(w)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), p)))
+		})
+	}
+}
+
+func (s *Shedder) retryAfterSeconds() int {
+	secs := int((s.RetryAfter() + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
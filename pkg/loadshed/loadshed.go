@@ -0,0 +1,296 @@
+// Package loadshed rejects low-priority requests while a service is under
+// pressure, so it keeps answering the requests that matter instead of slowing
+// down for all of them.
+//
+// Pressure is sampled from three signals, each relative to its limit:
+// goroutines, scheduler latency (how late a ticker fires, the Go counterpart
+// of event-loop lag) and the depth of the queue to the upstream service. The
+// highest of them is the pressure; at 1 low-priority requests are shed, at
+// 1.25 normal ones and at 1.5 high ones. Critical requests, such as health
+// checks and metrics, are never shed.
+//
+// Rejected HTTP requests get 503 with Retry-After and code overloaded; gRPC
+// calls get UNAVAILABLE with retry-after and grpc-retry-pushback-ms metadata.
+package loadshed
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Priority orders requests for shedding, lowest first
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+	// Critical requests are never shed
+	Critical
+)
+
+var priorityNames = [...]string{"low", "normal", "high", "critical"}
+
+func (p Priority) String() string {
+	if p < Low || p > Critical {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// ParsePriority parses low, normal, high or critical
+func ParsePriority(s string) (Priority, bool) {
+	for i, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return Priority(i), true
+		}
+	}
+	return Normal, false
+}
+
+// shedAt is the pressure from which each priority is shed
+var shedAt = [...]float64{Low: 1, Normal: 1.25, High: 1.5, Critical: math.Inf(1)}
+
+// Signals of pressure, the signal label of the metrics
+const (
+	SignalGoroutines = "goroutines"
+	SignalLatency    = "scheduler_latency"
+	SignalQueue      = "queue_depth"
+)
+
+var (
+	pressureGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zb_load_pressure",
+		Help: "Load of each signal relative to its shedding limit",
+	}, []string{"service", "signal"})
+	shedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zb_load_shed_requests_total",
+		Help: "Requests rejected by load shedding by priority and the signal over its limit",
+	}, []string{"service", "priority", "signal"})
+)
+
+// Config is the limits of the signals; a zero limit ignores the signal
+type Config struct {
+	Enabled          bool
+	MaxGoroutines    int
+	MaxLatency       time.Duration
+	MaxQueueDepth    int
+	RetryAfter       time.Duration
+	SamplingInterval time.Duration
+}
+
+// DefaultConfig sheds at 10000 goroutines, 100ms of scheduler latency or 500
+// queued upstream requests
+func DefaultConfig() Config {
+	return Config{
+		Enabled:          true,
+		MaxGoroutines:    10000,
+		MaxLatency:       100 * time.Millisecond,
+		MaxQueueDepth:    500,
+		RetryAfter:       2 * time.Second,
+		SamplingInterval: 100 * time.Millisecond,
+	}
+}
+
+// ConfigFromEnv overrides DefaultConfig with LOAD_SHED_ENABLED,
+// LOAD_SHED_MAX_GOROUTINES, LOAD_SHED_MAX_LATENCY, LOAD_SHED_MAX_QUEUE_DEPTH and
+// LOAD_SHED_RETRY_AFTER; invalid values are logged and ignored
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig()
+	if v := os.Getenv("LOAD_SHED_ENABLED"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Enabled = b
+		} else {
+			log.Printf("Invalid LOAD_SHED_ENABLED %q, using %v", v, cfg.Enabled)
+		}
+	}
+	envInt("LOAD_SHED_MAX_GOROUTINES", &cfg.MaxGoroutines)
+	envInt("LOAD_SHED_MAX_QUEUE_DEPTH", &cfg.MaxQueueDepth)
+	envDuration("LOAD_SHED_MAX_LATENCY", &cfg.MaxLatency)
+	envDuration("LOAD_SHED_RETRY_AFTER", &cfg.RetryAfter)
+	return cfg
+}
+
+func envInt(name string, dst *int) {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*dst = n
+		} else {
+			log.Printf("Invalid %s %q, using %d", name, v, *dst)
+		}
+	}
+}
+
+func envDuration(name string, dst *time.Duration) {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			*dst = d
+		} else {
+			log.Printf("Invalid %s %q, using %s", name, v, *dst)
+		}
+	}
+}
+
+// Shedder samples the pressure of a service and decides which requests to
+// reject
+type Shedder struct {
+	service string
+	cfg     Config
+	queue   func() int
+
+	mu       sync.RWMutex
+	latency  time.Duration
+	pressure float64
+	signal   string
+}
+
+// New returns the shedder of a service; queue reports the requests waiting
+// on the upstream service and may be nil. Run must be started for it to shed.
+func New(service string, cfg Config, queue func() int) *Shedder {
+	if cfg.SamplingInterval <= 0 {
+		cfg.SamplingInterval = DefaultConfig().SamplingInterval
+	}
+	return &Shedder{service: service, cfg: cfg, queue: queue}
+}
+
+// Run samples the signals until ctx is done
+func (s *Shedder) Run(ctx context.Context) {
+	if s == nil || !s.cfg.Enabled {
+		return
+	}
+	ticker := time.NewTicker(s.cfg.SamplingInterval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// A busy scheduler runs the ticker goroutine late
+			s.sample(now.Sub(last) - s.cfg.SamplingInterval)
+			last = now
+		}
+	}
+}
+
+// sample updates the pressure with the lateness of the last tick
+func (s *Shedder) sample(lag time.Duration) {
+	if lag < 0 {
+		lag = 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Latency rises at once and decays over a few intervals, so shedding does
+	// not flap on every other tick
+	s.latency = max(lag, s.latency*4/5)
+
+	signals := map[string]float64{
+		SignalGoroutines: ratio(float64(runtime.NumGoroutine()), float64(s.cfg.MaxGoroutines)),
+		SignalLatency:    ratio(float64(s.latency), float64(s.cfg.MaxLatency)),
+	}
+	if s.queue != nil {
+		signals[SignalQueue] = ratio(float64(s.queue()), float64(s.cfg.MaxQueueDepth))
+	}
+
+	s.pressure, s.signal = 0, ""
+	for signal, p := range signals {
+		pressureGauge.WithLabelValues(s.service, signal).Set(p)
+		if p > s.pressure {
+			s.pressure, s.signal = p, signal
+		}
+	}
+}
+
+func ratio(value, limit float64) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return value / limit
+}
+
+// Pressure is the highest load relative to its limit and its signal
+func (s *Shedder) Pressure() (float64, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pressure, s.signal
+}
+
+// Admit reports whether a request of priority p is served, counting the ones
+// that are shed
+func (s *Shedder) Admit(p Priority) bool {
+	if s == nil || !s.cfg.Enabled || p >= Critical {
+		return true
+	}
+	if p < Low {
+		p = Low
+	}
+	pressure, signal := s.Pressure()
+	if pressure < shedAt[p] {
+		return true
+	}
+	shedTotal.WithLabelValues(s.service, p.String(), signal).Inc()
+	return false
+}
+
+// RetryAfter is how long rejected clients are asked to wait
+func (s *Shedder) RetryAfter() time.Duration {
+	if s.cfg.RetryAfter <= 0 {
+		return time.Second
+	}
+	return s.cfg.RetryAfter
+}
+
+// Routes assigns priorities by the longest matching path or gRPC method
+// prefix, and Default to the rest. The Middleware matches /v1 paths for every
+// API version, see apiversion.Canonical.
+type Routes struct {
+	Default  Priority
+	Prefixes map[string]Priority
+}
+
+// Priority returns the priority of a path or gRPC full method
+func (r Routes) Priority(route string) Priority {
+	p, longest := r.Default, -1
+	for prefix, prio := range r.Prefixes {
+		if len(prefix) > longest && strings.HasPrefix(route, prefix) {
+			p, longest = prio, len(prefix)
+		}
+	}
+	return p
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the priority of its request
+func NewContext(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the priority of the request of ctx
+func FromContext(ctx context.Context) (Priority, bool) {
+	p, ok := ctx.Value(contextKey{}).(Priority)
+	return p, ok
+}
+
+// lower returns the requested priority when it is below the route's; callers
+// may give up priority but not claim more
+func lower(route Priority, requested string) Priority {
+	if p, ok := ParsePriority(requested); ok && p < route {
+		return p
+	}
+	return route
+}
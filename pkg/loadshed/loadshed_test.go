@@ -0,0 +1,184 @@
+package loadshed
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func testShedder(queue *int) *Shedder {
+	return New("test", Config{Enabled: true, MaxLatency: 100 * time.Millisecond, MaxQueueDepth: 100, RetryAfter: 1500 * time.Millisecond},
+		func() int { return *queue })
+}
+
+func TestAdmitByPressure(t *testing.T) {
+	queue := 0
+	s := testShedder(&queue)
+
+	tests := []struct {
+		queue int
+		want  map[Priority]bool
+	}{
+		{50, map[Priority]bool{Low: true, Normal: true, High: true, Critical: true}},
+		{100, map[Priority]bool{Low: false, Normal: true, High: true, Critical: true}},
+		{130, map[Priority]bool{Low: false, Normal: false, High: true, Critical: true}},
+		{1000, map[Priority]bool{Low: false, Normal: false, High: false, Critical: true}},
+	}
+	for _, tt := range tests {
+		queue = tt.queue
+		s.sample(0)
+		if _, signal := s.Pressure(); signal != SignalQueue {
+			t.Errorf("queue %d: signal = %q", tt.queue, signal)
+		}
+		for p, want := range tt.want {
+			if got := s.Admit(p); got != want {
+				t.Errorf("queue %d: Admit(%s) = %v, want %v", tt.queue, p, got, want)
+			}
+		}
+	}
+}
+
+func TestLatencyDecays(t *testing.T) {
+	queue := 0
+	s := testShedder(&queue)
+
+	s.sample(200 * time.Millisecond)
+	if p, signal := s.Pressure(); p != 2 || signal != SignalLatency {
+		t.Fatalf("pressure = %v %s", p, signal)
+	}
+	// A single punctual tick does not end shedding
+	s.sample(0)
+	if s.Admit(Low) {
+		t.Error("low priority admitted right after a latency spike")
+	}
+	for i := 0; i < 10; i++ {
+		s.sample(0)
+	}
+	if !s.Admit(Low) {
+		t.Error("low priority still shed after latency recovered")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	queue := 1000
+	s := testShedder(&queue)
+	s.cfg.Enabled = false
+	s.sample(0)
+	if !s.Admit(Low) {
+		t.Error("disabled shedder rejected a request")
+	}
+	var nilShedder *Shedder
+	if !nilShedder.Admit(Low) {
+		t.Error("nil shedder rejected a request")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	routes := Routes{Default: Normal, Prefixes: map[string]Priority{
+		"/v1/":                 Normal,
+		"/v1/chat/completions": High,
+		"/v1/batch":            Low,
+		"/health":              Critical,
+	}}
+	for route, want := range map[string]Priority{
+		"/v1/chat/completions": High,
+		"/v1/batches/b1":       Low,
+		"/v1/embeddings":       Normal,
+		"/health":              Critical,
+		"/other":               Normal,
+	} {
+		if got := routes.Priority(route); got != want {
+			t.Errorf("%s: priority = %s, want %s", route, got, want)
+		}
+	}
+
+	if got := lower(High, "low"); got != Low {
+		t.Errorf("lower(high, low) = %s", got)
+	}
+	if got := lower(Normal, "critical"); got != Normal {
+		t.Errorf("lower(normal, critical) = %s, callers must not raise priority", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	queue := 110
+	s := testShedder(&queue)
+	s.sample(0)
+
+	var got Priority
+	h := s.Middleware(Routes{Default: Normal, Prefixes: map[string]Priority{"/v1/batch": Low}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got, _ = FromContext(r.Context()) }))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/batch", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "2" {
+		t.Fatalf("status = %d, Retry-After = %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &body)
+	if body.Error.Code != "overloaded" {
+		t.Errorf("body = %s", rr.Body)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if rr.Code != http.StatusOK || got != Normal {
+		t.Errorf("status = %d, priority = %s", rr.Code, got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set(Header, "low")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("request lowered by %s: status = %d", Header, rr.Code)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	queue := 140
+	s := testShedder(&queue)
+	s.sample(0)
+
+	routes := Routes{Default: Normal, Prefixes: map[string]Priority{"/chat.ChatService/": High}}
+	var got Priority
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got, _ = FromContext(ctx)
+		return nil, nil
+	}
+	call := func(ctx context.Context, method string) error {
+		_, err := s.UnaryServerInterceptor(routes)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call(context.Background(), "/chat.ChatService/ChatCompletion"); err != nil || got != High {
+		t.Errorf("err = %v, priority = %s", err, got)
+	}
+	if err := call(context.Background(), "/embedding.EmbeddingService/CreateEmbeddings"); status.Code(err) != codes.Unavailable {
+		t.Errorf("err = %v, want Unavailable", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(metadataKey, "normal"))
+	if err := call(ctx, "/chat.ChatService/ChatCompletion"); status.Code(err) != codes.Unavailable {
+		t.Errorf("call lowered by metadata: err = %v", err)
+	}
+}
+
+func TestDialOptionSendsPriority(t *testing.T) {
+	ctx := clientHandler{}.TagRPC(NewContext(context.Background(), Low), nil)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if v := md.Get(metadataKey); len(v) != 1 || v[0] != "low" {
+		t.Errorf("metadata = %v", md)
+	}
+}
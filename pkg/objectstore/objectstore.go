@@ -0,0 +1,247 @@
+// Package objectstore keeps blobs in S3-compatible storage: AWS S3, MinIO,
+// Ceph RGW and the like.
+//
+// It speaks the three object calls services need (put, get, delete) with
+// path-style URLs and AWS Signature Version 4, so it works against any
+// endpoint without pulling in an SDK.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for a missing object
+var ErrNotFound = errors.New("object not found")
+
+// Config describes a bucket
+type Config struct {
+	// Endpoint is the base URL, e.g. https://s3.eu-central-1.amazonaws.com
+	// or http://minio:9000
+	Endpoint        string
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every key, e.g. "files/"
+	Prefix string
+}
+
+// ConfigFromEnv reads <prefix>_S3_ENDPOINT, _S3_BUCKET, _S3_REGION (default
+// us-east-1), _S3_PREFIX, _S3_ACCESS_KEY_ID and _S3_SECRET_ACCESS_KEY; the
+// credentials fall back to AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. ok is
+// false when no bucket is configured.
+func ConfigFromEnv(prefix string) (cfg Config, ok bool) {
+	env := func(name, fallback string) string {
+		if v := os.Getenv(prefix + "_S3_" + name); v != "" {
+			return v
+		}
+		if fallback != "" {
+			return os.Getenv(fallback)
+		}
+		return ""
+	}
+	cfg = Config{
+		Endpoint:        env("ENDPOINT", ""),
+		Bucket:          env("BUCKET", ""),
+		Region:          env("REGION", "AWS_REGION"),
+		AccessKeyID:     env("ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: env("SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		Prefix:          env("PREFIX", ""),
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	return cfg, cfg.Bucket != ""
+}
+
+// S3 is a client for one bucket
+type S3 struct {
+	cfg      Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// New creates a client; a nil client uses one with a 60s timeout
+func New(cfg Config, client *http.Client) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3 credentials are required")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: client, now: time.Now}, nil
+}
+
+// objectURL is the path-style URL of a key
+func (s *S3) objectURL(key string) string {
+	segments := strings.Split(s.cfg.Prefix+key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return s.endpoint.String() + "/" + uriEncode(s.cfg.Bucket) + "/" + strings.Join(segments, "/")
+}
+
+func (s *S3) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sign(req, body, s.cfg.Region, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.now())
+	return s.client.Do(req)
+}
+
+// Put stores an object, replacing any existing one
+func (s *S3) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("put", key, resp)
+	}
+	return nil
+}
+
+// Get returns the content of an object
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("get", key, resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes an object; deleting a missing object is not an error
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError("delete", key, resp)
+	}
+	return nil
+}
+
+func responseError(op, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 %s %s: status %d: %s", op, key, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// uriEncode encodes a path segment or query value the way SigV4 expects
+func uriEncode(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds the SigV4 headers for the S3 service. It signs the host,
+// Content-Type, Range and x-amz-* headers.
+func sign(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, strings.Join(params, "&"), canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
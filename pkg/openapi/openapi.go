@@ -0,0 +1,454 @@
+// Package openapi describes the public HTTP APIs as OpenAPI 3 documents built
+// from the Go types the handlers decode and encode, and serves them:
+//
+//	/openapi.json  the document
+//	/docs          the document rendered with Redoc
+//
+// Schemas follow encoding/json: struct fields are named by their json tags,
+// fields without omitempty are required, pointers to scalars are nullable and
+// named struct types become components/schemas entries referenced by $ref.
+// Types whose JSON form differs from their Go structure implement Schemer.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the OpenAPI version of the documents
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+
+	types map[reflect.Type]string
+
+	once sync.Once
+	body []byte
+	err  error
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by lower-case method
+type PathItem map[string]*Operation
+
+// Operation is an API operation
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the body of an operation
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the named schemas
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// Schema is a JSON schema in the OpenAPI 3.0 dialect
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// Schemer is implemented by types that describe their own JSON form, e.g.
+// a field that accepts a string or an array
+type Schemer interface {
+	OpenAPISchema() *Schema
+}
+
+// Route is an operation to describe
+type Route struct {
+	Method string
+	// Path with {name} segments for path parameters
+	Path    string
+	Summary string
+	Tag     string
+	// Query lists the query parameters
+	Query []string
+	// Request is a value of the body type, nil without a body
+	Request interface{}
+	// Response is a value of the body type, nil for an empty body
+	Response interface{}
+	// Content types of the bodies, application/json by default
+	RequestType  string
+	ResponseType string
+	// Status of a successful response, 200 by default
+	Status int
+	// Stream marks operations that answer with server-sent events when the
+	// request asks to stream
+	Stream bool
+	// Deprecated marks operations clients should move off before they are
+	// removed or changed
+	Deprecated bool
+}
+
+// errorSchema is the body written by pkg/apierror
+var errorSchema = &Schema{
+	Type:     "object",
+	Required: []string{"error"},
+	Properties: map[string]*Schema{
+		"error": {
+			Type:     "object",
+			Required: []string{"message", "type", "param", "code"},
+			Properties: map[string]*Schema{
+				"message": {Type: "string"},
+				"type":    {Type: "string"},
+				"param":   {Type: "string", Nullable: true},
+				"code":    {Type: "string", Nullable: true},
+			},
+		},
+	},
+}
+
+// New returns an empty document
+func New(title, version, description string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version, Description: description},
+		Paths:   map[string]PathItem{},
+		Components: Components{Schemas: map[string]*Schema{
+			"Error": errorSchema,
+		}},
+		types: map[reflect.Type]string{},
+	}
+}
+
+// Add describes routes. It must not be called once the document is served.
+func (d *Document) Add(routes ...Route) *Document {
+	for _, r := range routes {
+		method := strings.ToLower(r.Method)
+		op := &Operation{
+			OperationID: operationID(method, r.Path),
+			Summary:     r.Summary,
+			Deprecated:  r.Deprecated,
+			Responses: map[string]Response{
+				"default": {
+					Description: "Error",
+					Content:     jsonContent(&Schema{Ref: "#/components/schemas/Error"}),
+				},
+			},
+		}
+		if r.Tag != "" {
+			op.Tags = []string{r.Tag}
+		}
+		for _, name := range pathParams(r.Path) {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+		for _, name := range r.Query {
+			op.Parameters = append(op.Parameters, Parameter{Name: name, In: "query", Schema: &Schema{Type: "string"}})
+		}
+		if r.Request != nil {
+			op.RequestBody = &RequestBody{Required: true, Content: content(r.RequestType, d.SchemaOf(r.Request))}
+		}
+
+		status := r.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := Response{Description: http.StatusText(status)}
+		if r.Response != nil {
+			resp.Content = content(r.ResponseType, d.SchemaOf(r.Response))
+			if r.Stream {
+				resp.Content["text/event-stream"] = MediaType{Schema: &Schema{Type: "string"}}
+			}
+		}
+		op.Responses[strconv.Itoa(status)] = resp
+
+		if d.Paths[r.Path] == nil {
+			d.Paths[r.Path] = PathItem{}
+		}
+		d.Paths[r.Path][method] = op
+	}
+	return d
+}
+
+// Deprecated reports whether the operation of a method and path template is
+// marked deprecated
+func (d *Document) Deprecated(method, path string) bool {
+	op := d.Paths[path][strings.ToLower(method)]
+	return op != nil && op.Deprecated
+}
+
+// SchemaOf returns the schema of the type of v, adding named struct types to
+// the components
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schema(reflect.TypeOf(v))
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawType     = reflect.TypeOf(json.RawMessage{})
+	schemerType = reflect.TypeOf((*Schemer)(nil)).Elem()
+)
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t.Implements(schemerType) {
+		return reflect.Zero(t).Interface().(Schemer).OpenAPISchema()
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := d.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.object(t)
+		}
+		name, ok := d.types[t]
+		if !ok {
+			name = d.componentName(t)
+			d.types[t] = name
+			// Registered before the fields, so recursive types end in a $ref
+			d.Components.Schemas[name] = nil
+			d.Components.Schemas[name] = d.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		// Interfaces hold any JSON value
+		return &Schema{}
+	}
+}
+
+// componentName is the type name, qualified by its package when another
+// type already took it
+func (d *Document) componentName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := d.Components.Schemas[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + name
+}
+
+// object is the schema of the fields of a struct
+func (d *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			// Embedded struct fields are promoted, as encoding/json does
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded := d.object(ft)
+				for prop, ps := range embedded.Properties {
+					s.Properties[prop] = ps
+				}
+				s.Required = append(s.Required, embedded.Required...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := d.schema(f.Type)
+		if strings.Contains(opts, "string") && prop.Type != "" {
+			prop = &Schema{Type: "string"}
+		}
+		s.Properties[name] = prop
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+func jsonContent(s *Schema) map[string]MediaType {
+	return content("", s)
+}
+
+func content(contentType string, s *Schema) map[string]MediaType {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	return map[string]MediaType{contentType: {Schema: s}}
+}
+
+// pathParams returns the {name} segments of a path
+func pathParams(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, strings.TrimSuffix(strings.Trim(segment, "{}"), "..."))
+		}
+	}
+	return names
+}
+
+// operationID is the method followed by the path words, e.g.
+// getV1BatchesIdResults
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(method)
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
+
+// JSON encodes the document
+func (d *Document) JSON() ([]byte, error) {
+	d.once.Do(func() {
+		d.body, d.err = json.Marshal(d)
+	})
+	return d.body, d.err
+}
+
+// Handler serves the document as JSON
+func (d *Document) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := d.JSON()
+		if err != nil {
+			http.Error(w, "failed to encode the OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+// Docs serves an HTML page that renders the document at specURL
+func Docs(title, specURL string) http.Handler {
+	page := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+    <title>%s</title>
+    <meta charset="utf-8">
+</head>
+<body>
+    <redoc spec-url="%s"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`, html.EscapeString(title), html.EscapeString(specURL))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+}
+
+// Unrouted returns the operations no handler is registered for, as
+// "METHOD /path". match reports whether a request is routed, e.g. with
+// http.ServeMux.Handler or mux.Router.Match.
+func (d *Document) Unrouted(match func(*http.Request) bool) []string {
+	var missing []string
+	for path, item := range d.Paths {
+		target := path
+		for _, name := range pathParams(path) {
+			target = strings.Replace(target, "{"+name+"}", "x", 1)
+		}
+		for method := range item {
+			req, _ := http.NewRequest(strings.ToUpper(method), target, nil)
+			if !match(req) {
+				missing = append(missing, strings.ToUpper(method)+" "+path)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,195 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type usage struct {
+	PromptTokens int `json:"prompt_tokens"`
+}
+
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content,omitempty"`
+}
+
+type base struct {
+	ID string `json:"id"`
+}
+
+type completion struct {
+	base
+	Model    string            `json:"model"`
+	Messages []message         `json:"messages"`
+	Usage    *usage            `json:"usage,omitempty"`
+	MaxTok   *int              `json:"max_tokens,omitempty"`
+	Created  time.Time         `json:"created"`
+	Meta     map[string]string `json:"metadata,omitempty"`
+	Stop     stop              `json:"stop,omitempty"`
+	Next     *completion       `json:"next,omitempty"`
+	internal string
+	Ignored  string `json:"-"`
+}
+
+type stop []string
+
+func (stop) OpenAPISchema() *Schema {
+	return &Schema{OneOf: []*Schema{{Type: "string"}, {Type: "array", Items: &Schema{Type: "string"}}}}
+}
+
+func testDocument() *Document {
+	return New("Test API", "1.0.0", "").Add(
+		Route{Method: "POST", Path: "/v1/completions", Tag: "chat", Request: completion{}, Response: completion{}, Stream: true},
+		Route{Method: "GET", Path: "/v1/completions/{id}", Query: []string{"verbose"}, Response: completion{}},
+		Route{Method: "DELETE", Path: "/v1/completions/{id}", Status: http.StatusNoContent, Deprecated: true},
+		Route{Method: "GET", Path: "/v1/completions/{id}/lines", Response: message{}, ResponseType: "application/jsonl"},
+	)
+}
+
+func TestSchemaOf(t *testing.T) {
+	doc := testDocument()
+
+	s := doc.Components.Schemas["completion"]
+	if s == nil {
+		t.Fatalf("components = %v, want completion", doc.Components.Schemas)
+	}
+	if want := []string{"created", "id", "messages", "model"}; !reflect.DeepEqual(s.Required, want) {
+		t.Errorf("required = %v, want %v", s.Required, want)
+	}
+	if _, ok := s.Properties["internal"]; ok {
+		t.Error("unexported field is described")
+	}
+	if _, ok := s.Properties["Ignored"]; ok {
+		t.Error(`json:"-" field is described`)
+	}
+	if got := s.Properties["id"]; got == nil || got.Type != "string" {
+		t.Errorf("embedded id = %+v", got)
+	}
+	if got := s.Properties["messages"]; got.Type != "array" || got.Items.Ref != "#/components/schemas/message" {
+		t.Errorf("messages = %+v", got)
+	}
+	if got := s.Properties["max_tokens"]; got.Type != "integer" || !got.Nullable {
+		t.Errorf("max_tokens = %+v", got)
+	}
+	if got := s.Properties["created"]; got.Format != "date-time" {
+		t.Errorf("created = %+v", got)
+	}
+	if got := s.Properties["metadata"]; got.AdditionalProperties == nil || got.AdditionalProperties.Type != "string" {
+		t.Errorf("metadata = %+v", got)
+	}
+	if got := s.Properties["stop"]; len(got.OneOf) != 2 {
+		t.Errorf("stop = %+v", got)
+	}
+	if got := s.Properties["next"]; got.Ref != "#/components/schemas/completion" {
+		t.Errorf("recursive next = %+v", got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	doc := testDocument()
+
+	post := doc.Paths["/v1/completions"]["post"]
+	if post.OperationID != "postV1Completions" || post.RequestBody == nil {
+		t.Errorf("post = %+v", post)
+	}
+	if _, ok := post.Responses["200"].Content["text/event-stream"]; !ok {
+		t.Error("streaming operation has no text/event-stream response")
+	}
+
+	get := doc.Paths["/v1/completions/{id}"]["get"]
+	if len(get.Parameters) != 2 || get.Parameters[0].In != "path" || !get.Parameters[0].Required || get.Parameters[1].In != "query" {
+		t.Errorf("parameters = %+v", get.Parameters)
+	}
+
+	lines := doc.Paths["/v1/completions/{id}/lines"]["get"]
+	if _, ok := lines.Responses["200"].Content["application/jsonl"]; !ok {
+		t.Errorf("responses = %+v, want application/jsonl", lines.Responses)
+	}
+	if err := doc.ValidateResponse("GET", "/v1/completions/{id}/lines", 200, []byte("{}\n{}\n")); err != nil {
+		t.Errorf("non-JSON response validated: %v", err)
+	}
+
+	del := doc.Paths["/v1/completions/{id}"]["delete"]
+	if resp, ok := del.Responses["204"]; !ok || resp.Content != nil {
+		t.Errorf("responses = %+v", del.Responses)
+	}
+	if !doc.Deprecated("DELETE", "/v1/completions/{id}") || doc.Deprecated("GET", "/v1/completions/{id}") || doc.Deprecated("GET", "/v1/unknown") {
+		t.Error("deprecated operations not reported")
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	doc := testDocument()
+
+	rec := httptest.NewRecorder()
+	doc.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["openapi"] != Version {
+		t.Errorf("openapi = %v", got["openapi"])
+	}
+
+	rec = httptest.NewRecorder()
+	Docs("Test <API>", "/openapi.json").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `spec-url="/openapi.json"`) || !strings.Contains(body, "Test &lt;API&gt;") {
+		t.Errorf("docs = %s", body)
+	}
+}
+
+func TestUnrouted(t *testing.T) {
+	doc := testDocument()
+
+	mux := http.NewServeMux()
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	mux.HandleFunc("POST /v1/completions", noop)
+	mux.HandleFunc("GET /v1/completions/{id}", noop)
+
+	got := doc.Unrouted(func(r *http.Request) bool {
+		_, pattern := mux.Handler(r)
+		return pattern != ""
+	})
+	if want := []string{"DELETE /v1/completions/{id}", "GET /v1/completions/{id}/lines"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unrouted = %v, want %v", got, want)
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	doc := testDocument()
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		ok     bool
+	}{
+		{"valid", 200, `{"id":"1","model":"m","messages":[{"role":"user"}],"created":"2024-01-01T00:00:00Z","stop":"x"}`, true},
+		{"missing field", 200, `{"id":"1","messages":[],"created":""}`, false},
+		{"wrong type", 200, `{"id":"1","model":"m","messages":[{"role":1}],"created":""}`, false},
+		{"null scalar", 200, `{"id":"1","model":"m","messages":[],"created":"","max_tokens":null}`, true},
+		{"fractional integer", 200, `{"id":"1","model":"m","messages":[],"created":"","max_tokens":1.5}`, false},
+		{"stop array", 200, `{"id":"1","model":"m","messages":[],"created":"","stop":["a"]}`, true},
+		{"stop number", 200, `{"id":"1","model":"m","messages":[],"created":"","stop":1}`, false},
+		{"error", 404, `{"error":{"message":"not found","type":"invalid_request_error","param":null,"code":null}}`, true},
+		{"bad error", 500, `{"message":"boom"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := doc.ValidateResponse("GET", "/v1/completions/{id}", tt.status, []byte(tt.body))
+			if (err == nil) != tt.ok {
+				t.Errorf("err = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+
+	if err := doc.ValidateResponse("GET", "/v1/unknown", 200, []byte(`{}`)); err == nil {
+		t.Error("undocumented operation validated")
+	}
+}
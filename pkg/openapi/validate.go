@@ -0,0 +1,138 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateResponse checks a JSON response body against the schema the
+// document gives for the operation and status. Statuses the operation does
+// not list are checked against its default (error) response.
+func (d *Document) ValidateResponse(method, path string, status int, body []byte) error {
+	op := d.Paths[path][strings.ToLower(method)]
+	if op == nil {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp = op.Responses["default"]
+	}
+	media, ok := resp.Content["application/json"]
+	if !ok {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return fmt.Errorf("%s %s %d: invalid JSON: %w", method, path, status, err)
+	}
+	if err := d.validate(media.Schema, value, "$"); err != nil {
+		return fmt.Errorf("%s %s %d: %w", method, path, status, err)
+	}
+	return nil
+}
+
+// validate checks value, decoded with UseNumber, against s
+func (d *Document) validate(s *Schema, value interface{}, at string) error {
+	if s.Ref != "" {
+		ref, ok := d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, s.Ref)
+		}
+		s = ref
+	}
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: null, want %s", at, s.Type)
+	}
+	if len(s.OneOf) > 0 {
+		for _, option := range s.OneOf {
+			if d.validate(option, value, at) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: matches none of the allowed schemas", at)
+	}
+
+	switch s.Type {
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %T, want object", at, value)
+		}
+		for _, name := range s.Required {
+			if _, ok := fields[name]; !ok {
+				return fmt.Errorf("%s: missing %q", at, name)
+			}
+		}
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				prop = s.AdditionalProperties
+			}
+			if prop == nil {
+				continue
+			}
+			if err := d.validate(prop, fields[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %T, want array", at, value)
+		}
+		for i, item := range items {
+			if err := d.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: %T, want string", at, value)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %v", at, str, s.Enum)
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: %T, want integer", at, value)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%s: %s, want integer", at, n)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Errorf("%s: %T, want number", at, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: %T, want boolean", at, value)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+// Package jetstream publishes outbox events to NATS JetStream
+package jetstream
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/MaksimVF/ZB/pkg/outbox"
+)
+
+// DefaultDuplicates is the deduplication window of streams created by
+// EnsureStream. A relay that stops between publishing and marking a row
+// republishes it within this window when it runs again.
+const DefaultDuplicates = 10 * time.Minute
+
+// Publisher sends events with their ID as Nats-Msg-Id, so the stream stores
+// each event once even when the relay publishes it again
+type Publisher struct {
+	JS nats.JetStreamContext
+}
+
+func (p Publisher) Publish(ctx context.Context, event outbox.Event) error {
+	msg := nats.NewMsg(event.Subject)
+	msg.Data = event.Payload
+	for key, values := range event.Header {
+		msg.Header[key] = values
+	}
+	_, err := p.JS.PublishMsg(msg, nats.MsgId(event.ID), nats.Context(ctx))
+	return err
+}
+
+// EnsureStream creates the stream for subjects, or updates its subjects and
+// deduplication window
+func EnsureStream(js nats.JetStreamContext, name string, subjects ...string) error {
+	config := &nats.StreamConfig{
+		Name:       name,
+		Subjects:   subjects,
+		Storage:    nats.FileStorage,
+		Duplicates: DefaultDuplicates,
+	}
+	_, err := js.StreamInfo(name)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(config)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, err = js.UpdateStream(config)
+	return err
+}
+
+// Relay connects to NATS at url, sets up the stream for subjects and relays
+// the outbox of db until ctx is cancelled. Events stay in the outbox while
+// NATS is unreachable.
+func Relay(ctx context.Context, url, name string, db *sql.DB, stream string, subjects ...string) error {
+	nc, err := nats.Connect(url, nats.Name(name), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	for {
+		err := EnsureStream(js, stream, subjects...)
+		if err == nil {
+			break
+		}
+		log.Printf("Outbox: setting up stream %s: %v; retrying", stream, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	log.Printf("Outbox: relaying to stream %s", stream)
+	relay := &outbox.Relay{DB: db, Publisher: Publisher{JS: js}}
+	relay.Run(ctx)
+	return nil
+}
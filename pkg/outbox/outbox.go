@@ -0,0 +1,207 @@
+// Package outbox publishes cross-service events through a transactional
+// outbox: services write an event into the outbox table in the same
+// transaction as the change it describes, and a Relay publishes unpublished
+// rows in order and marks them published. An event is therefore published
+// if and only if its transaction committed.
+//
+// The relay may publish a row again when it fails between publishing and
+// marking it, so publishers deduplicate by event ID; the JetStream publisher
+// in outbox/jetstream sends it as Nats-Msg-Id.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
+)
+
+// Schema creates the outbox table in Postgres
+const Schema = `
+CREATE TABLE IF NOT EXISTS outbox (
+    id           text PRIMARY KEY,
+    subject      text NOT NULL,
+    payload      jsonb NOT NULL,
+    headers      jsonb NOT NULL DEFAULT '{}',
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    published_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_unpublished ON outbox (created_at) WHERE published_at IS NULL;
+`
+
+// Event is one outbox row
+type Event struct {
+	ID        string
+	Subject   string
+	Payload   []byte
+	Header    map[string][]string
+	CreatedAt time.Time
+}
+
+// Execer runs a statement, e.g. *sql.Tx or gorm's Statement.ConnPool inside
+// a transaction
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Add writes an event with payload as JSON. Call it with the transaction of
+// the change so both commit or roll back together. The trace context and
+// request ID of ctx travel with the event.
+func Add(ctx context.Context, tx Execer, subject string, payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("outbox: encode %s payload: %w", subject, err)
+	}
+	header := make(map[string][]string)
+	tracing.Inject(ctx, header)
+	requestid.Inject(ctx, header)
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.NewString()
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO outbox (id, subject, payload, headers) VALUES ($1, $2, $3, $4)`,
+		id, subject, string(data), string(headers))
+	if err != nil {
+		return "", fmt.Errorf("outbox: add %s: %w", subject, err)
+	}
+	return id, nil
+}
+
+// Publisher delivers an event and returns once the broker has stored it
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Relay publishes the outbox of one database
+type Relay struct {
+	DB        *sql.DB
+	Publisher Publisher
+	// Rows per transaction, default 100
+	BatchSize int
+	// How often the outbox is polled, default 1s
+	Interval time.Duration
+	// How long published rows are kept, default 7 days
+	Retention time.Duration
+}
+
+// Run relays until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+
+	for {
+		// Drain the backlog before waiting for the next tick
+		for {
+			n, err := r.Flush(ctx)
+			if err != nil {
+				log.Printf("Outbox: %v", err)
+			}
+			if err != nil || n < r.batchSize() {
+				break
+			}
+		}
+		if time.Since(lastCleanup) > time.Hour {
+			if err := r.cleanup(ctx); err != nil {
+				log.Printf("Outbox: cleanup failed: %v", err)
+			}
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) batchSize() int {
+	if r.BatchSize <= 0 {
+		return 100
+	}
+	return r.BatchSize
+}
+
+// Flush publishes up to one batch of unpublished events in order and returns
+// how many were published. Rows are locked with SKIP LOCKED, so several
+// replicas can relay the same outbox. It stops at the first failure, which
+// keeps the order for the next attempt.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, subject, payload, headers, created_at
+		FROM outbox
+		WHERE published_at IS NULL
+		ORDER BY created_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED`, r.batchSize())
+	if err != nil {
+		return 0, err
+	}
+	var events []Event
+	for rows.Next() {
+		var ev Event
+		var headers []byte
+		if err := rows.Scan(&ev.ID, &ev.Subject, &ev.Payload, &headers, &ev.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(headers, &ev.Header); err != nil {
+			ev.Header = nil
+		}
+		events = append(events, ev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var publishErr error
+	for _, ev := range events {
+		if publishErr = r.Publisher.Publish(ctx, ev); publishErr != nil {
+			publishErr = fmt.Errorf("publish %s %s: %w", ev.Subject, ev.ID, publishErr)
+			break
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE outbox SET published_at = now() WHERE id = $1`, ev.ID); err != nil {
+			return 0, err
+		}
+		published++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+// cleanup deletes published rows older than the retention
+func (r *Relay) cleanup(ctx context.Context) error {
+	retention := r.Retention
+	if retention <= 0 {
+		retention = 7 * 24 * time.Hour
+	}
+	_, err := r.DB.ExecContext(ctx,
+		`DELETE FROM outbox WHERE published_at IS NOT NULL AND published_at < $1`,
+		time.Now().Add(-retention))
+	return err
+}
@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/MaksimVF/ZB/pkg/requestid"
+)
+
+type recordingExecer struct {
+	query string
+	args  []interface{}
+}
+
+func (e *recordingExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.query, e.args = query, args
+	return nil, nil
+}
+
+func TestAdd(t *testing.T) {
+	ctx := requestid.NewContext(context.Background(), "req-123")
+	tx := &recordingExecer{}
+
+	id, err := Add(ctx, tx, "auth.user.registered", map[string]string{"user_id": "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tx.query, "INSERT INTO outbox") || len(tx.args) != 4 {
+		t.Fatalf("query = %q, args = %v", tx.query, tx.args)
+	}
+	if tx.args[0] != id || tx.args[1] != "auth.user.registered" || tx.args[2] != `{"user_id":"u1"}` {
+		t.Errorf("args = %v", tx.args)
+	}
+
+	var header map[string][]string
+	if err := json.Unmarshal([]byte(tx.args[3].(string)), &header); err != nil {
+		t.Fatal(err)
+	}
+	if got := http.Header(header).Get(requestid.Header); got != "req-123" {
+		t.Errorf("request ID header = %q", got)
+	}
+}
+
+func TestAddInvalidPayload(t *testing.T) {
+	if _, err := Add(context.Background(), &recordingExecer{}, "x", make(chan int)); err == nil {
+		t.Error("no error for a payload that is not JSON")
+	}
+}
@@ -0,0 +1,205 @@
+// Package payload rejects oversized requests before they reach heads and
+// providers.
+//
+// Bodies over MaxBodyBytes get 413. JSON bodies with chat messages, at the top
+// level or in the requests of a batch, get 400 when a message list is longer
+// than MaxMessages or its prompt is estimated at more than MaxPromptTokens.
+// Errors are apierror.Error values in the OpenAI error format, so SDKs surface
+// them as they would the provider's own.
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var rejectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "payload_rejections_total",
+	Help: "Requests rejected by payload validation, by reason",
+}, []string{"reason"})
+
+// Limits configures the checks; a zero limit is not enforced
+type Limits struct {
+	MaxBodyBytes    int64
+	MaxMessages     int
+	MaxPromptTokens int
+}
+
+// DefaultLimits allows 10 MiB bodies, 1000 messages and 128k prompt tokens
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBodyBytes:    10 << 20,
+		MaxMessages:     1000,
+		MaxPromptTokens: 128000,
+	}
+}
+
+// LimitsFromEnv reads MAX_REQUEST_BODY_BYTES, MAX_REQUEST_MESSAGES and
+// MAX_PROMPT_TOKENS; 0 disables a limit
+func LimitsFromEnv() Limits {
+	l := DefaultLimits()
+	if v, err := strconv.ParseInt(os.Getenv("MAX_REQUEST_BODY_BYTES"), 10, 64); err == nil && v >= 0 {
+		l.MaxBodyBytes = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MAX_REQUEST_MESSAGES")); err == nil && v >= 0 {
+		l.MaxMessages = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("MAX_PROMPT_TOKENS")); err == nil && v >= 0 {
+		l.MaxPromptTokens = v
+	}
+	return l
+}
+
+// Middleware checks the body of POST, PUT and PATCH requests and passes it
+// on unchanged
+func (l Limits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := l.ReadBody(w, r)
+		if err == nil {
+			err = l.Validate(body)
+		}
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) {
+			rejectionsTotal.WithLabelValues(apiErr.Code).Inc()
+			apiErr.Write(w)
+			return
+		}
+		if err != nil {
+			apierror.New(http.StatusBadRequest, "could not read request body").WithCode("invalid_body").Write(w)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReadBody reads the request body, or returns a 413 error when it is larger
+// than MaxBodyBytes
+func (l Limits) ReadBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if l.MaxBodyBytes <= 0 {
+		return io.ReadAll(r.Body)
+	}
+	tooLarge := apierror.New(http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("Request body is larger than the maximum of %d bytes", l.MaxBodyBytes)).WithCode("request_too_large")
+	if r.ContentLength > l.MaxBodyBytes {
+		return nil, tooLarge
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, l.MaxBodyBytes))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, tooLarge
+	}
+	return body, err
+}
+
+// chatPayload holds the fields of chat and batch requests that are checked
+type chatPayload struct {
+	Model    string          `json:"model"`
+	Messages json.RawMessage `json:"messages"`
+	Requests []struct {
+		Model    string          `json:"model"`
+		Messages json.RawMessage `json:"messages"`
+	} `json:"requests"`
+}
+
+type message struct {
+	Role    string          `json:"role"`
+	Name    string          `json:"name"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Validate checks the message lists of a JSON body. Bodies that are not JSON
+// objects, such as file uploads, are left to the handler.
+func (l Limits) Validate(body []byte) error {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil
+	}
+	var p chatPayload
+	if err := json.Unmarshal(trimmed, &p); err != nil {
+		return apierror.New(http.StatusBadRequest, "Request body is not valid JSON: "+err.Error()).WithCode("invalid_json")
+	}
+
+	if err := l.validateMessages(p.Model, p.Messages, "messages"); err != nil {
+		return err
+	}
+	for i, item := range p.Requests {
+		if err := l.validateMessages(item.Model, item.Messages, fmt.Sprintf("requests[%d].messages", i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l Limits) validateMessages(model string, raw json.RawMessage, param string) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var messages []message
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return apierror.New(http.StatusBadRequest, fmt.Sprintf("'%s' must be an array of messages", param)).WithParam(param).WithCode("invalid_type")
+	}
+	if l.MaxMessages > 0 && len(messages) > l.MaxMessages {
+		return apierror.New(http.StatusBadRequest,
+			fmt.Sprintf("'%s' has %d messages, more than the maximum of %d", param, len(messages), l.MaxMessages)).
+			WithParam(param).WithCode("too_many_messages")
+	}
+
+	counted := make([]tokenizer.Message, 0, len(messages))
+	for i, m := range messages {
+		if m.Role == "" {
+			role := fmt.Sprintf("%s[%d].role", param, i)
+			return apierror.New(http.StatusBadRequest, fmt.Sprintf("'%s' is required", role)).WithParam(role).WithCode("missing_required_parameter")
+		}
+		counted = append(counted, tokenizer.Message{Role: m.Role, Name: m.Name, Content: contentText(m.Content)})
+	}
+	if l.MaxPromptTokens > 0 {
+		if tokens := tokenizer.CountMessages(model, counted); tokens > l.MaxPromptTokens {
+			return apierror.New(http.StatusBadRequest,
+				fmt.Sprintf("'%s' is about %d tokens, more than the maximum of %d prompt tokens", param, tokens, l.MaxPromptTokens)).
+				WithParam(param).WithCode("context_length_exceeded")
+		}
+	}
+	return nil
+}
+
+// contentText returns the text of a message content, which is a string or an
+// array of parts of which only text parts count
+func contentText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if json.Unmarshal(raw, &parts) != nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	for _, part := range parts {
+		if part.Type == "text" {
+			buf.WriteString(part.Text)
+		}
+	}
+	return buf.String()
+}
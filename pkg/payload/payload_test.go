@@ -0,0 +1,95 @@
+package payload
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(l Limits, body string) (*httptest.ResponseRecorder, string) {
+	var received string
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+	return rec, received
+}
+
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error.Type != "invalid_request_error" {
+		t.Errorf("type %q", body.Error.Type)
+	}
+	return body.Error.Code
+}
+
+func TestMiddlewarePassesBody(t *testing.T) {
+	body := `{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]}`
+	rec, received := serve(DefaultLimits(), body)
+	if rec.Code != http.StatusOK || received != body {
+		t.Errorf("status %d, handler got %q", rec.Code, received)
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	rec, received := serve(Limits{MaxBodyBytes: 16}, `{"model":"gpt-4","messages":[]}`)
+	if rec.Code != http.StatusRequestEntityTooLarge || received != "" {
+		t.Fatalf("status %d, handler got %q", rec.Code, received)
+	}
+	if code := errorCode(t, rec); code != "request_too_large" {
+		t.Errorf("code %q", code)
+	}
+}
+
+func TestTooManyMessages(t *testing.T) {
+	rec, _ := serve(Limits{MaxMessages: 1}, `{"messages":[{"role":"user","content":"a"},{"role":"user","content":"b"}]}`)
+	if rec.Code != http.StatusBadRequest || errorCode(t, rec) != "too_many_messages" {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPromptTokens(t *testing.T) {
+	long := strings.Repeat("word ", 200)
+	rec, _ := serve(Limits{MaxPromptTokens: 50}, `{"model":"gpt-4","messages":[{"role":"user","content":"`+long+`"}]}`)
+	if rec.Code != http.StatusBadRequest || errorCode(t, rec) != "context_length_exceeded" {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body)
+	}
+
+	// Text parts count, batch items are checked one by one
+	rec, _ = serve(Limits{MaxPromptTokens: 50}, `{"requests":[{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]},`+
+		`{"model":"gpt-4","messages":[{"role":"user","content":[{"type":"text","text":"`+long+`"}]}]}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "requests[1].messages") {
+		t.Errorf("status %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestInvalidMessages(t *testing.T) {
+	for _, body := range []string{
+		`{"messages":`,
+		`{"messages":"hi"}`,
+		`{"messages":[{"content":"hi"}]}`,
+	} {
+		if rec, _ := serve(DefaultLimits(), body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, rec.Code)
+		}
+	}
+
+	// Non-JSON bodies are left to the handler
+	if rec, _ := serve(DefaultLimits(), "--boundary\r\n"); rec.Code != http.StatusOK {
+		t.Errorf("multipart body: status %d", rec.Code)
+	}
+}
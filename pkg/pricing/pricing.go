@@ -0,0 +1,156 @@
+// Package pricing estimates what a request costs in USD.
+//
+// Prices come from the billing pricing service, which keeps them in Redis
+// under pricing:current as USD per million tokens, per minute of transcribed
+// audio and per million characters of synthesized speech. They are reloaded in the
+// background every RefreshInterval; until the first load, or without Redis,
+// DefaultPrices is used. Unknown models get the pricing service's fallbacks.
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisKey is where the pricing service stores the current prices
+const RedisKey = "pricing:current"
+
+// RefreshInterval is how often prices are reloaded from Redis
+const RefreshInterval = time.Minute
+
+// Price is a model's price in USD per million tokens, or per minute and per
+// million characters for audio
+type Price struct {
+	ChatInput  float64 `json:"chat_input,omitempty"`
+	ChatOutput float64 `json:"chat_output,omitempty"`
+	Embed      float64 `json:"embed,omitempty"`
+	// Transcription is per minute of input audio
+	Transcription float64 `json:"transcription,omitempty"`
+	// Speech is per million characters of input text
+	Speech float64 `json:"speech,omitempty"`
+}
+
+// Fallbacks for models without a price, as in pricing_service.py
+var fallback = Price{ChatInput: 10.00, ChatOutput: 30.00, Embed: 0.13, Transcription: 0.006, Speech: 15.00}
+
+// DefaultPrices mirrors DEFAULT_PRICING of pricing_service.py
+var DefaultPrices = map[string]Price{
+	"gpt-4o":                 {ChatInput: 5.25, ChatOutput: 15.75, Embed: 0.11},
+	"gpt-4-turbo":            {ChatInput: 10.50, ChatOutput: 31.50, Embed: 0.14},
+	"claude-3-opus":          {ChatInput: 16.00, ChatOutput: 78.00},
+	"llama3-70b":             {ChatInput: 0.22, ChatOutput: 0.65},
+	"text-embedding-3-large": {Embed: 0.135},
+	"voyage-2":               {Embed: 0.105},
+	"cohere-embed-v3":        {Embed: 0.210},
+	"whisper-1":              {Transcription: 0.006},
+	"tts-1":                  {Speech: 15.00},
+	"tts-1-hd":               {Speech: 30.00},
+}
+
+// Table holds the current prices
+type Table struct {
+	rdb redis.UniversalClient
+
+	mu         sync.RWMutex
+	prices     map[string]Price
+	loaded     time.Time
+	refreshing bool
+}
+
+// NewTable creates a table that loads prices from rdb; nil keeps the defaults
+func NewTable(rdb redis.UniversalClient) *Table {
+	return &Table{rdb: rdb, prices: DefaultPrices}
+}
+
+// ChatCost is the cost of a chat completion
+func (t *Table) ChatCost(model string, promptTokens, completionTokens int) float64 {
+	p := t.price(model)
+	input, output := p.ChatInput, p.ChatOutput
+	if input == 0 {
+		input = fallback.ChatInput
+	}
+	if output == 0 {
+		output = fallback.ChatOutput
+	}
+	return (float64(promptTokens)*input + float64(completionTokens)*output) / 1e6
+}
+
+// BatchDiscount is the share of the chat price charged for requests run
+// through a provider's batch API (OpenAI Batch, Anthropic Message Batches)
+const BatchDiscount = 0.5
+
+// BatchChatCost is the cost of a chat completion run through a provider's
+// batch API
+func (t *Table) BatchChatCost(model string, promptTokens, completionTokens int) float64 {
+	return t.ChatCost(model, promptTokens, completionTokens) * BatchDiscount
+}
+
+// EmbedCost is the cost of embedding tokens
+func (t *Table) EmbedCost(model string, tokens int) float64 {
+	price := t.price(model).Embed
+	if price == 0 {
+		price = fallback.Embed
+	}
+	return float64(tokens) * price / 1e6
+}
+
+// TranscriptionCost is the cost of transcribing seconds of audio
+func (t *Table) TranscriptionCost(model string, seconds float64) float64 {
+	price := t.price(model).Transcription
+	if price == 0 {
+		price = fallback.Transcription
+	}
+	return seconds / 60 * price
+}
+
+// SpeechCost is the cost of synthesizing speech from characters of text
+func (t *Table) SpeechCost(model string, characters int) float64 {
+	price := t.price(model).Speech
+	if price == 0 {
+		price = fallback.Speech
+	}
+	return float64(characters) * price / 1e6
+}
+
+func (t *Table) price(model string) Price {
+	t.mu.Lock()
+	if t.rdb != nil && !t.refreshing && time.Since(t.loaded) > RefreshInterval {
+		t.refreshing = true
+		go t.refresh()
+	}
+	p := t.prices[model]
+	t.mu.Unlock()
+	return p
+}
+
+func (t *Table) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	prices := DefaultPrices
+	raw, err := t.rdb.Get(ctx, RedisKey).Bytes()
+	switch {
+	case err == redis.Nil:
+		// The pricing service has not saved prices yet
+	case err != nil:
+		log.Printf("Failed to load prices: %v", err)
+	default:
+		var loaded map[string]Price
+		if err := json.Unmarshal(raw, &loaded); err != nil {
+			log.Printf("Invalid prices in %s: %v", RedisKey, err)
+		} else {
+			prices = loaded
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prices = prices
+	t.loaded = time.Now()
+	t.refreshing = false
+}
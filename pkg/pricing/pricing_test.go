@@ -0,0 +1,52 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+)
+
+func TestChatCost(t *testing.T) {
+	table := NewTable(nil)
+
+	// gpt-4o: $5.25 in, $15.75 out per million
+	if got, want := table.ChatCost("gpt-4o", 1000, 500), 0.0131250; math.Abs(got-want) > 1e-9 {
+		t.Errorf("gpt-4o cost = %f, want %f", got, want)
+	}
+	// Unknown models use the pricing service fallbacks
+	if got, want := table.ChatCost("unknown", 1000000, 0), 10.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("fallback cost = %f, want %f", got, want)
+	}
+}
+
+func TestBatchChatCost(t *testing.T) {
+	table := NewTable(nil)
+
+	// Half of gpt-4o's 5.25 + 15.75 per million tokens
+	if got, want := table.BatchChatCost("gpt-4o", 1000000, 1000000), 10.5; math.Abs(got-want) > 1e-9 {
+		t.Errorf("gpt-4o batch cost = %f, want %f", got, want)
+	}
+}
+
+func TestEmbedCost(t *testing.T) {
+	table := NewTable(nil)
+
+	if got, want := table.EmbedCost("voyage-2", 2000000), 0.21; math.Abs(got-want) > 1e-9 {
+		t.Errorf("voyage-2 cost = %f, want %f", got, want)
+	}
+}
+
+func TestAudioCost(t *testing.T) {
+	table := NewTable(nil)
+
+	// whisper-1: $0.006 per minute
+	if got, want := table.TranscriptionCost("whisper-1", 90), 0.009; math.Abs(got-want) > 1e-9 {
+		t.Errorf("whisper-1 cost = %f, want %f", got, want)
+	}
+	// tts-1-hd: $30 per million characters
+	if got, want := table.SpeechCost("tts-1-hd", 1000), 0.03; math.Abs(got-want) > 1e-9 {
+		t.Errorf("tts-1-hd cost = %f, want %f", got, want)
+	}
+	if got, want := table.SpeechCost("unknown", 1000000), 15.0; math.Abs(got-want) > 1e-9 {
+		t.Errorf("fallback speech cost = %f, want %f", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth types, as classified by the rate-limiter service
+const (
+	AuthJWT       = "jwt"
+	AuthAPIKey    = "api_key"
+	AuthAnonymous = "anonymous"
+)
+
+// Window is the period limits apply to
+const Window = time.Minute
+
+// defaultLimits mirror the rate-limiter service defaults (requests per minute
+// by path and auth type). Paths not listed are not limited locally.
+var defaultLimits = map[string]map[string]int{
+	"/v1/chat/completions": {AuthJWT: 60, AuthAPIKey: 30, AuthAnonymous: 5},
+	"/v1/completions":      {AuthJWT: 60, AuthAPIKey: 30, AuthAnonymous: 5},
+	"/v1/embeddings":       {AuthJWT: 120, AuthAPIKey: 60, AuthAnonymous: 10},
+	"/v1/agentic":          {AuthJWT: 30, AuthAPIKey: 15, AuthAnonymous: 3},
+}
+
+// AuthType classifies an Authorization header the way the service does
+func AuthType(authorization string) string {
+	switch {
+	case strings.HasPrefix(authorization, "Bearer "):
+		return AuthJWT
+	case strings.HasPrefix(authorization, "tvo_"):
+		return AuthAPIKey
+	default:
+		return AuthAnonymous
+	}
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Local is an in-process fixed-window limiter keyed by credential and path
+type Local struct {
+	mu      sync.Mutex
+	limits  map[string]map[string]int
+	windows map[string]*window
+	now     func() time.Time
+}
+
+// NewLocal creates a Local limiter with the default limits
+func NewLocal() *Local {
+	return &Local{
+		limits:  defaultLimits,
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// Allow counts a request against its window
+func (l *Local) Allow(authorization, path string) Decision {
+	authType := AuthType(authorization)
+	limit, ok := l.limits[path][authType]
+	if !ok {
+		return Decision{Allowed: true, Source: SourceLocal, Ticket: &Ticket{source: SourceLocal}}
+	}
+
+	// Anonymous callers share one window per path
+	key := path + "|" + authType
+	if authType != AuthAnonymous {
+		sum := sha256.Sum256([]byte(authorization))
+		key += "|" + hex.EncodeToString(sum[:8])
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= Window {
+		l.sweep(now)
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	d := Decision{Source: SourceLocal, Limit: limit, Reset: w.start.Add(Window).Sub(now)}
+	if w.count >= limit {
+		d.RetryAfter = d.Reset
+		return d
+	}
+	w.count++
+	d.Allowed = true
+	d.Remaining = limit - w.count
+	d.Ticket = &Ticket{source: SourceLocal, localKey: key, localWindow: w.start}
+	return d
+}
+
+// refund gives back a request counted in the window starting at start
+func (l *Local) refund(key string, start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w := l.windows[key]; w != nil && w.start.Equal(start) && w.count > 0 {
+		w.count--
+	}
+}
+
+// sweep drops expired windows once the map grows; called with mu held
+func (l *Local) sweep(now time.Time) {
+	if len(l.windows) < 10000 {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= Window {
+			delete(l.windows, key)
+		}
+	}
+}
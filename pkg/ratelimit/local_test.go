@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLocalAllow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := NewLocal()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if d := l.Allow("", "/v1/chat/completions"); !d.Allowed {
+			t.Fatalf("anonymous request %d limited", i+1)
+		}
+	}
+	d := l.Allow("", "/v1/chat/completions")
+	if d.Allowed {
+		t.Fatal("6th anonymous request allowed")
+	}
+	if d.RetryAfter != time.Minute || d.Source != SourceLocal || d.Limit != 5 || d.Remaining != 0 {
+		t.Errorf("got %+v", d)
+	}
+
+	now = now.Add(20 * time.Second)
+	d = l.Allow("Bearer a", "/v1/chat/completions")
+	if !d.Allowed || d.Limit != 60 || d.Remaining != 59 || d.Reset != time.Minute {
+		t.Errorf("got %+v", d)
+	}
+	if d := l.Allow("", "/v1/chat/completions"); d.RetryAfter != 40*time.Second {
+		t.Errorf("Retry-After %s, want the window reset in 40s", d.RetryAfter)
+	}
+
+	// Unlisted paths are not limited
+	for i := 0; i < 100; i++ {
+		if d := l.Allow("", "/v1/models"); !d.Allowed {
+			t.Fatal("unlisted path limited")
+		}
+	}
+
+	now = now.Add(40 * time.Second)
+	if d := l.Allow("", "/v1/chat/completions"); !d.Allowed {
+		t.Error("window did not reset")
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	Decision{Allowed: false, Source: SourceCentral, Limit: 60, Remaining: 0, Reset: 1500 * time.Millisecond,
+		TokenLimit: 1000, TokensRemaining: -20}.SetHeaders(h)
+	want := map[string]string{
+		"X-RateLimit-Limit":            "60",
+		"X-RateLimit-Remaining":        "0",
+		"X-RateLimit-Reset":            "2",
+		"X-RateLimit-Limit-Tokens":     "1000",
+		"X-RateLimit-Remaining-Tokens": "0",
+		"Retry-After":                  "2",
+	}
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	// Unlimited paths get no headers
+	h = http.Header{}
+	Decision{Allowed: true}.SetHeaders(h)
+	if len(h) != 0 {
+		t.Errorf("unexpected headers %v", h)
+	}
+}
+
+func TestLocalRefund(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	l := &Limiter{local: NewLocal()}
+	l.local.now = func() time.Time { return now }
+
+	// Anonymous agentic callers get 3 requests per minute
+	var last *Ticket
+	for i := 0; i < 3; i++ {
+		last = l.local.Allow("", "/v1/agentic").Ticket
+		last.limiter = l
+	}
+	if d := l.local.Allow("", "/v1/agentic"); d.Allowed {
+		t.Fatal("request over the limit allowed")
+	}
+	last.Refund()
+	if d := l.local.Allow("", "/v1/agentic"); !d.Allowed {
+		t.Error("refunded slot not available")
+	}
+
+	// A refund from an expired window does not affect the new one
+	now = now.Add(Window)
+	for i := 0; i < 3; i++ {
+		l.local.Allow("", "/v1/agentic")
+	}
+	last.Refund()
+	if d := l.local.Allow("", "/v1/agentic"); d.Allowed {
+		t.Error("stale refund freed a slot")
+	}
+}
+
+func TestAuthType(t *testing.T) {
+	cases := map[string]string{
+		"Bearer x": AuthJWT,
+		"tvo_123":  AuthAPIKey,
+		"":         AuthAnonymous,
+		"Basic x":  AuthAnonymous,
+	}
+	for auth, want := range cases {
+		if got := AuthType(auth); got != want {
+			t.Errorf("AuthType(%q) = %s, want %s", auth, got, want)
+		}
+	}
+}
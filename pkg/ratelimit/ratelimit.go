@@ -0,0 +1,278 @@
+// Package ratelimit checks requests against the central rate-limiter service.
+//
+// In central mode every request is checked with RateLimiter.Check. When the
+// service is unreachable or slow the limiter falls back to an in-process
+// window with the same default limits, so an outage degrades to per-instance
+// limiting instead of rejecting all traffic. Local mode skips the service.
+package ratelimit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	pb "github.com/MaksimVF/ZB/services/rate-limiter/pb"
+
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+)
+
+// Modes selected with RATE_LIMIT_MODE
+const (
+	ModeCentral = "central"
+	ModeLocal   = "local"
+)
+
+// Decision sources
+const (
+	SourceCentral = "central"
+	SourceLocal   = "local"
+)
+
+var decisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limit_decisions_total",
+	Help: "Rate limit decisions by source (central, local) and result",
+}, []string{"source", "result"})
+
+var settlementsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rate_limit_settlements_total",
+	Help: "Consume and Refund calls by source and result",
+}, []string{"op", "source", "result"})
+
+// Config configures a Limiter
+type Config struct {
+	Mode string
+	// Address of the rate-limiter service
+	Address string
+	// CertFile is the CA the service certificate is checked against; the
+	// connection is insecure when it cannot be read
+	CertFile string
+	// Timeout bounds a single Check call
+	Timeout time.Duration
+	// Cooldown is how long central checks are skipped after a failure
+	Cooldown time.Duration
+}
+
+// ConfigFromEnv reads RATE_LIMIT_MODE, RATE_LIMITER_ADDR, RATE_LIMITER_CERT,
+// RATE_LIMITER_TIMEOUT_MS and RATE_LIMITER_COOLDOWN_SECONDS
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Mode:     os.Getenv("RATE_LIMIT_MODE"),
+		Address:  os.Getenv("RATE_LIMITER_ADDR"),
+		CertFile: os.Getenv("RATE_LIMITER_CERT"),
+		Timeout:  200 * time.Millisecond,
+		Cooldown: 10 * time.Second,
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModeCentral
+	}
+	if cfg.Address == "" {
+		cfg.Address = "rate-limiter:50051"
+	}
+	if cfg.CertFile == "" {
+		cfg.CertFile = "/certs/rate-limiter.pem"
+	}
+	if ms, err := strconv.Atoi(os.Getenv("RATE_LIMITER_TIMEOUT_MS")); err == nil && ms > 0 {
+		cfg.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	if s, err := strconv.Atoi(os.Getenv("RATE_LIMITER_COOLDOWN_SECONDS")); err == nil && s >= 0 {
+		cfg.Cooldown = time.Duration(s) * time.Second
+	}
+	return cfg
+}
+
+// Decision is the outcome of a check
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Source     string
+	// Ticket is set for allowed requests
+	Ticket *Ticket
+
+	// Request window state; Limit is 0 when the path is not limited
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+	// Token bucket state (central limiter only)
+	TokenLimit      int64
+	TokensRemaining int64
+}
+
+// SetHeaders sets the X-RateLimit-* headers and, for rejected requests,
+// Retry-After
+func (d Decision) SetHeaders(h http.Header) {
+	if d.Limit > 0 {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(d.Reset)))
+	}
+	if d.TokenLimit > 0 {
+		h.Set("X-RateLimit-Limit-Tokens", strconv.FormatInt(d.TokenLimit, 10))
+		h.Set("X-RateLimit-Remaining-Tokens", strconv.FormatInt(max(d.TokensRemaining, 0), 10))
+	}
+	if !d.Allowed {
+		retryAfter := d.RetryAfter
+		if retryAfter == 0 {
+			retryAfter = d.Reset
+		}
+		if retryAfter == 0 {
+			retryAfter = Window
+		}
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+		h.Set("X-RateLimit-Source", d.Source)
+	}
+}
+
+// ceilSeconds rounds up to whole seconds, at least one
+func ceilSeconds(d time.Duration) int {
+	secs := int((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// Limiter checks requests centrally with a local fallback
+type Limiter struct {
+	mode     string
+	client   pb.RateLimiterClient
+	conn     *grpc.ClientConn
+	local    *Local
+	timeout  time.Duration
+	cooldown time.Duration
+	// Unix nanoseconds until which central checks are skipped
+	centralDownUntil atomic.Int64
+}
+
+// New creates a Limiter. In central mode the connection is established
+// lazily, so an unavailable service does not prevent startup.
+func New(cfg Config) (*Limiter, error) {
+	l := &Limiter{
+		mode:     cfg.Mode,
+		local:    NewLocal(),
+		timeout:  cfg.Timeout,
+		cooldown: cfg.Cooldown,
+	}
+
+	switch cfg.Mode {
+	case ModeLocal:
+		return l, nil
+	case ModeCentral:
+	default:
+		return nil, fmt.Errorf("unknown rate limit mode %q (want %s or %s)", cfg.Mode, ModeCentral, ModeLocal)
+	}
+
+	creds, err := loadTLSCredentials(cfg.CertFile)
+	if err != nil {
+		log.Printf("Rate limiter: %v; connecting without TLS", err)
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.Dial(cfg.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("dial rate-limiter: %w", err)
+	}
+	l.conn = conn
+	l.client = pb.NewRateLimiterClient(conn)
+	return l, nil
+}
+
+// NewFromEnv creates a Limiter configured with ConfigFromEnv
+func NewFromEnv() (*Limiter, error) {
+	return New(ConfigFromEnv())
+}
+
+// Mode returns the configured mode
+func (l *Limiter) Mode() string { return l.mode }
+
+// Close closes the connection to the rate-limiter service
+func (l *Limiter) Close() error {
+	if l.conn == nil {
+		return nil
+	}
+	return l.conn.Close()
+}
+
+// Allow checks a request identified by its Authorization header and path;
+// every API version shares the limits of its /v1 path
+func (l *Limiter) Allow(ctx context.Context, authorization, path string) Decision {
+	path = apiversion.Canonical(path)
+	if l.client != nil && time.Now().UnixNano() >= l.centralDownUntil.Load() {
+		d, err := l.checkCentral(ctx, authorization, path)
+		if err == nil {
+			return record(d)
+		}
+		log.Printf("Rate limiter unavailable, using local limits for %s: %v", l.cooldown, err)
+		l.centralDownUntil.Store(time.Now().Add(l.cooldown).UnixNano())
+	}
+	d := l.local.Allow(authorization, path)
+	if d.Ticket != nil {
+		d.Ticket.limiter = l
+	}
+	return record(d)
+}
+
+func (l *Limiter) checkCentral(ctx context.Context, authorization, path string) (Decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	resp, err := l.client.Check(ctx, &pb.CheckRequest{
+		Authorization: authorization,
+		Path:          path,
+	})
+	if err != nil {
+		return Decision{}, err
+	}
+	d := Decision{
+		Allowed:         resp.Allowed,
+		RetryAfter:      time.Duration(resp.RetryAfterSecs) * time.Second,
+		Source:          SourceCentral,
+		Limit:           int(resp.Limit),
+		Remaining:       int(resp.Remaining),
+		Reset:           time.Duration(resp.ResetSecs) * time.Second,
+		TokenLimit:      resp.TokenLimit,
+		TokensRemaining: resp.TokensRemaining,
+	}
+	if d.Allowed {
+		d.Ticket = &Ticket{
+			limiter:   l,
+			source:    SourceCentral,
+			path:      path,
+			clientID:  resp.ClientId,
+			requestID: resp.RequestId,
+		}
+	}
+	return d, nil
+}
+
+func record(d Decision) Decision {
+	result := "allowed"
+	if !d.Allowed {
+		result = "limited"
+	}
+	decisionsTotal.WithLabelValues(d.Source, result).Inc()
+	return d
+}
+
+func loadTLSCredentials(certFile string) (credentials.TransportCredentials, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(certPEM) {
+		return nil, fmt.Errorf("failed to parse certificate %s", certFile)
+	}
+	return credentials.NewTLS(&tls.Config{RootCAs: certPool}), nil
+}
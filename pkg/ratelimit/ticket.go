@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	pb "github.com/MaksimVF/ZB/services/rate-limiter/pb"
+)
+
+// settleTimeout bounds Consume and Refund calls. They run after the response
+// was written, so they do not use the request context.
+const settleTimeout = time.Second
+
+// Ticket identifies an allowed request. Check only pre-authorizes it; once
+// the request is done its actual token usage is charged with Consume, or the
+// request is given back with Refund if it failed. Local limits count requests
+// only, so locally allowed requests can be refunded but not charged.
+//
+// A nil Ticket is valid and does nothing.
+type Ticket struct {
+	limiter   *Limiter
+	source    string
+	path      string
+	clientID  string
+	requestID string
+	// Local window the request was counted in
+	localKey    string
+	localWindow time.Time
+	// Tokens charged so far
+	consumed int64
+}
+
+type ticketKey struct{}
+
+// WithTicket returns ctx carrying t
+func WithTicket(ctx context.Context, t *Ticket) context.Context {
+	return context.WithValue(ctx, ticketKey{}, t)
+}
+
+// TicketFromContext returns the ticket attached by the rate limit middleware
+func TicketFromContext(ctx context.Context) *Ticket {
+	t, _ := ctx.Value(ticketKey{}).(*Ticket)
+	return t
+}
+
+// ClientID is the client the rate-limiter service resolved the credential
+// to, e.g. user:<id> for a JWT; empty for requests allowed locally
+func (t *Ticket) ClientID() string {
+	if t == nil {
+		return ""
+	}
+	return t.clientID
+}
+
+// Consume charges tokens actually used by the request
+func (t *Ticket) Consume(tokens int) {
+	if t == nil || tokens <= 0 || t.source != SourceCentral || t.clientID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	_, err := t.limiter.client.Consume(ctx, &pb.ConsumeRequest{
+		ClientId: t.clientID,
+		Endpoint: t.path,
+		Tokens:   int64(tokens),
+	})
+	if err != nil {
+		log.Printf("Rate limiter: failed to consume %d tokens for %s: %v", tokens, t.clientID, err)
+		settlementsTotal.WithLabelValues("consume", t.source, "error").Inc()
+		return
+	}
+	t.consumed += int64(tokens)
+	settlementsTotal.WithLabelValues("consume", t.source, "ok").Inc()
+}
+
+// Refund gives back a failed request and the tokens charged for it
+func (t *Ticket) Refund() {
+	if t == nil {
+		return
+	}
+
+	if t.source == SourceLocal {
+		if t.localKey != "" {
+			t.limiter.local.refund(t.localKey, t.localWindow)
+			settlementsTotal.WithLabelValues("refund", t.source, "ok").Inc()
+		}
+		return
+	}
+	if t.clientID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	_, err := t.limiter.client.Refund(ctx, &pb.RefundRequest{
+		ClientId:  t.clientID,
+		Endpoint:  t.path,
+		RequestId: t.requestID,
+		Tokens:    t.consumed,
+	})
+	if err != nil {
+		log.Printf("Rate limiter: failed to refund request %s for %s: %v", t.requestID, t.clientID, err)
+		settlementsTotal.WithLabelValues("refund", t.source, "error").Inc()
+		return
+	}
+	t.consumed = 0
+	settlementsTotal.WithLabelValues("refund", t.source, "ok").Inc()
+}
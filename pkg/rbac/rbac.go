@@ -0,0 +1,245 @@
+// Package rbac checks permissions granted by roles.
+//
+// A role is a named set of permissions such as heads.write; "resource.*"
+// grants every action on a resource and "*" grants everything. Handlers
+// require a permission instead of a role, so operators can define roles
+// without code changes. auth-service owns the role definitions in Postgres
+// and publishes them to one Redis hash, announcing every change on a pub/sub
+// channel; services keep an in-memory copy with Roles.
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// Redis keys: roles are stored as JSON in a hash keyed by role name, and
+// every change is announced on a pub/sub channel
+const (
+	RedisKey     = "rbac:roles"
+	RedisChannel = "rbac:roles:changed"
+)
+
+// Permissions checked by the services
+const (
+	// routing-service
+	HeadsWrite      = "heads.write"
+	PolicyWrite     = "policy.write"
+	RolloutsWrite   = "rollouts.write"
+	DiagnosticsRead = "diagnostics.read"
+	// auth-service
+	UsersRead  = "users.read"
+	UsersWrite = "users.write"
+	RolesRead  = "roles.read"
+	RolesWrite = "roles.write"
+)
+
+// Permissions lists every permission checked by the services
+var Permissions = []string{
+	HeadsWrite, PolicyWrite, RolloutsWrite, DiagnosticsRead,
+	UsersRead, UsersWrite, RolesRead, RolesWrite,
+}
+
+// ErrInvalid is returned for roles with an invalid name or permission
+var ErrInvalid = errors.New("invalid role")
+
+var (
+	validName       = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,63}$`)
+	validPermission = regexp.MustCompile(`^(\*|[a-z][a-z0-9_-]*\.(\*|[a-z][a-z0-9_-]*))$`)
+)
+
+// Role is a named set of permissions
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+// Validate checks the role name and the permissions
+func (r Role) Validate() error {
+	if !validName.MatchString(r.Name) {
+		return fmt.Errorf("%w: name %q must be up to 64 lowercase letters, digits, '_' or '-'", ErrInvalid, r.Name)
+	}
+	for _, p := range r.Permissions {
+		if !validPermission.MatchString(p) {
+			return fmt.Errorf("%w: permission %q must be resource.action, resource.* or *", ErrInvalid, p)
+		}
+	}
+	return nil
+}
+
+// Grants reports whether the role has a permission
+func (r Role) Grants(permission string) bool {
+	resource, _, _ := strings.Cut(permission, ".")
+	for _, p := range r.Permissions {
+		if p == "*" || p == permission || p == resource+".*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Defaults are the roles before any are defined: the roles of auth-service
+// users (user, admin, superadmin) and of the routing admin API (operator,
+// viewer). An admin now has every permission an operator has.
+func Defaults() []Role {
+	return []Role{
+		{Name: "superadmin", Description: "Everything", Permissions: []string{"*"}},
+		{Name: "admin", Description: "Users, routing policy, heads and rollouts", Permissions: []string{
+			UsersRead, UsersWrite, RolesRead, PolicyWrite, HeadsWrite, RolloutsWrite, DiagnosticsRead,
+		}},
+		{Name: "operator", Description: "Heads and rollouts", Permissions: []string{HeadsWrite, RolloutsWrite}},
+		{Name: "viewer", Description: "Read-only", Permissions: []string{}},
+		{Name: "user", Description: "API user", Permissions: []string{}},
+	}
+}
+
+// Publish replaces the roles in Redis with roles and notifies all services
+func Publish(ctx context.Context, rdb redis.UniversalClient, roles []Role) error {
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, RedisKey)
+	for _, role := range roles {
+		raw, err := json.Marshal(role)
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, RedisKey, role.Name, raw)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to publish roles: %w", err)
+	}
+	return rdb.Publish(ctx, RedisChannel, time.Now().Unix()).Err()
+}
+
+// Roles answers permission checks from an in-memory copy of the roles
+type Roles struct {
+	rdb redis.UniversalClient
+
+	mu    sync.RWMutex
+	roles map[string]Role
+}
+
+// New creates roles that start out as defaults. A nil rdb keeps them for
+// good; otherwise Load and Watch replace them with the published ones.
+func New(rdb redis.UniversalClient, defaults ...Role) *Roles {
+	r := &Roles{rdb: rdb, roles: make(map[string]Role, len(defaults))}
+	for _, role := range defaults {
+		r.roles[role.Name] = role
+	}
+	return r
+}
+
+// Allows reports whether a role has a permission. Unknown roles have none.
+func (r *Roles) Allows(role, permission string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.roles[role].Grants(permission)
+}
+
+// List returns the roles sorted by name
+func (r *Roles) List() []Role {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Role, 0, len(r.roles))
+	for _, role := range r.roles {
+		list = append(list, role)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Set replaces the roles, e.g. after they are read from Postgres
+func (r *Roles) Set(roles []Role) {
+	m := make(map[string]Role, len(roles))
+	for _, role := range roles {
+		m[role.Name] = role
+	}
+	r.mu.Lock()
+	r.roles = m
+	r.mu.Unlock()
+}
+
+// Load replaces the roles with the published ones. Nothing published yet
+// keeps the current roles.
+func (r *Roles) Load(ctx context.Context) error {
+	if r.rdb == nil {
+		return nil
+	}
+	entries, err := r.rdb.HGetAll(ctx, RedisKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load roles: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	roles := make([]Role, 0, len(entries))
+	for name, raw := range entries {
+		var role Role
+		if err := json.Unmarshal([]byte(raw), &role); err != nil {
+			log.Printf("Skipping invalid role %s: %v", name, err)
+			continue
+		}
+		role.Name = name
+		roles = append(roles, role)
+	}
+	r.Set(roles)
+	return nil
+}
+
+// Watch reloads the roles on change notifications and, as a fallback for
+// missed messages, every interval until ctx is cancelled
+func (r *Roles) Watch(ctx context.Context, interval time.Duration) {
+	if r.rdb == nil {
+		return
+	}
+	sub := r.rdb.Subscribe(ctx, RedisChannel)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+			case <-ticker.C:
+			}
+
+			if err := r.Load(ctx); err != nil {
+				log.Printf("Failed to reload roles: %v", err)
+			}
+		}
+	}()
+}
+
+// Require lets requests through whose role, as roleOf reads it from the
+// request the authentication middleware has run on, has the permission;
+// others get 403
+func (r *Roles) Require(permission string, roleOf func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !r.Allows(roleOf(req), permission) {
+				apierror.Write(w, http.StatusForbidden, "forbidden: requires permission "+permission)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestGrants(t *testing.T) {
+	role := Role{Name: "oncall", Permissions: []string{"heads.write", "rollouts.*"}}
+	for permission, want := range map[string]bool{
+		HeadsWrite:      true,
+		RolloutsWrite:   true,
+		"rollouts.read": true,
+		PolicyWrite:     false,
+		"heads.read":    false,
+	} {
+		if got := role.Grants(permission); got != want {
+			t.Errorf("Grants(%s) = %v", permission, got)
+		}
+	}
+	if !(Role{Permissions: []string{"*"}}).Grants(RolesWrite) {
+		t.Error("* does not grant roles.write")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, role := range Defaults() {
+		if err := role.Validate(); err != nil {
+			t.Errorf("%s: %v", role.Name, err)
+		}
+	}
+	for _, role := range []Role{
+		{Name: "On Call"},
+		{Name: "oncall", Permissions: []string{"heads"}},
+		{Name: "oncall", Permissions: []string{"*.write"}},
+		{Name: "oncall", Permissions: []string{"heads.write.all"}},
+	} {
+		if err := role.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: err = %v", role, err)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	roles := New(nil, Defaults()...)
+	tests := []struct {
+		role, permission string
+		want             bool
+	}{
+		{"admin", HeadsWrite, true},
+		{"admin", PolicyWrite, true},
+		{"admin", RolesWrite, false},
+		{"operator", RolloutsWrite, true},
+		{"operator", PolicyWrite, false},
+		{"viewer", HeadsWrite, false},
+		{"superadmin", RolesWrite, true},
+		{"unknown", HeadsWrite, false},
+		{"", HeadsWrite, false},
+	}
+	for _, tt := range tests {
+		if got := roles.Allows(tt.role, tt.permission); got != tt.want {
+			t.Errorf("Allows(%q, %s) = %v", tt.role, tt.permission, got)
+		}
+	}
+}
+
+func TestPublishLoad(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	roles := New(rdb, Defaults()...)
+	// Nothing published yet keeps the defaults
+	if err := roles.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !roles.Allows("operator", HeadsWrite) {
+		t.Fatal("defaults lost before the first publish")
+	}
+
+	published := []Role{
+		{Name: "operator", Permissions: []string{RolloutsWrite}},
+		{Name: "oncall", Permissions: []string{"heads.*"}},
+	}
+	if err := Publish(ctx, rdb, published); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if roles.Allows("operator", HeadsWrite) || !roles.Allows("oncall", HeadsWrite) || roles.Allows("admin", HeadsWrite) {
+		t.Errorf("roles = %+v", roles.List())
+	}
+
+	// Publishing replaces the roles, so deleted ones are gone
+	if err := Publish(ctx, rdb, published[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if list := roles.List(); len(list) != 1 || list[0].Name != "operator" {
+		t.Errorf("roles = %+v", list)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	roles := New(nil, Defaults()...)
+	handler := roles.Require(PolicyWrite, func(r *http.Request) string {
+		return r.Header.Get("X-Role")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for role, want := range map[string]int{"admin": http.StatusNoContent, "operator": http.StatusForbidden, "": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPut, "/api/routing/policy", nil)
+		req.Header.Set("X-Role", role)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("role %q: status = %d, want %d", role, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Package redisconn connects services to Redis in standalone, Sentinel or
+// Cluster mode, configured from the environment:
+//
+//	REDIS_MODE               standalone (default), sentinel or cluster
+//	REDIS_ADDR               comma-separated host:port list: the server, the
+//	                         sentinels or cluster seed nodes (default redis:6379)
+//	REDIS_MASTER_NAME        Sentinel master name (default mymaster)
+//	REDIS_USERNAME           ACL user
+//	REDIS_PASSWORD           password
+//	REDIS_SENTINEL_PASSWORD  password of the sentinels, if they have one
+//	REDIS_DB                 database number, not supported by Cluster
+//	REDIS_TLS                true to connect with TLS
+//	REDIS_TLS_CA             CA bundle to verify the servers with (default
+//	                         system roots)
+//	REDIS_TLS_CERT/KEY       client certificate for mTLS
+//	REDIS_VAULT_PATH         Vault secret, e.g. secret/data/redis, whose
+//	                         username and password fields replace
+//	                         REDIS_USERNAME and REDIS_PASSWORD; read with
+//	                         VAULT_ADDR and VAULT_TOKEN
+//
+// Clients are redis.UniversalClient, so code works unchanged in every mode as
+// long as multi-key commands and scripts keep their keys in one hash slot.
+package redisconn
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/tlsutil"
+)
+
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// DefaultAddr is used when REDIS_ADDR is unset
+const DefaultAddr = "redis:6379"
+
+// DefaultMasterName is the Sentinel master name when REDIS_MASTER_NAME is unset
+const DefaultMasterName = "mymaster"
+
+// vaultTimeout bounds reading the credentials from Vault
+const vaultTimeout = 10 * time.Second
+
+// Config describes a Redis deployment. Zero pool settings keep the go-redis
+// defaults.
+type Config struct {
+	Mode             string
+	Addrs            []string
+	MasterName       string
+	Username         string
+	Password         string
+	SentinelPassword string
+	DB               int
+
+	TLS         bool
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	VaultPath  string
+	VaultAddr  string
+	VaultToken string
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+	IdleTimeout  time.Duration
+	MaxConnAge   time.Duration
+}
+
+// FromEnv reads the configuration described in the package documentation
+func FromEnv() Config {
+	cfg := Config{
+		Mode:             strings.ToLower(os.Getenv("REDIS_MODE")),
+		Addrs:            splitAddrs(os.Getenv("REDIS_ADDR")),
+		MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+		Username:         os.Getenv("REDIS_USERNAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+		TLSCAFile:        os.Getenv("REDIS_TLS_CA"),
+		TLSCertFile:      os.Getenv("REDIS_TLS_CERT"),
+		TLSKeyFile:       os.Getenv("REDIS_TLS_KEY"),
+		VaultPath:        os.Getenv("REDIS_VAULT_PATH"),
+		VaultAddr:        os.Getenv("VAULT_ADDR"),
+		VaultToken:       os.Getenv("VAULT_TOKEN"),
+	}
+	cfg.DB, _ = strconv.Atoi(os.Getenv("REDIS_DB"))
+	cfg.TLS, _ = strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	return cfg
+}
+
+func splitAddrs(s string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(s, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Options resolves the configuration into go-redis options, reading the
+// credentials from Vault and the TLS files
+func (c Config) Options(ctx context.Context) (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            c.Addrs,
+		Username:         c.Username,
+		Password:         c.Password,
+		SentinelPassword: c.SentinelPassword,
+		PoolSize:         c.PoolSize,
+		MinIdleConns:     c.MinIdleConns,
+		DialTimeout:      c.DialTimeout,
+		ReadTimeout:      c.ReadTimeout,
+		WriteTimeout:     c.WriteTimeout,
+		PoolTimeout:      c.PoolTimeout,
+		IdleTimeout:      c.IdleTimeout,
+		MaxConnAge:       c.MaxConnAge,
+	}
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{DefaultAddr}
+	}
+
+	switch c.Mode {
+	case "", ModeStandalone:
+		if len(opts.Addrs) > 1 {
+			return nil, fmt.Errorf("redis: standalone mode takes one address, got %d", len(opts.Addrs))
+		}
+		opts.DB = c.DB
+	case ModeSentinel:
+		opts.MasterName = c.MasterName
+		if opts.MasterName == "" {
+			opts.MasterName = DefaultMasterName
+		}
+		opts.DB = c.DB
+	case ModeCluster:
+		if c.DB != 0 {
+			return nil, fmt.Errorf("redis: cluster mode has no database %d", c.DB)
+		}
+	default:
+		return nil, fmt.Errorf("redis: unknown mode %q", c.Mode)
+	}
+
+	if c.VaultPath != "" {
+		username, password, err := c.vaultCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if username != "" {
+			opts.Username = username
+		}
+		opts.Password = password
+	}
+
+	if c.TLS {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if c.TLSCAFile == "" {
+		config := &tls.Config{MinVersion: tls.VersionTLS12}
+		if c.TLSCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("redis: failed to load client certificate: %w", err)
+			}
+			config.Certificates = []tls.Certificate{cert}
+		}
+		return config, nil
+	}
+	config, err := tlsutil.ClientConfig(c.TLSCertFile, c.TLSKeyFile, c.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return config, nil
+}
+
+type vaultSecretResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// vaultCredentials reads username and password from a KV secret. KV v2
+// nests the fields in another data object.
+func (c Config) vaultCredentials(ctx context.Context) (username, password string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, vaultTimeout)
+	defer cancel()
+
+	url := strings.TrimRight(c.VaultAddr, "/") + "/v1/" + strings.TrimLeft(c.VaultPath, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("X-Vault-Token", c.VaultToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("redis: vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var secret vaultSecretResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&secret); err != nil && resp.StatusCode < 300 {
+		return "", "", fmt.Errorf("redis: invalid vault response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", "", fmt.Errorf("redis: vault returned status %d: %s", resp.StatusCode, strings.Join(secret.Errors, "; "))
+	}
+
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		fields = nested
+	}
+	password, _ = fields["password"].(string)
+	username, _ = fields["username"].(string)
+	if password == "" {
+		return "", "", fmt.Errorf("redis: vault secret %s has no password", c.VaultPath)
+	}
+	return username, password, nil
+}
+
+// New creates a client for the configured mode. Connections are made lazily,
+// so Redis does not have to be up yet.
+func New(ctx context.Context, c Config) (redis.UniversalClient, error) {
+	opts, err := c.Options(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Mode {
+	case ModeSentinel:
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case ModeCluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return redis.NewClient(opts.Simple()), nil
+	}
+}
+
+// MustNew is New for package-level clients; it panics on invalid configuration
+func MustNew(c Config) redis.UniversalClient {
+	client, err := New(context.Background(), c)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
@@ -0,0 +1,88 @@
+package redisconn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("REDIS_MODE", "Sentinel")
+	t.Setenv("REDIS_ADDR", "s1:26379, s2:26379,")
+	t.Setenv("REDIS_DB", "2")
+	t.Setenv("REDIS_TLS", "true")
+
+	cfg := FromEnv()
+	if cfg.Mode != ModeSentinel || cfg.DB != 2 || !cfg.TLS {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if want := []string{"s1:26379", "s2:26379"}; !reflect.DeepEqual(cfg.Addrs, want) {
+		t.Errorf("addrs = %v, want %v", cfg.Addrs, want)
+	}
+
+	opts, err := cfg.Options(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.MasterName != DefaultMasterName || opts.DB != 2 || opts.TLSConfig == nil {
+		t.Errorf("options = %+v", opts)
+	}
+}
+
+func TestNewModes(t *testing.T) {
+	ctx := context.Background()
+	client, err := New(ctx, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := client.(*redis.Client); !ok || c.Options().Addr != DefaultAddr {
+		t.Errorf("standalone client = %T", client)
+	}
+
+	client, err = New(ctx, Config{Mode: ModeCluster, Addrs: []string{"n1:6379"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		t.Errorf("cluster client = %T", client)
+	}
+
+	for _, cfg := range []Config{
+		{Mode: "replicated"},
+		{Mode: ModeCluster, DB: 1},
+		{Addrs: []string{"a:6379", "b:6379"}},
+	} {
+		if _, err := New(ctx, cfg); err == nil {
+			t.Errorf("config %+v: no error", cfg)
+		}
+	}
+}
+
+func TestVaultCredentials(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/redis" || r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"username":"svc","password":"s3cret"}}}`))
+	}))
+	defer vault.Close()
+
+	cfg := Config{Password: "env", VaultAddr: vault.URL, VaultPath: "secret/data/redis", VaultToken: "token"}
+	opts, err := cfg.Options(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Username != "svc" || opts.Password != "s3cret" {
+		t.Errorf("credentials = %q/%q", opts.Username, opts.Password)
+	}
+
+	cfg.VaultToken = "wrong"
+	if _, err := cfg.Options(context.Background()); err == nil {
+		t.Error("no error for a denied vault request")
+	}
+}
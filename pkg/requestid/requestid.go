@@ -0,0 +1,142 @@
+// Package requestid gives every request an ID at the HTTP edge and carries it
+// to the other services, so the log lines of one request can be found in
+// tail, gateway, routing-service, head and the providers.
+//
+// The ID is returned to clients in X-Request-ID and travels in the
+// x-request-id gRPC metadata key and the X-Request-ID NATS message header.
+// Services put it in the request_id field of their log lines.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+)
+
+// Header is the HTTP and NATS header with the request ID
+const Header = "X-Request-ID"
+
+// metadataKey is Header in gRPC metadata, which is lower case
+const metadataKey = "x-request-id"
+
+// maxLength bounds IDs taken from clients; longer ones are replaced
+const maxLength = 128
+
+type contextKey struct{}
+
+// New returns a random request ID
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewContext returns ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID of ctx, or "" without one
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// valid accepts IDs from callers that are short and printable, so they are
+// safe to log and to send back
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware keeps a valid X-Request-ID sent by the client, e.g. a load
+// balancer in front, and generates one otherwise. The ID is put in the request
+// context and the response header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !valid(id) {
+			id = New()
+			r.Header.Set(Header, id)
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// Inject writes the request ID of ctx into message headers, e.g. the Header
+// of a nats.Msg
+func Inject(ctx context.Context, header map[string][]string) {
+	if id := FromContext(ctx); id != "" {
+		http.Header(header).Set(Header, id)
+	}
+}
+
+// Extract returns ctx with the request ID from message headers, generating
+// one when the publisher sent none
+func Extract(ctx context.Context, header map[string][]string) context.Context {
+	id := http.Header(header).Get(Header)
+	if !valid(id) {
+		id = New()
+	}
+	return NewContext(ctx, id)
+}
+
+// DialOption sends the request ID of the call's context in metadata
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(clientHandler{})
+}
+
+// ServerOption puts the request ID from the metadata of incoming calls into
+// their context, generating one for callers that sent none
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(serverHandler{})
+}
+
+// The handlers only tag RPCs; the context returned by TagRPC is the one the
+// call runs with, before metadata is sent on the client and before the
+// handler runs on the server
+
+type clientHandler struct{ noopHandler }
+
+func (clientHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, metadataKey, id)
+	}
+	return ctx
+}
+
+type serverHandler struct{ noopHandler }
+
+func (serverHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(metadataKey); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if !valid(id) {
+		id = New()
+	}
+	return NewContext(ctx, id)
+}
+
+type noopHandler struct{}
+
+func (noopHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (noopHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+
+func (noopHandler) HandleConn(context.Context, stats.ConnStats) {}
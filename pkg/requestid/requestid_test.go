@@ -0,0 +1,103 @@
+package requestid
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+	serve := func(id string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if id != "" {
+			req.Header.Set(Header, id)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get(Header); got != seen {
+			t.Errorf("response ID %q, context ID %q", got, seen)
+		}
+		return seen
+	}
+
+	if id := serve("lb-123"); id != "lb-123" {
+		t.Errorf("client ID replaced by %q", id)
+	}
+	if id := serve(""); len(id) != 32 {
+		t.Errorf("generated ID %q", id)
+	}
+	for _, bad := range []string{"a\nb", strings.Repeat("x", maxLength+1)} {
+		if id := serve(bad); id == bad {
+			t.Errorf("invalid ID %q kept", bad)
+		}
+	}
+}
+
+// idServer records the request ID of the calls it serves
+type idServer struct {
+	healthpb.UnimplementedHealthServer
+	ids chan string
+}
+
+func (s *idServer) Check(ctx context.Context, _ *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.ids <- FromContext(ctx)
+	return &healthpb.HealthCheckResponse{}, nil
+}
+
+func TestGRPC(t *testing.T) {
+	lis := bufconn.Listen(1 << 16)
+	srv := grpc.NewServer(ServerOption())
+	ids := &idServer{ids: make(chan string, 1)}
+	healthpb.RegisterHealthServer(srv, ids)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		DialOption(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	if _, err := client.Check(NewContext(context.Background(), "req-1"), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-ids.ids; id != "req-1" {
+		t.Errorf("server saw %q, want req-1", id)
+	}
+
+	// Calls without an ID, e.g. from background jobs, still get one
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-ids.ids; id == "" {
+		t.Error("no ID generated for a call without one")
+	}
+}
+
+func TestMessageHeaders(t *testing.T) {
+	header := map[string][]string{}
+	Inject(NewContext(context.Background(), "req-2"), header)
+	if id := FromContext(Extract(context.Background(), header)); id != "req-2" {
+		t.Errorf("ID %q after NATS headers, headers %v", id, header)
+	}
+	if id := FromContext(Extract(context.Background(), nil)); id == "" {
+		t.Error("no ID generated for a message without one")
+	}
+}
@@ -0,0 +1,128 @@
+// Package residency keeps requests of a tenant inside the regions its data
+// may be processed in.
+//
+// auth-service owns the data residency of each user and publishes it to Redis
+// under KeyPrefix+<client ID>, where the client ID is user:<id> or
+// key:<api key>, the IDs the rate-limiter resolves plans by. A residency is a
+// comma-separated list of regions, e.g. "eu" or "eu,ch"; an empty one allows
+// every region. The gateway checks it against provider regions and
+// routing-service against head regions. Requests that no allowed region can
+// serve are refused with 451 Unavailable For Legal Reasons.
+package residency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// KeyPrefix is the prefix of the Redis keys auth-service publishes residencies to
+const KeyPrefix = "data_residency:"
+
+// MetadataKey carries the residency in routing request metadata
+const MetadataKey = "data_residency"
+
+// Code is the error code of refused requests
+const Code = "data_residency_violation"
+
+var (
+	// ErrViolation is returned when no allowed region can serve a request
+	ErrViolation = errors.New("no region allowed by the data residency policy can serve the request")
+	// ErrInvalid wraps validation failures
+	ErrInvalid = errors.New("invalid data residency")
+)
+
+var validRegion = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// Parse splits a comma-separated residency into regions, lowercased and
+// without blanks or duplicates
+func Parse(s string) []string {
+	var regions []string
+	seen := make(map[string]bool)
+	for _, region := range strings.Split(s, ",") {
+		region = strings.ToLower(strings.TrimSpace(region))
+		if region == "" || seen[region] {
+			continue
+		}
+		seen[region] = true
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+// Format joins regions into the stored form
+func Format(regions []string) string {
+	return strings.Join(regions, ",")
+}
+
+// Validate checks the region names
+func Validate(regions []string) error {
+	for _, region := range regions {
+		if !validRegion.MatchString(region) {
+			return fmt.Errorf("%w: region %q must be up to 32 lowercase letters, digits or '-'", ErrInvalid, region)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether a request may be processed in region. An empty
+// residency allows every region; otherwise a target without a region is
+// refused, since nobody can tell where it processes data.
+func Allows(regions []string, region string) bool {
+	if len(regions) == 0 {
+		return true
+	}
+	for _, allowed := range regions {
+		if strings.EqualFold(allowed, region) && region != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the residency of the first client ID that has one, e.g. the
+// API key before its user. Clients without one have an empty residency.
+func Lookup(ctx context.Context, rdb redis.UniversalClient, clientIDs ...string) ([]string, error) {
+	for _, clientID := range clientIDs {
+		if clientID == "" {
+			continue
+		}
+		value, err := rdb.Get(ctx, KeyPrefix+clientID).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load data residency: %w", err)
+		}
+		return Parse(value), nil
+	}
+	return nil, nil
+}
+
+type contextKey struct{}
+
+// WithRegions returns a copy of ctx carrying the residency of its request
+func WithRegions(ctx context.Context, regions []string) context.Context {
+	return context.WithValue(ctx, contextKey{}, regions)
+}
+
+// FromContext returns the residency of the request, empty when none is set
+func FromContext(ctx context.Context) []string {
+	regions, _ := ctx.Value(contextKey{}).([]string)
+	return regions
+}
+
+// Error is the 451 response for requests refused by the residency
+func Error(regions []string) *apierror.Error {
+	e := apierror.New(http.StatusUnavailableForLegalReasons,
+		fmt.Sprintf("this request cannot be served in the regions your organization allows data to be processed in (%s)", Format(regions)))
+	e.Type = apierror.TypePermission
+	return e.WithCode(Code)
+}
@@ -0,0 +1,71 @@
+package residency
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	got := Parse(" EU, ch,,eu ")
+	if want := []string{"eu", "ch"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse = %v, want %v", got, want)
+	}
+	if got := Parse(""); got != nil {
+		t.Errorf("Parse(\"\") = %v, want nil", got)
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		regions []string
+		region  string
+		want    bool
+	}{
+		{nil, "us", true},
+		{nil, "", true},
+		{[]string{"eu"}, "eu", true},
+		{[]string{"eu"}, "EU", true},
+		{[]string{"eu"}, "us", false},
+		{[]string{"eu"}, "", false},
+		{[]string{"eu", "ch"}, "ch", true},
+	}
+	for _, tt := range tests {
+		if got := Allows(tt.regions, tt.region); got != tt.want {
+			t.Errorf("Allows(%v, %q) = %v, want %v", tt.regions, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate([]string{"eu", "us-east-1"}); err != nil {
+		t.Errorf("valid regions: %v", err)
+	}
+	if err := Validate([]string{"eu west"}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Validate(%q) = %v, want ErrInvalid", "eu west", err)
+	}
+}
+
+func TestError(t *testing.T) {
+	w := httptest.NewRecorder()
+	Error([]string{"eu"}).Write(w)
+	if w.Code != 451 {
+		t.Errorf("status = %d, want 451", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, Code) || !strings.Contains(body, "permission_error") {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if got := FromContext(ctx); got != nil {
+		t.Errorf("FromContext(empty) = %v, want nil", got)
+	}
+	if got := FromContext(WithRegions(ctx, []string{"eu"})); !reflect.DeepEqual(got, []string{"eu"}) {
+		t.Errorf("FromContext = %v, want [eu]", got)
+	}
+}
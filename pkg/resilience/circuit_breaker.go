@@ -0,0 +1,220 @@
+// Package resilience has keyed circuit breakers and rate limiters for
+// services that guard many upstreams or clients with one instance.
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit states
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// CircuitBreakerConfig is the default for every key; SetThreshold and the
+// other setters override it per key
+type CircuitBreakerConfig struct {
+	// Consecutive failures that open the circuit
+	Threshold int
+	// How long the circuit stays open before a probe is let through
+	ResetTimeout time.Duration
+	// How long a half-open probe may take before another one is allowed
+	HalfOpenDuration time.Duration
+}
+
+// DefaultCircuitBreakerConfig opens after 3 failures for 30s
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Threshold:        3,
+		ResetTimeout:     30 * time.Second,
+		HalfOpenDuration: 10 * time.Second,
+	}
+}
+
+// CircuitBreaker tracks a circuit per key, e.g. per upstream service. After
+// Threshold consecutive failures the circuit opens and Allow refuses calls
+// for ResetTimeout; then one probe at a time is allowed, and its success
+// closes the circuit while its failure opens it again.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	defaults  CircuitBreakerConfig
+	overrides map[string]CircuitBreakerConfig
+	circuits  map[string]*circuit
+	now       func() time.Time
+}
+
+type circuit struct {
+	failures     int // consecutive
+	lastFailure  time.Time
+	probeUntil   time.Time // a half-open probe is in flight until then
+	successCount int
+	failureCount int
+	rejected     int
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	defaults := DefaultCircuitBreakerConfig()
+	if config.Threshold <= 0 {
+		config.Threshold = defaults.Threshold
+	}
+	if config.ResetTimeout <= 0 {
+		config.ResetTimeout = defaults.ResetTimeout
+	}
+	if config.HalfOpenDuration <= 0 {
+		config.HalfOpenDuration = defaults.HalfOpenDuration
+	}
+	return &CircuitBreaker{
+		defaults:  config,
+		overrides: make(map[string]CircuitBreakerConfig),
+		circuits:  make(map[string]*circuit),
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call to key may proceed. The caller reports its
+// outcome with Success or Fail.
+func (cb *CircuitBreaker) Allow(key string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.circuits[key]
+	if !ok {
+		return true
+	}
+	config := cb.config(key)
+	now := cb.now()
+	switch cb.state(c, config, now) {
+	case StateOpen:
+		c.rejected++
+		return false
+	case StateHalfOpen:
+		if now.Before(c.probeUntil) {
+			c.rejected++
+			return false
+		}
+		c.probeUntil = now.Add(config.HalfOpenDuration)
+	}
+	return true
+}
+
+// Fail records a failed call
+func (cb *CircuitBreaker) Fail(key string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c := cb.circuit(key)
+	c.failures++
+	c.failureCount++
+	c.lastFailure = cb.now()
+	c.probeUntil = time.Time{}
+}
+
+// Success records a successful call, which closes the circuit
+func (cb *CircuitBreaker) Success(key string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c := cb.circuit(key)
+	c.failures = 0
+	c.successCount++
+	c.probeUntil = time.Time{}
+}
+
+// State returns StateClosed, StateOpen or StateHalfOpen
+func (cb *CircuitBreaker) State(key string) string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.circuits[key]
+	if !ok {
+		return StateClosed
+	}
+	return cb.state(c, cb.config(key), cb.now())
+}
+
+// Reset forgets the state and overrides of key
+func (cb *CircuitBreaker) Reset(key string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	delete(cb.circuits, key)
+	delete(cb.overrides, key)
+}
+
+// Metrics returns the state and counters of every key seen
+func (cb *CircuitBreaker) Metrics() map[string]interface{} {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	now := cb.now()
+	metrics := make(map[string]interface{}, len(cb.circuits))
+	for key, c := range cb.circuits {
+		config := cb.config(key)
+		metrics[key] = map[string]interface{}{
+			"state":              cb.state(c, config, now),
+			"failures":           c.failures,
+			"last_failure":       c.lastFailure,
+			"success_count":      c.successCount,
+			"failure_count":      c.failureCount,
+			"rejected":           c.rejected,
+			"threshold":          config.Threshold,
+			"reset_timeout":      config.ResetTimeout,
+			"half_open_duration": config.HalfOpenDuration,
+		}
+	}
+	return metrics
+}
+
+// SetThreshold overrides the failures that open the circuit of key
+func (cb *CircuitBreaker) SetThreshold(key string, threshold int) {
+	cb.override(key, func(c *CircuitBreakerConfig) { c.Threshold = threshold })
+}
+
+// SetResetTimeout overrides how long the circuit of key stays open
+func (cb *CircuitBreaker) SetResetTimeout(key string, resetTimeout time.Duration) {
+	cb.override(key, func(c *CircuitBreakerConfig) { c.ResetTimeout = resetTimeout })
+}
+
+// SetHalfOpenDuration overrides how long a probe of key may take
+func (cb *CircuitBreaker) SetHalfOpenDuration(key string, halfOpenDuration time.Duration) {
+	cb.override(key, func(c *CircuitBreakerConfig) { c.HalfOpenDuration = halfOpenDuration })
+}
+
+func (cb *CircuitBreaker) override(key string, set func(*CircuitBreakerConfig)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	config := cb.config(key)
+	set(&config)
+	cb.overrides[key] = config
+}
+
+// config, circuit and state must be called with mu held
+func (cb *CircuitBreaker) config(key string) CircuitBreakerConfig {
+	if config, ok := cb.overrides[key]; ok {
+		return config
+	}
+	return cb.defaults
+}
+
+func (cb *CircuitBreaker) circuit(key string) *circuit {
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = &circuit{}
+		cb.circuits[key] = c
+	}
+	return c
+}
+
+func (cb *CircuitBreaker) state(c *circuit, config CircuitBreakerConfig, now time.Time) string {
+	if c.failures < config.Threshold {
+		return StateClosed
+	}
+	if now.Sub(c.lastFailure) < config.ResetTimeout {
+		return StateOpen
+	}
+	return StateHalfOpen
+}
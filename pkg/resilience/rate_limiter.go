@@ -0,0 +1,165 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiterConfig is the default for every key; SetThreshold and the other
+// setters override it per key
+type RateLimiterConfig struct {
+	// Requests allowed per ResetTimeout
+	Threshold    int
+	ResetTimeout time.Duration
+	// Requests allowed per BurstDuration
+	BurstLimit    int
+	BurstDuration time.Duration
+}
+
+// DefaultRateLimiterConfig allows 10 requests a minute, at most 5 in 10s
+func DefaultRateLimiterConfig() RateLimiterConfig {
+	return RateLimiterConfig{
+		Threshold:     10,
+		ResetTimeout:  time.Minute,
+		BurstLimit:    5,
+		BurstDuration: 10 * time.Second,
+	}
+}
+
+// RateLimiter counts requests per key, e.g. per client IP, in two fixed
+// windows: ResetTimeout for the sustained rate and BurstDuration for bursts.
+// A request is allowed when both windows have room.
+type RateLimiter struct {
+	mu        sync.Mutex
+	defaults  RateLimiterConfig
+	overrides map[string]RateLimiterConfig
+	clients   map[string]*client
+	now       func() time.Time
+}
+
+type client struct {
+	windowStart time.Time
+	requests    int
+	burstStart  time.Time
+	burst       int
+	lastRequest time.Time
+	rejected    int
+}
+
+func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	defaults := DefaultRateLimiterConfig()
+	if config.Threshold <= 0 {
+		config.Threshold = defaults.Threshold
+	}
+	if config.ResetTimeout <= 0 {
+		config.ResetTimeout = defaults.ResetTimeout
+	}
+	if config.BurstLimit <= 0 {
+		config.BurstLimit = defaults.BurstLimit
+	}
+	if config.BurstDuration <= 0 {
+		config.BurstDuration = defaults.BurstDuration
+	}
+	return &RateLimiter{
+		defaults:  config,
+		overrides: make(map[string]RateLimiterConfig),
+		clients:   make(map[string]*client),
+		now:       time.Now,
+	}
+}
+
+// Allow counts a request from key and reports whether it is within the limits
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	config := rl.config(key)
+	now := rl.now()
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &client{windowStart: now, burstStart: now}
+		rl.clients[key] = c
+	}
+	if now.Sub(c.windowStart) >= config.ResetTimeout {
+		c.windowStart, c.requests = now, 0
+	}
+	if now.Sub(c.burstStart) >= config.BurstDuration {
+		c.burstStart, c.burst = now, 0
+	}
+
+	if c.requests >= config.Threshold || c.burst >= config.BurstLimit {
+		c.rejected++
+		return false
+	}
+	c.requests++
+	c.burst++
+	c.lastRequest = now
+	return true
+}
+
+// Reset forgets the counts and overrides of key
+func (rl *RateLimiter) Reset(key string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	delete(rl.clients, key)
+	delete(rl.overrides, key)
+}
+
+// Metrics returns the counts and limits of every key seen
+func (rl *RateLimiter) Metrics() map[string]interface{} {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	metrics := make(map[string]interface{}, len(rl.clients))
+	for key, c := range rl.clients {
+		config := rl.config(key)
+		metrics[key] = map[string]interface{}{
+			"requests":       c.requests,
+			"last_request":   c.lastRequest,
+			"rejected":       c.rejected,
+			"threshold":      config.Threshold,
+			"reset_timeout":  config.ResetTimeout,
+			"burst_limit":    config.BurstLimit,
+			"burst_duration": config.BurstDuration,
+		}
+	}
+	return metrics
+}
+
+// SetThreshold overrides the requests allowed per ResetTimeout for key
+func (rl *RateLimiter) SetThreshold(key string, threshold int) {
+	rl.override(key, func(c *RateLimiterConfig) { c.Threshold = threshold })
+}
+
+// SetResetTimeout overrides the sustained rate window of key
+func (rl *RateLimiter) SetResetTimeout(key string, resetTimeout time.Duration) {
+	rl.override(key, func(c *RateLimiterConfig) { c.ResetTimeout = resetTimeout })
+}
+
+// SetBurstLimit overrides the requests allowed per BurstDuration for key
+func (rl *RateLimiter) SetBurstLimit(key string, burstLimit int) {
+	rl.override(key, func(c *RateLimiterConfig) { c.BurstLimit = burstLimit })
+}
+
+// SetBurstDuration overrides the burst window of key
+func (rl *RateLimiter) SetBurstDuration(key string, burstDuration time.Duration) {
+	rl.override(key, func(c *RateLimiterConfig) { c.BurstDuration = burstDuration })
+}
+
+func (rl *RateLimiter) override(key string, set func(*RateLimiterConfig)) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	config := rl.config(key)
+	set(&config)
+	rl.overrides[key] = config
+}
+
+// config must be called with mu held
+func (rl *RateLimiter) config(key string) RateLimiterConfig {
+	if config, ok := rl.overrides[key]; ok {
+		return config
+	}
+	return rl.defaults
+}
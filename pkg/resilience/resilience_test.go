@@ -0,0 +1,115 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cb := NewCircuitBreaker(CircuitBreakerConfig{Threshold: 3, ResetTimeout: 30 * time.Second, HalfOpenDuration: 5 * time.Second})
+	cb.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !cb.Allow("head") {
+			t.Fatalf("call %d refused while closed", i+1)
+		}
+		cb.Fail("head")
+	}
+	if cb.Allow("head") || cb.State("head") != StateOpen {
+		t.Fatalf("circuit %s after 3 failures, want open", cb.State("head"))
+	}
+	if !cb.Allow("other") {
+		t.Fatal("circuits are per key")
+	}
+
+	// One probe at a time once the reset timeout passed
+	now = now.Add(30 * time.Second)
+	if cb.State("head") != StateHalfOpen || !cb.Allow("head") {
+		t.Fatalf("probe refused in state %s", cb.State("head"))
+	}
+	if cb.Allow("head") {
+		t.Fatal("second probe allowed while the first is in flight")
+	}
+
+	// A failed probe opens the circuit again, a successful one closes it
+	cb.Fail("head")
+	if cb.Allow("head") {
+		t.Fatal("allowed after a failed probe")
+	}
+	now = now.Add(30 * time.Second)
+	cb.Allow("head")
+	cb.Success("head")
+	if cb.State("head") != StateClosed || !cb.Allow("head") {
+		t.Fatalf("circuit %s after a successful probe", cb.State("head"))
+	}
+
+	m := cb.Metrics()["head"].(map[string]interface{})
+	if m["failure_count"] != 4 || m["success_count"] != 1 || m["failures"] != 0 {
+		t.Errorf("metrics %v", m)
+	}
+}
+
+func TestCircuitBreakerOverrides(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{})
+	cb.SetThreshold("flaky", 1)
+	cb.Fail("flaky")
+	cb.Fail("steady")
+	if cb.Allow("flaky") || !cb.Allow("steady") {
+		t.Error("threshold override not applied per key")
+	}
+
+	cb.Reset("flaky")
+	if !cb.Allow("flaky") {
+		t.Error("reset circuit still open")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	rl := NewRateLimiter(RateLimiterConfig{Threshold: 4, ResetTimeout: time.Minute, BurstLimit: 2, BurstDuration: 10 * time.Second})
+	rl.now = func() time.Time { return now }
+
+	if !rl.Allow("a") || !rl.Allow("a") {
+		t.Fatal("requests within the burst refused")
+	}
+	if rl.Allow("a") {
+		t.Fatal("third request in the burst window allowed")
+	}
+	if !rl.Allow("b") {
+		t.Fatal("limits are per key")
+	}
+
+	now = now.Add(10 * time.Second)
+	if !rl.Allow("a") || !rl.Allow("a") {
+		t.Fatal("requests refused after the burst window")
+	}
+	now = now.Add(10 * time.Second)
+	if rl.Allow("a") {
+		t.Fatal("request over the threshold allowed")
+	}
+
+	now = now.Add(40 * time.Second)
+	if !rl.Allow("a") {
+		t.Fatal("request refused after the reset timeout")
+	}
+
+	m := rl.Metrics()["a"].(map[string]interface{})
+	if m["requests"] != 1 || m["rejected"] != 2 {
+		t.Errorf("metrics %v", m)
+	}
+}
+
+func TestRateLimiterOverrides(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{})
+	rl.Allow("ip")
+	rl.SetThreshold("ip", 2)
+	if !rl.Allow("ip") || rl.Allow("ip") {
+		t.Error("threshold override does not count earlier requests")
+	}
+
+	rl.Reset("ip")
+	if !rl.Allow("ip") {
+		t.Error("reset key still limited")
+	}
+}
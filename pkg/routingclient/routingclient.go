@@ -0,0 +1,240 @@
+// Package routingclient is the gRPC client of routing-service shared by
+// gateway, tail and head. Calls are retried with jittered backoff while
+// routing-service is unavailable, a circuit breaker fails them fast once it
+// keeps being unavailable, and GetAllHeads responses are reused for a short
+// time so callers polling the head list do not each reach routing-service.
+package routingclient
+
+import (
+	"context"
+	"crypto/tls"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/compress"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/resilience"
+	"github.com/MaksimVF/ZB/pkg/tracing"
+)
+
+// breakerKey is the circuit of routing-service in the client's breaker
+const breakerKey = "routing-service"
+
+var (
+	callsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "routing_client_calls_total",
+		Help: "Calls to routing-service by calling service, method and result (success, error, unavailable, rejected)",
+	}, []string{"service", "method", "result"})
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "routing_client_retries_total",
+		Help: "Calls to routing-service retried after it was unavailable",
+	}, []string{"service", "method"})
+)
+
+// Config of a Client; only Addr is required
+type Config struct {
+	// Addr of routing-service, e.g. routing-service:50051
+	Addr string
+	// Service is the name of the calling service in metrics
+	Service string
+	// Certs is the identity presented to routing-service, see Credentials;
+	// nil connects without a client certificate
+	Certs *certs.Manager
+	// MaxAttempts per call, default 3
+	MaxAttempts int
+	// Backoff is the wait before the first retry, default 100ms; it doubles
+	// up to MaxBackoff, default 2s, and is jittered
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// HeadsTTL is how long a GetAllHeads response is reused, default 2s;
+	// negative disables the cache
+	HeadsTTL time.Duration
+	// Breaker opens the circuit after consecutive unavailable calls; the
+	// zero value is resilience.DefaultCircuitBreakerConfig
+	Breaker resilience.CircuitBreakerConfig
+	// DialOptions are added to the client's own
+	DialOptions []grpc.DialOption
+}
+
+// Client is a routingpb.RoutingServiceClient with retries, circuit breaking
+// and a cached GetAllHeads
+type Client struct {
+	routingpb.RoutingServiceClient
+	conn *grpc.ClientConn
+
+	service     string
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	breaker     *resilience.CircuitBreaker
+	headsTTL    time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	heads   *routingpb.GetAllHeadsResponse
+	headsAt time.Time
+}
+
+// New connects to routing-service. The connection is established lazily by
+// gRPC, so routing-service does not have to be up yet.
+func New(cfg Config) (*Client, error) {
+	c := newClient(cfg)
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(Credentials(cfg.Certs)),
+		grpc.WithChainUnaryInterceptor(c.intercept),
+		tracing.DialOption(),
+		requestid.DialOption(),
+		compress.DialOption(),
+	}, cfg.DialOptions...)
+	conn, err := grpc.Dial(cfg.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	c.RoutingServiceClient = routingpb.NewRoutingServiceClient(conn)
+	return c, nil
+}
+
+func newClient(cfg Config) *Client {
+	c := &Client{
+		service:     cfg.Service,
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.Backoff,
+		maxBackoff:  cfg.MaxBackoff,
+		breaker:     resilience.NewCircuitBreaker(cfg.Breaker),
+		headsTTL:    cfg.HeadsTTL,
+		now:         time.Now,
+	}
+	if c.maxAttempts <= 0 {
+		c.maxAttempts = 3
+	}
+	if c.backoff <= 0 {
+		c.backoff = 100 * time.Millisecond
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = 2 * time.Second
+	}
+	if c.headsTTL == 0 {
+		c.headsTTL = 2 * time.Second
+	}
+	return c
+}
+
+// Credentials secures the connection to routing-service. routing-service has
+// its own CA, so it is only verified when manager has a SPIFFE identity,
+// which puts both in one trust domain; then manager's certificate is
+// presented and only routing-service's ID is trusted. Otherwise, and when
+// manager is nil, the connection is encrypted without verification.
+func Credentials(manager *certs.Manager) credentials.TransportCredentials {
+	if manager != nil && manager.ID("routing-service") != "" {
+		return credentials.NewTLS(manager.ClientConfig("", manager.AuthorizeService("routing-service")))
+	}
+	return credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+}
+
+// Conn returns the underlying connection, e.g. for readiness checks
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// BreakerState returns the state of the circuit to routing-service
+func (c *Client) BreakerState() string {
+	return c.breaker.State(breakerKey)
+}
+
+// GetAllHeads returns the heads, reusing a response younger than HeadsTTL.
+// The response is shared between callers and must not be modified.
+func (c *Client) GetAllHeads(ctx context.Context, in *routingpb.GetAllHeadsRequest, opts ...grpc.CallOption) (*routingpb.GetAllHeadsResponse, error) {
+	if c.headsTTL < 0 {
+		return c.RoutingServiceClient.GetAllHeads(ctx, in, opts...)
+	}
+
+	c.mu.Lock()
+	if c.heads != nil && c.now().Sub(c.headsAt) < c.headsTTL {
+		heads := c.heads
+		c.mu.Unlock()
+		return heads, nil
+	}
+	c.mu.Unlock()
+
+	resp, err := c.RoutingServiceClient.GetAllHeads(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.heads, c.headsAt = resp, c.now()
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// intercept retries calls routing-service could not take and keeps the
+// circuit: an open circuit fails calls with Unavailable right away, so
+// callers fall back to their local decisions without waiting
+func (c *Client) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	name := path.Base(method)
+	if !c.breaker.Allow(breakerKey) {
+		callsTotal.WithLabelValues(c.service, name, "rejected").Inc()
+		return status.Error(codes.Unavailable, "routing-service circuit is open")
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unavailable || attempt >= c.maxAttempts {
+			break
+		}
+		retriesTotal.WithLabelValues(c.service, name).Inc()
+		timer := time.NewTimer(c.wait(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	switch status.Code(err) {
+	case codes.OK:
+		c.breaker.Success(breakerKey)
+		callsTotal.WithLabelValues(c.service, name, "success").Inc()
+	case codes.Unavailable, codes.DeadlineExceeded:
+		c.breaker.Fail(breakerKey)
+		callsTotal.WithLabelValues(c.service, name, "unavailable").Inc()
+	case codes.Canceled:
+		// The caller gave up; nothing is known about routing-service
+		callsTotal.WithLabelValues(c.service, name, "error").Inc()
+	default:
+		// routing-service answered, so the circuit is fine
+		c.breaker.Success(breakerKey)
+		callsTotal.WithLabelValues(c.service, name, "error").Inc()
+	}
+	return err
+}
+
+// wait returns the jittered backoff before retry number attempt: between
+// half and all of Backoff doubled per earlier retry, capped at MaxBackoff
+func (c *Client) wait(attempt int) time.Duration {
+	d := c.backoff << (attempt - 1)
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
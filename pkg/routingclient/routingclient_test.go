@@ -0,0 +1,135 @@
+package routingclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"github.com/MaksimVF/ZB/pkg/resilience"
+)
+
+// invoker fails with the given errors in turn, then succeeds
+func invoker(calls *int, errs ...error) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func testClient() *Client {
+	return newClient(Config{
+		Service:    "test",
+		Backoff:    time.Millisecond,
+		MaxBackoff: 2 * time.Millisecond,
+		Breaker:    resilience.CircuitBreakerConfig{Threshold: 2, ResetTimeout: time.Hour},
+	})
+}
+
+const method = "/routing.RoutingService/GetRoutingDecision"
+
+func TestRetriesUnavailable(t *testing.T) {
+	c := testClient()
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	calls := 0
+	if err := c.intercept(context.Background(), method, nil, nil, nil, invoker(&calls, unavailable, unavailable)); err != nil {
+		t.Fatalf("err = %v after a third attempt", err)
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+
+	// Other errors are the answer of routing-service and are not retried
+	calls = 0
+	err := c.intercept(context.Background(), method, nil, nil, nil, invoker(&calls, status.Error(codes.NotFound, "no head")))
+	if status.Code(err) != codes.NotFound || calls != 1 {
+		t.Errorf("err = %v after %d calls", err, calls)
+	}
+}
+
+func TestCircuitOpens(t *testing.T) {
+	c := testClient()
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	for i := 0; i < 2; i++ {
+		calls := 0
+		err := c.intercept(context.Background(), method, nil, nil, nil, invoker(&calls, unavailable, unavailable, unavailable))
+		if status.Code(err) != codes.Unavailable || calls != 3 {
+			t.Fatalf("call %d: err = %v after %d attempts", i+1, err, calls)
+		}
+	}
+	if c.BreakerState() != resilience.StateOpen {
+		t.Fatalf("circuit %s, want open", c.BreakerState())
+	}
+
+	calls := 0
+	err := c.intercept(context.Background(), method, nil, nil, nil, invoker(&calls))
+	if status.Code(err) != codes.Unavailable || calls != 0 {
+		t.Errorf("open circuit: err = %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryStopsWithContext(t *testing.T) {
+	c := testClient()
+	c.backoff, c.maxBackoff = time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err := c.intercept(ctx, method, nil, nil, nil, invoker(&calls, status.Error(codes.Unavailable, "down"), nil))
+	if status.Code(err) != codes.Unavailable || calls != 1 || time.Since(start) > time.Second {
+		t.Errorf("err = %v after %d calls in %s", err, calls, time.Since(start))
+	}
+}
+
+func TestWait(t *testing.T) {
+	c := newClient(Config{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second, 70: time.Second} {
+		if d := c.wait(attempt); d < max/2 || d > max {
+			t.Errorf("wait(%d) = %s, want %s to %s", attempt, d, max/2, max)
+		}
+	}
+}
+
+type headLister struct {
+	routingpb.RoutingServiceClient
+	calls int
+}
+
+func (l *headLister) GetAllHeads(context.Context, *routingpb.GetAllHeadsRequest, ...grpc.CallOption) (*routingpb.GetAllHeadsResponse, error) {
+	l.calls++
+	return &routingpb.GetAllHeadsResponse{Heads: []*routingpb.HeadService{{HeadId: "head-1"}}}, nil
+}
+
+func TestGetAllHeadsCached(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lister := &headLister{}
+	c := newClient(Config{HeadsTTL: 5 * time.Second})
+	c.RoutingServiceClient = lister
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		resp, err := c.GetAllHeads(context.Background(), &routingpb.GetAllHeadsRequest{})
+		if err != nil || len(resp.GetHeads()) != 1 {
+			t.Fatalf("GetAllHeads = %v, %v", resp, err)
+		}
+	}
+	if lister.calls != 1 {
+		t.Errorf("routing-service called %d times within the TTL", lister.calls)
+	}
+
+	now = now.Add(5 * time.Second)
+	c.GetAllHeads(context.Background(), &routingpb.GetAllHeadsRequest{})
+	if lister.calls != 2 {
+		t.Errorf("expired response reused")
+	}
+}
@@ -0,0 +1,177 @@
+// Package sse keeps server-sent event streams alive through proxies. Load
+// balancers and proxies close connections that stay idle for their timeout,
+// commonly 60s, which cuts long completions, e.g. while a model reasons
+// before its first token. A Stream writes a heartbeat comment (": ping"),
+// which clients ignore, whenever nothing else was written for the interval.
+//
+// A write that fails means the client is gone. The Stream then cancels the
+// upstream call, so it stops at once instead of running on until its next
+// chunk or its deadline.
+//
+// The interval is SSE_HEARTBEAT_INTERVAL, 15s by default; 0 disables
+// heartbeats. Heartbeats stop with the context, e.g. at the request deadline.
+package sse
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Heartbeat is the comment written to idle streams
+const Heartbeat = ": ping\n\n"
+
+// DefaultInterval is well below the usual proxy idle timeouts
+const DefaultInterval = 15 * time.Second
+
+var (
+	heartbeatsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sse_heartbeats_total",
+		Help: "Heartbeat comments written to idle server-sent event streams",
+	})
+	disconnectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sse_client_disconnects_total",
+		Help: "Server-sent event streams whose client went away, by what noticed it (heartbeat, write)",
+	}, []string{"detected_by"})
+)
+
+// Interval returns SSE_HEARTBEAT_INTERVAL, or DefaultInterval when it is
+// unset or invalid
+func Interval() time.Duration {
+	v := os.Getenv("SSE_HEARTBEAT_INTERVAL")
+	if v == "" {
+		return DefaultInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return DefaultInterval
+	}
+	return d
+}
+
+// Stream serializes writes to an event stream with its heartbeats. Handlers
+// write events through it instead of to the ResponseWriter.
+type Stream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	last    time.Time
+	err     error
+	written bool
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Start starts heartbeats on w every interval until ctx is done or Close is
+// called. Heartbeats send the headers, so start the stream once the upstream
+// call succeeded and errors can no longer be returned as a status. cancel,
+// which may be nil, is called when the client is found gone and should
+// cancel the upstream call.
+func Start(ctx context.Context, w http.ResponseWriter, interval time.Duration, cancel context.CancelFunc) *Stream {
+	if cancel == nil {
+		cancel = func() {}
+	}
+	s := &Stream{
+		w:      w,
+		cancel: cancel,
+		last:   time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	s.flusher, _ = w.(http.Flusher)
+	if interval > 0 {
+		go s.run(ctx, interval)
+	} else {
+		close(s.done)
+	}
+	return s
+}
+
+// Write writes p to the stream and flushes it. After a failed write every
+// write fails with the same error.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(p, "write")
+}
+
+// Err returns the error that ended the stream, nil while the client is there
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Written reports whether anything, a heartbeat included, was written, i.e.
+// whether the headers are sent and errors can only be written as events
+func (s *Stream) Written() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.written
+}
+
+// Close stops the heartbeats. Writes after Close still go through but keep
+// nothing alive, e.g. a final event.
+func (s *Stream) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+func (s *Stream) write(p []byte, writer string) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.written = true
+	if err == nil && s.flusher != nil {
+		s.flusher.Flush()
+	}
+	if err != nil {
+		s.err = err
+		s.cancel()
+		disconnectsTotal.WithLabelValues(writer).Inc()
+		return n, err
+	}
+	s.last = time.Now()
+	return n, nil
+}
+
+// run writes a heartbeat each time the stream was idle for interval
+func (s *Stream) run(ctx context.Context, interval time.Duration) {
+	defer close(s.done)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		s.mu.Lock()
+		next := interval
+		if idle := time.Since(s.last); idle < interval {
+			next = interval - idle
+		} else if _, err := s.write([]byte(Heartbeat), "heartbeat"); err == nil {
+			heartbeatsTotal.Inc()
+		}
+		failed := s.err != nil
+		s.mu.Unlock()
+		if failed {
+			return
+		}
+		timer.Reset(next)
+	}
+}
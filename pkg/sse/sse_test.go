@@ -0,0 +1,105 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatWhenIdle(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := Start(context.Background(), rec, 10*time.Millisecond, nil)
+	time.Sleep(55 * time.Millisecond)
+	stream.Close()
+
+	if n := strings.Count(rec.Body.String(), Heartbeat); n < 2 {
+		t.Errorf("%d heartbeats in 55ms at 10ms", n)
+	}
+	if !rec.Flushed || !stream.Written() {
+		t.Error("heartbeats not flushed")
+	}
+}
+
+func TestNoHeartbeatWhileWriting(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := Start(context.Background(), rec, 50*time.Millisecond, nil)
+	for i := 0; i < 20; i++ {
+		if _, err := stream.Write([]byte("data: {}\n\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stream.Close()
+
+	body := rec.Body.String()
+	if strings.Contains(body, Heartbeat) {
+		t.Errorf("heartbeat on an active stream: %q", body)
+	}
+	if strings.Count(body, "data: {}") != 20 {
+		t.Errorf("events lost: %q", body)
+	}
+}
+
+func TestHeartbeatsStopWithContext(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := Start(ctx, rec, 5*time.Millisecond, nil)
+	cancel()
+	stream.Close()
+
+	before := rec.Body.Len()
+	time.Sleep(20 * time.Millisecond)
+	if rec.Body.Len() != before {
+		t.Error("heartbeat after the context was done")
+	}
+}
+
+// goneWriter fails every write like the connection of a client that left
+type goneWriter struct {
+	http.ResponseWriter
+}
+
+var errGone = errors.New("broken pipe")
+
+func (goneWriter) Write([]byte) (int, error) { return 0, errGone }
+
+func TestDisconnectCancels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := Start(context.Background(), goneWriter{httptest.NewRecorder()}, 5*time.Millisecond, cancel)
+	defer stream.Close()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not cancelled after a failed heartbeat")
+	}
+	if !errors.Is(stream.Err(), errGone) {
+		t.Errorf("Err() = %v", stream.Err())
+	}
+	if _, err := stream.Write([]byte("data: {}\n\n")); !errors.Is(err, errGone) {
+		t.Errorf("write after disconnect: %v", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	stream := Start(context.Background(), rec, 0, nil)
+	time.Sleep(10 * time.Millisecond)
+	stream.Close()
+	if rec.Body.Len() != 0 || stream.Written() {
+		t.Errorf("heartbeats with interval 0: %q", rec.Body.String())
+	}
+}
+
+func TestInterval(t *testing.T) {
+	for v, want := range map[string]time.Duration{"": DefaultInterval, "5s": 5 * time.Second, "0": 0, "-1s": DefaultInterval, "soon": DefaultInterval} {
+		t.Setenv("SSE_HEARTBEAT_INTERVAL", v)
+		if got := Interval(); got != want {
+			t.Errorf("SSE_HEARTBEAT_INTERVAL=%q: %s, want %s", v, got, want)
+		}
+	}
+}
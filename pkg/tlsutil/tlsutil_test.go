@@ -0,0 +1,95 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCA writes a self-signed certificate that is its own CA
+func writeCA(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestServerConfig(t *testing.T) {
+	certFile, keyFile := writeCA(t)
+	config, err := ServerConfig(certFile, keyFile, certFile, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Certificates) != 1 || config.ClientCAs == nil ||
+		config.ClientAuth != tls.RequireAndVerifyClientCert || config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("config %+v", config)
+	}
+
+	if _, err := ServerConfig(certFile, keyFile, filepath.Join(t.TempDir(), "missing.pem"), tls.NoClientCert); err == nil {
+		t.Error("missing CA accepted")
+	}
+	if _, err := ServerConfig(certFile, certFile, certFile, tls.NoClientCert); err == nil {
+		t.Error("certificate accepted as key")
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	certFile, keyFile := writeCA(t)
+	config, err := ClientConfig(certFile, keyFile, certFile)
+	if err != nil || len(config.Certificates) != 1 || config.RootCAs == nil {
+		t.Fatalf("config %+v, err %v", config, err)
+	}
+
+	// Without a client certificate only the CA is loaded
+	config, err = ClientConfig("", "", certFile)
+	if err != nil || len(config.Certificates) != 0 || config.RootCAs == nil {
+		t.Errorf("CA only: config %+v, err %v", config, err)
+	}
+}
+
+func TestClientConfigFromPEM(t *testing.T) {
+	certFile, keyFile := writeCA(t)
+	certPEM, _ := os.ReadFile(certFile)
+	keyPEM, _ := os.ReadFile(keyFile)
+
+	if _, err := ClientConfigFromPEM(certPEM, keyPEM, certPEM); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ClientConfigFromPEM(certPEM, keyPEM, []byte("not a certificate")); err == nil {
+		t.Error("invalid CA accepted")
+	}
+}
@@ -0,0 +1,134 @@
+// Package tokenizer estimates token counts the way tiktoken-based tokenizers do.
+//
+// Text is split with the cl100k/o200k pre-tokenization pattern and each piece
+// is costed by how many bytes the model family's BPE merges into one token. Counts match
+// tiktoken closely for English prose and code without shipping BPE ranks.
+package tokenizer
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Encoding describes how a model family tokenizes text
+type Encoding struct {
+	Name string
+	// Bytes of an ASCII piece that typically merge into one token
+	bytesPerToken float64
+	// Chat format overhead (see OpenAI's "counting tokens for chat" guide)
+	tokensPerMessage int
+	tokensPerName    int
+	replyPriming     int
+}
+
+// Message is a chat message to count
+type Message struct {
+	Role    string
+	Name    string
+	Content string
+}
+
+var (
+	cl100k = &Encoding{Name: "cl100k_base", bytesPerToken: 6.0, tokensPerMessage: 3, tokensPerName: 1, replyPriming: 3}
+	o200k  = &Encoding{Name: "o200k_base", bytesPerToken: 6.5, tokensPerMessage: 3, tokensPerName: 1, replyPriming: 3}
+	claude = &Encoding{Name: "claude", bytesPerToken: 5.0, tokensPerMessage: 4, tokensPerName: 1, replyPriming: 3}
+	llama  = &Encoding{Name: "llama", bytesPerToken: 5.5, tokensPerMessage: 4, tokensPerName: 1, replyPriming: 3}
+)
+
+// families maps model name prefixes to encodings; longer prefixes are listed first
+var families = []struct {
+	prefix   string
+	encoding *Encoding
+}{
+	{"gpt-4o", o200k},
+	{"gpt-4.1", o200k},
+	{"o1", o200k},
+	{"o3", o200k},
+	{"gpt-4", cl100k},
+	{"gpt-3.5", cl100k},
+	{"text-embedding", cl100k},
+	{"claude", claude},
+	{"llama", llama},
+	{"mistral", llama},
+	{"mixtral", llama},
+}
+
+// pieces approximates tiktoken's pre-tokenization regex (Go's RE2 has no lookahead)
+var pieces = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// ForModel returns the encoding for a model; unknown models use cl100k_base.
+// Provider prefixes such as "openai/" are ignored.
+func ForModel(model string) *Encoding {
+	model = strings.ToLower(model)
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	for _, f := range families {
+		if strings.HasPrefix(model, f.prefix) {
+			return f.encoding
+		}
+	}
+	return cl100k
+}
+
+// Count returns the number of tokens in text
+func (e *Encoding) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	total := 0
+	for _, piece := range pieces.FindAllString(text, -1) {
+		total += e.countPiece(piece)
+	}
+	return total
+}
+
+// countPiece costs one pre-tokenized piece. Common words are a single token and
+// longer ASCII pieces split every bytesPerToken bytes; other scripts get about one token per rune
+// (CJK) or per two runes (accented Latin, Cyrillic, ...).
+func (e *Encoding) countPiece(piece string) int {
+	ascii, wide, other := 0, 0, 0
+	for _, r := range piece {
+		switch {
+		case r < utf8.RuneSelf:
+			ascii++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			wide++
+		default:
+			other++
+		}
+	}
+
+	tokens := wide + (other+1)/2
+	if ascii > 0 {
+		tokens += int(math.Ceil(float64(ascii) / e.bytesPerToken))
+	}
+	return tokens
+}
+
+// CountMessages returns the prompt tokens for a chat request, including the
+// per-message formatting overhead and the assistant reply priming
+func (e *Encoding) CountMessages(messages []Message) int {
+	total := e.replyPriming
+	for _, m := range messages {
+		total += e.tokensPerMessage + e.Count(m.Role) + e.Count(m.Content)
+		if m.Name != "" {
+			total += e.tokensPerName + e.Count(m.Name)
+		}
+	}
+	return total
+}
+
+// Count returns the tokens in text for the given model
+func Count(model, text string) int {
+	return ForModel(model).Count(text)
+}
+
+// CountMessages returns the prompt tokens of messages for the given model
+func CountMessages(model string, messages []Message) int {
+	return ForModel(model).CountMessages(messages)
+}
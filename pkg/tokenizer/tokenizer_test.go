@@ -0,0 +1,41 @@
+package tokenizer
+
+import "testing"
+
+func TestForModel(t *testing.T) {
+	cases := map[string]string{
+		"gpt-4o-mini":       "o200k_base",
+		"gpt-4-turbo":       "cl100k_base",
+		"openai/gpt-4o":     "o200k_base",
+		"claude-3-5-sonnet": "claude",
+		"unknown-model":     "cl100k_base",
+	}
+	for model, want := range cases {
+		if got := ForModel(model).Name; got != want {
+			t.Errorf("ForModel(%q) = %s, want %s", model, got, want)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	// tiktoken cl100k_base gives 9 tokens for this sentence
+	got := Count("gpt-4", "The quick brown fox jumps over the lazy dog")
+	if got < 8 || got > 11 {
+		t.Errorf("Count = %d, want about 9", got)
+	}
+
+	if Count("gpt-4", "") != 0 {
+		t.Error("empty text must have no tokens")
+	}
+}
+
+func TestCountMessages(t *testing.T) {
+	// tiktoken's reference example: 2 short messages give 3 + 2*(3+1+1) = 13 with cl100k
+	messages := []Message{
+		{Role: "system", Content: "Hi"},
+		{Role: "user", Content: "Hello"},
+	}
+	if got := CountMessages("gpt-4", messages); got != 13 {
+		t.Errorf("CountMessages = %d, want 13", got)
+	}
+}
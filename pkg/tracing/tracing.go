@@ -0,0 +1,100 @@
+// Package tracing sets up OpenTelemetry the same way in every service so one
+// trace follows a request from the tail HTTP edge through gRPC and NATS to
+// heads and model-proxy.
+//
+// Context travels as W3C traceparent and baggage headers, in HTTP headers,
+// gRPC metadata and NATS message headers. Spans are exported over OTLP/gRPC
+// when OTEL_EXPORTER_OTLP_ENDPOINT is set; without it nothing is exported but
+// incoming trace context is still passed on, so a service without a collector
+// does not break the trace of the others.
+package tracing
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+)
+
+// Init installs the propagator and, when OTEL_EXPORTER_OTLP_ENDPOINT is set,
+// an OTLP exporter for service. OTEL_SERVICE_NAME overrides the name; the
+// exporter and sampler read the other standard OTEL_* variables, e.g.
+// OTEL_EXPORTER_OTLP_INSECURE and OTEL_TRACES_SAMPLER. The returned function
+// flushes spans on shutdown.
+func Init(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		log.Printf("Tracing: OTEL_EXPORTER_OTLP_ENDPOINT not set, propagating trace context without exporting")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		service = name
+	}
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", service)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("Tracing: exporting spans of %s over OTLP", service)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for each request, continuing the trace of
+// the caller's traceparent header
+func Middleware(operation string, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, operation)
+}
+
+// Transport is an http.RoundTripper that sends the trace context along
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// DialOption traces gRPC calls and sends the trace context in metadata
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// ServerOption continues the trace of incoming gRPC calls
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// Inject writes the trace context of ctx into message headers, e.g. the
+// Header of a nats.Msg
+func Inject(ctx context.Context, header map[string][]string) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns ctx with the trace context from message headers
+func Extract(ctx context.Context, header map[string][]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestPropagationWithoutExporter(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	shutdown, err := Init(context.Background(), "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	// The edge continues the caller's trace and passes it on over NATS
+	var outgoing map[string][]string
+	handler := Middleware("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outgoing = map[string][]string{}
+		Inject(r.Context(), outgoing)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", traceparent)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	ctx := Extract(context.Background(), outgoing)
+	if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace ID %q after HTTP and NATS, headers %v", got, outgoing)
+	}
+}
@@ -0,0 +1,141 @@
+package usageevents
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/MaksimVF/ZB/pkg/outbox/jetstream"
+)
+
+// Sink stores a batch of events. Events may be delivered again after a
+// failure, so a Sink must ignore IDs it already has.
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+}
+
+var consumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "usage_events_consumed_total",
+	Help: "Usage events taken from the stream by result (stored, failed, rejected)",
+}, []string{"result"})
+
+// Consumer loads the stream into a Sink in batches. Replicas sharing the
+// Durable name share the work.
+type Consumer struct {
+	JS      nats.JetStreamContext
+	Durable string
+	Sink    Sink
+	// BatchSize is the most events per write, default 500
+	BatchSize int
+	// MaxWait is how long a batch waits to fill up, default 5s
+	MaxWait time.Duration
+	// RetryDelay is how long failed batches wait for redelivery, default 30s
+	RetryDelay time.Duration
+}
+
+// Consume connects to NATS at url and runs c until ctx is cancelled
+func Consume(ctx context.Context, url, name string, c Consumer) error {
+	nc, err := nats.Connect(url, nats.Name(name+"-usage-consumer"), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	if c.JS, err = nc.JetStream(); err != nil {
+		return err
+	}
+	return c.Run(ctx)
+}
+
+// Run consumes until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	batchSize, maxWait, retryDelay := c.BatchSize, c.MaxWait, c.RetryDelay
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if maxWait <= 0 {
+		maxWait = 5 * time.Second
+	}
+	if retryDelay <= 0 {
+		retryDelay = 30 * time.Second
+	}
+
+	for {
+		err := jetstream.EnsureStream(c.JS, Stream, SubjectAll)
+		if err == nil {
+			break
+		}
+		log.Printf("Usage events: setting up stream %s: %v; retrying", Stream, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	sub, err := c.JS.PullSubscribe(SubjectAll, c.Durable,
+		nats.BindStream(Stream),
+		nats.AckExplicit(),
+		// A batch waits for the sink at most this long before redelivery
+		nats.AckWait(maxWait+time.Minute),
+		nats.MaxAckPending(batchSize*4),
+	)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for ctx.Err() == nil {
+		msgs, err := sub.Fetch(batchSize, nats.MaxWait(maxWait))
+		if errors.Is(err, nats.ErrTimeout) || len(msgs) == 0 {
+			continue
+		}
+		if err != nil {
+			log.Printf("Usage events: fetch: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		c.store(ctx, msgs, retryDelay)
+	}
+	return ctx.Err()
+}
+
+// store writes a batch and acknowledges it. Events of an unknown schema are
+// terminated so they do not block the stream; a failed write is retried.
+func (c *Consumer) store(ctx context.Context, msgs []*nats.Msg, retryDelay time.Duration) {
+	events := make([]Event, 0, len(msgs))
+	accepted := make([]*nats.Msg, 0, len(msgs))
+	for _, msg := range msgs {
+		event, err := Decode(msg.Data)
+		if err != nil {
+			log.Printf("Usage events: rejecting %s: %v", msg.Subject, err)
+			msg.Term()
+			consumedTotal.WithLabelValues("rejected").Inc()
+			continue
+		}
+		events = append(events, event)
+		accepted = append(accepted, msg)
+	}
+	if len(events) == 0 {
+		return
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	err := c.Sink.Write(writeCtx, events)
+	cancel()
+	if err != nil {
+		log.Printf("Usage events: writing %d events: %v", len(events), err)
+		for _, msg := range accepted {
+			msg.NakWithDelay(retryDelay)
+		}
+		consumedTotal.WithLabelValues("failed").Add(float64(len(events)))
+		return
+	}
+	for _, msg := range accepted {
+		msg.Ack()
+	}
+	consumedTotal.WithLabelValues("stored").Add(float64(len(events)))
+}
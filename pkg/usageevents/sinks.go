@@ -0,0 +1,165 @@
+package usageevents
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PostgresSchema creates the events table in Postgres
+const PostgresSchema = `
+CREATE TABLE IF NOT EXISTS usage_events (
+    id                text PRIMARY KEY,
+    schema_version    int NOT NULL,
+    time              timestamptz NOT NULL,
+    service           text NOT NULL,
+    request_id        text NOT NULL DEFAULT '',
+    user_id           text NOT NULL,
+    org_id            text NOT NULL,
+    endpoint          text NOT NULL DEFAULT '',
+    model             text NOT NULL,
+    provider          text NOT NULL,
+    status            text NOT NULL,
+    stream            boolean NOT NULL DEFAULT false,
+    prompt_tokens     int NOT NULL,
+    completion_tokens int NOT NULL,
+    total_tokens      int NOT NULL,
+    cost_usd          double precision NOT NULL,
+    latency_ms        bigint NOT NULL,
+    cache             text NOT NULL DEFAULT '',
+    circuit_breaker   text NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_usage_events_org_time ON usage_events (org_id, time);
+CREATE INDEX IF NOT EXISTS idx_usage_events_model_time ON usage_events (model, time);
+`
+
+const postgresColumns = 19
+
+// PostgresSink inserts events into the usage_events table, skipping IDs it
+// already has
+type PostgresSink struct {
+	DB *sql.DB
+}
+
+func (s PostgresSink) Write(ctx context.Context, events []Event) error {
+	var query strings.Builder
+	query.WriteString(`INSERT INTO usage_events (id, schema_version, time, service, request_id, user_id, org_id,
+		endpoint, model, provider, status, stream, prompt_tokens, completion_tokens, total_tokens, cost_usd,
+		latency_ms, cache, circuit_breaker) VALUES `)
+	args := make([]interface{}, 0, len(events)*postgresColumns)
+	for i, e := range events {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		query.WriteString("(")
+		for j := 0; j < postgresColumns; j++ {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", i*postgresColumns+j+1)
+		}
+		query.WriteString(")")
+		args = append(args, e.ID, e.SchemaVersion, e.Time, e.Service, e.RequestID, e.UserID, e.OrgID,
+			e.Endpoint, e.Model, e.Provider, e.Status, e.Stream, e.PromptTokens, e.CompletionTokens,
+			e.TotalTokens, e.CostUSD, e.LatencyMs, e.Cache, e.CircuitBreaker)
+	}
+	query.WriteString(" ON CONFLICT (id) DO NOTHING")
+	_, err := s.DB.ExecContext(ctx, query.String(), args...)
+	return err
+}
+
+// ClickHouseSchema creates the events table in ClickHouse. ReplacingMergeTree
+// drops redelivered events when parts merge; count with FINAL or uniqExact(id)
+// where duplicates matter.
+const ClickHouseSchema = `
+CREATE TABLE IF NOT EXISTS usage_events (
+    id                String,
+    schema_version    UInt16,
+    time              DateTime64(3, 'UTC'),
+    service           LowCardinality(String),
+    request_id        String,
+    user_id           String,
+    org_id            String,
+    endpoint          LowCardinality(String),
+    model             LowCardinality(String),
+    provider          LowCardinality(String),
+    status            LowCardinality(String),
+    stream            Bool,
+    prompt_tokens     UInt32,
+    completion_tokens UInt32,
+    total_tokens      UInt32,
+    cost_usd          Float64,
+    latency_ms        UInt64,
+    cache             LowCardinality(String),
+    circuit_breaker   LowCardinality(String)
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(time)
+ORDER BY (org_id, time, id)
+`
+
+// ClickHouseSink inserts events through the ClickHouse HTTP interface
+type ClickHouseSink struct {
+	// URL of the HTTP interface, e.g. http://clickhouse:8123
+	URL      string
+	Database string
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+func (s ClickHouseSink) Write(ctx context.Context, events []Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return s.exec(ctx, "INSERT INTO usage_events FORMAT JSONEachRow", &body)
+}
+
+// CreateTable creates the events table if it does not exist
+func (s ClickHouseSink) CreateTable(ctx context.Context) error {
+	return s.exec(ctx, ClickHouseSchema, nil)
+}
+
+func (s ClickHouseSink) exec(ctx context.Context, query string, data io.Reader) error {
+	params := url.Values{}
+	params.Set("query", query)
+	// Times are sent as RFC 3339
+	params.Set("date_time_input_format", "best_effort")
+	if s.Database != "" {
+		params.Set("database", s.Database)
+	}
+	if data == nil {
+		data = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.URL, "/")+"/?"+params.Encode(), data)
+	if err != nil {
+		return err
+	}
+	if s.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.User)
+		req.Header.Set("X-ClickHouse-Key", s.Password)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
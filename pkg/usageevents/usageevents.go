@@ -0,0 +1,213 @@
+// Package usageevents streams a usage event for every completed model request
+// to NATS JetStream for the data warehouse, and loads them from there into
+// Postgres or ClickHouse.
+//
+// Events are published on "usage.completed.v<schema version>" to the
+// USAGE_EVENTS stream, with the event ID as Nats-Msg-Id so a retried publish
+// is stored once. A schema change that only adds fields keeps the version;
+// anything else bumps it, and consumers reject versions they do not know
+// instead of loading them wrong.
+//
+// Unlike billing events these are not written through an outbox: losing a few
+// while NATS is down skews analytics, not invoices, so emitting never blocks
+// or fails a request.
+package usageevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/MaksimVF/ZB/pkg/outbox/jetstream"
+)
+
+// SchemaVersion is the version of Event
+const SchemaVersion = 1
+
+// Stream and subjects
+const (
+	Stream     = "USAGE_EVENTS"
+	SubjectAll = "usage.>"
+)
+
+// Subject is the subject of events of a schema version
+func Subject(version int) string {
+	return "usage.completed.v" + strconv.Itoa(version)
+}
+
+// SchemaHeader carries the schema version of an event
+const SchemaHeader = "Zb-Schema-Version"
+
+// Request statuses
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+	StatusTimeout = "timeout"
+)
+
+// Event is the usage of one completed request
+type Event struct {
+	SchemaVersion int       `json:"schema_version"`
+	ID            string    `json:"id"`
+	Time          time.Time `json:"time"`
+	// Service that served the request, e.g. tail or gateway
+	Service   string `json:"service"`
+	RequestID string `json:"request_id,omitempty"`
+	UserID    string `json:"user_id"`
+	// OrgID is the tenant the usage is billed to; users are their own
+	// tenant until they belong to organizations
+	OrgID    string `json:"org_id"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	// Status is StatusSuccess, StatusError or StatusTimeout
+	Status           string  `json:"status"`
+	Stream           bool    `json:"stream"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	LatencyMs        int64   `json:"latency_ms"`
+	// Cache is hit, miss or partial when a cache was involved
+	Cache string `json:"cache,omitempty"`
+	// CircuitBreaker is the state of the provider's breaker (closed,
+	// half-open, open) when the service has one
+	CircuitBreaker string `json:"circuit_breaker,omitempty"`
+}
+
+var eventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "usage_events_total",
+	Help: "Usage events by result (published, dropped, failed)",
+}, []string{"result"})
+
+// Emitter publishes events in the background
+type Emitter struct {
+	service string
+	js      nats.JetStreamContext
+	events  chan Event
+}
+
+// Start connects to NATS at url and publishes the emitted events of service
+// until ctx is cancelled. Up to buffer events wait while NATS is slow or
+// unreachable; more are dropped. Start does not wait for NATS.
+func Start(ctx context.Context, url, service string, buffer int) (*Emitter, error) {
+	nc, err := nats.Connect(url, nats.Name(service+"-usage"), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if buffer <= 0 {
+		buffer = 10000
+	}
+	e := &Emitter{service: service, js: js, events: make(chan Event, buffer)}
+
+	go func() {
+		defer nc.Close()
+		for {
+			err := jetstream.EnsureStream(js, Stream, SubjectAll)
+			if err == nil {
+				break
+			}
+			log.Printf("Usage events: setting up stream %s: %v; retrying", Stream, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-e.events:
+				e.publish(ctx, event)
+			}
+		}
+	}()
+	return e, nil
+}
+
+// Emit queues an event, filling in its ID, time, schema version and service.
+// It never blocks; a nil Emitter drops the event.
+func (e *Emitter) Emit(event Event) {
+	if e == nil {
+		return
+	}
+	event.SchemaVersion = SchemaVersion
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.Service == "" {
+		event.Service = e.service
+	}
+	if event.OrgID == "" {
+		event.OrgID = event.UserID
+	}
+	if event.TotalTokens == 0 {
+		event.TotalTokens = event.PromptTokens + event.CompletionTokens
+	}
+	select {
+	case e.events <- event:
+	default:
+		eventsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (e *Emitter) publish(ctx context.Context, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		eventsTotal.WithLabelValues("failed").Inc()
+		return
+	}
+	msg := nats.NewMsg(Subject(event.SchemaVersion))
+	msg.Data = data
+	msg.Header.Set(SchemaHeader, strconv.Itoa(event.SchemaVersion))
+
+	// Retries reuse the message ID, so the stream keeps one copy
+	for attempt := 0; attempt < 3; attempt++ {
+		pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err = e.js.PublishMsg(msg, nats.MsgId(event.ID), nats.Context(pubCtx))
+		cancel()
+		if err == nil {
+			eventsTotal.WithLabelValues("published").Inc()
+			return
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Duration(attempt+1) * 200 * time.Millisecond)
+	}
+	log.Printf("Usage events: publish %s: %v", event.ID, err)
+	eventsTotal.WithLabelValues("failed").Inc()
+}
+
+// Decode parses an event of a known schema version
+func Decode(data []byte) (Event, error) {
+	var version struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return Event{}, err
+	}
+	if version.SchemaVersion != SchemaVersion {
+		return Event{}, fmt.Errorf("unsupported usage event schema version %d", version.SchemaVersion)
+	}
+	var event Event
+	err := json.Unmarshal(data, &event)
+	return event, err
+}
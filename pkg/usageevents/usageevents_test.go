@@ -0,0 +1,97 @@
+package usageevents
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEmitFillsEvent(t *testing.T) {
+	e := &Emitter{service: "tail", events: make(chan Event, 1)}
+	e.Emit(Event{UserID: "u1", Model: "gpt-4o", PromptTokens: 10, CompletionTokens: 5})
+
+	event := <-e.events
+	if event.ID == "" || event.Time.IsZero() {
+		t.Fatalf("ID and time not set: %+v", event)
+	}
+	if event.SchemaVersion != SchemaVersion || event.Service != "tail" || event.OrgID != "u1" || event.TotalTokens != 15 {
+		t.Errorf("event = %+v", event)
+	}
+
+	// A full buffer drops instead of blocking
+	e.Emit(Event{UserID: "u1"})
+	e.Emit(Event{UserID: "u2"})
+	if got := (<-e.events).UserID; got != "u1" {
+		t.Errorf("kept %s, want the first event", got)
+	}
+
+	var none *Emitter
+	none.Emit(Event{UserID: "u1"})
+}
+
+func TestDecodeRejectsUnknownVersions(t *testing.T) {
+	data, _ := json.Marshal(Event{SchemaVersion: SchemaVersion, ID: "e1", Model: "gpt-4o"})
+	event, err := Decode(data)
+	if err != nil || event.ID != "e1" || event.Model != "gpt-4o" {
+		t.Fatalf("Decode = %+v, %v", event, err)
+	}
+
+	if _, err := Decode([]byte(`{"schema_version":2,"id":"e2"}`)); err == nil {
+		t.Error("schema version 2 decoded")
+	}
+	if _, err := Decode([]byte(`{"id":"e3"}`)); err == nil {
+		t.Error("event without schema version decoded")
+	}
+}
+
+func TestSubject(t *testing.T) {
+	if got := Subject(1); got != "usage.completed.v1" {
+		t.Errorf("Subject(1) = %s", got)
+	}
+}
+
+func TestClickHouseSink(t *testing.T) {
+	var query string
+	var rows []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		if r.Header.Get("X-ClickHouse-User") != "warehouse" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var e Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			rows = append(rows, e)
+		}
+	}))
+	defer server.Close()
+
+	sink := ClickHouseSink{URL: server.URL, User: "warehouse", Password: "secret"}
+	events := []Event{
+		{SchemaVersion: 1, ID: "e1", Time: time.Now(), Model: "gpt-4o"},
+		{SchemaVersion: 1, ID: "e2", Time: time.Now(), Model: "claude-3"},
+	}
+	if err := sink.Write(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	if query != "INSERT INTO usage_events FORMAT JSONEachRow" {
+		t.Errorf("query = %q", query)
+	}
+	if len(rows) != 2 || rows[1].ID != "e2" {
+		t.Errorf("rows = %+v", rows)
+	}
+
+	sink.User = "other"
+	if err := sink.Write(context.Background(), events); err == nil {
+		t.Error("rejected insert succeeded")
+	}
+}
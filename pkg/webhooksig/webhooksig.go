@@ -0,0 +1,281 @@
+// Package webhooksig signs and verifies inbound webhooks.
+//
+// A sender shares a secret with the receiver per source and sends
+//
+//	X-Webhook-Source:    the source, which selects the secret
+//	X-Webhook-Timestamp: Unix seconds
+//	X-Webhook-Nonce:     a unique value per delivery
+//	X-Webhook-Signature: v1=<hex HMAC-SHA256 of "timestamp.nonce.body">
+//
+// Requests outside the timestamp tolerance are rejected, and a nonce is
+// accepted once within it, so a captured request cannot be replayed. A
+// secret may hold several comma-separated values while it is rotated.
+package webhooksig
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// Headers of a signed request
+const (
+	HeaderSource    = "X-Webhook-Source"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderNonce     = "X-Webhook-Nonce"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Defaults
+const (
+	DefaultTolerance = 5 * time.Minute
+	// MaxBodyBytes caps the body read for verification
+	MaxBodyBytes     = 1 << 20
+	signatureVersion = "v1="
+)
+
+// Reasons a request is rejected, sent as the error code of the 401
+const (
+	ReasonMissingHeaders   = "missing_signature_headers"
+	ReasonUnknownSource    = "unknown_source"
+	ReasonInvalidTimestamp = "invalid_timestamp"
+	ReasonStaleTimestamp   = "stale_timestamp"
+	ReasonInvalidSignature = "invalid_signature"
+	ReasonReplayed         = "replayed_nonce"
+	ReasonBodyTooLarge     = "body_too_large"
+)
+
+var validSource = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ErrUnknownSource is returned by a SecretFunc for sources without a secret
+var ErrUnknownSource = errors.New("unknown webhook source")
+
+// Error is a rejected request; Reason is one of the Reason constants
+type Error struct {
+	Reason  string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Reason + ": " + e.Message }
+
+func reject(reason, format string, args ...interface{}) *Error {
+	return &Error{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// SecretFunc returns the shared secret of a source, or ErrUnknownSource
+type SecretFunc func(ctx context.Context, source string) (string, error)
+
+// NonceStore remembers nonces; Claim reports false if the nonce was seen
+// within ttl
+type NonceStore interface {
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// RedisNonces keeps nonces in Redis, shared by all replicas
+type RedisNonces struct {
+	RDB    redis.UniversalClient
+	Prefix string
+}
+
+// Claim sets the nonce key if it does not exist
+func (n RedisNonces) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	prefix := n.Prefix
+	if prefix == "" {
+		prefix = "webhook:nonce:"
+	}
+	return n.RDB.SetNX(ctx, prefix+key, 1, ttl).Result()
+}
+
+// Sign returns the X-Webhook-Signature value for a delivery
+func Sign(secret string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s.", timestamp, nonce)
+	mac.Write(body)
+	return signatureVersion + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the signature headers on a request with the given body
+func SignRequest(r *http.Request, source, secret, nonce string, body []byte, now time.Time) {
+	r.Header.Set(HeaderSource, source)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, Sign(secret, now.Unix(), nonce, body))
+}
+
+// Verifier checks signed requests
+type Verifier struct {
+	Secrets SecretFunc
+	Nonces  NonceStore
+	// Tolerance is how far the timestamp may be from now, DefaultTolerance
+	// if zero
+	Tolerance time.Duration
+	// Now is the clock, time.Now if nil
+	Now func() time.Time
+}
+
+func (v *Verifier) tolerance() time.Duration {
+	if v.Tolerance > 0 {
+		return v.Tolerance
+	}
+	return DefaultTolerance
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+// Verify checks the signature headers against body and returns the source.
+// A rejected request returns an *Error; other errors are failures of the
+// secret or nonce store.
+func (v *Verifier) Verify(ctx context.Context, header http.Header, body []byte) (string, error) {
+	source := header.Get(HeaderSource)
+	rawTimestamp := header.Get(HeaderTimestamp)
+	nonce := header.Get(HeaderNonce)
+	signature := header.Get(HeaderSignature)
+	if source == "" || rawTimestamp == "" || nonce == "" || signature == "" {
+		return "", reject(ReasonMissingHeaders, "%s, %s, %s and %s are required", HeaderSource, HeaderTimestamp, HeaderNonce, HeaderSignature)
+	}
+
+	if !validSource.MatchString(source) {
+		return "", reject(ReasonUnknownSource, "source must be up to 64 lowercase letters, digits, '_' or '-'")
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return source, reject(ReasonInvalidTimestamp, "%s must be Unix seconds", HeaderTimestamp)
+	}
+	skew := v.now().Sub(time.Unix(timestamp, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > v.tolerance() {
+		return source, reject(ReasonStaleTimestamp, "timestamp is %s away from the server time, more than %s", skew.Round(time.Second), v.tolerance())
+	}
+
+	secret, err := v.Secrets(ctx, source)
+	if errors.Is(err, ErrUnknownSource) {
+		return source, reject(ReasonUnknownSource, "no secret for source %q", source)
+	}
+	if err != nil {
+		return source, fmt.Errorf("failed to get the secret of %s: %w", source, err)
+	}
+	if !matches(secret, timestamp, nonce, body, signature) {
+		return source, reject(ReasonInvalidSignature, "signature does not match the body")
+	}
+
+	// Only signed requests claim a nonce, so nobody can burn another's
+	claimed, err := v.Nonces.Claim(ctx, source+":"+nonce, 2*v.tolerance())
+	if err != nil {
+		return source, fmt.Errorf("failed to check the nonce: %w", err)
+	}
+	if !claimed {
+		return source, reject(ReasonReplayed, "nonce was already used")
+	}
+	return source, nil
+}
+
+// matches compares the signature with each value of a rotating secret
+func matches(secret string, timestamp int64, nonce string, body []byte, signature string) bool {
+	for _, s := range strings.Split(secret, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" && hmac.Equal([]byte(Sign(s, timestamp, nonce, body)), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+type sourceKey struct{}
+
+// Source returns the verified source of a request passed by Middleware
+func Source(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// Middleware lets verified requests through with the body restored and the
+// source in the context. Rejected requests get 401 with the reason as the
+// error code, and onReject, if set, is called with it.
+func (v *Verifier) Middleware(onReject func(r *http.Request, source string, err *Error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodyBytes+1))
+			r.Body.Close()
+			if err != nil {
+				apierror.Write(w, http.StatusBadRequest, "failed to read the request body")
+				return
+			}
+
+			var source string
+			if len(body) > MaxBodyBytes {
+				err = reject(ReasonBodyTooLarge, "body is larger than %d bytes", MaxBodyBytes)
+			} else {
+				source, err = v.Verify(r.Context(), r.Header, body)
+			}
+			var rejected *Error
+			if errors.As(err, &rejected) {
+				if onReject != nil {
+					onReject(r, source, rejected)
+				}
+				apierror.New(http.StatusUnauthorized, rejected.Message).WithCode(rejected.Reason).Write(w)
+				return
+			}
+			if err != nil {
+				apierror.Write(w, http.StatusServiceUnavailable, "webhook verification is unavailable")
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sourceKey{}, source)))
+		})
+	}
+}
+
+// CachedSecrets caches the secrets of get for ttl. Unknown sources are not
+// cached, so made-up source names cannot grow the cache.
+func CachedSecrets(get SecretFunc, ttl time.Duration) SecretFunc {
+	type entry struct {
+		secret string
+		exp    time.Time
+	}
+	var (
+		mu    sync.Mutex
+		cache = make(map[string]entry)
+	)
+	return func(ctx context.Context, source string) (string, error) {
+		mu.Lock()
+		e, ok := cache[source]
+		mu.Unlock()
+		if ok && time.Now().Before(e.exp) {
+			return e.secret, nil
+		}
+
+		secret, err := get(ctx, source)
+		if err != nil {
+			return "", err
+		}
+		mu.Lock()
+		cache[source] = entry{secret: secret, exp: time.Now().Add(ttl)}
+		mu.Unlock()
+		return secret, nil
+	}
+}
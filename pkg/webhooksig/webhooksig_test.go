@@ -0,0 +1,140 @@
+package webhooksig
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func newVerifier(t *testing.T, now time.Time) *Verifier {
+	mr := miniredis.RunT(t)
+	return &Verifier{
+		Secrets: func(ctx context.Context, source string) (string, error) {
+			switch source {
+			case "monitor":
+				return "new-secret, old-secret", nil
+			case "broken":
+				return "", errors.New("secrets-service is down")
+			}
+			return "", ErrUnknownSource
+		},
+		Nonces: RedisNonces{RDB: redis.NewClient(&redis.Options{Addr: mr.Addr()})},
+		Now:    func() time.Time { return now },
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	v := newVerifier(t, now)
+	body := []byte(`{"head_id":"h1","status":"active"}`)
+
+	signed := func(source, secret, nonce string, at time.Time, body []byte) http.Header {
+		r := httptest.NewRequest(http.MethodPost, "/webhook/head-status", nil)
+		SignRequest(r, source, secret, nonce, body, at)
+		return r.Header
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		reason string
+	}{
+		{"valid", signed("monitor", "new-secret", "n1", now, body), body, ""},
+		{"previous secret", signed("monitor", "old-secret", "n2", now.Add(-time.Minute), body), body, ""},
+		{"replayed", signed("monitor", "new-secret", "n1", now, body), body, ReasonReplayed},
+		{"missing headers", http.Header{}, body, ReasonMissingHeaders},
+		{"unknown source", signed("other", "new-secret", "n3", now, body), body, ReasonUnknownSource},
+		{"invalid source", signed("Other Source", "new-secret", "n3", now, body), body, ReasonUnknownSource},
+		{"wrong secret", signed("monitor", "guess", "n4", now, body), body, ReasonInvalidSignature},
+		{"tampered body", signed("monitor", "new-secret", "n5", now, body), []byte(`{"head_id":"h2"}`), ReasonInvalidSignature},
+		{"stale", signed("monitor", "new-secret", "n6", now.Add(-10*time.Minute), body), body, ReasonStaleTimestamp},
+		{"future", signed("monitor", "new-secret", "n7", now.Add(10*time.Minute), body), body, ReasonStaleTimestamp},
+	}
+	for _, tt := range tests {
+		_, err := v.Verify(context.Background(), tt.header, tt.body)
+		var rejected *Error
+		switch {
+		case tt.reason == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.reason != "" && (!errors.As(err, &rejected) || rejected.Reason != tt.reason):
+			t.Errorf("%s: err = %v, want %s", tt.name, err, tt.reason)
+		}
+	}
+
+	// A rejected signature does not use up the nonce
+	if _, err := v.Verify(context.Background(), signed("monitor", "new-secret", "n4", now, body), body); err != nil {
+		t.Errorf("nonce of a rejected request: %v", err)
+	}
+
+	// Store failures are not rejections
+	_, err := v.Verify(context.Background(), signed("broken", "x", "n8", now, body), body)
+	var rejected *Error
+	if err == nil || errors.As(err, &rejected) {
+		t.Errorf("broken store: err = %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Now()
+	v := newVerifier(t, now)
+	var reasons []string
+	handler := v.Middleware(func(r *http.Request, source string, err *Error) {
+		reasons = append(reasons, err.Reason)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(r.Body)
+		w.Write([]byte(Source(r.Context()) + " " + buf.String()))
+	}))
+
+	body := []byte(`{"ok":true}`)
+	req := httptest.NewRequest(http.MethodPost, "/webhook/head-status", bytes.NewReader(body))
+	SignRequest(req, "monitor", "new-secret", "m1", body, now)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != `monitor {"ok":true}` {
+		t.Errorf("signed: %d %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/webhook/head-status", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer webhook-token")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), `"code":"`+ReasonMissingHeaders+`"`) {
+		t.Errorf("unsigned: %d %s", rec.Code, rec.Body)
+	}
+	if len(reasons) != 1 || reasons[0] != ReasonMissingHeaders {
+		t.Errorf("reasons = %v", reasons)
+	}
+}
+
+func TestCachedSecrets(t *testing.T) {
+	calls := 0
+	get := CachedSecrets(func(ctx context.Context, source string) (string, error) {
+		calls++
+		if source == "monitor" {
+			return "secret", nil
+		}
+		return "", ErrUnknownSource
+	}, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if s, err := get(context.Background(), "monitor"); s != "secret" || err != nil {
+			t.Fatalf("get = %q, %v", s, err)
+		}
+	}
+	if _, err := get(context.Background(), "other"); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("err = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
@@ -6,6 +6,7 @@ module github.com/MaksimVF/ZB/services/agentic-service
 go 1.22
 
 require (
+	github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/gorilla/mux v1.8.0
 	google.golang.org/grpc v1.56.3
@@ -15,3 +16,4 @@ require (
 replace github.com/MaksimVF/ZB/services/secrets-service/pb => ../secrets-service/pb
 replace github.com/MaksimVF/ZB/services/head-go/gen => ../head-go/gen
 
+replace github.com/MaksimVF/ZB => ../..
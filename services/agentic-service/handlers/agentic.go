@@ -10,28 +10,27 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"github.com/go-redis/redis/v8"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/status"
-	"llm-gateway-pro/services/agentic-service/internal/secrets"
+	"google.golang.org/grpc/metadata"
 	"llm-gateway-pro/services/agentic-service/internal"
+	"llm-gateway-pro/services/agentic-service/internal/agent"
 	"llm-gateway-pro/services/head-go/gen"
 )
 
@@ -45,6 +44,8 @@ func init() {
 	conn, err := grpc.Dial(
 		"head-service:50051",
 		grpc.WithTransportCredentials(loadHeadTLSCredentials()),
+		tracing.DialOption(),
+		requestid.DialOption(),
 		grpc.WithBlock(),
 		grpc.WithTimeout(10*time.Second),
 	)
@@ -56,30 +57,11 @@ func init() {
 }
 
 func loadHeadTLSCredentials() grpc.TransportCredentials {
-	// Load client certificate and key
-	cert, err := tls.LoadX509KeyPair("/certs/agentic-service.pem", "/certs/agentic-service-key.pem")
+	clientCerts, err := certs.Watch("agentic-service-head", "/certs/agentic-service.pem", "/certs/agentic-service-key.pem", "/certs/ca.pem")
 	if err != nil {
-		log.Fatalf("Failed to load client certificate: %v", err)
+		log.Fatalf("Failed to load client TLS credentials: %v", err)
 	}
-
-	// Load CA certificate
-	caCert, err := os.ReadFile("/certs/ca.pem")
-	if err != nil {
-		log.Fatalf("Failed to load CA certificate: %v", err)
-	}
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		log.Fatalf("Failed to add CA certificate to pool")
-	}
-
-	// Create TLS config
-	config := &tls.Config{
-		ServerName:   "head-service",
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      caCertPool,
-	}
-
-	return credentials.NewTLS(config)
+	return credentials.NewTLS(clientCerts.ClientConfig("head-service", clientCerts.AuthorizeService("head-service")))
 }
 
 type AgenticRequest struct {
@@ -92,6 +74,10 @@ type AgenticRequest struct {
 		Type   string `json:"type"`
 		Schema json.RawMessage `json:"schema,omitempty"`
 	} `json:"response_format,omitempty"`
+	Stream        bool     `json:"stream,omitempty"`
+	ServerTools   []string `json:"server_tools,omitempty"`
+	MaxIterations int      `json:"max_iterations,omitempty"`
+	TokenBudget   int      `json:"token_budget,omitempty"`
 }
 
 type ToolCall struct {
@@ -118,153 +104,258 @@ type AgenticResponse struct {
 	} `json:"usage"`
 	XParallelCalls int `json:"x_parallel_calls,omitempty"`
 	XCachedTools   int `json:"x_cached_tools,omitempty"`
+	XIterations    int `json:"x_iterations,omitempty"`
+}
+
+// Лимиты агентного цикла: AGENT_MAX_ITERATIONS и AGENT_TOKEN_BUDGET задают
+// значения по умолчанию и потолок для значений из запроса
+var (
+	agentMaxIterations = envInt("AGENT_MAX_ITERATIONS", 8)
+	agentTokenBudget   = envInt("AGENT_TOKEN_BUDGET", 50000)
+	agentTools         = agent.DefaultRegistry()
+)
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// redisToolCache кэширует результаты инструментов на 7 дней
+type redisToolCache struct{}
+
+func (redisToolCache) Get(ctx context.Context, key string) (string, bool) {
+	value, err := rdbAgentic.Get(ctx, key).Result()
+	return value, err == nil
+}
+
+func (redisToolCache) Set(ctx context.Context, key, value string) {
+	rdbAgentic.SetEX(ctx, key, value, 7*24*time.Hour)
 }
 
 func AgenticHandler(w http.ResponseWriter, r *http.Request) {
 	var req AgenticRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid json"}`, 400)
+		apierror.Write(w, 400, "invalid json")
 		return
 	}
 
-	// Force agentic settings
-	req.ParallelToolCalls = true
-	if req.ResponseFormat == nil {
-		req.ResponseFormat = &struct {
-			Type   string          `json:"type"`
-			Schema json.RawMessage `json:"schema,omitempty"`
-		}{Type: "json_object"}
-	}
-
 	// Only top reasoning models allowed - use LiteLLM for provider selection
-	provider, err := internal.GetProviderForModel(req.Model)
-	if err != nil {
-		http.Error(w, `{"error":"model not allowed for agentic endpoint"}`, 400)
+	if _, err := internal.GetProviderForModel(req.Model); err != nil {
+		apierror.Write(w, 400, "model not allowed for agentic endpoint")
 		return
 	}
 
-	// Convert messages to the format expected by head service
-	var messages []string
-	for _, msg := range req.Messages {
-		if content, ok := msg["content"].(string); ok {
-			messages = append(messages, content)
+	cfg := agent.Config{
+		MaxIterations: agentMaxIterations,
+		TokenBudget:   agentTokenBudget,
+		ToolTimeout:   30 * time.Second,
+	}
+	if req.MaxIterations > 0 && req.MaxIterations < cfg.MaxIterations {
+		cfg.MaxIterations = req.MaxIterations
+	}
+	if req.TokenBudget > 0 && req.TokenBudget < cfg.TokenBudget {
+		cfg.TokenBudget = req.TokenBudget
+	}
+
+	// Tools registered on the server run here; other tools are returned to the client
+	var clientTools []map[string]interface{}
+	for _, tool := range req.Tools {
+		fn, _ := tool["function"].(map[string]interface{})
+		name, _ := fn["name"].(string)
+		if _, ok := agentTools.Get(name); ok {
+			req.ServerTools = append(req.ServerTools, name)
+			continue
 		}
+		clientTools = append(clientTools, tool)
 	}
 
-	// Call head service via gRPC
-	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	messages := make([]agent.Message, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		role, _ := msg["role"].(string)
+		content, _ := msg["content"].(string)
+		messages = append(messages, agent.Message{Role: role, Content: content})
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
 
-	chatReq := &gen.ChatRequest{
-		RequestId:   fmt.Sprintf("agentic-%d", time.Now().UnixNano()),
-		Model:       req.Model,
-		Messages:    messages,
-		Temperature:  0.7, // Default temperature for agentic
-		MaxTokens:   8192,
-		Stream:      false,
+	var emit func(agent.Step)
+	flusher, canFlush := w.(http.Flusher)
+	if req.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Agentic-Endpoint", "true")
+
+		// Шаги приходят из нескольких горутин инструментов
+		var mu sync.Mutex
+		emit = func(step agent.Step) {
+			mu.Lock()
+			defer mu.Unlock()
+			writeSSE(w, map[string]interface{}{"object": "agentic.step", "step": step})
+			if canFlush {
+				flusher.Flush()
+			}
+		}
 	}
 
-	chatResp, err := headClient.ChatCompletion(ctx, chatReq)
+	engine := agent.NewEngine(headModel(req.Model), agentTools, redisToolCache{})
+	result, err := engine.Run(ctx, cfg, messages, req.ServerTools, clientTools, emit)
 	if err != nil {
-		http.Error(w, `{"error":"head service error"}`, 502)
+		log.Printf("Agent run failed: %v", err)
+		if req.Stream {
+			writeSSE(w, map[string]string{"error": err.Error()})
+			return
+		}
+		apierror.Write(w, 502, "head service error")
 		return
 	}
 
-	// Parse the response from head service
-	var providerResp map[string]interface{}
-	if err := json.Unmarshal([]byte(chatResp.FullText), &providerResp); err != nil {
-		http.Error(w, `{"error":"invalid response format"}`, 500)
+	final := buildAgenticResponse(req.Model, result)
+	if req.Stream {
+		writeSSE(w, final)
+		io.WriteString(w, "data: [DONE]\n\n")
 		return
 	}
 
-	// Parallel tool_calls + caching
-	toolCalls := extractToolCalls(providerResp)
-	cached := 0
-	if len(toolCalls) > 0 {
-		var wg sync.WaitGroup
-		for i := range toolCalls {
-			wg.Add(1)
-			go func(tc *ToolCall) {
-				defer wg.Done()
-				cacheKey := "tool:" + hashToolCall(tc)
-				if cached, _ := rdbAgentic.Get(r.Context(), cacheKey).Result(); cached != "" {
-					tc.Function["result"] = json.RawMessage(cached)
-					cached++
-					return
-				}
-				// Here you can add real tool calls
-				// result := callRealTool(tc)
-				// rdbAgentic.SetEX(r.Context(), cacheKey, result, 7*24*time.Hour)
-			}(&toolCalls[i])
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Agentic-Endpoint", "true")
+	json.NewEncoder(w).Encode(final)
+}
+
+func buildAgenticResponse(model string, result *agent.Result) AgenticResponse {
+	message := map[string]interface{}{"role": "assistant", "content": result.Content}
+	if len(result.ToolCalls) > 0 {
+		toolCalls := make([]ToolCall, 0, len(result.ToolCalls))
+		for _, call := range result.ToolCalls {
+			toolCalls = append(toolCalls, ToolCall{
+				ID:   call.ID,
+				Type: "function",
+				Function: map[string]interface{}{
+					"name":      call.Name,
+					"arguments": string(call.Arguments),
+				},
+			})
 		}
-		wg.Wait()
+		message["tool_calls"] = toolCalls
 	}
 
 	final := AgenticResponse{
 		ID:      "agentic-" + fmt.Sprintf("%d", time.Now().UnixNano()),
 		Object:  "agentic.completion",
 		Created: time.Now().Unix(),
-		Model:   req.Model,
+		Model:   model,
 		Choices: []struct {
 			Index        int                    `json:"index"`
 			Message      map[string]interface{} `json:"message"`
 			FinishReason string                 `json:"finish_reason"`
 		}{
-			{
-				Index:        0,
-				Message:      map[string]interface{}{"role": "assistant", "tool_calls": toolCalls},
-				FinishReason: "tool_calls",
-			},
-		},
-		Usage: struct {
-			PromptTokens     int `json:"prompt_tokens"`
-			CompletionTokens int `json:"completion_tokens"`
-			TotalTokens      int `json:"total_tokens"`
-			ToolCalls        int `json:"tool_calls,omitempty"`
-		}{
-			ToolCalls: len(toolCalls),
+			{Index: 0, Message: message, FinishReason: result.FinishReason},
 		},
-		XParallelCalls: len(toolCalls),
-		XCachedTools:   cached,
+		XParallelCalls: result.ToolsRun,
+		XCachedTools:   result.CachedTools,
+		XIterations:    result.Iterations,
 	}
+	final.Usage.TotalTokens = result.TokensUsed
+	final.Usage.ToolCalls = result.ToolsRun
+	return final
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("X-Agentic-Endpoint", "true")
-	json.NewEncoder(w).Encode(final)
+func writeSSE(w io.Writer, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "data: %s\n\n", data)
 }
 
-func extractToolCalls(resp map[string]interface{}) []ToolCall {
-	var calls []ToolCall
-	choices := resp["choices"].([]interface{})
-	for _, c := range choices {
-		choice := c.(map[string]interface{})
-		msg := choice["message"].(map[string]interface{})
-		if tc, ok := msg["tool_calls"]; ok {
-			for _, t := range tc.([]interface{}) {
-				tool := t.(map[string]interface{})
-				calls = append(calls, ToolCall{
-					ID:       tool["id"].(string),
-					Type:     tool["type"].(string),
-					Function: tool["function"].(map[string]interface{}),
-				})
+// agentProtocol объясняет модели, как вызывать инструменты через JSON-ответ
+const agentProtocol = `You are an agent that can call tools. Reply with a JSON object only:
+{"content": "<text for the user>", "tool_calls": [{"id": "call_1", "name": "<tool>", "arguments": {...}}]}
+Use an empty tool_calls list when you have the final answer. Available tools:
+`
+
+// headModel вызывает модель через head-сервис. Head принимает только тексты
+// сообщений, поэтому роли, вызовы инструментов и их результаты сериализуются в текст.
+func headModel(model string) agent.ModelFunc {
+	return func(ctx context.Context, messages []agent.Message, tools []map[string]interface{}) (*agent.Turn, error) {
+		rawTools, _ := json.Marshal(tools)
+		prompt := []string{agentProtocol + string(rawTools)}
+		for _, msg := range messages {
+			switch {
+			case msg.Role == "tool":
+				prompt = append(prompt, fmt.Sprintf("tool %s (%s) result: %s", msg.Name, msg.ToolCallID, msg.Content))
+			case len(msg.ToolCalls) > 0:
+				rawCalls, _ := json.Marshal(msg.ToolCalls)
+				prompt = append(prompt, fmt.Sprintf("assistant: %s\ntool_calls: %s", msg.Content, rawCalls))
+			default:
+				prompt = append(prompt, msg.Role+": "+msg.Content)
 			}
 		}
-	}
-	return calls
-}
-
-func hashToolCall(tc *ToolCall) string {
-	// Create a hash of the tool call for caching
-	data, _ := json.Marshal(tc)
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
-}
-
-
-
-
-
 
+		// Head проверяет JSON и при необходимости чинит или перезапрашивает ответ
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-response-format", `{"type":"json_object"}`)
+		chatResp, err := headClient.ChatCompletion(ctx, &gen.ChatRequest{
+			RequestId:   fmt.Sprintf("agentic-%d", time.Now().UnixNano()),
+			Model:       model,
+			Messages:    prompt,
+			Temperature: 0.7, // Default temperature for agentic
+			MaxTokens:   8192,
+			Stream:      false,
+		})
+		if err != nil {
+			return nil, err
+		}
 
+		turn := parseTurn(chatResp.FullText)
+		turn.TokensUsed = int(chatResp.TokensUsed)
+		return turn, nil
+	}
+}
 
+// parseTurn understands the agent protocol and OpenAI-style responses;
+// anything else is treated as a final text answer
+func parseTurn(text string) *agent.Turn {
+	var reply struct {
+		Content   string `json:"content"`
+		ToolCalls []struct {
+			ID        string          `json:"id"`
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"tool_calls"`
+		Choices []struct {
+			Message struct {
+				Content   string `json:"content"`
+				ToolCalls []struct {
+					ID       string `json:"id"`
+					Function struct {
+						Name      string `json:"name"`
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(text), &reply); err != nil {
+		return &agent.Turn{Content: text}
+	}
 
+	turn := &agent.Turn{Content: reply.Content}
+	for i, call := range reply.ToolCalls {
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("call_%d", i+1)
+		}
+		turn.ToolCalls = append(turn.ToolCalls, agent.ToolCall{ID: id, Name: call.Name, Arguments: call.Arguments})
+	}
+	if len(reply.Choices) > 0 {
+		msg := reply.Choices[0].Message
+		turn.Content = msg.Content
+		for _, call := range msg.ToolCalls {
+			turn.ToolCalls = append(turn.ToolCalls, agent.ToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: json.RawMessage(call.Function.Arguments),
+			})
+		}
+	}
+	return turn
+}
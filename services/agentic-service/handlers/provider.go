@@ -15,13 +15,15 @@ import (
 	"net/http"
 
 	"llm-gateway-pro/services/agentic-service/internal"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
 )
 
 // GetProviders returns the list of all configured providers
 func GetProviders(w http.ResponseWriter, r *http.Request) {
 	providers, err := internal.GetAllProviders()
 	if err != nil {
-		http.Error(w, `{"error":"failed to get providers"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to get providers")
 		return
 	}
 
@@ -33,7 +35,7 @@ func GetProviders(w http.ResponseWriter, r *http.Request) {
 func GetProviderHealth(w http.ResponseWriter, r *http.Request) {
 	health, err := internal.GetProviderHealth()
 	if err != nil {
-		http.Error(w, `{"error":"failed to get provider health"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to get provider health")
 		return
 	}
 
@@ -45,18 +47,18 @@ func GetProviderHealth(w http.ResponseWriter, r *http.Request) {
 func AddProvider(w http.ResponseWriter, r *http.Request) {
 	var config internal.ProviderConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		http.Error(w, `{"error":"invalid provider configuration"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "invalid provider configuration")
 		return
 	}
 
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
-		http.Error(w, `{"error":"provider name required"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "provider name required")
 		return
 	}
 
 	if err := internal.AddProvider(provider, config); err != nil {
-		http.Error(w, `{"error":"failed to add provider"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to add provider")
 		return
 	}
 
@@ -68,12 +70,12 @@ func AddProvider(w http.ResponseWriter, r *http.Request) {
 func RemoveProvider(w http.ResponseWriter, r *http.Request) {
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
-		http.Error(w, `{"error":"provider name required"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "provider name required")
 		return
 	}
 
 	if err := internal.RemoveProvider(provider); err != nil {
-		http.Error(w, `{"error":"failed to remove provider"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to remove provider")
 		return
 	}
 
@@ -85,7 +87,7 @@ func RemoveProvider(w http.ResponseWriter, r *http.Request) {
 func UpdateProviderAPIKey(w http.ResponseWriter, r *http.Request) {
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
-		http.Error(w, `{"error":"provider name required"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "provider name required")
 		return
 	}
 
@@ -93,12 +95,12 @@ func UpdateProviderAPIKey(w http.ResponseWriter, r *http.Request) {
 		APIKey string `json:"api_key"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "invalid request")
 		return
 	}
 
 	if err := internal.SetProviderAPIKey(provider, req.APIKey); err != nil {
-		http.Error(w, `{"error":"failed to update API key"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to update API key")
 		return
 	}
 
@@ -0,0 +1,253 @@
+// Package agent runs tool-using agent loops: the model is called, requested
+// tools are executed and their results fed back until the model answers,
+// the iteration limit is reached or the token budget is spent.
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Finish reasons
+const (
+	FinishStop          = "stop"
+	FinishToolCalls     = "tool_calls" // the model called tools the client has to execute
+	FinishMaxIterations = "max_iterations"
+	FinishTokenBudget   = "token_budget"
+)
+
+// Step types streamed to the client
+const (
+	StepModel      = "model"
+	StepToolCall   = "tool_call"
+	StepToolResult = "tool_result"
+	StepFinal      = "final"
+)
+
+// Message is a conversation message
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+}
+
+// ToolCall is a tool invocation requested by the model
+type ToolCall struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// Turn is one model response
+type Turn struct {
+	Content    string
+	ToolCalls  []ToolCall
+	TokensUsed int
+}
+
+// ModelFunc calls the model with the conversation and the available tool definitions
+type ModelFunc func(ctx context.Context, messages []Message, tools []map[string]interface{}) (*Turn, error)
+
+// Cache stores tool results by call hash
+type Cache interface {
+	Get(ctx context.Context, key string) (string, bool)
+	Set(ctx context.Context, key, value string)
+}
+
+// Step is an intermediate event of a run
+type Step struct {
+	Type       string    `json:"type"`
+	Iteration  int       `json:"iteration"`
+	Content    string    `json:"content,omitempty"`
+	ToolCall   *ToolCall `json:"tool_call,omitempty"`
+	Result     string    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Cached     bool      `json:"cached,omitempty"`
+	TokensUsed int       `json:"tokens_used,omitempty"`
+}
+
+// Config limits a run
+type Config struct {
+	MaxIterations int
+	TokenBudget   int // 0 means unlimited
+	ToolTimeout   time.Duration
+}
+
+// Result is the outcome of a run
+type Result struct {
+	Content      string     `json:"content"`
+	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
+	FinishReason string     `json:"finish_reason"`
+	Iterations   int        `json:"iterations"`
+	TokensUsed   int        `json:"tokens_used"`
+	ToolsRun     int        `json:"tools_run"`
+	CachedTools  int        `json:"cached_tools"`
+	Messages     []Message  `json:"-"`
+}
+
+// Engine executes agent runs
+type Engine struct {
+	model    ModelFunc
+	registry *Registry
+	cache    Cache
+}
+
+// NewEngine creates an engine; cache may be nil
+func NewEngine(model ModelFunc, registry *Registry, cache Cache) *Engine {
+	return &Engine{model: model, registry: registry, cache: cache}
+}
+
+// Run executes the loop. toolNames selects registered tools (all when empty),
+// clientTools are definitions the client executes itself. emit may be nil.
+func (e *Engine) Run(ctx context.Context, cfg Config, messages []Message, toolNames []string, clientTools []map[string]interface{}, emit func(Step)) (*Result, error) {
+	if emit == nil {
+		emit = func(Step) {}
+	}
+
+	defs := append(e.registry.Definitions(toolNames), clientTools...)
+	allowed := make(map[string]bool)
+	for _, def := range e.registry.Definitions(toolNames) {
+		allowed[def["function"].(map[string]interface{})["name"].(string)] = true
+	}
+
+	result := &Result{Messages: append([]Message{}, messages...)}
+	for iteration := 1; iteration <= cfg.MaxIterations; iteration++ {
+		result.Iterations = iteration
+
+		turn, err := e.model(ctx, result.Messages, defs)
+		if err != nil {
+			return result, fmt.Errorf("model call failed: %w", err)
+		}
+		result.TokensUsed += turn.TokensUsed
+		emit(Step{Type: StepModel, Iteration: iteration, Content: turn.Content, TokensUsed: turn.TokensUsed})
+
+		result.Messages = append(result.Messages, Message{Role: "assistant", Content: turn.Content, ToolCalls: turn.ToolCalls})
+		if len(turn.ToolCalls) == 0 {
+			result.Content = turn.Content
+			result.FinishReason = FinishStop
+			emit(Step{Type: StepFinal, Iteration: iteration, Content: turn.Content})
+			return result, nil
+		}
+
+		if cfg.TokenBudget > 0 && result.TokensUsed >= cfg.TokenBudget {
+			result.Content = turn.Content
+			result.FinishReason = FinishTokenBudget
+			return result, nil
+		}
+
+		// Calls to client-defined tools are handed back to the client
+		var serverCalls []ToolCall
+		for _, call := range turn.ToolCalls {
+			if allowed[call.Name] {
+				serverCalls = append(serverCalls, call)
+			} else if isClientTool(clientTools, call.Name) {
+				result.ToolCalls = append(result.ToolCalls, call)
+			} else {
+				serverCalls = append(serverCalls, call)
+			}
+		}
+		if len(result.ToolCalls) > 0 {
+			result.FinishReason = FinishToolCalls
+			return result, nil
+		}
+
+		for _, msg := range e.executeAll(ctx, cfg, iteration, serverCalls, allowed, result, emit) {
+			result.Messages = append(result.Messages, msg)
+		}
+	}
+
+	result.FinishReason = FinishMaxIterations
+	return result, nil
+}
+
+// executeAll runs the tool calls in parallel and returns the tool messages in call order
+func (e *Engine) executeAll(ctx context.Context, cfg Config, iteration int, calls []ToolCall, allowed map[string]bool, result *Result, emit func(Step)) []Message {
+	messages := make([]Message, len(calls))
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	for i := range calls {
+		call := calls[i]
+		emit(Step{Type: StepToolCall, Iteration: iteration, ToolCall: &call})
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			output, cached, err := e.execute(ctx, cfg, call, allowed)
+
+			step := Step{Type: StepToolResult, Iteration: iteration, ToolCall: &call, Cached: cached}
+			if err != nil {
+				step.Error = err.Error()
+				output = "error: " + err.Error()
+			} else {
+				step.Result = output
+			}
+
+			mu.Lock()
+			result.ToolsRun++
+			if cached {
+				result.CachedTools++
+			}
+			emit(step)
+			mu.Unlock()
+
+			messages[i] = Message{Role: "tool", Content: output, ToolCallID: call.ID, Name: call.Name}
+		}(i)
+	}
+	wg.Wait()
+	return messages
+}
+
+func (e *Engine) execute(ctx context.Context, cfg Config, call ToolCall, allowed map[string]bool) (string, bool, error) {
+	tool, ok := e.registry.Get(call.Name)
+	if !ok || !allowed[call.Name] {
+		return "", false, fmt.Errorf("unknown tool %s", call.Name)
+	}
+
+	key := "tool:" + hashCall(call)
+	if e.cache != nil {
+		if output, ok := e.cache.Get(ctx, key); ok {
+			return output, true, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.ToolTimeout)
+	defer cancel()
+
+	output, err := tool.Execute(ctx, call.Arguments)
+	if err != nil {
+		return "", false, err
+	}
+	if e.cache != nil {
+		e.cache.Set(ctx, key, output)
+	}
+	return output, false, nil
+}
+
+func isClientTool(clientTools []map[string]interface{}, name string) bool {
+	for _, def := range clientTools {
+		fn, _ := def["function"].(map[string]interface{})
+		if fn != nil && fn["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hashCall(call ToolCall) string {
+	data, _ := json.Marshal(struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}{call.Name, call.Arguments})
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
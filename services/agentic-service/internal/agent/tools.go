@@ -0,0 +1,263 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tool results are truncated to keep the conversation within the model context
+const maxToolResultBytes = 16 * 1024
+
+// maxToolRedirects is how many redirects the HTTP tool follows
+const maxToolRedirects = 5
+
+// Tool is a capability the agent can invoke between model turns
+type Tool interface {
+	Name() string
+	Description() string
+	// Parameters is the JSON Schema of the tool arguments
+	Parameters() json.RawMessage
+	Execute(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// Registry holds the tools available to agent runs
+type Registry struct {
+	mu    sync.RWMutex
+	tools map[string]Tool
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]Tool)}
+}
+
+// Register adds or replaces a tool
+func (r *Registry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[tool.Name()] = tool
+}
+
+// Get returns a tool by name
+func (r *Registry) Get(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Definitions returns OpenAI-style function definitions for the named tools,
+// or for all tools when names is empty
+func (r *Registry) Definitions(names []string) []map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	selected := names
+	if len(selected) == 0 {
+		for name := range r.tools {
+			selected = append(selected, name)
+		}
+	}
+
+	defs := make([]map[string]interface{}, 0, len(selected))
+	for _, name := range selected {
+		tool, ok := r.tools[name]
+		if !ok {
+			continue
+		}
+		defs = append(defs, map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name":        tool.Name(),
+				"description": tool.Description(),
+				"parameters":  tool.Parameters(),
+			},
+		})
+	}
+	return defs
+}
+
+// DefaultRegistry registers the built-in tools. The HTTP tool is limited to
+// AGENT_HTTP_ALLOWED_HOSTS; the sandbox and retrieval tools are only
+// available when AGENT_SANDBOX_URL and AGENT_RETRIEVAL_URL are set.
+func DefaultRegistry() *Registry {
+	registry := NewRegistry()
+	client := &http.Client{Timeout: 20 * time.Second}
+
+	registry.Register(NewHTTPTool(splitList(os.Getenv("AGENT_HTTP_ALLOWED_HOSTS"))))
+	if sandboxURL := os.Getenv("AGENT_SANDBOX_URL"); sandboxURL != "" {
+		registry.Register(&SandboxTool{client: client, url: sandboxURL})
+	}
+	if retrievalURL := os.Getenv("AGENT_RETRIEVAL_URL"); retrievalURL != "" {
+		registry.Register(&RetrievalTool{client: client, url: retrievalURL})
+	}
+	return registry
+}
+
+// HTTPTool performs HTTP GET/POST requests against allowed hosts
+type HTTPTool struct {
+	client       *http.Client
+	allowedHosts []string
+}
+
+// NewHTTPTool creates the HTTP tool for allowedHosts; "*.example.com" allows
+// the subdomains of example.com. Redirects are followed only to allowed hosts.
+func NewHTTPTool(allowedHosts []string) *HTTPTool {
+	t := &HTTPTool{allowedHosts: allowedHosts}
+	t.client = &http.Client{Timeout: 20 * time.Second, CheckRedirect: t.checkRedirect}
+	return t
+}
+
+// checkRedirect applies the allowlist to every hop, so an allowed host
+// cannot send the tool on to one that is not
+func (t *HTTPTool) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxToolRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxToolRedirects)
+	}
+	if !t.urlAllowed(req.URL) {
+		return fmt.Errorf("redirect to host %s is not allowed", req.URL.Hostname())
+	}
+	return nil
+}
+
+func (t *HTTPTool) urlAllowed(u *url.URL) bool {
+	return (u.Scheme == "https" || u.Scheme == "http") && hostAllowed(u.Hostname(), t.allowedHosts)
+}
+
+func (t *HTTPTool) Name() string { return "http_request" }
+
+func (t *HTTPTool) Description() string {
+	return "Send an HTTP GET or POST request and return the response body"
+}
+
+func (t *HTTPTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"method":{"type":"string","enum":["GET","POST"]},"url":{"type":"string"},"body":{"type":"string"}},"required":["url"]}`)
+}
+
+func (t *HTTPTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Method string `json:"method"`
+		URL    string `json:"url"`
+		Body   string `json:"body"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if params.Method == "" {
+		params.Method = http.MethodGet
+	}
+	if params.Method != http.MethodGet && params.Method != http.MethodPost {
+		return "", fmt.Errorf("method %s is not allowed", params.Method)
+	}
+
+	u, err := url.Parse(params.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", fmt.Errorf("invalid url")
+	}
+	if !t.urlAllowed(u) {
+		return "", fmt.Errorf("host %s is not allowed", u.Hostname())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, params.Method, u.String(), strings.NewReader(params.Body))
+	if err != nil {
+		return "", err
+	}
+	if params.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doRequest(t.client, req)
+}
+
+// SandboxTool runs code in an external sandbox service
+type SandboxTool struct {
+	client *http.Client
+	url    string
+}
+
+func (t *SandboxTool) Name() string { return "run_code" }
+
+func (t *SandboxTool) Description() string {
+	return "Run a code snippet in an isolated sandbox and return its output"
+}
+
+func (t *SandboxTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"language":{"type":"string","enum":["python","javascript"]},"code":{"type":"string"}},"required":["language","code"]}`)
+}
+
+func (t *SandboxTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	return postJSON(ctx, t.client, t.url, args)
+}
+
+// RetrievalTool searches the knowledge base through the retrieval endpoint
+type RetrievalTool struct {
+	client *http.Client
+	url    string
+}
+
+func (t *RetrievalTool) Name() string { return "retrieve" }
+
+func (t *RetrievalTool) Description() string {
+	return "Search the knowledge base and return the most relevant passages"
+}
+
+func (t *RetrievalTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"query":{"type":"string"},"collection":{"type":"string"},"top_k":{"type":"integer"}},"required":["query"]}`)
+}
+
+func (t *RetrievalTool) Execute(ctx context.Context, args json.RawMessage) (string, error) {
+	return postJSON(ctx, t.client, t.url, args)
+}
+
+func postJSON(ctx context.Context, client *http.Client, target string, body json.RawMessage) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(client, req)
+}
+
+func doRequest(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResultBytes))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return string(body), nil
+}
+
+func hostAllowed(host string, allowed []string) bool {
+	for _, h := range allowed {
+		if host == h || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"api.example.com", "*.trusted.org"}
+	for host, want := range map[string]bool{
+		"api.example.com":      true,
+		"example.com":          false,
+		"evil-api.example.com": false,
+		"docs.trusted.org":     true,
+		"a.b.trusted.org":      true,
+		"trusted.org":          false,
+		"nottrusted.org":       false,
+		"":                     false,
+	} {
+		if got := hostAllowed(host, allowed); got != want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", host, got, want)
+		}
+	}
+	if hostAllowed("api.example.com", nil) {
+		t.Error("empty allowlist allows a host")
+	}
+}
+
+func execute(t *testing.T, tool *HTTPTool, target string) (string, error) {
+	t.Helper()
+	args, _ := json.Marshal(map[string]string{"url": target})
+	return tool.Execute(context.Background(), args)
+}
+
+func TestHTTPToolAllowlist(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+	tool := NewHTTPTool([]string{"127.0.0.1"})
+
+	if body, err := execute(t, tool, srv.URL); err != nil || body != "ok" {
+		t.Fatalf("allowed host: %q, %v", body, err)
+	}
+	u, _ := url.Parse(srv.URL)
+	if _, err := execute(t, tool, "http://localhost:"+u.Port()); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("host not in the allowlist: %v", err)
+	}
+	if _, err := execute(t, tool, "file:///etc/passwd"); err == nil {
+		t.Error("file URL accepted")
+	}
+}
+
+func TestHTTPToolRedirects(t *testing.T) {
+	var reached bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		fmt.Fprint(w, "secret")
+	}))
+	defer internal.Close()
+	internalURL, _ := url.Parse(internal.URL)
+
+	var allowedURL string
+	allowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			// Same server under a hostname that is not allowed
+			http.Redirect(w, r, "http://localhost:"+internalURL.Port()+"/", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, allowedURL+"/loop", http.StatusFound)
+		case "/hop":
+			http.Redirect(w, r, allowedURL+"/", http.StatusFound)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer allowed.Close()
+	allowedURL = allowed.URL
+	tool := NewHTTPTool([]string{"127.0.0.1"})
+
+	if body, err := execute(t, tool, allowedURL+"/hop"); err != nil || body != "ok" {
+		t.Fatalf("redirect to an allowed host: %q, %v", body, err)
+	}
+	if _, err := execute(t, tool, allowedURL+"/away"); err == nil || !strings.Contains(err.Error(), "redirect to host localhost is not allowed") {
+		t.Errorf("redirect to a host not in the allowlist: %v", err)
+	}
+	if reached {
+		t.Error("redirect followed to a host not in the allowlist")
+	}
+	if _, err := execute(t, tool, allowedURL+"/loop"); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("redirect loop: %v", err)
+	}
+}
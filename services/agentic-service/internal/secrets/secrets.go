@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"google.golang.org/grpc"
 
 	"llm-gateway-pro/services/agentic-service/internal/grpc"
@@ -27,6 +29,8 @@ func init() {
 	secretConn, err = grpc.Dial(
 		"secret-service:50053",
 		grpc.WithTransportCredentials(loadClientTLSCredentials()),
+		tracing.DialOption(),
+		requestid.DialOption(),
 	)
 	if err != nil {
 		panic(fmt.Sprintf("Failed to connect to secret-service: %v", err))
@@ -13,7 +13,6 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
@@ -28,6 +27,11 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	pb "github.com/MaksimVF/ZB/services/secrets-service/pb"
 	"llm-gateway-pro/services/agentic-service/handlers"
 	"llm-gateway-pro/services/agentic-service/middleware"
@@ -41,16 +45,23 @@ var (
 )
 
 func main() {
+	shutdownTracing, err := tracing.Init(context.Background(), "agentic-service")
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize Redis client
 	redisClient = redis.NewClient(&redis.Options{
 		Addr: "redis:6379",
 	})
 
 	// Connect to secret-service (gRPC + mTLS)
-	var err error
 	secretConn, err = grpc.Dial(
 		"secret-service:50053",
 		grpc.WithTransportCredentials(loadClientTLSCredentials()),
+		tracing.DialOption(),
+		requestid.DialOption(),
 	)
 	if err != nil {
 		log.Fatalf("Failed to connect to secret-service: %v", err)
@@ -95,6 +106,16 @@ func main() {
 		fmt.Fprint(w, "OK")
 	}).Methods("GET")
 
+	// Liveness and readiness with dependency detail
+	checker := health.New("agentic-service").
+		Add("redis", func(ctx context.Context) error { return redisClient.Ping(ctx).Err() }).
+		Add("secret-service", health.GRPC(secretConn))
+	r.Handle("/livez", checker.LiveHandler()).Methods("GET")
+	r.Handle("/readyz", checker.ReadyHandler()).Methods("GET")
+
+	// pprof, expvar and /debug/runtime with DEBUG_ENDPOINTS=true, behind the admin key
+	r.PathPrefix(diagnostics.Prefix).Handler(diagnostics.Handler(diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))))
+
 	// Provider management endpoints
 	r.HandleFunc("/v1/providers", handlers.GetProviders).Methods("GET")
 	r.HandleFunc("/v1/providers/health", handlers.GetProviderHealth).Methods("GET")
@@ -105,7 +126,7 @@ func main() {
 	// Start HTTP server
 	srv := &http.Server{
 		Addr:    ":8081",
-		Handler: r,
+		Handler: tracing.Middleware("agentic-service", requestid.Middleware(r)),
 		TLSConfig: &tls.Config{
 			MinVersion: tls.VersionTLS12,
 		},
@@ -136,23 +157,11 @@ func main() {
 
 // loadClientTLSCredentials loads client TLS credentials for mTLS
 func loadClientTLSCredentials() credentials.TransportCredentials {
-	clientCert, err := tls.LoadX509KeyPair("/certs/agentic.pem", "/certs/agentic-key.pem")
+	clientCerts, err := certs.Watch("agentic-service", "/certs/agentic.pem", "/certs/agentic-key.pem", "/certs/ca.pem")
 	if err != nil {
-		log.Fatalf("Failed to load client certificate: %v", err)
+		log.Fatalf("Failed to load client TLS credentials: %v", err)
 	}
-
-	caCert, err := os.ReadFile("/certs/ca.pem")
-	if err != nil {
-		log.Fatalf("Failed to load CA certificate: %v", err)
-	}
-
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	return credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{clientCert},
-		RootCAs:      caCertPool,
-	})
+	return credentials.NewTLS(clientCerts.ClientConfig("", clientCerts.AuthorizeService("secret-service")))
 }
 
 // watchSecretsUpdates watches for secret updates
@@ -16,6 +16,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
@@ -57,7 +58,7 @@ func ContentFilteringMiddleware(next http.Handler) http.Handler {
 			for _, param := range r.URL.Query() {
 				for _, value := range param {
 					if containsBadWords(value) || containsBadPatterns(value) {
-						http.Error(w, "Request contains prohibited content", http.StatusBadRequest)
+						apierror.Write(w, http.StatusBadRequest, "Request contains prohibited content")
 						return
 					}
 				}
@@ -74,7 +75,7 @@ func ContentFilteringMiddleware(next http.Handler) http.Handler {
 				}
 
 				if containsBadWords(string(body)) || containsBadPatterns(string(body)) {
-					http.Error(w, "Request contains prohibited content", http.StatusBadRequest)
+					apierror.Write(w, http.StatusBadRequest, "Request contains prohibited content")
 					return
 				}
 			}
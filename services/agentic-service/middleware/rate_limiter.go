@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
 	"golang.org/x/time/rate"
 )
 
@@ -20,7 +21,7 @@ func RateLimiter(next http.HandlerFunc) http.HandlerFunc {
 		rateLimiterMu.Lock()
 		if !rateLimiter.Allow() {
 			rateLimiterMu.Unlock()
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			apierror.New(http.StatusTooManyRequests, "Too Many Requests").WithCode("rate_limit_exceeded").Write(w)
 			return
 		}
 		rateLimiterMu.Unlock()
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/golang-migrate/migrate/v4"
+	migratepostgres "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Schema migrations, applied in order at startup. Add new changes as the
+// next numbered up/down pair; never edit a released migration.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// replicaDB serves heavy reads when DB_REPLICA_HOST is set; nil otherwise
+var replicaDB *gorm.DB
+
+// poolConfig sizes a database/sql connection pool
+type poolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// loadPoolConfig reads DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+// DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME
+func loadPoolConfig() poolConfig {
+	return poolConfig{
+		MaxOpenConns:    envInt("DB_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    envInt("DB_MAX_IDLE_CONNS", 10),
+		ConnMaxLifetime: envDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime: envDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+	}
+}
+
+func (c poolConfig) apply(sqlDB *sql.DB) {
+	sqlDB.SetMaxOpenConns(c.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(c.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(c.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+}
+
+func envInt(key string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n >= 0 {
+		return n
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d >= 0 {
+		return d
+	}
+	return fallback
+}
+
+func postgresDSN(host, port string) string {
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		host,
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_NAME"),
+		port,
+	)
+}
+
+// openDatabase connects to the primary, migrates it unless DB_MIGRATE=false
+// and connects to the read replica when DB_REPLICA_HOST is set
+func openDatabase() error {
+	pool := loadPoolConfig()
+	dsn := postgresDSN(os.Getenv("DB_HOST"), os.Getenv("DB_PORT"))
+
+	var err error
+	db, err = openPool(dsn, pool, "primary")
+	if err != nil {
+		return fmt.Errorf("connect to database: %w", err)
+	}
+
+	if os.Getenv("DB_MIGRATE") != "false" {
+		if err := migrateDatabase(dsn); err != nil {
+			return fmt.Errorf("migrate database: %w", err)
+		}
+	}
+
+	if host := os.Getenv("DB_REPLICA_HOST"); host != "" {
+		port := os.Getenv("DB_REPLICA_PORT")
+		if port == "" {
+			port = os.Getenv("DB_PORT")
+		}
+		replicaDB, err = openPool(postgresDSN(host, port), pool, "replica")
+		if err != nil {
+			return fmt.Errorf("connect to read replica: %w", err)
+		}
+		logger.Info().Str("host", host).Msg("Read replica enabled")
+	}
+	return nil
+}
+
+// openPool opens a gorm connection with the given pool sizing and exports
+// the pool statistics as go_sql_* metrics labelled db_name=auth-<name>
+func openPool(dsn string, pool poolConfig, name string) (*gorm.DB, error) {
+	gdb, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return nil, err
+	}
+	pool.apply(sqlDB)
+	prometheus.MustRegister(collectors.NewDBStatsCollector(sqlDB, "auth-"+name))
+	return gdb, nil
+}
+
+// migrateDatabase applies the embedded migrations. It uses its own
+// connection, which the migrator closes, and a Postgres advisory lock, so
+// replicas starting together migrate once.
+func migrateDatabase(dsn string) error {
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return err
+	}
+	conn, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	driver, err := migratepostgres.WithInstance(conn, &migratepostgres.Config{})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	logger.Info().Uint("version", version).Bool("dirty", dirty).Msg("Database schema up to date")
+	return nil
+}
+
+// readDB is the connection for heavy reads that tolerate replication lag:
+// the replica when configured, the primary otherwise
+func readDB() *gorm.DB {
+	if replicaDB != nil {
+		return replicaDB
+	}
+	return db
+}
+
+// poolCheck pings through the pool and fails while it is exhausted, i.e.
+// every connection is in use and queries had to wait since the last check
+func poolCheck(gdb *gorm.DB) health.Check {
+	var mu sync.Mutex
+	var lastWaits int64
+	return func(ctx context.Context) error {
+		sqlDB, err := gdb.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return err
+		}
+
+		stats := sqlDB.Stats()
+		mu.Lock()
+		waited := stats.WaitCount > lastWaits
+		lastWaits = stats.WaitCount
+		mu.Unlock()
+		if waited && stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+			return fmt.Errorf("pool exhausted: %d/%d connections in use", stats.InUse, stats.MaxOpenConnections)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/outbox"
+	"github.com/MaksimVF/ZB/pkg/outbox/jetstream"
+	"gorm.io/gorm"
+)
+
+// Events auth-service publishes to the AUTH_EVENTS JetStream stream
+const (
+	eventStream             = "AUTH_EVENTS"
+	SubjectUserRegistered   = "auth.user.registered"
+	SubjectPlanChanged      = "auth.plan.changed"
+	SubjectResidencyChanged = "auth.residency.changed"
+	SubjectRoleChanged      = "auth.role.changed"
+	SubjectUserRoleChanged  = "auth.user.role_changed"
+)
+
+type UserRegisteredEvent struct {
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	Plan      string    `json:"plan"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PlanChangedEvent struct {
+	UserID    string `json:"user_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	ChangedBy string `json:"changed_by"`
+}
+
+type ResidencyChangedEvent struct {
+	UserID    string   `json:"user_id"`
+	From      []string `json:"from"`
+	To        []string `json:"to"`
+	ChangedBy string   `json:"changed_by"`
+}
+
+type RoleChangedEvent struct {
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+	Deleted     bool     `json:"deleted,omitempty"`
+	ChangedBy   string   `json:"changed_by"`
+}
+
+type UserRoleChangedEvent struct {
+	UserID    string `json:"user_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	ChangedBy string `json:"changed_by"`
+}
+
+// addEvent writes an event into the outbox inside the gorm transaction tx
+func addEvent(ctx context.Context, tx *gorm.DB, subject string, payload interface{}) error {
+	_, err := outbox.Add(ctx, tx.Statement.ConnPool, subject, payload)
+	return err
+}
+
+// startOutboxRelay publishes the outbox to JetStream (NATS_URL) in the
+// background until ctx is cancelled
+func startOutboxRelay(ctx context.Context) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		url = "nats://nats:4222"
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		logger.Error().Err(err).Msg("Outbox relay disabled")
+		return
+	}
+	go func() {
+		if err := jetstream.Relay(ctx, url, "auth-service", sqlDB, eventStream, "auth.>"); err != nil && ctx.Err() == nil {
+			logger.Error().Err(err).Msg("Outbox relay stopped")
+		}
+	}()
+}
@@ -6,6 +6,7 @@ module github.com/MaksimVF/ZB/services/auth-service
 go 1.21
 
 require (
+	github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/golang-jwt/jwt/v5 v5.0.0
 	github.com/gorilla/mux v1.8.0
@@ -21,4 +22,4 @@ require (
 
 replace github.com/MaksimVF/ZB/services/auth-service/pb => ../pb
 
-
+replace github.com/MaksimVF/ZB => ../..
@@ -6,6 +6,7 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -17,25 +18,33 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics/muxroute"
+	"github.com/MaksimVF/ZB/pkg/rbac"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/residency"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var (
 	db           *gorm.DB
-	rdb          *redis.Client
+	rdb          redis.UniversalClient
 	secret       []byte
 	logger       zerolog.Logger
 	authCounter  = prometheus.NewCounterVec(
@@ -45,14 +54,6 @@ var (
 		},
 		[]string{"operation", "status"},
 	)
-	httpDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"method", "path", "status"},
-	)
 )
 
 const (
@@ -72,7 +73,7 @@ func init() {
 		Logger()
 
 	// Register Prometheus metrics
-	prometheus.MustRegister(authCounter, httpDuration)
+	prometheus.MustRegister(authCounter)
 
 	// Load JWT secret from environment
 	secret = []byte(os.Getenv("JWT_SECRET"))
@@ -80,15 +81,15 @@ func init() {
 		logger.Fatal().Msg("JWT_SECRET environment variable not set")
 	}
 
-	// Initialize Redis
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_ADDR"),
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       0,
-	})
+	// Initialize Redis; standalone, Sentinel or Cluster per REDIS_MODE
+	var err error
+	rdb, err = redisconn.New(context.Background(), redisconn.FromEnv())
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid Redis configuration")
+	}
 
 	// Test Redis connection
-	_, err := rdb.Ping(context.Background()).Result()
+	_, err = rdb.Ping(context.Background()).Result()
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to connect to Redis")
 	}
@@ -96,14 +97,25 @@ func init() {
 	logger.Info().Msg("Auth service initialized successfully")
 }
 
+
 type User struct {
-	ID        string    `gorm:"primaryKey" json:"id"`
-	Email     string    `gorm:"unique" json:"email"`
-	Password  string    `json:"-"`
-	Role      string    `json:"role"` // user, admin, superadmin
-	Balance   float64   `json:"balance_usd"`
-	TOTP      string    `json:"-"` // encrypted secret
-	CreatedAt time.Time `json:"created_at"`
+	ID            string    `gorm:"primaryKey" json:"id"`
+	Email         string    `gorm:"unique" json:"email"`
+	Password      string    `json:"-"`
+	Role          string    `json:"role"`                     // user, admin, superadmin
+	Plan          string    `gorm:"default:free" json:"plan"` // rate limit plan: free, pro, enterprise
+	DataResidency string    `json:"data_residency"`           // regions the user's data may be processed in, e.g. "eu"; empty allows all
+	Balance       float64   `json:"balance_usd"`
+	TOTP          string    `json:"-"` // encrypted secret
+	CreatedAt     time.Time `json:"created_at"`
+	// ExternalID is the user's ID in the identity provider that provisions it
+	ExternalID string `json:"external_id,omitempty"`
+	// DeactivatedAt is set while a deprovisioned user may not sign in or use
+	// its API keys
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+	// SCIMRole is the role last granted by SCIM group membership. SCIM only
+	// changes a role it granted, so a role set through the admin API is kept.
+	SCIMRole string `json:"-"`
 }
 
 type APIKey struct {
@@ -118,29 +130,21 @@ type APIKey struct {
 
 func main() {
 	// Initialize Prometheus metrics
-	prometheus.MustRegister(authCounter, httpDuration)
-
-	// Initialize database
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_PORT"),
-	)
+	prometheus.MustRegister(authCounter)
 
-	var err error
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	shutdownTracing, err := tracing.Init(context.Background(), "auth-service")
 	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to connect to database")
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
 	}
+	defer shutdownTracing(context.Background())
 
-	// Auto migrate database schema
-	err = db.AutoMigrate(&User{}, &APIKey{})
-	if err != nil {
-		logger.Fatal().Err(err).Msg("Failed to migrate database schema")
+	// Initialize database: pool sizing, migrations and the optional read replica
+	if err := openDatabase(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize database")
 	}
+	go syncPlanAssignments()
+	watchRoles(context.Background())
+	startOutboxRelay(context.Background())
 
 	r := mux.NewRouter()
 	r.HandleFunc("/register", Register).Methods("POST")
@@ -150,11 +154,36 @@ func main() {
 	r.HandleFunc("/api-keys", AuthMiddleware(CreateAPIKey)).Methods("POST")
 	r.HandleFunc("/balance", AuthMiddleware(GetBalance)).Methods("GET")
 
+	// Rate limit plan assignments (plan limits are managed in the rate-limiter)
+	r.HandleFunc("/admin/users/{id}/plan", PermissionMiddleware(rbac.UsersRead, GetUserPlan)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}/plan", PermissionMiddleware(rbac.UsersWrite, SetUserPlan)).Methods("PUT")
+
+	// Data residency, enforced by the gateway and routing-service
+	r.HandleFunc("/admin/users/{id}/data-residency", PermissionMiddleware(rbac.UsersRead, GetUserResidency)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}/data-residency", PermissionMiddleware(rbac.UsersWrite, SetUserResidency)).Methods("PUT")
+
+	// Roles and the permissions they grant, shared with routing-service
+	r.HandleFunc("/admin/roles", PermissionMiddleware(rbac.RolesRead, ListRoles)).Methods("GET")
+	r.HandleFunc("/admin/roles/{name}", PermissionMiddleware(rbac.RolesWrite, PutRole)).Methods("PUT")
+	r.HandleFunc("/admin/roles/{name}", PermissionMiddleware(rbac.RolesWrite, DeleteRole)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id}/role", PermissionMiddleware(rbac.RolesWrite, SetUserRole)).Methods("PUT")
+
+	// SCIM 2.0 provisioning for identity providers, enabled by SCIM_TOKEN
+	registerSCIM(r)
+
+	// pprof, expvar and /debug/runtime with DEBUG_ENDPOINTS=true
+	r.PathPrefix(diagnostics.Prefix).Handler(diagnostics.Handler(func(next http.Handler) http.Handler {
+		return PermissionMiddleware(rbac.DiagnosticsRead, next.ServeHTTP)
+	}))
+
 	// Health check endpoint
 	r.HandleFunc("/health", HealthCheck).Methods("GET")
+	checker := newHealthChecker()
+	r.Handle("/livez", checker.LiveHandler()).Methods("GET")
+	r.Handle("/readyz", checker.ReadyHandler()).Methods("GET")
 
 	// Prometheus metrics endpoint
-	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/metrics", httpmetrics.Handler())
 
 	// gRPC for gateway with mTLS
 	go func() {
@@ -168,8 +197,11 @@ func main() {
 			logger.Fatal().Err(err).Msg("Failed to load TLS credentials")
 		}
 
-		s := grpc.NewServer(grpc.Creds(creds))
+		s := grpc.NewServer(grpc.Creds(creds), tracing.ServerOption(), requestid.ServerOption())
 		pb.RegisterAuthServiceServer(s, &server{})
+		if debug := diagnostics.RegisterGRPC(s); len(debug) > 0 {
+			logger.Warn().Strs("services", debug).Msg("gRPC debugging services enabled")
+		}
 		logger.Info().Msg("Auth service gRPC+mTLS listening on :50051")
 		if err := s.Serve(lis); err != nil {
 			logger.Fatal().Err(err).Msg("gRPC server failed")
@@ -177,7 +209,8 @@ func main() {
 	}()
 
 	logger.Info().Msg("Auth service: HTTP :8081 | gRPC+mTLS :50051")
-	log.Fatal(http.ListenAndServe(":8081", r))
+	log.Fatal(http.ListenAndServe(":8081", tracing.Middleware("auth-service", requestid.Middleware(
+		httpmetrics.Middleware("auth-service", muxroute.Template(r))(r)))))
 }
 
 // Custom error types
@@ -197,7 +230,6 @@ func newAuthError(code codes.Code, message, details string) *AuthError {
 
 // === HTTP API ===
 func Register(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	logger.Info().Str("method", "Register").Msg("Received registration request")
 
 	var req struct {
@@ -208,7 +240,6 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logger.Error().Err(err).Msg("Failed to decode request body")
 		http.Error(w, "invalid input", 400)
-		httpDuration.WithLabelValues("POST", "/register", "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -216,7 +247,6 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	if !isValidEmail(req.Email) {
 		logger.Warn().Str("email", req.Email).Msg("Invalid email format")
 		http.Error(w, InvalidEmailError, 400)
-		httpDuration.WithLabelValues("POST", "/register", "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -224,7 +254,6 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	if !isStrongPassword(req.Password) {
 		logger.Warn().Msg("Weak password attempt")
 		http.Error(w, WeakPasswordError, 400)
-		httpDuration.WithLabelValues("POST", "/register", "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -233,7 +262,6 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
 		logger.Warn().Str("email", req.Email).Msg("User already exists")
 		http.Error(w, "user already exists", 409)
-		httpDuration.WithLabelValues("POST", "/register", "409").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -242,7 +270,6 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to hash password")
 		http.Error(w, InternalServerError, 500)
-		httpDuration.WithLabelValues("POST", "/register", "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -252,28 +279,41 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		Email:     req.Email,
 		Password:  string(hash),
 		Role:      "user",
+		Plan:      PlanFree,
 		Balance:   10.0, // starting bonus
 		CreatedAt: time.Now(),
 	}
 
-	if err := db.Create(&user).Error; err != nil {
+	// The user and its event commit together; the outbox relay publishes it
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return addEvent(r.Context(), tx, SubjectUserRegistered, UserRegisteredEvent{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Plan:      user.Plan,
+			CreatedAt: user.CreatedAt,
+		})
+	})
+	if err != nil {
 		logger.Error().Err(err).Str("email", req.Email).Msg("Failed to create user")
 		http.Error(w, InternalServerError, 500)
-		httpDuration.WithLabelValues("POST", "/register", "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	// Generate first API key
 	createAPIKeyForUser(user.ID, "Default key")
+	if err := publishPlan(r.Context(), user); err != nil {
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to publish plan")
+	}
 
 	logger.Info().Str("user_id", user.ID).Msg("User registered successfully")
-	httpDuration.WithLabelValues("POST", "/register", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "user_id": user.ID})
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	logger.Info().Str("method", "Login").Msg("Received login request")
 
 	var req struct {
@@ -284,7 +324,6 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logger.Error().Err(err).Msg("Failed to decode request body")
 		http.Error(w, "invalid input", 400)
-		httpDuration.WithLabelValues("POST", "/login", "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -292,14 +331,17 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
 		logger.Warn().Str("email", req.Email).Msg("User not found")
 		http.Error(w, InvalidCredentialsError, 401)
-		httpDuration.WithLabelValues("POST", "/login", "401").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
 		logger.Warn().Str("email", req.Email).Msg("Invalid password attempt")
 		http.Error(w, InvalidCredentialsError, 401)
-		httpDuration.WithLabelValues("POST", "/login", "401").Observe(time.Since(start).Seconds())
+		return
+	}
+	if user.DeactivatedAt != nil {
+		logger.Warn().Str("user_id", user.ID).Msg("Login of a deactivated user")
+		http.Error(w, InvalidCredentialsError, 401)
 		return
 	}
 
@@ -308,6 +350,7 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		"user_id": user.ID,
 		"email":   user.Email,
 		"role":    user.Role,
+		"plan":    user.Plan,
 		"exp":     time.Now().Add(30 * 24 * time.Hour).Unix(),
 	})
 
@@ -315,12 +358,10 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to sign JWT token")
 		http.Error(w, InternalServerError, 500)
-		httpDuration.WithLabelValues("POST", "/login", "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	logger.Info().Str("user_id", user.ID).Msg("User logged in successfully")
-	httpDuration.WithLabelValues("POST", "/login", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"token":   signed,
@@ -330,59 +371,49 @@ func Login(w http.ResponseWriter, r *http.Request) {
 }
 
 func Me(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	user := r.Context().Value("user").(User)
 
 	logger.Info().Str("user_id", user.ID).Msg("User info request")
-	httpDuration.WithLabelValues("GET", "/me", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
 func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	user := r.Context().Value("user").(User)
 
 	logger.Info().Str("user_id", user.ID).Msg("List API keys request")
 	keys := getUserAPIKeys(user.ID)
-	httpDuration.WithLabelValues("GET", "/api-keys", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(keys)
 }
 
 func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	user := r.Context().Value("user").(User)
 
 	var req struct { Name string }
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logger.Error().Err(err).Msg("Failed to decode request body")
 		http.Error(w, "invalid input", 400)
-		httpDuration.WithLabelValues("POST", "/api-keys", "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	if req.Name == "" {
 		logger.Warn().Msg("API key name is required")
 		http.Error(w, "name is required", 400)
-		httpDuration.WithLabelValues("POST", "/api-keys", "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	createAPIKeyForUser(user.ID, req.Name)
 
 	logger.Info().Str("user_id", user.ID).Str("key_name", req.Name).Msg("API key created")
-	httpDuration.WithLabelValues("POST", "/api-keys", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "created"})
 }
 
 func GetBalance(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	user := r.Context().Value("user").(User)
 
 	logger.Info().Str("user_id", user.ID).Msg("Balance check request")
-	httpDuration.WithLabelValues("GET", "/balance", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"balance": user.Balance,
@@ -408,12 +439,22 @@ func createAPIKeyForUser(userID, name string) {
 
 	if err := db.Create(&apiKey).Error; err != nil {
 		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to create API key")
+		return
+	}
+
+	// The key gets its owner's rate limit plan and data residency
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err == nil {
+		rdb.Set(context.Background(), planAssignmentPrefix+"key:"+key, user.Plan, 0)
+		if user.DataResidency != "" {
+			rdb.Set(context.Background(), residency.KeyPrefix+"key:"+key, user.DataResidency, 0)
+		}
 	}
 }
 
 func getUserAPIKeys(userID string) []map[string]interface{} {
 	var keys []APIKey
-	if err := db.Where("user_id = ?", userID).Find(&keys).Error; err != nil {
+	if err := readDB().Where("user_id = ?", userID).Find(&keys).Error; err != nil {
 		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get API keys")
 		return []map[string]interface{}{}
 	}
@@ -434,7 +475,15 @@ func getUserAPIKeys(userID string) []map[string]interface{} {
 // === gRPC for gateway ===
 type server struct{ pb.UnimplementedAuthServiceServer }
 
+// requestLogger returns the logger with the request ID the caller sent in gRPC
+// metadata
+func requestLogger(ctx context.Context) zerolog.Logger {
+	return logger.With().Str("request_id", requestid.FromContext(ctx)).Logger()
+}
+
 func (s *server) ValidateAPIKey(ctx context.Context, req *pb.ValidateRequest) (*pb.ValidateResponse, error) {
+	logger := requestLogger(ctx)
+
 	key := req.ApiKey
 	if !strings.HasPrefix(key, "tvo_") {
 		return &pb.ValidateResponse{Valid: false}, nil
@@ -442,11 +491,17 @@ func (s *server) ValidateAPIKey(ctx context.Context, req *pb.ValidateRequest) (*
 
 	var apiKey APIKey
 	if err := db.Where("key = ?", key).First(&apiKey).Error; err != nil {
+		logger.Debug().Err(err).Msg("API key not found")
 		return &pb.ValidateResponse{Valid: false}, nil
 	}
 
 	var user User
 	if err := db.First(&user, "id = ?", apiKey.UserID).Error; err != nil {
+		logger.Warn().Err(err).Str("user_id", apiKey.UserID).Msg("API key owner not found")
+		return &pb.ValidateResponse{Valid: false}, nil
+	}
+	if user.DeactivatedAt != nil {
+		logger.Debug().Str("user_id", user.ID).Msg("API key of a deactivated user")
 		return &pb.ValidateResponse{Valid: false}, nil
 	}
 
@@ -461,7 +516,6 @@ func (s *server) ValidateAPIKey(ctx context.Context, req *pb.ValidateRequest) (*
 // === Middleware ===
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
 		tokenStr := r.Header.Get("Authorization")
 		if strings.HasPrefix(tokenStr, "Bearer ") {
 			tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
@@ -472,7 +526,6 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 				logger.Warn().Msg("Invalid JWT signing method")
 				http.Error(w, UnauthorizedError, 401)
-				httpDuration.WithLabelValues(r.Method, r.URL.Path, "401").Observe(time.Since(start).Seconds())
 				return nil, fmt.Errorf("invalid signing method")
 			}
 			return secret, nil
@@ -481,7 +534,6 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		if err != nil {
 			logger.Warn().Err(err).Msg("JWT parsing failed")
 			http.Error(w, UnauthorizedError, 401)
-			httpDuration.WithLabelValues(r.Method, r.URL.Path, "401").Observe(time.Since(start).Seconds())
 			return
 		}
 
@@ -490,7 +542,11 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			if err := db.First(&user, "id = ?", claims["user_id"]).Error; err != nil {
 				logger.Warn().Str("user_id", claims["user_id"].(string)).Msg("User not found")
 				http.Error(w, UnauthorizedError, 401)
-				httpDuration.WithLabelValues(r.Method, r.URL.Path, "401").Observe(time.Since(start).Seconds())
+				return
+			}
+			if user.DeactivatedAt != nil {
+				logger.Warn().Str("user_id", user.ID).Msg("Token of a deactivated user")
+				http.Error(w, UnauthorizedError, 401)
 				return
 			}
 			ctx := context.WithValue(r.Context(), "user", user)
@@ -500,13 +556,11 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 		logger.Warn().Msg("Invalid JWT token")
 		http.Error(w, UnauthorizedError, 401)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "401").Observe(time.Since(start).Seconds())
 	}
 }
 
 func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
 		ip := r.RemoteAddr
 		key := fmt.Sprintf("rate_limit:%s", ip)
 
@@ -515,7 +569,6 @@ func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		if err == nil && count >= 5 {
 			logger.Warn().Str("ip", ip).Msg("Rate limit exceeded")
 			http.Error(w, RateLimitExceededError, 429)
-			httpDuration.WithLabelValues(r.Method, r.URL.Path, "429").Observe(time.Since(start).Seconds())
 			return
 		}
 
@@ -533,13 +586,11 @@ func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
 }
 
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 
 	// Check database
 	if err := db.Exec("SELECT 1").Error; err != nil {
 		logger.Error().Err(err).Msg("Database health check failed")
 		http.Error(w, "database unhealthy", 503)
-		httpDuration.WithLabelValues("GET", "/health", "503").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -548,16 +599,27 @@ func HealthCheck(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error().Err(err).Msg("Redis health check failed")
 		http.Error(w, "redis unhealthy", 503)
-		httpDuration.WithLabelValues("GET", "/health", "503").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	logger.Info().Msg("Health check passed")
-	httpDuration.WithLabelValues("GET", "/health", "200").Observe(time.Since(start).Seconds())
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
+// newHealthChecker reports the Postgres pools and Redis on /readyz
+func newHealthChecker() *health.Checker {
+	checker := health.New("auth-service").
+		Add("postgres", poolCheck(db)).
+		Add("redis", func(ctx context.Context) error {
+			return rdb.Ping(ctx).Err()
+		})
+	if replicaDB != nil {
+		checker.Add("postgres-replica", poolCheck(replicaDB))
+	}
+	return checker
+}
+
 // === Helper Functions ===
 func isValidEmail(email string) bool {
 	// Simple email validation
@@ -573,32 +635,11 @@ func isStrongPassword(password string) bool {
 }
 
 func loadTLSCredentials() (credentials.TransportCredentials, error) {
-	// Load server certificate and key
-	serverCert, err := tls.LoadX509KeyPair("/certs/auth-service.pem", "/certs/auth-service-key.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load server certificate: %w", err)
-	}
-
-	// Load CA certificate for client verification
-	caCert, err := os.ReadFile("/certs/ca.pem")
+	serverCerts, err := certs.Watch("auth-service", "/certs/auth-service.pem", "/certs/auth-service-key.pem", "/certs/ca.pem")
 	if err != nil {
-		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
-	}
-
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to add CA certificate to pool")
+		return nil, err
 	}
-
-	// Create TLS config with proper validation
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    certPool,
-		MinVersion:   tls.VersionTLS12,
-	}
-
-	return credentials.NewTLS(tlsConfig), nil
+	return credentials.NewTLS(serverCerts.ServerConfig(tls.RequireAndVerifyClientCert, serverCerts.AuthorizeFromEnv())), nil
 }
 
 
@@ -46,6 +46,12 @@ func setupTestEnvironment() {
 	if err != nil {
 		panic("failed to migrate database")
 	}
+	err = db.Exec(`CREATE TABLE IF NOT EXISTS outbox (
+		id text PRIMARY KEY, subject text, payload text, headers text,
+		created_at datetime DEFAULT CURRENT_TIMESTAMP, published_at datetime)`).Error
+	if err != nil {
+		panic("failed to create outbox table")
+	}
 }
 
 func TestRegister(t *testing.T) {
@@ -114,6 +120,30 @@ func TestRegister(t *testing.T) {
 	}
 }
 
+func TestRegisterWritesEvent(t *testing.T) {
+	setupTestEnvironment()
+
+	reqBytes, _ := json.Marshal(map[string]string{
+		"email":    "outbox-test@example.com",
+		"password": "StrongPass123",
+	})
+	rr := httptest.NewRecorder()
+	Register(rr, httptest.NewRequest("POST", "/register", strings.NewReader(string(reqBytes))))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var response map[string]string
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&response))
+
+	var payload string
+	err := db.Raw("SELECT payload FROM outbox WHERE subject = ? AND payload LIKE ?",
+		SubjectUserRegistered, "%"+response["user_id"]+"%").Scan(&payload).Error
+	require.NoError(t, err)
+	var event UserRegisteredEvent
+	require.NoError(t, json.Unmarshal([]byte(payload), &event))
+	assert.Equal(t, "outbox-test@example.com", event.Email)
+	assert.Equal(t, PlanFree, event.Plan)
+}
+
 func TestLogin(t *testing.T) {
 	setupTestEnvironment()
 
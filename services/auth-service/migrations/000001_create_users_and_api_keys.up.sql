@@ -0,0 +1,24 @@
+-- Schema previously created by gorm.AutoMigrate; IF NOT EXISTS keeps this a
+-- no-op on existing databases
+CREATE TABLE IF NOT EXISTS users (
+    id         text PRIMARY KEY,
+    email      text UNIQUE,
+    password   text,
+    role       text,
+    plan       text DEFAULT 'free',
+    balance    numeric,
+    totp       text,
+    created_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id      text PRIMARY KEY,
+    user_id text,
+    key     text UNIQUE,
+    prefix  text,
+    name    text,
+    active  boolean,
+    created timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,11 @@
+-- Transactional outbox, see pkg/outbox
+CREATE TABLE IF NOT EXISTS outbox (
+    id           text PRIMARY KEY,
+    subject      text NOT NULL,
+    payload      jsonb NOT NULL,
+    headers      jsonb NOT NULL DEFAULT '{}',
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    published_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_unpublished ON outbox (created_at) WHERE published_at IS NULL;
@@ -0,0 +1,2 @@
+-- Regions a user's requests may be processed in, comma-separated; empty allows all
+ALTER TABLE users ADD COLUMN IF NOT EXISTS data_residency text NOT NULL DEFAULT '';
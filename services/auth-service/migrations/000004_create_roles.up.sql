@@ -0,0 +1,16 @@
+-- Roles and the permissions they grant, see pkg/rbac; seeded with the roles
+-- that were built in before
+CREATE TABLE IF NOT EXISTS roles (
+    name        text PRIMARY KEY,
+    description text NOT NULL DEFAULT '',
+    permissions jsonb NOT NULL DEFAULT '[]',
+    updated_at  timestamptz NOT NULL DEFAULT now()
+);
+
+INSERT INTO roles (name, description, permissions) VALUES
+    ('superadmin', 'Everything', '["*"]'),
+    ('admin', 'Users, routing policy, heads and rollouts', '["users.read", "users.write", "roles.read", "policy.write", "heads.write", "rollouts.write", "diagnostics.read"]'),
+    ('operator', 'Heads and rollouts', '["heads.write", "rollouts.write"]'),
+    ('viewer', 'Read-only', '[]'),
+    ('user', 'API user', '[]')
+ON CONFLICT (name) DO NOTHING;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS scim_group_members;
+DROP TABLE IF EXISTS scim_groups;
+ALTER TABLE users DROP COLUMN IF EXISTS deactivated_at;
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
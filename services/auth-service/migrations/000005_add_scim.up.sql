@@ -0,0 +1,20 @@
+-- SCIM provisioning: the identity provider's ID of a user, deprovisioned
+-- users, and the groups it maps to roles
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deactivated_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS scim_groups (
+    id           text PRIMARY KEY,
+    display_name text NOT NULL UNIQUE,
+    external_id  text NOT NULL DEFAULT '',
+    created_at   timestamptz NOT NULL DEFAULT now(),
+    updated_at   timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS scim_group_members (
+    group_id text NOT NULL REFERENCES scim_groups (id) ON DELETE CASCADE,
+    user_id  text NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_group_members_user_id ON scim_group_members (user_id);
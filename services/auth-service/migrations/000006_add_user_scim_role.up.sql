@@ -0,0 +1,2 @@
+-- The role SCIM group membership last granted a user; SCIM leaves other roles alone
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scim_role text NOT NULL DEFAULT '';
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Rate limit plans. The rate-limiter owns the limits of each plan; auth-service
+// owns which plan a user is on.
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+)
+
+var plans = map[string]bool{PlanFree: true, PlanPro: true, PlanEnterprise: true}
+
+// The rate-limiter resolves a client's plan from rate_limit:plan:<client ID>,
+// where the client ID is user:<id> for JWTs and key:<api key> for API keys
+const planAssignmentPrefix = "rate_limit:plan:"
+
+// publishPlan writes the plan of a user and of all their API keys to Redis
+func publishPlan(ctx context.Context, user User) error {
+	var keys []APIKey
+	if err := db.Where("user_id = ? AND active = ?", user.ID, true).Find(&keys).Error; err != nil {
+		return err
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, planAssignmentPrefix+"user:"+user.ID, user.Plan, 0)
+	for _, k := range keys {
+		pipe.Set(ctx, planAssignmentPrefix+"key:"+k.Key, user.Plan, 0)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// syncPlanAssignments republishes all plan and data residency assignments, so
+// the rate-limiter and the gateway see them after a Redis flush
+func syncPlanAssignments() {
+	var users []User
+	synced := 0
+	err := readDB().FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+		for _, user := range users {
+			if err := publishPlan(context.Background(), user); err != nil {
+				logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to publish plan")
+				continue
+			}
+			if err := publishResidency(context.Background(), user); err != nil {
+				logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to publish data residency")
+				continue
+			}
+			synced++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to load plan assignments")
+		return
+	}
+	logger.Info().Int("users", synced).Msg("Plan assignments synced to Redis")
+}
+
+// GetUserPlan handles GET /admin/users/{id}/plan
+func GetUserPlan(w http.ResponseWriter, r *http.Request) {
+
+	var user User
+	if err := db.First(&user, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "user not found", 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user_id": user.ID, "plan": user.Plan})
+}
+
+// SetUserPlan handles PUT /admin/users/{id}/plan
+func SetUserPlan(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value("user").(User)
+
+	var req struct {
+		Plan string `json:"plan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !plans[req.Plan] {
+		http.Error(w, "plan must be one of free, pro, enterprise", 400)
+		return
+	}
+
+	var user User
+	if err := db.First(&user, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "user not found", 404)
+		return
+	}
+
+	previous := user.Plan
+	user.Plan = req.Plan
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("plan", user.Plan).Error; err != nil {
+			return err
+		}
+		return addEvent(r.Context(), tx, SubjectPlanChanged, PlanChangedEvent{
+			UserID:    user.ID,
+			From:      previous,
+			To:        user.Plan,
+			ChangedBy: admin.ID,
+		})
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update plan")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+	if err := publishPlan(r.Context(), user); err != nil {
+		// The stored plan is authoritative and republished on restart
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to publish plan to rate-limiter")
+	}
+
+	logger.Info().Str("user_id", user.ID).Str("admin_id", admin.ID).
+		Str("from", previous).Str("to", user.Plan).Msg("User plan changed")
+	authCounter.WithLabelValues("set_plan", "success").Inc()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user_id": user.ID, "plan": user.Plan})
+}
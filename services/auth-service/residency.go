@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"github.com/MaksimVF/ZB/pkg/residency"
+)
+
+// publishResidency writes the data residency of a user and of all their API
+// keys to Redis, where the gateway and tail read it; an empty residency
+// removes the keys
+func publishResidency(ctx context.Context, user User) error {
+	var keys []APIKey
+	if err := db.Where("user_id = ? AND active = ?", user.ID, true).Find(&keys).Error; err != nil {
+		return err
+	}
+
+	clientIDs := []string{"user:" + user.ID}
+	for _, k := range keys {
+		clientIDs = append(clientIDs, "key:"+k.Key)
+	}
+
+	pipe := rdb.TxPipeline()
+	for _, clientID := range clientIDs {
+		if user.DataResidency == "" {
+			pipe.Del(ctx, residency.KeyPrefix+clientID)
+		} else {
+			pipe.Set(ctx, residency.KeyPrefix+clientID, user.DataResidency, 0)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func residencyResponse(user User) map[string]interface{} {
+	regions := residency.Parse(user.DataResidency)
+	if regions == nil {
+		regions = []string{}
+	}
+	return map[string]interface{}{"user_id": user.ID, "regions": regions}
+}
+
+// GetUserResidency handles GET /admin/users/{id}/data-residency
+func GetUserResidency(w http.ResponseWriter, r *http.Request) {
+
+	var user User
+	if err := db.First(&user, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "user not found", 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(residencyResponse(user))
+}
+
+// SetUserResidency handles PUT /admin/users/{id}/data-residency. An empty
+// region list lifts the restriction.
+func SetUserResidency(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value("user").(User)
+
+	var req struct {
+		Regions []string `json:"regions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `body must be {"regions": ["eu", ...]}`, 400)
+		return
+	}
+	regions := residency.Parse(residency.Format(req.Regions))
+	if err := residency.Validate(regions); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var user User
+	if err := db.First(&user, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "user not found", 404)
+		return
+	}
+
+	previous := residency.Parse(user.DataResidency)
+	user.DataResidency = residency.Format(regions)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("data_residency", user.DataResidency).Error; err != nil {
+			return err
+		}
+		return addEvent(r.Context(), tx, SubjectResidencyChanged, ResidencyChangedEvent{
+			UserID:    user.ID,
+			From:      previous,
+			To:        regions,
+			ChangedBy: admin.ID,
+		})
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update data residency")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+	if err := publishResidency(r.Context(), user); err != nil {
+		// The stored residency is authoritative and republished on restart
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to publish data residency")
+	}
+
+	logger.Info().Str("user_id", user.ID).Str("admin_id", admin.ID).
+		Strs("from", previous).Strs("to", regions).Msg("User data residency changed")
+	authCounter.WithLabelValues("set_data_residency", "success").Inc()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(residencyResponse(user))
+}
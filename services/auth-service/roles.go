@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/rbac"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// roles answers the permission checks of the admin API; it starts with the
+// built-in roles and follows the roles table once watchRoles has run
+var roles = rbac.New(nil, rbac.Defaults()...)
+
+// Role is a row of the roles table
+type Role struct {
+	Name        string    `gorm:"primaryKey" json:"name"`
+	Description string    `json:"description"`
+	Permissions []string  `gorm:"serializer:json" json:"permissions"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func (r Role) rbac() rbac.Role {
+	return rbac.Role{Name: r.Name, Description: r.Description, Permissions: r.Permissions, UpdatedAt: r.UpdatedAt}
+}
+
+// PermissionMiddleware allows users whose role grants the permission
+func PermissionMiddleware(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value("user").(User)
+		if !roles.Allows(user.Role, permission) {
+			logger.Warn().Str("user_id", user.ID).Str("role", user.Role).Str("permission", permission).Str("path", r.URL.Path).Msg("Admin access denied")
+			http.Error(w, "forbidden", 403)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// syncRoles loads the roles table and publishes it to Redis for the other
+// services and replicas
+func syncRoles(ctx context.Context) error {
+	var rows []Role
+	if err := db.Order("name").Find(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return errors.New("the roles table is empty")
+	}
+	list := make([]rbac.Role, 0, len(rows))
+	for _, row := range rows {
+		list = append(list, row.rbac())
+	}
+	roles.Set(list)
+	return rbac.Publish(ctx, rdb, list)
+}
+
+// watchRoles loads the roles and keeps them in sync with changes made on
+// other replicas; it runs before the server starts
+func watchRoles(ctx context.Context) {
+	if err := syncRoles(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to sync roles, using the built-in roles")
+	}
+	roles = rbac.New(rdb, roles.List()...)
+	roles.Watch(ctx, time.Minute)
+}
+
+// ListRoles handles GET /admin/roles
+func ListRoles(w http.ResponseWriter, r *http.Request) {
+	var rows []Role
+	if err := db.Order("name").Find(&rows).Error; err != nil {
+		logger.Error().Err(err).Msg("Failed to list roles")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"roles": rows})
+}
+
+// PutRole handles PUT /admin/roles/{name}, creating or replacing a role.
+// superadmin cannot be changed, so it always keeps every permission.
+func PutRole(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value("user").(User)
+	if mux.Vars(r)["name"] == "superadmin" {
+		http.Error(w, "the superadmin role cannot be changed", 409)
+		return
+	}
+
+	var req struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `body must be {"description": "...", "permissions": ["heads.write", ...]}`, 400)
+		return
+	}
+	if req.Permissions == nil {
+		req.Permissions = []string{}
+	}
+	role := Role{Name: mux.Vars(r)["name"], Description: req.Description, Permissions: req.Permissions, UpdatedAt: time.Now().UTC()}
+	if err := role.rbac().Validate(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&role).Error; err != nil {
+			return err
+		}
+		return addEvent(r.Context(), tx, SubjectRoleChanged, RoleChangedEvent{
+			Role:        role.Name,
+			Permissions: role.Permissions,
+			ChangedBy:   admin.ID,
+		})
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("role", role.Name).Msg("Failed to save role")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+	if err := syncRoles(r.Context()); err != nil {
+		logger.Error().Err(err).Str("role", role.Name).Msg("Failed to publish roles")
+	}
+
+	logger.Info().Str("role", role.Name).Strs("permissions", role.Permissions).Str("changed_by", admin.ID).Msg("Role saved")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+// DeleteRole handles DELETE /admin/roles/{name}. Roles still assigned to
+// users, and superadmin, cannot be deleted.
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value("user").(User)
+	name := mux.Vars(r)["name"]
+	if name == "superadmin" {
+		http.Error(w, "the superadmin role cannot be deleted", 409)
+		return
+	}
+
+	var assigned int64
+	if err := db.Model(&User{}).Where("role = ?", name).Count(&assigned).Error; err != nil {
+		logger.Error().Err(err).Str("role", name).Msg("Failed to count role users")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+	if assigned > 0 {
+		http.Error(w, "role is assigned to users", 409)
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&Role{}, "name = ?", name)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return addEvent(r.Context(), tx, SubjectRoleChanged, RoleChangedEvent{Role: name, Deleted: true, ChangedBy: admin.ID})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "role not found", 404)
+		return
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("role", name).Msg("Failed to delete role")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+	if err := syncRoles(r.Context()); err != nil {
+		logger.Error().Err(err).Str("role", name).Msg("Failed to publish roles")
+	}
+
+	logger.Info().Str("role", name).Str("changed_by", admin.ID).Msg("Role deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetUserRole handles PUT /admin/users/{id}/role
+func SetUserRole(w http.ResponseWriter, r *http.Request) {
+	admin := r.Context().Value("user").(User)
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Role == "" {
+		http.Error(w, `body must be {"role": "..."}`, 400)
+		return
+	}
+	if err := db.First(&Role{}, "name = ?", req.Role).Error; err != nil {
+		http.Error(w, "role not found", 400)
+		return
+	}
+
+	var user User
+	if err := db.First(&user, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "user not found", 404)
+		return
+	}
+
+	previous := user.Role
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("role", req.Role).Error; err != nil {
+			return err
+		}
+		return addEvent(r.Context(), tx, SubjectUserRoleChanged, UserRoleChangedEvent{
+			UserID:    user.ID,
+			From:      previous,
+			To:        req.Role,
+			ChangedBy: admin.ID,
+		})
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to update role")
+		http.Error(w, InternalServerError, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"user_id": user.ID, "role": req.Role})
+}
@@ -0,0 +1,1141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/MaksimVF/ZB/pkg/rbac"
+)
+
+// SCIM 2.0 (RFC 7643, RFC 7644) provisioning for identity providers, under
+// /scim/v2 with the bearer token SCIM_TOKEN
+const (
+	scimPrefix        = "/scim/v2"
+	scimUserSchema    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema    = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema   = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema   = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimConfigSchema  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimContentType   = "application/scim+json"
+	scimDefaultCount  = 100
+	scimMaxCount      = 200
+	scimChangedBy     = "scim"
+	scimDefaultRole   = "user"
+	scimGroupRolesEnv = "SCIM_GROUP_ROLES"
+)
+
+// Group is a SCIM group; SCIM_GROUP_ROLES maps groups to roles
+type Group struct {
+	ID          string `gorm:"primaryKey"`
+	DisplayName string `gorm:"unique"`
+	ExternalID  string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func (Group) TableName() string { return "scim_groups" }
+
+// GroupMember puts a user in a group
+type GroupMember struct {
+	GroupID string `gorm:"primaryKey"`
+	UserID  string `gorm:"primaryKey"`
+}
+
+func (GroupMember) TableName() string { return "scim_group_members" }
+
+// scimError is a SCIM error response; scimType is set for 400 and 409
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string { return e.detail }
+
+func scimBadRequest(scimType, format string, args ...interface{}) *scimError {
+	return &scimError{status: 400, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+var errSCIMNotFound = &scimError{status: 404, detail: "resource not found"}
+
+func writeSCIM(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeSCIMError answers err in the SCIM error format
+func writeSCIMError(w http.ResponseWriter, err error) {
+	var se *scimError
+	if !errors.As(err, &se) {
+		logger.Error().Err(err).Msg("SCIM request failed")
+		se = &scimError{status: 500, detail: InternalServerError}
+	}
+	body := map[string]interface{}{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(se.status),
+		"detail":  se.detail,
+	}
+	if se.scimType != "" {
+		body["scimType"] = se.scimType
+	}
+	writeSCIM(w, se.status, body)
+}
+
+// registerSCIM mounts the SCIM endpoints; without SCIM_TOKEN they answer 404
+func registerSCIM(r *mux.Router) {
+	s := r.PathPrefix(scimPrefix).Subrouter()
+	s.Use(scimAuth(os.Getenv("SCIM_TOKEN")))
+	s.HandleFunc("/ServiceProviderConfig", scimServiceProviderConfig).Methods("GET")
+	s.HandleFunc("/Users", scimListUsers).Methods("GET")
+	s.HandleFunc("/Users", scimCreateUser).Methods("POST")
+	s.HandleFunc("/Users/{id}", scimGetUser).Methods("GET")
+	s.HandleFunc("/Users/{id}", scimReplaceUser).Methods("PUT")
+	s.HandleFunc("/Users/{id}", scimPatchUser).Methods("PATCH")
+	s.HandleFunc("/Users/{id}", scimDeleteUser).Methods("DELETE")
+	s.HandleFunc("/Groups", scimListGroups).Methods("GET")
+	s.HandleFunc("/Groups", scimCreateGroup).Methods("POST")
+	s.HandleFunc("/Groups/{id}", scimGetGroup).Methods("GET")
+	s.HandleFunc("/Groups/{id}", scimReplaceGroup).Methods("PUT")
+	s.HandleFunc("/Groups/{id}", scimPatchGroup).Methods("PATCH")
+	s.HandleFunc("/Groups/{id}", scimDeleteGroup).Methods("DELETE")
+}
+
+// scimAuth checks the bearer token of the identity provider
+func scimAuth(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				writeSCIMError(w, &scimError{status: 404, detail: "SCIM provisioning is disabled"})
+				return
+			}
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				writeSCIMError(w, &scimError{status: 401, detail: UnauthorizedError})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func scimServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, 200, map[string]interface{}{
+		"schemas":        []string{scimConfigSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{
+			{"type": "oauthbearertoken", "name": "Bearer token", "description": "SCIM_TOKEN of auth-service"},
+		},
+	})
+}
+
+// === Filtering and pagination ===
+
+var scimFilter = regexp.MustCompile(`^\s*([A-Za-z.\[\]" ]+?)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+
+// parseSCIMFilter supports `attribute eq "value"` on the attributes in
+// columns, which maps SCIM attribute names (lowercase) to columns
+func parseSCIMFilter(filter string, columns map[string]string) (column, value string, err error) {
+	if filter == "" {
+		return "", "", nil
+	}
+	m := scimFilter.FindStringSubmatch(filter)
+	if m == nil {
+		return "", "", scimBadRequest("invalidFilter", "only filters of the form attribute eq \"value\" are supported")
+	}
+	column, ok := columns[strings.ToLower(m[1])]
+	if !ok {
+		return "", "", scimBadRequest("invalidFilter", "filtering on %s is not supported", m[1])
+	}
+	value, err = strconv.Unquote(`"` + m[2] + `"`)
+	if err != nil {
+		return "", "", scimBadRequest("invalidFilter", "invalid filter value")
+	}
+	return column, value, nil
+}
+
+// scimPage reads startIndex (1-based) and count
+func scimPage(r *http.Request) (offset, limit int) {
+	offset, limit = 0, scimDefaultCount
+	if n, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil && n > 1 {
+		offset = n - 1
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && n >= 0 {
+		limit = n
+	}
+	if limit > scimMaxCount {
+		limit = scimMaxCount
+	}
+	return offset, limit
+}
+
+func scimList(total int64, offset int, resources []interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   offset + 1,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
+
+// === Users ===
+
+type scimName struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// scimUserRequest is the part of a SCIM user auth-service keeps
+type scimUserRequest struct {
+	UserName   string     `json:"userName"`
+	ExternalID string     `json:"externalId"`
+	Emails     []scimName `json:"emails"`
+	Active     *bool      `json:"active"`
+	Password   string     `json:"password"`
+}
+
+// email is the userName, or else the primary email
+func (req scimUserRequest) email() string {
+	if req.UserName != "" {
+		return strings.TrimSpace(req.UserName)
+	}
+	for _, e := range req.Emails {
+		if e.Primary {
+			return strings.TrimSpace(e.Value)
+		}
+	}
+	if len(req.Emails) > 0 {
+		return strings.TrimSpace(req.Emails[0].Value)
+	}
+	return ""
+}
+
+func scimUser(user User, groups []Group) map[string]interface{} {
+	memberOf := make([]scimName, 0, len(groups))
+	for _, g := range groups {
+		memberOf = append(memberOf, scimName{Value: g.ID, Display: g.DisplayName})
+	}
+	resource := map[string]interface{}{
+		"schemas":  []string{scimUserSchema},
+		"id":       user.ID,
+		"userName": user.Email,
+		"emails":   []scimName{{Value: user.Email, Primary: true, Type: "work"}},
+		"active":   user.DeactivatedAt == nil,
+		"groups":   memberOf,
+		"roles":    []map[string]string{{"value": user.Role}},
+		"meta": map[string]interface{}{
+			"resourceType": "User",
+			"created":      user.CreatedAt,
+			"location":     scimPrefix + "/Users/" + user.ID,
+		},
+	}
+	if user.ExternalID != "" {
+		resource["externalId"] = user.ExternalID
+	}
+	return resource
+}
+
+var scimUserColumns = map[string]string{
+	"username":                     "email",
+	"emails.value":                 "email",
+	`emails[type eq "work"].value`: "email",
+	"externalid":                   "external_id",
+	"id":                           "id",
+}
+
+// userGroups returns the groups of each user
+func userGroups(tx *gorm.DB, userIDs []string) (map[string][]Group, error) {
+	var rows []struct {
+		UserID string
+		Group
+	}
+	err := tx.Table("scim_group_members").
+		Select("scim_group_members.user_id, scim_groups.*").
+		Joins("JOIN scim_groups ON scim_groups.id = scim_group_members.group_id").
+		Where("scim_group_members.user_id IN ?", userIDs).
+		Order("scim_groups.display_name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]Group, len(userIDs))
+	for _, row := range rows {
+		groups[row.UserID] = append(groups[row.UserID], row.Group)
+	}
+	return groups, nil
+}
+
+func scimListUsers(w http.ResponseWriter, r *http.Request) {
+	column, value, err := parseSCIMFilter(r.URL.Query().Get("filter"), scimUserColumns)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	offset, limit := scimPage(r)
+
+	query := db.Model(&User{})
+	if column == "email" {
+		// userName is case-insensitive
+		query = query.Where("lower(email) = lower(?)", value)
+	} else if column != "" {
+		query = query.Where(column+" = ?", value)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	var users []User
+	if limit > 0 {
+		if err := query.Order("created_at, id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+	}
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	groups, err := userGroups(db, ids)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	resources := make([]interface{}, 0, len(users))
+	for _, u := range users {
+		resources = append(resources, scimUser(u, groups[u.ID]))
+	}
+	writeSCIM(w, 200, scimList(total, offset, resources))
+}
+
+func scimGetUser(w http.ResponseWriter, r *http.Request) {
+	var user User
+	if err := db.First(&user, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		writeSCIMError(w, errSCIMNotFound)
+		return
+	}
+	writeUser(w, 200, user)
+}
+
+func writeUser(w http.ResponseWriter, status int, user User) {
+	groups, err := userGroups(db, []string{user.ID})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	writeSCIM(w, status, scimUser(user, groups[user.ID]))
+}
+
+func scimCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req scimUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSCIMError(w, scimBadRequest("invalidSyntax", "invalid JSON"))
+		return
+	}
+	email := req.email()
+	if !isValidEmail(email) {
+		writeSCIMError(w, scimBadRequest("invalidValue", "userName must be an email address"))
+		return
+	}
+	var existing User
+	if err := db.Where("lower(email) = lower(?)", email).First(&existing).Error; err == nil {
+		writeSCIMError(w, &scimError{status: 409, scimType: "uniqueness", detail: "a user with this userName exists"})
+		return
+	}
+
+	user := User{
+		ID:         uuid.New().String(),
+		Email:      email,
+		Role:       scimDefaultRole,
+		Plan:       PlanFree,
+		ExternalID: req.ExternalID,
+		CreatedAt:  time.Now(),
+	}
+	// Provisioned users without a password cannot log in with one
+	if req.Password != "" {
+		if !isStrongPassword(req.Password) {
+			writeSCIMError(w, scimBadRequest("invalidValue", WeakPasswordError))
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+		user.Password = string(hash)
+	}
+	if req.Active != nil && !*req.Active {
+		user.DeactivatedAt = &user.CreatedAt
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return addEvent(r.Context(), tx, SubjectUserRegistered, UserRegisteredEvent{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Plan:      user.Plan,
+			CreatedAt: user.CreatedAt,
+		})
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	if err := publishPlan(r.Context(), user); err != nil {
+		logger.Error().Err(err).Str("user_id", user.ID).Msg("Failed to publish plan")
+	}
+
+	logger.Info().Str("user_id", user.ID).Str("external_id", user.ExternalID).Msg("User provisioned")
+	writeUser(w, 201, user)
+}
+
+// updateUser applies fn to a user and saves it in a transaction
+func updateUser(ctx context.Context, id string, fn func(*User) error) (User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&user, "id = ?", id).Error; err != nil {
+			return errSCIMNotFound
+		}
+		wasActive := user.DeactivatedAt == nil
+		if err := fn(&user); err != nil {
+			return err
+		}
+		if !isValidEmail(user.Email) {
+			return scimBadRequest("invalidValue", "userName must be an email address")
+		}
+		var taken int64
+		if err := tx.Model(&User{}).Where("lower(email) = lower(?) AND id <> ?", user.Email, user.ID).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return &scimError{status: 409, scimType: "uniqueness", detail: "a user with this userName exists"}
+		}
+		if err := tx.Model(&user).Select("email", "external_id", "deactivated_at").Updates(&user).Error; err != nil {
+			return err
+		}
+		if wasActive != (user.DeactivatedAt == nil) {
+			logger.Info().Str("user_id", user.ID).Bool("active", user.DeactivatedAt == nil).Msg("User activation changed by SCIM")
+		}
+		return nil
+	})
+	return user, err
+}
+
+// setActive activates or deactivates a user; a deactivated user keeps its
+// data but cannot log in or use its API keys
+func setActive(user *User, active bool) {
+	switch {
+	case active:
+		user.DeactivatedAt = nil
+	case user.DeactivatedAt == nil:
+		now := time.Now()
+		user.DeactivatedAt = &now
+	}
+}
+
+func scimReplaceUser(w http.ResponseWriter, r *http.Request) {
+	var req scimUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSCIMError(w, scimBadRequest("invalidSyntax", "invalid JSON"))
+		return
+	}
+	user, err := updateUser(r.Context(), mux.Vars(r)["id"], func(u *User) error {
+		u.Email = req.email()
+		u.ExternalID = req.ExternalID
+		setActive(u, req.Active == nil || *req.Active)
+		return nil
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	writeUser(w, 200, user)
+}
+
+// scimPatch is a PatchOp request
+type scimPatch struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+func decodePatch(r *http.Request) (scimPatch, error) {
+	var patch scimPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return patch, scimBadRequest("invalidSyntax", "invalid JSON")
+	}
+	if len(patch.Operations) == 0 {
+		return patch, scimBadRequest("invalidSyntax", "no Operations")
+	}
+	for i := range patch.Operations {
+		op := strings.ToLower(patch.Operations[i].Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return patch, scimBadRequest("invalidSyntax", "unknown op %q", patch.Operations[i].Op)
+		}
+		patch.Operations[i].Op = op
+	}
+	return patch, nil
+}
+
+// scimBool reads a boolean some identity providers send as a string
+func scimBool(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, scimBadRequest("invalidValue", "expected a boolean")
+}
+
+func scimString(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", scimBadRequest("invalidValue", "expected a string")
+	}
+	return s, nil
+}
+
+// patchUserAttribute applies one attribute of a user PATCH
+func patchUserAttribute(u *User, op, path string, value json.RawMessage) error {
+	var err error
+	switch strings.ToLower(path) {
+	case "active":
+		active := false
+		if op != "remove" {
+			if active, err = scimBool(value); err != nil {
+				return err
+			}
+		}
+		setActive(u, active)
+	case "username", "emails.value", `emails[type eq "work"].value`:
+		if op == "remove" {
+			return scimBadRequest("mutability", "userName is required")
+		}
+		if u.Email, err = scimString(value); err != nil {
+			return err
+		}
+		u.Email = strings.TrimSpace(u.Email)
+	case "externalid":
+		u.ExternalID = ""
+		if op != "remove" {
+			if u.ExternalID, err = scimString(value); err != nil {
+				return err
+			}
+		}
+	default:
+		// Attributes auth-service does not keep, e.g. name or title, are ignored
+	}
+	return nil
+}
+
+func scimPatchUser(w http.ResponseWriter, r *http.Request) {
+	patch, err := decodePatch(r)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	user, err := updateUser(r.Context(), mux.Vars(r)["id"], func(u *User) error {
+		for _, op := range patch.Operations {
+			if op.Path != "" {
+				if err := patchUserAttribute(u, op.Op, op.Path, op.Value); err != nil {
+					return err
+				}
+				continue
+			}
+			// Without a path the value holds the attributes
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				return scimBadRequest("invalidValue", "a PATCH without path needs an object value")
+			}
+			for name, value := range attrs {
+				if err := patchUserAttribute(u, op.Op, name, value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	writeUser(w, 200, user)
+}
+
+// scimDeleteUser deprovisions a user: it is deactivated and leaves its
+// groups, but its usage and billing history stay
+func scimDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	_, err := updateUser(r.Context(), id, func(u *User) error {
+		setActive(u, false)
+		return nil
+	})
+	if err == nil {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", id).Delete(&GroupMember{}).Error; err != nil {
+				return err
+			}
+			return syncGroupRoles(r.Context(), tx, []string{id})
+		})
+	}
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// === Groups ===
+
+type scimGroupRequest struct {
+	DisplayName string     `json:"displayName"`
+	ExternalID  string     `json:"externalId"`
+	Members     []scimName `json:"members"`
+}
+
+var scimGroupColumns = map[string]string{
+	"displayname": "display_name",
+	"externalid":  "external_id",
+	"id":          "id",
+}
+
+func groupMembers(tx *gorm.DB, groupIDs []string) (map[string][]scimName, error) {
+	var rows []struct {
+		GroupID string
+		UserID  string
+		Email   string
+	}
+	err := tx.Table("scim_group_members").
+		Select("scim_group_members.group_id, scim_group_members.user_id, users.email").
+		Joins("JOIN users ON users.id = scim_group_members.user_id").
+		Where("scim_group_members.group_id IN ?", groupIDs).
+		Order("users.email").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string][]scimName, len(groupIDs))
+	for _, row := range rows {
+		members[row.GroupID] = append(members[row.GroupID], scimName{Value: row.UserID, Display: row.Email})
+	}
+	return members, nil
+}
+
+func scimGroup(g Group, members []scimName) map[string]interface{} {
+	if members == nil {
+		members = []scimName{}
+	}
+	resource := map[string]interface{}{
+		"schemas":     []string{scimGroupSchema},
+		"id":          g.ID,
+		"displayName": g.DisplayName,
+		"members":     members,
+		"meta": map[string]interface{}{
+			"resourceType": "Group",
+			"created":      g.CreatedAt,
+			"lastModified": g.UpdatedAt,
+			"location":     scimPrefix + "/Groups/" + g.ID,
+		},
+	}
+	if role := groupRole(g.DisplayName); role != "" {
+		resource["roles"] = []map[string]string{{"value": role}}
+	}
+	if g.ExternalID != "" {
+		resource["externalId"] = g.ExternalID
+	}
+	return resource
+}
+
+func writeGroup(w http.ResponseWriter, status int, g Group) {
+	members, err := groupMembers(db, []string{g.ID})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	writeSCIM(w, status, scimGroup(g, members[g.ID]))
+}
+
+func scimListGroups(w http.ResponseWriter, r *http.Request) {
+	column, value, err := parseSCIMFilter(r.URL.Query().Get("filter"), scimGroupColumns)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	offset, limit := scimPage(r)
+
+	query := db.Model(&Group{})
+	if column != "" {
+		query = query.Where(column+" = ?", value)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	var groups []Group
+	if limit > 0 {
+		if err := query.Order("display_name").Offset(offset).Limit(limit).Find(&groups).Error; err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+	}
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	// Identity providers ask for excludedAttributes=members on large groups
+	var members map[string][]scimName
+	if !strings.Contains(r.URL.Query().Get("excludedAttributes"), "members") {
+		if members, err = groupMembers(db, ids); err != nil {
+			writeSCIMError(w, err)
+			return
+		}
+	}
+	resources := make([]interface{}, 0, len(groups))
+	for _, g := range groups {
+		resources = append(resources, scimGroup(g, members[g.ID]))
+	}
+	writeSCIM(w, 200, scimList(total, offset, resources))
+}
+
+func scimGetGroup(w http.ResponseWriter, r *http.Request) {
+	var g Group
+	if err := db.First(&g, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+		writeSCIMError(w, errSCIMNotFound)
+		return
+	}
+	writeGroup(w, 200, g)
+}
+
+// memberIDs returns the user IDs of members; unknown users are an error
+func memberIDs(tx *gorm.DB, members []scimName) ([]string, error) {
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.Value)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	var found int64
+	if err := tx.Model(&User{}).Where("id IN ?", ids).Distinct("id").Count(&found).Error; err != nil {
+		return nil, err
+	}
+	if int(found) != len(uniqueStrings(ids)) {
+		return nil, scimBadRequest("invalidValue", "members must be existing users")
+	}
+	return uniqueStrings(ids), nil
+}
+
+func uniqueStrings(s []string) []string {
+	seen := make(map[string]bool, len(s))
+	out := make([]string, 0, len(s))
+	for _, v := range s {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// currentMembers returns the user IDs in a group
+func currentMembers(tx *gorm.DB, groupID string) ([]string, error) {
+	var ids []string
+	err := tx.Model(&GroupMember{}).Where("group_id = ?", groupID).Pluck("user_id", &ids).Error
+	return ids, err
+}
+
+// setMembers replaces the members of a group and updates the roles of the
+// users who joined or left
+func setMembers(ctx context.Context, tx *gorm.DB, groupID string, ids []string) error {
+	previous, err := currentMembers(tx, groupID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("group_id = ?", groupID).Delete(&GroupMember{}).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := tx.Create(&GroupMember{GroupID: groupID, UserID: id}).Error; err != nil {
+			return err
+		}
+	}
+	return syncGroupRoles(ctx, tx, uniqueStrings(append(previous, ids...)))
+}
+
+func isUniqueViolation(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "duplicate key") || strings.Contains(err.Error(), "UNIQUE constraint"))
+}
+
+func scimCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req scimGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSCIMError(w, scimBadRequest("invalidSyntax", "invalid JSON"))
+		return
+	}
+	if strings.TrimSpace(req.DisplayName) == "" {
+		writeSCIMError(w, scimBadRequest("invalidValue", "displayName is required"))
+		return
+	}
+	now := time.Now()
+	g := Group{ID: uuid.New().String(), DisplayName: strings.TrimSpace(req.DisplayName), ExternalID: req.ExternalID, CreatedAt: now, UpdatedAt: now}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ids, err := memberIDs(tx, req.Members)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&g).Error; err != nil {
+			if isUniqueViolation(err) {
+				return &scimError{status: 409, scimType: "uniqueness", detail: "a group with this displayName exists"}
+			}
+			return err
+		}
+		return setMembers(r.Context(), tx, g.ID, ids)
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	logger.Info().Str("group_id", g.ID).Str("display_name", g.DisplayName).Msg("Group provisioned")
+	writeGroup(w, 201, g)
+}
+
+func scimReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	var req scimGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSCIMError(w, scimBadRequest("invalidSyntax", "invalid JSON"))
+		return
+	}
+	var g Group
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&g, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			return errSCIMNotFound
+		}
+		ids, err := memberIDs(tx, req.Members)
+		if err != nil {
+			return err
+		}
+		if name := strings.TrimSpace(req.DisplayName); name != "" {
+			g.DisplayName = name
+		}
+		g.ExternalID = req.ExternalID
+		g.UpdatedAt = time.Now()
+		if err := tx.Save(&g).Error; err != nil {
+			if isUniqueViolation(err) {
+				return &scimError{status: 409, scimType: "uniqueness", detail: "a group with this displayName exists"}
+			}
+			return err
+		}
+		// A new name may map to another role
+		return setMembers(r.Context(), tx, g.ID, ids)
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	writeGroup(w, 200, g)
+}
+
+// scimMemberPath matches members[value eq "id"]
+var scimMemberPath = regexp.MustCompile(`^members\[\s*value\s+(?i:eq)\s+"([^"]+)"\s*\]$`)
+
+func scimPatchGroup(w http.ResponseWriter, r *http.Request) {
+	patch, err := decodePatch(r)
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	var g Group
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&g, "id = ?", mux.Vars(r)["id"]).Error; err != nil {
+			return errSCIMNotFound
+		}
+		members, err := currentMembers(tx, g.ID)
+		if err != nil {
+			return err
+		}
+		set := make(map[string]bool, len(members))
+		for _, id := range members {
+			set[id] = true
+		}
+
+		for _, op := range patch.Operations {
+			path := op.Path
+			value := op.Value
+			if path == "" {
+				// Without a path the value holds the attributes
+				var attrs struct {
+					DisplayName *string    `json:"displayName"`
+					ExternalID  *string    `json:"externalId"`
+					Members     []scimName `json:"members"`
+				}
+				if err := json.Unmarshal(value, &attrs); err != nil {
+					return scimBadRequest("invalidValue", "a PATCH without path needs an object value")
+				}
+				if attrs.DisplayName != nil {
+					g.DisplayName = strings.TrimSpace(*attrs.DisplayName)
+				}
+				if attrs.ExternalID != nil {
+					g.ExternalID = *attrs.ExternalID
+				}
+				if attrs.Members == nil {
+					continue
+				}
+				path, value = "members", mustJSON(attrs.Members)
+			}
+
+			if m := scimMemberPath.FindStringSubmatch(path); m != nil {
+				if op.Op != "remove" {
+					return scimBadRequest("invalidPath", "only remove is supported on %s", path)
+				}
+				delete(set, m[1])
+				continue
+			}
+			switch strings.ToLower(path) {
+			case "members":
+				var list []scimName
+				if len(value) > 0 {
+					if err := json.Unmarshal(value, &list); err != nil {
+						return scimBadRequest("invalidValue", "members must be a list of {\"value\": user id}")
+					}
+				}
+				ids, err := memberIDs(tx, list)
+				if op.Op != "remove" && err != nil {
+					return err
+				}
+				switch op.Op {
+				case "replace":
+					set = make(map[string]bool, len(ids))
+					fallthrough
+				case "add":
+					for _, id := range ids {
+						set[id] = true
+					}
+				case "remove":
+					// Without a value every member is removed
+					if len(list) == 0 {
+						set = map[string]bool{}
+					}
+					for _, m := range list {
+						delete(set, m.Value)
+					}
+				}
+			case "displayname":
+				name, err := scimString(value)
+				if err != nil || op.Op == "remove" {
+					return scimBadRequest("mutability", "displayName is required")
+				}
+				g.DisplayName = strings.TrimSpace(name)
+			case "externalid":
+				g.ExternalID = ""
+				if op.Op != "remove" {
+					if g.ExternalID, err = scimString(value); err != nil {
+						return err
+					}
+				}
+			default:
+				return scimBadRequest("invalidPath", "unsupported path %s", op.Path)
+			}
+		}
+
+		if g.DisplayName == "" {
+			return scimBadRequest("invalidValue", "displayName is required")
+		}
+		g.UpdatedAt = time.Now()
+		if err := tx.Save(&g).Error; err != nil {
+			if isUniqueViolation(err) {
+				return &scimError{status: 409, scimType: "uniqueness", detail: "a group with this displayName exists"}
+			}
+			return err
+		}
+		ids := make([]string, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+		}
+		return setMembers(r.Context(), tx, g.ID, ids)
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	writeGroup(w, 200, g)
+}
+
+func mustJSON(v interface{}) json.RawMessage {
+	raw, _ := json.Marshal(v)
+	return raw
+}
+
+func scimDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := db.Transaction(func(tx *gorm.DB) error {
+		members, err := currentMembers(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", id).Delete(&GroupMember{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&Group{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errSCIMNotFound
+		}
+		return syncGroupRoles(r.Context(), tx, members)
+	})
+	if err != nil {
+		writeSCIMError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// === Group to role mapping ===
+
+// groupRoleMapping is a group display name and the role it grants
+type groupRoleMapping struct {
+	group, role string
+}
+
+// groupRoleMappings reads SCIM_GROUP_ROLES, e.g. "Platform Admins=admin,SRE=operator";
+// a user in several mapped groups gets the most privileged of their roles
+func groupRoleMappings() []groupRoleMapping {
+	var mappings []groupRoleMapping
+	for _, entry := range strings.Split(os.Getenv(scimGroupRolesEnv), ",") {
+		group, role, ok := strings.Cut(entry, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if ok && group != "" && role != "" {
+			mappings = append(mappings, groupRoleMapping{group, role})
+		}
+	}
+	return mappings
+}
+
+// groupRole returns the role a group maps to, empty if none
+func groupRole(displayName string) string {
+	for _, m := range groupRoleMappings() {
+		if strings.EqualFold(m.group, displayName) {
+			return m.role
+		}
+	}
+	return ""
+}
+
+// rolePrivilege is the number of the permissions checked by the services
+// that a role grants, e.g. all of them for superadmin and none for user
+func rolePrivilege(role string) int {
+	n := 0
+	for _, p := range rbac.Permissions {
+		if roles.Allows(role, p) {
+			n++
+		}
+	}
+	return n
+}
+
+// roleForGroups returns the most privileged role the groups map to; between
+// equally privileged roles the first mapping wins. ok is false if no mapping
+// names one of the groups.
+func roleForGroups(mappings []groupRoleMapping, groups []Group) (role string, ok bool) {
+	best := -1
+	for _, m := range mappings {
+		for _, g := range groups {
+			if !strings.EqualFold(m.group, g.DisplayName) {
+				continue
+			}
+			if privilege := rolePrivilege(m.role); privilege > best {
+				role, ok, best = m.role, true, privilege
+			}
+		}
+	}
+	return role, ok
+}
+
+// syncGroupRoles gives users the role their groups map to. SCIM only manages
+// roles it granted and users with the default role: a role set through the
+// admin API, superadmin included, is never changed, and users in no mapped
+// group keep it. A user leaving its last mapped group returns to the default
+// role. Without SCIM_GROUP_ROLES roles are left to the admin API.
+func syncGroupRoles(ctx context.Context, tx *gorm.DB, userIDs []string) error {
+	mappings := groupRoleMappings()
+	if len(mappings) == 0 || len(userIDs) == 0 {
+		return nil
+	}
+	groups, err := userGroups(tx, userIDs)
+	if err != nil {
+		return err
+	}
+	var users []User
+	if err := tx.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		role, mapped := roleForGroups(mappings, groups[user.ID])
+		scimRole := role
+		granted := user.SCIMRole != "" && user.SCIMRole == user.Role
+		switch {
+		case mapped && (granted || user.Role == scimDefaultRole):
+			// The role follows the groups
+		case !mapped && granted:
+			role, scimRole = scimDefaultRole, ""
+		default:
+			// Set through the admin API, or never granted by SCIM
+			continue
+		}
+		if role == user.Role && scimRole == user.SCIMRole {
+			continue
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{"role": role, "scim_role": scimRole}).Error; err != nil {
+			return err
+		}
+		if role == user.Role {
+			continue
+		}
+		err := addEvent(ctx, tx, SubjectUserRoleChanged, UserRoleChangedEvent{
+			UserID:    user.ID,
+			From:      user.Role,
+			To:        role,
+			ChangedBy: scimChangedBy,
+		})
+		if err != nil {
+			return err
+		}
+		logger.Info().Str("user_id", user.ID).Str("from", user.Role).Str("to", role).Msg("Role changed by SCIM group membership")
+	}
+	return nil
+}
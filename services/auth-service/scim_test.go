@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSCIMToken = "scim-test-token"
+
+// setupSCIM returns a router with the SCIM endpoints over the test database
+func setupSCIM(t *testing.T) *mux.Router {
+	t.Helper()
+	setupTestEnvironment()
+	require.NoError(t, db.AutoMigrate(&Group{}, &GroupMember{}))
+	t.Setenv("SCIM_TOKEN", testSCIMToken)
+	r := mux.NewRouter()
+	registerSCIM(r)
+	return r
+}
+
+func scimRequest(t *testing.T, r *mux.Router, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *strings.Reader
+	if body == nil {
+		reader = strings.NewReader("")
+	} else {
+		raw, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = strings.NewReader(string(raw))
+	}
+	req := httptest.NewRequest(method, scimPrefix+path, reader)
+	req.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	req.Header.Set("Content-Type", scimContentType)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeSCIM(t *testing.T, rr *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+	return body
+}
+
+// provisionUser creates a user over SCIM and returns its ID
+func provisionUser(t *testing.T, r *mux.Router, email string) string {
+	t.Helper()
+	rr := scimRequest(t, r, "POST", "/Users", map[string]interface{}{
+		"schemas":  []string{scimUserSchema},
+		"userName": email,
+	})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	return decodeSCIM(t, rr)["id"].(string)
+}
+
+// provisionGroup creates a group over SCIM and returns its ID
+func provisionGroup(t *testing.T, r *mux.Router, name string, memberIDs ...string) string {
+	t.Helper()
+	members := make([]scimName, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		members = append(members, scimName{Value: id})
+	}
+	rr := scimRequest(t, r, "POST", "/Groups", map[string]interface{}{
+		"schemas":     []string{scimGroupSchema},
+		"displayName": name,
+		"members":     members,
+	})
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	return decodeSCIM(t, rr)["id"].(string)
+}
+
+func patchOp(ops ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"schemas": []string{scimPatchSchema}, "Operations": ops}
+}
+
+func userRole(t *testing.T, id string) string {
+	t.Helper()
+	var user User
+	require.NoError(t, db.First(&user, "id = ?", id).Error)
+	return user.Role
+}
+
+func groupMemberIDs(t *testing.T, r *mux.Router, groupID string) []string {
+	t.Helper()
+	rr := scimRequest(t, r, "GET", "/Groups/"+groupID, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var group struct {
+		Members []scimName `json:"members"`
+	}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&group))
+	ids := make([]string, 0, len(group.Members))
+	for _, m := range group.Members {
+		ids = append(ids, m.Value)
+	}
+	return ids
+}
+
+func TestParseSCIMFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		column   string
+		value    string
+		scimType string
+	}{
+		{name: "empty", filter: ""},
+		{name: "userName", filter: `userName eq "alice@example.com"`, column: "email", value: "alice@example.com"},
+		{name: "case-insensitive attribute and operator", filter: `USERNAME Eq "alice@example.com"`, column: "email", value: "alice@example.com"},
+		{name: "surrounding spaces", filter: `  externalId   eq   "ext-1"  `, column: "external_id", value: "ext-1"},
+		{name: "value path", filter: `emails[type eq "work"].value eq "alice@example.com"`, column: "email", value: "alice@example.com"},
+		{name: "escaped quote", filter: `externalId eq "a\"b"`, column: "external_id", value: `a"b`},
+		{name: "empty value", filter: `externalId eq ""`, column: "external_id", value: ""},
+		{name: "other operator", filter: `userName co "alice"`, scimType: "invalidFilter"},
+		{name: "unquoted value", filter: `userName eq alice`, scimType: "invalidFilter"},
+		{name: "logical expression", filter: `userName eq "a" and externalId eq "b"`, scimType: "invalidFilter"},
+		{name: "unsupported attribute", filter: `name.givenName eq "Alice"`, scimType: "invalidFilter"},
+		{name: "invalid escape", filter: `userName eq "a\qb"`, scimType: "invalidFilter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			column, value, err := parseSCIMFilter(tt.filter, scimUserColumns)
+			if tt.scimType != "" {
+				var se *scimError
+				require.ErrorAs(t, err, &se)
+				assert.Equal(t, http.StatusBadRequest, se.status)
+				assert.Equal(t, tt.scimType, se.scimType)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.column, column)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestSCIMListUsersFilter(t *testing.T) {
+	r := setupSCIM(t)
+	aliceID := provisionUser(t, r, "scim-filter-alice@example.com")
+	provisionUser(t, r, "scim-filter-bob@example.com")
+
+	list := func(filter string) *httptest.ResponseRecorder {
+		return scimRequest(t, r, "GET", "/Users?filter="+url.QueryEscape(filter), nil)
+	}
+
+	// userName is matched case-insensitively
+	rr := list(`userName eq "SCIM-Filter-Alice@example.com"`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	body := decodeSCIM(t, rr)
+	assert.EqualValues(t, 1, body["totalResults"])
+	resources := body["Resources"].([]interface{})
+	require.Len(t, resources, 1)
+	assert.Equal(t, aliceID, resources[0].(map[string]interface{})["id"])
+
+	rr = list(`id eq "` + aliceID + `"`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, 1, decodeSCIM(t, rr)["totalResults"])
+
+	rr = list(`userName eq "nobody@example.com"`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.EqualValues(t, 0, decodeSCIM(t, rr)["totalResults"])
+
+	rr = list(`userName sw "scim-filter"`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalidFilter", decodeSCIM(t, rr)["scimType"])
+}
+
+func TestSCIMPatchUser(t *testing.T) {
+	r := setupSCIM(t)
+	id := provisionUser(t, r, "scim-patch@example.com")
+
+	tests := []struct {
+		name           string
+		ops            []map[string]interface{}
+		expectedStatus int
+		scimType       string
+		check          func(t *testing.T, user map[string]interface{})
+	}{
+		{
+			name:           "replace active",
+			ops:            []map[string]interface{}{{"op": "replace", "path": "active", "value": false}},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, user map[string]interface{}) {
+				assert.Equal(t, false, user["active"])
+			},
+		},
+		{
+			name:           "replace without path, boolean as string",
+			ops:            []map[string]interface{}{{"op": "Replace", "value": map[string]interface{}{"active": "True"}}},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, user map[string]interface{}) {
+				assert.Equal(t, true, user["active"])
+			},
+		},
+		{
+			name:           "add externalId",
+			ops:            []map[string]interface{}{{"op": "add", "path": "externalId", "value": "ext-42"}},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, user map[string]interface{}) {
+				assert.Equal(t, "ext-42", user["externalId"])
+			},
+		},
+		{
+			name:           "remove externalId",
+			ops:            []map[string]interface{}{{"op": "remove", "path": "externalId"}},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, user map[string]interface{}) {
+				assert.NotContains(t, user, "externalId")
+			},
+		},
+		{
+			name:           "replace userName",
+			ops:            []map[string]interface{}{{"op": "replace", "path": "userName", "value": " scim-patch-renamed@example.com "}},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, user map[string]interface{}) {
+				assert.Equal(t, "scim-patch-renamed@example.com", user["userName"])
+			},
+		},
+		{
+			name:           "remove active deactivates",
+			ops:            []map[string]interface{}{{"op": "remove", "path": "active"}},
+			expectedStatus: http.StatusOK,
+			check: func(t *testing.T, user map[string]interface{}) {
+				assert.Equal(t, false, user["active"])
+			},
+		},
+		{
+			name:           "ignored attribute",
+			ops:            []map[string]interface{}{{"op": "replace", "path": "title", "value": "Engineer"}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "remove userName",
+			ops:            []map[string]interface{}{{"op": "remove", "path": "userName"}},
+			expectedStatus: http.StatusBadRequest,
+			scimType:       "mutability",
+		},
+		{
+			name:           "userName not an email",
+			ops:            []map[string]interface{}{{"op": "replace", "path": "userName", "value": "not-an-email"}},
+			expectedStatus: http.StatusBadRequest,
+			scimType:       "invalidValue",
+		},
+		{
+			name:           "active not a boolean",
+			ops:            []map[string]interface{}{{"op": "replace", "path": "active", "value": "maybe"}},
+			expectedStatus: http.StatusBadRequest,
+			scimType:       "invalidValue",
+		},
+		{
+			name:           "unknown op",
+			ops:            []map[string]interface{}{{"op": "move", "path": "active", "value": true}},
+			expectedStatus: http.StatusBadRequest,
+			scimType:       "invalidSyntax",
+		},
+		{
+			name:           "no operations",
+			expectedStatus: http.StatusBadRequest,
+			scimType:       "invalidSyntax",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := scimRequest(t, r, "PATCH", "/Users/"+id, patchOp(tt.ops...))
+			require.Equal(t, tt.expectedStatus, rr.Code, rr.Body.String())
+			body := decodeSCIM(t, rr)
+			if tt.scimType != "" {
+				assert.Equal(t, tt.scimType, body["scimType"])
+			}
+			if tt.check != nil {
+				tt.check(t, body)
+			}
+		})
+	}
+
+	rr := scimRequest(t, r, "PATCH", "/Users/no-such-user", patchOp(map[string]interface{}{"op": "replace", "path": "active", "value": true}))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestSCIMPatchGroupMembers(t *testing.T) {
+	r := setupSCIM(t)
+	alice := provisionUser(t, r, "scim-members-alice@example.com")
+	bob := provisionUser(t, r, "scim-members-bob@example.com")
+	carol := provisionUser(t, r, "scim-members-carol@example.com")
+	group := provisionGroup(t, r, "SCIM Members Test", alice)
+
+	patch := func(ops ...map[string]interface{}) *httptest.ResponseRecorder {
+		return scimRequest(t, r, "PATCH", "/Groups/"+group, patchOp(ops...))
+	}
+
+	rr := patch(map[string]interface{}{"op": "add", "path": "members", "value": []scimName{{Value: bob}}})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.ElementsMatch(t, []string{alice, bob}, groupMemberIDs(t, r, group))
+
+	rr = patch(map[string]interface{}{"op": "remove", "path": `members[value eq "` + alice + `"]`})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.ElementsMatch(t, []string{bob}, groupMemberIDs(t, r, group))
+
+	// Azure AD sends additions without a path
+	rr = patch(map[string]interface{}{"op": "add", "value": map[string]interface{}{"members": []scimName{{Value: carol}}}})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.ElementsMatch(t, []string{bob, carol}, groupMemberIDs(t, r, group))
+
+	rr = patch(map[string]interface{}{"op": "replace", "path": "members", "value": []scimName{{Value: alice}}})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.ElementsMatch(t, []string{alice}, groupMemberIDs(t, r, group))
+
+	rr = patch(map[string]interface{}{"op": "add", "path": "members", "value": []scimName{{Value: "no-such-user"}}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.ElementsMatch(t, []string{alice}, groupMemberIDs(t, r, group))
+
+	rr = patch(map[string]interface{}{"op": "add", "path": `members[value eq "` + bob + `"]`})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "invalidPath", decodeSCIM(t, rr)["scimType"])
+
+	rr = patch(map[string]interface{}{"op": "replace", "path": "displayName", "value": "SCIM Members Renamed"})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "SCIM Members Renamed", decodeSCIM(t, rr)["displayName"])
+
+	rr = patch(map[string]interface{}{"op": "remove", "path": "displayName"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "mutability", decodeSCIM(t, rr)["scimType"])
+
+	// Without a value every member is removed
+	rr = patch(map[string]interface{}{"op": "remove", "path": "members"})
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Empty(t, groupMemberIDs(t, r, group))
+}
+
+func TestSCIMGroupRoles(t *testing.T) {
+	r := setupSCIM(t)
+	t.Setenv(scimGroupRolesEnv, "SCIM Roles SRE=operator, SCIM Roles Admins=admin")
+	alice := provisionUser(t, r, "scim-roles-alice@example.com")
+	bob := provisionUser(t, r, "scim-roles-bob@example.com")
+	assert.Equal(t, scimDefaultRole, userRole(t, alice))
+
+	admins := provisionGroup(t, r, "SCIM Roles Admins", alice)
+	sre := provisionGroup(t, r, "SCIM Roles SRE", alice, bob)
+	// The most privileged role wins, whatever the order of the mappings
+	assert.Equal(t, "admin", userRole(t, alice))
+	assert.Equal(t, "operator", userRole(t, bob))
+
+	// Leaving the admin group demotes alice to the role of her other group
+	rr := scimRequest(t, r, "PATCH", "/Groups/"+admins, patchOp(map[string]interface{}{"op": "remove", "path": `members[value eq "` + alice + `"]`}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "operator", userRole(t, alice))
+
+	var payload string
+	err := db.Raw("SELECT payload FROM outbox WHERE subject = ? AND payload LIKE ? AND payload LIKE ?",
+		SubjectUserRoleChanged, "%"+alice+"%", `%"from":"admin"%`).Scan(&payload).Error
+	require.NoError(t, err)
+	var event UserRoleChangedEvent
+	require.NoError(t, json.Unmarshal([]byte(payload), &event))
+	assert.Equal(t, UserRoleChangedEvent{UserID: alice, From: "admin", To: "operator", ChangedBy: scimChangedBy}, event)
+
+	// Leaving the last mapped group returns to the default role
+	rr = scimRequest(t, r, "PATCH", "/Groups/"+sre, patchOp(map[string]interface{}{"op": "replace", "path": "members", "value": []scimName{{Value: bob}}}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, scimDefaultRole, userRole(t, alice))
+	assert.Equal(t, "operator", userRole(t, bob))
+
+	// A renamed group no longer grants its role
+	rr = scimRequest(t, r, "PATCH", "/Groups/"+sre, patchOp(map[string]interface{}{"op": "replace", "path": "displayName", "value": "SCIM Roles Former SRE"}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, scimDefaultRole, userRole(t, bob))
+
+	// Deprovisioning a user takes it out of its groups and their roles
+	rr = scimRequest(t, r, "PATCH", "/Groups/"+admins, patchOp(map[string]interface{}{"op": "add", "path": "members", "value": []scimName{{Value: bob}}}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "admin", userRole(t, bob))
+	rr = scimRequest(t, r, "DELETE", "/Users/"+bob, nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	assert.Equal(t, scimDefaultRole, userRole(t, bob))
+	assert.Empty(t, groupMemberIDs(t, r, admins))
+
+	// Deleting a group demotes its members
+	rr = scimRequest(t, r, "PATCH", "/Groups/"+admins, patchOp(map[string]interface{}{"op": "add", "path": "members", "value": []scimName{{Value: alice}}}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "admin", userRole(t, alice))
+	rr = scimRequest(t, r, "DELETE", "/Groups/"+admins, nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	assert.Equal(t, scimDefaultRole, userRole(t, alice))
+}
+
+func TestSCIMGroupRolesKeepAdminRoles(t *testing.T) {
+	r := setupSCIM(t)
+	t.Setenv(scimGroupRolesEnv, "SCIM Keep SRE=operator")
+	root := provisionUser(t, r, "scim-keep-root@example.com")
+	carol := provisionUser(t, r, "scim-keep-carol@example.com")
+	dave := provisionUser(t, r, "scim-keep-dave@example.com")
+	require.NoError(t, db.Model(&User{}).Where("id = ?", root).Update("role", "superadmin").Error)
+	require.NoError(t, db.Model(&User{}).Where("id = ?", carol).Update("role", "admin").Error)
+
+	// Roles set through the admin API are neither replaced nor demoted
+	sre := provisionGroup(t, r, "SCIM Keep SRE", root, carol, dave)
+	assert.Equal(t, "superadmin", userRole(t, root))
+	assert.Equal(t, "admin", userRole(t, carol))
+	assert.Equal(t, "operator", userRole(t, dave))
+	rr := scimRequest(t, r, "PATCH", "/Groups/"+sre, patchOp(map[string]interface{}{"op": "replace", "path": "members", "value": []scimName{{Value: dave}}}))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "superadmin", userRole(t, root))
+	assert.Equal(t, "admin", userRole(t, carol))
+
+	// Once an admin changes a role SCIM granted, it is the admin's
+	require.NoError(t, db.Model(&User{}).Where("id = ?", dave).Update("role", "viewer").Error)
+	rr = scimRequest(t, r, "DELETE", "/Groups/"+sre, nil)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	assert.Equal(t, "viewer", userRole(t, dave))
+}
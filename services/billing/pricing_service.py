@@ -39,6 +39,9 @@ DEFAULT_PRICING = {
     "text-embedding-3-large":  {"embed": 0.135},
     "voyage-2":                {"embed": 0.105},
     "cohere-embed-v3":         {"embed": 0.210},
+    "whisper-1":               {"transcription": 0.006},
+    "tts-1":                   {"speech": 15.00},
+    "tts-1-hd":                {"speech": 30.00},
 }
 
 # Error handling
@@ -105,6 +108,9 @@ class PricingManager:
                 "text-embedding-3-large":  {"embed": 0.135},
                 "voyage-2":                {"embed": 0.105},
                 "cohere-embed-v3":         {"embed": 0.210},
+                "whisper-1":               {"transcription": 0.006},
+                "tts-1":                   {"speech": 15.00},
+                "tts-1-hd":                {"speech": 30.00},
             }
 
             # Validate pricing data
@@ -3,6 +3,7 @@ module github.com/MaksimVF/ZB/services/gateway
 go 1.21
 
 require (
+	github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.9
 	github.com/prometheus/client_golang v1.16.0
@@ -11,6 +12,23 @@ require (
 	github.com/stretchr/testify v1.8.4
 )
 
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/mattn/go-colorable v0.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.14 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/prometheus/client_model v0.3.0 // indirect
+	github.com/prometheus/common v0.42.0 // indirect
+	github.com/prometheus/procfs v0.10.1 // indirect
+	golang.org/x/sys v0.8.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
 replace github.com/MaksimVF/ZB/services/gateway/internal/secrets => ./internal/secrets
 
 replace github.com/MaksimVF/ZB/services/gateway/internal/handlers => ./internal/handlers
@@ -20,3 +38,5 @@ replace github.com/MaksimVF/ZB/services/gateway/internal/billing => ./internal/b
 replace github.com/MaksimVF/ZB/services/gateway/internal/providers => ./internal/providers
 
 replace github.com/MaksimVF/ZB/services/gateway/internal/resilience => ./internal/resilience
+
+replace github.com/MaksimVF/ZB => ../..
@@ -0,0 +1,326 @@
+// Package analytics counts which clients call which endpoints: the SDK and its
+// version from the User-Agent, the API version the client asks for and the
+// route template, per tenant and day. Operators read it before changing or
+// removing an endpoint to see who still calls it.
+//
+// Counts are kept in memory and added to Redis at an interval, one hash per
+// day and tenant (gateway:client_analytics:<date>:<tenant>) with a set of the
+// tenants seen on a day (gateway:client_analytics:tenants:<date>). Keys
+// expire after the retention.
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+)
+
+const (
+	keyPrefix = "gateway:client_analytics:"
+	// dateLayout is the day of the keys, in UTC
+	dateLayout = "2006-01-02"
+	// maxValueLen caps client-supplied values so odd headers cannot blow up
+	// the number of fields
+	maxValueLen = 64
+	unknown     = "unknown"
+)
+
+// APIVersionHeader is the request header clients name an API version with
+const APIVersionHeader = apiversion.Header
+
+var logger = zerolog.New(os.Stdout).With().Timestamp().Str("service", "analytics").Logger()
+
+// Client identifies the software calling the gateway
+type Client struct {
+	SDK        string `json:"sdk"`
+	SDKVersion string `json:"sdk_version,omitempty"`
+	APIVersion string `json:"api_version,omitempty"`
+}
+
+var (
+	// OpenAI SDKs send "OpenAI/Python 1.35.3" or "OpenAI/JS 4.52.0"
+	openAIAgent = regexp.MustCompile(`(?i)^openai/(\w+) v?([\w.\-]+)`)
+	// langchain, langchain-openai, langchain_core, ... with an optional version
+	langchainAgent = regexp.MustCompile(`(?i)\b(langchain[\w\-]*)(?:/v?([\w.\-]+))?`)
+	productToken   = regexp.MustCompile(`^([\w.\-]+)(?:/v?([\w.\-]+))?`)
+)
+
+// ParseClient identifies the client of a request from its User-Agent, the
+// X-Stainless-* headers of the OpenAI SDKs and APIVersionHeader. The SDK
+// headers win over the User-Agent, except for LangChain, which sends them
+// from the OpenAI SDK it wraps.
+func ParseClient(r *http.Request) Client {
+	c := parseUserAgent(r.Header.Get("User-Agent"))
+	if lang := r.Header.Get("X-Stainless-Lang"); lang != "" && !strings.HasPrefix(c.SDK, "langchain") {
+		c.SDK = "openai-" + strings.ToLower(lang)
+		if v := r.Header.Get("X-Stainless-Package-Version"); v != "" {
+			c.SDKVersion = v
+		}
+	}
+	c.APIVersion = r.Header.Get(APIVersionHeader)
+
+	c.SDK = truncate(c.SDK)
+	c.SDKVersion = truncate(c.SDKVersion)
+	c.APIVersion = truncate(c.APIVersion)
+	return c
+}
+
+func parseUserAgent(ua string) Client {
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return Client{SDK: unknown}
+	}
+	// LangChain wraps the OpenAI SDK, so it is checked first
+	if m := langchainAgent.FindStringSubmatch(ua); m != nil {
+		return Client{SDK: strings.ToLower(strings.ReplaceAll(m[1], "_", "-")), SDKVersion: m[2]}
+	}
+	if m := openAIAgent.FindStringSubmatch(ua); m != nil {
+		return Client{SDK: "openai-" + strings.ToLower(m[1]), SDKVersion: m[2]}
+	}
+	// Browsers all claim to be Mozilla; their versions say nothing useful
+	if strings.HasPrefix(ua, "Mozilla/") {
+		return Client{SDK: "browser"}
+	}
+	if m := productToken.FindStringSubmatch(ua); m != nil {
+		return Client{SDK: strings.ToLower(m[1]), SDKVersion: m[2]}
+	}
+	return Client{SDK: unknown}
+}
+
+func truncate(s string) string {
+	if len(s) > maxValueLen {
+		return s[:maxValueLen]
+	}
+	return s
+}
+
+// Entry is the number of requests of a client to an endpoint
+type Entry struct {
+	Tenant string `json:"tenant"`
+	Client
+	Method     string `json:"method"`
+	Route      string `json:"route"`
+	Deprecated bool   `json:"deprecated"`
+	Requests   int64  `json:"requests"`
+}
+
+// field is the hash field of an entry: the client and endpoint, JSON encoded
+// since every part may contain any separator
+type field struct {
+	Client
+	Method string `json:"method"`
+	Route  string `json:"route"`
+}
+
+func (f field) encode() string {
+	raw, _ := json.Marshal([]string{f.SDK, f.SDKVersion, f.APIVersion, f.Method, f.Route})
+	return string(raw)
+}
+
+func decodeField(s string) (field, bool) {
+	var parts []string
+	if err := json.Unmarshal([]byte(s), &parts); err != nil || len(parts) != 5 {
+		return field{}, false
+	}
+	return field{Client: Client{SDK: parts[0], SDKVersion: parts[1], APIVersion: parts[2]}, Method: parts[3], Route: parts[4]}, true
+}
+
+type counterKey struct {
+	day    string
+	tenant string
+	field  string
+}
+
+var (
+	client     *redis.Client
+	retention  time.Duration
+	deprecated = func(method, route string) bool { return false }
+
+	mu     sync.Mutex
+	counts = map[counterKey]int64{}
+)
+
+// Init flushes the counts to Redis at the interval, and once more when ctx is
+// cancelled. isDeprecated tells the endpoints marked deprecated, it may be
+// nil. Without Init nothing is counted.
+func Init(ctx context.Context, rdb *redis.Client, interval, keep time.Duration, isDeprecated func(method, route string) bool) {
+	client, retention = rdb, keep
+	if isDeprecated != nil {
+		deprecated = isDeprecated
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// ctx is done, the last counts go out without it
+				if err := Flush(context.Background()); err != nil {
+					logger.Error().Err(err).Msg("Failed to flush client analytics")
+				}
+				return
+			case <-ticker.C:
+			}
+			if err := Flush(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to flush client analytics")
+			}
+		}
+	}()
+}
+
+// Record counts a request of a tenant to the route template of an endpoint
+func Record(tenant string, c Client, method, route string, at time.Time) {
+	if client == nil {
+		return
+	}
+	if tenant == "" {
+		tenant = unknown
+	}
+	key := counterKey{
+		day:    at.UTC().Format(dateLayout),
+		tenant: truncate(tenant),
+		field:  field{Client: c, Method: method, Route: route}.encode(),
+	}
+	mu.Lock()
+	counts[key]++
+	mu.Unlock()
+}
+
+// unrecorded are the paths of health probes and metrics scrapes
+var unrecorded = map[string]bool{"/health": true, "/livez": true, "/readyz": true, "/metrics": true}
+
+// Middleware records the requests of the routes of a mux router; tenant
+// names the tenant of a request. It must run as router middleware, after the
+// route is matched.
+func Middleware(tenant func(*http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route := mux.CurrentRoute(r); route != nil && !unrecorded[r.URL.Path] {
+				if template, err := route.GetPathTemplate(); err == nil {
+					Record(tenant(r), ParseClient(r), r.Method, template, time.Now())
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Flush adds the counts to Redis. Counts that fail to be written are kept
+// for the next flush.
+func Flush(ctx context.Context) error {
+	mu.Lock()
+	pending := counts
+	counts = map[counterKey]int64{}
+	mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	pipe := client.Pipeline()
+	for key, n := range pending {
+		hash := keyPrefix + key.day + ":" + key.tenant
+		tenants := keyPrefix + "tenants:" + key.day
+		pipe.HIncrBy(ctx, hash, key.field, n)
+		pipe.Expire(ctx, hash, retention)
+		pipe.SAdd(ctx, tenants, key.tenant)
+		pipe.Expire(ctx, tenants, retention)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// HINCRBY may have been applied in part; counting a few requests
+		// twice beats dropping them
+		mu.Lock()
+		for key, n := range pending {
+			counts[key] += n
+		}
+		mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Query selects entries of Report
+type Query struct {
+	// From and To are the first and last day, inclusive
+	From, To time.Time
+	// Tenant limits the report to one tenant, all tenants when empty
+	Tenant string
+	// DeprecatedOnly limits the report to endpoints marked deprecated
+	DeprecatedOnly bool
+}
+
+// Report sums the requests per tenant, client and endpoint over the days of
+// the query, most requests first. Deprecation is the current one, so marking
+// an endpoint deprecated shows who called it before.
+func Report(ctx context.Context, q Query) ([]Entry, error) {
+	if client == nil {
+		return []Entry{}, nil
+	}
+	type entryKey struct {
+		tenant string
+		field  string
+	}
+	sums := map[entryKey]int64{}
+	from := q.From.UTC().Truncate(24 * time.Hour)
+	for day := from; !day.After(q.To.UTC()); day = day.AddDate(0, 0, 1) {
+		date := day.Format(dateLayout)
+		tenants := []string{q.Tenant}
+		if q.Tenant == "" {
+			var err error
+			if tenants, err = client.SMembers(ctx, keyPrefix+"tenants:"+date).Result(); err != nil {
+				return nil, err
+			}
+		}
+		for _, tenant := range tenants {
+			fields, err := client.HGetAll(ctx, keyPrefix+date+":"+tenant).Result()
+			if err != nil {
+				return nil, err
+			}
+			for f, raw := range fields {
+				n, err := strconv.ParseInt(raw, 10, 64)
+				if err != nil {
+					continue
+				}
+				sums[entryKey{tenant, f}] += n
+			}
+		}
+	}
+
+	entries := make([]Entry, 0, len(sums))
+	for key, n := range sums {
+		f, ok := decodeField(key.field)
+		if !ok {
+			continue
+		}
+		e := Entry{Tenant: key.tenant, Client: f.Client, Method: f.Method, Route: f.Route,
+			Deprecated: deprecated(f.Method, f.Route), Requests: n}
+		if q.DeprecatedOnly && !e.Deprecated {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Requests != entries[j].Requests {
+			return entries[i].Requests > entries[j].Requests
+		}
+		if entries[i].Tenant != entries[j].Tenant {
+			return entries[i].Tenant < entries[j].Tenant
+		}
+		a, b := field{entries[i].Client, entries[i].Method, entries[i].Route}, field{entries[j].Client, entries[j].Method, entries[j].Route}
+		return a.encode() < b.encode()
+	})
+	return entries, nil
+}
@@ -0,0 +1,51 @@
+package analytics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Client
+	}{
+		{"none", nil, Client{SDK: "unknown"}},
+		{"openai python", map[string]string{"User-Agent": "OpenAI/Python 1.35.3"}, Client{SDK: "openai-python", SDKVersion: "1.35.3"}},
+		{"openai node via stainless", map[string]string{
+			"User-Agent": "node", "X-Stainless-Lang": "js", "X-Stainless-Package-Version": "4.52.0",
+		}, Client{SDK: "openai-js", SDKVersion: "4.52.0"}},
+		{"langchain over openai", map[string]string{
+			"User-Agent": "OpenAI/Python 1.35.3 langchain_openai/0.1.8", "X-Stainless-Lang": "python",
+		}, Client{SDK: "langchain-openai", SDKVersion: "0.1.8"}},
+		{"curl with api version", map[string]string{"User-Agent": "curl/8.4.0", "X-API-Version": "v2"},
+			Client{SDK: "curl", SDKVersion: "8.4.0", APIVersion: "v2"}},
+		{"browser", map[string]string{"User-Agent": "Mozilla/5.0 (X11; Linux x86_64) Firefox/128.0"}, Client{SDK: "browser"}},
+		{"long version", map[string]string{"User-Agent": "tool/" + strings.Repeat("1", 100)},
+			Client{SDK: "tool", SDKVersion: strings.Repeat("1", maxValueLen)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, ParseClient(r))
+		})
+	}
+}
+
+func TestFieldRoundTrip(t *testing.T) {
+	f := field{Client: Client{SDK: "odd\"|:sdk", SDKVersion: "1.0"}, Method: "GET", Route: "/v1/batch/{id}"}
+
+	decoded, ok := decodeField(f.encode())
+	assert.True(t, ok)
+	assert.Equal(t, f, decoded)
+
+	_, ok = decodeField(`["too","short"]`)
+	assert.False(t, ok)
+}
@@ -10,6 +10,7 @@
 package billing
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -18,13 +19,73 @@ import (
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/MaksimVF/ZB/pkg/outbox"
+)
+
+// SubjectUsageRecorded is published through the outbox for every usage record
+const SubjectUsageRecorded = "billing.usage.recorded"
+
+// UsageRecordedEvent is the payload of SubjectUsageRecorded
+type UsageRecordedEvent struct {
+	UsageID string  `json:"usage_id"`
+	UserID  string  `json:"user_id"`
+	Model   string  `json:"model"`
+	Tokens  int     `json:"tokens"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// SubjectAudioUsageRecorded is published through the outbox for every audio
+// usage record
+const SubjectAudioUsageRecorded = "billing.audio.recorded"
+
+// Units audio usage is billed in
+const (
+	UnitSeconds    = "seconds"
+	UnitCharacters = "characters"
 )
 
+// AudioUsageRecordedEvent is the payload of SubjectAudioUsageRecorded
+type AudioUsageRecordedEvent struct {
+	UsageID  string  `json:"usage_id"`
+	UserID   string  `json:"user_id"`
+	Model    string  `json:"model"`
+	Unit     string  `json:"unit"`
+	Quantity float64 `json:"quantity"`
+	CostUSD  float64 `json:"cost_usd"`
+}
+
 var (
 	db       *sql.DB
 	billMutex = &sync.Mutex{}
+
+	// Alerted on when the spend of a day goes over the budget
+	usageCost = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_usage_cost_usd_total",
+			Help: "Cost of the usage recorded for billing",
+		},
+		[]string{"model"},
+	)
 )
 
+func init() {
+	prometheus.MustRegister(usageCost)
+}
+
+// AudioUsageRecord is a billed transcription (seconds of audio) or speech
+// synthesis (characters of text)
+type AudioUsageRecord struct {
+	ID        string
+	UserID    string
+	Model     string
+	Unit      string
+	Quantity  float64
+	Timestamp time.Time
+	Cost      float64
+}
+
 type UsageRecord struct {
 	ID        string
 	UserID    string
@@ -56,6 +117,29 @@ func Init(dbConnString string) error {
 		return fmt.Errorf("failed to create usage table: %w", err)
 	}
 
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audio_usage (
+			id SERIAL PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			model TEXT NOT NULL,
+			unit TEXT NOT NULL,
+			quantity NUMERIC(14, 3) NOT NULL,
+			timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			cost NUMERIC(12, 6) NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create audio usage table: %w", err)
+	}
+
+	if _, err := db.Exec(usageDailySchema); err != nil {
+		return fmt.Errorf("failed to create usage_daily table: %w", err)
+	}
+
+	if _, err := db.Exec(outbox.Schema); err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+
 	return nil
 }
 
@@ -66,18 +150,114 @@ func TrackUsage(userID, model string, tokens int) error {
 	// Calculate cost based on model and token count
 	cost := calculateCost(model, tokens)
 
-	_, err := db.Exec(`
+	// The usage record and its event commit together
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO langchain_usage (user_id, model, tokens, cost)
 		VALUES ($1, $2, $3, $4)
-	`, userID, model, tokens, cost)
+		RETURNING id
+	`, userID, model, tokens, cost).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
 
+	_, err = outbox.Add(ctx, tx, SubjectUsageRecorded, UsageRecordedEvent{
+		UsageID: id,
+		UserID:  userID,
+		Model:   model,
+		Tokens:  tokens,
+		CostUSD: cost,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to record usage: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	usageCost.WithLabelValues(model).Add(cost)
 	return nil
 }
 
+// TrackAudioUsage records audio usage priced by the caller, which knows the
+// unit: seconds of transcribed audio or characters of synthesized speech
+func TrackAudioUsage(userID, model, unit string, quantity, cost float64) error {
+	billMutex.Lock()
+	defer billMutex.Unlock()
+
+	// The usage record and its event commit together
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to record audio usage: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO audio_usage (user_id, model, unit, quantity, cost)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID, model, unit, quantity, cost).Scan(&id)
+	if err != nil {
+		return fmt.Errorf("failed to record audio usage: %w", err)
+	}
+
+	_, err = outbox.Add(ctx, tx, SubjectAudioUsageRecorded, AudioUsageRecordedEvent{
+		UsageID:  id,
+		UserID:   userID,
+		Model:    model,
+		Unit:     unit,
+		Quantity: quantity,
+		CostUSD:  cost,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record audio usage: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to record audio usage: %w", err)
+	}
+	usageCost.WithLabelValues(model).Add(cost)
+	return nil
+}
+
+// GetAudioUsageReport returns the audio usage of a user between start and
+// end, newest first
+func GetAudioUsageReport(userID string, start, end time.Time) ([]AudioUsageRecord, error) {
+	billMutex.Lock()
+	defer billMutex.Unlock()
+
+	rows, err := db.Query(`
+		SELECT id, user_id, model, unit, quantity, timestamp, cost
+		FROM audio_usage
+		WHERE user_id = $1 AND timestamp BETWEEN $2 AND $3
+		ORDER BY timestamp DESC
+	`, userID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audio usage report: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AudioUsageRecord
+	for rows.Next() {
+		var r AudioUsageRecord
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Model, &r.Unit, &r.Quantity, &r.Timestamp, &r.Cost); err != nil {
+			return nil, fmt.Errorf("failed to scan audio usage record: %w", err)
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
 func GetUsageReport(userID string, start, end time.Time) ([]UsageRecord, error) {
 	billMutex.Lock()
 	defer billMutex.Unlock()
@@ -142,6 +322,11 @@ func GetTotalCost(userID string, start, end time.Time) (float64, error) {
 	return total, nil
 }
 
+// DB returns the billing database, e.g. for readiness checks
+func DB() *sql.DB {
+	return db
+}
+
 func Close() {
 	if db != nil {
 		db.Close()
@@ -0,0 +1,234 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// usageDailySchema holds the usage per model and day, aggregated from
+// langchain_usage by the report job
+const usageDailySchema = `
+	CREATE TABLE IF NOT EXISTS usage_daily (
+		day DATE NOT NULL,
+		model TEXT NOT NULL,
+		requests BIGINT NOT NULL,
+		tokens BIGINT NOT NULL,
+		cost NUMERIC(14, 4) NOT NULL,
+		PRIMARY KEY (day, model)
+	)
+`
+
+// minSeasonalDays is the history needed before weekday seasonality is
+// fitted; shorter histories are projected on the trend alone
+const minSeasonalDays = 14
+
+// DailyUsage is the usage of a model on one day
+type DailyUsage struct {
+	Day      time.Time
+	Model    string
+	Requests int64
+	Tokens   int64
+	Cost     float64
+}
+
+// ForecastDay is the projected usage of one day
+type ForecastDay struct {
+	Date    string  `json:"date"`
+	Tokens  int64   `json:"tokens"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// ModelForecast is the projected usage of a model
+type ModelForecast struct {
+	Model string `json:"model"`
+	// HistoryDays is the number of days the projection is fitted on
+	HistoryDays int `json:"history_days"`
+	// Trend is the fitted change in tokens per day
+	Trend    float64       `json:"trend_tokens_per_day"`
+	Seasonal bool          `json:"seasonal"`
+	Tokens   int64         `json:"tokens"`
+	CostUSD  float64       `json:"cost_usd"`
+	Daily    []ForecastDay `json:"daily"`
+}
+
+// AggregateUsage upserts the usage per model and day into usage_daily,
+// starting from the last aggregated day so that a partial day is completed.
+// It is idempotent and safe to run from every replica.
+func AggregateUsage(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO usage_daily (day, model, requests, tokens, cost)
+		SELECT timestamp::date, model, COUNT(*), SUM(tokens), SUM(cost)
+		FROM langchain_usage
+		WHERE timestamp >= COALESCE((SELECT MAX(day) FROM usage_daily), '-infinity'::timestamp)
+		GROUP BY 1, 2
+		ON CONFLICT (day, model) DO UPDATE SET
+			requests = EXCLUDED.requests,
+			tokens = EXCLUDED.tokens,
+			cost = EXCLUDED.cost
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate usage: %w", err)
+	}
+	return nil
+}
+
+// RunReports aggregates usage now and then every interval until ctx is done
+func RunReports(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := AggregateUsage(ctx); err != nil {
+			log.Printf("Usage report job: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DailyUsageSince returns the aggregated usage of the complete days from
+// since up to yesterday, in day order
+func DailyUsageSince(ctx context.Context, since time.Time) ([]DailyUsage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT day, model, requests, tokens, cost
+		FROM usage_daily
+		WHERE day >= $1 AND day < CURRENT_DATE
+		ORDER BY day, model
+	`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load daily usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []DailyUsage
+	for rows.Next() {
+		var u DailyUsage
+		if err := rows.Scan(&u.Day, &u.Model, &u.Requests, &u.Tokens, &u.Cost); err != nil {
+			return nil, fmt.Errorf("failed to scan daily usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// Forecast projects the tokens and cost per model over the horizon days
+// after the last day of history. Each model is fitted on the days since it
+// was first seen, missing days counting as no usage: a least-squares linear
+// trend plus, with minSeasonalDays of history, the mean deviation from the
+// trend per weekday. Cost is projected at the model's historical cost per
+// token.
+func Forecast(history []DailyUsage, horizon int) []ModelForecast {
+	if len(history) == 0 || horizon <= 0 {
+		return nil
+	}
+	last := history[0].Day
+	byModel := map[string][]DailyUsage{}
+	for _, u := range history {
+		if u.Day.After(last) {
+			last = u.Day
+		}
+		byModel[u.Model] = append(byModel[u.Model], u)
+	}
+
+	forecasts := make([]ModelForecast, 0, len(byModel))
+	for model, usage := range byModel {
+		forecasts = append(forecasts, forecastModel(model, usage, last, horizon))
+	}
+	sort.Slice(forecasts, func(i, j int) bool {
+		if forecasts[i].CostUSD != forecasts[j].CostUSD {
+			return forecasts[i].CostUSD > forecasts[j].CostUSD
+		}
+		return forecasts[i].Model < forecasts[j].Model
+	})
+	return forecasts
+}
+
+func forecastModel(model string, usage []DailyUsage, last time.Time, horizon int) ModelForecast {
+	first := usage[0].Day
+	for _, u := range usage {
+		if u.Day.Before(first) {
+			first = u.Day
+		}
+	}
+	n := daysBetween(first, last) + 1
+	tokens := make([]float64, n)
+	var totalTokens, totalCost float64
+	for _, u := range usage {
+		tokens[daysBetween(first, u.Day)] += float64(u.Tokens)
+		totalTokens += float64(u.Tokens)
+		totalCost += u.Cost
+	}
+	costPerToken := 0.0
+	if totalTokens > 0 {
+		costPerToken = totalCost / totalTokens
+	}
+
+	slope, intercept := linearFit(tokens)
+	var seasonal [7]float64
+	fitted := n >= minSeasonalDays
+	if fitted {
+		var counts [7]int
+		for i, y := range tokens {
+			w := first.AddDate(0, 0, i).Weekday()
+			seasonal[w] += y - (intercept + slope*float64(i))
+			counts[w]++
+		}
+		for w := range seasonal {
+			if counts[w] > 0 {
+				seasonal[w] /= float64(counts[w])
+			}
+		}
+	}
+
+	f := ModelForecast{Model: model, HistoryDays: n, Trend: slope, Seasonal: fitted, Daily: make([]ForecastDay, horizon)}
+	for d := 0; d < horizon; d++ {
+		day := last.AddDate(0, 0, d+1)
+		i := float64(n + d)
+		y := math.Max(0, intercept+slope*i+seasonal[day.Weekday()])
+		f.Daily[d] = ForecastDay{
+			Date:    day.Format("2006-01-02"),
+			Tokens:  int64(math.Round(y)),
+			CostUSD: roundUSD(y * costPerToken),
+		}
+		f.Tokens += f.Daily[d].Tokens
+		f.CostUSD += y * costPerToken
+	}
+	f.CostUSD = roundUSD(f.CostUSD)
+	return f
+}
+
+// linearFit returns the least-squares line through ys at x = 0, 1, ...
+func linearFit(ys []float64) (slope, intercept float64) {
+	n := float64(len(ys))
+	if len(ys) < 2 {
+		if len(ys) == 1 {
+			return 0, ys[0]
+		}
+		return 0, 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range ys {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	slope = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept = (sumY - slope*sumX) / n
+	return slope, intercept
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(math.Round(to.Sub(from).Hours() / 24))
+}
+
+func roundUSD(usd float64) float64 {
+	return math.Round(usd*1e4) / 1e4
+}
@@ -0,0 +1,80 @@
+package billing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func day(offset int) time.Time {
+	// 2026-09-07 is a Monday
+	return time.Date(2026, 9, 7, 0, 0, 0, 0, time.UTC).AddDate(0, 0, offset)
+}
+
+func TestForecastTrend(t *testing.T) {
+	// gpt-4 grows by 100 tokens a day at $0.06 per 1000; llama-3 is only seen
+	// on a single day
+	var history []DailyUsage
+	for i := 0; i < 10; i++ {
+		tokens := int64(1000 + 100*i)
+		history = append(history, DailyUsage{Day: day(i), Model: "gpt-4", Tokens: tokens, Cost: float64(tokens) * 0.00006})
+	}
+	history = append(history, DailyUsage{Day: day(9), Model: "llama-3", Tokens: 500, Cost: 0.01})
+
+	forecasts := Forecast(history, 3)
+	require.Len(t, forecasts, 2)
+
+	gpt4 := forecasts[0]
+	assert.Equal(t, "gpt-4", gpt4.Model)
+	assert.Equal(t, 10, gpt4.HistoryDays)
+	assert.False(t, gpt4.Seasonal)
+	assert.InDelta(t, 100, gpt4.Trend, 1e-9)
+	assert.Equal(t, []ForecastDay{
+		{Date: "2026-09-17", Tokens: 2000, CostUSD: 0.12},
+		{Date: "2026-09-18", Tokens: 2100, CostUSD: 0.126},
+		{Date: "2026-09-19", Tokens: 2200, CostUSD: 0.132},
+	}, gpt4.Daily)
+	assert.Equal(t, int64(6300), gpt4.Tokens)
+	assert.InDelta(t, 0.378, gpt4.CostUSD, 1e-9)
+
+	llama := forecasts[1]
+	assert.Equal(t, 1, llama.HistoryDays)
+	assert.Equal(t, int64(1500), llama.Tokens)
+}
+
+func TestForecastSeasonal(t *testing.T) {
+	// Flat weekdays with quiet weekends over four weeks; the missing Saturday
+	// counts as no usage
+	var history []DailyUsage
+	for i := 0; i < 28; i++ {
+		if i == 5 {
+			continue
+		}
+		tokens := int64(7000)
+		if wd := day(i).Weekday(); wd == time.Saturday || wd == time.Sunday {
+			tokens = 0
+		}
+		history = append(history, DailyUsage{Day: day(i), Model: "claude-3", Tokens: tokens, Cost: float64(tokens) * 0.00004})
+	}
+
+	forecasts := Forecast(history, 7)
+	require.Len(t, forecasts, 1)
+	f := forecasts[0]
+	assert.True(t, f.Seasonal)
+	assert.Equal(t, 28, f.HistoryDays)
+
+	// The forecast week starts on a Monday; weekends stay far below weekdays
+	for i, d := range f.Daily {
+		weekend := i >= 5
+		if weekend {
+			assert.Less(t, d.Tokens, int64(1000), d.Date)
+		} else {
+			assert.Greater(t, d.Tokens, int64(5000), d.Date)
+		}
+		assert.GreaterOrEqual(t, d.Tokens, int64(0))
+	}
+
+	assert.Nil(t, Forecast(nil, 30))
+}
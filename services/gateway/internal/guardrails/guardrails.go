@@ -0,0 +1,557 @@
+// Package guardrails evaluates a tenant's guardrail policy before a model is
+// called and on its response: which tools the request may offer, topics it
+// may not touch, the most a single request may cost and what has to be
+// redacted from prompts and completions.
+//
+// Bundles are stored per tenant in a Redis hash like model policies, with the
+// same change channel and periodic reload; the bundle of DefaultTenant applies
+// to tenants without their own. A bundle may carry its own Rego module,
+// inline or as a secret-service key. With an OPA server configured every
+// bundle is pushed to it and decisions are made by OPA; without one the
+// built-in rules are evaluated in process and custom Rego is refused.
+//
+// Every decision is logged, counted and kept in a per-tenant list in Redis
+// for the admin API.
+package guardrails
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+const (
+	bundlesKey     = "gateway:guardrails"
+	bundlesChannel = "gateway:guardrails:changed"
+	decisionsKey   = "gateway:guardrails:decisions:"
+	// maxDecisions is how many decisions are kept per tenant
+	maxDecisions = 1000
+)
+
+// DefaultTenant is the tenant whose bundle applies to tenants without one
+const DefaultTenant = "*"
+
+// Phases of a model call
+const (
+	PhasePre  = "pre"
+	PhasePost = "post"
+)
+
+// Modes of a bundle
+const (
+	// ModeEnforce refuses requests and responses the policy denies
+	ModeEnforce = "enforce"
+	// ModeAudit only logs what would have been denied
+	ModeAudit = "audit"
+)
+
+var (
+	// ErrNotFound is returned for tenants without a stored bundle
+	ErrNotFound = errors.New("guardrails not found")
+	// ErrInvalid wraps validation failures
+	ErrInvalid = errors.New("invalid guardrails")
+)
+
+var logger = zerolog.New(os.Stdout).With().Timestamp().Str("service", "guardrails").Logger()
+
+var decisionsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "gateway_guardrails_decisions_total",
+		Help: "Guardrail decisions by phase and result (allow, deny, audit_deny, error)",
+	},
+	[]string{"phase", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(decisionsTotal)
+}
+
+// Bundle is the guardrail policy of a tenant. The lists are the data of the
+// built-in rules; Rego, or the module stored under RegoSecret, may add deny
+// reasons and redactions of its own.
+type Bundle struct {
+	// AllowedTools are the function names a request may offer the model; an
+	// empty list allows every tool
+	AllowedTools []string `json:"allowed_tools,omitempty"`
+	// DeniedTopics are phrases prompts and completions may not contain,
+	// matched case-insensitively
+	DeniedTopics []string `json:"denied_topics,omitempty"`
+	// MaxCostUSD caps the estimated cost of a request before the call and its
+	// actual cost after; 0 is no cap
+	MaxCostUSD float64 `json:"max_cost_usd,omitempty"`
+	// Redactions name the redactors applied to prompts and completions
+	Redactions []string `json:"redactions,omitempty"`
+	// Rego is a module defining deny and redact sets over the same input as
+	// the built-in rules; its package is set by the gateway. RegoSecret is
+	// the secret-service key of such a module.
+	Rego       string `json:"rego,omitempty"`
+	RegoSecret string `json:"rego_secret,omitempty"`
+	// Mode is enforce (default) or audit
+	Mode      string    `json:"mode,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the mode and the redactors
+func (b Bundle) Validate() error {
+	if b.Mode != "" && b.Mode != ModeEnforce && b.Mode != ModeAudit {
+		return fmt.Errorf("%w: mode must be %q or %q", ErrInvalid, ModeEnforce, ModeAudit)
+	}
+	if b.MaxCostUSD < 0 {
+		return fmt.Errorf("%w: max_cost_usd must not be negative", ErrInvalid)
+	}
+	for _, name := range b.Redactions {
+		if _, ok := redactors[name]; !ok {
+			return fmt.Errorf("%w: unknown redaction %q", ErrInvalid, name)
+		}
+	}
+	if b.Rego != "" && b.RegoSecret != "" {
+		return fmt.Errorf("%w: set rego or rego_secret, not both", ErrInvalid)
+	}
+	return nil
+}
+
+// Input is what a decision is made on
+type Input struct {
+	Tenant string   `json:"tenant"`
+	Phase  string   `json:"phase"`
+	Model  string   `json:"model"`
+	Tools  []string `json:"tools,omitempty"`
+	// Text is the prompt before the call and the completion after it
+	Text string `json:"text"`
+	// CostUSD is the estimated cost before the call and the actual one after
+	CostUSD float64 `json:"cost_usd"`
+}
+
+// Decision is the outcome of an evaluation
+type Decision struct {
+	ID      string   `json:"id"`
+	Tenant  string   `json:"tenant"`
+	Phase   string   `json:"phase"`
+	Model   string   `json:"model"`
+	Allow   bool     `json:"allow"`
+	Reasons []string `json:"reasons,omitempty"`
+	Redact  []string `json:"redact,omitempty"`
+	Mode    string   `json:"mode"`
+	// Error is set when the policy could not be evaluated; Allow then
+	// follows the fail-open setting
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+	Time       time.Time `json:"time"`
+}
+
+// Config of the guardrails
+type Config struct {
+	// OPAURL is the OPA server decisions are made by, e.g. http://opa:8181;
+	// empty evaluates the built-in rules in process
+	OPAURL string
+	// FailOpen allows requests when the policy cannot be evaluated
+	FailOpen bool
+	// ResolveSecret reads the Rego module of a bundle from secret-service
+	ResolveSecret func(key string) (string, error)
+	// Interval of the periodic reload
+	Interval time.Duration
+}
+
+var (
+	client *redis.Client
+	config Config
+	opa    *opaClient
+
+	mu      sync.RWMutex
+	bundles = map[string]Bundle{}
+)
+
+// Init loads the bundles from Redis, pushes them to OPA and reloads them on
+// change until ctx is cancelled. Without Init no guardrails apply.
+func Init(ctx context.Context, rdb *redis.Client, cfg Config) {
+	client = rdb
+	config = cfg
+	if cfg.OPAURL != "" {
+		opa = newOPAClient(cfg.OPAURL)
+	}
+	if err := reload(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to load guardrails")
+	}
+
+	sub := client.Subscribe(ctx, bundlesChannel)
+	ticker := time.NewTicker(cfg.Interval)
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+			case <-ticker.C:
+			}
+			if err := reload(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to reload guardrails")
+			}
+		}
+	}()
+}
+
+func reload(ctx context.Context) error {
+	loaded, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	if opa != nil {
+		if err := opa.sync(ctx, loaded, resolveRego); err != nil {
+			// Decisions keep using what OPA has until the next reload
+			logger.Error().Err(err).Msg("Failed to push guardrails to OPA")
+		}
+	}
+	mu.Lock()
+	bundles = loaded
+	mu.Unlock()
+	return nil
+}
+
+func load(ctx context.Context) (map[string]Bundle, error) {
+	entries, err := client.HGetAll(ctx, bundlesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]Bundle, len(entries))
+	for tenant, raw := range entries {
+		var b Bundle
+		if err := json.Unmarshal([]byte(raw), &b); err != nil {
+			logger.Error().Str("tenant", tenant).Err(err).Msg("Skipping malformed guardrails")
+			continue
+		}
+		loaded[tenant] = b
+	}
+	return loaded, nil
+}
+
+// resolveRego returns the Rego module of a bundle, reading it from
+// secret-service when the bundle names a secret
+func resolveRego(b Bundle) (string, error) {
+	if b.RegoSecret == "" {
+		return b.Rego, nil
+	}
+	if config.ResolveSecret == nil {
+		return "", errors.New("no secret resolver configured")
+	}
+	return config.ResolveSecret(b.RegoSecret)
+}
+
+// lookup returns the bundle of a tenant and the key it is stored under, the
+// default bundle for tenants without one
+func lookup(tenant string) (Bundle, string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if b, ok := bundles[tenant]; ok {
+		return b, tenant, true
+	}
+	if b, ok := bundles[DefaultTenant]; ok {
+		return b, DefaultTenant, true
+	}
+	return Bundle{}, "", false
+}
+
+// For returns the bundle of a tenant, the default bundle for tenants without
+// one, or nil when neither exists
+func For(tenant string) *Bundle {
+	b, _, ok := lookup(tenant)
+	if !ok {
+		return nil
+	}
+	return &b
+}
+
+// Evaluate decides on a request or response of a tenant and logs the
+// decision. It returns nil when no bundle applies to the tenant. In audit
+// mode, and when evaluation fails with fail-open, Allow is true whatever the
+// policy says; the reasons are kept for the log.
+func Evaluate(ctx context.Context, in Input) *Decision {
+	bundle, key, ok := lookup(in.Tenant)
+	if !ok {
+		return nil
+	}
+
+	start := time.Now()
+	d := &Decision{
+		ID:     newDecisionID(),
+		Tenant: in.Tenant,
+		Phase:  in.Phase,
+		Model:  in.Model,
+		Mode:   bundle.Mode,
+		Time:   start.UTC(),
+	}
+	if d.Mode == "" {
+		d.Mode = ModeEnforce
+	}
+
+	var result evaluation
+	var err error
+	if opa != nil {
+		result, err = opa.evaluate(ctx, key, in)
+	} else {
+		result = evaluateLocal(bundle, in)
+	}
+	d.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+
+	label := "allow"
+	switch {
+	case err != nil:
+		d.Error = err.Error()
+		d.Allow = config.FailOpen
+		label = "error"
+	case len(result.Reasons) > 0:
+		d.Reasons = result.Reasons
+		d.Allow = d.Mode == ModeAudit
+		label = "deny"
+		if d.Allow {
+			label = "audit_deny"
+		}
+	default:
+		d.Allow = true
+	}
+	d.Redact = result.Redact
+	decisionsTotal.WithLabelValues(in.Phase, label).Inc()
+
+	logDecision(d)
+	return d
+}
+
+func newDecisionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// evaluation is what the policy says about an input
+type evaluation struct {
+	Reasons []string `json:"reasons"`
+	Redact  []string `json:"redact"`
+}
+
+// evaluateLocal applies the built-in rules, the same as builtinModule
+func evaluateLocal(b Bundle, in Input) evaluation {
+	var result evaluation
+	if in.Phase == PhasePre && len(b.AllowedTools) > 0 {
+		for _, tool := range in.Tools {
+			if !contains(b.AllowedTools, tool) {
+				result.Reasons = append(result.Reasons, fmt.Sprintf("tool %q is not allowed", tool))
+			}
+		}
+	}
+	text := strings.ToLower(in.Text)
+	for _, topic := range b.DeniedTopics {
+		if topic != "" && strings.Contains(text, strings.ToLower(topic)) {
+			result.Reasons = append(result.Reasons, fmt.Sprintf("topic %q is not allowed", topic))
+		}
+	}
+	if b.MaxCostUSD > 0 && in.CostUSD > b.MaxCostUSD {
+		result.Reasons = append(result.Reasons,
+			fmt.Sprintf("cost %.4f USD exceeds the limit of %.4f USD", in.CostUSD, b.MaxCostUSD))
+	}
+	result.Redact = b.Redactions
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// logDecision writes the decision to the log and the tenant's decision list
+func logDecision(d *Decision) {
+	event := logger.Info()
+	if !d.Allow || d.Error != "" {
+		event = logger.Warn()
+	}
+	event.Str("decision_id", d.ID).
+		Str("tenant", d.Tenant).
+		Str("phase", d.Phase).
+		Str("model", d.Model).
+		Str("mode", d.Mode).
+		Bool("allow", d.Allow).
+		Strs("reasons", d.Reasons).
+		Strs("redact", d.Redact).
+		Str("error", d.Error).
+		Float64("duration_ms", d.DurationMs).
+		Msg("Guardrail decision")
+
+	if client == nil {
+		return
+	}
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		key := decisionsKey + d.Tenant
+		_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LPush(ctx, key, raw)
+			pipe.LTrim(ctx, key, 0, maxDecisions-1)
+			return nil
+		})
+		if err != nil {
+			logger.Warn().Err(err).Str("decision_id", d.ID).Msg("Failed to store guardrail decision")
+		}
+	}()
+}
+
+// Decisions returns the latest decisions of a tenant, newest first
+func Decisions(ctx context.Context, tenant string, limit int) ([]Decision, error) {
+	if client == nil {
+		return []Decision{}, nil
+	}
+	entries, err := client.LRange(ctx, decisionsKey+tenant, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	decisions := make([]Decision, 0, len(entries))
+	for _, raw := range entries {
+		var d Decision
+		if err := json.Unmarshal([]byte(raw), &d); err == nil {
+			decisions = append(decisions, d)
+		}
+	}
+	return decisions, nil
+}
+
+// List returns the stored bundles by tenant
+func List(ctx context.Context) (map[string]Bundle, error) {
+	if client == nil {
+		return map[string]Bundle{}, nil
+	}
+	return load(ctx)
+}
+
+// Get returns the stored bundle of a tenant
+func Get(ctx context.Context, tenant string) (Bundle, error) {
+	if client == nil {
+		return Bundle{}, ErrNotFound
+	}
+	raw, err := client.HGet(ctx, bundlesKey, tenant).Result()
+	if err == redis.Nil {
+		return Bundle{}, ErrNotFound
+	}
+	if err != nil {
+		return Bundle{}, err
+	}
+	var b Bundle
+	if err := json.Unmarshal([]byte(raw), &b); err != nil {
+		return Bundle{}, err
+	}
+	return b, nil
+}
+
+// Save validates and stores the bundle of a tenant, then notifies all
+// replicas. Custom Rego is compiled by OPA before it is stored.
+func Save(ctx context.Context, tenant string, b Bundle) (Bundle, error) {
+	if tenant == "" {
+		return Bundle{}, fmt.Errorf("%w: tenant is required", ErrInvalid)
+	}
+	if err := b.Validate(); err != nil {
+		return Bundle{}, err
+	}
+	if client == nil {
+		return Bundle{}, errors.New("guardrails store is not configured")
+	}
+	if b.Rego != "" || b.RegoSecret != "" {
+		if opa == nil {
+			return Bundle{}, fmt.Errorf("%w: custom rego requires an OPA server", ErrInvalid)
+		}
+		module, err := resolveRego(b)
+		if err != nil {
+			return Bundle{}, fmt.Errorf("%w: rego_secret: %v", ErrInvalid, err)
+		}
+		if err := opa.putModule(ctx, tenant, module); err != nil {
+			return Bundle{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+
+	b.UpdatedAt = time.Now().UTC()
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return Bundle{}, err
+	}
+	if err := client.HSet(ctx, bundlesKey, tenant, raw).Err(); err != nil {
+		return Bundle{}, fmt.Errorf("failed to persist guardrails: %w", err)
+	}
+	applyLocal(tenant, &b)
+	return b, publish(ctx)
+}
+
+// Delete removes the bundle of a tenant, then notifies all replicas
+func Delete(ctx context.Context, tenant string) error {
+	if client == nil {
+		return ErrNotFound
+	}
+	n, err := client.HDel(ctx, bundlesKey, tenant).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete guardrails: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	applyLocal(tenant, nil)
+	return publish(ctx)
+}
+
+// applyLocal makes a change visible on this replica before the reload
+func applyLocal(tenant string, b *Bundle) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	updated := make(map[string]Bundle, len(bundles)+1)
+	for t, existing := range bundles {
+		updated[t] = existing
+	}
+	if b == nil {
+		delete(updated, tenant)
+	} else {
+		updated[tenant] = *b
+	}
+	bundles = updated
+}
+
+func publish(ctx context.Context) error {
+	// The change is persisted: replicas that miss it catch up on reload
+	if err := client.Publish(ctx, bundlesChannel, time.Now().Unix()).Err(); err != nil {
+		logger.Warn().Err(err).Msg("Failed to broadcast guardrails change")
+	}
+	return nil
+}
+
+// packageName is the Rego package segment of a tenant's custom module; the
+// hash keeps tenants that differ only in replaced characters apart
+func packageName(tenant string) string {
+	if tenant == DefaultTenant {
+		return "default_tenant"
+	}
+	h := fnv.New32a()
+	h.Write([]byte(tenant))
+	return fmt.Sprintf("t_%s_%08x", invalidPackageChars.ReplaceAllString(tenant, "_"), h.Sum32())
+}
+
+var invalidPackageChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
@@ -0,0 +1,67 @@
+package guardrails
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateLocal(t *testing.T) {
+	b := Bundle{
+		AllowedTools: []string{"search"},
+		DeniedTopics: []string{"Weapons"},
+		MaxCostUSD:   0.5,
+		Redactions:   []string{"email"},
+	}
+
+	ok := evaluateLocal(b, Input{Phase: PhasePre, Tools: []string{"search"}, Text: "hello", CostUSD: 0.1})
+	assert.Empty(t, ok.Reasons)
+	assert.Equal(t, []string{"email"}, ok.Redact)
+
+	denied := evaluateLocal(b, Input{Phase: PhasePre, Tools: []string{"search", "shell"}, Text: "about weapons", CostUSD: 1})
+	assert.Len(t, denied.Reasons, 3)
+
+	// Tools are only checked before the call
+	post := evaluateLocal(b, Input{Phase: PhasePost, Tools: []string{"shell"}, Text: "fine"})
+	assert.Empty(t, post.Reasons)
+}
+
+func TestEvaluateModes(t *testing.T) {
+	applyLocal("acme", &Bundle{DeniedTopics: []string{"secret"}})
+	applyLocal("globex", &Bundle{DeniedTopics: []string{"secret"}, Mode: ModeAudit})
+	defer func() { bundles = map[string]Bundle{} }()
+
+	d := Evaluate(context.Background(), Input{Tenant: "acme", Phase: PhasePre, Text: "the secret plan"})
+	assert.False(t, d.Allow)
+	assert.Equal(t, ModeEnforce, d.Mode)
+
+	d = Evaluate(context.Background(), Input{Tenant: "globex", Phase: PhasePre, Text: "the secret plan"})
+	assert.True(t, d.Allow)
+	assert.NotEmpty(t, d.Reasons)
+
+	assert.Nil(t, Evaluate(context.Background(), Input{Tenant: "initech", Phase: PhasePre}))
+}
+
+func TestRedact(t *testing.T) {
+	text := "Mail jane.doe@example.com or call +1 (555) 123-4567, card 4111 1111 1111 1111"
+
+	assert.Equal(t, "Mail [REDACTED_EMAIL] or call +1 (555) 123-4567, card 4111 1111 1111 1111",
+		Redact(text, []string{"email"}))
+	assert.Equal(t, "Mail [REDACTED_EMAIL] or call [REDACTED_PHONE], card [REDACTED_CARD]",
+		Redact(text, []string{"email", "phone", "credit_card"}))
+	assert.Equal(t, text, Redact(text, []string{"unknown"}))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Bundle{Redactions: []string{"email"}, Mode: ModeAudit}.Validate())
+	assert.Error(t, Bundle{Redactions: []string{"passport"}}.Validate())
+	assert.Error(t, Bundle{Mode: "block"}.Validate())
+	assert.Error(t, Bundle{Rego: "deny contains 1 if true", RegoSecret: "guardrails/acme"}.Validate())
+}
+
+func TestPackageName(t *testing.T) {
+	assert.Equal(t, "default_tenant", packageName(DefaultTenant))
+	assert.Regexp(t, `^t_user_42_[0-9a-f]{8}$`, packageName("user-42"))
+	assert.NotEqual(t, packageName("user-42"), packageName("user_42"))
+}
@@ -0,0 +1,234 @@
+package guardrails
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// builtinModule makes the decision from the tenant data pushed under
+// data.zb.guardrails.tenants and the tenant's custom module, if any
+const builtinModule = `package zb.guardrails
+
+import rego.v1
+
+tenant := data.zb.guardrails.tenants[input.bundle]
+
+deny contains msg if {
+	input.phase == "pre"
+	count(tenant.allowed_tools) > 0
+	some tool in input.tools
+	not tool in tenant.allowed_tools
+	msg := sprintf("tool %q is not allowed", [tool])
+}
+
+deny contains msg if {
+	some topic in tenant.denied_topics
+	topic != ""
+	contains(lower(input.text), lower(topic))
+	msg := sprintf("topic %q is not allowed", [topic])
+}
+
+deny contains msg if {
+	tenant.max_cost_usd > 0
+	input.cost_usd > tenant.max_cost_usd
+	msg := sprintf("cost %.4f USD exceeds the limit of %.4f USD", [input.cost_usd, tenant.max_cost_usd])
+}
+
+deny contains msg if {
+	some msg in data.zb.guardrails.custom[input.package].deny
+}
+
+redact contains name if {
+	some name in tenant.redactions
+}
+
+redact contains name if {
+	some name in data.zb.guardrails.custom[input.package].redact
+}
+
+decision := {"reasons": deny, "redact": redact}
+`
+
+const (
+	builtinPolicyID = "zb_guardrails"
+	customPolicyID  = "zb_guardrails_custom_"
+)
+
+var packageLine = regexp.MustCompile(`(?m)^\s*package\s+\S+\s*$`)
+
+// opaClient talks to the REST API of an OPA server
+type opaClient struct {
+	url  string
+	http *http.Client
+	// modules are the custom policy IDs pushed by the last sync
+	modules map[string]bool
+}
+
+func newOPAClient(url string) *opaClient {
+	return &opaClient{
+		url:     strings.TrimRight(url, "/"),
+		http:    &http.Client{Timeout: 5 * time.Second},
+		modules: map[string]bool{},
+	}
+}
+
+// sync pushes the built-in module, the tenant data and the custom modules,
+// and removes the custom modules of deleted bundles
+func (c *opaClient) sync(ctx context.Context, loaded map[string]Bundle, rego func(Bundle) (string, error)) error {
+	if err := c.put(ctx, "/v1/policies/"+builtinPolicyID, "text/plain", []byte(builtinModule)); err != nil {
+		return fmt.Errorf("built-in module: %w", err)
+	}
+
+	tenants := make(map[string]Bundle, len(loaded))
+	pushed := map[string]bool{}
+	for tenant, b := range loaded {
+		// OPA gets the rule data only
+		b.Rego, b.RegoSecret = "", ""
+		tenants[tenant] = b
+
+		module, err := rego(loaded[tenant])
+		if err != nil {
+			logger.Error().Str("tenant", tenant).Err(err).Msg("Failed to read guardrails rego")
+			continue
+		}
+		if module == "" {
+			continue
+		}
+		if err := c.putModule(ctx, tenant, module); err != nil {
+			logger.Error().Str("tenant", tenant).Err(err).Msg("Failed to push guardrails rego")
+			continue
+		}
+		pushed[customPolicyID+packageName(tenant)] = true
+	}
+
+	data, err := json.Marshal(tenants)
+	if err != nil {
+		return err
+	}
+	if err := c.put(ctx, "/v1/data/zb/guardrails/tenants", "application/json", data); err != nil {
+		return fmt.Errorf("tenant data: %w", err)
+	}
+
+	for id := range c.modules {
+		if !pushed[id] {
+			if err := c.delete(ctx, "/v1/policies/"+id); err != nil {
+				logger.Warn().Str("policy", id).Err(err).Msg("Failed to remove guardrails rego")
+				pushed[id] = true
+			}
+		}
+	}
+	c.modules = pushed
+	return nil
+}
+
+// putModule compiles and stores a tenant's custom module in its own package
+func (c *opaClient) putModule(ctx context.Context, tenant, module string) error {
+	header := "package zb.guardrails.custom." + packageName(tenant)
+	if packageLine.MatchString(module) {
+		module = packageLine.ReplaceAllLiteralString(module, header)
+	} else {
+		module = header + "\n\n" + module
+	}
+	return c.put(ctx, "/v1/policies/"+customPolicyID+packageName(tenant), "text/plain", []byte(module))
+}
+
+// evaluate queries the decision for an input on the bundle stored under key
+func (c *opaClient) evaluate(ctx context.Context, key string, in Input) (evaluation, error) {
+	var query struct {
+		Input struct {
+			Input
+			Bundle  string `json:"bundle"`
+			Package string `json:"package"`
+		} `json:"input"`
+	}
+	query.Input.Input = in
+	query.Input.Bundle = key
+	query.Input.Package = packageName(key)
+
+	body, err := json.Marshal(query)
+	if err != nil {
+		return evaluation{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/v1/data/zb/guardrails/decision", bytes.NewReader(body))
+	if err != nil {
+		return evaluation{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return evaluation{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return evaluation{}, opaError(resp)
+	}
+
+	var decoded struct {
+		Result *evaluation `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return evaluation{}, fmt.Errorf("decode opa decision: %w", err)
+	}
+	if decoded.Result == nil {
+		// The built-in module is not loaded yet
+		return evaluation{}, fmt.Errorf("opa returned no decision")
+	}
+	return *decoded.Result, nil
+}
+
+func (c *opaClient) put(ctx context.Context, path, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return c.do(req)
+}
+
+func (c *opaClient) delete(ctx context.Context, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req)
+}
+
+func (c *opaClient) do(req *http.Request) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return opaError(resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// opaError carries OPA's message, e.g. the compile errors of a module
+func opaError(resp *http.Response) error {
+	var body struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	msg := body.Message
+	for _, e := range body.Errors {
+		msg += "; " + e.Message
+	}
+	if msg == "" {
+		msg = resp.Status
+	}
+	return fmt.Errorf("opa: %s", msg)
+}
@@ -0,0 +1,53 @@
+package guardrails
+
+import (
+	"regexp"
+	"sort"
+)
+
+// redactors are the redactions a bundle can require, by name; a match is
+// replaced with its placeholder
+var redactors = map[string]struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	"email":       {regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "[REDACTED_EMAIL]"},
+	"phone":       {regexp.MustCompile(`\+?\d[\d\-\s().]{8,}\d`), "[REDACTED_PHONE]"},
+	"credit_card": {regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`), "[REDACTED_CARD]"},
+	"ssn":         {regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), "[REDACTED_SSN]"},
+	"ip_address":  {regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), "[REDACTED_IP]"},
+	"api_key":     {regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_\-]{16,}\b`), "[REDACTED_KEY]"},
+}
+
+// Redactors returns the names of the known redactions
+func Redactors() []string {
+	names := make([]string, 0, len(redactors))
+	for name := range redactors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// redactionOrder applies the specific patterns before the broad ones, so a
+// card number is not taken for a phone number
+var redactionOrder = []string{"email", "api_key", "ssn", "credit_card", "ip_address", "phone"}
+
+// Redact applies the named redactions to text; unknown names, e.g. from a
+// custom module, are ignored
+func Redact(text string, names []string) string {
+	if len(names) == 0 {
+		return text
+	}
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	for _, name := range redactionOrder {
+		if wanted[name] {
+			r := redactors[name]
+			text = r.pattern.ReplaceAllString(text, r.placeholder)
+		}
+	}
+	return text
+}
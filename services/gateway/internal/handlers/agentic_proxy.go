@@ -9,6 +9,9 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/requestid"
 )
 
 func ProxyAgenticRequest(w http.ResponseWriter, r *http.Request) {
@@ -19,7 +22,7 @@ func ProxyAgenticRequest(w http.ResponseWriter, r *http.Request) {
 	// Forward the request to the agentic service
 	req, err := http.NewRequestWithContext(ctx, "POST", "http://agentic-service:8081/v1/agentic", r.Body)
 	if err != nil {
-		http.Error(w, `{"error":"internal server error"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
 
@@ -33,13 +36,16 @@ func ProxyAgenticRequest(w http.ResponseWriter, r *http.Request) {
 	// Make the request to the agentic service
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		http.Error(w, `{"error":"agentic service unavailable"}`, http.StatusServiceUnavailable)
+		apierror.Write(w, http.StatusServiceUnavailable, "agentic service unavailable")
 		return
 	}
 	defer resp.Body.Close()
 
-	// Copy response headers
+	// Copy response headers; X-Request-ID is already set to the same ID
 	for name, values := range resp.Header {
+		if name == http.CanonicalHeaderKey(requestid.Header) {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(name, value)
 		}
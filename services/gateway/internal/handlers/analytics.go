@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"llm-gateway-pro/services/gateway/internal/analytics"
+)
+
+// maxAnalyticsDays bounds the days a client analytics report may cover
+const maxAnalyticsDays = 90
+
+// Tenant returns the tenant of a request for client analytics: the user of
+// its API key, empty for requests without a valid key
+func Tenant(r *http.Request) string {
+	apiKey := r.Header.Get("Authorization")
+	if !strings.HasPrefix(apiKey, "Bearer ") {
+		return ""
+	}
+	userID, err := validateAndTrackLangChainUsage(strings.TrimPrefix(apiKey, "Bearer "))
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// GetClientAnalytics returns the requests per tenant, client and endpoint of
+// the days ending on ?date (today by default) over ?days (1 by default),
+// optionally for one ?tenant and only for deprecated endpoints
+// (?deprecated=true)
+func GetClientAnalytics(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	to := time.Now().UTC()
+	if date := query.Get("date"); date != "" {
+		parsed, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			apierror.New(http.StatusBadRequest, "date must be YYYY-MM-DD").WithParam("date").Write(w)
+			return
+		}
+		to = parsed
+	}
+	days := 1
+	if raw := query.Get("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAnalyticsDays {
+			apierror.New(http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxAnalyticsDays)).WithParam("days").Write(w)
+			return
+		}
+		days = n
+	}
+	deprecatedOnly, _ := strconv.ParseBool(query.Get("deprecated"))
+
+	q := analytics.Query{
+		From:           to.AddDate(0, 0, 1-days),
+		To:             to,
+		Tenant:         query.Get("tenant"),
+		DeprecatedOnly: deprecatedOnly,
+	}
+	entries, err := analytics.Report(r.Context(), q)
+	if err != nil {
+		log.Printf("Failed to load client analytics: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load client analytics")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":    q.From.Format("2006-01-02"),
+		"to":      q.To.Format("2006-01-02"),
+		"clients": entries,
+	})
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MaksimVF/ZB/pkg/annotations"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/residency"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"llm-gateway-pro/services/gateway/internal/billing"
+	"llm-gateway-pro/services/gateway/internal/policy"
+	"llm-gateway-pro/services/gateway/internal/providers"
+)
+
+// maxSpeechInput is the longest text OpenAI synthesizes in one request
+const maxSpeechInput = 4096
+
+// Formats of /v1/audio/transcriptions responses
+var transcriptionFormats = map[string]bool{"json": true, "text": true, "srt": true, "verbose_json": true, "vtt": true}
+
+// cueEnd matches the end time of an SRT or WebVTT cue, "--> 00:01:02,500"
+var cueEnd = regexp.MustCompile(`--> (?:(\d+):)?(\d{2}):(\d{2})[.,](\d{3})`)
+
+var (
+	audioCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_audio_requests_total",
+			Help: "Transcription and speech requests by endpoint, model and status",
+		},
+		[]string{"endpoint", "model", "status"},
+	)
+	audioDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_audio_request_duration_seconds",
+			Help:    "Duration of transcription and speech requests, speech streams included",
+			Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"endpoint", "model"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(audioCounter, audioDuration)
+}
+
+// SpeechRequest is the body of POST /v1/audio/speech
+type SpeechRequest struct {
+	Model string `json:"model"`
+	// Text to synthesize, billed per character
+	Input string `json:"input"`
+	Voice string `json:"voice"`
+	// mp3 (default), opus, aac, flac, wav or pcm
+	ResponseFormat string   `json:"response_format,omitempty"`
+	Speed          *float64 `json:"speed,omitempty"`
+}
+
+// TranscriptionUpload is the multipart form of POST /v1/audio/transcriptions
+type TranscriptionUpload struct {
+	File     []byte `json:"file"`
+	Model    string `json:"model"`
+	Language string `json:"language,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+	// json (default), text, srt, verbose_json or vtt
+	ResponseFormat string   `json:"response_format,omitempty"`
+	Temperature    *float64 `json:"temperature,omitempty"`
+}
+
+// Transcription is the json response of /v1/audio/transcriptions
+type Transcription struct {
+	Text string `json:"text"`
+}
+
+// audioLogger returns the logger of an audio request
+func audioLogger(r *http.Request, handler string) zerolog.Logger {
+	return zerolog.New(os.Stdout).With().
+		Timestamp().
+		Str("service", "gateway").
+		Str("handler", handler).
+		Str("request_id", requestid.FromContext(r.Context())).
+		Logger()
+}
+
+// audioUser authenticates an audio request and returns its user, writing the
+// error otherwise
+func audioUser(w http.ResponseWriter, r *http.Request) (apiKey, userID string, ok bool) {
+	apiKey = r.Header.Get("Authorization")
+	if !strings.HasPrefix(apiKey, "Bearer ") {
+		apierror.New(401, "invalid api key format").WithCode("invalid_api_key").Write(w)
+		return "", "", false
+	}
+	apiKey = strings.TrimPrefix(apiKey, "Bearer ")
+	userID, err := validateAndTrackLangChainUsage(apiKey)
+	if err != nil {
+		apierror.New(401, "invalid api key").WithCode("invalid_api_key").Write(w)
+		return "", "", false
+	}
+	return apiKey, userID, true
+}
+
+// acquireAudioProvider picks an HTTP provider of the audio model that the
+// tenant's policy and data residency allow, like chat completions do, and
+// writes the error if there is none. The returned status labels the metrics.
+func acquireAudioProvider(w http.ResponseWriter, r *http.Request, logger zerolog.Logger, apiKey, userID, model string) (providers.ProviderConfig, func(), string) {
+	modelPolicy := policy.For(userID)
+	if !modelPolicy.AllowsModel(model) {
+		logger.Warn().Str("model", model).Str("user", userID).Msg("Model denied by policy")
+		apierror.New(403, "model not allowed by your organization's policy").WithParam("model").WithCode("model_not_allowed").Write(w)
+		return providers.ProviderConfig{}, nil, "forbidden"
+	}
+
+	regions, err := residency.Lookup(r.Context(), redisClient, "key:"+apiKey, "user:"+userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user", userID).Msg("Failed to load data residency")
+		apierror.Write(w, 503, "data residency policy unavailable")
+		return providers.ProviderConfig{}, nil, "error"
+	}
+	residencyDenied := false
+	providerConfig, release, err := providers.AcquireAllowedProvider(model, func(config providers.ProviderConfig) bool {
+		if config.UseGRPC || !modelPolicy.AllowsProvider(config) {
+			return false
+		}
+		if !residency.Allows(regions, config.Region) {
+			residencyDenied = true
+			return false
+		}
+		return true
+	})
+	switch {
+	case errors.Is(err, providers.ErrNotAllowed) && residencyDenied:
+		residency.Error(regions).WithParam("model").Write(w)
+		return providers.ProviderConfig{}, nil, "forbidden"
+	case errors.Is(err, providers.ErrNotAllowed):
+		apierror.New(403, "no provider of this model is allowed by your organization's policy").WithParam("model").WithCode("provider_not_allowed").Write(w)
+		return providers.ProviderConfig{}, nil, "forbidden"
+	case errors.Is(err, providers.ErrInMaintenance):
+		apierror.New(503, "all providers for this model are in scheduled maintenance").WithCode("provider_maintenance").Write(w)
+		return providers.ProviderConfig{}, nil, "maintenance"
+	case errors.Is(err, providers.ErrAtCapacity):
+		apierror.Write(w, 503, "all providers for this model are busy")
+		return providers.ProviderConfig{}, nil, "busy"
+	case err != nil:
+		apierror.New(400, "unsupported model").WithParam("model").WithCode("model_not_found").Write(w)
+		return providers.ProviderConfig{}, nil, "error"
+	}
+	return withUserAPIKey(providerConfig, userID, logger), release, ""
+}
+
+// writeAudioProviderError passes client errors of the provider on, e.g. an
+// unsupported audio format, and hides everything else behind a 502
+func writeAudioProviderError(w http.ResponseWriter, logger zerolog.Logger, provider string, err error) {
+	var statusErr *providers.StatusError
+	if errors.As(err, &statusErr) && statusErr.Status < 500 && statusErr.Status != 401 && statusErr.Status != 403 && statusErr.Status != 429 {
+		var providerErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(statusErr.Body, &providerErr)
+		message := providerErr.Error.Message
+		if message == "" {
+			message = "the provider rejected the request"
+		}
+		apierror.New(400, message).WithCode("provider_rejected").Write(w)
+		return
+	}
+	logger.Error().Err(err).Str("provider", provider).Msg("Provider audio request failed")
+	apierror.Write(w, 502, "provider unavailable")
+}
+
+// AudioTranscription handles POST /v1/audio/transcriptions: the upload is
+// forwarded to a provider of the model and billed per second of audio. The
+// provider is asked for verbose_json, which carries the duration, unless the
+// client wants subtitles, whose last cue gives it.
+func AudioTranscription(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	logger := audioLogger(r, "audio_transcription")
+
+	apiKey, userID, ok := audioUser(w, r)
+	if !ok {
+		audioCounter.WithLabelValues("transcriptions", "unknown", "unauthorized").Inc()
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		apierror.Write(w, 400, "invalid multipart form")
+		audioCounter.WithLabelValues("transcriptions", "unknown", "error").Inc()
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	model := r.FormValue("model")
+	upload, header, err := r.FormFile("file")
+	if model == "" || err != nil {
+		apierror.Write(w, 400, "model and file are required")
+		audioCounter.WithLabelValues("transcriptions", model, "error").Inc()
+		return
+	}
+	defer upload.Close()
+	format := r.FormValue("response_format")
+	if format == "" {
+		format = "json"
+	}
+	if !transcriptionFormats[format] {
+		apierror.New(400, "response_format must be json, text, srt, verbose_json or vtt").WithParam("response_format").Write(w)
+		audioCounter.WithLabelValues("transcriptions", model, "error").Inc()
+		return
+	}
+
+	providerConfig, release, status := acquireAudioProvider(w, r, logger, apiKey, userID, model)
+	if status != "" {
+		audioCounter.WithLabelValues("transcriptions", model, status).Inc()
+		return
+	}
+	defer release()
+
+	providerFormat := format
+	if format == "json" || format == "text" {
+		providerFormat = "verbose_json"
+	}
+	body, contentType, err := transcriptionBody(r.MultipartForm, upload, header, providerFormat)
+	if err != nil {
+		apierror.Write(w, 400, "failed to read file")
+		audioCounter.WithLabelValues("transcriptions", model, "error").Inc()
+		return
+	}
+
+	resp, err := providers.ProxyAudio(r.Context(), providerConfig, providers.TranscriptionPath, contentType, body)
+	if err != nil {
+		writeAudioProviderError(w, logger, providerConfig.Name, err)
+		audioCounter.WithLabelValues("transcriptions", model, "error").Inc()
+		return
+	}
+	defer resp.Body.Close()
+	result, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error().Err(err).Str("provider", providerConfig.Name).Msg("Failed to read transcription")
+		apierror.Write(w, 502, "provider unavailable")
+		audioCounter.WithLabelValues("transcriptions", model, "error").Inc()
+		return
+	}
+
+	var seconds float64
+	responseType := resp.Header.Get("Content-Type")
+	if providerFormat == "verbose_json" {
+		var verbose struct {
+			Text     string  `json:"text"`
+			Duration float64 `json:"duration"`
+		}
+		if err := json.Unmarshal(result, &verbose); err != nil {
+			logger.Error().Err(err).Str("provider", providerConfig.Name).Msg("Invalid transcription")
+			apierror.Write(w, 502, "invalid provider response")
+			audioCounter.WithLabelValues("transcriptions", model, "error").Inc()
+			return
+		}
+		seconds = verbose.Duration
+		switch format {
+		case "json":
+			result, _ = json.Marshal(Transcription{Text: verbose.Text})
+			responseType = "application/json"
+		case "text":
+			result = []byte(verbose.Text + "\n")
+			responseType = "text/plain; charset=utf-8"
+		}
+	} else {
+		seconds = subtitlesDuration(result)
+	}
+
+	cost := prices.TranscriptionCost(model, seconds)
+	go trackAudioUsage(userID, model, billing.UnitSeconds, seconds, cost)
+
+	annotations.Annotation{Provider: providerConfig.Name, Latency: time.Since(start), Cache: annotations.CacheMiss, CostUSD: cost}.SetHeaders(w.Header())
+	w.Header().Set("X-Audio-Duration-Seconds", strconv.FormatFloat(seconds, 'f', 3, 64))
+	if responseType != "" {
+		w.Header().Set("Content-Type", responseType)
+	}
+	w.Write(result)
+
+	logger.Info().Str("model", model).Str("provider", providerConfig.Name).Float64("seconds", seconds).Msg("Transcription completed")
+	audioCounter.WithLabelValues("transcriptions", model, "success").Inc()
+	httpmetrics.Observe(r.Context(), audioDuration.WithLabelValues("transcriptions", model), time.Since(start).Seconds())
+}
+
+// transcriptionBody rebuilds the multipart upload for the provider with
+// response_format set to format
+func transcriptionBody(form *multipart.Form, upload multipart.File, header *multipart.FileHeader, format string) ([]byte, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, values := range form.Value {
+		if name == "response_format" {
+			continue
+		}
+		for _, value := range values {
+			writer.WriteField(name, value)
+		}
+	}
+	writer.WriteField("response_format", format)
+
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", multipart.FileContentDisposition("file", header.Filename))
+	if contentType := header.Header.Get("Content-Type"); contentType != "" {
+		partHeader.Set("Content-Type", contentType)
+	} else {
+		partHeader.Set("Content-Type", "application/octet-stream")
+	}
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(part, upload); err != nil {
+		return nil, "", err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// subtitlesDuration is the end time of the last cue of SRT or WebVTT subtitles
+func subtitlesDuration(subtitles []byte) float64 {
+	var last float64
+	for _, m := range cueEnd.FindAllSubmatch(subtitles, -1) {
+		hours, _ := strconv.Atoi(string(m[1]))
+		minutes, _ := strconv.Atoi(string(m[2]))
+		secs, _ := strconv.Atoi(string(m[3]))
+		millis, _ := strconv.Atoi(string(m[4]))
+		end := float64(hours*3600+minutes*60+secs) + float64(millis)/1000
+		if end > last {
+			last = end
+		}
+	}
+	return last
+}
+
+// AudioSpeech handles POST /v1/audio/speech: the audio of a provider of the
+// model is streamed to the client as it arrives and billed per character of
+// input
+func AudioSpeech(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	logger := audioLogger(r, "audio_speech")
+
+	apiKey, userID, ok := audioUser(w, r)
+	if !ok {
+		audioCounter.WithLabelValues("speech", "unknown", "unauthorized").Inc()
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var req SpeechRequest
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		apierror.Write(w, 400, "invalid json")
+		audioCounter.WithLabelValues("speech", "unknown", "error").Inc()
+		return
+	}
+	if param, err := validateSpeech(req); err != nil {
+		apierror.New(400, err.Error()).WithParam(param).Write(w)
+		audioCounter.WithLabelValues("speech", req.Model, "error").Inc()
+		return
+	}
+	characters := utf8.RuneCountInString(req.Input)
+
+	providerConfig, release, status := acquireAudioProvider(w, r, logger, apiKey, userID, req.Model)
+	if status != "" {
+		audioCounter.WithLabelValues("speech", req.Model, status).Inc()
+		return
+	}
+	defer release()
+
+	resp, err := providers.ProxyAudio(r.Context(), providerConfig, providers.SpeechPath, "application/json", body)
+	if err != nil {
+		writeAudioProviderError(w, logger, providerConfig.Name, err)
+		audioCounter.WithLabelValues("speech", req.Model, "error").Inc()
+		return
+	}
+	defer resp.Body.Close()
+
+	// The provider has accepted the text and bills it from here on
+	cost := prices.SpeechCost(req.Model, characters)
+	go trackAudioUsage(userID, req.Model, billing.UnitCharacters, float64(characters), cost)
+
+	annotations.Annotation{Provider: providerConfig.Name, Latency: time.Since(start), Cache: annotations.CacheMiss, CostUSD: cost}.SetHeaders(w.Header())
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	status = "success"
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				status = "client_closed"
+				break
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			logger.Error().Err(readErr).Str("provider", providerConfig.Name).Msg("Speech stream interrupted")
+			status = "error"
+			break
+		}
+	}
+
+	logger.Info().Str("model", req.Model).Str("provider", providerConfig.Name).Int("characters", characters).Str("status", status).Msg("Speech completed")
+	audioCounter.WithLabelValues("speech", req.Model, status).Inc()
+	httpmetrics.Observe(r.Context(), audioDuration.WithLabelValues("speech", req.Model), time.Since(start).Seconds())
+}
+
+// validateSpeech checks a speech request and returns the invalid parameter
+func validateSpeech(req SpeechRequest) (string, error) {
+	switch {
+	case req.Model == "":
+		return "model", errors.New("model is required")
+	case req.Input == "":
+		return "input", errors.New("input is required")
+	case req.Voice == "":
+		return "voice", errors.New("voice is required")
+	case utf8.RuneCountInString(req.Input) > maxSpeechInput:
+		return "input", fmt.Errorf("input must be at most %d characters", maxSpeechInput)
+	case req.Speed != nil && (*req.Speed < 0.25 || *req.Speed > 4):
+		return "speed", errors.New("speed must be between 0.25 and 4")
+	}
+	return "", nil
+}
+
+func trackAudioUsage(userID, model, unit string, quantity, cost float64) {
+	if err := billing.TrackAudioUsage(userID, model, unit, quantity, cost); err != nil {
+		logger.Error().Err(err).Str("user", userID).Str("model", model).Str("unit", unit).Float64("quantity", quantity).Msg("Failed to track audio usage")
+	}
+}
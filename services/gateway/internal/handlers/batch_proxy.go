@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+)
+
+// tailServiceURL returns the base URL of the tail service that owns batches
+func tailServiceURL() string {
+	if url := os.Getenv("TAIL_SERVICE_URL"); url != "" {
+		return url
+	}
+	return "https://tail:8443"
+}
+
+// ProxyBatchRequest forwards batch status and results requests to the tail service
+func ProxyBatchRequest(w http.ResponseWriter, r *http.Request) {
+	// Create a timeout context for the request
+	ctx, cancel := context.WithTimeout(r.Context(), 60*time.Second)
+	defer cancel()
+
+	url := tailServiceURL() + r.URL.Path
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, url, nil)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// Copy headers
+	for name, values := range r.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		apierror.Write(w, http.StatusServiceUnavailable, "batch service unavailable")
+		return
+	}
+	defer resp.Body.Close()
+
+	// Copy response headers; X-Request-ID is already set to the same ID
+	for name, values := range resp.Header {
+		if name == http.CanonicalHeaderKey(requestid.Header) {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -19,9 +19,20 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/annotations"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/pricing"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/residency"
+	"github.com/MaksimVF/ZB/pkg/sse"
+	"github.com/MaksimVF/ZB/pkg/usageevents"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"llm-gateway-pro/services/gateway/internal/billing"
+	"llm-gateway-pro/services/gateway/internal/guardrails"
+	"llm-gateway-pro/services/gateway/internal/policy"
+	"llm-gateway-pro/services/gateway/internal/provenance"
 	"llm-gateway-pro/services/gateway/internal/providers"
 	"llm-gateway-pro/services/gateway/internal/resilience"
 )
@@ -51,6 +62,16 @@ type LangChainRequest struct {
 	Stream         bool                     `json:"stream,omitempty"`
 	MaxTokens      *int                     `json:"max_tokens,omitempty"`
 	Temperature    *float64                 `json:"temperature,omitempty"`
+	// Sampling parameters are passed to the provider as sent
+	TopP             *float64      `json:"top_p,omitempty"`
+	FrequencyPenalty *float64      `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64      `json:"presence_penalty,omitempty"`
+	Stop             StopSequences `json:"stop,omitempty"`
+	Seed             *int64        `json:"seed,omitempty"`
+	Logprobs         *bool         `json:"logprobs,omitempty"`
+	TopLogprobs      *int          `json:"top_logprobs,omitempty"`
+	// N choices, billed for their aggregate usage
+	N *int `json:"n,omitempty"`
 	ResponseFormat *struct {
 		Type string `json:"type"`
 	} `json:"response_format,omitempty"`
@@ -79,6 +100,9 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
+// prices attributes a cost to each response
+var prices = pricing.NewTable(redisClient)
+
 func init() {
 	prometheus.MustRegister(langchainCounter, langchainDuration)
 }
@@ -89,6 +113,7 @@ func LangChainCompletion(w http.ResponseWriter, r *http.Request) {
 		Timestamp().
 		Str("service", "gateway").
 		Str("handler", "langchain").
+		Str("request_id", requestid.FromContext(r.Context())).
 		Logger()
 
 	logger.Info().Msg("Received LangChain request")
@@ -97,7 +122,7 @@ func LangChainCompletion(w http.ResponseWriter, r *http.Request) {
 	apiKey := r.Header.Get("Authorization")
 	if !strings.HasPrefix(apiKey, "Bearer ") {
 		logger.Warn().Msg("Missing or invalid API key format")
-		http.Error(w, `{"error":"invalid api key format"}`, 401)
+		apierror.New(401, "invalid api key format").WithCode("invalid_api_key").Write(w)
 		langchainCounter.WithLabelValues("unknown", "unauthorized").Inc()
 		return
 	}
@@ -106,7 +131,7 @@ func LangChainCompletion(w http.ResponseWriter, r *http.Request) {
 	userID, err := validateAndTrackLangChainUsage(apiKey)
 	if err != nil {
 		logger.Warn().Err(err).Msg("Invalid API key")
-		http.Error(w, `{"error":"invalid api key"}`, 401)
+		apierror.New(401, "invalid api key").WithCode("invalid_api_key").Write(w)
 		langchainCounter.WithLabelValues("unknown", "unauthorized").Inc()
 		return
 	}
@@ -114,7 +139,7 @@ func LangChainCompletion(w http.ResponseWriter, r *http.Request) {
 	var req LangChainRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		logger.Error().Err(err).Msg("Invalid JSON input")
-		http.Error(w, `{"error":"invalid json"}`, 400)
+		apierror.Write(w, 400, "invalid json")
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
@@ -122,64 +147,242 @@ func LangChainCompletion(w http.ResponseWriter, r *http.Request) {
 	// Validate required fields
 	if req.Model == "" || len(req.Messages) == 0 {
 		logger.Warn().Msg("Missing required fields")
-		http.Error(w, `{"error":"model and messages are required"}`, 400)
+		apierror.Write(w, 400, "model and messages are required")
+		langchainCounter.WithLabelValues(req.Model, "error").Inc()
+		return
+	}
+	if param, err := validateSampling(req); err != nil {
+		logger.Warn().Err(err).Msg("Invalid sampling parameters")
+		apierror.New(400, err.Error()).WithParam(param).Write(w)
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
 
-	// Get provider for model using LiteLLM
-	providerConfig, err := providers.GetProviderForModel(req.Model)
+	// The tenant's policy limits the models and providers it may use; for a
+	// model class it limits the models the class may be served by
+	modelPolicy := policy.For(userID)
+	modelClass := providers.IsModelClass(req.Model)
+	if !modelClass && !modelPolicy.AllowsModel(req.Model) {
+		logger.Warn().Str("model", req.Model).Str("user", userID).Msg("Model denied by policy")
+		apierror.New(403, "model not allowed by your organization's policy").WithParam("model").WithCode("model_not_allowed").Write(w)
+		langchainCounter.WithLabelValues(req.Model, "forbidden").Inc()
+		return
+	}
+
+	// The tenant's data residency limits the regions the request may be processed in
+	regions, err := residency.Lookup(r.Context(), redisClient, "key:"+apiKey, "user:"+userID)
+	if err != nil {
+		// Without the residency we cannot tell where the request may go
+		logger.Error().Err(err).Str("user", userID).Msg("Failed to load data residency")
+		apierror.Write(w, 503, "data residency policy unavailable")
+		langchainCounter.WithLabelValues(req.Model, "error").Inc()
+		return
+	}
+	residencyDenied := false
+	allowProvider := func(config providers.ProviderConfig) bool {
+		if !modelPolicy.AllowsProvider(config) {
+			return false
+		}
+		if !residency.Allows(regions, config.Region) {
+			residencyDenied = true
+			return false
+		}
+		return true
+	}
+
+	// Pick an allowed provider for the model and hold a slot on it until the
+	// request is done. A model class is served by the cheapest allowed model
+	// of the class or a more capable one (cheapest_capable).
+	var providerConfig providers.ProviderConfig
+	var release func()
+	var selection providers.Selection
+	if modelClass {
+		selection, err = providers.AcquireCheapestCapable(providers.CapableRequest{
+			Class:         req.Model,
+			AllowModel:    modelPolicy.AllowsModel,
+			AllowProvider: allowProvider,
+			Cost:          estimatedCost(req),
+		})
+		providerConfig, release = selection.Provider, selection.Release
+	} else {
+		providerConfig, release, err = providers.AcquireAllowedProvider(req.Model, allowProvider)
+	}
+	if errors.Is(err, providers.ErrNotAllowed) && residencyDenied {
+		logger.Warn().Str("model", req.Model).Str("user", userID).Strs("regions", regions).Msg("All providers outside data residency")
+		residency.Error(regions).WithParam("model").Write(w)
+		langchainCounter.WithLabelValues(req.Model, "forbidden").Inc()
+		return
+	}
+	if errors.Is(err, providers.ErrNotAllowed) {
+		logger.Warn().Str("model", req.Model).Str("user", userID).Msg("All providers denied by policy")
+		apierror.New(403, "no provider of this model is allowed by your organization's policy").WithParam("model").WithCode("provider_not_allowed").Write(w)
+		langchainCounter.WithLabelValues(req.Model, "forbidden").Inc()
+		return
+	}
+	if errors.Is(err, providers.ErrInMaintenance) {
+		logger.Warn().Str("model", req.Model).Msg("All providers in maintenance")
+		apierror.New(503, "all providers for this model are in scheduled maintenance").WithCode("provider_maintenance").Write(w)
+		langchainCounter.WithLabelValues(req.Model, "maintenance").Inc()
+		return
+	}
+	if errors.Is(err, providers.ErrAtCapacity) {
+		logger.Warn().Str("model", req.Model).Msg("All providers at capacity")
+		apierror.Write(w, 503, "all providers for this model are busy")
+		langchainCounter.WithLabelValues(req.Model, "busy").Inc()
+		return
+	}
 	if err != nil {
 		logger.Warn().Str("model", req.Model).Msg("Unsupported model")
-		http.Error(w, `{"error":"unsupported model"}`, 400)
+		apierror.New(400, "unsupported model").WithParam("model").WithCode("model_not_found").Write(w)
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
+	defer release()
+	if modelClass {
+		logger.Info().Str("class", req.Model).Str("model", selection.Model).Str("provider", providerConfig.Name).Msg("Model class resolved")
+		req.Model = selection.Model
+	}
+
+	// The tenant's guardrails see the prompt, the tools and the estimated cost
+	// before the model is called, and may redact the prompt
+	preCheck := guardrails.Evaluate(r.Context(), guardrails.Input{
+		Tenant:  userID,
+		Phase:   guardrails.PhasePre,
+		Model:   req.Model,
+		Tools:   guardrailTools(req),
+		Text:    promptText(req),
+		CostUSD: estimatedCost(req)(req.Model),
+	})
+	if preCheck != nil && !preCheck.Allow {
+		guardrailDenied(w, nil, preCheck)
+		langchainCounter.WithLabelValues(req.Model, "forbidden").Inc()
+		return
+	}
+	if preCheck != nil {
+		redactPrompt(&req, preCheck.Redact)
+	}
 
-	// Check for user-specific API key
+	providerConfig = withUserAPIKey(providerConfig, userID, logger)
 	providerName := getProviderName(providerConfig.BaseURL)
-	userApiKey := getUserSecretFromService(userID, fmt.Sprintf("llm/%s/api_key", providerName))
-	if userApiKey != "" {
-		logger.Info().Str("user_id", userID).Str("provider", providerName).Msg("Using user-specific API key")
-		// Override the provider's API key with user-specific key
-		providerConfig.APIKey = userApiKey
-	} else {
-		logger.Info().Str("user_id", userID).Str("provider", providerName).Msg("Using shared API key")
+
+	// Tenants may have completions marked with their generation metadata
+	marking := provenance.For(userID)
+
+	// A streamed completion is written once the provider answered in full.
+	// While it waits, heartbeats keep proxies from cutting the stream, and a
+	// client found gone cancels the provider call.
+	callCtx := r.Context()
+	var stream *sse.Stream
+	if req.Stream {
+		if _, ok := w.(http.Flusher); !ok {
+			logger.Error().Msg("Streaming not supported")
+			apierror.Write(w, 500, "streaming not supported")
+			langchainCounter.WithLabelValues(req.Model, "error").Inc()
+			return
+		}
+		var cancelCall context.CancelFunc
+		callCtx, cancelCall = context.WithCancel(callCtx)
+		defer cancelCall()
+		setStreamHeaders(w.Header())
+		marking.DeclareTrailers(w.Header())
+		stream = sse.Start(callCtx, w, sse.Interval(), cancelCall)
+		defer stream.Close()
+	}
+
+	// Execute with circuit breaker and retry logic. The provider is asked for
+	// the whole completion: a stream is written from it once it passed the
+	// guardrails.
+	upstream := req
+	upstream.Stream = false
+	primary := func(ctx context.Context) (interface{}, error) {
+		return resilience.ExecuteWithCircuitBreaker(providerName, func() (interface{}, error) {
+			return executeWithRetry(ctx, providerConfig, upstream, 3, 1*time.Second)
+		})
 	}
+	// Non-streaming completions are idempotent: when the provider is slow,
+	// hedge to another provider of the model
+	var hedge resilience.Attempt
+	if !req.Stream && featureFlags.Enabled("hedging", userID) {
+		hedge = func(ctx context.Context) (interface{}, error) {
+			alternative, releaseAlternative, err := providers.AcquireAlternativeProvider(req.Model, providerConfig.Name, allowProvider)
+			if err != nil {
+				return nil, err
+			}
+			defer releaseAlternative()
 
-	// Execute with circuit breaker and retry logic
-	result, err := resilience.ExecuteWithCircuitBreaker(providerName, func() (interface{}, error) {
-		return executeWithRetry(providerConfig, req, 3, 1*time.Second)
-	})
+			alternative = withUserAPIKey(alternative, userID, logger)
+			return resilience.ExecuteWithCircuitBreaker(getProviderName(alternative.BaseURL), func() (interface{}, error) {
+				body, cacheHit, err := proxyCompletion(ctx, alternative, req)
+				return providerResult{body: body, provider: alternative.Name, cacheHit: cacheHit}, err
+			})
+		}
+	}
+	result, err := resilience.Hedge(callCtx, req.Model, primary, hedge)
 
 	if err != nil {
 		logger.Error().Err(err).Str("provider", providerConfig.BaseURL).Msg("Provider request failed")
-		http.Error(w, `{"error":"provider unavailable"}`, 502)
+		writeStreamError(w, stream, apierror.New(502, "provider unavailable"))
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
 
-	respBody, ok := result.([]byte)
+	res, ok := result.(providerResult)
 	if !ok {
 		logger.Error().Msg("Invalid response type from provider")
-		http.Error(w, `{"error":"internal error"}`, 500)
+		writeStreamError(w, stream, apierror.New(500, "internal error"))
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
+	respBody := res.body
 
-	// Handle streaming response
-	if req.Stream {
-		handleStreamingResponse(w, respBody, logger)
-		langchainCounter.WithLabelValues(req.Model, "success").Inc()
-		langchainDuration.WithLabelValues(req.Model).Observe(time.Since(start).Seconds())
-		return
+	// Cost and latency annotations; cached responses cost nothing
+	annotation := annotations.Annotation{Provider: res.provider, Latency: time.Since(start), Cache: annotations.CacheMiss}
+	var reported struct {
+		Usage Usage `json:"usage"`
+	}
+	json.Unmarshal(respBody, &reported)
+	if res.cacheHit {
+		annotation.Cache = annotations.CacheHit
+	} else {
+		annotation.CostUSD = prices.ChatCost(req.Model, reported.Usage.PromptTokens, reported.Usage.CompletionTokens)
+		if modelClass && reported.Usage.TotalTokens > 0 {
+			reference := prices.ChatCost(selection.Reference, reported.Usage.PromptTokens, reported.Usage.CompletionTokens)
+			selection.ObserveSavings(reference - annotation.CostUSD)
+		}
+	}
+	annotation.SetHeaders(w.Header())
+
+	// Usage event for the data warehouse; the breaker is known for the
+	// primary provider only, not for a hedge that won
+	usageEvent := usageevents.Event{
+		UserID:           userID,
+		Model:            req.Model,
+		Provider:         res.provider,
+		Status:           usageevents.StatusSuccess,
+		Stream:           req.Stream,
+		PromptTokens:     reported.Usage.PromptTokens,
+		CompletionTokens: reported.Usage.CompletionTokens,
+		TotalTokens:      reported.Usage.TotalTokens,
+		CostUSD:          annotation.CostUSD,
+		LatencyMs:        annotation.Latency.Milliseconds(),
+		Cache:            annotation.Cache,
+	}
+	breaker := ""
+	if res.provider == providerConfig.Name {
+		breaker = providerName
+	}
+
+	generation := provenance.Metadata{
+		Model:         req.Model,
+		Provider:      res.provider,
+		PolicyVersion: policyVersion(userID),
 	}
 
 	// Process and normalize response
 	var providerResp map[string]interface{}
 	if err := json.Unmarshal(respBody, &providerResp); err != nil {
 		logger.Error().Err(err).Msg("Failed to parse provider response")
-		http.Error(w, `{"error":"internal error"}`, 500)
+		writeStreamError(w, stream, apierror.New(500, "internal error"))
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
@@ -187,38 +390,106 @@ func LangChainCompletion(w http.ResponseWriter, r *http.Request) {
 	finalResp, err := normalizeProviderResponse(providerResp, req.Model)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to normalize provider response")
-		http.Error(w, `{"error":"internal error"}`, 500)
+		writeStreamError(w, stream, apierror.New(500, "internal error"))
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
 
+	// The completion is checked against the same guardrails with its actual
+	// cost, streamed or not: a stream is only written once the provider
+	// answered in full
+	postCheck := guardrails.Evaluate(r.Context(), guardrails.Input{
+		Tenant:  userID,
+		Phase:   guardrails.PhasePost,
+		Model:   req.Model,
+		Text:    completionText(finalResp),
+		CostUSD: annotation.CostUSD,
+	})
+	if postCheck != nil && !postCheck.Allow {
+		// The provider was called: the usage is billed all the same
+		go trackLangChainUsage(userID, req.Model, finalResp.Usage.TotalTokens)
+		guardrailDenied(w, stream, postCheck)
+		langchainCounter.WithLabelValues(req.Model, "forbidden").Inc()
+		return
+	}
+	if postCheck != nil {
+		redactCompletion(&finalResp, postCheck.Redact)
+	}
+
+	generation.GenerationID = finalResp.ID
+	generation.CreatedAt = finalResp.Created
+
+	// Handle streaming response; a redacted completion replaces the
+	// provider's. The provenance headers were declared as trailers.
+	if req.Stream {
+		if postCheck != nil && len(postCheck.Redact) > 0 {
+			respBody, _ = json.Marshal(finalResp)
+		}
+		marking.SetHeaders(w.Header(), generation)
+		handleStreamingResponse(stream, respBody)
+		emitUsage(r, usageEvent, breaker)
+		langchainCounter.WithLabelValues(req.Model, "success").Inc()
+		httpmetrics.Observe(r.Context(), langchainDuration.WithLabelValues(req.Model), time.Since(start).Seconds())
+		return
+	}
+	watermarkCompletion(&finalResp, marking, generation)
+	marking.SetHeaders(w.Header(), generation)
+
 	// Track usage for billing
 	go trackLangChainUsage(userID, req.Model, finalResp.Usage.TotalTokens)
+	emitUsage(r, usageEvent, breaker)
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(finalResp); err != nil {
+	encoded, err := json.Marshal(finalResp)
+	if err != nil {
 		logger.Error().Err(err).Msg("Failed to encode response")
-		http.Error(w, `{"error":"internal error"}`, 500)
+		apierror.Write(w, 500, "internal error")
 		langchainCounter.WithLabelValues(req.Model, "error").Inc()
 		return
 	}
+	if annotations.WantsBody(r) {
+		encoded = annotations.AddToBody(encoded, annotation)
+	}
+	encoded = marking.AddToBody(encoded, generation)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
 
 	logger.Info().Str("model", req.Model).Msg("LangChain request completed successfully")
 	langchainCounter.WithLabelValues(req.Model, "success").Inc()
-	langchainDuration.WithLabelValues(req.Model).Observe(time.Since(start).Seconds())
+	httpmetrics.Observe(r.Context(), langchainDuration.WithLabelValues(req.Model), time.Since(start).Seconds())
 }
 
-func executeWithRetry(providerConfig providers.ProviderConfig, req LangChainRequest, maxRetries int, backoff time.Duration) ([]byte, error) {
+// providerResult is a provider response and where it came from
+type providerResult struct {
+	body     []byte
+	provider string
+	cacheHit bool
+}
+
+func executeWithRetry(ctx context.Context, providerConfig providers.ProviderConfig, req LangChainRequest, maxRetries int, backoff time.Duration) (providerResult, error) {
 	var err error
-	var respBody []byte
+	result := providerResult{provider: providerConfig.Name}
 
 	operation := func() error {
-		respBody, err = providers.ProxyRequest(providerConfig, "POST", "/v1/chat/completions", req)
+		result.body, result.cacheHit, err = proxyCompletion(ctx, providerConfig, req)
 		return err
 	}
 
-	err = resilience.WithRetry(operation, maxRetries, backoff)
-	return respBody, err
+	err = resilience.WithBudgetedRetry(operation, maxRetries, backoff)
+	return result, err
+}
+
+// withUserAPIKey uses the user's own key for the provider when they have one
+func withUserAPIKey(providerConfig providers.ProviderConfig, userID string, logger zerolog.Logger) providers.ProviderConfig {
+	providerName := getProviderName(providerConfig.BaseURL)
+	userApiKey := getUserSecretFromService(userID, fmt.Sprintf("llm/%s/api_key", providerName))
+	if userApiKey != "" {
+		logger.Info().Str("user_id", userID).Str("provider", providerName).Msg("Using user-specific API key")
+		// Override the provider's API key with user-specific key
+		providerConfig.APIKey = userApiKey
+	} else {
+		logger.Info().Str("user_id", userID).Str("provider", providerName).Msg("Using shared API key")
+	}
+	return providerConfig
 }
 
 func getProviderName(baseURL string) string {
@@ -236,24 +507,38 @@ func getProviderName(baseURL string) string {
 	}
 }
 
-func handleStreamingResponse(w http.ResponseWriter, body []byte, logger zerolog.Logger) {
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
+// setStreamHeaders prepares an event stream. Cost and latency are also
+// declared as trailers: once a heartbeat sent the headers, they can only
+// follow the stream.
+func setStreamHeaders(h http.Header) {
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	annotations.DeclareTrailers(h)
+}
 
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		logger.Error().Msg("Streaming not supported")
-		http.Error(w, `{"error":"streaming not supported"}`, 500)
-		return
-	}
+func handleStreamingResponse(stream *sse.Stream, body []byte) {
+	stream.Close()
 
 	// For streaming, we need to process the response differently
 	// This is a simplified version - in production, you'd want to
 	// stream the response from the provider directly
-	io.WriteString(w, "data: "+string(body)+"\n\n")
-	io.WriteString(w, "data: [DONE]\n\n")
-	flusher.Flush()
+	io.WriteString(stream, "data: "+string(body)+"\n\n")
+	io.WriteString(stream, "data: [DONE]\n\n")
+}
+
+// writeStreamError writes e as the response or, once heartbeats sent the
+// headers of a stream, as its last event
+func writeStreamError(w http.ResponseWriter, stream *sse.Stream, e *apierror.Error) {
+	if stream != nil {
+		stream.Close()
+		if stream.Written() {
+			event, _ := json.Marshal(e)
+			io.WriteString(stream, "data: "+string(event)+"\n\n")
+			return
+		}
+	}
+	e.Write(w)
 }
 
 func normalizeProviderResponse(providerResp map[string]interface{}, model string) (LangChainResponse, error) {
@@ -288,8 +573,13 @@ func normalizeProviderResponse(providerResp map[string]interface{}, model string
 			choiceMap["finish_reason"] = "stop"
 		}
 
+		index := i
+		if reported, ok := choiceMap["index"].(float64); ok {
+			index = int(reported)
+		}
+
 		choices = append(choices, Choice{
-			Index:        i,
+			Index:        index,
 			Message:      choiceMap["message"].(map[string]interface{}),
 			FinishReason: choiceMap["finish_reason"].(string),
 		})
@@ -324,14 +614,16 @@ func trackLangChainUsage(userID, model string, tokens int) {
 }
 
 func ListProviders(w http.ResponseWriter, r *http.Request) {
-	providers := providers.GetAllProviders()
+	allProviders := providers.GetAllProviders()
 
 	// Format response with health status and additional info
-	providerList := make([]map[string]interface{}, 0, len(providers))
+	providerList := make([]map[string]interface{}, 0, len(allProviders))
 	healthyCount := 0
 	unhealthyCount := 0
+	maintenanceCount := 0
+	now := time.Now()
 
-	for providerName, config := range providers {
+	for providerName, config := range allProviders {
 		providerInfo := map[string]interface{}{
 			"name":           providerName,
 			"base_url":       config.BaseURL,
@@ -341,16 +633,28 @@ func ListProviders(w http.ResponseWriter, r *http.Request) {
 			"weight":          config.Weight,
 			"max_concurrency": config.MaxConcurrency,
 			"uses_grpc":       config.UseGRPC,
+			"region":          config.Region,
+			"self_hosted":     config.SelfHosted,
+			"status":          config.Status(now),
 			"failover_status": "available", // Default status
 		}
+		if len(config.MaintenanceWindows) > 0 {
+			providerInfo["maintenance_windows"] = config.MaintenanceWindows
+		}
+		if config.DiscoverModels {
+			providerInfo["discovered_models"] = config.DiscoveredModels
+		}
 
 		// Add gRPC address if using gRPC
 		if config.UseGRPC {
 			providerInfo["grpc_address"] = config.GRPCAddress
 		}
 
-		// Update failover status based on health
-		if !config.IsHealthy {
+		// Update failover status based on maintenance and health
+		if config.Status(now) == providers.StatusMaintenance {
+			providerInfo["failover_status"] = "maintenance"
+			maintenanceCount++
+		} else if !config.IsHealthy {
 			providerInfo["failover_status"] = "unavailable"
 			unhealthyCount++
 		} else {
@@ -383,8 +687,9 @@ func ListProviders(w http.ResponseWriter, r *http.Request) {
 		"providers": providerList,
 		"total":     len(providerList),
 		"healthy":   healthyCount,
-		"unhealthy": unhealthyCount,
-		"failover":  len(providerList) - healthyCount, // Providers that are either unhealthy or in failover state
+		"unhealthy":   unhealthyCount,
+		"maintenance": maintenanceCount,
+		"failover":    len(providerList) - healthyCount - maintenanceCount, // Providers that are either unhealthy or in failover state
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -394,7 +699,7 @@ func ListProviders(w http.ResponseWriter, r *http.Request) {
 func AddProvider(w http.ResponseWriter, r *http.Request) {
 	var config providers.ProviderConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		http.Error(w, `{"error":"invalid input"}`, 400)
+		apierror.Write(w, 400, "invalid input")
 		return
 	}
 
@@ -406,9 +711,10 @@ func AddProvider(w http.ResponseWriter, r *http.Request) {
 		config.MaxConcurrency = 10 // Default max concurrency
 	}
 
-	// Validate required fields
-	if config.BaseURL == "" || len(config.ModelNames) == 0 {
-		http.Error(w, `{"error":"base_url and model_names are required"}`, 400)
+	// Validate required fields; self-hosted endpoints may discover their
+	// models instead of listing them
+	if config.BaseURL == "" || (len(config.ModelNames) == 0 && !config.DiscoverModels) {
+		apierror.Write(w, 400, "base_url and model_names or discover_models are required")
 		return
 	}
 
@@ -416,6 +722,10 @@ func AddProvider(w http.ResponseWriter, r *http.Request) {
 	if config.HealthCheckURL == "" {
 		// Set default health check URL based on provider
 		switch {
+		case config.HealthCheckPath != "":
+			config.HealthCheckURL = config.BaseURL + config.HealthCheckPath
+		case config.DiscoverModels:
+			config.HealthCheckURL = config.BaseURL + "/v1/models"
 		case strings.Contains(config.BaseURL, "openai"):
 			config.HealthCheckURL = config.BaseURL + "/v1/models"
 		case strings.Contains(config.BaseURL, "anthropic"):
@@ -429,10 +739,32 @@ func AddProvider(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	providers.AddProvider(config.BaseURL, config)
-	w.WriteHeader(http.StatusCreated)
+	// Providers added without a name are keyed by their base URL
+	name := config.Name
+	if name == "" {
+		name = config.BaseURL
+	}
+
+	if err := providers.AddProvider(name, config); err != nil {
+		if errors.Is(err, providers.ErrRawAPIKey) {
+			apierror.Write(w, 400, "api_key is not persisted; store it in secret-service and pass api_key_secret")
+			return
+		}
+		if errors.Is(err, providers.ErrRawAuthHeader) {
+			apierror.New(400, err.Error()).WithParam("auth_headers").Write(w)
+			return
+		}
+		if errors.Is(err, providers.ErrModelDiscovery) {
+			apierror.New(400, err.Error()).WithCode("model_discovery_failed").WithParam("discover_models").Write(w)
+			return
+		}
+		log.Printf("Failed to add provider %s: %v", name, err)
+		apierror.Write(w, 500, "failed to persist provider")
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "provider added", "provider": config.BaseURL})
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ProviderChange{Status: "provider added", Provider: name})
 }
 
 func RemoveProvider(w http.ResponseWriter, r *http.Request) {
@@ -450,14 +782,18 @@ func RemoveProvider(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !found {
-		http.Error(w, `{"error":"provider not found"}`, 404)
+		apierror.Write(w, 404, "provider not found")
 		return
 	}
 
-	providers.RemoveProvider(provider)
-	w.WriteHeader(http.StatusOK)
+	if err := providers.RemoveProvider(provider); err != nil {
+		log.Printf("Failed to remove provider %s: %v", provider, err)
+		apierror.Write(w, 500, "failed to persist provider removal")
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "provider removed", "provider": provider})
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ProviderChange{Status: "provider removed", Provider: provider})
 }
 
 func ListCircuitBreakers(w http.ResponseWriter, r *http.Request) {
@@ -473,7 +809,7 @@ func GetCircuitBreakerStatus(w http.ResponseWriter, r *http.Request) {
 
 	state, counts, err := resilience.GetCircuitBreakerStatus(name)
 	if err != nil {
-		http.Error(w, `{"error":"circuit breaker not found"}`, 404)
+		apierror.Write(w, 404, "circuit breaker not found")
 		return
 	}
 
@@ -487,13 +823,18 @@ func GetCircuitBreakerStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(status)
 }
 
+func GetHedgingStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resilience.GetHedgingStats())
+}
+
 func ResetCircuitBreaker(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
 
 	err := resilience.ResetCircuitBreaker(name)
 	if err != nil {
-		http.Error(w, `{"error":"circuit breaker not found"}`, 404)
+		apierror.Write(w, 404, "circuit breaker not found")
 		return
 	}
 
@@ -502,3 +843,40 @@ func ResetCircuitBreaker(w http.ResponseWriter, r *http.Request) {
 }
 
 
+
+func GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(providers.GetCacheStats())
+}
+
+func ClearCache(w http.ResponseWriter, r *http.Request) {
+	providers.ClearCache()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"cleared"}`))
+}
+
+func SetProviderCache(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		apierror.Write(w, 400, "enabled is required")
+		return
+	}
+
+	if err := providers.SetProviderCache(provider, *req.Enabled); err != nil {
+		if errors.Is(err, providers.ErrUnknownProvider) {
+			apierror.Write(w, 404, "provider not found")
+			return
+		}
+		log.Printf("Failed to change caching for provider %s: %v", provider, err)
+		apierror.Write(w, 500, "failed to persist provider")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"provider": provider, "cache_enabled": *req.Enabled})
+}
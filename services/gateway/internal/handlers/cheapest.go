@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+)
+
+// completionEstimate is the completion length assumed when ranking models for
+// a request without max_tokens
+const completionEstimate = 256
+
+// estimatedCost returns what a request would cost on a model, by which
+// cheapest_capable ranks the models of a class
+func estimatedCost(req LangChainRequest) func(model string) float64 {
+	messages := make([]tokenizer.Message, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		role, _ := m["role"].(string)
+		content, _ := m["content"].(string)
+		messages = append(messages, tokenizer.Message{Role: role, Content: content})
+	}
+	completion := completionEstimate
+	if req.MaxTokens != nil && *req.MaxTokens > 0 {
+		completion = *req.MaxTokens
+	}
+	if req.N != nil && *req.N > 1 {
+		completion *= *req.N
+	}
+	return func(model string) float64 {
+		return prices.ChatCost(model, tokenizer.CountMessages(model, messages), completion)
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"llm-gateway-pro/services/gateway/internal/providers"
+)
+
+// maxChoices limits n, which fans out to that many provider calls where the
+// provider cannot generate several choices at once
+const maxChoices = 16
+
+// nativeChoices are the providers that return n choices from one call
+var nativeChoices = map[string]bool{
+	"openai": true,
+}
+
+// proxyCompletion sends a chat completion to the provider. Providers without
+// native n get one request per choice, merged into a single response.
+func proxyCompletion(ctx context.Context, providerConfig providers.ProviderConfig, req LangChainRequest) (body []byte, cacheHit bool, err error) {
+	defer func() {
+		if err == nil && !cacheHit {
+			observeProviderPayload(providerConfig.Name, req, body)
+		}
+	}()
+
+	if req.N == nil || *req.N <= 1 || nativeChoices[getProviderName(providerConfig.BaseURL)] {
+		return providers.ProxyRequestCached(ctx, providerConfig, "POST", "/v1/chat/completions", req)
+	}
+
+	n := *req.N
+	single := req
+	single.N = nil
+	bodies := make([][]byte, n)
+	errs := make([]error, n)
+	hits := make([]bool, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i], hits[i], errs[i] = providers.ProxyRequestCached(ctx, providerConfig, "POST", "/v1/chat/completions", single)
+		}(i)
+	}
+	wg.Wait()
+
+	cacheHit = true
+	for i := range bodies {
+		if errs[i] != nil {
+			return nil, false, errs[i]
+		}
+		cacheHit = cacheHit && hits[i]
+	}
+	body, err = mergeChoices(bodies)
+	return body, cacheHit, err
+}
+
+// mergeChoices joins single-choice completions into one with n choices,
+// indexed in order, and their aggregate usage
+func mergeChoices(bodies [][]byte) ([]byte, error) {
+	var merged map[string]interface{}
+	choices := make([]interface{}, 0, len(bodies))
+	var usage Usage
+	for i, body := range bodies {
+		var resp map[string]interface{}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, fmt.Errorf("failed to parse choice %d: %w", i, err)
+		}
+		if merged == nil {
+			merged = resp
+		}
+
+		list, _ := resp["choices"].([]interface{})
+		for _, c := range list {
+			if choice, ok := c.(map[string]interface{}); ok {
+				choice["index"] = len(choices)
+				choices = append(choices, choice)
+			}
+		}
+
+		var reported struct {
+			Usage Usage `json:"usage"`
+		}
+		json.Unmarshal(body, &reported)
+		usage.PromptTokens += reported.Usage.PromptTokens
+		usage.CompletionTokens += reported.Usage.CompletionTokens
+		usage.TotalTokens += reported.Usage.TotalTokens
+	}
+
+	merged["choices"] = choices
+	merged["usage"] = usage
+	return json.Marshal(merged)
+}
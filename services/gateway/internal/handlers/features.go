@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"github.com/MaksimVF/ZB/pkg/faultinject"
+	"github.com/MaksimVF/ZB/pkg/featureflags"
+)
+
+// DefaultFeatureFlags are the gateway's flags with their built-in values,
+// seeded into the store on first start
+var DefaultFeatureFlags = []featureflags.Flag{
+	{Name: "hedging", Description: "Hedge slow non-streaming completions to another provider", Enabled: true},
+	{Name: faultinject.FlagName, Description: "Inject the faults of FAULT_INJECTION_RULES where FAULT_INJECTION_ENABLED is set"},
+}
+
+// featureFlags are evaluated per user; the defaults apply until
+// InitFeatureFlags
+var featureFlags = featureflags.New("gateway", nil, DefaultFeatureFlags...)
+
+// InitFeatureFlags evaluates flags from the store, reloaded on change
+func InitFeatureFlags(flags *featureflags.Flags) {
+	featureFlags = flags
+}
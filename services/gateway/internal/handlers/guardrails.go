@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/sse"
+	"github.com/gorilla/mux"
+	"llm-gateway-pro/services/gateway/internal/guardrails"
+)
+
+// ListGuardrails returns the guardrail bundles of all tenants
+func ListGuardrails(w http.ResponseWriter, r *http.Request) {
+	bundles, err := guardrails.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to load guardrails: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load guardrails")
+		return
+	}
+	writePolicyJSON(w, map[string]interface{}{"guardrails": bundles, "redactions": guardrails.Redactors()})
+}
+
+// GetGuardrails returns the guardrail bundle of a tenant
+func GetGuardrails(w http.ResponseWriter, r *http.Request) {
+	b, err := guardrails.Get(r.Context(), mux.Vars(r)["tenant"])
+	if errors.Is(err, guardrails.ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, "guardrails not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load guardrails: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load guardrails")
+		return
+	}
+	writePolicyJSON(w, b)
+}
+
+// PutGuardrails creates or replaces the guardrail bundle of a tenant; tenant
+// "*" is the default for tenants without one
+func PutGuardrails(w http.ResponseWriter, r *http.Request) {
+	var b guardrails.Bundle
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&b); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	saved, err := guardrails.Save(r.Context(), mux.Vars(r)["tenant"], b)
+	if errors.Is(err, guardrails.ErrInvalid) {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save guardrails: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to save guardrails")
+		return
+	}
+	writePolicyJSON(w, saved)
+}
+
+// DeleteGuardrails removes the guardrail bundle of a tenant
+func DeleteGuardrails(w http.ResponseWriter, r *http.Request) {
+	err := guardrails.Delete(r.Context(), mux.Vars(r)["tenant"])
+	if errors.Is(err, guardrails.ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, "guardrails not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete guardrails: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to delete guardrails")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetGuardrailDecisions returns the latest guardrail decisions of a tenant,
+// newest first; limit defaults to 100
+func GetGuardrailDecisions(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1000 {
+			apierror.New(http.StatusBadRequest, "limit must be between 1 and 1000").WithParam("limit").Write(w)
+			return
+		}
+		limit = n
+	}
+	decisions, err := guardrails.Decisions(r.Context(), mux.Vars(r)["tenant"], limit)
+	if err != nil {
+		log.Printf("Failed to load guardrail decisions: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load decisions")
+		return
+	}
+	writePolicyJSON(w, map[string]interface{}{"decisions": decisions})
+}
+
+// guardrailDenied refuses a request or response the tenant's guardrails
+// deny; a started stream gets the error as its last event
+func guardrailDenied(w http.ResponseWriter, stream *sse.Stream, d *guardrails.Decision) {
+	msg := "request denied by your organization's guardrails"
+	if d.Phase == guardrails.PhasePost {
+		msg = "response withheld by your organization's guardrails"
+	}
+	if d.Error != "" {
+		writeStreamError(w, stream, apierror.New(http.StatusServiceUnavailable, "guardrails unavailable").WithCode("guardrails_unavailable"))
+		return
+	}
+	w.Header().Set("X-Guardrails-Decision", d.ID)
+	writeStreamError(w, stream, apierror.New(http.StatusForbidden, msg+": "+strings.Join(d.Reasons, "; ")).WithCode("guardrail_violation"))
+}
+
+// guardrailTools are the function names a request offers the model
+func guardrailTools(req LangChainRequest) []string {
+	var names []string
+	for _, tool := range req.Tools {
+		if fn, ok := tool["function"].(map[string]interface{}); ok {
+			if name, ok := fn["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// promptText is the text content of the request messages
+func promptText(req LangChainRequest) string {
+	var b strings.Builder
+	for _, m := range req.Messages {
+		if content, ok := m["content"].(string); ok {
+			b.WriteString(content)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// redactPrompt applies redactions to the text content of the request messages
+func redactPrompt(req *LangChainRequest, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, m := range req.Messages {
+		if content, ok := m["content"].(string); ok {
+			m["content"] = guardrails.Redact(content, names)
+		}
+	}
+}
+
+// completionText is the text content of the response choices
+func completionText(resp LangChainResponse) string {
+	var b strings.Builder
+	for _, c := range resp.Choices {
+		if content, ok := c.Message["content"].(string); ok {
+			b.WriteString(content)
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// redactCompletion applies redactions to the text content of the choices
+func redactCompletion(resp *LangChainResponse, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	for _, c := range resp.Choices {
+		if content, ok := c.Message["content"].(string); ok {
+			c.Message["content"] = guardrails.Redact(content, names)
+		}
+	}
+}
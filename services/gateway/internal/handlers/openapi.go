@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/openapi"
+	"llm-gateway-pro/services/gateway/internal/providers"
+)
+
+// ProviderList is the body ListProviders writes
+type ProviderList struct {
+	Providers []ProviderInfo `json:"providers"`
+	Total     int            `json:"total"`
+	Healthy   int            `json:"healthy"`
+	Unhealthy int            `json:"unhealthy"`
+	// Providers in a maintenance window, counted neither healthy nor unhealthy
+	Maintenance int `json:"maintenance"`
+	// Providers that are unhealthy or failed over
+	Failover int `json:"failover"`
+}
+
+// ProviderInfo is a provider with its health and failover state
+type ProviderInfo struct {
+	Name           string   `json:"name"`
+	BaseURL        string   `json:"base_url"`
+	ModelNames     []string `json:"model_names"`
+	IsHealthy      bool     `json:"is_healthy"`
+	LastChecked    string   `json:"last_checked"`
+	Weight         int      `json:"weight"`
+	MaxConcurrency int      `json:"max_concurrency"`
+	UsesGRPC       bool     `json:"uses_grpc"`
+	GRPCAddress    string   `json:"grpc_address,omitempty"`
+	Region         string   `json:"region"`
+	SelfHosted     bool     `json:"self_hosted"`
+	// healthy, unhealthy or maintenance
+	Status             string                        `json:"status"`
+	MaintenanceWindows []providers.MaintenanceWindow `json:"maintenance_windows,omitempty"`
+	// Models found at the provider's /v1/models, for providers that discover
+	// them
+	DiscoveredModels []string `json:"discovered_models,omitempty"`
+	// available, unavailable, maintenance, failed_over or recovering
+	FailoverStatus string                  `json:"failover_status"`
+	CircuitBreaker *ProviderCircuitBreaker `json:"circuit_breaker,omitempty"`
+}
+
+// ProviderCircuitBreaker is the circuit breaker state of a provider
+type ProviderCircuitBreaker struct {
+	State     string `json:"state"`
+	Requests  uint32 `json:"requests"`
+	Failures  uint32 `json:"failures"`
+	LastError string `json:"last_error"`
+	LastTrip  string `json:"last_trip"`
+}
+
+// ProviderMaintenance is the body of the maintenance windows of a provider
+type ProviderMaintenance struct {
+	Provider string                        `json:"provider,omitempty"`
+	Status   string                        `json:"status,omitempty"`
+	Windows  []providers.MaintenanceWindow `json:"windows"`
+}
+
+// ProviderChange is the body AddProvider and RemoveProvider write
+type ProviderChange struct {
+	Status   string `json:"status"`
+	Provider string `json:"provider"`
+}
+
+// OpenAPISchema describes stop as a string or an array of strings
+func (StopSequences) OpenAPISchema() *openapi.Schema {
+	return &openapi.Schema{OneOf: []*openapi.Schema{
+		{Type: "string"},
+		{Type: "array", Items: &openapi.Schema{Type: "string"}},
+	}}
+}
+
+// OpenAPI describes the public API of the gateway, served at /openapi.json
+func OpenAPI() *openapi.Document {
+	anyObject := map[string]interface{}{}
+	return openapi.New("LLM Gateway API", "1.0.0",
+		"OpenAI-compatible chat completions routed across providers, batch status, provider management and usage.").
+		Add(
+			openapi.Route{Method: http.MethodPost, Path: "/v1/chat/completions", Tag: "chat",
+				Summary: "Create a chat completion", Request: LangChainRequest{}, Response: LangChainResponse{}, Stream: true},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/langchain/chat/completions", Tag: "chat",
+				Summary: "Create a chat completion for LangChain clients", Request: LangChainRequest{}, Response: LangChainResponse{}, Stream: true},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/audio/transcriptions", Tag: "audio",
+				Summary: "Transcribe audio, billed per second", Request: TranscriptionUpload{}, RequestType: "multipart/form-data", Response: Transcription{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/audio/speech", Tag: "audio",
+				Summary: "Synthesize speech, streamed and billed per character", Request: SpeechRequest{}, Response: "", ResponseType: "audio/mpeg"},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/agentic", Tag: "agentic",
+				Summary: "Run an agentic request", Request: anyObject, Response: anyObject},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/batch/{id}", Tag: "batch",
+				Summary: "Get the status of a batch", Response: anyObject},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/batch/{id}/results", Tag: "batch",
+				Summary: "Get the results of a batch", Response: anyObject},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/providers", Tag: "providers",
+				Summary: "List providers with their health", Response: ProviderList{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/providers", Tag: "providers",
+				Summary: "Add a provider", Request: providers.ProviderConfig{}, Response: ProviderChange{}, Status: http.StatusCreated},
+			openapi.Route{Method: http.MethodDelete, Path: "/v1/providers/{provider}", Tag: "providers",
+				Summary: "Remove a provider", Response: ProviderChange{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/providers/{provider}/maintenance", Tag: "providers",
+				Summary: "Get the maintenance windows of a provider", Response: ProviderMaintenance{}},
+			openapi.Route{Method: http.MethodPut, Path: "/v1/providers/{provider}/maintenance", Tag: "providers",
+				Summary: "Replace the maintenance windows of a provider", Request: ProviderMaintenance{}, Response: ProviderMaintenance{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/usage", Tag: "usage",
+				Summary: "Get the billed usage of the API key", Query: []string{"start", "end"}, Response: UsageReport{}},
+		)
+}
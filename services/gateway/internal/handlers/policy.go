@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/gorilla/mux"
+	"llm-gateway-pro/services/gateway/internal/policy"
+)
+
+// ListPolicies returns the model policies of all tenants
+func ListPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := policy.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to load model policies: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load policies")
+		return
+	}
+	writePolicyJSON(w, map[string]interface{}{"policies": policies})
+}
+
+// GetPolicy returns the model policy of a tenant
+func GetPolicy(w http.ResponseWriter, r *http.Request) {
+	p, err := policy.Get(r.Context(), mux.Vars(r)["tenant"])
+	if errors.Is(err, policy.ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, "policy not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load model policy: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load policy")
+		return
+	}
+	writePolicyJSON(w, p)
+}
+
+// PutPolicy creates or replaces the model policy of a tenant; tenant "*" is
+// the default for tenants without one
+func PutPolicy(w http.ResponseWriter, r *http.Request) {
+	var p policy.Policy
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&p); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	saved, err := policy.Save(r.Context(), mux.Vars(r)["tenant"], p)
+	if errors.Is(err, policy.ErrInvalid) {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save model policy: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to save policy")
+		return
+	}
+	writePolicyJSON(w, saved)
+}
+
+// DeletePolicy removes the model policy of a tenant
+func DeletePolicy(w http.ResponseWriter, r *http.Request) {
+	err := policy.Delete(r.Context(), mux.Vars(r)["tenant"])
+	if errors.Is(err, policy.ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, "policy not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete model policy: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to delete policy")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePolicyJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
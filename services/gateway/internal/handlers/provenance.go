@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/gorilla/mux"
+	"llm-gateway-pro/services/gateway/internal/guardrails"
+	"llm-gateway-pro/services/gateway/internal/policy"
+	"llm-gateway-pro/services/gateway/internal/provenance"
+)
+
+// ListProvenance returns the provenance settings of all tenants
+func ListProvenance(w http.ResponseWriter, r *http.Request) {
+	settings, err := provenance.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to load provenance settings: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load provenance settings")
+		return
+	}
+	writePolicyJSON(w, map[string]interface{}{"provenance": settings, "watermarks": provenance.Watermarkers()})
+}
+
+// GetProvenance returns the provenance settings of a tenant
+func GetProvenance(w http.ResponseWriter, r *http.Request) {
+	s, err := provenance.Get(r.Context(), mux.Vars(r)["tenant"])
+	if errors.Is(err, provenance.ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, "provenance settings not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load provenance settings: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load provenance settings")
+		return
+	}
+	writePolicyJSON(w, s)
+}
+
+// PutProvenance creates or replaces the provenance settings of a tenant;
+// tenant "*" is the default for tenants without their own
+func PutProvenance(w http.ResponseWriter, r *http.Request) {
+	var s provenance.Settings
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&s); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	saved, err := provenance.Save(r.Context(), mux.Vars(r)["tenant"], s)
+	if errors.Is(err, provenance.ErrInvalid) {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save provenance settings: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to save provenance settings")
+		return
+	}
+	writePolicyJSON(w, saved)
+}
+
+// DeleteProvenance removes the provenance settings of a tenant
+func DeleteProvenance(w http.ResponseWriter, r *http.Request) {
+	err := provenance.Delete(r.Context(), mux.Vars(r)["tenant"])
+	if errors.Is(err, provenance.ErrNotFound) {
+		apierror.Write(w, http.StatusNotFound, "provenance settings not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete provenance settings: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to delete provenance settings")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DetectWatermark returns the generation ID watermarked in a text
+func DetectWatermark(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 10<<20)).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	id, watermark, ok := provenance.Detect(req.Text)
+	if !ok {
+		writePolicyJSON(w, map[string]interface{}{"found": false})
+		return
+	}
+	writePolicyJSON(w, map[string]interface{}{"found": true, "generation_id": id, "watermark": watermark})
+}
+
+// policyVersion identifies the model policy and guardrails a tenant's request
+// was served under; it changes whenever either is saved. Tenants with
+// neither have no version.
+func policyVersion(tenant string) string {
+	p := policy.For(tenant)
+	g := guardrails.For(tenant)
+	if p == nil && g == nil {
+		return ""
+	}
+	h := fnv.New32a()
+	if p != nil {
+		fmt.Fprintf(h, "policy:%d;", p.UpdatedAt.UnixNano())
+	}
+	if g != nil {
+		fmt.Fprintf(h, "guardrails:%d;", g.UpdatedAt.UnixNano())
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// watermarkCompletion applies the tenant's watermark to the text content of
+// the choices
+func watermarkCompletion(resp *LangChainResponse, s *provenance.Settings, m provenance.Metadata) {
+	for _, c := range resp.Choices {
+		if content, ok := c.Message["content"].(string); ok {
+			c.Message["content"] = s.Mark(content, m)
+		}
+	}
+}
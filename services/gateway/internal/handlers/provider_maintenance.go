@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/gorilla/mux"
+	"llm-gateway-pro/services/gateway/internal/providers"
+)
+
+// GetProviderMaintenance returns the maintenance windows of a provider and
+// its current status
+func GetProviderMaintenance(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+	config, ok := providers.GetAllProviders()[provider]
+	if !ok {
+		apierror.Write(w, 404, "provider not found")
+		return
+	}
+	writeProviderMaintenance(w, provider, config)
+}
+
+// PutProviderMaintenance replaces the maintenance windows of a provider; an
+// empty list ends its maintenance
+func PutProviderMaintenance(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+
+	var req ProviderMaintenance
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, 400, "invalid json")
+		return
+	}
+	for _, window := range req.Windows {
+		if err := window.Validate(); err != nil {
+			apierror.New(400, err.Error()).WithParam("windows").Write(w)
+			return
+		}
+	}
+
+	if err := providers.SetMaintenanceWindows(provider, req.Windows); err != nil {
+		if errors.Is(err, providers.ErrUnknownProvider) {
+			apierror.Write(w, 404, "provider not found")
+			return
+		}
+		log.Printf("Failed to change maintenance windows of provider %s: %v", provider, err)
+		apierror.Write(w, 500, "failed to persist provider")
+		return
+	}
+	writeProviderMaintenance(w, provider, providers.GetAllProviders()[provider])
+}
+
+func writeProviderMaintenance(w http.ResponseWriter, provider string, config providers.ProviderConfig) {
+	windows := config.MaintenanceWindows
+	if windows == nil {
+		windows = []providers.MaintenanceWindow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ProviderMaintenance{
+		Provider: provider,
+		Status:   config.Status(time.Now()),
+		Windows:  windows,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// Bytes from 256 B to 16 MiB, tokens from 16 to 256k
+	payloadBuckets = prometheus.ExponentialBuckets(256, 4, 9)
+	tokenBuckets   = prometheus.ExponentialBuckets(16, 4, 8)
+
+	providerRequestBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_provider_request_bytes",
+			Help:    "Size of completion requests sent to providers",
+			Buckets: payloadBuckets,
+		},
+		[]string{"provider", "model"},
+	)
+
+	providerResponseBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_provider_response_bytes",
+			Help:    "Size of completion responses received from providers",
+			Buckets: payloadBuckets,
+		},
+		[]string{"provider", "model"},
+	)
+
+	providerPromptTokens = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_provider_prompt_tokens",
+			Help:    "Prompt tokens reported by providers per completion",
+			Buckets: tokenBuckets,
+		},
+		[]string{"provider", "model"},
+	)
+
+	providerCompletionTokens = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_provider_completion_tokens",
+			Help:    "Completion tokens reported by providers per completion",
+			Buckets: tokenBuckets,
+		},
+		[]string{"provider", "model"},
+	)
+
+	providerFinishReasons = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_provider_finish_reasons_total",
+			Help: "Choices returned by providers by finish reason; length means the completion was truncated",
+		},
+		[]string{"provider", "model", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(providerRequestBytes, providerResponseBytes, providerPromptTokens,
+		providerCompletionTokens, providerFinishReasons)
+}
+
+// observeProviderPayload records the sizes of a completion a provider
+// returned, and its usage and finish reasons when the response has them
+// (streamed responses do not)
+func observeProviderPayload(provider string, req LangChainRequest, body []byte) {
+	if encoded, err := json.Marshal(req); err == nil {
+		providerRequestBytes.WithLabelValues(provider, req.Model).Observe(float64(len(encoded)))
+	}
+	providerResponseBytes.WithLabelValues(provider, req.Model).Observe(float64(len(body)))
+
+	var resp LangChainResponse
+	if req.Stream || json.Unmarshal(body, &resp) != nil {
+		return
+	}
+	if resp.Usage.TotalTokens > 0 {
+		providerPromptTokens.WithLabelValues(provider, req.Model).Observe(float64(resp.Usage.PromptTokens))
+		providerCompletionTokens.WithLabelValues(provider, req.Model).Observe(float64(resp.Usage.CompletionTokens))
+	}
+	for _, choice := range resp.Choices {
+		reason := choice.FinishReason
+		if reason == "" {
+			reason = "none"
+		}
+		providerFinishReasons.WithLabelValues(provider, req.Model, reason).Inc()
+	}
+}
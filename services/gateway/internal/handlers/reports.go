@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"llm-gateway-pro/services/gateway/internal/billing"
+)
+
+const (
+	// defaultForecastDays is the horizon of a forecast without ?days
+	defaultForecastDays = 30
+	maxForecastDays     = 90
+	// defaultForecastHistory is the history a forecast is fitted on without
+	// ?history
+	defaultForecastHistory = 90
+	maxForecastHistory     = 365
+)
+
+// GetForecast returns the projected tokens and cost per model over the next
+// ?days (30 by default), fitted on the usage of the last ?history days (90
+// by default), optionally for one ?model
+func GetForecast(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	days, ok := boundedParam(w, query.Get("days"), "days", defaultForecastDays, maxForecastDays)
+	if !ok {
+		return
+	}
+	historyDays, ok := boundedParam(w, query.Get("history"), "history", defaultForecastHistory, maxForecastHistory)
+	if !ok {
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	history, err := billing.DailyUsageSince(r.Context(), today.AddDate(0, 0, -historyDays))
+	if err != nil {
+		log.Printf("Failed to load usage history: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load usage history")
+		return
+	}
+	if model := query.Get("model"); model != "" {
+		filtered := history[:0]
+		for _, u := range history {
+			if u.Model == model {
+				filtered = append(filtered, u)
+			}
+		}
+		history = filtered
+	}
+
+	forecasts := billing.Forecast(history, days)
+	var tokens int64
+	var cost float64
+	for _, f := range forecasts {
+		tokens += f.Tokens
+		cost += f.CostUSD
+	}
+	if forecasts == nil {
+		forecasts = []billing.ModelForecast{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"days":         days,
+		"history_days": historyDays,
+		"tokens":       tokens,
+		"cost_usd":     cost,
+		"models":       forecasts,
+	})
+}
+
+// boundedParam parses an optional query parameter between 1 and max,
+// writing the error response when it is invalid
+func boundedParam(w http.ResponseWriter, raw, name string, def, max int) (int, bool) {
+	if raw == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > max {
+		apierror.New(http.StatusBadRequest, name+" must be between 1 and "+strconv.Itoa(max)).WithParam(name).Write(w)
+		return 0, false
+	}
+	return n, true
+}
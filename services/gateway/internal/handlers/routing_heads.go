@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/routingclient"
+)
+
+// routingClient reads the heads registered with routing-service; nil until
+// InitRouting
+var routingClient *routingclient.Client
+
+// InitRouting lists heads through c
+func InitRouting(c *routingclient.Client) {
+	routingClient = c
+}
+
+// GetRoutingHeads returns the heads registered with routing-service, with the
+// state of the gateway's circuit to it
+func GetRoutingHeads(w http.ResponseWriter, r *http.Request) {
+	if routingClient == nil {
+		apierror.Write(w, http.StatusNotFound, "routing-service is not configured")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	resp, err := routingClient.GetAllHeads(ctx, &routingpb.GetAllHeadsRequest{})
+	if err != nil {
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to list heads from routing-service")
+		return
+	}
+	writePolicyJSON(w, map[string]interface{}{
+		"heads":   resp.GetHeads(),
+		"circuit": routingClient.BreakerState(),
+	})
+}
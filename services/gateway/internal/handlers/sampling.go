@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// StopSequences is the OpenAI stop parameter, a string or an array of strings
+type StopSequences []string
+
+func (s *StopSequences) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = StopSequences{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("stop must be a string or an array of strings")
+	}
+	*s = many
+	return nil
+}
+
+// validateSampling checks the sampling parameters against the OpenAI limits
+// and returns the offending parameter; providers that do not support one
+// refuse the request rather than ignore it
+func validateSampling(req LangChainRequest) (param string, err error) {
+	if req.TopP != nil && (*req.TopP < 0 || *req.TopP > 1) {
+		return "top_p", errors.New("top_p must be between 0 and 1")
+	}
+	if req.FrequencyPenalty != nil && (*req.FrequencyPenalty < -2 || *req.FrequencyPenalty > 2) {
+		return "frequency_penalty", errors.New("frequency_penalty must be between -2 and 2")
+	}
+	if req.PresencePenalty != nil && (*req.PresencePenalty < -2 || *req.PresencePenalty > 2) {
+		return "presence_penalty", errors.New("presence_penalty must be between -2 and 2")
+	}
+	if len(req.Stop) > 4 {
+		return "stop", errors.New("stop accepts at most 4 sequences")
+	}
+	for _, stop := range req.Stop {
+		if stop == "" {
+			return "stop", errors.New("stop sequences must not be empty")
+		}
+	}
+	if req.TopLogprobs != nil {
+		if *req.TopLogprobs < 0 || *req.TopLogprobs > 20 {
+			return "top_logprobs", errors.New("top_logprobs must be between 0 and 20")
+		}
+		if req.Logprobs == nil || !*req.Logprobs {
+			return "top_logprobs", errors.New("top_logprobs requires logprobs")
+		}
+	}
+	if req.N != nil && (*req.N < 1 || *req.N > maxChoices) {
+		return "n", errors.New("n must be between 1 and 16")
+	}
+	return "", nil
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
@@ -28,7 +29,7 @@ func GetSecurityConfig(w http.ResponseWriter, r *http.Request) {
 	// Get client ID from context
 	clientID := r.Context().Value("client_id").(string)
 	if clientID == "" {
-		http.Error(w, "Client ID required", http.StatusUnauthorized)
+		apierror.Write(w, http.StatusUnauthorized, "Client ID required")
 		return
 	}
 
@@ -48,7 +49,7 @@ func GetSecurityConfig(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(defaultConfig)
 		return
 	} else if err != nil {
-		http.Error(w, "Failed to get security config", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "Failed to get security config")
 		return
 	}
 
@@ -56,7 +57,7 @@ func GetSecurityConfig(w http.ResponseWriter, r *http.Request) {
 	var config SecurityConfig
 	err = json.Unmarshal([]byte(val), &config)
 	if err != nil {
-		http.Error(w, "Failed to parse security config", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "Failed to parse security config")
 		return
 	}
 
@@ -68,21 +69,21 @@ func UpdateSecurityConfig(w http.ResponseWriter, r *http.Request) {
 	// Get client ID from context
 	clientID := r.Context().Value("client_id").(string)
 	if clientID == "" {
-		http.Error(w, "Client ID required", http.StatusUnauthorized)
+		apierror.Write(w, http.StatusUnauthorized, "Client ID required")
 		return
 	}
 
 	// Parse request body
 	var config SecurityConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Validate config
 	if config.ContentFilteringEnabled && config.AuditLoggingEnabled && config.DataIsolationEnabled {
 		// At least one security feature must be enabled
-		http.Error(w, "At least one security feature must be enabled", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "At least one security feature must be enabled")
 		return
 	}
 
@@ -92,13 +93,13 @@ func UpdateSecurityConfig(w http.ResponseWriter, r *http.Request) {
 
 	configData, err := json.Marshal(config)
 	if err != nil {
-		http.Error(w, "Failed to save security config", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "Failed to save security config")
 		return
 	}
 
 	err = redisClient.Set(ctx, configKey, configData, 0).Err()
 	if err != nil {
-		http.Error(w, "Failed to save security config", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "Failed to save security config")
 		return
 	}
 
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"llm-gateway-pro/services/gateway/internal/billing"
+)
+
+// defaultUsagePeriod is the report period when start is not set
+const defaultUsagePeriod = 30 * 24 * time.Hour
+
+// UsageReport is the body of GET /v1/usage
+type UsageReport struct {
+	Object string       `json:"object"`
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Data   []UsageEntry `json:"data"`
+	// Audio is the billed transcription and speech requests
+	Audio       []AudioUsageEntry `json:"audio"`
+	TotalTokens int               `json:"total_tokens"`
+	TotalCost   float64           `json:"total_cost"`
+}
+
+// AudioUsageEntry is a billed audio request
+type AudioUsageEntry struct {
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	// seconds of transcribed audio or characters of synthesized speech
+	Unit      string    `json:"unit"`
+	Quantity  float64   `json:"quantity"`
+	Cost      float64   `json:"cost"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// UsageEntry is a billed request
+type UsageEntry struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	Tokens    int       `json:"tokens"`
+	Cost      float64   `json:"cost"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// GetUsage handles GET /v1/usage: the billed requests of the API key between
+// start and end (RFC 3339, the last 30 days by default), newest first
+func GetUsage(w http.ResponseWriter, r *http.Request) {
+	apiKey := r.Header.Get("Authorization")
+	if !strings.HasPrefix(apiKey, "Bearer ") {
+		apierror.New(401, "invalid api key format").WithCode("invalid_api_key").Write(w)
+		return
+	}
+	userID, err := validateAndTrackLangChainUsage(strings.TrimPrefix(apiKey, "Bearer "))
+	if err != nil {
+		apierror.New(401, "invalid api key").WithCode("invalid_api_key").Write(w)
+		return
+	}
+
+	end := time.Now().UTC()
+	if v := r.URL.Query().Get("end"); v != "" {
+		if end, err = time.Parse(time.RFC3339, v); err != nil {
+			apierror.New(400, "end must be an RFC 3339 time").WithParam("end").Write(w)
+			return
+		}
+	}
+	start := end.Add(-defaultUsagePeriod)
+	if v := r.URL.Query().Get("start"); v != "" {
+		if start, err = time.Parse(time.RFC3339, v); err != nil {
+			apierror.New(400, "start must be an RFC 3339 time").WithParam("start").Write(w)
+			return
+		}
+	}
+	if !start.Before(end) {
+		apierror.New(400, "start must be before end").WithParam("start").Write(w)
+		return
+	}
+
+	records, err := billing.GetUsageReport(userID, start, end)
+	var audio []billing.AudioUsageRecord
+	if err == nil {
+		audio, err = billing.GetAudioUsageReport(userID, start, end)
+	}
+	if err != nil {
+		log.Printf("Failed to load usage of %s: %v", userID, err)
+		apierror.Write(w, http.StatusServiceUnavailable, "failed to load usage")
+		return
+	}
+
+	report := UsageReport{Object: "list", Start: start, End: end,
+		Data: make([]UsageEntry, 0, len(records)), Audio: make([]AudioUsageEntry, 0, len(audio))}
+	for _, rec := range records {
+		report.Data = append(report.Data, UsageEntry{
+			ID:        rec.ID,
+			Model:     rec.Model,
+			Tokens:    rec.Tokens,
+			Cost:      rec.Cost,
+			Timestamp: rec.Timestamp,
+		})
+		report.TotalTokens += rec.Tokens
+		report.TotalCost += rec.Cost
+	}
+	for _, rec := range audio {
+		report.Audio = append(report.Audio, AudioUsageEntry{
+			ID:        rec.ID,
+			Model:     rec.Model,
+			Unit:      rec.Unit,
+			Quantity:  rec.Quantity,
+			Cost:      rec.Cost,
+			Timestamp: rec.Timestamp,
+		})
+		report.TotalCost += rec.Cost
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
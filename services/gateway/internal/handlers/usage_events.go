@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/usageevents"
+	"llm-gateway-pro/services/gateway/internal/resilience"
+)
+
+// usageEmitter publishes the usage of completed requests for the data
+// warehouse; nil until InitUsageEvents
+var usageEmitter *usageevents.Emitter
+
+// InitUsageEvents publishes usage events through e
+func InitUsageEvents(e *usageevents.Emitter) {
+	usageEmitter = e
+}
+
+// emitUsage publishes the usage of a completed request with its request ID
+// and endpoint, and the state of the circuit breaker it went through
+func emitUsage(r *http.Request, event usageevents.Event, breaker string) {
+	if usageEmitter == nil {
+		return
+	}
+	event.RequestID = requestid.FromContext(r.Context())
+	event.Endpoint = r.URL.Path
+	if state, _, err := resilience.GetCircuitBreakerStatus(breaker); err == nil {
+		event.CircuitBreaker = state.String()
+	}
+	usageEmitter.Emit(event)
+}
@@ -0,0 +1,291 @@
+// Package policy restricts which models and providers a tenant's API keys may
+// call, e.g. only EU-hosted models or no external providers.
+//
+// Policies are stored per tenant in a Redis hash, one JSON entry each; the
+// entry of DefaultTenant applies to tenants without their own. Every change is
+// published on a channel so all gateway replicas reload at once, with a
+// periodic reload for missed messages.
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+
+	"llm-gateway-pro/services/gateway/internal/providers"
+)
+
+const (
+	policiesKey     = "gateway:model_policies"
+	policiesChannel = "gateway:model_policies:changed"
+)
+
+// DefaultTenant is the tenant whose policy applies to tenants without one
+const DefaultTenant = "*"
+
+var (
+	// ErrNotFound is returned for tenants without a stored policy
+	ErrNotFound = errors.New("policy not found")
+	// ErrInvalid wraps validation failures
+	ErrInvalid = errors.New("invalid policy")
+)
+
+var logger = zerolog.New(os.Stdout).With().Timestamp().Str("service", "policy").Logger()
+
+// Policy lists the models and providers a tenant may use. Models are matched
+// case-insensitively as path.Match patterns, e.g. "gpt-4*"; provider lists
+// hold provider names. Empty allow lists allow everything and deny lists win
+// over allow lists.
+type Policy struct {
+	AllowModels    []string `json:"allow_models,omitempty"`
+	DenyModels     []string `json:"deny_models,omitempty"`
+	AllowProviders []string `json:"allow_providers,omitempty"`
+	DenyProviders  []string `json:"deny_providers,omitempty"`
+	// Regions the provider must process requests in, e.g. ["eu"]; providers
+	// without a region are rejected by a non-empty list
+	Regions []string `json:"regions,omitempty"`
+	// Only providers on our own infrastructure, no external APIs
+	SelfHostedOnly bool      `json:"self_hosted_only,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Validate checks the model patterns
+func (p Policy) Validate() error {
+	for _, pattern := range append(append([]string{}, p.AllowModels...), p.DenyModels...) {
+		if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+			return fmt.Errorf("%w: bad model pattern %q", ErrInvalid, pattern)
+		}
+	}
+	return nil
+}
+
+// AllowsModel reports whether the policy allows a model. A nil policy
+// allows every model.
+func (p *Policy) AllowsModel(model string) bool {
+	if p == nil {
+		return true
+	}
+	model = strings.ToLower(model)
+	if matchAny(p.DenyModels, model) {
+		return false
+	}
+	return len(p.AllowModels) == 0 || matchAny(p.AllowModels, model)
+}
+
+// AllowsProvider reports whether the policy allows a provider; it is a
+// providers.Filter. A nil policy allows every provider.
+func (p *Policy) AllowsProvider(config providers.ProviderConfig) bool {
+	if p == nil {
+		return true
+	}
+	if p.SelfHostedOnly && !config.SelfHosted {
+		return false
+	}
+	if len(p.Regions) > 0 && !containsFold(p.Regions, config.Region) {
+		return false
+	}
+	if containsFold(p.DenyProviders, config.Name) {
+		return false
+	}
+	return len(p.AllowProviders) == 0 || containsFold(p.AllowProviders, config.Name)
+}
+
+func matchAny(patterns []string, model string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	client *redis.Client
+
+	mu       sync.RWMutex
+	policies = map[string]Policy{}
+)
+
+// Init loads the policies from Redis and reloads them on change until ctx is
+// cancelled. Without Init every tenant may use every model.
+func Init(ctx context.Context, rdb *redis.Client, interval time.Duration) {
+	client = rdb
+	if err := reload(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to load model policies")
+	}
+
+	sub := client.Subscribe(ctx, policiesChannel)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+			case <-ticker.C:
+			}
+			if err := reload(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to reload model policies")
+			}
+		}
+	}()
+}
+
+func reload(ctx context.Context) error {
+	loaded, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	policies = loaded
+	mu.Unlock()
+	return nil
+}
+
+func load(ctx context.Context) (map[string]Policy, error) {
+	entries, err := client.HGetAll(ctx, policiesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]Policy, len(entries))
+	for tenant, raw := range entries {
+		var p Policy
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			logger.Error().Str("tenant", tenant).Err(err).Msg("Skipping malformed model policy")
+			continue
+		}
+		loaded[tenant] = p
+	}
+	return loaded, nil
+}
+
+// For returns the policy of a tenant, the default policy for tenants without
+// one, or nil when neither exists
+func For(tenant string) *Policy {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if p, ok := policies[tenant]; ok {
+		return &p
+	}
+	if p, ok := policies[DefaultTenant]; ok {
+		return &p
+	}
+	return nil
+}
+
+// List returns the stored policies by tenant
+func List(ctx context.Context) (map[string]Policy, error) {
+	if client == nil {
+		return map[string]Policy{}, nil
+	}
+	return load(ctx)
+}
+
+// Get returns the stored policy of a tenant
+func Get(ctx context.Context, tenant string) (Policy, error) {
+	if client == nil {
+		return Policy{}, ErrNotFound
+	}
+	raw, err := client.HGet(ctx, policiesKey, tenant).Result()
+	if err == redis.Nil {
+		return Policy{}, ErrNotFound
+	}
+	if err != nil {
+		return Policy{}, err
+	}
+	var p Policy
+	if err := json.Unmarshal([]byte(raw), &p); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// Save validates and stores the policy of a tenant, then notifies all replicas
+func Save(ctx context.Context, tenant string, p Policy) (Policy, error) {
+	if tenant == "" {
+		return Policy{}, fmt.Errorf("%w: tenant is required", ErrInvalid)
+	}
+	if err := p.Validate(); err != nil {
+		return Policy{}, err
+	}
+	if client == nil {
+		return Policy{}, errors.New("policy store is not configured")
+	}
+	p.UpdatedAt = time.Now().UTC()
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return Policy{}, err
+	}
+	if err := client.HSet(ctx, policiesKey, tenant, raw).Err(); err != nil {
+		return Policy{}, fmt.Errorf("failed to persist policy: %w", err)
+	}
+	applyLocal(tenant, &p)
+	return p, publish(ctx)
+}
+
+// Delete removes the policy of a tenant, then notifies all replicas
+func Delete(ctx context.Context, tenant string) error {
+	if client == nil {
+		return ErrNotFound
+	}
+	n, err := client.HDel(ctx, policiesKey, tenant).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete policy: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	applyLocal(tenant, nil)
+	return publish(ctx)
+}
+
+// applyLocal makes a change visible on this replica before the reload
+func applyLocal(tenant string, p *Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	updated := make(map[string]Policy, len(policies)+1)
+	for t, existing := range policies {
+		updated[t] = existing
+	}
+	if p == nil {
+		delete(updated, tenant)
+	} else {
+		updated[tenant] = *p
+	}
+	policies = updated
+}
+
+func publish(ctx context.Context) error {
+	// The change is persisted: replicas that miss it catch up on reload
+	if err := client.Publish(ctx, policiesChannel, time.Now().Unix()).Err(); err != nil {
+		logger.Warn().Err(err).Msg("Failed to broadcast model policy change")
+	}
+	return nil
+}
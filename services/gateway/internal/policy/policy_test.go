@@ -0,0 +1,54 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"llm-gateway-pro/services/gateway/internal/providers"
+)
+
+func TestAllowsModel(t *testing.T) {
+	p := &Policy{AllowModels: []string{"gpt-4*", "mistral-*"}, DenyModels: []string{"gpt-4-32k"}}
+
+	assert.True(t, p.AllowsModel("gpt-4o"))
+	assert.True(t, p.AllowsModel("Mistral-Large"))
+	assert.False(t, p.AllowsModel("gpt-4-32k"))
+	assert.False(t, p.AllowsModel("claude-3"))
+
+	var none *Policy
+	assert.True(t, none.AllowsModel("claude-3"))
+}
+
+func TestAllowsProvider(t *testing.T) {
+	mistral := providers.ProviderConfig{Name: "mistral", Region: "eu"}
+	openai := providers.ProviderConfig{Name: "openai", Region: "us"}
+	local := providers.ProviderConfig{Name: "local", Region: "eu", SelfHosted: true}
+
+	euOnly := &Policy{Regions: []string{"EU"}}
+	assert.True(t, euOnly.AllowsProvider(mistral))
+	assert.False(t, euOnly.AllowsProvider(openai))
+
+	noExternal := &Policy{SelfHostedOnly: true}
+	assert.True(t, noExternal.AllowsProvider(local))
+	assert.False(t, noExternal.AllowsProvider(mistral))
+
+	lists := &Policy{AllowProviders: []string{"mistral", "local"}, DenyProviders: []string{"local"}}
+	assert.True(t, lists.AllowsProvider(mistral))
+	assert.False(t, lists.AllowsProvider(local))
+	assert.False(t, lists.AllowsProvider(openai))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Policy{AllowModels: []string{"gpt-*"}}.Validate())
+	assert.Error(t, Policy{DenyModels: []string{"gpt-[4"}}.Validate())
+}
+
+func TestForFallsBackToDefault(t *testing.T) {
+	applyLocal(DefaultTenant, &Policy{Regions: []string{"eu"}})
+	applyLocal("acme", &Policy{})
+	defer func() { policies = map[string]Policy{} }()
+
+	assert.Empty(t, For("acme").Regions)
+	assert.Equal(t, []string{"eu"}, For("globex").Regions)
+}
@@ -0,0 +1,385 @@
+// Package provenance marks generated content so downstream systems can
+// attribute it: the generation ID, model, provider and policy version of a
+// completion as response headers and, per tenant, as a "provenance" field of
+// the body, and optionally an invisible watermark in the text itself.
+//
+// Settings are stored per tenant in a Redis hash like model policies, with
+// the same change channel and periodic reload; the settings of DefaultTenant
+// apply to tenants without their own. Tenants without settings get nothing.
+package provenance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/rs/zerolog"
+)
+
+const (
+	settingsKey     = "gateway:provenance"
+	settingsChannel = "gateway:provenance:changed"
+)
+
+// DefaultTenant is the tenant whose settings apply to tenants without any
+const DefaultTenant = "*"
+
+// Response headers
+const (
+	HeaderGenerationID  = "X-ZB-Generation-ID"
+	HeaderModel         = "X-ZB-Model"
+	HeaderPolicyVersion = "X-ZB-Policy-Version"
+	HeaderWatermark     = "X-ZB-Watermark"
+)
+
+// Metadata fields a tenant can choose
+const (
+	FieldGenerationID  = "generation_id"
+	FieldModel         = "model"
+	FieldProvider      = "provider"
+	FieldPolicyVersion = "policy_version"
+	FieldCreatedAt     = "created_at"
+)
+
+var (
+	// ErrNotFound is returned for tenants without stored settings
+	ErrNotFound = errors.New("provenance settings not found")
+	// ErrInvalid wraps validation failures
+	ErrInvalid = errors.New("invalid provenance settings")
+)
+
+var logger = zerolog.New(os.Stdout).With().Timestamp().Str("service", "provenance").Logger()
+
+// Settings are what a tenant's completions are marked with
+type Settings struct {
+	Enabled bool `json:"enabled"`
+	// Fields of the metadata to send; empty sends all of them
+	Fields []string `json:"fields,omitempty"`
+	// InBody adds the metadata as a "provenance" field of JSON responses;
+	// headers are always sent
+	InBody bool `json:"in_body,omitempty"`
+	// Watermark names the watermarker applied to the completion text, empty
+	// for none
+	Watermark string    `json:"watermark,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the fields and the watermarker
+func (s Settings) Validate() error {
+	for _, f := range s.Fields {
+		switch f {
+		case FieldGenerationID, FieldModel, FieldProvider, FieldPolicyVersion, FieldCreatedAt:
+		default:
+			return fmt.Errorf("%w: unknown field %q", ErrInvalid, f)
+		}
+	}
+	if s.Watermark != "" {
+		if _, ok := lookupWatermarker(s.Watermark); !ok {
+			return fmt.Errorf("%w: unknown watermark %q", ErrInvalid, s.Watermark)
+		}
+	}
+	return nil
+}
+
+func (s Settings) wants(field string) bool {
+	if len(s.Fields) == 0 {
+		return true
+	}
+	for _, f := range s.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Metadata describes a generation
+type Metadata struct {
+	GenerationID  string `json:"generation_id,omitempty"`
+	Model         string `json:"model,omitempty"`
+	Provider      string `json:"provider,omitempty"`
+	PolicyVersion string `json:"policy_version,omitempty"`
+	// CreatedAt is a Unix time, like the created field of completions
+	CreatedAt int64 `json:"created_at,omitempty"`
+}
+
+// filter keeps the fields the settings ask for
+func (m Metadata) filter(s Settings) Metadata {
+	var kept Metadata
+	if s.wants(FieldGenerationID) {
+		kept.GenerationID = m.GenerationID
+	}
+	if s.wants(FieldModel) {
+		kept.Model = m.Model
+	}
+	if s.wants(FieldProvider) {
+		kept.Provider = m.Provider
+	}
+	if s.wants(FieldPolicyVersion) {
+		kept.PolicyVersion = m.PolicyVersion
+	}
+	if s.wants(FieldCreatedAt) {
+		kept.CreatedAt = m.CreatedAt
+	}
+	return kept
+}
+
+// SetHeaders sets the metadata headers; call it before writing the header.
+// A nil Settings sets nothing.
+func (s *Settings) SetHeaders(h http.Header, m Metadata) {
+	if s == nil || !s.Enabled {
+		return
+	}
+	m = m.filter(*s)
+	if m.GenerationID != "" {
+		h.Set(HeaderGenerationID, m.GenerationID)
+	}
+	if m.Model != "" {
+		h.Set(HeaderModel, m.Model)
+	}
+	if m.PolicyVersion != "" {
+		h.Set(HeaderPolicyVersion, m.PolicyVersion)
+	}
+	if s.Watermark != "" {
+		h.Set(HeaderWatermark, s.Watermark)
+	}
+}
+
+// DeclareTrailers announces the metadata headers the tenant wants as
+// trailers, for streams whose headers a heartbeat may already have sent.
+// Call SetHeaders after the body to send them.
+func (s *Settings) DeclareTrailers(h http.Header) {
+	if s == nil || !s.Enabled {
+		return
+	}
+	if s.wants(FieldGenerationID) {
+		h.Add("Trailer", HeaderGenerationID)
+	}
+	if s.wants(FieldModel) {
+		h.Add("Trailer", HeaderModel)
+	}
+	if s.wants(FieldPolicyVersion) {
+		h.Add("Trailer", HeaderPolicyVersion)
+	}
+	if s.Watermark != "" {
+		h.Add("Trailer", HeaderWatermark)
+	}
+}
+
+// Mark applies the tenant's watermarker to a completion text. A nil
+// Settings returns the text unchanged.
+func (s *Settings) Mark(text string, m Metadata) string {
+	if s == nil || !s.Enabled || s.Watermark == "" || text == "" {
+		return text
+	}
+	w, ok := lookupWatermarker(s.Watermark)
+	if !ok {
+		return text
+	}
+	return w.Apply(text, m)
+}
+
+// AddToBody adds the metadata as a "provenance" field of a JSON object when
+// the settings ask for it, keeping the rest of the body as is. Anything else
+// is returned unchanged.
+func (s *Settings) AddToBody(body []byte, m Metadata) []byte {
+	if s == nil || !s.Enabled || !s.InBody {
+		return body
+	}
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) < 2 || trimmed[0] != '{' || !json.Valid(trimmed) {
+		return body
+	}
+	raw, err := json.Marshal(m.filter(*s))
+	if err != nil {
+		return body
+	}
+
+	marked := make([]byte, 0, len(trimmed)+len(raw)+16)
+	marked = append(marked, trimmed[:len(trimmed)-1]...)
+	if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+		marked = append(marked, ',')
+	}
+	marked = append(marked, `"provenance":`...)
+	marked = append(marked, raw...)
+	return append(marked, '}')
+}
+
+var (
+	client *redis.Client
+
+	mu       sync.RWMutex
+	settings = map[string]Settings{}
+)
+
+// Init loads the settings from Redis and reloads them on change until ctx is
+// cancelled. Without Init no completion is marked.
+func Init(ctx context.Context, rdb *redis.Client, interval time.Duration) {
+	client = rdb
+	if err := reload(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to load provenance settings")
+	}
+
+	sub := client.Subscribe(ctx, settingsChannel)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+			case <-ticker.C:
+			}
+			if err := reload(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to reload provenance settings")
+			}
+		}
+	}()
+}
+
+func reload(ctx context.Context) error {
+	loaded, err := load(ctx)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	settings = loaded
+	mu.Unlock()
+	return nil
+}
+
+func load(ctx context.Context) (map[string]Settings, error) {
+	entries, err := client.HGetAll(ctx, settingsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]Settings, len(entries))
+	for tenant, raw := range entries {
+		var s Settings
+		if err := json.Unmarshal([]byte(raw), &s); err != nil {
+			logger.Error().Str("tenant", tenant).Err(err).Msg("Skipping malformed provenance settings")
+			continue
+		}
+		loaded[tenant] = s
+	}
+	return loaded, nil
+}
+
+// For returns the settings of a tenant, the default settings for tenants
+// without their own, or nil when neither exists or they are disabled
+func For(tenant string) *Settings {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	s, ok := settings[tenant]
+	if !ok {
+		s, ok = settings[DefaultTenant]
+	}
+	if !ok || !s.Enabled {
+		return nil
+	}
+	return &s
+}
+
+// List returns the stored settings by tenant
+func List(ctx context.Context) (map[string]Settings, error) {
+	if client == nil {
+		return map[string]Settings{}, nil
+	}
+	return load(ctx)
+}
+
+// Get returns the stored settings of a tenant
+func Get(ctx context.Context, tenant string) (Settings, error) {
+	if client == nil {
+		return Settings{}, ErrNotFound
+	}
+	raw, err := client.HGet(ctx, settingsKey, tenant).Result()
+	if err == redis.Nil {
+		return Settings{}, ErrNotFound
+	}
+	if err != nil {
+		return Settings{}, err
+	}
+	var s Settings
+	if err := json.Unmarshal([]byte(raw), &s); err != nil {
+		return Settings{}, err
+	}
+	return s, nil
+}
+
+// Save validates and stores the settings of a tenant, then notifies all
+// replicas
+func Save(ctx context.Context, tenant string, s Settings) (Settings, error) {
+	if tenant == "" {
+		return Settings{}, fmt.Errorf("%w: tenant is required", ErrInvalid)
+	}
+	if err := s.Validate(); err != nil {
+		return Settings{}, err
+	}
+	if client == nil {
+		return Settings{}, errors.New("provenance store is not configured")
+	}
+	s.UpdatedAt = time.Now().UTC()
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return Settings{}, err
+	}
+	if err := client.HSet(ctx, settingsKey, tenant, raw).Err(); err != nil {
+		return Settings{}, fmt.Errorf("failed to persist provenance settings: %w", err)
+	}
+	applyLocal(tenant, &s)
+	return s, publish(ctx)
+}
+
+// Delete removes the settings of a tenant, then notifies all replicas
+func Delete(ctx context.Context, tenant string) error {
+	if client == nil {
+		return ErrNotFound
+	}
+	n, err := client.HDel(ctx, settingsKey, tenant).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete provenance settings: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	applyLocal(tenant, nil)
+	return publish(ctx)
+}
+
+// applyLocal makes a change visible on this replica before the reload
+func applyLocal(tenant string, s *Settings) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	updated := make(map[string]Settings, len(settings)+1)
+	for t, existing := range settings {
+		updated[t] = existing
+	}
+	if s == nil {
+		delete(updated, tenant)
+	} else {
+		updated[tenant] = *s
+	}
+	settings = updated
+}
+
+func publish(ctx context.Context) error {
+	// The change is persisted: replicas that miss it catch up on reload
+	if err := client.Publish(ctx, settingsChannel, time.Now().Unix()).Err(); err != nil {
+		logger.Warn().Err(err).Msg("Failed to broadcast provenance settings change")
+	}
+	return nil
+}
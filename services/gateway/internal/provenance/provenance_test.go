@@ -0,0 +1,90 @@
+package provenance
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var meta = Metadata{
+	GenerationID:  "chatcmpl-123",
+	Model:         "gpt-4o",
+	Provider:      "openai",
+	PolicyVersion: "a1b2c3d4",
+	CreatedAt:     1718000000,
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	s := &Settings{Enabled: true, Fields: []string{FieldGenerationID, FieldModel}}
+	s.SetHeaders(h, meta)
+
+	assert.Equal(t, "chatcmpl-123", h.Get(HeaderGenerationID))
+	assert.Equal(t, "gpt-4o", h.Get(HeaderModel))
+	assert.Empty(t, h.Get(HeaderPolicyVersion))
+
+	var none *Settings
+	none.SetHeaders(h, Metadata{Model: "other"})
+	assert.Equal(t, "gpt-4o", h.Get(HeaderModel))
+}
+
+func TestDeclareTrailers(t *testing.T) {
+	h := http.Header{}
+	s := &Settings{Enabled: true, Fields: []string{FieldGenerationID, FieldModel}, Watermark: "zero_width"}
+	s.DeclareTrailers(h)
+	assert.Equal(t, []string{HeaderGenerationID, HeaderModel, HeaderWatermark}, h.Values("Trailer"))
+
+	h = http.Header{}
+	var none *Settings
+	none.DeclareTrailers(h)
+	(&Settings{Enabled: false}).DeclareTrailers(h)
+	assert.Empty(t, h.Values("Trailer"))
+}
+
+func TestAddToBody(t *testing.T) {
+	s := &Settings{Enabled: true, InBody: true, Fields: []string{FieldGenerationID, FieldPolicyVersion}}
+	body := s.AddToBody([]byte(`{"id":"chatcmpl-123","choices":[]}`), meta)
+
+	var fields struct {
+		ID         string   `json:"id"`
+		Provenance Metadata `json:"provenance"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &fields))
+	assert.Equal(t, Metadata{GenerationID: "chatcmpl-123", PolicyVersion: "a1b2c3d4"}, fields.Provenance)
+
+	headersOnly := &Settings{Enabled: true}
+	assert.Equal(t, `{"id":"x"}`, string(headersOnly.AddToBody([]byte(`{"id":"x"}`), meta)))
+}
+
+func TestZeroWidthWatermark(t *testing.T) {
+	s := &Settings{Enabled: true, Watermark: "zero_width"}
+	marked := s.Mark("Hello, world.", meta)
+
+	assert.NotEqual(t, "Hello, world.", marked)
+	assert.Equal(t, "Hello, world.", marked[:len("Hello, world.")])
+
+	id, watermark, ok := Detect("Quoted: " + marked + " and more")
+	assert.True(t, ok)
+	assert.Equal(t, "chatcmpl-123", id)
+	assert.Equal(t, "zero_width", watermark)
+
+	_, _, ok = Detect("Hello, world.")
+	assert.False(t, ok)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Settings{Fields: []string{FieldModel}, Watermark: "zero_width"}.Validate())
+	assert.Error(t, Settings{Fields: []string{"user"}}.Validate())
+	assert.Error(t, Settings{Watermark: "synthid"}.Validate())
+}
+
+func TestForSkipsDisabled(t *testing.T) {
+	applyLocal(DefaultTenant, &Settings{Enabled: true})
+	applyLocal("acme", &Settings{Enabled: false})
+	defer func() { settings = map[string]Settings{} }()
+
+	assert.Nil(t, For("acme"))
+	assert.NotNil(t, For("globex"))
+}
@@ -0,0 +1,128 @@
+package provenance
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Watermarker marks a completion text so it can be attributed later
+type Watermarker interface {
+	// Apply returns the marked text
+	Apply(text string, m Metadata) string
+	// Detect returns the generation ID marked in a text, if any
+	Detect(text string) (string, bool)
+}
+
+var (
+	watermarkersMu sync.RWMutex
+	watermarkers   = map[string]Watermarker{
+		"zero_width": zeroWidth{},
+	}
+)
+
+// RegisterWatermarker makes a watermarker available to tenants by name,
+// replacing one of the same name
+func RegisterWatermarker(name string, w Watermarker) {
+	watermarkersMu.Lock()
+	defer watermarkersMu.Unlock()
+	watermarkers[name] = w
+}
+
+// Watermarkers returns the names of the registered watermarkers
+func Watermarkers() []string {
+	watermarkersMu.RLock()
+	defer watermarkersMu.RUnlock()
+
+	names := make([]string, 0, len(watermarkers))
+	for name := range watermarkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupWatermarker(name string) (Watermarker, bool) {
+	watermarkersMu.RLock()
+	defer watermarkersMu.RUnlock()
+	w, ok := watermarkers[name]
+	return w, ok
+}
+
+// Detect looks for a generation ID with every registered watermarker and
+// returns the first found with the name of its watermarker
+func Detect(text string) (id, watermark string, ok bool) {
+	for _, name := range Watermarkers() {
+		w, _ := lookupWatermarker(name)
+		if id, ok := w.Detect(text); ok {
+			return id, name, true
+		}
+	}
+	return "", "", false
+}
+
+// Zero-width characters of the zero_width watermark: the generation ID is
+// appended to the text as bits between two word joiners. It survives copy
+// and paste but not normalization that strips format characters.
+const (
+	zeroWidthFrame = '\u2060'
+	zeroWidthZero  = '\u200b'
+	zeroWidthOne   = '\u200c'
+)
+
+type zeroWidth struct{}
+
+func (zeroWidth) Apply(text string, m Metadata) string {
+	if m.GenerationID == "" {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text) + len(m.GenerationID)*8*3 + 6)
+	b.WriteString(text)
+	b.WriteRune(zeroWidthFrame)
+	for _, c := range []byte(m.GenerationID) {
+		for bit := 7; bit >= 0; bit-- {
+			if c&(1<<bit) != 0 {
+				b.WriteRune(zeroWidthOne)
+			} else {
+				b.WriteRune(zeroWidthZero)
+			}
+		}
+	}
+	b.WriteRune(zeroWidthFrame)
+	return b.String()
+}
+
+func (zeroWidth) Detect(text string) (string, bool) {
+	last := strings.LastIndex(text, string(zeroWidthFrame))
+	if last < 0 {
+		return "", false
+	}
+	start := strings.LastIndex(text[:last], string(zeroWidthFrame))
+	if start < 0 {
+		return "", false
+	}
+
+	var id []byte
+	var c byte
+	bits := 0
+	for _, r := range text[start+len(string(zeroWidthFrame)) : last] {
+		switch r {
+		case zeroWidthZero:
+			c <<= 1
+		case zeroWidthOne:
+			c = c<<1 | 1
+		default:
+			return "", false
+		}
+		bits++
+		if bits == 8 {
+			id = append(id, c)
+			c, bits = 0, 0
+		}
+	}
+	if bits != 0 || len(id) == 0 {
+		return "", false
+	}
+	return string(id), true
+}
@@ -0,0 +1,64 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/requestid"
+)
+
+// Provider paths of the OpenAI audio endpoints
+const (
+	TranscriptionPath = "/v1/audio/transcriptions"
+	SpeechPath        = "/v1/audio/speech"
+)
+
+// audioClient allows for minute-long uploads and speech streams
+var audioClient = &http.Client{Timeout: 10 * time.Minute}
+
+// StatusError is a provider response with an error status
+type StatusError struct {
+	Status int
+	Body   []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("provider returned status %d: %s", e.Status, e.Body)
+}
+
+// ProxyAudio sends body as is, e.g. a multipart upload, to an HTTP provider
+// and returns its response for the caller to stream and close. The request
+// is cancelled with ctx, so a client that goes away stops the stream. A
+// response with a status of 400 or more is read and returned as *StatusError.
+func ProxyAudio(ctx context.Context, providerConfig ProviderConfig, path, contentType string, body []byte) (*http.Response, error) {
+	if providerConfig.UseGRPC {
+		return nil, fmt.Errorf("provider %s does not serve audio over gRPC", providerConfig.Name)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, providerConfig.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	setAuth(req, providerConfig)
+
+	resp, err := audioClient.Do(req)
+	if err != nil {
+		observeProviderResult(ctx, providerConfig.Name, err)
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		err := &StatusError{Status: resp.StatusCode, Body: respBody}
+		observeProviderResult(ctx, providerConfig.Name, err)
+		return nil, err
+	}
+	observeProviderResult(ctx, providerConfig.Name, nil)
+	return resp, nil
+}
@@ -0,0 +1,253 @@
+package providers
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Balancing strategies among providers serving the same model
+const (
+	// BalanceWeighted picks a provider at random in proportion to its Weight
+	BalanceWeighted = "weighted"
+	// BalanceLeastConnections picks the provider with the fewest in-flight
+	// requests per unit of Weight
+	BalanceLeastConnections = "least_connections"
+)
+
+var (
+	ErrNoProvider = errors.New("no provider found for model")
+	// ErrAtCapacity means every provider of the model is at its MaxConcurrency
+	ErrAtCapacity = errors.New("all providers for model are at capacity")
+	// ErrNotAllowed means the model has providers, but the filter of the
+	// request rejects all of them
+	ErrNotAllowed = errors.New("no allowed provider for model")
+)
+
+// Filter reports whether a request may use a provider
+type Filter func(ProviderConfig) bool
+
+var (
+	balancing = BalanceWeighted
+
+	// In-flight requests per provider
+	inFlight      = make(map[string]int)
+	inFlightMutex sync.Mutex
+
+	providerSelections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_provider_selections_total",
+			Help: "Providers selected for a model by the balancer",
+		},
+		[]string{"model", "provider", "strategy"},
+	)
+	providerSaturations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_provider_saturations_total",
+			Help: "Requests rejected because all providers of the model were at capacity",
+		},
+		[]string{"model"},
+	)
+	providerInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_provider_inflight_requests",
+			Help: "In-flight requests per provider",
+		},
+		[]string{"provider"},
+	)
+	providerRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_provider_requests_total",
+			Help: "Requests sent to providers by result (success, error, cancelled); cache hits are not counted",
+		},
+		[]string{"provider", "result"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(providerSelections, providerSaturations, providerInFlight, providerRequests)
+}
+
+// AcquireProvider selects a provider for the model among the healthy ones
+// (all of them if none is healthy), skipping providers at their
+// MaxConcurrency, and reserves a slot on it. Call release once the request
+// to the provider is done. Providers in a maintenance window are never
+// selected; ErrInMaintenance means all of the model's providers are.
+func AcquireProvider(model string) (config ProviderConfig, release func(), err error) {
+	return acquireProvider(model, "", nil)
+}
+
+// AcquireAllowedProvider is AcquireProvider among the providers allow
+// accepts. It returns ErrNotAllowed when the model has providers but allow
+// rejects them all.
+func AcquireAllowedProvider(model string, allow Filter) (ProviderConfig, func(), error) {
+	return acquireProvider(model, "", allow)
+}
+
+// AcquireAlternativeProvider is AcquireAllowedProvider among the providers of
+// the model other than exclude, e.g. to hedge a request to a second provider.
+// A nil allow accepts every provider.
+func AcquireAlternativeProvider(model, exclude string, allow Filter) (ProviderConfig, func(), error) {
+	return acquireProvider(model, exclude, allow)
+}
+
+func acquireProvider(model, exclude string, allow Filter) (config ProviderConfig, release func(), err error) {
+	var candidates []ProviderConfig
+	rejected := false
+	serving, maintenance := providersForModel(model, time.Now())
+	if len(serving) == 0 && maintenance {
+		return ProviderConfig{}, nil, ErrInMaintenance
+	}
+	for _, c := range serving {
+		if c.Name == exclude {
+			continue
+		}
+		if allow != nil && !allow(c) {
+			rejected = true
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		if rejected {
+			return ProviderConfig{}, nil, ErrNotAllowed
+		}
+		return ProviderConfig{}, nil, ErrNoProvider
+	}
+
+	inFlightMutex.Lock()
+	available := candidates[:0]
+	for _, c := range candidates {
+		if c.MaxConcurrency <= 0 || inFlight[c.Name] < c.MaxConcurrency {
+			available = append(available, c)
+		}
+	}
+	if len(available) == 0 {
+		inFlightMutex.Unlock()
+		providerSaturations.WithLabelValues(model).Inc()
+		return ProviderConfig{}, nil, ErrAtCapacity
+	}
+
+	if balancing == BalanceLeastConnections {
+		config = leastConnections(available)
+	} else {
+		config = weightedRandom(available)
+	}
+	inFlight[config.Name]++
+	providerInFlight.WithLabelValues(config.Name).Set(float64(inFlight[config.Name]))
+	inFlightMutex.Unlock()
+
+	providerSelections.WithLabelValues(model, config.Name, balancing).Inc()
+	return config, releaser(config.Name), nil
+}
+
+// releaser frees a slot reserved on a provider, once however often it is
+// called
+func releaser(name string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inFlightMutex.Lock()
+			defer inFlightMutex.Unlock()
+			inFlight[name]--
+			providerInFlight.WithLabelValues(name).Set(float64(inFlight[name]))
+		})
+	}
+}
+
+// GetProviderForModel selects a provider for the model like AcquireProvider,
+// without keeping a slot reserved
+func GetProviderForModel(model string) (ProviderConfig, error) {
+	config, release, err := AcquireProvider(model)
+	if err != nil {
+		return ProviderConfig{}, err
+	}
+	release()
+	return config, nil
+}
+
+// InFlight returns the in-flight requests per provider
+func InFlight() map[string]int {
+	inFlightMutex.Lock()
+	defer inFlightMutex.Unlock()
+
+	result := make(map[string]int, len(inFlight))
+	for name, n := range inFlight {
+		result[name] = n
+	}
+	return result
+}
+
+// providersForModel returns the healthy providers serving a model, or all
+// providers serving it if none of them is healthy. Providers in a
+// maintenance window are left out; maintenance reports whether there were any.
+func providersForModel(model string, now time.Time) (serving []ProviderConfig, maintenance bool) {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+
+	var healthy, all []ProviderConfig
+	for _, config := range providerCache {
+		for _, name := range config.Models() {
+			if strings.EqualFold(model, name) {
+				if _, ok := config.Maintenance(now); ok {
+					maintenance = true
+					break
+				}
+				all = append(all, config)
+				if config.IsHealthy {
+					healthy = append(healthy, config)
+				}
+				break
+			}
+		}
+	}
+	if len(healthy) > 0 {
+		return healthy, maintenance
+	}
+	return all, maintenance
+}
+
+// weight defaults to 1 for providers without one
+func weight(c ProviderConfig) int {
+	if c.Weight <= 0 {
+		return 1
+	}
+	return c.Weight
+}
+
+func weightedRandom(candidates []ProviderConfig) ProviderConfig {
+	total := 0
+	for _, c := range candidates {
+		total += weight(c)
+	}
+	n := rand.Intn(total)
+	for _, c := range candidates {
+		n -= weight(c)
+		if n < 0 {
+			return c
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// leastConnections must be called with inFlightMutex held. Ties are broken
+// at random so equally loaded providers share the traffic.
+func leastConnections(candidates []ProviderConfig) ProviderConfig {
+	var best []ProviderConfig
+	var bestLoad float64
+	for _, c := range candidates {
+		load := float64(inFlight[c.Name]) / float64(weight(c))
+		switch {
+		case len(best) == 0 || load < bestLoad:
+			best = append(best[:0], c)
+			bestLoad = load
+		case load == bestLoad:
+			best = append(best, c)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
@@ -0,0 +1,110 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initBalancerTest(strategy string) {
+	Init(LiteLLMConfig{
+		Providers: map[string]ProviderConfig{
+			"primary":   {BaseURL: "https://primary", ModelNames: []string{"gpt-4"}, Weight: 3, MaxConcurrency: 2},
+			"secondary": {BaseURL: "https://secondary", ModelNames: []string{"gpt-4"}, Weight: 1, MaxConcurrency: 1},
+			"other":     {BaseURL: "https://other", ModelNames: []string{"claude-3"}},
+		},
+		Balancing: strategy,
+	})
+}
+
+func TestWeightedBalancing(t *testing.T) {
+	initBalancerTest(BalanceWeighted)
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		config, err := GetProviderForModel("gpt-4")
+		require.NoError(t, err)
+		counts[config.Name]++
+	}
+	// Weights 3:1, so roughly 3000 and 1000
+	assert.InDelta(t, 3000, counts["primary"], 200)
+	assert.InDelta(t, 1000, counts["secondary"], 200)
+	assert.Zero(t, counts["other"])
+}
+
+func TestLeastConnectionsBalancing(t *testing.T) {
+	initBalancerTest(BalanceLeastConnections)
+
+	// 0/3 vs 0/1: tie, then the lower load per weight wins
+	first, releaseFirst, err := AcquireProvider("gpt-4")
+	require.NoError(t, err)
+	second, releaseSecond, err := AcquireProvider("gpt-4")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Name, second.Name)
+	assert.Equal(t, map[string]int{"primary": 1, "secondary": 1}, InFlight())
+
+	// secondary is at MaxConcurrency, primary still has a slot
+	third, releaseThird, err := AcquireProvider("gpt-4")
+	require.NoError(t, err)
+	assert.Equal(t, "primary", third.Name)
+
+	// Both providers are full
+	_, _, err = AcquireProvider("gpt-4")
+	assert.ErrorIs(t, err, ErrAtCapacity)
+
+	releaseFirst()
+	releaseFirst() // releasing twice has no effect
+	releaseSecond()
+	releaseThird()
+	assert.Equal(t, map[string]int{"primary": 0, "secondary": 0}, InFlight())
+}
+
+func TestUnhealthyProvidersSkipped(t *testing.T) {
+	initBalancerTest(BalanceWeighted)
+	cacheMutex.Lock()
+	primary := providerCache["primary"]
+	primary.IsHealthy = false
+	providerCache["primary"] = primary
+	cacheMutex.Unlock()
+
+	for i := 0; i < 20; i++ {
+		config, err := GetProviderForModel("gpt-4")
+		require.NoError(t, err)
+		assert.Equal(t, "secondary", config.Name)
+	}
+
+	_, err := GetProviderForModel("unknown-model")
+	assert.ErrorIs(t, err, ErrNoProvider)
+}
+
+func TestAlternativeProvider(t *testing.T) {
+	initBalancerTest(BalanceWeighted)
+
+	for i := 0; i < 20; i++ {
+		config, release, err := AcquireAlternativeProvider("gpt-4", "primary", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "secondary", config.Name)
+		release()
+	}
+
+	_, _, err := AcquireAlternativeProvider("claude-3", "other", nil)
+	assert.ErrorIs(t, err, ErrNoProvider)
+}
+
+func TestAllowedProvider(t *testing.T) {
+	initBalancerTest(BalanceWeighted)
+	onlySecondary := func(c ProviderConfig) bool { return c.Name == "secondary" }
+
+	for i := 0; i < 20; i++ {
+		config, release, err := AcquireAllowedProvider("gpt-4", onlySecondary)
+		require.NoError(t, err)
+		assert.Equal(t, "secondary", config.Name)
+		release()
+	}
+
+	_, _, err := AcquireAllowedProvider("claude-3", onlySecondary)
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	_, _, err = AcquireAllowedProvider("unknown-model", onlySecondary)
+	assert.ErrorIs(t, err, ErrNoProvider)
+}
@@ -0,0 +1,169 @@
+package providers
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxEntries bounds the response cache when the config does not
+const DefaultCacheMaxEntries = 1000
+
+// responseCache is an LRU of provider responses with a TTL. Keys hash the
+// provider, method, path and canonical JSON body, so equal requests share an
+// entry whatever their field order or Go type.
+type responseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	order      *list.List // front is most recently used
+	entries    map[string]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	expired   uint64
+}
+
+type cacheEntry struct {
+	key      string
+	response []byte
+	stored   time.Time
+	expires  time.Time
+}
+
+func newResponseCache(ttl time.Duration, maxEntries int) *responseCache {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	return &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		c.expired++
+		c.misses++
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	c.hits++
+	return entry.response, true
+}
+
+func (c *responseCache) set(key string, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.response = response
+		entry.stored = now
+		entry.expires = now.Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:      key,
+		response: response,
+		stored:   now,
+		expires:  now.Add(c.ttl),
+	})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// remove must be called with mu held
+func (c *responseCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+func (c *responseCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *responseCache) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hitRate := 0.0
+	if total := c.hits + c.misses; total > 0 {
+		hitRate = float64(c.hits) / float64(total)
+	}
+	oldest := time.Duration(0)
+	if back := c.order.Back(); back != nil {
+		// Least recently used, which is at worst as old as the oldest
+		oldest = time.Since(back.Value.(*cacheEntry).stored)
+	}
+
+	return map[string]interface{}{
+		"cache_size":   c.order.Len(),
+		"max_entries":  c.maxEntries,
+		"cache_ttl":    c.ttl.String(),
+		"hits":         c.hits,
+		"misses":       c.misses,
+		"hit_rate":     hitRate,
+		"evictions":    c.evictions,
+		"expired":      c.expired,
+		"oldest_entry": oldest.Round(time.Second).String(),
+	}
+}
+
+// cacheKey hashes a request. The body is re-encoded through a generic value,
+// which sorts object keys, so structs and maps with the same JSON match.
+func cacheKey(providerConfig ProviderConfig, method, path string, body interface{}) (string, error) {
+	canonical, err := canonicalJSON(body)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, part := range []string{providerConfig.Name, providerConfig.BaseURL, method, path} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func canonicalJSON(body interface{}) ([]byte, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// Keep numbers exact instead of going through float64
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheKeyCanonicalBody(t *testing.T) {
+	config := ProviderConfig{Name: "openai", BaseURL: "https://api.openai.com"}
+	type request struct {
+		Model       string  `json:"model"`
+		Temperature float64 `json:"temperature"`
+	}
+
+	fromStruct, err := cacheKey(config, "GET", "/v1/models", request{Model: "gpt-4", Temperature: 0.5})
+	require.NoError(t, err)
+	fromMap, err := cacheKey(config, "GET", "/v1/models", map[string]interface{}{"temperature": 0.5, "model": "gpt-4"})
+	require.NoError(t, err)
+	assert.Equal(t, fromStruct, fromMap)
+
+	other, err := cacheKey(config, "GET", "/v1/models", map[string]interface{}{"temperature": 0.7, "model": "gpt-4"})
+	require.NoError(t, err)
+	assert.NotEqual(t, fromStruct, other)
+
+	config.Name = "azure"
+	otherProvider, err := cacheKey(config, "GET", "/v1/models", request{Model: "gpt-4", Temperature: 0.5})
+	require.NoError(t, err)
+	assert.NotEqual(t, fromStruct, otherProvider)
+}
+
+func TestResponseCacheLRU(t *testing.T) {
+	cache := newResponseCache(time.Minute, 2)
+	cache.set("a", []byte("1"))
+	cache.set("b", []byte("2"))
+
+	// a becomes the most recently used, so c evicts b
+	_, ok := cache.get("a")
+	assert.True(t, ok)
+	cache.set("c", []byte("3"))
+
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	value, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+
+	stats := cache.stats()
+	assert.Equal(t, 2, stats["cache_size"])
+	assert.Equal(t, uint64(1), stats["evictions"])
+	assert.Equal(t, uint64(2), stats["hits"])
+	assert.Equal(t, uint64(1), stats["misses"])
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	cache := newResponseCache(10*time.Millisecond, 10)
+	cache.set("a", []byte("1"))
+	time.Sleep(20 * time.Millisecond)
+
+	_, ok := cache.get("a")
+	assert.False(t, ok)
+	stats := cache.stats()
+	assert.Equal(t, 0, stats["cache_size"])
+	assert.Equal(t, uint64(1), stats["expired"])
+}
@@ -0,0 +1,236 @@
+package providers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StrategyCheapestCapable serves a request for a model class, e.g. "small",
+// with the cheapest model of that class or a more capable one
+const StrategyCheapestCapable = "cheapest_capable"
+
+// ModelClasses in increasing capability: a request for a class may be served
+// by a model of any class at or after it
+var ModelClasses = []string{"small", "medium", "large"}
+
+// DefaultModelClasses is the class of each built-in model
+var DefaultModelClasses = map[string]string{
+	"gpt-3.5-turbo":  "small",
+	"claude-instant": "small",
+	"gemini-1.0":     "small",
+	"llama-2":        "small",
+	"mistral-small":  "small",
+	"command-light":  "small",
+	"claude-2":       "medium",
+	"gemini-pro":     "medium",
+	"llama-3":        "medium",
+	"mistral-medium": "medium",
+	"command-r":      "medium",
+	"gpt-4":          "large",
+	"gpt-4o":         "large",
+	"claude-3":       "large",
+	"gemini-1.5":     "large",
+	"mistral-large":  "large",
+}
+
+var (
+	classesMutex sync.RWMutex
+	modelClasses = DefaultModelClasses
+
+	costSavings = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_cheapest_capable_savings_usd",
+			Help:    "Cost saved per request by cheapest_capable routing, against the most expensive capable model",
+			Buckets: []float64{0, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1},
+		},
+		[]string{"class", "model"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(costSavings)
+}
+
+// SetModelClasses replaces the class of each model; models without a class
+// never serve class requests
+func SetModelClasses(classes map[string]string) {
+	classesMutex.Lock()
+	defer classesMutex.Unlock()
+	modelClasses = make(map[string]string, len(classes))
+	for model, class := range classes {
+		modelClasses[strings.ToLower(model)] = strings.ToLower(class)
+	}
+}
+
+// IsModelClass reports whether a requested model names a class
+func IsModelClass(model string) bool {
+	return classRank(model) >= 0
+}
+
+func classRank(class string) int {
+	for i, c := range ModelClasses {
+		if strings.EqualFold(c, class) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CapableRequest is a request for a model class
+type CapableRequest struct {
+	Class string
+	// AllowModel rejects models the tenant may not use; nil allows all
+	AllowModel func(model string) bool
+	// AllowProvider rejects providers the request may not use; nil allows all
+	AllowProvider Filter
+	// Cost estimates what the request costs on a model
+	Cost func(model string) float64
+}
+
+// Selection is the provider and model cheapest_capable chose
+type Selection struct {
+	Provider ProviderConfig
+	Model    string
+	// Reference is the most expensive capable model, the baseline savings
+	// are measured against
+	Reference string
+	Class     string
+	// Release frees the slot reserved on the provider
+	Release func()
+}
+
+type capableModel struct {
+	name string
+	rank int
+	cost float64
+}
+
+// AcquireCheapestCapable reserves a slot on a provider of the cheapest model
+// of the class or a more capable one. Like AcquireAllowedProvider it prefers
+// healthy providers, skips those in maintenance or at MaxConcurrency and
+// balances among the providers of the chosen model.
+func AcquireCheapestCapable(req CapableRequest) (Selection, error) {
+	rank := classRank(req.Class)
+	if rank < 0 {
+		return Selection{}, ErrNoProvider
+	}
+
+	var candidates []ProviderConfig
+	models := map[string]*capableModel{}
+	rejected, healthy, maintenance := false, false, false
+	now := time.Now()
+	classesMutex.RLock()
+	cacheMutex.RLock()
+	for _, config := range providerCache {
+		_, inMaintenance := config.Maintenance(now)
+		capable := false
+		for _, name := range config.Models() {
+			modelRank := classRank(modelClasses[strings.ToLower(name)])
+			if modelRank < rank {
+				continue
+			}
+			if (req.AllowModel != nil && !req.AllowModel(name)) || (req.AllowProvider != nil && !req.AllowProvider(config)) {
+				rejected = true
+				continue
+			}
+			if inMaintenance {
+				maintenance = true
+				continue
+			}
+			if key := strings.ToLower(name); models[key] == nil {
+				models[key] = &capableModel{name: name, rank: modelRank, cost: req.Cost(name)}
+			}
+			capable = true
+		}
+		if capable {
+			candidates = append(candidates, config)
+			healthy = healthy || config.IsHealthy
+		}
+	}
+	cacheMutex.RUnlock()
+	classesMutex.RUnlock()
+	if len(candidates) == 0 {
+		if rejected {
+			return Selection{}, ErrNotAllowed
+		}
+		if maintenance {
+			return Selection{}, ErrInMaintenance
+		}
+		return Selection{}, ErrNoProvider
+	}
+
+	// Models from cheapest to most expensive, the less capable first on a tie
+	ranked := make([]*capableModel, 0, len(models))
+	for _, m := range models {
+		ranked = append(ranked, m)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].cost != ranked[j].cost {
+			return ranked[i].cost < ranked[j].cost
+		}
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank < ranked[j].rank
+		}
+		return ranked[i].name < ranked[j].name
+	})
+
+	inFlightMutex.Lock()
+	for _, m := range ranked {
+		var available []ProviderConfig
+		for _, c := range candidates {
+			if (healthy && !c.IsHealthy) || !servesModel(c, m.name) {
+				continue
+			}
+			if c.MaxConcurrency <= 0 || inFlight[c.Name] < c.MaxConcurrency {
+				available = append(available, c)
+			}
+		}
+		if len(available) == 0 {
+			continue
+		}
+
+		var config ProviderConfig
+		if balancing == BalanceLeastConnections {
+			config = leastConnections(available)
+		} else {
+			config = weightedRandom(available)
+		}
+		inFlight[config.Name]++
+		providerInFlight.WithLabelValues(config.Name).Set(float64(inFlight[config.Name]))
+		inFlightMutex.Unlock()
+
+		providerSelections.WithLabelValues(m.name, config.Name, StrategyCheapestCapable).Inc()
+		return Selection{
+			Provider:  config,
+			Model:     m.name,
+			Reference: ranked[len(ranked)-1].name,
+			Class:     strings.ToLower(req.Class),
+			Release:   releaser(config.Name),
+		}, nil
+	}
+	inFlightMutex.Unlock()
+
+	providerSaturations.WithLabelValues(req.Class).Inc()
+	return Selection{}, ErrAtCapacity
+}
+
+// ObserveSavings records what a request saved against the reference model
+func (s Selection) ObserveSavings(usd float64) {
+	if usd < 0 {
+		usd = 0
+	}
+	costSavings.WithLabelValues(s.Class, s.Model).Observe(usd)
+}
+
+func servesModel(c ProviderConfig, model string) bool {
+	for _, name := range c.Models() {
+		if strings.EqualFold(name, model) {
+			return true
+		}
+	}
+	return false
+}
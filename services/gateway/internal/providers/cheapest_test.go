@@ -0,0 +1,83 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCosts are per-request costs, cheapest first
+var testCosts = map[string]float64{"tiny": 0.001, "mid": 0.01, "big": 0.1}
+
+func initCheapestTest() {
+	Init(LiteLLMConfig{
+		Providers: map[string]ProviderConfig{
+			"budget":  {BaseURL: "https://budget", ModelNames: []string{"tiny"}, MaxConcurrency: 1, Region: "us"},
+			"general": {BaseURL: "https://general", ModelNames: []string{"mid", "big"}, Region: "eu"},
+		},
+		ModelClasses: map[string]string{"tiny": "small", "mid": "medium", "big": "large"},
+	})
+}
+
+func cheapestFor(class string) CapableRequest {
+	return CapableRequest{Class: class, Cost: func(model string) float64 { return testCosts[model] }}
+}
+
+func TestCheapestCapable(t *testing.T) {
+	initCheapestTest()
+	assert.True(t, IsModelClass("Small"))
+	assert.False(t, IsModelClass("tiny"))
+
+	small, err := AcquireCheapestCapable(cheapestFor("small"))
+	require.NoError(t, err)
+	assert.Equal(t, "budget", small.Provider.Name)
+	assert.Equal(t, "tiny", small.Model)
+	assert.Equal(t, "big", small.Reference)
+
+	// budget is at capacity, the next cheapest capable model takes over
+	next, err := AcquireCheapestCapable(cheapestFor("small"))
+	require.NoError(t, err)
+	assert.Equal(t, "mid", next.Model)
+
+	// A large request is never served by a smaller model
+	large, err := AcquireCheapestCapable(cheapestFor("large"))
+	require.NoError(t, err)
+	assert.Equal(t, "big", large.Model)
+
+	small.Release()
+	next.Release()
+	large.Release()
+	assert.Equal(t, 0, InFlight()["budget"])
+}
+
+func TestCheapestCapableFilters(t *testing.T) {
+	initCheapestTest()
+
+	// Unhealthy providers are skipped while a healthy one is capable
+	cacheMutex.Lock()
+	budget := providerCache["budget"]
+	budget.IsHealthy = false
+	providerCache["budget"] = budget
+	cacheMutex.Unlock()
+	s, err := AcquireCheapestCapable(cheapestFor("small"))
+	require.NoError(t, err)
+	assert.Equal(t, "mid", s.Model)
+	s.Release()
+	initCheapestTest()
+
+	req := cheapestFor("small")
+	req.AllowModel = func(model string) bool { return model != "tiny" }
+	s, err = AcquireCheapestCapable(req)
+	require.NoError(t, err)
+	assert.Equal(t, "mid", s.Model)
+	s.Release()
+
+	req = cheapestFor("medium")
+	req.AllowProvider = func(c ProviderConfig) bool { return c.Region == "us" }
+	_, err = AcquireCheapestCapable(req)
+	assert.ErrorIs(t, err, ErrNotAllowed)
+
+	_, err = AcquireCheapestCapable(cheapestFor("huge"))
+	assert.ErrorIs(t, err, ErrNoProvider)
+}
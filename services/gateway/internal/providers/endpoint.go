@@ -0,0 +1,160 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Self-hosted OpenAI-compatible endpoints, e.g. vLLM or Ollama clusters, are
+// registered like any provider. They often need no API key or another auth
+// header than a bearer token, and serve whatever models they were started
+// with, so their models can be discovered from /v1/models instead of listed.
+
+// ErrModelDiscovery is returned when adding a provider that discovers its
+// models, lists none itself and whose models could not be discovered
+var ErrModelDiscovery = errors.New("model discovery failed")
+
+// modelsPath lists the models of an OpenAI-compatible endpoint
+const modelsPath = "/v1/models"
+
+var discoveryClient = &http.Client{Timeout: 5 * time.Second}
+
+// Models returns the models the provider serves: ModelNames followed by the
+// discovered ones
+func (c ProviderConfig) Models() []string {
+	if len(c.DiscoveredModels) == 0 {
+		return c.ModelNames
+	}
+	models := make([]string, 0, len(c.ModelNames)+len(c.DiscoveredModels))
+	seen := make(map[string]bool, cap(models))
+	for _, list := range [][]string{c.ModelNames, c.DiscoveredModels} {
+		for _, name := range list {
+			if key := strings.ToLower(name); !seen[key] {
+				seen[key] = true
+				models = append(models, name)
+			}
+		}
+	}
+	return models
+}
+
+// healthCheckURL returns HealthCheckURL, or HealthCheckPath on BaseURL, or
+// the default endpoint for the kind of provider
+func (c ProviderConfig) healthCheckURL() string {
+	switch {
+	case c.HealthCheckURL != "":
+		return c.HealthCheckURL
+	case c.HealthCheckPath != "":
+		return c.BaseURL + c.HealthCheckPath
+	case c.DiscoverModels:
+		return c.BaseURL + modelsPath
+	// Default health check endpoints for common providers
+	case strings.Contains(c.Name, "openai"):
+		return c.BaseURL + "/v1/models"
+	case strings.Contains(c.Name, "anthropic"):
+		return c.BaseURL + "/health"
+	case strings.Contains(c.Name, "google"):
+		return c.BaseURL + "/v1/health"
+	case strings.Contains(c.Name, "meta"):
+		return c.BaseURL + "/status"
+	default:
+		// For custom providers, try a simple ping
+		return c.BaseURL + "/ping"
+	}
+}
+
+// setAuth authenticates a request to the provider: a bearer token when it
+// has an API key, and its auth headers
+func setAuth(req *http.Request, c ProviderConfig) {
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	for name, value := range c.AuthHeaders {
+		req.Header.Set(name, value)
+	}
+}
+
+// discoverModels returns the IDs of the models listed by the provider's
+// /v1/models
+func discoverModels(ctx context.Context, c ProviderConfig) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+modelsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	setAuth(req, c)
+
+	resp, err := discoveryClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s returned status %d", modelsPath, resp.StatusCode)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&list); err != nil {
+		return nil, fmt.Errorf("malformed model list: %w", err)
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, model := range list.Data {
+		if model.ID != "" {
+			models = append(models, model.ID)
+		}
+	}
+	if len(models) == 0 {
+		return nil, fmt.Errorf("%s lists no models", modelsPath)
+	}
+	return models, nil
+}
+
+// refreshModels discovers the models of a provider again. On failure the
+// models found before are kept.
+func refreshModels(provider string) {
+	cacheMutex.RLock()
+	config, ok := providerCache[provider]
+	cacheMutex.RUnlock()
+	if !ok || !config.DiscoverModels {
+		return
+	}
+	if _, inMaintenance := config.Maintenance(time.Now()); inMaintenance {
+		return
+	}
+
+	models, err := discoverModels(context.Background(), config)
+	if err != nil {
+		logger.Warn().Str("provider", provider).Err(err).Msg("Model discovery failed")
+		return
+	}
+
+	cacheMutex.Lock()
+	// The provider may have been replaced or moved meanwhile
+	if current, ok := providerCache[provider]; ok && current.BaseURL == config.BaseURL {
+		if len(current.DiscoveredModels) != len(models) {
+			logger.Info().Str("provider", provider).Strs("models", models).Msg("Discovered provider models")
+		}
+		current.DiscoveredModels = models
+		providerCache[provider] = current
+	}
+	cacheMutex.Unlock()
+}
+
+// refreshAllModels discovers the models of every provider that discovers
+// them
+func refreshAllModels() {
+	for name, config := range GetAllProviders() {
+		if config.DiscoverModels {
+			refreshModels(name)
+		}
+	}
+}
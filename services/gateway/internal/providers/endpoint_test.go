@@ -0,0 +1,105 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfHosted serves an OpenAI-compatible model list to requests with the
+// X-API-Key header and no bearer token
+func selfHosted(t *testing.T, models string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("X-API-Key") != "cluster-key" || r.Header.Get("Authorization") != "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"object":"list","data":[` + models + `]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAddProviderDiscoversModels(t *testing.T) {
+	Init(LiteLLMConfig{})
+	srv := selfHosted(t, `{"id":"llama-3-70b"},{"id":"qwen2-7b"}`)
+
+	err := AddProvider("vllm", ProviderConfig{
+		BaseURL:        srv.URL,
+		AuthHeaders:    map[string]string{"X-API-Key": "cluster-key"},
+		DiscoverModels: true,
+	})
+	require.NoError(t, err)
+
+	config := GetAllProviders()["vllm"]
+	assert.Equal(t, []string{"llama-3-70b", "qwen2-7b"}, config.DiscoveredModels)
+	provider, err := GetProviderForModel("qwen2-7b")
+	require.NoError(t, err)
+	assert.Equal(t, "vllm", provider.Name)
+	assert.Contains(t, ListAvailableModels(), "llama-3-70b")
+}
+
+func TestAddProviderDiscoveryFailure(t *testing.T) {
+	Init(LiteLLMConfig{})
+	srv := selfHosted(t, `{"id":"llama-3-70b"}`)
+
+	// Without the auth header the endpoint refuses to list its models
+	err := AddProvider("ollama", ProviderConfig{BaseURL: srv.URL, DiscoverModels: true})
+	assert.ErrorIs(t, err, ErrModelDiscovery)
+	assert.NotContains(t, GetAllProviders(), "ollama")
+
+	// Listed models are served while discovery keeps being retried
+	err = AddProvider("ollama", ProviderConfig{BaseURL: srv.URL, DiscoverModels: true, ModelNames: []string{"llama3"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"llama3"}, GetAllProviders()["ollama"].Models())
+}
+
+func TestModelsMergesDiscovered(t *testing.T) {
+	config := ProviderConfig{ModelNames: []string{"llama3", "Mistral"}, DiscoveredModels: []string{"mistral", "phi3"}}
+	assert.Equal(t, []string{"llama3", "Mistral", "phi3"}, config.Models())
+}
+
+func TestHealthCheckURL(t *testing.T) {
+	tests := []struct {
+		config ProviderConfig
+		want   string
+	}{
+		{ProviderConfig{Name: "vllm", BaseURL: "http://vllm:8000", HealthCheckPath: "/health"}, "http://vllm:8000/health"},
+		{ProviderConfig{Name: "ollama", BaseURL: "http://ollama:11434", DiscoverModels: true}, "http://ollama:11434/v1/models"},
+		{ProviderConfig{Name: "ollama", BaseURL: "http://ollama:11434", HealthCheckURL: "http://probe/ready", HealthCheckPath: "/health"}, "http://probe/ready"},
+		{ProviderConfig{Name: "custom", BaseURL: "http://custom"}, "http://custom/ping"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.config.healthCheckURL())
+	}
+}
+
+func TestAddProviderRejectsRawAuthHeaderWhenPersisted(t *testing.T) {
+	Init(LiteLLMConfig{})
+	store = NewProviderStore(redis.NewClient(&redis.Options{Addr: "localhost:0"}))
+	defer func() { store = nil }()
+
+	err := AddProvider("vllm", ProviderConfig{
+		BaseURL:     "http://vllm:8000",
+		AuthHeaders: map[string]string{"X-API-Key": "cluster-key"},
+		ModelNames:  []string{"llama-3-70b"},
+	})
+	assert.ErrorIs(t, err, ErrRawAuthHeader)
+	assert.NotContains(t, GetAllProviders(), "vllm")
+}
+
+func TestResolveAuthHeaderSecrets(t *testing.T) {
+	Init(LiteLLMConfig{
+		Providers: map[string]ProviderConfig{
+			"vllm": {BaseURL: "http://vllm:8000", AuthHeaderSecrets: map[string]string{"X-API-Key": "llm/vllm/key"}, ModelNames: []string{"llama-3-70b"}},
+		},
+		ResolveSecret: func(key string) (string, error) { return "resolved:" + key, nil },
+	})
+	defer func() { resolveSecret = nil }()
+
+	assert.Equal(t, map[string]string{"X-API-Key": "resolved:llm/vllm/key"}, GetAllProviders()["vllm"].AuthHeaders)
+}
@@ -17,13 +17,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
-
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -35,50 +36,101 @@ var (
 	cacheMutex        = &sync.RWMutex{}
 	logger            = zerolog.New(os.Stdout).With().Timestamp().Str("service", "litellm-proxy").Logger()
 	grpcClients        = make(map[string]*grpc.ClientConn)
-	requestCache       = make(map[string]cacheEntry)
-	cacheTTL           = 5 * time.Minute
+	requestCache       = newResponseCache(5*time.Minute, DefaultCacheMaxEntries)
 	healthCheckInterval = 30 * time.Second
 )
 
-type cacheEntry struct {
-	response []byte
-	expires   time.Time
-}
-
 type ProviderConfig struct {
-	BaseURL        string
-	APIKey         string
-	ModelNames     []string
-	GRPCAddress     string // New field for gRPC address
-	UseGRPC        bool     // Flag to use gRPC instead of HTTP
-	MaxConcurrency  int      // Max concurrent requests
-	HealthCheckURL string  // Health check endpoint
-	IsHealthy       bool    // Health status
-	LastChecked     time.Time
-	Weight          int      // Load balancing weight
+	Name           string    `json:"name"` // Set from the provider's key in the config
+	BaseURL        string    `json:"base_url"`
+	APIKey         string    `json:"api_key,omitempty"`
+	APIKeySecret   string    `json:"api_key_secret,omitempty"` // secret-service key of the API key
+	ModelNames     []string  `json:"model_names"`
+	GRPCAddress    string    `json:"grpc_address,omitempty"`     // New field for gRPC address
+	UseGRPC        bool      `json:"use_grpc"`                   // Flag to use gRPC instead of HTTP
+	MaxConcurrency int       `json:"max_concurrency"`            // Max concurrent requests
+	HealthCheckURL string    `json:"health_check_url,omitempty"` // Health check endpoint
+	IsHealthy      bool      `json:"is_healthy"`                 // Health status
+	LastChecked    time.Time `json:"last_checked"`
+	Weight         int       `json:"weight"`                  // Load balancing weight
+	DisableCache   bool      `json:"disable_cache,omitempty"` // Never cache this provider's responses
+	Region         string    `json:"region,omitempty"`        // Where the provider processes requests, e.g. "eu"
+	SelfHosted     bool      `json:"self_hosted,omitempty"`   // Runs on our own infrastructure, not an external API
+	// Scheduled times the provider is not selected
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+	// Headers sent with every request, e.g. the auth header of a self-hosted
+	// endpoint; like API keys they are never persisted
+	AuthHeaders       map[string]string `json:"auth_headers,omitempty"`
+	AuthHeaderSecrets map[string]string `json:"auth_header_secrets,omitempty"` // secret-service key of each auth header
+	HealthCheckPath   string            `json:"health_check_path,omitempty"`   // Health check path on BaseURL
+	DiscoverModels    bool              `json:"discover_models,omitempty"`     // Also serve the models listed by BaseURL/v1/models
+	DiscoveredModels  []string          `json:"discovered_models,omitempty"`   // Models found by the last discovery
 }
 
 type LiteLLMConfig struct {
 	Providers map[string]ProviderConfig
 	CacheTTL  time.Duration
+	// Responses kept in the cache, DefaultCacheMaxEntries if unset
+	CacheMaxEntries int
+	Balancing       string // BalanceWeighted (default) or BalanceLeastConnections
+	// ModelClasses is the class of each model for cheapest_capable requests,
+	// DefaultModelClasses if nil
+	ModelClasses map[string]string
+	// Store persists providers and shares changes between replicas; without
+	// it providers only live in memory
+	Store *ProviderStore
+	// ResolveSecret returns the API key an APIKeySecret refers to
+	ResolveSecret func(key string) (string, error)
 }
 
 func Init(config LiteLLMConfig) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+	store = config.Store
+	resolveSecret = config.ResolveSecret
+
+	initial := config.Providers
+	if store != nil {
+		initial = loadProviders(config.Providers)
+	}
+	for name, provider := range initial {
+		initial[name] = withAPIKey(provider)
+	}
 
-	providerCache = config.Providers
+	cacheMutex.Lock()
+	providerCache = make(map[string]ProviderConfig, len(initial))
+	for name, provider := range initial {
+		provider.Name = name
+		// Set initial health status
+		provider.IsHealthy = true
+		provider.LastChecked = time.Now()
+		providerCache[name] = provider
+		if provider.DiscoverModels {
+			go refreshModels(name)
+		}
+	}
+	cacheTTL := 5 * time.Minute
 	if config.CacheTTL > 0 {
 		cacheTTL = config.CacheTTL
 	}
+	requestCache = newResponseCache(cacheTTL, config.CacheMaxEntries)
+	balancing = BalanceWeighted
+	if config.Balancing == BalanceLeastConnections {
+		balancing = BalanceLeastConnections
+	}
+	if config.ModelClasses != nil {
+		SetModelClasses(config.ModelClasses)
+	} else {
+		SetModelClasses(DefaultModelClasses)
+	}
 
-	logger.Info().Msgf("Initialized LiteLLM with %d providers", len(providerCache))
+	logger.Info().Str("balancing", balancing).Bool("persisted", store != nil).Msgf("Initialized LiteLLM with %d providers", len(providerCache))
 
 	// Initialize gRPC clients for providers that use gRPC
 	for provider, config := range providerCache {
 		if config.UseGRPC {
 			conn, err := grpc.Dial(config.GRPCAddress,
 				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				tracing.DialOption(),
+				requestid.DialOption(),
 				grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(1024*1024*100))) // 100MB max
 			if err != nil {
 				logger.Error().Str("provider", provider).Err(err).Msg("Failed to connect to gRPC server")
@@ -87,10 +139,12 @@ func Init(config LiteLLMConfig) {
 			grpcClients[provider] = conn
 			logger.Info().Str("provider", provider).Str("address", config.GRPCAddress).Msg("Connected to gRPC server")
 		}
+	}
+	cacheMutex.Unlock()
 
-		// Set initial health status
-		providerCache[provider].IsHealthy = true
-		providerCache[provider].LastChecked = time.Now()
+	// Apply changes made on other replicas
+	if store != nil {
+		go watchProviders(context.Background())
 	}
 
 	// Start health check goroutine
@@ -103,6 +157,7 @@ func healthCheckRoutine() {
 
 	for range ticker.C {
 		checkProviderHealth()
+		refreshAllModels()
 	}
 }
 
@@ -111,29 +166,16 @@ func checkProviderHealth() {
 	defer cacheMutex.Unlock()
 
 	for provider, config := range providerCache {
-		// Set default health check URL if not provided
-		healthCheckURL := config.HealthCheckURL
-		if healthCheckURL == "" {
-			// Default health check endpoints for common providers
-			switch {
-			case strings.Contains(provider, "openai"):
-				healthCheckURL = config.BaseURL + "/v1/models"
-			case strings.Contains(provider, "anthropic"):
-				healthCheckURL = config.BaseURL + "/health"
-			case strings.Contains(provider, "google"):
-				healthCheckURL = config.BaseURL + "/v1/health"
-			case strings.Contains(provider, "meta"):
-				healthCheckURL = config.BaseURL + "/status"
-			default:
-				// For custom providers, try a simple ping
-				healthCheckURL = config.BaseURL + "/ping"
-			}
-		}
+		healthCheckURL := config.healthCheckURL()
 
-		// Check if health check is needed
+		// Check if health check is needed; a provider in maintenance is
+		// expected to fail it
 		if time.Since(config.LastChecked) < healthCheckInterval {
 			continue
 		}
+		if _, ok := config.Maintenance(time.Now()); ok {
+			continue
+		}
 
 		// Perform health check
 		client := &http.Client{Timeout: 5 * time.Second}
@@ -145,10 +187,7 @@ func checkProviderHealth() {
 			continue
 		}
 
-		// Add auth header if API key is available
-		if config.APIKey != "" {
-			req.Header.Set("Authorization", "Bearer "+config.APIKey)
-		}
+		setAuth(req, config)
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -174,108 +213,66 @@ func checkProviderHealth() {
 	}
 }
 
-func GetProviderForModel(model string) (ProviderConfig, error) {
-	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
+// ProxyRequest sends a request to the provider. ctx carries the request ID and
+// trace. A cacheable call is not cancelled with it, so a response that
+// arrives late still fills the cache; other calls are, so a client that went
+// away or a hedge that lost frees the provider.
+func ProxyRequest(ctx context.Context, providerConfig ProviderConfig, method, path string, body interface{}) ([]byte, error) {
+	response, _, err := ProxyRequestCached(ctx, providerConfig, method, path, body)
+	return response, err
+}
 
-	// Filter to healthy providers first
-	var healthyProviders []ProviderConfig
-	for provider, config := range providerCache {
-		if config.IsHealthy {
-			healthyProviders = append(healthyProviders, config)
+// ProxyRequestCached is ProxyRequest that also reports whether the response
+// came from the cache
+func ProxyRequestCached(ctx context.Context, providerConfig ProviderConfig, method, path string, body interface{}) (response []byte, cacheHit bool, err error) {
+	// Check cache first
+	var key string
+	if !providerConfig.DisableCache && isCacheable(method, path, body) {
+		key, err = cacheKey(providerConfig, method, path, body)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to marshal request body: %w", err)
 		}
-	}
-
-	// If no healthy providers, try all
-	if len(healthyProviders) == 0 {
-		healthyProviders = make([]ProviderConfig, 0, len(providerCache))
-		for _, config := range providerCache {
-			healthyProviders = append(healthyProviders, config)
+		if cached, found := requestCache.get(key); found {
+			return cached, true, nil
 		}
 	}
 
-	// Find provider for model with load balancing
-	var matchingProviders []ProviderConfig
-	for _, config := range healthyProviders {
-		for _, modelName := range config.ModelNames {
-			if strings.EqualFold(model, modelName) {
-				matchingProviders = append(matchingProviders, config)
-			}
-		}
-	}
-
-	// If no matching providers found, return error
-	if len(matchingProviders) == 0 {
-		return ProviderConfig{}, errors.New("no provider found for model")
+	callCtx := ctx
+	if key != "" {
+		callCtx = context.WithoutCancel(ctx)
 	}
 
-	// If multiple providers support the model, choose based on weight (load balancing)
-	if len(matchingProviders) == 1 {
-		return matchingProviders[0], nil
+	// Use gRPC if configured, otherwise fall back to HTTP
+	if providerConfig.UseGRPC {
+		response, err = proxyGRPCRequest(callCtx, providerConfig, body)
+	} else {
+		response, err = proxyHTTPRequest(callCtx, providerConfig, method, path, body)
 	}
+	observeProviderResult(ctx, providerConfig.Name, err)
 
-	// Calculate total weight
-	totalWeight := 0
-	for _, provider := range matchingProviders {
-		if provider.Weight == 0 {
-			provider.Weight = 1 // Default weight if not set
-		}
-		totalWeight += provider.Weight
+	if err != nil {
+		return nil, false, err
 	}
 
-	// Random selection based on weights
-	// Initialize random seed once
-	rand.Seed(time.Now().UnixNano())
-	randomValue := rand.Intn(totalWeight)
-
-	// Select provider based on weight
-	currentWeight := 0
-	for _, provider := range matchingProviders {
-		currentWeight += provider.Weight
-		if randomValue < currentWeight {
-			return provider, nil
-		}
+	if key != "" {
+		requestCache.set(key, response)
 	}
 
-	// Fallback: return the first provider if something goes wrong with weight calculation
-	return matchingProviders[0], nil
+	return response, false, nil
 }
 
-func ProxyRequest(providerConfig ProviderConfig, method, path string, body interface{}) ([]byte, error) {
-	// Check cache first
-	cacheKey := fmt.Sprintf("%s:%s:%v", providerConfig.BaseURL, path, body)
-	cacheMutex.RLock()
-	cached, found := requestCache[cacheKey]
-	cacheMutex.RUnlock()
-
-	if found && time.Now().Before(cached.expires) {
-		return cached.response, nil
-	}
-
-	// Use gRPC if configured, otherwise fall back to HTTP
-	var response []byte
-	var err error
-	if providerConfig.UseGRPC {
-		response, err = proxyGRPCRequest(providerConfig, body)
-	} else {
-		response, err = proxyHTTPRequest(providerConfig, method, path, body)
-	}
-
+// observeProviderResult counts a provider request for its error rate. A
+// request we cancelled, e.g. the loser of a hedge, is not held against the
+// provider.
+func observeProviderResult(ctx context.Context, provider string, err error) {
+	result := "success"
 	if err != nil {
-		return nil, err
-	}
-
-	// Cache the response if cacheable
-	if isCacheable(method, path, body) {
-		cacheMutex.Lock()
-		requestCache[cacheKey] = cacheEntry{
-			response: response,
-			expires:   time.Now().Add(cacheTTL),
+		result = "error"
+		if ctx.Err() != nil {
+			result = "cancelled"
 		}
-		cacheMutex.Unlock()
 	}
-
-	return response, nil
+	providerRequests.WithLabelValues(provider, result).Inc()
 }
 
 func isCacheable(method, path string, body interface{}) bool {
@@ -283,7 +280,7 @@ func isCacheable(method, path string, body interface{}) bool {
 	return method == "GET" || method == ""
 }
 
-func proxyHTTPRequest(providerConfig ProviderConfig, method, path string, body interface{}) ([]byte, error) {
+func proxyHTTPRequest(ctx context.Context, providerConfig ProviderConfig, method, path string, body interface{}) ([]byte, error) {
 	url := providerConfig.BaseURL + path
 
 	// Create request
@@ -292,17 +289,18 @@ func proxyHTTPRequest(providerConfig ProviderConfig, method, path string, body i
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	req, err := http.NewRequest(method, url, strings.NewReader(string(reqBody)))
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(string(reqBody)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestid.Header, requestid.FromContext(ctx))
 
 	// Use the API key from provider config
 	// Note: This will be overridden by user-specific key in the handler if available
-	req.Header.Set("Authorization", "Bearer "+providerConfig.APIKey)
+	setAuth(req, providerConfig)
 
 	// Execute request
 	client := &http.Client{Timeout: 180 * time.Second}
@@ -325,7 +323,7 @@ func proxyHTTPRequest(providerConfig ProviderConfig, method, path string, body i
 	return respBody, nil
 }
 
-func proxyGRPCRequest(providerConfig ProviderConfig, body interface{}) ([]byte, error) {
+func proxyGRPCRequest(ctx context.Context, providerConfig ProviderConfig, body interface{}) ([]byte, error) {
 	// Find the provider in cache to get the gRPC client
 	cacheMutex.RLock()
 	client, ok := grpcClients[providerConfig.BaseURL] // Using BaseURL as key for now
@@ -349,7 +347,7 @@ func proxyGRPCRequest(providerConfig ProviderConfig, body interface{}) ([]byte,
 
 	// Create gRPC request
 	grpcClient := pb.NewModelServiceClient(client)
-	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 180*time.Second)
 	defer cancel()
 
 	// Convert messages to gRPC format
@@ -368,13 +366,15 @@ func proxyGRPCRequest(providerConfig ProviderConfig, body interface{}) ([]byte,
 	}
 
 	// Create and send gRPC request
-	resp, err := grpcClient.Generate(ctx, &pb.GenRequest{
+	genReq := &pb.GenRequest{
 		Model:       reqData["model"].(string),
 		Messages:    messages,
 		Temperature:  float32(reqData["temperature"].(float64)),
 		MaxTokens:   int32(reqData["max_tokens"].(float64)),
-		RequestId:  "gateway-" + time.Now().Format("20060102-150405"),
-	})
+		RequestId:  requestid.FromContext(ctx),
+	}
+	setSampling(genReq, reqData)
+	resp, err := grpcClient.Generate(ctx, genReq)
 	if err != nil {
 		return nil, fmt.Errorf("gRPC request failed: %w", err)
 	}
@@ -390,28 +390,117 @@ func proxyGRPCRequest(providerConfig ProviderConfig, body interface{}) ([]byte,
 	return json.Marshal(response)
 }
 
+// setSampling copies the OpenAI sampling parameters of a request body to the
+// gRPC request, so gRPC providers apply them like HTTP ones
+func setSampling(genReq *pb.GenRequest, reqData map[string]interface{}) {
+	number := func(key string) float32 {
+		v, _ := reqData[key].(float64)
+		return float32(v)
+	}
+	genReq.TopP = number("top_p")
+	genReq.FrequencyPenalty = number("frequency_penalty")
+	genReq.PresencePenalty = number("presence_penalty")
+	genReq.TopLogprobs = int32(number("top_logprobs"))
+	genReq.Logprobs, _ = reqData["logprobs"].(bool)
+	if seed, ok := reqData["seed"].(float64); ok {
+		s := int64(seed)
+		genReq.Seed = &s
+	}
+	if stops, ok := reqData["stop"].([]interface{}); ok {
+		for _, stop := range stops {
+			if s, ok := stop.(string); ok {
+				genReq.Stop = append(genReq.Stop, s)
+			}
+		}
+	}
+}
+
 func ListAvailableModels() []string {
 	cacheMutex.RLock()
 	defer cacheMutex.RUnlock()
 
 	var models []string
 	for _, config := range providerCache {
-		models = append(models, config.ModelNames...)
+		models = append(models, config.Models()...)
 	}
 	return models
 }
 
-func AddProvider(provider string, config ProviderConfig) {
+// AddProvider adds or replaces a provider. With a store the provider is
+// persisted first and every other replica picks it up; its API key and auth
+// headers must then come from APIKeySecret and AuthHeaderSecrets, since raw
+// keys are never persisted. A provider that discovers its models is only
+// added without ModelNames if they could be discovered.
+func AddProvider(provider string, config ProviderConfig) error {
+	config.Name = provider
+	if store != nil {
+		if err := rawCredentials(config); err != nil {
+			return err
+		}
+	}
+
+	config.DiscoveredModels = nil
+	if config.DiscoverModels {
+		models, err := discoverModels(context.Background(), withAPIKey(config))
+		if err != nil && len(config.ModelNames) == 0 {
+			return fmt.Errorf("%w: %v", ErrModelDiscovery, err)
+		}
+		if err != nil {
+			logger.Warn().Str("provider", provider).Err(err).Msg("Model discovery failed")
+		}
+		config.DiscoveredModels = models
+	}
+
+	if store != nil {
+		if err := store.Save(context.Background(), config); err != nil {
+			return err
+		}
+	}
+
+	setProvider(withAPIKey(config))
+	logger.Info().Str("provider", provider).Msg("Added new provider")
+	return nil
+}
+
+// RemoveProvider removes a provider, from the store and every replica as well
+// when persistence is enabled
+func RemoveProvider(provider string) error {
+	if store != nil {
+		if err := store.Delete(context.Background(), provider); err != nil {
+			return err
+		}
+	}
+
+	deleteProvider(provider)
+	logger.Info().Str("provider", provider).Msg("Removed provider")
+	return nil
+}
+
+// setProvider replaces the provider in memory and reconnects its gRPC client
+func setProvider(config ProviderConfig) {
 	cacheMutex.Lock()
 	defer cacheMutex.Unlock()
 
+	provider := config.Name
+	// Set initial health status
+	config.IsHealthy = true
+	config.LastChecked = time.Now()
 	providerCache[provider] = config
-	logger.Info().Str("provider", provider).Msg("Added new provider")
+	if config.DiscoverModels && len(config.DiscoveredModels) == 0 {
+		go refreshModels(provider)
+	}
+
+	if conn, ok := grpcClients[provider]; ok {
+		conn.Close()
+		delete(grpcClients, provider)
+	}
 
 	// Initialize gRPC client if needed
 	if config.UseGRPC {
 		conn, err := grpc.Dial(config.GRPCAddress,
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			tracing.DialOption(),
+			requestid.DialOption(),
 			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(1024*1024*100)))
 		if err != nil {
 			logger.Error().Str("provider", provider).Err(err).Msg("Failed to connect to gRPC server")
@@ -420,13 +509,9 @@ func AddProvider(provider string, config ProviderConfig) {
 		grpcClients[provider] = conn
 		logger.Info().Str("provider", provider).Str("address", config.GRPCAddress).Msg("Connected to gRPC server")
 	}
-
-	// Set initial health status
-	providerCache[provider].IsHealthy = true
-	providerCache[provider].LastChecked = time.Now()
 }
 
-func RemoveProvider(provider string) {
+func deleteProvider(provider string) {
 	cacheMutex.Lock()
 	defer cacheMutex.Unlock()
 
@@ -437,7 +522,38 @@ func RemoveProvider(provider string) {
 	}
 
 	delete(providerCache, provider)
-	logger.Info().Str("provider", provider).Msg("Removed provider")
+}
+
+// withAPIKey resolves the provider's APIKeySecret into APIKey and its
+// AuthHeaderSecrets into AuthHeaders
+func withAPIKey(config ProviderConfig) ProviderConfig {
+	if resolveSecret == nil {
+		return config
+	}
+	if config.APIKeySecret != "" {
+		key, err := resolveSecret(config.APIKeySecret)
+		if err != nil {
+			logger.Error().Str("provider", config.Name).Str("secret", config.APIKeySecret).Err(err).Msg("Failed to resolve provider API key")
+		} else {
+			config.APIKey = key
+		}
+	}
+	if len(config.AuthHeaderSecrets) > 0 {
+		headers := make(map[string]string, len(config.AuthHeaders)+len(config.AuthHeaderSecrets))
+		for name, value := range config.AuthHeaders {
+			headers[name] = value
+		}
+		for name, secret := range config.AuthHeaderSecrets {
+			value, err := resolveSecret(secret)
+			if err != nil {
+				logger.Error().Str("provider", config.Name).Str("secret", secret).Err(err).Msg("Failed to resolve provider auth header")
+				continue
+			}
+			headers[name] = value
+		}
+		config.AuthHeaders = headers
+	}
+	return config
 }
 
 func GetAllProviders() map[string]ProviderConfig {
@@ -464,26 +580,50 @@ func CloseAllConnections() {
 }
 
 func ClearCache() {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-
-	requestCache = make(map[string]cacheEntry)
+	requestCache.clear()
 	logger.Info().Msg("Cleared request cache")
 }
 
-func GetCacheStats() map[string]interface{} {
+// SetProviderCache enables or disables response caching for a provider
+func SetProviderCache(provider string, enabled bool) error {
 	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
-
-	return map[string]interface{}{
-		"cache_size":     len(requestCache),
-		"cache_ttl":      cacheTTL.String(),
-		"oldest_entry":   time.Since(time.Now().Add(-cacheTTL)).String(),
+	config, ok := providerCache[provider]
+	cacheMutex.RUnlock()
+	if !ok {
+		return ErrUnknownProvider
 	}
-}
 
+	config.DisableCache = !enabled
+	if store != nil {
+		if err := store.Save(context.Background(), config); err != nil {
+			return err
+		}
+	}
 
+	cacheMutex.Lock()
+	if current, ok := providerCache[provider]; ok {
+		current.DisableCache = !enabled
+		providerCache[provider] = current
+	}
+	cacheMutex.Unlock()
 
+	logger.Info().Str("provider", provider).Bool("enabled", enabled).Msg("Changed provider response caching")
+	return nil
+}
 
+func GetCacheStats() map[string]interface{} {
+	stats := requestCache.stats()
 
+	cacheMutex.RLock()
+	disabled := []string{}
+	for name, config := range providerCache {
+		if config.DisableCache {
+			disabled = append(disabled, name)
+		}
+	}
+	cacheMutex.RUnlock()
+	sort.Strings(disabled)
+	stats["disabled_providers"] = disabled
 
+	return stats
+}
@@ -13,6 +13,7 @@
 package providers
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -149,7 +150,7 @@ func TestProxyRequest(t *testing.T) {
 	// This would normally make an HTTP request, but we're not testing that here
 	// as it would require a mock server
 	assert.NotPanics(t, func() {
-		_, _ = ProxyRequest(config, "GET", "/test", nil)
+		_, _ = ProxyRequest(context.Background(), config, "GET", "/test", nil)
 	})
 }
 
@@ -166,7 +167,7 @@ func TestGRPCProxyRequest(t *testing.T) {
 	// This would normally make a gRPC request, but we're not testing that here
 	// as it would require a mock server
 	assert.NotPanics(t, func() {
-		_, _ = ProxyRequest(config, "GET", "/test", map[string]interface{}{
+		_, _ = ProxyRequest(context.Background(), config, "GET", "/test", map[string]interface{}{
 			"model": "local-model",
 			"messages": []map[string]string{
 				{"role": "user", "content": "test"},
@@ -0,0 +1,165 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Provider statuses, as listed by the API and in gateway_provider_status
+const (
+	StatusHealthy     = "healthy"
+	StatusUnhealthy   = "unhealthy"
+	StatusMaintenance = "maintenance"
+)
+
+// Recurrences of a maintenance window
+const (
+	RecurDaily  = "daily"
+	RecurWeekly = "weekly"
+)
+
+// ErrInMaintenance means every provider of the model is in a maintenance
+// window
+var ErrInMaintenance = errors.New("all providers for model are in maintenance")
+
+// MaintenanceWindow takes a provider out of selection from Start to End. A
+// recurring window repeats every day or week after Start, in fixed 24h
+// steps, so it follows UTC rather than daylight saving time.
+type MaintenanceWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Recurrence is RecurDaily, RecurWeekly or empty for a single window
+	Recurrence string `json:"recurrence,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+func (w MaintenanceWindow) period() time.Duration {
+	switch w.Recurrence {
+	case RecurDaily:
+		return 24 * time.Hour
+	case RecurWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// Validate checks that the window ends after it starts and, when recurring,
+// is shorter than its period
+func (w MaintenanceWindow) Validate() error {
+	if w.Start.IsZero() || !w.End.After(w.Start) {
+		return errors.New("maintenance window must end after it starts")
+	}
+	if w.Recurrence != "" && w.period() == 0 {
+		return fmt.Errorf("unknown recurrence %q, must be %s or %s", w.Recurrence, RecurDaily, RecurWeekly)
+	}
+	if period := w.period(); period > 0 && w.End.Sub(w.Start) >= period {
+		return fmt.Errorf("a %s maintenance window must be shorter than its period", w.Recurrence)
+	}
+	return nil
+}
+
+// Active reports whether now is within the window or one of its recurrences
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	if now.Before(w.Start) {
+		return false
+	}
+	elapsed := now.Sub(w.Start)
+	if period := w.period(); period > 0 {
+		elapsed %= period
+	}
+	return elapsed < w.End.Sub(w.Start)
+}
+
+// Maintenance returns the window the provider is in at now, if any
+func (c ProviderConfig) Maintenance(now time.Time) (MaintenanceWindow, bool) {
+	for _, w := range c.MaintenanceWindows {
+		if w.Active(now) {
+			return w, true
+		}
+	}
+	return MaintenanceWindow{}, false
+}
+
+// Status returns StatusMaintenance during a maintenance window, otherwise
+// the result of the last health check
+func (c ProviderConfig) Status(now time.Time) string {
+	if _, ok := c.Maintenance(now); ok {
+		return StatusMaintenance
+	}
+	if c.IsHealthy {
+		return StatusHealthy
+	}
+	return StatusUnhealthy
+}
+
+// SetMaintenanceWindows replaces the maintenance windows of a provider, on
+// every replica when persistence is enabled
+func SetMaintenanceWindows(provider string, windows []MaintenanceWindow) error {
+	for _, w := range windows {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+
+	cacheMutex.RLock()
+	config, ok := providerCache[provider]
+	cacheMutex.RUnlock()
+	if !ok {
+		return ErrUnknownProvider
+	}
+
+	config.MaintenanceWindows = windows
+	if store != nil {
+		if err := store.Save(context.Background(), config); err != nil {
+			return err
+		}
+	}
+
+	cacheMutex.Lock()
+	if current, ok := providerCache[provider]; ok {
+		current.MaintenanceWindows = windows
+		providerCache[provider] = current
+	}
+	cacheMutex.Unlock()
+
+	logger.Info().Str("provider", provider).Int("windows", len(windows)).Msg("Changed provider maintenance windows")
+	return nil
+}
+
+// statusCollector reports each provider's status when scraped, so windows
+// starting and ending show up without anything updating a gauge
+type statusCollector struct {
+	desc *prometheus.Desc
+}
+
+var providerStatus = statusCollector{prometheus.NewDesc(
+	"gateway_provider_status",
+	"Status of each provider: 1 for its current status (healthy, unhealthy, maintenance), 0 for the others",
+	[]string{"provider", "status"}, nil,
+)}
+
+func init() {
+	prometheus.MustRegister(providerStatus)
+}
+
+func (c statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c statusCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for name, config := range GetAllProviders() {
+		current := config.Status(now)
+		for _, status := range []string{StatusHealthy, StatusUnhealthy, StatusMaintenance} {
+			value := 0.0
+			if status == current {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, value, name, status)
+		}
+	}
+}
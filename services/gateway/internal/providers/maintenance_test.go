@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	start := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	once := MaintenanceWindow{Start: start, End: start.Add(time.Hour)}
+	assert.False(t, once.Active(start.Add(-time.Minute)))
+	assert.True(t, once.Active(start))
+	assert.True(t, once.Active(start.Add(59*time.Minute)))
+	assert.False(t, once.Active(start.Add(time.Hour)))
+	assert.False(t, once.Active(start.Add(24*time.Hour)))
+
+	daily := MaintenanceWindow{Start: start, End: start.Add(time.Hour), Recurrence: RecurDaily}
+	assert.True(t, daily.Active(start.Add(3*24*time.Hour+30*time.Minute)))
+	assert.False(t, daily.Active(start.Add(3*24*time.Hour+90*time.Minute)))
+
+	weekly := MaintenanceWindow{Start: start, End: start.Add(2 * time.Hour), Recurrence: RecurWeekly}
+	assert.True(t, weekly.Active(start.Add(14*24*time.Hour+time.Hour)))
+	assert.False(t, weekly.Active(start.Add(24*time.Hour+time.Hour)))
+}
+
+func TestMaintenanceWindowValidate(t *testing.T) {
+	start := time.Date(2024, 5, 1, 2, 0, 0, 0, time.UTC)
+	assert.NoError(t, MaintenanceWindow{Start: start, End: start.Add(time.Hour), Recurrence: RecurDaily}.Validate())
+	assert.Error(t, MaintenanceWindow{Start: start, End: start}.Validate())
+	assert.Error(t, MaintenanceWindow{End: start}.Validate())
+	assert.Error(t, MaintenanceWindow{Start: start, End: start.Add(time.Hour), Recurrence: "monthly"}.Validate())
+	assert.Error(t, MaintenanceWindow{Start: start, End: start.Add(25 * time.Hour), Recurrence: RecurDaily}.Validate())
+}
+
+func TestProvidersInMaintenanceSkipped(t *testing.T) {
+	initBalancerTest(BalanceWeighted)
+	now := time.Now()
+	window := MaintenanceWindow{Start: now.Add(-time.Minute), End: now.Add(time.Hour), Reason: "upgrade"}
+
+	require.NoError(t, SetMaintenanceWindows("primary", []MaintenanceWindow{window}))
+	assert.Equal(t, StatusMaintenance, GetAllProviders()["primary"].Status(now))
+	for i := 0; i < 20; i++ {
+		config, err := GetProviderForModel("gpt-4")
+		require.NoError(t, err)
+		assert.Equal(t, "secondary", config.Name)
+	}
+
+	require.NoError(t, SetMaintenanceWindows("secondary", []MaintenanceWindow{window}))
+	_, err := GetProviderForModel("gpt-4")
+	assert.ErrorIs(t, err, ErrInMaintenance)
+
+	// Models without providers in maintenance are unaffected
+	_, err = GetProviderForModel("llama-3")
+	assert.ErrorIs(t, err, ErrNoProvider)
+
+	require.NoError(t, SetMaintenanceWindows("primary", nil))
+	config, err := GetProviderForModel("gpt-4")
+	require.NoError(t, err)
+	assert.Equal(t, "primary", config.Name)
+	assert.Equal(t, StatusHealthy, config.Status(now))
+
+	assert.ErrorIs(t, SetMaintenanceWindows("missing", nil), ErrUnknownProvider)
+	assert.Error(t, SetMaintenanceWindows("primary", []MaintenanceWindow{{Start: now, End: now}}))
+}
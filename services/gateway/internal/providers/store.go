@@ -0,0 +1,319 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Providers are persisted in a Redis hash, one JSON entry per provider, so
+// changes made through the API survive restarts. Every change is published on
+// a channel and applied by all gateway replicas; a periodic resync covers
+// messages missed while a replica was disconnected. API keys are never
+// persisted: providers carry APIKeySecret, a secret-service key resolved into
+// APIKey on each replica.
+const (
+	providersKey          = "gateway:providers"
+	providersChannel      = "gateway:providers:changes"
+	providersSyncInterval = 5 * time.Minute
+)
+
+// ErrRawAPIKey is returned when adding a provider whose API key would have to
+// be persisted in clear
+var ErrRawAPIKey = errors.New("api_key is not persisted; store it in secret-service and pass api_key_secret")
+
+// ErrRawAuthHeader is returned when adding a provider whose auth headers
+// would have to be persisted in clear
+var ErrRawAuthHeader = errors.New("auth_headers are not persisted; store them in secret-service and pass auth_header_secrets")
+
+// ErrUnknownProvider is returned when changing a provider that does not exist
+var ErrUnknownProvider = errors.New("provider not found")
+
+// rawCredentials returns ErrRawAPIKey or ErrRawAuthHeader when the provider
+// has a credential that is not in secret-service
+func rawCredentials(config ProviderConfig) error {
+	if config.APIKey != "" && config.APIKeySecret == "" {
+		return ErrRawAPIKey
+	}
+	for name := range config.AuthHeaders {
+		if _, ok := config.AuthHeaderSecrets[name]; !ok {
+			return ErrRawAuthHeader
+		}
+	}
+	return nil
+}
+
+var (
+	store         *ProviderStore
+	resolveSecret func(key string) (string, error)
+)
+
+// ProviderStore persists provider configs and broadcasts changes
+type ProviderStore struct {
+	client *redis.Client
+	// Identifies this replica so it ignores its own change messages
+	origin string
+}
+
+// NewProviderStore creates a store on the given Redis client
+func NewProviderStore(client *redis.Client) *ProviderStore {
+	host, _ := os.Hostname()
+	return &ProviderStore{
+		client: client,
+		origin: fmt.Sprintf("%s-%d", host, time.Now().UnixNano()),
+	}
+}
+
+// storedProvider is the persisted form of a ProviderConfig: no API key or
+// auth headers, no health state and no discovered models
+type storedProvider struct {
+	BaseURL        string   `json:"base_url"`
+	APIKeySecret   string   `json:"api_key_secret,omitempty"`
+	ModelNames     []string `json:"model_names"`
+	GRPCAddress    string   `json:"grpc_address,omitempty"`
+	UseGRPC        bool     `json:"use_grpc,omitempty"`
+	MaxConcurrency int      `json:"max_concurrency,omitempty"`
+	HealthCheckURL string   `json:"health_check_url,omitempty"`
+	Weight         int      `json:"weight,omitempty"`
+	DisableCache   bool     `json:"disable_cache,omitempty"`
+	Region         string   `json:"region,omitempty"`
+	SelfHosted     bool     `json:"self_hosted,omitempty"`
+
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+	AuthHeaderSecrets  map[string]string   `json:"auth_header_secrets,omitempty"`
+	HealthCheckPath    string              `json:"health_check_path,omitempty"`
+	DiscoverModels     bool                `json:"discover_models,omitempty"`
+}
+
+type providerChange struct {
+	Provider string `json:"provider"`
+	Deleted  bool   `json:"deleted,omitempty"`
+	Origin   string `json:"origin"`
+}
+
+func toStored(config ProviderConfig) storedProvider {
+	return storedProvider{
+		BaseURL:        config.BaseURL,
+		APIKeySecret:   config.APIKeySecret,
+		ModelNames:     config.ModelNames,
+		GRPCAddress:    config.GRPCAddress,
+		UseGRPC:        config.UseGRPC,
+		MaxConcurrency: config.MaxConcurrency,
+		HealthCheckURL: config.HealthCheckURL,
+		Weight:         config.Weight,
+		DisableCache:   config.DisableCache,
+		Region:         config.Region,
+		SelfHosted:     config.SelfHosted,
+
+		MaintenanceWindows: config.MaintenanceWindows,
+		AuthHeaderSecrets:  config.AuthHeaderSecrets,
+		HealthCheckPath:    config.HealthCheckPath,
+		DiscoverModels:     config.DiscoverModels,
+	}
+}
+
+func fromStored(name string, s storedProvider) ProviderConfig {
+	return ProviderConfig{
+		Name:           name,
+		BaseURL:        s.BaseURL,
+		APIKeySecret:   s.APIKeySecret,
+		ModelNames:     s.ModelNames,
+		GRPCAddress:    s.GRPCAddress,
+		UseGRPC:        s.UseGRPC,
+		MaxConcurrency: s.MaxConcurrency,
+		HealthCheckURL: s.HealthCheckURL,
+		Weight:         s.Weight,
+		DisableCache:   s.DisableCache,
+		Region:         s.Region,
+		SelfHosted:     s.SelfHosted,
+
+		MaintenanceWindows: s.MaintenanceWindows,
+		AuthHeaderSecrets:  s.AuthHeaderSecrets,
+		HealthCheckPath:    s.HealthCheckPath,
+		DiscoverModels:     s.DiscoverModels,
+	}
+}
+
+// Load returns every persisted provider
+func (s *ProviderStore) Load(ctx context.Context) (map[string]ProviderConfig, error) {
+	entries, err := s.client.HGetAll(ctx, providersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load providers: %w", err)
+	}
+
+	result := make(map[string]ProviderConfig, len(entries))
+	for name, data := range entries {
+		var stored storedProvider
+		if err := json.Unmarshal([]byte(data), &stored); err != nil {
+			logger.Error().Str("provider", name).Err(err).Msg("Skipping malformed persisted provider")
+			continue
+		}
+		result[name] = fromStored(name, stored)
+	}
+	return result, nil
+}
+
+// Save persists a provider and notifies the other replicas
+func (s *ProviderStore) Save(ctx context.Context, config ProviderConfig) error {
+	data, err := json.Marshal(toStored(config))
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, providersKey, config.Name, data).Err(); err != nil {
+		return fmt.Errorf("failed to persist provider: %w", err)
+	}
+	return s.publish(ctx, providerChange{Provider: config.Name})
+}
+
+// Delete removes a persisted provider and notifies the other replicas
+func (s *ProviderStore) Delete(ctx context.Context, name string) error {
+	if err := s.client.HDel(ctx, providersKey, name).Err(); err != nil {
+		return fmt.Errorf("failed to delete provider: %w", err)
+	}
+	return s.publish(ctx, providerChange{Provider: name, Deleted: true})
+}
+
+func (s *ProviderStore) publish(ctx context.Context, change providerChange) error {
+	change.Origin = s.origin
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	// The change is persisted: replicas that miss it catch up on resync
+	if err := s.client.Publish(ctx, providersChannel, data).Err(); err != nil {
+		logger.Warn().Str("provider", change.Provider).Err(err).Msg("Failed to broadcast provider change")
+	}
+	return nil
+}
+
+// loadProviders returns the persisted providers. On first start the store is
+// seeded with the configured ones; if it cannot be read they are used as is.
+func loadProviders(configured map[string]ProviderConfig) map[string]ProviderConfig {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	persisted, err := store.Load(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Using configured providers")
+		return configured
+	}
+	if len(persisted) > 0 {
+		return persisted
+	}
+
+	for name, config := range configured {
+		if err := rawCredentials(config); err != nil {
+			logger.Warn().Str("provider", name).Msg("Not persisting provider configured with a raw API key or auth header")
+			continue
+		}
+		data, err := json.Marshal(toStored(config))
+		if err != nil {
+			continue
+		}
+		// Replicas starting together seed the same providers
+		if err := store.client.HSetNX(ctx, providersKey, name, data).Err(); err != nil {
+			logger.Error().Str("provider", name).Err(err).Msg("Failed to seed provider")
+		}
+	}
+	return configured
+}
+
+// watchProviders applies changes published by other replicas until ctx is
+// cancelled
+func watchProviders(ctx context.Context) {
+	pubsub := store.client.Subscribe(ctx, providersChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+
+	ticker := time.NewTicker(providersSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var change providerChange
+			if err := json.Unmarshal([]byte(msg.Payload), &change); err != nil {
+				logger.Warn().Err(err).Msg("Ignoring malformed provider change")
+				continue
+			}
+			if change.Origin != store.origin {
+				applyChange(ctx, change)
+			}
+		case <-ticker.C:
+			syncProviders(ctx)
+		}
+	}
+}
+
+func applyChange(ctx context.Context, change providerChange) {
+	if change.Deleted {
+		deleteProvider(change.Provider)
+		logger.Info().Str("provider", change.Provider).Msg("Provider removed on another replica")
+		return
+	}
+
+	data, err := store.client.HGet(ctx, providersKey, change.Provider).Result()
+	if err == redis.Nil {
+		deleteProvider(change.Provider)
+		return
+	}
+	if err != nil {
+		logger.Error().Str("provider", change.Provider).Err(err).Msg("Failed to load changed provider")
+		return
+	}
+	var stored storedProvider
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		logger.Error().Str("provider", change.Provider).Err(err).Msg("Malformed persisted provider")
+		return
+	}
+	if updateProvider(fromStored(change.Provider, stored)) {
+		logger.Info().Str("provider", change.Provider).Msg("Provider updated on another replica")
+	}
+}
+
+// syncProviders makes the in-memory providers match the store
+func syncProviders(ctx context.Context) {
+	persisted, err := store.Load(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Provider resync failed")
+		return
+	}
+	// An empty store was never seeded, e.g. Redis was down at startup
+	if len(persisted) == 0 {
+		return
+	}
+
+	for _, config := range persisted {
+		updateProvider(config)
+	}
+	for name := range GetAllProviders() {
+		if _, ok := persisted[name]; !ok {
+			deleteProvider(name)
+		}
+	}
+}
+
+// updateProvider applies a persisted provider unless it is unchanged, so
+// health state and gRPC connections survive resyncs
+func updateProvider(config ProviderConfig) bool {
+	cacheMutex.RLock()
+	current, ok := providerCache[config.Name]
+	cacheMutex.RUnlock()
+	if ok && reflect.DeepEqual(toStored(current), toStored(config)) {
+		return false
+	}
+
+	setProvider(withAPIKey(config))
+	return true
+}
@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredProviderHasNoAPIKey(t *testing.T) {
+	config := ProviderConfig{
+		Name:         "openai",
+		BaseURL:      "https://api.openai.com",
+		APIKey:       "sk-secret",
+		APIKeySecret: "llm/openai/api_key",
+		ModelNames:   []string{"gpt-4"},
+		IsHealthy:    true,
+		Weight:       2,
+	}
+
+	data, err := json.Marshal(toStored(config))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "sk-secret")
+
+	var stored storedProvider
+	require.NoError(t, json.Unmarshal(data, &stored))
+	restored := fromStored("openai", stored)
+	assert.Empty(t, restored.APIKey)
+	assert.Equal(t, "llm/openai/api_key", restored.APIKeySecret)
+	assert.Equal(t, config.ModelNames, restored.ModelNames)
+	assert.Equal(t, 2, restored.Weight)
+}
+
+func TestAddProviderRejectsRawAPIKeyWhenPersisted(t *testing.T) {
+	Init(LiteLLMConfig{})
+	// The key check happens before Redis is reached
+	store = NewProviderStore(redis.NewClient(&redis.Options{Addr: "localhost:0"}))
+	defer func() { store = nil }()
+
+	err := AddProvider("custom", ProviderConfig{BaseURL: "https://custom", APIKey: "sk-raw", ModelNames: []string{"m"}})
+	assert.ErrorIs(t, err, ErrRawAPIKey)
+	assert.NotContains(t, GetAllProviders(), "custom")
+}
+
+func TestResolveAPIKeySecret(t *testing.T) {
+	Init(LiteLLMConfig{
+		Providers: map[string]ProviderConfig{
+			"openai": {BaseURL: "https://api.openai.com", APIKeySecret: "llm/openai/api_key", ModelNames: []string{"gpt-4"}},
+		},
+		ResolveSecret: func(key string) (string, error) { return "resolved:" + key, nil },
+	})
+	defer func() { resolveSecret = nil }()
+
+	assert.Equal(t, "resolved:llm/openai/api_key", GetAllProviders()["openai"].APIKey)
+}
@@ -20,6 +20,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sony/gobreaker"
 	"github.com/rs/zerolog"
 )
@@ -28,8 +29,20 @@ var (
 	circuitBreakers = make(map[string]*gobreaker.CircuitBreaker)
 	cbMutex        = &sync.RWMutex{}
 	logger          = zerolog.New(os.Stdout).With().Timestamp().Str("service", "resilience").Logger()
+
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_circuit_breaker_state",
+			Help: "Circuit breaker state: 0 closed, 1 half-open, 2 open",
+		},
+		[]string{"name"},
+	)
 )
 
+func init() {
+	prometheus.MustRegister(circuitBreakerState)
+}
+
 type CircuitBreakerConfig struct {
 	Name          string
 	MaxRequests    uint32
@@ -56,12 +69,14 @@ func InitCircuitBreakers(configs []CircuitBreakerConfig) {
 					Str("from", from.String()).
 					Str("to", to.String()).
 					Msg("Circuit breaker state change")
+				circuitBreakerState.WithLabelValues(name).Set(float64(to))
 				if config.OnStateChange != nil {
 					config.OnStateChange(name, from, to)
 				}
 			},
 		})
 		circuitBreakers[config.Name] = cb
+		circuitBreakerState.WithLabelValues(config.Name).Set(float64(gobreaker.StateClosed))
 		logger.Info().Str("circuit_breaker", config.Name).Msg("Initialized circuit breaker")
 	}
 }
@@ -126,6 +141,7 @@ func ResetCircuitBreaker(name string) error {
 		return err
 	}
 	cb.Reset()
+	circuitBreakerState.WithLabelValues(name).Set(float64(gobreaker.StateClosed))
 	return nil
 }
 
@@ -168,6 +184,7 @@ func AddCircuitBreaker(config CircuitBreakerConfig) error {
 				Str("from", from.String()).
 				Str("to", to.String()).
 				Msg("Circuit breaker state change")
+			circuitBreakerState.WithLabelValues(name).Set(float64(to))
 			if config.OnStateChange != nil {
 				config.OnStateChange(name, from, to)
 			}
@@ -175,6 +192,7 @@ func AddCircuitBreaker(config CircuitBreakerConfig) error {
 	})
 
 	circuitBreakers[config.Name] = cb
+	circuitBreakerState.WithLabelValues(config.Name).Set(float64(gobreaker.StateClosed))
 	logger.Info().Str("circuit_breaker", config.Name).Msg("Added circuit breaker")
 	return nil
 }
@@ -188,6 +206,7 @@ func RemoveCircuitBreaker(name string) error {
 	}
 
 	delete(circuitBreakers, name)
+	circuitBreakerState.DeleteLabelValues(name)
 	logger.Info().Str("circuit_breaker", name).Msg("Removed circuit breaker")
 	return nil
 }
@@ -0,0 +1,419 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Hedging sends a second attempt when the first one is slower than the
+// configured percentile of recent latencies, and uses whichever answers
+// first. Retries and hedges both draw from a retry budget: at most
+// BudgetRatio extra attempts per request over the last BudgetWindow, plus
+// BudgetMinPerSecond so low traffic can still retry. When providers degrade,
+// the budget stops extra attempts from multiplying their load.
+
+// ErrRetryBudgetExhausted is returned when a retry is refused by the budget
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+type HedgeConfig struct {
+	// Percentile of recent latencies after which the hedge is sent; 0
+	// disables hedging
+	Percentile float64
+	// Bounds of the hedge delay. MaxDelay is also used until enough latencies
+	// are known.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// Latencies kept per key
+	Samples int
+
+	BudgetRatio        float64
+	BudgetMinPerSecond int
+	BudgetWindow       time.Duration
+}
+
+// DefaultHedgeConfig hedges at p95 with a 10% retry budget
+func DefaultHedgeConfig() HedgeConfig {
+	return HedgeConfig{
+		Percentile:         95,
+		MinDelay:           200 * time.Millisecond,
+		MaxDelay:           10 * time.Second,
+		Samples:            1000,
+		BudgetRatio:        0.1,
+		BudgetMinPerSecond: 10,
+		BudgetWindow:       10 * time.Second,
+	}
+}
+
+// minLatencySamples are needed before the percentile is trusted
+const minLatencySamples = 20
+
+var (
+	hedgeConfig = DefaultHedgeConfig()
+	budget      = NewRetryBudget(hedgeConfig.BudgetRatio, hedgeConfig.BudgetMinPerSecond, hedgeConfig.BudgetWindow)
+
+	latencies      = make(map[string]*latencyWindow)
+	latenciesMutex = &sync.Mutex{}
+
+	hedgedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_hedged_requests_total",
+			Help: "Requests that reached the hedge delay, by outcome (primary_won, hedge_won, failed, no_budget)",
+		},
+		[]string{"key", "outcome"},
+	)
+	retryBudgetRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_retry_budget_rejections_total",
+			Help: "Retries and hedges refused by the retry budget",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(hedgedRequests, retryBudgetRejections)
+}
+
+// InitHedging replaces the hedging configuration and resets the budget
+func InitHedging(config HedgeConfig) {
+	defaults := DefaultHedgeConfig()
+	if config.MaxDelay <= 0 {
+		config.MaxDelay = defaults.MaxDelay
+	}
+	if config.Samples <= 0 {
+		config.Samples = defaults.Samples
+	}
+	if config.BudgetWindow <= 0 {
+		config.BudgetWindow = defaults.BudgetWindow
+	}
+
+	latenciesMutex.Lock()
+	defer latenciesMutex.Unlock()
+	hedgeConfig = config
+	budget = NewRetryBudget(config.BudgetRatio, config.BudgetMinPerSecond, config.BudgetWindow)
+	latencies = make(map[string]*latencyWindow)
+
+	logger.Info().
+		Float64("percentile", config.Percentile).
+		Float64("budget_ratio", config.BudgetRatio).
+		Int("budget_min_per_second", config.BudgetMinPerSecond).
+		Msg("Initialized hedging")
+}
+
+// Attempt is one try at a request. It should return promptly once ctx is
+// cancelled, which happens when the other attempt wins.
+type Attempt func(ctx context.Context) (interface{}, error)
+
+// Hedge runs primary and, if it has not answered within the hedge delay for
+// key, hedge as well; the first success wins. If primary fails before the
+// delay, hedge runs right away as a retry. hedge is skipped when the retry
+// budget is exhausted, and may be nil for requests that are not idempotent:
+// they are still counted in the budget that their retries draw from.
+func Hedge(ctx context.Context, key string, primary, hedge Attempt) (interface{}, error) {
+	b := currentBudget()
+	b.Deposit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		value  interface{}
+		err    error
+		hedged bool
+	}
+	// Buffered so the loser never blocks after we return
+	results := make(chan result, 2)
+	run := func(attempt Attempt, hedged bool) {
+		start := time.Now()
+		value, err := attempt(ctx)
+		if err == nil {
+			recordLatency(key, time.Since(start))
+		}
+		results <- result{value, err, hedged}
+	}
+	go run(primary, false)
+
+	delay := HedgeDelay(key)
+	if hedge == nil || delay <= 0 {
+		r := <-results
+		return r.value, r.err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	fired, hedged := false, false
+	var firstErr error
+	sendHedge := func(kind string) {
+		if !b.Withdraw() {
+			retryBudgetRejections.WithLabelValues(kind).Inc()
+			return
+		}
+		go run(hedge, true)
+		pending++
+		hedged = true
+	}
+	// Outcome of a request that reached the hedge delay
+	outcome := func(won bool, byHedge bool) string {
+		switch {
+		case !hedged:
+			return "no_budget"
+		case !won:
+			return "failed"
+		case byHedge:
+			return "hedge_won"
+		default:
+			return "primary_won"
+		}
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			fired = true
+			sendHedge("hedge")
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if fired {
+					hedgedRequests.WithLabelValues(key, outcome(true, r.hedged)).Inc()
+				}
+				return r.value, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			// The primary failed before the hedge delay: retry right away
+			if !hedged && timer.Stop() {
+				sendHedge("retry")
+			}
+			if pending == 0 {
+				if fired {
+					hedgedRequests.WithLabelValues(key, outcome(false, false)).Inc()
+				}
+				return nil, firstErr
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// WithBudgetedRetry is WithRetry where every attempt after the first draws
+// from the retry budget. The request itself is counted by Hedge, so run it
+// from an Attempt.
+func WithBudgetedRetry(operation func() error, maxRetries int, backoff time.Duration) error {
+	b := currentBudget()
+
+	var err error
+	for i := 0; i < maxRetries; i++ {
+		if i > 0 {
+			if !b.Withdraw() {
+				retryBudgetRejections.WithLabelValues("retry").Inc()
+				return fmt.Errorf("%w after %d attempts: %v", ErrRetryBudgetExhausted, i, err)
+			}
+			time.Sleep(backoff * time.Duration(i))
+		}
+
+		err = operation()
+		if err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("operation failed after %d retries: %w", maxRetries, err)
+}
+
+// HedgeDelay is how long to wait for an attempt before hedging; 0 when
+// hedging is disabled
+func HedgeDelay(key string) time.Duration {
+	latenciesMutex.Lock()
+	defer latenciesMutex.Unlock()
+
+	if hedgeConfig.Percentile <= 0 {
+		return 0
+	}
+	delay := hedgeConfig.MaxDelay
+	if w, ok := latencies[key]; ok && w.count() >= minLatencySamples {
+		delay = w.percentile(hedgeConfig.Percentile)
+	}
+	if delay < hedgeConfig.MinDelay {
+		delay = hedgeConfig.MinDelay
+	}
+	if delay > hedgeConfig.MaxDelay {
+		delay = hedgeConfig.MaxDelay
+	}
+	return delay
+}
+
+// GetHedgingStats returns the hedge delay per key and the budget usage
+func GetHedgingStats() map[string]interface{} {
+	latenciesMutex.Lock()
+	keys := make([]string, 0, len(latencies))
+	for key := range latencies {
+		keys = append(keys, key)
+	}
+	config := hedgeConfig
+	latenciesMutex.Unlock()
+
+	delays := make(map[string]string, len(keys))
+	for _, key := range keys {
+		delays[key] = HedgeDelay(key).String()
+	}
+	requests, retries, allowed := currentBudget().Usage()
+
+	return map[string]interface{}{
+		"percentile":   config.Percentile,
+		"hedge_delays": delays,
+		"budget": map[string]interface{}{
+			"requests": requests,
+			"retries":  retries,
+			"allowed":  allowed,
+			"window":   config.BudgetWindow.String(),
+		},
+	}
+}
+
+func currentBudget() *RetryBudget {
+	latenciesMutex.Lock()
+	defer latenciesMutex.Unlock()
+	return budget
+}
+
+func recordLatency(key string, latency time.Duration) {
+	latenciesMutex.Lock()
+	defer latenciesMutex.Unlock()
+
+	w, ok := latencies[key]
+	if !ok {
+		w = &latencyWindow{samples: make([]time.Duration, 0, hedgeConfig.Samples), size: hedgeConfig.Samples}
+		latencies[key] = w
+	}
+	w.add(latency)
+}
+
+// latencyWindow keeps the last size latencies
+type latencyWindow struct {
+	samples []time.Duration
+	size    int
+	next    int
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	if len(w.samples) < w.size {
+		w.samples = append(w.samples, latency)
+		return
+	}
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % w.size
+}
+
+func (w *latencyWindow) count() int {
+	return len(w.samples)
+}
+
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(p / 100 * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// RetryBudget allows retries up to a ratio of requests over a sliding
+// window, counted in one-second buckets
+type RetryBudget struct {
+	ratio        float64
+	minPerSecond int
+	window       time.Duration
+
+	mu      sync.Mutex
+	buckets []budgetBucket
+	now     func() time.Time
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func NewRetryBudget(ratio float64, minPerSecond int, window time.Duration) *RetryBudget {
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &RetryBudget{
+		ratio:        ratio,
+		minPerSecond: minPerSecond,
+		window:       time.Duration(seconds) * time.Second,
+		buckets:      make([]budgetBucket, seconds),
+		now:          time.Now,
+	}
+}
+
+// Deposit records a request, which earns ratio retries
+func (b *RetryBudget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket().requests++
+}
+
+// Withdraw reserves a retry if the budget allows one
+func (b *RetryBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, retries := b.totals()
+	if float64(retries+1) > b.allowed(requests) {
+		return false
+	}
+	b.bucket().retries++
+	return true
+}
+
+// Usage returns the requests and retries in the window and the retries allowed
+func (b *RetryBudget) Usage() (requests, retries int, allowed float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, retries = b.totals()
+	return requests, retries, b.allowed(requests)
+}
+
+func (b *RetryBudget) allowed(requests int) float64 {
+	return b.ratio*float64(requests) + float64(b.minPerSecond)*b.window.Seconds()
+}
+
+// bucket returns the bucket of the current second, resetting it if it was
+// last used a window ago
+func (b *RetryBudget) bucket() *budgetBucket {
+	second := b.now().Unix()
+	bucket := &b.buckets[second%int64(len(b.buckets))]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
+
+func (b *RetryBudget) totals() (requests, retries int) {
+	oldest := b.now().Unix() - int64(len(b.buckets)) + 1
+	for _, bucket := range b.buckets {
+		if bucket.second >= oldest {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	return requests, retries
+}
@@ -0,0 +1,114 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func initTestHedging(minPerSecond int) {
+	InitHedging(HedgeConfig{
+		Percentile:         95,
+		MinDelay:           20 * time.Millisecond,
+		MaxDelay:           20 * time.Millisecond,
+		BudgetRatio:        0.1,
+		BudgetMinPerSecond: minPerSecond,
+		BudgetWindow:       10 * time.Second,
+	})
+}
+
+func sleepAttempt(d time.Duration, value string) Attempt {
+	return func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-time.After(d):
+			return value, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestHedgeWinsOverSlowPrimary(t *testing.T) {
+	initTestHedging(10)
+
+	start := time.Now()
+	result, err := Hedge(context.Background(), "gpt-4", sleepAttempt(time.Second, "primary"), sleepAttempt(10*time.Millisecond, "hedge"))
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", result)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestHedgeNotSentForFastPrimary(t *testing.T) {
+	initTestHedging(10)
+
+	var hedged int32
+	hedge := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&hedged, 1)
+		return "hedge", nil
+	}
+	result, err := Hedge(context.Background(), "gpt-4", sleepAttempt(time.Millisecond, "primary"), hedge)
+	require.NoError(t, err)
+	assert.Equal(t, "primary", result)
+	assert.Zero(t, atomic.LoadInt32(&hedged))
+}
+
+func TestHedgeRetriesFailedPrimary(t *testing.T) {
+	initTestHedging(10)
+
+	primary := func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("provider error")
+	}
+	result, err := Hedge(context.Background(), "gpt-4", primary, sleepAttempt(time.Millisecond, "hedge"))
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", result)
+}
+
+func TestHedgeRespectsBudget(t *testing.T) {
+	// No minimum: 10 requests earn a single hedge
+	initTestHedging(0)
+
+	var hedged int32
+	hedge := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&hedged, 1)
+		return "hedge", nil
+	}
+	for i := 0; i < 10; i++ {
+		_, err := Hedge(context.Background(), "gpt-4", sleepAttempt(50*time.Millisecond, "primary"), hedge)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hedged))
+}
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewRetryBudget(0.5, 0, 2*time.Second)
+	b.now = func() time.Time { return now }
+
+	assert.False(t, b.Withdraw())
+	b.Deposit()
+	b.Deposit()
+	assert.True(t, b.Withdraw())
+	assert.False(t, b.Withdraw())
+
+	// The deposits leave the window
+	now = now.Add(2 * time.Second)
+	b.Deposit()
+	assert.False(t, b.Withdraw())
+	b.Deposit()
+	assert.True(t, b.Withdraw())
+}
+
+func TestHedgeDelayFollowsPercentile(t *testing.T) {
+	InitHedging(HedgeConfig{Percentile: 90, MinDelay: time.Millisecond, MaxDelay: time.Second})
+
+	assert.Equal(t, time.Second, HedgeDelay("gpt-4"))
+	for i := 1; i <= 100; i++ {
+		recordLatency("gpt-4", time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 91*time.Millisecond, HedgeDelay("gpt-4"))
+}
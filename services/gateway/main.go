@@ -23,23 +23,43 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"github.com/MaksimVF/ZB/pkg/alerting"
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+	"github.com/MaksimVF/ZB/pkg/compress"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/faultinject"
+	"github.com/MaksimVF/ZB/pkg/featureflags"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics/muxroute"
+	"github.com/MaksimVF/ZB/pkg/openapi"
+	"github.com/MaksimVF/ZB/pkg/outbox/jetstream"
+	"github.com/MaksimVF/ZB/pkg/tlsutil"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/routingclient"
+	"github.com/MaksimVF/ZB/pkg/tracing"
+	"github.com/MaksimVF/ZB/pkg/usageevents"
 	pb "github.com/MaksimVF/ZB/services/secrets-service/pb"
+	"llm-gateway-pro/services/gateway/internal/analytics"
 	"llm-gateway-pro/services/gateway/internal/handlers"
 	"llm-gateway-pro/services/gateway/internal/billing"
+	"llm-gateway-pro/services/gateway/internal/guardrails"
+	"llm-gateway-pro/services/gateway/internal/policy"
+	"llm-gateway-pro/services/gateway/internal/provenance"
 	"llm-gateway-pro/services/gateway/internal/providers"
 	"llm-gateway-pro/services/gateway/internal/resilience"
+	"llm-gateway-pro/services/gateway/middleware"
 )
 
 var (
@@ -60,6 +80,8 @@ func init() {
 	secretConn, err = grpc.Dial(
 		"secret-service:50053",
 		grpc.WithTransportCredentials(loadClientTLSCredentials()),
+		tracing.DialOption(),
+		requestid.DialOption(),
 	)
 	if err != nil {
 		log.Fatalf("Failed to connect to secret-service: %v", err)
@@ -69,6 +91,12 @@ func init() {
 }
 
 func main() {
+	shutdownTracing, err := tracing.Init(context.Background(), "gateway")
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize Prometheus metrics
 	handlers.InitMetrics()
 
@@ -82,50 +110,122 @@ func main() {
 		os.Getenv("DB_PORT"),
 	)
 
-	err := billing.Init(dbConnString)
+	err = billing.Init(dbConnString)
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to initialize billing system")
 	}
 	defer billing.Close()
 
+	// Usage events go from the billing outbox to the BILLING_EVENTS stream
+	go func() {
+		err := jetstream.Relay(context.Background(), natsURL(), "gateway", billing.DB(), "BILLING_EVENTS", "billing.>")
+		if err != nil {
+			logger.Error().Err(err).Msg("Billing outbox relay stopped")
+		}
+	}()
+
+	// Every completed request is published to the USAGE_EVENTS stream
+	if os.Getenv("USAGE_EVENTS") != "off" {
+		emitter, err := usageevents.Start(context.Background(), natsURL(), "gateway", 10000)
+		if err != nil {
+			logger.Error().Err(err).Msg("Usage events disabled")
+		} else {
+			handlers.InitUsageEvents(emitter)
+		}
+	}
+	startUsageWarehouse(logger)
+
+	// Daily usage per model for the forecast report, aggregated hourly
+	go billing.RunReports(context.Background(), time.Hour)
+
+	redisClient := redis.NewClient(&redis.Options{Addr: redisAddr()})
+
+	// Feature flags are shared with head through Redis: the admin API below
+	// changes them and every replica reloads them on change
+	flagStore := featureflags.NewStore(redisClient)
+	if err := flagStore.Seed(context.Background(), handlers.DefaultFeatureFlags); err != nil {
+		logger.Error().Err(err).Msg("Failed to seed feature flags")
+	}
+	flags := featureflags.New("gateway", flagStore, handlers.DefaultFeatureFlags...)
+	if err := flags.Load(context.Background()); err != nil {
+		logger.Error().Err(err).Msg("Failed to load feature flags")
+	}
+	flags.Watch(context.Background(), 30*time.Second)
+	handlers.InitFeatureFlags(flags)
+
 	// Initialize LiteLLM providers with secrets from secrets-service
 	providerConfig := providers.LiteLLMConfig{
 		Providers: map[string]providers.ProviderConfig{
 			"openai": {
-				BaseURL:    "https://api.openai.com",
-				APIKey:     getSecretFromService("llm/openai/api_key"),
-				ModelNames: []string{"gpt-4", "gpt-3.5-turbo", "gpt-4o"},
+				BaseURL:      "https://api.openai.com",
+				APIKeySecret: "llm/openai/api_key",
+				ModelNames:   []string{"gpt-4", "gpt-3.5-turbo", "gpt-4o", "whisper-1", "tts-1", "tts-1-hd"},
+				Region:       "us",
 			},
 			"anthropic": {
-				BaseURL:    "https://api.anthropic.com",
-				APIKey:     getSecretFromService("llm/anthropic/api_key"),
-				ModelNames: []string{"claude-3", "claude-2", "claude-instant"},
+				BaseURL:      "https://api.anthropic.com",
+				APIKeySecret: "llm/anthropic/api_key",
+				ModelNames:   []string{"claude-3", "claude-2", "claude-instant"},
+				Region:       "us",
 			},
 			"google": {
-				BaseURL:    "https://api.google.com",
-				APIKey:     getSecretFromService("llm/google/api_key"),
-				ModelNames: []string{"gemini-1.5", "gemini-1.0", "gemini-pro"},
+				BaseURL:      "https://api.google.com",
+				APIKeySecret: "llm/google/api_key",
+				ModelNames:   []string{"gemini-1.5", "gemini-1.0", "gemini-pro"},
+				Region:       "us",
 			},
 			"meta": {
-				BaseURL:    "https://api.meta.com",
-				APIKey:     getSecretFromService("llm/meta/api_key"),
-				ModelNames: []string{"llama-3", "llama-2", "llama-1"},
+				BaseURL:      "https://api.meta.com",
+				APIKeySecret: "llm/meta/api_key",
+				ModelNames:   []string{"llama-3", "llama-2", "llama-1"},
+				Region:       "us",
 			},
 			"mistral": {
-				BaseURL:    "https://api.mistral.ai",
-				APIKey:     getSecretFromService("llm/mistral/api_key"),
-				ModelNames: []string{"mistral-large", "mistral-medium", "mistral-small"},
+				BaseURL:      "https://api.mistral.ai",
+				APIKeySecret: "llm/mistral/api_key",
+				ModelNames:   []string{"mistral-large", "mistral-medium", "mistral-small"},
+				Region:       "eu",
 			},
 			"cohere": {
-				BaseURL:    "https://api.cohere.ai",
-				APIKey:     getSecretFromService("llm/cohere/api_key"),
-				ModelNames: []string{"command-r", "command-light", "command-nightly"},
+				BaseURL:      "https://api.cohere.ai",
+				APIKeySecret: "llm/cohere/api_key",
+				ModelNames:   []string{"command-r", "command-light", "command-nightly"},
+				Region:       "us",
 			},
 		},
+		// weighted (default) or least_connections
+		Balancing: os.Getenv("PROVIDER_BALANCING"),
+		// Providers added through the API are persisted and shared by all replicas
+		Store:         providers.NewProviderStore(redisClient),
+		ResolveSecret: getSecretFromService,
 	}
 
 	providers.Init(providerConfig)
 
+	// Per-tenant model and provider allow/deny lists, shared by all replicas
+	policy.Init(context.Background(), redisClient, 30*time.Second)
+
+	// Per-tenant guardrails evaluated before and after model calls, by OPA
+	// when GUARDRAILS_OPA_URL is set; custom Rego may live in secret-service
+	guardrails.Init(context.Background(), redisClient, guardrails.Config{
+		OPAURL:        os.Getenv("GUARDRAILS_OPA_URL"),
+		FailOpen:      os.Getenv("GUARDRAILS_FAIL_OPEN") == "true",
+		ResolveSecret: getSecretFromService,
+		Interval:      30 * time.Second,
+	})
+
+	// Per-tenant provenance metadata and watermarks of completions
+	provenance.Init(context.Background(), redisClient, 30*time.Second)
+
+	// Clients per tenant and day, with the endpoints marked deprecated in the
+	// OpenAPI document, kept for ANALYTICS_RETENTION_DAYS
+	retentionDays := 90
+	if days, err := strconv.Atoi(os.Getenv("ANALYTICS_RETENTION_DAYS")); err == nil && days > 0 {
+		retentionDays = days
+	}
+	analytics.Init(context.Background(), redisClient, 10*time.Second,
+		time.Duration(retentionDays)*24*time.Hour, handlers.OpenAPI().Deprecated)
+
 	// Initialize circuit breakers
 	circuitBreakerConfigs := []resilience.CircuitBreakerConfig{
 		{
@@ -180,26 +280,45 @@ func main() {
 
 	resilience.InitCircuitBreakers(circuitBreakerConfigs)
 
+	// Hedge completions slower than this latency percentile (0 disables), with
+	// retries and hedges capped at a ratio of requests
+	hedgeConfig := resilience.DefaultHedgeConfig()
+	hedgeConfig.Percentile = envFloat("HEDGE_PERCENTILE", hedgeConfig.Percentile)
+	hedgeConfig.BudgetRatio = envFloat("RETRY_BUDGET_RATIO", hedgeConfig.BudgetRatio)
+	resilience.InitHedging(hedgeConfig)
+
 	r := mux.NewRouter()
 
+	// Client analytics count every routed request, rejected ones included
+	r.Use(analytics.Middleware(handlers.Tenant))
+
+	// Oversized payloads are rejected before anything reads them
+	r.Use(middleware.PayloadLimitMiddleware)
+
+	// Concurrency and rate limiting run before the security middlewares
+	if err := middleware.InitConcurrency(redisClient); err != nil {
+		logger.Fatal().Err(err).Msg("Invalid concurrency limits")
+	}
+	// The rate limiter resolves the client the concurrency limits apply to
+	r.Use(middleware.RateLimitMiddleware)
+	r.Use(middleware.ConcurrencyLimitMiddleware)
+
 	// Apply security middlewares
 	r.Use(middleware.ContentFilteringMiddleware)
 	r.Use(middleware.AuditLoggingMiddleware)
 	r.Use(middleware.DataIsolationMiddleware)
 
-	// LangChain-specific endpoint
-	r.HandleFunc("/v1/langchain/chat/completions", handlers.LangChainCompletion).Methods("POST")
+	// Latency, errors and dropped streams for resilience tests, only with
+	// FAULT_INJECTION_ENABLED=true and the fault_injection flag on
+	r.Use(faultinject.FromEnv("gateway", flags).Middleware)
 
-	// Standard OpenAI-compatible endpoint
-	r.HandleFunc("/v1/chat/completions", handlers.ChatCompletion).Methods("POST")
+	// Public API, described at /openapi.json
+	registerPublicAPI(r)
 
-	// Agentic endpoint - proxy to agentic service
-	r.HandleFunc("/v1/agentic", handlers.ProxyAgenticRequest).Methods("POST")
-
-	// Provider management endpoints
-	r.HandleFunc("/v1/providers", handlers.ListProviders).Methods("GET")
-	r.HandleFunc("/v1/providers", handlers.AddProvider).Methods("POST")
-	r.HandleFunc("/v1/providers/{provider}", handlers.RemoveProvider).Methods("DELETE")
+	// Provider response cache endpoints
+	r.HandleFunc("/v1/cache", handlers.GetCacheStats).Methods("GET")
+	r.HandleFunc("/v1/cache", handlers.ClearCache).Methods("DELETE")
+	r.HandleFunc("/v1/cache/providers/{provider}", handlers.SetProviderCache).Methods("PUT")
 
 	// Security configuration endpoints
 	r.HandleFunc("/v1/security/config", handlers.GetSecurityConfig).Methods("GET")
@@ -209,6 +328,7 @@ func main() {
 	r.HandleFunc("/v1/circuit-breakers", handlers.ListCircuitBreakers).Methods("GET")
 	r.HandleFunc("/v1/circuit-breakers/{name}", handlers.GetCircuitBreakerStatus).Methods("GET")
 	r.HandleFunc("/v1/circuit-breakers/{name}/reset", handlers.ResetCircuitBreaker).Methods("POST")
+	r.HandleFunc("/v1/hedging", handlers.GetHedgingStats).Methods("GET")
 
 	// Health check
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -216,13 +336,96 @@ func main() {
 		w.Write([]byte("OK"))
 	})
 
+	// Liveness and readiness with dependency detail
+	checker := health.New("gateway").
+		Add("postgres", health.SQL(billing.DB())).
+		Add("redis", func(ctx context.Context) error { return redisClient.Ping(ctx).Err() }).
+		Add("secret-service", health.GRPC(secretConn))
+	r.Handle("/livez", checker.LiveHandler()).Methods("GET")
+	r.Handle("/readyz", checker.ReadyHandler()).Methods("GET")
+
+	// pprof, expvar and /debug/runtime with DEBUG_ENDPOINTS=true, behind the admin key
+	r.PathPrefix(diagnostics.Prefix).Handler(diagnostics.Handler(diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))))
+
+	// Model policies per tenant ("*" for the default), behind the admin key
+	policies := r.PathPrefix("/v1/admin/policies").Subrouter()
+	policies.Use(diagnostics.AdminKey(os.Getenv("ADMIN_KEY")))
+	policies.HandleFunc("", handlers.ListPolicies).Methods("GET")
+	policies.HandleFunc("/{tenant}", handlers.GetPolicy).Methods("GET")
+	policies.HandleFunc("/{tenant}", handlers.PutPolicy).Methods("PUT")
+	policies.HandleFunc("/{tenant}", handlers.DeletePolicy).Methods("DELETE")
+
+	// Guardrail bundles and decision logs per tenant ("*" for the default), behind the admin key
+	guardrailBundles := r.PathPrefix("/v1/admin/guardrails").Subrouter()
+	guardrailBundles.Use(diagnostics.AdminKey(os.Getenv("ADMIN_KEY")))
+	guardrailBundles.HandleFunc("", handlers.ListGuardrails).Methods("GET")
+	guardrailBundles.HandleFunc("/{tenant}", handlers.GetGuardrails).Methods("GET")
+	guardrailBundles.HandleFunc("/{tenant}", handlers.PutGuardrails).Methods("PUT")
+	guardrailBundles.HandleFunc("/{tenant}", handlers.DeleteGuardrails).Methods("DELETE")
+	guardrailBundles.HandleFunc("/{tenant}/decisions", handlers.GetGuardrailDecisions).Methods("GET")
+
+	// Provenance metadata and watermarks of completions per tenant ("*" for
+	// the default), behind the admin key
+	provenanceSettings := r.PathPrefix("/v1/admin/provenance").Subrouter()
+	provenanceSettings.Use(diagnostics.AdminKey(os.Getenv("ADMIN_KEY")))
+	provenanceSettings.HandleFunc("", handlers.ListProvenance).Methods("GET")
+	provenanceSettings.HandleFunc("/detect", handlers.DetectWatermark).Methods("POST")
+	provenanceSettings.HandleFunc("/{tenant}", handlers.GetProvenance).Methods("GET")
+	provenanceSettings.HandleFunc("/{tenant}", handlers.PutProvenance).Methods("PUT")
+	provenanceSettings.HandleFunc("/{tenant}", handlers.DeleteProvenance).Methods("DELETE")
+
+	// Heads registered with routing-service, behind the admin key
+	if addr := os.Getenv("ROUTING_SERVICE_ADDR"); addr != "" {
+		routingClient, err := routingclient.New(routingclient.Config{Addr: addr, Service: "gateway"})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to connect to routing-service")
+		}
+		defer routingClient.Close()
+		handlers.InitRouting(routingClient)
+	}
+	r.Handle("/v1/admin/routing/heads", diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))(
+		http.HandlerFunc(handlers.GetRoutingHeads))).Methods("GET")
+
+	// Clients per tenant and endpoint, behind the admin key
+	clientAnalytics := r.PathPrefix("/v1/admin/analytics").Subrouter()
+	clientAnalytics.Use(diagnostics.AdminKey(os.Getenv("ADMIN_KEY")))
+	clientAnalytics.HandleFunc("/clients", handlers.GetClientAnalytics).Methods("GET")
+
+	// Usage forecasts for provider contract planning, behind the admin key
+	reports := r.PathPrefix("/v1/admin/reports").Subrouter()
+	reports.Use(diagnostics.AdminKey(os.Getenv("ADMIN_KEY")))
+	reports.HandleFunc("/forecast", handlers.GetForecast).Methods("GET")
+
+	// Alerting rules with their state from Prometheus, behind the admin key
+	alertConfig, err := alerting.FromEnv()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid alerting configuration")
+	}
+	prometheusURL := os.Getenv("PROMETHEUS_URL")
+	if prometheusURL == "" {
+		prometheusURL = "http://prometheus:9090"
+	}
+	r.PathPrefix(alerting.AdminPrefix).Handler(diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))(
+		alerting.AdminHandler(alerting.Rules(alertConfig), &alerting.Prometheus{URL: prometheusURL})))
+
+	// Feature flags of all services, per service and per tenant, behind the admin key
+	r.PathPrefix(featureflags.AdminPrefix).Handler(
+		diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))(featureflags.AdminHandler(flagStore)))
+
 	// Metrics endpoint
-	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/metrics", httpmetrics.Handler())
+
+	// Deprecation and sunset of API versions, e.g. API_V1_SUNSET=2027-05-01
+	versions, err := apiversion.FromEnv("gateway", apiVersions...)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid API version configuration")
+	}
 
 	// Start HTTP server
 	server := &http.Server{
 		Addr:    ":8080",
-		Handler: r,
+		Handler: tracing.Middleware("gateway", requestid.Middleware(middleware.AccessLog(logger)(
+			httpmetrics.Middleware("gateway", muxroute.Template(r))(compress.Middleware("gateway")(versions.Middleware(r)))))),
 	}
 
 	logger.Info().Msg("Starting gateway service on :8080")
@@ -231,40 +434,138 @@ func main() {
 	}
 }
 
+// apiVersions are the versions of the public API, each served under its own
+// path prefix
+var apiVersions = []string{"v1", "v2"}
+
+// registerPublicAPI registers the endpoints described by handlers.OpenAPI in
+// every API version, the document itself and its rendering at /docs
+func registerPublicAPI(r *mux.Router) {
+	for _, version := range apiVersions {
+		registerAPIVersion(r.PathPrefix("/" + version).Subrouter())
+	}
+
+	// OpenAPI document and its rendering
+	r.Handle("/openapi.json", handlers.OpenAPI().Handler()).Methods("GET")
+	r.Handle("/docs", openapi.Docs("LLM Gateway API", "/openapi.json")).Methods("GET")
+}
+
+// registerAPIVersion registers the public endpoints of a version. v2 serves
+// the v1 formats until a handler changes them by apiversion.FromContext.
+func registerAPIVersion(r *mux.Router) {
+	// LangChain-specific endpoint
+	r.HandleFunc("/langchain/chat/completions", handlers.LangChainCompletion).Methods("POST")
+
+	// Standard OpenAI-compatible endpoint
+	r.HandleFunc("/chat/completions", handlers.ChatCompletion).Methods("POST")
+
+	// Speech to text and text to speech, billed per second and per character
+	r.HandleFunc("/audio/transcriptions", handlers.AudioTranscription).Methods("POST")
+	r.HandleFunc("/audio/speech", handlers.AudioSpeech).Methods("POST")
+
+	// Agentic endpoint - proxy to agentic service
+	r.HandleFunc("/agentic", handlers.ProxyAgenticRequest).Methods("POST")
+
+	// Batch status and results - proxy to tail service
+	r.HandleFunc("/batch/{id}", handlers.ProxyBatchRequest).Methods("GET")
+	r.HandleFunc("/batch/{id}/results", handlers.ProxyBatchRequest).Methods("GET")
+
+	// Provider management endpoints
+	r.HandleFunc("/providers", handlers.ListProviders).Methods("GET")
+	r.HandleFunc("/providers", handlers.AddProvider).Methods("POST")
+	r.HandleFunc("/providers/{provider}", handlers.RemoveProvider).Methods("DELETE")
+	r.HandleFunc("/providers/{provider}/maintenance", handlers.GetProviderMaintenance).Methods("GET")
+	r.HandleFunc("/providers/{provider}/maintenance", handlers.PutProviderMaintenance).Methods("PUT")
+
+	// Billed usage of the API key
+	r.HandleFunc("/usage", handlers.GetUsage).Methods("GET")
+}
+
 func loadClientTLSCredentials() credentials.TransportCredentials {
 	// Load client certificates
 	clientCert := []byte(os.Getenv("CLIENT_CERT"))
 	clientKey := []byte(os.Getenv("CLIENT_KEY"))
 	caCert := []byte(os.Getenv("CA_CERT"))
 
-	// Create a certificate pool from the certificate authority
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		log.Fatalf("Failed to add CA certificate")
-	}
-
-	// Create the TLS credentials
-	cert, err := tls.X509KeyPair(clientCert, clientKey)
+	tlsConfig, err := tlsutil.ClientConfigFromPEM(clientCert, clientKey, caCert)
 	if err != nil {
-		log.Fatalf("Failed to load client certificates: %v", err)
+		log.Fatalf("Failed to load client TLS credentials: %v", err)
 	}
-
-	return credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      certPool,
-	})
+	return credentials.NewTLS(tlsConfig)
 }
 
-func getSecretFromService(key string) string {
+func getSecretFromService(key string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	resp, err := secretClient.GetSecret(ctx, &pb.GetSecretRequest{Key: key})
 	if err != nil {
 		logger.Error().Str("key", key).Err(err).Msg("Failed to get secret from secret-service")
-		return ""
+		return "", err
+	}
+
+	return resp.Value, nil
+}
+
+// startUsageWarehouse loads the USAGE_EVENTS stream into the warehouse
+// chosen by USAGE_WAREHOUSE: postgres (the billing database) or clickhouse
+func startUsageWarehouse(logger zerolog.Logger) {
+	var sink usageevents.Sink
+	switch warehouse := os.Getenv("USAGE_WAREHOUSE"); warehouse {
+	case "":
+		return
+	case "postgres":
+		if _, err := billing.DB().Exec(usageevents.PostgresSchema); err != nil {
+			logger.Error().Err(err).Msg("Failed to create usage events table")
+			return
+		}
+		sink = usageevents.PostgresSink{DB: billing.DB()}
+	case "clickhouse":
+		clickhouse := usageevents.ClickHouseSink{
+			URL:      os.Getenv("USAGE_CLICKHOUSE_URL"),
+			Database: os.Getenv("USAGE_CLICKHOUSE_DATABASE"),
+			User:     os.Getenv("USAGE_CLICKHOUSE_USER"),
+			Password: os.Getenv("USAGE_CLICKHOUSE_PASSWORD"),
+			Client:   &http.Client{Timeout: time.Minute},
+		}
+		if err := clickhouse.CreateTable(context.Background()); err != nil {
+			logger.Error().Err(err).Msg("Failed to create usage events table")
+			return
+		}
+		sink = clickhouse
+	default:
+		logger.Error().Str("warehouse", warehouse).Msg("Unknown USAGE_WAREHOUSE")
+		return
 	}
 
-	return resp.Value
+	go func() {
+		err := usageevents.Consume(context.Background(), natsURL(), "gateway", usageevents.Consumer{
+			Durable: "usage-warehouse",
+			Sink:    sink,
+		})
+		if err != nil {
+			logger.Error().Err(err).Msg("Usage warehouse consumer stopped")
+		}
+	}()
 }
 
+func redisAddr() string {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr
+	}
+	return "redis:6379"
+}
+
+func natsURL() string {
+	if url := os.Getenv("NATS_URL"); url != "" {
+		return url
+	}
+	return "nats://nats:4222"
+}
+
+func envFloat(name string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
@@ -12,8 +12,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"llm-gateway-pro/services/gateway/internal/handlers"
@@ -119,3 +121,45 @@ func TestHealthCheck(t *testing.T) {
 
 
 
+
+func TestOpenAPIRoutes(t *testing.T) {
+	r := mux.NewRouter()
+	registerPublicAPI(r)
+
+	unrouted := handlers.OpenAPI().Unrouted(func(req *http.Request) bool {
+		var match mux.RouteMatch
+		return r.Match(req, &match)
+	})
+	assert.Empty(t, unrouted, "documented operations without a handler")
+
+	// v2 serves every documented v1 operation
+	unrouted = handlers.OpenAPI().Unrouted(func(req *http.Request) bool {
+		req.URL.Path = "/v2" + strings.TrimPrefix(req.URL.Path, "/v1")
+		var match mux.RouteMatch
+		return r.Match(req, &match)
+	})
+	assert.Empty(t, unrouted, "documented operations without a v2 handler")
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc))
+	assert.Contains(t, doc["paths"], "/v1/chat/completions")
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	doc := handlers.OpenAPI()
+
+	// Error responses of the handlers match the documented error body
+	reqBytes, _ := json.Marshal(handlers.LangChainRequest{Messages: []map[string]interface{}{{"role": "user", "content": "Hello"}}})
+	req := httptest.NewRequest("POST", "/v1/langchain/chat/completions", bytes.NewReader(reqBytes))
+	req.Header.Set("Authorization", "Bearer langchain-12345")
+	rr := httptest.NewRecorder()
+	handlers.LangChainCompletion(rr, req)
+	assert.NoError(t, doc.ValidateResponse("POST", "/v1/langchain/chat/completions", rr.Code, rr.Body.Bytes()))
+
+	rr = httptest.NewRecorder()
+	handlers.ListProviders(rr, httptest.NewRequest("GET", "/v1/providers", nil))
+	assert.NoError(t, doc.ValidateResponse("GET", "/v1/providers", rr.Code, rr.Body.Bytes()))
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/rs/zerolog"
+)
+
+// AccessLog logs one line per request with its request_id, and puts a logger
+// with that field in the request context for handlers, see zerolog.Ctx
+func AccessLog(logger zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			reqLogger := logger.With().Str("request_id", requestid.FromContext(r.Context())).Logger()
+			rec := httpmetrics.NewStatusRecorder(w)
+			next.ServeHTTP(rec, r.WithContext(reqLogger.WithContext(r.Context())))
+
+			reqLogger.Info().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Int("status", rec.Status).
+				Dur("duration", time.Since(start)).
+				Str("remote_addr", r.RemoteAddr).
+				Msg("access")
+		})
+	}
+}
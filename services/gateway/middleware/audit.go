@@ -17,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/apiversion"
 	"github.com/go-redis/redis/v8"
 )
 
@@ -69,10 +70,13 @@ func isSensitiveOperation(r *http.Request) bool {
 		"/v1/secrets",
 		"/v1/billing",
 		"/v1/admin",
+		"/admin/api/flags",
 	}
 
+	// Paths of every API version are checked as their /v1 path
+	requested := apiversion.Canonical(r.URL.Path)
 	for _, path := range sensitivePaths {
-		if strings.Contains(r.URL.Path, path) {
+		if strings.Contains(requested, path) {
 			return true
 		}
 	}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+	"github.com/MaksimVF/ZB/pkg/concurrency"
+)
+
+// streams limits simultaneous requests per client (CONCURRENCY_LIMITS),
+// separately from the per-minute limits of RateLimitMiddleware
+var streams *concurrency.Limiter
+
+// concurrencyLimitedPaths are the model calls that hold a slot while they run
+var concurrencyLimitedPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/agentic":          true,
+}
+
+// InitConcurrency sets up the concurrency limits; the semaphores live in
+// Redis and are shared with all gateway replicas
+func InitConcurrency(rdb redis.UniversalClient) error {
+	var err error
+	streams, err = concurrency.NewFromEnv("gateway", rdb)
+	return err
+}
+
+// ConcurrencyLimitMiddleware holds a slot of the client while a model call,
+// stream included, is served; clients over their limit get 429 with the code
+// concurrency_limit_exceeded. It runs after RateLimitMiddleware, which
+// resolves the client.
+func ConcurrencyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streams == nil || !concurrencyLimitedPaths[apiversion.Canonical(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		streams.Middleware(next).ServeHTTP(w, r)
+	})
+}
@@ -14,6 +14,8 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/apiversion"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
@@ -49,20 +51,22 @@ func ContentFilteringMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check URL path for sensitive endpoints
-		if strings.Contains(r.URL.Path, "/v1/") {
+		// Check URL path for sensitive endpoints, in every API version
+		if apiversion.Of(r.URL.Path) != "" {
 			// Check for bad words in query parameters
 			for _, param := range r.URL.Query() {
 				for _, value := range param {
 					if containsBadWords(value) || containsBadPatterns(value) {
-						http.Error(w, "Request contains prohibited content", http.StatusBadRequest)
+						apierror.Write(w, http.StatusBadRequest, "Request contains prohibited content")
 						return
 					}
 				}
 			}
 
-			// For POST/PUT requests, check the body content
-			if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			// For POST/PUT requests, check the body content; uploads such as
+			// audio files are binary and not filtered
+			if (r.Method == http.MethodPost || r.Method == http.MethodPut) &&
+				!strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
 				// Read the body
 				body := make([]byte, r.ContentLength)
 				_, err := r.Body.Read(body)
@@ -72,7 +76,7 @@ func ContentFilteringMiddleware(next http.Handler) http.Handler {
 				}
 
 				if containsBadWords(string(body)) || containsBadPatterns(string(body)) {
-					http.Error(w, "Request contains prohibited content", http.StatusBadRequest)
+					apierror.Write(w, http.StatusBadRequest, "Request contains prohibited content")
 					return
 				}
 			}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
@@ -46,7 +47,7 @@ func DataIsolationMiddleware(next http.Handler) http.Handler {
 		// Extract client ID from request (could be from header, token, etc.)
 		clientID := getClientID(r)
 		if clientID == "" {
-			http.Error(w, "Client ID required", http.StatusUnauthorized)
+			apierror.Write(w, http.StatusUnauthorized, "Client ID required")
 			return
 		}
 
@@ -56,7 +57,7 @@ func DataIsolationMiddleware(next http.Handler) http.Handler {
 
 		// Apply data isolation policies
 		if !validateClientAccess(r, clientID) {
-			http.Error(w, "Access denied", http.StatusForbidden)
+			apierror.Write(w, http.StatusForbidden, "Access denied")
 			return
 		}
 
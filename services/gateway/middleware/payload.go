@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/payload"
+)
+
+// payloadLimits come from MAX_REQUEST_BODY_BYTES, MAX_REQUEST_MESSAGES and
+// MAX_PROMPT_TOKENS
+var payloadLimits = payload.LimitsFromEnv()
+
+// audioLimits bound audio uploads by AUDIO_MAX_BYTES instead, 25 MiB like
+// OpenAI by default
+var audioLimits = func() payload.Limits {
+	l := payload.Limits{MaxBodyBytes: 25 << 20}
+	if v, err := strconv.ParseInt(os.Getenv("AUDIO_MAX_BYTES"), 10, 64); err == nil && v >= 0 {
+		l.MaxBodyBytes = v
+	}
+	return l
+}()
+
+// PayloadLimitMiddleware rejects oversized bodies with 413 and message lists
+// over the limits with 400, before they are filtered or proxied
+func PayloadLimitMiddleware(next http.Handler) http.Handler {
+	checked := payloadLimits.Middleware(next)
+	audio := audioLimits.Middleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/audio/transcriptions") {
+			audio.ServeHTTP(w, r)
+			return
+		}
+		checked.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/ratelimit"
+)
+
+var limiter *ratelimit.Limiter
+
+func init() {
+	// Central rate-limiter with local fallback (RATE_LIMIT_MODE=central|local)
+	var err error
+	limiter, err = ratelimit.NewFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure rate limiter: %v", err)
+	}
+}
+
+// RateLimitMiddleware rejects requests over the limit with 429
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Health checks and metrics are never limited
+		if r.URL.Path == "/health" || r.URL.Path == "/livez" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decision := limiter.Allow(r.Context(), r.Header.Get("Authorization"), r.URL.Path)
+		decision.SetHeaders(w.Header())
+		if !decision.Allowed {
+			apierror.New(http.StatusTooManyRequests, "Rate limit exceeded").WithCode("rate_limit_exceeded").Write(w)
+			return
+		}
+
+		// The concurrency limiter keys on the client the ticket was issued to
+		next.ServeHTTP(w, r.WithContext(ratelimit.WithTicket(r.Context(), decision.Ticket)))
+	})
+}
@@ -33,8 +33,9 @@ func TestProviderManagement(t *testing.T) {
 
 	// Test 2: Add a new provider
 	newProvider := map[string]interface{}{
+		"name":           "newprovider",
 		"base_url":       "https://api.newprovider.com",
-		"api_key":        "test-api-key",
+		"api_key_secret": "llm/newprovider/api_key",
 		"model_names":     []string{"new-model-1", "new-model-2"},
 		"weight":          2,
 		"max_concurrency": 5,
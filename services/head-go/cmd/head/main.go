@@ -6,28 +6,103 @@ import (
     "os/signal"
     "syscall"
     "time"
+    "github.com/go-redis/redis/v8"
+    "github.com/MaksimVF/ZB/pkg/featureflags"
     "github.com/yourorg/head/internal/config"
     "github.com/yourorg/head/internal/metrics"
+    "github.com/yourorg/head/internal/models"
+    "github.com/yourorg/head/internal/registration"
     "github.com/yourorg/head/internal/server"
+    "github.com/yourorg/head/internal/speculative"
+    "github.com/yourorg/head/internal/webhook"
 )
 func main(){
     // Load static configuration
     cfg := config.Load()
 
     // Initialize network config manager
-    networkConfigManager := config.NewNetworkConfigManager("redis:6379")
+    networkConfigManager := config.NewNetworkConfigManager(cfg.RedisAddr)
     err := networkConfigManager.LoadConfig()
     if err != nil {
         log.Printf("Failed to load network config: %v", err)
     }
 
-    // Start auto-reload for network config
-    networkConfigManager.StartAutoReload(10 * time.Second)
+    // Reload network config on change notifications; polling is only a fallback
+    networkConfigManager.StartAutoReload(60 * time.Second)
+
+    // Model registry is stored in Redis and hot-reloaded on change
+    appCtx, stopApp := context.WithCancel(context.Background())
+    defer stopApp()
+    rdb := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+    modelStore := models.NewStore(rdb, cfg.ModelRegistry)
+    if err := modelStore.Seed(appCtx); err != nil {
+        log.Printf("Failed to seed model registry: %v", err)
+    }
+    if err := modelStore.Load(appCtx); err != nil {
+        log.Printf("Failed to load model registry: %v", err)
+    }
+    modelStore.Watch(appCtx, 30*time.Second)
+
+    // Feature flags live in Redis, seeded with the static defaults, and are
+    // changed through the gateway admin API; every head reloads them on change
+    flagStore := featureflags.NewStore(rdb)
+    defaults := cfg.FeaturesConfig.Defaults()
+    if err := flagStore.Seed(appCtx, defaults); err != nil {
+        log.Printf("Failed to seed feature flags: %v", err)
+    }
+    flags := featureflags.New("head", flagStore, defaults...)
+    if err := flags.Load(appCtx); err != nil {
+        log.Printf("Failed to load feature flags: %v", err)
+    }
+    flags.Watch(appCtx, 30*time.Second)
+    cfg.FeaturesConfig.UseFlags(flags)
 
     go metrics.Start(cfg.MetricsPort)
     srv := server.New(cfg, networkConfigManager)
+    srv.SetModelStore(modelStore)
+
+    // API keys with a policy race their requests against a second model
+    srv.SetSpeculativeStore(speculative.NewStore(rdb))
+
+    // Deliver events to endpoints registered via /v1/webhooks
+    dispatcher := webhook.NewDispatcher(rdb, cfg.WebhookConfig.Timeout, 8, 30*time.Second)
+    dispatcher.Start(appCtx, 5*time.Second)
+    srv.SetWebhookDispatcher(dispatcher)
     errCh := make(chan error,1)
     go func(){ errCh <- srv.Run() }()
+
+    // Announce this head to routing-service and keep heartbeats going
+    var registrar *registration.Registrar
+    regCtx, stopRegistration := context.WithCancel(context.Background())
+    if cfg.Routing.Enabled {
+        registrar, err = registration.New(cfg.Routing, cfg.ModelRegistry, srv.LoadPercent)
+        if err != nil {
+            log.Printf("Routing registration disabled: %v", err)
+        } else {
+            // Only announce models model-proxy actually serves
+            registrar.SetModelFilter(srv.ServesModel)
+            go registrar.Run(regCtx)
+            // Keep routing-service capabilities in sync with the registry and model-proxy
+            cfg.ModelRegistry.OnChange(func() { registrar.Sync(regCtx) })
+            srv.Capacity().OnChange(func() { registrar.Sync(regCtx) })
+            // Maintenance: a drain from the admin API or routing-service takes
+            // the head out of routing, and it deregisters once idle
+            srv.SetDrainHooks(func(drain bool) {
+                if drain {
+                    registrar.Drain(regCtx)
+                } else {
+                    registrar.Resume(regCtx)
+                }
+            }, func() { registrar.Deregister(regCtx) })
+            registrar.OnDrainRequested(func(drain bool) {
+                if drain {
+                    srv.StartDrain()
+                } else {
+                    srv.StopDrain()
+                }
+            })
+        }
+    }
     sig := make(chan os.Signal,1)
     signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
     select {
@@ -36,7 +111,26 @@ func main(){
     case e := <-errCh:
         log.Printf("server error %v", e)
     }
-    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-    defer cancel()
-    _ = srv.Shutdown(ctx)
+    // Stop receiving new traffic: routing-service sees the head as draining
+    // and stops picking it, while in-flight streams run up to the drain timeout
+    stopRegistration()
+    notifyCtx, cancelNotify := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancelNotify()
+    if registrar != nil {
+        registrar.Drain(notifyCtx)
+    }
+
+    log.Printf("draining in-flight requests for up to %s", cfg.DrainTimeout)
+    drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+    defer cancelDrain()
+    if err := srv.Shutdown(drainCtx); err != nil {
+        log.Printf("drain incomplete: %v", err)
+    }
+
+    if registrar != nil {
+        offlineCtx, cancelOffline := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancelOffline()
+        registrar.Deregister(offlineCtx)
+        registrar.Close()
+    }
 }
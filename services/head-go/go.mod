@@ -3,6 +3,7 @@ module github.com/MaksimVF/ZB/services/head-go
 go 1.21
 
 require (
+    github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
     github.com/golang-jwt/jwt/v5 v5.2.0
     github.com/grpc-ecosystem/grpc-gateway/v2 v2.16.0
     github.com/sony/gobreaker v2.0.0+incompatible
@@ -17,3 +18,5 @@ require (
     github.com/grpc-ecosystem/go-grpc-prometheus v2.0.0
     github.com/prometheus/client_golang v1.17.0
 )
+
+replace github.com/MaksimVF/ZB => ../..
@@ -6,6 +6,7 @@ package auth
 import (
     "context"
     "errors"
+    "net/http"
     "strings"
     "time"
 
@@ -173,3 +174,37 @@ func GetClaimsFromContext(ctx context.Context) (*TokenClaims, bool) {
 }
 
 
+
+// HasRole reports whether the claims include the given role
+func (c *TokenClaims) HasRole(role string) bool {
+    for _, r := range c.Roles {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
+
+// RequireRole protects an HTTP handler with a Bearer token carrying the given role.
+// An empty role accepts any valid token.
+func (a *Authenticator) RequireRole(role string, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if token == "" || token == r.Header.Get("Authorization") {
+            http.Error(w, `{"error":"missing authorization header"}`, http.StatusUnauthorized)
+            return
+        }
+
+        claims, err := a.ValidateToken(token)
+        if err != nil {
+            http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+            return
+        }
+        if role != "" && !claims.HasRole(role) {
+            http.Error(w, `{"error":"forbidden"}`, http.StatusForbidden)
+            return
+        }
+
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "claims", claims)))
+    })
+}
@@ -1,6 +1,8 @@
 package config
 import (
     "os"
+    "strconv"
+    "strings"
     "time"
 )
 
@@ -9,10 +11,60 @@ type Config struct {
     GRPCAddr        string
     MetricsPort     int
     ModelProxyAddr  string
+    // ModelProxyHealthInterval is how often model-proxy capacity is polled
+    ModelProxyHealthInterval time.Duration
+    RedisAddr       string
     AuthConfig      AuthConfig
     FeaturesConfig   *FeaturesConfig
     WebhookConfig   WebhookConfig
     ModelRegistry   *ModelRegistry
+    Routing         RoutingConfig
+    Admission       AdmissionConfig
+    Scaling         ScalingConfig
+    // DrainTimeout is how long in-flight requests and streams may run after
+    // SIGTERM before they are cancelled
+    DrainTimeout    time.Duration
+}
+
+// AdmissionConfig controls per-model concurrency limits
+type AdmissionConfig struct {
+    // MaxInFlight is the default limit for models without their own max_concurrent (0 = unlimited)
+    MaxInFlight  int
+    // Mode is "queue" (wait up to QueueTimeout for a slot) or "reject"
+    Mode         string
+    QueueTimeout time.Duration
+    // ProxyMaxQueue rejects requests while model-proxy has this many
+    // generations queued (0 = off)
+    ProxyMaxQueue int
+    // ProxyMaxGPUUtilization rejects requests while model-proxy GPUs are at
+    // least this busy, in percent (0 = off)
+    ProxyMaxGPUUtilization float64
+}
+
+// ScalingConfig controls the demand signals exported for autoscalers
+type ScalingConfig struct {
+    // Window is the period tokens/sec and P95 latency are computed over
+    Window            time.Duration
+    // LatencySLO is the P95 latency models should stay under
+    LatencySLO        time.Duration
+    // ModelSLOs overrides LatencySLO per model
+    ModelSLOs         map[string]time.Duration
+    // TargetUtilization is the share of a model's in-flight limit a head
+    // should run at, 0 to 1
+    TargetUtilization float64
+    // Metrics exports the signals as Prometheus gauges, e.g. for KEDA
+    Metrics           bool
+}
+
+// RoutingConfig holds routing-service registration configuration
+type RoutingConfig struct {
+    Enabled           bool
+    ServiceAddr       string
+    HeadID            string
+    AdvertiseAddr     string
+    Region            string
+    Version           string
+    HeartbeatInterval time.Duration
 }
 
 // AuthConfig holds authentication configuration
@@ -36,6 +88,8 @@ func Load() *Config {
         GRPCAddr:       ":50055",
         MetricsPort:    9001,
         ModelProxyAddr: os.Getenv("MODEL_ADDR"),
+        ModelProxyHealthInterval: time.Duration(getEnvInt("HEAD_PROXY_HEALTH_INTERVAL_MS", 5000)) * time.Millisecond,
+        RedisAddr:      getEnv("REDIS_ADDR", "redis:6379"),
         AuthConfig: AuthConfig{
             JWTSecret:       getEnv("JWT_SECRET", "default-secret-key"),
             TokenExpiration: 24 * time.Hour,
@@ -49,7 +103,55 @@ func Load() *Config {
             Enabled:       true,
         },
         ModelRegistry: DefaultModelRegistry(),
+        Routing: RoutingConfig{
+            Enabled:           getEnv("ROUTING_REGISTRATION", "true") == "true",
+            ServiceAddr:       getEnv("ROUTING_SERVICE_ADDR", "routing-service:50055"),
+            HeadID:            getEnv("HEAD_ID", hostname()),
+            AdvertiseAddr:     getEnv("HEAD_ADVERTISE_ADDR", "grpc://head:50055"),
+            Region:            getEnv("HEAD_REGION", "default"),
+            Version:           getEnv("HEAD_VERSION", "1.0.0"),
+            HeartbeatInterval: 10 * time.Second,
+        },
+        Admission: AdmissionConfig{
+            MaxInFlight:  getEnvInt("HEAD_MODEL_MAX_INFLIGHT", 100),
+            Mode:         getEnv("HEAD_ADMISSION_MODE", "queue"),
+            QueueTimeout: time.Duration(getEnvInt("HEAD_ADMISSION_QUEUE_TIMEOUT_MS", 2000)) * time.Millisecond,
+            ProxyMaxQueue: getEnvInt("HEAD_PROXY_MAX_QUEUE", 50),
+            ProxyMaxGPUUtilization: float64(getEnvInt("HEAD_PROXY_MAX_GPU_UTILIZATION", 95)),
+        },
+        Scaling: ScalingConfig{
+            Window:            time.Duration(getEnvInt("HEAD_SCALING_WINDOW_MS", 60000)) * time.Millisecond,
+            LatencySLO:        time.Duration(getEnvInt("HEAD_SCALING_LATENCY_SLO_MS", 10000)) * time.Millisecond,
+            ModelSLOs:         parseModelDurations(os.Getenv("HEAD_SCALING_MODEL_SLOS_MS")),
+            TargetUtilization: float64(getEnvInt("HEAD_SCALING_TARGET_UTILIZATION", 70)) / 100,
+            Metrics:           getEnv("HEAD_SCALING_METRICS", "false") == "true",
+        },
+        DrainTimeout: time.Duration(getEnvInt("HEAD_DRAIN_TIMEOUT_MS", 30000)) * time.Millisecond,
+    }
+}
+
+// parseModelDurations parses "model=ms,model=ms"; invalid entries are skipped
+func parseModelDurations(value string) map[string]time.Duration {
+    durations := make(map[string]time.Duration)
+    for _, entry := range strings.Split(value, ",") {
+        model, ms, ok := strings.Cut(strings.TrimSpace(entry), "=")
+        if !ok {
+            continue
+        }
+        if n, err := strconv.Atoi(ms); err == nil && n > 0 {
+            durations[model] = time.Duration(n) * time.Millisecond
+        }
+    }
+    return durations
+}
+
+// hostname returns the host name used as the default head ID
+func hostname() string {
+    name, err := os.Hostname()
+    if err != nil {
+        return "head"
     }
+    return name
 }
 
 // getEnv returns the environment variable value or a default
@@ -59,3 +161,11 @@ func getEnv(key, defaultValue string) string {
     }
     return defaultValue
 }
+
+// getEnvInt returns the environment variable as an int or a default
+func getEnvInt(key string, defaultValue int) int {
+    if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+        return value
+    }
+    return defaultValue
+}
@@ -5,6 +5,8 @@ package config
 
 import (
     "sync"
+
+    "github.com/MaksimVF/ZB/pkg/featureflags"
 )
 
 // Feature represents a feature toggle
@@ -18,6 +20,8 @@ type Feature struct {
 type FeaturesConfig struct {
     mu       sync.RWMutex
     features map[string]*Feature
+    // Dynamic flags from Redis; without them the static values apply
+    flags *featureflags.Flags
 }
 
 // NewFeaturesConfig creates a new features configuration
@@ -39,17 +43,52 @@ func (f *FeaturesConfig) AddFeature(name, description string, enabled bool) {
     }
 }
 
-// IsEnabled checks if a feature is enabled
+// IsEnabled checks if a feature is enabled for the service
 func (f *FeaturesConfig) IsEnabled(name string) bool {
+    return f.IsEnabledFor(name, "")
+}
+
+// IsEnabledFor checks if a feature is enabled for a tenant. With dynamic
+// flags tenant and service overrides apply, otherwise the static value.
+func (f *FeaturesConfig) IsEnabledFor(name, tenant string) bool {
     f.mu.RLock()
     defer f.mu.RUnlock()
 
+    if f.flags != nil {
+        return f.flags.Enabled(name, tenant)
+    }
     if feature, ok := f.features[name]; ok {
         return feature.Enabled
     }
     return false
 }
 
+// UseFlags switches evaluation to dynamic flags, e.g. from the Redis store
+// managed through the gateway admin API
+func (f *FeaturesConfig) UseFlags(flags *featureflags.Flags) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    f.flags = flags
+}
+
+// Defaults returns the static features as flags, to seed the store with and
+// to fall back to for flags that are not stored
+func (f *FeaturesConfig) Defaults() []featureflags.Flag {
+    f.mu.RLock()
+    defer f.mu.RUnlock()
+
+    defaults := make([]featureflags.Flag, 0, len(f.features))
+    for _, feature := range f.features {
+        defaults = append(defaults, featureflags.Flag{
+            Name:        feature.Name,
+            Description: feature.Description,
+            Enabled:     feature.Enabled,
+        })
+    }
+    return defaults
+}
+
 // SetEnabled enables or disables a feature
 func (f *FeaturesConfig) SetEnabled(name string, enabled bool) {
     f.mu.Lock()
@@ -86,6 +125,7 @@ func DefaultFeatures() *FeaturesConfig {
     features.AddFeature("model_registry", "Enable model registry and A/B testing", true)
     features.AddFeature("ab_testing", "Enable A/B testing for models", true)
     features.AddFeature("embedding", "Enable embedding functionality", true)
+    features.AddFeature("fault_injection", "Inject the faults of FAULT_INJECTION_RULES where FAULT_INJECTION_ENABLED is set", false)
 
     return features
 }
@@ -0,0 +1,209 @@
+// Package contextwindow fits a conversation into a model's context window
+// before it is sent to model-proxy, instead of letting the provider reject
+// it. System messages and the last message are always kept; older turns are
+// dropped (sliding_window) or summarized (summarize).
+package contextwindow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+)
+
+// Strategies for a conversation that does not fit the window
+const (
+	// StrategyFail rejects the request
+	StrategyFail = "fail"
+	// StrategySlidingWindow drops the oldest messages
+	StrategySlidingWindow = "sliding_window"
+	// StrategySummarize replaces the middle of the conversation with a
+	// summary and keeps the most recent turns
+	StrategySummarize = "summarize"
+)
+
+// ErrTooLong is returned when the conversation cannot be made to fit: the
+// strategy is fail, or the system messages and the last message alone
+// exceed the window
+var ErrTooLong = errors.New("prompt exceeds the model's context window")
+
+// DefaultWindows are the context windows, in tokens, of models whose
+// registry entry sets none
+var DefaultWindows = map[string]int{
+	"gpt-4o":          128000,
+	"gpt-4-turbo":     128000,
+	"claude-3-opus":   200000,
+	"claude-3-sonnet": 200000,
+	"llama3-70b":      8192,
+	"gemini-pro":      32760,
+}
+
+// summaryPrefix introduces the summary in the system message that replaces
+// the summarized turns
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+// summaryInstruction asks the model for the summary
+const summaryInstruction = "Summarize the following conversation so that it can be continued without it. " +
+	"Keep facts, names, numbers, decisions and open questions; answer with the summary only."
+
+// Message is a chat message
+type Message struct {
+	Role    string
+	Content string
+}
+
+// Summarizer returns a summary of the transcript and the tokens it used
+type Summarizer func(ctx context.Context, instruction, transcript string) (string, int, error)
+
+// Policy is how a model's conversations are fitted
+type Policy struct {
+	// Window is the context window in tokens; 0 disables fitting
+	Window int
+	// Reserve is kept free for the completion, usually max_tokens
+	Reserve  int
+	Strategy string
+}
+
+// Result is the fitted conversation
+type Result struct {
+	Messages []Message
+	// Strategy is the strategy that changed the conversation, "" if it fit
+	Strategy string
+	// Dropped and Summarized count the messages removed and summarized
+	Dropped    int
+	Summarized int
+	// SummaryTokens are the tokens used by the summarizer
+	SummaryTokens int
+}
+
+// Valid reports whether strategy is a known strategy; "" is the default
+func Valid(strategy string) bool {
+	switch strategy {
+	case "", StrategyFail, StrategySlidingWindow, StrategySummarize:
+		return true
+	}
+	return false
+}
+
+// Fit returns messages fitted into the window of the policy. If summarize
+// fails the conversation falls back to a sliding window.
+func Fit(ctx context.Context, model string, messages []Message, policy Policy, summarize Summarizer) (Result, error) {
+	budget := policy.Window - policy.Reserve
+	if policy.Window <= 0 || count(model, messages) <= budget {
+		return Result{Messages: messages}, nil
+	}
+
+	switch policy.Strategy {
+	case StrategyFail:
+		return Result{}, ErrTooLong
+	case StrategySummarize:
+		if summarize != nil {
+			result, err := summarizeMiddle(ctx, model, messages, budget, summarize)
+			if err == nil {
+				return result, nil
+			}
+		}
+	}
+
+	kept, dropped := slide(model, messages, budget)
+	if kept == nil {
+		return Result{}, ErrTooLong
+	}
+	return Result{Messages: kept, Strategy: StrategySlidingWindow, Dropped: dropped}, nil
+}
+
+// split separates the leading system messages, which are always kept
+func split(messages []Message) (system, rest []Message) {
+	i := 0
+	for i < len(messages) && messages[i].Role == "system" {
+		i++
+	}
+	return messages[:i], messages[i:]
+}
+
+// slide drops the oldest messages after the system messages until the rest
+// fits; nil if even the last message does not
+func slide(model string, messages []Message, budget int) ([]Message, int) {
+	system, rest := split(messages)
+	for dropped := 0; dropped < len(rest); dropped++ {
+		kept := append(append([]Message{}, system...), rest[dropped:]...)
+		if count(model, kept) <= budget {
+			return kept, dropped
+		}
+	}
+	return nil, 0
+}
+
+// summarizeMiddle keeps the system messages and the most recent turns that
+// fit in half of the budget, and replaces the turns between them with a
+// summary
+func summarizeMiddle(ctx context.Context, model string, messages []Message, budget int, summarize Summarizer) (Result, error) {
+	system, rest := split(messages)
+	if len(rest) < 2 {
+		return Result{}, errors.New("nothing to summarize")
+	}
+
+	// The last message is kept even if it alone takes more than half
+	tail := len(rest) - 1
+	for tail > 0 && count(model, rest[tail-1:]) <= budget/2 {
+		tail--
+	}
+	middle := rest[:tail]
+	if len(middle) == 0 {
+		return Result{}, errors.New("nothing to summarize")
+	}
+
+	// The summarizer has the same window: a middle too long for it is
+	// summarized from its most recent part
+	for len(middle) > 1 && count(model, middle) > budget {
+		middle = middle[1:]
+	}
+
+	summary, tokens, err := summarize(ctx, summaryInstruction, transcript(middle))
+	if err != nil {
+		return Result{}, fmt.Errorf("summarize: %w", err)
+	}
+
+	fitted := append([]Message{}, system...)
+	fitted = append(fitted, Message{Role: "system", Content: summaryPrefix + strings.TrimSpace(summary)})
+	fitted = append(fitted, rest[tail:]...)
+	result := Result{
+		Messages:      fitted,
+		Strategy:      StrategySummarize,
+		Dropped:       tail - len(middle),
+		Summarized:    len(middle),
+		SummaryTokens: tokens,
+	}
+
+	// A long summary may still not fit; the oldest kept turns go first
+	if count(model, fitted) > budget {
+		kept, dropped := slide(model, fitted, budget)
+		if kept == nil {
+			return Result{}, ErrTooLong
+		}
+		result.Messages = kept
+		result.Dropped += dropped
+	}
+	return result, nil
+}
+
+func transcript(messages []Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		b.WriteString(m.Role)
+		b.WriteString(": ")
+		b.WriteString(m.Content)
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+func count(model string, messages []Message) int {
+	converted := make([]tokenizer.Message, len(messages))
+	for i, m := range messages {
+		converted[i] = tokenizer.Message{Role: m.Role, Content: m.Content}
+	}
+	return tokenizer.CountMessages(model, converted)
+}
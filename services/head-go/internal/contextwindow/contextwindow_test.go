@@ -0,0 +1,298 @@
+package contextwindow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testModel = "gpt-4"
+
+// turns returns n alternating user and assistant messages of about size
+// tokens each, numbered so they can be told apart
+func turns(n, size int) []Message {
+	messages := make([]Message, n)
+	for i := range messages {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages[i] = Message{Role: role, Content: fmt.Sprintf("turn %d:%s", i, strings.Repeat(" lorem", size))}
+	}
+	return messages
+}
+
+func join(parts ...[]Message) []Message {
+	var all []Message
+	for _, p := range parts {
+		all = append(all, p...)
+	}
+	return all
+}
+
+// summarizer answers with summary and counts its calls
+func summarizer(summary string, err error, calls *int) Summarizer {
+	return func(ctx context.Context, instruction, transcript string) (string, int, error) {
+		*calls++
+		if err != nil {
+			return "", 0, err
+		}
+		return summary, 7, nil
+	}
+}
+
+func TestFit(t *testing.T) {
+	system := []Message{{Role: "system", Content: "You are a helpful assistant."}}
+	history := turns(8, 40)
+	last := Message{Role: "user", Content: "And what comes next?"}
+	conversation := join(system, history, []Message{last})
+
+	// The budget of the system message and the three most recent messages
+	recent := join(system, history[6:], []Message{last})
+	budget := count(testModel, recent)
+	tight := Policy{Window: budget + 100, Reserve: 100}
+
+	huge := join(system, []Message{{Role: "user", Content: strings.Repeat(" lorem", 2*budget)}})
+
+	tests := []struct {
+		name     string
+		messages []Message
+		policy   Policy
+		summary  string
+		err      error
+		// summarizerCalls is how often the summarizer must be called
+		summarizerCalls int
+		wantErr         error
+		strategy        string
+		check           func(t *testing.T, result Result)
+	}{
+		{
+			name:     "fitting disabled",
+			messages: conversation,
+			policy:   Policy{Strategy: StrategyFail},
+			check: func(t *testing.T, result Result) {
+				if len(result.Messages) != len(conversation) {
+					t.Errorf("%d messages, want all %d", len(result.Messages), len(conversation))
+				}
+			},
+		},
+		{
+			name:     "fits",
+			messages: recent,
+			policy:   Policy{Window: budget + 100, Reserve: 100, Strategy: StrategyFail},
+			check: func(t *testing.T, result Result) {
+				if len(result.Messages) != len(recent) || result.Dropped != 0 {
+					t.Errorf("result %+v, want the messages unchanged", result)
+				}
+			},
+		},
+		{
+			name:     "fail",
+			messages: conversation,
+			policy:   Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategyFail},
+			wantErr:  ErrTooLong,
+		},
+		{
+			name:     "sliding window keeps the system message and the most recent turns",
+			messages: conversation,
+			policy:   Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategySlidingWindow},
+			strategy: StrategySlidingWindow,
+			check: func(t *testing.T, result Result) {
+				if fmt.Sprint(result.Messages) != fmt.Sprint(recent) || result.Dropped != 6 {
+					t.Errorf("kept %v, dropped %d; want the system message and the last 3 messages", result.Messages, result.Dropped)
+				}
+			},
+		},
+		{
+			name:     "unknown strategy slides",
+			messages: conversation,
+			policy:   Policy{Window: tight.Window, Reserve: tight.Reserve},
+			strategy: StrategySlidingWindow,
+		},
+		{
+			name:     "summarize",
+			messages: conversation,
+			policy:   Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategySummarize},
+			// The summary fits in the room of the summarized turns
+			summary:         "  The user asked about lorem.  ",
+			summarizerCalls: 1,
+			strategy:        StrategySummarize,
+			check: func(t *testing.T, result Result) {
+				if result.Messages[0] != system[0] || result.Messages[len(result.Messages)-1] != last {
+					t.Errorf("messages %v, want the system message first and the last message last", result.Messages)
+				}
+				if got := result.Messages[1]; got != (Message{Role: "system", Content: summaryPrefix + "The user asked about lorem."}) {
+					t.Errorf("summary message %+v", got)
+				}
+				kept := len(result.Messages) - 2
+				if result.Summarized == 0 || result.Summarized+result.Dropped+kept != len(history)+1 {
+					t.Errorf("summarized %d, dropped %d, kept %d of %d messages", result.Summarized, result.Dropped, kept, len(history)+1)
+				}
+				if result.SummaryTokens != 7 {
+					t.Errorf("summary tokens %d, want those reported by the summarizer", result.SummaryTokens)
+				}
+			},
+		},
+		{
+			name:            "summarizer fails",
+			messages:        conversation,
+			policy:          Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategySummarize},
+			err:             errors.New("model-proxy unavailable"),
+			summarizerCalls: 1,
+			strategy:        StrategySlidingWindow,
+			check: func(t *testing.T, result Result) {
+				if fmt.Sprint(result.Messages) != fmt.Sprint(recent) || result.Dropped != 6 || result.Summarized != 0 {
+					t.Errorf("result %+v, want the sliding window", result)
+				}
+			},
+		},
+		{
+			name:            "summary still does not fit",
+			messages:        conversation,
+			policy:          Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategySummarize},
+			summary:         strings.Repeat(" lorem", 2*budget),
+			summarizerCalls: 1,
+			strategy:        StrategySlidingWindow,
+			check: func(t *testing.T, result Result) {
+				if fmt.Sprint(result.Messages) != fmt.Sprint(recent) || result.SummaryTokens != 0 {
+					t.Errorf("result %+v, want the sliding window without the summary", result)
+				}
+			},
+		},
+		{
+			name:     "system messages and a huge last message, sliding window",
+			messages: huge,
+			policy:   Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategySlidingWindow},
+			wantErr:  ErrTooLong,
+		},
+		{
+			name:     "system messages and a huge last message, summarize",
+			messages: huge,
+			policy:   Policy{Window: tight.Window, Reserve: tight.Reserve, Strategy: StrategySummarize},
+			summary:  "nothing",
+			// There is nothing between the system messages and the last one
+			summarizerCalls: 0,
+			wantErr:         ErrTooLong,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			result, err := Fit(context.Background(), testModel, tt.messages, tt.policy, summarizer(tt.summary, tt.err, &calls))
+			if calls != tt.summarizerCalls {
+				t.Errorf("summarizer called %d times, want %d", calls, tt.summarizerCalls)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Strategy != tt.strategy {
+				t.Errorf("strategy %q, want %q", result.Strategy, tt.strategy)
+			}
+			if budget := tt.policy.Window - tt.policy.Reserve; tt.policy.Window > 0 && count(testModel, result.Messages) > budget {
+				t.Errorf("%d tokens, over the budget of %d", count(testModel, result.Messages), budget)
+			}
+			if tt.check != nil {
+				tt.check(t, result)
+			}
+		})
+	}
+}
+
+func TestSlide(t *testing.T) {
+	system := []Message{{Role: "system", Content: "Be brief."}, {Role: "system", Content: "Answer in English."}}
+	history := turns(5, 20)
+	messages := join(system, history)
+
+	tests := []struct {
+		name    string
+		budget  int
+		kept    []Message
+		dropped int
+	}{
+		{name: "everything fits", budget: count(testModel, messages), kept: messages},
+		{name: "oldest turns dropped", budget: count(testModel, join(system, history[3:])), kept: join(system, history[3:]), dropped: 3},
+		{name: "only the last message", budget: count(testModel, join(system, history[4:])), kept: join(system, history[4:]), dropped: 4},
+		{name: "not even the last message", budget: count(testModel, join(system, history[4:])) - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped := slide(testModel, messages, tt.budget)
+			if fmt.Sprint(kept) != fmt.Sprint(tt.kept) || dropped != tt.dropped {
+				t.Errorf("kept %v, dropped %d; want %v, %d", kept, dropped, tt.kept, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestSummarizeMiddle(t *testing.T) {
+	system := []Message{{Role: "system", Content: "You are a helpful assistant."}}
+	last := Message{Role: "user", Content: "Go on."}
+
+	t.Run("middle longer than the budget is summarized from its most recent part", func(t *testing.T) {
+		history := turns(12, 40)
+		messages := join(system, history, []Message{last})
+		budget := count(testModel, join(system, history[8:], []Message{last}))
+
+		var seen string
+		result, err := summarizeMiddle(context.Background(), testModel, messages, budget, func(ctx context.Context, instruction, transcript string) (string, int, error) {
+			if instruction != summaryInstruction {
+				t.Errorf("instruction %q", instruction)
+			}
+			seen = transcript
+			return "summary", 3, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(seen, "turn 0:") || result.Dropped == 0 {
+			t.Errorf("dropped %d, transcript %q; want the oldest turns left out of the summary", result.Dropped, seen)
+		}
+		if !strings.Contains(seen, fmt.Sprintf("turn %d:", result.Dropped+result.Summarized-1)) {
+			t.Errorf("transcript %q lacks the most recent summarized turn", seen)
+		}
+		kept := len(result.Messages) - 2
+		if result.Dropped+result.Summarized+kept != len(history)+1 {
+			t.Errorf("dropped %d, summarized %d, kept %d of %d messages", result.Dropped, result.Summarized, kept, len(history)+1)
+		}
+		if count(testModel, result.Messages) > budget {
+			t.Errorf("%d tokens, over the budget of %d", count(testModel, result.Messages), budget)
+		}
+	})
+
+	nothing := []struct {
+		name     string
+		messages []Message
+		budget   int
+	}{
+		{name: "only the last message", messages: join(system, []Message{last}), budget: 1},
+		{name: "recent turns fit in half the budget", messages: join(system, turns(2, 5), []Message{last}), budget: 1000},
+	}
+	for _, tt := range nothing {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			if _, err := summarizeMiddle(context.Background(), testModel, tt.messages, tt.budget, summarizer("summary", nil, &calls)); err == nil || calls != 0 {
+				t.Errorf("err %v, %d summarizer calls; want nothing to summarize", err, calls)
+			}
+		})
+	}
+
+	t.Run("summary that does not fit", func(t *testing.T) {
+		history := turns(6, 40)
+		messages := join(system, history, []Message{last})
+		budget := count(testModel, join(system, history[4:], []Message{last}))
+		calls := 0
+		_, err := summarizeMiddle(context.Background(), testModel, messages, budget, summarizer(strings.Repeat(" lorem", 2*budget), nil, &calls))
+		if !errors.Is(err, ErrTooLong) || calls != 1 {
+			t.Errorf("err %v after %d summarizer calls; want ErrTooLong", err, calls)
+		}
+	})
+}
@@ -21,8 +21,10 @@ import (
 
     gen "github.com/yourorg/head/gen"
     model "github.com/yourorg/head/gen_model"
+    "github.com/yourorg/head/internal/auth"
     "github.com/yourorg/head/internal/config"
     "github.com/yourorg/head/internal/metrics"
+    "github.com/MaksimVF/ZB/pkg/httpmetrics"
     "github.com/yourorg/head/internal/models"
     "github.com/yourorg/head/internal/webhook"
 )
@@ -60,7 +62,7 @@ func (s *EmbeddingService) CreateEmbedding(ctx context.Context, req *gen.Embeddi
     )
 
     // Check if model registry is enabled
-    if s.cfg.FeaturesConfig.IsEnabled("model_registry") {
+    if s.cfg.FeaturesConfig.IsEnabledFor("model_registry", tenantID(ctx)) {
         // Use model registry to get model configuration
         modelConfig, ok := s.registry.GetModel(req.Model)
         if !ok {
@@ -103,10 +105,10 @@ func (s *EmbeddingService) CreateEmbedding(ctx context.Context, req *gen.Embeddi
     embedding = []float32{0.1, 0.2, 0.3, 0.4, 0.5} // Simulated embedding
 
     metrics.requestsTotal.WithLabelValues(req.Model, "ok").Inc()
-    metrics.requestLatency.WithLabelValues(req.Model).Observe(time.Since(start).Seconds())
+    httpmetrics.Observe(ctx, metrics.requestLatency.WithLabelValues(req.Model), time.Since(start).Seconds())
 
     // Send webhook notification
-    if s.cfg.FeaturesConfig.IsEnabled("webhook") {
+    if s.cfg.FeaturesConfig.IsEnabledFor("webhook", tenantID(ctx)) {
         webhookData := map[string]interface{}{
             "request_id":   req.RequestId,
             "model":       req.Model,
@@ -139,7 +141,7 @@ func (s *EmbeddingService) CreateEmbeddingBatch(ctx context.Context, req *gen.Em
     )
 
     // Check if model registry is enabled
-    if s.cfg.FeaturesConfig.IsEnabled("model_registry") {
+    if s.cfg.FeaturesConfig.IsEnabledFor("model_registry", tenantID(ctx)) {
         // Use model registry to get model configuration
         modelConfig, ok := s.registry.GetModel(req.Model)
         if !ok {
@@ -187,10 +189,10 @@ func (s *EmbeddingService) CreateEmbeddingBatch(ctx context.Context, req *gen.Em
     }
 
     metrics.requestsTotal.WithLabelValues(req.Model, "ok").Inc()
-    metrics.requestLatency.WithLabelValues(req.Model).Observe(time.Since(start).Seconds())
+    httpmetrics.Observe(ctx, metrics.requestLatency.WithLabelValues(req.Model), time.Since(start).Seconds())
 
     // Send webhook notification
-    if s.cfg.FeaturesConfig.IsEnabled("webhook") {
+    if s.cfg.FeaturesConfig.IsEnabledFor("webhook", tenantID(ctx)) {
         webhookData := map[string]interface{}{
             "request_id":   req.RequestId,
             "model":       req.Model,
@@ -207,7 +209,11 @@ func (s *EmbeddingService) CreateEmbeddingBatch(ctx context.Context, req *gen.Em
     }, nil
 }
 
-
-
-
-
+// tenantID is the user of the authenticated request, for per-tenant feature
+// flags; "" without authentication
+func tenantID(ctx context.Context) string {
+    if claims, ok := auth.GetClaimsFromContext(ctx); ok {
+        return claims.UserID
+    }
+    return ""
+}
@@ -0,0 +1,24 @@
+// Package identity holds the certificate the head serves to its clients and
+// presents to model-proxy and routing-service
+package identity
+
+import (
+	"sync"
+
+	"github.com/MaksimVF/ZB/pkg/certs"
+)
+
+var (
+	once    sync.Once
+	manager *certs.Manager
+	err     error
+)
+
+// Certs loads /certs/head.pem, or the SPIFFE or Vault identity configured in
+// the environment, on first use and keeps it fresh
+func Certs() (*certs.Manager, error) {
+	once.Do(func() {
+		manager, err = certs.Watch("head", "/certs/head.pem", "/certs/head-key.pem", "/certs/ca.pem")
+	})
+	return manager, err
+}
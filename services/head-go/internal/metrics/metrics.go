@@ -6,7 +6,8 @@ import (
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
 )
 
 var (
@@ -19,7 +20,8 @@ var (
 func Start(port int) {
 	prometheus.MustRegister(Requests, Latency)
 	addr := fmt.Sprintf(":%d", port)
-	http.Handle("/metrics", promhttp.Handler())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", httpmetrics.Handler())
 	log.Printf("metrics on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(http.ListenAndServe(addr, mux))
 }
@@ -8,6 +8,7 @@ package models
 
 import (
     "context"
+    "fmt"
     "math/rand"
     "sync"
     "time"
@@ -19,21 +20,30 @@ import (
 
 // ModelConfig holds model configuration
 type ModelConfig struct {
-    Name        string
-    Provider    string
-    Endpoint    string
-    APIKey      string
-    Weight      int
-    Enabled     bool
-    MaxTokens   int
-    Temperature  float32
+    Name           string  `json:"name"`
+    Provider       string  `json:"provider"`
+    Endpoint       string  `json:"endpoint"`
+    APIKey         string  `json:"-"`
+    Weight         int     `json:"weight"`
+    Enabled        bool    `json:"enabled"`
+    MaxTokens      int     `json:"max_tokens"`
+    Temperature    float32 `json:"temperature"`
+    MinTemperature float32 `json:"min_temperature"`
+    MaxTemperature float32 `json:"max_temperature"`
+    MaxConcurrent  int     `json:"max_concurrent,omitempty"`
+    // ContextWindow is the prompt and completion limit in tokens; 0 uses
+    // the known window of the model
+    ContextWindow   int    `json:"context_window,omitempty"`
+    // ContextStrategy fits longer prompts: fail, sliding_window or summarize
+    ContextStrategy string `json:"context_strategy,omitempty"`
 }
 
 // ModelRegistry manages available models
 type ModelRegistry struct {
-    mu      sync.RWMutex
-    models  map[string]*ModelConfig
-    weights []string
+    mu        sync.RWMutex
+    models    map[string]*ModelConfig
+    weights   []string
+    listeners []func()
 }
 
 // NewModelRegistry creates a new model registry
@@ -94,21 +104,94 @@ func (r *ModelRegistry) GetAllModels() map[string]*ModelConfig {
 // EnableModel enables a model
 func (r *ModelRegistry) EnableModel(name string) {
     r.mu.Lock()
-    defer r.mu.Unlock()
-
-    if model, ok := r.models[name]; ok {
+    model, ok := r.models[name]
+    if ok {
         model.Enabled = true
     }
+    r.mu.Unlock()
+
+    if ok {
+        r.notify()
+    }
 }
 
 // DisableModel disables a model
 func (r *ModelRegistry) DisableModel(name string) {
+    r.mu.Lock()
+    model, ok := r.models[name]
+    if ok {
+        model.Enabled = false
+    }
+    r.mu.Unlock()
+
+    if ok {
+        r.notify()
+    }
+}
+
+// ReplaceAll swaps the registry contents for the given models (hot reload)
+func (r *ModelRegistry) ReplaceAll(configs []ModelConfig) {
+    r.mu.Lock()
+    r.models = make(map[string]*ModelConfig, len(configs))
+    r.weights = nil
+    for i := range configs {
+        config := configs[i]
+        r.models[config.Name] = &config
+        for j := 0; j < config.Weight; j++ {
+            r.weights = append(r.weights, config.Name)
+        }
+    }
+    r.mu.Unlock()
+
+    r.notify()
+}
+
+// OnChange registers a listener called after models are enabled, disabled or reloaded
+func (r *ModelRegistry) OnChange(fn func()) {
     r.mu.Lock()
     defer r.mu.Unlock()
+    r.listeners = append(r.listeners, fn)
+}
 
-    if model, ok := r.models[name]; ok {
-        model.Enabled = false
+func (r *ModelRegistry) notify() {
+    r.mu.RLock()
+    listeners := append([]func(){}, r.listeners...)
+    r.mu.RUnlock()
+
+    for _, fn := range listeners {
+        fn()
+    }
+}
+
+// ValidateParams checks a request against the model's limits and returns
+// the temperature and max tokens to use. Zero values fall back to model defaults.
+func (r *ModelRegistry) ValidateParams(name string, temperature float32, maxTokens int32) (float32, int32, error) {
+    r.mu.RLock()
+    defer r.mu.RUnlock()
+
+    model, ok := r.models[name]
+    if !ok {
+        return 0, 0, fmt.Errorf("model %s is not registered", name)
+    }
+    if !model.Enabled {
+        return 0, 0, fmt.Errorf("model %s is disabled", name)
+    }
+
+    if temperature == 0 {
+        temperature = model.Temperature
     }
+    if model.MaxTemperature > 0 && (temperature < model.MinTemperature || temperature > model.MaxTemperature) {
+        return 0, 0, fmt.Errorf("temperature must be between %.2f and %.2f for model %s", model.MinTemperature, model.MaxTemperature, name)
+    }
+
+    if maxTokens == 0 {
+        maxTokens = int32(model.MaxTokens)
+    }
+    if model.MaxTokens > 0 && maxTokens > int32(model.MaxTokens) {
+        return 0, 0, fmt.Errorf("max_tokens must not exceed %d for model %s", model.MaxTokens, name)
+    }
+
+    return temperature, maxTokens, nil
 }
 
 // IsModelEnabled checks if a model is enabled
@@ -176,6 +259,8 @@ func DefaultModelRegistry() *ModelRegistry {
         Enabled:    true,
         MaxTokens:  4096,
         Temperature: 0.7,
+        MinTemperature: 0,
+        MaxTemperature: 2,
     })
 
     registry.RegisterModel(ModelConfig{
@@ -187,6 +272,8 @@ func DefaultModelRegistry() *ModelRegistry {
         Enabled:    true,
         MaxTokens:  4096,
         Temperature: 0.7,
+        MinTemperature: 0,
+        MaxTemperature: 2,
     })
 
     registry.RegisterModel(ModelConfig{
@@ -198,6 +285,8 @@ func DefaultModelRegistry() *ModelRegistry {
         Enabled:    true,
         MaxTokens:  4096,
         Temperature: 0.7,
+        MinTemperature: 0,
+        MaxTemperature: 2,
     })
 
     return registry
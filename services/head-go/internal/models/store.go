@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/yourorg/head/internal/contextwindow"
+)
+
+// Redis keys: models are stored as JSON in a hash keyed by model name, and
+// every change is announced on a pub/sub channel so all heads reload at once
+const (
+	modelsKey     = "head:models"
+	modelsChannel = "head:models:changed"
+)
+
+// Store keeps the model registry in Redis and hot-reloads it on change
+type Store struct {
+	rdb      *redis.Client
+	registry *ModelRegistry
+}
+
+// NewStore creates a Redis-backed store for the registry
+func NewStore(rdb *redis.Client, registry *ModelRegistry) *Store {
+	return &Store{rdb: rdb, registry: registry}
+}
+
+// Seed writes the current registry contents to Redis if no models are stored yet,
+// so the static defaults become the initial dynamic configuration
+func (s *Store) Seed(ctx context.Context) error {
+	n, err := s.rdb.HLen(ctx, modelsKey).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	for _, model := range s.registry.GetAllModels() {
+		if err := s.write(ctx, *model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load replaces the registry with the models stored in Redis
+func (s *Store) Load(ctx context.Context) error {
+	entries, err := s.rdb.HGetAll(ctx, modelsKey).Result()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// API keys are not stored in Redis; keep the ones from static config
+	current := s.registry.GetAllModels()
+
+	configs := make([]ModelConfig, 0, len(entries))
+	for name, raw := range entries {
+		var config ModelConfig
+		if err := json.Unmarshal([]byte(raw), &config); err != nil {
+			log.Printf("Skipping invalid model config %s: %v", name, err)
+			continue
+		}
+		if existing, ok := current[config.Name]; ok {
+			config.APIKey = existing.APIKey
+		}
+		configs = append(configs, config)
+	}
+
+	s.registry.ReplaceAll(configs)
+	return nil
+}
+
+// Put validates and stores a model config, then notifies all heads
+func (s *Store) Put(ctx context.Context, config ModelConfig) error {
+	if err := Validate(config); err != nil {
+		return err
+	}
+	if err := s.write(ctx, config); err != nil {
+		return err
+	}
+	return s.publish(ctx)
+}
+
+// SetEnabled enables or disables a stored model, then notifies all heads
+func (s *Store) SetEnabled(ctx context.Context, name string, enabled bool) (*ModelConfig, error) {
+	raw, err := s.rdb.HGet(ctx, modelsKey, name).Result()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("model %s not found", name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var config ModelConfig
+	if err := json.Unmarshal([]byte(raw), &config); err != nil {
+		return nil, err
+	}
+	config.Enabled = enabled
+
+	if err := s.write(ctx, config); err != nil {
+		return nil, err
+	}
+	return &config, s.publish(ctx)
+}
+
+// Watch reloads the registry on change notifications and, as a fallback for
+// missed messages, every interval until ctx is cancelled
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	sub := s.rdb.Subscribe(ctx, modelsChannel)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-messages:
+			case <-ticker.C:
+			}
+
+			if err := s.Load(ctx); err != nil {
+				log.Printf("Failed to reload models: %v", err)
+			}
+		}
+	}()
+}
+
+func (s *Store) write(ctx context.Context, config ModelConfig) error {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, modelsKey, config.Name, raw).Err()
+}
+
+func (s *Store) publish(ctx context.Context) error {
+	return s.rdb.Publish(ctx, modelsChannel, time.Now().Unix()).Err()
+}
+
+// Validate checks that a model config is usable
+func Validate(config ModelConfig) error {
+	if config.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if config.Provider == "" {
+		return fmt.Errorf("provider is required")
+	}
+	if config.Weight < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+	if config.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+	if config.MinTemperature < 0 || config.MaxTemperature < config.MinTemperature {
+		return fmt.Errorf("invalid temperature bounds")
+	}
+	if config.MaxTemperature > 0 && (config.Temperature < config.MinTemperature || config.Temperature > config.MaxTemperature) {
+		return fmt.Errorf("temperature must be within temperature bounds")
+	}
+	if config.ContextWindow < 0 || (config.ContextWindow > 0 && config.MaxTokens >= config.ContextWindow) {
+		return fmt.Errorf("context_window must be larger than max_tokens")
+	}
+	if !contextwindow.Valid(config.ContextStrategy) {
+		return fmt.Errorf("context_strategy must be fail, sliding_window or summarize")
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	model "github.com/yourorg/head/gen_model"
+)
+
+var (
+	proxyInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "head_model_proxy_inflight", Help: "Generations running on model-proxy"},
+	)
+	proxyQueueDepth = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "head_model_proxy_queue_depth", Help: "Generations waiting on model-proxy"},
+	)
+	proxyGPUUtilization = promauto.NewGauge(
+		prometheus.GaugeOpts{Name: "head_model_proxy_gpu_utilization", Help: "model-proxy GPU utilization in percent (-1 without GPU)"},
+	)
+)
+
+// Capacity is what model-proxy last reported about itself
+type Capacity struct {
+	InstanceID string   `json:"instance_id"`
+	Models     []string `json:"models"`
+	Providers  []string `json:"providers"`
+	Status     string   `json:"status"`
+	InFlight   int      `json:"in_flight"`
+	QueueDepth int      `json:"queue_depth"`
+	// MaxConcurrent generations run in parallel, the rest queue
+	MaxConcurrent int `json:"max_concurrent"`
+	// Percent; -1 when the proxy has no GPU
+	GPUUtilization       float64   `json:"gpu_utilization"`
+	GPUMemoryUtilization float64   `json:"gpu_memory_utilization"`
+	UpdatedAt            time.Time `json:"updated_at"`
+}
+
+// Serves reports whether the proxy serves a model. A proxy without a model
+// list serves every model of its providers; without providers, every model.
+func (c Capacity) Serves(modelName, provider string) bool {
+	if len(c.Models) > 0 {
+		for _, m := range c.Models {
+			if m == modelName {
+				return true
+			}
+		}
+		return false
+	}
+	if len(c.Providers) == 0 || provider == "" {
+		return true
+	}
+	for _, p := range c.Providers {
+		if strings.EqualFold(p, provider) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadPercent is the busier of the generation slots (queued work counts) and
+// the GPU, 0-100
+func (c Capacity) LoadPercent() int32 {
+	var load float64
+	if c.MaxConcurrent > 0 {
+		load = float64(c.InFlight+c.QueueDepth) * 100 / float64(c.MaxConcurrent)
+	}
+	if c.GPUUtilization > load {
+		load = c.GPUUtilization
+	}
+	if load > 100 {
+		load = 100
+	}
+	return int32(load)
+}
+
+// GetCapabilities asks model-proxy which models it serves
+func (m *ModelClient) GetCapabilities(ctx context.Context) (*model.CapabilitiesResponse, error) {
+	m.configMutex.RLock()
+	stub := m.stub
+	m.configMutex.RUnlock()
+	if stub == nil {
+		return nil, errors.New("model client is not initialized")
+	}
+	return stub.GetCapabilities(ctx, &model.CapabilitiesRequest{})
+}
+
+// GetHealth asks model-proxy for its queue depth and GPU utilization
+func (m *ModelClient) GetHealth(ctx context.Context) (*model.HealthResponse, error) {
+	m.configMutex.RLock()
+	stub := m.stub
+	m.configMutex.RUnlock()
+	if stub == nil {
+		return nil, errors.New("model client is not initialized")
+	}
+	return stub.GetHealth(ctx, &model.HealthRequest{})
+}
+
+// CapacityMonitor polls model-proxy capabilities and health. Reports older
+// than three intervals are considered stale, so a proxy that stops answering
+// (or predates these RPCs) does not block traffic on outdated numbers.
+type CapacityMonitor struct {
+	client   *ModelClient
+	interval time.Duration
+
+	mu        sync.RWMutex
+	current   Capacity
+	listeners []func()
+}
+
+// NewCapacityMonitor creates a monitor; call Run to start polling
+func NewCapacityMonitor(client *ModelClient, interval time.Duration) *CapacityMonitor {
+	return &CapacityMonitor{client: client, interval: interval}
+}
+
+// Run polls model-proxy until ctx is cancelled
+func (c *CapacityMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *CapacityMonitor) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+
+	caps, err := c.client.GetCapabilities(ctx)
+	if err != nil {
+		if status.Code(err) != grpccodes.Unimplemented {
+			log.Printf("Failed to get model-proxy capabilities: %v", err)
+		}
+		return
+	}
+	health, err := c.client.GetHealth(ctx)
+	if err != nil {
+		log.Printf("Failed to get model-proxy health: %v", err)
+		return
+	}
+
+	next := Capacity{
+		InstanceID:           caps.InstanceId,
+		Models:               caps.Models,
+		Providers:            caps.Providers,
+		Status:               health.Status,
+		InFlight:             int(health.InFlight),
+		QueueDepth:           int(health.QueueDepth),
+		MaxConcurrent:        int(health.MaxConcurrent),
+		GPUUtilization:       float64(health.GpuUtilization),
+		GPUMemoryUtilization: float64(health.GpuMemoryUtilization),
+		UpdatedAt:            time.Now(),
+	}
+	sort.Strings(next.Models)
+	sort.Strings(next.Providers)
+
+	proxyInFlight.Set(float64(next.InFlight))
+	proxyQueueDepth.Set(float64(next.QueueDepth))
+	proxyGPUUtilization.Set(next.GPUUtilization)
+
+	c.mu.Lock()
+	previous := c.current
+	c.current = next
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	// Listeners care about what is served, not about load
+	if previous.UpdatedAt.IsZero() || !equalStrings(previous.Models, next.Models) ||
+		!equalStrings(previous.Providers, next.Providers) {
+		for _, fn := range listeners {
+			fn()
+		}
+	}
+}
+
+// Current returns the latest report; false if there is none or it is stale
+func (c *CapacityMonitor) Current() (Capacity, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.current.UpdatedAt.IsZero() || time.Since(c.current.UpdatedAt) > 3*c.interval {
+		return c.current, false
+	}
+	return c.current, true
+}
+
+// OnChange registers a callback for changes in the models or providers served
+func (c *CapacityMonitor) OnChange(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
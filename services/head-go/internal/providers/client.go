@@ -2,17 +2,21 @@ package providers
 
 import (
     "context"
-    "crypto/tls"
-    "crypto/x509"
+    "errors"
     "fmt"
     "io"
-    "io/ioutil"
     "log"
     "sync"
     "sync/atomic"
     "time"
-
+    "github.com/MaksimVF/ZB/pkg/compress"
+    "github.com/MaksimVF/ZB/pkg/deadline"
+    "github.com/MaksimVF/ZB/pkg/health"
+    "github.com/MaksimVF/ZB/pkg/httpmetrics"
+    "github.com/MaksimVF/ZB/pkg/requestid"
+    "github.com/MaksimVF/ZB/pkg/tracing"
     "github.com/afex/hystrix-go/hystrix"
+    "github.com/yourorg/head/internal/identity"
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promauto"
     "go.opentelemetry.io/otel"
@@ -55,6 +59,8 @@ type ModelClient struct {
     connectionCount int32
     configManager *config.NetworkConfigManager
     configMutex sync.RWMutex
+    // Дополнительные опции соединений, например перехватчики fault injection
+    dialOptions []grpc.DialOption
 }
 
 // NewModelClient создаёт клиент, но ещё не подключается
@@ -71,31 +77,33 @@ func NewModelClient(addr string, configManager *config.NetworkConfigManager) *Mo
     }
 }
 
-// loadTLSCredentials загружает сертификаты для mTLS
-func loadTLSCredentials() (credentials.TransportCredentials, error) {
-    // Сертификат и ключ клиента (head)
-    cert, err := tls.LoadX509KeyPair("/certs/head.pem", "/certs/head-key.pem")
-    if err != nil {
-        return nil, err
+// commandTimeout — таймаут circuit breaker'ов в миллисекундах: самый длинный
+// дедлайн политики таймаутов
+func (m *ModelClient) commandTimeout() int {
+    policy := deadline.DefaultPolicy()
+    if m.configManager != nil {
+        policy = m.configManager.Timeouts()
     }
+    return int(policy.Longest().Milliseconds())
+}
 
-    // CA для проверки сервера model-proxy
-    caCert, err := ioutil.ReadFile("/certs/ca.pem")
+// WithDialOptions добавляет опции ко всем соединениям с model-proxy; вызывается до Init
+func (m *ModelClient) WithDialOptions(opts ...grpc.DialOption) *ModelClient {
+    m.dialOptions = append(m.dialOptions, opts...)
+    return m
+}
+
+// loadTLSCredentials загружает сертификаты для mTLS
+func loadTLSCredentials() (credentials.TransportCredentials, error) {
+    // Сертификат head, обновляется при ротации
+    headCerts, err := identity.Certs()
     if err != nil {
         return nil, err
     }
-    caCertPool := x509.NewCertPool()
-    if !caCertPool.AppendCertsFromPEM(caCert) {
-        return nil, err
-    }
-
-    // Настраиваем TLS с взаимной аутентификацией
-    config := &tls.Config{
-        ServerName:   "model-proxy", // Должно совпадать с CN в сертификате model-proxy
-        Certificates: []tls.Certificate{cert},
-        RootCAs:      caCertPool,
-    }
 
+    // ServerName должен совпадать с CN в сертификате model-proxy; для SPIFFE
+    // проверяется ID model-proxy
+    config := headCerts.ClientConfig("model-proxy", headCerts.AuthorizeService("model-proxy"))
     return credentials.NewTLS(config), nil
 }
 
@@ -134,12 +142,16 @@ func (m *ModelClient) reconnect(ctx context.Context) error {
         m.conn.Close()
     }
 
-    conn, err := grpc.DialContext(ctx, m.addr,
+    dialOptions := append([]grpc.DialOption{
         grpc.WithTransportCredentials(tlsCreds),
+        tracing.DialOption(),
+        requestid.DialOption(),
+        compress.DialOption(),
         grpc.WithBlock(),
         grpc.WithTimeout(10*time.Second),
         grpc.WithKeepaliveParams(keepaliveParams),
-    )
+    }, m.dialOptions...)
+    conn, err := grpc.DialContext(ctx, m.addr, dialOptions...)
     if err != nil {
         return err
     }
@@ -147,9 +159,10 @@ func (m *ModelClient) reconnect(ctx context.Context) error {
     m.conn = conn
     m.stub = model.NewModelServiceClient(conn)
 
-    // Initialize circuit breakers for different models
+    // Initialize circuit breakers for different models. Calls end at the
+    // deadline of their request, so the breakers wait up to the longest one.
     hystrix.ConfigureCommand("model_generate", hystrix.CommandConfig{
-        Timeout:                5000, // 5 seconds
+        Timeout:                m.commandTimeout(),
         MaxConcurrentRequests:  50,
         ErrorPercentThreshold:  30,
         SleepWindow:            10000, // 10 seconds
@@ -157,7 +170,7 @@ func (m *ModelClient) reconnect(ctx context.Context) error {
     })
 
     hystrix.ConfigureCommand("model_generate_stream", hystrix.CommandConfig{
-        Timeout:                10000, // 10 seconds
+        Timeout:                m.commandTimeout(),
         MaxConcurrentRequests:  30,
         ErrorPercentThreshold:  25,
         SleepWindow:            15000, // 15 seconds
@@ -172,12 +185,7 @@ func (m *ModelClient) reconnect(ctx context.Context) error {
     }
 
     for i := 0; i < m.maxConnections; i++ {
-        conn, err := grpc.DialContext(ctx, m.addr,
-            grpc.WithTransportCredentials(tlsCreds),
-            grpc.WithBlock(),
-            grpc.WithTimeout(10*time.Second),
-            grpc.WithKeepaliveParams(keepaliveParams),
-        )
+        conn, err := grpc.DialContext(ctx, m.addr, dialOptions...)
         if err != nil {
             log.Printf("Failed to create connection for pool: %v", err)
             continue
@@ -219,7 +227,7 @@ func (m *ModelClient) BatchGenerate(
 
     start := time.Now()
     defer func() {
-        modelRequestLatency.WithLabelValues("batch").Observe(time.Since(start).Seconds())
+        httpmetrics.Observe(ctx, modelRequestLatency.WithLabelValues("batch"), time.Since(start).Seconds())
     }()
 
     // Create BatchGenRequest
@@ -269,6 +277,17 @@ func (m *ModelClient) BatchGenerate(
     return resp, nil
 }
 
+// Ready проверяет соединение с model-proxy для /readyz
+func (m *ModelClient) Ready(ctx context.Context) error {
+    m.configMutex.RLock()
+    conn := m.conn
+    m.configMutex.RUnlock()
+    if conn == nil {
+        return errors.New("not connected")
+    }
+    return health.GRPC(conn)(ctx)
+}
+
 // Close закрывает все соединения
 func (m *ModelClient) Close() {
     if m.conn != nil {
@@ -295,6 +314,25 @@ func (m *ModelClient) Generate(
     temperature float32,
     maxTokens int32,
 ) (text string, tokens int, err error) {
+    resp, err := m.GenerateRequest(ctx, &model.GenRequest{
+        RequestId:   requestid.FromContext(ctx),
+        Model:       modelName,
+        Messages:    messages,
+        Temperature: temperature,
+        MaxTokens:   maxTokens,
+    })
+    if err != nil {
+        return "", 0, err
+    }
+    return resp.Text, int(resp.TokensUsed), nil
+}
+
+// GenerateRequest — Generate для готового запроса, например с параметрами
+// сэмплирования; возвращает ответ целиком, вместе с logprobs
+func (m *ModelClient) GenerateRequest(ctx context.Context, req *model.GenRequest) (*model.GenResponse, error) {
+    modelName := req.Model
+    req.Stream = false
+
     // Start a span for the Generate operation
     tracer := otel.GetTracerProvider().Tracer("head-go")
     ctx, span := tracer.Start(ctx, "ModelClient.Generate")
@@ -302,8 +340,8 @@ func (m *ModelClient) Generate(
 
     span.SetAttributes(
         trace.StringAttribute("model", modelName),
-        trace.IntAttribute("max_tokens", int(maxTokens)),
-        trace.Float64Attribute("temperature", float64(temperature)),
+        trace.IntAttribute("max_tokens", int(req.MaxTokens)),
+        trace.Float64Attribute("temperature", float64(req.Temperature)),
     )
 
     // Increment active request count
@@ -315,18 +353,9 @@ func (m *ModelClient) Generate(
 
     start := time.Now()
     defer func() {
-        modelRequestLatency.WithLabelValues(modelName).Observe(time.Since(start).Seconds())
+        httpmetrics.Observe(ctx, modelRequestLatency.WithLabelValues(modelName), time.Since(start).Seconds())
     }()
 
-    req := &model.GenRequest{
-        RequestId:   "",
-        Model:       modelName,
-        Messages:    messages,
-        Temperature: temperature,
-        MaxTokens:   maxTokens,
-        Stream:      false,
-    }
-
     // Get connection from pool or create new one
     var conn *grpc.ClientConn
     if m.connectionPool != nil {
@@ -348,7 +377,7 @@ func (m *ModelClient) Generate(
 
     // Execute with circuit breaker
     var resp *model.GenResponse
-    err = hystrix.Do("model_generate", func() error {
+    err := hystrix.Do("model_generate", func() error {
         var innerErr error
         client := model.NewModelServiceClient(conn)
         resp, innerErr = client.Generate(ctx, req)
@@ -358,7 +387,7 @@ func (m *ModelClient) Generate(
     if err != nil {
         modelRequestErrors.WithLabelValues(modelName, "generate_error").Inc()
         circuitBreakerErrors.WithLabelValues(modelName, "generate_circuit_breaker").Inc()
-        return "", 0, err
+        return nil, err
     }
 
     // Return connection to pool if it's from the pool
@@ -366,7 +395,7 @@ func (m *ModelClient) Generate(
         m.ReturnConnectionToPool(conn)
     }
 
-    return resp.Text, int(resp.TokensUsed), nil
+    return resp, nil
 }
 
 // GenerateStream — настоящий стриминговый вызов Возвращает канал, по которому приходят чанки
@@ -377,6 +406,20 @@ func (m *ModelClient) GenerateStream(
     temperature float32,
     maxTokens int32,
 ) (<-chan *model.GenResponse, <-chan error) {
+    return m.GenerateStreamRequest(ctx, &model.GenRequest{
+        RequestId:   requestid.FromContext(ctx),
+        Model:       modelName,
+        Messages:    messages,
+        Temperature: temperature,
+        MaxTokens:   maxTokens,
+    })
+}
+
+// GenerateStreamRequest — GenerateStream для готового запроса
+func (m *ModelClient) GenerateStreamRequest(ctx context.Context, req *model.GenRequest) (<-chan *model.GenResponse, <-chan error) {
+    modelName := req.Model
+    req.Stream = true
+
     // Start a span for the GenerateStream operation
     tracer := otel.GetTracerProvider().Tracer("head-go")
     ctx, span := tracer.Start(ctx, "ModelClient.GenerateStream")
@@ -384,8 +427,8 @@ func (m *ModelClient) GenerateStream(
 
     span.SetAttributes(
         trace.StringAttribute("model", modelName),
-        trace.IntAttribute("max_tokens", int(maxTokens)),
-        trace.Float64Attribute("temperature", float64(temperature)),
+        trace.IntAttribute("max_tokens", int(req.MaxTokens)),
+        trace.Float64Attribute("temperature", float64(req.Temperature)),
     )
 
     streamCh := make(chan *model.GenResponse, 10)
@@ -400,20 +443,11 @@ func (m *ModelClient) GenerateStream(
         ctx, span := tracer.Start(ctx, "GenerateStream",
             trace.WithAttributes(
                 attribute.String("model", modelName),
-                attribute.Int("messages", len(messages)),
+                attribute.Int("messages", len(req.Messages)),
             ),
         )
         defer span.End()
 
-        req := &model.GenRequest{
-            RequestId:   "",
-            Model:       modelName,
-            Messages:    messages,
-            Temperature: temperature,
-            MaxTokens:   maxTokens,
-            Stream:      true,
-        }
-
         // Get connection from pool or create new one
         var conn *grpc.ClientConn
         if m.connectionPool != nil {
@@ -463,7 +497,13 @@ func (m *ModelClient) GenerateStream(
                 errCh <- err
                 return
             }
-            streamCh <- chunk
+
+            // Caller went away: stop reading, ctx cancellation aborts the model-proxy call
+            select {
+            case streamCh <- chunk:
+            case <-ctx.Done():
+                return
+            }
         }
     }()
 
@@ -0,0 +1,301 @@
+package registration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"github.com/MaksimVF/ZB/pkg/routingclient"
+
+	"github.com/yourorg/head/internal/config"
+	"github.com/yourorg/head/internal/identity"
+	"github.com/yourorg/head/internal/models"
+)
+
+// drainMessage is routing-service's reply to the heartbeats of a head an
+// operator asked to drain
+const drainMessage = "drain requested"
+
+// LoadFunc reports the current load of the head as a percentage (0-100)
+type LoadFunc func() int32
+
+// Registrar announces this head to routing-service and keeps its status fresh.
+// routing-service matches heads by a single model type, so one routing entry
+// is registered per enabled model, with ID "<head_id>/<model>".
+type Registrar struct {
+	cfg      config.RoutingConfig
+	registry *models.ModelRegistry
+	load     LoadFunc
+	client   *routingclient.Client
+
+	mu         sync.Mutex
+	serves     func(model string) bool
+	registered map[string]string // routing head ID -> model
+	status     string
+	// remoteDrain is set while draining because routing-service asked to
+	remoteDrain bool
+	onDrain     func(drain bool)
+}
+
+// New connects to routing-service. The connection is established lazily by gRPC,
+// so routing-service does not have to be up when the head starts.
+func New(cfg config.RoutingConfig, registry *models.ModelRegistry, load LoadFunc) (*Registrar, error) {
+	// With SPIFFE the head presents its SVID to routing-service
+	headCerts, _ := identity.Certs()
+	client, err := routingclient.New(routingclient.Config{Addr: cfg.ServiceAddr, Service: "head", Certs: headCerts})
+	if err != nil {
+		return nil, fmt.Errorf("dial routing-service: %w", err)
+	}
+
+	return &Registrar{
+		cfg:        cfg,
+		registry:   registry,
+		load:       load,
+		client:     client,
+		registered: make(map[string]string),
+		status:     "active",
+	}, nil
+}
+
+// Run registers the head and sends heartbeats until ctx is cancelled
+func (r *Registrar) Run(ctx context.Context) {
+	r.Sync(ctx)
+
+	ticker := time.NewTicker(r.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.heartbeat(ctx)
+		}
+	}
+}
+
+// SetModelFilter limits registration to models the filter accepts, e.g.
+// the models model-proxy reports it serves
+func (r *Registrar) SetModelFilter(serves func(model string) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.serves = serves
+}
+
+// OnDrainRequested calls fn with true when an operator drains this head
+// through routing-service, and with false when it is resumed there
+func (r *Registrar) OnDrainRequested(fn func(drain bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onDrain = fn
+}
+
+// Sync registers entries for newly enabled models and marks entries of
+// disabled, removed or unserved models offline. A deregistered head stays
+// unregistered until Resume.
+func (r *Registrar) Sync(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status == "offline" {
+		return
+	}
+
+	wanted := make(map[string]string)
+	for name, model := range r.registry.GetAllModels() {
+		if model.Enabled && (r.serves == nil || r.serves(name)) {
+			wanted[r.entryID(name)] = name
+		}
+	}
+
+	for id, name := range wanted {
+		if _, ok := r.registered[id]; ok {
+			continue
+		}
+		if err := r.register(ctx, id, name); err != nil {
+			log.Printf("Failed to register %s with routing-service: %v", id, err)
+			continue
+		}
+		r.registered[id] = name
+	}
+
+	for id := range r.registered {
+		if _, ok := wanted[id]; ok {
+			continue
+		}
+		r.updateStatus(ctx, id, "offline", 0)
+		delete(r.registered, id)
+	}
+}
+
+func (r *Registrar) register(ctx context.Context, id, model string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := r.client.RegisterHead(ctx, &routingpb.RegisterHeadRequest{
+		HeadId:    id,
+		Endpoint:  r.cfg.AdvertiseAddr,
+		Region:    r.cfg.Region,
+		ModelType: model,
+		Version:   r.cfg.Version,
+		Metadata: map[string]string{
+			"head_id": r.cfg.HeadID,
+			"model":   model,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Message)
+	}
+
+	log.Printf("Registered %s (%s) with routing-service at %s", id, model, r.cfg.AdvertiseAddr)
+	return nil
+}
+
+// heartbeat reports the current status and load for every registered entry.
+// Entries unknown to routing-service (e.g. after its restart) are registered again.
+func (r *Registrar) heartbeat(ctx context.Context) {
+	load := r.load()
+
+	r.mu.Lock()
+	status := r.status
+	ids := make([]string, 0, len(r.registered))
+	for id := range r.registered {
+		ids = append(ids, id)
+	}
+	r.mu.Unlock()
+	if status == "offline" {
+		return
+	}
+	sort.Strings(ids)
+
+	answered, drain := false, false
+	for _, id := range ids {
+		resp := r.updateStatus(ctx, id, status, load)
+		if resp == nil {
+			continue
+		}
+		answered = true
+		if !resp.Success {
+			r.mu.Lock()
+			delete(r.registered, id)
+			r.mu.Unlock()
+			continue
+		}
+		drain = drain || resp.Message == drainMessage
+	}
+	if answered {
+		r.followDrain(drain)
+	}
+
+	// Picks up models that were enabled since the last sync or need re-registration
+	r.Sync(ctx)
+}
+
+// followDrain starts draining when routing-service asks for it and stops a
+// drain it asked for once it no longer does; a local drain is left alone
+func (r *Registrar) followDrain(drain bool) {
+	r.mu.Lock()
+	switch {
+	case drain && r.status == "active":
+		r.status, r.remoteDrain = "draining", true
+		log.Printf("routing-service asked head %s to drain", r.cfg.HeadID)
+	case !drain && r.remoteDrain && r.status == "draining":
+		r.status, r.remoteDrain = "active", false
+		log.Printf("routing-service resumed head %s", r.cfg.HeadID)
+	default:
+		r.mu.Unlock()
+		return
+	}
+	onDrain := r.onDrain
+	r.mu.Unlock()
+
+	if onDrain != nil {
+		onDrain(drain)
+	}
+}
+
+// updateStatus returns the reply of routing-service, nil when it could not be
+// reached; the reply is unsuccessful if it no longer knows the entry
+func (r *Registrar) updateStatus(ctx context.Context, id, status string, load int32) *routingpb.UpdateHeadStatusResponse {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := r.client.UpdateHeadStatus(ctx, &routingpb.UpdateHeadStatusRequest{
+		HeadId:      id,
+		Status:      status,
+		CurrentLoad: load,
+		Timestamp:   time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("Heartbeat for %s failed: %v", id, err)
+		return nil
+	}
+	return resp
+}
+
+// SetStatus changes the status reported in heartbeats (e.g. "draining")
+func (r *Registrar) SetStatus(status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// Drain reports "draining" for every entry right away, without waiting for
+// the next heartbeat, so routing-service stops picking this head while its
+// in-flight requests finish
+func (r *Registrar) Drain(ctx context.Context) {
+	load := r.load()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = "draining"
+	for id := range r.registered {
+		r.updateStatus(ctx, id, r.status, load)
+	}
+	log.Printf("Head %s is draining", r.cfg.HeadID)
+}
+
+// Resume reports "active" again after Drain or Deregister; entries that were
+// deregistered are registered again
+func (r *Registrar) Resume(ctx context.Context) {
+	load := r.load()
+
+	r.mu.Lock()
+	r.status, r.remoteDrain = "active", false
+	for id := range r.registered {
+		r.updateStatus(ctx, id, r.status, load)
+	}
+	r.mu.Unlock()
+
+	r.Sync(ctx)
+	log.Printf("Head %s is active", r.cfg.HeadID)
+}
+
+// Deregister marks all entries offline so routing-service stops sending traffic
+func (r *Registrar) Deregister(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.registered {
+		r.updateStatus(ctx, id, "offline", 0)
+		delete(r.registered, id)
+	}
+	r.status = "offline"
+	log.Printf("Deregistered head %s from routing-service", r.cfg.HeadID)
+}
+
+// Close closes the routing-service connection
+func (r *Registrar) Close() error {
+	return r.client.Close()
+}
+
+func (r *Registrar) entryID(model string) string {
+	return r.cfg.HeadID + "/" + model
+}
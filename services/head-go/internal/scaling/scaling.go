@@ -0,0 +1,284 @@
+// Package scaling computes the demand on each model served by a head — requests
+// waiting for a slot, tokens per second and P95 latency against its SLO — and
+// turns it into a scaling recommendation for external autoscalers.
+//
+// The signal is a ratio: how many heads a model needs for each head serving it
+// now. A ratio of 1.5 on every head asks for half as many heads again, which
+// is what the HPA computes from a target value of 1, so the ratio can drive it
+// directly, through KEDA's metrics-api scaler on /scaling/recommendations or
+// its Prometheus scaler on head_scaling_desired_ratio.
+package scaling
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/yourorg/head/internal/config"
+)
+
+// Recommendations
+const (
+	ScaleUp   = "scale_up"
+	ScaleDown = "scale_down"
+	Hold      = "hold"
+)
+
+const (
+	// scaleDownBelow leaves room between removing a head and needing it back
+	scaleDownBelow = 0.5
+	// minLatencySamples is the fewest requests in a window for P95 to count
+	minLatencySamples = 5
+	// maxSamples bounds the memory of a busy model
+	maxSamples = 10000
+)
+
+// Load is the admission state of a model
+type Load struct {
+	InFlight int
+	Waiting  int
+	// Limit is the model's in-flight limit, 0 when unlimited
+	Limit int
+}
+
+// Demand is what a model asks of this head
+type Demand struct {
+	Model        string  `json:"model"`
+	InFlight     int     `json:"in_flight"`
+	Waiting      int     `json:"waiting"`
+	Limit        int     `json:"limit,omitempty"`
+	Requests     int     `json:"requests"`
+	TokensPerSec float64 `json:"tokens_per_sec"`
+	P95LatencyMs float64 `json:"p95_latency_ms"`
+	SLOMs        float64 `json:"slo_ms"`
+	// DesiredRatio is the heads the model needs per head serving it now
+	DesiredRatio   float64 `json:"desired_ratio"`
+	Recommendation string  `json:"recommendation"`
+}
+
+// Recommendations is the demand on a head; its ratio and recommendation are
+// those of its busiest model
+type Recommendations struct {
+	HeadID         string    `json:"head_id"`
+	WindowSeconds  float64   `json:"window_seconds"`
+	DesiredRatio   float64   `json:"desired_ratio"`
+	Recommendation string    `json:"recommendation"`
+	Models         []Demand  `json:"models"`
+	GeneratedAt    time.Time `json:"generated_at"`
+}
+
+type sample struct {
+	at      time.Time
+	latency time.Duration
+	tokens  int
+}
+
+// Tracker records completed requests per model
+type Tracker struct {
+	cfg    config.ScalingConfig
+	headID string
+	load   func() map[string]Load
+
+	mu      sync.Mutex
+	samples map[string][]sample
+
+	gauges *gauges
+}
+
+// gauges are the KEDA-friendly metrics, registered only when enabled
+type gauges struct {
+	desiredRatio *prometheus.GaugeVec
+	waiting      *prometheus.GaugeVec
+	tokensPerSec *prometheus.GaugeVec
+	p95Latency   *prometheus.GaugeVec
+}
+
+// New creates a tracker; load returns the admission state of every model
+func New(cfg config.ScalingConfig, headID string, load func() map[string]Load) *Tracker {
+	t := &Tracker{cfg: cfg, headID: headID, load: load, samples: make(map[string][]sample)}
+	if cfg.Metrics {
+		t.gauges = &gauges{
+			desiredRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "head_scaling_desired_ratio", Help: "Heads a model needs per head serving it now"}, []string{"model"}),
+			waiting:      prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "head_scaling_waiting_requests", Help: "Requests waiting for a model slot"}, []string{"model"}),
+			tokensPerSec: prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "head_scaling_tokens_per_second", Help: "Tokens generated per second over the scaling window"}, []string{"model"}),
+			p95Latency:   prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "head_scaling_p95_latency_seconds", Help: "P95 latency over the scaling window"}, []string{"model"}),
+		}
+		prometheus.MustRegister(t.gauges.desiredRatio, t.gauges.waiting, t.gauges.tokensPerSec, t.gauges.p95Latency)
+	}
+	return t
+}
+
+// Observe records a completed request. latency is that of the whole response
+// for unary calls and of the first chunk for streams; 0 records tokens only.
+func (t *Tracker) Observe(model string, latency time.Duration, tokens int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := append(t.samples[model], sample{at: time.Now(), latency: latency, tokens: tokens})
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	t.samples[model] = samples
+}
+
+// Recommendations computes the demand of every model with traffic in the
+// window or requests in flight
+func (t *Tracker) Recommendations() Recommendations {
+	now := time.Now()
+	load := t.load()
+
+	t.mu.Lock()
+	windows := make(map[string][]sample, len(t.samples))
+	for model, samples := range t.samples {
+		samples = pruned(samples, now.Add(-t.cfg.Window))
+		if len(samples) == 0 {
+			delete(t.samples, model)
+			continue
+		}
+		t.samples[model] = samples
+		windows[model] = samples
+	}
+	t.mu.Unlock()
+
+	models := make(map[string]bool)
+	for model := range windows {
+		models[model] = true
+	}
+	for model, l := range load {
+		if l.InFlight > 0 || l.Waiting > 0 {
+			models[model] = true
+		}
+	}
+
+	recs := Recommendations{
+		HeadID:        t.headID,
+		WindowSeconds: t.cfg.Window.Seconds(),
+		Models:        make([]Demand, 0, len(models)),
+		GeneratedAt:   now,
+	}
+	waiting := 0
+	for model := range models {
+		d := t.demand(model, load[model], windows[model])
+		recs.Models = append(recs.Models, d)
+		waiting += d.Waiting
+		if d.DesiredRatio > recs.DesiredRatio {
+			recs.DesiredRatio = d.DesiredRatio
+		}
+	}
+	sort.Slice(recs.Models, func(i, j int) bool { return recs.Models[i].Model < recs.Models[j].Model })
+	recs.Recommendation = recommend(recs.DesiredRatio, waiting)
+	return recs
+}
+
+func (t *Tracker) demand(model string, l Load, samples []sample) Demand {
+	d := Demand{
+		Model:    model,
+		InFlight: l.InFlight,
+		Waiting:  l.Waiting,
+		Limit:    l.Limit,
+		Requests: len(samples),
+		SLOMs:    float64(t.slo(model).Milliseconds()),
+	}
+
+	tokens := 0
+	latencies := make([]time.Duration, 0, len(samples))
+	for _, s := range samples {
+		tokens += s.tokens
+		if s.latency > 0 {
+			latencies = append(latencies, s.latency)
+		}
+	}
+	d.TokensPerSec = float64(tokens) / t.cfg.Window.Seconds()
+
+	// Slots in use against the share of the limit a head should run at
+	if l.Limit > 0 && t.cfg.TargetUtilization > 0 {
+		d.DesiredRatio = float64(l.InFlight+l.Waiting) / (float64(l.Limit) * t.cfg.TargetUtilization)
+	}
+	// Latency over its SLO asks for as many more heads as it is over
+	if len(latencies) >= minLatencySamples {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		p95 := latencies[int(math.Ceil(0.95*float64(len(latencies))))-1]
+		d.P95LatencyMs = float64(p95.Milliseconds())
+		if d.SLOMs > 0 {
+			d.DesiredRatio = math.Max(d.DesiredRatio, d.P95LatencyMs/d.SLOMs)
+		}
+	}
+	d.DesiredRatio = math.Round(d.DesiredRatio*100) / 100
+	d.Recommendation = recommend(d.DesiredRatio, d.Waiting)
+	return d
+}
+
+func (t *Tracker) slo(model string) time.Duration {
+	if slo, ok := t.cfg.ModelSLOs[model]; ok {
+		return slo
+	}
+	return t.cfg.LatencySLO
+}
+
+// recommend turns a ratio into a recommendation; requests still waiting
+// never allow removing a head
+func recommend(ratio float64, waiting int) string {
+	switch {
+	case ratio > 1:
+		return ScaleUp
+	case ratio < scaleDownBelow && waiting == 0:
+		return ScaleDown
+	default:
+		return Hold
+	}
+}
+
+// pruned drops the samples before since; samples are in time order
+func pruned(samples []sample, since time.Time) []sample {
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].at.Before(since) })
+	return samples[i:]
+}
+
+// Run refreshes the Prometheus gauges every interval until ctx is done; it
+// returns at once when the metrics are off
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	if t.gauges == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		t.export(t.Recommendations())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *Tracker) export(recs Recommendations) {
+	// Models without demand go away instead of reporting stale values
+	for _, g := range []*prometheus.GaugeVec{t.gauges.desiredRatio, t.gauges.waiting, t.gauges.tokensPerSec, t.gauges.p95Latency} {
+		g.Reset()
+	}
+	for _, d := range recs.Models {
+		t.gauges.desiredRatio.WithLabelValues(d.Model).Set(d.DesiredRatio)
+		t.gauges.waiting.WithLabelValues(d.Model).Set(float64(d.Waiting))
+		t.gauges.tokensPerSec.WithLabelValues(d.Model).Set(d.TokensPerSec)
+		t.gauges.p95Latency.WithLabelValues(d.Model).Set(d.P95LatencyMs / 1000)
+	}
+}
+
+// Handler serves the recommendations as JSON
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Recommendations())
+	})
+}
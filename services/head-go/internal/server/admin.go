@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/yourorg/head/internal/models"
+)
+
+// SetModelStore enables the model admin API backed by the given store
+func (s *HeadServer) SetModelStore(store *models.Store) {
+	s.modelStore = store
+}
+
+// registerAdminRoutes adds the diagnostics endpoints and the model admin API
+// to the metrics mux:
+//
+//	GET  /debug/pprof/, /debug/vars, /debug/runtime
+//	GET  /admin/models
+//	PUT  /admin/models/{name}
+//	POST /admin/models/{name}/enable
+//	POST /admin/models/{name}/disable
+func (s *HeadServer) registerAdminRoutes(mux *http.ServeMux) {
+	// Enabled with DEBUG_ENDPOINTS=true
+	mux.Handle(diagnostics.Prefix, diagnostics.Handler(func(next http.Handler) http.Handler {
+		return s.auth.RequireRole("admin", next)
+	}))
+
+	if s.modelStore == nil {
+		return
+	}
+	mux.Handle("/admin/models", s.auth.RequireRole("admin", http.HandlerFunc(s.handleListModels)))
+	mux.Handle("/admin/models/", s.auth.RequireRole("admin", http.HandlerFunc(s.handleModel)))
+}
+
+func (s *HeadServer) handleListModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := make([]*models.ModelConfig, 0)
+	for _, model := range s.registry.GetAllModels() {
+		list = append(list, model)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"models": list})
+}
+
+func (s *HeadServer) handleModel(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/admin/models/")
+	name, action, _ := strings.Cut(path, "/")
+	if name == "" {
+		http.Error(w, `{"error":"model name is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodPut:
+		var config models.ModelConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+			return
+		}
+		config.Name = name
+		if err := s.modelStore.Put(r.Context(), config); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, config)
+
+	case (action == "enable" || action == "disable") && r.Method == http.MethodPost:
+		config, err := s.modelStore.SetEnabled(r.Context(), name, action == "enable")
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, config)
+
+	default:
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
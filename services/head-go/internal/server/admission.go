@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/yourorg/head/internal/config"
+	"github.com/yourorg/head/internal/models"
+	modelclient "github.com/yourorg/head/internal/providers"
+	"github.com/yourorg/head/internal/scaling"
+)
+
+var (
+	modelInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "head_model_inflight_requests", Help: "In-flight requests per model"},
+		[]string{"model"},
+	)
+	admissionQueueDepth = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{Name: "head_admission_queue_depth", Help: "Requests waiting for a model slot"},
+		[]string{"model"},
+	)
+	admissionRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{Name: "head_admission_rejections_total", Help: "Requests rejected by admission control"},
+		[]string{"model", "reason"},
+	)
+)
+
+// admission limits in-flight requests per model. When a model is at its limit,
+// requests either wait in a FIFO queue until QueueTimeout or are rejected with
+// RESOURCE_EXHAUSTED so the tail can retry on another head. Requests are
+// also rejected up front when model-proxy reports it cannot take them.
+type admission struct {
+	cfg      config.AdmissionConfig
+	registry *models.ModelRegistry
+	capacity *modelclient.CapacityMonitor
+
+	mu    sync.Mutex
+	gates map[string]*modelGate
+}
+
+type modelGate struct {
+	inflight int
+	waiters  []chan struct{}
+}
+
+func newAdmission(cfg config.AdmissionConfig, registry *models.ModelRegistry, capacity *modelclient.CapacityMonitor) *admission {
+	return &admission{
+		cfg:      cfg,
+		registry: registry,
+		capacity: capacity,
+		gates:    make(map[string]*modelGate),
+	}
+}
+
+// limit returns the max in-flight requests for a model; 0 means unlimited.
+// It is read on every call so registry hot reloads apply immediately.
+func (a *admission) limit(model string) int {
+	if m, ok := a.registry.GetModel(model); ok && m.MaxConcurrent > 0 {
+		return m.MaxConcurrent
+	}
+	return a.cfg.MaxInFlight
+}
+
+// acquire reserves a slot for the model. The returned function releases it.
+func (a *admission) acquire(ctx context.Context, model string) (func(), error) {
+	if err := a.checkProxy(model); err != nil {
+		return nil, err
+	}
+	limit := a.limit(model)
+
+	a.mu.Lock()
+	gate, ok := a.gates[model]
+	if !ok {
+		gate = &modelGate{}
+		a.gates[model] = gate
+	}
+
+	if limit <= 0 || gate.inflight < limit {
+		gate.inflight++
+		modelInFlight.WithLabelValues(model).Set(float64(gate.inflight))
+		a.mu.Unlock()
+		return a.releaser(model, gate), nil
+	}
+
+	if a.cfg.Mode == "reject" || a.cfg.QueueTimeout <= 0 {
+		a.mu.Unlock()
+		admissionRejections.WithLabelValues(model, "limit").Inc()
+		return nil, status.Errorf(grpccodes.ResourceExhausted, "model %s is at its concurrency limit (%d)", model, limit)
+	}
+
+	ready := make(chan struct{})
+	gate.waiters = append(gate.waiters, ready)
+	admissionQueueDepth.WithLabelValues(model).Set(float64(len(gate.waiters)))
+	a.mu.Unlock()
+
+	timer := time.NewTimer(a.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	var reason string
+	select {
+	case <-ready:
+		return a.releaser(model, gate), nil
+	case <-timer.C:
+		reason = "queue_timeout"
+	case <-ctx.Done():
+		reason = "cancelled"
+	}
+
+	a.mu.Lock()
+	removed := false
+	for i, w := range gate.waiters {
+		if w == ready {
+			gate.waiters = append(gate.waiters[:i], gate.waiters[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	admissionQueueDepth.WithLabelValues(model).Set(float64(len(gate.waiters)))
+	a.mu.Unlock()
+
+	// The slot was handed over while we were giving up: pass it on
+	if !removed {
+		a.releaser(model, gate)()
+	}
+
+	admissionRejections.WithLabelValues(model, reason).Inc()
+	if reason == "cancelled" {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return nil, status.Errorf(grpccodes.ResourceExhausted, "timed out waiting for a %s slot", model)
+}
+
+// tryAcquire reserves a slot only if one is free right away, for optional
+// work such as the second call of a speculative request
+func (a *admission) tryAcquire(model string) (func(), bool) {
+	if a.checkProxy(model) != nil {
+		return nil, false
+	}
+	limit := a.limit(model)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	gate, ok := a.gates[model]
+	if !ok {
+		gate = &modelGate{}
+		a.gates[model] = gate
+	}
+	if limit > 0 && gate.inflight >= limit {
+		return nil, false
+	}
+	gate.inflight++
+	modelInFlight.WithLabelValues(model).Set(float64(gate.inflight))
+	return a.releaser(model, gate), true
+}
+
+// load returns the in-flight and waiting requests of every model seen so
+// far, for the scaling signals
+func (a *admission) load() map[string]scaling.Load {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	load := make(map[string]scaling.Load, len(a.gates))
+	for model, gate := range a.gates {
+		load[model] = scaling.Load{InFlight: gate.inflight, Waiting: len(gate.waiters), Limit: a.limit(model)}
+	}
+	return load
+}
+
+// checkProxy rejects requests model-proxy cannot take: models it does not
+// serve, and any request while it drains or is over the queue or GPU limit.
+// Without a fresh report everything is admitted.
+func (a *admission) checkProxy(model string) error {
+	if a.capacity == nil {
+		return nil
+	}
+	c, ok := a.capacity.Current()
+	if !ok {
+		return nil
+	}
+
+	var provider string
+	if m, ok := a.registry.GetModel(model); ok {
+		provider = m.Provider
+	}
+	switch {
+	case !c.Serves(model, provider):
+		admissionRejections.WithLabelValues(model, "not_served").Inc()
+		return status.Errorf(grpccodes.Unavailable, "model %s is not served by this head", model)
+	case c.Status == "draining":
+		admissionRejections.WithLabelValues(model, "proxy_draining").Inc()
+		return status.Errorf(grpccodes.Unavailable, "model-proxy is draining")
+	case a.cfg.ProxyMaxQueue > 0 && c.QueueDepth >= a.cfg.ProxyMaxQueue:
+		admissionRejections.WithLabelValues(model, "proxy_queue").Inc()
+		return status.Errorf(grpccodes.ResourceExhausted, "model-proxy queue is full (%d waiting)", c.QueueDepth)
+	case a.cfg.ProxyMaxGPUUtilization > 0 && c.GPUUtilization >= a.cfg.ProxyMaxGPUUtilization:
+		admissionRejections.WithLabelValues(model, "proxy_gpu").Inc()
+		return status.Errorf(grpccodes.ResourceExhausted, "model-proxy GPU utilization at %.0f%%", c.GPUUtilization)
+	}
+	return nil
+}
+
+// releaser hands the slot to the next waiter, or frees it if nobody is waiting
+func (a *admission) releaser(model string, gate *modelGate) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+
+			if len(gate.waiters) > 0 {
+				next := gate.waiters[0]
+				gate.waiters = gate.waiters[1:]
+				admissionQueueDepth.WithLabelValues(model).Set(float64(len(gate.waiters)))
+				close(next)
+				return
+			}
+			gate.inflight--
+			modelInFlight.WithLabelValues(model).Set(float64(gate.inflight))
+		})
+	}
+}
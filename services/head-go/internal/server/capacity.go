@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+
+	modelclient "github.com/yourorg/head/internal/providers"
+)
+
+// Capacity returns the model-proxy capacity monitor
+func (s *HeadServer) Capacity() *modelclient.CapacityMonitor {
+	return s.capacity
+}
+
+// ServesModel reports whether model-proxy serves a model. Without a fresh
+// report from the proxy every model is assumed to be served.
+func (s *HeadServer) ServesModel(name string) bool {
+	c, ok := s.capacity.Current()
+	if !ok {
+		return true
+	}
+	var provider string
+	if m, ok := s.registry.GetModel(name); ok {
+		provider = m.Provider
+	}
+	return c.Serves(name, provider)
+}
+
+// registerCapacityRoutes adds the model-proxy report to the metrics mux:
+//
+//	GET /admin/model-proxy
+func (s *HeadServer) registerCapacityRoutes(mux *http.ServeMux) {
+	mux.Handle("/admin/model-proxy", s.auth.RequireRole("admin", http.HandlerFunc(s.handleCapacity)))
+}
+
+func (s *HeadServer) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	c, fresh := s.capacity.Current()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"capacity":     c,
+		"fresh":        fresh,
+		"load_percent": c.LoadPercent(),
+	})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/MaksimVF/ZB/pkg/deadline"
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+	"github.com/afex/hystrix-go/hystrix"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	gen "github.com/yourorg/head/gen"
+	model "github.com/yourorg/head/gen_model"
+	"github.com/yourorg/head/internal/structured"
+)
+
+// maxChoices limits n; model-proxy returns one text per call, so every choice
+// is a call of its own
+const maxChoices = 16
+
+// choiceCount returns the number of choices a request asks for
+func choiceCount(req *gen.ChatRequest) (int, error) {
+	if req.N == 0 {
+		return 1, nil
+	}
+	if req.N < 0 || req.N > maxChoices {
+		return 0, fmt.Errorf("n must be between 1 and %d", maxChoices)
+	}
+	return int(req.N), nil
+}
+
+// choiceResult is one generated choice; err is a gRPC status error
+type choiceResult struct {
+	text     string
+	tokens   int
+	logprobs []*model.TokenLogprob
+	err      error
+}
+
+// generateChoice runs one generation of a chat request, enforcing its
+// response format
+func (s *HeadServer) generateChoice(
+	ctx context.Context,
+	req *gen.ChatRequest,
+	modelName string,
+	messages []string,
+	temperature float32,
+	maxTokens int32,
+	format *structured.ResponseFormat,
+) (res choiceResult) {
+	// Format retries append to messages, and choices run concurrently
+	messages = messages[:len(messages):len(messages)]
+
+	var cancelled bool
+	err := hystrix.Do("model_proxy", func() error {
+		resp, err := s.model.GenerateRequest(ctx, genRequest(ctx, req, modelName, messages, temperature, maxTokens))
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			// A call we cancelled, e.g. the loser of a speculative race, says
+			// nothing about model-proxy and must not open the circuit
+			cancelled = true
+			return nil
+		}
+		if err != nil {
+			requestErrors.WithLabelValues(modelName, "model_error").Inc()
+			circuitBreakerState.WithLabelValues("model_proxy", "open").Set(1)
+			return fmt.Errorf("model error: %w", err)
+		}
+		res.text, res.tokens, res.logprobs = resp.Text, int(resp.TokensUsed), resp.Logprobs
+		return nil
+	}, nil)
+	if cancelled {
+		res.err = status.Error(grpccodes.Canceled, "model-proxy call cancelled")
+		return res
+	}
+	if err != nil && deadline.Exceeded(ctx) {
+		res.err = status.Error(grpccodes.DeadlineExceeded, "deadline exceeded before model-proxy answered")
+		return res
+	}
+	if err != nil {
+		requestErrors.WithLabelValues(modelName, "circuit_breaker").Inc()
+		res.err = status.Errorf(grpccodes.Internal, "request failed: %v", err)
+		return res
+	}
+
+	if format != nil {
+		// A repaired answer no longer matches the model's logprobs
+		res.logprobs = nil
+		res.text, err = s.enforceFormat(ctx, modelName, messages, temperature, maxTokens, format, res.text, &res.tokens)
+		if err != nil {
+			requestErrors.WithLabelValues(modelName, "structured_output").Inc()
+			res.err = status.Errorf(grpccodes.Internal, "%v", err)
+			return res
+		}
+	}
+
+	// model-proxy does not always report usage; count it ourselves
+	if res.tokens == 0 {
+		res.tokens = promptTokens(modelName, req) + tokenizer.Count(modelName, res.text)
+	}
+	return res
+}
+
+// generateChoices runs the n generations of a request concurrently and
+// fails when any of them does
+func (s *HeadServer) generateChoices(
+	ctx context.Context,
+	n int,
+	req *gen.ChatRequest,
+	modelName string,
+	messages []string,
+	temperature float32,
+	maxTokens int32,
+	format *structured.ResponseFormat,
+) ([]choiceResult, error) {
+	results := make([]choiceResult, n)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.generateChoice(ctx, req, modelName, messages, temperature, maxTokens, format)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, res := range results {
+		if res.err != nil {
+			return nil, res.err
+		}
+	}
+	return results, nil
+}
+
+// chatChoices converts generated choices to the chat API
+func chatChoices(results []choiceResult) []*gen.ChatChoice {
+	choices := make([]*gen.ChatChoice, 0, len(results))
+	for i, res := range results {
+		choices = append(choices, &gen.ChatChoice{Index: int32(i), Text: res.text, Logprobs: chatLogprobs(res.logprobs)})
+	}
+	return choices
+}
+
+// indexedChunk is a stream chunk of the choice with the given index
+type indexedChunk struct {
+	index int
+	resp  *model.GenResponse
+}
+
+// mergeStreams starts n model-proxy streams, one per choice, and merges their
+// chunks. The chunk channel is closed when all streams are done; the error
+// channel carries the first failure.
+func (s *HeadServer) mergeStreams(ctx context.Context, n int, newRequest func() *model.GenRequest) (<-chan indexedChunk, <-chan error) {
+	out := make(chan indexedChunk, 10*n)
+	errOut := make(chan error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		streamCh, errCh := s.model.GenerateStreamRequest(ctx, newRequest())
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pipeStream(ctx, i, streamCh, errCh, out, errOut)
+		}(i)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, errOut
+}
+
+// pipeStream forwards the chunks of one model-proxy stream to out as choice
+// index, and its error to errOut. It reports whether the stream failed.
+func pipeStream(ctx context.Context, index int, streamCh <-chan *model.GenResponse, errCh <-chan error, out chan<- indexedChunk, errOut chan<- error) bool {
+	// errCh is closed before streamCh, which may still hold chunks
+	for {
+		select {
+		case resp, ok := <-streamCh:
+			if !ok {
+				return false
+			}
+			select {
+			case out <- indexedChunk{index: index, resp: resp}:
+			case <-ctx.Done():
+				return false
+			}
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			errOut <- err
+			return true
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	gen "github.com/yourorg/head/gen"
+	"github.com/yourorg/head/internal/contextwindow"
+)
+
+// contextTrimmedHeader tells the tail that the conversation was trimmed or
+// summarized, e.g. "sliding_window;dropped=12"
+const contextTrimmedHeader = "x-context-trimmed"
+
+// defaultContextStrategy applies to models whose registry entry sets none
+var defaultContextStrategy = func() string {
+	if s := os.Getenv("HEAD_CONTEXT_STRATEGY"); contextwindow.Valid(s) && s != "" {
+		return s
+	}
+	return contextwindow.StrategySlidingWindow
+}()
+
+// summaryMaxTokens bounds the summary of the summarize strategy
+const summaryMaxTokens = 1024
+
+var contextFits = promauto.NewCounterVec(
+	prometheus.CounterOpts{Name: "head_context_fit_total", Help: "Prompts fitted into the context window by result"},
+	[]string{"model", "result"},
+)
+
+// contextPolicy is the context window policy of a model: the registry entry,
+// else the known window of the model and HEAD_CONTEXT_STRATEGY
+func (s *HeadServer) contextPolicy(modelName string, maxTokens int32) contextwindow.Policy {
+	policy := contextwindow.Policy{
+		Window:   contextwindow.DefaultWindows[modelName],
+		Reserve:  int(maxTokens),
+		Strategy: defaultContextStrategy,
+	}
+	if m, ok := s.registry.GetModel(modelName); ok {
+		if m.ContextWindow > 0 {
+			policy.Window = m.ContextWindow
+		}
+		if m.ContextStrategy != "" {
+			policy.Strategy = m.ContextStrategy
+		}
+	}
+	return policy
+}
+
+// fitContext trims or summarizes the conversation to the model's context
+// window and returns the message contents for model-proxy and the tokens
+// the summary used
+func (s *HeadServer) fitContext(ctx context.Context, modelName string, chat []*gen.ChatMessage, maxTokens int32) ([]string, int, error) {
+	messages := make([]contextwindow.Message, 0, len(chat))
+	for _, m := range chat {
+		messages = append(messages, contextwindow.Message{Role: m.Role, Content: m.Content})
+	}
+
+	summarize := func(ctx context.Context, instruction, transcript string) (string, int, error) {
+		return s.model.Generate(ctx, modelName, []string{instruction, transcript}, 0, summaryMaxTokens)
+	}
+	result, err := contextwindow.Fit(ctx, modelName, messages, s.contextPolicy(modelName, maxTokens), summarize)
+	if errors.Is(err, contextwindow.ErrTooLong) {
+		contextFits.WithLabelValues(modelName, "too_long").Inc()
+		return nil, 0, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	if result.Strategy != "" {
+		contextFits.WithLabelValues(modelName, result.Strategy).Inc()
+		trimmed := result.Strategy + ";dropped=" + strconv.Itoa(result.Dropped)
+		if result.Summarized > 0 {
+			trimmed += ";summarized=" + strconv.Itoa(result.Summarized)
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(contextTrimmedHeader, trimmed))
+	}
+
+	contents := make([]string, 0, len(result.Messages))
+	for _, m := range result.Messages {
+		contents = append(contents, m.Content)
+	}
+	return contents, result.SummaryTokens, nil
+}
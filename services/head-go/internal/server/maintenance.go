@@ -0,0 +1,185 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/health"
+)
+
+// idlePollInterval is how often a draining head checks for in-flight requests
+const idlePollInterval = time.Second
+
+// maintenance is the drain of a head taken out of service without shutting it
+// down: health checks fail, in-flight requests finish and the head leaves
+// routing-service once it is idle
+type maintenance struct {
+	mu           sync.Mutex
+	draining     bool
+	since        time.Time
+	deregistered bool
+	stop         chan struct{}
+
+	onDrain func(drain bool)
+	onIdle  func()
+}
+
+// MaintenanceStatus is the body of GET /admin/drain
+type MaintenanceStatus struct {
+	Draining       bool       `json:"draining"`
+	Since          *time.Time `json:"since,omitempty"`
+	ActiveRequests int32      `json:"active_requests"`
+	Deregistered   bool       `json:"deregistered"`
+}
+
+// SetDrainHooks sets what happens around a drain: onDrain is called when a
+// drain starts or stops, e.g. to report it to routing-service, and onIdle once
+// a draining head has no request in flight, e.g. to deregister it
+func (s *HeadServer) SetDrainHooks(onDrain func(drain bool), onIdle func()) {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+	s.maintenance.onDrain = onDrain
+	s.maintenance.onIdle = onIdle
+}
+
+// StartDrain puts the head in maintenance. It is a no-op while draining.
+func (s *HeadServer) StartDrain() {
+	m := &s.maintenance
+	m.mu.Lock()
+	if m.draining {
+		m.mu.Unlock()
+		return
+	}
+	m.draining, m.since, m.deregistered = true, time.Now(), false
+	m.stop = make(chan struct{})
+	stop, onDrain := m.stop, m.onDrain
+	m.mu.Unlock()
+
+	s.SetHealthStatus("NOT_SERVING")
+	if checker, _ := s.healthChecker(); checker != nil {
+		checker.Drain()
+	}
+	log.Printf("Head is draining, %d requests in flight", atomic.LoadInt32(&s.activeRequests))
+	if onDrain != nil {
+		onDrain(true)
+	}
+	go s.awaitIdle(stop)
+}
+
+// StopDrain takes the head out of maintenance. It is a no-op unless draining.
+func (s *HeadServer) StopDrain() {
+	m := &s.maintenance
+	m.mu.Lock()
+	if !m.draining {
+		m.mu.Unlock()
+		return
+	}
+	m.draining, m.deregistered = false, false
+	close(m.stop)
+	onDrain := m.onDrain
+	m.mu.Unlock()
+
+	// A head shutting down stays out of service
+	if checker, shutdown := s.healthChecker(); !shutdown {
+		s.SetHealthStatus("SERVING")
+		if checker != nil {
+			checker.Resume()
+		}
+	}
+	log.Printf("Head is serving again")
+	if onDrain != nil {
+		onDrain(false)
+	}
+}
+
+// Draining reports whether the head is in maintenance
+func (s *HeadServer) Draining() bool {
+	s.maintenance.mu.Lock()
+	defer s.maintenance.mu.Unlock()
+	return s.maintenance.draining
+}
+
+// awaitIdle calls the idle hook once no request is in flight, unless the
+// drain is stopped first
+func (s *HeadServer) awaitIdle(stop <-chan struct{}) {
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if atomic.LoadInt32(&s.activeRequests) > 0 {
+			continue
+		}
+
+		m := &s.maintenance
+		m.mu.Lock()
+		select {
+		case <-stop:
+			m.mu.Unlock()
+			return
+		default:
+		}
+		m.deregistered = true
+		onIdle, since := m.onIdle, m.since
+		m.mu.Unlock()
+
+		log.Printf("Drained head is idle after %s", time.Since(since).Round(time.Second))
+		if onIdle != nil {
+			onIdle()
+		}
+		return
+	}
+}
+
+// healthChecker returns the /readyz checker, nil before Run, and whether the
+// server is shutting down
+func (s *HeadServer) healthChecker() (*health.Checker, bool) {
+	s.shutdownMutex.RLock()
+	defer s.shutdownMutex.RUnlock()
+	return s.checker, s.shutdown
+}
+
+func (s *HeadServer) maintenanceStatus() MaintenanceStatus {
+	m := &s.maintenance
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := MaintenanceStatus{
+		Draining:       m.draining,
+		ActiveRequests: atomic.LoadInt32(&s.activeRequests),
+		Deregistered:   m.deregistered,
+	}
+	if m.draining {
+		since := m.since
+		status.Since = &since
+	}
+	return status
+}
+
+// registerMaintenanceRoutes adds the drain admin API to the metrics mux
+//
+//	GET    /admin/drain
+//	POST   /admin/drain
+//	DELETE /admin/drain
+func (s *HeadServer) registerMaintenanceRoutes(mux *http.ServeMux) {
+	mux.Handle("/admin/drain", s.auth.RequireRole("admin", http.HandlerFunc(s.handleDrain)))
+}
+
+func (s *HeadServer) handleDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		s.StartDrain()
+	case http.MethodDelete:
+		s.StopDrain()
+	default:
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.maintenanceStatus())
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/MaksimVF/ZB/pkg/requestid"
+
+	gen "github.com/yourorg/head/gen"
+	model "github.com/yourorg/head/gen_model"
+)
+
+// maxStopSequences and maxTopLogprobs are the OpenAI limits; providers with
+// lower ones are refused by model-proxy
+const (
+	maxStopSequences = 4
+	maxTopLogprobs   = 20
+)
+
+// validateSampling checks the sampling parameters of a chat request
+func validateSampling(req *gen.ChatRequest) error {
+	if req.TopP < 0 || req.TopP > 1 {
+		return errors.New("top_p must be between 0 and 1")
+	}
+	if req.FrequencyPenalty < -2 || req.FrequencyPenalty > 2 {
+		return errors.New("frequency_penalty must be between -2 and 2")
+	}
+	if req.PresencePenalty < -2 || req.PresencePenalty > 2 {
+		return errors.New("presence_penalty must be between -2 and 2")
+	}
+	if len(req.Stop) > maxStopSequences {
+		return fmt.Errorf("stop accepts at most %d sequences", maxStopSequences)
+	}
+	for _, stop := range req.Stop {
+		if stop == "" {
+			return errors.New("stop sequences must not be empty")
+		}
+	}
+	if req.TopLogprobs < 0 || req.TopLogprobs > maxTopLogprobs {
+		return fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+	}
+	if req.TopLogprobs > 0 && !req.Logprobs {
+		return errors.New("top_logprobs requires logprobs")
+	}
+	return nil
+}
+
+// genRequest builds the model-proxy request for a chat request, with its
+// sampling parameters
+func genRequest(ctx context.Context, req *gen.ChatRequest, modelName string, messages []string, temperature float32, maxTokens int32) *model.GenRequest {
+	return &model.GenRequest{
+		RequestId:        requestid.FromContext(ctx),
+		Model:            modelName,
+		Messages:         messages,
+		Temperature:      temperature,
+		MaxTokens:        maxTokens,
+		TopP:             req.TopP,
+		FrequencyPenalty: req.FrequencyPenalty,
+		PresencePenalty:  req.PresencePenalty,
+		Stop:             req.Stop,
+		Seed:             req.Seed,
+		Logprobs:         req.Logprobs,
+		TopLogprobs:      req.TopLogprobs,
+	}
+}
+
+// chatLogprobs converts model-proxy logprobs to the chat API
+func chatLogprobs(logprobs []*model.TokenLogprob) []*gen.TokenLogprob {
+	if len(logprobs) == 0 {
+		return nil
+	}
+	out := make([]*gen.TokenLogprob, 0, len(logprobs))
+	for _, lp := range logprobs {
+		token := &gen.TokenLogprob{Token: lp.Token, Logprob: lp.Logprob}
+		for _, top := range lp.TopLogprobs {
+			token.TopLogprobs = append(token.TopLogprobs, &gen.TopLogprob{Token: top.Token, Logprob: top.Logprob})
+		}
+		out = append(out, token)
+	}
+	return out
+}
@@ -20,27 +20,34 @@ import (
     "github.com/yourorg/head/internal/config"
     "github.com/yourorg/head/internal/docs"
     "github.com/yourorg/head/internal/embedding"
+    "github.com/yourorg/head/internal/identity"
     "github.com/yourorg/head/internal/models"
+    "github.com/yourorg/head/internal/speculative"
+    "github.com/yourorg/head/internal/structured"
     modelclient "github.com/yourorg/head/internal/providers"
+    "github.com/yourorg/head/internal/scaling"
     "github.com/yourorg/head/internal/metrics"
     "github.com/yourorg/head/internal/webhook"
+    "github.com/MaksimVF/ZB/pkg/compress"
+    "github.com/MaksimVF/ZB/pkg/deadline"
+    "github.com/MaksimVF/ZB/pkg/diagnostics"
+    "github.com/MaksimVF/ZB/pkg/faultinject"
+    "github.com/MaksimVF/ZB/pkg/health"
+    "github.com/MaksimVF/ZB/pkg/httpmetrics"
+    "github.com/MaksimVF/ZB/pkg/loadshed"
+    "github.com/MaksimVF/ZB/pkg/requestid"
+    "github.com/MaksimVF/ZB/pkg/tokenizer"
+    "github.com/MaksimVF/ZB/pkg/tracing"
 
     "github.com/afex/hystrix-go/hystrix"
     "github.com/grpc-ecosystem/go-grpc-middleware"
     "github.com/grpc-ecosystem/go-grpc-prometheus"
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promauto"
-    "github.com/prometheus/client_golang/prometheus/promhttp"
-    "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
     "go.opentelemetry.io/otel"
     "go.opentelemetry.io/otel/attribute"
     "go.opentelemetry.io/otel/codes"
-    "go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-    "go.opentelemetry.io/otel/sdk/resource"
-    "go.opentelemetry.io/otel/sdk/trace"
     "go.opentelemetry.io/otel/trace"
-    "go.opentelemetry.io/otel/trace/noop"
     "google.golang.org/grpc"
     "google.golang.org/grpc/credentials"
     "google.golang.org/grpc/keepalive"
@@ -64,6 +71,10 @@ var (
     activeConnections = promauto.NewGauge(
         prometheus.GaugeOpts{Name: "head_active_connections", Help: "Currently active connections"},
     )
+    streamCancellations = promauto.NewCounterVec(
+        prometheus.CounterOpts{Name: "head_stream_cancellations_total", Help: "Streams cancelled by the client"},
+        []string{"model"},
+    )
     circuitBreakerState = promauto.NewGaugeVec(
         prometheus.GaugeOpts{Name: "head_circuit_breaker_state", Help: "Circuit breaker state"},
         []string{"circuit", "state"},
@@ -90,14 +101,35 @@ type HeadServer struct {
     registry               *models.ModelRegistry
     embedding              *embedding.EmbeddingService
     networkConfigManager   *config.NetworkConfigManager
+    modelStore             *models.Store
+    dispatcher             *webhook.Dispatcher
+    admission              *admission
+    capacity               *modelclient.CapacityMonitor
+    faults                 *faultinject.Injector
+    shedder                *loadshed.Shedder
+    scaling                *scaling.Tracker
+    speculative            *speculative.Store
+    maintenance            maintenance
     shutdown               bool
     shutdownMutex          sync.RWMutex
+    grpcServer             *grpc.Server
+    checker                *health.Checker
     activeRequests         int32
     maxRequests            int
     healthStatus           string
     healthMutex            sync.RWMutex
 }
 
+// loadPriorities sheds embeddings before chat; the tail lowers the priority
+// of batch requests through the x-priority metadata
+var loadPriorities = loadshed.Routes{
+    Default: loadshed.Normal,
+    Prefixes: map[string]loadshed.Priority{
+        "/chat.ChatService/":      loadshed.High,
+        "/grpc.health.v1.Health/": loadshed.Critical,
+    },
+}
+
 func New(cfg *config.Config, networkConfigManager *config.NetworkConfigManager) *HeadServer {
     // Get network config for model proxy address
     networkConfig := networkConfigManager.GetConfig()
@@ -106,13 +138,37 @@ func New(cfg *config.Config, networkConfigManager *config.NetworkConfigManager)
         modelProxyAddr = cfg.ModelProxyAddr
     }
 
-    modelClient := modelclient.NewModelClient(modelProxyAddr, networkConfigManager)
+    // Fault injection for resilience tests: on the chat and embedding API and
+    // on calls to model-proxy, only with FAULT_INJECTION_ENABLED=true and the
+    // fault_injection flag on
+    faults := faultinject.FromEnv("head", faultinject.FlagsFunc(cfg.FeaturesConfig.IsEnabledFor))
+
+    modelClient := modelclient.NewModelClient(modelProxyAddr, networkConfigManager).
+        WithDialOptions(faults.DialOptions()...)
+    capacity := modelclient.NewCapacityMonitor(modelClient, cfg.ModelProxyHealthInterval)
+
+    // Load shedding; the upstream queue is the one model-proxy reports
+    shedder := loadshed.New("head", loadshed.ConfigFromEnv(), func() int {
+        if c, ok := capacity.Current(); ok {
+            return c.QueueDepth
+        }
+        return 0
+    })
+
+    // Demand per model for external autoscalers, from admission and the
+    // requests served
+    admission := newAdmission(cfg.Admission, cfg.ModelRegistry, capacity)
     return &HeadServer{
         cfg:            cfg,
         model:          modelClient,
         auth:           auth.NewAuthenticator(cfg.AuthConfig),
         webhook:        webhook.NewWebhookClient(cfg.WebhookConfig),
         registry:       cfg.ModelRegistry,
+        admission:      admission,
+        scaling:        scaling.New(cfg.Scaling, cfg.Routing.HeadID, admission.load),
+        capacity:       capacity,
+        faults:         faults,
+        shedder:        shedder,
         embedding:      embedding.NewEmbeddingService(cfg, modelClient),
         networkConfigManager: networkConfigManager,
         shutdown:       false,
@@ -122,30 +178,10 @@ func New(cfg *config.Config, networkConfigManager *config.NetworkConfigManager)
     }
 }
 
+// setupTracer returns the tracer of the global provider, which exports once
+// metrics.InitializeTracing has run
 func setupTracer() trace.Tracer {
-    ctx := context.Background()
-
-    // Create OTLP exporter
-    exporter, err := otlptracegrpc.New(ctx,
-        otlptracegrpc.WithInsecure(),
-        otlptracegrpc.WithEndpoint("otel-collector:4317"),
-    )
-    if err != nil {
-        log.Printf("Failed to create OTLP exporter: %v", err)
-        return noop.NewTracerProvider().Tracer("head")
-    }
-
-    // Create tracer provider
-    tp := trace.NewTracerProvider(
-        trace.WithBatcher(exporter),
-        trace.WithResource(resource.NewSchemaless(
-            attribute.String("service.name", "head"),
-            attribute.String("service.version", "1.0.0"),
-        )),
-    )
-
-    otel.SetTracerProvider(tp)
-    return tp.Tracer("head")
+    return otel.Tracer("head")
 }
 
 func (s *HeadServer) Run() error {
@@ -155,12 +191,29 @@ func (s *HeadServer) Run() error {
         log.Printf("Failed to initialize tracing: %v", err)
     }
 
+    // /livez и /readyz в общем JSON-формате, /readyz проверяет model-proxy
+    // и отдаёт 503 во время дренажа
+    checker := health.New("head").Add("model-proxy", s.model.Ready)
+    s.shutdownMutex.Lock()
+    s.checker = checker
+    s.shutdownMutex.Unlock()
+
     // Start metrics server
     go func() {
         mux := http.NewServeMux()
-        mux.Handle("/metrics", promhttp.Handler())
+        mux.Handle("/metrics", httpmetrics.Handler())
         mux.HandleFunc("/health", healthCheckHandler)
+        mux.Handle("/livez", checker.LiveHandler())
+        mux.Handle("/readyz", checker.ReadyHandler())
         mux.Handle("/docs/", http.StripPrefix("/docs", docs.DocumentationHandler()))
+        mux.Handle("/scaling/recommendations", s.scaling.Handler())
+        s.registerAdminRoutes(mux)
+        if s.speculative != nil {
+            s.registerSpeculativeRoutes(mux)
+        }
+        s.registerWebhookRoutes(mux)
+        s.registerCapacityRoutes(mux)
+        s.registerMaintenanceRoutes(mux)
 
         log.Printf("Metrics, health, and documentation server listening on :%d", s.cfg.MetricsPort)
         if err := http.ListenAndServe(fmt.Sprintf(":%d", s.cfg.MetricsPort), mux); err != nil {
@@ -172,9 +225,10 @@ func (s *HeadServer) Run() error {
     initCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
     defer cancel()
 
-    // Initialize circuit breakers
+    // Initialize circuit breakers; the deadline of each request ends its
+    // calls, the breaker timeout only backs it up
     hystrix.ConfigureCommand("model_proxy", hystrix.CommandConfig{
-        Timeout:                5000, // 5 seconds
+        Timeout:                int(s.networkConfigManager.Timeouts().Longest().Milliseconds()),
         MaxConcurrentRequests:  100,
         ErrorPercentThreshold:   25,
         SleepWindow:            10000, // 10 seconds recovery window
@@ -216,17 +270,25 @@ func (s *HeadServer) Run() error {
     var unaryInterceptors []grpc.UnaryServerInterceptor
     var streamInterceptors []grpc.StreamServerInterceptor
 
-    // Add monitoring and tracing middleware
+    // Add monitoring middleware; tracing continues the caller's traceparent
+    // through the stats handler below
     unaryInterceptors = append(unaryInterceptors,
         grpc_prometheus.UnaryServerInterceptor,
-        otelgrpc.UnaryServerInterceptor(),
     )
 
     streamInterceptors = append(streamInterceptors,
         grpc_prometheus.StreamServerInterceptor,
-        otelgrpc.StreamServerInterceptor(),
     )
 
+    // Calls keep the deadline of the tail; calls without one get the timeout
+    // policy of the network config. model-proxy gets what is left.
+    unaryInterceptors = append(unaryInterceptors, deadline.UnaryServerInterceptor(s.networkConfigManager.Timeouts))
+    streamInterceptors = append(streamInterceptors, deadline.StreamServerInterceptor(s.networkConfigManager.Timeouts))
+
+    // Shed low-priority calls under pressure before any work is done on them
+    unaryInterceptors = append(unaryInterceptors, s.shedder.UnaryServerInterceptor(loadPriorities))
+    streamInterceptors = append(streamInterceptors, s.shedder.StreamServerInterceptor(loadPriorities))
+
     // Add authentication if enabled
     if s.cfg.FeaturesConfig.IsEnabled("authentication") {
         log.Printf("Authentication enabled")
@@ -236,9 +298,16 @@ func (s *HeadServer) Run() error {
         log.Printf("Authentication disabled")
     }
 
+    // Injected faults reach clients as failures of the head itself
+    unaryInterceptors = append(unaryInterceptors, s.faults.UnaryServerInterceptor())
+    streamInterceptors = append(streamInterceptors, s.faults.StreamServerInterceptor())
+
     // Create gRPC server with middleware
     srv := grpc.NewServer(
         grpc.Creds(creds),
+        tracing.ServerOption(),
+        requestid.ServerOption(),
+        compress.ServerOption(),
         grpc.KeepaliveParams(keepaliveParams),
         grpc.KeepaliveEnforcementPolicy(keepalivePolicy),
         grpc.MaxConcurrentStreams(1000), // Limit concurrent streams
@@ -255,9 +324,28 @@ func (s *HeadServer) Run() error {
     // Register health service
     grpc_health_v1.RegisterHealthServer(srv, s)
 
+    // grpcurl and channelz tooling, for staging; see GRPC_REFLECTION and
+    // GRPC_CHANNELZ
+    if debug := diagnostics.RegisterGRPC(srv); len(debug) > 0 {
+        log.Printf("gRPC debugging services enabled: %v", debug)
+    }
+
     // Start health check goroutine
     go s.runHealthChecks()
 
+    // Poll model-proxy capabilities and load for routing and admission control
+    go s.capacity.Run(ctx)
+    go s.shedder.Run(ctx)
+    go s.scaling.Run(ctx, 15*time.Second)
+
+    s.shutdownMutex.Lock()
+    if s.shutdown {
+        s.shutdownMutex.Unlock()
+        return nil
+    }
+    s.grpcServer = srv
+    s.shutdownMutex.Unlock()
+
     lis, err := net.Listen("tcp", s.cfg.GRPCAddr)
     if err != nil {
         log.Printf("Failed to listen on %s: %v", s.cfg.GRPCAddr, err)
@@ -273,6 +361,40 @@ func (s *HeadServer) Run() error {
     return nil
 }
 
+// Shutdown drains the server: /readyz and the gRPC health check start
+// failing, new RPCs are refused and in-flight requests and streams may finish.
+// Streams still open when ctx expires are cancelled.
+func (s *HeadServer) Shutdown(ctx context.Context) error {
+    s.shutdownMutex.Lock()
+    s.shutdown = true
+    srv, checker := s.grpcServer, s.checker
+    s.shutdownMutex.Unlock()
+
+    s.SetHealthStatus("NOT_SERVING")
+    if checker != nil {
+        checker.Drain()
+    }
+    if srv == nil {
+        return nil
+    }
+
+    done := make(chan struct{})
+    go func() {
+        srv.GracefulStop()
+        close(done)
+    }()
+    select {
+    case <-done:
+        log.Printf("All in-flight requests finished")
+        return nil
+    case <-ctx.Done():
+        log.Printf("Drain timeout exceeded, cancelling %d in-flight requests", atomic.LoadInt32(&s.activeRequests))
+        srv.Stop()
+        <-done
+        return ctx.Err()
+    }
+}
+
 func (s *HeadServer) runHealthChecks() {
     ticker := time.NewTicker(10 * time.Second)
     defer ticker.Stop()
@@ -323,6 +445,14 @@ func (s *HeadServer) runHealthChecks() {
 
 // Health check implementation
 func (s *HeadServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+    // A draining head stays NOT_SERVING whatever the health loop reports
+    s.shutdownMutex.RLock()
+    draining := s.shutdown
+    s.shutdownMutex.RUnlock()
+    if draining || s.Draining() {
+        return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_NOT_SERVING}, nil
+    }
+
     s.healthMutex.RLock()
     defer s.healthMutex.RUnlock()
 
@@ -351,6 +481,21 @@ func (s *HeadServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_h
     }
 }
 
+// LoadPercent returns in-flight requests as a percentage of maxRequests,
+// or the model-proxy load if that is higher, as reported to routing-service
+// in heartbeats
+func (s *HeadServer) LoadPercent() int32 {
+    active := atomic.LoadInt32(&s.activeRequests)
+    load := active * 100 / int32(s.maxRequests)
+    if c, ok := s.capacity.Current(); ok && c.LoadPercent() > load {
+        load = c.LoadPercent()
+    }
+    if load > 100 {
+        load = 100
+    }
+    return load
+}
+
 // Update health status
 func (s *HeadServer) SetHealthStatus(status string) {
     s.healthMutex.Lock()
@@ -416,7 +561,7 @@ func (s *HeadServer) BatchGenerate(ctx context.Context, req *model.BatchGenReque
         })
     }
 
-    requestLatency.WithLabelValues("batch").Observe(time.Since(start).Seconds())
+    httpmetrics.Observe(ctx, requestLatency.WithLabelValues("batch"), time.Since(start).Seconds())
     requestsTotal.WithLabelValues("batch", "ok").Inc()
 
     return &model.BatchGenResponse{
@@ -424,6 +569,15 @@ func (s *HeadServer) BatchGenerate(ctx context.Context, req *model.BatchGenReque
     }, nil
 }
 
+// modelParams applies the per-model limits from the registry. Models that are
+// not in the registry are passed through unchanged.
+func (s *HeadServer) modelParams(modelName string, temperature float32, maxTokens int32) (float32, int32, error) {
+    if _, ok := s.registry.GetModel(modelName); !ok {
+        return temperature, maxTokens, nil
+    }
+    return s.registry.ValidateParams(modelName, temperature, maxTokens)
+}
+
 // Обычный (не стриминговый) запрос — возвращает полный текст сразу
 func (s *HeadServer) ChatCompletion(ctx context.Context, req *gen.ChatRequest) (*gen.ChatResponse, error) {
     start := time.Now()
@@ -435,6 +589,7 @@ func (s *HeadServer) ChatCompletion(ctx context.Context, req *gen.ChatRequest) (
     // Start tracing span
     ctx, span := tracer.Start(ctx, "ChatCompletion",
         trace.WithAttributes(
+            attribute.String("request_id", requestid.FromContext(ctx)),
             attribute.String("model", modelName),
             attribute.Int("messages", len(req.Messages)),
         ),
@@ -448,57 +603,113 @@ func (s *HeadServer) ChatCompletion(ctx context.Context, req *gen.ChatRequest) (
     // Update active connections metric
     activeConnections.Set(float64(atomic.LoadInt32(&s.activeRequests)))
 
-    messages := make([]string, 0, len(req.Messages))
-    for _, m := range req.Messages {
-        messages = append(messages, m.Content)
+    temperature, maxTokens, err := s.modelParams(modelName, req.Temperature, req.MaxTokens)
+    if err == nil {
+        err = validateSampling(req)
+    }
+    n := 1
+    if err == nil {
+        n, err = choiceCount(req)
+    }
+    if err != nil {
+        requestsTotal.WithLabelValues(modelName, "invalid").Inc()
+        return nil, status.Errorf(codes.InvalidArgument, "%v", err)
     }
 
-    // Execute with circuit breaker
-    var responseText string
-    var tokensUsed int
-    err := hystrix.Do("model_proxy", func() error {
-        var err error
-        responseText, tokensUsed, err = s.model.Generate(ctx, modelName, messages, float32(req.Temperature), req.MaxTokens)
-        if err != nil {
-            requestErrors.WithLabelValues(modelName, "model_error").Inc()
-            circuitBreakerState.WithLabelValues("model_proxy", "open").Set(1)
-            return fmt.Errorf("model error: %w", err)
+    release, err := s.admission.acquire(ctx, modelName)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    // Переписку, не помещающуюся в контекстное окно модели, обрезаем или
+    // сжимаем по стратегии модели, а не отдаём провайдеру на отказ
+    messages, summaryTokens, err := s.fitContext(ctx, modelName, req.Messages, maxTokens)
+    if err != nil {
+        requestsTotal.WithLabelValues(modelName, "invalid").Inc()
+        return nil, err
+    }
+
+    // response_format comes from the tail as metadata and is forwarded to model-proxy
+    format, err := structured.FromIncomingContext(ctx)
+    if err != nil {
+        return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+    }
+    if format != nil {
+        ctx = structured.AppendToOutgoing(ctx, format)
+        if !s.nativeStructuredOutput(modelName) {
+            messages = append(messages, structured.Instruction(format))
         }
-        return nil
-    }, nil)
+    }
 
+    // n > 1: каждый вариант — отдельный вызов model-proxy, usage суммируется.
+    // Ключи со speculative dispatch получают ответ модели, ответившей первой.
+    var results []choiceResult
+    race := s.speculativeRaceFor(ctx, req, modelName, n)
+    if race != nil {
+        results, err = s.raceChoice(ctx, race, req, modelName, messages, temperature, maxTokens, format)
+    } else {
+        results, err = s.generateChoices(ctx, n, req, modelName, messages, temperature, maxTokens, format)
+    }
+    if status.Code(err) == codes.DeadlineExceeded {
+        // model-proxy has already read the prompt
+        s.reportPartialUsage(req, modelName, 0, "", partialTimeout)
+        return nil, err
+    }
     if err != nil {
-        requestErrors.WithLabelValues(modelName, "circuit_breaker").Inc()
         requestsTotal.WithLabelValues(modelName, "error").Inc()
-        return nil, status.Errorf(codes.Internal, "request failed: %v", err)
+        return nil, err
+    }
+    tokensUsed := summaryTokens
+    for _, res := range results {
+        tokensUsed += res.tokens
     }
 
     // Update circuit breaker state
     circuitBreakerState.WithLabelValues("model_proxy", "closed").Set(1)
 
-    requestLatency.WithLabelValues(modelName).Observe(time.Since(start).Seconds())
+    httpmetrics.Observe(ctx, requestLatency.WithLabelValues(modelName), time.Since(start).Seconds())
     requestsTotal.WithLabelValues(modelName, "ok").Inc()
+    s.scaling.Observe(modelName, time.Since(start), tokensUsed)
+
+    answeredBy := modelName
+    if race != nil {
+        // Both calls are billed through the key's cost multiplier
+        tokensUsed = s.billSpeculative(req, modelName, race, tokensUsed, results[0].text)
+        answeredBy = race.winner
+    }
 
-    return &gen.ChatResponse{
+    resp := &gen.ChatResponse{
         RequestId:  req.RequestId,
-        FullText:   responseText,
-        Model:      modelName,
+        FullText:   results[0].text,
+        Model:      answeredBy,
         Provider:  "litellm",
         TokensUsed: int32(tokensUsed),
-    }, nil
+        Logprobs:   chatLogprobs(results[0].logprobs),
+    }
+    if n > 1 {
+        resp.Choices = chatChoices(results)
+    }
+    return resp, nil
 }
 
 // Стриминговый запрос — настоящий SSE-совместимый стриминг
 func (s *HeadServer) ChatCompletionStream(req *gen.ChatRequest, stream gen.ChatService_ChatCompletionStreamServer) error {
+    start := time.Now()
     ctx := stream.Context()
     modelName := req.Model
     if modelName == "" {
         modelName = "gpt-4o"
     }
 
+    // Without a deadline from the tail the policy's for the model applies
+    ctx, cancelDeadline := deadline.ForModel(ctx, modelName)
+    defer cancelDeadline()
+
     // Start tracing span
     ctx, span := tracer.Start(ctx, "ChatCompletionStream",
         trace.WithAttributes(
+            attribute.String("request_id", requestid.FromContext(ctx)),
             attribute.String("model", modelName),
             attribute.Int("messages", len(req.Messages)),
         ),
@@ -509,34 +720,163 @@ func (s *HeadServer) ChatCompletionStream(req *gen.ChatRequest, stream gen.ChatS
     atomic.AddInt32(&s.activeRequests, 1)
     defer atomic.AddInt32(&s.activeRequests, -1)
 
-    messages := make([]string, 0, len(req.Messages))
-    for _, m := range req.Messages {
-        messages = append(messages, m.Content)
+    temperature, maxTokens, err := s.modelParams(modelName, req.Temperature, req.MaxTokens)
+    if err == nil {
+        err = validateSampling(req)
+    }
+    n := 1
+    if err == nil {
+        n, err = choiceCount(req)
+    }
+    if err != nil {
+        requestsTotal.WithLabelValues(modelName, "invalid").Inc()
+        return status.Errorf(codes.InvalidArgument, "%v", err)
+    }
+
+    release, err := s.admission.acquire(ctx, modelName)
+    if err != nil {
+        return err
+    }
+    defer release()
+
+    // Переписку, не помещающуюся в контекстное окно модели, обрезаем или
+    // сжимаем по стратегии модели, а не отдаём провайдеру на отказ
+    messages, summaryTokens, err := s.fitContext(ctx, modelName, req.Messages, maxTokens)
+    if err != nil {
+        requestsTotal.WithLabelValues(modelName, "invalid").Inc()
+        return err
     }
 
     // Execute with circuit breaker
     var responseText string
     var tokensUsed int
+    // Time to the first chunk is the latency of a stream for autoscaling
+    var ttft time.Duration
 
-    streamCh, errCh := s.model.GenerateStream(ctx, modelName, messages, float32(req.Temperature), req.MaxTokens)
+    // Streams cannot be validated as a whole; the format is only forwarded to model-proxy
+    format, err := structured.FromIncomingContext(ctx)
+    if err != nil {
+        return status.Errorf(codes.InvalidArgument, "%v", err)
+    }
+    if format != nil {
+        ctx = structured.AppendToOutgoing(ctx, format)
+        if !s.nativeStructuredOutput(modelName) {
+            messages = append(messages, structured.Instruction(format))
+        }
+    }
+
+    // Cancelling streamCtx aborts the model-proxy stream when the tail disconnects
+    streamCtx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    // При n > 1 чанки вариантов идут вперемешку, с их index. Со speculative
+    // dispatch стримятся обе модели, остаётся та, что первой прислала чанк.
+    var streamCh <-chan indexedChunk
+    var errCh <-chan error
+    race := s.speculativeRaceFor(ctx, req, modelName, n)
+    if race != nil {
+        streamCh, errCh = s.raceStreams(streamCtx, race, modelName, [2]*model.GenRequest{
+            genRequest(ctx, req, modelName, messages, temperature, maxTokens),
+            genRequest(ctx, req, race.partner, messages, race.temperature, race.maxTokens),
+        })
+    } else {
+        streamCh, errCh = s.mergeStreams(streamCtx, n, func() *model.GenRequest {
+            return genRequest(ctx, req, modelName, messages, temperature, maxTokens)
+        })
+    }
 
     for {
         select {
-        case resp, ok := <-streamCh:
+        case chunk, ok := <-streamCh:
             if !ok {
+                if tokensUsed == 0 {
+                    tokensUsed = promptTokens(modelName, req) + tokenizer.Count(modelName, responseText)
+                }
+                tokensUsed += summaryTokens
+                s.scaling.Observe(modelName, ttft, tokensUsed)
+                if race != nil {
+                    s.billSpeculative(req, modelName, race, tokensUsed, responseText)
+                }
                 return nil
             }
+            if ttft == 0 {
+                ttft = time.Since(start)
+            }
+            resp := chunk.resp
+            responseText += resp.Text
+            tokensUsed += int(resp.TokensUsed)
             if err := stream.Send(&gen.ChatStreamResponse{
-                Chunk: resp.Text,
+                Chunk:    resp.Text,
+                Logprobs: chatLogprobs(resp.Logprobs),
+                Index:    int32(chunk.index),
             }); err != nil {
+                s.reportPartialUsage(req, modelName, tokensUsed, responseText, partialCancelled)
                 return err
             }
         case err, ok := <-errCh:
             if !ok {
                 return nil
             }
+            if deadline.Exceeded(ctx) || status.Code(err) == codes.DeadlineExceeded {
+                s.reportPartialUsage(req, modelName, tokensUsed, responseText, partialTimeout)
+                return status.Error(codes.DeadlineExceeded, "deadline exceeded during the stream")
+            }
             requestErrors.WithLabelValues(modelName, "stream_error").Inc()
             return status.Errorf(codes.Internal, "stream error: %v", err)
+        case <-ctx.Done():
+            reason := partialCancelled
+            if deadline.Exceeded(ctx) {
+                reason = partialTimeout
+            }
+            s.reportPartialUsage(req, modelName, tokensUsed, responseText, reason)
+            return status.FromContextError(ctx.Err()).Err()
         }
     }
 }
+
+// promptTokens counts the tokens of the request messages
+func promptTokens(modelName string, req *gen.ChatRequest) int {
+    messages := make([]tokenizer.Message, 0, len(req.Messages))
+    for _, m := range req.Messages {
+        messages = append(messages, tokenizer.Message{Role: m.Role, Content: m.Content})
+    }
+    return tokenizer.CountMessages(modelName, messages)
+}
+
+// Why a request ended before its response was complete
+const (
+    partialCancelled = "cancelled"
+    partialTimeout   = "timeout"
+)
+
+// reportPartialUsage records tokens already generated for a request the client
+// abandoned or that ran out of time, so billing can charge for them
+func (s *HeadServer) reportPartialUsage(req *gen.ChatRequest, modelName string, tokensUsed int, text string, reason string) {
+    if reason == partialCancelled {
+        streamCancellations.WithLabelValues(modelName).Inc()
+    }
+    requestsTotal.WithLabelValues(modelName, reason).Inc()
+
+    // model-proxy may not report tokens per chunk; count what was streamed
+    if tokensUsed == 0 {
+        tokensUsed = promptTokens(modelName, req) + tokenizer.Count(modelName, text)
+    }
+
+    log.Printf("Request %s for model %s ended (%s) after %d tokens", req.RequestId, modelName, reason, tokensUsed)
+    s.webhook.SendAsyncWebhook("usage.partial", map[string]interface{}{
+        "request_id":  req.RequestId,
+        "model":       modelName,
+        "tokens_used": tokensUsed,
+        "cancelled":   true,
+        "reason":      reason,
+    })
+}
+
+// loadServerTLSCredentials загружает сертификат head для mTLS с клиентами;
+// SPIFFE_AUTHORIZED_IDS ограничивает, кто может подключаться
+func loadServerTLSCredentials() (credentials.TransportCredentials, error) {
+    headCerts, err := identity.Certs()
+    if err != nil {
+        return nil, err
+    }
+    return credentials.NewTLS(headCerts.ServerConfig(tls.RequireAndVerifyClientCert, headCerts.AuthorizeFromEnv())), nil
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	gen "github.com/yourorg/head/gen"
+	model "github.com/yourorg/head/gen_model"
+	"github.com/yourorg/head/internal/auth"
+	"github.com/yourorg/head/internal/speculative"
+	"github.com/yourorg/head/internal/structured"
+)
+
+// speculativeRequests counts races by their outcome: "requested" or
+// "partner" won, "none" when both calls failed, "skipped" when the partner
+// had no free slot. Win rates per model pair are ratios of these.
+var speculativeRequests = promauto.NewCounterVec(
+	prometheus.CounterOpts{Name: "head_speculative_requests_total", Help: "Speculative requests by the model that answered first"},
+	[]string{"model", "partner", "winner"},
+)
+
+// SetSpeculativeStore enables speculative dispatch for the API keys that have
+// a policy in the store, and its admin API
+func (s *HeadServer) SetSpeculativeStore(store *speculative.Store) {
+	s.speculative = store
+}
+
+// speculativeRace is a request raced against a partner model
+type speculativeRace struct {
+	apiKey      string
+	partner     string
+	temperature float32
+	maxTokens   int32
+	multiplier  float64
+	// release frees the partner's admission slot
+	release func()
+	// winner is the model that answered, set once the race is decided
+	winner string
+}
+
+// speculativeRaceFor returns the race of a request whose API key opted in to
+// speculative dispatch, nil when the request runs on its model alone. Only
+// single-choice requests are raced, and only when the partner has a free slot.
+func (s *HeadServer) speculativeRaceFor(ctx context.Context, req *gen.ChatRequest, modelName string, n int) *speculativeRace {
+	if s.speculative == nil || n != 1 {
+		return nil
+	}
+	claims, ok := auth.GetClaimsFromContext(ctx)
+	if !ok || claims.UserID == "" {
+		return nil
+	}
+	policy, found, err := s.speculative.Get(ctx, claims.UserID)
+	if err != nil {
+		log.Printf("Speculative policy of %s unavailable: %v", claims.UserID, err)
+		return nil
+	}
+	partner := policy.PartnerFor(modelName)
+	if !found || partner == "" {
+		return nil
+	}
+	temperature, maxTokens, err := s.modelParams(partner, req.Temperature, req.MaxTokens)
+	if err != nil {
+		return nil
+	}
+
+	// The second call is optional: never queue for it
+	release, ok := s.admission.tryAcquire(partner)
+	if !ok {
+		speculativeRequests.WithLabelValues(modelName, partner, "skipped").Inc()
+		return nil
+	}
+	return &speculativeRace{
+		apiKey:      claims.UserID,
+		partner:     partner,
+		temperature: temperature,
+		maxTokens:   maxTokens,
+		multiplier:  policy.Multiplier(),
+		release:     release,
+	}
+}
+
+// decide records the outcome of the race; winner is 0 for the requested
+// model, 1 for the partner and -1 when both failed
+func (r *speculativeRace) decide(modelName string, winner int) {
+	label := "none"
+	switch winner {
+	case 0:
+		label, r.winner = "requested", modelName
+	case 1:
+		label, r.winner = "partner", r.partner
+	}
+	speculativeRequests.WithLabelValues(modelName, r.partner, label).Inc()
+}
+
+// raceChoice generates the choice of a request on its model and on the
+// partner at once and returns the first success, cancelling the other call.
+// When both fail the requested model's error is returned.
+func (s *HeadServer) raceChoice(
+	ctx context.Context,
+	race *speculativeRace,
+	req *gen.ChatRequest,
+	modelName string,
+	messages []string,
+	temperature float32,
+	maxTokens int32,
+	format *structured.ResponseFormat,
+) ([]choiceResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type entry struct {
+		index int
+		res   choiceResult
+	}
+	entries := make(chan entry, 2)
+	go func() {
+		entries <- entry{0, s.generateChoice(ctx, req, modelName, messages, temperature, maxTokens, format)}
+	}()
+	go func() {
+		defer race.release()
+		entries <- entry{1, s.generateChoice(ctx, req, race.partner, messages, race.temperature, race.maxTokens, format)}
+	}()
+
+	var failed error
+	for i := 0; i < 2; i++ {
+		e := <-entries
+		if e.res.err == nil {
+			race.decide(modelName, e.index)
+			return []choiceResult{e.res}, nil
+		}
+		if e.index == 0 {
+			failed = e.res.err
+		}
+	}
+	race.decide(modelName, -1)
+	return nil, failed
+}
+
+// raceStreams starts the model-proxy streams of the requested model and of
+// the partner and keeps the one whose first chunk comes first, cancelling the
+// other. Chunks and errors are delivered as by mergeStreams; the chunk
+// channel is only closed when the winning stream ends without error.
+func (s *HeadServer) raceStreams(ctx context.Context, race *speculativeRace, modelName string, requests [2]*model.GenRequest) (<-chan indexedChunk, <-chan error) {
+	out := make(chan indexedChunk, 10)
+	errOut := make(chan error, 1)
+
+	var streams [2]<-chan *model.GenResponse
+	var errs [2]<-chan error
+	var cancels [2]context.CancelFunc
+	for i, r := range requests {
+		var streamCtx context.Context
+		streamCtx, cancels[i] = context.WithCancel(ctx)
+		streams[i], errs[i] = s.model.GenerateStreamRequest(streamCtx, r)
+	}
+
+	go func() {
+		winner, first, err := firstChunk(ctx, streams, errs)
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+		if winner == 1 {
+			defer race.release()
+		} else {
+			race.release()
+		}
+		if winner < 0 {
+			// A client that went away decided nothing
+			if ctx.Err() == nil {
+				race.decide(modelName, winner)
+			}
+			errOut <- err
+			return
+		}
+		race.decide(modelName, winner)
+		defer cancels[winner]()
+
+		if first != nil {
+			select {
+			case out <- indexedChunk{resp: first}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !pipeStream(ctx, 0, streams[winner], errs[winner], out, errOut) {
+			close(out)
+		}
+	}()
+	return out, errOut
+}
+
+// firstChunk waits for one of two streams to deliver a chunk, or to end
+// without one. It returns -1 and the last failure when both streams fail.
+func firstChunk(ctx context.Context, streams [2]<-chan *model.GenResponse, errs [2]<-chan error) (int, *model.GenResponse, error) {
+	var lastErr error
+	for streams[0] != nil || streams[1] != nil {
+		var i int
+		var resp *model.GenResponse
+		var ok bool
+		select {
+		case resp, ok = <-streams[0]:
+		case resp, ok = <-streams[1]:
+			i = 1
+		case <-ctx.Done():
+			return -1, nil, ctx.Err()
+		}
+		if ok {
+			return i, resp, nil
+		}
+		// errCh is closed before streamCh and still holds the error, if any
+		if err, failed := <-errs[i]; failed {
+			lastErr = err
+			streams[i] = nil
+			continue
+		}
+		return i, nil, nil
+	}
+	return -1, nil, lastErr
+}
+
+// billSpeculative reports the usage of a race, with the cost multiplier of the
+// API key applied, and returns the tokens to bill
+func (s *HeadServer) billSpeculative(req *gen.ChatRequest, modelName string, race *speculativeRace, tokensUsed int, text string) int {
+	// model-proxy may not report tokens per chunk; count what was streamed
+	if tokensUsed == 0 {
+		tokensUsed = promptTokens(race.winner, req) + tokenizer.Count(race.winner, text)
+	}
+	billed := int(math.Ceil(float64(tokensUsed) * race.multiplier))
+
+	s.webhook.SendAsyncWebhook("usage.speculative", map[string]interface{}{
+		"request_id":      req.RequestId,
+		"user_id":         race.apiKey,
+		"model":           modelName,
+		"partner":         race.partner,
+		"winner":          race.winner,
+		"tokens_used":     tokensUsed,
+		"cost_multiplier": race.multiplier,
+		"billed_tokens":   billed,
+	})
+	return billed
+}
+
+// registerSpeculativeRoutes adds the speculative dispatch admin API to the
+// metrics mux. Policies are keyed by API key, the user_id of its token.
+//
+//	GET    /admin/speculative
+//	GET    /admin/speculative/{api_key}
+//	PUT    /admin/speculative/{api_key}
+//	DELETE /admin/speculative/{api_key}
+func (s *HeadServer) registerSpeculativeRoutes(mux *http.ServeMux) {
+	mux.Handle("/admin/speculative", s.auth.RequireRole("admin", http.HandlerFunc(s.handleListSpeculative)))
+	mux.Handle("/admin/speculative/", s.auth.RequireRole("admin", http.HandlerFunc(s.handleSpeculativePolicy)))
+}
+
+func (s *HeadServer) handleListSpeculative(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	policies, err := s.speculative.List(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"policies": policies})
+}
+
+func (s *HeadServer) handleSpeculativePolicy(w http.ResponseWriter, r *http.Request) {
+	apiKey := strings.TrimPrefix(r.URL.Path, "/admin/speculative/")
+	if apiKey == "" || strings.Contains(apiKey, "/") {
+		http.Error(w, `{"error":"api key is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		policy, found, err := s.speculative.Get(r.Context(), apiKey)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if !found {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, policy)
+
+	case http.MethodPut:
+		var policy speculative.Policy
+		if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+			return
+		}
+		if err := s.speculative.Put(r.Context(), apiKey, policy); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, policy)
+
+	case http.MethodDelete:
+		found, err := s.speculative.Delete(r.Context(), apiKey)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if !found {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/yourorg/head/internal/structured"
+)
+
+// maxStructuredRetries is how many times the model is asked again after
+// returning JSON that cannot be repaired or does not match the schema
+const maxStructuredRetries = 2
+
+var structuredOutputResults = promauto.NewCounterVec(
+	prometheus.CounterOpts{Name: "head_structured_output_total", Help: "Structured output validation results"},
+	[]string{"model", "result"},
+)
+
+// nativeStructuredOutput reports whether the model's provider enforces response_format
+func (s *HeadServer) nativeStructuredOutput(modelName string) bool {
+	if m, ok := s.registry.GetModel(modelName); ok {
+		return structured.SupportsNative(m.Provider)
+	}
+	return false
+}
+
+// enforceFormat validates the model answer against the response format,
+// repairing it or asking the model again when it does not conform.
+// tokensUsed is increased by the tokens of the extra calls.
+func (s *HeadServer) enforceFormat(
+	ctx context.Context,
+	modelName string,
+	messages []string,
+	temperature float32,
+	maxTokens int32,
+	format *structured.ResponseFormat,
+	text string,
+	tokensUsed *int,
+) (string, error) {
+	for attempt := 0; ; attempt++ {
+		repaired := structured.Repair(text)
+		err := structured.Validate(repaired, format)
+		if err == nil {
+			switch {
+			case attempt > 0:
+				structuredOutputResults.WithLabelValues(modelName, "retried").Inc()
+			case repaired != text:
+				structuredOutputResults.WithLabelValues(modelName, "repaired").Inc()
+			default:
+				structuredOutputResults.WithLabelValues(modelName, "valid").Inc()
+			}
+			return repaired, nil
+		}
+
+		if attempt == maxStructuredRetries {
+			structuredOutputResults.WithLabelValues(modelName, "failed").Inc()
+			return "", fmt.Errorf("model did not return valid structured output: %w", err)
+		}
+
+		messages = append(messages, text, structured.RetryInstruction(format, err))
+		var tokens int
+		text, tokens, err = s.model.Generate(ctx, modelName, messages, temperature, maxTokens)
+		if err != nil {
+			return "", err
+		}
+		*tokensUsed += tokens
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/yourorg/head/internal/auth"
+	"github.com/yourorg/head/internal/webhook"
+)
+
+// SetWebhookDispatcher enables the webhook management API and fans server
+// events out to registered endpoints
+func (s *HeadServer) SetWebhookDispatcher(dispatcher *webhook.Dispatcher) {
+	s.dispatcher = dispatcher
+	s.webhook.SetDispatcher(dispatcher)
+}
+
+// registerWebhookRoutes adds the webhook management API to the metrics mux.
+// Endpoints belong to the token's user; admins can pass ?tenant_id=.
+//
+//	GET    /v1/webhooks
+//	POST   /v1/webhooks
+//	GET    /v1/webhooks/{id}
+//	DELETE /v1/webhooks/{id}
+//	POST   /v1/webhooks/{id}/enable
+//	POST   /v1/webhooks/{id}/disable
+//	GET    /v1/webhooks/{id}/deliveries?status=&limit=
+//	GET    /v1/webhooks/deliveries/{id}
+//	POST   /v1/webhooks/deliveries/{id}/redeliver
+func (s *HeadServer) registerWebhookRoutes(mux *http.ServeMux) {
+	if s.dispatcher == nil {
+		return
+	}
+	mux.Handle("/v1/webhooks", s.auth.RequireRole("", http.HandlerFunc(s.handleWebhooks)))
+	mux.Handle("/v1/webhooks/", s.auth.RequireRole("", http.HandlerFunc(s.handleWebhook)))
+}
+
+// webhookTenant returns the tenant the request acts for
+func webhookTenant(r *http.Request) (string, bool) {
+	claims, ok := auth.GetClaimsFromContext(r.Context())
+	if !ok {
+		return "", false
+	}
+	if tenant := r.URL.Query().Get("tenant_id"); tenant != "" && claims.HasRole("admin") {
+		return tenant, true
+	}
+	return claims.UserID, true
+}
+
+func (s *HeadServer) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := webhookTenant(r)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		endpoints, err := s.dispatcher.ListEndpoints(r.Context(), tenant)
+		if err != nil {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": endpoints})
+
+	case http.MethodPost:
+		var endpoint webhook.Endpoint
+		if err := json.NewDecoder(r.Body).Decode(&endpoint); err != nil {
+			http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+			return
+		}
+		endpoint.TenantID = tenant
+		if err := s.dispatcher.CreateEndpoint(r.Context(), &endpoint); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, endpoint)
+
+	default:
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *HeadServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	tenant, ok := webhookTenant(r)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/webhooks/"), "/")
+	if parts[0] == "deliveries" {
+		s.handleWebhookDelivery(w, r, tenant, parts[1:])
+		return
+	}
+
+	endpoint, err := s.dispatcher.GetEndpoint(r.Context(), parts[0])
+	if errors.Is(err, webhook.ErrEndpointNotFound) || (err == nil && endpoint.TenantID != tenant) {
+		http.Error(w, `{"error":"webhook endpoint not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+	endpoint.Secret = ""
+
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, endpoint)
+
+	case action == "" && r.Method == http.MethodDelete:
+		if err := s.dispatcher.DeleteEndpoint(r.Context(), endpoint.ID); err != nil {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": endpoint.ID, "deleted": true})
+
+	case (action == "enable" || action == "disable") && r.Method == http.MethodPost:
+		updated, err := s.dispatcher.SetEndpointActive(r.Context(), endpoint.ID, action == "enable")
+		if err != nil {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, updated)
+
+	case action == "deliveries" && r.Method == http.MethodGet:
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > 1000 {
+			limit = 100
+		}
+		deliveries, err := s.dispatcher.ListDeliveries(r.Context(), endpoint.ID, r.URL.Query().Get("status"), limit)
+		if err != nil {
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": deliveries})
+
+	default:
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	}
+}
+
+func (s *HeadServer) handleWebhookDelivery(w http.ResponseWriter, r *http.Request, tenant string, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+		return
+	}
+
+	delivery, err := s.dispatcher.GetDelivery(r.Context(), parts[0])
+	if errors.Is(err, webhook.ErrDeliveryNotFound) || (err == nil && delivery.TenantID != tenant) {
+		http.Error(w, `{"error":"webhook delivery not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, delivery)
+
+	case len(parts) == 2 && parts[1] == "redeliver" && r.Method == http.MethodPost:
+		delivery, err = s.dispatcher.Redeliver(r.Context(), delivery.ID)
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, delivery)
+
+	default:
+		http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+	}
+}
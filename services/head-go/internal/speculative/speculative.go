@@ -0,0 +1,171 @@
+// Package speculative holds the per-API-key settings of speculative dispatch:
+// a chat request is sent to two models at once, the first answer is returned
+// and the other call is cancelled. Both calls cost money, so the key is billed
+// the winner's tokens times a cost multiplier.
+package speculative
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Policies are stored as JSON in a hash keyed by API key, the user_id of the
+// caller's token
+const policiesKey = "head:speculative"
+
+// cacheTTL bounds how long a head keeps using a policy changed on another head
+const cacheTTL = 30 * time.Second
+
+// DefaultCostMultiplier bills both calls of a race
+const DefaultCostMultiplier = 2.0
+
+// Policy is the speculative dispatch setting of one API key
+type Policy struct {
+	Enabled bool `json:"enabled"`
+	// Partners maps a requested model to the model raced against it; "*"
+	// applies to all other models. A model may race itself, e.g. when
+	// model-proxy spreads it over several providers.
+	Partners map[string]string `json:"partners"`
+	// CostMultiplier applies to the winner's tokens; 0 means DefaultCostMultiplier
+	CostMultiplier float64 `json:"cost_multiplier,omitempty"`
+}
+
+// Validate checks that an enabled policy has partners and a multiplier of at
+// least 1
+func (p Policy) Validate() error {
+	if p.Enabled && len(p.Partners) == 0 {
+		return errors.New("partners are required")
+	}
+	for model, partner := range p.Partners {
+		if model == "" || partner == "" {
+			return errors.New("partners must map a model to a model")
+		}
+	}
+	if p.CostMultiplier != 0 && p.CostMultiplier < 1 {
+		return fmt.Errorf("cost_multiplier must be at least 1")
+	}
+	return nil
+}
+
+// PartnerFor returns the model to race against model, "" for none
+func (p Policy) PartnerFor(model string) string {
+	if !p.Enabled {
+		return ""
+	}
+	if partner, ok := p.Partners[model]; ok {
+		return partner
+	}
+	return p.Partners["*"]
+}
+
+// Multiplier returns the cost multiplier of the policy
+func (p Policy) Multiplier() float64 {
+	if p.CostMultiplier == 0 {
+		return DefaultCostMultiplier
+	}
+	return p.CostMultiplier
+}
+
+// Store keeps the policies in Redis, with a short-lived cache so requests do
+// not wait for Redis
+type Store struct {
+	rdb *redis.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedPolicy
+}
+
+type cachedPolicy struct {
+	policy Policy
+	found  bool
+	at     time.Time
+}
+
+// NewStore creates a Redis-backed policy store
+func NewStore(rdb *redis.Client) *Store {
+	return &Store{rdb: rdb, cache: make(map[string]cachedPolicy)}
+}
+
+// Get returns the policy of an API key; found is false for keys that did not
+// opt in
+func (s *Store) Get(ctx context.Context, apiKey string) (policy Policy, found bool, err error) {
+	s.mu.Lock()
+	c, ok := s.cache[apiKey]
+	s.mu.Unlock()
+	if ok && time.Since(c.at) < cacheTTL {
+		return c.policy, c.found, nil
+	}
+
+	raw, err := s.rdb.HGet(ctx, policiesKey, apiKey).Result()
+	switch {
+	case err == redis.Nil:
+	case err != nil:
+		return Policy{}, false, err
+	default:
+		if err := json.Unmarshal([]byte(raw), &policy); err != nil {
+			return Policy{}, false, fmt.Errorf("invalid policy of %s: %w", apiKey, err)
+		}
+		found = true
+	}
+
+	s.mu.Lock()
+	s.cache[apiKey] = cachedPolicy{policy: policy, found: found, at: time.Now()}
+	s.mu.Unlock()
+	return policy, found, nil
+}
+
+// List returns the policies of all API keys
+func (s *Store) List(ctx context.Context) (map[string]Policy, error) {
+	entries, err := s.rdb.HGetAll(ctx, policiesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]Policy, len(entries))
+	for apiKey, raw := range entries {
+		var policy Policy
+		if err := json.Unmarshal([]byte(raw), &policy); err != nil {
+			continue
+		}
+		policies[apiKey] = policy
+	}
+	return policies, nil
+}
+
+// Put validates and stores the policy of an API key. Other heads pick it up
+// within cacheTTL.
+func (s *Store) Put(ctx context.Context, apiKey string, policy Policy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	if err := s.rdb.HSet(ctx, policiesKey, apiKey, data).Err(); err != nil {
+		return err
+	}
+	s.forget(apiKey)
+	return nil
+}
+
+// Delete removes the policy of an API key and reports whether it had one
+func (s *Store) Delete(ctx context.Context, apiKey string) (bool, error) {
+	n, err := s.rdb.HDel(ctx, policiesKey, apiKey).Result()
+	if err != nil {
+		return false, err
+	}
+	s.forget(apiKey)
+	return n > 0, nil
+}
+
+func (s *Store) forget(apiKey string) {
+	s.mu.Lock()
+	delete(s.cache, apiKey)
+	s.mu.Unlock()
+}
@@ -0,0 +1,254 @@
+// Package structured implements response_format (json_object / json_schema)
+// handling: passing the format to model-proxy, prompting providers without
+// native support, and validating or repairing the JSON they return.
+package structured
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// MetadataKey carries the JSON-encoded response_format on gRPC calls
+const MetadataKey = "x-response-format"
+
+// ResponseFormat is the OpenAI response_format object
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict,omitempty"`
+}
+
+// nativeProviders accept response_format directly (kept in sync with model-proxy)
+var nativeProviders = map[string]bool{
+	"openai":       true,
+	"azure":        true,
+	"gemini":       true,
+	"vertex_ai":    true,
+	"mistral":      true,
+	"groq":         true,
+	"fireworks_ai": true,
+}
+
+// FromIncomingContext returns the response_format sent by the tail, or nil
+func FromIncomingContext(ctx context.Context) (*ResponseFormat, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	values := md.Get(MetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return nil, nil
+	}
+
+	var format ResponseFormat
+	if err := json.Unmarshal([]byte(values[0]), &format); err != nil {
+		return nil, fmt.Errorf("invalid response_format: %w", err)
+	}
+	switch format.Type {
+	case "text":
+		return nil, nil
+	case "json_object":
+	case "json_schema":
+		if format.JSONSchema == nil || len(format.JSONSchema.Schema) == 0 {
+			return nil, fmt.Errorf("json_schema response_format requires a schema")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported response_format type %q", format.Type)
+	}
+	return &format, nil
+}
+
+// AppendToOutgoing forwards the format to model-proxy
+func AppendToOutgoing(ctx context.Context, format *ResponseFormat) context.Context {
+	raw, err := json.Marshal(format)
+	if err != nil {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, string(raw))
+}
+
+// SupportsNative reports whether the provider enforces response_format itself
+func SupportsNative(provider string) bool {
+	return nativeProviders[strings.ToLower(provider)]
+}
+
+// Instruction is appended to the prompt for providers without native support
+func Instruction(format *ResponseFormat) string {
+	if format.Type == "json_schema" {
+		return "Respond only with a JSON value that conforms to this JSON Schema, without any other text or code fences:\n" +
+			string(format.JSONSchema.Schema)
+	}
+	return "Respond only with a valid JSON object, without any other text or code fences."
+}
+
+// RetryInstruction asks the model to correct an invalid answer
+func RetryInstruction(format *ResponseFormat, cause error) string {
+	return fmt.Sprintf("Your previous answer was rejected: %v. %s", cause, Instruction(format))
+}
+
+var (
+	codeFence     = regexp.MustCompile("(?s)^\\s*```[a-zA-Z]*\\s*(.*?)\\s*```\\s*$")
+	trailingComma = regexp.MustCompile(`,(\s*[}\]])`)
+)
+
+// Repair tries cheap fixes for common model mistakes: code fences, text around
+// the JSON value and trailing commas. It returns the input unchanged if nothing helps.
+func Repair(text string) string {
+	candidate := strings.TrimSpace(text)
+	if m := codeFence.FindStringSubmatch(candidate); m != nil {
+		candidate = m[1]
+	}
+
+	if start := strings.IndexAny(candidate, "{["); start >= 0 {
+		closer := "}"
+		if candidate[start] == '[' {
+			closer = "]"
+		}
+		if end := strings.LastIndex(candidate, closer); end > start {
+			candidate = candidate[start : end+1]
+		}
+	}
+	candidate = trailingComma.ReplaceAllString(candidate, "$1")
+
+	if json.Valid([]byte(candidate)) {
+		return candidate
+	}
+	return text
+}
+
+// Validate checks text against the format
+func Validate(text string, format *ResponseFormat) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if format.Type == "json_object" {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("expected a JSON object")
+		}
+		return nil
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(format.JSONSchema.Schema, &schema); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	return validateValue(value, schema, "$")
+}
+
+// validateValue checks the commonly used JSON Schema keywords: type,
+// properties, required, additionalProperties (false), items and enum
+func validateValue(value interface{}, schema map[string]interface{}, path string) error {
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed enum values", path)
+		}
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if matchesType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s", path, strings.Join(types, " or "))
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				key, _ := name.(string)
+				if _, ok := v[key]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, key)
+				}
+			}
+		}
+		for key, child := range v {
+			childSchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+				continue
+			}
+			if err := validateValue(child, childSchema, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, child := range v {
+				if err := validateValue(child, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func schemaTypes(t interface{}) []string {
+	switch v := t.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		types := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func matchesType(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
@@ -0,0 +1,540 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // failed attempt, will be retried
+	DeliveryDead      = "dead"   // retries exhausted, moved to dead-letter storage
+)
+
+// Redis keys
+const (
+	endpointKeyPrefix  = "webhook:endpoint:"
+	endpointsKey       = "webhook:endpoints"
+	tenantEndpointsKey = "webhook:tenant:"
+	deliveryKeyPrefix  = "webhook:delivery:"
+	dueDeliveriesKey   = "webhook:deliveries:due"
+	deadLetterKey      = "webhook:dead_letter"
+)
+
+// Delivery logs are kept for a week
+const deliveryTTL = 7 * 24 * time.Hour
+
+var (
+	ErrEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// Endpoint is a registered webhook receiver. Events are exact event types,
+// prefixes such as "usage.*", or "*" for everything.
+type Endpoint struct {
+	ID          string   `json:"id"`
+	TenantID    string   `json:"tenant_id"`
+	URL         string   `json:"url"`
+	Secret      string   `json:"secret,omitempty"`
+	Events      []string `json:"events"`
+	Description string   `json:"description,omitempty"`
+	Active      bool     `json:"active"`
+	CreatedAt   int64    `json:"created_at"`
+}
+
+// Delivery is one event sent to one endpoint, including its attempt log
+type Delivery struct {
+	ID            string          `json:"id"`
+	EndpointID    string          `json:"endpoint_id"`
+	TenantID      string          `json:"tenant_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt int64           `json:"next_attempt_at,omitempty"`
+	CreatedAt     int64           `json:"created_at"`
+	UpdatedAt     int64           `json:"updated_at"`
+}
+
+// Dispatcher stores endpoints and deliveries in Redis and delivers events
+// with HMAC signatures and exponential backoff. Deliveries are claimed from a
+// due index, so several heads can run the dispatcher concurrently.
+type Dispatcher struct {
+	rdb         *redis.Client
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// NewDispatcher creates a dispatcher. Retries start at baseDelay and double
+// up to one hour; after maxAttempts the delivery is dead-lettered.
+func NewDispatcher(rdb *redis.Client, timeout time.Duration, maxAttempts int, baseDelay time.Duration) *Dispatcher {
+	// Addresses are checked again when connecting: a host may resolve to
+	// another address than at registration, and redirects are followed
+	dialer := &net.Dialer{Timeout: timeout, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Through a proxy only the proxy's address would be checked
+	transport.Proxy = nil
+	return &Dispatcher{
+		rdb:         rdb,
+		client:      &http.Client{Timeout: timeout, Transport: transport},
+		maxAttempts: maxAttempts,
+		baseDelay:   baseDelay,
+		maxDelay:    time.Hour,
+	}
+}
+
+// CreateEndpoint validates and registers an endpoint. The signing secret is
+// generated when not provided and only returned from this call.
+func (d *Dispatcher) CreateEndpoint(ctx context.Context, endpoint *Endpoint) error {
+	if err := validateEndpointURL(ctx, endpoint.URL); err != nil {
+		return err
+	}
+	if len(endpoint.Events) == 0 {
+		endpoint.Events = []string{"*"}
+	}
+	if endpoint.Secret == "" {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		endpoint.Secret = "whsec_" + hex.EncodeToString(secret)
+	}
+
+	endpoint.ID = "we_" + uuid.New().String()
+	endpoint.Active = true
+	endpoint.CreatedAt = time.Now().Unix()
+
+	if err := d.saveEndpoint(ctx, endpoint); err != nil {
+		return err
+	}
+	pipe := d.rdb.TxPipeline()
+	pipe.SAdd(ctx, endpointsKey, endpoint.ID)
+	pipe.SAdd(ctx, tenantEndpointsKey+endpoint.TenantID, endpoint.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// validateEndpointURL accepts absolute https URLs of public hosts, so that
+// endpoints cannot make head post events to itself or the internal network
+func validateEndpointURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return errors.New("url must be an absolute https URL")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("url host cannot be resolved: %w", err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return errors.New("url must not point to a private or loopback address")
+		}
+	}
+	return nil
+}
+
+// nonGlobalNets are the special-purpose ranges that are not globally
+// reachable (IANA special-purpose address registries) beyond those of the
+// net.IP predicates, plus the IPv6 prefixes that embed an IPv4 address
+var nonGlobalNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // this network
+		"100.64.0.0/10",   // shared address space (CGNAT)
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"192.88.99.0/24",  // 6to4 relay anycast
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"240.0.0.0/4",     // reserved, broadcast included
+		"64:ff9b::/96",    // NAT64
+		"64:ff9b:1::/48",  // local NAT64
+		"100::/64",        // discard
+		"2001::/23",       // IETF protocol assignments, Teredo included
+		"2001:db8::/32",   // documentation
+		"2002::/16",       // 6to4
+		"3fff::/20",       // documentation
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// publicIP reports whether ip is routable on the internet
+func publicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, n := range nonGlobalNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublicOnly refuses connections to addresses that are not public
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("webhook delivery to %s is not allowed", host)
+	}
+	return nil
+}
+
+// GetEndpoint returns an endpoint by ID
+func (d *Dispatcher) GetEndpoint(ctx context.Context, id string) (*Endpoint, error) {
+	raw, err := d.rdb.Get(ctx, endpointKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrEndpointNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var endpoint Endpoint
+	if err := json.Unmarshal(raw, &endpoint); err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// ListEndpoints returns the endpoints of a tenant without their secrets
+func (d *Dispatcher) ListEndpoints(ctx context.Context, tenantID string) ([]*Endpoint, error) {
+	ids, err := d.rdb.SMembers(ctx, tenantEndpointsKey+tenantID).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]*Endpoint, 0, len(ids))
+	for _, id := range ids {
+		endpoint, err := d.GetEndpoint(ctx, id)
+		if err != nil {
+			continue
+		}
+		endpoint.Secret = ""
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// SetEndpointActive pauses or resumes deliveries to an endpoint
+func (d *Dispatcher) SetEndpointActive(ctx context.Context, id string, active bool) (*Endpoint, error) {
+	endpoint, err := d.GetEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Active = active
+	if err := d.saveEndpoint(ctx, endpoint); err != nil {
+		return nil, err
+	}
+	endpoint.Secret = ""
+	return endpoint, nil
+}
+
+// DeleteEndpoint removes an endpoint; its pending deliveries are dropped when due
+func (d *Dispatcher) DeleteEndpoint(ctx context.Context, id string) error {
+	endpoint, err := d.GetEndpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	pipe := d.rdb.TxPipeline()
+	pipe.Del(ctx, endpointKeyPrefix+id)
+	pipe.SRem(ctx, endpointsKey, id)
+	pipe.SRem(ctx, tenantEndpointsKey+endpoint.TenantID, id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Publish creates a delivery for every active endpoint of the tenant that
+// subscribes to the event type. Endpoints of tenant "" receive all tenants' events.
+func (d *Dispatcher) Publish(ctx context.Context, tenantID, eventType string, data interface{}) error {
+	payload, err := json.Marshal(WebhookPayload{
+		EventType: eventType,
+		Timestamp: time.Now(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	ids, err := d.rdb.SUnion(ctx, tenantEndpointsKey+tenantID, tenantEndpointsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		endpoint, err := d.GetEndpoint(ctx, id)
+		if err != nil || !endpoint.Active || !subscribes(endpoint.Events, eventType) {
+			continue
+		}
+
+		delivery := &Delivery{
+			ID:            "wd_" + uuid.New().String(),
+			EndpointID:    endpoint.ID,
+			TenantID:      endpoint.TenantID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: now.Unix(),
+			CreatedAt:     now.Unix(),
+			UpdatedAt:     now.Unix(),
+		}
+		if err := d.saveDelivery(ctx, delivery); err != nil {
+			return err
+		}
+		d.rdb.ZAdd(ctx, endpointDeliveriesKey(endpoint.ID), &redis.Z{Score: float64(now.UnixNano()), Member: delivery.ID})
+		d.rdb.Expire(ctx, endpointDeliveriesKey(endpoint.ID), deliveryTTL)
+		d.rdb.ZAdd(ctx, dueDeliveriesKey, &redis.Z{Score: float64(now.Unix()), Member: delivery.ID})
+	}
+	return nil
+}
+
+// GetDelivery returns a delivery by ID
+func (d *Dispatcher) GetDelivery(ctx context.Context, id string) (*Delivery, error) {
+	raw, err := d.rdb.Get(ctx, deliveryKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrDeliveryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var delivery Delivery
+	if err := json.Unmarshal(raw, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListDeliveries returns the latest deliveries of an endpoint, newest first,
+// optionally filtered by status
+func (d *Dispatcher) ListDeliveries(ctx context.Context, endpointID, status string, limit int) ([]*Delivery, error) {
+	ids, err := d.rdb.ZRevRange(ctx, endpointDeliveriesKey(endpointID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*Delivery, 0, limit)
+	for _, id := range ids {
+		if len(deliveries) == limit {
+			break
+		}
+		delivery, err := d.GetDelivery(ctx, id)
+		if err != nil {
+			continue
+		}
+		if status != "" && delivery.Status != status {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// Redeliver schedules a failed or dead delivery for an immediate new attempt
+func (d *Dispatcher) Redeliver(ctx context.Context, id string) (*Delivery, error) {
+	delivery, err := d.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if delivery.Status == DeliveryPending {
+		return nil, fmt.Errorf("delivery is already pending")
+	}
+
+	delivery.Status = DeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().Unix()
+	delivery.UpdatedAt = delivery.NextAttemptAt
+	if err := d.saveDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	d.rdb.LRem(ctx, deadLetterKey, 0, delivery.ID)
+	return delivery, d.rdb.ZAdd(ctx, dueDeliveriesKey, &redis.Z{Score: float64(delivery.NextAttemptAt), Member: delivery.ID}).Err()
+}
+
+// Start delivers due events at the given interval until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.deliverDue(ctx)
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	ids, err := d.rdb.ZRangeByScore(ctx, dueDeliveriesKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Unix(), 10),
+	}).Result()
+	if err != nil {
+		log.Printf("Webhook dispatcher: failed to read due deliveries: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		// ZREM returns 1 only for the head that claimed the delivery
+		claimed, err := d.rdb.ZRem(ctx, dueDeliveriesKey, id).Result()
+		if err != nil || claimed == 0 {
+			continue
+		}
+		d.attempt(ctx, id)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, id string) {
+	delivery, err := d.GetDelivery(ctx, id)
+	if err != nil {
+		return
+	}
+	endpoint, err := d.GetEndpoint(ctx, delivery.EndpointID)
+	if err != nil || !endpoint.Active {
+		return
+	}
+
+	delivery.Attempts++
+	delivery.UpdatedAt = time.Now().Unix()
+	code, err := d.send(ctx, endpoint, delivery)
+	delivery.ResponseCode = code
+
+	switch {
+	case err == nil:
+		delivery.Status = DeliverySucceeded
+		delivery.LastError = ""
+		delivery.NextAttemptAt = 0
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = DeliveryDead
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = 0
+		d.rdb.LPush(ctx, deadLetterKey, delivery.ID)
+		log.Printf("Webhook delivery %s to %s dead-lettered after %d attempts: %v", delivery.ID, endpoint.URL, delivery.Attempts, err)
+	default:
+		delivery.Status = DeliveryFailed
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Now().Add(d.backoff(delivery.Attempts)).Unix()
+		d.rdb.ZAdd(ctx, dueDeliveriesKey, &redis.Z{Score: float64(delivery.NextAttemptAt), Member: delivery.ID})
+	}
+
+	if err := d.saveDelivery(ctx, delivery); err != nil {
+		log.Printf("Webhook dispatcher: failed to save delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// send posts the payload with signature headers. Receivers verify
+// X-Webhook-Signature: t=<unix>,v1=<hex HMAC-SHA256 of "<t>.<body>">.
+func (d *Dispatcher) send(ctx context.Context, endpoint *Endpoint, delivery *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Signature", "t="+timestamp+",v1="+Sign(endpoint.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the delay before the next attempt (1-based attempts)
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.baseDelay << (attempt - 1)
+	if delay > d.maxDelay || delay <= 0 {
+		delay = d.maxDelay
+	}
+	return delay
+}
+
+func (d *Dispatcher) saveEndpoint(ctx context.Context, endpoint *Endpoint) error {
+	raw, err := json.Marshal(endpoint)
+	if err != nil {
+		return err
+	}
+	return d.rdb.Set(ctx, endpointKeyPrefix+endpoint.ID, raw, 0).Err()
+}
+
+func (d *Dispatcher) saveDelivery(ctx context.Context, delivery *Delivery) error {
+	raw, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	return d.rdb.Set(ctx, deliveryKeyPrefix+delivery.ID, raw, deliveryTTL).Err()
+}
+
+func endpointDeliveriesKey(endpointID string) string {
+	return endpointKeyPrefix + endpointID + ":deliveries"
+}
+
+// subscribes reports whether the event filters match the event type
+func subscribes(filters []string, eventType string) bool {
+	for _, filter := range filters {
+		if filter == "*" || filter == eventType {
+			return true
+		}
+		if strings.HasSuffix(filter, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(filter, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestValidateEndpointURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://93.184.216.34/hooks":      true,
+		"https://[2606:4700::1111]/hooks":  true,
+		"http://93.184.216.34/hooks":       false,
+		"ftp://93.184.216.34/hooks":        false,
+		"/hooks":                           false,
+		"https://127.0.0.1/hooks":          false,
+		"https://localhost:8443/hooks":     false,
+		"https://[::1]/hooks":              false,
+		"https://10.0.0.5/hooks":           false,
+		"https://192.168.1.10/hooks":       false,
+		"https://172.16.0.1/hooks":         false,
+		"https://169.254.169.254/metadata": false,
+		"https://0.0.0.0/hooks":            false,
+		"https://[fd00::1]/hooks":          false,
+		"https://100.64.0.1/hooks":         false,
+		"https://100.127.255.254/hooks":    false,
+		"https://192.0.0.8/hooks":          false,
+		"https://192.0.2.10/hooks":         false,
+		"https://198.18.0.1/hooks":         false,
+		"https://198.19.255.1/hooks":       false,
+		"https://203.0.113.7/hooks":        false,
+		"https://240.0.0.1/hooks":          false,
+		"https://255.255.255.255/hooks":    false,
+		"https://224.0.0.1/hooks":          false,
+		"https://[::ffff:10.0.0.5]/hooks":  false,
+		"https://[64:ff9b::a00:5]/hooks":   false,
+		"https://[2002:a00:5::1]/hooks":    false,
+		"https://[2001:db8::1]/hooks":      false,
+		"https://[ff02::1]/hooks":          false,
+	}
+	for raw, valid := range tests {
+		if err := validateEndpointURL(context.Background(), raw); (err == nil) != valid {
+			t.Errorf("%s: err %v, want valid %v", raw, err, valid)
+		}
+	}
+}
+
+func TestDialPublicOnly(t *testing.T) {
+	for address, allowed := range map[string]bool{
+		"93.184.216.34:443":   true,
+		"127.0.0.1:443":       false,
+		"10.1.2.3:443":        false,
+		"[fe80::1%eth0]:443":  false,
+		"169.254.169.254:443": false,
+		"100.64.0.1:443":      false,
+		"198.18.0.1:443":      false,
+	} {
+		if err := dialPublicOnly("tcp", address, nil); (err == nil) != allowed {
+			t.Errorf("%s: err %v, want allowed %v", address, err, allowed)
+		}
+	}
+
+	d := NewDispatcher(nil, 0, 1, 0)
+	if d.client.Transport.(*http.Transport).Proxy != nil {
+		t.Error("delivery client goes through a proxy")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if _, err := d.client.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("delivery client connected to a loopback address")
+	}
+}
@@ -30,9 +30,10 @@ type WebhookConfig struct {
 
 // WebhookClient handles webhook notifications
 type WebhookClient struct {
-    config WebhookConfig
-    client *http.Client
-    mu     sync.Mutex
+    config     WebhookConfig
+    client     *http.Client
+    mu         sync.Mutex
+    dispatcher *Dispatcher
 }
 
 // WebhookPayload represents the payload sent to webhooks
@@ -133,10 +134,26 @@ func (w *WebhookClient) SendWebhook(ctx context.Context, eventType string, data
     return fmt.Errorf("webhook failed after %d attempts: %w", w.config.MaxRetries+1, lastErr)
 }
 
+// SetDispatcher also fans events out to the endpoints registered via the webhook API
+func (w *WebhookClient) SetDispatcher(dispatcher *Dispatcher) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.dispatcher = dispatcher
+}
+
 // SendAsyncWebhook sends a webhook asynchronously
 func (w *WebhookClient) SendAsyncWebhook(eventType string, data interface{}) {
+    w.mu.Lock()
+    dispatcher := w.dispatcher
+    w.mu.Unlock()
+
     go func() {
         ctx := context.Background()
+        if dispatcher != nil {
+            if err := dispatcher.Publish(ctx, tenantOf(data), eventType, data); err != nil {
+                fmt.Printf("Webhook publish failed: %v\n", err)
+            }
+        }
         if err := w.SendWebhook(ctx, eventType, data); err != nil {
             // Log the error (in a real implementation, this would use proper logging)
             fmt.Printf("Async webhook failed: %v\n", err)
@@ -165,6 +182,16 @@ func (w *WebhookClient) IsEnabled() bool {
     return w.config.Enabled
 }
 
-
-
-
+// tenantOf returns the tenant an event belongs to, taken from its tenant_id or user_id field
+func tenantOf(data interface{}) string {
+    fields, ok := data.(map[string]interface{})
+    if !ok {
+        return ""
+    }
+    for _, key := range []string{"tenant_id", "user_id"} {
+        if id, ok := fields[key].(string); ok && id != "" {
+            return id
+        }
+    }
+    return ""
+}
@@ -2,7 +2,11 @@
 import os
 import json
 import logging
+import signal
+import socket
+import threading
 from concurrent import futures
+from contextlib import contextmanager
 import ssl
 import grpc
 # generated modules expected: model_pb2, model_pb2_grpc
@@ -21,6 +25,26 @@ try:
 except Exception:
     LITELLM = False
 
+# optional: GPU utilization is reported only where NVML is available
+try:
+    import pynvml
+    pynvml.nvmlInit()
+    NVML = True
+except Exception:
+    NVML = False
+
+# optional: traces continue from head-go through the gRPC metadata
+try:
+    from opentelemetry import trace
+    from opentelemetry.exporter.otlp.proto.grpc.trace_exporter import OTLPSpanExporter
+    from opentelemetry.instrumentation.grpc import GrpcInstrumentorServer
+    from opentelemetry.sdk.resources import Resource
+    from opentelemetry.sdk.trace import TracerProvider
+    from opentelemetry.sdk.trace.export import BatchSpanProcessor
+    OTEL = True
+except Exception:
+    OTEL = False
+
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger("model-proxy")
 
@@ -40,7 +64,153 @@ def get_provider_keys_from_secrets():
 
 PROVIDER_KEYS = get_provider_keys_from_secrets()
 
-def call_litellm(provider_model, messages, temperature, max_tokens):
+# Models this instance serves (comma separated); empty means any model of a
+# provider in PROVIDER_KEYS. head-go reads them with GetCapabilities.
+SERVED_MODELS = [m.strip() for m in os.getenv("MODEL_PROXY_MODELS", "").split(",") if m.strip()]
+INSTANCE_ID = os.getenv("INSTANCE_ID", socket.gethostname())
+MAX_CONCURRENT_GENERATIONS = int(os.getenv("MAX_CONCURRENT_GENERATIONS", "10"))
+
+class Capacity:
+    """Bounds concurrent generations and counts running and queued ones"""
+    def __init__(self, max_concurrent):
+        self.max_concurrent = max_concurrent
+        self.draining = False
+        self.in_flight = 0
+        self.queued = 0
+        self._slots = threading.Semaphore(max_concurrent)
+        self._lock = threading.Lock()
+
+    @contextmanager
+    def slot(self):
+        with self._lock:
+            self.queued += 1
+        self._slots.acquire()
+        with self._lock:
+            self.queued -= 1
+            self.in_flight += 1
+        try:
+            yield
+        finally:
+            with self._lock:
+                self.in_flight -= 1
+            self._slots.release()
+
+CAPACITY = Capacity(MAX_CONCURRENT_GENERATIONS)
+
+def gpu_utilization():
+    """Average GPU and GPU memory utilization in percent, -1 without a GPU"""
+    if not NVML:
+        return -1.0, -1.0
+    try:
+        count = pynvml.nvmlDeviceGetCount()
+        if count == 0:
+            return -1.0, -1.0
+        gpu = memory = 0.0
+        for i in range(count):
+            handle = pynvml.nvmlDeviceGetHandleByIndex(i)
+            gpu += pynvml.nvmlDeviceGetUtilizationRates(handle).gpu
+            info = pynvml.nvmlDeviceGetMemoryInfo(handle)
+            memory += 100.0 * info.used / info.total
+        return gpu / count, memory / count
+    except pynvml.NVMLError as e:
+        logger.warning(f"failed to read GPU utilization: {e}")
+        return -1.0, -1.0
+
+# Providers that accept response_format natively; for the others head-go
+# adds a JSON instruction to the prompt and validates the output itself
+RESPONSE_FORMAT_PROVIDERS = {"openai", "azure", "gemini", "vertex_ai", "mistral", "groq", "fireworks_ai"}
+
+def get_response_format(context):
+    """response_format is passed by head-go as JSON in x-response-format metadata"""
+    if context is None:
+        return None
+    for key, value in context.invocation_metadata():
+        if key == "x-response-format":
+            try:
+                return json.loads(value)
+            except ValueError:
+                logger.warning("ignoring invalid x-response-format metadata")
+    return None
+
+# Sampling parameters each provider accepts, in OpenAI names, when litellm
+# cannot tell. litellm maps the names to the provider's (e.g. stop to
+# stop_sequences for anthropic); parameters a provider does not accept are
+# refused instead of silently dropped. Providers not listed are passed through.
+SAMPLING_PARAMS = {
+    "openai": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed", "logprobs", "top_logprobs"},
+    "azure": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed", "logprobs", "top_logprobs"},
+    "anthropic": {"top_p", "stop"},
+    "gemini": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed", "logprobs", "top_logprobs"},
+    "vertex_ai": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed", "logprobs", "top_logprobs"},
+    "mistral": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed"},
+    "groq": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed"},
+    "cohere": {"top_p", "frequency_penalty", "presence_penalty", "stop", "seed"},
+    "fireworks_ai": {"top_p", "frequency_penalty", "presence_penalty", "stop", "logprobs", "top_logprobs"},
+}
+
+class UnsupportedParams(ValueError):
+    pass
+
+def sampling_params(request):
+    """Sampling parameters set on a GenRequest; unset ones keep the provider defaults"""
+    params = {}
+    if request.top_p:
+        params["top_p"] = request.top_p
+    if request.frequency_penalty:
+        params["frequency_penalty"] = request.frequency_penalty
+    if request.presence_penalty:
+        params["presence_penalty"] = request.presence_penalty
+    if request.stop:
+        params["stop"] = list(request.stop)
+    if request.HasField("seed"):
+        params["seed"] = request.seed
+    if request.logprobs:
+        params["logprobs"] = True
+        if request.top_logprobs:
+            params["top_logprobs"] = request.top_logprobs
+    return params
+
+def check_sampling(provider_model, params):
+    """Raises UnsupportedParams for parameters the provider does not accept"""
+    if not params:
+        return
+    provider, _, model = provider_model.partition("/")
+    supported = None
+    if LITELLM:
+        try:
+            supported = litellm.get_supported_openai_params(model=model, custom_llm_provider=provider)
+        except Exception:
+            supported = None
+    if supported is None:
+        supported = SAMPLING_PARAMS.get(provider)
+    if supported is None:
+        return
+    unsupported = sorted(p for p in params if p not in supported)
+    if unsupported:
+        raise UnsupportedParams(f"{provider} does not support {', '.join(unsupported)}")
+
+def response_logprobs(res):
+    """Token logprobs of the first choice of a completion, as TokenLogprob messages"""
+    try:
+        content = res["choices"][0]["logprobs"]["content"] or []
+    except (KeyError, IndexError, TypeError):
+        return []
+    logprobs = []
+    for t in content:
+        top = [model_pb2.TopLogprob(token=x["token"], logprob=x["logprob"]) for x in (t.get("top_logprobs") or [])]
+        logprobs.append(model_pb2.TokenLogprob(token=t["token"], logprob=t["logprob"], top_logprobs=top))
+    return logprobs
+
+def remaining_time(context):
+    """Seconds left of the caller's deadline, None without one. head-go passes
+    on the deadline of the tail request, so a call that already ran out of time
+    is not started."""
+    left = context.time_remaining() if context else None
+    if left is not None and left <= 0:
+        context.abort(grpc.StatusCode.DEADLINE_EXCEEDED, "deadline exceeded before generation")
+    return left
+
+def call_litellm(provider_model, messages, temperature, max_tokens, response_format=None, request_id="", sampling=None, timeout=None):
     provider = provider_model.split("/")[0]
     try:
         # Convert messages to litellm format
@@ -52,26 +222,50 @@ def call_litellm(provider_model, messages, temperature, max_tokens):
                 litellm_messages.append({"role": "user", "content": str(msg)})
 
         litellm.api_key = PROVIDER_KEYS.get(provider)
+        kwargs = {}
+        if response_format and provider in RESPONSE_FORMAT_PROVIDERS:
+            kwargs["response_format"] = response_format
+        if sampling:
+            kwargs.update(sampling)
+        if timeout is not None:
+            kwargs["timeout"] = timeout
         return completion(
             model=provider_model,
             messages=litellm_messages,
             temperature=temperature,
             max_tokens=max_tokens,
-            stream=False
+            stream=False,
+            **kwargs
         )
     except Exception as e:
-        logger.exception("litellm call failed")
+        logger.exception("litellm call failed request_id=%s", request_id)
         return {"text": "litellm error: "+str(e), "usage": {"total_tokens": 0}}
 
 class ModelServicer:
     # will be wrapped when protos are generated
     def Generate(self, request, context):
+        with CAPACITY.slot():
+            return self._generate(request, context)
+
+    def _generate(self, request, context):
         msgs = list(request.messages) if request and hasattr(request, "messages") else []
         text = " ".join(msgs) if msgs else "empty"
+        # head-go sends the request ID of the tail/gateway edge, so these lines
+        # can be matched with theirs
+        request_id = request.request_id if request and hasattr(request, "request_id") else ""
+        logger.info("generate request_id=%s model=%s", request_id, request.model)
+        logprobs = []
         if LITELLM:
             prov = request.model or "local"
+            sampling = sampling_params(request)
+            try:
+                check_sampling(f"{prov}/{request.model}", sampling)
+            except UnsupportedParams as e:
+                context.abort(grpc.StatusCode.INVALID_ARGUMENT, str(e))
+            timeout = remaining_time(context)
             try:
-                res = call_litellm(f"{prov}/{request.model}", msgs, request.temperature, request.max_tokens)
+                res = call_litellm(f"{prov}/{request.model}", msgs, request.temperature, request.max_tokens, get_response_format(context), request_id, sampling, timeout)
+                logprobs = response_logprobs(res)
                 text = ""
                 if isinstance(res, dict):
                     if "choices" in res and len(res["choices"])>0:
@@ -82,7 +276,7 @@ class ModelServicer:
                 else:
                     text = str(res)
             except Exception as e:
-                logger.exception("error")
+                logger.exception("generation failed request_id=%s", request_id)
                 text = "error: "+str(e)
 
         # Create and return proper GenResponse
@@ -90,7 +284,8 @@ class ModelServicer:
         return model_pb2.GenResponse(
             request_id=request.request_id if request and hasattr(request, "request_id") else "",
             text=text,
-            tokens_used=tokens_used
+            tokens_used=tokens_used,
+            logprobs=logprobs
         )
 
     def BatchGenerate(self, request, context):
@@ -99,47 +294,62 @@ class ModelServicer:
 
         for single_request in request.requests:
             # Process each request individually but within the same batch
-            msgs = list(single_request.messages) if single_request and hasattr(single_request, "messages") else []
-            text = " ".join(msgs) if msgs else "empty"
-
-            if LITELLM:
-                prov = single_request.model or "local"
-                try:
-                    res = call_litellm(f"{prov}/{single_request.model}", msgs, single_request.temperature, single_request.max_tokens)
-                    text = ""
-                    if isinstance(res, dict):
-                        if "choices" in res and len(res["choices"])>0:
-                            for c in res["choices"]:
-                                text += c.get("message",{}).get("content","") or c.get("text","")
+            with CAPACITY.slot():
+                msgs = list(single_request.messages) if single_request and hasattr(single_request, "messages") else []
+                text = " ".join(msgs) if msgs else "empty"
+
+                if LITELLM:
+                    prov = single_request.model or "local"
+                    try:
+                        sampling = sampling_params(single_request)
+                        check_sampling(f"{prov}/{single_request.model}", sampling)
+                        res = call_litellm(f"{prov}/{single_request.model}", msgs, single_request.temperature, single_request.max_tokens, sampling=sampling)
+                        text = ""
+                        if isinstance(res, dict):
+                            if "choices" in res and len(res["choices"])>0:
+                                for c in res["choices"]:
+                                    text += c.get("message",{}).get("content","") or c.get("text","")
+                            else:
+                                text = res.get("text", str(res))
                         else:
-                            text = res.get("text", str(res))
-                    else:
-                        text = str(res)
-                except Exception as e:
-                    logger.exception("error")
-                    text = "error: "+str(e)
-
-            # Create and return proper GenResponse for this request
-            tokens_used = max(1, len(text) // 4)  # Simple token estimation
-            response = model_pb2.GenResponse(
-                request_id=single_request.request_id if single_request and hasattr(single_request, "request_id") else "",
-                text=text,
-                tokens_used=tokens_used
-            )
-            responses.append(response)
+                            text = str(res)
+                    except Exception as e:
+                        logger.exception("error")
+                        text = "error: "+str(e)
+
+                # Create and return proper GenResponse for this request
+                tokens_used = max(1, len(text) // 4)  # Simple token estimation
+                response = model_pb2.GenResponse(
+                    request_id=single_request.request_id if single_request and hasattr(single_request, "request_id") else "",
+                    text=text,
+                    tokens_used=tokens_used
+                )
+                responses.append(response)
 
         return model_pb2.BatchGenResponse(responses=responses)
 
     def GenerateStream(self, request, context):
         """Streaming version of Generate that yields multiple responses"""
+        with CAPACITY.slot():
+            yield from self._generate_stream(request, context)
+
+    def _generate_stream(self, request, context):
         msgs = list(request.messages) if request and hasattr(request, "messages") else []
         text = " ".join(msgs) if msgs else "empty"
 
         # For streaming, we'll split the response into chunks
         if LITELLM:
             prov = request.model or "local"
+            sampling = sampling_params(request)
+            try:
+                check_sampling(f"{prov}/{request.model}", sampling)
+            except UnsupportedParams as e:
+                context.abort(grpc.StatusCode.INVALID_ARGUMENT, str(e))
+            timeout = remaining_time(context)
             try:
-                res = call_litellm(f"{prov}/{request.model}", msgs, request.temperature, request.max_tokens)
+                res = call_litellm(f"{prov}/{request.model}", msgs, request.temperature, request.max_tokens, get_response_format(context), sampling=sampling, timeout=timeout)
+                # Logprobs belong to the first choice, sent with its chunk
+                logprobs = response_logprobs(res)
                 if isinstance(res, dict):
                     if "choices" in res and len(res["choices"])>0:
                         # Yield each choice as a separate response
@@ -150,8 +360,10 @@ class ModelServicer:
                                 yield model_pb2.GenResponse(
                                     request_id=request.request_id if request and hasattr(request, "request_id") else "",
                                     text=chunk_text,
-                                    tokens_used=tokens_used
+                                    tokens_used=tokens_used,
+                                    logprobs=logprobs
                                 )
+                                logprobs = []
                     else:
                         # Single response
                         text = res.get("text", str(res))
@@ -187,6 +399,27 @@ class ModelServicer:
                 tokens_used=tokens_used
             )
 
+    def GetCapabilities(self, request, context):
+        """Models this instance serves, for routing in head-go"""
+        return model_pb2.CapabilitiesResponse(
+            instance_id=INSTANCE_ID,
+            models=SERVED_MODELS,
+            providers=sorted(PROVIDER_KEYS.keys()),
+            max_concurrent=CAPACITY.max_concurrent
+        )
+
+    def GetHealth(self, request, context):
+        """Load of this instance, for admission control in head-go"""
+        gpu, gpu_memory = gpu_utilization()
+        return model_pb2.HealthResponse(
+            status="draining" if CAPACITY.draining else "serving",
+            in_flight=CAPACITY.in_flight,
+            queue_depth=CAPACITY.queued,
+            max_concurrent=CAPACITY.max_concurrent,
+            gpu_utilization=gpu,
+            gpu_memory_utilization=gpu_memory
+        )
+
 def get_server_credentials():
     with open("/workspace/ZB/certs/model-proxy.pem", "rb") as f:
         cert_chain = f.read()
@@ -201,8 +434,25 @@ def get_server_credentials():
         require_client_auth=True  # Обязательная взаимная аутентификация
     )
 
+def setup_tracing():
+    """Instrument the gRPC server; spans are exported over OTLP only when
+    OTEL_EXPORTER_OTLP_ENDPOINT is set, as in the Go services"""
+    if not OTEL:
+        logger.info("opentelemetry not installed, tracing disabled")
+        return
+    if os.getenv("OTEL_EXPORTER_OTLP_ENDPOINT"):
+        name = os.getenv("OTEL_SERVICE_NAME", "model-proxy")
+        provider = TracerProvider(resource=Resource.create({"service.name": name}))
+        provider.add_span_processor(BatchSpanProcessor(OTLPSpanExporter()))
+        trace.set_tracer_provider(provider)
+    GrpcInstrumentorServer().instrument()
+
 def serve():
-    server = grpc.server(futures.ThreadPoolExecutor(max_workers=10))
+    setup_tracing()
+
+    # Workers beyond MAX_CONCURRENT_GENERATIONS let queued requests be counted
+    # and keep GetHealth answering while every generation slot is busy
+    server = grpc.server(futures.ThreadPoolExecutor(max_workers=MAX_CONCURRENT_GENERATIONS * 2 + 4))
     model_pb2_grpc.add_ModelServiceServicer_to_server(ModelServicer(), server)
 
     port = os.getenv("GRPC_PORT", "50061")
@@ -211,6 +461,14 @@ def serve():
 
     logger.info(f"model-proxy mTLS gRPC server starting on :{port}")
     server.start()
+
+    # Report draining so head-go stops sending work, then finish what is running
+    def drain(signum, frame):
+        logger.info("draining model-proxy")
+        CAPACITY.draining = True
+        server.stop(int(os.getenv("SHUTDOWN_GRACE_SECONDS", "30")))
+    signal.signal(signal.SIGTERM, drain)
+
     server.wait_for_termination()
 
 if __name__ == "__main__":
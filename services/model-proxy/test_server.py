@@ -48,6 +48,11 @@ def run_test():
                 print(f"  Text: {chunk.text}")
                 print(f"  Tokens used: {chunk.tokens_used}")
 
+            # Test capacity reporting
+            print("\nTesting GetCapabilities and GetHealth methods...")
+            print(f"Capabilities: {stub.GetCapabilities(model_pb2.CapabilitiesRequest())}")
+            print(f"Health: {stub.GetHealth(model_pb2.HealthRequest())}")
+
         except grpc.RpcError as e:
             print(f"gRPC error: {e.code()} - {e.details()}")
         except Exception as e:
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Pending changes are stored as JSON and indexed in a set; unapproved changes expire
+const (
+	pendingChangeKeyPrefix = "network_config:change:"
+	pendingChangesKey      = "network_config:changes"
+	pendingChangeTTL       = 24 * time.Hour
+)
+
+// requireApproval enables two-person approval for head_endpoint changes
+// (CONFIG_REQUIRE_APPROVAL=true)
+var requireApproval = os.Getenv("CONFIG_REQUIRE_APPROVAL") == "true"
+
+var errChangeNotFound = errors.New("pending change not found")
+
+// PendingChange is a config update waiting for a second admin
+type PendingChange struct {
+	ID                   string         `json:"id"`
+	Config               NetworkConfig  `json:"config"`
+	Diff                 []ConfigChange `json:"diff"`
+	Author               string         `json:"author"`
+	Comment              string         `json:"comment,omitempty"`
+	PreviousHeadEndpoint string         `json:"previous_head_endpoint"`
+	CreatedAt            time.Time      `json:"created_at"`
+	ExpiresAt            time.Time      `json:"expires_at"`
+}
+
+func pendingChangeKey(id string) string { return pendingChangeKeyPrefix + id }
+
+// needsApproval reports whether applying cfg requires a second admin
+func needsApproval(cfg NetworkConfig) bool {
+	if !requireApproval {
+		return false
+	}
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return cfg.HeadEndpoint != currentConfig.HeadEndpoint
+}
+
+// applyConfig saves cfg, makes it current and records the version
+func applyConfig(cfg NetworkConfig, author, comment string) (*ConfigVersion, error) {
+	if err := saveConfig(cfg); err != nil {
+		return nil, err
+	}
+	loadConfig()
+	return recordVersion(cfg, author, comment)
+}
+
+// submitForApproval stores cfg as a pending change and responds with 202
+func submitForApproval(w http.ResponseWriter, r *http.Request, cfg NetworkConfig, comment string) {
+	configMutex.RLock()
+	current := currentConfig
+	configMutex.RUnlock()
+
+	now := time.Now().UTC()
+	change := &PendingChange{
+		ID:                   uuid.New().String(),
+		Config:               cfg,
+		Diff:                 diffConfigs(&current, &cfg),
+		Author:               requestAuthor(r),
+		Comment:              comment,
+		PreviousHeadEndpoint: current.HeadEndpoint,
+		CreatedAt:            now,
+		ExpiresAt:            now.Add(pendingChangeTTL),
+	}
+
+	raw, _ := json.Marshal(change)
+	pipe := redisClient.TxPipeline()
+	pipe.Set(r.Context(), pendingChangeKey(change.ID), raw, pendingChangeTTL)
+	pipe.SAdd(r.Context(), pendingChangesKey, change.ID)
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		logger.Error("Failed to store pending change", zap.Error(err))
+		http.Error(w, "Failed to store pending change", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("Config change awaits approval", zap.String("change", change.ID), zap.String("author", change.Author))
+
+	maskPendingChange(change)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "pending_approval", "change": change})
+}
+
+func loadPendingChange(ctx context.Context, id string) (*PendingChange, error) {
+	raw, err := redisClient.Get(ctx, pendingChangeKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, errChangeNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var change PendingChange
+	if err := json.Unmarshal(raw, &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+func deletePendingChange(ctx context.Context, id string) {
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, pendingChangeKey(id))
+	pipe.SRem(ctx, pendingChangesKey, id)
+	pipe.Exec(ctx)
+}
+
+func maskPendingChange(change *PendingChange) {
+	change.Config.SecurityToken = "***"
+	for i := range change.Diff {
+		maskChange(&change.Diff[i])
+	}
+}
+
+func writeChangeError(w http.ResponseWriter, err error) {
+	if err == errChangeNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Error("Failed to load pending change", zap.Error(err))
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+// getPendingChanges handles GET /api/config/changes
+func getPendingChanges(w http.ResponseWriter, r *http.Request) {
+	ids, err := redisClient.SMembers(r.Context(), pendingChangesKey).Result()
+	if err != nil {
+		writeChangeError(w, err)
+		return
+	}
+
+	changes := []*PendingChange{}
+	for _, id := range ids {
+		change, err := loadPendingChange(r.Context(), id)
+		if err == errChangeNotFound {
+			// Expired
+			redisClient.SRem(r.Context(), pendingChangesKey, id)
+			continue
+		} else if err != nil {
+			writeChangeError(w, err)
+			return
+		}
+		maskPendingChange(change)
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].CreatedAt.Before(changes[j].CreatedAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// approveChange handles POST /api/config/changes/{id}/approve. The approver
+// must be a different admin than the author, and the head endpoint must not
+// have changed since the change was submitted.
+func approveChange(w http.ResponseWriter, r *http.Request) {
+	change, err := loadPendingChange(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeChangeError(w, err)
+		return
+	}
+
+	approver := requestAuthor(r)
+	if approver == change.Author {
+		http.Error(w, "A change cannot be approved by its author", http.StatusForbidden)
+		return
+	}
+
+	configMutex.RLock()
+	currentHead := currentConfig.HeadEndpoint
+	configMutex.RUnlock()
+	if currentHead != change.PreviousHeadEndpoint {
+		http.Error(w, "head_endpoint changed since the change was submitted; submit it again", http.StatusConflict)
+		return
+	}
+
+	// Reachability may have changed while the change was pending
+	if errs := validateConfig(change.Config, r.URL.Query().Get("skip_connectivity") != "true"); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	comment := fmt.Sprintf("approved by %s", approver)
+	if change.Comment != "" {
+		comment = change.Comment + "; " + comment
+	}
+	version, err := applyConfig(change.Config, change.Author, comment)
+	if err != nil {
+		logger.Error("Failed to apply approved change", zap.Error(err))
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+	deletePendingChange(r.Context(), change.ID)
+	logger.Info("Config change approved", zap.String("change", change.ID), zap.String("approver", approver))
+
+	w.Header().Set("Content-Type", "application/json")
+	if version == nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "applied"})
+		return
+	}
+	maskVersion(version)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "applied", "version": version})
+}
+
+// rejectChange handles POST /api/config/changes/{id}/reject
+func rejectChange(w http.ResponseWriter, r *http.Request) {
+	change, err := loadPendingChange(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeChangeError(w, err)
+		return
+	}
+
+	deletePendingChange(r.Context(), change.ID)
+	logger.Info("Config change rejected", zap.String("change", change.ID), zap.String("by", requestAuthor(r)))
+	w.WriteHeader(http.StatusNoContent)
+}
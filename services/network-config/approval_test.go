@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestTwoPersonApproval(t *testing.T) {
+	requireApproval = true
+	t.Cleanup(func() { requireApproval = false })
+
+	author := token(t, jwt.MapClaims{"user_id": "admin-1", "role": "admin"})
+	approver := token(t, jwt.MapClaims{"user_id": "admin-2", "role": "admin"})
+	user := token(t, jwt.MapClaims{"user_id": "user-1", "role": "user"})
+
+	configMutex.RLock()
+	cfg := currentConfig
+	configMutex.RUnlock()
+	previousHead := cfg.HeadEndpoint
+	cfg.HeadEndpoint = "grpc://head-2:50055"
+	cfg.SecurityToken = "rotated-token"
+	body, _ := json.Marshal(cfg)
+
+	update := requireAdmin("config.update", updateConfig)
+	if rec := call(update, http.MethodPut, "/api/config?skip_connectivity=true", user, string(body)); rec.Code != http.StatusForbidden {
+		t.Fatalf("update by a user: %d, want 403", rec.Code)
+	}
+	rec := call(update, http.MethodPut, "/api/config?skip_connectivity=true", author, string(body))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("head change: %d %s, want 202", rec.Code, rec.Body)
+	}
+	var submitted struct {
+		Change PendingChange `json:"change"`
+	}
+	json.NewDecoder(rec.Body).Decode(&submitted)
+	if submitted.Change.Config.SecurityToken != "***" {
+		t.Errorf("pending change shows security_token %q", submitted.Change.Config.SecurityToken)
+	}
+
+	approve := func(bearer string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/config/changes/"+submitted.Change.ID+"/approve?skip_connectivity=true", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		muxRouter("/api/config/changes/{id}/approve", requireAdmin("config.change.approve", approveChange)).ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := approve(author); rec.Code != http.StatusForbidden {
+		t.Errorf("approval by the author: %d, want 403", rec.Code)
+	}
+	if rec := approve(user); rec.Code != http.StatusForbidden {
+		t.Errorf("approval by a user: %d, want 403", rec.Code)
+	}
+	configMutex.RLock()
+	head := currentConfig.HeadEndpoint
+	configMutex.RUnlock()
+	if head != previousHead {
+		t.Fatalf("head changed to %s before approval", head)
+	}
+
+	if rec := approve(approver); rec.Code != http.StatusOK {
+		t.Fatalf("approval by a second admin: %d %s", rec.Code, rec.Body)
+	}
+	configMutex.RLock()
+	head = currentConfig.HeadEndpoint
+	configMutex.RUnlock()
+	if head != cfg.HeadEndpoint {
+		t.Errorf("head after approval: %s, want %s", head, cfg.HeadEndpoint)
+	}
+	if rec := approve(approver); rec.Code != http.StatusNotFound {
+		t.Errorf("second approval: %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/ipfilter"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Roles accepted by the API
+const (
+	roleAdmin = "admin"
+	// roleNode is held by head/tail instances; a node may only read its own
+	// WireGuard config and report its own status (user_id must equal the peer ID)
+	roleNode = "node"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+// tokenClaims matches the tokens issued by auth-service, which carry the
+// user's role in "role"; "roles" lists several roles, e.g. in node tokens
+type tokenClaims struct {
+	UserID string   `json:"user_id"`
+	Role   string   `json:"role"`
+	Roles  []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+func (c *tokenClaims) hasRole(role string) bool {
+	if c.Role == role {
+		return true
+	}
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtSecret verifies Bearer tokens (JWT_SECRET)
+var jwtSecret []byte
+
+func initAuth() {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		logger.Fatal("JWT_SECRET is not set; the config API cannot run unauthenticated")
+	}
+	jwtSecret = []byte(secret)
+}
+
+func parseToken(r *http.Request) (*tokenClaims, error) {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == "" || token == header {
+		return nil, errors.New("missing bearer token")
+	}
+
+	claims := &tokenClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims.UserID == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func claimsFromRequest(r *http.Request) *tokenClaims {
+	claims, _ := r.Context().Value(claimsContextKey).(*tokenClaims)
+	return claims
+}
+
+// authorize wraps a handler with token validation; allow decides whether the
+// token may call it
+func authorize(allow func(*tokenClaims, *http.Request) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := parseToken(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !allow(claims, r) {
+			logger.Warn("Forbidden config API call",
+				zap.String("user", claims.UserID), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+	}
+}
+
+// requireToken accepts any valid token (read access)
+func requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return authorize(func(*tokenClaims, *http.Request) bool { return true }, next)
+}
+
+// requireAdmin restricts a handler to admins calling from the IPs adminIPs
+// lets through and records it in the audit log
+func requireAdmin(action string, next http.HandlerFunc) http.HandlerFunc {
+	return adminIPs.Middleware(auditBlockedIP)(authorize(func(c *tokenClaims, _ *http.Request) bool {
+		return c.hasRole(roleAdmin)
+	}, audited(action, next))).ServeHTTP
+}
+
+// adminIPs filters admin calls by ADMIN_IP_ALLOW and ADMIN_IP_DENY, or the
+// rules published for network-config
+var adminIPs *ipfilter.Filter
+
+func initAdminIPs(ctx context.Context) {
+	var err error
+	adminIPs, err = ipfilter.New("network-config", redisClient, ipfilter.FromEnv("ADMIN_IP"))
+	if err != nil {
+		logger.Fatal("Invalid admin IP filter", zap.Error(err))
+	}
+	if err := adminIPs.Load(ctx); err != nil {
+		logger.Error("Failed to load the admin IP filter, using ADMIN_IP_*", zap.Error(err))
+	}
+	adminIPs.Watch(ctx, time.Minute)
+}
+
+// auditBlockedIP records admin calls blocked by the IP filter; the token is
+// not checked yet, so the actor is the IP
+func auditBlockedIP(r *http.Request, b ipfilter.Blocked) {
+	logger.Warn("Admin call blocked by IP filter",
+		zap.String("ip", b.IP), zap.String("reason", b.Reason),
+		zap.String("method", r.Method), zap.String("path", r.URL.Path))
+	writeAudit(AuditEntry{
+		Actor:      b.IP,
+		Action:     "ip_filter.blocked",
+		Target:     r.URL.Path,
+		Params:     map[string]string{"reason": b.Reason, "method": r.Method},
+		Status:     http.StatusForbidden,
+		RemoteAddr: r.RemoteAddr,
+		CreatedAt:  time.Now().UTC(),
+	})
+}
+
+// requirePeerAccess allows admins and the node the {id} peer belongs to
+func requirePeerAccess(action string, next http.HandlerFunc) http.HandlerFunc {
+	return authorize(func(c *tokenClaims, r *http.Request) bool {
+		return c.hasRole(roleAdmin) || (c.hasRole(roleNode) && c.UserID == mux.Vars(r)["id"])
+	}, audited(action, next))
+}
+
+// AuditEntry records one change request against the API
+type AuditEntry struct {
+	ID         int64             `json:"id"`
+	Actor      string            `json:"actor"`
+	Action     string            `json:"action"`
+	Target     string            `json:"target"`
+	Params     map[string]string `json:"params,omitempty"`
+	Status     int               `json:"status"`
+	RemoteAddr string            `json:"remote_addr"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// audited records the request and its response status after the handler ran.
+// Entries go to the log and, when configured, to Postgres.
+func audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := httpmetrics.NewStatusRecorder(w)
+		next(rec, r)
+
+		// Reads by nodes are not changes
+		if r.Method == http.MethodGet {
+			return
+		}
+
+		entry := AuditEntry{
+			Actor:      requestAuthor(r),
+			Action:     action,
+			Target:     r.URL.Path,
+			Params:     mux.Vars(r),
+			Status:     rec.Status,
+			RemoteAddr: r.RemoteAddr,
+			CreatedAt:  time.Now().UTC(),
+		}
+		logger.Info("Config API change",
+			zap.String("actor", entry.Actor), zap.String("action", action),
+			zap.String("target", entry.Target), zap.Int("status", entry.Status))
+		writeAudit(entry)
+	}
+}
+
+// writeAudit stores an audit entry in Postgres, when configured
+func writeAudit(entry AuditEntry) {
+	if historyDB == nil {
+		return
+	}
+	params, _ := json.Marshal(entry.Params)
+	_, err := historyDB.Exec(`
+		INSERT INTO network_config_audit (actor, action, target, params, status, remote_addr, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, entry.Actor, entry.Action, entry.Target, params, entry.Status, entry.RemoteAddr, entry.CreatedAt)
+	if err != nil {
+		logger.Error("Failed to write audit entry", zap.Error(err))
+	}
+}
+
+// getAuditLog returns audit entries, newest first (?limit=&offset=&actor=)
+func getAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !historyEnabled(w) {
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	rows, err := historyDB.Query(`
+		SELECT id, actor, action, target, params, status, remote_addr, created_at
+		FROM network_config_audit
+		WHERE $1 = '' OR actor = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, r.URL.Query().Get("actor"), limit, offset)
+	if err != nil {
+		logger.Error("Failed to load audit log", zap.Error(err))
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var params []byte
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &params, &e.Status, &e.RemoteAddr, &e.CreatedAt); err != nil {
+			logger.Error("Failed to scan audit entry", zap.Error(err))
+			http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+			return
+		}
+		json.Unmarshal(params, &e.Params)
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries, "limit": limit, "offset": offset})
+}
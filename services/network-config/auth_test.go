@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/ipfilter"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "miniredis: %v\n", err)
+		os.Exit(1)
+	}
+	logger = zap.NewNop()
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	jwtSecret = []byte("test-secret")
+	adminIPs, err = ipfilter.New("network-config", redisClient, ipfilter.Rules{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipfilter: %v\n", err)
+		os.Exit(1)
+	}
+	loadConfig()
+
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+// token signs claims like auth-service does
+func token(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func call(h http.HandlerFunc, method, path, bearer, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// muxRouter serves h at pattern, so mux.Vars are set
+func muxRouter(pattern string, h http.HandlerFunc) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, h)
+	return router
+}
+
+func TestParseToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		bearer string
+		admin  bool
+		valid  bool
+	}{
+		{"auth-service role", token(t, jwt.MapClaims{"user_id": "u1", "role": "admin"}), true, true},
+		{"roles list", token(t, jwt.MapClaims{"user_id": "n1", "roles": []string{"node", "admin"}}), true, true},
+		{"user", token(t, jwt.MapClaims{"user_id": "u2", "role": "user"}), false, true},
+		{"no user", token(t, jwt.MapClaims{"role": "admin"}), false, false},
+		{"expired", token(t, jwt.MapClaims{"user_id": "u1", "role": "admin", "exp": time.Now().Add(-time.Minute).Unix()}), false, false},
+		{"other secret", func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": "u1", "role": "admin"}).SignedString([]byte("other"))
+			return s
+		}(), false, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		claims, err := parseToken(req)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: err = %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+		if err == nil && claims.hasRole(roleAdmin) != tt.admin {
+			t.Errorf("%s: admin = %v, want %v", tt.name, claims.hasRole(roleAdmin), tt.admin)
+		}
+	}
+}
+
+func TestAdminGating(t *testing.T) {
+	admin := token(t, jwt.MapClaims{"user_id": "admin-1", "role": "admin"})
+	user := token(t, jwt.MapClaims{"user_id": "user-1", "role": "user"})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	for bearer, want := range map[string]int{admin: http.StatusNoContent, user: http.StatusForbidden, "": http.StatusUnauthorized} {
+		if rec := call(requireAdmin("test", ok), http.MethodPost, "/api/config/rollback/1", bearer, ""); rec.Code != want {
+			t.Errorf("requireAdmin: %d, want %d", rec.Code, want)
+		}
+	}
+
+	// A node may only read its own peer
+	node := token(t, jwt.MapClaims{"user_id": "tail-1", "role": "node"})
+	peer := func(id string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/wireguard/peers/"+id+"/config", nil)
+		req.Header.Set("Authorization", "Bearer "+node)
+		router := muxRouter("/api/wireguard/peers/{id}/config", requirePeerAccess("test", ok))
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := peer("tail-1"); code != http.StatusNoContent {
+		t.Errorf("node reading its own peer: %d", code)
+	}
+	if code := peer("tail-2"); code != http.StatusForbidden {
+		t.Errorf("node reading another peer: %d, want 403", code)
+	}
+}
+
+func TestGetConfigMasksSecurityToken(t *testing.T) {
+	for role, wantMasked := range map[string]bool{"admin": false, "user": true, "node": true} {
+		rec := call(requireToken(getConfig), http.MethodGet, "/api/config", token(t, jwt.MapClaims{"user_id": "u", "role": role}), "")
+		var cfg NetworkConfig
+		if err := json.NewDecoder(rec.Body).Decode(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if masked := cfg.SecurityToken == "***"; masked != wantMasked {
+			t.Errorf("role %s: security_token %q", role, cfg.SecurityToken)
+		}
+	}
+}
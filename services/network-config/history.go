@@ -0,0 +1,405 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+var historyDB *sql.DB
+
+var errVersionNotFound = errors.New("config version not found")
+
+// Fields whose values are masked in history and diff responses
+var secretConfigFields = map[string]bool{
+	"security_token": true,
+}
+
+// ConfigVersion is a saved configuration with the change against the previous version
+type ConfigVersion struct {
+	Version   int            `json:"version"`
+	Config    NetworkConfig  `json:"config"`
+	Diff      []ConfigChange `json:"diff"`
+	Author    string         `json:"author"`
+	Comment   string         `json:"comment,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// ConfigChange is a changed field, addressed by its dotted JSON path
+type ConfigChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// initHistory connects to Postgres (DB_HOST, DB_USER, DB_PASSWORD, DB_NAME,
+// DB_PORT) and creates the versions table. History is disabled without DB_HOST.
+func initHistory() error {
+	if os.Getenv("DB_HOST") == "" {
+		logger.Warn("DB_HOST is not set, config history is disabled")
+		return nil
+	}
+
+	dsn := fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_NAME"),
+		os.Getenv("DB_PORT"),
+	)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS network_config_versions (
+			version SERIAL PRIMARY KEY,
+			config JSONB NOT NULL,
+			diff JSONB NOT NULL,
+			author TEXT NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create history table: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS network_config_audit (
+			id BIGSERIAL PRIMARY KEY,
+			actor TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			params JSONB NOT NULL DEFAULT '{}',
+			status INTEGER NOT NULL,
+			remote_addr TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create audit table: %w", err)
+	}
+	historyDB = db
+
+	// The config that is live before history existed becomes version 1
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM network_config_versions`).Scan(&count); err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	if count == 0 {
+		configMutex.RLock()
+		initial := currentConfig
+		configMutex.RUnlock()
+		if _, err := recordVersion(initial, "system", "initial configuration"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordVersion stores cfg as a new version with the diff against the latest one
+func recordVersion(cfg NetworkConfig, author, comment string) (*ConfigVersion, error) {
+	if historyDB == nil {
+		return nil, nil
+	}
+
+	tx, err := historyDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// FOR UPDATE serializes concurrent saves so every diff is against its predecessor
+	var previous *NetworkConfig
+	var raw []byte
+	err = tx.QueryRow(`SELECT config FROM network_config_versions ORDER BY version DESC LIMIT 1 FOR UPDATE`).Scan(&raw)
+	if err == nil {
+		previous = &NetworkConfig{}
+		if err := json.Unmarshal(raw, previous); err != nil {
+			return nil, err
+		}
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	version := &ConfigVersion{
+		Config:  cfg,
+		Diff:    diffConfigs(previous, &cfg),
+		Author:  author,
+		Comment: comment,
+	}
+	configJSON, _ := json.Marshal(cfg)
+	diffJSON, _ := json.Marshal(version.Diff)
+
+	err = tx.QueryRow(`
+		INSERT INTO network_config_versions (config, diff, author, comment)
+		VALUES ($1, $2, $3, $4)
+		RETURNING version, created_at
+	`, configJSON, diffJSON, author, comment).Scan(&version.Version, &version.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return version, tx.Commit()
+}
+
+func loadVersion(version int) (*ConfigVersion, error) {
+	var v ConfigVersion
+	var configJSON, diffJSON []byte
+	err := historyDB.QueryRow(`
+		SELECT version, config, diff, author, comment, created_at
+		FROM network_config_versions WHERE version = $1
+	`, version).Scan(&v.Version, &configJSON, &diffJSON, &v.Author, &v.Comment, &v.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errVersionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(configJSON, &v.Config); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(diffJSON, &v.Diff); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// diffConfigs compares two configs field by field; previous may be nil
+func diffConfigs(previous, next *NetworkConfig) []ConfigChange {
+	oldFields := map[string]interface{}{}
+	if previous != nil {
+		oldFields = flattenConfig(previous)
+	}
+	newFields := flattenConfig(next)
+
+	paths := make(map[string]bool)
+	for path := range oldFields {
+		paths[path] = true
+	}
+	for path := range newFields {
+		paths[path] = true
+	}
+
+	changes := []ConfigChange{}
+	for path := range paths {
+		if !reflect.DeepEqual(oldFields[path], newFields[path]) {
+			changes = append(changes, ConfigChange{Path: path, Old: oldFields[path], New: newFields[path]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// flattenConfig maps dotted JSON paths to leaf values; arrays are compared whole
+func flattenConfig(cfg *NetworkConfig) map[string]interface{} {
+	raw, _ := json.Marshal(cfg)
+	var tree map[string]interface{}
+	json.Unmarshal(raw, &tree)
+
+	fields := make(map[string]interface{})
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		if obj, ok := value.(map[string]interface{}); ok {
+			for key, child := range obj {
+				path := key
+				if prefix != "" {
+					path = prefix + "." + key
+				}
+				walk(path, child)
+			}
+			return
+		}
+		fields[prefix] = value
+	}
+	walk("", tree)
+	return fields
+}
+
+// maskVersion hides secret values before a version is returned over the API
+func maskVersion(v *ConfigVersion) {
+	v.Config.SecurityToken = "***"
+	for i := range v.Diff {
+		maskChange(&v.Diff[i])
+	}
+}
+
+func maskChange(c *ConfigChange) {
+	if secretConfigFields[c.Path] {
+		if c.Old != nil {
+			c.Old = "***"
+		}
+		if c.New != nil {
+			c.New = "***"
+		}
+	}
+}
+
+// requestAuthor is the user ID of the request's token
+func requestAuthor(r *http.Request) string {
+	if claims := claimsFromRequest(r); claims != nil {
+		return claims.UserID
+	}
+	return "unknown"
+}
+
+func writeVersionError(w http.ResponseWriter, err error) {
+	if err == errVersionNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Error("Failed to load config version", zap.Error(err))
+	http.Error(w, "Failed to load version", http.StatusInternalServerError)
+}
+
+func historyEnabled(w http.ResponseWriter) bool {
+	if historyDB == nil {
+		http.Error(w, "Config history is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// getConfigHistory returns saved versions, newest first (?limit=&offset=)
+func getConfigHistory(w http.ResponseWriter, r *http.Request) {
+	if !historyEnabled(w) {
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	var total int
+	if err := historyDB.QueryRow(`SELECT COUNT(*) FROM network_config_versions`).Scan(&total); err != nil {
+		logger.Error("Failed to count config versions", zap.Error(err))
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := historyDB.Query(`
+		SELECT version, config, diff, author, comment, created_at
+		FROM network_config_versions
+		ORDER BY version DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		logger.Error("Failed to load config history", zap.Error(err))
+		http.Error(w, "Failed to load history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	versions := []ConfigVersion{}
+	for rows.Next() {
+		var v ConfigVersion
+		var configJSON, diffJSON []byte
+		if err := rows.Scan(&v.Version, &configJSON, &diffJSON, &v.Author, &v.Comment, &v.CreatedAt); err != nil {
+			logger.Error("Failed to scan config version", zap.Error(err))
+			http.Error(w, "Failed to load history", http.StatusInternalServerError)
+			return
+		}
+		json.Unmarshal(configJSON, &v.Config)
+		json.Unmarshal(diffJSON, &v.Diff)
+		maskVersion(&v)
+		versions = append(versions, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"versions": versions,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// getConfigDiff returns the changes between two versions (?from=&to=)
+func getConfigDiff(w http.ResponseWriter, r *http.Request) {
+	if !historyEnabled(w) {
+		return
+	}
+
+	from, errFrom := strconv.Atoi(r.URL.Query().Get("from"))
+	to, errTo := strconv.Atoi(r.URL.Query().Get("to"))
+	if errFrom != nil || errTo != nil {
+		http.Error(w, "from and to must be version numbers", http.StatusBadRequest)
+		return
+	}
+
+	fromVersion, err := loadVersion(from)
+	if err != nil {
+		writeVersionError(w, err)
+		return
+	}
+	toVersion, err := loadVersion(to)
+	if err != nil {
+		writeVersionError(w, err)
+		return
+	}
+
+	changes := diffConfigs(&fromVersion.Config, &toVersion.Config)
+	for i := range changes {
+		maskChange(&changes[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"from": from, "to": to, "diff": changes})
+}
+
+// rollbackConfig makes a previous version current; the rollback is saved as a new version
+func rollbackConfig(w http.ResponseWriter, r *http.Request) {
+	if !historyEnabled(w) {
+		return
+	}
+
+	target, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	v, err := loadVersion(target)
+	if err != nil {
+		writeVersionError(w, err)
+		return
+	}
+	// An old version may point at heads that no longer exist
+	if errs := validateConfig(v.Config, r.URL.Query().Get("skip_connectivity") != "true"); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	comment := fmt.Sprintf("rollback to version %d", target)
+	if needsApproval(v.Config) {
+		submitForApproval(w, r, v.Config, comment)
+		return
+	}
+
+	version, err := applyConfig(v.Config, requestAuthor(r), comment)
+	if err != nil {
+		logger.Error("Failed to roll back config", zap.Error(err))
+		http.Error(w, "Failed to save config", http.StatusInternalServerError)
+		return
+	}
+
+	maskVersion(version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
@@ -17,6 +17,10 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
+
+	"github.com/MaksimVF/ZB/pkg/deadline"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
 )
 
 var (
@@ -26,6 +30,9 @@ var (
 	currentConfig NetworkConfig
 )
 
+// configChangedChannel notifies NetworkConfigManager instances that the config was saved
+const configChangedChannel = "network_config:changed"
+
 // NetworkConfig represents the network configuration structure
 type NetworkConfig struct {
 	HeadEndpoint    string            `json:"head_endpoint"`
@@ -36,6 +43,8 @@ type NetworkConfig struct {
 	RetryPolicy    RetryPolicy       `json:"retry_policy"`
 	RateLimits     RateLimits        `json:"rate_limits"`
 	LoadBalancing LoadBalancingConfig `json:"load_balancing"`
+	// Timeouts of routes and models; the default policy while unset
+	Timeouts deadline.Policy `json:"timeouts,omitempty"`
 }
 
 type RetryPolicy struct {
@@ -54,7 +63,9 @@ type LoadBalancingConfig struct {
 	HeadEndpoints []string `json:"head_endpoints,omitempty"`
 }
 
-func init() {
+// setup connects Redis and history storage and loads the config. It runs in
+// main rather than init, so tests of the package do not need them.
+func setup() {
 	// Initialize logger
 	var err error
 	logger, err = zap.NewProduction()
@@ -69,18 +80,57 @@ func init() {
 
 	// Load initial config
 	loadConfig()
+
+	// All API calls except /health require a JWT
+	initAuth()
+
+	// Admin calls are only accepted from allowed IPs
+	initAdminIPs(context.Background())
+
+	// Connect config history storage
+	if err := initHistory(); err != nil {
+		logger.Fatal("Failed to initialize config history", zap.Error(err))
+	}
 }
 
 func main() {
+	setup()
 	router := mux.NewRouter()
 
-	// API endpoints
-	router.HandleFunc("/api/config", getConfig).Methods("GET")
-	router.HandleFunc("/api/config", updateConfig).Methods("PUT")
-	router.HandleFunc("/api/config/history", getConfigHistory).Methods("GET")
+	// API endpoints: reads need a valid token, changes need the admin role
+	router.HandleFunc("/api/config", requireToken(getConfig)).Methods("GET")
+	router.HandleFunc("/api/config", requireAdmin("config.update", updateConfig)).Methods("PUT")
+	router.HandleFunc("/api/config/validate", requireToken(validateConfigHandler)).Methods("POST")
+	router.HandleFunc("/api/config/history", requireToken(getConfigHistory)).Methods("GET")
+	router.HandleFunc("/api/config/diff", requireToken(getConfigDiff)).Methods("GET")
+	router.HandleFunc("/api/config/rollback/{version}", requireAdmin("config.rollback", rollbackConfig)).Methods("POST")
+	router.HandleFunc("/api/config/changes", requireAdmin("config.changes.list", getPendingChanges)).Methods("GET")
+	router.HandleFunc("/api/config/changes/{id}/approve", requireAdmin("config.change.approve", approveChange)).Methods("POST")
+	router.HandleFunc("/api/config/changes/{id}/reject", requireAdmin("config.change.reject", rejectChange)).Methods("POST")
+	router.HandleFunc("/api/audit", requireAdmin("audit.list", getAuditLog)).Methods("GET")
+
+	// WireGuard mesh; nodes may read their own config and report their own status
+	router.HandleFunc("/api/wireguard/peers", requireToken(getPeers)).Methods("GET")
+	router.HandleFunc("/api/wireguard/peers", requireAdmin("wireguard.peer.register", registerPeer)).Methods("POST")
+	router.HandleFunc("/api/wireguard/peers/{id}", requireToken(getPeer)).Methods("GET")
+	router.HandleFunc("/api/wireguard/peers/{id}", requireAdmin("wireguard.peer.delete", deletePeer)).Methods("DELETE")
+	router.HandleFunc("/api/wireguard/peers/{id}/config", requirePeerAccess("wireguard.peer.config", getPeerConfig)).Methods("GET")
+	router.HandleFunc("/api/wireguard/peers/{id}/rotate", requireAdmin("wireguard.peer.rotate", rotatePeerKey)).Methods("POST")
+	router.HandleFunc("/api/wireguard/peers/{id}/status", requireToken(getPeerStatus)).Methods("GET")
+	router.HandleFunc("/api/wireguard/peers/{id}/status", requirePeerAccess("wireguard.peer.status", reportPeerStatus)).Methods("POST")
+
+	// pprof, expvar and /debug/runtime with DEBUG_ENDPOINTS=true
+	router.PathPrefix(diagnostics.Prefix).Handler(diagnostics.Handler(func(next http.Handler) http.Handler {
+		return requireAdmin("debug.read", next.ServeHTTP)
+	}))
 
 	// Health check
 	router.HandleFunc("/health", healthCheck).Methods("GET")
+	checker := health.New("network-config").Add("redis", func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	})
+	router.Handle("/livez", checker.LiveHandler()).Methods("GET")
+	router.Handle("/readyz", checker.ReadyHandler()).Methods("GET")
 
 	srv := &http.Server{
 		Addr:    ":50060",
@@ -135,6 +185,7 @@ func loadConfig() {
 			LoadBalancing: LoadBalancingConfig{
 				Mode: "single",
 			},
+			Timeouts: deadline.DefaultPolicy(),
 		}
 		return
 	} else if err != nil {
@@ -159,7 +210,15 @@ func saveConfig(cfg NetworkConfig) error {
 	}
 
 	ctx := context.Background()
-	return redisClient.Set(ctx, "network_config", data, 0).Err()
+	if err := redisClient.Set(ctx, "network_config", data, 0).Err(); err != nil {
+		return err
+	}
+
+	// Notify head and tail instances so they reload without waiting for the poll
+	if err := redisClient.Publish(ctx, configChangedChannel, time.Now().Unix()).Err(); err != nil {
+		logger.Warn("Failed to publish config change", zap.Error(err))
+	}
+	return nil
 }
 
 // autoReloadConfig periodically checks for config updates
@@ -172,13 +231,18 @@ func autoReloadConfig() {
 	}
 }
 
-// getConfig returns the current configuration
+// getConfig returns the current configuration; the security token only to
+// admins, as in history and pending changes
 func getConfig(w http.ResponseWriter, r *http.Request) {
 	configMutex.RLock()
-	defer configMutex.RUnlock()
+	cfg := currentConfig
+	configMutex.RUnlock()
 
+	if claims := claimsFromRequest(r); claims == nil || !claims.hasRole(roleAdmin) {
+		cfg.SecurityToken = "***"
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(currentConfig)
+	json.NewEncoder(w).Encode(cfg)
 }
 
 // updateConfig updates the configuration
@@ -190,14 +254,21 @@ func updateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields
-	if newConfig.HeadEndpoint == "" || newConfig.NetworkMode == "" || newConfig.SecurityToken == "" {
-		http.Error(w, "Missing required fields", http.StatusBadRequest)
+	// Validate the schema and make sure heads stay reachable
+	if errs := validateConfig(newConfig, r.URL.Query().Get("skip_connectivity") != "true"); len(errs) > 0 {
+		writeValidationErrors(w, errs)
 		return
 	}
 
-	err = saveConfig(newConfig)
-	if err != nil {
+	// Moving traffic to another head needs a second admin
+	comment := r.URL.Query().Get("comment")
+	if needsApproval(newConfig) {
+		submitForApproval(w, r, newConfig, comment)
+		return
+	}
+
+	if _, err := applyConfig(newConfig, requestAuthor(r), comment); err != nil {
+		logger.Error("Failed to apply config", zap.Error(err))
 		http.Error(w, "Failed to save config", http.StatusInternalServerError)
 		return
 	}
@@ -217,11 +288,3 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
-
-// getConfigHistory returns the configuration history (stub for now)
-func getConfigHistory(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement config history from database
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode([]NetworkConfig{currentConfig})
-}
-
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	networkModes       = []string{"direct", "wireguard", "zerotier"}
+	loadBalancingModes = []string{"single", "round_robin", "least_loaded"}
+	endpointSchemes    = []string{"grpc", "grpcs"}
+)
+
+// Bounds for numeric settings
+const (
+	maxRetries            = 10
+	maxBackoffMs          = 60000
+	maxRequestsPerUser    = 1000000
+	maxRequestsPerIP      = 10000000
+	maxRateWindowSeconds  = 86400
+	minSecurityTokenBytes = 8
+)
+
+// connectivityTimeout bounds the reachability check of head endpoints
+const connectivityTimeout = 2 * time.Second
+
+// ValidationError is a problem with one config field
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validateConfig checks the config schema. With checkConnectivity the head
+// endpoints must also accept TCP connections (direct mode only, since other
+// modes reach heads through a tunnel that may not exist yet).
+func validateConfig(cfg NetworkConfig, checkConnectivity bool) []ValidationError {
+	errs := []ValidationError{}
+	add := func(field, code, format string, args ...interface{}) {
+		errs = append(errs, ValidationError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.HeadEndpoint == "" {
+		add("head_endpoint", "required", "head_endpoint is required")
+	} else if err := validateEndpoint(cfg.HeadEndpoint); err != nil {
+		add("head_endpoint", "invalid_format", "%v", err)
+	}
+
+	if !oneOf(cfg.NetworkMode, networkModes) {
+		add("network_mode", "invalid_enum", "network_mode must be one of %s", strings.Join(networkModes, ", "))
+	}
+
+	// WireGuard peers are required in wireguard mode and validated whenever set
+	if cfg.NetworkMode == "wireguard" && cfg.WGPeerPublic == "" {
+		add("wg_peer_public", "required", "wg_peer_public is required in wireguard mode")
+	} else if cfg.WGPeerPublic != "" && !validWireGuardKey(cfg.WGPeerPublic) {
+		add("wg_peer_public", "invalid_format", "wg_peer_public must be a base64-encoded 32-byte key")
+	}
+	if cfg.NetworkMode == "wireguard" && cfg.WGAllowedIPs == "" {
+		add("wg_allowed_ips", "required", "wg_allowed_ips is required in wireguard mode")
+	} else if cfg.WGAllowedIPs != "" {
+		for _, cidr := range strings.Split(cfg.WGAllowedIPs, ",") {
+			if _, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err != nil {
+				add("wg_allowed_ips", "invalid_format", "%q is not a CIDR", strings.TrimSpace(cidr))
+			}
+		}
+	}
+
+	if cfg.SecurityToken == "" {
+		add("security_token", "required", "security_token is required")
+	} else if len(cfg.SecurityToken) < minSecurityTokenBytes || strings.ContainsAny(cfg.SecurityToken, " \t\r\n") {
+		add("security_token", "invalid_format", "security_token must be at least %d characters without whitespace", minSecurityTokenBytes)
+	}
+
+	checkRange := func(field string, value, min, max int) {
+		if value < min || value > max {
+			add(field, "out_of_range", "%s must be between %d and %d", field, min, max)
+		}
+	}
+	checkRange("retry_policy.retries", cfg.RetryPolicy.Retries, 0, maxRetries)
+	checkRange("retry_policy.backoff_ms", cfg.RetryPolicy.BackoffMs, 0, maxBackoffMs)
+	checkRange("rate_limits.max_requests_per_user", cfg.RateLimits.MaxRequestsPerUser, 1, maxRequestsPerUser)
+	checkRange("rate_limits.max_requests_per_ip", cfg.RateLimits.MaxRequestsPerIP, 1, maxRequestsPerIP)
+	checkRange("rate_limits.window_seconds", cfg.RateLimits.WindowSeconds, 1, maxRateWindowSeconds)
+
+	if !oneOf(cfg.LoadBalancing.Mode, loadBalancingModes) {
+		add("load_balancing.mode", "invalid_enum", "load_balancing.mode must be one of %s", strings.Join(loadBalancingModes, ", "))
+	} else if cfg.LoadBalancing.Mode != "single" && len(cfg.LoadBalancing.HeadEndpoints) == 0 {
+		add("load_balancing.head_endpoints", "required", "head_endpoints are required for load_balancing mode %s", cfg.LoadBalancing.Mode)
+	}
+	seen := make(map[string]bool)
+	for i, endpoint := range cfg.LoadBalancing.HeadEndpoints {
+		field := fmt.Sprintf("load_balancing.head_endpoints[%d]", i)
+		if err := validateEndpoint(endpoint); err != nil {
+			add(field, "invalid_format", "%v", err)
+		} else if seen[endpoint] {
+			add(field, "duplicate", "%s is listed more than once", endpoint)
+		}
+		seen[endpoint] = true
+	}
+
+	// Unset timeouts mean the default policy
+	if cfg.Timeouts.DefaultMs != 0 || len(cfg.Timeouts.Routes) > 0 || len(cfg.Timeouts.Models) > 0 {
+		if err := cfg.Timeouts.Validate(); err != nil {
+			add("timeouts", "out_of_range", "%v", err)
+		}
+	}
+
+	// Only probe endpoints once the schema is valid
+	if checkConnectivity && len(errs) == 0 && cfg.NetworkMode == "direct" {
+		targets := []string{cfg.HeadEndpoint}
+		if cfg.LoadBalancing.Mode != "single" {
+			targets = cfg.LoadBalancing.HeadEndpoints
+		}
+		reachable := 0
+		for _, endpoint := range targets {
+			if probeEndpoint(endpoint) == nil {
+				reachable++
+			}
+		}
+		if reachable == 0 {
+			field := "head_endpoint"
+			if cfg.LoadBalancing.Mode != "single" {
+				field = "load_balancing.head_endpoints"
+			}
+			add(field, "unreachable", "no head endpoint accepts connections; the config would cut tails off from heads")
+		}
+	}
+
+	return errs
+}
+
+// validateEndpoint accepts host:port or grpc[s]://host:port
+func validateEndpoint(endpoint string) error {
+	hostPort := endpoint
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid URL", endpoint)
+		}
+		if !oneOf(u.Scheme, endpointSchemes) {
+			return fmt.Errorf("%q: scheme must be one of %s", endpoint, strings.Join(endpointSchemes, ", "))
+		}
+		if u.Path != "" && u.Path != "/" {
+			return fmt.Errorf("%q must not have a path", endpoint)
+		}
+		hostPort = u.Host
+	}
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil || host == "" {
+		return fmt.Errorf("%q must be host:port", endpoint)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q has an invalid port", endpoint)
+	}
+	return nil
+}
+
+// probeEndpoint opens a TCP connection to the endpoint
+func probeEndpoint(endpoint string) error {
+	hostPort := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		hostPort = u.Host
+	}
+	conn, err := net.DialTimeout("tcp", hostPort, connectivityTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func validWireGuardKey(key string) bool {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(raw) == 32
+}
+
+func oneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// writeValidationErrors responds with 422 and the structured errors
+func writeValidationErrors(w http.ResponseWriter, errs []ValidationError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{"valid": false, "errors": errs})
+}
+
+// validateConfigHandler is a dry run of PUT /api/config: the config is
+// validated (including connectivity unless ?skip_connectivity=true) but not saved
+func validateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var cfg NetworkConfig
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	errs := validateConfig(cfg, r.URL.Query().Get("skip_connectivity") != "true")
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	configMutex.RLock()
+	current := currentConfig
+	configMutex.RUnlock()
+	changes := diffConfigs(&current, &cfg)
+	for i := range changes {
+		maskChange(&changes[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"valid": true, "errors": errs, "diff": changes})
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// Redis keys: peers are stored as JSON, private keys separately so they never
+// leak through peer listings
+const (
+	wgPeerKeyPrefix    = "wg:peer:"
+	wgPeersKey         = "wg:peers"
+	wgAddressesKey     = "wg:addresses"
+	wgChangedChannel   = "wireguard:changed"
+	wgStatusTTL        = 5 * time.Minute
+	wgDefaultPort      = 51820
+	wgKeepaliveSeconds = 25
+	// A peer without a handshake for this long is reported as disconnected
+	wgHandshakeTimeout = 3 * time.Minute
+)
+
+var errPeerNotFound = errors.New("peer not found")
+
+var peerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// wgSubnet is the tunnel address range (WG_SUBNET, default 10.10.0.0/24)
+var wgSubnet = func() *net.IPNet {
+	subnet := os.Getenv("WG_SUBNET")
+	if subnet == "" {
+		subnet = "10.10.0.0/24"
+	}
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ipNet.IP.To4() == nil {
+		panic(fmt.Sprintf("invalid WG_SUBNET %q", subnet))
+	}
+	return ipNet
+}()
+
+// WireGuardPeer is a head or tail node of the mesh
+type WireGuardPeer struct {
+	ID         string    `json:"id"`
+	Role       string    `json:"role"`
+	PublicKey  string    `json:"public_key"`
+	Address    string    `json:"address"`
+	Endpoint   string    `json:"endpoint,omitempty"`
+	ListenPort int       `json:"listen_port"`
+	CreatedAt  time.Time `json:"created_at"`
+	RotatedAt  time.Time `json:"rotated_at"`
+}
+
+// PeerStatus is what a node reports about one of its peers (from `wg show dump`)
+type PeerStatus struct {
+	PublicKey       string `json:"public_key"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	LatestHandshake int64  `json:"latest_handshake"`
+	TransferRx      int64  `json:"transfer_rx"`
+	TransferTx      int64  `json:"transfer_tx"`
+	PeerID          string `json:"peer_id,omitempty"`
+	Connected       bool   `json:"connected"`
+}
+
+// NodeStatus is the last status report of a node
+type NodeStatus struct {
+	PeerID     string       `json:"peer_id"`
+	ReportedAt time.Time    `json:"reported_at"`
+	Peers      []PeerStatus `json:"peers"`
+}
+
+func wgPeerKey(id string) string       { return wgPeerKeyPrefix + id }
+func wgPrivateKeyKey(id string) string { return wgPeerKeyPrefix + id + ":private" }
+func wgStatusKey(id string) string     { return wgPeerKeyPrefix + id + ":status" }
+
+// generateKeyPair returns a base64 WireGuard (X25519) private and public key
+func generateKeyPair() (string, string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()),
+		base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// allocateAddress reserves the lowest free tunnel address in wgSubnet
+func allocateAddress(ctx context.Context) (string, error) {
+	base := binary.BigEndian.Uint32(wgSubnet.IP.To4())
+	ones, bits := wgSubnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+
+	// Skip the network and broadcast addresses
+	for offset := uint32(1); offset < size-1; offset++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+offset)
+		added, err := redisClient.SAdd(ctx, wgAddressesKey, ip.String()).Result()
+		if err != nil {
+			return "", err
+		}
+		if added == 1 {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no free addresses in %s", wgSubnet)
+}
+
+func loadPeer(ctx context.Context, id string) (*WireGuardPeer, error) {
+	raw, err := redisClient.Get(ctx, wgPeerKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, errPeerNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var peer WireGuardPeer
+	if err := json.Unmarshal(raw, &peer); err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+func listPeers(ctx context.Context) ([]*WireGuardPeer, error) {
+	ids, err := redisClient.SMembers(ctx, wgPeersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	peers := make([]*WireGuardPeer, 0, len(ids))
+	for _, id := range ids {
+		peer, err := loadPeer(ctx, id)
+		if err == errPeerNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// savePeer stores the peer (and its private key when set) and notifies nodes
+// so they re-render their configs
+func savePeer(ctx context.Context, peer *WireGuardPeer, privateKey string) error {
+	raw, err := json.Marshal(peer)
+	if err != nil {
+		return err
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, wgPeerKey(peer.ID), raw, 0)
+	if privateKey != "" {
+		pipe.Set(ctx, wgPrivateKeyKey(peer.ID), privateKey, 0)
+	}
+	pipe.SAdd(ctx, wgPeersKey, peer.ID)
+	pipe.Publish(ctx, wgChangedChannel, peer.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// renderPeerConfig renders a wg-quick config for the node: every other peer
+// of the mesh is added with its tunnel address as allowed IP
+func renderPeerConfig(peer *WireGuardPeer, privateKey string, peers []*WireGuardPeer) string {
+	ones, _ := wgSubnet.Mask.Size()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s (%s), generated by network-config\n", peer.ID, peer.Role)
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", privateKey)
+	fmt.Fprintf(&b, "Address = %s/%d\n", peer.Address, ones)
+	fmt.Fprintf(&b, "ListenPort = %d\n", peer.ListenPort)
+
+	for _, other := range peers {
+		if other.ID == peer.ID {
+			continue
+		}
+		fmt.Fprintf(&b, "\n# %s (%s)\n[Peer]\n", other.ID, other.Role)
+		fmt.Fprintf(&b, "PublicKey = %s\n", other.PublicKey)
+		fmt.Fprintf(&b, "AllowedIPs = %s/32\n", other.Address)
+		if other.Endpoint != "" {
+			fmt.Fprintf(&b, "Endpoint = %s\n", other.Endpoint)
+		}
+		fmt.Fprintf(&b, "PersistentKeepalive = %d\n", wgKeepaliveSeconds)
+	}
+	return b.String()
+}
+
+// syncHeadPeerKey keeps NetworkConfig.WGPeerPublic pointing at the head's
+// current key when that head's key is rotated
+func syncHeadPeerKey(oldKey, newKey, author string) {
+	configMutex.RLock()
+	cfg := currentConfig
+	configMutex.RUnlock()
+	if cfg.WGPeerPublic == "" || cfg.WGPeerPublic != oldKey {
+		return
+	}
+
+	cfg.WGPeerPublic = newKey
+	if _, err := applyConfig(cfg, author, "wireguard key rotation"); err != nil {
+		logger.Error("Failed to update wg_peer_public after key rotation", zap.Error(err))
+	}
+}
+
+func writePeerError(w http.ResponseWriter, err error) {
+	if err == errPeerNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	logger.Error("WireGuard storage error", zap.Error(err))
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+// registerPeer handles POST /api/wireguard/peers: generates a key pair and
+// allocates a tunnel address for a new head or tail node
+func registerPeer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID         string `json:"id"`
+		Role       string `json:"role"`
+		Endpoint   string `json:"endpoint"`
+		ListenPort int    `json:"listen_port"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var errs []ValidationError
+	if !peerIDPattern.MatchString(req.ID) {
+		errs = append(errs, ValidationError{Field: "id", Code: "invalid_format", Message: "id must be lowercase letters, digits and '-'"})
+	}
+	if req.Role != "head" && req.Role != "tail" {
+		errs = append(errs, ValidationError{Field: "role", Code: "invalid_enum", Message: "role must be head or tail"})
+	}
+	if req.Endpoint != "" {
+		if err := validateEndpoint(req.Endpoint); err != nil || strings.Contains(req.Endpoint, "://") {
+			errs = append(errs, ValidationError{Field: "endpoint", Code: "invalid_format", Message: "endpoint must be host:port"})
+		}
+	}
+	if req.ListenPort == 0 {
+		req.ListenPort = wgDefaultPort
+	} else if req.ListenPort < 1 || req.ListenPort > 65535 {
+		errs = append(errs, ValidationError{Field: "listen_port", Code: "out_of_range", Message: "listen_port must be between 1 and 65535"})
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	ctx := r.Context()
+	if exists, err := redisClient.Exists(ctx, wgPeerKey(req.ID)).Result(); err != nil {
+		writePeerError(w, err)
+		return
+	} else if exists > 0 {
+		http.Error(w, "Peer already exists", http.StatusConflict)
+		return
+	}
+
+	privateKey, publicKey, err := generateKeyPair()
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+	address, err := allocateAddress(ctx)
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	peer := &WireGuardPeer{
+		ID:         req.ID,
+		Role:       req.Role,
+		PublicKey:  publicKey,
+		Address:    address,
+		Endpoint:   req.Endpoint,
+		ListenPort: req.ListenPort,
+		CreatedAt:  now,
+		RotatedAt:  now,
+	}
+	if err := savePeer(ctx, peer, privateKey); err != nil {
+		redisClient.SRem(ctx, wgAddressesKey, address)
+		writePeerError(w, err)
+		return
+	}
+	logger.Info("WireGuard peer registered", zap.String("peer", peer.ID), zap.String("address", address))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(peer)
+}
+
+// getPeers handles GET /api/wireguard/peers
+func getPeers(w http.ResponseWriter, r *http.Request) {
+	peers, err := listPeers(r.Context())
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+// getPeer handles GET /api/wireguard/peers/{id}
+func getPeer(w http.ResponseWriter, r *http.Request) {
+	peer, err := loadPeer(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peer)
+}
+
+// deletePeer handles DELETE /api/wireguard/peers/{id} and releases its address
+func deletePeer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	peer, err := loadPeer(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, wgPeerKey(peer.ID), wgPrivateKeyKey(peer.ID), wgStatusKey(peer.ID))
+	pipe.SRem(ctx, wgPeersKey, peer.ID)
+	pipe.SRem(ctx, wgAddressesKey, peer.Address)
+	pipe.Publish(ctx, wgChangedChannel, peer.ID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPeerConfig handles GET /api/wireguard/peers/{id}/config and returns the
+// node's wg-quick config, including its private key
+func getPeerConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	peer, err := loadPeer(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+	privateKey, err := redisClient.Get(ctx, wgPrivateKeyKey(peer.ID)).Result()
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+	peers, err := listPeers(ctx)
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, renderPeerConfig(peer, privateKey, peers))
+}
+
+// rotatePeerKey handles POST /api/wireguard/peers/{id}/rotate. Other nodes
+// pick up the new public key when they re-render their configs.
+func rotatePeerKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	peer, err := loadPeer(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	privateKey, publicKey, err := generateKeyPair()
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+	oldKey := peer.PublicKey
+	peer.PublicKey = publicKey
+	peer.RotatedAt = time.Now().UTC()
+	if err := savePeer(ctx, peer, privateKey); err != nil {
+		writePeerError(w, err)
+		return
+	}
+	logger.Info("WireGuard key rotated", zap.String("peer", peer.ID))
+
+	if peer.Role == "head" {
+		syncHeadPeerKey(oldKey, publicKey, requestAuthor(r))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peer)
+}
+
+// reportPeerStatus handles POST /api/wireguard/peers/{id}/status, sent by the
+// node with the per-peer handshake and transfer counters from `wg show`
+func reportPeerStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	peer, err := loadPeer(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	var status NodeStatus
+	if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	status.PeerID = peer.ID
+	status.ReportedAt = time.Now().UTC()
+
+	raw, _ := json.Marshal(status)
+	if err := redisClient.Set(ctx, wgStatusKey(peer.ID), raw, wgStatusTTL).Err(); err != nil {
+		writePeerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPeerStatus handles GET /api/wireguard/peers/{id}/status: the node's last
+// report with peers resolved to IDs and a connected flag
+func getPeerStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	peer, err := loadPeer(ctx, mux.Vars(r)["id"])
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	raw, err := redisClient.Get(ctx, wgStatusKey(peer.ID)).Bytes()
+	if err == redis.Nil {
+		http.Error(w, "No status reported in the last 5 minutes", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	var status NodeStatus
+	if err := json.Unmarshal(raw, &status); err != nil {
+		writePeerError(w, err)
+		return
+	}
+
+	peers, err := listPeers(ctx)
+	if err != nil {
+		writePeerError(w, err)
+		return
+	}
+	byKey := make(map[string]string, len(peers))
+	for _, p := range peers {
+		byKey[p.PublicKey] = p.ID
+	}
+	for i := range status.Peers {
+		s := &status.Peers[i]
+		s.PeerID = byKey[s.PublicKey]
+		s.Connected = s.LatestHandshake > 0 && time.Since(time.Unix(s.LatestHandshake, 0)) < wgHandshakeTimeout
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
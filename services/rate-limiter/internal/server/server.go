@@ -7,13 +7,16 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net"
 	"strings"
 	"time"
-
-	pb "llm-gateway-pro/services/rate-limiter/pb"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
+	pb "github.com/MaksimVF/ZB/services/rate-limiter/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type RateLimiterServer struct {
@@ -30,6 +33,12 @@ func NewRateLimiterServer() *RateLimiterServer {
 }
 
 func (s *RateLimiterServer) Run() error {
+	shutdownTracing, err := tracing.Init(context.Background(), "rate-limiter")
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
 	lis, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		return err
@@ -39,13 +48,13 @@ func (s *RateLimiterServer) Run() error {
 	creds, err := credentials.NewServerTLSFromFile("/certs/rate-limiter.pem", "/certs/rate-limiter-key.pem")
 	if err != nil {
 		log.Printf("Failed to load TLS credentials: %v. Running without TLS.", err)
-		grpcServer := grpc.NewServer()
+		grpcServer := grpc.NewServer(tracing.ServerOption(), requestid.ServerOption())
 		pb.RegisterRateLimiterServer(grpcServer, s)
 		log.Println("Rate limiter service running on :50051 (no TLS)")
 		return grpcServer.Serve(lis)
 	}
 
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
+	grpcServer := grpc.NewServer(grpc.Creds(creds), tracing.ServerOption(), requestid.ServerOption())
 	pb.RegisterRateLimiterServer(grpcServer, s)
 
 	log.Println("Rate limiter service running on :50051 (TLS enabled)")
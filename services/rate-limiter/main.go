@@ -8,7 +8,7 @@ package main
 
 import (
 	"log"
-	"llm-gateway-pro/services/rate-limiter/internal/server"
+	"github.com/MaksimVF/ZB/services/rate-limiter/internal/server"
 )
 
 func main() {
@@ -0,0 +1,786 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.21.12
+// source: rate_limiter.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type CheckRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Authorization string                 `protobuf:"bytes,1,opt,name=authorization,proto3" json:"authorization,omitempty"`
+	Path          string                 `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckRequest) Reset() {
+	*x = CheckRequest{}
+	mi := &file_rate_limiter_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckRequest) ProtoMessage() {}
+
+func (x *CheckRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckRequest.ProtoReflect.Descriptor instead.
+func (*CheckRequest) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CheckRequest) GetAuthorization() string {
+	if x != nil {
+		return x.Authorization
+	}
+	return ""
+}
+
+func (x *CheckRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+type CheckResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Allowed        bool                   `protobuf:"varint,1,opt,name=allowed,proto3" json:"allowed,omitempty"`
+	RetryAfterSecs uint32                 `protobuf:"varint,2,opt,name=retry_after_secs,json=retryAfterSecs,proto3" json:"retry_after_secs,omitempty"`
+	// Identify the request in Consume and Refund
+	ClientId  string `protobuf:"bytes,3,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	RequestId string `protobuf:"bytes,4,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Request window state for X-RateLimit-* headers
+	Limit     uint32 `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+	Remaining uint32 `protobuf:"varint,6,opt,name=remaining,proto3" json:"remaining,omitempty"`
+	// Seconds until a request slot frees up
+	ResetSecs uint32 `protobuf:"varint,7,opt,name=reset_secs,json=resetSecs,proto3" json:"reset_secs,omitempty"`
+	// Token bucket state
+	TokenLimit      int64 `protobuf:"varint,8,opt,name=token_limit,json=tokenLimit,proto3" json:"token_limit,omitempty"`
+	TokensRemaining int64 `protobuf:"varint,9,opt,name=tokens_remaining,json=tokensRemaining,proto3" json:"tokens_remaining,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *CheckResponse) Reset() {
+	*x = CheckResponse{}
+	mi := &file_rate_limiter_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckResponse) ProtoMessage() {}
+
+func (x *CheckResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckResponse.ProtoReflect.Descriptor instead.
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CheckResponse) GetAllowed() bool {
+	if x != nil {
+		return x.Allowed
+	}
+	return false
+}
+
+func (x *CheckResponse) GetRetryAfterSecs() uint32 {
+	if x != nil {
+		return x.RetryAfterSecs
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *CheckResponse) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetRemaining() uint32 {
+	if x != nil {
+		return x.Remaining
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetResetSecs() uint32 {
+	if x != nil {
+		return x.ResetSecs
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetTokenLimit() int64 {
+	if x != nil {
+		return x.TokenLimit
+	}
+	return 0
+}
+
+func (x *CheckResponse) GetTokensRemaining() int64 {
+	if x != nil {
+		return x.TokensRemaining
+	}
+	return 0
+}
+
+type ConsumeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Endpoint      string                 `protobuf:"bytes,2,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	Tokens        int64                  `protobuf:"varint,3,opt,name=tokens,proto3" json:"tokens,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ConsumeRequest) Reset() {
+	*x = ConsumeRequest{}
+	mi := &file_rate_limiter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeRequest) ProtoMessage() {}
+
+func (x *ConsumeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeRequest.ProtoReflect.Descriptor instead.
+func (*ConsumeRequest) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ConsumeRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *ConsumeRequest) GetEndpoint() string {
+	if x != nil {
+		return x.Endpoint
+	}
+	return ""
+}
+
+func (x *ConsumeRequest) GetTokens() int64 {
+	if x != nil {
+		return x.Tokens
+	}
+	return 0
+}
+
+type ConsumeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Tokens left in the bucket; negative when the request overdrew it
+	RemainingTokens int64 `protobuf:"varint,1,opt,name=remaining_tokens,json=remainingTokens,proto3" json:"remaining_tokens,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *ConsumeResponse) Reset() {
+	*x = ConsumeResponse{}
+	mi := &file_rate_limiter_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ConsumeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConsumeResponse) ProtoMessage() {}
+
+func (x *ConsumeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConsumeResponse.ProtoReflect.Descriptor instead.
+func (*ConsumeResponse) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ConsumeResponse) GetRemainingTokens() int64 {
+	if x != nil {
+		return x.RemainingTokens
+	}
+	return 0
+}
+
+type RefundRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	ClientId  string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Endpoint  string                 `protobuf:"bytes,2,opt,name=endpoint,proto3" json:"endpoint,omitempty"`
+	RequestId string                 `protobuf:"bytes,3,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// Tokens already charged with Consume, if any
+	Tokens        int64 `protobuf:"varint,4,opt,name=tokens,proto3" json:"tokens,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RefundRequest) Reset() {
+	*x = RefundRequest{}
+	mi := &file_rate_limiter_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RefundRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RefundRequest) ProtoMessage() {}
+
+func (x *RefundRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RefundRequest.ProtoReflect.Descriptor instead.
+func (*RefundRequest) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RefundRequest) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *RefundRequest) GetEndpoint() string {
+	if x != nil {
+		return x.Endpoint
+	}
+	return ""
+}
+
+func (x *RefundRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *RefundRequest) GetTokens() int64 {
+	if x != nil {
+		return x.Tokens
+	}
+	return 0
+}
+
+type RefundResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RefundResponse) Reset() {
+	*x = RefundResponse{}
+	mi := &file_rate_limiter_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RefundResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RefundResponse) ProtoMessage() {}
+
+func (x *RefundResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RefundResponse.ProtoReflect.Descriptor instead.
+func (*RefundResponse) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{5}
+}
+
+type SetLimitRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Path          string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	AuthType      string                 `protobuf:"bytes,2,opt,name=auth_type,json=authType,proto3" json:"auth_type,omitempty"` // "jwt", "api_key", "anonymous"
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`                      // requests per minute
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLimitRequest) Reset() {
+	*x = SetLimitRequest{}
+	mi := &file_rate_limiter_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLimitRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLimitRequest) ProtoMessage() {}
+
+func (x *SetLimitRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLimitRequest.ProtoReflect.Descriptor instead.
+func (*SetLimitRequest) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *SetLimitRequest) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *SetLimitRequest) GetAuthType() string {
+	if x != nil {
+		return x.AuthType
+	}
+	return ""
+}
+
+func (x *SetLimitRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type SetLimitResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetLimitResponse) Reset() {
+	*x = SetLimitResponse{}
+	mi := &file_rate_limiter_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetLimitResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetLimitResponse) ProtoMessage() {}
+
+func (x *SetLimitResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetLimitResponse.ProtoReflect.Descriptor instead.
+func (*SetLimitResponse) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SetLimitResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *SetLimitResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type GetLimitsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetLimitsRequest) Reset() {
+	*x = GetLimitsRequest{}
+	mi := &file_rate_limiter_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetLimitsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLimitsRequest) ProtoMessage() {}
+
+func (x *GetLimitsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLimitsRequest.ProtoReflect.Descriptor instead.
+func (*GetLimitsRequest) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{8}
+}
+
+type GetLimitsResponse struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Limits        map[string]*LimitConfig `protobuf:"bytes,1,rep,name=limits,proto3" json:"limits,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetLimitsResponse) Reset() {
+	*x = GetLimitsResponse{}
+	mi := &file_rate_limiter_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetLimitsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetLimitsResponse) ProtoMessage() {}
+
+func (x *GetLimitsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetLimitsResponse.ProtoReflect.Descriptor instead.
+func (*GetLimitsResponse) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *GetLimitsResponse) GetLimits() map[string]*LimitConfig {
+	if x != nil {
+		return x.Limits
+	}
+	return nil
+}
+
+type LimitConfig struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Path           string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	JwtLimit       int32                  `protobuf:"varint,2,opt,name=jwt_limit,json=jwtLimit,proto3" json:"jwt_limit,omitempty"`
+	ApiKeyLimit    int32                  `protobuf:"varint,3,opt,name=api_key_limit,json=apiKeyLimit,proto3" json:"api_key_limit,omitempty"`
+	AnonymousLimit int32                  `protobuf:"varint,4,opt,name=anonymous_limit,json=anonymousLimit,proto3" json:"anonymous_limit,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *LimitConfig) Reset() {
+	*x = LimitConfig{}
+	mi := &file_rate_limiter_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LimitConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LimitConfig) ProtoMessage() {}
+
+func (x *LimitConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_rate_limiter_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LimitConfig.ProtoReflect.Descriptor instead.
+func (*LimitConfig) Descriptor() ([]byte, []int) {
+	return file_rate_limiter_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *LimitConfig) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *LimitConfig) GetJwtLimit() int32 {
+	if x != nil {
+		return x.JwtLimit
+	}
+	return 0
+}
+
+func (x *LimitConfig) GetApiKeyLimit() int32 {
+	if x != nil {
+		return x.ApiKeyLimit
+	}
+	return 0
+}
+
+func (x *LimitConfig) GetAnonymousLimit() int32 {
+	if x != nil {
+		return x.AnonymousLimit
+	}
+	return 0
+}
+
+var File_rate_limiter_proto protoreflect.FileDescriptor
+
+const file_rate_limiter_proto_rawDesc = "" +
+	"\n" +
+	"\x12rate_limiter.proto\x12\frate_limiter\"H\n" +
+	"\fCheckRequest\x12$\n" +
+	"\rauthorization\x18\x01 \x01(\tR\rauthorization\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\"\xae\x02\n" +
+	"\rCheckResponse\x12\x18\n" +
+	"\aallowed\x18\x01 \x01(\bR\aallowed\x12(\n" +
+	"\x10retry_after_secs\x18\x02 \x01(\rR\x0eretryAfterSecs\x12\x1b\n" +
+	"\tclient_id\x18\x03 \x01(\tR\bclientId\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x04 \x01(\tR\trequestId\x12\x14\n" +
+	"\x05limit\x18\x05 \x01(\rR\x05limit\x12\x1c\n" +
+	"\tremaining\x18\x06 \x01(\rR\tremaining\x12\x1d\n" +
+	"\n" +
+	"reset_secs\x18\a \x01(\rR\tresetSecs\x12\x1f\n" +
+	"\vtoken_limit\x18\b \x01(\x03R\n" +
+	"tokenLimit\x12)\n" +
+	"\x10tokens_remaining\x18\t \x01(\x03R\x0ftokensRemaining\"a\n" +
+	"\x0eConsumeRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x1a\n" +
+	"\bendpoint\x18\x02 \x01(\tR\bendpoint\x12\x16\n" +
+	"\x06tokens\x18\x03 \x01(\x03R\x06tokens\"<\n" +
+	"\x0fConsumeResponse\x12)\n" +
+	"\x10remaining_tokens\x18\x01 \x01(\x03R\x0fremainingTokens\"\x7f\n" +
+	"\rRefundRequest\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x1a\n" +
+	"\bendpoint\x18\x02 \x01(\tR\bendpoint\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x03 \x01(\tR\trequestId\x12\x16\n" +
+	"\x06tokens\x18\x04 \x01(\x03R\x06tokens\"\x10\n" +
+	"\x0eRefundResponse\"X\n" +
+	"\x0fSetLimitRequest\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1b\n" +
+	"\tauth_type\x18\x02 \x01(\tR\bauthType\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\"F\n" +
+	"\x10SetLimitResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\x12\n" +
+	"\x10GetLimitsRequest\"\xae\x01\n" +
+	"\x11GetLimitsResponse\x12C\n" +
+	"\x06limits\x18\x01 \x03(\v2+.rate_limiter.GetLimitsResponse.LimitsEntryR\x06limits\x1aT\n" +
+	"\vLimitsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12/\n" +
+	"\x05value\x18\x02 \x01(\v2\x19.rate_limiter.LimitConfigR\x05value:\x028\x01\"\x8b\x01\n" +
+	"\vLimitConfig\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x1b\n" +
+	"\tjwt_limit\x18\x02 \x01(\x05R\bjwtLimit\x12\"\n" +
+	"\rapi_key_limit\x18\x03 \x01(\x05R\vapiKeyLimit\x12'\n" +
+	"\x0fanonymous_limit\x18\x04 \x01(\x05R\x0eanonymousLimit2\xf5\x02\n" +
+	"\vRateLimiter\x12@\n" +
+	"\x05Check\x12\x1a.rate_limiter.CheckRequest\x1a\x1b.rate_limiter.CheckResponse\x12F\n" +
+	"\aConsume\x12\x1c.rate_limiter.ConsumeRequest\x1a\x1d.rate_limiter.ConsumeResponse\x12C\n" +
+	"\x06Refund\x12\x1b.rate_limiter.RefundRequest\x1a\x1c.rate_limiter.RefundResponse\x12I\n" +
+	"\bSetLimit\x12\x1d.rate_limiter.SetLimitRequest\x1a\x1e.rate_limiter.SetLimitResponse\x12L\n" +
+	"\tGetLimits\x12\x1e.rate_limiter.GetLimitsRequest\x1a\x1f.rate_limiter.GetLimitsResponseB1Z/github.com/MaksimVF/ZB/services/rate-limiter/pbb\x06proto3"
+
+var (
+	file_rate_limiter_proto_rawDescOnce sync.Once
+	file_rate_limiter_proto_rawDescData []byte
+)
+
+func file_rate_limiter_proto_rawDescGZIP() []byte {
+	file_rate_limiter_proto_rawDescOnce.Do(func() {
+		file_rate_limiter_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_rate_limiter_proto_rawDesc), len(file_rate_limiter_proto_rawDesc)))
+	})
+	return file_rate_limiter_proto_rawDescData
+}
+
+var file_rate_limiter_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_rate_limiter_proto_goTypes = []any{
+	(*CheckRequest)(nil),      // 0: rate_limiter.CheckRequest
+	(*CheckResponse)(nil),     // 1: rate_limiter.CheckResponse
+	(*ConsumeRequest)(nil),    // 2: rate_limiter.ConsumeRequest
+	(*ConsumeResponse)(nil),   // 3: rate_limiter.ConsumeResponse
+	(*RefundRequest)(nil),     // 4: rate_limiter.RefundRequest
+	(*RefundResponse)(nil),    // 5: rate_limiter.RefundResponse
+	(*SetLimitRequest)(nil),   // 6: rate_limiter.SetLimitRequest
+	(*SetLimitResponse)(nil),  // 7: rate_limiter.SetLimitResponse
+	(*GetLimitsRequest)(nil),  // 8: rate_limiter.GetLimitsRequest
+	(*GetLimitsResponse)(nil), // 9: rate_limiter.GetLimitsResponse
+	(*LimitConfig)(nil),       // 10: rate_limiter.LimitConfig
+	nil,                       // 11: rate_limiter.GetLimitsResponse.LimitsEntry
+}
+var file_rate_limiter_proto_depIdxs = []int32{
+	11, // 0: rate_limiter.GetLimitsResponse.limits:type_name -> rate_limiter.GetLimitsResponse.LimitsEntry
+	10, // 1: rate_limiter.GetLimitsResponse.LimitsEntry.value:type_name -> rate_limiter.LimitConfig
+	0,  // 2: rate_limiter.RateLimiter.Check:input_type -> rate_limiter.CheckRequest
+	2,  // 3: rate_limiter.RateLimiter.Consume:input_type -> rate_limiter.ConsumeRequest
+	4,  // 4: rate_limiter.RateLimiter.Refund:input_type -> rate_limiter.RefundRequest
+	6,  // 5: rate_limiter.RateLimiter.SetLimit:input_type -> rate_limiter.SetLimitRequest
+	8,  // 6: rate_limiter.RateLimiter.GetLimits:input_type -> rate_limiter.GetLimitsRequest
+	1,  // 7: rate_limiter.RateLimiter.Check:output_type -> rate_limiter.CheckResponse
+	3,  // 8: rate_limiter.RateLimiter.Consume:output_type -> rate_limiter.ConsumeResponse
+	5,  // 9: rate_limiter.RateLimiter.Refund:output_type -> rate_limiter.RefundResponse
+	7,  // 10: rate_limiter.RateLimiter.SetLimit:output_type -> rate_limiter.SetLimitResponse
+	9,  // 11: rate_limiter.RateLimiter.GetLimits:output_type -> rate_limiter.GetLimitsResponse
+	7,  // [7:12] is the sub-list for method output_type
+	2,  // [2:7] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_rate_limiter_proto_init() }
+func file_rate_limiter_proto_init() {
+	if File_rate_limiter_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_rate_limiter_proto_rawDesc), len(file_rate_limiter_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rate_limiter_proto_goTypes,
+		DependencyIndexes: file_rate_limiter_proto_depIdxs,
+		MessageInfos:      file_rate_limiter_proto_msgTypes,
+	}.Build()
+	File_rate_limiter_proto = out.File
+	file_rate_limiter_proto_goTypes = nil
+	file_rate_limiter_proto_depIdxs = nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// alertSubject is the NATS subject of anomaly alerts
+const alertSubject = "routing.alerts"
+
+// Anomaly metrics; error rates are per head
+const (
+	metricDecisionLatency = "decision_latency_ms"
+	metricErrorRate       = "error_rate"
+	metricCacheHitRate    = "cache_hit_rate"
+)
+
+const (
+	// maxAlerts is how many recent alerts /api/routing/alerts returns
+	maxAlerts = 100
+	// minHeadRequests is the fewest routed requests a head needs in an
+	// interval for its error rate to be a sample
+	minHeadRequests = 5
+)
+
+var anomalyAlerts = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "routing_anomaly_alerts_total",
+		Help: "Total number of anomalies detected on routing metrics",
+	},
+	[]string{"metric"},
+)
+
+// anomalies watches the routing metrics of this instance
+var anomalies = newAnomalyDetector(anomalyConfigFromEnv(), publishAlert)
+
+// Alert is a metric that deviates from its rolling baseline
+type Alert struct {
+	Metric string `json:"metric"`
+	HeadID string `json:"head_id,omitempty"`
+	// Value of the last interval, and mean and standard deviation of the
+	// intervals before it
+	Value      float64   `json:"value"`
+	Mean       float64   `json:"mean"`
+	StdDev     float64   `json:"std_dev"`
+	ZScore     float64   `json:"z_score"`
+	Threshold  float64   `json:"threshold"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+type anomalyConfig struct {
+	// Interval of a sample, e.g. the mean decision latency over a minute
+	Interval time.Duration
+	// Window is the number of samples in a baseline
+	Window int
+	// MinSamples is the number of samples needed before alerting
+	MinSamples int
+	// Threshold is the z-score of an anomaly
+	Threshold float64
+}
+
+// anomalyConfigFromEnv reads ANOMALY_INTERVAL, ANOMALY_WINDOW and
+// ANOMALY_Z_THRESHOLD
+func anomalyConfigFromEnv() anomalyConfig {
+	cfg := anomalyConfig{Interval: time.Minute, Window: 30, MinSamples: 10, Threshold: 3}
+	if d, err := time.ParseDuration(os.Getenv("ANOMALY_INTERVAL")); err == nil && d > 0 {
+		cfg.Interval = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("ANOMALY_WINDOW")); err == nil && n > 1 {
+		cfg.Window = n
+		if cfg.MinSamples > n {
+			cfg.MinSamples = n
+		}
+	}
+	if z, err := strconv.ParseFloat(os.Getenv("ANOMALY_Z_THRESHOLD"), 64); err == nil && z > 0 {
+		cfg.Threshold = z
+	}
+	return cfg
+}
+
+// baseline is the rolling window of a metric
+type baseline struct {
+	samples []float64
+	// higherIsWorse is false for metrics that degrade downwards
+	higherIsWorse bool
+	// minStdDev keeps a flat baseline from turning noise into anomalies
+	minStdDev float64
+	// alerting until the metric is back within the threshold, so an anomaly
+	// raises a single alert
+	alerting bool
+}
+
+func (b *baseline) stats() (mean, stdDev float64) {
+	for _, v := range b.samples {
+		mean += v
+	}
+	mean /= float64(len(b.samples))
+	for _, v := range b.samples {
+		stdDev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stdDev / float64(len(b.samples)))
+}
+
+type headOutcomes struct {
+	total, failed int
+}
+
+// anomalyDetector samples routing metrics per interval and compares each
+// sample with the baseline of the previous ones
+type anomalyDetector struct {
+	cfg     anomalyConfig
+	publish func(Alert)
+
+	mu sync.Mutex
+	// Current interval
+	latencySum   time.Duration
+	decisions    int
+	cacheHits    int
+	cacheLookups int
+	heads        map[string]*headOutcomes
+
+	baselines map[string]*baseline
+	alerts    []Alert
+}
+
+func newAnomalyDetector(cfg anomalyConfig, publish func(Alert)) *anomalyDetector {
+	return &anomalyDetector{
+		cfg:       cfg,
+		publish:   publish,
+		heads:     make(map[string]*headOutcomes),
+		baselines: make(map[string]*baseline),
+	}
+}
+
+// ObserveDecision records the latency of a routing decision
+func (d *anomalyDetector) ObserveDecision(latency time.Duration) {
+	d.mu.Lock()
+	d.latencySum += latency
+	d.decisions++
+	d.mu.Unlock()
+}
+
+// ObserveCache records a lookup of the decision cache
+func (d *anomalyDetector) ObserveCache(hit bool) {
+	d.mu.Lock()
+	d.cacheLookups++
+	if hit {
+		d.cacheHits++
+	}
+	d.mu.Unlock()
+}
+
+// ObserveOutcome records the outcome of a request routed to a head
+func (d *anomalyDetector) ObserveOutcome(headID string, success bool) {
+	d.mu.Lock()
+	h := d.heads[headID]
+	if h == nil {
+		h = &headOutcomes{}
+		d.heads[headID] = h
+	}
+	h.total++
+	if !success {
+		h.failed++
+	}
+	d.mu.Unlock()
+}
+
+// Evaluate closes the interval: each metric with a sample is checked against
+// its baseline, then added to it. New alerts are published and returned.
+func (d *anomalyDetector) Evaluate(now time.Time) []Alert {
+	d.mu.Lock()
+	var alerts []Alert
+	check := func(metric, headID string, value float64, higherIsWorse bool, minStdDev float64) {
+		key := metric + "/" + headID
+		b := d.baselines[key]
+		if b == nil {
+			b = &baseline{higherIsWorse: higherIsWorse, minStdDev: minStdDev}
+			d.baselines[key] = b
+		}
+		if alert, ok := d.check(b, value); ok {
+			alert.Metric, alert.HeadID, alert.DetectedAt = metric, headID, now
+			alerts = append(alerts, alert)
+		}
+		b.samples = append(b.samples, value)
+		if len(b.samples) > d.cfg.Window {
+			b.samples = b.samples[1:]
+		}
+	}
+
+	if d.decisions > 0 {
+		check(metricDecisionLatency, "", float64(d.latencySum)/float64(d.decisions)/float64(time.Millisecond), true, 1)
+	}
+	if d.cacheLookups > 0 {
+		check(metricCacheHitRate, "", float64(d.cacheHits)/float64(d.cacheLookups), false, 0.01)
+	}
+	headIDs := make([]string, 0, len(d.heads))
+	for headID := range d.heads {
+		headIDs = append(headIDs, headID)
+	}
+	sort.Strings(headIDs)
+	for _, headID := range headIDs {
+		if h := d.heads[headID]; h.total >= minHeadRequests {
+			check(metricErrorRate, headID, float64(h.failed)/float64(h.total), true, 0.01)
+		}
+	}
+
+	d.latencySum, d.decisions, d.cacheHits, d.cacheLookups = 0, 0, 0, 0
+	d.heads = make(map[string]*headOutcomes)
+	d.alerts = append(d.alerts, alerts...)
+	if len(d.alerts) > maxAlerts {
+		d.alerts = d.alerts[len(d.alerts)-maxAlerts:]
+	}
+	d.mu.Unlock()
+
+	for _, alert := range alerts {
+		anomalyAlerts.WithLabelValues(alert.Metric).Inc()
+		if d.publish != nil {
+			d.publish(alert)
+		}
+	}
+	return alerts
+}
+
+// check compares value with the baseline and reports whether it starts an
+// anomaly
+func (d *anomalyDetector) check(b *baseline, value float64) (Alert, bool) {
+	if len(b.samples) < d.cfg.MinSamples {
+		return Alert{}, false
+	}
+	mean, stdDev := b.stats()
+	z := (value - mean) / math.Max(stdDev, b.minStdDev)
+	worse := z
+	if !b.higherIsWorse {
+		worse = -z
+	}
+	if worse < d.cfg.Threshold {
+		b.alerting = false
+		return Alert{}, false
+	}
+	if b.alerting {
+		return Alert{}, false
+	}
+	b.alerting = true
+	return Alert{Value: value, Mean: mean, StdDev: stdDev, ZScore: z, Threshold: d.cfg.Threshold}, true
+}
+
+// Alerts returns the recent alerts, newest first
+func (d *anomalyDetector) Alerts() []Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	alerts := make([]Alert, len(d.alerts))
+	for i, alert := range d.alerts {
+		alerts[len(d.alerts)-1-i] = alert
+	}
+	return alerts
+}
+
+// Run evaluates the metrics every interval until ctx is done
+func (d *anomalyDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.Evaluate(now)
+		}
+	}
+}
+
+// publishAlert logs the alert and publishes it on alertSubject
+func publishAlert(alert Alert) {
+	logger.Warn("Routing metric anomaly",
+		zap.String("metric", alert.Metric),
+		zap.String("head_id", alert.HeadID),
+		zap.Float64("value", alert.Value),
+		zap.Float64("mean", alert.Mean),
+		zap.Float64("z_score", alert.ZScore),
+	)
+	if natsConn == nil {
+		return
+	}
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+	if err := natsConn.Publish(alertSubject, data); err != nil {
+		messageQueueMessages.WithLabelValues(alertSubject, "error").Inc()
+		return
+	}
+	messageQueueMessages.WithLabelValues(alertSubject, "success").Inc()
+}
+
+// handleAlerts serves the recent anomaly alerts, newest first
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	alerts := anomalies.Alerts()
+	if v := r.URL.Query().Get("metric"); v != "" {
+		filtered := alerts[:0]
+		for _, alert := range alerts {
+			if alert.Metric == v {
+				filtered = append(filtered, alert)
+			}
+		}
+		alerts = filtered
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"alerts":   alerts,
+		"interval": anomalies.cfg.Interval.String(),
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testDetector(published *[]Alert) *anomalyDetector {
+	return newAnomalyDetector(anomalyConfig{Interval: time.Minute, Window: 10, MinSamples: 5, Threshold: 3}, func(a Alert) {
+		*published = append(*published, a)
+	})
+}
+
+func TestAnomalyDecisionLatency(t *testing.T) {
+	var published []Alert
+	d := testDetector(&published)
+	now := time.Now()
+
+	// Baseline of 10ms, 12ms, 10ms, ...
+	for i := 0; i < 10; i++ {
+		d.ObserveDecision(time.Duration(10+2*(i%2)) * time.Millisecond)
+		if alerts := d.Evaluate(now); len(alerts) != 0 {
+			t.Fatalf("interval %d: alerts = %+v", i, alerts)
+		}
+	}
+
+	d.ObserveDecision(80 * time.Millisecond)
+	alerts := d.Evaluate(now)
+	if len(alerts) != 1 || alerts[0].Metric != metricDecisionLatency || alerts[0].Value != 80 || alerts[0].ZScore < 3 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if len(published) != 1 {
+		t.Errorf("published = %+v", published)
+	}
+
+	// An ongoing anomaly raises a single alert
+	d.ObserveDecision(90 * time.Millisecond)
+	if alerts := d.Evaluate(now); len(alerts) != 0 {
+		t.Errorf("repeated alerts = %+v", alerts)
+	}
+}
+
+func TestAnomalyCacheHitRateAndErrorRate(t *testing.T) {
+	var published []Alert
+	d := testDetector(&published)
+	now := time.Now()
+
+	for i := 0; i < 6; i++ {
+		for j := 0; j < 10; j++ {
+			d.ObserveCache(j < 8)
+			d.ObserveOutcome("head-1", j != 0)
+		}
+		d.Evaluate(now)
+	}
+
+	// A higher hit rate is not an anomaly, a lower one is
+	for j := 0; j < 10; j++ {
+		d.ObserveCache(true)
+	}
+	if alerts := d.Evaluate(now); len(alerts) != 0 {
+		t.Errorf("alerts on improvement = %+v", alerts)
+	}
+	for j := 0; j < 10; j++ {
+		d.ObserveCache(j < 2)
+		d.ObserveOutcome("head-1", j < 3)
+	}
+	alerts := d.Evaluate(now)
+	if len(alerts) != 2 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if alerts[0].Metric != metricCacheHitRate || alerts[0].ZScore >= 0 {
+		t.Errorf("cache alert = %+v", alerts[0])
+	}
+	if alerts[1].Metric != metricErrorRate || alerts[1].HeadID != "head-1" {
+		t.Errorf("error rate alert = %+v", alerts[1])
+	}
+
+	// Heads with too few requests are not sampled
+	d.ObserveOutcome("head-2", false)
+	if alerts := d.Evaluate(now); len(alerts) != 0 {
+		t.Errorf("alerts = %+v", alerts)
+	}
+}
+
+func TestHandleAlerts(t *testing.T) {
+	var published []Alert
+	saved := anomalies
+	anomalies = testDetector(&published)
+	defer func() { anomalies = saved }()
+	anomalies.alerts = []Alert{{Metric: metricErrorRate, HeadID: "head-1"}, {Metric: metricDecisionLatency}}
+
+	rr := httptest.NewRecorder()
+	handleAlerts(rr, httptest.NewRequest(http.MethodGet, "/api/routing/alerts?metric=error_rate", nil))
+	var body struct {
+		Alerts []Alert `json:"alerts"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Alerts) != 1 || body.Alerts[0].HeadID != "head-1" {
+		t.Errorf("alerts = %+v", body.Alerts)
+	}
+}
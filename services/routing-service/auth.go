@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// UserContext is the caller of the admin API, from its auth-service token
+type UserContext struct {
+	UserID string
+	Role   string
+}
+
+// tokenClaims matches the tokens issued by auth-service, which carry the
+// user's role in "role"
+type tokenClaims struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// jwtSecret verifies Bearer tokens (JWT_SECRET, shared with auth-service)
+var jwtSecret []byte
+
+func initAuth() {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		logger.Fatal("JWT_SECRET is not set; the admin API cannot run unauthenticated")
+	}
+	jwtSecret = []byte(secret)
+}
+
+// parseToken validates the Bearer token of r, signed by auth-service
+func parseToken(r *http.Request) (*tokenClaims, error) {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == "" || token == header {
+		return nil, errors.New("missing bearer token")
+	}
+
+	claims := &tokenClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid || claims.UserID == "" || claims.Role == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// jwtMiddleware puts the caller into the request context as "user"; what its
+// role may do is decided by requirePermission
+func jwtMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Skip authentication for health checks
+		if r.URL.Path == "/health" || r.URL.Path == "/livez" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "Missing authorization header", http.StatusUnauthorized)
+			return
+		}
+		claims, err := parseToken(r)
+		if err != nil {
+			logger.Debug("Rejected admin API token", zap.Error(err), zap.String("path", r.URL.Path))
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		userCtx := UserContext{UserID: claims.UserID, Role: claims.Role}
+		ctx := context.WithValue(r.Context(), "user", userCtx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
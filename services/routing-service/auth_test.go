@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// signToken issues a token the way auth-service does
+func signToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	logger = zap.NewNop()
+	jwtSecret = []byte("test-secret")
+	exp := time.Now().Add(time.Hour).Unix()
+
+	var got UserContext
+	handler := jwtMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value("user").(UserContext)
+	}))
+
+	tests := map[string]struct {
+		auth   string
+		status int
+		user   UserContext
+	}{
+		"operator": {
+			auth:   "Bearer " + signToken(t, "test-secret", jwt.MapClaims{"user_id": "u1", "email": "op@example.com", "role": "operator", "exp": exp}),
+			status: http.StatusOK,
+			user:   UserContext{UserID: "u1", Role: "operator"},
+		},
+		"expired": {
+			auth:   "Bearer " + signToken(t, "test-secret", jwt.MapClaims{"user_id": "u1", "role": "admin", "exp": time.Now().Add(-time.Minute).Unix()}),
+			status: http.StatusUnauthorized,
+		},
+		"other secret": {
+			auth:   "Bearer " + signToken(t, "other-secret", jwt.MapClaims{"user_id": "u1", "role": "admin", "exp": exp}),
+			status: http.StatusUnauthorized,
+		},
+		"no role": {
+			auth:   "Bearer " + signToken(t, "test-secret", jwt.MapClaims{"user_id": "u1", "exp": exp}),
+			status: http.StatusUnauthorized,
+		},
+		"former static token": {auth: "Bearer admin-token", status: http.StatusUnauthorized},
+		"missing":             {status: http.StatusUnauthorized},
+	}
+	for name, tc := range tests {
+		got = UserContext{}
+		req := httptest.NewRequest(http.MethodPut, "/api/routing/policy", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.status || got != tc.user {
+			t.Errorf("%s: status %d, user %+v; want %d, %+v", name, rec.Code, got, tc.status, tc.user)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/readyz without a token: %d", rec.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// dedupTTL is how long a message ID is remembered, set by NATS_DEDUP_TTL;
+// a copy delivered later is processed again
+var dedupTTL = 10 * time.Minute
+
+func init() {
+	if d, err := time.ParseDuration(os.Getenv("NATS_DEDUP_TTL")); err == nil && d > 0 {
+		dedupTTL = d
+	}
+}
+
+// messageID is the publisher's Nats-Msg-Id header of msg, or "" without one.
+// The payload is no identity: a head re-registering after a restart sends
+// the same bytes again, and that must not be dropped as a copy.
+func messageID(msg *nats.Msg) string {
+	if msg.Header == nil {
+		return ""
+	}
+	return msg.Header.Get(nats.MsgIdHdr)
+}
+
+func dedupKey(msg *nats.Msg) string {
+	return "routing:nats:seen:" + msg.Subject + ":" + messageID(msg)
+}
+
+// firstDelivery claims msg in Redis and reports whether it was not seen
+// within dedupTTL. Messages without an ID, and all messages without Redis,
+// are processed rather than lost.
+func firstDelivery(ctx context.Context, msg *nats.Msg) bool {
+	if redisClient == nil || messageID(msg) == "" {
+		return true
+	}
+	first, err := redisClient.SetNX(ctx, dedupKey(msg), 1, dedupTTL).Result()
+	if err != nil {
+		logger.Warn("Message deduplication unavailable", zap.String("subject", msg.Subject), zap.Error(err))
+		return true
+	}
+	return first
+}
+
+// forgetDelivery releases the claim on a message that failed, so a retry by
+// the publisher is processed
+func forgetDelivery(ctx context.Context, msg *nats.Msg) {
+	if redisClient == nil || messageID(msg) == "" {
+		return
+	}
+	redisClient.Del(ctx, dedupKey(msg))
+}
+
+// staleStatusUpdate reports whether a status update was sent before the
+// last heartbeat already applied to the head, i.e. arrived out of order
+func staleStatusUpdate(headID string, timestamp int64) bool {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	head, exists := headServices[headID]
+	return exists && timestamp < head.LastHeartbeat
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/nats-io/nats.go"
+)
+
+func TestMessageID(t *testing.T) {
+	msg := nats.NewMsg("head.registration.request")
+	msg.Data = []byte(`{"head_id":"head-1","endpoint":"head-1:50055"}`)
+	if got := messageID(msg); got != "" {
+		t.Errorf("messageID without a header = %q, want none", got)
+	}
+	if got := messageID(&nats.Msg{Subject: "head.registration.request", Data: msg.Data}); got != "" {
+		t.Errorf("messageID of a message without headers = %q, want none", got)
+	}
+
+	msg.Header.Set(nats.MsgIdHdr, "update-1")
+	if got := messageID(msg); got != "update-1" {
+		t.Errorf("messageID = %s, want the Nats-Msg-Id header", got)
+	}
+}
+
+func TestFirstDelivery(t *testing.T) {
+	mr := miniredis.RunT(t)
+	prev := redisClient
+	redisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer func() { redisClient = prev }()
+	ctx := context.Background()
+
+	// A head re-registering after a restart sends the same payload again
+	registration := []byte(`{"head_id":"head-1","endpoint":"head-1:50055","status":"active"}`)
+	for i := 0; i < 2; i++ {
+		if !firstDelivery(ctx, &nats.Msg{Subject: "head.registration.request", Data: registration}) {
+			t.Fatalf("registration %d without a message ID dropped", i+1)
+		}
+	}
+
+	msg := nats.NewMsg("head.status.update")
+	msg.Header.Set(nats.MsgIdHdr, "update-1")
+	if !firstDelivery(ctx, msg) {
+		t.Fatal("first delivery dropped")
+	}
+	if firstDelivery(ctx, msg) {
+		t.Error("redelivery with the same message ID processed")
+	}
+	forgetDelivery(ctx, msg)
+	if !firstDelivery(ctx, msg) {
+		t.Error("retry after a failure dropped")
+	}
+}
+
+func TestStaleStatusUpdate(t *testing.T) {
+	configMutex.Lock()
+	headServices["stale-head"] = HeadService{HeadID: "stale-head", LastHeartbeat: 100}
+	configMutex.Unlock()
+	defer func() {
+		configMutex.Lock()
+		delete(headServices, "stale-head")
+		configMutex.Unlock()
+	}()
+
+	if !staleStatusUpdate("stale-head", 99) {
+		t.Error("update older than the last heartbeat applied")
+	}
+	if staleStatusUpdate("stale-head", 100) || staleStatusUpdate("stale-head", 101) {
+		t.Error("current update ignored")
+	}
+	if staleStatusUpdate("unknown-head", 1) {
+		t.Error("update of an unknown head ignored")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// drainMessage is the UpdateHeadStatus reply to the heartbeats of a head an
+// operator asked to drain; the head starts draining when it sees it
+const drainMessage = "drain requested"
+
+// headEventSubject is the NATS subject of head status changes
+const headEventSubject = "head.status.changed"
+
+// HeadEvent is a change of a head's status, sent to /events/head-status
+// subscribers and published on headEventSubject
+type HeadEvent struct {
+	HeadID         string    `json:"head_id"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status"`
+	DrainRequested bool      `json:"drain_requested"`
+	At             time.Time `json:"at"`
+}
+
+// publishHeadEvent sends the event to SSE subscribers, skipping those that
+// are not keeping up, and publishes it on headEventSubject
+func publishHeadEvent(head HeadService, previous string) {
+	data, err := json.Marshal(HeadEvent{
+		HeadID:         head.HeadID,
+		Status:         head.Status,
+		PreviousStatus: previous,
+		DrainRequested: head.DrainRequested,
+		At:             time.Now(),
+	})
+	if err != nil {
+		return
+	}
+
+	clientsMutex.Lock()
+	for _, client := range headStatusClients {
+		select {
+		case client <- string(data):
+		default:
+		}
+	}
+	clientsMutex.Unlock()
+
+	if natsConn == nil {
+		return
+	}
+	if err := natsConn.Publish(headEventSubject, data); err != nil {
+		messageQueueMessages.WithLabelValues(headEventSubject, "error").Inc()
+		return
+	}
+	messageQueueMessages.WithLabelValues(headEventSubject, "success").Inc()
+}
+
+// drainHeads marks the routing entries of a head as draining, so they are no
+// longer selected, or makes them selectable again. id is an entry ID or the
+// head_id the entries of a head share. Heads learn of the drain from the
+// reply to their next heartbeat.
+func drainHeads(id string, drain bool) ([]HeadService, error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	var changed []HeadService
+	for entryID, head := range headServices {
+		if entryID != id && head.Metadata["head_id"] != id {
+			continue
+		}
+		previous := head.Status
+		head.DrainRequested = drain
+		switch {
+		case drain && head.Status == "active":
+			head.Status = "draining"
+		case !drain && head.Status == "draining":
+			head.Status = "active"
+		}
+		if err := updateHeadStatusInRedis(head); err != nil {
+			return nil, err
+		}
+		headServices[entryID] = head
+		changed = append(changed, head)
+		if head.Status != previous {
+			publishHeadEvent(head, previous)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, fmt.Errorf("head %s not found", id)
+	}
+
+	// Cached decisions must not keep pointing at draining entries
+	cacheMutex.Lock()
+	for key, cachedHeadID := range routingCache {
+		for _, head := range changed {
+			if cachedHeadID == head.HeadID {
+				delete(routingCache, key)
+			}
+		}
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("Head drain changed", zap.String("head_id", id), zap.Bool("drain", drain), zap.Int("entries", len(changed)))
+	return changed, nil
+}
+
+// handleDrainHead serves POST (drain) and DELETE (resume) on
+// /api/routing/heads/{head_id}/drain
+func handleDrainHead(w http.ResponseWriter, r *http.Request) {
+	heads, err := drainHeads(mux.Vars(r)["head_id"], r.Method == http.MethodPost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"heads": heads})
+}
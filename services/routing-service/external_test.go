@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/resilience"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -308,48 +309,8 @@ func checkPermission(role, action string) bool {
 func initService() {
 	// Initialize the service for testing
 	// This should initialize all the global variables and dependencies
-	rateLimiter = &RateLimiter{
-		requests:     make(map[string]int),
-		lastRequest:  make(map[string]time.Time),
-		threshold:    10,
-		resetTimeout:  1 * time.Minute,
-		burstLimit:   5,
-		burstDuration: 10 * time.Second,
-		ipThresholds:  make(map[string]int),
-		ipBurstLimits: make(map[string]int),
-		ipResetTimeouts: make(map[string]time.Duration),
-		ipBurstDurations: make(map[string]time.Duration),
-		ipRequestCounts: make(map[string]int),
-		ipLastRequests: make(map[string]time.Time),
-		ipSuccessCounts: make(map[string]int),
-		ipFailureCounts: make(map[string]int),
-		ipRecoveryAttempts: make(map[string]int),
-		ipRecoverySuccesses: make(map[string]int),
-		ipRecoveryFailures: make(map[string]int),
-	}
-
-	circuitBreaker = &CircuitBreaker{
-		failures:     make(map[string]int),
-		lastFailure:  make(map[string]time.Time),
-		threshold:    3,
-		resetTimeout: 30 * time.Second,
-		successCount: make(map[string]int),
-		failureCount: make(map[string]int),
-		recoveryAttempts: make(map[string]int),
-		serviceThresholds: make(map[string]int),
-		serviceResetTimeouts: make(map[string]time.Duration),
-		serviceHalfOpenDurations: make(map[string]time.Duration),
-		serviceFailureWindows: make(map[string]time.Duration),
-		serviceSuccessWindows: make(map[string]time.Duration),
-		serviceRecoveryAttempts: make(map[string]int),
-		serviceRecoverySuccesses: make(map[string]int),
-		serviceRecoveryFailures: make(map[string]int),
-		serviceRecoveryTime: make(map[string]time.Duration),
-		serviceRecoveryLatency: make(map[string]time.Duration),
-		serviceRecoveryThroughput: make(map[string]float64),
-		serviceRecoverySuccessRate: make(map[string]float64),
-		serviceRecoveryErrorRate: make(map[string]float64),
-	}
+	rateLimiter = resilience.NewRateLimiter(resilience.DefaultRateLimiterConfig())
+	circuitBreaker = resilience.NewCircuitBreaker(resilience.DefaultCircuitBreakerConfig())
 
 	// Initialize other dependencies
 	// ...
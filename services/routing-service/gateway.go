@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/MaksimVF/ZB/gen/proto"
+)
+
+// openAPISpec is generated by protoc-gen-openapiv2 from proto/routing.proto
+//
+//go:embed openapi/routing.swagger.json
+var openAPISpec []byte
+
+// newRESTGateway serves RoutingService over REST in process. The routes come
+// from the google.api.http options in proto/routing.proto, so REST and gRPC
+// share the request and response messages.
+func newRESTGateway(ctx context.Context) (http.Handler, error) {
+	gateway := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}),
+		runtime.WithForwardResponseOption(rejectUnsuccessful),
+	)
+	if err := pb.RegisterRoutingServiceHandlerServer(ctx, gateway, &RoutingServer{}); err != nil {
+		return nil, err
+	}
+	return gateway, nil
+}
+
+// rejectUnsuccessful answers 400 to responses with success=false, which the
+// gRPC methods return for requests they refuse
+func rejectUnsuccessful(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	if result, ok := resp.(interface{ GetSuccess() bool }); ok && !result.GetSuccess() {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	return nil
+}
+
+// serveOpenAPI serves the OpenAPI description of the REST surface
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -3,10 +3,17 @@ module github.com/MaksimVF/ZB/services/routing-service
 go 1.21
 
 require (
+	github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
+	github.com/MaksimVF/ZB/services/secrets-service v0.0.0-00010101000000-000000000000
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/golang-jwt/jwt/v5 v5.2.0
 	github.com/gorilla/mux v1.8.0
 	go.uber.org/zap v1.21.0
 	google.golang.org/grpc v1.44.0
 )
 
-replace github.com/MaksimVF/ZB => ../../..
+replace (
+	github.com/MaksimVF/ZB => ../..
+	github.com/MaksimVF/ZB/services/secrets-service => ../secrets-service
+)
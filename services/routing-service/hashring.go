@@ -0,0 +1,229 @@
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Request metadata the consistent_hash strategy keys on, in this order: a
+// hash of the prompt prefix keeps requests sharing it on the head that has
+// it in its KV cache, a tenant keeps its requests together otherwise
+const (
+	prefixHashMetadataKey = "prefix_hash"
+	tenantMetadataKey     = "tenant_id"
+)
+
+// defaultVirtualNodes is the number of points per head on the ring, set with
+// "consistent_hash.vnodes" in the policy's strategy_config
+const defaultVirtualNodes = 160
+
+var (
+	affinityDecisions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_affinity_decisions_total",
+			Help: "consistent_hash decisions by result: owner (the key's head on the ring), spillover (the owner was over capacity) or no_key",
+		},
+		[]string{"result"},
+	)
+	hashRingChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_hash_ring_changes_total",
+			Help: "Heads joining and leaving the consistent hash ring of a model type",
+		},
+		[]string{"model_type", "change"},
+	)
+)
+
+// hashRing places each head at virtual points on a ring of 32-bit hashes; a
+// key belongs to the first point at or after its hash. A head joining or
+// leaving only moves the keys of its own points.
+type hashRing struct {
+	points []uint32
+	owners []string
+	heads  map[string]bool
+	vnodes int
+}
+
+func newHashRing(headIDs []string, vnodes int) *hashRing {
+	r := &hashRing{heads: make(map[string]bool, len(headIDs)), vnodes: vnodes}
+	type point struct {
+		hash  uint32
+		owner string
+	}
+	points := make([]point, 0, len(headIDs)*vnodes)
+	for _, id := range headIDs {
+		r.heads[id] = true
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{ringHash(id + "#" + strconv.Itoa(i)), id})
+		}
+	}
+	// Ties are broken by head so every instance builds the same ring
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].owner < points[j].owner
+	})
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+func ringHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// walk returns the heads in ring order from the key, each once: the owner
+// first, then the heads its keys move to when it is unavailable
+func (r *hashRing) walk(key string) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+	hash := ringHash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	order := make([]string, 0, len(r.heads))
+	seen := make(map[string]bool, len(r.heads))
+	for i := 0; i < len(r.points) && len(order) < len(r.heads); i++ {
+		owner := r.owners[(start+i)%len(r.points)]
+		if !seen[owner] {
+			seen[owner] = true
+			order = append(order, owner)
+		}
+	}
+	return order
+}
+
+// hashRings holds a ring per model type over its active heads, rebuilt when
+// they change
+var hashRings = struct {
+	sync.Mutex
+	byModel map[string]*hashRing
+}{byModel: make(map[string]*hashRing)}
+
+// ringFor returns the ring of a model type; the caller holds configMutex
+func ringFor(modelType string) *hashRing {
+	var ids []string
+	for _, head := range headServices {
+		if head.ModelType == modelType && head.Status == "active" {
+			ids = append(ids, head.HeadID)
+		}
+	}
+	vnodes := defaultVirtualNodes
+	if n, err := strconv.Atoi(routingPolicy.StrategyConfig["consistent_hash.vnodes"]); err == nil && n > 0 {
+		vnodes = n
+	}
+
+	hashRings.Lock()
+	defer hashRings.Unlock()
+	ring := hashRings.byModel[modelType]
+	if ring != nil && ring.vnodes == vnodes && sameHeads(ring.heads, ids) {
+		return ring
+	}
+
+	next := newHashRing(ids, vnodes)
+	if ring != nil {
+		var joined, left []string
+		for _, id := range ids {
+			if !ring.heads[id] {
+				joined = append(joined, id)
+			}
+		}
+		for id := range ring.heads {
+			if !next.heads[id] {
+				left = append(left, id)
+			}
+		}
+		hashRingChanges.WithLabelValues(modelType, "join").Add(float64(len(joined)))
+		hashRingChanges.WithLabelValues(modelType, "leave").Add(float64(len(left)))
+		logger.Info("Rebalanced hash ring",
+			zap.String("model_type", modelType),
+			zap.Strings("joined", joined),
+			zap.Strings("left", left),
+			zap.Int("heads", len(ids)),
+		)
+	}
+	hashRings.byModel[modelType] = next
+	return next
+}
+
+func sameHeads(heads map[string]bool, ids []string) bool {
+	if len(heads) != len(ids) {
+		return false
+	}
+	for _, id := range ids {
+		if !heads[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// affinityKey returns the key of a request for the consistent_hash strategy,
+// empty when it carries none
+func affinityKey(metadata map[string]string) string {
+	if key := strings.TrimSpace(metadata[prefixHashMetadataKey]); key != "" {
+		return "prefix:" + key
+	}
+	if key := strings.TrimSpace(metadata[tenantMetadataKey]); key != "" {
+		return "tenant:" + key
+	}
+	return ""
+}
+
+// applyConsistentHashStrategy routes requests with the same affinity key to
+// the same head while it is a candidate and has capacity; otherwise the key
+// spills over to the next head on the ring. Requests without a key go to the
+// least loaded head.
+func applyConsistentHashStrategy(heads []HeadService, modelType string, metadata map[string]string) *HeadService {
+	if len(heads) == 0 {
+		return nil
+	}
+	key := affinityKey(metadata)
+	if key == "" {
+		affinityDecisions.WithLabelValues("no_key").Inc()
+		return applyLeastLoadedStrategy(heads)
+	}
+
+	// The ring spans every active head of the model so that residency and
+	// rollouts, which narrow the candidates, do not move the keys of others
+	candidates := make(map[string]int, len(heads))
+	for i, head := range heads {
+		candidates[head.HeadID] = i
+	}
+	var owner *HeadService
+	for _, id := range ringFor(modelType).walk(key) {
+		i, ok := candidates[id]
+		if !ok {
+			continue
+		}
+		head := &heads[i]
+		if owner == nil {
+			owner = head
+		}
+		if canHandleLoad(head) {
+			if head == owner {
+				affinityDecisions.WithLabelValues("owner").Inc()
+			} else {
+				affinityDecisions.WithLabelValues("spillover").Inc()
+			}
+			return head
+		}
+	}
+	if owner == nil {
+		// The candidates are active heads of the model, so on the ring
+		return applyLeastLoadedStrategy(heads)
+	}
+	// Every head is over capacity; the owner still has the key's cache
+	affinityDecisions.WithLabelValues("owner").Inc()
+	return owner
+}
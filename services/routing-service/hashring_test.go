@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHashRingRebalance(t *testing.T) {
+	ring := newHashRing([]string{"a", "b", "c"}, defaultVirtualNodes)
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("tenant-%d", i)
+		order := ring.walk(key)
+		if len(order) != 3 {
+			t.Fatalf("walk(%s) = %v", key, order)
+		}
+		owners[key] = order[0]
+		counts[order[0]]++
+	}
+	for head, n := range counts {
+		if n < 700 || n > 1300 {
+			t.Errorf("head %s owns %d of 3000 keys", head, n)
+		}
+	}
+
+	// A joining head only takes keys, a leaving one only gives its own
+	joined := newHashRing([]string{"a", "b", "c", "d"}, defaultVirtualNodes)
+	left := newHashRing([]string{"a", "c"}, defaultVirtualNodes)
+	moved := 0
+	for key, owner := range owners {
+		if got := joined.walk(key)[0]; got != owner {
+			moved++
+			if got != "d" {
+				t.Errorf("%s moved from %s to %s on join", key, owner, got)
+			}
+		}
+		if got := left.walk(key)[0]; owner != "b" && got != owner {
+			t.Errorf("%s moved from %s to %s when b left", key, owner, got)
+		}
+	}
+	if moved < 450 || moved > 1050 {
+		t.Errorf("%d of 3000 keys moved to the new head", moved)
+	}
+}
+
+func TestConsistentHashStrategy(t *testing.T) {
+	savedHeads, savedPolicy := headServices, routingPolicy
+	defer func() { headServices, routingPolicy = savedHeads, savedPolicy }()
+	routingPolicy = RoutingPolicy{CapacityThreshold: 80}
+	headServices = map[string]HeadService{
+		"h1": {HeadID: "h1", ModelType: "gpt-4o", Status: "active", Capacity: 10},
+		"h2": {HeadID: "h2", ModelType: "gpt-4o", Status: "active", Capacity: 10},
+		"h3": {HeadID: "h3", ModelType: "gpt-4o", Status: "active", Capacity: 10, CurrentLoad: 1},
+	}
+	candidates := func() []HeadService {
+		var heads []HeadService
+		for _, id := range []string{"h3", "h1", "h2"} {
+			heads = append(heads, headServices[id])
+		}
+		return heads
+	}
+	pick := func(metadata map[string]string) string {
+		return applyConsistentHashStrategy(candidates(), "gpt-4o", metadata).HeadID
+	}
+
+	prefix := map[string]string{"prefix_hash": "9f2c", "tenant_id": "acme"}
+	owner := pick(prefix)
+	for i := 0; i < 5; i++ {
+		if got := pick(prefix); got != owner {
+			t.Fatalf("prefix routed to %s, then %s", owner, got)
+		}
+	}
+	if got := pick(map[string]string{"prefix_hash": "9f2c", "tenant_id": "other"}); got != owner {
+		t.Errorf("prefix hash did not take precedence over the tenant: %s", got)
+	}
+	if got := pick(nil); got != "h1" {
+		t.Errorf("no key chose %s, want the least loaded", got)
+	}
+
+	// The owner over capacity spills over to the next head on the ring
+	before := testutil.ToFloat64(affinityDecisions.WithLabelValues("spillover"))
+	head := headServices[owner]
+	head.CurrentLoad = 9
+	headServices[owner] = head
+	next := ringFor("gpt-4o").walk(affinityKey(prefix))[1]
+	if got := pick(prefix); got != next {
+		t.Errorf("spillover chose %s, want %s", got, next)
+	}
+	if got := testutil.ToFloat64(affinityDecisions.WithLabelValues("spillover")) - before; got != 1 {
+		t.Errorf("spillovers = %v", got)
+	}
+
+	// A head leaving rebuilds the ring
+	leaves := testutil.ToFloat64(hashRingChanges.WithLabelValues("gpt-4o", "leave"))
+	head.Status = "offline"
+	headServices[owner] = head
+	if ring := ringFor("gpt-4o"); len(ring.heads) != 2 || ring.heads[owner] {
+		t.Errorf("ring heads = %v", ring.heads)
+	}
+	if got := testutil.ToFloat64(hashRingChanges.WithLabelValues("gpt-4o", "leave")) - leaves; got != 1 {
+		t.Errorf("leaves = %v", got)
+	}
+}
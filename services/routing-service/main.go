@@ -7,8 +7,8 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
@@ -23,21 +23,37 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/go-redis/redis/v8"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"github.com/graph-gophers/graphql-go"
 	"github.com/graph-gophers/graphql-go/relay"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/compress"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics/muxroute"
+	"github.com/MaksimVF/ZB/pkg/ipfilter"
+	"github.com/MaksimVF/ZB/pkg/rbac"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/residency"
+	"github.com/MaksimVF/ZB/pkg/resilience"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"github.com/MaksimVF/ZB/services/routing-service/middleware"
 	"github.com/MaksimVF/ZB/services/routing-service/retry"
 	pb "github.com/MaksimVF/ZB/gen/proto"
 )
 
 var (
-	redisClient   *redis.Client
+	redisClient   redis.UniversalClient
+	roles         *rbac.Roles
+	adminIPs      *ipfilter.Filter
 	logger        *zap.Logger
 	httpServer    *http.Server
 	grpcServer    *grpc.Server
@@ -95,21 +111,13 @@ var (
 		},
 	)
 
-	httpRequests = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method", "endpoint", "status"},
-	)
-
-	httpRequestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "HTTP request latency distribution",
-			Buckets: prometheus.DefBuckets,
+	// Alerted on when a head stops sending heartbeats
+	headLastHeartbeat = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "head_last_heartbeat_timestamp_seconds",
+			Help: "Unix time of the last heartbeat of each head",
 		},
-		[]string{"method", "endpoint"},
+		[]string{"head_id"},
 	)
 
 	// Cache performance metrics
@@ -159,6 +167,22 @@ var (
 			Help: "Number of active WebSocket connections",
 		},
 	)
+
+	// Routing feedback metrics
+	routingFeedback = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_feedback_total",
+			Help: "Total number of routing outcomes reported by clients",
+		},
+		[]string{"head_id", "outcome"},
+	)
+	userFeedback = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_user_feedback_total",
+			Help: "Total number of user ratings of completions, published by tail on completion_feedback",
+		},
+		[]string{"model", "provider", "rating"},
+	)
 )
 
 type HeadService struct {
@@ -176,6 +200,9 @@ type HeadService struct {
 	ResponseTimes []int64           `json:"response_times,omitempty"` // Historical response times
 	Capacity      int32             `json:"capacity,omitempty"` // Maximum capacity
 	Utilization   float64           `json:"utilization,omitempty"` // Current utilization percentage
+	// DrainRequested keeps the head draining whatever its heartbeats report,
+	// until an operator resumes it
+	DrainRequested bool             `json:"drain_requested,omitempty"`
 }
 
 type RoutingPolicy struct {
@@ -189,6 +216,9 @@ type RoutingPolicy struct {
 	PredictionWindow      int               `json:"prediction_window"` // Time window for predictions in minutes
 	LoadGrowthFactor      float64           `json:"load_growth_factor"` // Growth factor for load prediction
 	CapacityThreshold      float64           `json:"capacity_threshold"` // Utilization threshold for routing
+	// RegionFailover orders the regions geo-preferred routing falls back to,
+	// by preferred region; "*" applies to regions without their own order
+	RegionFailover map[string][]string `json:"region_failover,omitempty"`
 }
 
 type RoutingServer struct {
@@ -204,33 +234,70 @@ func main() {
 	}
 	defer logger.Sync()
 
+	// Admin API tokens are issued by auth-service
+	initAuth()
+
+	shutdownTracing, err := tracing.Init(context.Background(), "routing-service")
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize Prometheus metrics
 	prometheus.MustRegister(
 		routingDecisions,
 		headRegistrations,
 		headStatusUpdates,
 		activeHeads,
-		httpRequests,
-		httpRequestDuration,
+		headLastHeartbeat,
+		regionFailovers,
+		regionHealthyHeads,
+		affinityDecisions,
+		hashRingChanges,
 		cacheHits,
 		cacheMisses,
 		externalServiceCalls,
 		messageQueueMessages,
 		sseConnections,
 		websocketConnections,
+		routingFeedback,
+		userFeedback,
+		anomalyAlerts,
+		rolloutPercent,
+		rolloutStateChanges,
+		webhookRejections,
 	)
 
-	// Initialize Redis client
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	// Initialize Redis client; standalone, Sentinel or Cluster per REDIS_MODE
+	ctx := context.Background()
+	redisClient, err = redisconn.New(ctx, redisconn.FromEnv())
+	if err != nil {
+		logger.Fatal("Invalid Redis configuration", zap.Error(err))
+	}
 
 	// Test Redis connection
-	ctx := context.Background()
 	if err := redisClient.Ping(ctx).Err(); err != nil {
 		logger.Fatal("Failed to connect to Redis", zap.Error(err))
 	}
 
+	// Roles defined in auth-service decide what the admin API allows
+	roles = rbac.New(redisClient, rbac.Defaults()...)
+	if err := roles.Load(ctx); err != nil {
+		logger.Error("Failed to load roles, using the built-in roles", zap.Error(err))
+	}
+	roles.Watch(ctx, time.Minute)
+
+	// Admin changes are only accepted from the IPs of ADMIN_IP_ALLOW, or the
+	// rules published for routing-admin
+	adminIPs, err = ipfilter.New("routing-admin", redisClient, ipfilter.FromEnv("ADMIN_IP"))
+	if err != nil {
+		logger.Fatal("Invalid admin IP filter", zap.Error(err))
+	}
+	if err := adminIPs.Load(ctx); err != nil {
+		logger.Error("Failed to load the admin IP filter, using ADMIN_IP_*", zap.Error(err))
+	}
+	adminIPs.Watch(ctx, time.Minute)
+
 	// Initialize default routing policy
 	routingPolicy = RoutingPolicy{
 		DefaultStrategy:       "adaptive",
@@ -260,6 +327,16 @@ func main() {
 
 	// Start message queue subscribers
 	go startMessageQueueSubscribers()
+	go startUserFeedbackSubscriber(ctx)
+
+	// Alert on deviations of decision latency, cache hit rate and head error rates
+	go anomalies.Run(ctx)
+
+	// Ramp version rollouts up or roll them back on their error rates
+	if err := rollouts.Load(ctx); err != nil {
+		logger.Error("Failed to load rollouts", zap.Error(err))
+	}
+	go rollouts.Run(ctx)
 
 	// Start gRPC server
 	go startGRPCServer()
@@ -277,36 +354,25 @@ func startGRPCServer() {
 		logger.Fatal("Failed to listen", zap.Error(err))
 	}
 
-	// Load TLS certificates
-	serverCert, err := tls.LoadX509KeyPair("certs/server.crt", "certs/server.key")
+	// Load TLS certificates, reloaded when they rotate, and require client
+	// certificates signed by our CA and allowed by SPIFFE_AUTHORIZED_IDS
+	serverCerts, err := certs.Watch("routing-service", "certs/server.crt", "certs/server.key", "certs/ca.crt")
 	if err != nil {
-		logger.Fatal("Failed to load server certificates", zap.Error(err))
-	}
-
-	// Load CA certificate
-	caCert, err := os.ReadFile("certs/ca.crt")
-	if err != nil {
-		logger.Fatal("Failed to read CA certificate", zap.Error(err))
-	}
-
-	// Create certificate pool
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		logger.Fatal("Failed to add CA certificate to pool")
-	}
-
-	// Create TLS configuration
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:   certPool,
+		logger.Fatal("Failed to load TLS certificates", zap.Error(err))
 	}
+	tlsConfig := serverCerts.ServerConfig(tls.RequireAndVerifyClientCert, serverCerts.AuthorizeFromEnv())
 
 	// Create gRPC server with TLS
 	grpcServer = grpc.NewServer(
 		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		tracing.ServerOption(),
+		requestid.ServerOption(),
+		compress.ServerOption(),
 	)
 	pb.RegisterRoutingServiceServer(grpcServer, &RoutingServer{})
+	if debug := diagnostics.RegisterGRPC(grpcServer); len(debug) > 0 {
+		logger.Warn("gRPC debugging services enabled", zap.Strings("services", debug))
+	}
 
 	logger.Info("Starting gRPC server with mTLS on :50055")
 	if err := grpcServer.Serve(lis); err != nil {
@@ -315,158 +381,69 @@ func startGRPCServer() {
 }
 
 
-type UserRole string
-
-const (
-	RoleAdmin    UserRole = "admin"
-	RoleOperator UserRole = "operator"
-	RoleViewer   UserRole = "viewer"
-)
-
-type UserContext struct {
-	UserID string
-	Role   UserRole
-}
-
-func jwtMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip authentication for health check
-		if r.URL.Path == "/health" {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		// Start timer for request duration
-		start := time.Now()
-
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Missing authorization header", http.StatusUnauthorized)
-			httpRequests.WithLabelValues(r.Method, r.URL.Path, "401").Inc()
-			httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-			return
-		}
-
-		// In production, validate the JWT token and extract user info
-		// For now, we'll simulate token validation and role extraction
-		var userCtx UserContext
-
-		// Simulate token validation
-		switch authHeader {
-		case "Bearer admin-token":
-			userCtx = UserContext{UserID: "admin-user", Role: RoleAdmin}
-		case "Bearer operator-token":
-			userCtx = UserContext{UserID: "operator-user", Role: RoleOperator}
-		case "Bearer viewer-token":
-			userCtx = UserContext{UserID: "viewer-user", Role: RoleViewer}
-		default:
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			httpRequests.WithLabelValues(r.Method, r.URL.Path, "401").Inc()
-			httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-			return
-		}
-
-		// Add user context to request
-		ctx := context.WithValue(r.Context(), "user", userCtx)
-
-		// Create a response recorder to capture status code
-		rec := statusRecorder{ResponseWriter: w, statusCode: 200}
-		next.ServeHTTP(&rec, r.WithContext(ctx))
-
-		// Record HTTP request metrics
-		httpRequests.WithLabelValues(r.Method, r.URL.Path, fmt.Sprintf("%d", rec.statusCode)).Inc()
-		httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-	})
-}
-
-func webhookSecurityMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Start timer for request duration
-		start := time.Now()
-
-		// Validate JWT token for webhook
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Missing authorization header", http.StatusUnauthorized)
-			httpRequests.WithLabelValues(r.Method, r.URL.Path, "401").Inc()
-			httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-			return
-		}
-
-		// In production, validate the JWT token
-		// For now, we'll check for a specific webhook token format
-		if !strings.HasPrefix(authHeader, "Bearer webhook-") {
-			http.Error(w, "Invalid webhook token", http.StatusUnauthorized)
-			httpRequests.WithLabelValues(r.Method, r.URL.Path, "401").Inc()
-			httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-			return
-		}
-
-		// Validate application signature if present
-		appSignature := r.Header.Get("X-App-Signature")
-		if appSignature == "" {
-			http.Error(w, "Missing application signature", http.StatusUnauthorized)
-			httpRequests.WithLabelValues(r.Method, r.URL.Path, "401").Inc()
-			httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-			return
-		}
-
-		// In production, validate the application signature
-		// For now, we'll check for a specific format
-		if !strings.HasPrefix(appSignature, "app-sig-") {
-			http.Error(w, "Invalid application signature", http.StatusUnauthorized)
-			httpRequests.WithLabelValues(r.Method, r.URL.Path, "401").Inc()
-			httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
-			return
-		}
-
-		// Create a response recorder to capture status code
-		rec := statusRecorder{ResponseWriter: w, statusCode: 200}
-		next.ServeHTTP(&rec, r)
-
-		// Record HTTP request metrics
-		httpRequests.WithLabelValues(r.Method, r.URL.Path, fmt.Sprintf("%d", rec.statusCode)).Inc()
-		httpRequestDuration.WithLabelValues(r.Method, r.URL.Path).Observe(time.Since(start).Seconds())
+// requirePermission allows users whose role grants the permission, as the
+// roles defined in auth-service say, from the IPs adminIPs lets through
+func requirePermission(permission string) func(http.Handler) http.Handler {
+	require := roles.Require(permission, func(r *http.Request) string {
+		userCtx, _ := r.Context().Value("user").(UserContext)
+		return userCtx.Role
 	})
-}
-
-// statusRecorder is a wrapper around http.ResponseWriter that captures the status code
-type statusRecorder struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (r *statusRecorder) WriteHeader(code int) {
-	r.statusCode = code
-	r.ResponseWriter.WriteHeader(code)
-}
-
-func checkRole(requiredRole UserRole) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userCtx, ok := r.Context().Value("user").(UserContext)
-			if !ok || userCtx.Role != requiredRole {
-				http.Error(w, "Forbidden", http.StatusForbidden)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
+		return adminIPs.Middleware(auditBlockedIP)(require(next))
 	}
 }
 
+// auditBlockedIP logs admin requests blocked by the IP filter
+func auditBlockedIP(r *http.Request, b ipfilter.Blocked) {
+	userCtx, _ := r.Context().Value("user").(UserContext)
+	logger.Warn("Admin request blocked by IP filter",
+		zap.String("scope", b.Scope),
+		zap.String("ip", b.IP),
+		zap.String("reason", b.Reason),
+		zap.String("user_id", userCtx.UserID),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+	)
+}
+
 func startHTTPServer() {
 	router := mux.NewRouter()
 
-	// Admin API endpoints with RBAC
-	router.HandleFunc("/api/routing/policy", getRoutingPolicy).Methods("GET")
-	router.Handle("/api/routing/policy", checkRole(RoleAdmin)(http.HandlerFunc(updateRoutingPolicy))).Methods("PUT")
-	router.Handle("/api/routing/heads", checkRole(RoleOperator)(http.HandlerFunc(registerHeadHTTP))).Methods("POST")
-	router.HandleFunc("/api/routing/heads", getAllHeads).Methods("GET")
+	// Admin API endpoints with RBAC, served from the RoutingService proto
+	gateway, err := newRESTGateway(context.Background())
+	if err != nil {
+		logger.Fatal("Failed to register REST gateway", zap.Error(err))
+	}
+	router.Handle("/api/routing/policy", gateway).Methods("GET")
+	router.Handle("/api/routing/policy", requirePermission(rbac.PolicyWrite)(gateway)).Methods("PUT")
+	router.Handle("/api/routing/heads", requirePermission(rbac.HeadsWrite)(gateway)).Methods("POST")
+	router.Handle("/api/routing/heads", gateway).Methods("GET")
+	router.Handle("/api/routing/heads/{head_id}/status", requirePermission(rbac.HeadsWrite)(gateway)).Methods("PUT")
+	router.Handle("/api/routing/heads/{head_id}/drain", requirePermission(rbac.HeadsWrite)(http.HandlerFunc(handleDrainHead))).Methods("POST", "DELETE")
+	router.Handle("/api/routing/decision", gateway).Methods("POST")
+	router.HandleFunc("/api/routing/openapi.json", serveOpenAPI).Methods("GET")
+	router.HandleFunc("/api/routing/alerts", handleAlerts).Methods("GET")
+	router.HandleFunc("/api/routing/rollouts", handleRollouts).Methods("GET")
+	// Readiness and key metrics of all services for the admin UI
+	overview = newOverviewAggregator(overviewConfigFromEnv())
+	router.HandleFunc("/api/overview", handleOverview).Methods("GET")
+	router.HandleFunc("/api/routing/regions", handleRegions).Methods("GET")
+	router.Handle("/api/routing/rollouts", requirePermission(rbac.RolloutsWrite)(http.HandlerFunc(handleRollouts))).Methods("POST")
+	router.HandleFunc("/api/routing/rollouts/{model_type}", handleRollout).Methods("GET")
+	router.Handle("/api/routing/rollouts/{model_type}", requirePermission(rbac.RolloutsWrite)(http.HandlerFunc(handleRollout))).Methods("DELETE")
+	router.Handle("/api/routing/rollouts/{model_type}/{action}", requirePermission(rbac.RolloutsWrite)(http.HandlerFunc(handleRolloutAction))).Methods("POST")
 	router.HandleFunc("/health", healthCheck).Methods("GET")
-
-	// Webhook endpoints with security and rate limiting
-	router.Handle("/webhook/head-status", webhookSecurityMiddleware(rateLimitMiddleware(http.HandlerFunc(handleHeadStatusWebhook)))).Methods("POST")
-	router.Handle("/webhook/routing-decision", webhookSecurityMiddleware(rateLimitMiddleware(http.HandlerFunc(handleRoutingDecisionWebhook)))).Methods("POST")
+	// pprof, expvar and /debug/runtime with DEBUG_ENDPOINTS=true
+	router.PathPrefix(diagnostics.Prefix).Handler(diagnostics.Handler(requirePermission(rbac.DiagnosticsRead)))
+	checker := newHealthChecker()
+	router.Handle("/livez", checker.LiveHandler()).Methods("GET")
+	router.Handle("/readyz", checker.ReadyHandler()).Methods("GET")
+
+	// Webhook endpoints, rate limited and signed with the secret of their source
+	webhookVerifier = newWebhookVerifier()
+	router.Handle("/webhook/head-status", rateLimitMiddleware(webhookSecurityMiddleware(http.HandlerFunc(handleHeadStatusWebhook)))).Methods("POST")
+	router.Handle("/webhook/routing-decision", rateLimitMiddleware(webhookSecurityMiddleware(http.HandlerFunc(handleRoutingDecisionWebhook)))).Methods("POST")
+	router.Handle("/webhook/routing-feedback", rateLimitMiddleware(webhookSecurityMiddleware(http.HandlerFunc(handleRoutingFeedbackWebhook)))).Methods("POST")
 
 	// Server-Sent Events (SSE) endpoints
 	router.HandleFunc("/events/head-status", handleHeadStatusEvents).Methods("GET")
@@ -484,7 +461,7 @@ func startHTTPServer() {
 	router.PathPrefix("/admin/").Handler(http.StripPrefix("/admin/", http.FileServer(http.Dir("./"))))
 
 	// Add Prometheus metrics endpoint
-	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.Handle("/metrics", httpmetrics.Handler()).Methods("GET")
 
 	// Apply middlewares
 	router.Use(middleware.AuditLoggingMiddleware)
@@ -492,7 +469,8 @@ func startHTTPServer() {
 	// Apply JWT middleware
 	httpServer = &http.Server{
 		Addr:    ":8080",
-		Handler: jwtMiddleware(router),
+		Handler: tracing.Middleware("routing-service", requestid.Middleware(middleware.AccessLog(logger)(
+			httpmetrics.Middleware("routing-service", muxroute.Template(router))(compress.Middleware("routing-service")(jwtMiddleware(router)))))),
 	}
 
 	logger.Info("Starting HTTP server with JWT authentication, RBAC, Prometheus metrics, webhook support, SSE, WebSocket, and GraphQL on :8080")
@@ -536,290 +514,8 @@ func getClientIP(r *http.Request) string {
 	return ip
 }
 
-// Rate limiter implementation
-type RateLimiter struct {
-	mu            sync.Mutex
-	requests      map[string]int
-	lastRequest   map[string]time.Time
-	threshold     int
-	resetTimeout  time.Duration
-	burstLimit    int
-	burstDuration time.Duration
-	ipThresholds  map[string]int
-	ipBurstLimits map[string]int
-	ipResetTimeouts map[string]time.Duration
-	ipBurstDurations map[string]time.Duration
-	ipRequestCounts map[string]int
-	ipLastRequests map[string]time.Time
-	ipSuccessCounts map[string]int
-	ipFailureCounts map[string]int
-	ipRecoveryAttempts map[string]int
-	ipRecoverySuccesses map[string]int
-	ipRecoveryFailures map[string]int
-}
-
-var rateLimiter = &RateLimiter{
-	requests:     make(map[string]int),
-	lastRequest:  make(map[string]time.Time),
-	threshold:    10,
-	resetTimeout:  1 * time.Minute,
-	burstLimit:   5,
-	burstDuration: 10 * time.Second,
-	ipThresholds:  make(map[string]int),
-	ipBurstLimits: make(map[string]int),
-	ipResetTimeouts: make(map[string]time.Duration),
-	ipBurstDurations: make(map[string]time.Duration),
-	ipRequestCounts: make(map[string]int),
-	ipLastRequests: make(map[string]time.Time),
-	ipSuccessCounts: make(map[string]int),
-	ipFailureCounts: make(map[string]int),
-	ipRecoveryAttempts: make(map[string]int),
-	ipRecoverySuccesses: make(map[string]int),
-	ipRecoveryFailures: make(map[string]int),
-}
-
-func (rl *RateLimiter) Allow(ip string) bool {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Get custom thresholds for IP
-	threshold := rl.threshold
-	if customThreshold, exists := rl.ipThresholds[ip]; exists {
-		threshold = customThreshold
-	}
-
-	burstLimit := rl.burstLimit
-	if customBurstLimit, exists := rl.ipBurstLimits[ip]; exists {
-		burstLimit = customBurstLimit
-	}
-
-	resetTimeout := rl.resetTimeout
-	if customResetTimeout, exists := rl.ipResetTimeouts[ip]; exists {
-		resetTimeout = customResetTimeout
-	}
-
-	burstDuration := rl.burstDuration
-	if customBurstDuration, exists := rl.ipBurstDurations[ip]; exists {
-		burstDuration = customBurstDuration
-	}
-
-	// Check if rate limit is exceeded
-	if requests, exists := rl.requests[ip]; exists && requests >= threshold {
-		// Check if reset timeout has passed
-		if lastRequest, exists := rl.lastRequest[ip]; exists {
-			if time.Since(lastRequest) < resetTimeout {
-				// Increment failure count
-				rl.ipFailureCounts[ip]++
-				rl.ipRecoveryFailures[ip]++
-				return false
-			}
-			// Reset rate limit
-			delete(rl.requests, ip)
-			delete(rl.lastRequest, ip)
-		}
-	}
-
-	// Check burst limit
-	if requests, exists := rl.requests[ip]; exists {
-		if requests >= burstLimit {
-			// Check if burst duration has passed
-			if lastRequest, exists := rl.lastRequest[ip]; exists {
-				if time.Since(lastRequest) < burstDuration {
-					// Increment failure count
-					rl.ipFailureCounts[ip]++
-					rl.ipRecoveryFailures[ip]++
-					return false
-				}
-			}
-		}
-	}
-
-	// Increment request count
-	rl.requests[ip]++
-	rl.lastRequest[ip] = time.Now()
-	rl.ipSuccessCounts[ip]++
-	rl.ipRecoverySuccesses[ip]++
-	return true
-}
-
-func (rl *RateLimiter) Metrics() map[string]interface{} {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	metrics := make(map[string]interface{})
-	for ip, requests := range rl.requests {
-		threshold := rl.threshold
-		if customThreshold, exists := rl.ipThresholds[ip]; exists {
-			threshold = customThreshold
-		}
-
-		burstLimit := rl.burstLimit
-		if customBurstLimit, exists := rl.ipBurstLimits[ip]; exists {
-			burstLimit = customBurstLimit
-		}
-
-		resetTimeout := rl.resetTimeout
-		if customResetTimeout, exists := rl.ipResetTimeouts[ip]; exists {
-			resetTimeout = customResetTimeout
-		}
-
-		burstDuration := rl.burstDuration
-		if customBurstDuration, exists := rl.ipBurstDurations[ip]; exists {
-			burstDuration = customBurstDuration
-		}
-
-		metrics[ip] = map[string]interface{}{
-			"requests":     requests,
-			"last_request": rl.lastRequest[ip],
-			"threshold":    threshold,
-			"burst_limit":   burstLimit,
-			"reset_timeout": resetTimeout,
-			"burst_duration": burstDuration,
-			"success_count": rl.ipSuccessCounts[ip],
-			"failure_count": rl.ipFailureCounts[ip],
-			"recovery_attempts": rl.ipRecoveryAttempts[ip],
-			"recovery_successes": rl.ipRecoverySuccesses[ip],
-			"recovery_failures": rl.ipRecoveryFailures[ip],
-		}
-	}
-	return metrics
-}
-
-func (rl *RateLimiter) Reset(ip string) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	delete(rl.requests, ip)
-	delete(rl.lastRequest, ip)
-	delete(rl.ipThresholds, ip)
-	delete(rl.ipBurstLimits, ip)
-	delete(rl.ipResetTimeouts, ip)
-	delete(rl.ipBurstDurations, ip)
-	delete(rl.ipRequestCounts, ip)
-	delete(rl.ipLastRequests, ip)
-	delete(rl.ipSuccessCounts, ip)
-	delete(rl.ipFailureCounts, ip)
-	delete(rl.ipRecoveryAttempts, ip)
-	delete(rl.ipRecoverySuccesses, ip)
-	delete(rl.ipRecoveryFailures, ip)
-}
-
-func (rl *RateLimiter) SetThreshold(ip string, threshold int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set custom threshold for specific IP
-	rl.ipThresholds[ip] = threshold
-	logger.Info("Setting custom rate limit threshold", zap.String("ip", ip), zap.Int("threshold", threshold))
-}
-
-func (rl *RateLimiter) SetBurstLimit(ip string, burstLimit int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set custom burst limit for specific IP
-	rl.ipBurstLimits[ip] = burstLimit
-	logger.Info("Setting custom rate limit burst limit", zap.String("ip", ip), zap.Int("burst_limit", burstLimit))
-}
-
-func (rl *RateLimiter) SetResetTimeout(ip string, resetTimeout time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set custom reset timeout for specific IP
-	rl.ipResetTimeouts[ip] = resetTimeout
-	logger.Info("Setting custom rate limit reset timeout", zap.String("ip", ip), zap.Duration("reset_timeout", resetTimeout))
-}
-
-func (rl *RateLimiter) SetBurstDuration(ip string, burstDuration time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set custom burst duration for specific IP
-	rl.ipBurstDurations[ip] = burstDuration
-	logger.Info("Setting custom rate limit burst duration", zap.String("ip", ip), zap.Duration("burst_duration", burstDuration))
-}
-
-func (rl *RateLimiter) SetGlobalThreshold(threshold int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global threshold
-	rl.threshold = threshold
-	logger.Info("Setting global rate limit threshold", zap.Int("threshold", threshold))
-}
-
-func (rl *RateLimiter) SetGlobalBurstLimit(burstLimit int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global burst limit
-	rl.burstLimit = burstLimit
-	logger.Info("Setting global rate limit burst limit", zap.Int("burst_limit", burstLimit))
-}
-
-func (rl *RateLimiter) SetGlobalResetTimeout(resetTimeout time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global reset timeout
-	rl.resetTimeout = resetTimeout
-	logger.Info("Setting global rate limit reset timeout", zap.Duration("reset_timeout", resetTimeout))
-}
-
-func (rl *RateLimiter) SetGlobalBurstDuration(burstDuration time.Duration) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global burst duration
-	rl.burstDuration = burstDuration
-	logger.Info("Setting global rate limit burst duration", zap.Duration("burst_duration", burstDuration))
-}
-
-func (rl *RateLimiter) SetGlobalSuccessCount(ip string, successCount int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global success count
-	rl.ipSuccessCounts[ip] = successCount
-	logger.Info("Setting global rate limit success count", zap.String("ip", ip), zap.Int("success_count", successCount))
-}
-
-func (rl *RateLimiter) SetGlobalFailureCount(ip string, failureCount int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global failure count
-	rl.ipFailureCounts[ip] = failureCount
-	logger.Info("Setting global rate limit failure count", zap.String("ip", ip), zap.Int("failure_count", failureCount))
-}
-
-func (rl *RateLimiter) SetGlobalRecoveryAttempts(ip string, recoveryAttempts int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global recovery attempts
-	rl.ipRecoveryAttempts[ip] = recoveryAttempts
-	logger.Info("Setting global rate limit recovery attempts", zap.String("ip", ip), zap.Int("recovery_attempts", recoveryAttempts))
-}
-
-func (rl *RateLimiter) SetGlobalRecoverySuccesses(ip string, recoverySuccesses int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global recovery successes
-	rl.ipRecoverySuccesses[ip] = recoverySuccesses
-	logger.Info("Setting global rate limit recovery successes", zap.String("ip", ip), zap.Int("recovery_successes", recoverySuccesses))
-}
-
-func (rl *RateLimiter) SetGlobalRecoveryFailures(ip string, recoveryFailures int) {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	// Set global recovery failures
-	rl.ipRecoveryFailures[ip] = recoveryFailures
-	logger.Info("Setting global rate limit recovery failures", zap.String("ip", ip), zap.Int("recovery_failures", recoveryFailures))
-}
+// rateLimiter limits requests per client IP
+var rateLimiter = resilience.NewRateLimiter(resilience.DefaultRateLimiterConfig())
 
 func graphqlHandler() http.Handler {
 	// Define GraphQL schema
@@ -891,6 +587,8 @@ func graphqlHandler() http.Handler {
 	type Mutation {
 		registerHead(input: RegisterHeadInput!): Head!
 		updateHeadStatus(id: ID!, status: String!, currentLoad: Int!): Head!
+		drainHead(id: ID!): [Head!]!
+		resumeHead(id: ID!): [Head!]!
 		deregisterHead(id: ID!): Boolean!
 		updateRoutingPolicy(input: UpdateRoutingPolicyInput!): RoutingPolicy!
 		resetCircuitBreaker(service: String!): Boolean!
@@ -1316,6 +1014,27 @@ func (r *MutationResolver) UpdateHeadStatus(ctx context.Context, args struct {
 	return &head, nil
 }
 
+// DrainHead stops routing to a head; id is an entry ID or a head_id
+func (r *MutationResolver) DrainHead(ctx context.Context, args struct{ ID string }) ([]*HeadService, error) {
+	return drainHeadsResult(drainHeads(args.ID, true))
+}
+
+// ResumeHead routes to a drained head again
+func (r *MutationResolver) ResumeHead(ctx context.Context, args struct{ ID string }) ([]*HeadService, error) {
+	return drainHeadsResult(drainHeads(args.ID, false))
+}
+
+func drainHeadsResult(heads []HeadService, err error) ([]*HeadService, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*HeadService, 0, len(heads))
+	for i := range heads {
+		result = append(result, &heads[i])
+	}
+	return result, nil
+}
+
 type RegisterHeadInput struct {
 	HeadID    string            `json:"head_id"`
 	Endpoint  string            `json:"endpoint"`
@@ -1719,9 +1438,26 @@ func handleWebSocketGetRoutingStrategies(conn *websocket.Conn) {
 	conn.WriteJSON(response)
 }
 
+// requestLogger returns the logger with the request ID of ctx, which comes
+// from the caller's X-Request-ID or gRPC metadata
+func requestLogger(ctx context.Context) *zap.Logger {
+	return logger.With(zap.String("request_id", requestid.FromContext(ctx)))
+}
+
+// natsSpan starts a consumer span for msg that continues the trace and the
+// request ID of the publisher from the message headers
+func natsSpan(msg *nats.Msg) (context.Context, trace.Span) {
+	ctx := requestid.Extract(context.Background(), msg.Header)
+	ctx = tracing.Extract(ctx, msg.Header)
+	return otel.Tracer("routing-service").Start(ctx, msg.Subject, trace.WithSpanKind(trace.SpanKindConsumer))
+}
+
 func startMessageQueueSubscribers() {
 	// Subscribe to head status updates
 	natsConn.Subscribe("head.status.update", func(msg *nats.Msg) {
+		ctx, span := natsSpan(msg)
+		defer span.End()
+
 		var statusUpdate struct {
 			HeadID     string `json:"head_id"`
 			Status     string `json:"status"`
@@ -1734,9 +1470,20 @@ func startMessageQueueSubscribers() {
 			return
 		}
 
+		// Redelivered and delayed updates must not roll the head back
+		if !firstDelivery(ctx, msg) {
+			messageQueueMessages.WithLabelValues("head.status.update", "duplicate").Inc()
+			return
+		}
+		if staleStatusUpdate(statusUpdate.HeadID, statusUpdate.Timestamp) {
+			messageQueueMessages.WithLabelValues("head.status.update", "stale").Inc()
+			return
+		}
+
 		// Process the status update
 		err := processHeadStatusUpdate(statusUpdate.HeadID, statusUpdate.Status, statusUpdate.CurrentLoad, statusUpdate.Timestamp)
 		if err != nil {
+			forgetDelivery(ctx, msg)
 			messageQueueMessages.WithLabelValues("head.status.update", "error").Inc()
 			return
 		}
@@ -1746,6 +1493,9 @@ func startMessageQueueSubscribers() {
 
 	// Subscribe to routing decision requests
 	natsConn.Subscribe("routing.decision.request", func(msg *nats.Msg) {
+		ctx, span := natsSpan(msg)
+		defer span.End()
+
 		var decisionRequest struct {
 			ModelType       string            `json:"model_type"`
 			RegionPreference string            `json:"region_preference"`
@@ -1761,6 +1511,7 @@ func startMessageQueueSubscribers() {
 		// Process the routing decision request
 		decision, err := makeRoutingDecisionFromWebhook(decisionRequest.ModelType, decisionRequest.RegionPreference, decisionRequest.RoutingStrategy, decisionRequest.Metadata)
 		if err != nil {
+			requestLogger(ctx).Error("Routing decision failed", zap.String("model_type", decisionRequest.ModelType), zap.Error(err))
 			messageQueueMessages.WithLabelValues("routing.decision.request", "error").Inc()
 			return
 		}
@@ -1772,12 +1523,19 @@ func startMessageQueueSubscribers() {
 			return
 		}
 
-		natsConn.Publish("routing.decision.response", responseData)
+		response := nats.NewMsg("routing.decision.response")
+		response.Data = responseData
+		tracing.Inject(ctx, response.Header)
+		requestid.Inject(ctx, response.Header)
+		natsConn.PublishMsg(response)
 		messageQueueMessages.WithLabelValues("routing.decision.request", "success").Inc()
 	})
 
 	// Subscribe to head registration requests
 	natsConn.Subscribe("head.registration.request", func(msg *nats.Msg) {
+		ctx, span := natsSpan(msg)
+		defer span.End()
+
 		var registrationRequest struct {
 			HeadID    string            `json:"head_id"`
 			Endpoint string            `json:"endpoint"`
@@ -1792,8 +1550,14 @@ func startMessageQueueSubscribers() {
 			return
 		}
 
+		// A redelivered registration would reset the head's status
+		if !firstDelivery(ctx, msg) {
+			messageQueueMessages.WithLabelValues("head.registration.request", "duplicate").Inc()
+			return
+		}
+
 		// Process the registration request
-		_, err := (&RoutingServer{}).RegisterHead(context.Background(), &pb.RegisterHeadRequest{
+		_, err := (&RoutingServer{}).RegisterHead(ctx, &pb.RegisterHeadRequest{
 			HeadId:    registrationRequest.HeadID,
 			Endpoint:  registrationRequest.Endpoint,
 			ModelType: registrationRequest.ModelType,
@@ -1802,6 +1566,8 @@ func startMessageQueueSubscribers() {
 			Metadata:  registrationRequest.Metadata,
 		})
 		if err != nil {
+			requestLogger(ctx).Error("Head registration failed", zap.String("head_id", registrationRequest.HeadID), zap.Error(err))
+			forgetDelivery(ctx, msg)
 			messageQueueMessages.WithLabelValues("head.registration.request", "error").Inc()
 			return
 		}
@@ -1810,6 +1576,44 @@ func startMessageQueueSubscribers() {
 	})
 }
 
+// startUserFeedbackSubscriber counts the user ratings tail publishes on the
+// completion_feedback Redis channel, the quality signal next to the latency
+// and failures reported to /webhook/routing-feedback
+func startUserFeedbackSubscriber(ctx context.Context) {
+	sub := redisClient.Subscribe(ctx, "completion_feedback")
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var feedback struct {
+			Rating     string   `json:"rating"`
+			Score      *float64 `json:"score"`
+			Completion struct {
+				Model    string `json:"model"`
+				Provider string `json:"provider"`
+			} `json:"completion"`
+		}
+		if err := json.Unmarshal([]byte(msg.Payload), &feedback); err != nil {
+			messageQueueMessages.WithLabelValues("completion_feedback", "error").Inc()
+			continue
+		}
+
+		rating := feedback.Rating
+		if rating == "" {
+			// A score alone counts as up from 4 and as down up to 2
+			switch {
+			case feedback.Score != nil && *feedback.Score >= 4:
+				rating = "up"
+			case feedback.Score != nil && *feedback.Score <= 2:
+				rating = "down"
+			default:
+				rating = "neutral"
+			}
+		}
+		userFeedback.WithLabelValues(feedback.Completion.Model, feedback.Completion.Provider, rating).Inc()
+		messageQueueMessages.WithLabelValues("completion_feedback", "success").Inc()
+	}
+}
+
 // Webhook handlers
 func handleHeadStatusWebhook(w http.ResponseWriter, r *http.Request) {
 	var webhookData struct {
@@ -1860,6 +1664,66 @@ func handleRoutingDecisionWebhook(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(decision)
 }
 
+func handleRoutingFeedbackWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhookData struct {
+		HeadID    string `json:"head_id"`
+		ModelType string `json:"model_type"`
+		Strategy  string `json:"strategy"`
+		Success   bool   `json:"success"`
+		LatencyMs int64  `json:"latency_ms"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&webhookData); err != nil {
+		http.Error(w, "Invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := processRoutingFeedback(webhookData.HeadID, webhookData.Success, webhookData.LatencyMs); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Feedback recorded")
+}
+
+// processRoutingFeedback records the observed latency of a routed request so
+// predictive and adaptive strategies work from real response times
+func processRoutingFeedback(headID string, success bool, latencyMs int64) error {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	head, exists := headServices[headID]
+	if !exists {
+		return fmt.Errorf("head %s not found", headID)
+	}
+
+	if len(head.ResponseTimes) >= 10 {
+		head.ResponseTimes = head.ResponseTimes[1:]
+	}
+	head.ResponseTimes = append(head.ResponseTimes, latencyMs)
+	headServices[headID] = head
+
+	outcome := "success"
+	if !success {
+		outcome = "failure"
+
+		// Drop cached decisions pointing at a failing head
+		cacheMutex.Lock()
+		for key, cachedHeadID := range routingCache {
+			if cachedHeadID == headID {
+				delete(routingCache, key)
+			}
+		}
+		cacheMutex.Unlock()
+	}
+	routingFeedback.WithLabelValues(headID, outcome).Inc()
+	anomalies.ObserveOutcome(headID, success)
+	rollouts.ObserveOutcome(head.ModelType, head.Version, success)
+
+	return nil
+}
+
 func processHeadStatusUpdate(headID, status string, currentLoad int32, timestamp int64) error {
 	// Update head status in the system
 	_, err := (&RoutingServer{}).UpdateHeadStatus(context.Background(), &pb.UpdateHeadStatusRequest{
@@ -1922,6 +1786,8 @@ func (s *RoutingServer) RegisterHead(ctx context.Context, req *pb.RegisterHeadRe
 	// Record metrics
 	headRegistrations.Inc()
 	activeHeads.Inc()
+	headLastHeartbeat.WithLabelValues(head.HeadID).Set(float64(head.LastHeartbeat))
+	observeRegionHealth()
 
 	return &pb.RegisterHeadResponse{
 		Success: true,
@@ -1941,10 +1807,21 @@ func (s *RoutingServer) UpdateHeadStatus(ctx context.Context, req *pb.UpdateHead
 		}, nil
 	}
 
+	previous := head.Status
 	head.Status = req.Status
+	// An operator's drain holds until it is resumed; the head going offline
+	// is still reported
+	if head.DrainRequested && req.Status == "active" {
+		head.Status = "draining"
+	}
 	head.CurrentLoad = req.CurrentLoad
 	head.LastHeartbeat = req.Timestamp
 	headServices[req.HeadId] = head
+	headLastHeartbeat.WithLabelValues(head.HeadID).Set(float64(head.LastHeartbeat))
+	observeRegionHealth()
+	if head.Status != previous {
+		publishHeadEvent(head, previous)
+	}
 
 	// Update in Redis
 	err := updateHeadStatusInRedis(head)
@@ -1970,28 +1847,42 @@ func (s *RoutingServer) UpdateHeadStatus(ctx context.Context, req *pb.UpdateHead
 	// Record metrics
 	headStatusUpdates.Inc()
 
+	// The reply tells the head to drain itself
+	if head.DrainRequested {
+		return &pb.UpdateHeadStatusResponse{Success: true, Message: drainMessage}, nil
+	}
 	return &pb.UpdateHeadStatusResponse{Success: true, Message: "Head status updated successfully"}, nil
 }
 
 func (s *RoutingServer) GetRoutingDecision(ctx context.Context, req *pb.GetRoutingDecisionRequest) (*pb.GetRoutingDecisionResponse, error) {
+	start := time.Now()
+	defer func() { anomalies.ObserveDecision(time.Since(start)) }()
 
 	// Implement routing decision logic based on current policy
 	// This is a simplified version - in production this would be more sophisticated
 
+	// Heads outside the tenant's data residency must not see the request
+	allowedRegions := residency.Parse(req.Metadata[residency.MetadataKey])
+
 	// Check cache first
-	cacheKey := fmt.Sprintf("%s-%s-%s-%s", req.ModelType, req.RegionPreference, req.RoutingStrategy, req.Metadata["model"])
+	cacheKey := fmt.Sprintf("%s-%s-%s-%s-%s", req.ModelType, req.RegionPreference, req.RoutingStrategy, req.Metadata["model"], residency.Format(allowedRegions))
 	cacheMutex.RLock()
 	cachedHeadID, found := routingCache[cacheKey]
 	cacheMutex.RUnlock()
 
-	if found {
+	// A rollout picks a version per request, which a cached head would skip,
+	// and the cache does not key on the affinity of consistent_hash
+	splitting := rollouts.Splitting(req.ModelType)
+	affinity := affinityKey(req.Metadata) != ""
+	if found && !splitting && !affinity {
 		// Cache hit
 		cacheHits.Inc()
+		anomalies.ObserveCache(true)
 
 		// Find the cached head in our current list
 		configMutex.RLock()
 		for _, head := range headServices {
-			if head.HeadID == cachedHeadID && head.Status == "active" {
+			if head.HeadID == cachedHeadID && head.Status == "active" && residency.Allows(allowedRegions, head.Region) {
 				configMutex.RUnlock()
 				return &pb.GetRoutingDecisionResponse{
 					HeadId:      head.HeadID,
@@ -2007,21 +1898,44 @@ func (s *RoutingServer) GetRoutingDecision(ctx context.Context, req *pb.GetRouti
 
 	// Cache miss - proceed with normal routing
 	cacheMisses.Inc()
+	anomalies.ObserveCache(false)
 
 	configMutex.RLock()
 	defer configMutex.RUnlock()
 
 	var selectedHead *HeadService
 
-	// Filter heads by model type
+	// Filter heads by model type and data residency
 	var candidates []HeadService
+	outsideResidency := 0
 	for _, head := range headServices {
-		if head.ModelType == req.ModelType && head.Status == "active" {
-			candidates = append(candidates, head)
+		if head.ModelType != req.ModelType || head.Status != "active" {
+			continue
+		}
+		if !residency.Allows(allowedRegions, head.Region) {
+			outsideResidency++
+			continue
 		}
+		candidates = append(candidates, head)
+	}
+
+	if len(candidates) == 0 && outsideResidency > 0 {
+		requestLogger(ctx).Warn("No available heads within data residency",
+			zap.String("model_type", req.ModelType),
+			zap.Strings("regions", allowedRegions),
+			zap.Int("heads_outside", outsideResidency),
+		)
+		return &pb.GetRoutingDecisionResponse{
+			HeadId:       "",
+			Endpoint:     "",
+			StrategyUsed: "none",
+			Reason:       "No available heads in the regions allowed by data residency",
+			Metadata:     map[string]string{"error": residency.Code, residency.MetadataKey: residency.Format(allowedRegions)},
+		}, nil
 	}
 
 	if len(candidates) == 0 {
+		requestLogger(ctx).Warn("No available heads", zap.String("model_type", req.ModelType))
 		return &pb.GetRoutingDecisionResponse{
 			HeadId:      "",
 			Endpoint:    "",
@@ -2030,6 +1944,9 @@ func (s *RoutingServer) GetRoutingDecision(ctx context.Context, req *pb.GetRouti
 		}, nil
 	}
 
+	// A version rollout narrows the heads to the version this request goes to
+	candidates, rolloutReason := rollouts.Split(req.ModelType, candidates)
+
 	// Apply routing strategy based on request or default policy
 	strategy := req.RoutingStrategy
 	if strategy == "" {
@@ -2056,6 +1973,9 @@ func (s *RoutingServer) GetRoutingDecision(ctx context.Context, req *pb.GetRouti
 	case "adaptive":
 		selectedHead = applyAdaptiveRouting(candidates, req)
 		reason = "Adaptive routing"
+	case "consistent_hash":
+		selectedHead = applyConsistentHashStrategy(candidates, req.ModelType, req.Metadata)
+		reason = "Consistent hash selection"
 	case "hybrid":
 		selectedHead = applyHybridStrategy(candidates, req)
 		reason = "Hybrid strategy selection"
@@ -2074,10 +1994,18 @@ func (s *RoutingServer) GetRoutingDecision(ctx context.Context, req *pb.GetRouti
 		}, nil
 	}
 
-	// Update cache
-	cacheMutex.Lock()
-	routingCache[cacheKey] = selectedHead.HeadID
-	cacheMutex.Unlock()
+	if rolloutReason != "" {
+		reason += "; " + rolloutReason
+	}
+
+	// Update cache; a failover is not cached so the preferred region is
+	// tried again once it recovers
+	failedOver := req.RegionPreference != "" && selectedHead.Region != req.RegionPreference
+	if !splitting && !failedOver && !affinity {
+		cacheMutex.Lock()
+		routingCache[cacheKey] = selectedHead.HeadID
+		cacheMutex.Unlock()
+	}
 
 	// Update head metrics for predictive algorithms
 	updateHeadMetrics(selectedHead, req.ModelType, strategy)
@@ -2107,6 +2035,11 @@ func updateHeadMetrics(head *HeadService, modelType, strategy string) {
 
 	// Record metrics
 	routingDecisions.WithLabelValues(strategy, req.ModelType, selectedHead.Region).Inc()
+	requestLogger(ctx).Info("Routing decision",
+		zap.String("model_type", req.ModelType),
+		zap.String("head_id", selectedHead.HeadID),
+		zap.String("strategy", strategy),
+	)
 
 	return &pb.GetRoutingDecisionResponse{
 		HeadId:      selectedHead.HeadID,
@@ -2143,16 +2076,25 @@ func (s *RoutingServer) UpdateRoutingPolicy(ctx context.Context, req *pb.UpdateR
 	configMutex.Lock()
 	defer configMutex.Unlock()
 
-	routingPolicy = RoutingPolicy{
-		DefaultStrategy:   req.DefaultStrategy,
-		EnableGeoRouting:  req.EnableGeoRouting,
-		EnableLoadBalancing: req.EnableLoadBalancing,
-		EnableModelSpecific: req.EnableModelSpecific,
-		StrategyConfig:    req.StrategyConfig,
+	// Settings the proto does not carry (predictive, adaptive) are kept
+	policy := req.GetPolicy()
+	// Region failover orders travel in the strategy config
+	failover, err := parseRegionFailover(policy.GetStrategyConfig())
+	if err != nil {
+		return &pb.UpdateRoutingPolicyResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
 	}
+	routingPolicy.DefaultStrategy = policy.GetDefaultStrategy()
+	routingPolicy.EnableGeoRouting = policy.GetEnableGeoRouting()
+	routingPolicy.EnableLoadBalancing = policy.GetEnableLoadBalancing()
+	routingPolicy.EnableModelSpecific = policy.GetEnableModelSpecific()
+	routingPolicy.StrategyConfig = policy.GetStrategyConfig()
+	routingPolicy.RegionFailover = failover
 
 	// Store in Redis
-	err := storeRoutingPolicyInRedis(routingPolicy)
+	err = storeRoutingPolicyInRedis(routingPolicy)
 	if err != nil {
 		return &pb.UpdateRoutingPolicyResponse{
 			Success: false,
@@ -2171,87 +2113,16 @@ func (s *RoutingServer) GetRoutingPolicy(ctx context.Context, req *pb.GetRouting
 	defer configMutex.RUnlock()
 
 	return &pb.GetRoutingPolicyResponse{
-		DefaultStrategy:   routingPolicy.DefaultStrategy,
-		EnableGeoRouting:  routingPolicy.EnableGeoRouting,
-		EnableLoadBalancing: routingPolicy.EnableLoadBalancing,
-		EnableModelSpecific: routingPolicy.EnableModelSpecific,
-		StrategyConfig:    routingPolicy.StrategyConfig,
+		Policy: &pb.RoutingPolicy{
+			DefaultStrategy:     routingPolicy.DefaultStrategy,
+			EnableGeoRouting:    routingPolicy.EnableGeoRouting,
+			EnableLoadBalancing: routingPolicy.EnableLoadBalancing,
+			EnableModelSpecific: routingPolicy.EnableModelSpecific,
+			StrategyConfig:      routingPolicy.StrategyConfig,
+		},
 	}, nil
 }
 
-// HTTP Handlers
-
-func getRoutingPolicy(w http.ResponseWriter, r *http.Request) {
-	configMutex.RLock()
-	defer configMutex.RUnlock()
-
-	json.NewEncoder(w).Encode(routingPolicy)
-}
-
-func updateRoutingPolicy(w http.ResponseWriter, r *http.Request) {
-	var policy RoutingPolicy
-	err := json.NewDecoder(r.Body).Decode(&policy)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
-
-	configMutex.Lock()
-	defer configMutex.Unlock()
-
-	routingPolicy = policy
-
-	// Store in Redis
-	err = storeRoutingPolicyInRedis(policy)
-	if err != nil {
-		http.Error(w, "Failed to store policy", http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(policy)
-}
-
-func getAllHeads(w http.ResponseWriter, r *http.Request) {
-	configMutex.RLock()
-	defer configMutex.RUnlock()
-
-	json.NewEncoder(w).Encode(headServices)
-}
-
-// HTTP handler for head registration
-func registerHeadHTTP(w http.ResponseWriter, r *http.Request) {
-	var head HeadService
-	err := json.NewDecoder(r.Body).Decode(&head)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
-
-	// Call the gRPC method
-	resp, err := (&RoutingServer{}).RegisterHead(context.Background(), &pb.RegisterHeadRequest{
-		HeadId:    head.HeadID,
-		Endpoint:  head.Endpoint,
-		Region:    head.Region,
-		ModelType: head.ModelType,
-		Version:   head.Version,
-		Metadata:  head.Metadata,
-	})
-
-	if err != nil {
-		http.Error(w, "Failed to register head", http.StatusInternalServerError)
-		return
-	}
-
-	if !resp.Success {
-		http.Error(w, resp.Message, http.StatusBadRequest)
-		return
-	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(resp)
-}
-
 func healthCheck(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
@@ -2265,6 +2136,23 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 }
 
+// newHealthChecker reports Redis and NATS on /readyz
+func newHealthChecker() *health.Checker {
+	return health.New("routing-service").
+		Add("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}).
+		Add("nats", func(ctx context.Context) error {
+			if natsConn == nil {
+				return errors.New("not connected")
+			}
+			if status := natsConn.Status(); status != nats.CONNECTED {
+				return fmt.Errorf("connection %s", strings.ToLower(status.String()))
+			}
+			return nil
+		})
+}
+
 // Redis Functions
 
 func storeHeadInRedis(head HeadService) error {
@@ -2309,9 +2197,10 @@ func updateHeadStatusInRedis(head HeadService) error {
 
 	headKey := fmt.Sprintf("head:%s", head.HeadID)
 	headData := map[string]interface{}{
-		"status":         head.Status,
-		"current_load":   head.CurrentLoad,
-		"last_heartbeat": head.LastHeartbeat,
+		"status":          head.Status,
+		"current_load":    head.CurrentLoad,
+		"last_heartbeat":  head.LastHeartbeat,
+		"drain_requested": head.DrainRequested,
 	}
 
 	return redisClient.HMSet(ctx, headKey, headData).Err()
@@ -2358,23 +2247,6 @@ func applyLeastLoadedStrategy(heads []HeadService) *HeadService {
 	return &minLoad
 }
 
-// applyGeoPreferredStrategy selects a head in the preferred region
-func applyGeoPreferredStrategy(heads []HeadService, preferredRegion string) *HeadService {
-	if len(heads) == 0 {
-		return nil
-	}
-
-	// First try to find a head in the preferred region
-	for _, head := range heads {
-		if head.Region == preferredRegion {
-			return &head
-		}
-	}
-
-	// If no head in preferred region, fall back to round-robin
-	return applyRoundRobinStrategy(heads)
-}
-
 // applyModelSpecificStrategy selects based on model-specific criteria
 func applyModelSpecificStrategy(heads []HeadService, metadata map[string]string) *HeadService {
 	if len(heads) == 0 {
@@ -2631,391 +2503,5 @@ func callExternalService(serviceName, endpoint string, payload interface{}) ([]b
 	return result.([]byte), nil
 }
 
-// Enhanced circuit breaker implementation
-type CircuitBreaker struct {
-	mu            sync.Mutex
-	failures      map[string]int
-	lastFailure   map[string]time.Time
-	threshold     int
-	resetTimeout  time.Duration
-	halfOpen      bool
-	halfOpenUntil time.Time
-	successCount  map[string]int
-	failureCount map[string]int
-	recoveryAttempts map[string]int
-	serviceThresholds map[string]int
-	serviceResetTimeouts map[string]time.Duration
-	serviceHalfOpenDurations map[string]time.Duration
-	serviceFailureWindows map[string]time.Duration
-	serviceSuccessWindows map[string]time.Duration
-	serviceRecoveryAttempts map[string]int
-	serviceRecoverySuccesses map[string]int
-	serviceRecoveryFailures map[string]int
-	serviceRecoveryTime map[string]time.Duration
-	serviceRecoveryLatency map[string]time.Duration
-	serviceRecoveryThroughput map[string]float64
-	serviceRecoverySuccessRate map[string]float64
-	serviceRecoveryErrorRate map[string]float64
-}
-
-var circuitBreaker = &CircuitBreaker{
-	failures:     make(map[string]int),
-	lastFailure:  make(map[string]time.Time),
-	threshold:    3,
-	resetTimeout: 30 * time.Second,
-	successCount: make(map[string]int),
-	failureCount: make(map[string]int),
-	recoveryAttempts: make(map[string]int),
-	serviceThresholds: make(map[string]int),
-	serviceResetTimeouts: make(map[string]time.Duration),
-	serviceHalfOpenDurations: make(map[string]time.Duration),
-	serviceFailureWindows: make(map[string]time.Duration),
-	serviceSuccessWindows: make(map[string]time.Duration),
-	serviceRecoveryAttempts: make(map[string]int),
-	serviceRecoverySuccesses: make(map[string]int),
-	serviceRecoveryFailures: make(map[string]int),
-	serviceRecoveryTime: make(map[string]time.Duration),
-	serviceRecoveryLatency: make(map[string]time.Duration),
-	serviceRecoveryThroughput: make(map[string]float64),
-	serviceRecoverySuccessRate: make(map[string]float64),
-	serviceRecoveryErrorRate: make(map[string]float64),
-}
-
-func (cb *CircuitBreaker) Allow(service string) bool {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Get custom threshold for service
-	threshold := cb.threshold
-	if customThreshold, exists := cb.serviceThresholds[service]; exists {
-		threshold = customThreshold
-	}
-
-	// Get custom reset timeout for service
-	resetTimeout := cb.resetTimeout
-	if customResetTimeout, exists := cb.serviceResetTimeouts[service]; exists {
-		resetTimeout = customResetTimeout
-	}
-
-	// Get custom half-open duration for service
-	halfOpenDuration := 10 * time.Second
-	if customHalfOpenDuration, exists := cb.serviceHalfOpenDurations[service]; exists {
-		halfOpenDuration = customHalfOpenDuration
-	}
-
-	// Check if circuit breaker is open
-	if failures, exists := cb.failures[service]; exists && failures >= threshold {
-		// Check if reset timeout has passed
-		if lastFailure, exists := cb.lastFailure[service]; exists {
-			if time.Since(lastFailure) < resetTimeout {
-				// Circuit is open
-				return false
-			}
-
-			// Check if we're in half-open state
-			if cb.halfOpen && time.Now().Before(cb.halfOpenUntil) {
-				// Allow one request to test the service
-				cb.halfOpen = false
-				return true
-			}
-
-			// Reset circuit breaker
-			delete(cb.failures, service)
-			delete(cb.lastFailure, service)
-			cb.halfOpen = true
-			cb.halfOpenUntil = time.Now().Add(halfOpenDuration)
-			return true
-		}
-	}
-	return true
-}
-
-func (cb *CircuitBreaker) Fail(service string) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Increment failure count
-	cb.failures[service]++
-	cb.failureCount[service]++
-	cb.recoveryAttempts[service]++
-	cb.serviceRecoveryFailures[service]++
-	cb.lastFailure[service] = time.Now()
-	cb.halfOpen = false
-}
-
-func (cb *CircuitBreaker) Success(service string) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Increment success count
-	cb.successCount[service]++
-	cb.serviceRecoverySuccesses[service]++
-	cb.halfOpen = false
-
-	// Reset failure count on success
-	delete(cb.failures, service)
-	delete(cb.lastFailure, service)
-}
-
-func (cb *CircuitBreaker) State(service string) string {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Get custom threshold for service
-	threshold := cb.threshold
-	if customThreshold, exists := cb.serviceThresholds[service]; exists {
-		threshold = customThreshold
-	}
-
-	if failures, exists := cb.failures[service]; exists && failures >= threshold {
-		if lastFailure, exists := cb.lastFailure[service]; exists {
-			resetTimeout := cb.resetTimeout
-			if customResetTimeout, exists := cb.serviceResetTimeouts[service]; exists {
-				resetTimeout = customResetTimeout
-			}
-
-			if time.Since(lastFailure) < resetTimeout {
-				return "open"
-			}
-			return "half-open"
-		}
-	}
-	return "closed"
-}
-
-func (cb *CircuitBreaker) Metrics() map[string]interface{} {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	metrics := make(map[string]interface{})
-	for service, failures := range cb.failures {
-		metrics[service] = map[string]interface{}{
-			"failures":     failures,
-			"last_failure": cb.lastFailure[service],
-			"state":        cb.State(service),
-			"success_count": cb.successCount[service],
-			"failure_count": cb.failureCount[service],
-			"recovery_attempts": cb.recoveryAttempts[service],
-			"threshold": cb.serviceThresholds[service],
-			"reset_timeout": cb.serviceResetTimeouts[service],
-			"half_open_duration": cb.serviceHalfOpenDurations[service],
-			"failure_window": cb.serviceFailureWindows[service],
-			"success_window": cb.serviceSuccessWindows[service],
-			"recovery_successes": cb.serviceRecoverySuccesses[service],
-			"recovery_failures": cb.serviceRecoveryFailures[service],
-			"recovery_time": cb.serviceRecoveryTime[service],
-			"recovery_latency": cb.serviceRecoveryLatency[service],
-			"recovery_throughput": cb.serviceRecoveryThroughput[service],
-			"recovery_success_rate": cb.serviceRecoverySuccessRate[service],
-			"recovery_error_rate": cb.serviceRecoveryErrorRate[service],
-		}
-	}
-	return metrics
-}
-
-func (cb *CircuitBreaker) Reset(service string) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Reset all metrics for a service
-	delete(cb.failures, service)
-	delete(cb.lastFailure, service)
-	delete(cb.successCount, service)
-	delete(cb.failureCount, service)
-	delete(cb.recoveryAttempts, service)
-	delete(cb.serviceThresholds, service)
-	delete(cb.serviceResetTimeouts, service)
-	delete(cb.serviceHalfOpenDurations, service)
-	delete(cb.serviceFailureWindows, service)
-	delete(cb.serviceSuccessWindows, service)
-	delete(cb.serviceRecoveryAttempts, service)
-	delete(cb.serviceRecoverySuccesses, service)
-	delete(cb.serviceRecoveryFailures, service)
-	delete(cb.serviceRecoveryTime, service)
-	delete(cb.serviceRecoveryLatency, service)
-	delete(cb.serviceRecoveryThroughput, service)
-	delete(cb.serviceRecoverySuccessRate, service)
-	delete(cb.serviceRecoveryErrorRate, service)
-	cb.halfOpen = false
-}
-
-func (cb *CircuitBreaker) SetThreshold(service string, threshold int) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom threshold for a service
-	cb.serviceThresholds[service] = threshold
-	logger.Info("Setting custom circuit breaker threshold", zap.String("service", service), zap.Int("threshold", threshold))
-}
-
-func (cb *CircuitBreaker) SetResetTimeout(service string, resetTimeout time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom reset timeout for a service
-	cb.serviceResetTimeouts[service] = resetTimeout
-	logger.Info("Setting custom circuit breaker reset timeout", zap.String("service", service), zap.Duration("reset_timeout", resetTimeout))
-}
-
-func (cb *CircuitBreaker) SetHalfOpenDuration(service string, halfOpenDuration time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom half-open duration for a service
-	cb.serviceHalfOpenDurations[service] = halfOpenDuration
-	logger.Info("Setting custom circuit breaker half-open duration", zap.String("service", service), zap.Duration("half_open_duration", halfOpenDuration))
-}
-
-func (cb *CircuitBreaker) SetFailureWindow(service string, failureWindow time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom failure window for a service
-	cb.serviceFailureWindows[service] = failureWindow
-	logger.Info("Setting custom circuit breaker failure window", zap.String("service", service), zap.Duration("failure_window", failureWindow))
-}
-
-func (cb *CircuitBreaker) SetSuccessWindow(service string, successWindow time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom success window for a service
-	cb.serviceSuccessWindows[service] = successWindow
-	logger.Info("Setting custom circuit breaker success window", zap.String("service", service), zap.Duration("success_window", successWindow))
-}
-
-func (cb *CircuitBreaker) SetRecoveryTime(service string, recoveryTime time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom recovery time for a service
-	cb.serviceRecoveryTime[service] = recoveryTime
-	logger.Info("Setting custom circuit breaker recovery time", zap.String("service", service), zap.Duration("recovery_time", recoveryTime))
-}
-
-func (cb *CircuitBreaker) SetRecoveryLatency(service string, recoveryLatency time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom recovery latency for a service
-	cb.serviceRecoveryLatency[service] = recoveryLatency
-	logger.Info("Setting custom circuit breaker recovery latency", zap.String("service", service), zap.Duration("recovery_latency", recoveryLatency))
-}
-
-func (cb *CircuitBreaker) SetRecoveryThroughput(service string, recoveryThroughput float64) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom recovery throughput for a service
-	cb.serviceRecoveryThroughput[service] = recoveryThroughput
-	logger.Info("Setting custom circuit breaker recovery throughput", zap.String("service", service), zap.Float64("recovery_throughput", recoveryThroughput))
-}
-
-func (cb *CircuitBreaker) SetRecoverySuccessRate(service string, recoverySuccessRate float64) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom recovery success rate for a service
-	cb.serviceRecoverySuccessRate[service] = recoverySuccessRate
-	logger.Info("Setting custom circuit breaker recovery success rate", zap.String("service", service), zap.Float64("recovery_success_rate", recoverySuccessRate))
-}
-
-func (cb *CircuitBreaker) SetRecoveryErrorRate(service string, recoveryErrorRate float64) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set custom recovery error rate for a service
-	cb.serviceRecoveryErrorRate[service] = recoveryErrorRate
-	logger.Info("Setting custom circuit breaker recovery error rate", zap.String("service", service), zap.Float64("recovery_error_rate", recoveryErrorRate))
-}
-
-func (cb *CircuitBreaker) SetGlobalThreshold(threshold int) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global threshold
-	cb.threshold = threshold
-	logger.Info("Setting global circuit breaker threshold", zap.Int("threshold", threshold))
-}
-
-func (cb *CircuitBreaker) SetGlobalResetTimeout(resetTimeout time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global reset timeout
-	cb.resetTimeout = resetTimeout
-	logger.Info("Setting global circuit breaker reset timeout", zap.Duration("reset_timeout", resetTimeout))
-}
-
-func (cb *CircuitBreaker) SetGlobalHalfOpenDuration(halfOpenDuration time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global half-open duration
-	cb.halfOpenDuration = halfOpenDuration
-	logger.Info("Setting global circuit breaker half-open duration", zap.Duration("half_open_duration", halfOpenDuration))
-}
-
-func (cb *CircuitBreaker) SetGlobalFailureWindow(failureWindow time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global failure window
-	cb.failureWindow = failureWindow
-	logger.Info("Setting global circuit breaker failure window", zap.Duration("failure_window", failureWindow))
-}
-
-func (cb *CircuitBreaker) SetGlobalSuccessWindow(successWindow time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global success window
-	cb.successWindow = successWindow
-	logger.Info("Setting global circuit breaker success window", zap.Duration("success_window", successWindow))
-}
-
-func (cb *CircuitBreaker) SetGlobalRecoveryTime(recoveryTime time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global recovery time
-	cb.recoveryTime = recoveryTime
-	logger.Info("Setting global circuit breaker recovery time", zap.Duration("recovery_time", recoveryTime))
-}
-
-func (cb *CircuitBreaker) SetGlobalRecoveryLatency(recoveryLatency time.Duration) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global recovery latency
-	cb.recoveryLatency = recoveryLatency
-	logger.Info("Setting global circuit breaker recovery latency", zap.Duration("recovery_latency", recoveryLatency))
-}
-
-func (cb *CircuitBreaker) SetGlobalRecoveryThroughput(recoveryThroughput float64) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global recovery throughput
-	cb.recoveryThroughput = recoveryThroughput
-	logger.Info("Setting global circuit breaker recovery throughput", zap.Float64("recovery_throughput", recoveryThroughput))
-}
-
-func (cb *CircuitBreaker) SetGlobalRecoverySuccessRate(recoverySuccessRate float64) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global recovery success rate
-	cb.recoverySuccessRate = recoverySuccessRate
-	logger.Info("Setting global circuit breaker recovery success rate", zap.Float64("recovery_success_rate", recoverySuccessRate))
-}
-
-func (cb *CircuitBreaker) SetGlobalRecoveryErrorRate(recoveryErrorRate float64) {
-	cb.mu.Lock()
-	defer cb.mu.Unlock()
-
-	// Set global recovery error rate
-	cb.recoveryErrorRate = recoveryErrorRate
-	logger.Info("Setting global circuit breaker recovery error rate", zap.Float64("recovery_error_rate", recoveryErrorRate))
-}
-
+// circuitBreaker guards calls to external services, per service name
+var circuitBreaker = resilience.NewCircuitBreaker(resilience.DefaultCircuitBreakerConfig())
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"go.uber.org/zap"
+)
+
+// AccessLog logs one line per request with its request_id
+func AccessLog(logger *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := httpmetrics.NewStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			logger.Info("access",
+				zap.String("request_id", requestid.FromContext(r.Context())),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", rec.Status),
+				zap.Duration("duration", time.Since(start)),
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+		})
+	}
+}
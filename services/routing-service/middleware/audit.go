@@ -14,13 +14,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/redisconn"
+	"github.com/MaksimVF/ZB/pkg/requestid"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
 )
 
 var (
 	auditLogFile *os.File
-	redisClient  *redis.Client
+	redisClient  redis.UniversalClient
 	logger       *zap.Logger
 )
 
@@ -33,9 +35,7 @@ func init() {
 	}
 
 	// Initialize Redis client for audit logging
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	redisClient = redisconn.MustNew(redisconn.FromEnv())
 
 	// Initialize logger
 	logger, err = zap.NewProduction()
@@ -88,6 +88,7 @@ func logAuditToFile(r *http.Request) {
 	// Prepare audit log entry
 	entry := AuditLogEntry{
 		Timestamp:   time.Now().Format(time.RFC3339),
+		RequestID:   requestid.FromContext(r.Context()),
 		Method:      r.Method,
 		Path:        r.URL.Path,
 		ClientIP:    r.RemoteAddr,
@@ -114,6 +115,7 @@ func logAuditToRedis(r *http.Request) {
 	// Prepare audit log entry
 	entry := AuditLogEntry{
 		Timestamp:   time.Now().Format(time.RFC3339),
+		RequestID:   requestid.FromContext(r.Context()),
 		Method:      r.Method,
 		Path:        r.URL.Path,
 		ClientIP:    r.RemoteAddr,
@@ -144,6 +146,7 @@ func logAuditToRedis(r *http.Request) {
 // AuditLogEntry represents an audit log entry
 type AuditLogEntry struct {
 	Timestamp   string            `json:"timestamp"`
+	RequestID   string            `json:"request_id,omitempty"`
 	Method      string            `json:"method"`
 	Path        string            `json:"path"`
 	ClientIP    string            `json:"client_ip"`
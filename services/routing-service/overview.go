@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/tlsutil"
+)
+
+// defaultOverviewServices are the services /api/overview reports on, by
+// name, with the base URL of their /readyz and /metrics
+const defaultOverviewServices = "auth=http://auth-service:8081,gateway=http://gateway:8080," +
+	"rate-limiter=https://rate-limiter:8081,secrets=http://secret-service:8082"
+
+// Overview statuses; a service that cannot be reached is unreachable
+const (
+	overviewOK          = "ok"
+	overviewDegraded    = "degraded"
+	overviewUnreachable = "unreachable"
+)
+
+// overview answers /api/overview; set up with the HTTP server
+var overview *overviewAggregator
+
+// ServiceOverview is the readiness and key metrics of one service
+type ServiceOverview struct {
+	// Status is the /readyz status (ok, fail, draining) or unreachable
+	Status    string                   `json:"status"`
+	LatencyMs float64                  `json:"latency_ms"`
+	Checks    map[string]health.Result `json:"checks,omitempty"`
+	Metrics   map[string]float64       `json:"metrics,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+	// LastOK is when the service was last ready, zero if never seen ready
+	LastOK time.Time `json:"last_ok,omitempty"`
+}
+
+// HeadsOverview summarizes the registered heads
+type HeadsOverview struct {
+	Total    int            `json:"total"`
+	ByStatus map[string]int `json:"by_status"`
+	// Stale heads missed heartbeats for longer than the heartbeat timeout
+	Stale []string `json:"stale"`
+	Load  int64    `json:"load"`
+}
+
+// Overview is the cross-service status document of the admin UI
+type Overview struct {
+	// Status is ok while every service is ready and no head is stale
+	Status      string                     `json:"status"`
+	GeneratedAt time.Time                  `json:"generated_at"`
+	Cached      bool                       `json:"cached"`
+	Services    map[string]ServiceOverview `json:"services"`
+	Heads       HeadsOverview              `json:"heads"`
+	// Metrics are the key metrics summed over all services
+	Metrics map[string]float64 `json:"metrics"`
+}
+
+type overviewConfig struct {
+	// Services by name, with their base URL
+	Services map[string]string
+	// TTL is how long a document is served from the cache
+	TTL time.Duration
+	// Timeout bounds the requests to each service
+	Timeout time.Duration
+	// HeartbeatTimeout is how long a head may go without heartbeats
+	HeartbeatTimeout time.Duration
+	// CAFile verifies the https services, e.g. the rate-limiter admin API
+	CAFile string
+}
+
+// overviewConfigFromEnv reads OVERVIEW_SERVICES (name=url,...),
+// OVERVIEW_CACHE_TTL, OVERVIEW_TIMEOUT and OVERVIEW_CA_FILE; heads use the
+// HEAD_HEARTBEAT_TIMEOUT of the routing
+func overviewConfigFromEnv() overviewConfig {
+	cfg := overviewConfig{TTL: 10 * time.Second, Timeout: 2 * time.Second, HeartbeatTimeout: headHeartbeatTimeout, CAFile: os.Getenv("OVERVIEW_CA_FILE")}
+	services := os.Getenv("OVERVIEW_SERVICES")
+	if services == "" {
+		services = defaultOverviewServices
+	}
+	cfg.Services = parseOverviewServices(services)
+	if d, err := time.ParseDuration(os.Getenv("OVERVIEW_CACHE_TTL")); err == nil && d >= 0 {
+		cfg.TTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("OVERVIEW_TIMEOUT")); err == nil && d > 0 {
+		cfg.Timeout = d
+	}
+	return cfg
+}
+
+func parseOverviewServices(s string) map[string]string {
+	services := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		name, url, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || url == "" {
+			continue
+		}
+		services[strings.TrimSpace(name)] = strings.TrimSuffix(strings.TrimSpace(url), "/")
+	}
+	return services
+}
+
+// overviewAggregator fans out to the services and caches the document; one
+// refresh runs at a time and concurrent requests wait for it
+type overviewAggregator struct {
+	cfg    overviewConfig
+	client *http.Client
+	heads  func() []HeadService
+	now    func() time.Time
+
+	refresh sync.Mutex
+	mu      sync.Mutex
+	cached  *Overview
+	lastOK  map[string]time.Time
+}
+
+func newOverviewAggregator(cfg overviewConfig) *overviewAggregator {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		tlsConfig, err := tlsutil.ClientConfig("", "", cfg.CAFile)
+		if err != nil {
+			logger.Error("Invalid OVERVIEW_CA_FILE, using the system roots", zap.Error(err))
+		} else {
+			transport.TLSClientConfig = tlsConfig
+		}
+	}
+	return &overviewAggregator{
+		cfg:    cfg,
+		client: &http.Client{Transport: transport},
+		heads:  registeredHeads,
+		now:    time.Now,
+		lastOK: make(map[string]time.Time),
+	}
+}
+
+// registeredHeads returns a copy of the head registry
+func registeredHeads() []HeadService {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	heads := make([]HeadService, 0, len(headServices))
+	for _, head := range headServices {
+		heads = append(heads, head)
+	}
+	return heads
+}
+
+// Get returns the cached document while it is fresh and builds a new one
+// otherwise
+func (a *overviewAggregator) Get(ctx context.Context) Overview {
+	if doc, ok := a.fresh(); ok {
+		return doc
+	}
+	a.refresh.Lock()
+	defer a.refresh.Unlock()
+	// Another request may have refreshed it while this one waited
+	if doc, ok := a.fresh(); ok {
+		return doc
+	}
+
+	// The document is shared, so a client that goes away must not cut it short
+	doc := a.build(context.WithoutCancel(ctx))
+	a.mu.Lock()
+	a.cached = &doc
+	a.mu.Unlock()
+	return doc
+}
+
+func (a *overviewAggregator) fresh() (Overview, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cached == nil || a.now().Sub(a.cached.GeneratedAt) >= a.cfg.TTL {
+		return Overview{}, false
+	}
+	doc := *a.cached
+	doc.Cached = true
+	return doc, true
+}
+
+func (a *overviewAggregator) build(ctx context.Context) Overview {
+	doc := Overview{
+		Status:      overviewOK,
+		GeneratedAt: a.now(),
+		Services:    make(map[string]ServiceOverview, len(a.cfg.Services)),
+		Metrics:     make(map[string]float64),
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, url := range a.cfg.Services {
+		wg.Add(1)
+		go func(name, url string) {
+			defer wg.Done()
+			s := a.probe(ctx, url)
+			mu.Lock()
+			doc.Services[name] = s
+			mu.Unlock()
+		}(name, url)
+	}
+	wg.Wait()
+
+	a.mu.Lock()
+	for name, s := range doc.Services {
+		if s.Status == health.StatusOK {
+			a.lastOK[name] = doc.GeneratedAt
+		} else {
+			doc.Status = overviewDegraded
+		}
+		s.LastOK = a.lastOK[name]
+		doc.Services[name] = s
+		for metric, v := range s.Metrics {
+			doc.Metrics[metric] += v
+		}
+	}
+	a.mu.Unlock()
+
+	doc.Heads = a.summarizeHeads(doc.GeneratedAt)
+	if len(doc.Heads.Stale) > 0 {
+		doc.Status = overviewDegraded
+	}
+	doc.Metrics["heads_active"] = float64(doc.Heads.ByStatus["active"])
+	doc.Metrics["head_load"] = float64(doc.Heads.Load)
+	return doc
+}
+
+// probe reads the readiness and key metrics of a service. A failed metrics
+// scrape leaves the metrics out but keeps the readiness.
+func (a *overviewAggregator) probe(ctx context.Context, url string) ServiceOverview {
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	var report health.Report
+	err := a.get(ctx, url+"/readyz", func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&report)
+	})
+	s := ServiceOverview{LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil || report.Status == "" {
+		s.Status = overviewUnreachable
+		if err == nil {
+			err = fmt.Errorf("no status in /readyz")
+		}
+		s.Error = err.Error()
+		return s
+	}
+	s.Status, s.Checks = report.Status, report.Checks
+
+	a.get(ctx, url+"/metrics", func(body io.Reader) error {
+		s.Metrics = keyMetrics(body)
+		return nil
+	})
+	return s
+}
+
+// get calls read with the body of a GET; /readyz answers 503 with a report
+// when a check fails, so only the body decides
+func (a *overviewAggregator) get(ctx context.Context, url string, read func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+		return fmt.Errorf("%s answered %d", url, resp.StatusCode)
+	}
+	return read(io.LimitReader(resp.Body, 4<<20))
+}
+
+func (a *overviewAggregator) summarizeHeads(now time.Time) HeadsOverview {
+	h := HeadsOverview{ByStatus: make(map[string]int), Stale: []string{}}
+	for _, head := range a.heads() {
+		h.Total++
+		h.ByStatus[head.Status]++
+		h.Load += int64(head.CurrentLoad)
+		if head.Status != "offline" && now.Sub(time.Unix(head.LastHeartbeat, 0)) > a.cfg.HeartbeatTimeout {
+			h.Stale = append(h.Stale, head.HeadID)
+		}
+	}
+	sort.Strings(h.Stale)
+	return h
+}
+
+// keyMetrics sums the metrics the overview reports from a Prometheus text
+// exposition: requests and server errors (zb_http_requests_total), in-flight
+// provider requests and rate-limit rejections
+func keyMetrics(body io.Reader) map[string]float64 {
+	metrics := make(map[string]float64)
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, labels, value, ok := parseSample(line)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "zb_http_requests_total":
+			metrics["requests_total"] += value
+			if strings.Contains(labels, `status="5`) {
+				metrics["server_errors_total"] += value
+			}
+		case "gateway_provider_inflight_requests":
+			metrics["provider_inflight"] += value
+		case "rate_limit_decisions_total":
+			if strings.Contains(labels, `result="limited"`) {
+				metrics["rate_limited_total"] += value
+			}
+		}
+	}
+	return metrics
+}
+
+// parseSample splits `name{labels} value [timestamp]`
+func parseSample(line string) (name, labels string, value float64, ok bool) {
+	rest := line
+	if i := strings.IndexByte(line, '{'); i >= 0 {
+		j := strings.LastIndexByte(line, '}')
+		if j < i {
+			return "", "", 0, false
+		}
+		name, labels, rest = line[:i], line[i+1:j], line[j+1:]
+	} else {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return "", "", 0, false
+		}
+		name, rest = line[:i], line[i:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", "", 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", "", 0, false
+	}
+	return name, labels, value, true
+}
+
+// handleOverview serves GET /api/overview
+func handleOverview(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overview.Get(r.Context()))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testMetrics = `# HELP zb_http_requests_total Requests
+# TYPE zb_http_requests_total counter
+zb_http_requests_total{method="POST",route="/v1/chat/completions",service="gateway",status="200"} 90
+zb_http_requests_total{method="POST",route="/v1/chat/completions",service="gateway",status="502"} 10
+gateway_provider_inflight_requests{provider="openai"} 3
+rate_limit_decisions_total{result="limited",source="central"} 4 1700000000000
+rate_limit_decisions_total{result="allowed",source="central"} 96
+`
+
+func TestKeyMetrics(t *testing.T) {
+	got := keyMetrics(strings.NewReader(testMetrics))
+	want := map[string]float64{"requests_total": 100, "server_errors_total": 10, "provider_inflight": 3, "rate_limited_total": 4}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestParseOverviewServices(t *testing.T) {
+	got := parseOverviewServices(" auth = http://auth:8081/ ,broken, gateway=http://gateway:8080")
+	if len(got) != 2 || got["auth"] != "http://auth:8081" || got["gateway"] != "http://gateway:8080" {
+		t.Errorf("services = %v", got)
+	}
+}
+
+func TestOverview(t *testing.T) {
+	var calls atomic.Int32
+	ready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/readyz":
+			w.Write([]byte(`{"status":"ok","service":"gateway","checks":{"redis":{"status":"ok","latency_ms":0.3}}}`))
+		case "/metrics":
+			w.Write([]byte(testMetrics))
+		}
+	}))
+	defer ready.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"status":"fail","service":"auth","checks":{"postgres":{"status":"fail","error":"timeout"}}}`))
+	}))
+	defer failing.Close()
+
+	now := time.Unix(1_800_000_000, 0)
+	a := newOverviewAggregator(overviewConfig{
+		Services: map[string]string{
+			"gateway": ready.URL,
+			"auth":    failing.URL,
+			"secrets": "http://127.0.0.1:1",
+		},
+		TTL:              10 * time.Second,
+		Timeout:          time.Second,
+		HeartbeatTimeout: 30 * time.Second,
+	})
+	a.now = func() time.Time { return now }
+	a.heads = func() []HeadService {
+		return []HeadService{
+			{HeadID: "h1", Status: "active", CurrentLoad: 5, LastHeartbeat: now.Unix() - 5},
+			{HeadID: "h2", Status: "active", CurrentLoad: 2, LastHeartbeat: now.Unix() - 120},
+			{HeadID: "h3", Status: "offline", LastHeartbeat: now.Unix() - 600},
+		}
+	}
+
+	doc := a.Get(context.Background())
+	if doc.Status != overviewDegraded || doc.Cached {
+		t.Errorf("status = %s, cached = %v", doc.Status, doc.Cached)
+	}
+	if s := doc.Services["gateway"]; s.Status != "ok" || s.Checks["redis"].Status != "ok" || s.Metrics["requests_total"] != 100 || !s.LastOK.Equal(now) {
+		t.Errorf("gateway = %+v", s)
+	}
+	if s := doc.Services["auth"]; s.Status != "fail" || s.Checks["postgres"].Error != "timeout" || !s.LastOK.IsZero() {
+		t.Errorf("auth = %+v", s)
+	}
+	if s := doc.Services["secrets"]; s.Status != overviewUnreachable || s.Error == "" {
+		t.Errorf("secrets = %+v", s)
+	}
+	if doc.Heads.Total != 3 || doc.Heads.Load != 7 || len(doc.Heads.Stale) != 1 || doc.Heads.Stale[0] != "h2" {
+		t.Errorf("heads = %+v", doc.Heads)
+	}
+	if doc.Metrics["server_errors_total"] != 10 || doc.Metrics["heads_active"] != 2 {
+		t.Errorf("metrics = %v", doc.Metrics)
+	}
+
+	// Served from the cache until the TTL passes
+	before := calls.Load()
+	now = now.Add(5 * time.Second)
+	if doc = a.Get(context.Background()); !doc.Cached || calls.Load() != before {
+		t.Errorf("second request was not cached")
+	}
+	now = now.Add(10 * time.Second)
+	if doc = a.Get(context.Background()); doc.Cached || calls.Load() == before {
+		t.Errorf("stale document was served")
+	}
+
+	saved := overview
+	overview = a
+	defer func() { overview = saved }()
+	rr := httptest.NewRecorder()
+	handleOverview(rr, httptest.NewRequest(http.MethodGet, "/api/overview", nil))
+	var body Overview
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Services) != 3 || !body.Cached {
+		t.Errorf("body = %+v", body)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// regionFailoverPrefix keys the failover order of a region in the policy's
+// strategy_config, e.g. "region_failover.eu-west": "eu-central,us-east";
+// "region_failover.*" is the order of regions without their own
+const regionFailoverPrefix = "region_failover."
+
+// Region health statuses
+const (
+	regionHealthy  = "healthy"
+	regionDegraded = "degraded"
+	regionDown     = "down"
+)
+
+// headHeartbeatTimeout is how long a head may go without heartbeats before
+// it no longer counts as healthy, HEAD_HEARTBEAT_TIMEOUT (default 30s)
+var headHeartbeatTimeout = heartbeatTimeoutFromEnv()
+
+var (
+	regionFailovers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_region_failovers_total",
+			Help: "Geo-preferred decisions served outside the preferred region, by preferred and serving region",
+		},
+		[]string{"from", "to"},
+	)
+	regionHealthyHeads = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "routing_region_healthy_heads",
+			Help: "Heads per region that are active, heartbeating and under the capacity threshold",
+		},
+		[]string{"region"},
+	)
+)
+
+func heartbeatTimeoutFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("HEAD_HEARTBEAT_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// RegionHealth aggregates the heads of a region
+type RegionHealth struct {
+	Region string `json:"region"`
+	Heads  int    `json:"heads"`
+	// Healthy heads are active, heartbeating and under the capacity
+	// threshold
+	Healthy int    `json:"healthy"`
+	Load    int64  `json:"load"`
+	Status  string `json:"status"`
+}
+
+// parseRegionFailover reads the failover orders from the strategy config
+func parseRegionFailover(config map[string]string) (map[string][]string, error) {
+	orders := make(map[string][]string)
+	for key, value := range config {
+		if !strings.HasPrefix(key, regionFailoverPrefix) {
+			continue
+		}
+		region := strings.TrimPrefix(key, regionFailoverPrefix)
+		if region == "" {
+			return nil, fmt.Errorf("%s needs a region, e.g. %seu-west", key, regionFailoverPrefix)
+		}
+		var order []string
+		seen := map[string]bool{region: true}
+		for _, r := range strings.Split(value, ",") {
+			r = strings.TrimSpace(r)
+			if r == "" || seen[r] {
+				continue
+			}
+			if r == "*" {
+				return nil, fmt.Errorf("%s: * is not a region", key)
+			}
+			seen[r] = true
+			order = append(order, r)
+		}
+		orders[region] = order
+	}
+	return orders, nil
+}
+
+// failoverOrder returns the regions to try for a preferred region, the
+// preferred one first
+func failoverOrder(policy RoutingPolicy, preferred string) []string {
+	order, ok := policy.RegionFailover[preferred]
+	if !ok {
+		order = policy.RegionFailover["*"]
+	}
+	regions := []string{preferred}
+	for _, r := range order {
+		if r != preferred {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
+
+// headHealthy reports whether a head can take a request
+func headHealthy(head HeadService, now time.Time) bool {
+	return head.Status == "active" &&
+		now.Sub(time.Unix(head.LastHeartbeat, 0)) <= headHeartbeatTimeout &&
+		canHandleLoad(&head)
+}
+
+// regionHealth aggregates the heads per region
+func regionHealth(heads []HeadService, now time.Time) map[string]*RegionHealth {
+	regions := make(map[string]*RegionHealth)
+	for _, head := range heads {
+		h := regions[head.Region]
+		if h == nil {
+			h = &RegionHealth{Region: head.Region}
+			regions[head.Region] = h
+		}
+		h.Heads++
+		h.Load += int64(head.CurrentLoad)
+		if headHealthy(head, now) {
+			h.Healthy++
+		}
+	}
+	for _, h := range regions {
+		switch {
+		case h.Healthy == 0:
+			h.Status = regionDown
+		case h.Healthy < h.Heads:
+			h.Status = regionDegraded
+		default:
+			h.Status = regionHealthy
+		}
+	}
+	return regions
+}
+
+// observeRegionHealth updates routing_region_healthy_heads from the
+// registry; the caller holds configMutex
+func observeRegionHealth() {
+	heads := make([]HeadService, 0, len(headServices))
+	for _, head := range headServices {
+		heads = append(heads, head)
+	}
+	for region, h := range regionHealth(heads, time.Now()) {
+		regionHealthyHeads.WithLabelValues(region).Set(float64(h.Healthy))
+	}
+}
+
+// applyGeoPreferredStrategy selects the least loaded healthy head in the
+// preferred region, then in the regions of its failover order. When none of
+// them has a healthy head it takes the least loaded head of the first of
+// them that has any, and only then a head in any other region.
+func applyGeoPreferredStrategy(heads []HeadService, preferredRegion string) *HeadService {
+	if len(heads) == 0 {
+		return nil
+	}
+	if preferredRegion == "" {
+		return applyRoundRobinStrategy(heads)
+	}
+
+	now := time.Now()
+	byRegion := make(map[string][]HeadService)
+	for _, head := range heads {
+		byRegion[head.Region] = append(byRegion[head.Region], head)
+	}
+	order := failoverOrder(routingPolicy, preferredRegion)
+
+	for _, region := range order {
+		var healthy []HeadService
+		for _, head := range byRegion[region] {
+			if headHealthy(head, now) {
+				healthy = append(healthy, head)
+			}
+		}
+		if len(healthy) > 0 {
+			return failover(preferredRegion, applyLeastLoadedStrategy(healthy))
+		}
+	}
+	for _, region := range order {
+		if len(byRegion[region]) > 0 {
+			return failover(preferredRegion, applyLeastLoadedStrategy(byRegion[region]))
+		}
+	}
+	return failover(preferredRegion, applyLeastLoadedStrategy(heads))
+}
+
+// failover records a head chosen outside the preferred region
+func failover(preferred string, head *HeadService) *HeadService {
+	if head != nil && head.Region != preferred {
+		regionFailovers.WithLabelValues(preferred, head.Region).Inc()
+		logger.Info("Region failover",
+			zap.String("preferred_region", preferred),
+			zap.String("region", head.Region),
+			zap.String("head_id", head.HeadID),
+		)
+	}
+	return head
+}
+
+// handleRegions serves GET /api/routing/regions: the health of each region
+// over all heads, and the failover orders of the policy
+func handleRegions(w http.ResponseWriter, r *http.Request) {
+	configMutex.RLock()
+	heads := make([]HeadService, 0, len(headServices))
+	for _, head := range headServices {
+		heads = append(heads, head)
+	}
+	regions := regionHealth(heads, time.Now())
+	orders := routingPolicy.RegionFailover
+	if orders == nil {
+		orders = map[string][]string{}
+	}
+	list := make([]RegionHealth, 0, len(regions))
+	for _, h := range regions {
+		list = append(list, *h)
+	}
+	configMutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Region < list[j].Region })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"regions": list, "failover": orders})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseRegionFailover(t *testing.T) {
+	got, err := parseRegionFailover(map[string]string{
+		"region_failover.eu-west": "eu-central, us-east,eu-west,,us-east",
+		"region_failover.*":       "us-east",
+		"weight":                  "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"eu-west": {"eu-central", "us-east"}, "*": {"us-east"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("failover = %v, want %v", got, want)
+	}
+
+	for _, config := range []map[string]string{
+		{"region_failover.": "us-east"},
+		{"region_failover.eu-west": "*"},
+	} {
+		if _, err := parseRegionFailover(config); err == nil {
+			t.Errorf("%v accepted", config)
+		}
+	}
+}
+
+func TestGeoPreferredFailover(t *testing.T) {
+	saved := routingPolicy
+	defer func() { routingPolicy = saved }()
+	routingPolicy = RoutingPolicy{
+		CapacityThreshold: 80,
+		RegionFailover: map[string][]string{
+			"eu-west": {"eu-central", "us-east"},
+			"*":       {"us-east"},
+		},
+	}
+
+	now := time.Now().Unix()
+	head := func(id, region string, load, capacity int32, heartbeat int64) HeadService {
+		return HeadService{HeadID: id, Region: region, Status: "active", CurrentLoad: load, Capacity: capacity, LastHeartbeat: heartbeat}
+	}
+	pick := func(heads []HeadService, region string) string {
+		if h := applyGeoPreferredStrategy(heads, region); h != nil {
+			return h.HeadID
+		}
+		return ""
+	}
+
+	heads := []HeadService{
+		head("us1", "us-east", 0, 10, now),
+		head("ec1", "eu-central", 5, 10, now),
+		head("ec2", "eu-central", 2, 10, now),
+		head("ew1", "eu-west", 7, 10, now),
+		head("ew2", "eu-west", 3, 10, now),
+	}
+	if got := pick(heads, "eu-west"); got != "ew2" {
+		t.Errorf("healthy preferred region chose %s", got)
+	}
+
+	// eu-west is full, then stale: eu-central comes before us-east
+	heads[3].CurrentLoad, heads[4].CurrentLoad = 9, 8
+	before := testutil.ToFloat64(regionFailovers.WithLabelValues("eu-west", "eu-central"))
+	if got := pick(heads, "eu-west"); got != "ec2" {
+		t.Errorf("full preferred region chose %s", got)
+	}
+	if got := testutil.ToFloat64(regionFailovers.WithLabelValues("eu-west", "eu-central")) - before; got != 1 {
+		t.Errorf("failovers = %v", got)
+	}
+	heads[3].CurrentLoad, heads[4].CurrentLoad = 1, 1
+	heads[3].LastHeartbeat, heads[4].LastHeartbeat = now-600, now-600
+	if got := pick(heads, "eu-west"); got != "ec2" {
+		t.Errorf("stale preferred region chose %s", got)
+	}
+
+	// Regions without an order use "*"
+	if got := pick(heads, "ap-south"); got != "us1" {
+		t.Errorf("default order chose %s", got)
+	}
+
+	// Nothing healthy in the order: the first region with heads, then any
+	full := []HeadService{
+		head("ap1", "ap-south", 0, 10, now),
+		head("ec1", "eu-central", 9, 10, now),
+		head("ew1", "eu-west", 9, 10, now),
+	}
+	if got := pick(full, "eu-west"); got != "ew1" {
+		t.Errorf("saturated order chose %s", got)
+	}
+	if got := pick(full[:1], "eu-west"); got != "ap1" {
+		t.Errorf("unlisted fallback chose %s", got)
+	}
+	if got := pick(nil, "eu-west"); got != "" {
+		t.Errorf("no heads chose %s", got)
+	}
+}
+
+func TestRegionHealth(t *testing.T) {
+	saved, savedPolicy := headServices, routingPolicy
+	defer func() { headServices, routingPolicy = saved, savedPolicy }()
+	routingPolicy = RoutingPolicy{RegionFailover: map[string][]string{"eu-west": {"us-east"}}}
+
+	now := time.Now().Unix()
+	headServices = map[string]HeadService{
+		"ew1": {HeadID: "ew1", Region: "eu-west", Status: "active", CurrentLoad: 3, LastHeartbeat: now},
+		"ew2": {HeadID: "ew2", Region: "eu-west", Status: "draining", CurrentLoad: 1, LastHeartbeat: now},
+		"us1": {HeadID: "us1", Region: "us-east", Status: "active", LastHeartbeat: now - 600},
+		"ec1": {HeadID: "ec1", Region: "eu-central", Status: "active", LastHeartbeat: now},
+	}
+
+	rr := httptest.NewRecorder()
+	handleRegions(rr, httptest.NewRequest(http.MethodGet, "/api/routing/regions", nil))
+	var body struct {
+		Regions  []RegionHealth      `json:"regions"`
+		Failover map[string][]string `json:"failover"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := []RegionHealth{
+		{Region: "eu-central", Heads: 1, Healthy: 1, Status: regionHealthy},
+		{Region: "eu-west", Heads: 2, Healthy: 1, Load: 4, Status: regionDegraded},
+		{Region: "us-east", Heads: 1, Healthy: 0, Status: regionDown},
+	}
+	if !reflect.DeepEqual(body.Regions, want) {
+		t.Errorf("regions = %+v", body.Regions)
+	}
+	if len(body.Failover["eu-west"]) != 1 {
+		t.Errorf("failover = %v", body.Failover)
+	}
+
+	observeRegionHealth()
+	if got := testutil.ToFloat64(regionHealthyHeads.WithLabelValues("us-east")); got != 0 {
+		t.Errorf("us-east healthy heads = %v", got)
+	}
+	if got := testutil.ToFloat64(regionHealthyHeads.WithLabelValues("eu-west")); got != 1 {
+		t.Errorf("eu-west healthy heads = %v", got)
+	}
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// rolloutSubject is the NATS subject of rollout state changes
+const rolloutSubject = "routing.rollout"
+
+// rolloutsKey is the Redis hash of rollouts, keyed by model type
+const rolloutsKey = "routing:rollouts"
+
+// allModels is the model type of a rollout of every model
+const allModels = "*"
+
+// Rollout states. A rollout ramps up, step by step, until it completes or is
+// rolled back; it can be paused and resumed while ramping. Completed and
+// rolled back rollouts keep routing to the version they ended on until they
+// are deleted.
+const (
+	rolloutRamping    = "ramping"
+	rolloutPaused     = "paused"
+	rolloutCompleted  = "completed"
+	rolloutRolledBack = "rolled_back"
+)
+
+// rolloutTransitions are the states a rollout can move to from each state
+var rolloutTransitions = map[string][]string{
+	rolloutRamping: {rolloutPaused, rolloutCompleted, rolloutRolledBack},
+	rolloutPaused:  {rolloutRamping, rolloutCompleted, rolloutRolledBack},
+}
+
+var (
+	rolloutPercent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "routing_rollout_percent",
+			Help: "Percentage of traffic routed to the candidate version of a rollout",
+		},
+		[]string{"model_type", "version"},
+	)
+	rolloutStateChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "routing_rollout_state_changes_total",
+			Help: "Total number of rollout state changes",
+		},
+		[]string{"model_type", "state"},
+	)
+)
+
+// errRolloutExists is returned when starting a rollout of a model that has one
+var errRolloutExists = errors.New("model already has a rollout, delete it first")
+
+// rollouts holds the version rollouts of this instance
+var rollouts = newRolloutController(rolloutIntervalFromEnv(), publishRolloutEvent, storeRolloutInRedis)
+
+// RolloutSpec is what an operator asks for when starting a rollout
+type RolloutSpec struct {
+	// ModelType is the model whose heads are rolled out, "*" for all
+	ModelType string `json:"model_type"`
+	// BaselineVersion is the version in service; empty means every head not
+	// on the candidate version
+	BaselineVersion  string `json:"baseline_version,omitempty"`
+	CandidateVersion string `json:"candidate_version"`
+	// Steps are the percentages of traffic sent to the candidate, in order
+	Steps []int `json:"steps,omitempty"`
+	// StepSeconds is how long a step must stay healthy before the next one
+	StepSeconds int `json:"step_seconds,omitempty"`
+	// MinRequests is the fewest candidate outcomes a step needs to be judged
+	MinRequests int `json:"min_requests,omitempty"`
+	// MaxErrorRate rolls back a candidate failing more often than this
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+	// MaxErrorRateIncrease rolls back a candidate failing this much more
+	// often than the baseline
+	MaxErrorRateIncrease float64 `json:"max_error_rate_increase,omitempty"`
+}
+
+// withDefaults fills in the settings the spec leaves out
+func (s RolloutSpec) withDefaults() RolloutSpec {
+	if s.ModelType == "" {
+		s.ModelType = allModels
+	}
+	if len(s.Steps) == 0 {
+		s.Steps = []int{5, 25, 50, 100}
+	}
+	if s.StepSeconds == 0 {
+		s.StepSeconds = 300
+	}
+	if s.MinRequests == 0 {
+		s.MinRequests = 20
+	}
+	if s.MaxErrorRate == 0 {
+		s.MaxErrorRate = 0.05
+	}
+	if s.MaxErrorRateIncrease == 0 {
+		s.MaxErrorRateIncrease = 0.02
+	}
+	return s
+}
+
+func (s RolloutSpec) validate() error {
+	if s.CandidateVersion == "" {
+		return errors.New("candidate_version is required")
+	}
+	if s.CandidateVersion == s.BaselineVersion {
+		return errors.New("candidate_version must differ from baseline_version")
+	}
+	prev := 0
+	for _, step := range s.Steps {
+		if step <= prev || step > 100 {
+			return errors.New("steps must increase from above 0 to at most 100")
+		}
+		prev = step
+	}
+	if s.StepSeconds < 0 || s.MinRequests < 0 || s.MaxErrorRate < 0 || s.MaxErrorRateIncrease < 0 {
+		return errors.New("durations, counts and rates must not be negative")
+	}
+	return nil
+}
+
+// versionOutcomes counts the routed requests reported on
+// /webhook/routing-feedback for a version during the current step
+type versionOutcomes struct {
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+}
+
+func (o versionOutcomes) errorRate() float64 {
+	if o.Requests == 0 {
+		return 0
+	}
+	return float64(o.Failures) / float64(o.Requests)
+}
+
+// Rollout is a version rollout and where it stands
+type Rollout struct {
+	RolloutSpec
+	State string `json:"state"`
+	// Step is the index in Steps of the current percentage
+	Step          int       `json:"step"`
+	Percent       int       `json:"percent"`
+	Reason        string    `json:"reason,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	StepStartedAt time.Time `json:"step_started_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// Outcomes of the current step
+	Candidate versionOutcomes `json:"candidate"`
+	Baseline  versionOutcomes `json:"baseline"`
+}
+
+// RolloutEvent is a change of a rollout's state or step, published on
+// rolloutSubject
+type RolloutEvent struct {
+	ModelType        string    `json:"model_type"`
+	CandidateVersion string    `json:"candidate_version"`
+	State            string    `json:"state"`
+	PreviousState    string    `json:"previous_state,omitempty"`
+	Percent          int       `json:"percent"`
+	Reason           string    `json:"reason,omitempty"`
+	At               time.Time `json:"at"`
+}
+
+// rolloutController ramps rollouts up on healthy error rates and rolls them
+// back on regressions
+type rolloutController struct {
+	interval time.Duration
+	publish  func(RolloutEvent)
+	store    func(Rollout) error
+	random   func() float64
+
+	mu       sync.Mutex
+	rollouts map[string]*Rollout
+}
+
+func newRolloutController(interval time.Duration, publish func(RolloutEvent), store func(Rollout) error) *rolloutController {
+	return &rolloutController{
+		interval: interval,
+		publish:  publish,
+		store:    store,
+		random:   rand.Float64,
+		rollouts: make(map[string]*Rollout),
+	}
+}
+
+// rolloutIntervalFromEnv reads ROLLOUT_INTERVAL, how often rollouts are
+// evaluated
+func rolloutIntervalFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("ROLLOUT_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * time.Second
+}
+
+// Start begins a rollout at its first step; a model can have one rollout at
+// a time
+func (c *rolloutController) Start(spec RolloutSpec, now time.Time) (Rollout, error) {
+	spec = spec.withDefaults()
+	if err := spec.validate(); err != nil {
+		return Rollout{}, err
+	}
+
+	c.mu.Lock()
+	if _, ok := c.rollouts[spec.ModelType]; ok {
+		c.mu.Unlock()
+		return Rollout{}, errRolloutExists
+	}
+	r := &Rollout{
+		RolloutSpec:   spec,
+		State:         rolloutRamping,
+		Percent:       spec.Steps[0],
+		StartedAt:     now,
+		StepStartedAt: now,
+		UpdatedAt:     now,
+	}
+	c.rollouts[spec.ModelType] = r
+	snapshot := *r
+	c.mu.Unlock()
+
+	c.changed(snapshot, "", "started")
+	return snapshot, nil
+}
+
+// Get returns the rollout of a model type
+func (c *rolloutController) Get(modelType string) (Rollout, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.rollouts[modelType]
+	if !ok {
+		return Rollout{}, false
+	}
+	return *r, true
+}
+
+// List returns every rollout, by model type
+func (c *rolloutController) List() []Rollout {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]Rollout, 0, len(c.rollouts))
+	for _, r := range c.rollouts {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ModelType < list[j].ModelType })
+	return list
+}
+
+// Delete forgets the rollout of a model type, which is then routed by the
+// routing policy alone
+func (c *rolloutController) Delete(modelType string) bool {
+	c.mu.Lock()
+	_, ok := c.rollouts[modelType]
+	delete(c.rollouts, modelType)
+	c.mu.Unlock()
+	if ok {
+		clearRoutingCache()
+	}
+	return ok
+}
+
+// Pause holds a ramping rollout at its step
+func (c *rolloutController) Pause(modelType string, now time.Time) (Rollout, error) {
+	return c.transition(modelType, rolloutPaused, "paused by operator", now)
+}
+
+// Resume continues a paused rollout; its step is judged afresh
+func (c *rolloutController) Resume(modelType string, now time.Time) (Rollout, error) {
+	return c.transition(modelType, rolloutRamping, "resumed by operator", now)
+}
+
+// Rollback sends all traffic of the model back to the baseline
+func (c *rolloutController) Rollback(modelType string, now time.Time) (Rollout, error) {
+	return c.transition(modelType, rolloutRolledBack, "rolled back by operator", now)
+}
+
+// Promote moves a rollout to its next step without waiting, or completes it
+// from the last step
+func (c *rolloutController) Promote(modelType string, now time.Time) (Rollout, error) {
+	c.mu.Lock()
+	r, ok := c.rollouts[modelType]
+	if !ok {
+		c.mu.Unlock()
+		return Rollout{}, fmt.Errorf("model %s has no rollout", modelType)
+	}
+	if r.State != rolloutRamping && r.State != rolloutPaused {
+		c.mu.Unlock()
+		return Rollout{}, fmt.Errorf("rollout of %s is %s", modelType, r.State)
+	}
+	previous := r.State
+	c.advance(r, "promoted by operator", now)
+	snapshot := *r
+	c.mu.Unlock()
+
+	c.changed(snapshot, previous, snapshot.Reason)
+	return snapshot, nil
+}
+
+func (c *rolloutController) transition(modelType, to, reason string, now time.Time) (Rollout, error) {
+	c.mu.Lock()
+	r, ok := c.rollouts[modelType]
+	if !ok {
+		c.mu.Unlock()
+		return Rollout{}, fmt.Errorf("model %s has no rollout", modelType)
+	}
+	if !canTransition(r.State, to) {
+		c.mu.Unlock()
+		return Rollout{}, fmt.Errorf("rollout of %s cannot go from %s to %s", modelType, r.State, to)
+	}
+	previous := r.State
+	c.setState(r, to, reason, now)
+	snapshot := *r
+	c.mu.Unlock()
+
+	c.changed(snapshot, previous, reason)
+	return snapshot, nil
+}
+
+func canTransition(from, to string) bool {
+	for _, state := range rolloutTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setState moves a rollout to a state; a new step or state starts counting
+// outcomes again
+func (c *rolloutController) setState(r *Rollout, state, reason string, now time.Time) {
+	r.State, r.Reason, r.UpdatedAt = state, reason, now
+	switch state {
+	case rolloutCompleted:
+		r.Percent = 100
+	case rolloutRolledBack:
+		r.Percent = 0
+	}
+	r.StepStartedAt = now
+	r.Candidate, r.Baseline = versionOutcomes{}, versionOutcomes{}
+}
+
+// advance moves a rollout to its next step, completing it after the last
+func (c *rolloutController) advance(r *Rollout, reason string, now time.Time) {
+	if r.Step+1 >= len(r.Steps) {
+		c.setState(r, rolloutCompleted, reason, now)
+		return
+	}
+	r.Step++
+	r.Percent = r.Steps[r.Step]
+	c.setState(r, rolloutRamping, reason, now)
+}
+
+// ObserveOutcome records the outcome of a request routed to a head
+func (c *rolloutController) ObserveOutcome(modelType, version string, success bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.rolloutOf(modelType)
+	if r == nil || r.State != rolloutRamping {
+		return
+	}
+	outcomes := &r.Baseline
+	switch {
+	case version == r.CandidateVersion:
+		outcomes = &r.Candidate
+	case r.BaselineVersion != "" && version != r.BaselineVersion:
+		return
+	}
+	outcomes.Requests++
+	if !success {
+		outcomes.Failures++
+	}
+}
+
+// rolloutOf returns the rollout that applies to a model type; c.mu is held
+func (c *rolloutController) rolloutOf(modelType string) *Rollout {
+	if r, ok := c.rollouts[modelType]; ok {
+		return r
+	}
+	return c.rollouts[allModels]
+}
+
+// Splitting reports whether the rollout of a model type decides between
+// versions per request, so cached decisions must not be used
+func (c *rolloutController) Splitting(modelType string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rolloutOf(modelType) != nil
+}
+
+// Split narrows the candidate heads of a request to one version: the
+// candidate version for the rollout's share of requests, the baseline for the
+// rest. When the chosen version has no head the other one is used.
+func (c *rolloutController) Split(modelType string, heads []HeadService) ([]HeadService, string) {
+	c.mu.Lock()
+	r := c.rolloutOf(modelType)
+	if r == nil {
+		c.mu.Unlock()
+		return heads, ""
+	}
+	candidateVersion, baselineVersion, percent := r.CandidateVersion, r.BaselineVersion, r.Percent
+	c.mu.Unlock()
+
+	var candidate, baseline []HeadService
+	for _, head := range heads {
+		switch {
+		case head.Version == candidateVersion:
+			candidate = append(candidate, head)
+		case baselineVersion == "" || head.Version == baselineVersion:
+			baseline = append(baseline, head)
+		}
+	}
+
+	toCandidate := c.random()*100 < float64(percent)
+	switch {
+	case toCandidate && len(candidate) > 0, len(baseline) == 0 && len(candidate) > 0:
+		return candidate, fmt.Sprintf("rollout of %s at %d%%: candidate", candidateVersion, percent)
+	case len(baseline) > 0:
+		return baseline, fmt.Sprintf("rollout of %s at %d%%: baseline", candidateVersion, percent)
+	default:
+		return heads, ""
+	}
+}
+
+// Evaluate rolls back ramping rollouts whose candidate regressed and ramps
+// up those whose step stayed healthy for its duration
+func (c *rolloutController) Evaluate(now time.Time) {
+	type change struct {
+		rollout  Rollout
+		previous string
+	}
+	var changes []change
+
+	c.mu.Lock()
+	for _, r := range c.rollouts {
+		if r.State != rolloutRamping || r.Candidate.Requests < r.MinRequests {
+			continue
+		}
+		previous := r.State
+		candidateRate, baselineRate := r.Candidate.errorRate(), r.Baseline.errorRate()
+		switch {
+		case candidateRate > r.MaxErrorRate:
+			c.setState(r, rolloutRolledBack, fmt.Sprintf("candidate error rate %.3f above %.3f", candidateRate, r.MaxErrorRate), now)
+		case r.Baseline.Requests >= r.MinRequests && candidateRate-baselineRate > r.MaxErrorRateIncrease:
+			c.setState(r, rolloutRolledBack, fmt.Sprintf("candidate error rate %.3f against %.3f on baseline", candidateRate, baselineRate), now)
+		case now.Sub(r.StepStartedAt) >= time.Duration(r.StepSeconds)*time.Second:
+			c.advance(r, fmt.Sprintf("step at %d%% healthy with error rate %.3f", r.Percent, candidateRate), now)
+		default:
+			continue
+		}
+		changes = append(changes, change{*r, previous})
+	}
+	c.mu.Unlock()
+
+	for _, ch := range changes {
+		c.changed(ch.rollout, ch.previous, ch.rollout.Reason)
+	}
+}
+
+// changed persists a rollout, updates its metrics, drops cached decisions
+// and publishes the change
+func (c *rolloutController) changed(r Rollout, previous, reason string) {
+	rolloutPercent.WithLabelValues(r.ModelType, r.CandidateVersion).Set(float64(r.Percent))
+	rolloutStateChanges.WithLabelValues(r.ModelType, r.State).Inc()
+	clearRoutingCache()
+	if c.store != nil {
+		if err := c.store(r); err != nil && logger != nil {
+			logger.Error("Failed to store rollout", zap.String("model_type", r.ModelType), zap.Error(err))
+		}
+	}
+	c.publish(RolloutEvent{
+		ModelType:        r.ModelType,
+		CandidateVersion: r.CandidateVersion,
+		State:            r.State,
+		PreviousState:    previous,
+		Percent:          r.Percent,
+		Reason:           reason,
+		At:               r.UpdatedAt,
+	})
+}
+
+// Load restores the rollouts stored in Redis, e.g. after a restart
+func (c *rolloutController) Load(ctx context.Context) error {
+	entries, err := redisClient.HGetAll(ctx, rolloutsKey).Result()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for modelType, raw := range entries {
+		var r Rollout
+		if err := json.Unmarshal([]byte(raw), &r); err != nil {
+			continue
+		}
+		c.rollouts[modelType] = &r
+		rolloutPercent.WithLabelValues(r.ModelType, r.CandidateVersion).Set(float64(r.Percent))
+	}
+	return nil
+}
+
+// Run evaluates the rollouts every interval until ctx is done
+func (c *rolloutController) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.Evaluate(now)
+		}
+	}
+}
+
+// clearRoutingCache drops every cached decision, which may point at a version
+// that no longer gets the traffic
+func clearRoutingCache() {
+	cacheMutex.Lock()
+	routingCache = make(map[string]string)
+	cacheMutex.Unlock()
+}
+
+func storeRolloutInRedis(r Rollout) error {
+	if redisClient == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(context.Background(), rolloutsKey, r.ModelType, data).Err()
+}
+
+// publishRolloutEvent logs the event and publishes it on rolloutSubject
+func publishRolloutEvent(event RolloutEvent) {
+	logger.Info("Rollout changed",
+		zap.String("model_type", event.ModelType),
+		zap.String("candidate_version", event.CandidateVersion),
+		zap.String("state", event.State),
+		zap.Int("percent", event.Percent),
+		zap.String("reason", event.Reason),
+	)
+	if natsConn == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := natsConn.Publish(rolloutSubject, data); err != nil {
+		messageQueueMessages.WithLabelValues(rolloutSubject, "error").Inc()
+		return
+	}
+	messageQueueMessages.WithLabelValues(rolloutSubject, "success").Inc()
+}
+
+// handleRollouts serves GET and POST on /api/routing/rollouts
+func handleRollouts(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeRolloutJSON(w, http.StatusOK, map[string]interface{}{"rollouts": rollouts.List()})
+		return
+	}
+
+	var spec RolloutSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "Invalid rollout", http.StatusBadRequest)
+		return
+	}
+	rollout, err := rollouts.Start(spec, time.Now())
+	if errors.Is(err, errRolloutExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRolloutJSON(w, http.StatusCreated, rollout)
+}
+
+// handleRollout serves GET and DELETE on /api/routing/rollouts/{model_type}
+func handleRollout(w http.ResponseWriter, r *http.Request) {
+	modelType := mux.Vars(r)["model_type"]
+	if r.Method == http.MethodDelete {
+		if !rollouts.Delete(modelType) {
+			http.Error(w, "Rollout not found", http.StatusNotFound)
+			return
+		}
+		if redisClient != nil {
+			redisClient.HDel(r.Context(), rolloutsKey, modelType)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rollout, ok := rollouts.Get(modelType)
+	if !ok {
+		http.Error(w, "Rollout not found", http.StatusNotFound)
+		return
+	}
+	writeRolloutJSON(w, http.StatusOK, rollout)
+}
+
+// handleRolloutAction serves POST on
+// /api/routing/rollouts/{model_type}/{pause,resume,promote,rollback}
+func handleRolloutAction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	actions := map[string]func(string, time.Time) (Rollout, error){
+		"pause":    rollouts.Pause,
+		"resume":   rollouts.Resume,
+		"promote":  rollouts.Promote,
+		"rollback": rollouts.Rollback,
+	}
+	action, ok := actions[vars["action"]]
+	if !ok {
+		http.Error(w, "Unknown rollout action", http.StatusNotFound)
+		return
+	}
+	if _, ok := rollouts.Get(vars["model_type"]); !ok {
+		http.Error(w, "Rollout not found", http.StatusNotFound)
+		return
+	}
+	rollout, err := action(vars["model_type"], time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeRolloutJSON(w, http.StatusOK, rollout)
+}
+
+func writeRolloutJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
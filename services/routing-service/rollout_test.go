@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testRollouts(events *[]RolloutEvent) *rolloutController {
+	return newRolloutController(time.Minute, func(e RolloutEvent) {
+		*events = append(*events, e)
+	}, nil)
+}
+
+func observe(c *rolloutController, version string, requests, failures int) {
+	for i := 0; i < requests; i++ {
+		c.ObserveOutcome("gpt-4o", version, i >= failures)
+	}
+}
+
+func TestRolloutRampsUpWhenHealthy(t *testing.T) {
+	var events []RolloutEvent
+	c := testRollouts(&events)
+	now := time.Now()
+
+	r, err := c.Start(RolloutSpec{ModelType: "gpt-4o", BaselineVersion: "1.0", CandidateVersion: "1.1", Steps: []int{10, 100}, StepSeconds: 60, MinRequests: 10}, now)
+	if err != nil || r.State != rolloutRamping || r.Percent != 10 {
+		t.Fatalf("Start = %+v, %v", r, err)
+	}
+
+	// Too early, then too few requests
+	observe(c, "1.1", 10, 0)
+	c.Evaluate(now.Add(30 * time.Second))
+	if r, _ := c.Get("gpt-4o"); r.Percent != 10 {
+		t.Fatalf("percent = %d before the step ended", r.Percent)
+	}
+
+	c.Evaluate(now.Add(time.Minute))
+	if r, _ := c.Get("gpt-4o"); r.State != rolloutRamping || r.Percent != 100 || r.Candidate.Requests != 0 {
+		t.Fatalf("after first step: %+v", r)
+	}
+	c.Evaluate(now.Add(2 * time.Minute))
+	if r, _ := c.Get("gpt-4o"); r.Percent != 100 {
+		t.Fatalf("step without requests advanced: %+v", r)
+	}
+
+	observe(c, "1.1", 10, 0)
+	c.Evaluate(now.Add(3 * time.Minute))
+	if r, _ := c.Get("gpt-4o"); r.State != rolloutCompleted {
+		t.Fatalf("state = %s, want completed", r.State)
+	}
+	if len(events) != 3 || events[2].PreviousState != rolloutRamping || events[2].State != rolloutCompleted {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestRolloutRollsBackOnRegression(t *testing.T) {
+	var events []RolloutEvent
+	c := testRollouts(&events)
+	now := time.Now()
+	c.Start(RolloutSpec{ModelType: "gpt-4o", BaselineVersion: "1.0", CandidateVersion: "1.1", MinRequests: 10}, now)
+
+	// 4% fails on the candidate, within 5%, but 1% on the baseline
+	observe(c, "1.0", 100, 1)
+	observe(c, "1.1", 100, 4)
+	observe(c, "0.9", 100, 100)
+	c.Evaluate(now.Add(time.Second))
+
+	r, _ := c.Get("gpt-4o")
+	if r.State != rolloutRolledBack || r.Percent != 0 {
+		t.Fatalf("rollout = %+v, want rolled back", r)
+	}
+	if last := events[len(events)-1]; last.State != rolloutRolledBack || last.Reason == "" {
+		t.Errorf("event = %+v", last)
+	}
+	if _, err := c.Resume("gpt-4o", now); err == nil {
+		t.Error("resumed a rolled back rollout")
+	}
+}
+
+func TestRolloutSplit(t *testing.T) {
+	var events []RolloutEvent
+	c := testRollouts(&events)
+	heads := []HeadService{
+		{HeadID: "a", Version: "1.0"},
+		{HeadID: "b", Version: "1.1"},
+		{HeadID: "c", Version: "0.9"},
+	}
+
+	if got, _ := c.Split("gpt-4o", heads); len(got) != 3 {
+		t.Fatalf("split without rollout = %+v", got)
+	}
+
+	c.Start(RolloutSpec{ModelType: "*", BaselineVersion: "1.0", CandidateVersion: "1.1", Steps: []int{20, 100}}, time.Now())
+	c.random = func() float64 { return 0.1 }
+	if got, reason := c.Split("gpt-4o", heads); len(got) != 1 || got[0].HeadID != "b" || reason == "" {
+		t.Errorf("split at 10 of 20%% = %+v", got)
+	}
+	c.random = func() float64 { return 0.5 }
+	if got, _ := c.Split("gpt-4o", heads); len(got) != 1 || got[0].HeadID != "a" {
+		t.Errorf("split at 50 of 20%% = %+v", got)
+	}
+
+	// Without candidate heads the baseline takes all requests
+	c.random = func() float64 { return 0.1 }
+	if got, _ := c.Split("gpt-4o", heads[:1]); len(got) != 1 || got[0].HeadID != "a" {
+		t.Errorf("split without candidate = %+v", got)
+	}
+
+	c.Pause("*", time.Now())
+	if r, _ := c.Promote("*", time.Now()); r.Percent != 100 {
+		t.Fatalf("promoted = %+v", r)
+	}
+	c.random = func() float64 { return 0.99 }
+	if got, _ := c.Split("gpt-4o", heads); len(got) != 1 || got[0].HeadID != "b" {
+		t.Errorf("split at 100%% = %+v", got)
+	}
+}
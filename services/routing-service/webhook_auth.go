@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tlsutil"
+	"github.com/MaksimVF/ZB/pkg/tracing"
+	"github.com/MaksimVF/ZB/pkg/webhooksig"
+	secretpb "github.com/MaksimVF/ZB/services/secrets-service/pb"
+)
+
+// webhookSecretPrefix is where the shared secret of each webhook source is
+// kept in secrets-service, e.g. webhooks/monitoring
+const webhookSecretPrefix = "webhooks/"
+
+// webhookSecretTTL is how long a secret is cached; a rotated secret is
+// picked up within it
+const webhookSecretTTL = time.Minute
+
+// webhookVerifier checks the signatures of /webhook requests; set up with
+// the HTTP server
+var webhookVerifier *webhooksig.Verifier
+
+var webhookRejections = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "routing_webhook_rejections_total",
+		Help: "Total number of webhook requests rejected by signature verification",
+	},
+	[]string{"reason"},
+)
+
+// newWebhookVerifier verifies webhooks with the secrets of secrets-service
+// and the nonces in Redis. WEBHOOK_TOLERANCE is the allowed clock skew.
+func newWebhookVerifier() *webhooksig.Verifier {
+	addr := os.Getenv("SECRETS_SERVICE_ADDR")
+	if addr == "" {
+		addr = "secret-service:50053"
+	}
+	tlsConfig, err := tlsutil.ClientConfig("certs/client.crt", "certs/client.key", "certs/ca.crt")
+	if err != nil {
+		logger.Fatal("Failed to load the secrets-service client certificate", zap.Error(err))
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		tracing.DialOption(),
+		requestid.DialOption(),
+	)
+	if err != nil {
+		logger.Fatal("Failed to connect to secrets-service", zap.Error(err))
+	}
+	secrets := secretpb.NewSecretServiceClient(conn)
+
+	tolerance := webhooksig.DefaultTolerance
+	if raw := os.Getenv("WEBHOOK_TOLERANCE"); raw != "" {
+		if tolerance, err = time.ParseDuration(raw); err != nil || tolerance <= 0 {
+			logger.Fatal("Invalid WEBHOOK_TOLERANCE", zap.String("value", raw))
+		}
+	}
+
+	return &webhooksig.Verifier{
+		Secrets: webhooksig.CachedSecrets(func(ctx context.Context, source string) (string, error) {
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			resp, err := secrets.GetSecret(ctx, &secretpb.GetSecretRequest{Name: webhookSecretPrefix + source})
+			if status.Code(err) == codes.NotFound {
+				return "", webhooksig.ErrUnknownSource
+			}
+			if err != nil {
+				return "", err
+			}
+			return resp.Value, nil
+		}, webhookSecretTTL),
+		Nonces:    webhooksig.RedisNonces{RDB: redisClient, Prefix: "routing:webhook:nonce:"},
+		Tolerance: tolerance,
+	}
+}
+
+// webhookSecurityMiddleware lets through webhooks signed with the secret of
+// their source; others get 401 with the reason as the error code
+func webhookSecurityMiddleware(next http.Handler) http.Handler {
+	return webhookVerifier.Middleware(func(r *http.Request, source string, err *webhooksig.Error) {
+		webhookRejections.WithLabelValues(err.Reason).Inc()
+		logger.Warn("Webhook rejected",
+			zap.String("path", r.URL.Path),
+			zap.String("source", source),
+			zap.String("reason", err.Reason),
+			zap.String("remote_addr", r.RemoteAddr),
+		)
+	})(next)
+}
@@ -1,63 +1,91 @@
-
 package main
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
-)
 
-func TestWebhookSecurity(t *testing.T) {
-	// Create a test router
-	router := mux.NewRouter()
-	router.Handle("/webhook/head-status", webhookSecurityMiddleware(rateLimitMiddleware(http.HandlerFunc(handleHeadStatusWebhook)))).Methods("POST")
-	router.Handle("/webhook/routing-decision", webhookSecurityMiddleware(rateLimitMiddleware(http.HandlerFunc(handleRoutingDecisionWebhook)))).Methods("POST")
+	"github.com/MaksimVF/ZB/pkg/webhooksig"
+)
 
-	// Test case 1: Missing authorization header
-	req := httptest.NewRequest("POST", "/webhook/head-status", bytes.NewReader([]byte(`{"head_id":"test","status":"active","current_load":10,"timestamp":1234567890}`)))
-	req.Header.Set("Content-Type", "application/json")
-	rr := httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
+// memoryNonces is a NonceStore for tests
+type memoryNonces struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
 
-	if rr.Code != http.StatusUnauthorized {
-		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+func (n *memoryNonces) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen[key] {
+		return false, nil
 	}
+	n.seen[key] = true
+	return true, nil
+}
 
-	// Test case 2: Invalid token format
-	req = httptest.NewRequest("POST", "/webhook/head-status", bytes.NewReader([]byte(`{"head_id":"test","status":"active","current_load":10,"timestamp":1234567890}`)))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer invalid-token")
-	req.Header.Set("X-App-Signature", "app-sig-valid")
-	rr = httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusUnauthorized {
-		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+func TestWebhookSecurity(t *testing.T) {
+	saved := webhookVerifier
+	defer func() { webhookVerifier = saved }()
+	webhookVerifier = &webhooksig.Verifier{
+		Secrets: func(ctx context.Context, source string) (string, error) {
+			if source == "monitoring" {
+				return "test-secret", nil
+			}
+			return "", webhooksig.ErrUnknownSource
+		},
+		Nonces: &memoryNonces{seen: make(map[string]bool)},
 	}
 
-	// Test case 3: Missing app signature
-	req = httptest.NewRequest("POST", "/webhook/head-status", bytes.NewReader([]byte(`{"head_id":"test","status":"active","current_load":10,"timestamp":1234567890}`)))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer webhook-token")
-	rr = httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
+	// Create a test router
+	router := mux.NewRouter()
+	router.Handle("/webhook/head-status", rateLimitMiddleware(webhookSecurityMiddleware(http.HandlerFunc(handleHeadStatusWebhook)))).Methods("POST")
 
-	if rr.Code != http.StatusUnauthorized {
-		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	body := []byte(`{"head_id":"test","status":"active","current_load":10,"timestamp":1234567890}`)
+	send := func(sign func(*http.Request)) int {
+		req := httptest.NewRequest("POST", "/webhook/head-status", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		sign(req)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
 	}
 
-	// Test case 4: Valid request
-	req = httptest.NewRequest("POST", "/webhook/head-status", bytes.NewReader([]byte(`{"head_id":"test","status":"active","current_load":10,"timestamp":1234567890}`)))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer webhook-token")
-	req.Header.Set("X-App-Signature", "app-sig-valid")
-	rr = httptest.NewRecorder()
-	router.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	tests := []struct {
+		name string
+		sign func(*http.Request)
+		want int
+	}{
+		{"unsigned", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"old bearer token", func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer webhook-token")
+			r.Header.Set("X-App-Signature", "app-sig-valid")
+		}, http.StatusUnauthorized},
+		{"unknown source", func(r *http.Request) {
+			webhooksig.SignRequest(r, "other", "test-secret", "n1", body, time.Now())
+		}, http.StatusUnauthorized},
+		{"wrong secret", func(r *http.Request) {
+			webhooksig.SignRequest(r, "monitoring", "guess", "n2", body, time.Now())
+		}, http.StatusUnauthorized},
+		{"stale", func(r *http.Request) {
+			webhooksig.SignRequest(r, "monitoring", "test-secret", "n3", body, time.Now().Add(-time.Hour))
+		}, http.StatusUnauthorized},
+		{"valid", func(r *http.Request) {
+			webhooksig.SignRequest(r, "monitoring", "test-secret", "n4", body, time.Now())
+		}, http.StatusOK},
+		{"replayed", func(r *http.Request) {
+			webhooksig.SignRequest(r, "monitoring", "test-secret", "n4", body, time.Now())
+		}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if got := send(tt.sign); got != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, got)
+		}
 	}
 }
@@ -11,6 +11,7 @@ module github.com/MaksimVF/ZB/services/secrets-service
 go 1.21
 
 require (
+github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
 github.com/go-redis/redis/v8 v8.11.5
 github.com/hashicorp/vault/api v1.10.0
 github.com/prometheus/client_golang v1.16.0
@@ -22,3 +23,4 @@ google.golang.org/grpc/status v1.56.3
 
 replace github.com/MaksimVF/ZB/services/secrets-service/pb => ../pb
 
+replace github.com/MaksimVF/ZB => ../..
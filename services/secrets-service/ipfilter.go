@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/ipfilter"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+)
+
+// newAdminIPFilter restricts the admin API to the IPs of ADMIN_IP_ALLOW and
+// ADMIN_IP_DENY. With REDIS_ADDR set, rules published for secrets-admin
+// replace them at runtime.
+func newAdminIPFilter(ctx context.Context) *ipfilter.Filter {
+	var rdb redis.UniversalClient
+	if os.Getenv("REDIS_ADDR") != "" {
+		var err error
+		if rdb, err = redisconn.New(ctx, redisconn.FromEnv()); err != nil {
+			logger.Fatal().Err(err).Msg("Invalid Redis configuration")
+		}
+	}
+
+	filter, err := ipfilter.New("secrets-admin", rdb, ipfilter.FromEnv("ADMIN_IP"))
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid admin IP filter")
+	}
+	if err := filter.Load(ctx); err != nil {
+		logger.Error().Err(err).Msg("Failed to load the admin IP filter, using ADMIN_IP_*")
+	}
+	filter.Watch(ctx, time.Minute)
+	return filter
+}
+
+// auditBlockedIP logs admin requests blocked by the IP filter
+func auditBlockedIP(r *http.Request, b ipfilter.Blocked) {
+	logger.Warn().
+		Str("scope", b.Scope).
+		Str("ip", b.IP).
+		Str("reason", b.Reason).
+		Str("http_method", r.Method).
+		Str("path", r.URL.Path).
+		Str("request_id", requestid.FromContext(r.Context())).
+		Msg("Admin request blocked by IP filter")
+}
@@ -4,17 +4,22 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
-	"time"
 
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"github.com/hashicorp/vault/api"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -33,14 +38,6 @@ var (
 		},
 		[]string{"operation", "status"},
 	)
-	httpDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "HTTP request duration in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"method", "path", "status"},
-	)
 )
 
 const (
@@ -59,7 +56,7 @@ func init() {
 		Logger()
 
 	// Register Prometheus metrics
-	prometheus.MustRegister(secretCounter, httpDuration)
+	prometheus.MustRegister(secretCounter)
 
 	// Initialize Vault client
 	config := api.DefaultConfig()
@@ -96,7 +93,16 @@ func newSecretError(code codes.Code, message, details string) *SecretError {
 }
 
 // ===================== gRPC =====================
+
+// requestLogger returns the logger with the request ID the caller sent in gRPC
+// metadata
+func requestLogger(ctx context.Context) zerolog.Logger {
+	return logger.With().Str("request_id", requestid.FromContext(ctx)).Logger()
+}
+
 func (s *server) GetSecret(ctx context.Context, req *pb.GetSecretRequest) (*pb.GetSecretResponse, error) {
+	logger := requestLogger(ctx)
+
 	logger.Info().
 		Str("method", "GetSecret").
 		Str("secret_name", req.Name).
@@ -151,6 +157,8 @@ func (s *server) GetSecret(ctx context.Context, req *pb.GetSecretRequest) (*pb.G
 }
 
 func (s *server) GetUserSecret(ctx context.Context, req *pb.GetUserSecretRequest) (*pb.GetUserSecretResponse, error) {
+	logger := requestLogger(ctx)
+
 	logger.Info().
 		Str("method", "GetUserSecret").
 		Str("user_id", req.UserId).
@@ -209,6 +217,8 @@ func (s *server) GetUserSecret(ctx context.Context, req *pb.GetUserSecretRequest
 }
 
 func (s *server) SetUserSecret(ctx context.Context, req *pb.SetUserSecretRequest) (*pb.SetUserSecretResponse, error) {
+	logger := requestLogger(ctx)
+
 	logger.Info().
 		Str("method", "SetUserSecret").
 		Str("user_id", req.UserId).
@@ -250,7 +260,6 @@ func (s *server) SetUserSecret(ctx context.Context, req *pb.SetUserSecretRequest
 
 // ===================== HTTP Admin API =====================
 func adminHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 
 	logger.Info().
 		Str("method", "adminHandler").
@@ -264,7 +273,6 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,X-Admin-Key")
 
 	if r.Method == http.MethodOptions {
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "200").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -273,42 +281,38 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 	if adminKey == "" {
 		logger.Warn().Str("method", "adminHandler").Msg("Missing admin key")
 		http.Error(w, "forbidden: missing admin key", 403)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "403").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	if adminKey != os.Getenv("ADMIN_KEY") {
 		logger.Warn().Str("method", "adminHandler").Msg("Invalid admin key")
 		http.Error(w, "forbidden: invalid admin key", 403)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "403").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		handleGetSecrets(w, r, start)
+		handleGetSecrets(w, r)
 
 	case http.MethodPost:
-		handlePostSecret(w, r, start)
+		handlePostSecret(w, r)
 
 	case http.MethodDelete:
-		handleDeleteSecret(w, r, start)
+		handleDeleteSecret(w, r)
 
 	default:
 		logger.Warn().Str("method", "adminHandler").Str("http_method", r.Method).Msg("Invalid HTTP method")
 		http.Error(w, "invalid method", 405)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "405").Observe(time.Since(start).Seconds())
 	}
 }
 
-func handleGetSecrets(w http.ResponseWriter, r *http.Request, start time.Time) {
+func handleGetSecrets(w http.ResponseWriter, r *http.Request) {
 	logger.Info().Str("method", "handleGetSecrets").Msg("Listing secrets")
 
 	secrets, err := vaultClient.Logical().List("secret/metadata/llm")
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to list secrets")
 		http.Error(w, fmt.Sprintf("failed to list secrets: %v", err), 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -316,16 +320,13 @@ func handleGetSecrets(w http.ResponseWriter, r *http.Request, start time.Time) {
 	if err := json.NewEncoder(w).Encode(secrets); err != nil {
 		logger.Error().Err(err).Msg("Failed to encode response")
 		http.Error(w, "failed to encode response", 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	logger.Info().Int("count", len(secrets.Data["keys"].([]string))).Msg("Secrets listed successfully")
-	httpDuration.WithLabelValues(r.Method, r.URL.Path, "200").Observe(time.Since(start).Seconds())
 }
 
 func handlePostSecret(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	logger.Info().Str("method", "handlePostSecret").Msg("Creating/updating secret")
 
 	var input struct {
@@ -336,14 +337,12 @@ func handlePostSecret(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		logger.Error().Err(err).Msg("Failed to decode request body")
 		http.Error(w, fmt.Sprintf("invalid input: %v", err), 400)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	if input.Path == "" || input.Value == "" {
 		logger.Error().Msg("Missing required fields in request")
 		http.Error(w, "path and value are required", 400)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -353,7 +352,6 @@ func handlePostSecret(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error().Err(err).Str("path", input.Path).Msg("Failed to write secret to Vault")
 		http.Error(w, fmt.Sprintf("failed to save secret: %v", err), 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -361,23 +359,19 @@ func handlePostSecret(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "saved"}); err != nil {
 		logger.Error().Err(err).Msg("Failed to encode response")
 		http.Error(w, "failed to encode response", 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	logger.Info().Str("path", input.Path).Msg("Secret saved successfully")
-	httpDuration.WithLabelValues(r.Method, r.URL.Path, "200").Observe(time.Since(start).Seconds())
 }
 
 func handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 	name := r.URL.Path[len("/admin/api/secrets/"):]
 	logger.Info().Str("method", "handleDeleteSecret").Str("secret_name", name).Msg("Deleting secret")
 
 	if name == "" {
 		logger.Error().Msg("Missing secret name in delete request")
 		http.Error(w, "secret name is required", 400)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "400").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -385,7 +379,6 @@ func handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error().Err(err).Str("secret_name", name).Msg("Failed to delete secret")
 		http.Error(w, fmt.Sprintf("failed to delete secret: %v", err), 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -393,31 +386,41 @@ func handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "deleted"}); err != nil {
 		logger.Error().Err(err).Msg("Failed to encode response")
 		http.Error(w, "failed to encode response", 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	logger.Info().Str("secret_name", name).Msg("Secret deleted successfully")
-	httpDuration.WithLabelValues(r.Method, r.URL.Path, "200").Observe(time.Since(start).Seconds())
+}
+
+// newHealthChecker reports Vault on /readyz
+func newHealthChecker() *health.Checker {
+	return health.New("secret-service").
+		Add("vault", func(ctx context.Context) error {
+			status, err := vaultClient.Sys().HealthWithContext(ctx)
+			if err != nil {
+				return err
+			}
+			if !status.Initialized || status.Sealed {
+				return errors.New("vault not initialized or sealed")
+			}
+			return nil
+		})
 }
 
 // Health check handler
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
 
 	// Check Vault health
 	health, err := vaultClient.Sys().Health()
 	if err != nil {
 		logger.Error().Err(err).Msg("Vault health check failed")
 		http.Error(w, "vault unhealthy", 503)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "503").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	if !health.Initialized || health.Sealed {
 		logger.Error().Msg("Vault is not initialized or sealed")
 		http.Error(w, "vault not ready", 503)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "503").Observe(time.Since(start).Seconds())
 		return
 	}
 
@@ -425,30 +428,40 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(map[string]string{"status": "healthy"}); err != nil {
 		logger.Error().Err(err).Msg("Failed to encode health response")
 		http.Error(w, "failed to encode response", 500)
-		httpDuration.WithLabelValues(r.Method, r.URL.Path, "500").Observe(time.Since(start).Seconds())
 		return
 	}
 
 	logger.Info().Msg("Health check passed")
-	httpDuration.WithLabelValues(r.Method, r.URL.Path, "200").Observe(time.Since(start).Seconds())
 }
 
 func main() {
 	init()
 
+	shutdownTracing, err := tracing.Init(context.Background(), "secret-service")
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	defer shutdownTracing(context.Background())
+
 	// gRPC (mTLS)
 	lis, err := net.Listen("tcp", ":50053")
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to listen on TCP port 50053")
 	}
 
-	creds, err := credentials.NewServerTLSFromFile("/certs/secret-service.pem", "/certs/secret-service-key.pem")
+	// Clients must present a certificate from our CA; SPIFFE_AUTHORIZED_IDS
+	// narrows them down to the services that read secrets
+	serverCerts, err := certs.Watch("secret-service", "/certs/secret-service.pem", "/certs/secret-service-key.pem", "/certs/ca.pem")
 	if err != nil {
 		logger.Fatal().Err(err).Msg("Failed to load TLS credentials")
 	}
+	creds := credentials.NewTLS(serverCerts.ServerConfig(tls.RequireAndVerifyClientCert, serverCerts.AuthorizeFromEnv()))
 
-	grpcServer := grpc.NewServer(grpc.Creds(creds))
+	grpcServer := grpc.NewServer(grpc.Creds(creds), tracing.ServerOption(), requestid.ServerOption())
 	pb.RegisterSecretServiceServer(grpcServer, &server{})
+	if debug := diagnostics.RegisterGRPC(grpcServer); len(debug) > 0 {
+		logger.Warn().Strs("services", debug).Msg("gRPC debugging services enabled")
+	}
 
 	go func() {
 		logger.Info().Msg("Starting gRPC server on :50053")
@@ -457,18 +470,27 @@ func main() {
 		}
 	}()
 
-	// HTTP Admin API
-	http.HandleFunc("/admin/api/secrets", adminHandler)
-	http.HandleFunc("/admin/api/secrets/", adminHandler)
+	// HTTP Admin API. Own mux: pprof and expvar register on http.DefaultServeMux
+	mux := http.NewServeMux()
+	adminIPs := newAdminIPFilter(context.Background()).Middleware(auditBlockedIP)
+	mux.Handle("/admin/api/secrets", adminIPs(http.HandlerFunc(adminHandler)))
+	mux.Handle("/admin/api/secrets/", adminIPs(http.HandlerFunc(adminHandler)))
 
 	// Health check endpoint
-	http.HandleFunc("/health", healthCheckHandler)
+	mux.HandleFunc("/health", healthCheckHandler)
+	checker := newHealthChecker()
+	mux.Handle("/livez", checker.LiveHandler())
+	mux.Handle("/readyz", checker.ReadyHandler())
 
 	// Prometheus metrics endpoint
-	http.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", httpmetrics.Handler())
+
+	// pprof, expvar and /debug/runtime with DEBUG_ENDPOINTS=true, behind the admin key
+	mux.Handle(diagnostics.Prefix, adminIPs(diagnostics.Handler(diagnostics.AdminKey(os.Getenv("ADMIN_KEY")))))
 
 	logger.Info().Msg("Starting HTTP server on :8082")
-	if err := http.ListenAndServe(":8082", nil); err != nil {
+	if err := http.ListenAndServe(":8082", tracing.Middleware("secret-service",
+		httpmetrics.Middleware("secret-service", httpmetrics.ServeMuxRoute(mux))(mux))); err != nil {
 		logger.Fatal().Err(err).Msg("HTTP server failed")
 	}
 }
@@ -0,0 +1,396 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.21.12
+// source: secret.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetSecretRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` // "openai.api_key"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSecretRequest) Reset() {
+	*x = GetSecretRequest{}
+	mi := &file_secret_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSecretRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSecretRequest) ProtoMessage() {}
+
+func (x *GetSecretRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secret_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSecretRequest.ProtoReflect.Descriptor instead.
+func (*GetSecretRequest) Descriptor() ([]byte, []int) {
+	return file_secret_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetSecretRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type GetSecretResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         string                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSecretResponse) Reset() {
+	*x = GetSecretResponse{}
+	mi := &file_secret_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSecretResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSecretResponse) ProtoMessage() {}
+
+func (x *GetSecretResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secret_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSecretResponse.ProtoReflect.Descriptor instead.
+func (*GetSecretResponse) Descriptor() ([]byte, []int) {
+	return file_secret_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetSecretResponse) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type GetUserSecretRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	SecretName    string                 `protobuf:"bytes,2,opt,name=secret_name,json=secretName,proto3" json:"secret_name,omitempty"` // "openai.api_key"
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUserSecretRequest) Reset() {
+	*x = GetUserSecretRequest{}
+	mi := &file_secret_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUserSecretRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUserSecretRequest) ProtoMessage() {}
+
+func (x *GetUserSecretRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secret_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUserSecretRequest.ProtoReflect.Descriptor instead.
+func (*GetUserSecretRequest) Descriptor() ([]byte, []int) {
+	return file_secret_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetUserSecretRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *GetUserSecretRequest) GetSecretName() string {
+	if x != nil {
+		return x.SecretName
+	}
+	return ""
+}
+
+type GetUserSecretResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Value         string                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUserSecretResponse) Reset() {
+	*x = GetUserSecretResponse{}
+	mi := &file_secret_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUserSecretResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUserSecretResponse) ProtoMessage() {}
+
+func (x *GetUserSecretResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secret_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUserSecretResponse.ProtoReflect.Descriptor instead.
+func (*GetUserSecretResponse) Descriptor() ([]byte, []int) {
+	return file_secret_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetUserSecretResponse) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+type SetUserSecretRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	SecretName    string                 `protobuf:"bytes,2,opt,name=secret_name,json=secretName,proto3" json:"secret_name,omitempty"` // "openai.api_key"
+	SecretValue   string                 `protobuf:"bytes,3,opt,name=secret_value,json=secretValue,proto3" json:"secret_value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetUserSecretRequest) Reset() {
+	*x = SetUserSecretRequest{}
+	mi := &file_secret_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetUserSecretRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetUserSecretRequest) ProtoMessage() {}
+
+func (x *SetUserSecretRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_secret_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetUserSecretRequest.ProtoReflect.Descriptor instead.
+func (*SetUserSecretRequest) Descriptor() ([]byte, []int) {
+	return file_secret_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SetUserSecretRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *SetUserSecretRequest) GetSecretName() string {
+	if x != nil {
+		return x.SecretName
+	}
+	return ""
+}
+
+func (x *SetUserSecretRequest) GetSecretValue() string {
+	if x != nil {
+		return x.SecretValue
+	}
+	return ""
+}
+
+type SetUserSecretResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        string                 `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetUserSecretResponse) Reset() {
+	*x = SetUserSecretResponse{}
+	mi := &file_secret_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetUserSecretResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetUserSecretResponse) ProtoMessage() {}
+
+func (x *SetUserSecretResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_secret_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetUserSecretResponse.ProtoReflect.Descriptor instead.
+func (*SetUserSecretResponse) Descriptor() ([]byte, []int) {
+	return file_secret_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SetUserSecretResponse) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+var File_secret_proto protoreflect.FileDescriptor
+
+const file_secret_proto_rawDesc = "" +
+	"\n" +
+	"\fsecret.proto\x12\x06secret\"&\n" +
+	"\x10GetSecretRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\")\n" +
+	"\x11GetSecretResponse\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\tR\x05value\"P\n" +
+	"\x14GetUserSecretRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x1f\n" +
+	"\vsecret_name\x18\x02 \x01(\tR\n" +
+	"secretName\"-\n" +
+	"\x15GetUserSecretResponse\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\tR\x05value\"s\n" +
+	"\x14SetUserSecretRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x1f\n" +
+	"\vsecret_name\x18\x02 \x01(\tR\n" +
+	"secretName\x12!\n" +
+	"\fsecret_value\x18\x03 \x01(\tR\vsecretValue\"/\n" +
+	"\x15SetUserSecretResponse\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status2\xed\x01\n" +
+	"\rSecretService\x12@\n" +
+	"\tGetSecret\x12\x18.secret.GetSecretRequest\x1a\x19.secret.GetSecretResponse\x12L\n" +
+	"\rGetUserSecret\x12\x1c.secret.GetUserSecretRequest\x1a\x1d.secret.GetUserSecretResponse\x12L\n" +
+	"\rSetUserSecret\x12\x1c.secret.SetUserSecretRequest\x1a\x1d.secret.SetUserSecretResponseB4Z2github.com/MaksimVF/ZB/services/secrets-service/pbb\x06proto3"
+
+var (
+	file_secret_proto_rawDescOnce sync.Once
+	file_secret_proto_rawDescData []byte
+)
+
+func file_secret_proto_rawDescGZIP() []byte {
+	file_secret_proto_rawDescOnce.Do(func() {
+		file_secret_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_secret_proto_rawDesc), len(file_secret_proto_rawDesc)))
+	})
+	return file_secret_proto_rawDescData
+}
+
+var file_secret_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_secret_proto_goTypes = []any{
+	(*GetSecretRequest)(nil),      // 0: secret.GetSecretRequest
+	(*GetSecretResponse)(nil),     // 1: secret.GetSecretResponse
+	(*GetUserSecretRequest)(nil),  // 2: secret.GetUserSecretRequest
+	(*GetUserSecretResponse)(nil), // 3: secret.GetUserSecretResponse
+	(*SetUserSecretRequest)(nil),  // 4: secret.SetUserSecretRequest
+	(*SetUserSecretResponse)(nil), // 5: secret.SetUserSecretResponse
+}
+var file_secret_proto_depIdxs = []int32{
+	0, // 0: secret.SecretService.GetSecret:input_type -> secret.GetSecretRequest
+	2, // 1: secret.SecretService.GetUserSecret:input_type -> secret.GetUserSecretRequest
+	4, // 2: secret.SecretService.SetUserSecret:input_type -> secret.SetUserSecretRequest
+	1, // 3: secret.SecretService.GetSecret:output_type -> secret.GetSecretResponse
+	3, // 4: secret.SecretService.GetUserSecret:output_type -> secret.GetUserSecretResponse
+	5, // 5: secret.SecretService.SetUserSecret:output_type -> secret.SetUserSecretResponse
+	3, // [3:6] is the sub-list for method output_type
+	0, // [0:3] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_secret_proto_init() }
+func file_secret_proto_init() {
+	if File_secret_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_secret_proto_rawDesc), len(file_secret_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_secret_proto_goTypes,
+		DependencyIndexes: file_secret_proto_depIdxs,
+		MessageInfos:      file_secret_proto_msgTypes,
+	}.Build()
+	File_secret_proto = out.File
+	file_secret_proto_goTypes = nil
+	file_secret_proto_depIdxs = nil
+}
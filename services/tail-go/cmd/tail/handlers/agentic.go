@@ -14,11 +14,14 @@ import (
 "sync"
 "time"
 
-"github.com/go-redis/redis/v8"
+"github.com/MaksimVF/ZB/pkg/apierror"
+"github.com/MaksimVF/ZB/pkg/ratelimit"
+"github.com/MaksimVF/ZB/pkg/redisconn"
+"github.com/MaksimVF/ZB/pkg/tokenizer"
 "llm-gateway-pro/services/gateway/internal/secrets"
 )
 
-var rdbAgentic = redis.NewClient(&redis.Options{Addr: "redis:6379"})
+var rdbAgentic = redisconn.MustNew(redisconn.FromEnv())
 
 type AgenticRequest struct {
 Model    string                   `json:"model"`
@@ -61,7 +64,7 @@ XCachedTools   int `json:"x_cached_tools,omitempty"`
 func AgenticHandler(w http.ResponseWriter, r *http.Request) {
 var req AgenticRequest
 if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-http.Error(w, `{"error":"invalid json"}`, 400)
+apierror.Write(w, 400, "invalid json")
 return
 }
 
@@ -83,7 +86,7 @@ allowed := map[string]string{
 }
 provider, ok := allowed[req.Model]
 if !ok {
-http.Error(w, `{"error":"model not allowed for agentic endpoint"}`, 400)
+apierror.Write(w, 400, "model not allowed for agentic endpoint")
 return
 }
 
@@ -113,9 +116,11 @@ httpReq.Header.Set("x-api-key", apiKey)
 httpReq.Header.Set("anthropic-version", "2023-06-01")
 }
 
+ticket := ratelimit.TicketFromContext(r.Context())
 resp, err := http.DefaultClient.Do(httpReq)
 if err != nil {
-http.Error(w, `{"error":"provider error"}`, 502)
+ticket.Refund()
+apierror.Write(w, 502, "provider error")
 return
 }
 defer resp.Body.Close()
@@ -147,6 +152,9 @@ return
 wg.Wait()
 }
 
+promptTok, completionTok := agenticUsage(req, providerResp, toolCalls)
+ticket.Consume(promptTok + completionTok)
+
 final := AgenticResponse{
 ID:      "agentic-" + fmt.Sprintf("%d", time.Now().UnixNano()),
 Object:  "agentic.completion",
@@ -169,7 +177,10 @@ CompletionTokens int `json:"completion_tokens"`
 TotalTokens      int `json:"total_tokens"`
 ToolCalls        int `json:"tool_calls,omitempty"`
 }{
-ToolCalls: len(toolCalls),
+PromptTokens:     promptTok,
+CompletionTokens: completionTok,
+TotalTokens:      promptTok + completionTok,
+ToolCalls:        len(toolCalls),
 },
 XParallelCalls: len(toolCalls),
 XCachedTools:   cached,
@@ -180,6 +191,35 @@ w.Header().Set("X-Agentic-Endpoint", "true")
 json.NewEncoder(w).Encode(final)
 }
 
+// agenticUsage берёт usage из ответа провайдера, а если его нет — считает токенизатором
+func agenticUsage(req AgenticRequest, providerResp map[string]interface{}, toolCalls []ToolCall) (int, int) {
+if usage, ok := providerResp["usage"].(map[string]interface{}); ok {
+prompt, _ := usage["prompt_tokens"].(float64)
+completion, _ := usage["completion_tokens"].(float64)
+if prompt == 0 {
+// Anthropic
+prompt, _ = usage["input_tokens"].(float64)
+completion, _ = usage["output_tokens"].(float64)
+}
+if prompt > 0 {
+return int(prompt), int(completion)
+}
+}
+
+messages := make([]tokenizer.Message, 0, len(req.Messages))
+for _, m := range req.Messages {
+role, _ := m["role"].(string)
+content, ok := m["content"].(string)
+if !ok {
+raw, _ := json.Marshal(m["content"])
+content = string(raw)
+}
+messages = append(messages, tokenizer.Message{Role: role, Content: content})
+}
+rawCalls, _ := json.Marshal(toolCalls)
+return tokenizer.CountMessages(req.Model, messages), tokenizer.Count(req.Model, string(rawCalls))
+}
+
 func extractToolCalls(resp map[string]interface{}) []ToolCall {
 var calls []ToolCall
 choices := resp["choices"].([]interface{})
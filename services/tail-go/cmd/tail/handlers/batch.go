@@ -12,6 +12,10 @@ import (
 "strings"
 "time"
 
+"github.com/MaksimVF/ZB/pkg/apierror"
+"github.com/MaksimVF/ZB/pkg/compress"
+"github.com/MaksimVF/ZB/pkg/requestid"
+"github.com/MaksimVF/ZB/pkg/tracing"
 "github.com/google/uuid"
 "google.golang.org/grpc"
 "google.golang.org/grpc/credentials/insecure"
@@ -54,19 +58,19 @@ BaseURL  string
 func BatchSubmit(w http.ResponseWriter, r *http.Request) {
 var req BatchRequest
 if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+apierror.Write(w, http.StatusBadRequest, "invalid json")
 return
 }
 
 if len(req.Requests) == 0 || len(req.Requests) > 500 {
-http.Error(w, `{"error":"batch size must be 1-500"}`, http.StatusBadRequest)
+apierror.Write(w, http.StatusBadRequest, "batch size must be 1-500")
 return
 }
 
 mode := strings.ToLower(strings.TrimSpace(req.Mode))
 if mode == "" || mode == "sync" {
 // === SYNC режим ===
-results := processBatchSync(req.Requests)
+results := processBatchSync(r.Context(), req.Requests)
 resp := map[string]interface{}{
 "object":     "list",
 "data":       results,
@@ -80,35 +84,25 @@ return
 }
 
 // === ASYNC режим ===
-batchID := "batch_" + uuid.New().String()
-created := time.Now().Unix()
-
-// Сохраняем в Redis для обработки
-key := "batch:pending:" + batchID
-data := map[string]interface{}{
-"status":     "queued",
-"created_at": created,
-"requests":   req.Requests,
-}
-if err := redisClient.HSet(r.Context(), key, data).Err(); err != nil {
+// Асинхронные батчи идут через тот же конвейер, что и /v1/batches
+batch, err := createBatchFromItems(r.Context(), r.Header.Get("X-User-ID"), req.Requests)
+if err != nil {
 log.Printf("Redis error: %v", err)
-http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+apierror.Write(w, http.StatusInternalServerError, "internal error")
 return
 }
-redisClient.Expire(r.Context(), key, 7*24*time.Hour)
-redisClient.LPush(r.Context(), "batch_queue", batchID)
 
 resp := BatchResponse{
-BatchID:   batchID,
-Status:    "queued",
-CreatedAt: created,
+BatchID:   batch.ID,
+Status:    batch.Status,
+CreatedAt: batch.CreatedAt,
 }
 w.Header().Set("Content-Type", "application/json")
 json.NewEncoder(w).Encode(resp)
 }
 
-// Синхронная обработка батча
-func processBatchSync(items []BatchItem) []map[string]interface{} {
+// Синхронная обработка батча; ctx — контекст запроса клиента
+func processBatchSync(ctx context.Context, items []BatchItem) []map[string]interface{} {
     results := make([]map[string]interface{}, len(items))
 
     // Convert items to the new BatchGenRequest format
@@ -121,15 +115,20 @@ func processBatchSync(items []BatchItem) []map[string]interface{} {
             messages = append(messages, fmt.Sprintf("%s: %s", msg.Role, msg.Content))
         }
 
-        // Create GenRequest for each item
-        batchRequests = append(batchRequests, &model.GenRequest{
+        // Create GenRequest for each item; optional parameters keep proto defaults when unset
+        genReq := &model.GenRequest{
             RequestId:   item.CustomID,
             Model:       item.Model,
             Messages:    messages,
-            Temperature:  float32(*item.Temperature),
-            MaxTokens:   int32(*item.MaxTokens),
             Stream:      false,
-        })
+        }
+        if item.Temperature != nil {
+            genReq.Temperature = *item.Temperature
+        }
+        if item.MaxTokens != nil {
+            genReq.MaxTokens = int32(*item.MaxTokens)
+        }
+        batchRequests = append(batchRequests, genReq)
     }
 
     // Call the new BatchGenerate method
@@ -138,23 +137,23 @@ func processBatchSync(items []BatchItem) []map[string]interface{} {
     }
 
     // Call the head-go service via gRPC
-    conn, err := grpc.Dial("head-go:50052", grpc.WithTransportCredentials(insecure.NewCredentials()))
+    conn, err := grpc.Dial("head-go:50052", grpc.WithTransportCredentials(insecure.NewCredentials()), tracing.DialOption(), requestid.DialOption(), compress.DialOption())
     if err != nil {
         log.Printf("Failed to connect to head-go: %v", err)
         // Fallback to individual processing
-        return processBatchSyncFallback(items)
+        return processBatchSyncFallback(ctx, items)
     }
     defer conn.Close()
 
     client := model.NewModelServiceClient(conn)
-    ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+    ctx, cancel := context.WithTimeout(ctx, 300*time.Second)
     defer cancel()
 
     batchResp, err := client.BatchGenerate(ctx, batchReq)
     if err != nil {
         log.Printf("BatchGenerate failed: %v", err)
         // Fallback to individual processing
-        return processBatchSyncFallback(items)
+        return processBatchSyncFallback(ctx, items)
     }
 
     // Convert responses to the expected format
@@ -171,61 +170,12 @@ func processBatchSync(items []BatchItem) []map[string]interface{} {
 }
 
 // Fallback method in case BatchGenerate fails
-func processBatchSyncFallback(items []BatchItem) []map[string]interface{} {
+func processBatchSyncFallback(ctx context.Context, items []BatchItem) []map[string]interface{} {
     results := make([]map[string]interface{}, len(items))
     client := &http.Client{Timeout: 300 * time.Second}
 
     for i, item := range items {
-        cfg, ok := providerConfig[item.Model]
-        if !ok {
-            results[i] = map[string]interface{}{
-                "custom_id": item.CustomID,
-                "error":     "unknown model",
-            }
-            continue
-        }
-
-        // Получаем актуальный API-ключ из Vault
-        apiKey, err := secrets.Get(fmt.Sprintf("llm/%s/api_key", cfg.Provider))
-        if err != nil {
-            log.Printf("Secret error for %s: %v", cfg.Provider, err)
-            results[i] = map[string]interface{}{
-                "custom_id": item.CustomID,
-                "error":     "provider configuration error",
-            }
-            continue
-        }
-
-        // Формируем тело запроса
-        body := map[string]interface{}{
-            "model":       item.Model,
-            "messages":    item.Messages,
-            "max_tokens":  item.MaxTokens,
-            "temperature": item.Temperature,
-        }
-        jsonBody, _ := json.Marshal(body)
-
-        // URL зависит от провайдера
-        url := cfg.BaseURL + "/v1/chat/completions"
-        if cfg.Provider == "anthropic" {
-            url = cfg.BaseURL + "/v1/messages"
-        }
-
-        req, _ := http.NewRequest("POST", url, bytes.NewReader(jsonBody))
-        req.Header.Set("Content-Type", "application/json")
-
-        // Разные заголовки авторизации
-        switch cfg.Provider {
-        case "openai", "groq":
-            req.Header.Set("Authorization", "Bearer "+apiKey)
-        case "anthropic":
-            req.Header.Set("x-api-key", apiKey)
-            req.Header.Set("anthropic-version", "2023-06-01")
-        case "google":
-            req.URL.RawQuery = "key=" + apiKey
-        }
-
-        resp, err := client.Do(req)
+        status, result, err := executeBatchItem(ctx, client, item)
         if err != nil {
             results[i] = map[string]interface{}{
                 "custom_id": item.CustomID,
@@ -233,17 +183,70 @@ func processBatchSyncFallback(items []BatchItem) []map[string]interface{} {
             }
             continue
         }
-        defer resp.Body.Close()
-
-        bodyBytes, _ := io.ReadAll(resp.Body)
-        var result map[string]interface{}
-        json.Unmarshal(bodyBytes, &result)
 
         results[i] = map[string]interface{}{
             "custom_id": item.CustomID,
             "response":  result,
-            "status":    resp.StatusCode,
+            "status":    status,
         }
     }
     return results
 }
+
+// executeBatchItem sends a single batch item directly to its provider and
+// returns the provider status code and decoded response body. Failures no
+// retry can fix are a permanentError.
+func executeBatchItem(ctx context.Context, client *http.Client, item BatchItem) (int, map[string]interface{}, error) {
+    cfg, ok := providerConfig[item.Model]
+    if !ok {
+        return 0, nil, permanentError("unknown model")
+    }
+
+    // Получаем актуальный API-ключ из Vault
+    apiKey, err := secrets.Get(fmt.Sprintf("llm/%s/api_key", cfg.Provider))
+    if err != nil {
+        log.Printf("Secret error for %s: %v", cfg.Provider, err)
+        return 0, nil, permanentError("provider configuration error")
+    }
+
+    // Формируем тело запроса
+    body := map[string]interface{}{
+        "model":       item.Model,
+        "messages":    item.Messages,
+        "max_tokens":  item.MaxTokens,
+        "temperature": item.Temperature,
+    }
+    jsonBody, _ := json.Marshal(body)
+
+    // URL зависит от провайдера
+    url := cfg.BaseURL + "/v1/chat/completions"
+    if cfg.Provider == "anthropic" {
+        url = cfg.BaseURL + "/v1/messages"
+    }
+
+    req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+    req.Header.Set("Content-Type", "application/json")
+
+    // Разные заголовки авторизации
+    switch cfg.Provider {
+    case "openai", "groq":
+        req.Header.Set("Authorization", "Bearer "+apiKey)
+    case "anthropic":
+        req.Header.Set("x-api-key", apiKey)
+        req.Header.Set("anthropic-version", "2023-06-01")
+    case "google":
+        req.URL.RawQuery = "key=" + apiKey
+    }
+
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, nil, err
+    }
+    defer resp.Body.Close()
+
+    bodyBytes, _ := io.ReadAll(resp.Body)
+    var result map[string]interface{}
+    json.Unmarshal(bodyBytes, &result)
+
+    return resp.StatusCode, result, nil
+}
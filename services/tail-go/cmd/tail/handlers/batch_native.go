@@ -0,0 +1,663 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"llm-gateway-pro/services/gateway/internal/secrets"
+)
+
+// Батчи, отправленные в Batch API провайдера и ожидающие результатов
+const nativeBatchPending = "batch_native_pending"
+
+// nativeBatchMinItems — с какого размера батч уходит в Batch API провайдера
+// (BATCH_NATIVE_MIN_ITEMS, 0 отключает); меньшие батчи выполняет пул воркеров
+var nativeBatchMinItems = loadNativeBatchMinItems()
+
+// nativeBatchPollInterval — как часто опрашивается статус у провайдера
+var nativeBatchPollInterval = time.Duration(envInt("BATCH_NATIVE_POLL_SECONDS", 60)) * time.Second
+
+func loadNativeBatchMinItems() int {
+	if v, err := strconv.Atoi(os.Getenv("BATCH_NATIVE_MIN_ITEMS")); err == nil && v >= 0 {
+		return v
+	}
+	return 100
+}
+
+func nativeBatchKey(id string) string     { return "batch:" + id + ":native" }
+func nativeBatchLockKey(id string) string { return "batch:" + id + ":native:lock" }
+
+// nativeBatch связывает батч ZB с заданием у провайдера
+type nativeBatch struct {
+	Provider        string `json:"provider"`
+	ProviderBatchID string `json:"provider_batch_id"`
+	Owner           string `json:"owner"`
+	// CustomIDs in input order: requests the provider did not return are
+	// reported as failed, and Anthropic results are matched by index
+	CustomIDs       []string `json:"custom_ids"`
+	CancelRequested bool     `json:"cancel_requested,omitempty"`
+	SubmittedAt     int64    `json:"submitted_at"`
+}
+
+// nativeBatchProgress is the provider's view of a submitted batch
+type nativeBatchProgress struct {
+	Ended     bool
+	Expired   bool
+	Completed int
+	Failed    int
+	// resultRefs are the provider's result files or URLs
+	resultRefs []string
+}
+
+// nativeBatchAdapter speaks one provider's batch API
+type nativeBatchAdapter interface {
+	submit(ctx context.Context, c nativeClient, lines []BatchRequestLine) (string, error)
+	poll(ctx context.Context, c nativeClient, id string) (nativeBatchProgress, error)
+	cancel(ctx context.Context, c nativeClient, id string) error
+	// results returns the provider's result lines, keyed by ZB custom_id
+	results(ctx context.Context, c nativeClient, state *nativeBatch, progress nativeBatchProgress) (map[string]BatchResultLine, error)
+}
+
+// Провайдеры со своим Batch API (половина цены, результаты в течение 24 часов)
+var nativeBatchAdapters = map[string]nativeBatchAdapter{
+	"openai":    openAIBatches{},
+	"anthropic": anthropicBatches{},
+}
+
+// nativeBatchProvider возвращает провайдера, которому можно отдать батч
+// целиком: батч достаточно большой и все его модели у одного провайдера с
+// Batch API
+func nativeBatchProvider(lines []BatchRequestLine) (string, bool) {
+	if nativeBatchMinItems == 0 || len(lines) < nativeBatchMinItems {
+		return "", false
+	}
+
+	provider := ""
+	for _, line := range lines {
+		cfg, ok := providerConfig[line.Body.Model]
+		if !ok || (provider != "" && cfg.Provider != provider) {
+			return "", false
+		}
+		provider = cfg.Provider
+	}
+	if _, ok := nativeBatchAdapters[provider]; !ok {
+		return "", false
+	}
+	return provider, true
+}
+
+// newNativeClient готовит клиента провайдера с ключом из Vault
+func newNativeClient(provider string) (nativeClient, error) {
+	var baseURL string
+	for _, cfg := range providerConfig {
+		if cfg.Provider == provider {
+			baseURL = cfg.BaseURL
+			break
+		}
+	}
+	if baseURL == "" {
+		return nativeClient{}, fmt.Errorf("unknown provider %s", provider)
+	}
+
+	apiKey, err := secrets.Get(fmt.Sprintf("llm/%s/api_key", provider))
+	if err != nil {
+		return nativeClient{}, fmt.Errorf("provider configuration error: %w", err)
+	}
+	return nativeClient{
+		provider: provider,
+		baseURL:  baseURL,
+		apiKey:   apiKey,
+		http:     &http.Client{Timeout: 300 * time.Second},
+	}, nil
+}
+
+// submitNativeBatch отправляет провалидированный батч в Batch API провайдера.
+// Дальше батч ведёт опрос из StartNativeBatchPoller.
+func submitNativeBatch(ctx context.Context, batch *Batch, provider string, lines []BatchRequestLine) error {
+	client, err := newNativeClient(provider)
+	if err != nil {
+		return err
+	}
+
+	providerID, err := nativeBatchAdapters[provider].submit(ctx, client, lines)
+	if err != nil {
+		return err
+	}
+
+	state := &nativeBatch{
+		Provider:        provider,
+		ProviderBatchID: providerID,
+		CustomIDs:       make([]string, len(lines)),
+		SubmittedAt:     time.Now().Unix(),
+	}
+	for i, line := range lines {
+		state.CustomIDs[i] = line.CustomID
+	}
+	if input, err := loadFile(ctx, batch.InputFileID); err == nil {
+		state.Owner = input.UserID
+	}
+	if err := saveNativeBatch(ctx, batch.ID, state); err != nil {
+		// Задание у провайдера уже создано — без состояния его не забрать
+		nativeBatchAdapters[provider].cancel(ctx, client, providerID)
+		return err
+	}
+
+	batch.Status = BatchStatusInProgress
+	batch.InProgressAt = time.Now().Unix()
+	batch.RequestCounts.Total = len(lines)
+	saveBatch(ctx, batch)
+
+	progressKey := batchProgressKey(batch.ID)
+	rdb.HSet(ctx, progressKey, "total", len(lines), "completed", 0, "failed", 0, "retried", 0)
+	rdb.Expire(ctx, progressKey, 7*24*time.Hour)
+	rdb.SAdd(ctx, nativeBatchPending, batch.ID)
+
+	log.Printf("Batch %s submitted to %s batch API as %s (%d requests)", batch.ID, provider, providerID, len(lines))
+	return nil
+}
+
+func saveNativeBatch(ctx context.Context, id string, state *nativeBatch) error {
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, nativeBatchKey(id), raw, 7*24*time.Hour).Err()
+}
+
+func loadNativeBatch(ctx context.Context, id string) (*nativeBatch, error) {
+	raw, err := rdb.Get(ctx, nativeBatchKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var state nativeBatch
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// StartNativeBatchPoller опрашивает провайдеров о батчах, отправленных в их
+// Batch API. Каждый батч за один проход опрашивает одна реплика.
+func StartNativeBatchPoller(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(nativeBatchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			ids, err := rdb.SMembers(ctx, nativeBatchPending).Result()
+			if err != nil {
+				log.Printf("Native batch poll error: %v", err)
+				continue
+			}
+			for _, id := range ids {
+				claimed, err := rdb.SetNX(ctx, nativeBatchLockKey(id), 1, nativeBatchPollInterval).Result()
+				if err != nil || !claimed {
+					continue
+				}
+				pollNativeBatch(ctx, id)
+			}
+		}
+	}()
+}
+
+// pollNativeBatch передаёт отмену провайдеру, обновляет прогресс и по
+// завершении задания сводит его результаты в файлы батча ZB
+func pollNativeBatch(ctx context.Context, id string) {
+	batch, err := loadBatch(ctx, id)
+	if err != nil {
+		log.Printf("Native batch %s: %v", id, err)
+		rdb.SRem(ctx, nativeBatchPending, id)
+		return
+	}
+	state, err := loadNativeBatch(ctx, id)
+	if err != nil {
+		log.Printf("Native batch %s lost its provider state: %v", id, err)
+		rdb.SRem(ctx, nativeBatchPending, id)
+		failBatch(ctx, batch, BatchError{Code: "provider_batch_lost", Message: "provider batch state not found"})
+		return
+	}
+
+	adapter := nativeBatchAdapters[state.Provider]
+	client, err := newNativeClient(state.Provider)
+	if err != nil {
+		log.Printf("Native batch %s: %v", id, err)
+		return
+	}
+
+	if batch.Status == BatchStatusCancelling && !state.CancelRequested {
+		if err := adapter.cancel(ctx, client, state.ProviderBatchID); err != nil {
+			log.Printf("Failed to cancel %s batch %s: %v", state.Provider, state.ProviderBatchID, err)
+			return
+		}
+		state.CancelRequested = true
+		saveNativeBatch(ctx, id, state)
+	}
+
+	progress, err := adapter.poll(ctx, client, state.ProviderBatchID)
+	if err != nil {
+		log.Printf("Failed to poll %s batch %s: %v", state.Provider, state.ProviderBatchID, err)
+		return
+	}
+	rdb.HSet(ctx, batchProgressKey(id), "completed", progress.Completed, "failed", progress.Failed)
+	if !progress.Ended {
+		if batch.Status == BatchStatusInProgress {
+			batch.RequestCounts.Completed, batch.RequestCounts.Failed = progress.Completed, progress.Failed
+			saveBatch(ctx, batch)
+		}
+		return
+	}
+
+	results, err := adapter.results(ctx, client, state, progress)
+	if err != nil {
+		log.Printf("Failed to fetch results of %s batch %s: %v", state.Provider, state.ProviderBatchID, err)
+		return
+	}
+	mergeNativeResults(ctx, batch, state, progress, results)
+	rdb.SRem(ctx, nativeBatchPending, id)
+	rdb.Del(ctx, nativeBatchKey(id))
+}
+
+// mergeNativeResults пишет результаты провайдера в формате ZB в порядке
+// входного файла. Запросы без результата считаются упавшими.
+func mergeNativeResults(ctx context.Context, batch *Batch, state *nativeBatch, progress nativeBatchProgress, results map[string]BatchResultLine) {
+	var output, errorsOut bytes.Buffer
+	batch.RequestCounts.Completed, batch.RequestCounts.Failed = 0, 0
+
+	for _, customID := range state.CustomIDs {
+		result, ok := results[customID]
+		if !ok {
+			code, message := "request_not_processed", "provider batch ended without a result for this request"
+			switch {
+			case state.CancelRequested:
+				code, message = "batch_cancelled", "batch was cancelled before this request was processed"
+			case progress.Expired:
+				code, message = "batch_expired", "batch expired before this request was processed"
+			}
+			result = BatchResultLine{ID: "batch_req_" + customID, CustomID: customID, Error: &BatchError{Code: code, Message: message}}
+		}
+
+		if result.Error != nil || result.Response == nil || result.Response.StatusCode >= 400 {
+			json.NewEncoder(&errorsOut).Encode(result)
+			batch.RequestCounts.Failed++
+			continue
+		}
+		json.NewEncoder(&output).Encode(result)
+		batch.RequestCounts.Completed++
+		recordNativeBatchUsage(state.Owner, result.Response.Body)
+	}
+	rdb.HSet(ctx, batchProgressKey(batch.ID), "completed", batch.RequestCounts.Completed, "failed", batch.RequestCounts.Failed)
+
+	status := BatchStatusCompleted
+	switch {
+	case state.CancelRequested:
+		status = BatchStatusCancelled
+	case progress.Expired && batch.RequestCounts.Completed == 0:
+		status = BatchStatusExpired
+	}
+	finishBatch(ctx, batch, status, &output, &errorsOut)
+}
+
+// recordNativeBatchUsage ставит в billing_usage использование запроса,
+// выполненного в Batch API провайдера, по цене батча
+func recordNativeBatchUsage(userID string, body map[string]interface{}) {
+	model, _ := body["model"].(string)
+	usage, _ := body["usage"].(map[string]interface{})
+	// OpenAI: prompt_tokens/completion_tokens, Anthropic: input_tokens/output_tokens
+	prompt := jsonInt(usage["prompt_tokens"]) + jsonInt(usage["input_tokens"])
+	completion := jsonInt(usage["completion_tokens"]) + jsonInt(usage["output_tokens"])
+
+	raw, _ := json.Marshal(map[string]interface{}{
+		"user_id":           userID,
+		"model":             model,
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+		"partial":           false,
+		"batch":             true,
+		"cost_usd":          prices.BatchChatCost(model, prompt, completion),
+		"timestamp":         time.Now().Unix(),
+	})
+	if err := rdb.LPush(context.Background(), "billing_usage", raw).Err(); err != nil {
+		log.Printf("Failed to record usage: %v", err)
+	}
+}
+
+func jsonInt(v interface{}) int {
+	n, _ := v.(float64)
+	return int(n)
+}
+
+// nativeBatchBody is the chat request sent for a batch item, as in executeBatchItem
+func nativeBatchBody(item BatchItem) map[string]interface{} {
+	body := map[string]interface{}{
+		"model":    item.Model,
+		"messages": item.Messages,
+	}
+	if item.MaxTokens != nil {
+		body["max_tokens"] = *item.MaxTokens
+	}
+	if item.Temperature != nil {
+		body["temperature"] = *item.Temperature
+	}
+	return body
+}
+
+// nativeClient is an authenticated HTTP client of one provider
+type nativeClient struct {
+	provider string
+	baseURL  string
+	apiKey   string
+	http     *http.Client
+}
+
+// do sends a request to path (or an absolute URL) and decodes a JSON
+// response into out; with out == nil the raw body is returned
+func (c nativeClient) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) ([]byte, error) {
+	url := path
+	if !strings.HasPrefix(path, "http") {
+		url = c.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch c.provider {
+	case "anthropic":
+		req.Header.Set("x-api-key", c.apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	default:
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, truncateBytes(raw, 512))
+	}
+	if out != nil {
+		return raw, json.Unmarshal(raw, out)
+	}
+	return raw, nil
+}
+
+func (c nativeClient) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		raw, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(raw)
+	}
+	_, err := c.do(ctx, method, path, "application/json", body, out)
+	return err
+}
+
+func truncateBytes(b []byte, n int) string {
+	if len(b) > n {
+		return string(b[:n]) + "..."
+	}
+	return string(b)
+}
+
+// openAIBatches — OpenAI Batch API: JSONL-файл запросов загружается с
+// purpose=batch, результаты приходят файлами в формате, который ZB и так использует
+type openAIBatches struct{}
+
+type openAIBatchObject struct {
+	ID            string      `json:"id"`
+	Status        string      `json:"status"`
+	OutputFileID  string      `json:"output_file_id"`
+	ErrorFileID   string      `json:"error_file_id"`
+	RequestCounts BatchCounts `json:"request_counts"`
+}
+
+func (openAIBatches) submit(ctx context.Context, c nativeClient, lines []BatchRequestLine) (string, error) {
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	for _, line := range lines {
+		if err := enc.Encode(map[string]interface{}{
+			"custom_id": line.CustomID,
+			"method":    "POST",
+			"url":       "/v1/chat/completions",
+			"body":      nativeBatchBody(line.Body),
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("purpose", "batch")
+	part, err := mw.CreateFormFile("file", "batch_input.jsonl")
+	if err != nil {
+		return "", err
+	}
+	part.Write(input.Bytes())
+	mw.Close()
+
+	var file struct {
+		ID string `json:"id"`
+	}
+	if _, err := c.do(ctx, http.MethodPost, "/v1/files", mw.FormDataContentType(), &form, &file); err != nil {
+		return "", fmt.Errorf("upload input file: %w", err)
+	}
+
+	var batch openAIBatchObject
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/batches", map[string]string{
+		"input_file_id":     file.ID,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	}, &batch); err != nil {
+		return "", fmt.Errorf("create batch: %w", err)
+	}
+	return batch.ID, nil
+}
+
+func (openAIBatches) poll(ctx context.Context, c nativeClient, id string) (nativeBatchProgress, error) {
+	var batch openAIBatchObject
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/batches/"+id, nil, &batch); err != nil {
+		return nativeBatchProgress{}, err
+	}
+
+	progress := nativeBatchProgress{
+		Completed: batch.RequestCounts.Completed,
+		Failed:    batch.RequestCounts.Failed,
+		Expired:   batch.Status == BatchStatusExpired,
+	}
+	switch batch.Status {
+	case BatchStatusCompleted, BatchStatusFailed, BatchStatusExpired, BatchStatusCancelled:
+		progress.Ended = true
+	}
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID != "" {
+			progress.resultRefs = append(progress.resultRefs, fileID)
+		}
+	}
+	return progress, nil
+}
+
+func (openAIBatches) cancel(ctx context.Context, c nativeClient, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/v1/batches/"+id+"/cancel", nil, nil)
+}
+
+func (openAIBatches) results(ctx context.Context, c nativeClient, state *nativeBatch, progress nativeBatchProgress) (map[string]BatchResultLine, error) {
+	results := make(map[string]BatchResultLine, len(state.CustomIDs))
+	for _, fileID := range progress.resultRefs {
+		raw, err := c.do(ctx, http.MethodGet, "/v1/files/"+fileID+"/content", "", nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range bytes.Split(raw, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var result BatchResultLine
+			if err := json.Unmarshal(line, &result); err != nil {
+				return nil, fmt.Errorf("invalid result line: %w", err)
+			}
+			results[result.CustomID] = result
+		}
+	}
+	return results, nil
+}
+
+// anthropicBatches — Anthropic Message Batches API. custom_id у Anthropic
+// ограничен 64 символами [a-zA-Z0-9_-], поэтому запросы нумеруются, а
+// custom_id ZB восстанавливается по номеру.
+type anthropicBatches struct{}
+
+type anthropicBatchObject struct {
+	ID               string `json:"id"`
+	ProcessingStatus string `json:"processing_status"`
+	ResultsURL       string `json:"results_url"`
+	RequestCounts    struct {
+		Processing int `json:"processing"`
+		Succeeded  int `json:"succeeded"`
+		Errored    int `json:"errored"`
+		Canceled   int `json:"canceled"`
+		Expired    int `json:"expired"`
+	} `json:"request_counts"`
+}
+
+// Anthropic требует max_tokens
+const anthropicDefaultMaxTokens = 1024
+
+func anthropicCustomID(i int) string { return "req-" + strconv.Itoa(i) }
+
+func (anthropicBatches) submit(ctx context.Context, c nativeClient, lines []BatchRequestLine) (string, error) {
+	requests := make([]map[string]interface{}, 0, len(lines))
+	for i, line := range lines {
+		// Системные сообщения Anthropic принимает отдельным полем
+		var system []string
+		messages := make([]Message, 0, len(line.Body.Messages))
+		for _, m := range line.Body.Messages {
+			if m.Role == "system" {
+				system = append(system, m.Content)
+				continue
+			}
+			messages = append(messages, m)
+		}
+
+		params := nativeBatchBody(line.Body)
+		params["messages"] = messages
+		if len(system) > 0 {
+			params["system"] = strings.Join(system, "\n\n")
+		}
+		if _, ok := params["max_tokens"]; !ok {
+			params["max_tokens"] = anthropicDefaultMaxTokens
+		}
+		requests = append(requests, map[string]interface{}{
+			"custom_id": anthropicCustomID(i),
+			"params":    params,
+		})
+	}
+
+	var batch anthropicBatchObject
+	if err := c.doJSON(ctx, http.MethodPost, "/v1/messages/batches", map[string]interface{}{"requests": requests}, &batch); err != nil {
+		return "", fmt.Errorf("create message batch: %w", err)
+	}
+	return batch.ID, nil
+}
+
+func (anthropicBatches) poll(ctx context.Context, c nativeClient, id string) (nativeBatchProgress, error) {
+	var batch anthropicBatchObject
+	if err := c.doJSON(ctx, http.MethodGet, "/v1/messages/batches/"+id, nil, &batch); err != nil {
+		return nativeBatchProgress{}, err
+	}
+
+	counts := batch.RequestCounts
+	progress := nativeBatchProgress{
+		Ended:     batch.ProcessingStatus == "ended",
+		Expired:   counts.Expired > 0,
+		Completed: counts.Succeeded,
+		Failed:    counts.Errored + counts.Canceled + counts.Expired,
+	}
+	if batch.ResultsURL != "" {
+		progress.resultRefs = []string{batch.ResultsURL}
+	}
+	return progress, nil
+}
+
+func (anthropicBatches) cancel(ctx context.Context, c nativeClient, id string) error {
+	return c.doJSON(ctx, http.MethodPost, "/v1/messages/batches/"+id+"/cancel", nil, nil)
+}
+
+func (anthropicBatches) results(ctx context.Context, c nativeClient, state *nativeBatch, progress nativeBatchProgress) (map[string]BatchResultLine, error) {
+	index := make(map[string]string, len(state.CustomIDs))
+	for i, customID := range state.CustomIDs {
+		index[anthropicCustomID(i)] = customID
+	}
+
+	results := make(map[string]BatchResultLine, len(state.CustomIDs))
+	for _, url := range progress.resultRefs {
+		raw, err := c.do(ctx, http.MethodGet, url, "", nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range bytes.Split(raw, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var entry struct {
+				CustomID string `json:"custom_id"`
+				Result   struct {
+					Type    string                 `json:"type"`
+					Message map[string]interface{} `json:"message"`
+					Error   json.RawMessage        `json:"error"`
+				} `json:"result"`
+			}
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return nil, fmt.Errorf("invalid result line: %w", err)
+			}
+			customID, ok := index[entry.CustomID]
+			if !ok {
+				continue
+			}
+
+			result := BatchResultLine{ID: "batch_req_" + entry.CustomID, CustomID: customID}
+			switch entry.Result.Type {
+			case "succeeded":
+				id, _ := entry.Result.Message["id"].(string)
+				result.Response = &BatchResultResponse{StatusCode: http.StatusOK, RequestID: id, Body: entry.Result.Message}
+			case "errored":
+				result.Error = &BatchError{Code: "request_failed", Message: string(entry.Result.Error)}
+			case "canceled":
+				result.Error = &BatchError{Code: "batch_cancelled", Message: "batch was cancelled before this request was processed"}
+			case "expired":
+				result.Error = &BatchError{Code: "batch_expired", Message: "batch expired before this request was processed"}
+			default:
+				result.Error = &BatchError{Code: "request_failed", Message: "unknown result type " + entry.Result.Type}
+			}
+			results[customID] = result
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// nativeServer serves a provider's batch API with h; the client is
+// authenticated with "test-key"
+func nativeServer(t *testing.T, provider string, h http.HandlerFunc) nativeClient {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return nativeClient{provider: provider, baseURL: srv.URL, apiKey: "test-key", http: srv.Client()}
+}
+
+// jsonLines encodes values one per line
+func jsonLines(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range values {
+		enc.Encode(v)
+	}
+	return buf.Bytes()
+}
+
+func TestOpenAIBatches(t *testing.T) {
+	maxTokens := 64
+	lines := []BatchRequestLine{testLine("a", "gpt-4o-mini"), testLine("b", "gpt-4o-mini")}
+	lines[1].Body.MaxTokens = &maxTokens
+	ctx := context.Background()
+
+	var uploaded []byte
+	var cancelled bool
+	client := nativeServer(t, "openai", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("%s %s: Authorization %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/files":
+			if purpose := r.FormValue("purpose"); purpose != "batch" {
+				t.Errorf("purpose %q, want batch", purpose)
+			}
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("input file: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			uploaded, _ = io.ReadAll(file)
+			w.Write([]byte(`{"id":"file-input"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/batches":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["input_file_id"] != "file-input" || req["endpoint"] != "/v1/chat/completions" || req["completion_window"] != "24h" {
+				t.Errorf("create batch %v", req)
+			}
+			w.Write([]byte(`{"id":"batch_openai","status":"validating"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/batches/batch_openai":
+			w.Write([]byte(`{"id":"batch_openai","status":"completed","output_file_id":"file-out","error_file_id":"file-err","request_counts":{"total":3,"completed":1,"failed":1}}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/batches/batch_running":
+			w.Write([]byte(`{"id":"batch_running","status":"in_progress","request_counts":{"total":3,"completed":1}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/batches/batch_openai/cancel":
+			cancelled = true
+			w.Write([]byte(`{"id":"batch_openai","status":"cancelling"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/files/file-out/content":
+			w.Write(jsonLines(BatchResultLine{ID: "batch_req_1", CustomID: "a", Response: &BatchResultResponse{StatusCode: 200, Body: map[string]interface{}{"id": "chatcmpl-a"}}}))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/files/file-err/content":
+			w.Write(jsonLines(BatchResultLine{ID: "batch_req_2", CustomID: "b", Error: &BatchError{Code: "rate_limit_exceeded", Message: "too many tokens"}}))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/batches/batch_missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"No batch found with id 'batch_missing'."}}`))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	adapter := openAIBatches{}
+
+	id, err := adapter.submit(ctx, client, lines)
+	if err != nil || id != "batch_openai" {
+		t.Fatalf("submit: id %q, err %v", id, err)
+	}
+	type inputLine struct {
+		CustomID string                 `json:"custom_id"`
+		Method   string                 `json:"method"`
+		URL      string                 `json:"url"`
+		Body     map[string]interface{} `json:"body"`
+	}
+	var requests []inputLine
+	for _, line := range bytes.Split(bytes.TrimSpace(uploaded), []byte("\n")) {
+		var req inputLine
+		if err := json.Unmarshal(line, &req); err != nil {
+			t.Fatalf("input line %s: %v", line, err)
+		}
+		requests = append(requests, req)
+	}
+	if len(requests) != 2 || requests[0].CustomID != "a" || requests[0].Method != http.MethodPost || requests[0].URL != "/v1/chat/completions" {
+		t.Fatalf("input %s", uploaded)
+	}
+	if _, ok := requests[0].Body["max_tokens"]; ok || requests[1].Body["max_tokens"] != 64.0 || requests[1].Body["model"] != "gpt-4o-mini" {
+		t.Errorf("request bodies %v, %v", requests[0].Body, requests[1].Body)
+	}
+
+	progress, err := adapter.poll(ctx, client, "batch_running")
+	if err != nil || progress.Ended || progress.Completed != 1 || len(progress.resultRefs) != 0 {
+		t.Errorf("running batch: progress %+v, err %v", progress, err)
+	}
+	progress, err = adapter.poll(ctx, client, "batch_openai")
+	if err != nil || !progress.Ended || progress.Expired || progress.Completed != 1 || progress.Failed != 1 {
+		t.Fatalf("completed batch: progress %+v, err %v", progress, err)
+	}
+	if fmt.Sprint(progress.resultRefs) != "[file-out file-err]" {
+		t.Errorf("result refs %v, want the output and error files", progress.resultRefs)
+	}
+
+	results, err := adapter.results(ctx, client, &nativeBatch{CustomIDs: []string{"a", "b", "c"}}, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results["a"].Response.Body["id"] != "chatcmpl-a" || results["b"].Error.Code != "rate_limit_exceeded" {
+		t.Errorf("results %+v", results)
+	}
+
+	if err := adapter.cancel(ctx, client, "batch_openai"); err != nil || !cancelled {
+		t.Errorf("cancel: err %v, cancelled %v", err, cancelled)
+	}
+	if _, err := adapter.poll(ctx, client, "batch_missing"); err == nil {
+		t.Error("poll of an unknown batch succeeded")
+	}
+}
+
+func TestAnthropicBatches(t *testing.T) {
+	lines := []BatchRequestLine{testLine("a", "claude-3-5-haiku"), testLine("b", "claude-3-5-haiku"), testLine("c", "claude-3-5-haiku"), testLine("d", "claude-3-5-haiku")}
+	lines[0].Body.Messages = []Message{{Role: "system", Content: "Be brief."}, {Role: "system", Content: "Answer in English."}, {Role: "user", Content: "a"}}
+	ctx := context.Background()
+
+	var resultsURL string
+	client := nativeServer(t, "anthropic", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "test-key" || r.Header.Get("anthropic-version") != "2023-06-01" || r.Header.Get("Authorization") != "" {
+			t.Errorf("%s %s: headers %v", r.Method, r.URL.Path, r.Header)
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/messages/batches":
+			var req struct {
+				Requests []struct {
+					CustomID string `json:"custom_id"`
+					Params   struct {
+						System    string    `json:"system"`
+						Messages  []Message `json:"messages"`
+						MaxTokens int       `json:"max_tokens"`
+					} `json:"params"`
+				} `json:"requests"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if len(req.Requests) != 4 || req.Requests[0].CustomID != "req-0" || req.Requests[3].CustomID != "req-3" {
+				t.Errorf("requests %+v", req.Requests)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			first := req.Requests[0].Params
+			if first.System != "Be brief.\n\nAnswer in English." || len(first.Messages) != 1 || first.Messages[0].Role != "user" {
+				t.Errorf("system messages not moved to params: %+v", first)
+			}
+			if first.MaxTokens != anthropicDefaultMaxTokens {
+				t.Errorf("max_tokens %d, want the default %d", first.MaxTokens, anthropicDefaultMaxTokens)
+			}
+			w.Write([]byte(`{"id":"msgbatch_1","processing_status":"in_progress"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_1":
+			fmt.Fprintf(w, `{"id":"msgbatch_1","processing_status":"ended","results_url":%q,"request_counts":{"succeeded":1,"errored":1,"canceled":1,"expired":1}}`, resultsURL)
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_1/results":
+			w.Write(jsonLines(
+				map[string]interface{}{"custom_id": "req-0", "result": map[string]interface{}{"type": "succeeded", "message": map[string]interface{}{"id": "msg_a", "model": "claude-3-5-haiku"}}},
+				map[string]interface{}{"custom_id": "req-1", "result": map[string]interface{}{"type": "errored", "error": map[string]string{"type": "invalid_request_error"}}},
+				map[string]interface{}{"custom_id": "req-2", "result": map[string]interface{}{"type": "canceled"}},
+				map[string]interface{}{"custom_id": "req-3", "result": map[string]interface{}{"type": "expired"}},
+				map[string]interface{}{"custom_id": "req-9", "result": map[string]interface{}{"type": "succeeded"}},
+			))
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	resultsURL = client.baseURL + "/v1/messages/batches/msgbatch_1/results"
+	adapter := anthropicBatches{}
+
+	id, err := adapter.submit(ctx, client, lines)
+	if err != nil || id != "msgbatch_1" {
+		t.Fatalf("submit: id %q, err %v", id, err)
+	}
+
+	progress, err := adapter.poll(ctx, client, id)
+	if err != nil || !progress.Ended || !progress.Expired || progress.Completed != 1 || progress.Failed != 3 {
+		t.Fatalf("progress %+v, err %v", progress, err)
+	}
+	if len(progress.resultRefs) != 1 || progress.resultRefs[0] != resultsURL {
+		t.Errorf("result refs %v, want the results URL", progress.resultRefs)
+	}
+
+	state := &nativeBatch{CustomIDs: []string{"a", "b", "c", "d"}}
+	results, err := adapter.results(ctx, client, state, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("%d results, want 4 without the unknown custom_id: %+v", len(results), results)
+	}
+	if a := results["a"]; a.Response == nil || a.Response.StatusCode != http.StatusOK || a.Response.RequestID != "msg_a" || a.CustomID != "a" {
+		t.Errorf("succeeded result %+v", a)
+	}
+	for customID, code := range map[string]string{"b": "request_failed", "c": "batch_cancelled", "d": "batch_expired"} {
+		if result := results[customID]; result.Error == nil || result.Error.Code != code {
+			t.Errorf("%s: result %+v, want error %s", customID, result, code)
+		}
+	}
+	if !strings.Contains(results["b"].Error.Message, "invalid_request_error") {
+		t.Errorf("errored result lacks the provider's error: %q", results["b"].Error.Message)
+	}
+}
+
+func TestMergeNativeResults(t *testing.T) {
+	succeeded := func(customID string) BatchResultLine {
+		return BatchResultLine{ID: "batch_req_" + customID, CustomID: customID, Response: &BatchResultResponse{
+			StatusCode: http.StatusOK,
+			Body:       map[string]interface{}{"model": "gpt-4o-mini", "usage": map[string]interface{}{"prompt_tokens": 10.0, "completion_tokens": 5.0}},
+		}}
+	}
+	rejected := BatchResultLine{ID: "batch_req_c", CustomID: "c", Response: &BatchResultResponse{StatusCode: http.StatusBadRequest}}
+
+	tests := []struct {
+		name      string
+		cancelled bool
+		progress  nativeBatchProgress
+		results   map[string]BatchResultLine
+		status    string
+		completed int
+		// missing is the error code of the requests without a result
+		missing string
+	}{
+		{
+			name:      "completed",
+			results:   map[string]BatchResultLine{"a": succeeded("a"), "b": succeeded("b"), "c": rejected},
+			status:    BatchStatusCompleted,
+			completed: 2,
+		},
+		{
+			name:      "request without a result",
+			results:   map[string]BatchResultLine{"a": succeeded("a"), "c": rejected},
+			status:    BatchStatusCompleted,
+			completed: 1,
+			missing:   "request_not_processed",
+		},
+		{
+			name:      "cancelled",
+			cancelled: true,
+			results:   map[string]BatchResultLine{"a": succeeded("a"), "c": rejected},
+			status:    BatchStatusCancelled,
+			completed: 1,
+			missing:   "batch_cancelled",
+		},
+		{
+			name:      "expired with some results",
+			progress:  nativeBatchProgress{Expired: true},
+			results:   map[string]BatchResultLine{"a": succeeded("a"), "c": rejected},
+			status:    BatchStatusCompleted,
+			completed: 1,
+			missing:   "batch_expired",
+		},
+		{
+			name:     "expired without results",
+			progress: nativeBatchProgress{Expired: true},
+			results:  map[string]BatchResultLine{"c": rejected},
+			status:   BatchStatusExpired,
+			missing:  "batch_expired",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			batch := &Batch{ID: fmt.Sprintf("batch_native_%d", i), Object: "batch", UserID: "alice", Status: BatchStatusInProgress}
+			saveBatch(ctx, batch)
+			state := &nativeBatch{Provider: "openai", Owner: "alice", CustomIDs: []string{"a", "b", "c"}, CancelRequested: tt.cancelled}
+			usage := rdb.LLen(ctx, "billing_usage").Val()
+
+			mergeNativeResults(ctx, batch, state, tt.progress, tt.results)
+
+			current, err := loadBatch(ctx, batch.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			failed := len(state.CustomIDs) - tt.completed
+			if current.Status != tt.status || current.RequestCounts.Completed != tt.completed || current.RequestCounts.Failed != failed {
+				t.Errorf("status %s, counts %+v; want %s with %d completed and %d failed", current.Status, current.RequestCounts, tt.status, tt.completed, failed)
+			}
+			progress := rdb.HGetAll(ctx, batchProgressKey(batch.ID)).Val()
+			if progress["completed"] != fmt.Sprint(tt.completed) || progress["failed"] != fmt.Sprint(failed) {
+				t.Errorf("progress %v", progress)
+			}
+			if got := rdb.LLen(ctx, "billing_usage").Val() - usage; got != int64(tt.completed) {
+				t.Errorf("%d usage records, want one per completed request", got)
+			}
+
+			if tt.completed > 0 {
+				if current.OutputFileID == "" {
+					t.Fatal("no output file")
+				}
+				output, _ := loadFileContent(ctx, current.OutputFileID)
+				if got := strings.Count(string(output), "\n"); got != tt.completed {
+					t.Errorf("%d output lines, want %d", got, tt.completed)
+				}
+			}
+			if current.ErrorFileID == "" {
+				t.Fatal("no error file")
+			}
+			raw, _ := loadFileContent(ctx, current.ErrorFileID)
+			var errorIDs []string
+			for _, line := range bytes.Split(bytes.TrimSpace(raw), []byte("\n")) {
+				var result BatchResultLine
+				if err := json.Unmarshal(line, &result); err != nil {
+					t.Fatalf("error line %s: %v", line, err)
+				}
+				errorIDs = append(errorIDs, result.CustomID)
+				if result.CustomID == "b" && tt.missing != "" && (result.Error == nil || result.Error.Code != tt.missing) {
+					t.Errorf("missing request: %+v, want error %s", result, tt.missing)
+				}
+			}
+			// Errors keep the order of the input file
+			if errorIDs[len(errorIDs)-1] != "c" {
+				t.Errorf("error lines %v, want c last", errorIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Очередь «мёртвых» элементов, которые не удалось выполнить после всех попыток
+const batchDeadLetterQueue = "batch_dead_letter"
+
+// BatchWorkerConfig controls how batch items are executed
+type BatchWorkerConfig struct {
+	Concurrency  int
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// batchWorkerConfig читается из окружения при старте:
+// BATCH_CONCURRENCY, BATCH_MAX_ATTEMPTS, BATCH_RETRY_DELAY_MS
+var batchWorkerConfig = loadBatchWorkerConfig()
+
+func loadBatchWorkerConfig() BatchWorkerConfig {
+	cfg := BatchWorkerConfig{
+		Concurrency:  4,
+		MaxAttempts:  3,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+	}
+	if v, err := strconv.Atoi(os.Getenv("BATCH_CONCURRENCY")); err == nil && v > 0 {
+		cfg.Concurrency = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BATCH_MAX_ATTEMPTS")); err == nil && v > 0 {
+		cfg.MaxAttempts = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("BATCH_RETRY_DELAY_MS")); err == nil && v > 0 {
+		cfg.InitialDelay = time.Duration(v) * time.Millisecond
+	}
+	return cfg
+}
+
+// DeadLetterItem is pushed to batch_dead_letter for items that exhausted their retries
+type DeadLetterItem struct {
+	BatchID  string           `json:"batch_id"`
+	CustomID string           `json:"custom_id"`
+	Request  BatchRequestLine `json:"request"`
+	Error    string           `json:"error"`
+	Attempts int              `json:"attempts"`
+	FailedAt int64            `json:"failed_at"`
+}
+
+// batchProgressKey хранит счётчики прогресса батча (total/completed/failed/retried)
+func batchProgressKey(id string) string { return "batch:" + id + ":progress" }
+
+// isRetryableStatus reports whether a provider status code is worth retrying
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// permanentError — ошибка элемента, которую повтор не исправит
+// (неизвестная модель, нет ключа провайдера)
+type permanentError string
+
+func (e permanentError) Error() string { return string(e) }
+
+// isRetryableError reports whether an item that failed with err is worth
+// retrying: network errors and timeouts are, permanent errors are not
+func isRetryableError(err error) bool {
+	var permanent permanentError
+	return !errors.As(err, &permanent)
+}
+
+// retryDelay returns exponential backoff with jitter for the given attempt (1-based)
+func (c BatchWorkerConfig) retryDelay(attempt int) time.Duration {
+	delay := c.InitialDelay << (attempt - 1)
+	if delay > c.MaxDelay || delay <= 0 {
+		delay = c.MaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+// executeWithRetry выполняет элемент батча с повторами и экспоненциальной
+// задержкой; ошибки, которые повтор не исправит, возвращаются сразу
+func executeWithRetry(ctx context.Context, client *http.Client, batchID string, line BatchRequestLine) (int, map[string]interface{}, int, error) {
+	var (
+		status int
+		body   map[string]interface{}
+		err    error
+	)
+
+	cfg := batchWorkerConfig
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		status, body, err = executeBatchItem(ctx, client, line.Body)
+		if err == nil && !isRetryableStatus(status) {
+			return status, body, attempt, nil
+		}
+		if ctx.Err() != nil {
+			return status, body, attempt, ctx.Err()
+		}
+		if err != nil && !isRetryableError(err) {
+			return status, body, attempt, err
+		}
+		if attempt == cfg.MaxAttempts {
+			break
+		}
+
+		rdb.HIncrBy(ctx, batchProgressKey(batchID), "retried", 1)
+		select {
+		case <-ctx.Done():
+			return status, body, attempt, ctx.Err()
+		case <-time.After(cfg.retryDelay(attempt)):
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("provider returned status %d", status)
+	}
+	return status, body, cfg.MaxAttempts, err
+}
+
+// deadLetter отправляет элемент в очередь DLQ для ручного разбора
+func deadLetter(ctx context.Context, batchID string, line BatchRequestLine, attempts int, cause error) {
+	raw, err := json.Marshal(DeadLetterItem{
+		BatchID:  batchID,
+		CustomID: line.CustomID,
+		Request:  line,
+		Error:    cause.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+	if err := rdb.LPush(ctx, batchDeadLetterQueue, raw).Err(); err != nil {
+		log.Printf("Failed to dead-letter item %s of batch %s: %v", line.CustomID, batchID, err)
+	}
+}
+
+// batchResults collects output and error lines from concurrent workers
+type batchResults struct {
+	mu        sync.Mutex
+	output    bytes.Buffer
+	errorsOut bytes.Buffer
+}
+
+func (r *batchResults) add(line BatchResultLine, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if failed {
+		json.NewEncoder(&r.errorsOut).Encode(line)
+	} else {
+		json.NewEncoder(&r.output).Encode(line)
+	}
+}
+
+// runBatchItems выполняет элементы батча пулом воркеров. Возвращает false,
+// если батч был отменён во время выполнения.
+func runBatchItems(ctx context.Context, batch *Batch, lines []BatchRequestLine, results *batchResults) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	progressKey := batchProgressKey(batch.ID)
+	rdb.HSet(ctx, progressKey, "total", len(lines), "completed", 0, "failed", 0, "retried", 0)
+	rdb.Expire(ctx, progressKey, 7*24*time.Hour)
+
+	var (
+		mu        sync.Mutex
+		cancelled bool
+		wg        sync.WaitGroup
+	)
+	jobs := make(chan BatchRequestLine)
+	client := &http.Client{Timeout: 300 * time.Second}
+
+	for i := 0; i < batchWorkerConfig.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for line := range jobs {
+				result := BatchResultLine{ID: "batch_req_" + uuid.New().String(), CustomID: line.CustomID}
+				status, body, attempts, err := executeWithRetry(ctx, client, batch.ID, line)
+
+				failed := true
+				switch {
+				case err != nil && ctx.Err() != nil:
+					// Батч отменён — элемент не считаем ни успешным, ни упавшим
+					continue
+				case err != nil:
+					result.Error = &BatchError{Code: "request_failed", Message: err.Error()}
+					if body != nil {
+						result.Response = &BatchResultResponse{StatusCode: status, RequestID: result.ID, Body: body}
+					}
+					deadLetter(ctx, batch.ID, line, attempts, err)
+				case status >= 400:
+					result.Response = &BatchResultResponse{StatusCode: status, RequestID: result.ID, Body: body}
+				default:
+					result.Response = &BatchResultResponse{StatusCode: status, RequestID: result.ID, Body: body}
+					failed = false
+				}
+				results.add(result, failed)
+
+				mu.Lock()
+				if failed {
+					batch.RequestCounts.Failed++
+					rdb.HIncrBy(ctx, progressKey, "failed", 1)
+				} else {
+					batch.RequestCounts.Completed++
+					rdb.HIncrBy(ctx, progressKey, "completed", 1)
+				}
+
+				// Проверяем отмену до сохранения прогресса, чтобы не затереть статус cancelling
+				if isBatchCancelled(ctx, batch.ID) {
+					cancelled = true
+					cancel()
+				} else {
+					saveBatch(ctx, batch)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, line := range lines {
+		select {
+		case jobs <- line:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return !cancelled
+}
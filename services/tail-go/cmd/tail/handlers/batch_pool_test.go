@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"llm-gateway-pro/services/gateway/internal/secrets"
+)
+
+// fakeProvider serves chat completions for the model "test-model" with h
+func fakeProvider(t *testing.T, h http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	providerConfig["test-model"] = struct {
+		Provider string
+		BaseURL  string
+	}{"openai", srv.URL}
+	t.Cleanup(func() { delete(providerConfig, "test-model") })
+	secrets.Set("llm/openai/api_key", "test-key")
+
+	cfg := batchWorkerConfig
+	batchWorkerConfig = BatchWorkerConfig{Concurrency: 2, MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	t.Cleanup(func() { batchWorkerConfig = cfg })
+}
+
+// waitForClient blocks until the client of r is gone; the server only
+// notices once the body was read
+func waitForClient(r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	<-r.Context().Done()
+}
+
+func testLine(customID, model string) BatchRequestLine {
+	return BatchRequestLine{
+		CustomID: customID,
+		Method:   http.MethodPost,
+		URL:      "/v1/chat/completions",
+		Body:     BatchItem{Model: model, Messages: []Message{{Role: "user", Content: customID}}},
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	cfg := BatchWorkerConfig{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second, 60: time.Second} {
+		if d := cfg.retryDelay(attempt); d < base || d > base+base/5 {
+			t.Errorf("attempt %d: %v, want %v plus up to 20%%", attempt, d, base)
+		}
+	}
+}
+
+func TestExecuteWithRetry(t *testing.T) {
+	var calls atomic.Int32
+	fakeProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("Authorization %q", r.Header.Get("Authorization"))
+		}
+		switch {
+		case strings.Contains(r.URL.Path, "/v1/chat/completions") && calls.Add(1) < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			json.NewEncoder(w).Encode(map[string]string{"id": "chatcmpl-1"})
+		}
+	})
+	ctx := context.Background()
+
+	status, body, attempts, err := executeWithRetry(ctx, http.DefaultClient, "batch_retry", testLine("a", "test-model"))
+	if err != nil || status != http.StatusOK || attempts != 3 || body["id"] != "chatcmpl-1" {
+		t.Fatalf("5xx twice: status %d, attempts %d, body %v, err %v", status, attempts, body, err)
+	}
+	if retried, _ := rdb.HGet(ctx, batchProgressKey("batch_retry"), "retried").Int(); retried != 2 {
+		t.Errorf("retried %d, want 2", retried)
+	}
+
+	_, _, attempts, err = executeWithRetry(ctx, http.DefaultClient, "batch_retry", testLine("b", "no-such-model"))
+	var permanent permanentError
+	if !errors.As(err, &permanent) || attempts != 1 {
+		t.Errorf("unknown model: attempts %d, err %v; want one attempt and a permanent error", attempts, err)
+	}
+}
+
+func TestExecuteWithRetryDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	fakeProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "bad request"})
+	})
+
+	status, _, attempts, err := executeWithRetry(context.Background(), http.DefaultClient, "batch_400", testLine("a", "test-model"))
+	if err != nil || status != http.StatusBadRequest || attempts != 1 || calls.Load() != 1 {
+		t.Errorf("400: status %d, attempts %d, calls %d, err %v", status, attempts, calls.Load(), err)
+	}
+}
+
+func TestExecuteWithRetryStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fakeProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		waitForClient(r)
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, _, err := executeWithRetry(ctx, http.DefaultClient, "batch_cancel", testLine("a", "test-model"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("err %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the provider call outlived its context")
+	}
+}
+
+func TestRunBatchItems(t *testing.T) {
+	fakeProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []Message `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Messages[0].Content == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"echo": req.Messages[0].Content})
+	})
+	ctx := context.Background()
+	batch := &Batch{ID: "batch_pool", Object: "batch", Status: BatchStatusInProgress}
+	saveBatch(ctx, batch)
+	lines := []BatchRequestLine{testLine("a", "test-model"), testLine("b", "test-model"), testLine("c", "test-model"), testLine("bad", "test-model"), testLine("x", "no-such-model")}
+	deadLetters := rdb.LLen(ctx, batchDeadLetterQueue).Val()
+
+	results := &batchResults{}
+	if !runBatchItems(ctx, batch, lines, results) {
+		t.Fatal("batch reported as cancelled")
+	}
+	if batch.RequestCounts.Completed != 3 || batch.RequestCounts.Failed != 2 {
+		t.Errorf("counts %+v, want 3 completed and 2 failed", batch.RequestCounts)
+	}
+	if got := strings.Count(results.output.String(), "\n"); got != 3 {
+		t.Errorf("%d output lines, want 3", got)
+	}
+	if got := strings.Count(results.errorsOut.String(), "\n"); got != 2 {
+		t.Errorf("%d error lines, want 2", got)
+	}
+	if got := rdb.LLen(ctx, batchDeadLetterQueue).Val() - deadLetters; got != 1 {
+		t.Errorf("%d items dead-lettered, want the unknown model only", got)
+	}
+	progress := rdb.HGetAll(ctx, batchProgressKey(batch.ID)).Val()
+	if progress["total"] != "5" || progress["completed"] != "3" || progress["failed"] != "2" {
+		t.Errorf("progress %v", progress)
+	}
+}
+
+func TestProcessBatchRequeuedOnShutdown(t *testing.T) {
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	fakeProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		shutdown()
+		waitForClient(r)
+	})
+
+	var input strings.Builder
+	for _, id := range []string{"a", "b", "c"} {
+		line, _ := json.Marshal(testLine(id, "test-model"))
+		input.Write(append(line, '\n'))
+	}
+	file, err := saveFile(context.Background(), "alice", "input.jsonl", "batch", []byte(input.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := enqueueBatch(context.Background(), "alice", CreateBatchRequest{InputFileID: file.ID, Endpoint: "/v1/chat/completions", CompletionWindow: "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb.LRem(context.Background(), batchQueue, 0, batch.ID)
+
+	processBatch(ctx, batch.ID)
+
+	current, err := loadBatch(context.Background(), batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Status != BatchStatusValidating || current.RequestCounts != (BatchCounts{}) {
+		t.Errorf("after shutdown: status %s, counts %+v; want validating and no counts", current.Status, current.RequestCounts)
+	}
+	if next := rdb.LIndex(context.Background(), batchQueue, -1).Val(); next != batch.ID {
+		t.Errorf("next batch in the queue %q, want %s", next, batch.ID)
+	}
+}
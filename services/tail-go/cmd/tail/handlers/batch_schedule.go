@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/go-redis/redis/v8"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/scheduler"
+)
+
+// batchScheduler отправляет отложенные и периодические батчи в обычную очередь
+var batchScheduler = scheduler.New(rdb, func(ctx context.Context, job *scheduler.Job) (string, error) {
+	// Батч принадлежит владельцу входного файла
+	input, err := loadFile(ctx, job.InputFileID)
+	if err != nil {
+		return "", err
+	}
+	batch, err := enqueueBatch(ctx, input.UserID, CreateBatchRequest{
+		InputFileID:      job.InputFileID,
+		Endpoint:         job.Endpoint,
+		CompletionWindow: "24h",
+		Metadata:         job.Metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+	return batch.ID, nil
+})
+
+// StartBatchScheduler запускает проверку запланированных батчей раз в 15 секунд
+func StartBatchScheduler(ctx context.Context) {
+	batchScheduler.Start(ctx, 15*time.Second)
+}
+
+type ScheduleBatchRequest struct {
+	InputFileID string            `json:"input_file_id"`
+	Endpoint    string            `json:"endpoint"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	RunAt       int64             `json:"run_at,omitempty"`
+	Cron        string            `json:"cron,omitempty"`
+}
+
+// ScheduleBatch handles POST /v1/batches/scheduled
+func ScheduleBatch(w http.ResponseWriter, r *http.Request) {
+	var req ScheduleBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if req.Endpoint == "" {
+		req.Endpoint = "/v1/chat/completions"
+	}
+	if req.Endpoint != "/v1/chat/completions" {
+		apierror.Write(w, http.StatusBadRequest, "unsupported endpoint")
+		return
+	}
+
+	file, err := loadUserFile(r.Context(), r.Header.Get("X-User-ID"), req.InputFileID)
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusBadRequest, "input file not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if file.Purpose != "batch" {
+		apierror.Write(w, http.StatusBadRequest, "input file must have purpose batch")
+		return
+	}
+
+	job := &scheduler.Job{
+		InputFileID: req.InputFileID,
+		Endpoint:    req.Endpoint,
+		Metadata:    req.Metadata,
+		RunAt:       req.RunAt,
+		Cron:        req.Cron,
+	}
+	if err := batchScheduler.Create(r.Context(), job); err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(job)
+}
+
+// ListScheduledBatches handles GET /admin/scheduled-batches
+func ListScheduledBatches(w http.ResponseWriter, r *http.Request) {
+	jobs, err := batchScheduler.List(r.Context())
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   jobs,
+	})
+}
+
+// PauseScheduledBatch handles POST /admin/scheduled-batches/{id}/pause
+func PauseScheduledBatch(w http.ResponseWriter, r *http.Request) {
+	updateScheduledBatch(w, r, batchScheduler.Pause)
+}
+
+// ResumeScheduledBatch handles POST /admin/scheduled-batches/{id}/resume
+func ResumeScheduledBatch(w http.ResponseWriter, r *http.Request) {
+	updateScheduledBatch(w, r, batchScheduler.Resume)
+}
+
+// CancelScheduledBatch handles POST /admin/scheduled-batches/{id}/cancel
+func CancelScheduledBatch(w http.ResponseWriter, r *http.Request) {
+	updateScheduledBatch(w, r, batchScheduler.Cancel)
+}
+
+func updateScheduledBatch(w http.ResponseWriter, r *http.Request, action func(context.Context, string) (*scheduler.Job, error)) {
+	job, err := action(r.Context(), r.PathValue("id"))
+	if errors.Is(err, scheduler.ErrJobNotFound) {
+		apierror.Write(w, http.StatusNotFound, "scheduled job not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/go-redis/redis/v8"
+)
+
+// Размер страницы результатов по умолчанию и максимальный
+const (
+	defaultResultsPageSize = 1000
+	maxResultsPageSize     = 10000
+)
+
+// BatchStatus is the progress view returned by GET /v1/batch/{id}
+type BatchStatus struct {
+	BatchID      string      `json:"batch_id"`
+	Status       string      `json:"status"`
+	CreatedAt    int64       `json:"created_at"`
+	CompletedAt  int64       `json:"completed_at,omitempty"`
+	Counts       BatchTotals `json:"counts"`
+	OutputFileID string      `json:"output_file_id,omitempty"`
+	ErrorFileID  string      `json:"error_file_id,omitempty"`
+}
+
+type BatchTotals struct {
+	Total     int `json:"total"`
+	Pending   int `json:"pending"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Retried   int `json:"retried"`
+}
+
+// isTerminalBatchStatus reports whether a batch will not change any more
+func isTerminalBatchStatus(status string) bool {
+	switch status {
+	case BatchStatusCompleted, BatchStatusFailed, BatchStatusCancelled, BatchStatusExpired:
+		return true
+	}
+	return false
+}
+
+// GetBatchStatus handles GET /v1/batch/{id}
+func GetBatchStatus(w http.ResponseWriter, r *http.Request) {
+	batch, err := loadUserBatch(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "batch not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	status := BatchStatus{
+		BatchID:      batch.ID,
+		Status:       batch.Status,
+		CreatedAt:    batch.CreatedAt,
+		CompletedAt:  batch.CompletedAt,
+		OutputFileID: batch.OutputFileID,
+		ErrorFileID:  batch.ErrorFileID,
+		Counts: BatchTotals{
+			Total:     batch.RequestCounts.Total,
+			Completed: batch.RequestCounts.Completed,
+			Failed:    batch.RequestCounts.Failed,
+		},
+	}
+
+	// Живые счётчики пула воркеров точнее, чем последний сохранённый объект батча
+	if progress, err := rdb.HGetAll(r.Context(), batchProgressKey(batch.ID)).Result(); err == nil && len(progress) > 0 {
+		status.Counts.Total, _ = strconv.Atoi(progress["total"])
+		status.Counts.Completed, _ = strconv.Atoi(progress["completed"])
+		status.Counts.Failed, _ = strconv.Atoi(progress["failed"])
+		status.Counts.Retried, _ = strconv.Atoi(progress["retried"])
+	}
+	status.Counts.Pending = status.Counts.Total - status.Counts.Completed - status.Counts.Failed
+	if status.Counts.Pending < 0 || isTerminalBatchStatus(batch.Status) {
+		status.Counts.Pending = 0
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// GetBatchResults handles GET /v1/batch/{id}/results and streams results as JSONL.
+// Query parameters: type=output|errors (default output), offset (line number) and limit.
+// X-Next-Offset is set when more lines are available.
+func GetBatchResults(w http.ResponseWriter, r *http.Request) {
+	batch, err := loadUserBatch(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "batch not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if !isTerminalBatchStatus(batch.Status) {
+		apierror.Write(w, http.StatusConflict, "batch is not finished yet")
+		return
+	}
+
+	query := r.URL.Query()
+	fileID := batch.OutputFileID
+	if query.Get("type") == "errors" {
+		fileID = batch.ErrorFileID
+	}
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultResultsPageSize
+	}
+	if limit > maxResultsPageSize {
+		limit = maxResultsPageSize
+	}
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	if fileID == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Файл результатов читается с проверкой владельца, как через /v1/files
+	file, err := loadUserFile(r.Context(), batch.UserID, fileID)
+	if err != nil {
+		apierror.Write(w, http.StatusGone, "results not available")
+		return
+	}
+	content, err := loadFileContent(r.Context(), file.ID)
+	if err != nil {
+		apierror.Write(w, http.StatusGone, "results not available")
+		return
+	}
+
+	var page bytes.Buffer
+	line, written := 0, 0
+	hasMore := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		if line >= offset {
+			if written == limit {
+				hasMore = true
+				break
+			}
+			page.Write(scanner.Bytes())
+			page.WriteByte('\n')
+			written++
+		}
+		line++
+	}
+
+	if hasMore {
+		w.Header().Set("X-Next-Offset", strconv.Itoa(offset+written))
+	}
+	w.Write(page.Bytes())
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestBatchResultsOfOwnerOnly(t *testing.T) {
+	ctx := context.Background()
+	batch, err := enqueueBatch(ctx, "alice", CreateBatchRequest{InputFileID: "file-in", Endpoint: "/v1/chat/completions", CompletionWindow: "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := bytes.NewBufferString(`{"custom_id":"a"}` + "\n" + `{"custom_id":"b"}` + "\n")
+	finishBatch(ctx, batch, BatchStatusCompleted, output, nil)
+
+	results := func(userID string) (int, string) {
+		rec := serve(GetBatchResults, "GET /v1/batch/{id}/results", http.MethodGet, "/v1/batch/"+batch.ID+"/results?limit=1", userID, "")
+		return rec.Code, rec.Body.String()
+	}
+	if code, body := results("alice"); code != http.StatusOK || body != `{"custom_id":"a"}`+"\n" {
+		t.Errorf("owner: %d %q", code, body)
+	}
+	if code, _ := results("bob"); code != http.StatusNotFound {
+		t.Errorf("other user: results = %d, want 404", code)
+	}
+	if code := serve(GetBatchStatus, "GET /v1/batch/{id}", http.MethodGet, "/v1/batch/"+batch.ID, "bob", "").Code; code != http.StatusNotFound {
+		t.Errorf("other user: status = %d, want 404", code)
+	}
+
+	// Выходной файл чужого пользователя не отдаётся, даже если батч его указывает
+	foreign, err := saveFile(ctx, "bob", "out.jsonl", "batch_output", []byte(`{"custom_id":"secret"}`+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch.OutputFileID = foreign.ID
+	saveBatch(ctx, batch)
+	if code, body := results("alice"); code != http.StatusGone {
+		t.Errorf("foreign output file: %d %q, want 410", code, body)
+	}
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Статусы жизненного цикла батча (совместимы с OpenAI Batch API)
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Очередь батчей в Redis
+const batchQueue = "batch_queue"
+
+// Batch is an OpenAI-compatible batch object
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	UserID           string            `json:"user_id,omitempty"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *BatchErrors      `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     string            `json:"output_file_id,omitempty"`
+	ErrorFileID      string            `json:"error_file_id,omitempty"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     int64             `json:"in_progress_at,omitempty"`
+	ExpiresAt        int64             `json:"expires_at"`
+	FinalizingAt     int64             `json:"finalizing_at,omitempty"`
+	CompletedAt      int64             `json:"completed_at,omitempty"`
+	FailedAt         int64             `json:"failed_at,omitempty"`
+	CancellingAt     int64             `json:"cancelling_at,omitempty"`
+	CancelledAt      int64             `json:"cancelled_at,omitempty"`
+	RequestCounts    BatchCounts       `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type BatchErrors struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+type BatchCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchRequestLine is one line of the JSONL input file
+type BatchRequestLine struct {
+	CustomID string    `json:"custom_id"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Body     BatchItem `json:"body"`
+}
+
+// BatchResultLine is one line of the JSONL output or error file
+type BatchResultLine struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *BatchResultResponse `json:"response"`
+	Error    *BatchError          `json:"error"`
+}
+
+type BatchResultResponse struct {
+	StatusCode int                    `json:"status_code"`
+	RequestID  string                 `json:"request_id"`
+	Body       map[string]interface{} `json:"body"`
+}
+
+type CreateBatchRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+func batchKey(id string) string           { return "batch:" + id }
+func userBatchesKey(userID string) string { return "batches:user:" + userID }
+
+// saveBatch persists the batch object
+func saveBatch(ctx context.Context, batch *Batch) error {
+	raw, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, batchKey(batch.ID), raw, 7*24*time.Hour).Err()
+}
+
+// loadBatch reads the batch object
+func loadBatch(ctx context.Context, id string) (*Batch, error) {
+	raw, err := rdb.Get(ctx, batchKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var batch Batch
+	if err := json.Unmarshal(raw, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// loadUserBatch reads the batch object if it belongs to userID; batches of
+// other users are reported as missing (redis.Nil)
+func loadUserBatch(ctx context.Context, userID, id string) (*Batch, error) {
+	batch, err := loadBatch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if batch.UserID != userID {
+		return nil, redis.Nil
+	}
+	return batch, nil
+}
+
+// enqueueBatch creates the batch object of userID and pushes it to the queue
+func enqueueBatch(ctx context.Context, userID string, req CreateBatchRequest) (*Batch, error) {
+	now := time.Now()
+	batch := &Batch{
+		ID:               "batch_" + uuid.New().String(),
+		Object:           "batch",
+		UserID:           userID,
+		Endpoint:         req.Endpoint,
+		InputFileID:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           BatchStatusValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        now.Add(24 * time.Hour).Unix(),
+		Metadata:         req.Metadata,
+	}
+
+	if err := saveBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	if err := rdb.LPush(ctx, batchQueue, batch.ID).Err(); err != nil {
+		return nil, err
+	}
+	rdb.ZAdd(ctx, userBatchesKey(userID), &redis.Z{Score: float64(batch.CreatedAt), Member: batch.ID})
+	rdb.Expire(ctx, userBatchesKey(userID), 7*24*time.Hour)
+
+	return batch, nil
+}
+
+// createBatchFromItems converts legacy /v1/batch items to a JSONL file of
+// userID and enqueues it
+func createBatchFromItems(ctx context.Context, userID string, items []BatchItem) (*Batch, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i, item := range items {
+		customID := item.CustomID
+		if customID == "" {
+			customID = fmt.Sprintf("request-%d", i+1)
+		}
+		if err := enc.Encode(BatchRequestLine{
+			CustomID: customID,
+			Method:   "POST",
+			URL:      "/v1/chat/completions",
+			Body:     item,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := saveFile(ctx, userID, "batch_input.jsonl", "batch", buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return enqueueBatch(ctx, userID, CreateBatchRequest{
+		InputFileID:      file.ID,
+		Endpoint:         "/v1/chat/completions",
+		CompletionWindow: "24h",
+	})
+}
+
+// CreateBatch handles POST /v1/batches
+func CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req CreateBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if req.Endpoint != "/v1/chat/completions" {
+		apierror.Write(w, http.StatusBadRequest, "unsupported endpoint")
+		return
+	}
+	if req.CompletionWindow == "" {
+		req.CompletionWindow = "24h"
+	}
+	if req.CompletionWindow != "24h" {
+		apierror.Write(w, http.StatusBadRequest, "completion_window must be 24h")
+		return
+	}
+
+	userID := r.Header.Get("X-User-ID")
+	file, err := loadUserFile(r.Context(), userID, req.InputFileID)
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusBadRequest, "input file not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if file.Purpose != "batch" {
+		apierror.Write(w, http.StatusBadRequest, "input file must have purpose batch")
+		return
+	}
+
+	batch, err := enqueueBatch(r.Context(), userID, req)
+	if err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// GetBatch handles GET /v1/batches/{id}
+func GetBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := loadUserBatch(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "batch not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// ListBatches handles GET /v1/batches: the caller's batches, newest first,
+// limit 20 by default
+func ListBatches(w http.ResponseWriter, r *http.Request) {
+	limit := int64(20)
+	fmt.Sscanf(r.URL.Query().Get("limit"), "%d", &limit)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	ids, err := rdb.ZRevRange(r.Context(), userBatchesKey(r.Header.Get("X-User-ID")), 0, limit-1).Result()
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	data := make([]*Batch, 0, len(ids))
+	for _, id := range ids {
+		batch, err := loadBatch(r.Context(), id)
+		if err != nil {
+			continue
+		}
+		data = append(data, batch)
+	}
+
+	resp := map[string]interface{}{
+		"object":   "list",
+		"data":     data,
+		"has_more": int64(len(ids)) == limit,
+	}
+	if len(data) > 0 {
+		resp["first_id"] = data[0].ID
+		resp["last_id"] = data[len(data)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// CancelBatch handles POST /v1/batches/{id}/cancel
+func CancelBatch(w http.ResponseWriter, r *http.Request) {
+	batch, err := loadUserBatch(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "batch not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	switch batch.Status {
+	case BatchStatusValidating, BatchStatusInProgress:
+		batch.Status = BatchStatusCancelling
+		batch.CancellingAt = time.Now().Unix()
+		if err := saveBatch(r.Context(), batch); err != nil {
+			apierror.Write(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+	case BatchStatusCancelling, BatchStatusCancelled:
+	default:
+		apierror.Write(w, http.StatusConflict, "batch cannot be cancelled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
+
+// StartBatchWorker забирает батчи из очереди Redis и обрабатывает их
+func StartBatchWorker(ctx context.Context) {
+	go func() {
+		for {
+			res, err := rdb.BRPop(ctx, 5*time.Second, batchQueue).Result()
+			if ctx.Err() != nil {
+				return
+			}
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				log.Printf("Batch queue error: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			processBatch(ctx, res[1])
+		}
+	}()
+}
+
+// isBatchCancelled re-reads the batch to pick up cancellation requests
+func isBatchCancelled(ctx context.Context, id string) bool {
+	current, err := loadBatch(ctx, id)
+	return err == nil && current.Status == BatchStatusCancelling
+}
+
+// processBatch валидирует входной файл и выполняет запросы пулом воркеров
+func processBatch(ctx context.Context, id string) {
+	batch, err := loadBatch(ctx, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			requeueBatch(id)
+			return
+		}
+		log.Printf("Batch %s not found: %v", id, err)
+		return
+	}
+	if batch.Status == BatchStatusCancelling {
+		finishBatch(ctx, batch, BatchStatusCancelled, nil, nil)
+		return
+	}
+
+	// === validating ===
+	content, err := loadFileContent(ctx, batch.InputFileID)
+	if err != nil {
+		if ctx.Err() != nil {
+			restartBatch(batch)
+			return
+		}
+		failBatch(ctx, batch, BatchError{Code: "invalid_input_file", Message: "input file not found"})
+		return
+	}
+
+	var lines []BatchRequestLine
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var line BatchRequestLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			failBatch(ctx, batch, BatchError{Code: "invalid_json_line", Message: err.Error(), Line: n})
+			return
+		}
+		if line.CustomID == "" || seen[line.CustomID] {
+			failBatch(ctx, batch, BatchError{Code: "invalid_custom_id", Message: "custom_id must be unique and non-empty", Line: n})
+			return
+		}
+		if line.URL != batch.Endpoint {
+			failBatch(ctx, batch, BatchError{Code: "mismatched_endpoint", Message: "url does not match batch endpoint", Line: n})
+			return
+		}
+		seen[line.CustomID] = true
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		failBatch(ctx, batch, BatchError{Code: "empty_file", Message: "input file contains no requests"})
+		return
+	}
+
+	// Большие батчи к одному провайдеру с Batch API выполняет сам провайдер
+	// со скидкой; если отправить не удалось, батч выполняет пул воркеров
+	if provider, ok := nativeBatchProvider(lines); ok {
+		err := submitNativeBatch(ctx, batch, provider, lines)
+		if err == nil {
+			return
+		}
+		log.Printf("Batch %s: %s batch API unavailable, running items directly: %v", batch.ID, provider, err)
+	}
+
+	// === in_progress ===
+	batch.Status = BatchStatusInProgress
+	batch.InProgressAt = time.Now().Unix()
+	batch.RequestCounts.Total = len(lines)
+	saveBatch(ctx, batch)
+
+	results := &batchResults{}
+	if !runBatchItems(ctx, batch, lines, results) {
+		finishBatch(ctx, batch, BatchStatusCancelled, &results.output, &results.errorsOut)
+		return
+	}
+	if ctx.Err() != nil {
+		log.Printf("Batch %s interrupted by shutdown, returned to the queue", batch.ID)
+		restartBatch(batch)
+		return
+	}
+
+	finishBatch(ctx, batch, BatchStatusCompleted, &results.output, &results.errorsOut)
+}
+
+// restartBatch возвращает прерванный остановкой батч в validating и в
+// очередь: его заново выполнит другая реплика или следующий запуск.
+// Контекст воркера уже отменён, поэтому используется свежий.
+func restartBatch(batch *Batch) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	batch.Status = BatchStatusValidating
+	batch.InProgressAt = 0
+	batch.RequestCounts = BatchCounts{}
+	if err := saveBatch(ctx, batch); err != nil {
+		log.Printf("Failed to save batch %s: %v", batch.ID, err)
+	}
+	rdb.Del(ctx, batchProgressKey(batch.ID))
+	requeueBatch(batch.ID)
+}
+
+// requeueBatch ставит id в голову очереди, откуда BRPop заберёт его первым
+func requeueBatch(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.RPush(ctx, batchQueue, id).Err(); err != nil {
+		log.Printf("Failed to requeue batch %s: %v", id, err)
+	}
+}
+
+// failBatch marks the batch as failed during validation
+func failBatch(ctx context.Context, batch *Batch, batchErr BatchError) {
+	batch.Status = BatchStatusFailed
+	batch.FailedAt = time.Now().Unix()
+	batch.Errors = &BatchErrors{Object: "list", Data: []BatchError{batchErr}}
+	if err := saveBatch(ctx, batch); err != nil {
+		log.Printf("Failed to save batch %s: %v", batch.ID, err)
+	}
+}
+
+// finishBatch writes the output and error files and sets the final status
+func finishBatch(ctx context.Context, batch *Batch, status string, output, errorsOut *bytes.Buffer) {
+	batch.Status = BatchStatusFinalizing
+	batch.FinalizingAt = time.Now().Unix()
+	saveBatch(ctx, batch)
+
+	// Результаты принадлежат владельцу батча; у батчей, созданных до учёта
+	// владельца, — владельцу входного файла
+	owner := batch.UserID
+	if input, err := loadFile(ctx, batch.InputFileID); err == nil && owner == "" {
+		owner = input.UserID
+	}
+	if output != nil && output.Len() > 0 {
+		if file, err := saveFile(ctx, owner, batch.ID+"_output.jsonl", "batch_output", output.Bytes()); err == nil {
+			batch.OutputFileID = file.ID
+		} else {
+			log.Printf("Failed to save output file for batch %s: %v", batch.ID, err)
+		}
+	}
+	if errorsOut != nil && errorsOut.Len() > 0 {
+		if file, err := saveFile(ctx, owner, batch.ID+"_errors.jsonl", "batch_output", errorsOut.Bytes()); err == nil {
+			batch.ErrorFileID = file.ID
+		} else {
+			log.Printf("Failed to save error file for batch %s: %v", batch.ID, err)
+		}
+	}
+
+	batch.Status = status
+	switch status {
+	case BatchStatusCompleted:
+		batch.CompletedAt = time.Now().Unix()
+	case BatchStatusCancelled:
+		batch.CancelledAt = time.Now().Unix()
+	}
+	if err := saveBatch(ctx, batch); err != nil {
+		log.Printf("Failed to save batch %s: %v", batch.ID, err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBatchesBelongToTheirOwner(t *testing.T) {
+	ctx := context.Background()
+	batch, err := enqueueBatch(ctx, "alice", CreateBatchRequest{InputFileID: "file-1", Endpoint: "/v1/chat/completions", CompletionWindow: "24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enqueueBatch(ctx, "bob", CreateBatchRequest{InputFileID: "file-2", Endpoint: "/v1/chat/completions", CompletionWindow: "24h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(userID string) int {
+		return serve(GetBatch, "GET /v1/batches/{id}", http.MethodGet, "/v1/batches/"+batch.ID, userID, "").Code
+	}
+	if code := get("alice"); code != http.StatusOK {
+		t.Errorf("owner: GET = %d", code)
+	}
+	if code := get("bob"); code != http.StatusNotFound {
+		t.Errorf("other user: GET = %d, want 404", code)
+	}
+	if code := serve(CancelBatch, "POST /v1/batches/{id}/cancel", http.MethodPost, "/v1/batches/"+batch.ID+"/cancel", "bob", "").Code; code != http.StatusNotFound {
+		t.Errorf("other user: cancel = %d, want 404", code)
+	}
+	if current, _ := loadBatch(ctx, batch.ID); current.Status != BatchStatusValidating {
+		t.Errorf("status after a foreign cancel: %s", current.Status)
+	}
+
+	rec := serve(ListBatches, "GET /v1/batches", http.MethodGet, "/v1/batches", "bob", "")
+	var list struct {
+		Data []Batch `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range list.Data {
+		if b.UserID != "bob" {
+			t.Errorf("bob lists batch %s of %q", b.ID, b.UserID)
+		}
+	}
+	if len(list.Data) == 0 {
+		t.Error("bob's own batch not listed")
+	}
+}
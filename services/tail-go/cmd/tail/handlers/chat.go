@@ -10,6 +10,7 @@ package handlers
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -19,6 +20,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/annotations"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/deadline"
+	"github.com/MaksimVF/ZB/pkg/pricing"
+	"github.com/MaksimVF/ZB/pkg/ratelimit"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/sse"
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+	"github.com/MaksimVF/ZB/pkg/usageevents"
 	"llm-gateway-pro/services/gateway/internal/secrets"
 	"llm-gateway-pro/services/tail-go/cmd/tail/internal"
 )
@@ -27,6 +37,19 @@ type OpenAIRequest struct {
 	Model    string    `json:"model"`
 	Messages []Message `json:"messages"`
 	Stream   bool      `json:"stream,omitempty"`
+	// ConversationID подставляет сохранённую историю диалога и дописывает в неё ответ
+	ConversationID string `json:"conversation_id,omitempty"`
+	// Retrieval дополняет промпт чанками из коллекции /v1/collections
+	Retrieval *RetrievalOptions `json:"retrieval,omitempty"`
+	// TemplateID подставляет шаблон /v1/prompt-templates перед messages;
+	// версия — TemplateVersion, закреплённая за API-ключом или последняя
+	TemplateID      string            `json:"template_id,omitempty"`
+	TemplateVersion int               `json:"template_version,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+	// ExperimentID распределяет запрос по вариантам эксперимента /v1/experiments
+	ExperimentID string `json:"experiment_id,omitempty"`
+	// N — число вариантов ответа; usage и стоимость считаются по всем
+	N int `json:"n,omitempty"`
 }
 
 type Message struct {
@@ -34,24 +57,119 @@ type Message struct {
 	Content string `json:"content"`
 }
 
+// prices attributes a cost to each response
+var prices = pricing.NewTable(rdb)
+
 func ChatCompletion(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid body")
+		return
+	}
 	var req OpenAIRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+	if err := json.Unmarshal(body, &req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
 		return
 	}
 
+	// ?async=true: запрос ставится в очередь, результат — по GET /v1/jobs/{id} или вебхуком
+	if r.URL.Query().Get("async") == "true" {
+		submitChatJob(w, r, body, req)
+		return
+	}
+
+	// Эксперимент: вариант может заменить модель, шаблон и temperature,
+	// поэтому он применяется до выбора провайдера
+	var assignment *experimentAssignment
+	if req.ExperimentID != "" {
+		if body, assignment, err = applyExperiment(r, r.Header.Get("X-User-ID"), body, &req); err != nil {
+			writeExperimentError(w, err)
+			return
+		}
+		if assignment != nil {
+			w.Header().Set("X-Experiment-ID", assignment.ExperimentID)
+			w.Header().Set("X-Experiment-Variant", assignment.Variant)
+		}
+	}
+
 	// Определяем провайдера по модели с использованием LiteLLM
 	provider, err := internal.GetProviderForModel(req.Model)
 	if err != nil {
 		log.Printf("Ошибка определения провайдера для модели %s: %v", req.Model, err)
-		http.Error(w, `{"error":"model not supported"}`, http.StatusBadRequest)
+		apierror.New(http.StatusBadRequest, "model not supported").WithParam("model").WithCode("model_not_found").Write(w)
+		return
+	}
+
+	if req.N < 0 || req.N > maxChoices {
+		apierror.New(http.StatusBadRequest, fmt.Sprintf("n must be between 1 and %d", maxChoices)).WithParam("n").Write(w)
+		return
+	}
+	if req.Stream && req.N > 1 && !nativeChoices[provider] {
+		apierror.New(http.StatusBadRequest, fmt.Sprintf("n > 1 cannot be streamed from %s", provider)).WithParam("n").Write(w)
 		return
 	}
 
 	// Get user ID from request (assuming it's in the header)
 	userID := r.Header.Get("X-User-ID")
 
+	// Check в rate-limiter только предавторизует запрос; токены списываются после ответа
+	ticket := ratelimit.TicketFromContext(r.Context())
+
+	// Шаблон промпта: его сообщения идут перед сообщениями запроса
+	var tmplUse *templateUse
+	if req.TemplateID != "" {
+		var rendered []Message
+		if tmplUse, rendered, err = renderRequestTemplate(r, userID, req); err != nil {
+			writeTemplateError(w, err)
+			return
+		}
+		req.Messages = append(rendered, req.Messages...)
+	}
+
+	// Собираем контекст из сохранённой истории диалога
+	var conv *Conversation
+	requestMessages := req.Messages
+	if req.ConversationID != "" {
+		if err := validateConversationMessages(req.Messages); err != nil {
+			apierror.Write(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		conv, req.Messages, err = conversationContext(r.Context(), userID, req.ConversationID, req.Model, req.Messages)
+		if err != nil {
+			writeConversationError(w, err)
+			return
+		}
+		w.Header().Set("X-Conversation-ID", conv.ID)
+	}
+
+	// RAG: добавляем в промпт top-k чанков из коллекции
+	if req.Retrieval != nil {
+		if req.Messages, err = augmentWithRetrieval(r.Context(), userID, *req.Retrieval, req.Messages); err != nil {
+			writeRetrievalError(w, err)
+			return
+		}
+	}
+
+	if conv != nil || req.Retrieval != nil || tmplUse != nil {
+		if body, err = withMessages(body, req.Messages); err != nil {
+			apierror.Write(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	}
+
+	// Семантический кэш: ответ на похожий промпт клиента к той же модели
+	// отдаётся без обращения к провайдеру
+	var cacheLookup *semanticLookup
+	if semanticCacheable(r, userID, req, conv, assignment) {
+		var hit *semanticHit
+		if hit, cacheLookup = lookupSemanticCache(r.Context(), userID, req, body); hit != nil {
+			ticket.Refund()
+			serveSemanticCacheHit(w, r, userID, req, tmplUse, hit, start)
+			return
+		}
+	}
+
 	// Try to get user-specific API key
 	var apiKey string
 	if userID != "" {
@@ -61,7 +179,7 @@ func ChatCompletion(w http.ResponseWriter, r *http.Request) {
 			apiKey, err = secrets.Get(fmt.Sprintf("llm/%s/api_key", provider))
 			if err != nil {
 				log.Printf("Ошибка получения секрета %s: %v", provider, err)
-				http.Error(w, `{"error":"internal configuration error"}`, http.StatusInternalServerError)
+				apierror.Write(w, http.StatusInternalServerError, "internal configuration error")
 				return
 			}
 			log.Printf("Using shared API key for user %s, provider %s", userID, provider)
@@ -73,7 +191,7 @@ func ChatCompletion(w http.ResponseWriter, r *http.Request) {
 		apiKey, err = secrets.Get(fmt.Sprintf("llm/%s/api_key", provider))
 		if err != nil {
 			log.Printf("Ошибка получения секрета %s: %v", provider, err)
-			http.Error(w, `{"error":"internal configuration error"}`, http.StatusInternalServerError)
+			apierror.Write(w, http.StatusInternalServerError, "internal configuration error")
 			return
 		}
 		log.Printf("Using shared API key for provider %s", provider)
@@ -83,58 +201,272 @@ func ChatCompletion(w http.ResponseWriter, r *http.Request) {
 	providerURL, err := internal.GetProviderBaseURL(provider)
 	if err != nil {
 		log.Printf("Ошибка получения базового URL для провайдера %s: %v", provider, err)
-		http.Error(w, `{"error":"provider configuration error"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "provider configuration error")
 		return
 	}
 
-	client := &http.Client{Timeout: 180 * time.Second}
+	// Дедлайн запроса — по политике таймаутов для модели (network config).
+	// Контекст ограничивает вызов провайдера и отменяет его, если клиент отключился.
+	ctx, cancel := deadline.ForModel(r.Context(), req.Model)
+	defer cancel()
+	client := http.DefaultClient
 
 	// Пересылаем тело почти без изменений
-	proxyReq, _ := http.NewRequest("POST", providerURL+"/v1/chat/completions", r.Body)
+	proxyReq, _ := http.NewRequestWithContext(ctx, "POST", providerURL+"/v1/chat/completions", bytes.NewReader(body))
 	proxyReq.Header.Set("Authorization", "Bearer "+apiKey)
 	proxyReq.Header.Set("Content-Type", "application/json")
+	// Провайдеры, которые логируют X-Request-ID, позволяют найти запрос и у них
+	proxyReq.Header.Set(requestid.Header, requestid.FromContext(r.Context()))
 
 	if req.Stream {
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
+		// Стоимость известна только в конце стрима — отдаём её трейлерами
+		w.Header().Set(annotations.HeaderProvider, provider)
+		annotations.DeclareTrailers(w.Header())
+
+		// Вызов провайдера отменяется и тогда, когда пинг не дошёл до ушедшего клиента
+		ctx, cancelStream := context.WithCancel(ctx)
+		defer cancelStream()
+		proxyReq = proxyReq.WithContext(ctx)
 
 		resp, err := client.Do(proxyReq)
 		if err != nil {
-			http.Error(w, `{"error":"provider unreachable"}`, http.StatusBadGateway)
+			if deadline.Exceeded(ctx) {
+				chargeTimedOut(w, r, ticket, userID, req, provider, start, assignment)
+				return
+			}
+			ticket.Refund()
+			recordExperimentOutcome(assignment, time.Since(start), 0, true)
+			apierror.Write(w, http.StatusBadGateway, "provider unreachable")
 			return
 		}
 		defer resp.Body.Close()
 
+		// Пока модель молчит, пинги (": ping") не дают прокси оборвать стрим
+		// по таймауту простоя. Запускаем их только после ответа провайдера:
+		// первый пинг отправляет заголовки, и ошибку статусом уже не вернуть.
+		stream := sse.Start(ctx, w, sse.Interval(), cancelStream)
+		defer stream.Close()
+
+		// Токены считаем по ходу стрима, не накапливая его текст; вариант 0
+		// храним только для диалога
+		usage := newStreamUsage(req.Model, conv != nil)
 		scanner := bufio.NewScanner(resp.Body)
 		for scanner.Scan() {
 			line := scanner.Text()
 			if strings.HasPrefix(line, "data: ") {
-				io.WriteString(w, line+"\n\n")
-				if flusher, ok := w.(http.Flusher); ok {
-					flusher.Flush()
+				usage.Observe(line)
+				if _, err := io.WriteString(stream, line+"\n\n"); err != nil {
+					break
 				}
 			}
 		}
+		stream.Close()
+		// Заголовки уже отправлены — о дедлайне сообщаем событием стрима
+		if deadline.Exceeded(ctx) {
+			event, _ := json.Marshal(deadline.Error())
+			io.WriteString(stream, "data: "+string(event)+"\n\n")
+		}
+
+		// usage из последнего чанка, если клиент запросил stream_options.include_usage,
+		// иначе подсчёт токенизатором. Если клиент отключился посреди стрима,
+		// фиксируем уже сгенерированные токены.
+		prompt, completion := usage.Tokens(req)
+		recordUsage(userID, req.Model, prompt, completion, ctx.Err() != nil)
+		recordTemplateUsage(tmplUse, prompt, completion)
+		ticket.Consume(prompt + completion)
+		annotation := annotations.Annotation{
+			Provider: provider,
+			CostUSD:  prices.ChatCost(req.Model, prompt, completion),
+			Latency:  time.Since(start),
+		}
+		annotation.SetHeaders(w.Header())
+		recordExperimentOutcome(assignment, annotation.Latency, annotation.CostUSD, false)
+		// Отзыв на стрим оставляют по id его чанков
+		record := newCompletionRecord(r, userID, provider, req, tmplUse, assignment)
+		record.ID = usage.ID
+		if record.ID == "" {
+			record.ID = record.RequestID
+		}
+		record.PromptTokens, record.CompletionTokens = prompt, completion
+		record.CostUSD, record.LatencyMs = annotation.CostUSD, annotation.Latency.Milliseconds()
+		rememberCompletion(record)
+		status := usageevents.StatusSuccess
+		if deadline.Exceeded(ctx) {
+			status = usageevents.StatusTimeout
+		}
+		emitUsage(r, record, status, "")
+		if conv != nil && ctx.Err() == nil {
+			rememberTurn(conv, requestMessages, usage.FirstChoice())
+		}
 		return
 	}
 
-	// Не стриминг — обычный запрос
-	resp, err := client.Do(proxyReq)
+	// Не стриминг — обычный запрос. Тело читаем целиком: нужны usage для
+	// rate-limiter'а и текст ответа для диалога
+	var statusCode int
+	var respBody []byte
+	if req.N > 1 && !nativeChoices[provider] {
+		statusCode, respBody, err = fanOutCompletion(ctx, client, proxyReq.URL.String(), proxyReq.Header, body, req.N)
+	} else {
+		statusCode, respBody, err = doCompletion(client, proxyReq)
+	}
 	if err != nil {
-		http.Error(w, `{"error":"provider error"}`, http.StatusBadGateway)
+		if deadline.Exceeded(ctx) {
+			chargeTimedOut(w, r, ticket, userID, req, provider, start, assignment)
+			return
+		}
+		ticket.Refund()
+		recordExperimentOutcome(assignment, time.Since(start), 0, true)
+		apierror.Write(w, http.StatusBadGateway, "provider error")
+		return
+	}
+	if statusCode != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		w.Write(respBody)
+		ticket.Refund()
+		recordExperimentOutcome(assignment, time.Since(start), 0, true)
 		return
 	}
-	defer resp.Body.Close()
 
+	prompt, completion := completionUsage(req, respBody)
+	annotation := annotations.Annotation{
+		Provider: provider,
+		CostUSD:  prices.ChatCost(req.Model, prompt, completion),
+		Latency:  time.Since(start),
+	}
+	if cacheLookup != nil {
+		annotation.Cache = annotations.CacheMiss
+		go storeSemanticCache(cacheLookup, userID, provider, req.Model, respBody, annotation.CostUSD)
+	}
+	annotation.SetHeaders(w.Header())
+	record := newCompletionRecord(r, userID, provider, req, tmplUse, assignment)
+	record.ID = completionID(respBody)
+	if record.ID == "" {
+		record.ID = record.RequestID
+	}
+	record.PromptTokens, record.CompletionTokens = prompt, completion
+	record.CostUSD, record.LatencyMs = annotation.CostUSD, annotation.Latency.Milliseconds()
+	w.Header().Set("X-Completion-ID", record.ID)
+	if annotations.WantsBody(r) {
+		respBody = annotations.AddToBody(respBody, annotation)
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	io.Copy(w, resp.Body)
+	w.WriteHeader(statusCode)
+	w.Write(respBody)
+
+	ticket.Consume(prompt + completion)
+	recordTemplateUsage(tmplUse, prompt, completion)
+	recordExperimentOutcome(assignment, annotation.Latency, annotation.CostUSD, false)
+	rememberCompletion(record)
+	emitUsage(r, record, usageevents.StatusSuccess, annotation.Cache)
+	if conv != nil {
+		rememberTurn(conv, requestMessages, completionContent(respBody))
+	}
 }
 
+// withMessages заменяет messages в теле запроса и убирает поля шлюза
+// (conversation_id, retrieval, шаблон), остальные параметры передаются провайдеру как есть
+func withMessages(body []byte, messages []Message) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+	fields["messages"] = raw
+	delete(fields, "conversation_id")
+	delete(fields, "retrieval")
+	delete(fields, "template_id")
+	delete(fields, "template_version")
+	delete(fields, "variables")
+	return json.Marshal(fields)
+}
 
+// completionUsage returns the prompt and completion tokens of a chat
+// completion, estimated with the tokenizer when the provider reports none
+func completionUsage(req OpenAIRequest, body []byte) (prompt, completion int) {
+	var resp struct {
+		Usage   tokenUsage `json:"usage"`
+		Choices []struct {
+			Message Message `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Usage.TotalTokens > 0 {
+		return resp.Usage.PromptTokens, resp.Usage.TotalTokens - resp.Usage.PromptTokens
+	}
+	// При n > 1 оплачиваются все варианты
+	for _, c := range resp.Choices {
+		completion += tokenizer.Count(req.Model, c.Message.Content)
+	}
+	return promptTokens(req), completion
+}
 
+// completionContent extracts the assistant message from a chat completion response
+func completionContent(body []byte) string {
+	var completion struct {
+		Choices []struct {
+			Message Message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(body, &completion); err != nil || len(completion.Choices) == 0 {
+		return ""
+	}
+	return completion.Choices[0].Message.Content
+}
 
+// promptTokens считает токены запроса до отправки провайдеру
+func promptTokens(req OpenAIRequest) int {
+	messages := make([]tokenizer.Message, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		messages = append(messages, tokenizer.Message{Role: m.Role, Content: m.Content})
+	}
+	return tokenizer.CountMessages(req.Model, messages)
+}
 
+// recordUsage ставит в очередь billing_usage запись об использовании токенов.
+// partial отмечает ответы, оборванные отключением клиента или дедлайном.
+func recordUsage(userID, model string, prompt, completion int, partial bool) {
+	if partial {
+		log.Printf("Request for user %s, model %s cut short after %d completion tokens", userID, model, completion)
+	}
 
+	raw, _ := json.Marshal(map[string]interface{}{
+		"user_id":           userID,
+		"model":             model,
+		"prompt_tokens":     prompt,
+		"completion_tokens": completion,
+		"total_tokens":      prompt + completion,
+		"partial":           partial,
+		"timestamp":         time.Now().Unix(),
+	})
+	if err := rdb.LPush(context.Background(), "billing_usage", raw).Err(); err != nil {
+		log.Printf("Failed to record usage: %v", err)
+	}
+}
 
+// chargeTimedOut отвечает 504 на запрос, не уложившийся в дедлайн до ответа
+// провайдера. Промпт провайдер уже обработал — его токены учитываются как
+// частичное использование, стоимость отдаётся в заголовках аннотаций.
+func chargeTimedOut(w http.ResponseWriter, r *http.Request, ticket *ratelimit.Ticket, userID string, req OpenAIRequest, provider string, start time.Time, assignment *experimentAssignment) {
+	prompt := promptTokens(req)
+	recordUsage(userID, req.Model, prompt, 0, true)
+	ticket.Consume(prompt)
+	annotation := annotations.Annotation{
+		Provider: provider,
+		CostUSD:  prices.ChatCost(req.Model, prompt, 0),
+		Latency:  time.Since(start),
+	}
+	annotation.SetHeaders(w.Header())
+	recordExperimentOutcome(assignment, annotation.Latency, annotation.CostUSD, true)
+	record := newCompletionRecord(r, userID, provider, req, nil, assignment)
+	record.PromptTokens = prompt
+	record.CostUSD, record.LatencyMs = annotation.CostUSD, annotation.Latency.Milliseconds()
+	emitUsage(r, record, usageevents.StatusTimeout, "")
+	deadline.Error().Write(w)
+}
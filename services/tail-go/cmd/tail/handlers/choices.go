@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Несколько вариантов ответа (n): OpenAI генерирует их за один вызов,
+// остальным провайдерам уходит по запросу на вариант.
+const maxChoices = 16
+
+var nativeChoices = map[string]bool{
+	"openai": true,
+}
+
+// tokenUsage is the usage of an OpenAI chat completion
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// doCompletion sends a chat completion and reads the whole response
+func doCompletion(client *http.Client, req *http.Request) (status int, body []byte, err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+// fanOutCompletion sends the completion once per choice, without n, and
+// merges the responses. The first failed response is returned as is.
+func fanOutCompletion(ctx context.Context, client *http.Client, url string, header http.Header, body []byte, n int) (status int, merged []byte, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return 0, nil, err
+	}
+	delete(fields, "n")
+	single, err := json.Marshal(fields)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	statuses := make([]int, n)
+	bodies := make([][]byte, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(single))
+			req.Header = header.Clone()
+			statuses[i], bodies[i], errs[i] = doCompletion(client, req)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range bodies {
+		if errs[i] != nil {
+			return 0, nil, errs[i]
+		}
+		if statuses[i] != http.StatusOK {
+			return statuses[i], bodies[i], nil
+		}
+	}
+	merged, err = mergeChoices(bodies)
+	return http.StatusOK, merged, err
+}
+
+// mergeChoices joins single-choice completions into one with n choices,
+// indexed in order, and their aggregate usage
+func mergeChoices(bodies [][]byte) ([]byte, error) {
+	var merged map[string]interface{}
+	choices := make([]interface{}, 0, len(bodies))
+	var usage tokenUsage
+	for i, body := range bodies {
+		var resp map[string]interface{}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return nil, fmt.Errorf("failed to parse choice %d: %w", i, err)
+		}
+		if merged == nil {
+			merged = resp
+		}
+
+		list, _ := resp["choices"].([]interface{})
+		for _, c := range list {
+			if choice, ok := c.(map[string]interface{}); ok {
+				choice["index"] = len(choices)
+				choices = append(choices, choice)
+			}
+		}
+
+		var reported struct {
+			Usage tokenUsage `json:"usage"`
+		}
+		json.Unmarshal(body, &reported)
+		usage.PromptTokens += reported.Usage.PromptTokens
+		usage.CompletionTokens += reported.Usage.CompletionTokens
+		usage.TotalTokens += reported.Usage.TotalTokens
+	}
+
+	merged["choices"] = choices
+	merged["usage"] = usage
+	return json.Marshal(merged)
+}
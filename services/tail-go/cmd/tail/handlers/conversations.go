@@ -0,0 +1,402 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var errConversationNotFound = errors.New("conversation not found")
+
+// Лимиты хранения диалогов:
+// CONVERSATION_TTL_HOURS — сколько живёт диалог без активности,
+// CONVERSATION_MAX_MESSAGES — сколько последних сообщений хранится,
+// CONVERSATION_MAX_MESSAGE_BYTES — максимальный размер одного сообщения,
+// CONVERSATION_CONTEXT_TOKENS — сколько токенов истории подставляется в запрос.
+var (
+	conversationTTL             = time.Duration(envInt("CONVERSATION_TTL_HOURS", 168)) * time.Hour
+	conversationMaxMessages     = envInt("CONVERSATION_MAX_MESSAGES", 200)
+	conversationMaxMessageBytes = envInt("CONVERSATION_MAX_MESSAGE_BYTES", 32*1024)
+	conversationContextTokens   = envInt("CONVERSATION_CONTEXT_TOKENS", 8000)
+)
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// Conversation is a stored chat session; messages are kept in a separate list
+type Conversation struct {
+	ID           string    `json:"id"`
+	Object       string    `json:"object"`
+	UserID       string    `json:"user_id,omitempty"`
+	Title        string    `json:"title,omitempty"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    int64     `json:"created_at"`
+	UpdatedAt    int64     `json:"updated_at"`
+	ExpiresAt    int64     `json:"expires_at"`
+	Messages     []Message `json:"messages,omitempty"`
+}
+
+func conversationKey(id string) string         { return "conversation:" + id }
+func conversationMessagesKey(id string) string { return "conversation:" + id + ":messages" }
+func userConversationsKey(userID string) string {
+	return "conversations:user:" + userID
+}
+
+func saveConversation(ctx context.Context, conv *Conversation) error {
+	conv.ExpiresAt = time.Unix(conv.UpdatedAt, 0).Add(conversationTTL).Unix()
+	meta := *conv
+	meta.Messages = nil
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, conversationKey(conv.ID), raw, conversationTTL)
+	pipe.Expire(ctx, conversationMessagesKey(conv.ID), conversationTTL)
+	if conv.UserID != "" {
+		pipe.ZAdd(ctx, userConversationsKey(conv.UserID), &redis.Z{Score: float64(conv.UpdatedAt), Member: conv.ID})
+		pipe.Expire(ctx, userConversationsKey(conv.UserID), conversationTTL)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// loadConversation returns the conversation if it exists and belongs to userID
+func loadConversation(ctx context.Context, userID, id string) (*Conversation, error) {
+	raw, err := rdb.Get(ctx, conversationKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, errConversationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var conv Conversation
+	if err := json.Unmarshal(raw, &conv); err != nil {
+		return nil, err
+	}
+	if conv.UserID != userID {
+		return nil, errConversationNotFound
+	}
+	return &conv, nil
+}
+
+func loadConversationMessages(ctx context.Context, id string) ([]Message, error) {
+	items, err := rdb.LRange(ctx, conversationMessagesKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(items))
+	for _, item := range items {
+		var m Message
+		if err := json.Unmarshal([]byte(item), &m); err == nil {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+// appendConversationMessages stores messages, keeping only the newest
+// CONVERSATION_MAX_MESSAGES, and refreshes the TTL
+func appendConversationMessages(ctx context.Context, conv *Conversation, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, 0, len(messages))
+	for _, m := range messages {
+		raw, _ := json.Marshal(m)
+		values = append(values, raw)
+	}
+
+	key := conversationMessagesKey(conv.ID)
+	pipe := rdb.TxPipeline()
+	pipe.RPush(ctx, key, values...)
+	pipe.LTrim(ctx, key, int64(-conversationMaxMessages), -1)
+	count := pipe.LLen(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	conv.MessageCount = int(count.Val())
+	conv.UpdatedAt = time.Now().Unix()
+	return saveConversation(ctx, conv)
+}
+
+func validateConversationMessages(messages []Message) error {
+	for _, m := range messages {
+		if m.Role == "" {
+			return errors.New("message role is required")
+		}
+		if len(m.Content) > conversationMaxMessageBytes {
+			return fmt.Errorf("message exceeds %d bytes", conversationMaxMessageBytes)
+		}
+	}
+	return nil
+}
+
+// conversationContext builds the messages sent to the provider: system messages
+// from the request, then as much stored history as fits into
+// CONVERSATION_CONTEXT_TOKENS (newest first), then the new request messages.
+func conversationContext(ctx context.Context, userID, id, model string, messages []Message) (*Conversation, []Message, error) {
+	conv, err := loadConversation(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	history, err := loadConversationMessages(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var system, rest []Message
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m)
+		} else {
+			rest = append(rest, m)
+		}
+	}
+
+	budget := conversationContextTokens
+	start := len(history)
+	for start > 0 {
+		m := history[start-1]
+		tokens := tokenizer.CountMessages(model, []tokenizer.Message{{Role: m.Role, Content: m.Content}})
+		if tokens > budget {
+			break
+		}
+		budget -= tokens
+		start--
+	}
+
+	assembled := make([]Message, 0, len(system)+len(history)-start+len(rest))
+	assembled = append(assembled, system...)
+	assembled = append(assembled, history[start:]...)
+	assembled = append(assembled, rest...)
+	return conv, assembled, nil
+}
+
+// rememberTurn saves the new request messages and the assistant reply
+func rememberTurn(conv *Conversation, requestMessages []Message, reply string) {
+	var turn []Message
+	for _, m := range requestMessages {
+		if m.Role != "system" {
+			turn = append(turn, m)
+		}
+	}
+	turn = append(turn, Message{Role: "assistant", Content: reply})
+
+	if err := appendConversationMessages(context.Background(), conv, turn); err != nil {
+		log.Printf("Failed to store conversation %s: %v", conv.ID, err)
+	}
+}
+
+func writeConversationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errConversationNotFound) {
+		apierror.Write(w, http.StatusNotFound, "conversation not found")
+		return
+	}
+	log.Printf("Redis error: %v", err)
+	apierror.Write(w, http.StatusInternalServerError, "internal error")
+}
+
+// CreateConversation handles POST /v1/conversations
+func CreateConversation(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title    string    `json:"title"`
+		Messages []Message `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if err := validateConversationMessages(req.Messages); err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().Unix()
+	conv := &Conversation{
+		ID:        "conv_" + uuid.New().String(),
+		Object:    "conversation",
+		UserID:    r.Header.Get("X-User-ID"),
+		Title:     req.Title,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := saveConversation(r.Context(), conv); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	if err := appendConversationMessages(r.Context(), conv, req.Messages); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(conv)
+}
+
+// ListConversations handles GET /v1/conversations, newest first
+func ListConversations(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	ids, err := rdb.ZRevRange(r.Context(), userConversationsKey(userID), 0, 99).Result()
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	data := make([]*Conversation, 0, len(ids))
+	for _, id := range ids {
+		conv, err := loadConversation(r.Context(), userID, id)
+		if errors.Is(err, errConversationNotFound) {
+			// Истёк по TTL — убираем из индекса
+			rdb.ZRem(r.Context(), userConversationsKey(userID), id)
+			continue
+		} else if err != nil {
+			writeConversationError(w, err)
+			return
+		}
+		data = append(data, conv)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+}
+
+// GetConversation handles GET /v1/conversations/{id} and returns the stored messages
+func GetConversation(w http.ResponseWriter, r *http.Request) {
+	conv, err := loadConversation(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	if conv.Messages, err = loadConversationMessages(r.Context(), conv.ID); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv)
+}
+
+// UpdateConversation handles PATCH /v1/conversations/{id}
+func UpdateConversation(w http.ResponseWriter, r *http.Request) {
+	conv, err := loadConversation(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	var req struct {
+		Title *string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Title != nil {
+		conv.Title = *req.Title
+	}
+	conv.UpdatedAt = time.Now().Unix()
+	if err := saveConversation(r.Context(), conv); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv)
+}
+
+// DeleteConversation handles DELETE /v1/conversations/{id}
+func DeleteConversation(w http.ResponseWriter, r *http.Request) {
+	conv, err := loadConversation(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(r.Context(), conversationKey(conv.ID), conversationMessagesKey(conv.ID))
+	if conv.UserID != "" {
+		pipe.ZRem(r.Context(), userConversationsKey(conv.UserID), conv.ID)
+	}
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": conv.ID, "object": "conversation.deleted", "deleted": true})
+}
+
+// GetConversationMessages handles GET /v1/conversations/{id}/messages
+func GetConversationMessages(w http.ResponseWriter, r *http.Request) {
+	conv, err := loadConversation(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+	messages, err := loadConversationMessages(r.Context(), conv.ID)
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": messages})
+}
+
+// AddConversationMessages handles POST /v1/conversations/{id}/messages
+func AddConversationMessages(w http.ResponseWriter, r *http.Request) {
+	conv, err := loadConversation(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	var req struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if len(req.Messages) == 0 {
+		apierror.Write(w, http.StatusBadRequest, "messages are required")
+		return
+	}
+	if err := validateConversationMessages(req.Messages); err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := appendConversationMessages(r.Context(), conv, req.Messages); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conv)
+}
@@ -13,11 +13,14 @@ import (
 "strings"
 "time"
 
-"github.com/go-redis/redis/v8"
+"github.com/MaksimVF/ZB/pkg/annotations"
+"github.com/MaksimVF/ZB/pkg/apierror"
+"github.com/MaksimVF/ZB/pkg/ratelimit"
+"github.com/MaksimVF/ZB/pkg/redisconn"
 "llm-gateway-pro/services/gateway/internal/secrets"
 )
 
-var rdb = redis.NewClient(&redis.Options{Addr: "redis:6379"})
+var rdb = redisconn.MustNew(redisconn.FromEnv())
 
 type EmbeddingsRequest struct {
 Model string      `json:"model"`
@@ -50,9 +53,10 @@ BaseURL  string
 }
 
 func Embeddings(w http.ResponseWriter, r *http.Request) {
+start := time.Now()
 var req EmbeddingsRequest
 if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+apierror.Write(w, http.StatusBadRequest, "invalid json")
 return
 }
 
@@ -89,8 +93,7 @@ w.Header().Add("X-Cache", "MISS")
 // Если всё в кэше — сразу отдаём
 if len(missIndices) == 0 {
 final := buildBatchResponse(req.Model, inputTexts, cachedResults)
-w.Header().Set("Content-Type", "application/json")
-json.NewEncoder(w).Encode(final)
+writeEmbeddings(w, r, final, annotations.Annotation{Latency: time.Since(start), Cache: annotations.CacheHit})
 return
 }
 
@@ -102,9 +105,12 @@ missTexts[i] = inputTexts[idx]
 
 providerResp := requestEmbeddings(req.Model, missTexts)
 if providerResp == nil {
-http.Error(w, `{"error":"provider error"}`, http.StatusBadGateway)
+ratelimit.TicketFromContext(r.Context()).Refund()
+apierror.Write(w, http.StatusBadGateway, "provider error")
 return
 }
+// Кэш-хиты не тарифицируются — списываем только токены провайдера
+ratelimit.TicketFromContext(r.Context()).Consume(providerResp.Usage.TotalTokens)
 
 // Сохраняем в кэш (30 дней)
 for i, data := range providerResp.Data {
@@ -121,10 +127,30 @@ cachedResults[missIndices[i]] = &resp
 }
 
 final := buildBatchResponse(req.Model, inputTexts, cachedResults)
-w.Header().Set("Content-Type", "application/json")
 w.Header().Set("X-Cache-Hits", fmt.Sprintf("%d", len(inputTexts)-len(missIndices)))
 w.Header().Set("X-Cache-Misses", fmt.Sprintf("%d", len(missIndices)))
-json.NewEncoder(w).Encode(final)
+// Стоимость — только токены, отправленные провайдеру
+cache := annotations.CacheMiss
+if len(missIndices) < len(inputTexts) {
+cache = annotations.CachePartial
+}
+writeEmbeddings(w, r, final, annotations.Annotation{
+Provider: embeddingProviders[req.Model].Provider,
+CostUSD:  prices.EmbedCost(req.Model, providerResp.Usage.TotalTokens),
+Latency:  time.Since(start),
+Cache:    cache,
+})
+}
+
+// writeEmbeddings отдаёт ответ с аннотациями стоимости и задержки
+func writeEmbeddings(w http.ResponseWriter, r *http.Request, resp EmbeddingResponse, annotation annotations.Annotation) {
+body, _ := json.Marshal(resp)
+if annotations.WantsBody(r) {
+body = annotations.AddToBody(body, annotation)
+}
+annotation.SetHeaders(w.Header())
+w.Header().Set("Content-Type", "application/json")
+w.Write(body)
 }
 
 // Вспомогательные функции
@@ -172,11 +198,15 @@ req.URL.RawQuery = "key=" + apiKey
 }
 
 resp, err := http.DefaultClient.Do(req)
-if err != nil || resp.StatusCode >= 400 {
-log.Printf("Provider error: %v, status: %d", err, resp.StatusCode)
+if err != nil {
+log.Printf("Provider error: %v", err)
 return nil
 }
 defer resp.Body.Close()
+if resp.StatusCode >= 400 {
+log.Printf("Provider error: status %d", resp.StatusCode)
+return nil
+}
 
 var result EmbeddingResponse
 json.NewDecoder(resp.Body).Decode(&result)
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Отдельная очередь для батчей эмбеддингов
+const embeddingsQueue = "embeddings_queue"
+
+// Максимум текстов в одном батче эмбеддингов
+const maxEmbeddingsBatchInputs = 50000
+
+// embeddingsChunkSize — сколько текстов отправляется провайдеру за один запрос (EMBEDDINGS_CHUNK_SIZE)
+var embeddingsChunkSize = func() int {
+	if v, err := strconv.Atoi(os.Getenv("EMBEDDINGS_CHUNK_SIZE")); err == nil && v > 0 {
+		return v
+	}
+	return 100
+}()
+
+// EmbeddingsBatch tracks an asynchronous embeddings job
+type EmbeddingsBatch struct {
+	ID          string `json:"id"`
+	Object      string `json:"object"`
+	Model       string `json:"model"`
+	Status      string `json:"status"`
+	Total       int    `json:"total"`
+	Processed   int    `json:"processed"`
+	CachedHits  int    `json:"cached_hits"`
+	Error       string `json:"error,omitempty"`
+	UserID      string `json:"user_id,omitempty"`
+	CreatedAt   int64  `json:"created_at"`
+	CompletedAt int64  `json:"completed_at,omitempty"`
+}
+
+func embeddingsBatchKey(id string) string       { return "emb_batch:" + id }
+func embeddingsBatchInputKey(id string) string  { return "emb_batch:" + id + ":input" }
+func embeddingsBatchResultKey(id string) string { return "emb_batch:" + id + ":result" }
+
+func saveEmbeddingsBatch(ctx context.Context, job *EmbeddingsBatch) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, embeddingsBatchKey(job.ID), raw, 7*24*time.Hour).Err()
+}
+
+func loadEmbeddingsBatch(ctx context.Context, id string) (*EmbeddingsBatch, error) {
+	raw, err := rdb.Get(ctx, embeddingsBatchKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var job EmbeddingsBatch
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SubmitEmbeddingsBatch handles POST /v1/embeddings/batches
+func SubmitEmbeddingsBatch(w http.ResponseWriter, r *http.Request) {
+	var req EmbeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if _, ok := embeddingProviders[req.Model]; !ok {
+		apierror.New(http.StatusBadRequest, "model not supported").WithParam("model").WithCode("model_not_found").Write(w)
+		return
+	}
+
+	inputs := normalizeInput(req.Input)
+	if len(inputs) == 0 || len(inputs) > maxEmbeddingsBatchInputs {
+		apierror.Write(w, http.StatusBadRequest, fmt.Sprintf("input size must be 1-%d", maxEmbeddingsBatchInputs))
+		return
+	}
+
+	job := &EmbeddingsBatch{
+		ID:        "embbatch_" + uuid.New().String(),
+		Object:    "embeddings.batch",
+		Model:     req.Model,
+		Status:    BatchStatusValidating,
+		Total:     len(inputs),
+		UserID:    r.Header.Get("X-User-ID"),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	rawInputs, _ := json.Marshal(inputs)
+	pipe := rdb.TxPipeline()
+	pipe.Set(r.Context(), embeddingsBatchInputKey(job.ID), rawInputs, 7*24*time.Hour)
+	if err := saveEmbeddingsBatch(r.Context(), job); err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	pipe.LPush(r.Context(), embeddingsQueue, job.ID)
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// loadUserEmbeddingsBatch loads a batch of userID; batches of other users
+// are redis.Nil, as if they did not exist
+func loadUserEmbeddingsBatch(ctx context.Context, userID, id string) (*EmbeddingsBatch, error) {
+	job, err := loadEmbeddingsBatch(ctx, id)
+	if err == nil && job.UserID != userID {
+		return nil, redis.Nil
+	}
+	return job, err
+}
+
+// GetEmbeddingsBatch handles GET /v1/embeddings/batches/{id}
+func GetEmbeddingsBatch(w http.ResponseWriter, r *http.Request) {
+	job, err := loadUserEmbeddingsBatch(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "batch not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetEmbeddingsBatchResult handles GET /v1/embeddings/batches/{id}/result.
+// The body has the same shape as a /v1/embeddings response.
+func GetEmbeddingsBatchResult(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, err := loadUserEmbeddingsBatch(r.Context(), r.Header.Get("X-User-ID"), id)
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "batch not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	if job.Status != BatchStatusCompleted {
+		apierror.Write(w, http.StatusConflict, "batch is not completed")
+		return
+	}
+
+	raw, err := rdb.Get(r.Context(), embeddingsBatchResultKey(id)).Bytes()
+	if err != nil {
+		apierror.Write(w, http.StatusGone, "results not available")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(raw)
+}
+
+// StartEmbeddingsWorker запускает отдельного потребителя очереди эмбеддингов
+func StartEmbeddingsWorker(ctx context.Context) {
+	go func() {
+		for {
+			res, err := rdb.BRPop(ctx, 5*time.Second, embeddingsQueue).Result()
+			if ctx.Err() != nil {
+				return
+			}
+			if err == redis.Nil {
+				continue
+			} else if err != nil {
+				log.Printf("Embeddings queue error: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			processEmbeddingBatch(ctx, res[1])
+		}
+	}()
+}
+
+// processEmbeddingBatch разбивает вход на чанки, берёт готовые векторы из кэша
+// и запрашивает у провайдера только недостающие, с повторами
+func processEmbeddingBatch(ctx context.Context, id string) {
+	job, err := loadEmbeddingsBatch(ctx, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			requeueEmbeddingsBatch(id)
+			return
+		}
+		log.Printf("Embeddings batch %s not found: %v", id, err)
+		return
+	}
+
+	rawInputs, err := rdb.Get(ctx, embeddingsBatchInputKey(id)).Bytes()
+	var inputs []string
+	if err == nil {
+		err = json.Unmarshal(rawInputs, &inputs)
+	}
+	if err != nil && ctx.Err() != nil {
+		requeueEmbeddingsBatch(id)
+		return
+	} else if err != nil {
+		failEmbeddingsBatch(ctx, job, "input not found")
+		return
+	}
+
+	job.Status = BatchStatusInProgress
+	saveEmbeddingsBatch(ctx, job)
+
+	results := make([]*EmbeddingResponse, len(inputs))
+	for start := 0; start < len(inputs); start += embeddingsChunkSize {
+		end := start + embeddingsChunkSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+
+		if err := embedChunk(ctx, job, inputs, results, start, end); err != nil {
+			// При остановке батч возвращается в очередь; готовые чанки уже
+			// в кэше эмбеддингов и провайдеру повторно не отправляются
+			if ctx.Err() != nil {
+				restartEmbeddingsBatch(job)
+				return
+			}
+			failEmbeddingsBatch(ctx, job, err.Error())
+			return
+		}
+
+		job.Processed = end
+		saveEmbeddingsBatch(ctx, job)
+	}
+
+	final := buildBatchResponse(job.Model, inputs, results)
+	raw, err := json.Marshal(final)
+	if err != nil {
+		failEmbeddingsBatch(ctx, job, "failed to encode result")
+		return
+	}
+	if err := rdb.Set(ctx, embeddingsBatchResultKey(id), raw, 7*24*time.Hour).Err(); err != nil {
+		failEmbeddingsBatch(ctx, job, "failed to store result")
+		return
+	}
+
+	job.Status = BatchStatusCompleted
+	job.CompletedAt = time.Now().Unix()
+	saveEmbeddingsBatch(ctx, job)
+	rdb.Del(ctx, embeddingsBatchInputKey(id))
+}
+
+// embedChunk fills results[start:end], using the shared emb:<model>:<hash> cache
+func embedChunk(ctx context.Context, job *EmbeddingsBatch, inputs []string, results []*EmbeddingResponse, start, end int) error {
+	var missIdx []int
+	var missTexts, missHashes []string
+
+	for i := start; i < end; i++ {
+		h := sha256.Sum256([]byte(inputs[i]))
+		hash := hex.EncodeToString(h[:])
+		if cached, err := rdb.Get(ctx, fmt.Sprintf("emb:%s:%s", job.Model, hash)).Result(); err == nil {
+			var resp EmbeddingResponse
+			if json.Unmarshal([]byte(cached), &resp) == nil {
+				results[i] = &resp
+				job.CachedHits++
+				continue
+			}
+		}
+		missIdx = append(missIdx, i)
+		missTexts = append(missTexts, inputs[i])
+		missHashes = append(missHashes, hash)
+	}
+	if len(missIdx) == 0 {
+		return nil
+	}
+
+	var providerResp *EmbeddingResponse
+	cfg := batchWorkerConfig
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		providerResp = requestEmbeddings(job.Model, missTexts)
+		if providerResp != nil && len(providerResp.Data) == len(missTexts) {
+			break
+		}
+		providerResp = nil
+		if attempt == cfg.MaxAttempts {
+			return fmt.Errorf("provider error after %d attempts", cfg.MaxAttempts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.retryDelay(attempt)):
+		}
+	}
+
+	// Сохраняем в тот же кэш, что и синхронный /v1/embeddings (30 дней)
+	for i, data := range providerResp.Data {
+		resp := EmbeddingResponse{
+			Object: "list",
+			Model:  job.Model,
+			Usage:  providerResp.Usage,
+		}
+		resp.Data = append(resp.Data, data)
+		raw, _ := json.Marshal(resp)
+		rdb.SetEX(ctx, fmt.Sprintf("emb:%s:%s", job.Model, missHashes[i]), raw, 30*24*time.Hour)
+		results[missIdx[i]] = &resp
+	}
+	return nil
+}
+
+// failEmbeddingsBatch сохраняет итоговый статус со свежим контекстом: контекст
+// воркера может быть уже отменён
+func failEmbeddingsBatch(ctx context.Context, job *EmbeddingsBatch, reason string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	job.Status = BatchStatusFailed
+	job.Error = reason
+	if err := saveEmbeddingsBatch(ctx, job); err != nil {
+		log.Printf("Failed to save embeddings batch %s: %v", job.ID, err)
+	}
+}
+
+// restartEmbeddingsBatch возвращает прерванный остановкой батч в validating
+// и в очередь. Контекст воркера уже отменён, поэтому используется свежий.
+func restartEmbeddingsBatch(job *EmbeddingsBatch) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job.Status = BatchStatusValidating
+	if err := saveEmbeddingsBatch(ctx, job); err != nil {
+		log.Printf("Failed to save embeddings batch %s: %v", job.ID, err)
+	}
+	requeueEmbeddingsBatch(job.ID)
+}
+
+// requeueEmbeddingsBatch ставит id прерванного остановкой батча в голову
+// очереди, откуда BRPop заберёт его первым
+func requeueEmbeddingsBatch(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.RPush(ctx, embeddingsQueue, id).Err(); err != nil {
+		log.Printf("Failed to requeue embeddings batch %s: %v", id, err)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestEmbeddingsBatchesBelongToTheirOwner(t *testing.T) {
+	ctx := context.Background()
+	rec := serve(SubmitEmbeddingsBatch, "POST /v1/embeddings/batches", http.MethodPost, "/v1/embeddings/batches", "alice",
+		`{"model":"text-embedding-3-small","input":["a","b"]}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("submit: %d %s", rec.Code, rec.Body)
+	}
+	var job EmbeddingsBatch
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.UserID != "alice" {
+		t.Errorf("owner %q", job.UserID)
+	}
+
+	get := func(userID string) int {
+		return serve(GetEmbeddingsBatch, "GET /v1/embeddings/batches/{id}", http.MethodGet, "/v1/embeddings/batches/"+job.ID, userID, "").Code
+	}
+	result := func(userID string) int {
+		return serve(GetEmbeddingsBatchResult, "GET /v1/embeddings/batches/{id}/result", http.MethodGet, "/v1/embeddings/batches/"+job.ID+"/result", userID, "").Code
+	}
+	if code := get("alice"); code != http.StatusOK {
+		t.Errorf("owner: GET = %d", code)
+	}
+	if code := result("alice"); code != http.StatusConflict {
+		t.Errorf("owner, batch not completed: result = %d, want 409", code)
+	}
+
+	job.Status = BatchStatusCompleted
+	saveEmbeddingsBatch(ctx, &job)
+	rdb.Set(ctx, embeddingsBatchResultKey(job.ID), `{"object":"list","data":[]}`, 0)
+	if code := result("alice"); code != http.StatusOK {
+		t.Errorf("owner: result = %d", code)
+	}
+	for _, userID := range []string{"bob", ""} {
+		if code := get(userID); code != http.StatusNotFound {
+			t.Errorf("user %q: GET = %d, want 404", userID, code)
+		}
+		if code := result(userID); code != http.StatusNotFound {
+			t.Errorf("user %q: result = %d, want 404", userID, code)
+		}
+	}
+}
+
+func TestFailEmbeddingsBatchAfterShutdown(t *testing.T) {
+	job := &EmbeddingsBatch{ID: "embbatch_fail", Object: "embeddings.batch", Status: BatchStatusInProgress, UserID: "alice"}
+	saveEmbeddingsBatch(context.Background(), job)
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	shutdown()
+	failEmbeddingsBatch(ctx, job, "provider error after 3 attempts")
+
+	current, err := loadEmbeddingsBatch(context.Background(), job.ID)
+	if err != nil || current.Status != BatchStatusFailed || current.Error != "provider error after 3 attempts" {
+		t.Errorf("batch %+v, %v; want it failed", current, err)
+	}
+}
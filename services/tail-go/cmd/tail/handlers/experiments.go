@@ -0,0 +1,524 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	errExperimentNotFound = errors.New("experiment not found")
+	errExperimentInvalid  = errors.New("invalid experiment")
+)
+
+// Статусы эксперимента: запросы распределяются по вариантам только в running
+const (
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+)
+
+// По чему делится трафик: один и тот же ключ или пользователь всегда попадает
+// в один вариант
+const (
+	SplitByAPIKey = "api_key"
+	SplitByUser   = "user"
+)
+
+// Присвоение варианта хранится по request ID, чтобы к нему можно было
+// привязать отзыв пользователя; EXPERIMENT_FEEDBACK_TTL_HOURS — сколько ждём отзыв
+var experimentFeedbackTTL = time.Duration(envInt("EXPERIMENT_FEEDBACK_TTL_HOURS", 168)) * time.Hour
+
+var variantNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,32}$`)
+
+// Experiment splits the chat completions that name it between variants
+type Experiment struct {
+	ID          string              `json:"id"`
+	Object      string              `json:"object"`
+	UserID      string              `json:"user_id,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Status      string              `json:"status"`
+	SplitBy     string              `json:"split_by"`
+	Variants    []ExperimentVariant `json:"variants"`
+	CreatedAt   int64               `json:"created_at"`
+	UpdatedAt   int64               `json:"updated_at"`
+}
+
+// ExperimentVariant overrides parts of the request; empty fields keep the
+// request's own values. Weights are relative shares of the traffic.
+type ExperimentVariant struct {
+	Name            string   `json:"name"`
+	Weight          int      `json:"weight"`
+	Model           string   `json:"model,omitempty"`
+	TemplateID      string   `json:"template_id,omitempty"`
+	TemplateVersion int      `json:"template_version,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+}
+
+// experimentAssignment is the variant a chat completion was assigned to
+type experimentAssignment struct {
+	ExperimentID string
+	Variant      string
+}
+
+func experimentKey(id string) string      { return "experiment:" + id }
+func experimentStatsKey(id string) string { return "experiment:" + id + ":stats" }
+func experimentRequestKey(requestID string) string {
+	return "experiment_request:" + requestID
+}
+func userExperimentsKey(userID string) string {
+	return "experiments:user:" + userID
+}
+
+// validate checks the experiment as created; variants do not change later,
+// since that would move users between variants mid-experiment
+func (e *Experiment) validate(ctx context.Context) error {
+	if strings.TrimSpace(e.Name) == "" {
+		return fmt.Errorf("%w: name is required", errExperimentInvalid)
+	}
+	if e.SplitBy != SplitByAPIKey && e.SplitBy != SplitByUser {
+		return fmt.Errorf("%w: split_by must be %s or %s", errExperimentInvalid, SplitByAPIKey, SplitByUser)
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("%w: at least two variants are required", errExperimentInvalid)
+	}
+	seen := make(map[string]bool)
+	for _, v := range e.Variants {
+		if !variantNamePattern.MatchString(v.Name) || seen[v.Name] {
+			return fmt.Errorf("%w: variant names must be unique, 1-32 letters, digits, '_', '.' or '-'", errExperimentInvalid)
+		}
+		seen[v.Name] = true
+		if v.Weight < 1 {
+			return fmt.Errorf("%w: variant %s needs a positive weight", errExperimentInvalid, v.Name)
+		}
+		if v.Temperature != nil && (*v.Temperature < 0 || *v.Temperature > 2) {
+			return fmt.Errorf("%w: variant %s temperature must be between 0 and 2", errExperimentInvalid, v.Name)
+		}
+		if v.TemplateID != "" {
+			if _, err := loadPromptTemplate(ctx, e.UserID, v.TemplateID); err != nil {
+				return fmt.Errorf("%w: variant %s: %v", errExperimentInvalid, v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// assign picks the variant of a subject: the same subject always gets the
+// same variant, and subjects spread over variants by weight
+func (e *Experiment) assign(subject string) *ExperimentVariant {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	hash := sha256.Sum256([]byte(e.ID + ":" + subject))
+	point := int(binary.BigEndian.Uint64(hash[:8]) % uint64(total))
+	for i := range e.Variants {
+		if point < e.Variants[i].Weight {
+			return &e.Variants[i]
+		}
+		point -= e.Variants[i].Weight
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
+
+// experimentSubject returns what the traffic of an experiment is split by
+func experimentSubject(e *Experiment, r *http.Request, userID string) string {
+	if e.SplitBy == SplitByUser && userID != "" {
+		return "user:" + userID
+	}
+	return apiKeyScope(r)
+}
+
+func saveExperiment(ctx context.Context, e *Experiment) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, experimentKey(e.ID), raw, 0)
+	pipe.SAdd(ctx, userExperimentsKey(e.UserID), e.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// loadExperiment returns the experiment if it exists and belongs to userID
+func loadExperiment(ctx context.Context, userID, id string) (*Experiment, error) {
+	raw, err := rdb.Get(ctx, experimentKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, errExperimentNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var e Experiment
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, err
+	}
+	if e.UserID != userID {
+		return nil, errExperimentNotFound
+	}
+	return &e, nil
+}
+
+// applyExperiment assigns a chat completion to a variant of its experiment
+// and applies the variant to the request and its body. Requests of stopped
+// experiments run as sent.
+func applyExperiment(r *http.Request, userID string, body []byte, req *OpenAIRequest) ([]byte, *experimentAssignment, error) {
+	e, err := loadExperiment(r.Context(), userID, req.ExperimentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, nil, err
+	}
+	delete(fields, "experiment_id")
+	if e.Status != ExperimentRunning {
+		body, err = json.Marshal(fields)
+		return body, nil, err
+	}
+
+	variant := e.assign(experimentSubject(e, r, userID))
+	if variant.Model != "" {
+		req.Model = variant.Model
+		fields["model"], _ = json.Marshal(variant.Model)
+	}
+	if variant.Temperature != nil {
+		fields["temperature"], _ = json.Marshal(*variant.Temperature)
+	}
+	if variant.TemplateID != "" {
+		req.TemplateID = variant.TemplateID
+		req.TemplateVersion = variant.TemplateVersion
+	}
+	if body, err = json.Marshal(fields); err != nil {
+		return nil, nil, err
+	}
+
+	assignment := &experimentAssignment{ExperimentID: e.ID, Variant: variant.Name}
+	// Клиент видит request ID в X-Request-ID и присылает его с отзывом
+	if requestID := requestid.FromContext(r.Context()); requestID != "" {
+		rdb.Set(r.Context(), experimentRequestKey(requestID), e.ID+"|"+variant.Name, experimentFeedbackTTL)
+	}
+	return body, assignment, nil
+}
+
+// recordExperimentOutcome adds a completion to the metrics of its variant
+func recordExperimentOutcome(a *experimentAssignment, latency time.Duration, costUSD float64, failed bool) {
+	if a == nil {
+		return
+	}
+	ctx := context.Background()
+	key := experimentStatsKey(a.ExperimentID)
+	prefix := a.Variant + ":"
+
+	pipe := rdb.Pipeline()
+	pipe.HIncrBy(ctx, key, prefix+"requests", 1)
+	if failed {
+		pipe.HIncrBy(ctx, key, prefix+"errors", 1)
+	} else {
+		pipe.HIncrBy(ctx, key, prefix+"latency_ms", latency.Milliseconds())
+		pipe.HIncrByFloat(ctx, key, prefix+"cost_usd", costUSD)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record outcome of experiment %s: %v", a.ExperimentID, err)
+	}
+}
+
+func writeExperimentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errExperimentNotFound):
+		apierror.New(http.StatusNotFound, "experiment not found").WithParam("experiment_id").Write(w)
+	case errors.Is(err, errExperimentInvalid):
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+	default:
+		writeTemplateError(w, err)
+	}
+}
+
+// CreateExperiment handles POST /v1/experiments; experiments start running
+func CreateExperiment(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	var req struct {
+		Name        string              `json:"name"`
+		Description string              `json:"description"`
+		SplitBy     string              `json:"split_by"`
+		Variants    []ExperimentVariant `json:"variants"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.SplitBy == "" {
+		req.SplitBy = SplitByAPIKey
+	}
+
+	now := time.Now().Unix()
+	e := &Experiment{
+		ID:          "exp_" + uuid.New().String(),
+		Object:      "experiment",
+		UserID:      userID,
+		Name:        req.Name,
+		Description: req.Description,
+		Status:      ExperimentRunning,
+		SplitBy:     req.SplitBy,
+		Variants:    req.Variants,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := e.validate(r.Context()); err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	if err := saveExperiment(r.Context(), e); err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, e)
+}
+
+// ListExperiments handles GET /v1/experiments, newest first
+func ListExperiments(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	ids, err := rdb.SMembers(r.Context(), userExperimentsKey(userID)).Result()
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	data := make([]*Experiment, 0, len(ids))
+	for _, id := range ids {
+		e, err := loadExperiment(r.Context(), userID, id)
+		if errors.Is(err, errExperimentNotFound) {
+			rdb.SRem(r.Context(), userExperimentsKey(userID), id)
+			continue
+		} else if err != nil {
+			writeExperimentError(w, err)
+			return
+		}
+		data = append(data, e)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].CreatedAt > data[j].CreatedAt })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// GetExperiment handles GET /v1/experiments/{id}
+func GetExperiment(w http.ResponseWriter, r *http.Request) {
+	e, err := loadExperiment(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// UpdateExperiment handles PATCH /v1/experiments/{id}: stop or resume it, or
+// change its description
+func UpdateExperiment(w http.ResponseWriter, r *http.Request) {
+	e, err := loadExperiment(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	var req struct {
+		Status      *string `json:"status"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Status != nil {
+		if *req.Status != ExperimentRunning && *req.Status != ExperimentStopped {
+			apierror.New(http.StatusBadRequest, "status must be running or stopped").WithParam("status").Write(w)
+			return
+		}
+		e.Status = *req.Status
+	}
+	if req.Description != nil {
+		e.Description = *req.Description
+	}
+	e.UpdatedAt = time.Now().Unix()
+	if err := saveExperiment(r.Context(), e); err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, e)
+}
+
+// DeleteExperiment handles DELETE /v1/experiments/{id} with its metrics
+func DeleteExperiment(w http.ResponseWriter, r *http.Request) {
+	e, err := loadExperiment(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(r.Context(), experimentKey(e.ID), experimentStatsKey(e.ID))
+	pipe.SRem(r.Context(), userExperimentsKey(e.UserID), e.ID)
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": e.ID, "object": "experiment.deleted", "deleted": true})
+}
+
+// ExperimentFeedback handles POST /v1/experiments/feedback: a score from -1
+// to 5 for the completion with request_id (the X-Request-ID of its
+// response), counted once per request
+func ExperimentFeedback(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+
+	var req struct {
+		RequestID string   `json:"request_id"`
+		Score     *float64 `json:"score"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RequestID == "" || req.Score == nil {
+		apierror.Write(w, http.StatusBadRequest, `body must be {"request_id": "...", "score": <number>}`)
+		return
+	}
+	if *req.Score < -1 || *req.Score > 5 {
+		apierror.New(http.StatusBadRequest, "score must be between -1 and 5").WithParam("score").Write(w)
+		return
+	}
+
+	assigned, err := rdb.Get(r.Context(), experimentRequestKey(req.RequestID)).Result()
+	if err == redis.Nil {
+		apierror.New(http.StatusNotFound, "no experiment assignment for this request").WithParam("request_id").Write(w)
+		return
+	} else if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	experimentID, variant, _ := strings.Cut(assigned, "|")
+	if _, err := loadExperiment(r.Context(), userID, experimentID); err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	// Отзыв на запрос принимается один раз
+	first, err := rdb.SetNX(r.Context(), experimentRequestKey(req.RequestID)+":feedback", *req.Score, experimentFeedbackTTL).Result()
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	if !first {
+		apierror.New(http.StatusConflict, "feedback for this request was already recorded").WithParam("request_id").Write(w)
+		return
+	}
+
+	if err := addExperimentFeedback(r.Context(), experimentID, variant, "", req.Score); err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":        "experiment.feedback",
+		"experiment_id": experimentID,
+		"variant":       variant,
+		"request_id":    req.RequestID,
+		"score":         *req.Score,
+	})
+}
+
+// addExperimentFeedback counts a thumbs up or down and/or a score for a
+// variant; an empty rating or nil score is skipped
+func addExperimentFeedback(ctx context.Context, experimentID, variant, rating string, score *float64) error {
+	pipe := rdb.Pipeline()
+	switch rating {
+	case RatingUp:
+		pipe.HIncrBy(ctx, experimentStatsKey(experimentID), variant+":thumbs_up", 1)
+	case RatingDown:
+		pipe.HIncrBy(ctx, experimentStatsKey(experimentID), variant+":thumbs_down", 1)
+	}
+	if score != nil {
+		pipe.HIncrBy(ctx, experimentStatsKey(experimentID), variant+":feedback_count", 1)
+		pipe.HIncrByFloat(ctx, experimentStatsKey(experimentID), variant+":feedback_sum", *score)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetExperimentResults handles GET /v1/experiments/{id}/results: requests,
+// errors, latency, cost and feedback per variant
+func GetExperimentResults(w http.ResponseWriter, r *http.Request) {
+	e, err := loadExperiment(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	fields, err := rdb.HGetAll(r.Context(), experimentStatsKey(e.ID)).Result()
+	if err != nil {
+		writeExperimentError(w, err)
+		return
+	}
+	stat := func(variant, name string) float64 {
+		n, _ := strconv.ParseFloat(fields[variant+":"+name], 64)
+		return n
+	}
+
+	variants := make([]map[string]interface{}, 0, len(e.Variants))
+	for _, v := range e.Variants {
+		requests, errs := stat(v.Name, "requests"), stat(v.Name, "errors")
+		succeeded := requests - errs
+		feedbackCount := stat(v.Name, "feedback_count")
+
+		result := map[string]interface{}{
+			"variant":        v.Name,
+			"requests":       int64(requests),
+			"errors":         int64(errs),
+			"total_cost_usd": stat(v.Name, "cost_usd"),
+			"feedback_count": int64(feedbackCount),
+			"thumbs_up":      int64(stat(v.Name, "thumbs_up")),
+			"thumbs_down":    int64(stat(v.Name, "thumbs_down")),
+		}
+		if requests > 0 {
+			result["error_rate"] = errs / requests
+		}
+		if succeeded > 0 {
+			result["avg_latency_ms"] = stat(v.Name, "latency_ms") / succeeded
+			result["avg_cost_usd"] = stat(v.Name, "cost_usd") / succeeded
+		}
+		if feedbackCount > 0 {
+			result["avg_feedback"] = stat(v.Name, "feedback_sum") / feedbackCount
+		}
+		variants = append(variants, result)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":        "experiment.results",
+		"experiment_id": e.ID,
+		"status":        e.Status,
+		"variants":      variants,
+	})
+}
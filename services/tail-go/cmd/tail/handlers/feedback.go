@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/go-redis/redis/v8"
+)
+
+var errCompletionNotFound = errors.New("completion not found")
+
+// Отзывы о ответах:
+// FEEDBACK_RETENTION_DAYS — сколько хранятся ответы (для отзыва) и сами отзывы,
+// FEEDBACK_COMMENT_MAX_BYTES — максимальный размер текста отзыва,
+// FEEDBACK_SUMMARY_LIMIT — сколько последних отзывов учитывает сводка.
+var (
+	feedbackRetention       = time.Duration(envInt("FEEDBACK_RETENTION_DAYS", 90)) * 24 * time.Hour
+	feedbackCommentMaxBytes = envInt("FEEDBACK_COMMENT_MAX_BYTES", 4096)
+	feedbackSummaryLimit    = envInt("FEEDBACK_SUMMARY_LIMIT", 10000)
+)
+
+// FeedbackChannel is the Redis channel every feedback is published on, e.g.
+// for routing-service to use as an outcome signal
+const FeedbackChannel = "completion_feedback"
+
+// Ratings
+const (
+	RatingUp   = "up"
+	RatingDown = "down"
+)
+
+// CompletionRecord is the metadata of a chat completion kept for feedback
+type CompletionRecord struct {
+	ID               string  `json:"id"`
+	RequestID        string  `json:"request_id,omitempty"`
+	UserID           string  `json:"user_id,omitempty"`
+	Model            string  `json:"model"`
+	Provider         string  `json:"provider"`
+	TemplateID       string  `json:"template_id,omitempty"`
+	TemplateVersion  int     `json:"template_version,omitempty"`
+	ExperimentID     string  `json:"experiment_id,omitempty"`
+	Variant          string  `json:"variant,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+	LatencyMs        int64   `json:"latency_ms"`
+	Stream           bool    `json:"stream,omitempty"`
+	// CacheSimilarity is set when the response came from the semantic cache
+	CacheSimilarity float64 `json:"cache_similarity,omitempty"`
+	CreatedAt       int64   `json:"created_at"`
+}
+
+// CompletionFeedback is a user's feedback on a completion, stored with the
+// metadata of the completion
+type CompletionFeedback struct {
+	Object     string           `json:"object"`
+	Rating     string           `json:"rating,omitempty"`
+	Score      *float64         `json:"score,omitempty"`
+	Comment    string           `json:"comment,omitempty"`
+	CreatedAt  int64            `json:"created_at"`
+	Completion CompletionRecord `json:"completion"`
+}
+
+func completionRecordKey(id string) string   { return "completion:" + id }
+func completionFeedbackKey(id string) string { return "completion_feedback:" + id }
+func userFeedbackKey(userID string) string {
+	return "completion_feedback:user:" + userID
+}
+
+// newCompletionRecord fills the metadata of a completion that is known before
+// its response
+func newCompletionRecord(r *http.Request, userID, provider string, req OpenAIRequest, tmplUse *templateUse, assignment *experimentAssignment) CompletionRecord {
+	record := CompletionRecord{
+		RequestID: requestid.FromContext(r.Context()),
+		UserID:    userID,
+		Model:     req.Model,
+		Provider:  provider,
+		Stream:    req.Stream,
+	}
+	if tmplUse != nil {
+		record.TemplateID, record.TemplateVersion = tmplUse.TemplateID, tmplUse.Version
+	}
+	if assignment != nil {
+		record.ExperimentID, record.Variant = assignment.ExperimentID, assignment.Variant
+	}
+	return record
+}
+
+// rememberCompletion keeps the metadata of a completion so feedback on it can
+// be tied to the model, template and experiment variant that produced it
+func rememberCompletion(record CompletionRecord) {
+	if record.ID == "" {
+		return
+	}
+	record.CreatedAt = time.Now().Unix()
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if err := rdb.Set(context.Background(), completionRecordKey(record.ID), raw, feedbackRetention).Err(); err != nil {
+		log.Printf("Failed to store completion %s: %v", record.ID, err)
+	}
+}
+
+// completionID returns the ID of a chat completion response, or of a stream
+// chunk
+func completionID(body []byte) string {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(body, &resp)
+	return resp.ID
+}
+
+func loadCompletionRecord(ctx context.Context, userID, id string) (*CompletionRecord, error) {
+	raw, err := rdb.Get(ctx, completionRecordKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, errCompletionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var record CompletionRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, err
+	}
+	if record.UserID != userID {
+		return nil, errCompletionNotFound
+	}
+	return &record, nil
+}
+
+func writeFeedbackError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCompletionNotFound) {
+		apierror.New(http.StatusNotFound, "completion not found or too old for feedback").WithParam("id").Write(w)
+		return
+	}
+	log.Printf("Redis error: %v", err)
+	apierror.Write(w, http.StatusInternalServerError, "internal error")
+}
+
+// CompletionFeedbackHandler handles POST /v1/completions/{id}/feedback: a thumbs
+// up or down, a score from 1 to 5 and/or a comment, once per completion. The
+// ID is the id of the completion response, also sent as X-Completion-ID.
+func CompletionFeedbackHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+
+	var req struct {
+		Rating  string   `json:"rating"`
+		Score   *float64 `json:"score"`
+		Comment string   `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Rating != "" && req.Rating != RatingUp && req.Rating != RatingDown {
+		apierror.New(http.StatusBadRequest, "rating must be up or down").WithParam("rating").Write(w)
+		return
+	}
+	if req.Score != nil && (*req.Score < 1 || *req.Score > 5) {
+		apierror.New(http.StatusBadRequest, "score must be between 1 and 5").WithParam("score").Write(w)
+		return
+	}
+	if len(req.Comment) > feedbackCommentMaxBytes {
+		apierror.New(http.StatusBadRequest, "comment is too long").WithParam("comment").Write(w)
+		return
+	}
+	if req.Rating == "" && req.Score == nil && strings.TrimSpace(req.Comment) == "" {
+		apierror.Write(w, http.StatusBadRequest, "rating, score or comment is required")
+		return
+	}
+
+	record, err := loadCompletionRecord(r.Context(), userID, r.PathValue("id"))
+	if err != nil {
+		writeFeedbackError(w, err)
+		return
+	}
+
+	feedback := CompletionFeedback{
+		Object:     "completion.feedback",
+		Rating:     req.Rating,
+		Score:      req.Score,
+		Comment:    req.Comment,
+		CreatedAt:  time.Now().Unix(),
+		Completion: *record,
+	}
+	raw, err := json.Marshal(feedback)
+	if err != nil {
+		writeFeedbackError(w, err)
+		return
+	}
+
+	// Один отзыв на ответ: повторный не должен дважды попасть в статистику
+	first, err := rdb.SetNX(r.Context(), completionFeedbackKey(record.ID), raw, feedbackRetention).Result()
+	if err != nil {
+		writeFeedbackError(w, err)
+		return
+	}
+	if !first {
+		apierror.New(http.StatusConflict, "feedback for this completion was already recorded").WithParam("id").Write(w)
+		return
+	}
+	if err := rdb.ZAdd(r.Context(), userFeedbackKey(userID), &redis.Z{Score: float64(feedback.CreatedAt), Member: record.ID}).Err(); err != nil {
+		writeFeedbackError(w, err)
+		return
+	}
+
+	if record.ExperimentID != "" {
+		// Оценку запроса могли уже передать через /v1/experiments/feedback
+		score := feedback.Score
+		if score != nil && record.RequestID != "" {
+			if first, err := rdb.SetNX(r.Context(), experimentRequestKey(record.RequestID)+":feedback", *score, experimentFeedbackTTL).Result(); err != nil || !first {
+				score = nil
+			}
+		}
+		if err := addExperimentFeedback(r.Context(), record.ExperimentID, record.Variant, feedback.Rating, score); err != nil {
+			log.Printf("Failed to record experiment feedback on %s: %v", record.ID, err)
+		}
+	}
+	if record.CacheSimilarity > 0 && feedback.Rating != "" {
+		semanticCacheFeedback.WithLabelValues(feedback.Rating).Inc()
+	}
+	if err := rdb.Publish(r.Context(), FeedbackChannel, raw).Err(); err != nil {
+		log.Printf("Failed to publish feedback on %s: %v", record.ID, err)
+	}
+
+	writeJSON(w, http.StatusCreated, feedback)
+}
+
+// loadFeedback returns the user's feedback since the given time, newest first
+func loadFeedback(ctx context.Context, userID string, since int64, limit int) ([]CompletionFeedback, error) {
+	ids, err := rdb.ZRevRangeByScore(ctx, userFeedbackKey(userID), &redis.ZRangeBy{
+		Min:   strconv.FormatInt(since, 10),
+		Max:   "+inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	feedback := make([]CompletionFeedback, 0, len(ids))
+	for _, id := range ids {
+		raw, err := rdb.Get(ctx, completionFeedbackKey(id)).Bytes()
+		if err == redis.Nil {
+			// Истёк по FEEDBACK_RETENTION_DAYS — убираем из индекса
+			rdb.ZRem(ctx, userFeedbackKey(userID), id)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var f CompletionFeedback
+		if err := json.Unmarshal(raw, &f); err == nil {
+			feedback = append(feedback, f)
+		}
+	}
+	return feedback, nil
+}
+
+// feedbackFilter reads the since, model and rating query parameters
+func feedbackFilter(r *http.Request) (since int64, match func(CompletionFeedback) bool, err error) {
+	q := r.URL.Query()
+	if v := q.Get("since"); v != "" {
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, nil, errors.New("since must be a unix timestamp")
+		}
+	}
+	model, rating, templateID := q.Get("model"), q.Get("rating"), q.Get("template_id")
+	match = func(f CompletionFeedback) bool {
+		return (model == "" || f.Completion.Model == model) &&
+			(rating == "" || f.Rating == rating) &&
+			(templateID == "" || f.Completion.TemplateID == templateID)
+	}
+	return since, match, nil
+}
+
+// ListFeedback handles GET /v1/feedback: the user's feedback, newest first,
+// filtered by since, model, rating and template_id, up to limit (max 100)
+func ListFeedback(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+	since, match, err := feedbackFilter(r)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 20
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 {
+		limit = min(v, 100)
+	}
+
+	all, err := loadFeedback(r.Context(), userID, since, feedbackSummaryLimit)
+	if err != nil {
+		writeFeedbackError(w, err)
+		return
+	}
+	data := make([]CompletionFeedback, 0, limit)
+	for _, f := range all {
+		if len(data) == limit {
+			break
+		}
+		if match(f) {
+			data = append(data, f)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// feedbackGroup is the summary of the feedback of one group
+type feedbackGroup struct {
+	Key          string   `json:"key"`
+	Count        int      `json:"count"`
+	Up           int      `json:"up"`
+	Down         int      `json:"down"`
+	Scored       int      `json:"scored"`
+	AvgScore     *float64 `json:"avg_score,omitempty"`
+	Comments     int      `json:"comments"`
+	AvgLatencyMs float64  `json:"avg_latency_ms"`
+	scoreSum     float64
+	latencySum   int64
+}
+
+// FeedbackSummary handles GET /v1/feedback/summary: counts, thumbs, average
+// score and latency grouped by model (default), provider, template or
+// experiment, over the last FEEDBACK_SUMMARY_LIMIT feedbacks
+func FeedbackSummary(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+	since, match, err := feedbackFilter(r)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	var key func(CompletionRecord) string
+	switch groupBy {
+	case "", "model":
+		groupBy, key = "model", func(c CompletionRecord) string { return c.Model }
+	case "provider":
+		key = func(c CompletionRecord) string { return c.Provider }
+	case "template":
+		key = func(c CompletionRecord) string {
+			if c.TemplateID == "" {
+				return ""
+			}
+			return c.TemplateID + "@" + strconv.Itoa(c.TemplateVersion)
+		}
+	case "experiment":
+		key = func(c CompletionRecord) string {
+			if c.ExperimentID == "" {
+				return ""
+			}
+			return c.ExperimentID + "/" + c.Variant
+		}
+	default:
+		apierror.New(http.StatusBadRequest, "group_by must be model, provider, template or experiment").WithParam("group_by").Write(w)
+		return
+	}
+
+	all, err := loadFeedback(r.Context(), userID, since, feedbackSummaryLimit)
+	if err != nil {
+		writeFeedbackError(w, err)
+		return
+	}
+
+	groups := make(map[string]*feedbackGroup)
+	for _, f := range all {
+		if !match(f) {
+			continue
+		}
+		k := key(f.Completion)
+		g := groups[k]
+		if g == nil {
+			g = &feedbackGroup{Key: k}
+			groups[k] = g
+		}
+		g.Count++
+		switch f.Rating {
+		case RatingUp:
+			g.Up++
+		case RatingDown:
+			g.Down++
+		}
+		if f.Score != nil {
+			g.Scored++
+			g.scoreSum += *f.Score
+		}
+		if f.Comment != "" {
+			g.Comments++
+		}
+		g.latencySum += f.Completion.LatencyMs
+	}
+
+	data := make([]*feedbackGroup, 0, len(groups))
+	for _, g := range groups {
+		if g.Scored > 0 {
+			avg := g.scoreSum / float64(g.Scored)
+			g.AvgScore = &avg
+		}
+		g.AvgLatencyMs = float64(g.latencySum) / float64(g.Count)
+		data = append(data, g)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Count > data[j].Count })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "feedback.summary", "group_by": groupBy, "data": data})
+}
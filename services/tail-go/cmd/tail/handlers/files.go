@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/clamav"
+	"github.com/MaksimVF/ZB/pkg/objectstore"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Файлы хранятся 30 дней
+const fileTTL = 30 * 24 * time.Hour
+
+// FILES_MAX_BYTES — максимальный размер файла (OpenAI допускает до 200 MB для batch),
+// FILES_QUOTA_BYTES и FILES_QUOTA_COUNT — объём и число файлов одного пользователя
+var (
+	maxFileSize    = envInt("FILES_MAX_BYTES", 200<<20)
+	fileQuotaBytes = envInt("FILES_QUOTA_BYTES", 10<<30)
+	fileQuotaCount = envInt("FILES_QUOTA_COUNT", 1000)
+)
+
+const maxFileListSize = 100
+
+// Назначения файлов, которые можно загрузить; batch_output создаёт сам tail
+var uploadPurposes = map[string]bool{
+	"batch":        true,
+	"batch_output": true,
+	"assistants":   true,
+	"user_data":    true,
+}
+
+// Содержимое файлов — в S3-совместимом хранилище (FILES_S3_BUCKET и т.д.),
+// без него — в Redis рядом с метаданными
+var fileContents = newFileStore()
+
+// Антивирусная проверка загрузок, если задан CLAMAV_ADDR
+var fileScanner = clamav.FromEnv()
+
+var (
+	errFileQuotaExceeded = errors.New("file quota exceeded")
+	errFileInfected      = errors.New("file is infected")
+)
+
+// FileObject is an OpenAI-compatible file object
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	UserID    string `json:"user_id,omitempty"`
+}
+
+// FileList is the body of GET /v1/files
+type FileList struct {
+	Object string        `json:"object"`
+	Data   []*FileObject `json:"data"`
+}
+
+// FileDeleted is the body of DELETE /v1/files/{id}
+type FileDeleted struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Deleted bool   `json:"deleted"`
+}
+
+func fileKey(id string) string          { return "file:" + id }
+func fileContentKey(id string) string   { return "file:" + id + ":content" }
+func userFilesKey(userID string) string { return "files:user:" + userID }
+
+// fileStore keeps file contents; a missing file is reported as redis.Nil,
+// like its metadata
+type fileStore interface {
+	Put(ctx context.Context, id string, content []byte) error
+	Get(ctx context.Context, id string) ([]byte, error)
+	Delete(ctx context.Context, id string) error
+}
+
+func newFileStore() fileStore {
+	cfg, ok := objectstore.ConfigFromEnv("FILES")
+	if !ok {
+		return redisFileStore{}
+	}
+	s3, err := objectstore.New(cfg, nil)
+	if err != nil {
+		log.Fatalf("Invalid file storage configuration: %v", err)
+	}
+	return s3FileStore{s3}
+}
+
+type redisFileStore struct{}
+
+func (redisFileStore) Put(ctx context.Context, id string, content []byte) error {
+	return rdb.Set(ctx, fileContentKey(id), content, fileTTL).Err()
+}
+
+func (redisFileStore) Get(ctx context.Context, id string) ([]byte, error) {
+	return rdb.Get(ctx, fileContentKey(id)).Bytes()
+}
+
+func (redisFileStore) Delete(ctx context.Context, id string) error {
+	return rdb.Del(ctx, fileContentKey(id)).Err()
+}
+
+// s3FileStore keeps contents under <FILES_S3_PREFIX><id>; objects of expired
+// files are removed by a lifecycle rule of the bucket
+type s3FileStore struct{ s3 *objectstore.S3 }
+
+func (s s3FileStore) Put(ctx context.Context, id string, content []byte) error {
+	return s.s3.Put(ctx, id, content, "application/octet-stream")
+}
+
+func (s s3FileStore) Get(ctx context.Context, id string) ([]byte, error) {
+	content, err := s.s3.Get(ctx, id)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, redis.Nil
+	}
+	return content, err
+}
+
+func (s s3FileStore) Delete(ctx context.Context, id string) error {
+	return s.s3.Delete(ctx, id)
+}
+
+// saveFile stores a file of userID: the content in the file store, the
+// metadata in Redis
+func saveFile(ctx context.Context, userID, filename, purpose string, content []byte) (*FileObject, error) {
+	now := time.Now()
+	file := &FileObject{
+		ID:        "file-" + uuid.New().String(),
+		Object:    "file",
+		Bytes:     len(content),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(fileTTL).Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+		UserID:    userID,
+	}
+
+	meta, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fileContents.Put(ctx, file.ID, content); err != nil {
+		return nil, err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(ctx, fileKey(file.ID), meta, fileTTL)
+	pipe.ZAdd(ctx, userFilesKey(userID), &redis.Z{Score: float64(file.CreatedAt), Member: file.ID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		fileContents.Delete(ctx, file.ID)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// loadFile returns file metadata from Redis
+func loadFile(ctx context.Context, id string) (*FileObject, error) {
+	raw, err := rdb.Get(ctx, fileKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var file FileObject
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// loadUserFile returns file metadata if the file belongs to userID, redis.Nil otherwise
+func loadUserFile(ctx context.Context, userID, id string) (*FileObject, error) {
+	file, err := loadFile(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if file.UserID != userID {
+		return nil, redis.Nil
+	}
+	return file, nil
+}
+
+// loadFileContent returns the raw content of a file
+func loadFileContent(ctx context.Context, id string) ([]byte, error) {
+	return fileContents.Get(ctx, id)
+}
+
+// deleteFile removes a file with its content
+func deleteFile(ctx context.Context, file *FileObject) error {
+	if err := fileContents.Delete(ctx, file.ID); err != nil {
+		return err
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Del(ctx, fileKey(file.ID))
+	pipe.ZRem(ctx, userFilesKey(file.UserID), file.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// listUserFiles returns the files of userID, newest first; files that
+// expired are dropped from the index
+func listUserFiles(ctx context.Context, userID string) ([]*FileObject, error) {
+	ids, err := rdb.ZRevRange(ctx, userFilesKey(userID), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	// По ключу на запрос: в Redis Cluster файлы лежат в разных слотах
+	pipe := rdb.Pipeline()
+	gets := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		gets[i] = pipe.Get(ctx, fileKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	files := make([]*FileObject, 0, len(ids))
+	for i, get := range gets {
+		raw, err := get.Bytes()
+		if err == redis.Nil {
+			rdb.ZRem(ctx, userFilesKey(userID), ids[i])
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		var file FileObject
+		if err := json.Unmarshal(raw, &file); err != nil {
+			continue
+		}
+		files = append(files, &file)
+	}
+	return files, nil
+}
+
+// checkFileQuota fails with errFileQuotaExceeded if a file of size bytes
+// does not fit into the quota of userID
+func checkFileQuota(ctx context.Context, userID string, size int) error {
+	files, err := listUserFiles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	used := size
+	for _, file := range files {
+		used += file.Bytes
+	}
+	if len(files)+1 > fileQuotaCount || used > fileQuotaBytes {
+		return errFileQuotaExceeded
+	}
+	return nil
+}
+
+// validateFileContent checks that batch input is JSONL and scans the file
+// for malware when a scanner is configured
+func validateFileContent(ctx context.Context, purpose string, content []byte) error {
+	if purpose == "batch" {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(make([]byte, 1024*1024), 10*1024*1024)
+		for n := 1; scanner.Scan(); n++ {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) > 0 && !json.Valid(line) {
+				return fmt.Errorf("line %d is not valid JSON", n)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("invalid JSONL: %v", err)
+		}
+	}
+
+	if fileScanner == nil {
+		return nil
+	}
+	virus, err := fileScanner.Scan(ctx, content)
+	if err != nil {
+		return err
+	}
+	if virus != "" {
+		return fmt.Errorf("%w: %s", errFileInfected, virus)
+	}
+	return nil
+}
+
+// UploadFile handles POST /v1/files (multipart: file, purpose)
+func UploadFile(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxFileSize)+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Write(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d bytes", maxFileSize))
+			return
+		}
+		apierror.Write(w, http.StatusBadRequest, "invalid multipart form")
+		return
+	}
+
+	purpose := r.FormValue("purpose")
+	if !uploadPurposes[purpose] {
+		apierror.New(http.StatusBadRequest, "unsupported purpose").WithParam("purpose").Write(w)
+		return
+	}
+
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	defer upload.Close()
+
+	content, err := io.ReadAll(upload)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, "failed to read file")
+		return
+	}
+	if len(content) == 0 {
+		apierror.New(http.StatusBadRequest, "file is empty").WithParam("file").Write(w)
+		return
+	}
+	if len(content) > maxFileSize {
+		apierror.Write(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file exceeds %d bytes", maxFileSize))
+		return
+	}
+
+	if err := checkFileQuota(r.Context(), userID, len(content)); errors.Is(err, errFileQuotaExceeded) {
+		apierror.New(http.StatusForbidden, fmt.Sprintf("file quota exceeded: at most %d files and %d bytes", fileQuotaCount, fileQuotaBytes)).
+			WithCode("file_quota_exceeded").Write(w)
+		return
+	} else if err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := validateFileContent(r.Context(), purpose, content); errors.Is(err, clamav.ErrUnavailable) {
+		log.Printf("Virus scan failed: %v", err)
+		apierror.Write(w, http.StatusServiceUnavailable, "virus scanner unavailable")
+		return
+	} else if errors.Is(err, errFileInfected) {
+		log.Printf("Rejected upload %q of user %q: %v", header.Filename, userID, err)
+		apierror.New(http.StatusUnprocessableEntity, "file rejected by virus scan").WithCode("file_infected").Write(w)
+		return
+	} else if err != nil {
+		apierror.New(http.StatusBadRequest, err.Error()).WithParam("file").Write(w)
+		return
+	}
+
+	file, err := saveFile(r.Context(), userID, header.Filename, purpose, content)
+	if err != nil {
+		log.Printf("Failed to store file: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// ListFiles handles GET /v1/files, newest first, optionally filtered by purpose
+func ListFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := listUserFiles(r.Context(), r.Header.Get("X-User-ID"))
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxFileListSize {
+		limit = maxFileListSize
+	}
+	purpose := r.URL.Query().Get("purpose")
+	data := make([]*FileObject, 0, len(files))
+	for _, file := range files {
+		if len(data) == limit {
+			break
+		}
+		if purpose == "" || file.Purpose == purpose {
+			data = append(data, file)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileList{Object: "list", Data: data})
+}
+
+// GetFile handles GET /v1/files/{id}
+func GetFile(w http.ResponseWriter, r *http.Request) {
+	file, err := loadUserFile(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "file not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// GetFileContent handles GET /v1/files/{id}/content
+func GetFileContent(w http.ResponseWriter, r *http.Request) {
+	file, err := loadUserFile(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	var content []byte
+	if err == nil {
+		content, err = loadFileContent(r.Context(), file.ID)
+	}
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "file not found")
+		return
+	} else if err != nil {
+		log.Printf("Failed to load file: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	contentType := "application/octet-stream"
+	if file.Purpose == "batch" || file.Purpose == "batch_output" {
+		contentType = "application/jsonl"
+	}
+	filename := file.Filename
+	if filename == "" {
+		filename = file.ID
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(content)
+}
+
+// DeleteFile handles DELETE /v1/files/{id}
+func DeleteFile(w http.ResponseWriter, r *http.Request) {
+	file, err := loadUserFile(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "file not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := deleteFile(r.Context(), file); err != nil {
+		log.Printf("Failed to delete file %s: %v", file.ID, err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileDeleted{ID: file.ID, Object: "file", Deleted: true})
+}
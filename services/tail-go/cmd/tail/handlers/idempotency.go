@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+)
+
+// Idempotency-Key: повтор запроса с тем же ключом получает сохранённый ответ
+// вместо нового вызова провайдера, поэтому ретраи клиента после таймаута не
+// списываются дважды. Ключи действуют в пределах пользователя
+// IDEMPOTENCY_TTL_HOURS (24 ч).
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader отмечает ответы, отданные из хранилища
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyMaxKeyLength   = 255
+	// idempotencyLockTTL ограничивает, сколько ключ остаётся занятым, если
+	// обработка запроса оборвалась вместе с инстансом
+	idempotencyLockTTL = 10 * time.Minute
+)
+
+var idempotencyTTL = time.Duration(envInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour
+
+// idempotencyMaxBody — сколько ответа копируется для повтора
+// (IDEMPOTENCY_MAX_BODY_KB, 1 МБ); длинные стримы дальше не копируются
+var idempotencyMaxBody = envInt("IDEMPOTENCY_MAX_BODY_KB", 1024) * 1024
+
+// storedResponse is a response kept for replay. Status is 0 while the first
+// request with the key is still being handled.
+type storedResponse struct {
+	RequestHash string      `json:"request_hash"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	// TooLarge marks a response over idempotencyMaxBody, kept without its body
+	TooLarge bool `json:"too_large,omitempty"`
+}
+
+// Idempotent replays the stored response of a request with the same
+// Idempotency-Key from the same user. Responses are kept unless the handler
+// failed with a 5xx or a stream was cut off, so that those can be retried.
+func Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > idempotencyMaxKeyLength {
+			apierror.Write(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		scope := idempotencyScope(r)
+		if scope == "" {
+			// Анонимные ключи могли бы пересечься у разных клиентов
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		// ?async=true и синхронный запрос с одним ключом — разные запросы
+		hash := sha256.Sum256(append([]byte(r.URL.RequestURI()+"\x00"), body...))
+		requestHash := hex.EncodeToString(hash[:])
+
+		redisKey := "idempotency:" + scope + ":" + key
+		pending, _ := json.Marshal(storedResponse{RequestHash: requestHash})
+		acquired, err := rdb.SetNX(r.Context(), redisKey, pending, idempotencyLockTTL).Result()
+		if err != nil {
+			// Без Redis обрабатываем запрос как обычно
+			log.Printf("Idempotency store unavailable: %v", err)
+			next(w, r)
+			return
+		}
+		if !acquired {
+			replayIdempotent(w, r, redisKey, requestHash)
+			return
+		}
+
+		before := w.Header().Clone()
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK, limit: idempotencyMaxBody}
+		next(rec, r)
+
+		ctx := r.Context()
+		streamCut := strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") && ctx.Err() != nil
+		if rec.status >= 500 || streamCut {
+			if err := rdb.Del(context.Background(), redisKey).Err(); err != nil {
+				log.Printf("Failed to release Idempotency-Key: %v", err)
+			}
+			return
+		}
+
+		stored := storedResponse{
+			RequestHash: requestHash,
+			Status:      rec.status,
+			Header:      http.Header{},
+			Body:        rec.body.Bytes(),
+			TooLarge:    rec.overflow,
+		}
+		// Только заголовки обработчика: лимиты и т.п. middleware выставит заново
+		for name, values := range w.Header() {
+			if name != "Trailer" && !slices.Equal(before[name], values) {
+				stored.Header[name] = values
+			}
+		}
+		raw, err := json.Marshal(stored)
+		if err != nil {
+			return
+		}
+		if err := rdb.Set(context.Background(), redisKey, raw, idempotencyTTL).Err(); err != nil {
+			log.Printf("Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+func replayIdempotent(w http.ResponseWriter, r *http.Request, redisKey, requestHash string) {
+	raw, err := rdb.Get(r.Context(), redisKey).Bytes()
+	if err != nil {
+		log.Printf("Failed to load idempotent response: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	var stored storedResponse
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	switch {
+	case stored.RequestHash != requestHash:
+		apierror.New(http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request").WithCode("idempotency_key_reused").Write(w)
+	case stored.TooLarge:
+		// Повторить нечем, а новый вызов провайдера списал бы запрос дважды
+		apierror.New(http.StatusConflict, "the response to this Idempotency-Key was too large to store").WithCode("idempotency_response_too_large").Write(w)
+	case stored.Status == 0:
+		w.Header().Set("Retry-After", "1")
+		apierror.New(http.StatusConflict, "a request with this Idempotency-Key is in progress").WithCode("idempotency_key_in_use").Write(w)
+	default:
+		for name, values := range stored.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set(idempotencyReplayedHeader, "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+	}
+}
+
+// idempotencyScope returns the user the key belongs to: X-User-ID, or a hash
+// of the credentials for callers without one
+func idempotencyScope(r *http.Request) string {
+	if userID := r.Header.Get("X-User-ID"); userID != "" {
+		return "user:" + userID
+	}
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		hash := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(hash[:16])
+	}
+	return ""
+}
+
+// idempotencyRecorder passes the response through and keeps a copy of it, up
+// to limit bytes
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int
+	overflow    bool
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	switch {
+	case rec.overflow:
+	case rec.body.Len()+len(p) > rec.limit:
+		rec.overflow = true
+		rec.body = bytes.Buffer{}
+	default:
+		rec.body.Write(p)
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// Flush keeps streaming responses streaming
+func (rec *idempotencyRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
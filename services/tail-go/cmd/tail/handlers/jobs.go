@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/annotations"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/webhooksig"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal"
+)
+
+// Очередь асинхронных запросов /v1/chat/completions?async=true
+const chatJobsQueue = "chat_jobs_queue"
+
+// Сколько хранится задача и её результат
+const chatJobRetention = 7 * 24 * time.Hour
+
+// Statuses of a chat job
+const (
+	JobStatusQueued     = "queued"
+	JobStatusInProgress = "in_progress"
+	JobStatusCompleted  = "completed"
+	JobStatusFailed     = "failed"
+)
+
+// jobWebhookHeader задаёт URL, на который отправляется завершённая задача
+const jobWebhookHeader = "X-Webhook-URL"
+
+// jobWebhookSource — X-Webhook-Source доставок; подпись ставится, если задан JOBS_WEBHOOK_SECRET
+const jobWebhookSource = "tail-jobs"
+
+// chatJobsConcurrency — сколько задач выполняется одновременно (JOBS_CONCURRENCY)
+var chatJobsConcurrency = envInt("JOBS_CONCURRENCY", 4)
+
+var jobWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// ChatJob tracks an asynchronous chat completion
+type ChatJob struct {
+	ID     string `json:"id"`
+	Object string `json:"object"`
+	Model  string `json:"model"`
+	Status string `json:"status"`
+	// ResponseStatus is the status code of the completion, set once it ran
+	ResponseStatus int    `json:"response_status,omitempty"`
+	Error          string `json:"error,omitempty"`
+	WebhookURL     string `json:"webhook_url,omitempty"`
+	// WebhookStatus is delivered or failed once the webhook was called
+	WebhookStatus string `json:"webhook_status,omitempty"`
+	UserID        string `json:"user_id,omitempty"`
+	CreatedAt     int64  `json:"created_at"`
+	StartedAt     int64  `json:"started_at,omitempty"`
+	CompletedAt   int64  `json:"completed_at,omitempty"`
+	// Result is the completion response, included once the job finished
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// chatJobRequest is the original request, replayed by the worker and
+// deleted once the job finished since it carries the client's credentials
+type chatJobRequest struct {
+	Path      string            `json:"path"`
+	Body      json.RawMessage   `json:"body"`
+	Header    map[string]string `json:"header"`
+	RequestID string            `json:"request_id"`
+}
+
+// chatJobHeaders are the headers ChatCompletion reads
+var chatJobHeaders = []string{"Authorization", "X-User-ID", annotations.RequestHeader}
+
+func chatJobKey(id string) string        { return "chat_job:" + id }
+func chatJobRequestKey(id string) string { return "chat_job:" + id + ":request" }
+func chatJobResultKey(id string) string  { return "chat_job:" + id + ":result" }
+
+func saveChatJob(ctx context.Context, job *ChatJob) error {
+	// Результат хранится отдельным ключом
+	stored := *job
+	stored.Result = nil
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, chatJobKey(job.ID), raw, chatJobRetention).Err()
+}
+
+func loadChatJob(ctx context.Context, id string) (*ChatJob, error) {
+	raw, err := rdb.Get(ctx, chatJobKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var job ChatJob
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// validJobWebhookURL допускает только абсолютные https-адреса
+func validJobWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+// submitChatJob handles POST /v1/chat/completions?async=true: the request is
+// validated, queued and answered with 202 and the job right away
+func submitChatJob(w http.ResponseWriter, r *http.Request, body []byte, req OpenAIRequest) {
+	if req.Stream {
+		apierror.New(http.StatusBadRequest, "async requests cannot be streamed").WithParam("stream").Write(w)
+		return
+	}
+	if _, err := internal.GetProviderForModel(req.Model); err != nil && req.ExperimentID == "" {
+		apierror.New(http.StatusBadRequest, "model not supported").WithParam("model").WithCode("model_not_found").Write(w)
+		return
+	}
+	webhookURL := r.Header.Get(jobWebhookHeader)
+	if webhookURL != "" && !validJobWebhookURL(webhookURL) {
+		apierror.Write(w, http.StatusBadRequest, jobWebhookHeader+" must be an absolute https URL")
+		return
+	}
+
+	job := &ChatJob{
+		ID:         "job_" + uuid.New().String(),
+		Object:     "chat.completion.job",
+		Model:      req.Model,
+		Status:     JobStatusQueued,
+		WebhookURL: webhookURL,
+		UserID:     r.Header.Get("X-User-ID"),
+		CreatedAt:  time.Now().Unix(),
+	}
+	stored := chatJobRequest{
+		Path:      r.URL.Path,
+		Body:      body,
+		Header:    make(map[string]string),
+		RequestID: requestid.FromContext(r.Context()),
+	}
+	for _, name := range chatJobHeaders {
+		if v := r.Header.Get(name); v != "" {
+			stored.Header[name] = v
+		}
+	}
+	rawRequest, err := json.Marshal(stored)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := saveChatJob(r.Context(), job); err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(r.Context(), chatJobRequestKey(job.ID), rawRequest, chatJobRetention)
+	pipe.LPush(r.Context(), chatJobsQueue, job.ID)
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetChatJob handles GET /v1/jobs/{id}; a finished job includes its result
+func GetChatJob(w http.ResponseWriter, r *http.Request) {
+	job, err := loadChatJob(r.Context(), r.PathValue("id"))
+	if err == nil && job.UserID != r.Header.Get("X-User-ID") {
+		err = redis.Nil
+	}
+	if err == redis.Nil {
+		apierror.Write(w, http.StatusNotFound, "job not found")
+		return
+	} else if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if job.Status == JobStatusCompleted || job.Status == JobStatusFailed {
+		if raw, err := rdb.Get(r.Context(), chatJobResultKey(job.ID)).Bytes(); err == nil {
+			job.Result = raw
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// StartChatJobsWorker запускает JOBS_CONCURRENCY потребителей очереди асинхронных запросов
+func StartChatJobsWorker(ctx context.Context) {
+	for i := 0; i < chatJobsConcurrency; i++ {
+		go func() {
+			for {
+				res, err := rdb.BRPop(ctx, 5*time.Second, chatJobsQueue).Result()
+				if ctx.Err() != nil {
+					return
+				}
+				if err == redis.Nil {
+					continue
+				} else if err != nil {
+					log.Printf("Chat jobs queue error: %v", err)
+					time.Sleep(time.Second)
+					continue
+				}
+
+				processChatJob(ctx, res[1])
+			}
+		}()
+	}
+}
+
+// processChatJob выполняет запрос тем же обработчиком, что и синхронный
+// /v1/chat/completions, с повторами при 429 и 5xx, и сохраняет ответ
+func processChatJob(ctx context.Context, id string) {
+	job, err := loadChatJob(ctx, id)
+	if err != nil {
+		if ctx.Err() != nil {
+			requeueChatJob(id)
+			return
+		}
+		log.Printf("Chat job %s not found: %v", id, err)
+		return
+	}
+
+	raw, err := rdb.Get(ctx, chatJobRequestKey(id)).Bytes()
+	var stored chatJobRequest
+	if err == nil {
+		err = json.Unmarshal(raw, &stored)
+	}
+	if err != nil && ctx.Err() != nil {
+		requeueChatJob(id)
+		return
+	} else if err != nil {
+		finishChatJob(ctx, job, 0, nil, "request not found")
+		return
+	}
+
+	job.Status = JobStatusInProgress
+	job.StartedAt = time.Now().Unix()
+	saveChatJob(ctx, job)
+
+	var rec *httptest.ResponseRecorder
+	cfg := batchWorkerConfig
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		rec = replayChatJob(ctx, stored)
+		if !isRetryableStatus(rec.Code) || attempt == cfg.MaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(cfg.retryDelay(attempt)):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	// Остановка прервала задачу: её запрос сохранён, так что она снова
+	// ставится в очередь и выполняется другой репликой или после запуска
+	if ctx.Err() != nil {
+		restartChatJob(job)
+		return
+	}
+
+	if rec.Code != http.StatusOK {
+		finishChatJob(ctx, job, rec.Code, rec.Body.Bytes(), fmt.Sprintf("completion failed with status %d", rec.Code))
+		return
+	}
+	finishChatJob(ctx, job, rec.Code, rec.Body.Bytes(), "")
+}
+
+// restartChatJob возвращает задачу в queued и в очередь. Контекст воркера
+// уже отменён, поэтому используется свежий.
+func restartChatJob(job *ChatJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	job.Status = JobStatusQueued
+	job.StartedAt = 0
+	if err := saveChatJob(ctx, job); err != nil {
+		log.Printf("Failed to save chat job %s: %v", job.ID, err)
+	}
+	requeueChatJob(job.ID)
+}
+
+// requeueChatJob ставит id в голову очереди, откуда BRPop заберёт его первым
+func requeueChatJob(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rdb.RPush(ctx, chatJobsQueue, id).Err(); err != nil {
+		log.Printf("Failed to requeue chat job %s: %v", id, err)
+	}
+}
+
+// replayChatJob runs the stored request through ChatCompletion
+func replayChatJob(ctx context.Context, stored chatJobRequest) *httptest.ResponseRecorder {
+	ctx = requestid.NewContext(ctx, stored.RequestID)
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, stored.Path, bytes.NewReader(stored.Body))
+	r.Header.Set("Content-Type", "application/json")
+	for name, v := range stored.Header {
+		r.Header.Set(name, v)
+	}
+	rec := httptest.NewRecorder()
+	ChatCompletion(rec, r)
+	return rec
+}
+
+// finishChatJob сохраняет результат, удаляет исходный запрос и вызывает вебхук
+func finishChatJob(ctx context.Context, job *ChatJob, status int, result []byte, reason string) {
+	job.Status = JobStatusCompleted
+	if reason != "" {
+		job.Status = JobStatusFailed
+		job.Error = reason
+	}
+	job.ResponseStatus = status
+	job.CompletedAt = time.Now().Unix()
+	if len(result) > 0 && json.Valid(result) {
+		job.Result = result
+		if err := rdb.Set(ctx, chatJobResultKey(job.ID), result, chatJobRetention).Err(); err != nil {
+			log.Printf("Failed to store result of chat job %s: %v", job.ID, err)
+		}
+	}
+	rdb.Del(ctx, chatJobRequestKey(job.ID))
+
+	if job.WebhookURL != "" {
+		job.WebhookStatus = "delivered"
+		if err := deliverJobWebhook(ctx, job); err != nil {
+			log.Printf("Webhook of chat job %s failed: %v", job.ID, err)
+			job.WebhookStatus = "failed"
+		}
+	}
+	if err := saveChatJob(ctx, job); err != nil {
+		log.Printf("Failed to save chat job %s: %v", job.ID, err)
+	}
+}
+
+// deliverJobWebhook отправляет задачу с результатом POST-запросом, с теми же
+// повторами, что и элементы батчей
+func deliverJobWebhook(ctx context.Context, job *ChatJob) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	secret := os.Getenv("JOBS_WEBHOOK_SECRET")
+
+	cfg := batchWorkerConfig
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			webhooksig.SignRequest(req, jobWebhookSource, secret, uuid.New().String(), body, time.Now())
+		}
+
+		resp, err := jobWebhookClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+			if !isRetryableStatus(resp.StatusCode) {
+				return err
+			}
+		}
+		if attempt == cfg.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(cfg.retryDelay(attempt)):
+		}
+	}
+}
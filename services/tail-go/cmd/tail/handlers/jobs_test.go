@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func submitJob(t *testing.T, userID, webhookURL, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", ChatCompletion)
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions?async=true", strings.NewReader(body))
+	req.Header.Set("X-User-ID", userID)
+	req.Header.Set("Authorization", "Bearer sk-test")
+	if webhookURL != "" {
+		req.Header.Set(jobWebhookHeader, webhookURL)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSubmitChatJob(t *testing.T) {
+	ctx := context.Background()
+	rec := submitJob(t, "alice", "", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("submit: %d %s", rec.Code, rec.Body)
+	}
+	var job ChatJob
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JobStatusQueued || job.UserID != "alice" || rec.Header().Get("Location") != "/v1/jobs/"+job.ID {
+		t.Errorf("job %+v, Location %q", job, rec.Header().Get("Location"))
+	}
+	if queued, _ := rdb.LRange(ctx, chatJobsQueue, 0, -1).Result(); !slices.Contains(queued, job.ID) {
+		t.Errorf("job not queued: %v", queued)
+	}
+	var stored chatJobRequest
+	raw, _ := rdb.Get(ctx, chatJobRequestKey(job.ID)).Bytes()
+	if err := json.Unmarshal(raw, &stored); err != nil || stored.Header["Authorization"] != "Bearer sk-test" || stored.Path != "/v1/chat/completions" {
+		t.Errorf("stored request %+v, %v", stored, err)
+	}
+
+	for name, tc := range map[string]struct{ webhook, body string }{
+		"stream":        {"", `{"model":"gpt-4o","stream":true}`},
+		"unknown model": {"", `{"model":"no-such-model"}`},
+		"http webhook":  {"http://example.com/hook", `{"model":"gpt-4o"}`},
+		"relative hook": {"/hook", `{"model":"gpt-4o"}`},
+	} {
+		if rec := submitJob(t, "alice", tc.webhook, tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", name, rec.Code)
+		}
+	}
+}
+
+func TestGetChatJobOfOwnerOnly(t *testing.T) {
+	ctx := context.Background()
+	rec := submitJob(t, "alice", "", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	var job ChatJob
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	poll := func(userID string) (int, ChatJob) {
+		rec := serve(GetChatJob, "GET /v1/jobs/{id}", http.MethodGet, "/v1/jobs/"+job.ID, userID, "")
+		var got ChatJob
+		json.NewDecoder(rec.Body).Decode(&got)
+		return rec.Code, got
+	}
+	if code, got := poll("alice"); code != http.StatusOK || got.Status != JobStatusQueued || got.Result != nil {
+		t.Errorf("queued job: %d %+v", code, got)
+	}
+	for _, userID := range []string{"bob", ""} {
+		if code, _ := poll(userID); code != http.StatusNotFound {
+			t.Errorf("user %q: %d, want 404", userID, code)
+		}
+	}
+
+	finishChatJob(ctx, &job, http.StatusOK, []byte(`{"id":"chatcmpl-1","object":"chat.completion"}`), "")
+	code, got := poll("alice")
+	if code != http.StatusOK || got.Status != JobStatusCompleted || got.ResponseStatus != http.StatusOK || !strings.Contains(string(got.Result), "chatcmpl-1") {
+		t.Errorf("finished job: %d %+v", code, got)
+	}
+	if n, _ := rdb.Exists(ctx, chatJobRequestKey(job.ID)).Result(); n != 0 {
+		t.Error("request with the client's credentials kept after the job finished")
+	}
+	if code, _ := poll("bob"); code != http.StatusNotFound {
+		t.Errorf("finished job to another user: %d, want 404", code)
+	}
+}
+
+func TestChatJobRequeuedOnShutdown(t *testing.T) {
+	rec := submitJob(t, "alice", "", `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)
+	var job ChatJob
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	rdb.LRem(context.Background(), chatJobsQueue, 0, job.ID)
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	shutdown()
+	processChatJob(ctx, job.ID)
+
+	if next := rdb.LIndex(context.Background(), chatJobsQueue, -1).Val(); next != job.ID {
+		t.Errorf("next job in the queue %q, want %s", next, job.ID)
+	}
+	if current, err := loadChatJob(context.Background(), job.ID); err != nil || current.Status != JobStatusQueued {
+		t.Errorf("job after shutdown: %+v, %v", current, err)
+	}
+}
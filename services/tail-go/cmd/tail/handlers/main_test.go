@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// mr is the in-memory Redis the handlers run against in tests
+var mr *miniredis.Miniredis
+
+func TestMain(m *testing.M) {
+	var err error
+	mr, err = miniredis.Run()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "miniredis: %v\n", err)
+		os.Exit(1)
+	}
+	rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rdbAgentic = rdb
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
+
+// serve calls h like the mux does for a request of userID; pattern is the
+// route pattern, so path values such as {id} are set
+func serve(h http.HandlerFunc, pattern, method, path, userID, body string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, h)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if userID != "" {
+		req.Header.Set("X-User-ID", userID)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/openapi"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/scheduler"
+)
+
+// ChatCompletionResponse is the OpenAI chat completion the providers return
+// through /v1/chat/completions
+type ChatCompletionResponse struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []ChatChoice `json:"choices"`
+	Usage   *tokenUsage  `json:"usage,omitempty"`
+}
+
+// ChatChoice is a choice of a chat completion
+type ChatChoice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason,omitempty"`
+}
+
+// BatchSubmitResponse is the body of POST /v1/batch: the results of a sync
+// batch, or the queued batch in async mode
+type BatchSubmitResponse struct {
+	BatchResponse
+	Object string        `json:"object,omitempty"`
+	Data   []interface{} `json:"data,omitempty"`
+}
+
+// BatchList is the body of GET /v1/batches
+type BatchList struct {
+	Object  string   `json:"object"`
+	Data    []*Batch `json:"data"`
+	HasMore bool     `json:"has_more"`
+	FirstID string   `json:"first_id,omitempty"`
+	LastID  string   `json:"last_id,omitempty"`
+}
+
+// FileUpload is the multipart form of POST /v1/files
+type FileUpload struct {
+	File []byte `json:"file"`
+	// batch, batch_output, assistants or user_data
+	Purpose string `json:"purpose"`
+}
+
+// ProviderChange is the body of the provider management endpoints
+type ProviderChange struct {
+	Status string `json:"status"`
+}
+
+// OpenAPI describes the public API of tail, served at /openapi.json
+func OpenAPI() *openapi.Document {
+	providerQuery := []string{"provider"}
+	return openapi.New("LLM Gateway Tail API", "1.0.0",
+		"OpenAI-compatible chat completions, embeddings, batches and provider management.").
+		Add(
+			openapi.Route{Method: http.MethodPost, Path: "/v1/chat/completions", Tag: "chat",
+				Summary: "Create a chat completion", Request: OpenAIRequest{}, Response: ChatCompletionResponse{}, Stream: true},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/completions", Tag: "chat",
+				Summary: "Create a completion", Request: OpenAIRequest{}, Response: ChatCompletionResponse{}, Stream: true},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/jobs/{id}", Tag: "chat",
+				Summary: "Get an asynchronous chat completion job and its result", Response: ChatJob{}},
+
+			openapi.Route{Method: http.MethodPost, Path: "/v1/embeddings", Tag: "embeddings",
+				Summary: "Create embeddings", Request: EmbeddingsRequest{}, Response: EmbeddingResponse{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/embeddings/batches", Tag: "embeddings",
+				Summary: "Submit an asynchronous embeddings batch", Request: EmbeddingsRequest{}, Response: EmbeddingsBatch{}, Status: http.StatusAccepted},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/embeddings/batches/{id}", Tag: "embeddings",
+				Summary: "Get an embeddings batch", Response: EmbeddingsBatch{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/embeddings/batches/{id}/result", Tag: "embeddings",
+				Summary: "Get the embeddings of a completed batch", Response: EmbeddingResponse{}},
+
+			openapi.Route{Method: http.MethodPost, Path: "/v1/batch", Tag: "batch",
+				Summary: "Run a batch of chat completions, sync or async", Request: BatchRequest{}, Response: BatchSubmitResponse{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/batch/{id}", Tag: "batch",
+				Summary: "Get the progress of a batch", Response: BatchStatus{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/batch/{id}/results", Tag: "batch",
+				Summary: "Get the results of a batch as JSONL", Query: []string{"type", "offset", "limit"},
+				Response: BatchResultLine{}, ResponseType: "application/jsonl"},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/files", Tag: "batch",
+				Summary: "Upload a file for batches or retrieval", Request: FileUpload{}, RequestType: "multipart/form-data", Response: FileObject{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/files", Tag: "batch",
+				Summary: "List files, newest first", Query: []string{"purpose", "limit"}, Response: FileList{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/files/{id}", Tag: "batch",
+				Summary: "Get a file", Response: FileObject{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/files/{id}/content", Tag: "batch",
+				Summary: "Get the content of a file", Response: "", ResponseType: "application/octet-stream"},
+			openapi.Route{Method: http.MethodDelete, Path: "/v1/files/{id}", Tag: "batch",
+				Summary: "Delete a file", Response: FileDeleted{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/batches", Tag: "batch",
+				Summary: "Create a batch from an input file", Request: CreateBatchRequest{}, Response: Batch{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/batches", Tag: "batch",
+				Summary: "List batches, newest first", Query: []string{"limit"}, Response: BatchList{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/batches/{id}", Tag: "batch",
+				Summary: "Get a batch", Response: Batch{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/batches/{id}/cancel", Tag: "batch",
+				Summary: "Cancel a batch", Response: Batch{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/batches/scheduled", Tag: "batch",
+				Summary: "Schedule a batch once or on a cron", Request: ScheduleBatchRequest{}, Response: scheduler.Job{}, Status: http.StatusCreated},
+
+			openapi.Route{Method: http.MethodGet, Path: "/v1/providers", Tag: "providers",
+				Summary: "List providers", Response: map[string]internal.ProviderConfig{}},
+			openapi.Route{Method: http.MethodGet, Path: "/v1/providers/health", Tag: "providers",
+				Summary: "Get the health of providers", Response: map[string]internal.ProviderStatus{}},
+			openapi.Route{Method: http.MethodPost, Path: "/v1/providers", Tag: "providers",
+				Summary: "Add a provider", Query: providerQuery, Request: internal.ProviderConfig{}, Response: ProviderChange{}, Status: http.StatusCreated},
+			openapi.Route{Method: http.MethodDelete, Path: "/v1/providers", Tag: "providers",
+				Summary: "Remove a provider", Query: providerQuery, Response: ProviderChange{}},
+			openapi.Route{Method: http.MethodPut, Path: "/v1/providers/api-key", Tag: "providers",
+				Summary: "Update the API key of a provider", Query: providerQuery,
+				Request: struct {
+					APIKey string `json:"api_key"`
+				}{}, Response: ProviderChange{}},
+		)
+}
@@ -14,13 +14,15 @@ import (
 	"net/http"
 
 	"llm-gateway-pro/services/tail-go/cmd/tail/internal"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
 )
 
 // GetProviders returns the list of all configured providers
 func GetProviders(w http.ResponseWriter, r *http.Request) {
 	providers, err := internal.GetAllProviders()
 	if err != nil {
-		http.Error(w, `{"error":"failed to get providers"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to get providers")
 		return
 	}
 
@@ -32,7 +34,7 @@ func GetProviders(w http.ResponseWriter, r *http.Request) {
 func GetProviderHealth(w http.ResponseWriter, r *http.Request) {
 	health, err := internal.GetProviderHealth()
 	if err != nil {
-		http.Error(w, `{"error":"failed to get provider health"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to get provider health")
 		return
 	}
 
@@ -44,47 +46,47 @@ func GetProviderHealth(w http.ResponseWriter, r *http.Request) {
 func AddProvider(w http.ResponseWriter, r *http.Request) {
 	var config internal.ProviderConfig
 	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
-		http.Error(w, `{"error":"invalid provider configuration"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "invalid provider configuration")
 		return
 	}
 
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
-		http.Error(w, `{"error":"provider name required"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "provider name required")
 		return
 	}
 
 	if err := internal.AddProvider(provider, config); err != nil {
-		http.Error(w, `{"error":"failed to add provider"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to add provider")
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "provider added"})
+	json.NewEncoder(w).Encode(ProviderChange{Status: "provider added"})
 }
 
 // RemoveProvider removes a provider configuration
 func RemoveProvider(w http.ResponseWriter, r *http.Request) {
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
-		http.Error(w, `{"error":"provider name required"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "provider name required")
 		return
 	}
 
 	if err := internal.RemoveProvider(provider); err != nil {
-		http.Error(w, `{"error":"failed to remove provider"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to remove provider")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "provider removed"})
+	json.NewEncoder(w).Encode(ProviderChange{Status: "provider removed"})
 }
 
 // UpdateProviderAPIKey updates the API key for a provider
 func UpdateProviderAPIKey(w http.ResponseWriter, r *http.Request) {
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
-		http.Error(w, `{"error":"provider name required"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "provider name required")
 		return
 	}
 
@@ -92,17 +94,17 @@ func UpdateProviderAPIKey(w http.ResponseWriter, r *http.Request) {
 		APIKey string `json:"api_key"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request"}`, http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, "invalid request")
 		return
 	}
 
 	if err := internal.SetProviderAPIKey(provider, req.APIKey); err != nil {
-		http.Error(w, `{"error":"failed to update API key"}`, http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, "failed to update API key")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "API key updated"})
+	json.NewEncoder(w).Encode(ProviderChange{Status: "API key updated"})
 }
 
 
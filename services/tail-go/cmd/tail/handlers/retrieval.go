@@ -0,0 +1,515 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/retrieval"
+)
+
+// Векторное хранилище для RAG (QDRANT_URL, QDRANT_API_KEY)
+var vectorStore retrieval.Store = retrieval.NewQdrant(
+	envString("QDRANT_URL", "http://qdrant:6333"),
+	os.Getenv("QDRANT_API_KEY"),
+)
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+const (
+	defaultChunkSize    = 1000
+	defaultChunkOverlap = 200
+	defaultTopK         = 4
+	maxTopK             = 20
+)
+
+// Размерности векторов моделей эмбеддингов — нужны при создании коллекции
+var embeddingDimensions = map[string]int{
+	"text-embedding-3-large": 3072,
+	"text-embedding-3-small": 1536,
+	"voyage-2":               1024,
+	"embed-multilingual-v3":  1024,
+	"textembedding-gecko":    768,
+}
+
+var collectionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+var errCollectionNotFound = errors.New("collection not found")
+
+// Collection is a named set of embedded document chunks
+type Collection struct {
+	Name           string `json:"name"`
+	Object         string `json:"object"`
+	UserID         string `json:"user_id,omitempty"`
+	EmbeddingModel string `json:"embedding_model"`
+	Dimensions     int    `json:"dimensions"`
+	ChunkSize      int    `json:"chunk_size"`
+	ChunkOverlap   int    `json:"chunk_overlap"`
+	DocumentCount  int    `json:"document_count"`
+	CreatedAt      int64  `json:"created_at"`
+}
+
+// Document is a text added to a collection, given inline or as an uploaded
+// file
+type Document struct {
+	ID       string                 `json:"id,omitempty"`
+	Text     string                 `json:"text,omitempty"`
+	FileID   string                 `json:"file_id,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// RetrievedChunk is a search result
+type RetrievedChunk struct {
+	ID         string                 `json:"id"`
+	Score      float64                `json:"score"`
+	Text       string                 `json:"text"`
+	DocumentID string                 `json:"document_id"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// RetrievalOptions enables prompt augmentation in /v1/chat/completions
+type RetrievalOptions struct {
+	Collection string  `json:"collection"`
+	TopK       int     `json:"top_k,omitempty"`
+	MinScore   float64 `json:"min_score,omitempty"`
+}
+
+func collectionKey(name string) string          { return "rag:collection:" + name }
+func collectionDocumentsKey(name string) string { return "rag:collection:" + name + ":documents" }
+func userCollectionsKey(userID string) string   { return "rag:collections:user:" + userID }
+
+// vectorCollection is the collection name in the vector store
+func vectorCollection(name string) string { return "rag_" + name }
+
+func saveCollection(ctx context.Context, c *Collection) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, collectionKey(c.Name), raw, 0).Err()
+}
+
+// loadCollection returns the collection if it exists and belongs to userID
+func loadCollection(ctx context.Context, userID, name string) (*Collection, error) {
+	raw, err := rdb.Get(ctx, collectionKey(name)).Bytes()
+	if err == redis.Nil {
+		return nil, errCollectionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var c Collection
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	if c.UserID != userID {
+		return nil, errCollectionNotFound
+	}
+	return &c, nil
+}
+
+func writeRetrievalError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errCollectionNotFound) || errors.Is(err, retrieval.ErrCollectionNotFound) {
+		apierror.Write(w, http.StatusNotFound, "collection not found")
+		return
+	}
+	log.Printf("Retrieval error: %v", err)
+	apierror.Write(w, http.StatusBadGateway, "retrieval backend error")
+}
+
+// embedTexts embeds texts in EMBEDDINGS_CHUNK_SIZE requests
+func embedTexts(model string, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, 0, len(texts))
+	for start := 0; start < len(texts); start += embeddingsChunkSize {
+		end := start + embeddingsChunkSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		resp := requestEmbeddings(model, texts[start:end])
+		if resp == nil || len(resp.Data) != end-start {
+			return nil, fmt.Errorf("embedding provider error")
+		}
+		for _, d := range resp.Data {
+			vectors = append(vectors, d.Embedding)
+		}
+	}
+	return vectors, nil
+}
+
+// CreateCollection handles POST /v1/collections
+func CreateCollection(w http.ResponseWriter, r *http.Request) {
+	var req Collection
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if !collectionNamePattern.MatchString(req.Name) {
+		apierror.Write(w, http.StatusBadRequest, "name must be 1-64 letters, digits, '_' or '-'")
+		return
+	}
+	dimensions, ok := embeddingDimensions[req.EmbeddingModel]
+	if !ok {
+		apierror.Write(w, http.StatusBadRequest, "embedding model not supported")
+		return
+	}
+	if req.ChunkSize <= 0 {
+		req.ChunkSize = defaultChunkSize
+	}
+	if req.ChunkOverlap <= 0 {
+		req.ChunkOverlap = defaultChunkOverlap
+	}
+	if req.ChunkOverlap >= req.ChunkSize {
+		req.ChunkOverlap = req.ChunkSize / 5
+	}
+
+	c := &Collection{
+		Name:           req.Name,
+		Object:         "collection",
+		UserID:         r.Header.Get("X-User-ID"),
+		EmbeddingModel: req.EmbeddingModel,
+		Dimensions:     dimensions,
+		ChunkSize:      req.ChunkSize,
+		ChunkOverlap:   req.ChunkOverlap,
+		CreatedAt:      time.Now().Unix(),
+	}
+
+	// Имя резервируем до создания коллекции в хранилище
+	raw, _ := json.Marshal(c)
+	created, err := rdb.SetNX(r.Context(), collectionKey(c.Name), raw, 0).Result()
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+	if !created {
+		apierror.Write(w, http.StatusConflict, "collection already exists")
+		return
+	}
+
+	if err := vectorStore.CreateCollection(r.Context(), vectorCollection(c.Name), dimensions); err != nil {
+		rdb.Del(r.Context(), collectionKey(c.Name))
+		writeRetrievalError(w, err)
+		return
+	}
+	if c.UserID != "" {
+		rdb.SAdd(r.Context(), userCollectionsKey(c.UserID), c.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// ListCollections handles GET /v1/collections
+func ListCollections(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	names, err := rdb.SMembers(r.Context(), userCollectionsKey(userID)).Result()
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	data := make([]*Collection, 0, len(names))
+	for _, name := range names {
+		c, err := loadCollection(r.Context(), userID, name)
+		if errors.Is(err, errCollectionNotFound) {
+			continue
+		} else if err != nil {
+			writeRetrievalError(w, err)
+			return
+		}
+		data = append(data, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+}
+
+// GetCollection handles GET /v1/collections/{name}
+func GetCollection(w http.ResponseWriter, r *http.Request) {
+	c, err := loadCollection(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("name"))
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// DeleteCollection handles DELETE /v1/collections/{name}
+func DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	c, err := loadCollection(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("name"))
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	err = vectorStore.DeleteCollection(r.Context(), vectorCollection(c.Name))
+	if err != nil && !errors.Is(err, retrieval.ErrCollectionNotFound) {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(r.Context(), collectionKey(c.Name), collectionDocumentsKey(c.Name))
+	if c.UserID != "" {
+		pipe.SRem(r.Context(), userCollectionsKey(c.UserID), c.Name)
+	}
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": c.Name, "object": "collection.deleted", "deleted": true})
+}
+
+// AddDocuments handles POST /v1/collections/{name}/documents. Documents are
+// chunked, embedded with the collection model and upserted; re-adding a
+// document ID replaces its chunks.
+func AddDocuments(w http.ResponseWriter, r *http.Request) {
+	c, err := loadCollection(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("name"))
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	var req struct {
+		Documents []Document `json:"documents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if len(req.Documents) == 0 {
+		apierror.Write(w, http.StatusBadRequest, "documents are required")
+		return
+	}
+
+	chunksAdded := 0
+	for i := range req.Documents {
+		doc := &req.Documents[i]
+		if doc.Text == "" && doc.FileID != "" {
+			text, err := loadDocumentFile(r.Context(), r.Header.Get("X-User-ID"), doc.FileID)
+			if err != nil {
+				apierror.New(http.StatusBadRequest, err.Error()).WithParam(fmt.Sprintf("documents[%d].file_id", i)).Write(w)
+				return
+			}
+			doc.Text = text
+			if doc.ID == "" {
+				doc.ID = doc.FileID
+			}
+		}
+		if doc.ID == "" {
+			doc.ID = "doc_" + uuid.New().String()
+		}
+
+		chunks := retrieval.Chunk(doc.Text, c.ChunkSize, c.ChunkOverlap)
+		if len(chunks) == 0 {
+			continue
+		}
+		vectors, err := embedTexts(c.EmbeddingModel, chunks)
+		if err != nil {
+			apierror.Write(w, http.StatusBadGateway, "provider error")
+			return
+		}
+
+		points := make([]retrieval.Point, len(chunks))
+		for j, chunk := range chunks {
+			points[j] = retrieval.Point{
+				ID:     uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("%s/%s/%d", c.Name, doc.ID, j))).String(),
+				Vector: vectors[j],
+				Payload: map[string]interface{}{
+					"text":        chunk,
+					"document_id": doc.ID,
+					"chunk_index": j,
+					"file_id":     doc.FileID,
+					"metadata":    doc.Metadata,
+				},
+			}
+		}
+
+		if err := vectorStore.DeleteDocument(r.Context(), vectorCollection(c.Name), doc.ID); err != nil {
+			writeRetrievalError(w, err)
+			return
+		}
+		if err := vectorStore.Upsert(r.Context(), vectorCollection(c.Name), points); err != nil {
+			writeRetrievalError(w, err)
+			return
+		}
+		rdb.SAdd(r.Context(), collectionDocumentsKey(c.Name), doc.ID)
+		chunksAdded += len(chunks)
+	}
+
+	c.DocumentCount = int(rdb.SCard(r.Context(), collectionDocumentsKey(c.Name)).Val())
+	saveCollection(r.Context(), c)
+
+	ids := make([]string, len(req.Documents))
+	for i, doc := range req.Documents {
+		ids[i] = doc.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"collection": c.Name, "document_ids": ids, "chunks": chunksAdded})
+}
+
+// loadDocumentFile returns the text of an uploaded file of userID for ingestion
+func loadDocumentFile(ctx context.Context, userID, id string) (string, error) {
+	file, err := loadUserFile(ctx, userID, id)
+	var content []byte
+	if err == nil {
+		content, err = loadFileContent(ctx, id)
+	}
+	if err == redis.Nil {
+		return "", fmt.Errorf("file %s not found", id)
+	} else if err != nil {
+		log.Printf("Failed to load file %s: %v", id, err)
+		return "", fmt.Errorf("file %s could not be read", id)
+	}
+	if file.Purpose != "assistants" && file.Purpose != "user_data" {
+		return "", fmt.Errorf("file %s must have purpose assistants or user_data", id)
+	}
+	if !utf8.Valid(content) {
+		return "", fmt.Errorf("file %s is not UTF-8 text", id)
+	}
+	return string(content), nil
+}
+
+// DeleteDocument handles DELETE /v1/collections/{name}/documents/{id}
+func DeleteDocument(w http.ResponseWriter, r *http.Request) {
+	c, err := loadCollection(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("name"))
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := vectorStore.DeleteDocument(r.Context(), vectorCollection(c.Name), id); err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+	rdb.SRem(r.Context(), collectionDocumentsKey(c.Name), id)
+	c.DocumentCount = int(rdb.SCard(r.Context(), collectionDocumentsKey(c.Name)).Val())
+	saveCollection(r.Context(), c)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "object": "document.deleted", "deleted": true})
+}
+
+// retrieve embeds the query and returns the top-k chunks of the collection
+func retrieve(ctx context.Context, userID string, opts RetrievalOptions, query string) ([]RetrievedChunk, error) {
+	c, err := loadCollection(ctx, userID, opts.Collection)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TopK <= 0 {
+		opts.TopK = defaultTopK
+	}
+	if opts.TopK > maxTopK {
+		opts.TopK = maxTopK
+	}
+
+	vectors, err := embedTexts(c.EmbeddingModel, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	matches, err := vectorStore.Search(ctx, vectorCollection(c.Name), vectors[0], opts.TopK)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]RetrievedChunk, 0, len(matches))
+	for _, m := range matches {
+		if m.Score < opts.MinScore {
+			continue
+		}
+		chunk := RetrievedChunk{ID: m.ID, Score: m.Score}
+		chunk.Text, _ = m.Payload["text"].(string)
+		chunk.DocumentID, _ = m.Payload["document_id"].(string)
+		chunk.Metadata, _ = m.Payload["metadata"].(map[string]interface{})
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// Retrieve handles POST /v1/retrieve
+func Retrieve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RetrievalOptions
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		apierror.Write(w, http.StatusBadRequest, "query is required")
+		return
+	}
+
+	chunks, err := retrieve(r.Context(), r.Header.Get("X-User-ID"), req.RetrievalOptions, req.Query)
+	if err != nil {
+		writeRetrievalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": chunks})
+}
+
+// augmentWithRetrieval retrieves chunks for the last user message and adds
+// them as a system message before the conversation
+func augmentWithRetrieval(ctx context.Context, userID string, opts RetrievalOptions, messages []Message) ([]Message, error) {
+	query := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			query = messages[i].Content
+			break
+		}
+	}
+	if strings.TrimSpace(query) == "" {
+		return messages, nil
+	}
+
+	chunks, err := retrieve(ctx, userID, opts, query)
+	if err != nil || len(chunks) == 0 {
+		return messages, err
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString("Use the following context to answer when it is relevant. Cite sources by their number.\n")
+	for i, chunk := range chunks {
+		fmt.Fprintf(&prompt, "\n[%d] %s\n", i+1, chunk.Text)
+	}
+
+	// Контекст идёт после системных сообщений клиента
+	pos := 0
+	for pos < len(messages) && messages[pos].Role == "system" {
+		pos++
+	}
+	augmented := make([]Message, 0, len(messages)+1)
+	augmented = append(augmented, messages[:pos]...)
+	augmented = append(augmented, Message{Role: "system", Content: prompt.String()})
+	augmented = append(augmented, messages[pos:]...)
+	return augmented, nil
+}
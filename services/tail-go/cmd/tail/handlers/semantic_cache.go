@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/annotations"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/usageevents"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/retrieval"
+)
+
+// Семантический кэш ответов:
+// SEMANTIC_CACHE_EMBEDDING_MODEL — модель эмбеддингов промптов,
+// SEMANTIC_CACHE_THRESHOLD — минимальное косинусное сходство для попадания,
+// SEMANTIC_CACHE_TTL_HOURS — сколько хранится ответ.
+var (
+	semanticCacheModel     = envString("SEMANTIC_CACHE_EMBEDDING_MODEL", "text-embedding-3-small")
+	semanticCacheThreshold = loadSemanticCacheThreshold()
+	semanticCacheTTL       = time.Duration(envInt("SEMANTIC_CACHE_TTL_HOURS", 24)) * time.Hour
+)
+
+// SemanticCacheHeader marks a chat request whose response may be served from
+// and stored in the semantic cache
+const SemanticCacheHeader = "X-ZB-Semantic-Cache"
+
+// SemanticCacheSimilarityHeader carries the similarity of a cache hit
+const SemanticCacheSimilarityHeader = "X-ZB-Cache-Similarity"
+
+var (
+	semanticCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "semantic_cache_lookups_total",
+		Help: "Semantic cache lookups by result (hit, miss, error)",
+	}, []string{"result"})
+	semanticCacheSimilarity = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "semantic_cache_similarity",
+		Help:    "Similarity of the nearest cached prompt, by lookup result",
+		Buckets: []float64{0.5, 0.7, 0.8, 0.85, 0.9, 0.93, 0.95, 0.97, 0.98, 0.99, 1},
+	}, []string{"result"})
+	semanticCacheSavedCost = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "semantic_cache_saved_cost_usd_total",
+		Help: "Provider cost of the responses served from the semantic cache",
+	})
+	semanticCacheFeedback = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "semantic_cache_feedback_total",
+		Help: "Feedback on responses served from the semantic cache, by rating",
+	}, []string{"rating"})
+)
+
+func loadSemanticCacheThreshold() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("SEMANTIC_CACHE_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		return v
+	}
+	return 0.95
+}
+
+// SemanticCacheSettings is a tenant's opt-in to the semantic cache
+type SemanticCacheSettings struct {
+	Enabled bool `json:"enabled"`
+	// Threshold overrides SEMANTIC_CACHE_THRESHOLD for the tenant
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+func semanticCacheSettingsKey(userID string) string { return "semantic_cache:settings:" + userID }
+
+// semanticCacheCollection is the vector collection of the cached prompts
+func semanticCacheCollection() string { return "semantic_cache_" + semanticCacheModel }
+
+func loadSemanticCacheSettings(ctx context.Context, userID string) (SemanticCacheSettings, error) {
+	var settings SemanticCacheSettings
+	raw, err := rdb.Get(ctx, semanticCacheSettingsKey(userID)).Bytes()
+	if err == redis.Nil {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	err = json.Unmarshal(raw, &settings)
+	return settings, err
+}
+
+// semanticLookup is a cache miss whose response can be stored under the
+// prompt embedding computed for the lookup and the hash of its parameters
+type semanticLookup struct {
+	vector []float64
+	params string
+}
+
+// semanticHit is a cached response close enough to the request
+type semanticHit struct {
+	body       []byte
+	provider   string
+	costUSD    float64
+	similarity float64
+}
+
+// semanticCacheable reports whether the request may use the semantic cache.
+// Streams, several choices, conversations and experiments are not cached:
+// their responses are not a function of the prompt alone.
+func semanticCacheable(r *http.Request, userID string, req OpenAIRequest, conv *Conversation, assignment *experimentAssignment) bool {
+	if userID == "" || !strings.EqualFold(r.Header.Get(SemanticCacheHeader), "true") {
+		return false
+	}
+	return !req.Stream && req.N <= 1 && conv == nil && assignment == nil
+}
+
+// semanticCachePromptFields are the request fields already in the prompt,
+// the cache key or semanticCacheable, or only read by the tail
+var semanticCachePromptFields = []string{
+	"messages", "model", "stream", "n", "user",
+	"conversation_id", "retrieval", "template_id", "template_version", "variables", "experiment_id",
+}
+
+// semanticCacheParams hashes the request fields besides the prompt that
+// shape the response — tools, response_format, max_tokens, temperature and
+// any other — so a cached response is only reused for the same ones. Key
+// order and formatting of body do not matter.
+func semanticCacheParams(body []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", err
+	}
+	for _, name := range semanticCachePromptFields {
+		delete(fields, name)
+	}
+	// json.Marshal sorts the keys; values are compacted so whitespace is ignored
+	for name, value := range fields {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, value); err != nil {
+			return "", err
+		}
+		fields[name] = compact.Bytes()
+	}
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// semanticCachePrompt is the text embedded for a request
+func semanticCachePrompt(messages []Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		b.WriteString(m.Role)
+		b.WriteString(": ")
+		b.WriteString(m.Content)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// lookupSemanticCache ищет ответ на похожий промпт того же клиента к той же
+// модели с теми же параметрами (body — тело, уходящее провайдеру). Без hit
+// возвращает lookup, под которым сохраняется ответ провайдера; nil, если
+// клиент не включил кэш или кэш недоступен.
+func lookupSemanticCache(ctx context.Context, userID string, req OpenAIRequest, body []byte) (*semanticHit, *semanticLookup) {
+	settings, err := loadSemanticCacheSettings(ctx, userID)
+	if err != nil {
+		log.Printf("Semantic cache settings of %s: %v", userID, err)
+		return nil, nil
+	}
+	if !settings.Enabled {
+		return nil, nil
+	}
+	threshold := semanticCacheThreshold
+	if settings.Threshold > 0 {
+		threshold = settings.Threshold
+	}
+
+	params, err := semanticCacheParams(body)
+	if err != nil {
+		semanticCacheLookups.WithLabelValues("error").Inc()
+		return nil, nil
+	}
+	vectors, err := embedTexts(semanticCacheModel, []string{semanticCachePrompt(req.Messages)})
+	if err != nil {
+		log.Printf("Semantic cache embedding failed: %v", err)
+		semanticCacheLookups.WithLabelValues("error").Inc()
+		return nil, nil
+	}
+	lookup := &semanticLookup{vector: vectors[0], params: params}
+
+	matches, err := vectorStore.SearchWhere(ctx, semanticCacheCollection(), lookup.vector, 1, retrieval.Filter{
+		Equals: map[string]string{"user_id": userID, "model": req.Model, "params": params},
+		After:  map[string]float64{"expires_at": float64(time.Now().Unix())},
+	})
+	if errors.Is(err, retrieval.ErrCollectionNotFound) {
+		// Ещё ничего не сохранено — коллекция создаётся при первой записи
+		semanticCacheLookups.WithLabelValues("miss").Inc()
+		return nil, lookup
+	} else if err != nil {
+		log.Printf("Semantic cache search failed: %v", err)
+		semanticCacheLookups.WithLabelValues("error").Inc()
+		return nil, nil
+	}
+	if len(matches) == 0 {
+		semanticCacheLookups.WithLabelValues("miss").Inc()
+		return nil, lookup
+	}
+
+	best := matches[0]
+	response, _ := best.Payload["response"].(string)
+	if best.Score < threshold || response == "" {
+		semanticCacheLookups.WithLabelValues("miss").Inc()
+		semanticCacheSimilarity.WithLabelValues("miss").Observe(best.Score)
+		return nil, lookup
+	}
+
+	semanticCacheLookups.WithLabelValues("hit").Inc()
+	semanticCacheSimilarity.WithLabelValues("hit").Observe(best.Score)
+	hit := &semanticHit{body: []byte(response), similarity: best.Score}
+	hit.provider, _ = best.Payload["provider"].(string)
+	hit.costUSD, _ = best.Payload["cost_usd"].(float64)
+	return hit, nil
+}
+
+// serveSemanticCacheHit отдаёт сохранённый ответ под новым id, чтобы отзыв
+// на него учитывался как оценка попадания в кэш
+func serveSemanticCacheHit(w http.ResponseWriter, r *http.Request, userID string, req OpenAIRequest, tmplUse *templateUse, hit *semanticHit, start time.Time) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(hit.body, &fields); err != nil {
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	id := "chatcmpl-" + uuid.New().String()
+	fields["id"], _ = json.Marshal(id)
+	fields["created"], _ = json.Marshal(time.Now().Unix())
+	body, _ := json.Marshal(fields)
+
+	semanticCacheSavedCost.Add(hit.costUSD)
+	annotation := annotations.Annotation{
+		Provider: hit.provider,
+		Latency:  time.Since(start),
+		Cache:    annotations.CacheHit,
+	}
+	annotation.SetHeaders(w.Header())
+
+	record := newCompletionRecord(r, userID, hit.provider, req, tmplUse, nil)
+	record.ID = id
+	record.CacheSimilarity = hit.similarity
+	record.LatencyMs = annotation.Latency.Milliseconds()
+	rememberCompletion(record)
+	emitUsage(r, record, usageevents.StatusSuccess, annotations.CacheHit)
+
+	if annotations.WantsBody(r) {
+		body = annotations.AddToBody(body, annotation)
+	}
+	w.Header().Set("X-Completion-ID", id)
+	w.Header().Set(SemanticCacheSimilarityHeader, strconv.FormatFloat(hit.similarity, 'f', 4, 64))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// storeSemanticCache сохраняет ответ провайдера под эмбеддингом промпта.
+// Раз в час одна из реплик удаляет истёкшие ответы.
+func storeSemanticCache(lookup *semanticLookup, userID, provider, model string, body []byte, costUSD float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	point := retrieval.Point{
+		ID:     uuid.New().String(),
+		Vector: lookup.vector,
+		Payload: map[string]interface{}{
+			"user_id":    userID,
+			"model":      model,
+			"params":     lookup.params,
+			"provider":   provider,
+			"response":   string(body),
+			"cost_usd":   costUSD,
+			"created_at": now.Unix(),
+			"expires_at": now.Add(semanticCacheTTL).Unix(),
+		},
+	}
+
+	collection := semanticCacheCollection()
+	err := vectorStore.Upsert(ctx, collection, []retrieval.Point{point})
+	if errors.Is(err, retrieval.ErrCollectionNotFound) {
+		if err = vectorStore.CreateCollection(ctx, collection, len(lookup.vector)); err == nil {
+			err = vectorStore.Upsert(ctx, collection, []retrieval.Point{point})
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to store semantic cache entry: %v", err)
+		return
+	}
+
+	if first, err := rdb.SetNX(ctx, "semantic_cache:sweep", 1, time.Hour).Result(); err == nil && first {
+		if err := vectorStore.DeleteWhere(ctx, collection, retrieval.Filter{
+			Before: map[string]float64{"expires_at": float64(now.Unix())},
+		}); err != nil {
+			log.Printf("Failed to remove expired semantic cache entries: %v", err)
+		}
+	}
+}
+
+// GetSemanticCacheSettings handles GET /v1/semantic-cache
+func GetSemanticCacheSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	settings, err := loadSemanticCacheSettings(r.Context(), userID)
+	if err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// UpdateSemanticCacheSettings handles PUT /v1/semantic-cache
+func UpdateSemanticCacheSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	var settings SemanticCacheSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if settings.Threshold < 0 || settings.Threshold > 1 {
+		apierror.New(http.StatusBadRequest, "threshold must be between 0 and 1").WithParam("threshold").Write(w)
+		return
+	}
+
+	raw, _ := json.Marshal(settings)
+	if err := rdb.Set(r.Context(), semanticCacheSettingsKey(userID), raw, 0).Err(); err != nil {
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	writeJSON(w, http.StatusOK, settings)
+}
+
+// ClearSemanticCache handles DELETE /v1/semantic-cache/entries and removes
+// every cached response of the tenant
+func ClearSemanticCache(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	err := vectorStore.DeleteWhere(r.Context(), semanticCacheCollection(), retrieval.Filter{
+		Equals: map[string]string{"user_id": userID},
+	})
+	if err != nil && !errors.Is(err, retrieval.ErrCollectionNotFound) {
+		log.Printf("Semantic cache error: %v", err)
+		apierror.Write(w, http.StatusBadGateway, "vector store error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"llm-gateway-pro/services/gateway/internal/secrets"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/retrieval"
+)
+
+// memoryStore is a vector store for one collection that finds points by
+// their payload; every point matches with score 1
+type memoryStore struct {
+	retrieval.Store
+	points []retrieval.Point
+}
+
+func (s *memoryStore) Upsert(_ context.Context, _ string, points []retrieval.Point) error {
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func (s *memoryStore) SearchWhere(_ context.Context, _ string, _ []float64, limit int, filter retrieval.Filter) ([]retrieval.Match, error) {
+	var matches []retrieval.Match
+	for _, p := range s.points {
+		matched := true
+		for key, value := range filter.Equals {
+			matched = matched && p.Payload[key] == value
+		}
+		if matched && len(matches) < limit {
+			matches = append(matches, retrieval.Match{ID: p.ID, Score: 1, Payload: p.Payload})
+		}
+	}
+	return matches, nil
+}
+
+func (s *memoryStore) DeleteWhere(context.Context, string, retrieval.Filter) error { return nil }
+
+// fakeSemanticCache enables the semantic cache of userID over a memoryStore,
+// with prompts embedded by a fake provider
+func fakeSemanticCache(t *testing.T, userID string) *memoryStore {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"object":"list","data":[{"index":0,"object":"embedding","embedding":[0.6,0.8]}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	prev := embeddingProviders[semanticCacheModel]
+	embeddingProviders[semanticCacheModel] = struct {
+		Provider string
+		BaseURL  string
+	}{"openai", srv.URL}
+	t.Cleanup(func() { embeddingProviders[semanticCacheModel] = prev })
+	secrets.Set("llm/openai/api_key", "test-key")
+
+	store := &memoryStore{}
+	prevStore := vectorStore
+	vectorStore = store
+	t.Cleanup(func() { vectorStore = prevStore })
+
+	raw, _ := json.Marshal(SemanticCacheSettings{Enabled: true})
+	rdb.Set(context.Background(), semanticCacheSettingsKey(userID), raw, 0)
+	return store
+}
+
+func TestSemanticCacheParams(t *testing.T) {
+	base := `{"model":"gpt-4o","messages":[{"role":"user","content":"What is the capital of France?"}],"temperature":0.2}`
+	key := func(body string) string {
+		t.Helper()
+		params, err := semanticCacheParams([]byte(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return params
+	}
+
+	same := map[string]string{
+		"other prompt":         `{"model":"gpt-4o","messages":[{"role":"user","content":"Capital of France?"}],"temperature":0.2}`,
+		"key order and spaces": `{ "temperature": 0.2, "messages": [], "model": "gpt-4o" }`,
+		"tail-only fields":     `{"model":"gpt-4o","messages":[],"temperature":0.2,"n":1,"stream":false,"conversation_id":"conv_1","template_id":"tmpl_1","variables":{"city":"Paris"}}`,
+	}
+	for name, body := range same {
+		if key(body) != key(base) {
+			t.Errorf("%s: different parameters", name)
+		}
+	}
+
+	different := map[string]string{
+		"temperature":     `{"model":"gpt-4o","messages":[],"temperature":0.9}`,
+		"max_tokens":      `{"model":"gpt-4o","messages":[],"temperature":0.2,"max_tokens":10}`,
+		"tools":           `{"model":"gpt-4o","messages":[],"temperature":0.2,"tools":[{"type":"function","function":{"name":"get_weather"}}]}`,
+		"response_format": `{"model":"gpt-4o","messages":[],"temperature":0.2,"response_format":{"type":"json_object"}}`,
+	}
+	for name, body := range different {
+		if key(body) == key(base) {
+			t.Errorf("%s: same parameters as the base request", name)
+		}
+	}
+
+	if _, err := semanticCacheParams([]byte(`[1,2]`)); err == nil {
+		t.Error("parameters of a body that is not an object")
+	}
+}
+
+func TestSemanticCacheReusesResponsesForTheSameParameters(t *testing.T) {
+	store := fakeSemanticCache(t, "alice")
+	ctx := context.Background()
+	req := OpenAIRequest{Model: "gpt-4o", Messages: []Message{{Role: "user", Content: "What is the weather in Paris?"}}}
+	plain := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"What is the weather in Paris?"}],"max_tokens":100}`)
+
+	hit, lookup := lookupSemanticCache(ctx, "alice", req, plain)
+	if hit != nil || lookup == nil {
+		t.Fatalf("empty cache: hit %v, lookup %v", hit, lookup)
+	}
+	storeSemanticCache(lookup, "alice", "openai", req.Model, []byte(`{"id":"chatcmpl-1","object":"chat.completion"}`), 0.001)
+
+	reordered := []byte(`{"max_tokens":100,"messages":[{"role":"user","content":"What is the weather in Paris?"}],"model":"gpt-4o"}`)
+	if hit, _ := lookupSemanticCache(ctx, "alice", req, reordered); hit == nil {
+		t.Error("same parameters: miss")
+	}
+
+	for name, body := range map[string]string{
+		"tools":           `{"model":"gpt-4o","messages":[],"max_tokens":100,"tools":[{"type":"function","function":{"name":"get_weather"}}]}`,
+		"response_format": `{"model":"gpt-4o","messages":[],"max_tokens":100,"response_format":{"type":"json_object"}}`,
+		"max_tokens":      `{"model":"gpt-4o","messages":[],"max_tokens":5}`,
+		"temperature":     `{"model":"gpt-4o","messages":[],"max_tokens":100,"temperature":1.5}`,
+	} {
+		if hit, lookup := lookupSemanticCache(ctx, "alice", req, []byte(body)); hit != nil || lookup == nil {
+			t.Errorf("%s: hit %v, lookup %v; want a miss", name, hit, lookup)
+		}
+	}
+
+	if !slices.ContainsFunc(store.points, func(p retrieval.Point) bool { return p.Payload["params"] == lookup.params }) {
+		t.Errorf("stored points %v lack the parameters", store.points)
+	}
+}
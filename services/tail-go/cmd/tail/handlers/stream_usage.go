@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/MaksimVF/ZB/pkg/tokenizer"
+)
+
+// countBatch is how much streamed text is held before its tokens are counted
+const countBatch = 1024
+
+// streamUsage counts the tokens of a streamed chat completion as its chunks
+// pass through, without keeping the streamed text. The usage the provider
+// reports in its final chunk (stream_options.include_usage) wins over the
+// tokenizer's count of the deltas.
+type streamUsage struct {
+	model string
+	// ID is the completion ID of the chunks
+	ID string
+
+	completion int
+	// pending is text not counted yet; it is counted up to a word boundary
+	// so no word is split between two counts
+	pending  strings.Builder
+	reported *tokenUsage
+
+	// first keeps the text of choice 0, for conversations only
+	first *strings.Builder
+}
+
+// newStreamUsage creates the counter of a stream; keepFirst keeps the text
+// of the first choice for FirstChoice
+func newStreamUsage(model string, keepFirst bool) *streamUsage {
+	u := &streamUsage{model: model}
+	if keepFirst {
+		u.first = &strings.Builder{}
+	}
+	return u
+}
+
+// Observe counts an SSE "data: {...}" line
+func (u *streamUsage) Observe(line string) {
+	var chunk struct {
+		ID      string `json:"id"`
+		Choices []struct {
+			Index int `json:"index"`
+			Delta struct {
+				Content string `json:"content"`
+			} `json:"delta"`
+		} `json:"choices"`
+		Usage *tokenUsage `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+		return
+	}
+	if u.ID == "" {
+		u.ID = chunk.ID
+	}
+	if chunk.Usage != nil && chunk.Usage.TotalTokens > 0 {
+		u.reported = chunk.Usage
+	}
+	for _, c := range chunk.Choices {
+		u.pending.WriteString(c.Delta.Content)
+		if c.Index == 0 && u.first != nil {
+			u.first.WriteString(c.Delta.Content)
+		}
+	}
+	if u.pending.Len() >= countBatch {
+		u.count(false)
+	}
+}
+
+// count counts the pending text, all of it or up to its last space
+func (u *streamUsage) count(all bool) {
+	text := u.pending.String()
+	cut := len(text)
+	if !all {
+		if i := strings.LastIndexAny(text, " \n\t"); i > 0 {
+			cut = i
+		}
+	}
+	u.completion += tokenizer.Count(u.model, text[:cut])
+	u.pending.Reset()
+	u.pending.WriteString(text[cut:])
+}
+
+// Tokens returns the prompt and completion tokens of the stream so far
+func (u *streamUsage) Tokens(req OpenAIRequest) (prompt, completion int) {
+	if u.reported != nil {
+		return u.reported.PromptTokens, u.reported.TotalTokens - u.reported.PromptTokens
+	}
+	u.count(true)
+	return promptTokens(req), u.completion
+}
+
+// FirstChoice returns the text of choice 0, empty unless kept
+func (u *streamUsage) FirstChoice() string {
+	if u.first == nil {
+		return ""
+	}
+	return u.first.String()
+}
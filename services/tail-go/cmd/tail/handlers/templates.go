@@ -0,0 +1,691 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	errTemplateNotFound        = errors.New("prompt template not found")
+	errTemplateVersionNotFound = errors.New("prompt template version not found")
+	errTemplateExists          = errors.New("prompt template with this name already exists")
+	errTemplateVariables       = errors.New("invalid template variables")
+)
+
+var templateNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// Переменные в тексте шаблона: {{name}} или {{ name }}
+var templateVariablePattern = regexp.MustCompile(`\{\{\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\}\}`)
+
+// PromptTemplate is a named prompt of a user; its versions are stored separately
+// and never change once created
+type PromptTemplate struct {
+	ID            string `json:"id"`
+	Object        string `json:"object"`
+	UserID        string `json:"user_id,omitempty"`
+	Name          string `json:"name"`
+	Description   string `json:"description,omitempty"`
+	LatestVersion int    `json:"latest_version"`
+	CreatedAt     int64  `json:"created_at"`
+	UpdatedAt     int64  `json:"updated_at"`
+	// PinnedVersion is the version pinned for the API key of the request
+	PinnedVersion int              `json:"pinned_version,omitempty"`
+	Version       *TemplateVersion `json:"version,omitempty"`
+}
+
+// TemplateVersion is the content of one template version. Variables are the
+// {{name}} placeholders of its messages; those without a default are required.
+type TemplateVersion struct {
+	Version   int               `json:"version"`
+	Messages  []Message         `json:"messages"`
+	Variables []string          `json:"variables"`
+	Defaults  map[string]string `json:"defaults,omitempty"`
+	CreatedAt int64             `json:"created_at"`
+}
+
+// TemplateUsage is how often a template was used in chat completions
+type TemplateUsage struct {
+	Requests         int64 `json:"requests"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// templateUse is the template version a chat completion was rendered from
+type templateUse struct {
+	TemplateID string
+	Version    int
+}
+
+func promptTemplateKey(id string) string         { return "prompt_template:" + id }
+func promptTemplateVersionsKey(id string) string { return "prompt_template:" + id + ":versions" }
+func promptTemplateSeqKey(id string) string      { return "prompt_template:" + id + ":seq" }
+func promptTemplatePinsKey(id string) string     { return "prompt_template:" + id + ":pins" }
+func promptTemplateUsageKey(id string) string    { return "prompt_template:" + id + ":usage" }
+func userPromptTemplatesKey(userID string) string {
+	return "prompt_templates:user:" + userID
+}
+
+// apiKeyScope identifies the API key of a request for pins without storing it
+func apiKeyScope(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(auth))
+	return "auth:" + hex.EncodeToString(hash[:16])
+}
+
+// templateVariables returns the sorted variable names used in messages
+func templateVariables(messages []Message) []string {
+	seen := make(map[string]bool)
+	variables := []string{}
+	for _, m := range messages {
+		for _, match := range templateVariablePattern.FindAllStringSubmatch(m.Content, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				variables = append(variables, match[1])
+			}
+		}
+	}
+	sort.Strings(variables)
+	return variables
+}
+
+// render substitutes the variables into the messages. Missing required and
+// unknown variables are errors, so typos do not reach the provider.
+func (v *TemplateVersion) render(variables map[string]string) ([]Message, error) {
+	known := make(map[string]bool, len(v.Variables))
+	values := make(map[string]string, len(v.Variables))
+	for _, name := range v.Variables {
+		known[name] = true
+		value, ok := variables[name]
+		if !ok {
+			value, ok = v.Defaults[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: missing variable %q", errTemplateVariables, name)
+		}
+		values[name] = value
+	}
+	for name := range variables {
+		if !known[name] {
+			return nil, fmt.Errorf("%w: unknown variable %q", errTemplateVariables, name)
+		}
+	}
+
+	rendered := make([]Message, len(v.Messages))
+	for i, m := range v.Messages {
+		rendered[i] = Message{
+			Role: m.Role,
+			Content: templateVariablePattern.ReplaceAllStringFunc(m.Content, func(placeholder string) string {
+				return values[templateVariablePattern.FindStringSubmatch(placeholder)[1]]
+			}),
+		}
+	}
+	return rendered, nil
+}
+
+// newTemplateVersion validates the content of a version
+func newTemplateVersion(messages []Message, defaults map[string]string) (*TemplateVersion, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("messages are required")
+	}
+	if err := validateConversationMessages(messages); err != nil {
+		return nil, err
+	}
+	version := &TemplateVersion{
+		Messages:  messages,
+		Variables: templateVariables(messages),
+		Defaults:  defaults,
+		CreatedAt: time.Now().Unix(),
+	}
+	known := make(map[string]bool, len(version.Variables))
+	for _, name := range version.Variables {
+		known[name] = true
+	}
+	for name := range defaults {
+		if !known[name] {
+			return nil, fmt.Errorf("default for unknown variable %q", name)
+		}
+	}
+	return version, nil
+}
+
+func savePromptTemplate(ctx context.Context, tmpl *PromptTemplate) error {
+	meta := *tmpl
+	meta.Version = nil
+	meta.PinnedVersion = 0
+	raw, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, promptTemplateKey(tmpl.ID), raw, 0).Err()
+}
+
+// loadPromptTemplate returns the template if it exists and belongs to userID
+func loadPromptTemplate(ctx context.Context, userID, id string) (*PromptTemplate, error) {
+	raw, err := rdb.Get(ctx, promptTemplateKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, errTemplateNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var tmpl PromptTemplate
+	if err := json.Unmarshal(raw, &tmpl); err != nil {
+		return nil, err
+	}
+	if tmpl.UserID != userID {
+		return nil, errTemplateNotFound
+	}
+	return &tmpl, nil
+}
+
+func loadTemplateVersion(ctx context.Context, id string, version int) (*TemplateVersion, error) {
+	raw, err := rdb.HGet(ctx, promptTemplateVersionsKey(id), strconv.Itoa(version)).Bytes()
+	if err == redis.Nil {
+		return nil, errTemplateVersionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var v TemplateVersion
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// addTemplateVersion stores content as the next version of the template
+func addTemplateVersion(ctx context.Context, tmpl *PromptTemplate, version *TemplateVersion) error {
+	number, err := rdb.Incr(ctx, promptTemplateSeqKey(tmpl.ID)).Result()
+	if err != nil {
+		return err
+	}
+	version.Version = int(number)
+	raw, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	if err := rdb.HSet(ctx, promptTemplateVersionsKey(tmpl.ID), strconv.Itoa(version.Version), raw).Err(); err != nil {
+		return err
+	}
+
+	if version.Version > tmpl.LatestVersion {
+		tmpl.LatestVersion = version.Version
+	}
+	tmpl.UpdatedAt = time.Now().Unix()
+	return savePromptTemplate(ctx, tmpl)
+}
+
+// pinnedTemplateVersion returns the version pinned for the API key, 0 if none
+func pinnedTemplateVersion(ctx context.Context, id, scope string) (int, error) {
+	if scope == "" {
+		return 0, nil
+	}
+	version, err := rdb.HGet(ctx, promptTemplatePinsKey(id), scope).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// resolveTemplateVersion picks the requested version, else the one pinned for
+// the API key, else the latest
+func resolveTemplateVersion(ctx context.Context, tmpl *PromptTemplate, requested int, scope string) (*TemplateVersion, error) {
+	version := requested
+	if version == 0 {
+		pinned, err := pinnedTemplateVersion(ctx, tmpl.ID, scope)
+		if err != nil {
+			return nil, err
+		}
+		version = pinned
+	}
+	if version == 0 {
+		version = tmpl.LatestVersion
+	}
+	return loadTemplateVersion(ctx, tmpl.ID, version)
+}
+
+// renderRequestTemplate renders the template of a chat completion request
+func renderRequestTemplate(r *http.Request, userID string, req OpenAIRequest) (*templateUse, []Message, error) {
+	tmpl, err := loadPromptTemplate(r.Context(), userID, req.TemplateID)
+	if err != nil {
+		return nil, nil, err
+	}
+	version, err := resolveTemplateVersion(r.Context(), tmpl, req.TemplateVersion, apiKeyScope(r))
+	if err != nil {
+		return nil, nil, err
+	}
+	messages, err := version.render(req.Variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &templateUse{TemplateID: tmpl.ID, Version: version.Version}, messages, nil
+}
+
+// recordTemplateUsage counts a completion rendered from a template, in total
+// and per version
+func recordTemplateUsage(use *templateUse, prompt, completion int) {
+	if use == nil {
+		return
+	}
+	ctx := context.Background()
+	key := promptTemplateUsageKey(use.TemplateID)
+	prefix := "v" + strconv.Itoa(use.Version) + ":"
+
+	pipe := rdb.Pipeline()
+	for _, p := range []string{"", prefix} {
+		pipe.HIncrBy(ctx, key, p+"requests", 1)
+		pipe.HIncrBy(ctx, key, p+"prompt_tokens", int64(prompt))
+		pipe.HIncrBy(ctx, key, p+"completion_tokens", int64(completion))
+	}
+	pipe.HSet(ctx, key, "last_used_at", time.Now().Unix())
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record usage of template %s: %v", use.TemplateID, err)
+	}
+}
+
+func writeTemplateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTemplateNotFound):
+		apierror.New(http.StatusNotFound, "prompt template not found").WithParam("template_id").Write(w)
+	case errors.Is(err, errTemplateVersionNotFound):
+		apierror.New(http.StatusNotFound, "prompt template version not found").WithParam("template_version").Write(w)
+	case errors.Is(err, errTemplateVariables):
+		apierror.New(http.StatusBadRequest, err.Error()).WithParam("variables").WithCode("invalid_template_variables").Write(w)
+	case errors.Is(err, errTemplateExists):
+		apierror.New(http.StatusConflict, err.Error()).WithParam("name").Write(w)
+	default:
+		log.Printf("Redis error: %v", err)
+		apierror.Write(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// CreatePromptTemplate handles POST /v1/prompt-templates and stores version 1
+func CreatePromptTemplate(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	var req struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Messages    []Message         `json:"messages"`
+		Defaults    map[string]string `json:"defaults"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if !templateNamePattern.MatchString(req.Name) {
+		apierror.New(http.StatusBadRequest, "name must be 1-64 letters, digits, '_', '.' or '-'").WithParam("name").Write(w)
+		return
+	}
+	version, err := newTemplateVersion(req.Messages, req.Defaults)
+	if err != nil {
+		apierror.New(http.StatusBadRequest, err.Error()).WithParam("messages").Write(w)
+		return
+	}
+
+	now := time.Now().Unix()
+	tmpl := &PromptTemplate{
+		ID:          "tmpl_" + uuid.New().String(),
+		Object:      "prompt_template",
+		UserID:      userID,
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	// Имя уникально в пределах пользователя
+	ok, err := rdb.HSetNX(r.Context(), userPromptTemplatesKey(userID), tmpl.Name, tmpl.ID).Result()
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	if !ok {
+		writeTemplateError(w, errTemplateExists)
+		return
+	}
+	if err := addTemplateVersion(r.Context(), tmpl, version); err != nil {
+		rdb.HDel(r.Context(), userPromptTemplatesKey(userID), tmpl.Name)
+		writeTemplateError(w, err)
+		return
+	}
+
+	tmpl.Version = version
+	writeJSON(w, http.StatusCreated, tmpl)
+}
+
+// ListPromptTemplates handles GET /v1/prompt-templates, sorted by name
+func ListPromptTemplates(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		apierror.Write(w, http.StatusBadRequest, "X-User-ID header is required")
+		return
+	}
+
+	ids, err := rdb.HGetAll(r.Context(), userPromptTemplatesKey(userID)).Result()
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	data := make([]*PromptTemplate, 0, len(ids))
+	for _, id := range ids {
+		tmpl, err := loadPromptTemplate(r.Context(), userID, id)
+		if errors.Is(err, errTemplateNotFound) {
+			continue
+		} else if err != nil {
+			writeTemplateError(w, err)
+			return
+		}
+		data = append(data, tmpl)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Name < data[j].Name })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// GetPromptTemplate handles GET /v1/prompt-templates/{id}. It returns the
+// version chat completions of this API key would use, or ?version=N.
+func GetPromptTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	requested := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		if requested, err = strconv.Atoi(v); err != nil || requested < 1 {
+			apierror.New(http.StatusBadRequest, "version must be a positive integer").WithParam("version").Write(w)
+			return
+		}
+	}
+	scope := apiKeyScope(r)
+	if tmpl.PinnedVersion, err = pinnedTemplateVersion(r.Context(), tmpl.ID, scope); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	if tmpl.Version, err = resolveTemplateVersion(r.Context(), tmpl, requested, scope); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// UpdatePromptTemplate handles PATCH /v1/prompt-templates/{id}; content
+// changes are new versions
+func UpdatePromptTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	var req struct {
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Description != nil {
+		tmpl.Description = *req.Description
+	}
+	tmpl.UpdatedAt = time.Now().Unix()
+	if err := savePromptTemplate(r.Context(), tmpl); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// DeletePromptTemplate handles DELETE /v1/prompt-templates/{id} with all its
+// versions, pins and usage
+func DeletePromptTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	pipe := rdb.TxPipeline()
+	pipe.Del(r.Context(), promptTemplateKey(tmpl.ID), promptTemplateVersionsKey(tmpl.ID), promptTemplateSeqKey(tmpl.ID),
+		promptTemplatePinsKey(tmpl.ID), promptTemplateUsageKey(tmpl.ID))
+	pipe.HDel(r.Context(), userPromptTemplatesKey(tmpl.UserID), tmpl.Name)
+	if _, err := pipe.Exec(r.Context()); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": tmpl.ID, "object": "prompt_template.deleted", "deleted": true})
+}
+
+// CreateTemplateVersion handles POST /v1/prompt-templates/{id}/versions
+func CreateTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	var req struct {
+		Messages []Message         `json:"messages"`
+		Defaults map[string]string `json:"defaults"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	version, err := newTemplateVersion(req.Messages, req.Defaults)
+	if err != nil {
+		apierror.New(http.StatusBadRequest, err.Error()).WithParam("messages").Write(w)
+		return
+	}
+	if err := addTemplateVersion(r.Context(), tmpl, version); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, version)
+}
+
+// ListTemplateVersions handles GET /v1/prompt-templates/{id}/versions, newest first
+func ListTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	entries, err := rdb.HGetAll(r.Context(), promptTemplateVersionsKey(tmpl.ID)).Result()
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	data := make([]TemplateVersion, 0, len(entries))
+	for _, raw := range entries {
+		var v TemplateVersion
+		if err := json.Unmarshal([]byte(raw), &v); err == nil {
+			data = append(data, v)
+		}
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Version > data[j].Version })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// PinTemplateVersion handles PUT /v1/prompt-templates/{id}/pin: chat
+// completions with the API key of this request use the version until it is
+// unpinned, whatever versions are added later
+func PinTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	scope := apiKeyScope(r)
+	if scope == "" {
+		apierror.Write(w, http.StatusBadRequest, "pinning requires an API key")
+		return
+	}
+
+	var req struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Version < 1 {
+		apierror.New(http.StatusBadRequest, `body must be {"version": <number>}`).WithParam("version").Write(w)
+		return
+	}
+	if _, err := loadTemplateVersion(r.Context(), tmpl.ID, req.Version); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	if err := rdb.HSet(r.Context(), promptTemplatePinsKey(tmpl.ID), scope, req.Version).Err(); err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	tmpl.PinnedVersion = req.Version
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// UnpinTemplateVersion handles DELETE /v1/prompt-templates/{id}/pin; the API
+// key follows the latest version again
+func UnpinTemplateVersion(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	if scope := apiKeyScope(r); scope != "" {
+		if err := rdb.HDel(r.Context(), promptTemplatePinsKey(tmpl.ID), scope).Err(); err != nil {
+			writeTemplateError(w, err)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, tmpl)
+}
+
+// RenderPromptTemplate handles POST /v1/prompt-templates/{id}/render and
+// returns the messages a chat completion would start with
+func RenderPromptTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Version   int               `json:"version"`
+		Variables map[string]string `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	use, messages, err := renderRequestTemplate(r, r.Header.Get("X-User-ID"), OpenAIRequest{
+		TemplateID:      r.PathValue("id"),
+		TemplateVersion: req.Version,
+		Variables:       req.Variables,
+	})
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"template_id": use.TemplateID,
+		"version":     use.Version,
+		"messages":    messages,
+	})
+}
+
+// GetTemplateUsage handles GET /v1/prompt-templates/{id}/usage: requests and
+// tokens of chat completions rendered from the template, in total and per version
+func GetTemplateUsage(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := loadPromptTemplate(r.Context(), r.Header.Get("X-User-ID"), r.PathValue("id"))
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	fields, err := rdb.HGetAll(r.Context(), promptTemplateUsageKey(tmpl.ID)).Result()
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+
+	var total TemplateUsage
+	versions := make(map[string]*TemplateUsage)
+	var lastUsedAt int64
+	for field, value := range fields {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		if field == "last_used_at" {
+			lastUsedAt = n
+			continue
+		}
+		usage := &total
+		if version, name, ok := strings.Cut(field, ":"); ok {
+			field = name
+			if versions[version] == nil {
+				versions[version] = &TemplateUsage{}
+			}
+			usage = versions[version]
+		}
+		switch field {
+		case "requests":
+			usage.Requests = n
+		case "prompt_tokens":
+			usage.PromptTokens = n
+		case "completion_tokens":
+			usage.CompletionTokens = n
+		}
+	}
+
+	perVersion := make([]map[string]interface{}, 0, len(versions))
+	for version, usage := range versions {
+		number, _ := strconv.Atoi(strings.TrimPrefix(version, "v"))
+		perVersion = append(perVersion, map[string]interface{}{
+			"version":           number,
+			"requests":          usage.Requests,
+			"prompt_tokens":     usage.PromptTokens,
+			"completion_tokens": usage.CompletionTokens,
+		})
+	}
+	sort.Slice(perVersion, func(i, j int) bool { return perVersion[i]["version"].(int) > perVersion[j]["version"].(int) })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"object":            "prompt_template.usage",
+		"template_id":       tmpl.ID,
+		"requests":          total.Requests,
+		"prompt_tokens":     total.PromptTokens,
+		"completion_tokens": total.CompletionTokens,
+		"last_used_at":      lastUsedAt,
+		"versions":          perVersion,
+	})
+}
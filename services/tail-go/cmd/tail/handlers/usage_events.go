@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/usageevents"
+)
+
+// События использования для хранилища аналитики:
+// USAGE_EVENTS=off отключает их, USAGE_EVENTS_BUFFER — сколько событий ждёт
+// отправки, пока NATS недоступен (дальше они теряются).
+var usageEmitter *usageevents.Emitter
+
+// StartUsageEvents публикует событие о каждом завершённом запросе в
+// JetStream (NATS_URL) до отмены ctx
+func StartUsageEvents(ctx context.Context) {
+	if envString("USAGE_EVENTS", "on") == "off" {
+		return
+	}
+	emitter, err := usageevents.Start(ctx, envString("NATS_URL", "nats://nats:4222"), "tail", envInt("USAGE_EVENTS_BUFFER", 10000))
+	if err != nil {
+		log.Printf("Usage events disabled: %v", err)
+		return
+	}
+	usageEmitter = emitter
+}
+
+// emitUsage публикует событие о завершённом запросе по его записи
+func emitUsage(r *http.Request, record CompletionRecord, status, cache string) {
+	usageEmitter.Emit(usageevents.Event{
+		RequestID:        record.RequestID,
+		UserID:           record.UserID,
+		Endpoint:         r.URL.Path,
+		Model:            record.Model,
+		Provider:         record.Provider,
+		Status:           status,
+		Stream:           record.Stream,
+		PromptTokens:     record.PromptTokens,
+		CompletionTokens: record.CompletionTokens,
+		CostUSD:          record.CostUSD,
+		LatencyMs:        record.LatencyMs,
+		Cache:            cache,
+	})
+}
@@ -11,9 +11,37 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/MaksimVF/ZB/pkg/deadline"
+)
+
+// ConfigChangedChannel is published by network-config every time the config is saved
+const ConfigChangedChannel = "network_config:changed"
+
+// lastConfigLoad is the UnixNano time of the last successful load
+var lastConfigLoad int64
+
+var (
+	configStaleness = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "network_config_staleness_seconds",
+		Help: "Seconds since the network config was last loaded successfully",
+	}, func() float64 {
+		last := atomic.LoadInt64(&lastConfigLoad)
+		if last == 0 {
+			return 0
+		}
+		return time.Since(time.Unix(0, last)).Seconds()
+	})
+	configReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "network_config_reloads_total",
+		Help: "Network config reloads by trigger (push, poll) and result",
+	}, []string{"trigger", "result"})
 )
 
 // NetworkConfig represents the network configuration structure
@@ -26,6 +54,8 @@ type NetworkConfig struct {
 	RetryPolicy    RetryPolicy       `json:"retry_policy"`
 	RateLimits     RateLimits        `json:"rate_limits"`
 	LoadBalancing LoadBalancingConfig `json:"load_balancing"`
+	// Timeouts of routes and models; the default policy while unset
+	Timeouts deadline.Policy `json:"timeouts,omitempty"`
 }
 
 type RetryPolicy struct {
@@ -46,17 +76,13 @@ type LoadBalancingConfig struct {
 
 // NetworkConfigManager manages dynamic network configuration
 type NetworkConfigManager struct {
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	currentConfig NetworkConfig
 	mutex        sync.RWMutex
 }
 
 // NewNetworkConfigManager creates a new config manager
-func NewNetworkConfigManager(redisAddr string) *NetworkConfigManager {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
-
+func NewNetworkConfigManager(redisClient redis.UniversalClient) *NetworkConfigManager {
 	return &NetworkConfigManager{
 		redisClient: redisClient,
 	}
@@ -88,6 +114,7 @@ func (m *NetworkConfigManager) LoadConfig() error {
 				Mode: "single",
 			},
 		}
+		atomic.StoreInt64(&lastConfigLoad, time.Now().UnixNano())
 		return nil
 	} else if err != nil {
 		return err
@@ -98,6 +125,7 @@ func (m *NetworkConfigManager) LoadConfig() error {
 		return err
 	}
 
+	atomic.StoreInt64(&lastConfigLoad, time.Now().UnixNano())
 	return nil
 }
 
@@ -108,22 +136,39 @@ func (m *NetworkConfigManager) GetConfig() NetworkConfig {
 	return m.currentConfig
 }
 
-// StartAutoReload starts the auto-reload goroutine
+// Timeouts returns the timeout policy of the current configuration, a
+// deadline.Source
+func (m *NetworkConfigManager) Timeouts() deadline.Policy {
+	return m.GetConfig().Timeouts.OrDefault()
+}
+
+// StartAutoReload reloads the config as soon as network-config publishes a
+// change and, as a fallback for missed messages, every interval
 func (m *NetworkConfigManager) StartAutoReload(interval time.Duration) {
+	sub := m.redisClient.Subscribe(context.Background(), ConfigChangedChannel)
 	ticker := time.NewTicker(interval)
 	go func() {
-		for range ticker.C {
-			err := m.LoadConfig()
-			if err != nil {
+		defer sub.Close()
+		defer ticker.Stop()
+
+		messages := sub.Channel()
+		for {
+			trigger := "poll"
+			select {
+			case <-messages:
+				trigger = "push"
+			case <-ticker.C:
+			}
+
+			if err := m.LoadConfig(); err != nil {
+				configReloads.WithLabelValues(trigger, "error").Inc()
 				log.Printf("Failed to reload config: %v", err)
-			} else {
-				log.Printf("Config reloaded successfully")
+				continue
+			}
+			configReloads.WithLabelValues(trigger, "success").Inc()
+			if trigger == "push" {
+				log.Printf("Config reloaded after change notification")
 			}
 		}
 	}()
 }
-
-
-
-
-
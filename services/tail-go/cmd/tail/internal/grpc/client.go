@@ -3,8 +3,18 @@ package grpc
 import (
 "context"
 "crypto/tls"
+"errors"
+"io"
 "log"
-"llm-gateway-pro/services/rate-limiter/pb"
+"sync"
+"sync/atomic"
+"time"
+"llm-gateway-pro/services/head-go/gen"
+"github.com/MaksimVF/ZB/services/rate-limiter/pb"
+"github.com/MaksimVF/ZB/pkg/compress"
+"github.com/MaksimVF/ZB/pkg/loadshed"
+"github.com/MaksimVF/ZB/pkg/requestid"
+"github.com/MaksimVF/ZB/pkg/tracing"
 "google.golang.org/grpc"
 "google.golang.org/grpc/credentials"
 )
@@ -12,6 +22,13 @@ import (
 type HeadClient struct {
 Conn *grpc.ClientConn
 configManager *config.NetworkConfigManager
+routing *RoutingClient
+pool *headConnPool
+// inflight — вызовы head, ещё не получившие ответа; очередь для сброса нагрузки
+inflight atomic.Int64
+// mu защищает Conn и endpoint при смене head в сетевой конфигурации
+mu sync.Mutex
+endpoint string
 }
 
 type RateLimiterClient struct {
@@ -21,11 +38,42 @@ configManager *config.NetworkConfigManager
 
 func NewHeadClient(addr string, configManager *config.NetworkConfigManager) *HeadClient {
 creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
-conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds), tracing.DialOption(), requestid.DialOption(), compress.DialOption(), loadshed.DialOption())
 if err != nil { log.Fatal(err) }
-return &HeadClient{Conn: conn, configManager: configManager}
+return &HeadClient{Conn: conn, configManager: configManager, pool: newHeadConnPool(), endpoint: addr}
 }
 
+// WithRouting makes the client pick a head per request via routing-service
+func (c *HeadClient) WithRouting(routing *RoutingClient) *HeadClient {
+c.routing = routing
+return c
+}
+
+// connFor returns the connection to use for the model and the routing decision
+// that produced it. Without a routing client the static connection is used.
+func (c *HeadClient) connFor(ctx context.Context, model string) (*grpc.ClientConn, *RoutingDecision, error) {
+if c.routing == nil {
+c.mu.Lock()
+defer c.mu.Unlock()
+return c.Conn, nil, nil
+}
+
+decision, err := c.routing.SelectHead(ctx, model)
+if err != nil {
+return nil, nil, err
+}
+
+conn, err := c.pool.get(decision.Endpoint)
+if err != nil {
+return nil, decision, err
+}
+return conn, decision, nil
+}
+
+// reconnect переключается на head из сетевой конфигурации, когда он сменился,
+// например при выводе прежнего head на обслуживание. Старое соединение
+// закрывается только через самый длинный таймаут, чтобы начатые на нём
+// стримы успели завершиться.
 func (c *HeadClient) reconnect() error {
 if c.configManager == nil {
 return nil
@@ -36,54 +84,154 @@ if networkConfig.HeadEndpoint == "" {
 return nil
 }
 
-creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
-
-// Close existing connection
-if c.Conn != nil {
-c.Conn.Close()
+c.mu.Lock()
+defer c.mu.Unlock()
+if networkConfig.HeadEndpoint == c.endpoint && c.Conn != nil {
+return nil
 }
 
-conn, err := grpc.Dial(networkConfig.HeadEndpoint, grpc.WithTransportCredentials(creds))
+creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+conn, err := grpc.Dial(networkConfig.HeadEndpoint, grpc.WithTransportCredentials(creds), tracing.DialOption(), requestid.DialOption(), compress.DialOption(), loadshed.DialOption())
 if err != nil {
 log.Printf("Failed to reconnect to head service: %v", err)
 return err
 }
 
-c.Conn = conn
+if old := c.Conn; old != nil {
+grace := c.configManager.Timeouts().Longest()
+time.AfterFunc(grace, func() { old.Close() })
+log.Printf("Closing connection to head service at %s in %s", c.endpoint, grace)
+}
+c.Conn, c.endpoint = conn, networkConfig.HeadEndpoint
 log.Printf("Successfully reconnected to head service at %s", networkConfig.HeadEndpoint)
 return nil
 }
 
+// InFlight возвращает число вызовов head, ещё не получивших ответа
+func (c *HeadClient) InFlight() int {
+return int(c.inflight.Load())
+}
+
 func (c *HeadClient) Completion(ctx context.Context, model string, msgs []any) (any, error) {
+c.inflight.Add(1)
+defer c.inflight.Add(-1)
+
 // Check if we need to reconnect
-if c.configManager != nil {
+if c.configManager != nil && c.routing == nil {
 err := c.reconnect()
 if err != nil {
 log.Printf("Failed to reconnect: %v", err)
 }
 }
 
-// вызов твоего head-сервиса
-return map[string]string{"content": "Hello!"}, nil
+start := time.Now()
+conn, decision, err := c.connFor(ctx, model)
+if err != nil {
+if c.routing != nil {
+c.routing.ReportOutcome(decision, model, time.Since(start), err)
+}
+return nil, err
+}
+
+// Вызов идёт на head, выбранный routing-service, а не на статический
+resp, err := gen.NewChatServiceClient(conn).ChatCompletion(ctx, chatRequest(ctx, model, msgs, false))
+if c.routing != nil {
+c.routing.ReportOutcome(decision, model, time.Since(start), err)
+}
+if err != nil {
+return nil, err
+}
+return resp, nil
 }
 
 func (c *HeadClient) Stream(ctx context.Context, model string, msgs []any) (<-chan string, error) {
 // Check if we need to reconnect
-if c.configManager != nil {
+if c.configManager != nil && c.routing == nil {
 err := c.reconnect()
 if err != nil {
 log.Printf("Failed to reconnect: %v", err)
 }
 }
 
+start := time.Now()
+conn, decision, err := c.connFor(ctx, model)
+if err == nil {
+var stream gen.ChatService_ChatCompletionStreamClient
+stream, err = gen.NewChatServiceClient(conn).ChatCompletionStream(ctx, chatRequest(ctx, model, msgs, true))
+if err == nil {
+return c.forward(ctx, stream, decision, model, start), nil
+}
+}
+if c.routing != nil {
+c.routing.ReportOutcome(decision, model, time.Since(start), err)
+}
+return nil, err
+}
+
+// forward передаёт чанки стрима head в канал, пока head не завершит ответ
+func (c *HeadClient) forward(ctx context.Context, stream gen.ChatService_ChatCompletionStreamClient, decision *RoutingDecision, model string, start time.Time) <-chan string {
 ch := make(chan string, 10)
-go func() { ch <- "Hello"; ch <- "World"; close(ch) }()
-return ch, nil
+c.inflight.Add(1)
+go func() {
+defer c.inflight.Add(-1)
+defer close(ch)
+for {
+chunk, err := stream.Recv()
+if errors.Is(err, io.EOF) {
+break
+}
+if err != nil {
+// Клиент отключился — отмена ctx уже оборвала вызов head, это не сбой head
+if ctx.Err() != nil {
+return
+}
+log.Printf("Head stream for %s failed: %v", model, err)
+if c.routing != nil {
+c.routing.ReportOutcome(decision, model, time.Since(start), err)
+}
+return
+}
+// Клиент отключился — прекращаем стрим, отмена ctx обрывает вызов head
+select {
+case ch <- chunk.GetChunk():
+case <-ctx.Done():
+return
+}
+if chunk.GetIsFinal() {
+break
+}
+}
+if c.routing != nil {
+c.routing.ReportOutcome(decision, model, time.Since(start), nil)
+}
+}()
+return ch
+}
+
+// chatRequest переводит сообщения в формате OpenAI ({"role", "content"}) в запрос head
+func chatRequest(ctx context.Context, model string, msgs []any, stream bool) *gen.ChatRequest {
+req := &gen.ChatRequest{RequestId: requestid.FromContext(ctx), Model: model, Stream: stream}
+if req.RequestId == "" {
+req.RequestId = requestid.New()
+}
+for _, m := range msgs {
+switch m := m.(type) {
+case *gen.ChatMessage:
+req.Messages = append(req.Messages, m)
+case map[string]string:
+req.Messages = append(req.Messages, &gen.ChatMessage{Role: m["role"], Content: m["content"]})
+case map[string]any:
+role, _ := m["role"].(string)
+content, _ := m["content"].(string)
+req.Messages = append(req.Messages, &gen.ChatMessage{Role: role, Content: content})
+}
+}
+return req
 }
 
 func NewRateLimiterClient(addr string, configManager *config.NetworkConfigManager) *RateLimiterClient {
 creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
-conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds), tracing.DialOption(), requestid.DialOption())
 if err != nil { log.Fatal(err) }
 return &RateLimiterClient{pb.NewRateLimiterClient(conn), configManager}
 }
@@ -105,7 +253,7 @@ if c.Client != nil {
 c.Client.Close()
 }
 
-conn, err := grpc.Dial(networkConfig.HeadEndpoint, grpc.WithTransportCredentials(creds))
+conn, err := grpc.Dial(networkConfig.HeadEndpoint, grpc.WithTransportCredentials(creds), tracing.DialOption(), requestid.DialOption())
 if err != nil {
 log.Printf("Failed to reconnect to rate limiter: %v", err)
 return err
@@ -0,0 +1,283 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"github.com/MaksimVF/ZB/pkg/compress"
+	"github.com/MaksimVF/ZB/pkg/loadshed"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/residency"
+	"github.com/MaksimVF/ZB/pkg/routingclient"
+	"github.com/MaksimVF/ZB/pkg/tracing"
+	"github.com/MaksimVF/ZB/pkg/webhooksig"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/config"
+	"llm-gateway-pro/services/tail-go/cmd/tail/internal/headcache"
+)
+
+// RoutingDecision is the head selected by routing-service for a single request
+type RoutingDecision struct {
+	HeadID   string
+	Endpoint string
+	Strategy string
+	Fallback bool
+}
+
+// RoutingOutcome is reported back to routing-service after a request completes
+type RoutingOutcome struct {
+	HeadID     string `json:"head_id"`
+	ModelType  string `json:"model_type"`
+	Region     string `json:"region"`
+	Strategy   string `json:"strategy"`
+	Success    bool   `json:"success"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+	ReportedAt int64  `json:"reported_at"`
+}
+
+// feedbackWebhookSource is the X-Webhook-Source of outcome reports;
+// routing-service verifies them with its webhooks/tail secret
+const feedbackWebhookSource = "tail"
+
+// RoutingClient asks routing-service which head should serve a request
+type RoutingClient struct {
+	client        *routingclient.Client
+	configManager *config.NetworkConfigManager
+	clientID      string
+	region        string
+	feedbackURL   string
+	// feedbackSecret signs outcome reports, see feedbackWebhookSource
+	feedbackSecret string
+	httpClient     *http.Client
+	// heads decides locally while routing-service cannot be reached
+	heads     *headcache.Cache
+	stopWatch context.CancelFunc
+}
+
+// NewRoutingClient connects to routing-service. Region and client ID come from
+// TAIL_REGION and TAIL_ID, the feedback endpoint from ROUTING_FEEDBACK_URL
+// and the secret signing the feedback from ROUTING_WEBHOOK_SECRET.
+//
+// The head list is refreshed every ROUTING_HEADS_REFRESH (default 10s) for
+// local decisions, which use it for up to ROUTING_HEADS_MAX_AGE (default 5m)
+// with ROUTING_LOCAL_STRATEGY (least_loaded or round_robin).
+func NewRoutingClient(addr string, configManager *config.NetworkConfigManager) *RoutingClient {
+	client, err := routingclient.New(routingclient.Config{Addr: addr, Service: "tail"})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clientID := os.Getenv("TAIL_ID")
+	if clientID == "" {
+		clientID, _ = os.Hostname()
+	}
+
+	feedbackURL, feedbackSecret := os.Getenv("ROUTING_FEEDBACK_URL"), os.Getenv("ROUTING_WEBHOOK_SECRET")
+	if feedbackURL != "" && feedbackSecret == "" {
+		log.Printf("ROUTING_WEBHOOK_SECRET is not set, routing feedback is disabled")
+		feedbackURL = ""
+	}
+
+	heads := headcache.New(client, durationEnv("ROUTING_HEADS_MAX_AGE", 5*time.Minute), os.Getenv("ROUTING_LOCAL_STRATEGY"))
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	go heads.Watch(watchCtx, durationEnv("ROUTING_HEADS_REFRESH", 10*time.Second))
+
+	return &RoutingClient{
+		client:         client,
+		configManager:  configManager,
+		clientID:       clientID,
+		region:         os.Getenv("TAIL_REGION"),
+		feedbackURL:    feedbackURL,
+		feedbackSecret: feedbackSecret,
+		httpClient:     &http.Client{Timeout: 2 * time.Second, Transport: tracing.Transport(http.DefaultTransport)},
+		heads:          heads,
+		stopWatch:      stopWatch,
+	}
+}
+
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(name)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// Close stops the head list refresh and closes the underlying connection
+func (c *RoutingClient) Close() error {
+	c.stopWatch()
+	return c.client.Close()
+}
+
+// Conn returns the underlying connection, e.g. for readiness checks
+func (c *RoutingClient) Conn() *grpc.ClientConn {
+	return c.client.Conn()
+}
+
+// SelectHead returns the head that should serve the given model. If routing-service
+// is unavailable, the head is picked locally from the last head list it sent;
+// without a recent list, or if routing-service has no suitable head, the
+// statically configured head is used.
+//
+// A data residency in ctx (residency.WithRegions) limits the heads to its
+// regions. The region of the static head is unknown, so such requests fail
+// with residency.ErrViolation instead of falling back.
+func (c *RoutingClient) SelectHead(ctx context.Context, modelType string) (*RoutingDecision, error) {
+	regions := residency.FromContext(ctx)
+	metadata := map[string]string{"model": modelType}
+	if len(regions) > 0 {
+		metadata[residency.MetadataKey] = residency.Format(regions)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+
+	resp, err := c.client.GetRoutingDecision(ctx, &routingpb.GetRoutingDecisionRequest{
+		ClientId:         c.clientID,
+		ModelType:        modelType,
+		RegionPreference: c.region,
+		Metadata:         metadata,
+	})
+	if err == nil && resp.Endpoint != "" {
+		return &RoutingDecision{
+			HeadID:   resp.HeadId,
+			Endpoint: normalizeEndpoint(resp.Endpoint),
+			Strategy: resp.StrategyUsed,
+		}, nil
+	}
+
+	if err != nil {
+		log.Printf("Routing decision failed for model %s: %v", modelType, err)
+		decision, localErr := c.selectLocal(modelType, regions)
+		if localErr == nil {
+			return decision, nil
+		}
+		log.Printf("Local routing decision failed for model %s: %v", modelType, localErr)
+	} else {
+		log.Printf("Routing-service returned no head for model %s: %s", modelType, resp.Reason)
+	}
+
+	if len(regions) > 0 {
+		return nil, fmt.Errorf("model %s in regions %s: %w", modelType, residency.Format(regions), residency.ErrViolation)
+	}
+	return c.fallback(modelType)
+}
+
+// selectLocal picks a head from the cached head list
+func (c *RoutingClient) selectLocal(modelType string, regions []string) (*RoutingDecision, error) {
+	head, err := c.heads.Select(modelType, c.region, regions)
+	if err != nil {
+		return nil, err
+	}
+	return &RoutingDecision{
+		HeadID:   head.GetHeadId(),
+		Endpoint: normalizeEndpoint(head.GetEndpoint()),
+		Strategy: "local_" + c.heads.Strategy(),
+	}, nil
+}
+
+// fallback returns the head endpoint from network configuration
+func (c *RoutingClient) fallback(modelType string) (*RoutingDecision, error) {
+	if c.configManager == nil {
+		return nil, fmt.Errorf("no head available for model %s", modelType)
+	}
+
+	endpoint := c.configManager.GetConfig().HeadEndpoint
+	if endpoint == "" {
+		return nil, fmt.Errorf("no head available for model %s", modelType)
+	}
+
+	return &RoutingDecision{
+		Endpoint: normalizeEndpoint(endpoint),
+		Strategy: "static",
+		Fallback: true,
+	}, nil
+}
+
+// ReportOutcome sends the result of a routed request back to routing-service so
+// that feedback-based strategies can use real latencies. Reporting is best effort.
+func (c *RoutingClient) ReportOutcome(decision *RoutingDecision, modelType string, latency time.Duration, callErr error) {
+	if c.feedbackURL == "" || decision == nil || decision.Fallback {
+		return
+	}
+
+	outcome := RoutingOutcome{
+		HeadID:     decision.HeadID,
+		ModelType:  modelType,
+		Region:     c.region,
+		Strategy:   decision.Strategy,
+		Success:    callErr == nil,
+		LatencyMs:  latency.Milliseconds(),
+		ReportedAt: time.Now().Unix(),
+	}
+	if callErr != nil {
+		outcome.Error = callErr.Error()
+	}
+
+	go func() {
+		body, err := json.Marshal(outcome)
+		if err != nil {
+			return
+		}
+
+		req, err := http.NewRequest("POST", c.feedbackURL, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		webhooksig.SignRequest(req, feedbackWebhookSource, c.feedbackSecret, uuid.New().String(), body, time.Now())
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			log.Printf("Failed to report routing outcome for head %s: %v", decision.HeadID, err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// normalizeEndpoint strips the grpc:// scheme used in routing and network config
+func normalizeEndpoint(endpoint string) string {
+	return strings.TrimPrefix(endpoint, "grpc://")
+}
+
+// headConnPool keeps one connection per head endpoint
+type headConnPool struct {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newHeadConnPool() *headConnPool {
+	return &headConnPool{conns: make(map[string]*grpc.ClientConn)}
+}
+
+func (p *headConnPool) get(endpoint string) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[endpoint]; ok {
+		return conn, nil
+	}
+
+	creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds), tracing.DialOption(), requestid.DialOption(), compress.DialOption(), loadshed.DialOption())
+	if err != nil {
+		return nil, err
+	}
+
+	p.conns[endpoint] = conn
+	return conn, nil
+}
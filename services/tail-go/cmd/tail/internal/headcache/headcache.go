@@ -0,0 +1,141 @@
+// Package headcache keeps the head list of routing-service so a tail can
+// still route when routing-service cannot be reached. The list is refreshed
+// with GetAllHeads in the background and decisions are made locally from the
+// last one received.
+package headcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"github.com/MaksimVF/ZB/pkg/residency"
+	"google.golang.org/grpc"
+)
+
+// Local strategies
+const (
+	RoundRobin  = "round_robin"
+	LeastLoaded = "least_loaded"
+)
+
+var (
+	// ErrStale is returned when the head list is older than the max age, or
+	// was never received
+	ErrStale = errors.New("head list is stale")
+	// ErrNoHead is returned when no active head serves the model
+	ErrNoHead = errors.New("no active head")
+)
+
+// Lister returns the registered heads; routingpb.RoutingServiceClient is one
+type Lister interface {
+	GetAllHeads(ctx context.Context, in *routingpb.GetAllHeadsRequest, opts ...grpc.CallOption) (*routingpb.GetAllHeadsResponse, error)
+}
+
+// Cache holds the last head list received from routing-service
+type Cache struct {
+	lister Lister
+	// maxAge bounds how long a list is used without a successful refresh
+	maxAge   time.Duration
+	strategy string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	heads   []*routingpb.HeadService
+	updated time.Time
+	// next is the round-robin position per model
+	next map[string]int
+}
+
+// New returns an empty cache; decisions fail with ErrStale until the first
+// Refresh. An unknown strategy is least-loaded.
+func New(lister Lister, maxAge time.Duration, strategy string) *Cache {
+	if strategy != RoundRobin {
+		strategy = LeastLoaded
+	}
+	return &Cache{lister: lister, maxAge: maxAge, strategy: strategy, now: time.Now, next: make(map[string]int)}
+}
+
+// Refresh replaces the list with the heads routing-service has now
+func (c *Cache) Refresh(ctx context.Context) error {
+	resp, err := c.lister.GetAllHeads(ctx, &routingpb.GetAllHeadsRequest{})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.heads = resp.GetHeads()
+	c.updated = c.now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch refreshes the list every interval until ctx is done. A failed
+// refresh keeps the previous list, which is used until it is older than the
+// max age.
+func (c *Cache) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, interval)
+		if err := c.Refresh(refreshCtx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh heads from routing-service: %v", err)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Strategy returns the local strategy
+func (c *Cache) Strategy() string {
+	return c.strategy
+}
+
+// Select picks an active head of the model from the list, within regions
+// when set. Heads in the preferred region are picked first.
+func (c *Cache) Select(model, preferredRegion string, regions []string) (*routingpb.HeadService, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.updated.IsZero() || c.now().Sub(c.updated) > c.maxAge {
+		return nil, ErrStale
+	}
+
+	var candidates, preferred []*routingpb.HeadService
+	for _, head := range c.heads {
+		if head.GetModelType() != model || head.GetStatus() != "active" || !residency.Allows(regions, head.GetRegion()) {
+			continue
+		}
+		candidates = append(candidates, head)
+		if preferredRegion != "" && head.GetRegion() == preferredRegion {
+			preferred = append(preferred, head)
+		}
+	}
+	if len(preferred) > 0 {
+		candidates = preferred
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w for model %s", ErrNoHead, model)
+	}
+
+	if c.strategy == RoundRobin {
+		i := c.next[model] % len(candidates)
+		c.next[model] = i + 1
+		return candidates[i], nil
+	}
+	best := candidates[0]
+	for _, head := range candidates[1:] {
+		if head.GetCurrentLoad() < best.GetCurrentLoad() {
+			best = head
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,99 @@
+package headcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	routingpb "github.com/MaksimVF/ZB/gen/proto"
+	"google.golang.org/grpc"
+)
+
+type lister struct {
+	heads []*routingpb.HeadService
+	err   error
+}
+
+func (l *lister) GetAllHeads(ctx context.Context, in *routingpb.GetAllHeadsRequest, opts ...grpc.CallOption) (*routingpb.GetAllHeadsResponse, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &routingpb.GetAllHeadsResponse{Heads: l.heads}, nil
+}
+
+func TestSelect(t *testing.T) {
+	l := &lister{heads: []*routingpb.HeadService{
+		{HeadId: "eu1", Endpoint: "grpc://eu1:50055", Status: "active", CurrentLoad: 40, Region: "eu-west", ModelType: "gpt-4o"},
+		{HeadId: "eu2", Status: "active", CurrentLoad: 10, Region: "eu-west", ModelType: "gpt-4o"},
+		{HeadId: "eu3", Status: "draining", CurrentLoad: 0, Region: "eu-west", ModelType: "gpt-4o"},
+		{HeadId: "us1", Status: "active", CurrentLoad: 5, Region: "us-east", ModelType: "gpt-4o"},
+		{HeadId: "cl1", Status: "active", Region: "eu-west", ModelType: "claude"},
+	}}
+	now := time.Unix(1_800_000_000, 0)
+	c := New(l, time.Minute, "")
+	c.now = func() time.Time { return now }
+
+	if _, err := c.Select("gpt-4o", "", nil); !errors.Is(err, ErrStale) {
+		t.Fatalf("before the first refresh err = %v", err)
+	}
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	pick := func(model, region string, regions []string) string {
+		head, err := c.Select(model, region, regions)
+		if err != nil {
+			return err.Error()
+		}
+		return head.HeadId
+	}
+	if got := pick("gpt-4o", "", nil); got != "us1" {
+		t.Errorf("least loaded = %s", got)
+	}
+	if got := pick("gpt-4o", "eu-west", nil); got != "eu2" {
+		t.Errorf("least loaded in the preferred region = %s", got)
+	}
+	if got := pick("gpt-4o", "us-east", []string{"eu-west"}); got != "eu2" {
+		t.Errorf("within residency = %s", got)
+	}
+	if _, err := c.Select("llama", "", nil); !errors.Is(err, ErrNoHead) {
+		t.Errorf("unknown model err = %v", err)
+	}
+
+	// A failed refresh keeps the list until it is older than the max age
+	l.err = errors.New("unavailable")
+	if err := c.Refresh(context.Background()); err == nil {
+		t.Fatal("refresh did not fail")
+	}
+	now = now.Add(50 * time.Second)
+	if got := pick("claude", "", nil); got != "cl1" {
+		t.Errorf("after a failed refresh = %s", got)
+	}
+	now = now.Add(20 * time.Second)
+	if _, err := c.Select("claude", "", nil); !errors.Is(err, ErrStale) {
+		t.Errorf("past the max age err = %v", err)
+	}
+}
+
+func TestSelectRoundRobin(t *testing.T) {
+	l := &lister{heads: []*routingpb.HeadService{
+		{HeadId: "a", Status: "active", ModelType: "gpt-4o"},
+		{HeadId: "b", Status: "active", ModelType: "gpt-4o"},
+	}}
+	c := New(l, time.Minute, RoundRobin)
+	if err := c.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for i := 0; i < 3; i++ {
+		head, err := c.Select("gpt-4o", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += head.HeadId
+	}
+	if got != "aba" {
+		t.Errorf("round robin = %s", got)
+	}
+}
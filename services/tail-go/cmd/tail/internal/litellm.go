@@ -9,16 +9,13 @@
 package internal
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/redisconn"
 )
 
 var (
@@ -42,28 +39,26 @@ var (
 		},
 	}
 
-	// Provider health status
-	providerHealth = map[string]bool{
-		"openai":     true,
-		"anthropic":  true,
-		"google":     true,
-		"groq":      true,
+	// Provider health status, updated by probes
+	providerHealth = map[string]*ProviderStatus{
+		"openai":    newProviderStatus(),
+		"anthropic": newProviderStatus(),
+		"google":    newProviderStatus(),
+		"groq":      newProviderStatus(),
 	}
 
 	// Mutex for provider health updates
 	healthMutex sync.RWMutex
 
 	// Redis client for provider management
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 )
 
 func init() {
 	// Initialize Redis client
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	redisClient = redisconn.MustNew(redisconn.FromEnv())
 
-	// Start health check goroutine
+	// Start probing providers
 	go startHealthChecks()
 }
 
@@ -72,6 +67,8 @@ type ProviderConfig struct {
 	BaseURL string   `json:"base_url"`
 	Models  []string `json:"models"`
 	APIKey  string   `json:"api_key"`
+	// ProbeModel is used for health probes (default: the first model)
+	ProbeModel string `json:"probe_model,omitempty"`
 }
 
 // GetProviderForModel returns the appropriate provider for a given model
@@ -82,7 +79,7 @@ func GetProviderForModel(model string) (string, error) {
 	for provider, config := range providerConfig {
 		for _, m := range config.Models {
 			if m == model {
-				if providerHealth[provider] {
+				if status, ok := providerHealth[provider]; ok && status.Healthy {
 					return provider, nil
 				}
 				return "", fmt.Errorf("provider %s is unhealthy", provider)
@@ -124,6 +121,10 @@ func SetProviderAPIKey(provider, apiKey string) error {
 	if config, exists := providerConfig[provider]; exists {
 		config.APIKey = apiKey
 		providerConfig[provider] = config
+		// Probe with the new key right away
+		if status, ok := providerHealth[provider]; ok {
+			status.NextProbe = time.Time{}
+		}
 		return nil
 	}
 	return fmt.Errorf("provider %s not configured", provider)
@@ -135,7 +136,7 @@ func AddProvider(provider string, config ProviderConfig) error {
 	defer healthMutex.Unlock()
 
 	providerConfig[provider] = config
-	providerHealth[provider] = true
+	providerHealth[provider] = newProviderStatus()
 	return nil
 }
 
@@ -152,27 +153,6 @@ func RemoveProvider(provider string) error {
 	return fmt.Errorf("provider %s not found", provider)
 }
 
-// startHealthChecks periodically checks provider health
-func startHealthChecks() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		checkProviderHealth()
-	}
-}
-
-// checkProviderHealth checks the health of all providers
-func checkProviderHealth() {
-	healthMutex.Lock()
-	defer healthMutex.Unlock()
-
-	for provider := range providerConfig {
-		// Simple health check - could be enhanced with actual API calls
-		providerHealth[provider] = true // In real implementation, this would check actual provider status
-	}
-}
-
 // GetAllProviders returns the list of all configured providers
 func GetAllProviders() (map[string]ProviderConfig, error) {
 	healthMutex.RLock()
@@ -186,15 +166,15 @@ func GetAllProviders() (map[string]ProviderConfig, error) {
 	return result, nil
 }
 
-// GetProviderHealth returns the health status of all providers
-func GetProviderHealth() (map[string]bool, error) {
+// GetProviderHealth returns the probe status of all providers
+func GetProviderHealth() (map[string]ProviderStatus, error) {
 	healthMutex.RLock()
 	defer healthMutex.RUnlock()
 
 	// Return a copy to avoid race conditions
-	result := make(map[string]bool)
+	result := make(map[string]ProviderStatus)
 	for k, v := range providerHealth {
-		result[k] = v
+		result[k] = *v
 	}
 	return result, nil
 }
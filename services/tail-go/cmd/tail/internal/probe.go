@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Providers are probed with a one-token completion, which exercises the API
+// key, the endpoint and the model rather than just reachability. Healthy
+// providers are probed every PROVIDER_PROBE_INTERVAL_SECONDS; failing ones
+// back off exponentially up to PROVIDER_PROBE_MAX_BACKOFF_SECONDS, so an
+// outage does not cost a paid request every interval.
+var (
+	probeInterval   = envSeconds("PROVIDER_PROBE_INTERVAL_SECONDS", 30)
+	probeMaxBackoff = envSeconds("PROVIDER_PROBE_MAX_BACKOFF_SECONDS", 600)
+	probeTimeout    = envSeconds("PROVIDER_PROBE_TIMEOUT_SECONDS", 10)
+
+	probeClient = &http.Client{}
+)
+
+// A provider is marked unhealthy after this many failed probes in a row
+const probeFailureThreshold = 2
+
+// Provider states
+const (
+	ProviderUnprobed  = "unprobed"
+	ProviderHealthy   = "healthy"
+	ProviderUnhealthy = "unhealthy"
+)
+
+var errNoAPIKey = errors.New("API key not configured")
+
+var (
+	probeLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "provider_probe_latency_seconds",
+		Help:    "Latency of successful provider probes",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider"})
+	probesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "provider_probes_total",
+		Help: "Provider probes by result (success, failure, skipped)",
+	}, []string{"provider", "result"})
+	providerUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "provider_healthy",
+		Help: "1 if the provider passes its probes",
+	}, []string{"provider"})
+)
+
+// ProviderStatus is the probe history of a provider
+type ProviderStatus struct {
+	Healthy bool   `json:"healthy"`
+	State   string `json:"state"`
+	// Latency of the last successful probe and its moving average
+	LatencyMs           int64      `json:"latency_ms"`
+	AvgLatencyMs        float64    `json:"avg_latency_ms"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastProbe           *time.Time `json:"last_probe,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	NextProbe           time.Time  `json:"next_probe"`
+}
+
+func newProviderStatus() *ProviderStatus {
+	return &ProviderStatus{Healthy: true, State: ProviderUnprobed}
+}
+
+// startHealthChecks probes providers as they become due
+func startHealthChecks() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		checkProviderHealth()
+	}
+}
+
+// checkProviderHealth probes every provider that is due, in parallel
+func checkProviderHealth() {
+	now := time.Now()
+	due := make(map[string]ProviderConfig)
+
+	healthMutex.RLock()
+	for provider, config := range providerConfig {
+		if status, ok := providerHealth[provider]; ok && !now.Before(status.NextProbe) {
+			due[provider] = config
+		}
+	}
+	healthMutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for provider, config := range due {
+		wg.Add(1)
+		go func(provider string, config ProviderConfig) {
+			defer wg.Done()
+			latency, err := probeProvider(provider, config)
+			recordProbe(provider, latency, err, time.Now())
+		}(provider, config)
+	}
+	wg.Wait()
+}
+
+// recordProbe updates the status of a provider with a probe result
+func recordProbe(provider string, latency time.Duration, err error, now time.Time) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	status, ok := providerHealth[provider]
+	if !ok {
+		// Removed while the probe was running
+		return
+	}
+	status.LastProbe = &now
+
+	switch {
+	case errors.Is(err, errNoAPIKey):
+		// Nothing to probe with; keep routing to it as before
+		probesTotal.WithLabelValues(provider, "skipped").Inc()
+		status.State = ProviderUnprobed
+		status.LastError = err.Error()
+		status.NextProbe = now.Add(probeInterval)
+		return
+	case err != nil:
+		probesTotal.WithLabelValues(provider, "failure").Inc()
+		status.ConsecutiveFailures++
+		status.LastError = err.Error()
+		status.LastErrorAt = &now
+		if status.ConsecutiveFailures >= probeFailureThreshold {
+			status.Healthy = false
+			status.State = ProviderUnhealthy
+		}
+		status.NextProbe = now.Add(probeBackoff(status.ConsecutiveFailures))
+	default:
+		probesTotal.WithLabelValues(provider, "success").Inc()
+		probeLatency.WithLabelValues(provider).Observe(latency.Seconds())
+		ms := float64(latency.Microseconds()) / 1000
+		status.LatencyMs = latency.Milliseconds()
+		if status.LastSuccess == nil {
+			status.AvgLatencyMs = ms
+		} else {
+			status.AvgLatencyMs = 0.8*status.AvgLatencyMs + 0.2*ms
+		}
+		status.ConsecutiveFailures = 0
+		status.Healthy = true
+		status.State = ProviderHealthy
+		status.LastSuccess = &now
+		status.NextProbe = now.Add(probeInterval)
+	}
+
+	if status.Healthy {
+		providerUp.WithLabelValues(provider).Set(1)
+	} else {
+		providerUp.WithLabelValues(provider).Set(0)
+	}
+}
+
+// probeBackoff doubles the probe interval with every failure in a row
+func probeBackoff(failures int) time.Duration {
+	backoff := probeInterval
+	for i := 1; i < failures && backoff < probeMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > probeMaxBackoff {
+		backoff = probeMaxBackoff
+	}
+	return backoff
+}
+
+// probeProvider sends a one-token completion and returns its latency
+func probeProvider(provider string, config ProviderConfig) (time.Duration, error) {
+	if config.APIKey == "" {
+		return 0, errNoAPIKey
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	req, err := probeRequest(ctx, provider, config)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := probeClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	latency := time.Since(start)
+	if err != nil {
+		return 0, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncate(strings.TrimSpace(string(body)), 300))
+	}
+	return latency, nil
+}
+
+// probeRequest builds the smallest completion request the provider's API accepts
+func probeRequest(ctx context.Context, provider string, config ProviderConfig) (*http.Request, error) {
+	model := config.ProbeModel
+	if model == "" && len(config.Models) > 0 {
+		model = config.Models[0]
+	}
+	if model == "" {
+		return nil, errors.New("no model to probe")
+	}
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	message := []map[string]string{{"role": "user", "content": "ping"}}
+
+	var url string
+	var payload interface{}
+	header := http.Header{}
+	switch provider {
+	case "anthropic":
+		url = baseURL + "/v1/messages"
+		payload = map[string]interface{}{"model": model, "max_tokens": 1, "messages": message}
+		header.Set("x-api-key", config.APIKey)
+		header.Set("anthropic-version", "2023-06-01")
+	case "google":
+		url = baseURL + "/v1beta/models/" + model + ":generateContent"
+		payload = map[string]interface{}{
+			"contents":         []map[string]interface{}{{"parts": []map[string]string{{"text": "ping"}}}},
+			"generationConfig": map[string]int{"maxOutputTokens": 1},
+		}
+		header.Set("x-goog-api-key", config.APIKey)
+	default:
+		// OpenAI-compatible
+		url = baseURL + "/v1/chat/completions"
+		payload = map[string]interface{}{"model": model, "max_tokens": 1, "messages": message}
+		header.Set("Authorization", "Bearer "+config.APIKey)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func envSeconds(name string, def int) time.Duration {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return time.Duration(def) * time.Second
+}
@@ -0,0 +1,40 @@
+// Package retrieval splits documents into chunks and stores their embeddings
+// in a vector database for top-k search.
+package retrieval
+
+import "strings"
+
+// Chunk splits text into chunks of at most size characters on word
+// boundaries; consecutive chunks share about overlap characters.
+func Chunk(text string, size, overlap int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return nil
+	}
+	if overlap >= size {
+		overlap = 0
+	}
+
+	var chunks []string
+	start := 0
+	for start < len(words) {
+		length := 0
+		end := start
+		for end < len(words) && (end == start || length+1+len(words[end]) <= size) {
+			length += len(words[end]) + 1
+			end++
+		}
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+		if end == len(words) {
+			break
+		}
+
+		// Следующий чанк начинается за overlap символов до конца текущего
+		next := end
+		for back := 0; next > start+1 && back+len(words[next-1])+1 <= overlap; next-- {
+			back += len(words[next-1]) + 1
+		}
+		start = next
+	}
+	return chunks
+}
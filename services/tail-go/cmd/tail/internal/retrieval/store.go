@@ -0,0 +1,179 @@
+package retrieval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// Point is a chunk embedding with its payload (text, document_id, metadata)
+type Point struct {
+	ID      string                 `json:"id"`
+	Vector  []float64              `json:"vector"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// Match is a search hit
+type Match struct {
+	ID      string                 `json:"id"`
+	Score   float64                `json:"score"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// Filter restricts points by their payload: every Equals key must match and
+// every Before/After key must be a number below/above the given value
+type Filter struct {
+	Equals map[string]string
+	Before map[string]float64
+	After  map[string]float64
+}
+
+// Store is a vector database holding collection embeddings
+type Store interface {
+	CreateCollection(ctx context.Context, name string, dimensions int) error
+	DeleteCollection(ctx context.Context, name string) error
+	Upsert(ctx context.Context, collection string, points []Point) error
+	DeleteDocument(ctx context.Context, collection, documentID string) error
+	DeleteWhere(ctx context.Context, collection string, filter Filter) error
+	Search(ctx context.Context, collection string, vector []float64, limit int) ([]Match, error)
+	SearchWhere(ctx context.Context, collection string, vector []float64, limit int, filter Filter) ([]Match, error)
+}
+
+// Qdrant implements Store over the Qdrant REST API. Collections are created
+// with cosine distance; point IDs must be UUIDs.
+type Qdrant struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewQdrant creates a Qdrant store; apiKey may be empty
+func NewQdrant(url, apiKey string) *Qdrant {
+	return &Qdrant{
+		url:    strings.TrimRight(url, "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (q *Qdrant) CreateCollection(ctx context.Context, name string, dimensions int) error {
+	body := map[string]interface{}{
+		"vectors": map[string]interface{}{"size": dimensions, "distance": "Cosine"},
+	}
+	return q.do(ctx, http.MethodPut, "/collections/"+name, body, nil)
+}
+
+func (q *Qdrant) DeleteCollection(ctx context.Context, name string) error {
+	return q.do(ctx, http.MethodDelete, "/collections/"+name, nil, nil)
+}
+
+func (q *Qdrant) Upsert(ctx context.Context, collection string, points []Point) error {
+	return q.do(ctx, http.MethodPut, "/collections/"+collection+"/points?wait=true", map[string]interface{}{"points": points}, nil)
+}
+
+func (q *Qdrant) DeleteDocument(ctx context.Context, collection, documentID string) error {
+	body := map[string]interface{}{
+		"filter": map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{"key": "document_id", "match": map[string]interface{}{"value": documentID}},
+			},
+		},
+	}
+	return q.do(ctx, http.MethodPost, "/collections/"+collection+"/points/delete?wait=true", body, nil)
+}
+
+func (q *Qdrant) DeleteWhere(ctx context.Context, collection string, filter Filter) error {
+	body := map[string]interface{}{"filter": qdrantFilter(filter)}
+	return q.do(ctx, http.MethodPost, "/collections/"+collection+"/points/delete", body, nil)
+}
+
+func (q *Qdrant) Search(ctx context.Context, collection string, vector []float64, limit int) ([]Match, error) {
+	return q.SearchWhere(ctx, collection, vector, limit, Filter{})
+}
+
+func (q *Qdrant) SearchWhere(ctx context.Context, collection string, vector []float64, limit int, filter Filter) ([]Match, error) {
+	body := map[string]interface{}{
+		"vector":       vector,
+		"limit":        limit,
+		"with_payload": true,
+	}
+	if len(filter.Equals)+len(filter.Before)+len(filter.After) > 0 {
+		body["filter"] = qdrantFilter(filter)
+	}
+	var resp struct {
+		Result []struct {
+			ID      interface{}            `json:"id"`
+			Score   float64                `json:"score"`
+			Payload map[string]interface{} `json:"payload"`
+		} `json:"result"`
+	}
+	if err := q.do(ctx, http.MethodPost, "/collections/"+collection+"/points/search", body, &resp); err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(resp.Result))
+	for _, r := range resp.Result {
+		matches = append(matches, Match{ID: fmt.Sprint(r.ID), Score: r.Score, Payload: r.Payload})
+	}
+	return matches, nil
+}
+
+func qdrantFilter(filter Filter) map[string]interface{} {
+	must := make([]interface{}, 0, len(filter.Equals)+len(filter.Before)+len(filter.After))
+	for key, value := range filter.Equals {
+		must = append(must, map[string]interface{}{"key": key, "match": map[string]interface{}{"value": value}})
+	}
+	for key, value := range filter.Before {
+		must = append(must, map[string]interface{}{"key": key, "range": map[string]interface{}{"lt": value}})
+	}
+	for key, value := range filter.After {
+		must = append(must, map[string]interface{}{"key": key, "range": map[string]interface{}{"gt": value}})
+	}
+	return map[string]interface{}{"must": must}
+}
+
+func (q *Qdrant) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, q.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.apiKey != "" {
+		req.Header.Set("api-key", q.apiKey)
+	}
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("qdrant unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrCollectionNotFound
+	}
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("qdrant %s %s: status %d: %s", method, path, resp.StatusCode, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression (minute hour day-of-month month day-of-week)
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week (0 = Sunday)
+}
+
+// ParseCron parses a standard five-field cron expression. Lists (1,5), ranges (1-5),
+// steps (*/15, 10-40/5) and the @hourly, @daily, @weekly and @monthly shortcuts are supported.
+func ParseCron(expr string) (*Schedule, error) {
+	switch strings.TrimSpace(expr) {
+	case "@hourly":
+		expr = "0 * * * *"
+	case "@daily", "@midnight":
+		expr = "0 0 * * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(parts))
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron field %q: %v", part, err)
+		}
+		bits[i] = b
+	}
+
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			s, err := strconv.Atoi(item[idx+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("bad step")
+			}
+			step = s
+			item = item[:idx]
+		}
+
+		lo, hi := bounds.min, bounds.max
+		if item != "*" {
+			if idx := strings.Index(item, "-"); idx >= 0 {
+				var err error
+				if lo, err = strconv.Atoi(item[:idx]); err != nil {
+					return 0, fmt.Errorf("bad range start")
+				}
+				if hi, err = strconv.Atoi(item[idx+1:]); err != nil {
+					return 0, fmt.Errorf("bad range end")
+				}
+			} else {
+				v, err := strconv.Atoi(item)
+				if err != nil {
+					return 0, fmt.Errorf("bad value")
+				}
+				lo = v
+				if step == 1 {
+					hi = v
+				}
+			}
+		}
+
+		if lo < bounds.min || hi > bounds.max || lo > hi {
+			return 0, fmt.Errorf("value out of range %d-%d", bounds.min, bounds.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first activation time strictly after t, or zero time if none is found within 5 years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron semantics: if both day fields are restricted, either may match
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,271 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Job statuses
+const (
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+	StatusDone      = "done"
+)
+
+// Redis keys: jobs are stored as JSON, due jobs are indexed in a sorted set by next run time
+const (
+	jobKeyPrefix = "scheduled_job:"
+	jobIndexKey  = "scheduled_jobs"
+	dueIndexKey  = "scheduled_jobs:due"
+)
+
+var ErrJobNotFound = errors.New("scheduled job not found")
+
+// Job is a one-off (RunAt) or recurring (Cron) batch submission
+type Job struct {
+	ID          string            `json:"id"`
+	InputFileID string            `json:"input_file_id"`
+	Endpoint    string            `json:"endpoint"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	RunAt       int64             `json:"run_at,omitempty"`
+	Cron        string            `json:"cron,omitempty"`
+	Status      string            `json:"status"`
+	NextRunAt   int64             `json:"next_run_at,omitempty"`
+	LastRunAt   int64             `json:"last_run_at,omitempty"`
+	LastBatchID string            `json:"last_batch_id,omitempty"`
+	RunCount    int               `json:"run_count"`
+	CreatedAt   int64             `json:"created_at"`
+}
+
+// EnqueueFunc submits a batch for the job and returns the created batch ID
+type EnqueueFunc func(ctx context.Context, job *Job) (string, error)
+
+// Scheduler keeps scheduled jobs in Redis and enqueues them when they are due.
+// Jobs are claimed by removing them from the due index, so several tail
+// replicas can run the scheduler without submitting the same run twice.
+type Scheduler struct {
+	rdb     redis.UniversalClient
+	enqueue EnqueueFunc
+}
+
+// New creates a scheduler
+func New(rdb redis.UniversalClient, enqueue EnqueueFunc) *Scheduler {
+	return &Scheduler{rdb: rdb, enqueue: enqueue}
+}
+
+// Create validates and stores a new job
+func (s *Scheduler) Create(ctx context.Context, job *Job) error {
+	if (job.RunAt == 0) == (job.Cron == "") {
+		return fmt.Errorf("exactly one of run_at or cron must be set")
+	}
+
+	now := time.Now()
+	if job.Cron != "" {
+		sched, err := ParseCron(job.Cron)
+		if err != nil {
+			return err
+		}
+		next := sched.Next(now)
+		if next.IsZero() {
+			return fmt.Errorf("cron expression never fires")
+		}
+		job.NextRunAt = next.Unix()
+	} else {
+		if job.RunAt < now.Unix() {
+			return fmt.Errorf("run_at must be in the future")
+		}
+		job.NextRunAt = job.RunAt
+	}
+
+	job.ID = "sched_" + uuid.New().String()
+	job.Status = StatusActive
+	job.CreatedAt = now.Unix()
+
+	if err := s.save(ctx, job); err != nil {
+		return err
+	}
+	s.rdb.ZAdd(ctx, jobIndexKey, &redis.Z{Score: float64(job.CreatedAt), Member: job.ID})
+	return s.rdb.ZAdd(ctx, dueIndexKey, &redis.Z{Score: float64(job.NextRunAt), Member: job.ID}).Err()
+}
+
+// Get returns a job by ID
+func (s *Scheduler) Get(ctx context.Context, id string) (*Job, error) {
+	raw, err := s.rdb.Get(ctx, jobKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// List returns all jobs, newest first
+func (s *Scheduler) List(ctx context.Context) ([]*Job, error) {
+	ids, err := s.rdb.ZRevRange(ctx, jobIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := s.Get(ctx, id)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Pause stops an active job from firing until it is resumed
+func (s *Scheduler) Pause(ctx context.Context, id string) (*Job, error) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != StatusActive {
+		return nil, fmt.Errorf("job is %s", job.Status)
+	}
+
+	job.Status = StatusPaused
+	s.rdb.ZRem(ctx, dueIndexKey, job.ID)
+	return job, s.save(ctx, job)
+}
+
+// Resume reactivates a paused job. Recurring jobs continue from the next
+// cron activation; one-off jobs whose time has passed run immediately.
+func (s *Scheduler) Resume(ctx context.Context, id string) (*Job, error) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != StatusPaused {
+		return nil, fmt.Errorf("job is %s", job.Status)
+	}
+
+	now := time.Now()
+	if job.Cron != "" {
+		sched, err := ParseCron(job.Cron)
+		if err != nil {
+			return nil, err
+		}
+		job.NextRunAt = sched.Next(now).Unix()
+	} else if job.NextRunAt < now.Unix() {
+		job.NextRunAt = now.Unix()
+	}
+
+	job.Status = StatusActive
+	if err := s.save(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, s.rdb.ZAdd(ctx, dueIndexKey, &redis.Z{Score: float64(job.NextRunAt), Member: job.ID}).Err()
+}
+
+// Cancel permanently stops a job
+func (s *Scheduler) Cancel(ctx context.Context, id string) (*Job, error) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Status = StatusCancelled
+	job.NextRunAt = 0
+	s.rdb.ZRem(ctx, dueIndexKey, job.ID)
+	return job, s.save(ctx, job)
+}
+
+// Start polls for due jobs at the given interval until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.runDue(ctx)
+			}
+		}
+	}()
+}
+
+// runDue enqueues every job whose next run time has passed
+func (s *Scheduler) runDue(ctx context.Context) {
+	now := time.Now()
+	ids, err := s.rdb.ZRangeByScore(ctx, dueIndexKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	}).Result()
+	if err != nil {
+		log.Printf("Scheduler: failed to read due jobs: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		// ZREM returns 1 only for the replica that claimed the job
+		claimed, err := s.rdb.ZRem(ctx, dueIndexKey, id).Result()
+		if err != nil || claimed == 0 {
+			continue
+		}
+		s.run(ctx, id, now)
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, id string, now time.Time) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		log.Printf("Scheduler: failed to load job %s: %v", id, err)
+		return
+	}
+	if job.Status != StatusActive {
+		return
+	}
+
+	batchID, err := s.enqueue(ctx, job)
+	if err != nil {
+		// Повторим на следующем тике
+		log.Printf("Scheduler: failed to enqueue job %s: %v", id, err)
+		s.rdb.ZAdd(ctx, dueIndexKey, &redis.Z{Score: float64(now.Add(time.Minute).Unix()), Member: id})
+		return
+	}
+
+	job.LastRunAt = now.Unix()
+	job.LastBatchID = batchID
+	job.RunCount++
+
+	if job.Cron == "" {
+		job.Status = StatusDone
+		job.NextRunAt = 0
+	} else if sched, err := ParseCron(job.Cron); err == nil {
+		job.NextRunAt = sched.Next(now).Unix()
+		s.rdb.ZAdd(ctx, dueIndexKey, &redis.Z{Score: float64(job.NextRunAt), Member: id})
+	}
+
+	if err := s.save(ctx, job); err != nil {
+		log.Printf("Scheduler: failed to save job %s: %v", id, err)
+	}
+	log.Printf("Scheduler: job %s submitted batch %s", id, batchID)
+}
+
+func (s *Scheduler) save(ctx context.Context, job *Job) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, jobKeyPrefix+job.ID, raw, 0).Err()
+}
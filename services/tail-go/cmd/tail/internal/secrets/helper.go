@@ -8,6 +8,8 @@ import (
 "time"
 
 pb "github.com/MaksimVF/ZB/services/secrets-service/pb"
+"github.com/MaksimVF/ZB/pkg/requestid"
+"github.com/MaksimVF/ZB/pkg/tracing"
 "google.golang.org/grpc"
 )
 
@@ -27,6 +29,8 @@ func initClient() {
 once.Do(func() {
 conn, err := grpc.Dial("secret-service:50053",
 grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
+tracing.DialOption(),
+requestid.DialOption(),
 grpc.WithBlock(),
 )
 if err != nil {
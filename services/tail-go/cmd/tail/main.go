@@ -12,7 +12,6 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
@@ -26,6 +25,18 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/compress"
+	"github.com/MaksimVF/ZB/pkg/deadline"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/loadshed"
+	"github.com/MaksimVF/ZB/pkg/openapi"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	pb "github.com/MaksimVF/ZB/services/secrets-service/pb" // <-- твой proto
 	"llm-gateway-pro/services/gateway/handlers"
 	"llm-gateway-pro/services/tail-go/cmd/tail/middleware"
@@ -35,32 +46,47 @@ import (
 // Глобальные клиенты
 var (
 	secretClient pb.SecretServiceClient
-	secretConn   *grpc.ClientConnInterface
+	secretConn   *grpc.ClientConn
 	authClient   pb.AuthServiceClient
-	authConn     *grpc.ClientConnInterface
-	redisClient  *redis.Client
+	authConn     *grpc.ClientConn
+	redisClient  redis.UniversalClient
 	secretsCache sync.Map // имя → plaintext (кешируем на 30 сек)
 )
 
 func main() {
-	// === 1. Подключаемся к Redis ===
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	// === 0. Трассировка: traceparent идёт дальше по gRPC и NATS ===
+	shutdownTracing, err := tracing.Init(context.Background(), "tail")
+	if err != nil {
+		log.Fatalf("Не удалось инициализировать трассировку: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// === 1. Подключаемся к Redis (standalone, Sentinel или Cluster по REDIS_MODE) ===
+	redisClient, err = redisconn.New(context.Background(), redisconn.FromEnv())
+	if err != nil {
+		log.Fatalf("Неверная конфигурация Redis: %v", err)
+	}
+
+	// Лимит одновременных запросов на клиента; семафоры в Redis общие для всех реплик
+	if err := middleware.InitConcurrency(redisClient); err != nil {
+		log.Fatalf("Неверная конфигурация лимитов параллельных запросов: %v", err)
+	}
 
 	// === 2. Инициализируем NetworkConfigManager ===
-	networkConfigManager := config.NewNetworkConfigManager("redis:6379")
-	err := networkConfigManager.LoadConfig()
+	networkConfigManager := config.NewNetworkConfigManager(redisClient)
+	err = networkConfigManager.LoadConfig()
 	if err != nil {
 		log.Fatalf("Не удалось загрузить сетевую конфигурацию: %v", err)
 	}
-	networkConfigManager.StartAutoReload(10 * time.Second)
+	// Изменения приходят через Redis pub/sub, опрос — только страховка
+	networkConfigManager.StartAutoReload(60 * time.Second)
 
 	// === 3. Подключаемся к secret-service (gRPC + mTLS) ===
-	var err error
 	secretConn, err = grpc.Dial(
 		"secret-service:50053",
-		grpc.WithTransportCredentials(loadClientTLSCredentials()),
+		grpc.WithTransportCredentials(loadClientTLSCredentials("secret-service")),
+		tracing.DialOption(),
+		requestid.DialOption(),
 	)
 	if err != nil {
 		log.Fatalf("Не удалось подключиться к secret-service: %v", err)
@@ -72,7 +98,9 @@ func main() {
 	// === 4. Подключаемся к auth-service (gRPC + mTLS) ===
 	authConn, err = grpc.Dial(
 		"auth-service:50051",
-		grpc.WithTransportCredentials(loadClientTLSCredentials()),
+		grpc.WithTransportCredentials(loadClientTLSCredentials("auth-service")),
+		tracing.DialOption(),
+		requestid.DialOption(),
 	)
 	if err != nil {
 		log.Fatalf("Не удалось подключиться к auth-service: %v", err)
@@ -89,29 +117,95 @@ func main() {
 	}
 	headClient = grpc.NewHeadClient(headEndpoint, networkConfigManager)
 
-	// === 4. Фоновая задача: обновление секретов при изменении ===
+	// === 6. Выбор head через routing-service ===
+	routingAddr := os.Getenv("ROUTING_SERVICE_ADDR")
+	if routingAddr == "" {
+		routingAddr = "routing-service:50055"
+	}
+	routingClient := grpc.NewRoutingClient(routingAddr, networkConfigManager)
+	defer routingClient.Close()
+	headClient.WithRouting(routingClient)
+
+	// === 7. Фоновая задача: обновление секретов при изменении ===
 	go watchSecretsUpdates()
 
-	// === 5. HTTP → HTTPS сервер (OpenAI-совместимый API) ===
+	// Обработчик очереди батчей
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	handlers.StartBatchWorker(workerCtx)
+	handlers.StartBatchScheduler(workerCtx)
+	handlers.StartNativeBatchPoller(workerCtx)
+	handlers.StartEmbeddingsWorker(workerCtx)
+	handlers.StartChatJobsWorker(workerCtx)
+	handlers.StartUsageEvents(workerCtx)
+
+	// Сброс нагрузки: при нехватке горутин, задержке планировщика или
+	// длинной очереди к head первыми отклоняются запросы с низким приоритетом
+	shedder := loadshed.New("tail", loadshed.ConfigFromEnv(), headClient.InFlight)
+	go shedder.Run(workerCtx)
+
+	// === 8. HTTP → HTTPS сервер (OpenAI-совместимый API) ===
 	mux := http.NewServeMux()
 
-	// Публичные эндпоинты
-	mux.HandleFunc("POST /v1/chat/completions", middleware.RateLimiter(
-		middleware.ContentFilteringMiddleware(
-			middleware.AuditLoggingMiddleware(
-				middleware.DataIsolationMiddleware(handlers.ChatCompletion)))))
-	mux.HandleFunc("POST /v1/completions", middleware.RateLimiter(
-		middleware.ContentFilteringMiddleware(
-			middleware.AuditLoggingMiddleware(
-				middleware.DataIsolationMiddleware(handlers.ChatCompletion)))))
-	mux.HandleFunc("POST /v1/batch", middleware.RateLimiter(
-		middleware.ContentFilteringMiddleware(
-			middleware.AuditLoggingMiddleware(
-				middleware.DataIsolationMiddleware(handlers.BatchSubmit)))))
-	mux.HandleFunc("POST /v1/embeddings", middleware.RateLimiter(
-		middleware.ContentFilteringMiddleware(
-			middleware.AuditLoggingMiddleware(
-				middleware.DataIsolationMiddleware(handlers.Embeddings)))))
+	// Публичные эндпоинты, описанные в /openapi.json
+	registerPublicAPI(mux)
+
+	// Диалоги: история сообщений для conversation_id в /v1/chat/completions
+	mux.HandleFunc("POST /v1/conversations", handlers.CreateConversation)
+	mux.HandleFunc("GET /v1/conversations", handlers.ListConversations)
+	mux.HandleFunc("GET /v1/conversations/{id}", handlers.GetConversation)
+	mux.HandleFunc("PATCH /v1/conversations/{id}", handlers.UpdateConversation)
+	mux.HandleFunc("DELETE /v1/conversations/{id}", handlers.DeleteConversation)
+	mux.HandleFunc("GET /v1/conversations/{id}/messages", handlers.GetConversationMessages)
+	mux.HandleFunc("POST /v1/conversations/{id}/messages", handlers.AddConversationMessages)
+
+	// Шаблоны промптов: template_id и variables в /v1/chat/completions
+	mux.HandleFunc("POST /v1/prompt-templates", handlers.CreatePromptTemplate)
+	mux.HandleFunc("GET /v1/prompt-templates", handlers.ListPromptTemplates)
+	mux.HandleFunc("GET /v1/prompt-templates/{id}", handlers.GetPromptTemplate)
+	mux.HandleFunc("PATCH /v1/prompt-templates/{id}", handlers.UpdatePromptTemplate)
+	mux.HandleFunc("DELETE /v1/prompt-templates/{id}", handlers.DeletePromptTemplate)
+	mux.HandleFunc("POST /v1/prompt-templates/{id}/versions", handlers.CreateTemplateVersion)
+	mux.HandleFunc("GET /v1/prompt-templates/{id}/versions", handlers.ListTemplateVersions)
+	mux.HandleFunc("PUT /v1/prompt-templates/{id}/pin", handlers.PinTemplateVersion)
+	mux.HandleFunc("DELETE /v1/prompt-templates/{id}/pin", handlers.UnpinTemplateVersion)
+	mux.HandleFunc("POST /v1/prompt-templates/{id}/render", handlers.RenderPromptTemplate)
+	mux.HandleFunc("GET /v1/prompt-templates/{id}/usage", handlers.GetTemplateUsage)
+
+	// A/B-эксперименты: experiment_id в /v1/chat/completions
+	mux.HandleFunc("POST /v1/experiments", handlers.CreateExperiment)
+	mux.HandleFunc("GET /v1/experiments", handlers.ListExperiments)
+	mux.HandleFunc("POST /v1/experiments/feedback", handlers.ExperimentFeedback)
+	mux.HandleFunc("GET /v1/experiments/{id}", handlers.GetExperiment)
+	mux.HandleFunc("PATCH /v1/experiments/{id}", handlers.UpdateExperiment)
+	mux.HandleFunc("DELETE /v1/experiments/{id}", handlers.DeleteExperiment)
+	mux.HandleFunc("GET /v1/experiments/{id}/results", handlers.GetExperimentResults)
+
+	// Отзывы пользователей об ответах
+	mux.HandleFunc("POST /v1/completions/{id}/feedback", handlers.CompletionFeedbackHandler)
+	mux.HandleFunc("GET /v1/feedback", handlers.ListFeedback)
+	mux.HandleFunc("GET /v1/feedback/summary", handlers.FeedbackSummary)
+
+	// RAG: коллекции документов и поиск по ним
+	mux.HandleFunc("POST /v1/collections", handlers.CreateCollection)
+	mux.HandleFunc("GET /v1/collections", handlers.ListCollections)
+	mux.HandleFunc("GET /v1/collections/{name}", handlers.GetCollection)
+	mux.HandleFunc("DELETE /v1/collections/{name}", handlers.DeleteCollection)
+	mux.HandleFunc("POST /v1/collections/{name}/documents", handlers.AddDocuments)
+	mux.HandleFunc("DELETE /v1/collections/{name}/documents/{id}", handlers.DeleteDocument)
+	mux.HandleFunc("POST /v1/retrieve", handlers.Retrieve)
+
+	// Семантический кэш: включение клиентом и очистка его записей
+	mux.HandleFunc("GET /v1/semantic-cache", handlers.GetSemanticCacheSettings)
+	mux.HandleFunc("PUT /v1/semantic-cache", handlers.UpdateSemanticCacheSettings)
+	mux.HandleFunc("DELETE /v1/semantic-cache/entries", handlers.ClearSemanticCache)
+
+	// Администрирование запланированных батчей, по X-Admin-Key
+	adminKey := diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))
+	mux.Handle("GET /admin/scheduled-batches", adminKey(http.HandlerFunc(handlers.ListScheduledBatches)))
+	mux.Handle("POST /admin/scheduled-batches/{id}/pause", adminKey(http.HandlerFunc(handlers.PauseScheduledBatch)))
+	mux.Handle("POST /admin/scheduled-batches/{id}/resume", adminKey(http.HandlerFunc(handlers.ResumeScheduledBatch)))
+	mux.Handle("POST /admin/scheduled-batches/{id}/cancel", adminKey(http.HandlerFunc(handlers.CancelScheduledBatch)))
 
 	// Health check
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
@@ -137,16 +231,35 @@ func main() {
 		fmt.Fprint(w, "OK")
 	})
 
-	// Provider management endpoints
-	mux.HandleFunc("GET /v1/providers", handlers.GetProviders)
-	mux.HandleFunc("GET /v1/providers/health", handlers.GetProviderHealth)
-	mux.HandleFunc("POST /v1/providers", handlers.AddProvider)
-	mux.HandleFunc("DELETE /v1/providers", handlers.RemoveProvider)
-	mux.HandleFunc("PUT /v1/providers/api-key", handlers.UpdateProviderAPIKey)
+	// /livez — процесс жив, /readyz — состояние всех зависимостей в JSON
+	checker := health.New("tail").
+		Add("redis", func(ctx context.Context) error { return redisClient.Ping(ctx).Err() }).
+		Add("secret-service", health.GRPC(secretConn)).
+		Add("auth-service", health.GRPC(authConn)).
+		Add("routing-service", health.GRPC(routingClient.Conn()))
+	mux.Handle("GET /livez", checker.LiveHandler())
+	mux.Handle("GET /readyz", checker.ReadyHandler())
 
+	// pprof, expvar и /debug/runtime при DEBUG_ENDPOINTS=true, по X-Admin-Key
+	mux.Handle(diagnostics.Prefix, diagnostics.Handler(adminKey))
+
+	// Метрики Prometheus
+	mux.Handle("GET /metrics", httpmetrics.Handler())
+
+	// Версии API: API_V1_DEPRECATED, API_V1_SUNSET и API_V1_LINK объявляют
+	// о выводе версии из обращения (заголовки Deprecation и Sunset)
+	versions, err := apiversion.FromEnv("tail", apiVersions...)
+	if err != nil {
+		log.Fatalf("Invalid API version configuration: %v", err)
+	}
+
+	// Дедлайн каждого запроса — по политике таймаутов из network config;
+	// он уходит в head вместе с контекстом вызова
 	srv := &http.Server{
 		Addr:    ":8443",
-		Handler: mux,
+		Handler: tracing.Middleware("tail", requestid.Middleware(middleware.AccessLog(
+			httpmetrics.Middleware("tail", httpmetrics.ServeMuxRoute(mux))(compress.Middleware("tail")(versions.Middleware(shedder.Middleware(loadPriorities)(
+				deadline.Middleware(networkConfigManager.Timeouts)(mux)))))))),
 		TLSConfig: &tls.Config{
 			MinVersion: tls.VersionTLS12,
 		},
@@ -168,32 +281,134 @@ func main() {
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 	<-c
 
-	log.Println("Shutting down Gateway...")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Дренаж: /readyz отдаёт 503, новые соединения не принимаются,
+	// активные SSE-стримы дорабатывают до DRAIN_TIMEOUT
+	timeout := drainTimeout()
+	log.Printf("Shutting down Gateway, draining in-flight requests for up to %s...", timeout)
+	checker.Drain()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	srv.Shutdown(ctx)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Drain timeout exceeded, closing remaining streams: %v", err)
+		srv.Close()
+	}
 	log.Println("Gateway stopped")
 }
 
-// loadClientTLSCredentials loads client TLS credentials for mTLS
-func loadClientTLSCredentials() credentials.TransportCredentials {
-	clientCert, err := tls.LoadX509KeyPair("/certs/gateway.pem", "/certs/gateway-key.pem")
-	if err != nil {
-		log.Fatalf("Failed to load client certificate: %v", err)
+// apiVersions — версии публичного API, каждая под своим префиксом пути
+var apiVersions = []string{"v1", "v2"}
+
+// registerPublicAPI регистрирует OpenAI-совместимый API во всех версиях, его
+// спецификацию /openapi.json и документацию /docs
+func registerPublicAPI(mux *http.ServeMux) {
+	for _, version := range apiVersions {
+		registerAPIVersion(mux, "/"+version)
 	}
 
-	caCert, err := os.ReadFile("/certs/ca.pem")
-	if err != nil {
-		log.Fatalf("Failed to load CA certificate: %v", err)
+	mux.Handle("GET /openapi.json", handlers.OpenAPI().Handler())
+	mux.Handle("GET /docs", openapi.Docs("LLM Gateway Tail API", "/openapi.json"))
+}
+
+// registerAPIVersion регистрирует эндпоинты версии с префиксом prefix.
+// v2 отдаёт форматы v1, пока обработчик не различит их по
+// apiversion.FromContext
+func registerAPIVersion(mux *http.ServeMux, prefix string) {
+	mux.HandleFunc("POST "+prefix+"/chat/completions", middleware.PayloadLimits(middleware.RateLimiter(middleware.ConcurrencyLimiter(
+		middleware.ContentFilteringMiddleware(
+			middleware.AuditLoggingMiddleware(
+				middleware.DataIsolationMiddleware(handlers.Idempotent(handlers.ChatCompletion))))))))
+	mux.HandleFunc("POST "+prefix+"/completions", middleware.PayloadLimits(middleware.RateLimiter(middleware.ConcurrencyLimiter(
+		middleware.ContentFilteringMiddleware(
+			middleware.AuditLoggingMiddleware(
+				middleware.DataIsolationMiddleware(handlers.Idempotent(handlers.ChatCompletion))))))))
+	mux.HandleFunc("POST "+prefix+"/batch", middleware.PayloadLimits(middleware.RateLimiter(middleware.ConcurrencyLimiter(
+		middleware.ContentFilteringMiddleware(
+			middleware.AuditLoggingMiddleware(
+				middleware.DataIsolationMiddleware(handlers.Idempotent(handlers.BatchSubmit))))))))
+	mux.HandleFunc("POST "+prefix+"/embeddings", middleware.PayloadLimits(middleware.RateLimiter(middleware.ConcurrencyLimiter(
+		middleware.ContentFilteringMiddleware(
+			middleware.AuditLoggingMiddleware(
+				middleware.DataIsolationMiddleware(handlers.Embeddings)))))))
+
+	// Асинхронные батчи эмбеддингов
+	mux.HandleFunc("POST "+prefix+"/embeddings/batches", handlers.SubmitEmbeddingsBatch)
+	mux.HandleFunc("GET "+prefix+"/embeddings/batches/{id}", handlers.GetEmbeddingsBatch)
+	mux.HandleFunc("GET "+prefix+"/embeddings/batches/{id}/result", handlers.GetEmbeddingsBatchResult)
+
+	// Асинхронные запросы /chat/completions?async=true
+	mux.HandleFunc("GET "+prefix+"/jobs/{id}", handlers.GetChatJob)
+
+	// Статус и результаты батча
+	mux.HandleFunc("GET "+prefix+"/batch/{id}", handlers.GetBatchStatus)
+	mux.HandleFunc("GET "+prefix+"/batch/{id}/results", handlers.GetBatchResults)
+
+	// OpenAI-совместимые Files и Batch API
+	mux.HandleFunc("POST "+prefix+"/files", handlers.UploadFile)
+	mux.HandleFunc("GET "+prefix+"/files", handlers.ListFiles)
+	mux.HandleFunc("GET "+prefix+"/files/{id}", handlers.GetFile)
+	mux.HandleFunc("DELETE "+prefix+"/files/{id}", handlers.DeleteFile)
+	mux.HandleFunc("GET "+prefix+"/files/{id}/content", handlers.GetFileContent)
+	mux.HandleFunc("POST "+prefix+"/batches", handlers.CreateBatch)
+	mux.HandleFunc("GET "+prefix+"/batches", handlers.ListBatches)
+	mux.HandleFunc("GET "+prefix+"/batches/{id}", handlers.GetBatch)
+	mux.HandleFunc("POST "+prefix+"/batches/{id}/cancel", handlers.CancelBatch)
+	mux.HandleFunc("POST "+prefix+"/batches/scheduled", handlers.ScheduleBatch)
+
+	// Provider management endpoints
+	mux.HandleFunc("GET "+prefix+"/providers", handlers.GetProviders)
+	mux.HandleFunc("GET "+prefix+"/providers/health", handlers.GetProviderHealth)
+	mux.HandleFunc("POST "+prefix+"/providers", handlers.AddProvider)
+	mux.HandleFunc("DELETE "+prefix+"/providers", handlers.RemoveProvider)
+	mux.HandleFunc("PUT "+prefix+"/providers/api-key", handlers.UpdateProviderAPIKey)
+}
+
+// loadPriorities — порядок сброса нагрузки: сначала батчи и файлы, затем
+// остальной API, затем чат; служебные эндпоинты не отклоняются никогда
+var loadPriorities = loadshed.Routes{
+	Default: loadshed.Normal,
+	Prefixes: map[string]loadshed.Priority{
+		"/v1/chat/completions":   loadshed.High,
+		"/v1/completions":        loadshed.High,
+		"/v1/batch":              loadshed.Low,
+		"/v1/embeddings/batches": loadshed.Low,
+		"/v1/files":              loadshed.Low,
+		"/health":                loadshed.Critical,
+		"/livez":                 loadshed.Critical,
+		"/readyz":                loadshed.Critical,
+		"/metrics":               loadshed.Critical,
+		diagnostics.Prefix:       loadshed.Critical,
+		"/admin/":                loadshed.Critical,
+	},
+}
+
+// drainTimeout — сколько ждать завершения активных запросов при остановке
+// (DRAIN_TIMEOUT, например "30s")
+func drainTimeout() time.Duration {
+	if v := os.Getenv("DRAIN_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid DRAIN_TIMEOUT %q, using 30s", v)
 	}
+	return 30 * time.Second
+}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
+var (
+	clientCertsOnce sync.Once
+	clientCerts     *certs.Manager
+)
 
-	return credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{clientCert},
-		RootCAs:      caCertPool,
+// loadClientTLSCredentials loads client TLS credentials for mTLS with service.
+// With SPIFFE certificates only that service's ID is trusted.
+func loadClientTLSCredentials(service string) credentials.TransportCredentials {
+	clientCertsOnce.Do(func() {
+		var err error
+		clientCerts, err = certs.Watch("tail", "/certs/gateway.pem", "/certs/gateway-key.pem", "/certs/ca.pem")
+		if err != nil {
+			log.Fatalf("Failed to load client TLS credentials: %v", err)
+		}
 	})
+	return credentials.NewTLS(clientCerts.ClientConfig("", clientCerts.AuthorizeService(service)))
 }
 
 // watchSecretsUpdates watches for secret updates
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"llm-gateway-pro/services/gateway/handlers"
+)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	mux := http.NewServeMux()
+	registerPublicAPI(mux)
+
+	unrouted := handlers.OpenAPI().Unrouted(func(r *http.Request) bool {
+		_, pattern := mux.Handler(r)
+		return pattern != ""
+	})
+	if len(unrouted) > 0 {
+		t.Errorf("documented operations without a handler: %v", unrouted)
+	}
+
+	// v2 обслуживает все описанные операции v1
+	unrouted = handlers.OpenAPI().Unrouted(func(r *http.Request) bool {
+		r.URL.Path = "/v2" + strings.TrimPrefix(r.URL.Path, "/v1")
+		_, pattern := mux.Handler(r)
+		return pattern != ""
+	})
+	if len(unrouted) > 0 {
+		t.Errorf("documented operations without a v2 handler: %v", unrouted)
+	}
+
+	for _, path := range []string{"/openapi.json", "/docs"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s = %d", path, rec.Code)
+		}
+	}
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	doc := handlers.OpenAPI()
+
+	// Ошибки обработчиков соответствуют описанному телу ошибки
+	rec := httptest.NewRecorder()
+	handlers.RemoveProvider(rec, httptest.NewRequest(http.MethodDelete, "/v1/providers", nil))
+	if err := doc.ValidateResponse(http.MethodDelete, "/v1/providers", rec.Code, rec.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+
+	rec = httptest.NewRecorder()
+	handlers.ChatCompletion(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if err := doc.ValidateResponse(http.MethodPost, "/v1/chat/completions", rec.Code, rec.Body.Bytes()); err != nil {
+		t.Error(err)
+	}
+}
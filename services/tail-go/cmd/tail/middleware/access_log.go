@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MaksimVF/ZB/pkg/httpmetrics"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+)
+
+// AccessLog пишет по строке на запрос в формате key=value; request_id тот же,
+// что в X-Request-ID ответа и в логах routing-service, head и провайдеров
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := httpmetrics.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		log.Printf("access request_id=%s method=%s path=%s status=%d duration_ms=%d user_id=%q",
+			requestid.FromContext(r.Context()), r.Method, r.URL.Path, rec.Status,
+			time.Since(start).Milliseconds(), r.Header.Get("X-User-ID"))
+	})
+}
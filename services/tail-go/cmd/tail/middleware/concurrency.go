@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/MaksimVF/ZB/pkg/concurrency"
+)
+
+// streams limits simultaneous requests per client (CONCURRENCY_LIMITS),
+// separately from the per-minute limits of RateLimiter
+var streams *concurrency.Limiter
+
+// InitConcurrency sets up the concurrency limits; the semaphores live in
+// Redis and are shared by all tail replicas
+func InitConcurrency(rdb redis.UniversalClient) error {
+	var err error
+	streams, err = concurrency.NewFromEnv("tail", rdb)
+	return err
+}
+
+// ConcurrencyLimiter holds a slot of the client while the request, stream
+// included, is served; clients over their limit get 429 with the code
+// concurrency_limit_exceeded. It runs inside RateLimiter, which resolves the
+// client.
+func ConcurrencyLimiter(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if streams == nil {
+			next(w, r)
+			return
+		}
+		streams.Middleware(next).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/MaksimVF/ZB/pkg/payload"
+)
+
+// payloadLimits задаются MAX_REQUEST_BODY_BYTES, MAX_REQUEST_MESSAGES и
+// MAX_PROMPT_TOKENS
+var payloadLimits = payload.LimitsFromEnv()
+
+// PayloadLimits отклоняет слишком большие тела (413) и списки сообщений сверх
+// лимитов (400) до того, как запрос уйдёт в head или к провайдеру
+func PayloadLimits(next http.HandlerFunc) http.HandlerFunc {
+	return payloadLimits.Middleware(next).ServeHTTP
+}
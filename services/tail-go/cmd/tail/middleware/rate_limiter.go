@@ -1,75 +1,25 @@
-
-
-
-
-
 package middleware
 
 import (
-	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"encoding/pem"
-	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
-	"time"
-	"strconv"
 
-	pb "llm-gateway-pro/services/rate-limiter/pb"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/ratelimit"
 )
 
-var rateLimiterClient pb.RateLimiterClient
+// limiter consults the central rate-limiter and falls back to local limits
+// when it is unavailable (RATE_LIMIT_MODE=central|local)
+var limiter *ratelimit.Limiter
 
 func init() {
-	// Try to establish secure connection first
-	creds, err := loadTLSCredentials()
-	if err != nil {
-		log.Println("Failed to load TLS credentials, falling back to insecure connection:", err)
-		conn, err := grpc.Dial("rate-limiter:50051", grpc.WithInsecure())
-		if err != nil {
-			panic("cannot connect to rate-limiter: " + err.Error())
-		}
-		rateLimiterClient = pb.NewRateLimiterClient(conn)
-		return
-	}
-
-	conn, err := grpc.Dial("rate-limiter:50051", grpc.WithTransportCredentials(creds))
-	if err != nil {
-		log.Println("Failed to connect with TLS, falling back to insecure connection:", err)
-		conn, err := grpc.Dial("rate-limiter:50051", grpc.WithInsecure())
-		if err != nil {
-			panic("cannot connect to rate-limiter: " + err.Error())
-		}
-		rateLimiterClient = pb.NewRateLimiterClient(conn)
-		return
-	}
-	rateLimiterClient = pb.NewRateLimiterClient(conn)
-}
-
-func loadTLSCredentials() (credentials.TransportCredentials, error) {
-	// Load the certificate from file
-	certPEMBlock, err := os.ReadFile("/certs/rate-limiter.pem")
+	var err error
+	limiter, err = ratelimit.NewFromEnv()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate: %w", err)
+		panic("cannot configure rate limiter: " + err.Error())
 	}
-
-	// Parse the certificate
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(certPEMBlock) {
-		return nil, fmt.Errorf("failed to parse certificate")
-	}
-
-	// Create TLS config
-	tlsConfig := &tls.Config{
-		RootCAs: certPool,
-	}
-
-	return credentials.NewTLS(tlsConfig), nil
+	log.Printf("Rate limiting mode: %s", limiter.Mode())
 }
 
 func RateLimiter(next http.HandlerFunc) http.HandlerFunc {
@@ -77,43 +27,19 @@ func RateLimiter(next http.HandlerFunc) http.HandlerFunc {
 		path := r.URL.Path
 
 		// Пропускаем health-check и статические файлы
-		if strings.HasPrefix(path, "/health") || strings.HasPrefix(path, "/static") {
+		if strings.HasPrefix(path, "/health") || path == "/livez" || path == "/readyz" || strings.HasPrefix(path, "/static") {
 			next(w, r)
 			return
 		}
 
-		auth := r.Header.Get("Authorization")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
-		defer cancel()
-
-		resp, err := rateLimiterClient.Check(ctx, &pb.CheckRequest{
-			Authorization: auth,
-			Path:          path,
-		})
-
-                // Handle gRPC errors first
-                if err != nil {
-                        log.Printf("Rate limiter error: %v", err)
-                        w.Header().Set("Retry-After", "30")
-                        http.Error(w, `{"error": "rate limiter unavailable"}`, http.StatusServiceUnavailable)
-                        return
-                }
-
-                // Handle rate limiting response
-                if !resp.Allowed {
-                        retryAfter := int(resp.RetryAfterSecs)
-                        if retryAfter == 0 {
-                                retryAfter = 30 // Default retry-after in seconds (should be configurable)
-                        }
-                        w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
-                        http.Error(w, `{"error": "rate limit exceeded"}`, http.StatusTooManyRequests)
-                        return
-                }
+		decision := limiter.Allow(r.Context(), r.Header.Get("Authorization"), path)
+		decision.SetHeaders(w.Header())
+		if !decision.Allowed {
+			apierror.New(http.StatusTooManyRequests, "rate limit exceeded").WithCode("rate_limit_exceeded").Write(w)
+			return
+		}
 
-		next(w, r)
+		// Обработчик списывает фактические токены по тикету (или возвращает запрос)
+		next(w, r.WithContext(ratelimit.WithTicket(r.Context(), decision.Ticket)))
 	}
 }
-
-
-
@@ -1,3 +1,7 @@
 module github.com/MaksimVF/ZB/services/tail-go
 
 go 1.21
+
+require github.com/MaksimVF/ZB v0.0.0-00010101000000-000000000000
+
+replace github.com/MaksimVF/ZB => ../..
@@ -19,12 +19,14 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
 	"github.com/go-redis/redis/v8"
 )
 
 var (
 	auditLogFile *os.File
-	redisClient  *redis.Client
+	redisClient  redis.UniversalClient
 )
 
 func init() {
@@ -36,9 +38,7 @@ func init() {
 	}
 
 	// Initialize Redis client for audit logging
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	redisClient = redisconn.MustNew(redisconn.FromEnv())
 }
 
 // AuditLoggingMiddleware logs sensitive operations to audit log
@@ -73,8 +73,10 @@ func isSensitiveOperation(r *http.Request) bool {
 		"/v1/admin",
 	}
 
+	// Paths of every API version are checked as their /v1 path
+	requested := apiversion.Canonical(r.URL.Path)
 	for _, path := range sensitivePaths {
-		if strings.Contains(r.URL.Path, path) {
+		if strings.Contains(requested, path) {
 			return true
 		}
 	}
@@ -15,7 +15,9 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/apiversion"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
 	"github.com/gorilla/mux"
 )
 
@@ -29,9 +31,7 @@ var (
 		regexp.MustCompile(`(?i)javascript:`),              // JavaScript protocols
 		regexp.MustCompile(`(?i)onerror=`),                // XSS patterns
 	}
-	redisClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	redisClient = redisconn.MustNew(redisconn.FromEnv())
 )
 
 // SecurityConfig represents the security configuration for a client
@@ -50,13 +50,13 @@ func ContentFilteringMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check URL path for sensitive endpoints
-		if strings.Contains(r.URL.Path, "/v1/") {
+		// Check URL path for sensitive endpoints, in every API version
+		if apiversion.Of(r.URL.Path) != "" {
 			// Check for bad words in query parameters
 			for _, param := range r.URL.Query() {
 				for _, value := range param {
 					if containsBadWords(value) || containsBadPatterns(value) {
-						http.Error(w, "Request contains prohibited content", http.StatusBadRequest)
+						apierror.Write(w, http.StatusBadRequest, "Request contains prohibited content")
 						return
 					}
 				}
@@ -73,7 +73,7 @@ func ContentFilteringMiddleware(next http.Handler) http.Handler {
 				}
 
 				if containsBadWords(string(body)) || containsBadPatterns(string(body)) {
-					http.Error(w, "Request contains prohibited content", http.StatusBadRequest)
+					apierror.Write(w, http.StatusBadRequest, "Request contains prohibited content")
 					return
 				}
 			}
@@ -14,19 +14,19 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/apierror"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 )
 
 var (
-	redisIsolationClient *redis.Client
+	redisIsolationClient redis.UniversalClient
 )
 
 func init() {
 	// Initialize Redis client for data isolation
-	redisIsolationClient = redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
+	redisIsolationClient = redisconn.MustNew(redisconn.FromEnv())
 }
 
 // SecurityConfig represents the security configuration for a client
@@ -48,7 +48,7 @@ func DataIsolationMiddleware(next http.Handler) http.Handler {
 		// Extract client ID from request (could be from header, token, etc.)
 		clientID := getClientID(r)
 		if clientID == "" {
-			http.Error(w, "Client ID required", http.StatusUnauthorized)
+			apierror.Write(w, http.StatusUnauthorized, "Client ID required")
 			return
 		}
 
@@ -58,7 +58,7 @@ func DataIsolationMiddleware(next http.Handler) http.Handler {
 
 		// Apply data isolation policies
 		if !validateClientAccess(r, clientID) {
-			http.Error(w, "Access denied", http.StatusForbidden)
+			apierror.Write(w, http.StatusForbidden, "Access denied")
 			return
 		}
 
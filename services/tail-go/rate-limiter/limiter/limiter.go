@@ -8,19 +8,26 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/MaksimVF/ZB/pkg/tlsutil"
+	"github.com/MaksimVF/ZB/pkg/redisconn"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-jwt/jwt/v5"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"crypto/tls"
-	"crypto/x509"
-	pb "llm-gateway-pro/services/rate-limiter/pb"
+	pb "github.com/MaksimVF/ZB/services/rate-limiter/pb"
 )
 
 // Rate limit constants with explanations
@@ -44,43 +51,67 @@ const (
 )
 
 var (
-	rdb       = newRedisClient()
 	ctx       = context.Background()
 	jwtSecret = getJWTSecret() // Load from environment variable or secret service
 )
 
-// newRedisClient creates a Redis client with connection pooling and health checks
-func newRedisClient() *redis.Client {
-	options := &redis.Options{
-		Addr:         "redis:6379",
-		PoolSize:      100, // Connection pool size
-		MinIdleConns:  10,  // Minimum idle connections
-		MaxConnAge:    30 * time.Minute,
-		IdleTimeout:   5 * time.Minute,
-		ReadTimeout:   1 * time.Second,
-		WriteTimeout:  1 * time.Second,
-		DialTimeout:   5 * time.Second,
-		PoolTimeout:   5 * time.Second,
-	}
+// rdb is the Redis client, connected on first use through redisClient so
+// tests can substitute their own
+var (
+	rdb     redis.UniversalClient
+	rdbOnce sync.Once
+)
+
+// redisClient returns the Redis client, connecting on first use
+func redisClient() redis.UniversalClient {
+	rdbOnce.Do(func() { rdb = newRedisClient() })
+	return rdb
+}
 
-	client := redis.NewClient(options)
+// newRedisClient creates a Redis client with connection pooling and health checks.
+// Both rate limit scripts touch a single key, so they also run on Redis Cluster.
+func newRedisClient() redis.UniversalClient {
+	cfg := redisconn.FromEnv()
+	cfg.PoolSize = 100    // Connection pool size
+	cfg.MinIdleConns = 10 // Minimum idle connections
+	cfg.MaxConnAge = 30 * time.Minute
+	cfg.IdleTimeout = 5 * time.Minute
+	cfg.ReadTimeout = 1 * time.Second
+	cfg.WriteTimeout = 1 * time.Second
+	cfg.DialTimeout = 5 * time.Second
+	cfg.PoolTimeout = 5 * time.Second
+
+	client, err := redisconn.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Invalid Redis configuration: %v", err)
+	}
 
 	// Test the connection
-	err := client.Ping(ctx).Err()
+	err = client.Ping(ctx).Err()
 	if err != nil {
 		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 
+	// Load the scripts so checks go straight to EVALSHA
+	if err := loadScripts(client); err != nil {
+		log.Printf("Failed to preload rate limit scripts: %v", err)
+	}
+
 	log.Println("Redis connection established successfully")
 	return client
 }
 
 // checkRedisHealth checks if Redis is healthy
 func checkRedisHealth() bool {
-	err := rdb.Ping(ctx).Err()
+	err := redisClient().Ping(ctx).Err()
 	return err == nil
 }
 
+// PingRedis checks Redis for the admin server's /readyz
+func PingRedis(ctx context.Context) error {
+	return redisClient().Ping(ctx).Err()
+}
+
 func getJWTSecret() []byte {
 	// Try to get from environment variable first
 	secret := os.Getenv("JWT_SECRET")
@@ -103,6 +134,8 @@ func getJWTSecret() []byte {
 			MinConnectTimeout: 5 * time.Second,
 		}),
 		grpc.WithUnaryInterceptor(circuitBreakerUnaryClientInterceptor),
+		tracing.DialOption(),
+		requestid.DialOption(),
 	)
 	if err != nil {
 		log.Printf("Failed to connect to secret service, using fallback secret: %v", err)
@@ -144,6 +177,8 @@ func refreshJWTSecret() {
 				MinConnectTimeout: 5 * time.Second,
 			}),
 			grpc.WithUnaryInterceptor(circuitBreakerUnaryClientInterceptor),
+			tracing.DialOption(),
+			requestid.DialOption(),
 		)
 		if err != nil {
 			log.Printf("Failed to connect to secret service for refresh: %v", err)
@@ -211,21 +246,7 @@ func validateAndRefreshToken(tokenStr string) (string, error) {
 
 // loadSecretServiceTLSCredentials loads TLS config for secret service
 func loadSecretServiceTLSCredentials() (*tls.Config, error) {
-	// Load CA certificate
-	caCert, err := os.ReadFile("/certs/ca.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
-	}
-
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to add CA certificate to pool")
-	}
-
-	return &tls.Config{
-		RootCAs:    certPool,
-		MinVersion: tls.VersionTLS12,
-	}, nil
+	return tlsutil.ClientConfig("", "", "/certs/ca.pem")
 }
 
 // circuitBreakerUnaryClientInterceptor implements a simple circuit breaker
@@ -251,6 +272,7 @@ func circuitBreakerUnaryClientInterceptor(
 }
 
 // DefaultRateLimits defines the default rate limits for different endpoints
+// (the pro plan)
 var DefaultRateLimits = map[string]map[string]int{
 	"/v1/chat/completions": {
 		"requests_per_minute": ChatRequestsPerMinute,
@@ -326,164 +348,162 @@ type Server struct {
 	pb.UnimplementedRateLimiterServer
 }
 
-func (s *Server) Check(_ context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
-	clientID, err := extractClientID(req.Authorization)
+// endpointFor maps a request path to the endpoint its limits are configured for
+func endpointFor(path string) string {
+	switch {
+	case strings.Contains(path, "/v1/chat/completions") || strings.Contains(path, "/v1/completions"):
+		return "/v1/chat/completions"
+	case strings.HasPrefix(path, "/v1/embeddings"):
+		return "/v1/embeddings"
+	case strings.HasPrefix(path, "/v1/agentic"):
+		return "/v1/agentic"
+	}
+	return ""
+}
+
+// endpointLimits returns the limits of the client's plan for the endpoint
+func endpointLimits(clientID, endpoint string) (map[string]int, bool) {
+	plan := planForClient(clientID)
+	limits, err := getRateLimitsFromRedis(plan)
 	if err != nil {
-		clientID = "invalid:" + req.Authorization[:min(len(req.Authorization), 16)]
+		limits = PlanLimits[plan]
 	}
+	l, ok := limits[endpoint]
+	return l, ok
+}
 
-	path := req.Path
-	now := time.Now().UnixNano()
+func requestsKey(endpoint, clientID string) string { return "rl:" + endpoint + ":rq:" + clientID }
+func tokensKey(endpoint, clientID string) string   { return "rl:" + endpoint + ":tk:" + clientID }
 
-	// Get rate limits from Redis or use defaults
-	limits, err := getRateLimitsFromRedis()
+// Check pre-authorizes a request: it is allowed while the client has
+// requests left in the window and a positive token balance. Tokens are
+// charged afterwards with Consume, once the actual usage is known.
+func (s *Server) Check(_ context.Context, req *pb.CheckRequest) (*pb.CheckResponse, error) {
+	clientID, err := extractClientID(req.Authorization)
 	if err != nil {
-		limits = DefaultRateLimits
+		clientID = "invalid:" + req.Authorization[:min(len(req.Authorization), 16)]
 	}
 
-	// Determine which endpoint this request is for
-	var endpoint string
-	if strings.Contains(path, "/v1/chat/completions") || strings.Contains(path, "/v1/completions") {
-		endpoint = "/v1/chat/completions"
-	} else if strings.HasPrefix(path, "/v1/embeddings") {
-		endpoint = "/v1/embeddings"
-	} else if strings.HasPrefix(path, "/v1/agentic") {
-		endpoint = "/v1/agentic"
-	} else {
-		return &pb.CheckResponse{Allowed: true}, nil
+	endpoint := endpointFor(req.Path)
+	if endpoint == "" {
+		return &pb.CheckResponse{Allowed: true, ClientId: clientID}, nil
 	}
-
-	// Get limits for this endpoint
-	endpointLimits, exists := limits[endpoint]
+	limits, exists := endpointLimits(clientID, endpoint)
 	if !exists {
-		return &pb.CheckResponse{Allowed: true}, nil
+		return &pb.CheckResponse{Allowed: true, ClientId: clientID}, nil
 	}
 
-	if !slidingWindow("rl:"+endpoint+":rq:"+clientID, int64(endpointLimits["requests_per_minute"]), time.Minute) {
-		return &pb.CheckResponse{Allowed: false, RetryAfterSecs: 30}, nil
+	tpm := int64(limits["tokens_per_minute"])
+	resp := &pb.CheckResponse{ClientId: clientID, TokenLimit: tpm}
+	remaining := tokenBucket(tokensKey(endpoint, clientID), tpm, tpm, time.Minute, 0)
+	resp.TokensRemaining = remaining
+	if remaining < 1 {
+		// Wait until the bucket refills back above zero
+		resp.RetryAfterSecs = uint32((1-remaining)*60/max(tpm, 1) + 1)
+		return resp, nil
 	}
-	if !tokenBucket("rl:"+endpoint+":tk:"+clientID, int64(endpointLimits["tokens_per_minute"]), int64(endpointLimits["tokens_per_minute"]), time.Minute) {
-		return &pb.CheckResponse{Allowed: false, RetryAfterSecs: 30}, nil
+
+	requestID := fmt.Sprintf("%d-%x", time.Now().UnixNano(), rand.Uint32())
+	rpm := int64(limits["requests_per_minute"])
+	window := slidingWindow(requestsKey(endpoint, clientID), requestID, rpm, time.Minute)
+	resp.Limit = uint32(rpm)
+	resp.Remaining = uint32(max(rpm-window.count, 0))
+	resp.ResetSecs = ceilSeconds(window.reset)
+	if !window.allowed {
+		resp.RetryAfterSecs = resp.ResetSecs
+		return resp, nil
 	}
 
 	// Special case for agentic tools
 	if endpoint == "/v1/agentic" {
-		if toolsPM, exists := endpointLimits["tools_per_minute"]; exists {
-			if !slidingWindow("rl:agentic:tools:"+clientID, int64(toolsPM), time.Minute) {
-				return &pb.CheckResponse{Allowed: false, RetryAfterSecs: 60}, nil
+		if toolsPM, exists := limits["tools_per_minute"]; exists {
+			tools := slidingWindow("rl:agentic:tools:"+clientID, requestID, int64(toolsPM), time.Minute)
+			if !tools.allowed {
+				// The request does not count if it is rejected
+				if err := redisClient().ZRem(ctx, requestsKey(endpoint, clientID), requestID).Err(); err != nil {
+					log.Printf("Failed to release request slot of %s: %v", clientID, err)
+				} else {
+					resp.Remaining++
+				}
+				resp.RetryAfterSecs = ceilSeconds(tools.reset)
+				return resp, nil
 			}
 		}
 	}
 
-	return &pb.CheckResponse{Allowed: true}, nil
+	resp.Allowed = true
+	resp.RequestId = requestID
+	return resp, nil
 }
 
-// Вспомогательная функция
-func min(a, b int) int {
-	if a < b {
-		return a
+// ceilSeconds rounds a wait up to whole seconds, at least one
+func ceilSeconds(d time.Duration) uint32 {
+	secs := (d + time.Second - 1) / time.Second
+	if secs < 1 {
+		secs = 1
 	}
-	return b
+	return uint32(secs)
 }
 
-// Lua scripts for atomic rate limiting operations
-var (
-	slidingWindowScript = redis.NewScript(`
-	local now = tonumber(ARGV[1])
-	local window = tonumber(ARGV[2])
-	local limit = tonumber(ARGV[3])
-	local cutoff = now - window
-
-	-- Remove old entries
-	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', cutoff)
-
-	-- Add new entry
-	redis.call('ZADD', KEYS[1], now, now)
-
-	-- Set expiration
-	redis.call('EXPIRE', KEYS[1], math.ceil(window / 1000000000) + 60)
-
-	-- Get count
-	local count = redis.call('ZCARD', KEYS[1])
-
-	return count < limit
-	`)
-
-	tokenBucketScript = redis.NewScript(`
-	local now = tonumber(ARGV[1])
-	local capacity = tonumber(ARGV[2])
-	local rate = tonumber(ARGV[3])
-	local period = tonumber(ARGV[4])
-
-	local data = redis.call('HGETALL', KEYS[1])
-	if next(data) == nil then
-	    -- Initialize new bucket
-	    redis.call('HSET', KEYS[1], 'tokens', capacity, 'last_refill', now)
-	    return 1
-	end
-
-	local tokens = tonumber(data[2])
-	local last_refill = tonumber(data[4])
-
-	local elapsed = now - last_refill
-	local add = (elapsed * rate) / period
-	tokens = tokens + add
-	if tokens > capacity then
-	    tokens = capacity
-	end
-
-	if tokens < 1 then
-	    return 0
-	end
-
-	tokens = tokens - 1
-	redis.call('HSET', KEYS[1], 'tokens', tokens, 'last_refill', now)
-	return 1
-	`)
-)
-
-// slidingWindow и tokenBucket — без изменений (рабочие)
-func slidingWindow(key string, limit int64, window time.Duration) bool {
-	now := time.Now().UnixNano()
-
-	result, err := slidingWindowScript.Run(ctx, rdb, []string{key},
-		now, window.Nanoseconds(), limit).Result()
-	if err != nil {
-		log.Printf("Redis error in slidingWindow: %v", err)
-		// In case of Redis failure, allow the request to avoid complete service disruption
-		return true
+// Consume charges the tokens a completed request used. The bucket may go
+// negative, in which case Check rejects the client until it refills.
+func (s *Server) Consume(_ context.Context, req *pb.ConsumeRequest) (*pb.ConsumeResponse, error) {
+	if req.ClientId == "" || req.Tokens < 0 {
+		return nil, status.Error(codes.InvalidArgument, "client_id and a non-negative tokens count are required")
 	}
 
-	count, ok := result.(int64)
-	if !ok {
-		log.Printf("Unexpected Redis response type in slidingWindow")
-		return true
+	endpoint := endpointFor(req.Endpoint)
+	limits, exists := endpointLimits(req.ClientId, endpoint)
+	if !exists {
+		return &pb.ConsumeResponse{}, nil
 	}
 
-	return count < limit
+	tpm := int64(limits["tokens_per_minute"])
+	remaining := tokenBucket(tokensKey(endpoint, req.ClientId), tpm, tpm, time.Minute, req.Tokens)
+	return &pb.ConsumeResponse{RemainingTokens: remaining}, nil
 }
 
-func tokenBucket(key string, capacity, rate int64, period time.Duration) bool {
-	now := time.Now().Unix()
+// Refund gives back a failed request: its slot in the request window and
+// any tokens already charged for it
+func (s *Server) Refund(_ context.Context, req *pb.RefundRequest) (*pb.RefundResponse, error) {
+	if req.ClientId == "" || req.Tokens < 0 {
+		return nil, status.Error(codes.InvalidArgument, "client_id and a non-negative tokens count are required")
+	}
 
-	result, err := tokenBucketScript.Run(ctx, rdb, []string{key},
-		now, capacity, rate, int64(period.Seconds())).Result()
-	if err != nil {
-		log.Printf("Redis error in tokenBucket: %v", err)
-		// In case of Redis failure, allow the request to avoid complete service disruption
-		return true
+	endpoint := endpointFor(req.Endpoint)
+	limits, exists := endpointLimits(req.ClientId, endpoint)
+	if !exists {
+		return &pb.RefundResponse{}, nil
 	}
 
-	allowed, ok := result.(int64)
-	if !ok {
-		log.Printf("Unexpected Redis response type in tokenBucket")
-		return true
+	if req.RequestId != "" {
+		keys := []string{requestsKey(endpoint, req.ClientId)}
+		if endpoint == "/v1/agentic" {
+			keys = append(keys, "rl:agentic:tools:"+req.ClientId)
+		}
+		for _, key := range keys {
+			if err := redisClient().ZRem(ctx, key, req.RequestId).Err(); err != nil {
+				return nil, status.Errorf(codes.Unavailable, "refund request slot: %v", err)
+			}
+		}
+	}
+	if req.Tokens > 0 {
+		tpm := int64(limits["tokens_per_minute"])
+		tokenBucket(tokensKey(endpoint, req.ClientId), tpm, tpm, time.Minute, -req.Tokens)
 	}
+	return &pb.RefundResponse{}, nil
+}
 
-	return allowed == 1
+// Вспомогательная функция
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
 
-// AdminHandler handles HTTP requests for rate limit administration
+// AdminHandler handles HTTP requests for rate limit administration of one
+// plan (?plan=, DefaultPlan if omitted)
 func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	// Check Redis health first
 	if !checkRedisHealth() {
@@ -491,13 +511,22 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	plan := r.URL.Query().Get("plan")
+	if plan == "" {
+		plan = DefaultPlan
+	}
+	if !isPlan(plan) {
+		http.Error(w, "Unknown plan", http.StatusBadRequest)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		// Return current rate limits from Redis or defaults
-		limits, err := getRateLimitsFromRedis()
+		limits, err := getRateLimitsFromRedis(plan)
 		if err != nil {
 			// Fallback to defaults
-			limits = DefaultRateLimits
+			limits = PlanLimits[plan]
 		}
 
 		response, err := json.Marshal(limits)
@@ -525,7 +554,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Validate path
-		if _, exists := DefaultRateLimits[req.Path]; !exists {
+		if _, exists := PlanLimits[plan][req.Path]; !exists {
 			http.Error(w, "Invalid path", http.StatusBadRequest)
 			return
 		}
@@ -539,7 +568,7 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 			limits["tools_per_minute"] = req.ToolsPM
 		}
 
-		if err := saveRateLimitsToRedis(req.Path, limits); err != nil {
+		if err := saveRateLimitsToRedis(plan, req.Path, limits); err != nil {
 			http.Error(w, "Failed to save rate limits", http.StatusInternalServerError)
 			return
 		}
@@ -552,16 +581,19 @@ func AdminHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// getRateLimitsFromRedis retrieves rate limits from Redis
-func getRateLimitsFromRedis() (map[string]map[string]int, error) {
+func rateLimitsKey(plan, path string) string { return "rate_limits:" + plan + ":" + path }
+
+// getRateLimitsFromRedis retrieves a plan's rate limits: the overrides stored
+// in Redis merged over the built-in limits
+func getRateLimitsFromRedis(plan string) (map[string]map[string]int, error) {
 	result := make(map[string]map[string]int)
 
-	for path := range DefaultRateLimits {
-		data, err := rdb.HGetAll(ctx, "rate_limits:"+path).Result()
+	for path, defaults := range PlanLimits[plan] {
+		data, err := redisClient().HGetAll(ctx, rateLimitsKey(plan, path)).Result()
 		if err != nil {
 			if err == redis.Nil {
 				// No data in Redis, use defaults
-				result[path] = DefaultRateLimits[path]
+				result[path] = defaults
 				continue
 			}
 			return nil, err
@@ -578,7 +610,7 @@ func getRateLimitsFromRedis() (map[string]map[string]int, error) {
 		}
 
 		// Merge with defaults
-		for defaultKey, defaultVal := range DefaultRateLimits[path] {
+		for defaultKey, defaultVal := range defaults {
 			if _, exists := limits[defaultKey]; !exists {
 				limits[defaultKey] = defaultVal
 			}
@@ -590,12 +622,15 @@ func getRateLimitsFromRedis() (map[string]map[string]int, error) {
 	return result, nil
 }
 
-// saveRateLimitsToRedis saves rate limits to Redis
-func saveRateLimitsToRedis(path string, limits map[string]int) error {
-	pipeline := rdb.Pipeline()
+// saveRateLimitsToRedis saves a plan's rate limit overrides to Redis in a
+// single HSET, so limits are never half updated
+func saveRateLimitsToRedis(plan, path string, limits map[string]int) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(limits))
 	for key, value := range limits {
-		pipeline.HSet(ctx, "rate_limits:"+path, key, value)
+		values[key] = value
 	}
-	_, err := pipeline.Exec(ctx)
-	return err
+	return redisClient().HSet(ctx, rateLimitsKey(plan, path), values).Err()
 }
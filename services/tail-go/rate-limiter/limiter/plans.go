@@ -0,0 +1,167 @@
+// limiter/plans.go
+package limiter
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Plans a client can be on
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+)
+
+// Plans lists the plans in ascending order
+var Plans = []string{PlanFree, PlanPro, PlanEnterprise}
+
+// DefaultPlan applies to clients without an assignment and to anonymous
+// callers (RATE_LIMIT_DEFAULT_PLAN)
+var DefaultPlan = defaultPlan()
+
+// PlanLimits are the built-in limits of each plan, by endpoint. Admins can
+// override them per plan; overrides are stored in Redis.
+var PlanLimits = map[string]map[string]map[string]int{
+	PlanFree: {
+		"/v1/chat/completions": {"requests_per_minute": 20, "tokens_per_minute": 100000},
+		"/v1/completions":      {"requests_per_minute": 20, "tokens_per_minute": 100000},
+		"/v1/embeddings":       {"requests_per_minute": 5, "tokens_per_minute": 1000000},
+		"/v1/agentic":          {"requests_per_minute": 2, "tokens_per_minute": 2000000, "tools_per_minute": 20},
+	},
+	PlanPro: DefaultRateLimits,
+	PlanEnterprise: {
+		"/v1/chat/completions": {"requests_per_minute": 600, "tokens_per_minute": 5000000},
+		"/v1/completions":      {"requests_per_minute": 600, "tokens_per_minute": 5000000},
+		"/v1/embeddings":       {"requests_per_minute": 150, "tokens_per_minute": 60000000},
+		"/v1/agentic":          {"requests_per_minute": 50, "tokens_per_minute": 200000000, "tools_per_minute": 1000},
+	},
+}
+
+// Plan assignments are written by auth-service: rate_limit:plan:<client ID>,
+// where the client ID is user:<id> or key:<api key>
+const planAssignmentPrefix = "rate_limit:plan:"
+
+func defaultPlan() string {
+	if plan := os.Getenv("RATE_LIMIT_DEFAULT_PLAN"); isPlan(plan) {
+		return plan
+	}
+	return PlanFree
+}
+
+func isPlan(plan string) bool {
+	_, ok := PlanLimits[plan]
+	return ok
+}
+
+// planForClient resolves the plan assigned to a client
+func planForClient(clientID string) string {
+	if clientID == "anonymous" || strings.HasPrefix(clientID, "invalid:") {
+		return DefaultPlan
+	}
+	plan, err := redisClient().Get(ctx, planAssignmentPrefix+clientID).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to resolve plan for %s: %v", clientID, err)
+	}
+	if !isPlan(plan) {
+		return DefaultPlan
+	}
+	return plan
+}
+
+// PlansHandler manages plan limits:
+//
+//	GET    /admin/api/plans                   all plans with effective limits
+//	GET    /admin/api/plans/{plan}            one plan
+//	PUT    /admin/api/plans/{plan}            override limits: {"<endpoint>": {"requests_per_minute": n, ...}}
+//	DELETE /admin/api/plans/{plan}            drop overrides, back to built-in limits
+//
+// Users are assigned to plans through auth-service.
+func PlansHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkRedisHealth() {
+		http.Error(w, "Redis is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	plan := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/plans"), "/")
+	if plan == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result := make(map[string]map[string]map[string]int)
+		for _, p := range Plans {
+			limits, err := getRateLimitsFromRedis(p)
+			if err != nil {
+				http.Error(w, "Failed to load rate limits", http.StatusInternalServerError)
+				return
+			}
+			result[p] = limits
+		}
+		writePlanJSON(w, map[string]interface{}{"default_plan": DefaultPlan, "plans": result})
+		return
+	}
+	if !isPlan(plan) {
+		http.Error(w, "Unknown plan", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req map[string]map[string]int
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for path, limits := range req {
+			if _, exists := PlanLimits[plan][path]; !exists {
+				http.Error(w, "Invalid path: "+path, http.StatusBadRequest)
+				return
+			}
+			for key, value := range limits {
+				if _, exists := PlanLimits[plan][path][key]; !exists || value <= 0 {
+					http.Error(w, "Invalid limit "+key+" for "+path, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		for path, limits := range req {
+			if err := saveRateLimitsToRedis(plan, path, limits); err != nil {
+				http.Error(w, "Failed to save rate limits", http.StatusInternalServerError)
+				return
+			}
+		}
+		log.Printf("Rate limits of plan %s updated", plan)
+	case http.MethodDelete:
+		pipeline := redisClient().Pipeline()
+		for path := range PlanLimits[plan] {
+			pipeline.Del(ctx, rateLimitsKey(plan, path))
+		}
+		if _, err := pipeline.Exec(ctx); err != nil {
+			http.Error(w, "Failed to reset rate limits", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Rate limits of plan %s reset to defaults", plan)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limits, err := getRateLimitsFromRedis(plan)
+	if err != nil {
+		http.Error(w, "Failed to load rate limits", http.StatusInternalServerError)
+		return
+	}
+	writePlanJSON(w, map[string]interface{}{"plan": plan, "limits": limits})
+}
+
+func writePlanJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,140 @@
+// limiter/scripts.go
+package limiter
+
+import (
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Each limiter operation is a single Lua script, so concurrent checks from
+// any number of rate-limiter replicas cannot interleave between reading and
+// updating a window or bucket. Time comes from Redis (TIME) rather than the
+// replica, so all replicas share one clock. Scripts are run with EVALSHA
+// and preloaded at startup; Run falls back to EVAL if Redis lost them.
+var (
+	// slidingWindowScript returns {allowed, count, reset}: whether the request
+	// was added, the requests in the window including it, and microseconds
+	// until the oldest request leaves the window
+	slidingWindowScript = redis.NewScript(`
+	-- Needed on Redis < 5 to write after TIME
+	redis.replicate_commands()
+	local t = redis.call('TIME')
+	local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+	local window = tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+
+	-- Drop entries that left the window, and any written with another clock
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+	redis.call('ZREMRANGEBYSCORE', KEYS[1], '(' .. now, '+inf')
+
+	-- Rejected requests do not take a slot
+	local count = redis.call('ZCARD', KEYS[1])
+	local allowed = 0
+	if count < limit then
+	    -- The member is the request ID, so Refund can remove it
+	    redis.call('ZADD', KEYS[1], now, ARGV[3])
+	    redis.call('EXPIRE', KEYS[1], math.ceil(window / 1000000) + 60)
+	    count = count + 1
+	    allowed = 1
+	end
+
+	local reset = window
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	if oldest[2] then
+	    reset = tonumber(oldest[2]) + window - now
+	end
+	return {allowed, count, reset}
+	`)
+
+	// tokenBucketScript refills the bucket, subtracts ARGV[4] tokens (negative
+	// to refund) and returns the balance. Charges may overdraw the bucket;
+	// refunds never fill it past capacity.
+	tokenBucketScript = redis.NewScript(`
+	redis.replicate_commands()
+	local t = redis.call('TIME')
+	local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+	local capacity = tonumber(ARGV[1])
+	local rate = tonumber(ARGV[2])
+	local period = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+
+	local data = redis.call('HMGET', KEYS[1], 'tokens', 'last_refill')
+	local tokens = tonumber(data[1])
+	local last_refill = tonumber(data[2])
+	if tokens == nil or last_refill == nil then
+	    -- New bucket
+	    tokens = capacity
+	    last_refill = now
+	end
+
+	local elapsed = math.max(0, now - last_refill)
+	tokens = math.min(capacity, tokens + (elapsed * rate) / period)
+	tokens = math.min(capacity, tokens - cost)
+
+	redis.call('HSET', KEYS[1], 'tokens', tokens, 'last_refill', now)
+	redis.call('EXPIRE', KEYS[1], math.ceil(period * 2))
+	return math.floor(tokens)
+	`)
+)
+
+// loadScripts caches the scripts in Redis
+func loadScripts(client redis.Scripter) error {
+	for _, script := range []*redis.Script{slidingWindowScript, tokenBucketScript} {
+		if err := script.Load(ctx, client).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// windowState is the state of a sliding window after a request
+type windowState struct {
+	allowed bool
+	count   int64
+	// Until the oldest request leaves the window and a slot frees up
+	reset time.Duration
+}
+
+// slidingWindow adds the request to the window if it is under the limit
+func slidingWindow(key, requestID string, limit int64, window time.Duration) windowState {
+	// In case of Redis failure, allow the request to avoid complete service disruption
+	fallback := windowState{allowed: true, reset: window}
+
+	result, err := slidingWindowScript.Run(ctx, redisClient(), []string{key},
+		window.Microseconds(), limit, requestID).Result()
+	if err != nil {
+		log.Printf("Redis error in slidingWindow: %v", err)
+		return fallback
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 3 {
+		log.Printf("Unexpected Redis response type in slidingWindow")
+		return fallback
+	}
+	allowed, _ := values[0].(int64)
+	count, _ := values[1].(int64)
+	reset, _ := values[2].(int64)
+	return windowState{allowed: allowed == 1, count: count, reset: time.Duration(reset) * time.Microsecond}
+}
+
+// tokenBucket charges cost tokens (0 only refills) and returns the balance
+func tokenBucket(key string, capacity, rate int64, period time.Duration, cost int64) int64 {
+	result, err := tokenBucketScript.Run(ctx, redisClient(), []string{key},
+		capacity, rate, period.Seconds(), cost).Result()
+	if err != nil {
+		log.Printf("Redis error in tokenBucket: %v", err)
+		// In case of Redis failure, allow the request to avoid complete service disruption
+		return capacity
+	}
+
+	remaining, ok := result.(int64)
+	if !ok {
+		log.Printf("Unexpected Redis response type in tokenBucket")
+		return capacity
+	}
+
+	return remaining
+}
@@ -0,0 +1,198 @@
+package limiter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// mr is the in-memory Redis the tests run against unless REDIS_ADDR is set;
+// nil when running against a real Redis
+var mr *miniredis.Miniredis
+
+// TestMain runs the tests against Redis at REDIS_ADDR, like the rate-limiter
+// itself, or else against miniredis, which runs scripts one at a time just
+// as Redis does
+func TestMain(m *testing.M) {
+	if os.Getenv("REDIS_ADDR") == "" {
+		var err error
+		mr, err = miniredis.Run()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "miniredis: %v\n", err)
+			os.Exit(1)
+		}
+		rdbOnce.Do(func() { rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()}) })
+	}
+	code := m.Run()
+	if mr != nil {
+		mr.Close()
+	}
+	os.Exit(code)
+}
+
+// withClock sets the time of miniredis, which the scripts read with TIME;
+// tests that need it are skipped against a real Redis
+func withClock(t *testing.T, now time.Time) {
+	t.Helper()
+	if mr == nil {
+		t.Skip("needs miniredis to control the clock")
+	}
+	mr.SetTime(now)
+	t.Cleanup(func() { mr.SetTime(time.Time{}) })
+}
+
+func testKey(t testing.TB) string {
+	key := fmt.Sprintf("test:%s:%d", t.Name(), time.Now().UnixNano())
+	t.Cleanup(func() { rdb.Del(ctx, key) })
+	return key
+}
+
+func TestSlidingWindowConcurrent(t *testing.T) {
+	key := testKey(t)
+	const limit, requests = 50, 500
+
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if slidingWindow(key, fmt.Sprintf("req-%d", i), limit, time.Minute).allowed {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Errorf("allowed %d of %d concurrent requests, want exactly %d", allowed, requests, limit)
+	}
+	if n := rdb.ZCard(ctx, key).Val(); n != limit {
+		t.Errorf("window holds %d requests, want %d", n, limit)
+	}
+
+	state := slidingWindow(key, "late", limit, time.Minute)
+	if state.allowed || state.count != limit || state.reset <= 0 || state.reset > time.Minute {
+		t.Errorf("got %+v after the window filled", state)
+	}
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	key := testKey(t)
+	// Refills one token per second, so the test run barely changes the balance
+	const capacity, workers, cost = 3600, 100, 10
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokenBucket(key, capacity, capacity, time.Hour, cost)
+		}()
+	}
+	wg.Wait()
+
+	remaining := tokenBucket(key, capacity, capacity, time.Hour, 0)
+	want := int64(capacity - workers*cost)
+	if drift := int64(time.Since(start).Seconds()) + 1; remaining < want || remaining > want+drift {
+		t.Errorf("balance %d after %d concurrent charges of %d, want %d", remaining, workers, cost, want)
+	}
+}
+
+func TestTokenBucketRefund(t *testing.T) {
+	key := testKey(t)
+
+	if got := tokenBucket(key, 1000, 1000, time.Hour, 1500); got != -500 {
+		t.Errorf("overdrawn balance %d, want -500", got)
+	}
+	if got := tokenBucket(key, 1000, 1000, time.Hour, -700); got != 200 {
+		t.Errorf("balance after refund %d, want 200", got)
+	}
+	if got := tokenBucket(key, 1000, 1000, time.Hour, -5000); got != 1000 {
+		t.Errorf("refund filled the bucket to %d, past its capacity", got)
+	}
+}
+
+func TestSlidingWindowExpires(t *testing.T) {
+	key := testKey(t)
+	start := time.Unix(1700000000, 0)
+	withClock(t, start)
+
+	for i := 0; i < 3; i++ {
+		if !slidingWindow(key, fmt.Sprint(i), 3, time.Minute).allowed {
+			t.Fatalf("request %d rejected under the limit", i)
+		}
+	}
+	mr.SetTime(start.Add(20 * time.Second))
+	state := slidingWindow(key, "over", 3, time.Minute)
+	if state.allowed || state.count != 3 || state.reset != 40*time.Second {
+		t.Fatalf("got %+v over the limit, want a reset in 40s", state)
+	}
+
+	// The first requests leave the window; the rejected one never took a slot
+	mr.SetTime(start.Add(61 * time.Second))
+	state = slidingWindow(key, "next", 3, time.Minute)
+	if !state.allowed || state.count != 1 {
+		t.Errorf("got %+v after the window passed", state)
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	key := testKey(t)
+	start := time.Unix(1700000000, 0)
+	withClock(t, start)
+
+	if got := tokenBucket(key, 600, 600, time.Minute, 600); got != 0 {
+		t.Fatalf("balance %d after spending the bucket, want 0", got)
+	}
+	mr.SetTime(start.Add(30 * time.Second))
+	if got := tokenBucket(key, 600, 600, time.Minute, 0); got != 300 {
+		t.Errorf("balance %d after half a period, want 300", got)
+	}
+	mr.SetTime(start.Add(10 * time.Minute))
+	if got := tokenBucket(key, 600, 600, time.Minute, 0); got != 600 {
+		t.Errorf("balance %d after a long idle time, want the capacity", got)
+	}
+}
+
+func TestSaveRateLimits(t *testing.T) {
+	key := rateLimitsKey("test", t.Name())
+	t.Cleanup(func() { rdb.Del(ctx, key) })
+
+	if err := saveRateLimitsToRedis("test", t.Name(), map[string]int{"requests_per_minute": 10, "tokens_per_minute": 1000}); err != nil {
+		t.Fatal(err)
+	}
+	got := rdb.HGetAll(ctx, key).Val()
+	if len(got) != 2 || got["requests_per_minute"] != "10" || got["tokens_per_minute"] != "1000" {
+		t.Errorf("stored %v", got)
+	}
+}
+
+func BenchmarkSlidingWindowParallel(b *testing.B) {
+	key := testKey(b)
+	var n int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			slidingWindow(key, fmt.Sprint(atomic.AddInt64(&n, 1)), 1000, time.Minute)
+		}
+	})
+	if count := rdb.ZCard(ctx, key).Val(); count > 1000 {
+		b.Fatalf("window holds %d requests, over the limit of 1000", count)
+	}
+}
+
+func BenchmarkTokenBucketParallel(b *testing.B) {
+	key := testKey(b)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tokenBucket(key, 1000000, 1000000, time.Minute, 1)
+		}
+	})
+}
@@ -3,21 +3,31 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"crypto/x509"
-	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 
+	"github.com/MaksimVF/ZB/pkg/certs"
+	"github.com/MaksimVF/ZB/pkg/diagnostics"
+	"github.com/MaksimVF/ZB/pkg/health"
+	"github.com/MaksimVF/ZB/pkg/requestid"
+	"github.com/MaksimVF/ZB/pkg/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	pb "llm-gateway-pro/services/rate-limiter/pb"
+	pb "github.com/MaksimVF/ZB/services/rate-limiter/pb"
 	"llm-gateway-pro/services/rate-limiter/internal/limiter"
 )
 
 func main() {
+	shutdownTracing, err := tracing.Init(context.Background(), "rate-limiter")
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Load TLS credentials with proper certificate validation
 	creds, err := loadTLSCredentials()
 	if err != nil {
@@ -30,7 +40,7 @@ func main() {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	s := grpc.NewServer(grpc.Creds(creds))
+	s := grpc.NewServer(grpc.Creds(creds), tracing.ServerOption(), requestid.ServerOption())
 	pb.RegisterRateLimiterServer(s, &limiter.Server{})
 
 	// Start JWT secret refresh in background
@@ -38,7 +48,19 @@ func main() {
 
 	// HTTP админка (для UI) - also with TLS
 	go func() {
-		http.HandleFunc("/admin/api/rate-limits", limiter.AdminHandler)
+		// Свой mux: pprof и expvar регистрируются в http.DefaultServeMux
+		mux := http.NewServeMux()
+		mux.HandleFunc("/admin/api/rate-limits", limiter.AdminHandler)
+		mux.HandleFunc("/admin/api/plans", limiter.PlansHandler)
+		mux.HandleFunc("/admin/api/plans/", limiter.PlansHandler)
+
+		// /livez и /readyz в общем JSON-формате
+		checker := health.New("rate-limiter").Add("redis", limiter.PingRedis)
+		mux.Handle("/livez", checker.LiveHandler())
+		mux.Handle("/readyz", checker.ReadyHandler())
+
+		// pprof, expvar и /debug/runtime при DEBUG_ENDPOINTS=true, по X-Admin-Key
+		mux.Handle(diagnostics.Prefix, diagnostics.Handler(diagnostics.AdminKey(os.Getenv("ADMIN_KEY"))))
 
 		// Load admin server TLS credentials
 		adminCreds, err := loadAdminTLSCredentials()
@@ -48,6 +70,7 @@ func main() {
 
 		server := &http.Server{
 			Addr:      ":8081",
+			Handler:   tracing.Middleware("rate-limiter-admin", mux),
 			TLSConfig: adminCreds,
 		}
 
@@ -63,57 +86,19 @@ func main() {
 
 // loadTLSCredentials loads gRPC TLS credentials with proper certificate validation
 func loadTLSCredentials() (credentials.TransportCredentials, error) {
-	// Load server certificate and key
-	serverCert, err := tls.LoadX509KeyPair("/certs/rate-limiter.pem", "/certs/rate-limiter-key.pem")
+	serverCerts, err := certs.Watch("rate-limiter", "/certs/rate-limiter.pem", "/certs/rate-limiter-key.pem", "/certs/ca.pem")
 	if err != nil {
-		return nil, fmt.Errorf("failed to load server certificate: %w", err)
-	}
-
-	// Load CA certificate for client verification
-	caCert, err := os.ReadFile("/certs/ca.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
-	}
-
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to add CA certificate to pool")
+		return nil, err
 	}
-
-	// Create TLS config with proper validation
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    certPool,
-		MinVersion:   tls.VersionTLS12,
-	}
-
-	return credentials.NewTLS(tlsConfig), nil
+	return credentials.NewTLS(serverCerts.ServerConfig(tls.RequireAndVerifyClientCert, serverCerts.AuthorizeFromEnv())), nil
 }
 
 // loadAdminTLSCredentials loads HTTP server TLS credentials
 func loadAdminTLSCredentials() (*tls.Config, error) {
-	// Load server certificate and key
-	serverCert, err := tls.LoadX509KeyPair("/certs/admin.pem", "/certs/admin-key.pem")
+	adminCerts, err := certs.Watch("rate-limiter-admin", "/certs/admin.pem", "/certs/admin-key.pem", "/certs/ca.pem")
 	if err != nil {
-		return nil, fmt.Errorf("failed to load admin certificate: %w", err)
+		return nil, err
 	}
-
-	// Load CA certificate for client verification (optional for admin)
-	caCert, err := os.ReadFile("/certs/ca.pem")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
-	}
-
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to add CA certificate to pool")
-	}
-
-	return &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.VerifyClientCertIfGiven,
-		ClientCAs:    certPool,
-		MinVersion:   tls.VersionTLS12,
-	}, nil
+	// Client certificates are optional for admin
+	return adminCerts.ServerConfig(tls.VerifyClientCertIfGiven, adminCerts.AuthorizeFromEnv()), nil
 }
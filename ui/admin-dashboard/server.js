@@ -67,7 +67,7 @@ app.get('/admin/routing/policy', async (req, res) => {
     const response = await axios.get('http://routing-service:8080/api/routing/policy', {
       headers: { 'X-Admin-Key': adminKey }
     });
-    res.json(response.data);
+    res.json(response.data.policy);
   } catch (error) {
     console.error('Error fetching routing policy:', error);
     res.status(500).json({ error: 'Failed to fetch routing policy' });
@@ -103,7 +103,7 @@ app.get('/admin/routing/heads', async (req, res) => {
     const response = await axios.get('http://routing-service:8080/api/routing/heads', {
       headers: { 'X-Admin-Key': adminKey }
     });
-    res.json(response.data);
+    res.json(response.data.heads);
   } catch (error) {
     console.error('Error fetching head services:', error);
     res.status(500).json({ error: 'Failed to fetch head services' });
@@ -232,6 +232,29 @@ app.delete('/admin/models/:model_id', async (req, res) => {
   }
 });
 
+// Feature flags - proxy to the gateway admin API
+app.all(['/admin/api/flags', '/admin/api/flags/*'], async (req, res) => {
+  try {
+    const adminKey = req.headers['x-admin-key'];
+    if (adminKey !== process.env.ADMIN_KEY) {
+      return res.status(403).json({ error: 'Forbidden' });
+    }
+
+    // Forward request to gateway, keeping its status for the UI
+    const response = await axios.request({
+      method: req.method,
+      url: `http://gateway:8080${req.originalUrl}`,
+      data: ['PUT', 'POST'].includes(req.method) ? req.body : undefined,
+      headers: { 'X-Admin-Key': adminKey },
+      validateStatus: () => true
+    });
+    res.status(response.status).send(response.data);
+  } catch (error) {
+    console.error('Error proxying feature flags:', error);
+    res.status(500).json({ error: 'Failed to reach feature flags API' });
+  }
+});
+
 app.listen(port, () => {
   console.log(`Admin UI server running at http://localhost:${port}`);
 });
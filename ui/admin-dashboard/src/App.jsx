@@ -17,6 +17,7 @@ const Logs = lazy(() => import('./pages/Logs'))
 const Revenue = lazy(() => import('./pages/Revenue'))
 const Routing = lazy(() => import('./pages/Routing'))
 const Models = lazy(() => import('./pages/Models'))
+const FeatureFlags = lazy(() => import('./pages/FeatureFlags'))
 const Login = lazy(() => import('./pages/Login'))
 
 function App() {
@@ -99,6 +100,11 @@ function App() {
                   <PrivateRoute><Models /></PrivateRoute>
                 </Suspense>
               } />
+              <Route path="/admin/feature-flags" element={
+                <Suspense fallback={<div>Loading...</div>}>
+                  <PrivateRoute><FeatureFlags /></PrivateRoute>
+                </Suspense>
+              } />
             </Routes>
           </Router>
         </AuthProvider>
@@ -178,6 +178,52 @@ export const admin = {
       handleApiError(error)
     }
   },
+  featureFlags: async (service, tenant) => {
+    try {
+      const response = await api.get('/admin/api/flags', {
+        params: { service, tenant },
+        cache: { maxAge: 0 }
+      })
+      return response
+    } catch (error) {
+      handleApiError(error)
+    }
+  },
+  saveFeatureFlag: async (flag) => {
+    try {
+      const response = await api.put(`/admin/api/flags/${flag.name}`, flag)
+      return response
+    } catch (error) {
+      handleApiError(error)
+    }
+  },
+  toggleFeatureFlag: async (name, enabled) => {
+    try {
+      const response = await api.post(`/admin/api/flags/${name}/${enabled ? 'enable' : 'disable'}`)
+      return response
+    } catch (error) {
+      handleApiError(error)
+    }
+  },
+  setFeatureFlagOverride: async (name, level, key, enabled) => {
+    try {
+      const path = `/admin/api/flags/${name}/${level}/${encodeURIComponent(key)}`
+      const response = enabled === null
+        ? await api.delete(path)
+        : await api.put(path, { enabled })
+      return response
+    } catch (error) {
+      handleApiError(error)
+    }
+  },
+  deleteFeatureFlag: async (name) => {
+    try {
+      const response = await api.delete(`/admin/api/flags/${name}`)
+      return response
+    } catch (error) {
+      handleApiError(error)
+    }
+  },
   login: async (username, password) => {
     try {
       const response = await api.post('/admin/login', { username, password })
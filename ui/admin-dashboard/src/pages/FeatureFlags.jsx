@@ -0,0 +1,209 @@
+import { useState, useEffect } from 'react'
+import { admin } from '../api'
+
+const SERVICES = ['gateway', 'head']
+
+export default function FeatureFlags() {
+  const [flags, setFlags] = useState([])
+  const [filter, setFilter] = useState({ service: '', tenant: '' })
+  const [override, setOverride] = useState({ level: 'services', key: '' })
+  const [newFlag, setNewFlag] = useState({ name: '', description: '', enabled: false })
+  const [error, setError] = useState('')
+
+  useEffect(() => {
+    loadFlags()
+  }, [filter.service, filter.tenant])
+
+  const loadFlags = async () => {
+    try {
+      const response = await admin.featureFlags(filter.service || undefined, filter.tenant || undefined)
+      setFlags(response.data.flags)
+    } catch (error) {
+      console.error('Failed to load feature flags:', error)
+      setError(error.message)
+    }
+  }
+
+  const run = async (action) => {
+    try {
+      setError('')
+      await action()
+      loadFlags()
+    } catch (error) {
+      console.error('Feature flag update failed:', error)
+      setError(error.message)
+    }
+  }
+
+  const handleToggle = (flag) => run(() => admin.toggleFeatureFlag(flag.name, !flag.enabled))
+
+  const handleOverride = (flag, level, key, enabled) =>
+    run(() => admin.setFeatureFlagOverride(flag.name, level, key, enabled))
+
+  const handleDelete = (name) => {
+    if (confirm('Удалить флаг? Сервисы вернутся к встроенному значению.')) {
+      run(() => admin.deleteFeatureFlag(name))
+    }
+  }
+
+  const createFlag = (e) => {
+    e.preventDefault()
+    run(async () => {
+      await admin.saveFeatureFlag(newFlag)
+      setNewFlag({ name: '', description: '', enabled: false })
+    })
+  }
+
+  const overrides = (flag, level) => Object.entries(flag[level] || {})
+
+  return (
+    <div className="max-w-7xl mx-auto p-8">
+      <h1 className="text-5xl font-bold mb-10 text-gray-800 dark:text-gray-100">Feature flags</h1>
+
+      {error && (
+        <div className="mb-6 p-4 bg-red-100 text-red-700 rounded-md">{error}</div>
+      )}
+
+      {/* Filter */}
+      <div className="bg-white dark:bg-gray-800 p-8 rounded-2xl shadow-xl mb-8 grid grid-cols-1 md:grid-cols-2 gap-6">
+        <div>
+          <label className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-1">Сервис</label>
+          <select
+            value={filter.service}
+            onChange={(e) => setFilter(prev => ({ ...prev, service: e.target.value }))}
+            className="w-full p-3 border border-gray-300 dark:border-gray-600 rounded-md dark:bg-gray-700 dark:text-white"
+          >
+            <option value="">Все сервисы</option>
+            {SERVICES.map(service => <option key={service} value={service}>{service}</option>)}
+          </select>
+        </div>
+        <div>
+          <label className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-1">Тенант</label>
+          <input
+            type="text"
+            value={filter.tenant}
+            onChange={(e) => setFilter(prev => ({ ...prev, tenant: e.target.value }))}
+            placeholder="ID пользователя"
+            className="w-full p-3 border border-gray-300 dark:border-gray-600 rounded-md dark:bg-gray-700 dark:text-white"
+          />
+        </div>
+      </div>
+
+      {/* Flag List */}
+      <div className="bg-white dark:bg-gray-800 p-8 rounded-2xl shadow-xl mb-8">
+        <h2 className="text-3xl font-bold mb-6 dark:text-white">Флаги</h2>
+        <div className="grid grid-cols-1 md:grid-cols-2 gap-6">
+          {flags.map(flag => (
+            <div key={flag.name} className="bg-gray-50 dark:bg-gray-700 p-6 rounded-xl shadow-md">
+              <div className="flex justify-between items-center mb-3">
+                <h3 className="text-xl font-semibold dark:text-white">{flag.name}</h3>
+                <button
+                  onClick={() => handleToggle(flag)}
+                  className={`px-4 py-2 text-white rounded-md ${flag.enabled ? 'bg-green-500 hover:bg-green-600' : 'bg-gray-500 hover:bg-gray-600'}`}
+                >
+                  {flag.enabled ? 'Включён' : 'Выключен'}
+                </button>
+              </div>
+              <p className="text-gray-600 dark:text-gray-300 mb-3">{flag.description}</p>
+              {flag.value !== undefined && (
+                <p className="text-gray-600 dark:text-gray-300 mb-3">
+                  <strong>Значение для фильтра:</strong> {flag.value ? 'вкл' : 'выкл'} ({flag.source})
+                </p>
+              )}
+
+              {['services', 'tenants'].map(level => (
+                <div key={level} className="mb-2">
+                  <p className="text-sm font-medium text-gray-700 dark:text-gray-300">
+                    {level === 'services' ? 'Сервисы' : 'Тенанты'}:
+                  </p>
+                  {overrides(flag, level).length === 0 && (
+                    <span className="text-sm text-gray-500">нет переопределений</span>
+                  )}
+                  {overrides(flag, level).map(([key, enabled]) => (
+                    <span key={key} className="inline-flex items-center mr-2 mb-1 px-2 py-1 bg-gray-200 dark:bg-gray-600 rounded text-sm dark:text-white">
+                      <button onClick={() => handleOverride(flag, level, key, !enabled)} className="mr-1">
+                        {key}: {enabled ? 'вкл' : 'выкл'}
+                      </button>
+                      <button onClick={() => handleOverride(flag, level, key, null)} className="text-red-500" aria-label="Удалить переопределение">×</button>
+                    </span>
+                  ))}
+                </div>
+              ))}
+
+              <div className="mt-4 flex space-x-2">
+                <select
+                  value={override.level}
+                  onChange={(e) => setOverride(prev => ({ ...prev, level: e.target.value }))}
+                  className="p-2 border border-gray-300 dark:border-gray-600 rounded-md dark:bg-gray-700 dark:text-white"
+                >
+                  <option value="services">сервис</option>
+                  <option value="tenants">тенант</option>
+                </select>
+                <input
+                  type="text"
+                  value={override.key}
+                  onChange={(e) => setOverride(prev => ({ ...prev, key: e.target.value }))}
+                  placeholder="gateway / ID"
+                  className="flex-1 p-2 border border-gray-300 dark:border-gray-600 rounded-md dark:bg-gray-700 dark:text-white"
+                />
+                <button
+                  onClick={() => override.key && handleOverride(flag, override.level, override.key, !flag.enabled)}
+                  className="px-4 py-2 bg-blue-500 text-white rounded-md hover:bg-blue-600"
+                >
+                  Переопределить
+                </button>
+                <button
+                  onClick={() => handleDelete(flag.name)}
+                  className="px-4 py-2 bg-red-500 text-white rounded-md hover:bg-red-600"
+                >
+                  Удалить
+                </button>
+              </div>
+            </div>
+          ))}
+        </div>
+      </div>
+
+      {/* Create New Flag */}
+      <div className="bg-white dark:bg-gray-800 p-8 rounded-2xl shadow-xl mb-8">
+        <h2 className="text-3xl font-bold mb-6 dark:text-white">Новый флаг</h2>
+        <form onSubmit={createFlag} className="grid grid-cols-1 md:grid-cols-3 gap-6 items-end">
+          <div>
+            <label className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-1">Имя</label>
+            <input
+              type="text"
+              value={newFlag.name}
+              onChange={(e) => setNewFlag(prev => ({ ...prev, name: e.target.value }))}
+              pattern="[a-z0-9][a-z0-9_.\-]{0,63}"
+              className="w-full p-3 border border-gray-300 dark:border-gray-600 rounded-md dark:bg-gray-700 dark:text-white"
+              required
+            />
+          </div>
+          <div>
+            <label className="block text-sm font-medium text-gray-700 dark:text-gray-300 mb-1">Описание</label>
+            <input
+              type="text"
+              value={newFlag.description}
+              onChange={(e) => setNewFlag(prev => ({ ...prev, description: e.target.value }))}
+              className="w-full p-3 border border-gray-300 dark:border-gray-600 rounded-md dark:bg-gray-700 dark:text-white"
+            />
+          </div>
+          <div className="flex items-center space-x-4">
+            <label className="flex items-center text-gray-700 dark:text-gray-300">
+              <input
+                type="checkbox"
+                checked={newFlag.enabled}
+                onChange={(e) => setNewFlag(prev => ({ ...prev, enabled: e.target.checked }))}
+                className="mr-2"
+              />
+              Включён
+            </label>
+            <button type="submit" className="px-6 py-3 bg-blue-500 text-white rounded-md hover:bg-blue-600">
+              Создать
+            </button>
+          </div>
+        </form>
+      </div>
+    </div>
+  )
+}